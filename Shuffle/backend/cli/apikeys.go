@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+func newApikeysCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apikeys",
+		Short: "Manage named api keys",
+	}
+
+	all := false
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List your api keys, or every key in the org with --all",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+
+			query := url.Values{}
+			if all {
+				query.Set("all", "true")
+			}
+
+			data, err := c.cslRequest("GET", "/api/v1/csl/apiKeys", query, nil)
+			if err != nil {
+				return err
+			}
+
+			return printJson(data)
+		},
+	}
+
+	list.Flags().BoolVar(&all, "all", false, "list every key in the org (org admin)")
+
+	expiresInDays := 0
+	create := &cobra.Command{
+		Use:   "create <name>",
+		Short: "Create an api key. The key is only printed once",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+
+			data, err := c.cslRequest("POST", "/api/v1/csl/apiKeys", nil, map[string]interface{}{
+				"name":            args[0],
+				"expires_in_days": expiresInDays,
+			})
+			if err != nil {
+				return err
+			}
+
+			return printJson(data)
+		},
+	}
+
+	create.Flags().IntVar(&expiresInDays, "expires-in-days", 0, "days until the key expires, 0 for never")
+
+	overlapHours := 24
+	rotate := &cobra.Command{
+		Use:   "rotate <key_id>",
+		Short: "Replace a key with a new one with the same name and lifetime",
+		Long: `Replaces a key with a new one with the same name and lifetime. The old key
+stays valid for --overlap-hours so clients can be updated without downtime.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+
+			query := url.Values{
+				"key_id":        {args[0]},
+				"overlap_hours": {strconv.Itoa(overlapHours)},
+			}
+
+			data, err := c.cslRequest("POST", "/api/v1/csl/apiKeys/rotate", query, nil)
+			if err != nil {
+				return err
+			}
+
+			return printJson(data)
+		},
+	}
+
+	rotate.Flags().IntVar(&overlapHours, "overlap-hours", overlapHours, "hours the old key stays valid")
+
+	revoke := &cobra.Command{
+		Use:   "revoke <key_id>",
+		Short: "Revoke a key immediately",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+
+			data, err := c.cslRequest("POST", "/api/v1/csl/apiKeys/revoke", url.Values{"key_id": {args[0]}}, nil)
+			if err != nil {
+				return err
+			}
+
+			return printJson(data)
+		},
+	}
+
+	cmd.AddCommand(list, create, rotate, revoke)
+	return cmd
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type client struct {
+	baseUrl    string
+	apikey     string
+	orgId      string
+	httpClient *http.Client
+}
+
+// Response of the CSL APIs. Older APIs have their fields at the top level
+// instead of in data
+type apiResponse struct {
+	Success bool            `json:"success"`
+	Reason  string          `json:"reason"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func newClient() (*client, error) {
+	if len(options.apikey) == 0 {
+		return nil, errors.New("no api key. Use --apikey or set SHUFFLE_APIKEY")
+	}
+
+	if len(options.url) == 0 {
+		return nil, errors.New("no backend url. Use --url or set SHUFFLE_URL")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if options.insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &client{
+		baseUrl: strings.TrimRight(options.url, "/"),
+		apikey:  options.apikey,
+		orgId:   options.org,
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   options.timeout,
+		},
+	}, nil
+}
+
+// Sends a request and returns the raw response body. body is sent as is if
+// it's a reader, and as JSON otherwise
+func (c *client) request(method, path string, query url.Values, body interface{}) ([]byte, error) {
+	requestUrl := c.baseUrl + path
+	if len(query) > 0 {
+		requestUrl += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		if bodyReader, ok := body.(io.Reader); ok {
+			reader = bodyReader
+		} else {
+			data, err := json.Marshal(body)
+			if err != nil {
+				return nil, err
+			}
+
+			reader = bytes.NewReader(data)
+		}
+	}
+
+	req, err := http.NewRequest(method, requestUrl, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.apikey)
+	req.Header.Set("Accept", "application/json")
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if len(c.orgId) > 0 {
+		req.Header.Set("Org-Id", c.orgId)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+	respBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 300 {
+		parsed := apiResponse{}
+		if json.Unmarshal(respBody, &parsed) == nil && len(parsed.Reason) > 0 {
+			return nil, errors.New(fmt.Sprintf("%s %s failed with status %d: %s", method, path, res.StatusCode, parsed.Reason))
+		}
+
+		return nil, errors.New(fmt.Sprintf("%s %s failed with status %d", method, path, res.StatusCode))
+	}
+
+	return respBody, nil
+}
+
+// Sends a request to a CSL API and returns its data
+func (c *client) cslRequest(method, path string, query url.Values, body interface{}) (json.RawMessage, error) {
+	respBody, err := c.request(method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := apiResponse{}
+	err = json.Unmarshal(respBody, &parsed)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unexpected response from %s: %s", path, err))
+	}
+
+	if !parsed.Success {
+		return nil, errors.New(fmt.Sprintf("%s %s failed: %s", method, path, parsed.Reason))
+	}
+
+	return parsed.Data, nil
+}
+
+func (c *client) waitFor(interval time.Duration, check func() (bool, error)) error {
+	for {
+		done, err := check()
+		if err != nil || done {
+			return err
+		}
+
+		time.Sleep(interval)
+	}
+}
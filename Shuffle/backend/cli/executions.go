@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type executionResponse struct {
+	Success       bool   `json:"success"`
+	Reason        string `json:"reason"`
+	ExecutionId   string `json:"execution_id"`
+	Authorization string `json:"authorization"`
+}
+
+type executionSummary struct {
+	ExecutionId     string `json:"execution_id"`
+	WorkflowId      string `json:"workflow_id"`
+	Status          string `json:"status"`
+	ExecutionSource string `json:"execution_source"`
+	StartedAt       int64  `json:"started_at"`
+	CompletedAt     int64  `json:"completed_at"`
+	Result          string `json:"result"`
+}
+
+func isExecutionDone(status string) bool {
+	return status == "FINISHED" || status == "ABORTED" || status == "FAILURE"
+}
+
+// Gets an execution through the stream results API. Org admins don't need
+// the authorization of the execution
+func getExecution(c *client, executionId, authorization string) (json.RawMessage, executionSummary, error) {
+	body, err := c.request("POST", "/api/v1/streams/results", nil, map[string]string{
+		"execution_id":  executionId,
+		"authorization": authorization,
+	})
+	if err != nil {
+		return nil, executionSummary{}, err
+	}
+
+	summary := executionSummary{}
+	err = json.Unmarshal(body, &summary)
+	if err != nil {
+		return nil, summary, err
+	}
+
+	return json.RawMessage(body), summary, nil
+}
+
+func printExecution(raw json.RawMessage, summary executionSummary, full bool) error {
+	if full {
+		return printJson(raw)
+	}
+
+	return printJson(summary)
+}
+
+func newExecutionsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "executions",
+		Short: "Run workflows and look up their executions",
+	}
+
+	argument := ""
+	argumentFile := ""
+	start := ""
+	wait := false
+	interval := 2 * time.Second
+	full := false
+	run := &cobra.Command{
+		Use:   "run <workflow_id>",
+		Short: "Start an execution of a workflow",
+		Long: `Starts an execution of a workflow with an optional execution argument, and
+prints its id and authorization. With --wait, the execution is followed until
+it is done and the command fails unless it finished.
+
+Examples:
+  shuffle-cli executions run <id> --argument '{"ip": "10.0.0.1"}'
+  shuffle-cli executions run <id> --argument-file alert.json --wait`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(argumentFile) > 0 {
+				if len(argument) > 0 {
+					return errors.New("use either --argument or --argument-file")
+				}
+
+				data, err := ioutil.ReadFile(argumentFile)
+				if err != nil {
+					return err
+				}
+
+				argument = string(data)
+			}
+
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+
+			body, err := c.request("POST", fmt.Sprintf("/api/v1/workflows/%s/execute", url.PathEscape(args[0])), nil, map[string]string{
+				"execution_argument": argument,
+				"start":              start,
+			})
+			if err != nil {
+				return err
+			}
+
+			execution := executionResponse{}
+			err = json.Unmarshal(body, &execution)
+			if err != nil {
+				return err
+			}
+
+			if !execution.Success {
+				return errors.New(fmt.Sprintf("failed starting workflow %s: %s", args[0], execution.Reason))
+			}
+
+			if !wait {
+				return printJson(execution)
+			}
+
+			fmt.Fprintf(os.Stderr, "Started execution %s, waiting for it to finish\n", execution.ExecutionId)
+
+			var raw json.RawMessage
+			summary := executionSummary{}
+			err = c.waitFor(interval, func() (bool, error) {
+				raw, summary, err = getExecution(c, execution.ExecutionId, execution.Authorization)
+				if err != nil {
+					return false, err
+				}
+
+				return isExecutionDone(summary.Status), nil
+			})
+			if err != nil {
+				return err
+			}
+
+			err = printExecution(raw, summary, full)
+			if err != nil {
+				return err
+			}
+
+			if summary.Status != "FINISHED" {
+				return errors.New(fmt.Sprintf("execution %s ended with status %s", summary.ExecutionId, summary.Status))
+			}
+
+			return nil
+		},
+	}
+
+	run.Flags().StringVar(&argument, "argument", "", "execution argument")
+	run.Flags().StringVar(&argumentFile, "argument-file", "", "file with the execution argument")
+	run.Flags().StringVar(&start, "start", "", "id of the node to start from instead of the start node")
+	run.Flags().BoolVar(&wait, "wait", false, "wait for the execution to be done")
+	run.Flags().DurationVar(&interval, "interval", interval, "how often to check the execution with --wait")
+	run.Flags().BoolVar(&full, "full", false, "print the whole execution with --wait instead of a summary")
+
+	authorization := ""
+	getFull := false
+	get := &cobra.Command{
+		Use:   "get <execution_id>",
+		Short: "Get an execution",
+		Long: `Gets an execution with its authorization from run. Org admins can leave out
+the authorization.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+
+			raw, summary, err := getExecution(c, args[0], authorization)
+			if err != nil {
+				return err
+			}
+
+			return printExecution(raw, summary, getFull)
+		},
+	}
+
+	get.Flags().StringVar(&authorization, "authorization", "", "authorization of the execution")
+	get.Flags().BoolVar(&getFull, "full", false, "print the whole execution instead of a summary")
+
+	top := 0
+	listFull := false
+	list := &cobra.Command{
+		Use:   "list <workflow_id>",
+		Short: "List the latest executions of a workflow",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+
+			query := url.Values{}
+			if top > 0 {
+				query.Set("top", strconv.Itoa(top))
+			}
+
+			body, err := c.request("GET", fmt.Sprintf("/api/v1/workflows/%s/executions", url.PathEscape(args[0])), query, nil)
+			if err != nil {
+				return err
+			}
+
+			if listFull {
+				return printJson(json.RawMessage(body))
+			}
+
+			executions := []executionSummary{}
+			err = json.Unmarshal(body, &executions)
+			if err != nil {
+				return err
+			}
+
+			return printJson(executions)
+		},
+	}
+
+	list.Flags().IntVar(&top, "top", 0, "number of executions to list")
+	list.Flags().BoolVar(&listFull, "full", false, "print the whole executions instead of summaries")
+
+	cmd.AddCommand(run, get, list)
+	return cmd
+}
@@ -0,0 +1,115 @@
+package main
+
+// Command line client for operators who script against Shuffle. It talks to
+// the CSL and admin APIs of a backend with an api key, and prints the data of
+// each response as JSON so it can be piped to jq and other tools.
+//
+// The backend and key are set with --url and --apikey, or SHUFFLE_URL and
+// SHUFFLE_APIKEY. --org runs commands in another org the user belongs to.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+var options = struct {
+	url      string
+	apikey   string
+	org      string
+	insecure bool
+	timeout  time.Duration
+	compact  bool
+}{}
+
+func getEnvDefault(name, fallback string) string {
+	if value := os.Getenv(name); len(value) > 0 {
+		return value
+	}
+
+	return fallback
+}
+
+// Prints data as indented JSON, or on one line with --compact
+func printJson(data interface{}) error {
+	var output []byte
+	var err error
+
+	if raw, ok := data.(json.RawMessage); ok {
+		if len(raw) == 0 {
+			raw = json.RawMessage("null")
+		}
+
+		var parsed interface{}
+		err = json.Unmarshal(raw, &parsed)
+		if err != nil {
+			return err
+		}
+
+		data = parsed
+	}
+
+	if options.compact {
+		output, err = json.Marshal(data)
+	} else {
+		output, err = json.MarshalIndent(data, "", "  ")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(output))
+	return nil
+}
+
+func newRootCommand() *cobra.Command {
+	insecure, _ := strconv.ParseBool(os.Getenv("SHUFFLE_INSECURE"))
+
+	root := &cobra.Command{
+		Use:           "shuffle-cli",
+		Short:         "Stats and administration of a Shuffle backend",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&options.url, "url", getEnvDefault("SHUFFLE_URL", "http://localhost:5001"), "backend url (SHUFFLE_URL)")
+	flags.StringVar(&options.apikey, "apikey", os.Getenv("SHUFFLE_APIKEY"), "api key (SHUFFLE_APIKEY)")
+	flags.StringVar(&options.org, "org", os.Getenv("SHUFFLE_ORG"), "id of the org to use instead of the active one (SHUFFLE_ORG)")
+	flags.BoolVar(&options.insecure, "insecure", insecure, "skip TLS certificate verification (SHUFFLE_INSECURE)")
+	flags.DurationVar(&options.timeout, "timeout", 60*time.Second, "timeout of each request")
+	flags.BoolVar(&options.compact, "compact", false, "print JSON on one line")
+
+	root.AddCommand(
+		newStatsCommand(),
+		newWorkflowsCommand(),
+		newExecutionsCommand(),
+		newApikeysCommand(),
+		&cobra.Command{
+			Use:   "version",
+			Short: "Print the version of the CLI",
+			Args:  cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				fmt.Println(version)
+			},
+		},
+	)
+
+	return root
+}
+
+func main() {
+	err := newRootCommand().Execute()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Statistics that can be fetched with the stats command, by the name of
+// their CSL API
+var statsEndpoints = map[string]string{
+	"workflows":          "Workflow counts",
+	"apps":               "App counts",
+	"apiUsage":           "Api usage of the org",
+	"apiUsage/breakdown": "Api usage per key and endpoint",
+	"workflowExecutions": "Execution counts and success rate",
+	"workflowChart":      "Daily executions",
+	"appChart":           "Daily app runs",
+	"appUsage":           "Most used apps",
+	"executionHeatmap":   "Executions per weekday and hour",
+	"forecast":           "Forecast of executions and api usage",
+	"trends":             "Week and month over week and month trends",
+	"triggers":           "Executions per trigger type",
+	"failures":           "Failures per category",
+	"healthScore":        "Health score of the org",
+	"fleetHealth":        "Health of the runners",
+	"queueBacklog":       "Queued executions per environment",
+	"workflowQuality":    "Quality findings per workflow",
+	"nodeStats":          "Results per node of a workflow (workflow_id=)",
+	"endpointStats":      "Latency and errors per endpoint",
+	"slowQueries":        "Slow database queries",
+}
+
+func getStatsNames() []string {
+	names := []string{}
+	for name := range statsEndpoints {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Parses key=value parameters into a query
+func parseParams(params []string) (url.Values, error) {
+	query := url.Values{}
+	for _, param := range params {
+		parts := strings.SplitN(param, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, errors.New(fmt.Sprintf("parameter %s must be in the format key=value", param))
+		}
+
+		query.Add(parts[0], parts[1])
+	}
+
+	return query, nil
+}
+
+func newStatsCommand() *cobra.Command {
+	params := []string{}
+
+	cmd := &cobra.Command{
+		Use:   "stats [name]",
+		Short: "Fetch statistics of the org",
+		Long: `Fetches statistics of the org from the CSL API with the given name.
+Without a name, the available statistics are listed.
+
+Examples:
+  shuffle-cli stats trends
+  shuffle-cli stats executionHeatmap -p weeks=4
+  shuffle-cli stats nodeStats -p workflow_id=<id> -p executions=500`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				for _, name := range getStatsNames() {
+					fmt.Printf("%-20s %s\n", name, statsEndpoints[name])
+				}
+
+				return nil
+			}
+
+			name := args[0]
+			if _, ok := statsEndpoints[name]; !ok {
+				return errors.New(fmt.Sprintf("unknown statistics %s. Use one of %s", name, strings.Join(getStatsNames(), ", ")))
+			}
+
+			query, err := parseParams(params)
+			if err != nil {
+				return err
+			}
+
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+
+			data, err := c.cslRequest("GET", "/api/v1/csl/"+name, query, nil)
+			if err != nil {
+				return err
+			}
+
+			return printJson(data)
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&params, "param", "p", []string{}, "query parameter as key=value, can be repeated")
+	return cmd
+}
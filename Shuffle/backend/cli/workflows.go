@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+type workflowSummary struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	IsValid bool     `json:"is_valid"`
+	Tags    []string `json:"tags"`
+	Owner   string   `json:"owner"`
+	Created int64    `json:"created"`
+	Edited  int64    `json:"edited"`
+}
+
+func newWorkflowsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "workflows",
+		Short: "List, export and import workflows",
+	}
+
+	full := false
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the workflows of the org",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+
+			body, err := c.request("GET", "/api/v1/workflows", nil, nil)
+			if err != nil {
+				return err
+			}
+
+			if full {
+				return printJson(json.RawMessage(body))
+			}
+
+			workflows := []workflowSummary{}
+			err = json.Unmarshal(body, &workflows)
+			if err != nil {
+				return err
+			}
+
+			return printJson(workflows)
+		},
+	}
+
+	list.Flags().BoolVar(&full, "full", false, "print the whole workflows instead of a summary")
+
+	output := ""
+	export := &cobra.Command{
+		Use:   "export <workflow_id>",
+		Short: "Export a workflow with its subflows and app dependencies as a signed bundle",
+		Long: `Exports a workflow as a bundle that can be imported into another org or
+instance with the same SHUFFLE_BUNDLE_SIGNING_KEY. Auth is exported as
+placeholders without secrets.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+
+			query := url.Values{"workflow_id": {args[0]}}
+			data, err := c.cslRequest("GET", "/api/v1/csl/bundles/export", query, nil)
+			if err != nil {
+				return err
+			}
+
+			if len(output) == 0 {
+				return printJson(data)
+			}
+
+			indented := bytes.Buffer{}
+			err = json.Indent(&indented, data, "", "  ")
+			if err != nil {
+				return err
+			}
+
+			err = ioutil.WriteFile(output, indented.Bytes(), 0600)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(os.Stderr, "Exported workflow %s to %s\n", args[0], output)
+			return nil
+		},
+	}
+
+	export.Flags().StringVarP(&output, "output", "o", "", "file to write the bundle to instead of stdout")
+
+	importCmd := &cobra.Command{
+		Use:   "import <bundle file>",
+		Short: "Import a bundle from export into the org",
+		Long: `Imports a bundle from export into the org. The workflows get new ids, and
+auth that couldn't be mapped to existing auth is listed in the output.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bundle, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+
+			if !json.Valid(bundle) {
+				return errors.New(fmt.Sprintf("%s isn't valid JSON", args[0]))
+			}
+
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+
+			data, err := c.cslRequest("POST", "/api/v1/csl/bundles/import", nil, bytes.NewReader(bundle))
+			if err != nil {
+				return err
+			}
+
+			return printJson(data)
+		},
+	}
+
+	cmd.AddCommand(list, export, importCmd)
+	return cmd
+}
@@ -4,15 +4,23 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strings"
 
 	"github.com/shuffle/shuffle-shared"
+	"golang.org/x/sync/errgroup"
 )
 
 const MaxAppCount = 1000
 const MonthLength = 30
 const WeekLength = 7
+const DaySeconds = 24 * 60 * 60
+
+// Max amount of workflow or org lookups running at the same time for one request or job
+const MaxConcurrentLookups = 10
 
 type CslResponse struct {
 	Success bool        `json:"success"`
@@ -31,8 +39,9 @@ type CslAppsResponse struct {
 }
 
 type CslApiUsageResponse struct {
-	TotalApiUsage int64 `json:"total_api_usage"`
-	DailyApiUsage int64 `json:"daily_api_usage"`
+	TotalApiUsage int64       `json:"total_api_usage"`
+	DailyApiUsage int64       `json:"daily_api_usage"`
+	ApiKeys       []CslApiKey `json:"api_keys"`
 }
 
 type CslWorkflowExecutionsResponse struct {
@@ -40,18 +49,21 @@ type CslWorkflowExecutionsResponse struct {
 	WorkflowExecutionsFinished int64   `json:"workflow_executions_finished"`
 	WorkflowExecutionsFailed   int64   `json:"workflow_executions_failed"`
 	DailyWorkflowExecutions    []int64 `json:"daily_workflow_executions"`
+	Timezone                   string  `json:"timezone"`
 }
 
 type CslChartResponse struct {
-	Day   CslExecutionStats `json:"day"`
-	Week  CslExecutionStats `json:"week"`
-	Month CslExecutionStats `json:"month"`
+	Day      CslExecutionStats `json:"day"`
+	Week     CslExecutionStats `json:"week"`
+	Month    CslExecutionStats `json:"month"`
+	Timezone string            `json:"timezone"`
 }
 
 type CslExecutionStats struct {
-	Total   int64 `json:"total"`
-	Success int64 `json:"success"`
-	Failure int64 `json:"failure"`
+	Total    int64 `json:"total"`
+	Success  int64 `json:"success"`
+	Failure  int64 `json:"failure"`
+	Timeouts int64 `json:"timeouts"`
 }
 
 // Take error and generate response in Csl expected format
@@ -97,21 +109,65 @@ func checkUserOrgAccess(ctx context.Context, user shuffle.User) error {
 	return errors.New("user attempting to access an organization they're not a part of")
 }
 
-// Handle a request that requires OrgStats, created to reduce code duplication.
+// Whether the roles middleware (csl_roles.go) granted the user the
+// permission for this request
+func hasCslDelegatedPermission(ctx context.Context, user shuffle.User, permission string) bool {
+	if len(permission) == 0 {
+		return false
+	}
+
+	grant, ok := shuffle.GetDelegatedGrant(ctx)
+	return ok && grant.UserId == user.Id && grant.OrgId == user.ActiveOrg.Id && grant.Permission == permission
+}
+
+// Verifies whether user is an admin of their active organization, or has
+// the permission through their custom role (csl_roles.go)
+func checkUserOrgPermission(ctx context.Context, user shuffle.User, permission string) error {
+	if hasCslDelegatedPermission(ctx, user, permission) {
+		return nil
+	}
+
+	return checkUserOrgAdmin(ctx, user)
+}
+
+// Verifies whether user is an admin of their active organization. Support
+// access is treated as admin access, permissions of custom roles are not
+func checkUserOrgAdmin(ctx context.Context, user shuffle.User) error {
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		log.Printf("[ERROR] Failed retrieving Org %s: %s", user.ActiveOrg.Id, err)
+		return err
+	}
+
+	for _, orgUser := range org.Users {
+		if orgUser.Id == user.Id && orgUser.Role == "admin" {
+			return nil
+		}
+	}
+
+	if user.SupportAccess {
+		log.Printf("[AUDIT] User %s (%s) is administering org %s (%s) with support access", user.Username, user.Id, org.Name, org.Id)
+		return nil
+	}
+
+	log.Printf("[WARNING] User %s isn't an admin of org %s", user.Id, org.Id)
+	return errors.New("user must be an admin of the organization")
+}
+
+// Handle an authenticated Csl request, created to reduce code duplication.
 // Function returns nil if error occurs and handles error response
 //  1. Handle Cors
 //  2. Handle Api Authentication
-//  3. Retrieves context
-//  4. Checks users access to org
-//  5. Retrieves and returns org statistics
-func handleOrgStatsRequest(resp http.ResponseWriter, request *http.Request) *shuffle.ExecutionInfo {
+//  3. Checks users access to org
+//  4. Answers mock requests the handler shouldn't get (handleCslMockRequest)
+func handleCslRequest(resp http.ResponseWriter, request *http.Request) *shuffle.User {
 	if shuffle.HandleCors(resp, request) {
 		return nil
 	}
 
 	user, err := shuffle.HandleApiAuthentication(resp, request)
 	if err != nil {
-		log.Printf("[ERROR] Api authentication failed in cslWorkflows: %s", err)
+		log.Printf("[ERROR] Api authentication failed in %s: %s", request.URL.Path, err)
 		resp.WriteHeader(401)
 		resp.Write(createCslErrorResponse(err))
 		return nil
@@ -126,6 +182,85 @@ func handleOrgStatsRequest(resp http.ResponseWriter, request *http.Request) *shu
 		return nil
 	}
 
+	if !handleCslMockRequest(resp, request, user.ActiveOrg.Id) {
+		return nil
+	}
+
+	return &user
+}
+
+// Same as handleCslRequest, but additionally requires the user to be an org
+// admin, or to have the permission cslPermissionRules gives the endpoint
+func handleCslAdminRequest(resp http.ResponseWriter, request *http.Request) *shuffle.User {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return nil
+	}
+
+	err := checkUserOrgPermission(shuffle.GetContext(request), *user, getCslRequestPermission(request))
+	if err != nil {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(err))
+		return nil
+	}
+
+	return user
+}
+
+// Authorizes either a user, or a running execution with
+// ?execution_id=<id>&authorization=<execution authorization> so playbooks can
+// store and scan evidence. Returns the org, the name of the caller and the
+// execution id when authorized as an execution
+func handleCslExecutionRequest(resp http.ResponseWriter, request *http.Request) (string, string, string, bool) {
+	query := request.URL.Query()
+	if len(query.Get("authorization")) == 0 {
+		user := handleCslRequest(resp, request)
+		if user == nil {
+			return "", "", "", false
+		}
+
+		return user.ActiveOrg.Id, user.Username, "", true
+	}
+
+	if shuffle.HandleCors(resp, request) {
+		return "", "", "", false
+	}
+
+	execution, err := shuffle.GetWorkflowExecution(shuffle.GetContext(request), query.Get("execution_id"))
+	if err != nil || len(execution.Authorization) == 0 || execution.Authorization != query.Get("authorization") {
+		log.Printf("[WARNING] Bad execution authorization in %s for execution %s", request.URL.Path, query.Get("execution_id"))
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("execution_id or authorization is not valid")))
+		return "", "", "", false
+	}
+
+	return execution.ExecutionOrg, fmt.Sprintf("execution %s", execution.ExecutionId), execution.ExecutionId, true
+}
+
+// Handle a request that requires OrgStats, created to reduce code duplication.
+// Function returns nil if error occurs and handles error response
+//  1. Handle Cors, Api Authentication and org access (handleCslRequest)
+//  2. Retrieves context
+//  3. Retrieves and returns org statistics, with the statistics of sub-orgs
+//     added when requested (handleCslChildOrgsRequest)
+func handleOrgStatsRequest(resp http.ResponseWriter, request *http.Request) *shuffle.ExecutionInfo {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return nil
+	}
+
+	childOrgIds, ok := handleCslChildOrgsRequest(resp, request, user)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := shuffle.GetRequestContext(request)
+	defer cancel()
+
+	if useCslMockData(resp, request) {
+		return getMockOrgStatistics(getCslMockData(ctx, user.ActiveOrg.Id), user.ActiveOrg.Id)
+	}
+
 	orgStats, err := shuffle.GetOrgStatistics(ctx, user.ActiveOrg.Id)
 	if err != nil {
 		log.Printf("[ERROR] Failed getting stats for org %s: %s", user.ActiveOrg.Id, err)
@@ -134,9 +269,236 @@ func handleOrgStatsRequest(resp http.ResponseWriter, request *http.Request) *shu
 		return nil
 	}
 
+	// Older stats aren't always stored with the org id
+	if len(orgStats.OrgId) == 0 {
+		orgStats.OrgId = user.ActiveOrg.Id
+	}
+
+	if len(childOrgIds) > 0 {
+		orgStats, err = getRolledUpOrgStatistics(ctx, orgStats, childOrgIds)
+		if err != nil {
+			log.Printf("[ERROR] Failed getting sub-org stats for org %s: %s", user.ActiveOrg.Id, err)
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(err))
+			return nil
+		}
+	}
+
 	return orgStats
 }
 
+// The org a request selects. Same precedence as HandleApiAuthentication, so
+// middlewares check the org the handler ends up using
+func getCslRequestOrgId(request *http.Request) string {
+	orgId := request.Header.Get("Org-Id")
+	if len(orgId) == 0 {
+		orgId = request.URL.Query().Get("org_id")
+	}
+
+	if len(orgId) == 0 {
+		orgId = request.Header.Get("OrgId")
+	}
+
+	return orgId
+}
+
+// Identifies the user of a request in middlewares. HandleApiAuthentication
+// counts every api key request as api usage, so keys are looked up directly
+// to not count requests twice before they reach the handler
+func getMiddlewareUser(resp http.ResponseWriter, request *http.Request) (shuffle.User, error) {
+	authorization := request.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return shuffle.HandleApiAuthentication(resp, request)
+	}
+
+	apikey := strings.TrimPrefix(authorization, "Bearer ")
+	if len(apikey) < 36 {
+		return shuffle.User{}, errors.New("invalid api key")
+	}
+
+	ctx := shuffle.GetContext(request)
+	orgId := getCslRequestOrgId(request)
+
+	// Same cache HandleApiAuthentication uses
+	user := shuffle.User{}
+	cache, err := shuffle.GetCache(ctx, apikey+orgId)
+	if cacheData, ok := cache.([]uint8); err == nil && ok {
+		json.Unmarshal(cacheData, &user)
+	}
+
+	if len(user.Id) == 0 {
+		user, err = shuffle.GetApikey(ctx, apikey)
+		if err != nil {
+			return shuffle.User{}, err
+		}
+
+		if len(orgId) > 0 && orgId != user.ActiveOrg.Id {
+			if !shuffle.ArrayContains(user.Orgs, orgId) {
+				return shuffle.User{}, errors.New("User doesn't have access to this org")
+			}
+
+			user.ActiveOrg.Id = orgId
+		}
+	}
+
+	if len(user.Id) == 0 {
+		return shuffle.User{}, errors.New("invalid api key")
+	}
+
+	return user, nil
+}
+
+// Retrieves a workflow and verifies that it belongs to the users active org
+func getCslWorkflow(ctx context.Context, user shuffle.User, workflowId string) (*shuffle.Workflow, error) {
+	if len(workflowId) != 36 {
+		return nil, errors.New("workflow_id is not valid")
+	}
+
+	workflow, err := shuffle.GetWorkflow(ctx, workflowId)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting workflow %s: %s", workflowId, err)
+		return nil, errors.New("workflow not found")
+	}
+
+	if workflow.OrgId != user.ActiveOrg.Id && workflow.Owner != user.Id {
+		log.Printf("[WARNING] User %s tried to access workflow %s outside their org", user.Id, workflowId)
+		return nil, errors.New("workflow not found")
+	}
+
+	return workflow, nil
+}
+
+// Retrieves all workflows in an org without a user context, used by background jobs
+func getOrgWorkflows(ctx context.Context, orgId string) ([]shuffle.Workflow, error) {
+	user := shuffle.User{
+		Role: "admin",
+		ActiveOrg: shuffle.OrgMini{
+			Id: orgId,
+		},
+	}
+
+	return shuffle.GetAllWorkflowsByQuery(ctx, user)
+}
+
+// Runs lookup for every index up to count with at most MaxConcurrentLookups
+// running at once. Results should be written by index so no locking is needed.
+// Returns the first error, after which remaining lookups are skipped
+func runConcurrentLookups(ctx context.Context, count int, lookup func(ctx context.Context, index int) error) error {
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(MaxConcurrentLookups)
+
+	for i := 0; i < count; i++ {
+		index := i
+		group.Go(func() error {
+			if groupCtx.Err() != nil {
+				return groupCtx.Err()
+			}
+
+			return lookup(groupCtx, index)
+		})
+	}
+
+	return group.Wait()
+}
+
+// The timezone days in the org statistics roll over in
+func getStatisticsTimezone(orgStats *shuffle.ExecutionInfo) string {
+	return getTimezoneLocation(orgStats.Timezone).String()
+}
+
+// Sums today's statistics and the previous days-1 days of DailyStatistics
+func sumRecentDailyStatistics(orgStats *shuffle.ExecutionInfo, days int) shuffle.DailyStatistics {
+	sum := shuffle.DailyStatistics{
+		AppExecutions:              orgStats.DailyAppExecutions,
+		AppExecutionsFailed:        orgStats.DailyAppExecutionsFailed,
+		SubflowExecutions:          orgStats.DailySubflowExecutions,
+		WorkflowExecutions:         orgStats.DailyWorkflowExecutions,
+		WorkflowExecutionsFinished: orgStats.DailyWorkflowExecutionsFinished,
+		WorkflowExecutionsFailed:   orgStats.DailyWorkflowExecutionsFailed,
+		ApiUsage:                   orgStats.DailyApiUsage,
+	}
+
+	i := 0
+	for i < days-1 && i < len(orgStats.DailyStatistics) {
+		dayStats := orgStats.DailyStatistics[len(orgStats.DailyStatistics)-i-1]
+		sum.AppExecutions += dayStats.AppExecutions
+		sum.AppExecutionsFailed += dayStats.AppExecutionsFailed
+		sum.SubflowExecutions += dayStats.SubflowExecutions
+		sum.WorkflowExecutions += dayStats.WorkflowExecutions
+		sum.WorkflowExecutionsFinished += dayStats.WorkflowExecutionsFinished
+		sum.WorkflowExecutionsFailed += dayStats.WorkflowExecutionsFailed
+		sum.ApiUsage += dayStats.ApiUsage
+
+		i++
+	}
+
+	return sum
+}
+
+// Sums the days days of DailyStatistics before the period summed by
+// sumRecentDailyStatistics, for comparing a period with the previous one
+func sumPreviousDailyStatistics(orgStats *shuffle.ExecutionInfo, days int) shuffle.DailyStatistics {
+	sum := shuffle.DailyStatistics{}
+
+	i := days - 1
+	for i < 2*days-1 && i < len(orgStats.DailyStatistics) {
+		dayStats := orgStats.DailyStatistics[len(orgStats.DailyStatistics)-i-1]
+		sum.AppExecutions += dayStats.AppExecutions
+		sum.AppExecutionsFailed += dayStats.AppExecutionsFailed
+		sum.SubflowExecutions += dayStats.SubflowExecutions
+		sum.WorkflowExecutions += dayStats.WorkflowExecutions
+		sum.WorkflowExecutionsFinished += dayStats.WorkflowExecutionsFinished
+		sum.WorkflowExecutionsFailed += dayStats.WorkflowExecutionsFailed
+		sum.ApiUsage += dayStats.ApiUsage
+
+		i++
+	}
+
+	return sum
+}
+
+type CslAdditionSum struct {
+	Count int64
+	Today int64
+	Total int64
+}
+
+// Sums the additions starting with prefix for today and the previous days-1
+// days, keyed by the rest of the key. Only keys in names are counted
+func sumRecentAdditions(orgStats *shuffle.ExecutionInfo, prefix string, names []string, days int) map[string]*CslAdditionSum {
+	sums := map[string]*CslAdditionSum{}
+	for _, name := range names {
+		sums[name] = &CslAdditionSum{}
+	}
+
+	for _, addition := range orgStats.Additions {
+		if sum, ok := sums[strings.TrimPrefix(addition.Key, prefix)]; ok && strings.HasPrefix(addition.Key, prefix) {
+			sum.Today = addition.DailyValue
+			sum.Total = addition.Value
+			sum.Count += addition.DailyValue
+		}
+	}
+
+	i := 0
+	for i < days-1 && i < len(orgStats.DailyStatistics) {
+		dayStats := orgStats.DailyStatistics[len(orgStats.DailyStatistics)-i-1]
+		for _, addition := range dayStats.Additions {
+			if sum, ok := sums[strings.TrimPrefix(addition.Key, prefix)]; ok && strings.HasPrefix(addition.Key, prefix) {
+				sum.Count += addition.DailyValue
+			}
+		}
+
+		i++
+	}
+
+	return sums
+}
+
+// Timeouts counted in the org statistics over the last days, by prefix and name
+func sumRecentTimeouts(orgStats *shuffle.ExecutionInfo, prefix, name string, days int) int64 {
+	return sumRecentAdditions(orgStats, prefix, []string{name}, days)[name].Count
+}
+
 // Write response status code and JSON response body.
 // If error occurs during marshaling handle it and write error response
 func marshalAndWriteResponse(response http.ResponseWriter, res interface{}, callingFunctionName string) {
@@ -152,60 +514,66 @@ func marshalAndWriteResponse(response http.ResponseWriter, res interface{}, call
 	response.Write(b)
 }
 
-// ===========================
-//          CSL APIS
-// ===========================
-
-// TESTING:
-// Test endpoint that returns example success Csl Response, missing auth checks
-func cslTestSuccess(resp http.ResponseWriter, request *http.Request) {
-	if shuffle.HandleCors(resp, request) {
-		return
+// Write a successful response where data has one array that can be large.
+// The array is written item by item with shuffle.WriteJsonArray instead of
+// being marshaled in memory with the rest of the response. fields are the
+// other data fields and are left out when empty
+func streamCslResponse[T any](response http.ResponseWriter, fields map[string]interface{}, arrayField string, items []T, callingFunctionName string) {
+	keys := []string{}
+	for key := range fields {
+		keys = append(keys, key)
 	}
 
-	res := CslResponse{
-		Success: true,
-		Data:    "this field can be any type",
-	}
+	sort.Strings(keys)
 
-	b, err := json.Marshal(res)
-	if err != nil {
-		resp.WriteHeader(500)
-		resp.Write(createCslErrorResponse(err))
-		return
-	}
+	head := []byte(`{"success":true,"data":{`)
+	for _, key := range keys {
+		value, err := json.Marshal(fields[key])
+		if err != nil {
+			log.Printf("[ERROR] Failed marshaling in %s", callingFunctionName)
+			response.WriteHeader(500)
+			response.Write(createCslErrorResponse(err))
+			return
+		}
 
-	resp.WriteHeader(200)
-	resp.Write(b)
-}
+		if string(value) == `""` || string(value) == "null" {
+			continue
+		}
 
-// TESTING:
-// Test endpoint that returns example failure Csl Response, missing auth checks
-func cslTestFailure(resp http.ResponseWriter, request *http.Request) {
-	if shuffle.HandleCors(resp, request) {
-		return
+		name, _ := json.Marshal(key)
+		head = append(head, name...)
+		head = append(head, ':')
+		head = append(head, value...)
+		head = append(head, ',')
 	}
 
-	res := CslResponse{
-		Success: false,
-		Reason:  "failed because something happened",
-	}
+	name, _ := json.Marshal(arrayField)
+	head = append(head, name...)
+	head = append(head, ':')
 
-	b, err := json.Marshal(res)
+	response.WriteHeader(200)
+	response.Write(head)
+
+	err := shuffle.WriteJsonArray(response, items)
 	if err != nil {
-		resp.WriteHeader(500)
-		resp.Write(createCslErrorResponse(err))
+		log.Printf("[ERROR] Failed streaming response in %s: %s", callingFunctionName, err)
 		return
 	}
 
-	resp.WriteHeader(200)
-	resp.Write(b)
+	response.Write([]byte("}}"))
 }
 
+// ===========================
+//          CSL APIS
+// ===========================
+
 /*
 Dashboard:
 Returns workflows belonging to current organization and number of those
-workflows that haven't been executed before
+workflows that haven't been executed before. Counts are recalculated every
+15 minutes. Org admins can include sub-orgs with ?include_children=true, or
+only some of them with ?child_org_ids=<id>,<id>, as in the other dashboard
+statistics.
 
 	{
 	    "success": true,
@@ -228,7 +596,8 @@ func cslWorkflows(resp http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	ctx := shuffle.GetContext(request)
+	ctx, cancel := shuffle.GetRequestContext(request)
+	defer cancel()
 
 	err = checkUserOrgAccess(ctx, user)
 	if err != nil {
@@ -237,37 +606,35 @@ func cslWorkflows(resp http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	workflows, err := shuffle.GetAllWorkflowsByQuery(ctx, user)
+	childOrgIds, ok := handleCslChildOrgsRequest(resp, request, &user)
+	if !ok {
+		return
+	}
+
+	snapshot, err := getRequestStatsSnapshot(resp, request, user.ActiveOrg.Id)
 	if err != nil {
-		log.Printf("[ERROR] Failed getting workflows for user %s: %s", user.Username, err)
+		log.Printf("[ERROR] Failed getting stats snapshot for org %s: %s", user.ActiveOrg.Id, err)
 		resp.WriteHeader(500)
 		resp.Write(createCslErrorResponse(err))
 		return
 	}
 
-	unexecutedWorkflows := 0
-	for _, workflow := range workflows {
-
-		// amount argument can be hardcoded to 1 since we just need to check
-		// if there's been 1 or more executions
-		workflowExecutions, err := shuffle.GetAllWorkflowExecutions(ctx, workflow.ID, 1)
+	for _, childOrgId := range childOrgIds {
+		childSnapshot, err := getStatsSnapshot(ctx, childOrgId)
 		if err != nil {
-			log.Printf("[ERROR] Failed getting workflow executions for workflow %s: %s", workflow.ID, err)
-			resp.WriteHeader(500)
-			resp.Write(createCslErrorResponse(err))
-			return
+			log.Printf("[WARNING] Failed getting stats snapshot for sub-org %s: %s", childOrgId, err)
+			continue
 		}
 
-		if len(workflowExecutions) == 0 {
-			unexecutedWorkflows++
-		}
+		snapshot.Workflows += childSnapshot.Workflows
+		snapshot.UnexecutedWorkflows += childSnapshot.UnexecutedWorkflows
 	}
 
 	res := CslResponse{
 		Success: true,
 		Data: CslWorkflowsResponse{
-			Workflows:           len(workflows),
-			UnexecutedWorkflows: unexecutedWorkflows,
+			Workflows:           snapshot.Workflows,
+			UnexecutedWorkflows: snapshot.UnexecutedWorkflows,
 		},
 	}
 
@@ -277,18 +644,16 @@ func cslWorkflows(resp http.ResponseWriter, request *http.Request) {
 /*
 Dashboard:
 Returns apps that the current organization has access to and the
-number of apps that haven't been executed before
-
-	  INCOMPLETE: unexecuted_apps functionality not completed
-	  currently value will always be returned as -1
+number of apps that haven't been used in the recent executions of the
+organizations workflows. App usage is recalculated every 15 minutes.
 
-		{
-		    "success": true,
-		    "data": {
-		        "apps": 62,
-		        "unexecuted_apps": 60
-		    }
-		}
+	{
+	    "success": true,
+	    "data": {
+	        "apps": 62,
+	        "unexecuted_apps": 60
+	    }
+	}
 */
 func cslApps(resp http.ResponseWriter, request *http.Request) {
 	if shuffle.HandleCors(resp, request) {
@@ -303,7 +668,8 @@ func cslApps(resp http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	ctx := shuffle.GetContext(request)
+	ctx, cancel := shuffle.GetRequestContext(request)
+	defer cancel()
 
 	err = checkUserOrgAccess(ctx, user)
 	if err != nil {
@@ -320,15 +686,32 @@ func cslApps(resp http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	// TODO: add logic to get UnexecutedApps count
-	//  information in the doc about potential solutions to get the information because
-	//  by default Shuffler has no tracking of which apps have and haven't been executed
+	snapshot, err := getRequestStatsSnapshot(resp, request, user.ActiveOrg.Id)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting stats snapshot for org %s: %s", user.ActiveOrg.Id, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	usedApps := map[string]bool{}
+	for _, usage := range snapshot.AppUsage {
+		usedApps[usage.AppId] = true
+		usedApps[usage.AppName] = true
+	}
+
+	unexecutedApps := 0
+	for _, app := range workflowapps {
+		if !usedApps[app.ID] && !usedApps[app.Name] {
+			unexecutedApps++
+		}
+	}
 
 	res := CslResponse{
 		Success: true,
 		Data: CslAppsResponse{
 			Apps:           len(workflowapps),
-			UnexecutedApps: -1, // always returning -1 because logic hasn't been setup yet
+			UnexecutedApps: unexecutedApps,
 		},
 	}
 
@@ -337,13 +720,25 @@ func cslApps(resp http.ResponseWriter, request *http.Request) {
 
 /*
 Dashboard:
-Returns total and daily API usage for the current organization
+Returns total and daily API usage for the current organization, and when each
+named api key was last used, most recently used first
 
 	{
 	    "success": true,
 	    "data": {
 	        "total_api_usage": 680,
-	        "daily_api_usage": 670
+	        "daily_api_usage": 670,
+	        "api_keys": [
+	            {
+	                "id": "...",
+	                "name": "SIEM integration",
+	                "username": "analyst@example.com",
+	                "last_used": 1700050000,
+	                "last_used_ip": "10.0.0.5",
+	                "status": "active",
+	                ...
+	            }
+	        ]
 	    }
 	}
 */
@@ -353,11 +748,15 @@ func cslApiUsage(resp http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	ctx, cancel := shuffle.GetRequestContext(request)
+	defer cancel()
+
 	res := CslResponse{
 		Success: true,
 		Data: CslApiUsageResponse{
 			TotalApiUsage: orgStats.TotalApiUsage,
 			DailyApiUsage: orgStats.DailyApiUsage,
+			ApiKeys:       getOrgApiKeyUsage(ctx, orgStats.OrgId),
 		},
 	}
 
@@ -367,7 +766,8 @@ func cslApiUsage(resp http.ResponseWriter, request *http.Request) {
 /*
 Dashboard:
 Returns monthly workflow (total, successful, failed) executions and
-a list of the daily workflow execution count for the last 30 days ordered from most recent to oldest
+a list of the daily workflow execution count for the last 30 days ordered from most recent to oldest.
+Days start at midnight in the returned timezone, set with the timezone CSL setting
 
 	{
 	    "success": true,
@@ -378,7 +778,8 @@ a list of the daily workflow execution count for the last 30 days ordered from m
 	        "daily_workflow_executions": [
 	            20,
 	            ...
-	        ]
+	        ],
+	        "timezone": "Europe/Oslo"
 	    }
 	}
 */
@@ -406,6 +807,7 @@ func cslWorkflowExecutions(resp http.ResponseWriter, request *http.Request) {
 			WorkflowExecutionsFinished: orgStats.MonthlyWorkflowExecutionsFinished,
 			WorkflowExecutionsFailed:   orgStats.MonthlyWorkflowExecutions - orgStats.MonthlyWorkflowExecutionsFinished,
 			DailyWorkflowExecutions:    dailyWorkflowExecutions,
+			Timezone:                   getStatisticsTimezone(orgStats),
 		},
 	}
 
@@ -414,7 +816,8 @@ func cslWorkflowExecutions(resp http.ResponseWriter, request *http.Request) {
 
 /*
 Dashboard:
-Returns day, week and month statistics for workflow total, succesful and failed executions
+Returns day, week and month statistics for workflow total, succesful and failed executions. Days start
+at midnight in the returned timezone. Timeouts are the executions aborted by their workflow timeout
 
 	{
 		"success": true,
@@ -422,14 +825,16 @@ Returns day, week and month statistics for workflow total, succesful and failed
 			"day": {
 				"total": 20,
 				"success": 10,
-				"failure": 10
+				"failure": 10,
+				"timeouts": 2
 			},
 			"week": {
 			...
 			},
 			"month": {
 			...
-			}
+			},
+			"timezone": "Europe/Oslo"
 		}
 	}
 */
@@ -456,20 +861,24 @@ func cslWorkflowChart(resp http.ResponseWriter, request *http.Request) {
 		Success: true,
 		Data: CslChartResponse{
 			Day: CslExecutionStats{
-				Total:   orgStats.DailyWorkflowExecutions,
-				Success: orgStats.DailyWorkflowExecutionsFinished,
-				Failure: orgStats.DailyWorkflowExecutions - orgStats.DailyWorkflowExecutionsFinished,
+				Total:    orgStats.DailyWorkflowExecutions,
+				Success:  orgStats.DailyWorkflowExecutionsFinished,
+				Failure:  orgStats.DailyWorkflowExecutions - orgStats.DailyWorkflowExecutionsFinished,
+				Timeouts: sumRecentTimeouts(orgStats, TimeoutStatPrefix, TimeoutStatName, 1),
 			},
 			Week: CslExecutionStats{
-				Total:   weekSuccess + weekFailure,
-				Success: weekSuccess,
-				Failure: weekFailure,
+				Total:    weekSuccess + weekFailure,
+				Success:  weekSuccess,
+				Failure:  weekFailure,
+				Timeouts: sumRecentTimeouts(orgStats, TimeoutStatPrefix, TimeoutStatName, WeekLength),
 			},
 			Month: CslExecutionStats{
-				Total:   orgStats.MonthlyWorkflowExecutions,
-				Success: orgStats.MonthlyWorkflowExecutionsFinished,
-				Failure: orgStats.MonthlyWorkflowExecutions - orgStats.MonthlyWorkflowExecutionsFinished,
+				Total:    orgStats.MonthlyWorkflowExecutions,
+				Success:  orgStats.MonthlyWorkflowExecutionsFinished,
+				Failure:  orgStats.MonthlyWorkflowExecutions - orgStats.MonthlyWorkflowExecutionsFinished,
+				Timeouts: sumRecentTimeouts(orgStats, TimeoutStatPrefix, TimeoutStatName, MonthLength),
 			},
+			Timezone: getStatisticsTimezone(orgStats),
 		},
 	}
 
@@ -478,7 +887,8 @@ func cslWorkflowChart(resp http.ResponseWriter, request *http.Request) {
 
 /*
 Dashboard:
-Returns day, week and month statistics for app total, succesful and failed executions
+Returns day, week and month statistics for app total, succesful and failed executions. Days start
+at midnight in the returned timezone. Timeouts are the failed app executions in the timeout category
 
 	{
 		"success": true,
@@ -486,14 +896,16 @@ Returns day, week and month statistics for app total, succesful and failed execu
 			"day": {
 				"total": 30,
 				"success": 30,
-				"failure": 0
+				"failure": 0,
+				"timeouts": 0
 			},
 			"week": {
 			...
 			},
 			"month": {
 			...
-			}
+			},
+			"timezone": "Europe/Oslo"
 		}
 	}
 */
@@ -520,20 +932,24 @@ func cslAppChart(resp http.ResponseWriter, request *http.Request) {
 		Success: true,
 		Data: CslChartResponse{
 			Day: CslExecutionStats{
-				Total:   orgStats.DailyAppExecutions,
-				Success: orgStats.DailyAppExecutions - orgStats.DailyAppExecutionsFailed,
-				Failure: orgStats.DailyAppExecutionsFailed,
+				Total:    orgStats.DailyAppExecutions,
+				Success:  orgStats.DailyAppExecutions - orgStats.DailyAppExecutionsFailed,
+				Failure:  orgStats.DailyAppExecutionsFailed,
+				Timeouts: sumRecentTimeouts(orgStats, shuffle.FailureStatPrefix, shuffle.FailureTimeout, 1),
 			},
 			Week: CslExecutionStats{
-				Total:   weekSuccess + weekFailure,
-				Success: weekSuccess,
-				Failure: weekFailure,
+				Total:    weekSuccess + weekFailure,
+				Success:  weekSuccess,
+				Failure:  weekFailure,
+				Timeouts: sumRecentTimeouts(orgStats, shuffle.FailureStatPrefix, shuffle.FailureTimeout, WeekLength),
 			},
 			Month: CslExecutionStats{
-				Total:   orgStats.MonthlyAppExecutions,
-				Success: orgStats.MonthlyAppExecutions - orgStats.MonthlyAppExecutionsFailed,
-				Failure: orgStats.MonthlyAppExecutionsFailed,
+				Total:    orgStats.MonthlyAppExecutions,
+				Success:  orgStats.MonthlyAppExecutions - orgStats.MonthlyAppExecutionsFailed,
+				Failure:  orgStats.MonthlyAppExecutionsFailed,
+				Timeouts: sumRecentTimeouts(orgStats, shuffle.FailureStatPrefix, shuffle.FailureTimeout, MonthLength),
 			},
+			Timezone: getStatisticsTimezone(orgStats),
 		},
 	}
 
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslActivityDocument = "activity"
+
+// Max amount of recorded activities kept per org. Oldest are dropped first
+const MaxActivityCount = 1000
+const DefaultActivityLimit = 50
+const MaxActivityLimit = 200
+
+// Amount of executions looked at per workflow when finding executions of interest
+const ActivityExecutionsPerWorkflow = 20
+
+// Activity types
+const (
+	ActivityTypeWorkflow    = "workflow"
+	ActivityTypeCase        = "case"
+	ActivityTypeExecution   = "execution"
+	ActivityTypeIntegration = "integration"
+	ActivityTypeAdmin       = "admin"
+	ActivityTypeSecurity    = "security"
+)
+
+var activityTypes = []string{ActivityTypeWorkflow, ActivityTypeCase, ActivityTypeExecution, ActivityTypeIntegration, ActivityTypeAdmin, ActivityTypeSecurity}
+
+type CslActivity struct {
+	Id          string `json:"id"`
+	Type        string `json:"type"`
+	Action      string `json:"action"`
+	Title       string `json:"title"`
+	Actor       string `json:"actor,omitempty"`
+	ReferenceId string `json:"reference_id,omitempty"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+type CslActivityLog struct {
+	Activities []CslActivity `json:"activities"`
+}
+
+// Records an activity in the org's activity feed. Failures are logged, not returned,
+// as recording activity should never break the action being recorded
+func recordCslActivity(ctx context.Context, orgId, activityType, action, title, actor, referenceId string) {
+	activity := CslActivity{
+		Id:          uuid.NewV4().String(),
+		Type:        activityType,
+		Action:      action,
+		Title:       title,
+		Actor:       actor,
+		ReferenceId: referenceId,
+		Timestamp:   time.Now().Unix(),
+	}
+
+	activityLog := CslActivityLog{}
+	err := updateCslDocument(ctx, orgId, CslActivityDocument, &activityLog, func() error {
+		activityLog.Activities = append(activityLog.Activities, activity)
+		if len(activityLog.Activities) > MaxActivityCount {
+			activityLog.Activities = activityLog.Activities[len(activityLog.Activities)-MaxActivityCount:]
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed recording %s activity for org %s: %s", activityType, orgId, err)
+	}
+}
+
+// Workflow changes are derived from the workflows themselves
+func getWorkflowActivities(workflows []shuffle.Workflow, since int64) []CslActivity {
+	activities := []CslActivity{}
+	for _, workflow := range workflows {
+		if workflow.Edited > since && workflow.Edited > workflow.Created {
+			activities = append(activities, CslActivity{
+				Id:          fmt.Sprintf("workflow_edited_%s_%d", workflow.ID, workflow.Edited),
+				Type:        ActivityTypeWorkflow,
+				Action:      "updated",
+				Title:       fmt.Sprintf("Workflow %s was updated", workflow.Name),
+				ReferenceId: workflow.ID,
+				Timestamp:   workflow.Edited,
+			})
+		}
+
+		if workflow.Created > since {
+			activities = append(activities, CslActivity{
+				Id:          fmt.Sprintf("workflow_created_%s", workflow.ID),
+				Type:        ActivityTypeWorkflow,
+				Action:      "created",
+				Title:       fmt.Sprintf("Workflow %s was created", workflow.Name),
+				Actor:       workflow.Owner,
+				ReferenceId: workflow.ID,
+				Timestamp:   workflow.Created,
+			})
+		}
+	}
+
+	return activities
+}
+
+// Executions of interest are the ones that didn't finish successfully
+func getExecutionActivities(ctx context.Context, workflows []shuffle.Workflow, since int64) []CslActivity {
+	workflowExecutions := make([][]shuffle.WorkflowExecution, len(workflows))
+	runConcurrentLookups(ctx, len(workflows), func(ctx context.Context, index int) error {
+		executions, err := shuffle.GetAllWorkflowExecutions(ctx, workflows[index].ID, ActivityExecutionsPerWorkflow)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting executions for workflow %s in activity feed: %s", workflows[index].ID, err)
+			return nil
+		}
+
+		workflowExecutions[index] = executions
+		return nil
+	})
+
+	return getFailedExecutionActivities(workflows, workflowExecutions, since)
+}
+
+// Same as getExecutionActivities, with the executions of each workflow given
+func getFailedExecutionActivities(workflows []shuffle.Workflow, workflowExecutions [][]shuffle.WorkflowExecution, since int64) []CslActivity {
+	activities := []CslActivity{}
+	for i, workflow := range workflows {
+		for _, execution := range workflowExecutions[i] {
+			if execution.Status != "ABORTED" && execution.Status != "FAILURE" {
+				continue
+			}
+
+			timestamp := execution.CompletedAt
+			if timestamp == 0 {
+				timestamp = execution.StartedAt
+			}
+
+			if timestamp <= since {
+				continue
+			}
+
+			activities = append(activities, CslActivity{
+				Id:          fmt.Sprintf("execution_%s", execution.ExecutionId),
+				Type:        ActivityTypeExecution,
+				Action:      strings.ToLower(execution.Status),
+				Title:       fmt.Sprintf("Execution of %s ended with status %s", workflow.Name, execution.Status),
+				ReferenceId: execution.ExecutionId,
+				Timestamp:   timestamp,
+			})
+		}
+	}
+
+	return activities
+}
+
+// Integration health changes are derived from credentials that have expired
+func getIntegrationActivities(ctx context.Context, orgId string, since int64) []CslActivity {
+	settings := getCslOrgSettings(ctx, orgId)
+	statuses, err := getOrgCredentialStatuses(ctx, orgId, settings.CredentialExpiryWarningDays)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting credentials for org %s in activity feed: %s", orgId, err)
+		return []CslActivity{}
+	}
+
+	activities := []CslActivity{}
+	for _, status := range statuses {
+		if status.Status != CredentialStatusExpired || status.ExpiresAt <= since {
+			continue
+		}
+
+		activities = append(activities, CslActivity{
+			Id:          fmt.Sprintf("credential_expired_%s", status.Id),
+			Type:        ActivityTypeIntegration,
+			Action:      "credential_expired",
+			Title:       fmt.Sprintf("Credential %s for %s expired", status.Label, status.AppName),
+			ReferenceId: status.Id,
+			Timestamp:   status.ExpiresAt,
+		})
+	}
+
+	return activities
+}
+
+// Cursor format: <timestamp>_<id> of the last item returned
+func parseActivityCursor(cursor string) (int64, string, error) {
+	if len(cursor) == 0 {
+		return 0, "", nil
+	}
+
+	parts := strings.SplitN(cursor, "_", 2)
+	if len(parts) != 2 {
+		return 0, "", errors.New("invalid cursor")
+	}
+
+	timestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", errors.New("invalid cursor")
+	}
+
+	return timestamp, parts[1], nil
+}
+
+// Newest first. Ties are ordered by id to make cursoring stable
+func activityBefore(a, b CslActivity) bool {
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp > b.Timestamp
+	}
+
+	return a.Id > b.Id
+}
+
+func parseActivityTypes(value string) ([]string, error) {
+	if len(value) == 0 {
+		return activityTypes, nil
+	}
+
+	types := []string{}
+	for _, activityType := range strings.Split(value, ",") {
+		activityType = strings.TrimSpace(strings.ToLower(activityType))
+		if !shuffle.ArrayContains(activityTypes, activityType) {
+			return types, errors.New(fmt.Sprintf("unknown activity type %s. Valid types: %s", activityType, strings.Join(activityTypes, ", ")))
+		}
+
+		types = append(types, activityType)
+	}
+
+	return types, nil
+}
+
+/*
+Dashboard:
+Returns the org activity feed, newest first. Combines workflow changes, case updates,
+failed/aborted executions, integration health changes, admin actions and security
+events such as 2FA changes and account lockouts.
+Filter with ?type=workflow,case,execution,integration,admin,security and ?since=<unix timestamp>
+(e.g. start of today). Page with ?limit=N (default 50, max 200) and ?cursor=<cursor>
+from the previous page.
+
+	{
+	    "success": true,
+	    "data": {
+	        "items": [
+	            {
+	                "id": "execution_...",
+	                "type": "execution",
+	                "action": "aborted",
+	                "title": "Execution of Phishing triage ended with status ABORTED",
+	                "reference_id": "...",
+	                "timestamp": 1700000000
+	            }
+	        ],
+	        "cursor": "1700000000_execution_..."
+	    }
+	}
+*/
+func cslActivityFeed(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	types, err := parseActivityTypes(query.Get("type"))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	cursorTimestamp, cursorId, err := parseActivityCursor(query.Get("cursor"))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	limit := DefaultActivityLimit
+	if parsedLimit, err := strconv.Atoi(query.Get("limit")); err == nil && parsedLimit > 0 {
+		limit = parsedLimit
+	}
+
+	if limit > MaxActivityLimit {
+		limit = MaxActivityLimit
+	}
+
+	var since int64
+	if parsedSince, err := strconv.ParseInt(query.Get("since"), 10, 64); err == nil {
+		since = parsedSince
+	}
+
+	activities := []CslActivity{}
+
+	// Mock orgs have nothing recorded and no credentials
+	if useCslMockData(resp, request) {
+		data := getCslMockData(ctx, user.ActiveOrg.Id)
+		if shuffle.ArrayContains(types, ActivityTypeWorkflow) {
+			activities = append(activities, getWorkflowActivities(data.WorkflowList, since)...)
+		}
+
+		if shuffle.ArrayContains(types, ActivityTypeExecution) {
+			activities = append(activities, getFailedExecutionActivities(data.WorkflowList, data.RecentExecutions, since)...)
+		}
+
+		writeCslActivityPage(resp, activities, types, cursorTimestamp, cursorId, limit)
+		return
+	}
+
+	activityLog := CslActivityLog{}
+	_, err = getCslDocument(ctx, user.ActiveOrg.Id, CslActivityDocument, &activityLog)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting recorded activities for org %s: %s", user.ActiveOrg.Id, err)
+	}
+
+	for _, activity := range activityLog.Activities {
+		if activity.Timestamp > since {
+			activities = append(activities, activity)
+		}
+	}
+
+	if shuffle.ArrayContains(types, ActivityTypeWorkflow) || shuffle.ArrayContains(types, ActivityTypeExecution) {
+		workflows, err := shuffle.GetAllWorkflowsByQuery(ctx, *user)
+		if err != nil {
+			log.Printf("[ERROR] Failed getting workflows for user %s: %s", user.Username, err)
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		if shuffle.ArrayContains(types, ActivityTypeWorkflow) {
+			activities = append(activities, getWorkflowActivities(workflows, since)...)
+		}
+
+		if shuffle.ArrayContains(types, ActivityTypeExecution) {
+			activities = append(activities, getExecutionActivities(ctx, workflows, since)...)
+		}
+	}
+
+	if shuffle.ArrayContains(types, ActivityTypeIntegration) {
+		activities = append(activities, getIntegrationActivities(ctx, user.ActiveOrg.Id, since)...)
+	}
+
+	writeCslActivityPage(resp, activities, types, cursorTimestamp, cursorId, limit)
+}
+
+// Writes the page of activities of the given types after the cursor, newest first
+func writeCslActivityPage(resp http.ResponseWriter, activities []CslActivity, types []string, cursorTimestamp int64, cursorId string, limit int) {
+	sort.SliceStable(activities, func(i, j int) bool {
+		return activityBefore(activities[i], activities[j])
+	})
+
+	cursorActivity := CslActivity{Id: cursorId, Timestamp: cursorTimestamp}
+	items := []CslActivity{}
+	for _, activity := range activities {
+		if !shuffle.ArrayContains(types, activity.Type) {
+			continue
+		}
+
+		if cursorTimestamp > 0 && !activityBefore(cursorActivity, activity) {
+			continue
+		}
+
+		items = append(items, activity)
+		if len(items) >= limit {
+			break
+		}
+	}
+
+	nextCursor := ""
+	if len(items) == limit {
+		last := items[len(items)-1]
+		nextCursor = fmt.Sprintf("%d_%s", last.Timestamp, last.Id)
+	}
+
+	fields := map[string]interface{}{
+		"cursor": nextCursor,
+	}
+
+	streamCslResponse(resp, fields, "items", items, "cslActivityFeed")
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Alert rules are conditions that stay open until they clear: failure spikes,
+// SLA breaches and worker outages. An alert is notified when it opens, and
+// PagerDuty incidents, Jira issues and ServiceNow incidents are resolved
+// when it clears.
+
+const CslAlertsDocument = "alerts"
+const CslFailureSpikeDocument = "failure_spike"
+
+const FailureSpikeMinutes = 15
+const SlaCheckMinutes = 15
+const WorkerOutageCheckMinutes = 5
+
+// Orborus checks in about every minute while it polls the queue
+const WorkerOutageMinutes = 10
+
+// Most recent executions per workflow checked for SLA breaches
+const SlaExecutionsPerWorkflow = 20
+
+// Events of the alert rules
+var alertEvents = []string{EventFailureSpike, EventSlaBreach, EventWorkerOutage}
+
+type CslAlert struct {
+	Event       string `json:"event"`
+	Key         string `json:"key"`
+	Title       string `json:"title"`
+	Severity    string `json:"severity"`
+	TriggeredAt int64  `json:"triggered_at"`
+}
+
+// Open alerts keyed by <event>:<key>
+type CslAlerts struct {
+	Open map[string]CslAlert `json:"open"`
+}
+
+type CslFailureSpike struct {
+	Failures        int64   `json:"failures"`
+	Executions      int64   `json:"executions"`
+	FailureRate     float64 `json:"failure_rate"`
+	Threshold       int     `json:"threshold"`
+	IntervalMinutes int     `json:"interval_minutes"`
+}
+
+// Daily counters at the last check, so the failures since then can be found
+type CslFailureSpikeState struct {
+	Date       string `json:"date"`
+	Failed     int64  `json:"failed"`
+	Executions int64  `json:"executions"`
+}
+
+type CslWorkerOutage struct {
+	EnvironmentId string `json:"environment_id"`
+	Name          string `json:"name"`
+	LastCheckin   int64  `json:"last_checkin"`
+	RunningIp     string `json:"running_ip"`
+	MinutesSince  int64  `json:"minutes_since"`
+}
+
+func getAlertId(event, key string) string {
+	return fmt.Sprintf("%s:%s", event, key)
+}
+
+func getCslAlerts(ctx context.Context, orgId string) CslAlerts {
+	alerts := CslAlerts{}
+	_, err := getCslDocument(ctx, orgId, CslAlertsDocument, &alerts)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting alerts for org %s: %s", orgId, err)
+	}
+
+	if alerts.Open == nil {
+		alerts.Open = map[string]CslAlert{}
+	}
+
+	return alerts
+}
+
+// Opens an alert and notifies the org, unless it is already open
+func triggerCslAlert(ctx context.Context, orgId, event, key string, data interface{}) {
+	notification := getCslNotification(orgId, event, data)
+
+	opened := false
+	alerts := CslAlerts{}
+	err := updateCslDocument(ctx, orgId, CslAlertsDocument, &alerts, func() error {
+		if alerts.Open == nil {
+			alerts.Open = map[string]CslAlert{}
+		}
+
+		if _, ok := alerts.Open[getAlertId(event, key)]; ok {
+			return nil
+		}
+
+		alerts.Open[getAlertId(event, key)] = CslAlert{
+			Event:       event,
+			Key:         key,
+			Title:       notification.Title,
+			Severity:    notification.Severity,
+			TriggeredAt: notification.Timestamp,
+		}
+
+		opened = true
+		return nil
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed storing alert %s for org %s: %s", getAlertId(event, key), orgId, err)
+		return
+	}
+
+	if !opened {
+		return
+	}
+
+	log.Printf("[INFO] Alert %s opened for org %s: %s", getAlertId(event, key), orgId, notification.Title)
+	notifyCslEvent(ctx, orgId, event, data)
+	sendCslPagerDutyEvent(ctx, orgId, PagerDutyTrigger, key, notification)
+	openCslJiraIssue(ctx, orgId, event, key, notification)
+	openCslServiceNowIncident(ctx, orgId, event, key, notification)
+}
+
+// Closes an alert if it is open, resolving its incidents and issues
+func resolveCslAlert(ctx context.Context, orgId, event, key string) {
+	alert := CslAlert{}
+	alerts := CslAlerts{}
+	err := updateCslDocument(ctx, orgId, CslAlertsDocument, &alerts, func() error {
+		alert = alerts.Open[getAlertId(event, key)]
+		delete(alerts.Open, getAlertId(event, key))
+		return nil
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed resolving alert %s for org %s: %s", getAlertId(event, key), orgId, err)
+		return
+	}
+
+	if len(alert.Event) == 0 {
+		return
+	}
+
+	log.Printf("[INFO] Alert %s resolved for org %s", getAlertId(event, key), orgId)
+	sendCslPagerDutyEvent(ctx, orgId, PagerDutyResolve, key, CslNotification{
+		Event:     event,
+		OrgId:     orgId,
+		Title:     alert.Title,
+		Severity:  alert.Severity,
+		Timestamp: time.Now().Unix(),
+	})
+	resolveCslJiraIssue(ctx, orgId, event, key)
+	resolveCslServiceNowIncident(ctx, orgId, event, key)
+}
+
+// Job: opens an alert while more executions fail between two checks than the
+// failure_spike_threshold setting, and resolves it when they don't
+func runCslFailureSpikeJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for failure spike job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		settings := getCslOrgSettings(ctx, org.Id)
+		if settings.FailureSpikeThreshold <= 0 || !hasCslNotificationChannel(ctx, org.Id) {
+			continue
+		}
+
+		orgStats, err := shuffle.GetOrgStatistics(ctx, org.Id)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting stats for org %s in failure spike job: %s", org.Id, err)
+			continue
+		}
+
+		now := time.Now().In(getTimezoneLocation(orgStats.Timezone))
+		checked := false
+		spike := CslFailureSpike{}
+		state := CslFailureSpikeState{}
+		err = updateCslDocument(ctx, org.Id, CslFailureSpikeDocument, &state, func() error {
+			// The first check is only a baseline. Counters restart every day
+			if len(state.Date) > 0 {
+				previousFailed, previousExecutions := state.Failed, state.Executions
+				if state.Date != now.Format(UsageDateFormat) {
+					previousFailed, previousExecutions = 0, 0
+				}
+
+				checked = true
+				spike = CslFailureSpike{
+					Failures:        orgStats.DailyWorkflowExecutionsFailed - previousFailed,
+					Executions:      orgStats.DailyWorkflowExecutions - previousExecutions,
+					Threshold:       settings.FailureSpikeThreshold,
+					IntervalMinutes: FailureSpikeMinutes,
+				}
+			}
+
+			state.Date = now.Format(UsageDateFormat)
+			state.Failed = orgStats.DailyWorkflowExecutionsFailed
+			state.Executions = orgStats.DailyWorkflowExecutions
+			return nil
+		})
+		if err != nil {
+			log.Printf("[ERROR] Failed updating failure spike state for org %s: %s", org.Id, err)
+			continue
+		}
+
+		if !checked {
+			continue
+		}
+
+		if spike.Failures < int64(settings.FailureSpikeThreshold) {
+			resolveCslAlert(ctx, org.Id, EventFailureSpike, "executions")
+			continue
+		}
+
+		if spike.Executions > 0 {
+			spike.FailureRate = roundScore(float64(spike.Failures) / float64(spike.Executions) * 100)
+		}
+
+		triggerCslAlert(ctx, org.Id, EventFailureSpike, "executions", spike)
+	}
+}
+
+// Job: opens an alert for every execution that has been running longer than
+// the sla_minutes setting, and resolves it when the execution is done
+func runCslSlaJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for SLA job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		settings := getCslOrgSettings(ctx, org.Id)
+		if settings.SlaMinutes <= 0 || !hasCslNotificationChannel(ctx, org.Id) {
+			continue
+		}
+
+		workflows, err := getOrgWorkflows(ctx, org.Id)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting workflows for org %s in SLA job: %s", org.Id, err)
+			continue
+		}
+
+		workflowExecutions := make([][]shuffle.WorkflowExecution, len(workflows))
+		runConcurrentLookups(ctx, len(workflows), func(ctx context.Context, index int) error {
+			executions, err := shuffle.GetAllWorkflowExecutions(ctx, workflows[index].ID, SlaExecutionsPerWorkflow)
+			if err != nil {
+				log.Printf("[WARNING] Failed getting executions for workflow %s in SLA job: %s", workflows[index].ID, err)
+				return nil
+			}
+
+			workflowExecutions[index] = executions
+			return nil
+		})
+
+		timeNow := time.Now().Unix()
+		breaching := map[string]bool{}
+		for i, workflow := range workflows {
+			for _, execution := range workflowExecutions[i] {
+				if execution.Status != "EXECUTING" && execution.Status != "WAITING" {
+					continue
+				}
+
+				duration := timeNow - execution.StartedAt
+				if duration <= int64(settings.SlaMinutes)*60 {
+					continue
+				}
+
+				breaching[execution.ExecutionId] = true
+				triggerCslAlert(ctx, org.Id, EventSlaBreach, execution.ExecutionId, CslDigestSlaBreach{
+					ExecutionId:     execution.ExecutionId,
+					WorkflowId:      workflow.ID,
+					WorkflowName:    workflow.Name,
+					Status:          execution.Status,
+					StartedAt:       execution.StartedAt,
+					DurationMinutes: duration / 60,
+				})
+			}
+		}
+
+		for _, alert := range getCslAlerts(ctx, org.Id).Open {
+			if alert.Event == EventSlaBreach && !breaching[alert.Key] {
+				resolveCslAlert(ctx, org.Id, EventSlaBreach, alert.Key)
+			}
+		}
+	}
+}
+
+// Job: opens an alert for every Orborus environment that stopped checking
+// in, and resolves it when it is back. Environments that never checked in are
+// skipped
+func runCslWorkerOutageJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for worker outage job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		if !hasCslNotificationChannel(ctx, org.Id) {
+			continue
+		}
+
+		environments, err := shuffle.GetEnvironments(ctx, org.Id)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting environments for org %s in worker outage job: %s", org.Id, err)
+			continue
+		}
+
+		timeNow := time.Now().Unix()
+		for _, environment := range environments {
+			if environment.Archived || environment.Type == "cloud" || environment.Checkin == 0 {
+				continue
+			}
+
+			if timeNow-environment.Checkin <= WorkerOutageMinutes*60 {
+				resolveCslAlert(ctx, org.Id, EventWorkerOutage, environment.Id)
+				continue
+			}
+
+			triggerCslAlert(ctx, org.Id, EventWorkerOutage, environment.Id, CslWorkerOutage{
+				EnvironmentId: environment.Id,
+				Name:          environment.Name,
+				LastCheckin:   environment.Checkin,
+				RunningIp:     environment.RunningIp,
+				MinutesSince:  (timeNow - environment.Checkin) / 60,
+			})
+		}
+	}
+}
+
+/*
+Dashboard:
+Returns the open alerts of the current organization, oldest first. Alerts are
+opened for execution failure spikes, executions running longer than the SLA
+and Orborus environments that stopped checking in, and close by themselves.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "event": "worker_outage",
+	            "key": "...",
+	            "title": "Environment onprem stopped checking in",
+	            "severity": "critical",
+	            "triggered_at": 1700000000
+	        }
+	    ]
+	}
+*/
+func cslOpenAlerts(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	alerts := []CslAlert{}
+	for _, alert := range getCslAlerts(ctx, user.ActiveOrg.Id).Open {
+		alerts = append(alerts, alert)
+	}
+
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return alerts[i].TriggeredAt < alerts[j].TriggeredAt
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data:    alerts,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslOpenAlerts")
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslIpAllowlistDocument = "ip_allowlist"
+
+const MaxIpAllowlistEntries = 200
+
+// Blocked requests from the same IP are only added to the activity log once per interval
+const IpBlockedActivityMinutes = 10
+
+type CslIpAllowlistEntry struct {
+	Cidr        string `json:"cidr"`
+	Description string `json:"description"`
+	CreatedBy   string `json:"created_by"`
+	Created     int64  `json:"created"`
+}
+
+// Requests authenticated to an org are only let through from these networks
+// when the allowlist is enabled
+type CslIpAllowlist struct {
+	Enabled bool                  `json:"enabled"`
+	Entries []CslIpAllowlistEntry `json:"entries"`
+}
+
+func getCslIpAllowlist(ctx context.Context, orgId string) CslIpAllowlist {
+	allowlist := CslIpAllowlist{}
+	_, err := getCslDocument(ctx, orgId, CslIpAllowlistDocument, &allowlist)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting IP allowlist for org %s: %s", orgId, err)
+	}
+
+	if allowlist.Entries == nil {
+		allowlist.Entries = []CslIpAllowlistEntry{}
+	}
+
+	return allowlist
+}
+
+// Single IPs are stored as /32 or /128 networks
+func normalizeCidr(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return "", errors.New(fmt.Sprintf("%s is not a valid IP or CIDR", value))
+		}
+
+		if ip.To4() != nil {
+			return fmt.Sprintf("%s/32", ip.String()), nil
+		}
+
+		return fmt.Sprintf("%s/128", ip.String()), nil
+	}
+
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("%s is not a valid IP or CIDR", value))
+	}
+
+	return network.String(), nil
+}
+
+func isIpAllowed(allowlist CslIpAllowlist, ip string) bool {
+	if !allowlist.Enabled {
+		return true
+	}
+
+	parsedIp := net.ParseIP(strings.TrimSpace(ip))
+	if parsedIp == nil {
+		return false
+	}
+
+	for _, entry := range allowlist.Entries {
+		_, network, err := net.ParseCIDR(entry.Cidr)
+		if err != nil {
+			continue
+		}
+
+		if network.Contains(parsedIp) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func recordIpBlocked(ctx context.Context, orgId string, user shuffle.User, ip, path string) {
+	log.Printf("[AUDIT] Blocked request to %s from %s for user %s (%s) in org %s: IP not in allowlist", path, ip, user.Username, user.Id, orgId)
+
+	cacheKey := fmt.Sprintf("csl_ip_blocked_%s_%s", orgId, ip)
+	cache, err := shuffle.GetCache(ctx, cacheKey)
+	if err == nil && cache != nil {
+		return
+	}
+
+	shuffle.SetCache(ctx, cacheKey, []byte("1"), IpBlockedActivityMinutes)
+	recordCslActivity(ctx, orgId, ActivityTypeAdmin, "ip_blocked", fmt.Sprintf("Request from %s by %s was blocked by the IP allowlist", ip, user.Username), user.Username, user.Id)
+}
+
+// Middleware enforcing the org IP allowlist before requests reach the
+// handlers. Requests that don't authenticate are left for the handlers to reject
+func cslIpAllowlistMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, request *http.Request) {
+		if !strings.HasPrefix(request.URL.Path, "/api/") || request.Method == "OPTIONS" {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		user, err := getMiddlewareUser(resp, request)
+		if err != nil || len(user.ActiveOrg.Id) == 0 {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		ctx := shuffle.GetContext(request)
+		ip := shuffle.GetRequestIp(request)
+		allowlist := getCslIpAllowlist(ctx, user.ActiveOrg.Id)
+		if !isIpAllowed(allowlist, ip) {
+			recordIpBlocked(ctx, user.ActiveOrg.Id, user, ip, request.URL.Path)
+			resp.WriteHeader(403)
+			resp.Write(createCslErrorResponse(errors.New("your IP is not allowed for this organization")))
+			return
+		}
+
+		next.ServeHTTP(resp, request)
+	})
+}
+
+/*
+Settings:
+Returns the IP allowlist of the current organization. Requires org admin.
+
+	{
+	    "success": true,
+	    "data": {
+	        "enabled": true,
+	        "entries": [
+	            {
+	                "cidr": "10.20.0.0/16",
+	                "description": "SOC network",
+	                "created_by": "admin",
+	                "created": 1712345678
+	            }
+	        ]
+	    }
+	}
+*/
+func cslGetIpAllowlist(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslIpAllowlist(ctx, user.ActiveOrg.Id),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetIpAllowlist")
+}
+
+/*
+Settings:
+Replaces the IP allowlist. Requires org admin. Entries take a single IP or a
+CIDR. The list can't be enabled unless it contains the IP of the request, so
+admins can't lock themselves out.
+
+	{
+	    "enabled": true,
+	    "entries": [
+	        {
+	            "cidr": "10.20.0.0/16",
+	            "description": "SOC network"
+	        }
+	    ]
+	}
+*/
+func cslSetIpAllowlist(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	newAllowlist := CslIpAllowlist{}
+	err = json.Unmarshal(body, &newAllowlist)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling IP allowlist: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if len(newAllowlist.Entries) > MaxIpAllowlistEntries {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("at most %d entries are allowed", MaxIpAllowlistEntries))))
+		return
+	}
+
+	previous := getCslIpAllowlist(ctx, user.ActiveOrg.Id)
+	now := time.Now().Unix()
+	entries := []CslIpAllowlistEntry{}
+	for _, entry := range newAllowlist.Entries {
+		cidr, err := normalizeCidr(entry.Cidr)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		entry.Cidr = cidr
+		entry.CreatedBy = user.Username
+		entry.Created = now
+
+		// Keep who added existing entries
+		for _, previousEntry := range previous.Entries {
+			if previousEntry.Cidr == cidr {
+				entry.CreatedBy = previousEntry.CreatedBy
+				entry.Created = previousEntry.Created
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	newAllowlist.Entries = entries
+
+	ip := shuffle.GetRequestIp(request)
+	if !isIpAllowed(newAllowlist, ip) {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("the allowlist must include your current IP %s", ip))))
+		return
+	}
+
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslIpAllowlistDocument, newAllowlist)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated IP allowlist for org %s. Enabled: %t, entries: %d", user.Username, user.Id, user.ActiveOrg.Id, newAllowlist.Enabled, len(newAllowlist.Entries))
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "ip_allowlist_updated", fmt.Sprintf("IP allowlist was updated with %d entries", len(newAllowlist.Entries)), user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    newAllowlist,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetIpAllowlist")
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslApiKeysDocument = "api_keys"
+
+// Named keys look like cslk_<org id>_<key id>_<secret>
+const ApiKeyPrefix = "cslk"
+
+// Last used is only stored once per interval to avoid a write on every request
+const ApiKeyLastUsedInterval = 60
+
+const DefaultRotationOverlapHours = 24
+const MaxRotationOverlapHours = 30 * 24
+
+// Api key statuses
+const (
+	ApiKeyStatusActive  = "active"
+	ApiKeyStatusExpired = "expired"
+	ApiKeyStatusRevoked = "revoked"
+)
+
+type CslApiKey struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	UserId     string `json:"user_id"`
+	Username   string `json:"username"`
+	Preview    string `json:"preview"`
+	SecretHash string `json:"secret_hash,omitempty"`
+	Created    int64  `json:"created"`
+	ExpiresAt  int64  `json:"expires_at"`
+	RevokedAt  int64  `json:"revoked_at"`
+	RotatedTo  string `json:"rotated_to,omitempty"`
+	LastUsed   int64  `json:"last_used"`
+	LastUsedIp string `json:"last_used_ip,omitempty"`
+	Status     string `json:"status,omitempty"`
+}
+
+type CslApiKeys struct {
+	Keys []CslApiKey `json:"keys"`
+}
+
+type CslApiKeyRequest struct {
+	Name          string `json:"name"`
+	ExpiresInDays int    `json:"expires_in_days"`
+}
+
+// Returned once when a key is created or rotated. The key can't be retrieved later
+type CslCreatedApiKey struct {
+	CslApiKey
+	Key string `json:"key"`
+}
+
+func getApiKeyStatus(apiKey CslApiKey, now int64) string {
+	if apiKey.RevokedAt > 0 {
+		return ApiKeyStatusRevoked
+	}
+
+	if apiKey.ExpiresAt > 0 && apiKey.ExpiresAt <= now {
+		return ApiKeyStatusExpired
+	}
+
+	return ApiKeyStatusActive
+}
+
+func hashApiKeySecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// Parses a named key into org id, key id and secret
+func parseApiKey(key string) (string, string, string, error) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 4 || parts[0] != ApiKeyPrefix {
+		return "", "", "", errors.New("not a named api key")
+	}
+
+	return parts[1], parts[2], parts[3], nil
+}
+
+// Creates a new key for a user. The secret is only returned here
+func newApiKey(name string, user shuffle.User, orgId string, expiresAt int64) (CslApiKey, string, error) {
+	data := make([]byte, 32)
+	_, err := rand.Read(data)
+	if err != nil {
+		return CslApiKey{}, "", err
+	}
+
+	secret := hex.EncodeToString(data)
+	apiKey := CslApiKey{
+		Id:         uuid.NewV4().String(),
+		Name:       name,
+		UserId:     user.Id,
+		Username:   user.Username,
+		Preview:    secret[:6],
+		SecretHash: hashApiKeySecret(secret),
+		Created:    time.Now().Unix(),
+		ExpiresAt:  expiresAt,
+	}
+
+	return apiKey, fmt.Sprintf("%s_%s_%s_%s", ApiKeyPrefix, orgId, apiKey.Id, secret), nil
+}
+
+// Keys are returned without the secret hash and with their current status
+func getPublicApiKey(apiKey CslApiKey, now int64) CslApiKey {
+	apiKey.SecretHash = ""
+	apiKey.Status = getApiKeyStatus(apiKey, now)
+	return apiKey
+}
+
+func findApiKeyIndex(apiKeys CslApiKeys, keyId string) int {
+	for i, apiKey := range apiKeys.Keys {
+		if apiKey.Id == keyId {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Users manage their own keys. Org admins can manage every key in the org
+func canManageApiKey(ctx context.Context, user shuffle.User, apiKey CslApiKey) bool {
+	if apiKey.UserId == user.Id {
+		return true
+	}
+
+	return checkUserOrgAdmin(ctx, user) == nil
+}
+
+// Validates a named key and returns the user it belongs to
+func authenticateApiKey(ctx context.Context, key, ip string) (*shuffle.User, string, error) {
+	orgId, keyId, secret, err := parseApiKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	apiKeys := CslApiKeys{}
+	_, err = getCslDocument(ctx, orgId, CslApiKeysDocument, &apiKeys)
+	if err != nil {
+		return nil, "", err
+	}
+
+	index := findApiKeyIndex(apiKeys, keyId)
+	if index < 0 {
+		return nil, "", errors.New("invalid api key")
+	}
+
+	apiKey := apiKeys.Keys[index]
+	if subtle.ConstantTimeCompare([]byte(hashApiKeySecret(secret)), []byte(apiKey.SecretHash)) != 1 {
+		return nil, "", errors.New("invalid api key")
+	}
+
+	now := time.Now().Unix()
+	status := getApiKeyStatus(apiKey, now)
+	if status != ApiKeyStatusActive {
+		return nil, "", errors.New(fmt.Sprintf("api key is %s", status))
+	}
+
+	user, err := shuffle.GetUser(ctx, apiKey.UserId)
+	if err != nil || !user.Active || len(user.ApiKey) == 0 {
+		return nil, "", errors.New("user of api key is not active")
+	}
+
+	if now-apiKey.LastUsed >= ApiKeyLastUsedInterval {
+		mfaStatus := "disabled"
+		if user.MFA.Active {
+			mfaStatus = "enabled"
+		} else if getCslMfaPolicy(ctx, orgId).Required {
+			mfaStatus = "disabled, but required by the org"
+		}
+
+		log.Printf("[AUDIT] Api key %s (%s) of user %s (%s) used from %s in org %s. 2FA is %s for the user", apiKey.Name, apiKey.Id, user.Username, user.Id, ip, orgId, mfaStatus)
+
+		updateCslDocument(ctx, orgId, CslApiKeysDocument, &apiKeys, func() error {
+			index := findApiKeyIndex(apiKeys, keyId)
+			if index >= 0 {
+				apiKeys.Keys[index].LastUsed = now
+				apiKeys.Keys[index].LastUsedIp = ip
+			}
+
+			return nil
+		})
+	}
+
+	return user, orgId, nil
+}
+
+// Endpoints that return or regenerate the users own api key, or create keys
+// that outlive the named key. Refused for named keys, as the own key doesn't
+// expire and works in every org of the user
+var namedApiKeyBlockedEndpoints = []string{
+	"GET /api/v1/getsettings",
+	"GET /api/v1/users/getsettings",
+	"GET /api/v1/generateapikey",
+	"POST /api/v1/generateapikey",
+	"GET /api/v1/users/generateapikey",
+	"POST /api/v1/users/generateapikey",
+	"POST /api/v1/csl/apiKeys",
+	"POST /api/v1/csl/apiKeys/rotate",
+}
+
+// Checks that the request can be made with a named key of the org. Other
+// org selectors than the org of the key are refused
+func checkNamedApiKeyRequest(request *http.Request, orgId string) error {
+	if shuffle.ArrayContains(namedApiKeyBlockedEndpoints, getUsageEndpoint(request)) {
+		return errors.New("this endpoint can't be used with a named api key")
+	}
+
+	for _, selected := range []string{request.Header.Get("Org-Id"), request.URL.Query().Get("org_id"), request.Header.Get("OrgId")} {
+		if len(selected) > 0 && selected != orgId {
+			return errors.New("named api keys can only be used in the org they were created in")
+		}
+	}
+
+	return nil
+}
+
+// Middleware accepting named api keys. A valid key is swapped for the users
+// own api key before the request reaches HandleApiAuthentication, so handlers
+// don't need to know about named keys. The request is pinned to the org of
+// the named key, and endpoints exposing the own key are refused
+func cslApiKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, request *http.Request) {
+		authorization := request.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, fmt.Sprintf("Bearer %s_", ApiKeyPrefix)) {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		ctx := shuffle.GetContext(request)
+		user, orgId, err := authenticateApiKey(ctx, strings.TrimPrefix(authorization, "Bearer "), shuffle.GetRequestIp(request))
+		if err != nil {
+			log.Printf("[WARNING] Named api key rejected for %s: %s", request.URL.Path, err)
+			resp.WriteHeader(401)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		err = checkNamedApiKeyRequest(request, orgId)
+		if err != nil {
+			log.Printf("[AUDIT] Refused named api key request of user %s (%s) to %s: %s", user.Username, user.Id, request.URL.Path, err)
+			resp.WriteHeader(403)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		// Lets usage tracking attribute the request to the named key
+		_, keyId, _, _ := parseApiKey(strings.TrimPrefix(authorization, "Bearer "))
+		request = request.WithContext(context.WithValue(request.Context(), cslApiKeyIdContextKey, keyId))
+
+		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", user.ApiKey))
+		request.Header.Set("Org-Id", orgId)
+		request.Header.Del("OrgId")
+		next.ServeHTTP(resp, request)
+	})
+}
+
+// Last used information for the usage endpoint
+func getOrgApiKeyUsage(ctx context.Context, orgId string) []CslApiKey {
+	apiKeys := CslApiKeys{}
+	_, err := getCslDocument(ctx, orgId, CslApiKeysDocument, &apiKeys)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting api keys for org %s: %s", orgId, err)
+	}
+
+	now := time.Now().Unix()
+	usage := []CslApiKey{}
+	for _, apiKey := range apiKeys.Keys {
+		usage = append(usage, getPublicApiKey(apiKey, now))
+	}
+
+	sort.SliceStable(usage, func(i, j int) bool {
+		return usage[i].LastUsed > usage[j].LastUsed
+	})
+
+	return usage
+}
+
+func parseApiKeyRequest(request *http.Request) (CslApiKeyRequest, error) {
+	keyRequest := CslApiKeyRequest{}
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return keyRequest, err
+	}
+
+	err = json.Unmarshal(body, &keyRequest)
+	if err != nil {
+		return keyRequest, err
+	}
+
+	keyRequest.Name = strings.TrimSpace(keyRequest.Name)
+	if len(keyRequest.Name) == 0 || len(keyRequest.Name) > 100 {
+		return keyRequest, errors.New("name must be between 1 and 100 characters")
+	}
+
+	if keyRequest.ExpiresInDays < 0 {
+		return keyRequest, errors.New("expires_in_days can't be negative")
+	}
+
+	return keyRequest, nil
+}
+
+/*
+Api keys:
+Returns the named api keys of the current user. Org admins can use ?all=true
+to list every key in the organization.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "id": "...",
+	            "name": "SIEM integration",
+	            "user_id": "...",
+	            "username": "analyst@example.com",
+	            "preview": "3f9a1c",
+	            "created": 1700000000,
+	            "expires_at": 1707776000,
+	            "revoked_at": 0,
+	            "last_used": 1700050000,
+	            "last_used_ip": "10.0.0.5",
+	            "status": "active"
+	        }
+	    ]
+	}
+*/
+func cslListApiKeys(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	all := request.URL.Query().Get("all") == "true"
+	if all && checkUserOrgAdmin(ctx, *user) != nil {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("user must be an admin of the organization")))
+		return
+	}
+
+	keys := []CslApiKey{}
+	for _, apiKey := range getOrgApiKeyUsage(ctx, user.ActiveOrg.Id) {
+		if all || apiKey.UserId == user.Id {
+			keys = append(keys, apiKey)
+		}
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    keys,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslListApiKeys")
+}
+
+/*
+Api keys:
+Creates a named api key for the current user. The key is only returned in this
+response. An expires_in_days of 0 creates a key that doesn't expire.
+
+	{
+	    "name": "SIEM integration",
+	    "expires_in_days": 90
+	}
+*/
+func cslCreateApiKey(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	keyRequest, err := parseApiKeyRequest(request)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	expiresAt := int64(0)
+	if keyRequest.ExpiresInDays > 0 {
+		expiresAt = time.Now().Unix() + int64(keyRequest.ExpiresInDays)*DaySeconds
+	}
+
+	apiKey, key, err := newApiKey(keyRequest.Name, *user, user.ActiveOrg.Id, expiresAt)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	apiKeys := CslApiKeys{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslApiKeysDocument, &apiKeys, func() error {
+		apiKeys.Keys = append(apiKeys.Keys, apiKey)
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) created api key %s (%s) in org %s", user.Username, user.Id, apiKey.Name, apiKey.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "api_key_created", fmt.Sprintf("Api key %s was created", apiKey.Name), user.Username, apiKey.Id)
+
+	res := CslResponse{
+		Success: true,
+		Data: CslCreatedApiKey{
+			CslApiKey: getPublicApiKey(apiKey, time.Now().Unix()),
+			Key:       key,
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslCreateApiKey")
+}
+
+/*
+Api keys:
+Rotates a key. Requires ?key_id=<id>. A new key with the same name and lifetime
+is returned, and the old key stays valid for ?overlap_hours=N (default 24) so
+clients can be updated without downtime.
+*/
+func cslRotateApiKey(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	overlapHours := DefaultRotationOverlapHours
+	if len(query.Get("overlap_hours")) > 0 {
+		parsed, err := strconv.Atoi(query.Get("overlap_hours"))
+		if err != nil || parsed < 0 || parsed > MaxRotationOverlapHours {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("overlap_hours must be between 0 and %d", MaxRotationOverlapHours))))
+			return
+		}
+
+		overlapHours = parsed
+	}
+
+	keyId := query.Get("key_id")
+	now := time.Now().Unix()
+
+	var newKey CslApiKey
+	var key string
+	apiKeys := CslApiKeys{}
+	err := updateCslDocument(ctx, user.ActiveOrg.Id, CslApiKeysDocument, &apiKeys, func() error {
+		index := findApiKeyIndex(apiKeys, keyId)
+		if index < 0 || !canManageApiKey(ctx, *user, apiKeys.Keys[index]) {
+			return errors.New("api key not found")
+		}
+
+		oldKey := apiKeys.Keys[index]
+		if getApiKeyStatus(oldKey, now) != ApiKeyStatusActive {
+			return errors.New("only active api keys can be rotated")
+		}
+
+		// The new key keeps the lifetime of the old one
+		expiresAt := int64(0)
+		if oldKey.ExpiresAt > 0 {
+			expiresAt = now + (oldKey.ExpiresAt - oldKey.Created)
+		}
+
+		owner := shuffle.User{Id: oldKey.UserId, Username: oldKey.Username}
+
+		var err error
+		newKey, key, err = newApiKey(oldKey.Name, owner, user.ActiveOrg.Id, expiresAt)
+		if err != nil {
+			return err
+		}
+
+		overlapEnd := now + int64(overlapHours)*60*60
+		if oldKey.ExpiresAt == 0 || overlapEnd < oldKey.ExpiresAt {
+			apiKeys.Keys[index].ExpiresAt = overlapEnd
+		}
+
+		apiKeys.Keys[index].RotatedTo = newKey.Id
+		apiKeys.Keys = append(apiKeys.Keys, newKey)
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) rotated api key %s to %s in org %s with %d hours overlap", user.Username, user.Id, keyId, newKey.Id, user.ActiveOrg.Id, overlapHours)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "api_key_rotated", fmt.Sprintf("Api key %s was rotated", newKey.Name), user.Username, newKey.Id)
+
+	res := CslResponse{
+		Success: true,
+		Data: CslCreatedApiKey{
+			CslApiKey: getPublicApiKey(newKey, now),
+			Key:       key,
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslRotateApiKey")
+}
+
+/*
+Api keys:
+Revokes a key immediately. Requires ?key_id=<id>.
+*/
+func cslRevokeApiKey(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	keyId := request.URL.Query().Get("key_id")
+
+	var revoked CslApiKey
+	apiKeys := CslApiKeys{}
+	err := updateCslDocument(ctx, user.ActiveOrg.Id, CslApiKeysDocument, &apiKeys, func() error {
+		index := findApiKeyIndex(apiKeys, keyId)
+		if index < 0 || !canManageApiKey(ctx, *user, apiKeys.Keys[index]) {
+			return errors.New("api key not found")
+		}
+
+		if apiKeys.Keys[index].RevokedAt == 0 {
+			apiKeys.Keys[index].RevokedAt = time.Now().Unix()
+		}
+
+		revoked = apiKeys.Keys[index]
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) revoked api key %s (%s) in org %s", user.Username, user.Id, revoked.Name, revoked.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "api_key_revoked", fmt.Sprintf("Api key %s was revoked", revoked.Name), user.Username, revoked.Id)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getPublicApiKey(revoked, time.Now().Unix()),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslRevokeApiKey")
+}
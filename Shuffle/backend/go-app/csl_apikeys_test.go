@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCheckNamedApiKeyRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		headers map[string]string
+		valid   bool
+	}{
+		{name: "workflows", method: "GET", url: "/api/v1/workflows", valid: true},
+		{name: "same org", method: "GET", url: "/api/v1/workflows?org_id=org", headers: map[string]string{"Org-Id": "org", "OrgId": "org"}, valid: true},
+		{name: "list keys", method: "GET", url: "/api/v1/csl/apiKeys", valid: true},
+		{name: "getsettings", method: "GET", url: "/api/v1/getsettings"},
+		{name: "generateapikey", method: "POST", url: "/api/v1/users/generateapikey"},
+		{name: "create key", method: "POST", url: "/api/v1/csl/apiKeys"},
+		{name: "other org header", method: "GET", url: "/api/v1/workflows", headers: map[string]string{"Org-Id": "other"}},
+		{name: "other org query", method: "GET", url: "/api/v1/workflows?org_id=other"},
+		{name: "other orgid header", method: "GET", url: "/api/v1/workflows", headers: map[string]string{"OrgId": "other"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var err error
+			router := mux.NewRouter()
+			router.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(resp http.ResponseWriter, request *http.Request) {
+					err = checkNamedApiKeyRequest(request, "org")
+				})
+			})
+
+			for _, path := range []string{"/api/v1/workflows", "/api/v1/getsettings", "/api/v1/users/generateapikey", "/api/v1/csl/apiKeys"} {
+				router.HandleFunc(path, func(resp http.ResponseWriter, request *http.Request) {}).Methods("GET", "POST")
+			}
+
+			request := httptest.NewRequest(test.method, test.url, nil)
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+
+			router.ServeHTTP(httptest.NewRecorder(), request)
+			if (err == nil) != test.valid {
+				t.Errorf("got error %v, expected valid %t", err, test.valid)
+			}
+		})
+	}
+}
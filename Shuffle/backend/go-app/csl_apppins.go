@@ -0,0 +1,829 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/gorilla/mux"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// App version pinning per workflow. Saving a workflow that uses another
+// version of a pinned app is rejected, so apps only change through an
+// explicit upgrade
+
+const CslAppPinsDocument = "app_pins"
+
+type CslAppPin struct {
+	AppName    string `json:"app_name"`
+	AppVersion string `json:"app_version"`
+	PinnedBy   string `json:"pinned_by"`
+	Pinned     int64  `json:"pinned"`
+}
+
+// Pins per workflow, keyed by the lowercased app name
+type CslAppPins struct {
+	Workflows map[string]map[string]CslAppPin `json:"workflows"`
+}
+
+type CslPinRequest struct {
+	AppName    string `json:"app_name"`
+	AppVersion string `json:"app_version"`
+}
+
+type CslWorkflowApp struct {
+	AppName           string     `json:"app_name"`
+	UsedVersions      []string   `json:"used_versions"`
+	Actions           int        `json:"actions"`
+	Pin               *CslAppPin `json:"pin"`
+	AvailableVersions []string   `json:"available_versions"`
+	LatestVersion     string     `json:"latest_version"`
+	UpdateAvailable   bool       `json:"update_available"`
+}
+
+// Parameter changes of one action between two app versions. LostValues are
+// removed parameters that have a value in the workflow
+type CslActionUpgrade struct {
+	ActionName        string   `json:"action_name"`
+	FromVersion       string   `json:"from_version"`
+	Nodes             []string `json:"nodes"`
+	ActionRemoved     bool     `json:"action_removed"`
+	ParametersAdded   []string `json:"parameters_added"`
+	RequiredAdded     []string `json:"required_added"`
+	ParametersRemoved []string `json:"parameters_removed"`
+	LostValues        []string `json:"lost_values"`
+	RequiredChanged   []string `json:"required_changed"`
+	OptionsChanged    []string `json:"options_changed"`
+}
+
+type CslAppUpgradeDiff struct {
+	AppName   string             `json:"app_name"`
+	ToVersion string             `json:"to_version"`
+	Breaking  bool               `json:"breaking"`
+	Actions   []CslActionUpgrade `json:"actions"`
+}
+
+func getCslAppPins(ctx context.Context, orgId string) CslAppPins {
+	pins := CslAppPins{}
+	_, err := getCslDocument(ctx, orgId, CslAppPinsDocument, &pins)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting app pins for org %s: %s", orgId, err)
+	}
+
+	if pins.Workflows == nil {
+		pins.Workflows = map[string]map[string]CslAppPin{}
+	}
+
+	return pins
+}
+
+// Sorts app versions oldest first. Versions that aren't semver are sorted
+// as strings before the others
+func sortAppVersions(versions []string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		first, firstErr := semver.NewVersion(versions[i])
+		second, secondErr := semver.NewVersion(versions[j])
+		if firstErr != nil || secondErr != nil {
+			if firstErr != nil && secondErr != nil {
+				return versions[i] < versions[j]
+			}
+
+			return firstErr != nil
+		}
+
+		return first.LessThan(second)
+	})
+}
+
+// Returns the app with every version of it that the user can use
+func findAppVersions(apps []shuffle.WorkflowApp, appName string) (shuffle.WorkflowApp, map[string]string, bool) {
+	for _, app := range apps {
+		if !strings.EqualFold(app.Name, appName) {
+			continue
+		}
+
+		versions := map[string]string{app.AppVersion: app.ID}
+		for _, version := range app.Versions {
+			versions[version.Version] = version.ID
+		}
+
+		return app, versions, true
+	}
+
+	return shuffle.WorkflowApp{}, map[string]string{}, false
+}
+
+func findAppAction(app *shuffle.WorkflowApp, actionName string) *shuffle.WorkflowAppAction {
+	for i := range app.Actions {
+		if app.Actions[i].Name == actionName {
+			return &app.Actions[i]
+		}
+	}
+
+	return nil
+}
+
+func getActionParameter(action *shuffle.WorkflowAppAction, name string) *shuffle.WorkflowAppActionParameter {
+	for i := range action.Parameters {
+		if action.Parameters[i].Name == name {
+			return &action.Parameters[i]
+		}
+	}
+
+	return nil
+}
+
+// Compares the actions the workflow uses of an app with the same actions in
+// the target version. The app definitions are loaded once per version
+func diffWorkflowAppUpgrade(ctx context.Context, user shuffle.User, workflow shuffle.Workflow, appName, toVersion string, versions map[string]string) (CslAppUpgradeDiff, *shuffle.WorkflowApp, error) {
+	diff := CslAppUpgradeDiff{
+		AppName:   appName,
+		ToVersion: toVersion,
+		Actions:   []CslActionUpgrade{},
+	}
+
+	target, err := shuffle.GetApp(ctx, versions[toVersion], user, false)
+	if err != nil {
+		return diff, nil, errors.New(fmt.Sprintf("failed loading version %s of %s", toVersion, appName))
+	}
+
+	definitions := map[string]*shuffle.WorkflowApp{}
+	upgrades := map[string]*CslActionUpgrade{}
+	keys := []string{}
+	for _, node := range workflow.Actions {
+		if !strings.EqualFold(node.AppName, appName) || node.AppVersion == toVersion {
+			continue
+		}
+
+		key := fmt.Sprintf("%s_%s", node.AppVersion, node.Name)
+		upgrade, ok := upgrades[key]
+		if ok {
+			upgrade.Nodes = append(upgrade.Nodes, node.ID)
+		} else {
+			upgrade = &CslActionUpgrade{
+				ActionName:        node.Name,
+				FromVersion:       node.AppVersion,
+				Nodes:             []string{node.ID},
+				ParametersAdded:   []string{},
+				RequiredAdded:     []string{},
+				ParametersRemoved: []string{},
+				LostValues:        []string{},
+				RequiredChanged:   []string{},
+				OptionsChanged:    []string{},
+			}
+
+			upgrades[key] = upgrade
+			keys = append(keys, key)
+		}
+
+		targetAction := findAppAction(target, node.Name)
+		if targetAction == nil {
+			upgrade.ActionRemoved = true
+			continue
+		}
+
+		// Parameters of the node are compared when the old version of the
+		// app isn't available anymore
+		source, ok := definitions[node.AppVersion]
+		if !ok {
+			source, err = shuffle.GetApp(ctx, versions[node.AppVersion], user, false)
+			if err != nil {
+				source = nil
+			}
+
+			definitions[node.AppVersion] = source
+		}
+
+		sourceAction := &shuffle.WorkflowAppAction{Parameters: node.Parameters}
+		if source != nil {
+			if found := findAppAction(source, node.Name); found != nil {
+				sourceAction = found
+			}
+		}
+
+		for _, param := range targetAction.Parameters {
+			previous := getActionParameter(sourceAction, param.Name)
+			if previous == nil {
+				if !shuffle.ArrayContains(upgrade.ParametersAdded, param.Name) {
+					upgrade.ParametersAdded = append(upgrade.ParametersAdded, param.Name)
+					if param.Required {
+						upgrade.RequiredAdded = append(upgrade.RequiredAdded, param.Name)
+					}
+				}
+
+				continue
+			}
+
+			if previous.Required != param.Required && !shuffle.ArrayContains(upgrade.RequiredChanged, param.Name) {
+				upgrade.RequiredChanged = append(upgrade.RequiredChanged, param.Name)
+			}
+
+			if strings.Join(previous.Options, ",") != strings.Join(param.Options, ",") && !shuffle.ArrayContains(upgrade.OptionsChanged, param.Name) {
+				upgrade.OptionsChanged = append(upgrade.OptionsChanged, param.Name)
+			}
+		}
+
+		for _, param := range sourceAction.Parameters {
+			if getActionParameter(targetAction, param.Name) != nil {
+				continue
+			}
+
+			if !shuffle.ArrayContains(upgrade.ParametersRemoved, param.Name) {
+				upgrade.ParametersRemoved = append(upgrade.ParametersRemoved, param.Name)
+			}
+
+			nodeAction := &shuffle.WorkflowAppAction{Parameters: node.Parameters}
+			if nodeParam := getActionParameter(nodeAction, param.Name); nodeParam != nil && len(nodeParam.Value) > 0 && !shuffle.ArrayContains(upgrade.LostValues, param.Name) {
+				upgrade.LostValues = append(upgrade.LostValues, param.Name)
+			}
+		}
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		upgrade := upgrades[key]
+		if upgrade.ActionRemoved || len(upgrade.RequiredAdded) > 0 || len(upgrade.LostValues) > 0 {
+			diff.Breaking = true
+		}
+
+		diff.Actions = append(diff.Actions, *upgrade)
+	}
+
+	return diff, target, nil
+}
+
+// Moves every node of the app to the target version. Values of parameters
+// that still exist are kept, new parameters get their default values
+func upgradeWorkflowApp(workflow *shuffle.Workflow, appName string, target *shuffle.WorkflowApp) int {
+	upgraded := 0
+	for i, node := range workflow.Actions {
+		if !strings.EqualFold(node.AppName, appName) || node.AppVersion == target.AppVersion {
+			continue
+		}
+
+		targetAction := findAppAction(target, node.Name)
+		if targetAction == nil {
+			continue
+		}
+
+		nodeAction := &shuffle.WorkflowAppAction{Parameters: node.Parameters}
+		parameters := []shuffle.WorkflowAppActionParameter{}
+		for _, param := range targetAction.Parameters {
+			if previous := getActionParameter(nodeAction, param.Name); previous != nil {
+				param.Value = previous.Value
+				param.Variant = previous.Variant
+				param.ActionField = previous.ActionField
+			}
+
+			parameters = append(parameters, param)
+		}
+
+		workflow.Actions[i].Parameters = parameters
+		workflow.Actions[i].AppVersion = target.AppVersion
+		workflow.Actions[i].AppID = target.ID
+		upgraded += 1
+	}
+
+	return upgraded
+}
+
+// Returns the nodes using another version of a pinned app
+func findPinConflicts(workflow shuffle.Workflow, pins map[string]CslAppPin) []string {
+	conflicts := []string{}
+	for _, node := range workflow.Actions {
+		pin, ok := pins[strings.ToLower(node.AppName)]
+		if !ok || node.AppVersion == pin.AppVersion {
+			continue
+		}
+
+		label := node.Label
+		if len(label) == 0 {
+			label = node.ID
+		}
+
+		conflicts = append(conflicts, fmt.Sprintf("%s uses %s %s, but the workflow is pinned to %s", label, node.AppName, node.AppVersion, pin.AppVersion))
+	}
+
+	return conflicts
+}
+
+// Wraps the workflow save handler to reject saves that change the version
+// of a pinned app
+func cslAppPinsOnSave(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		if request.Method != "PUT" {
+			handler(resp, request)
+			return
+		}
+
+		ctx := shuffle.GetContext(request)
+		existing, err := shuffle.GetWorkflow(ctx, mux.Vars(request)["key"])
+		if err != nil || len(existing.OrgId) == 0 {
+			handler(resp, request)
+			return
+		}
+
+		pins := getCslAppPins(ctx, existing.OrgId).Workflows[existing.ID]
+		if len(pins) == 0 {
+			handler(resp, request)
+			return
+		}
+
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		workflow := shuffle.Workflow{}
+		if json.Unmarshal(body, &workflow) == nil {
+			conflicts := findPinConflicts(workflow, pins)
+			if len(conflicts) > 0 {
+				log.Printf("[WARNING] Rejected save of workflow %s with pinned app versions changed: %s", existing.ID, strings.Join(conflicts, ", "))
+				resp.WriteHeader(409)
+				resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("pinned app versions can only be changed with an upgrade: %s", strings.Join(conflicts, ", ")))))
+				return
+			}
+		}
+
+		handler(resp, request)
+	}
+}
+
+/*
+App versions:
+Returns the apps used in ?workflow_id=<id> with the versions the workflow
+uses, the pinned version and the versions that are available.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "app_name": "VirusTotal",
+	            "used_versions": ["1.0.0"],
+	            "actions": 3,
+	            "pin": {
+	                "app_name": "VirusTotal",
+	                "app_version": "1.0.0",
+	                "pinned_by": "admin@example.com",
+	                "pinned": 1700000000
+	            },
+	            "available_versions": ["1.0.0", "1.1.0"],
+	            "latest_version": "1.1.0",
+	            "update_available": true
+	        }
+	    ]
+	}
+*/
+func cslWorkflowApps(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	workflow, err := getCslWorkflow(ctx, *user, request.URL.Query().Get("workflow_id"))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	apps, err := shuffle.GetPrioritizedApps(ctx, *user)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting apps for workflow %s: %s", workflow.ID, err)
+	}
+
+	pins := getCslAppPins(ctx, user.ActiveOrg.Id).Workflows[workflow.ID]
+	workflowApps := map[string]*CslWorkflowApp{}
+	names := []string{}
+	for _, node := range workflow.Actions {
+		key := strings.ToLower(node.AppName)
+		workflowApp, ok := workflowApps[key]
+		if !ok {
+			workflowApp = &CslWorkflowApp{
+				AppName:           node.AppName,
+				UsedVersions:      []string{},
+				AvailableVersions: []string{},
+			}
+
+			if pin, ok := pins[key]; ok {
+				workflowApp.Pin = &pin
+			}
+
+			_, versions, _ := findAppVersions(apps, node.AppName)
+			for version := range versions {
+				workflowApp.AvailableVersions = append(workflowApp.AvailableVersions, version)
+			}
+
+			sortAppVersions(workflowApp.AvailableVersions)
+			if len(workflowApp.AvailableVersions) > 0 {
+				workflowApp.LatestVersion = workflowApp.AvailableVersions[len(workflowApp.AvailableVersions)-1]
+			}
+
+			workflowApps[key] = workflowApp
+			names = append(names, key)
+		}
+
+		workflowApp.Actions += 1
+		if !shuffle.ArrayContains(workflowApp.UsedVersions, node.AppVersion) {
+			workflowApp.UsedVersions = append(workflowApp.UsedVersions, node.AppVersion)
+		}
+	}
+
+	sort.Strings(names)
+	result := []CslWorkflowApp{}
+	for _, name := range names {
+		workflowApp := workflowApps[name]
+		sortAppVersions(workflowApp.UsedVersions)
+		if len(workflowApp.LatestVersion) > 0 && len(workflowApp.UsedVersions) > 0 {
+			workflowApp.UpdateAvailable = workflowApp.UsedVersions[0] != workflowApp.LatestVersion
+		}
+
+		result = append(result, *workflowApp)
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    result,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslWorkflowApps")
+}
+
+/*
+App versions:
+Pins an app in ?workflow_id=<id> to a version. Without app_version the
+version the workflow uses is pinned, which requires every action of the app
+to use the same version. Saving the workflow with another version of the app
+is rejected until it's unpinned or upgraded.
+
+	{
+	    "app_name": "VirusTotal",
+	    "app_version": "1.0.0"
+	}
+*/
+func cslPinWorkflowApp(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if user.Role == "org-reader" {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("read only user")))
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	workflow, err := getCslWorkflow(ctx, *user, request.URL.Query().Get("workflow_id"))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	pinRequest := CslPinRequest{}
+	err = json.Unmarshal(body, &pinRequest)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	usedVersions := []string{}
+	appName := ""
+	for _, node := range workflow.Actions {
+		if strings.EqualFold(node.AppName, pinRequest.AppName) {
+			appName = node.AppName
+			if !shuffle.ArrayContains(usedVersions, node.AppVersion) {
+				usedVersions = append(usedVersions, node.AppVersion)
+			}
+		}
+	}
+
+	if len(appName) == 0 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("the workflow doesn't use %s", pinRequest.AppName))))
+		return
+	}
+
+	if len(pinRequest.AppVersion) == 0 {
+		if len(usedVersions) != 1 {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("the workflow uses versions %s of %s. Set app_version or upgrade first", strings.Join(usedVersions, ", "), appName))))
+			return
+		}
+
+		pinRequest.AppVersion = usedVersions[0]
+	}
+
+	if len(usedVersions) != 1 || usedVersions[0] != pinRequest.AppVersion {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("the workflow has to use version %s of %s before it's pinned. Upgrade it first", pinRequest.AppVersion, appName))))
+		return
+	}
+
+	pin := CslAppPin{
+		AppName:    appName,
+		AppVersion: pinRequest.AppVersion,
+		PinnedBy:   user.Username,
+		Pinned:     time.Now().Unix(),
+	}
+
+	pins := CslAppPins{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslAppPinsDocument, &pins, func() error {
+		if pins.Workflows == nil {
+			pins.Workflows = map[string]map[string]CslAppPin{}
+		}
+
+		if pins.Workflows[workflow.ID] == nil {
+			pins.Workflows[workflow.ID] = map[string]CslAppPin{}
+		}
+
+		pins.Workflows[workflow.ID][strings.ToLower(appName)] = pin
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) pinned %s to version %s in workflow %s", user.Username, user.Id, appName, pin.AppVersion, workflow.ID)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeWorkflow, "app_pinned", fmt.Sprintf("%s was pinned to version %s in %s", appName, pin.AppVersion, workflow.Name), user.Username, workflow.ID)
+
+	res := CslResponse{
+		Success: true,
+		Data:    pin,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslPinWorkflowApp")
+}
+
+/*
+App versions:
+Removes the pin of ?app_name=<name> in ?workflow_id=<id>.
+*/
+func cslUnpinWorkflowApp(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if user.Role == "org-reader" {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("read only user")))
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	workflow, err := getCslWorkflow(ctx, *user, request.URL.Query().Get("workflow_id"))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	appName := request.URL.Query().Get("app_name")
+	pins := CslAppPins{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslAppPinsDocument, &pins, func() error {
+		if _, ok := pins.Workflows[workflow.ID][strings.ToLower(appName)]; !ok {
+			return errors.New(fmt.Sprintf("%s isn't pinned in the workflow", appName))
+		}
+
+		delete(pins.Workflows[workflow.ID], strings.ToLower(appName))
+		if len(pins.Workflows[workflow.ID]) == 0 {
+			delete(pins.Workflows, workflow.ID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) unpinned %s in workflow %s", user.Username, user.Id, appName, workflow.ID)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeWorkflow, "app_unpinned", fmt.Sprintf("%s was unpinned in %s", appName, workflow.Name), user.Username, workflow.ID)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslUnpinWorkflowApp")
+}
+
+// Loads what's needed to diff or upgrade ?app_name=<name> in ?workflow_id=<id>
+// to ?version=<version>, defaulting to the latest version
+func getWorkflowAppUpgrade(ctx context.Context, user shuffle.User, request *http.Request) (*shuffle.Workflow, CslAppUpgradeDiff, *shuffle.WorkflowApp, error) {
+	query := request.URL.Query()
+	workflow, err := getCslWorkflow(ctx, user, query.Get("workflow_id"))
+	if err != nil {
+		return nil, CslAppUpgradeDiff{}, nil, err
+	}
+
+	apps, err := shuffle.GetPrioritizedApps(ctx, user)
+	if err != nil {
+		return nil, CslAppUpgradeDiff{}, nil, err
+	}
+
+	app, versions, found := findAppVersions(apps, query.Get("app_name"))
+	if !found {
+		return nil, CslAppUpgradeDiff{}, nil, errors.New(fmt.Sprintf("app %s not found", query.Get("app_name")))
+	}
+
+	toVersion := query.Get("version")
+	if len(toVersion) == 0 || toVersion == "latest" {
+		available := []string{}
+		for version := range versions {
+			available = append(available, version)
+		}
+
+		sortAppVersions(available)
+		toVersion = available[len(available)-1]
+	}
+
+	if _, ok := versions[toVersion]; !ok {
+		return nil, CslAppUpgradeDiff{}, nil, errors.New(fmt.Sprintf("version %s of %s isn't available", toVersion, app.Name))
+	}
+
+	diff, target, err := diffWorkflowAppUpgrade(ctx, user, *workflow, app.Name, toVersion, versions)
+	return workflow, diff, target, err
+}
+
+/*
+App versions:
+Returns the parameter changes of upgrading ?app_name=<name> in
+?workflow_id=<id> to ?version=<version>, or the latest version. Changes are
+grouped per action and version the workflow uses. The upgrade is breaking if
+an action was removed, a required parameter was added or a parameter with a
+value in the workflow was removed.
+
+	{
+	    "success": true,
+	    "data": {
+	        "app_name": "VirusTotal",
+	        "to_version": "1.1.0",
+	        "breaking": true,
+	        "actions": [
+	            {
+	                "action_name": "get_hash_report",
+	                "from_version": "1.0.0",
+	                "nodes": ["<action id>"],
+	                "action_removed": false,
+	                "parameters_added": ["relationships"],
+	                "required_added": [],
+	                "parameters_removed": ["allinfo"],
+	                "lost_values": ["allinfo"],
+	                "required_changed": [],
+	                "options_changed": []
+	            }
+	        ]
+	    }
+	}
+*/
+func cslWorkflowAppDiff(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	_, diff, _, err := getWorkflowAppUpgrade(ctx, *user, request)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    diff,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslWorkflowAppDiff")
+}
+
+/*
+App versions:
+Upgrades ?app_name=<name> in ?workflow_id=<id> to ?version=<version>, or the
+latest version, and moves the pin if the app is pinned. Parameter values are
+kept for parameters that still exist. Breaking upgrades require ?force=true,
+and upgrades that remove actions the workflow uses are rejected. The current
+workflow is stored as a revision first, so it can be rolled back. Returns
+the diff of the upgrade.
+*/
+func cslUpgradeWorkflowApp(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if user.Role == "org-reader" {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("read only user")))
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	workflow, diff, target, err := getWorkflowAppUpgrade(ctx, *user, request)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	for _, action := range diff.Actions {
+		if action.ActionRemoved {
+			resp.WriteHeader(409)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("version %s of %s has no action %s", diff.ToVersion, diff.AppName, action.ActionName))))
+			return
+		}
+	}
+
+	if diff.Breaking && request.URL.Query().Get("force") != "true" {
+		resp.WriteHeader(409)
+		resp.Write(createCslErrorResponse(errors.New("the upgrade has breaking changes. Check the diff and retry with force=true")))
+		return
+	}
+
+	if len(diff.Actions) == 0 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("the workflow already uses version %s of %s", diff.ToVersion, diff.AppName))))
+		return
+	}
+
+	err = shuffle.SetWorkflowRevision(ctx, *workflow)
+	if err != nil {
+		log.Printf("[ERROR] Failed storing current version of workflow %s before app upgrade: %s", workflow.ID, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	upgraded := upgradeWorkflowApp(workflow, diff.AppName, target)
+	workflow.UpdatedBy = user.Username
+	workflow.Edited = time.Now().Unix()
+
+	err = shuffle.SetWorkflow(ctx, *workflow, workflow.ID)
+	if err != nil {
+		log.Printf("[ERROR] Failed upgrading %s in workflow %s: %s", diff.AppName, workflow.ID, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	pins := CslAppPins{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslAppPinsDocument, &pins, func() error {
+		pin, ok := pins.Workflows[workflow.ID][strings.ToLower(diff.AppName)]
+		if !ok {
+			return nil
+		}
+
+		pin.AppVersion = diff.ToVersion
+		pin.PinnedBy = user.Username
+		pin.Pinned = time.Now().Unix()
+		pins.Workflows[workflow.ID][strings.ToLower(diff.AppName)] = pin
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed moving pin of %s in workflow %s: %s", diff.AppName, workflow.ID, err)
+	}
+
+	log.Printf("[AUDIT] User %s (%s) upgraded %d actions of %s to version %s in workflow %s", user.Username, user.Id, upgraded, diff.AppName, diff.ToVersion, workflow.ID)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeWorkflow, "app_upgraded", fmt.Sprintf("%s was upgraded to version %s in %s", diff.AppName, diff.ToVersion, workflow.Name), user.Username, workflow.ID)
+
+	res := CslResponse{
+		Success: true,
+		Data:    diff,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslUpgradeWorkflowApp")
+}
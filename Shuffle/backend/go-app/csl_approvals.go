@@ -0,0 +1,835 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Human-in-the-loop approvals. Workflows pause at User Input nodes, and the
+// approvals job picks up executions waiting at one as pending approvals and
+// notifies the org. Approvers answer them with the approve and reject APIs,
+// which continue or abort the execution the same way the User Input link
+// does.
+//
+// Approval policies decide who can answer an approval node. Without a policy
+// anyone who can run the workflow can, including through the link. With one,
+// only org admins and the roles and users of the policy can, and answers
+// through the link are refused. Policies can escalate approvals that aren't
+// answered in time to other stages, and approve or reject them when the last
+// stage times out (csl_escalations.go).
+
+const CslApprovalsDocument = "approvals"
+const CslApprovalPoliciesDocument = "approval_policies"
+
+const (
+	ApprovalPending   = "pending"
+	ApprovalApproved  = "approved"
+	ApprovalRejected  = "rejected"
+	ApprovalCancelled = "cancelled"
+)
+
+const ApprovalsJobMinutes = 1
+const ApprovalExecutionsPerWorkflow = 50
+const MaxApprovalPolicies = 200
+
+// Oldest approvals are dropped past these
+const MaxPendingApprovals = 1000
+const MaxRecentApprovals = 100
+
+type CslApprovalPolicy struct {
+	WorkflowId string `json:"workflow_id"`
+
+	// Every approval node of the workflow if empty
+	NodeId string `json:"node_id"`
+
+	// Org roles or custom roles (csl_roles.go), and user ids, that can
+	// answer the approval
+	Roles     []string `json:"roles"`
+	Approvers []string `json:"approvers"`
+
+	// Minutes the first stage has to answer. Never times out if 0
+	TimeoutMinutes int `json:"timeout_minutes"`
+
+	// Stages the approval escalates to, in order
+	Escalations []CslApprovalStage `json:"escalations"`
+
+	// approve or reject when the last stage times out
+	OnTimeout string `json:"on_timeout"`
+}
+
+type CslApprovalStage struct {
+	Roles          []string `json:"roles"`
+	Approvers      []string `json:"approvers"`
+	TimeoutMinutes int      `json:"timeout_minutes"`
+}
+
+type CslApprovalPolicies struct {
+	Policies  []CslApprovalPolicy `json:"policies"`
+	UpdatedBy string              `json:"updated_by"`
+	Updated   int64               `json:"updated"`
+}
+
+type CslApproval struct {
+	Id           string `json:"id"`
+	ExecutionId  string `json:"execution_id"`
+	WorkflowId   string `json:"workflow_id"`
+	WorkflowName string `json:"workflow_name"`
+	NodeId       string `json:"node_id"`
+	Label        string `json:"label"`
+	Status       string `json:"status"`
+	RequestedAt  int64  `json:"requested_at"`
+
+	// Who can answer in the current stage
+	Roles     []string `json:"roles"`
+	Approvers []string `json:"approvers"`
+
+	// Stages of the policy the approval was requested with
+	Stages         []CslApprovalStage `json:"stages"`
+	Stage          int                `json:"stage"`
+	StageStartedAt int64              `json:"stage_started_at"`
+	OnTimeout      string             `json:"on_timeout,omitempty"`
+
+	AnsweredBy string `json:"answered_by,omitempty"`
+	AnsweredAt int64  `json:"answered_at,omitempty"`
+	Note       string `json:"note,omitempty"`
+}
+
+type CslApprovals struct {
+	Pending     []CslApproval           `json:"pending"`
+	Recent      []CslApproval           `json:"recent"`
+	Escalations []CslApprovalEscalation `json:"escalations"`
+}
+
+type CslApprovalAnswer struct {
+	ApprovalId string `json:"approval_id"`
+	Note       string `json:"note"`
+}
+
+type cslApprovalAnswerKey struct{}
+
+func getCslApprovalPolicies(ctx context.Context, orgId string) CslApprovalPolicies {
+	policies := CslApprovalPolicies{}
+	_, err := getCslDocument(ctx, orgId, CslApprovalPoliciesDocument, &policies)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting approval policies for org %s: %s", orgId, err)
+	}
+
+	if policies.Policies == nil {
+		policies.Policies = []CslApprovalPolicy{}
+	}
+
+	return policies
+}
+
+func getCslApprovals(ctx context.Context, orgId string) CslApprovals {
+	approvals := CslApprovals{}
+	_, err := getCslDocument(ctx, orgId, CslApprovalsDocument, &approvals)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting approvals for org %s: %s", orgId, err)
+	}
+
+	setCslApprovalsDefaults(&approvals)
+	return approvals
+}
+
+func setCslApprovalsDefaults(approvals *CslApprovals) {
+	if approvals.Pending == nil {
+		approvals.Pending = []CslApproval{}
+	}
+
+	if approvals.Recent == nil {
+		approvals.Recent = []CslApproval{}
+	}
+
+	if approvals.Escalations == nil {
+		approvals.Escalations = []CslApprovalEscalation{}
+	}
+}
+
+// Returns the policy of an approval node. Node policies come before the
+// policy of the whole workflow
+func (policies CslApprovalPolicies) getPolicy(workflowId, nodeId string) (CslApprovalPolicy, bool) {
+	found := false
+	workflowPolicy := CslApprovalPolicy{}
+	for _, policy := range policies.Policies {
+		if policy.WorkflowId != workflowId {
+			continue
+		}
+
+		if policy.NodeId == nodeId {
+			return policy, true
+		}
+
+		if len(policy.NodeId) == 0 {
+			workflowPolicy = policy
+			found = true
+		}
+	}
+
+	return workflowPolicy, found
+}
+
+func validateCslApprovalPolicies(ctx context.Context, orgId string, policies []CslApprovalPolicy) error {
+	if len(policies) > MaxApprovalPolicies {
+		return errors.New(fmt.Sprintf("an org can have at most %d approval policies", MaxApprovalPolicies))
+	}
+
+	roles := getCslRoles(ctx, orgId)
+	seen := map[string]bool{}
+	for _, policy := range policies {
+		if len(policy.WorkflowId) == 0 {
+			return errors.New("workflow_id is required")
+		}
+
+		key := policy.WorkflowId + "_" + policy.NodeId
+		if seen[key] {
+			return errors.New(fmt.Sprintf("workflow %s has more than one policy for node '%s'", policy.WorkflowId, policy.NodeId))
+		}
+
+		seen[key] = true
+		if len(policy.Roles) == 0 && len(policy.Approvers) == 0 && policy.TimeoutMinutes <= 0 {
+			return errors.New(fmt.Sprintf("the policy of workflow %s needs roles, approvers or a timeout", policy.WorkflowId))
+		}
+
+		for _, stage := range getCslApprovalStages(policy) {
+			for _, role := range stage.Roles {
+				if _, ok := roles.getRole(role); !ok && !shuffle.ArrayContains(ssoRoles, role) {
+					return errors.New(fmt.Sprintf("unknown role %s", role))
+				}
+			}
+		}
+
+		err := validateCslApprovalEscalations(policy)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the org role and custom role of the user
+func getCslApproverRoles(ctx context.Context, org *shuffle.Org, user shuffle.User) []string {
+	roles := []string{}
+	for _, orgUser := range org.Users {
+		if orgUser.Id == user.Id {
+			roles = append(roles, orgUser.Role)
+			break
+		}
+	}
+
+	if role, ok := getCslRoles(ctx, org.Id).Assignments[user.Id]; ok {
+		roles = append(roles, role)
+	}
+
+	return roles
+}
+
+// Whether the user with the roles from getCslApproverRoles can answer an
+// approval. Org admins always can
+func canAnswerCslApproval(org *shuffle.Org, user shuffle.User, roles []string, approval CslApproval) bool {
+	if shuffle.ArrayContains(roles, "admin") || user.SupportAccess {
+		return true
+	}
+
+	if len(approval.Roles) == 0 && len(approval.Approvers) == 0 {
+		return !shuffle.ArrayContains(roles, "org-reader") && shuffle.GetWorkflowAccess(org, user, approval.WorkflowId).Execute
+	}
+
+	if shuffle.ArrayContains(approval.Approvers, user.Id) {
+		return true
+	}
+
+	for _, role := range roles {
+		if shuffle.ArrayContains(approval.Roles, role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Adds executions waiting at an approval node as pending approvals, and
+// cancels pending approvals whose execution stopped waiting
+func syncCslApprovals(ctx context.Context, orgId string) error {
+	workflows, err := getOrgWorkflows(ctx, orgId)
+	if err != nil {
+		return err
+	}
+
+	approvalWorkflows := []shuffle.Workflow{}
+	for _, workflow := range workflows {
+		for _, trigger := range workflow.Triggers {
+			if trigger.TriggerType == "USERINPUT" {
+				approvalWorkflows = append(approvalWorkflows, workflow)
+				break
+			}
+		}
+	}
+
+	approvals := getCslApprovals(ctx, orgId)
+	if len(approvalWorkflows) == 0 && len(approvals.Pending) == 0 {
+		return nil
+	}
+
+	workflowExecutions := make([][]shuffle.WorkflowExecution, len(approvalWorkflows))
+	runConcurrentLookups(ctx, len(approvalWorkflows), func(ctx context.Context, index int) error {
+		executions, err := shuffle.GetAllWorkflowExecutions(ctx, approvalWorkflows[index].ID, ApprovalExecutionsPerWorkflow)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting executions for workflow %s in approvals job: %s", approvalWorkflows[index].ID, err)
+			return nil
+		}
+
+		workflowExecutions[index] = executions
+		return nil
+	})
+
+	waiting := map[string]CslApproval{}
+	for i, workflow := range approvalWorkflows {
+		for _, execution := range workflowExecutions[i] {
+			if execution.Status != "WAITING" {
+				continue
+			}
+
+			for _, result := range execution.Results {
+				if result.Status != "WAITING" || result.Action.AppName != "User Input" {
+					continue
+				}
+
+				waiting[execution.ExecutionId+"_"+result.Action.ID] = CslApproval{
+					ExecutionId:  execution.ExecutionId,
+					WorkflowId:   workflow.ID,
+					WorkflowName: workflow.Name,
+					NodeId:       result.Action.ID,
+					Label:        result.Action.Label,
+					RequestedAt:  normalizeTimestamp(result.StartedAt),
+				}
+			}
+		}
+	}
+
+	// Executions of other workflows weren't looked up, and old executions
+	// may be past the ones that were
+	done := map[string]bool{}
+	for _, approval := range approvals.Pending {
+		key := approval.ExecutionId + "_" + approval.NodeId
+		if _, ok := waiting[key]; !ok && isCslApprovalExecutionDone(ctx, approval.ExecutionId) {
+			done[key] = true
+		}
+	}
+
+	policies := getCslApprovalPolicies(ctx, orgId)
+	requested := []CslApproval{}
+	cancelled := []CslApproval{}
+	err = updateCslDocument(ctx, orgId, CslApprovalsDocument, &approvals, func() error {
+		setCslApprovalsDefaults(&approvals)
+		known := map[string]bool{}
+		for _, approval := range approvals.Recent {
+			known[approval.ExecutionId+"_"+approval.NodeId] = true
+		}
+
+		pending := []CslApproval{}
+		for _, approval := range approvals.Pending {
+			key := approval.ExecutionId + "_" + approval.NodeId
+			known[key] = true
+			if done[key] {
+				approval.Status = ApprovalCancelled
+				approval.AnsweredAt = time.Now().Unix()
+				approvals.Recent = append(approvals.Recent, approval)
+				cancelled = append(cancelled, approval)
+				continue
+			}
+
+			pending = append(pending, approval)
+		}
+
+		for key, approval := range waiting {
+			if known[key] {
+				continue
+			}
+
+			policy, _ := policies.getPolicy(approval.WorkflowId, approval.NodeId)
+			approval.Id = uuid.NewV4().String()
+			approval.Status = ApprovalPending
+			approval.Roles = policy.Roles
+			approval.Approvers = policy.Approvers
+			approval.Stages = getCslApprovalStages(policy)
+			approval.OnTimeout = policy.OnTimeout
+			if approval.RequestedAt == 0 {
+				approval.RequestedAt = time.Now().Unix()
+			}
+
+			approval.StageStartedAt = approval.RequestedAt
+
+			pending = append(pending, approval)
+			requested = append(requested, approval)
+		}
+
+		if len(pending) > MaxPendingApprovals {
+			pending = pending[len(pending)-MaxPendingApprovals:]
+		}
+
+		if len(approvals.Recent) > MaxRecentApprovals {
+			approvals.Recent = approvals.Recent[len(approvals.Recent)-MaxRecentApprovals:]
+		}
+
+		approvals.Pending = pending
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, approval := range requested {
+		log.Printf("[AUDIT] Approval %s (%s) was requested for execution %s of workflow %s in org %s", approval.Id, approval.Label, approval.ExecutionId, approval.WorkflowId, orgId)
+		recordCslActivity(ctx, orgId, ActivityTypeWorkflow, "approval_requested", fmt.Sprintf("Approval %s of %s was requested", approval.Label, approval.WorkflowName), "", approval.ExecutionId)
+		notifyCslEvent(ctx, orgId, EventApprovalRequested, approval)
+	}
+
+	for _, approval := range cancelled {
+		log.Printf("[AUDIT] Approval %s (%s) of execution %s in org %s was cancelled, as the execution stopped waiting", approval.Id, approval.Label, approval.ExecutionId, orgId)
+	}
+
+	return nil
+}
+
+// Executions that can't be found are treated as done, so their approvals
+// don't stay pending for good
+func isCslApprovalExecutionDone(ctx context.Context, executionId string) bool {
+	execution, err := shuffle.GetWorkflowExecution(ctx, executionId)
+	if err != nil {
+		return true
+	}
+
+	return execution.Status != "WAITING"
+}
+
+func runCslApprovalsJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for approvals job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		err = syncCslApprovals(ctx, org.Id)
+		if err != nil {
+			log.Printf("[WARNING] Failed syncing approvals for org %s: %s", org.Id, err)
+		}
+
+		escalateCslApprovals(ctx, org.Id)
+	}
+}
+
+// Continues or aborts the execution of an approval through the User Input
+// continuation of the execute handler
+func runCslApprovalAnswer(ctx context.Context, approval CslApproval, approved bool, note string) error {
+	execution, err := shuffle.GetWorkflowExecution(ctx, approval.ExecutionId)
+	if err != nil {
+		return errors.New(fmt.Sprintf("execution %s not found", approval.ExecutionId))
+	}
+
+	if execution.Status != "WAITING" {
+		return errors.New(fmt.Sprintf("execution %s is no longer waiting for approval", approval.ExecutionId))
+	}
+
+	query := url.Values{}
+	query.Set("reference_execution", execution.ExecutionId)
+	query.Set("authorization", execution.Authorization)
+	query.Set("start", approval.NodeId)
+	query.Set("answer", fmt.Sprintf("%t", approved))
+	if len(note) > 0 {
+		query.Set("note", note)
+	}
+
+	request := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/workflows/%s/execute?%s", approval.WorkflowId, query.Encode()), nil)
+	request = request.WithContext(context.WithValue(ctx, cslApprovalAnswerKey{}, true))
+
+	recorder := httptest.NewRecorder()
+	executeWorkflow(recorder, request)
+	if recorder.Code != 200 {
+		parsed := CslResponse{}
+		json.Unmarshal(recorder.Body.Bytes(), &parsed)
+		return errors.New(fmt.Sprintf("failed continuing execution %s: %s", execution.ExecutionId, parsed.Reason))
+	}
+
+	return nil
+}
+
+// Refuses User Input answers through the link for approval nodes with a
+// policy, and records the other ones
+func cslApprovalsOnAnswer(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		executionId := query.Get("reference_execution")
+		if len(query.Get("answer")) == 0 || len(executionId) == 0 || request.Context().Value(cslApprovalAnswerKey{}) != nil {
+			handler(resp, request)
+			return
+		}
+
+		ctx := shuffle.GetContext(request)
+		execution, err := shuffle.GetWorkflowExecution(ctx, executionId)
+		if err != nil {
+			handler(resp, request)
+			return
+		}
+
+		nodeId := query.Get("start")
+		policies := getCslApprovalPolicies(ctx, execution.ExecutionOrg)
+		if _, found := policies.getPolicy(execution.Workflow.ID, nodeId); found || (len(nodeId) == 0 && hasCslWorkflowPolicy(policies, execution.Workflow.ID)) {
+			log.Printf("[AUDIT] Refused answer to approval node %s of execution %s through the link, as the node has an approval policy", nodeId, executionId)
+			resp.WriteHeader(403)
+			resp.Write(createCslErrorResponse(errors.New("this approval has to be answered with /api/v1/csl/approvals/approve or /api/v1/csl/approvals/reject")))
+			return
+		}
+
+		if _, ok := runRecordedHandler(handler, resp, request); !ok {
+			return
+		}
+
+		actor := "link"
+		if user, err := getMiddlewareUser(resp, request); err == nil && len(user.Username) > 0 {
+			actor = user.Username
+		}
+
+		status := ApprovalApproved
+		if query.Get("answer") == "false" {
+			status = ApprovalRejected
+		}
+
+		approval, found := finishCslApproval(ctx, execution.ExecutionOrg, executionId, nodeId, status, actor, query.Get("note"))
+		if found {
+			log.Printf("[AUDIT] Approval %s (%s) of execution %s was %s by %s through the link", approval.Id, approval.Label, executionId, status, actor)
+			recordCslActivity(ctx, execution.ExecutionOrg, ActivityTypeWorkflow, "approval_"+status, fmt.Sprintf("Approval %s of %s was %s", approval.Label, approval.WorkflowName, status), actor, executionId)
+		}
+	}
+}
+
+func hasCslWorkflowPolicy(policies CslApprovalPolicies, workflowId string) bool {
+	for _, policy := range policies.Policies {
+		if policy.WorkflowId == workflowId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Moves a pending approval to the recent ones. An empty nodeId matches any
+// node of the execution
+func finishCslApproval(ctx context.Context, orgId, executionId, nodeId, status, actor, note string) (CslApproval, bool) {
+	approvals := CslApprovals{}
+	finished := CslApproval{}
+	found := false
+	err := updateCslDocument(ctx, orgId, CslApprovalsDocument, &approvals, func() error {
+		setCslApprovalsDefaults(&approvals)
+		for index, approval := range approvals.Pending {
+			if approval.ExecutionId != executionId || (len(nodeId) > 0 && approval.NodeId != nodeId) {
+				continue
+			}
+
+			approval.Status = status
+			approval.AnsweredBy = actor
+			approval.AnsweredAt = time.Now().Unix()
+			approval.Note = note
+
+			approvals.Pending = append(approvals.Pending[:index], approvals.Pending[index+1:]...)
+			approvals.Recent = append(approvals.Recent, approval)
+			if len(approvals.Recent) > MaxRecentApprovals {
+				approvals.Recent = approvals.Recent[len(approvals.Recent)-MaxRecentApprovals:]
+			}
+
+			finished = approval
+			found = true
+			return nil
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed finishing approval of execution %s in org %s: %s", executionId, orgId, err)
+	}
+
+	return finished, found
+}
+
+// Puts an approval that couldn't be answered back in the pending ones
+func restoreCslApproval(ctx context.Context, orgId string, approval CslApproval) {
+	approvals := CslApprovals{}
+	err := updateCslDocument(ctx, orgId, CslApprovalsDocument, &approvals, func() error {
+		setCslApprovalsDefaults(&approvals)
+		for index, recent := range approvals.Recent {
+			if recent.Id == approval.Id {
+				approvals.Recent = append(approvals.Recent[:index], approvals.Recent[index+1:]...)
+				break
+			}
+		}
+
+		approval.Status = ApprovalPending
+		approval.AnsweredBy = ""
+		approval.AnsweredAt = 0
+		approval.Note = ""
+		approvals.Pending = append(approvals.Pending, approval)
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed restoring approval %s in org %s: %s", approval.Id, orgId, err)
+	}
+}
+
+/*
+Approvals:
+Returns the pending approvals the current user can answer, and the recently
+answered ones. Org admins get every approval. The approvals job looks for
+executions waiting at a User Input node every minute.
+
+	{"success": true, "data": {"pending": [{"id": "<approval id>", "execution_id": "<execution id>", "workflow_id": "<workflow id>", "workflow_name": "Isolate host", "node_id": "<node id>", "label": "Approve isolation", "status": "pending", "requested_at": 1700000000, "roles": ["admin"], "approvers": []}], "recent": []}}
+*/
+func cslGetApprovals(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	roles := getCslApproverRoles(ctx, org, *user)
+	approvals := getCslApprovals(ctx, org.Id)
+	visible := CslApprovals{}
+	setCslApprovalsDefaults(&visible)
+	for _, approval := range approvals.Pending {
+		if canAnswerCslApproval(org, *user, roles, approval) {
+			visible.Pending = append(visible.Pending, approval)
+		}
+	}
+
+	for _, approval := range approvals.Recent {
+		if canAnswerCslApproval(org, *user, roles, approval) {
+			visible.Recent = append(visible.Recent, approval)
+		}
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    visible,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetApprovals")
+}
+
+func answerCslApproval(resp http.ResponseWriter, request *http.Request, approved bool, fnName string) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	answer := CslApprovalAnswer{}
+	err = json.Unmarshal(body, &answer)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	approval := CslApproval{}
+	found := false
+	for _, pending := range getCslApprovals(ctx, org.Id).Pending {
+		if pending.Id == answer.ApprovalId {
+			approval = pending
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("pending approval %s not found", answer.ApprovalId))))
+		return
+	}
+
+	if !canAnswerCslApproval(org, *user, getCslApproverRoles(ctx, org, *user), approval) {
+		log.Printf("[WARNING] User %s (%s) isn't allowed to answer approval %s in org %s", user.Username, user.Id, approval.Id, org.Id)
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("you aren't allowed to answer this approval")))
+		return
+	}
+
+	// Claimed before the execution continues, so it can't be answered twice
+	status := ApprovalRejected
+	if approved {
+		status = ApprovalApproved
+	}
+
+	approval, found = finishCslApproval(ctx, org.Id, approval.ExecutionId, approval.NodeId, status, user.Username, answer.Note)
+	if !found {
+		resp.WriteHeader(409)
+		resp.Write(createCslErrorResponse(errors.New("the approval was already answered")))
+		return
+	}
+
+	err = runCslApprovalAnswer(ctx, approval, approved, answer.Note)
+	if err != nil {
+		log.Printf("[WARNING] Failed answering approval %s of execution %s: %s", approval.Id, approval.ExecutionId, err)
+		restoreCslApproval(ctx, org.Id, approval)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) %s approval %s (%s) of execution %s in org %s", user.Username, user.Id, status, approval.Id, approval.Label, approval.ExecutionId, org.Id)
+	recordCslActivity(ctx, org.Id, ActivityTypeWorkflow, "approval_"+status, fmt.Sprintf("Approval %s of %s was %s", approval.Label, approval.WorkflowName, status), user.Username, approval.ExecutionId)
+
+	res := CslResponse{
+		Success: true,
+		Data:    approval,
+	}
+
+	marshalAndWriteResponse(resp, res, fnName)
+}
+
+/*
+Approvals:
+Approves a pending approval, continuing its execution from the approval
+node. Requires a role or user of the approval policy, org admin, or if the
+node has no policy, access to run the workflow.
+
+	{"approval_id": "<approval id>", "note": "Host confirmed compromised"}
+*/
+func cslApproveApproval(resp http.ResponseWriter, request *http.Request) {
+	answerCslApproval(resp, request, true, "cslApproveApproval")
+}
+
+/*
+Approvals:
+Rejects a pending approval, which aborts its execution. Requires the same
+access as approving it.
+
+	{"approval_id": "<approval id>", "note": "False positive"}
+*/
+func cslRejectApproval(resp http.ResponseWriter, request *http.Request) {
+	answerCslApproval(resp, request, false, "cslRejectApproval")
+}
+
+/*
+Approvals:
+Returns who can answer the approval nodes of each workflow. Nodes without a
+policy can be answered by anyone who can run the workflow. Requires org
+admin.
+
+	{"success": true, "data": {"policies": [{"workflow_id": "<workflow id>", "node_id": "", "roles": ["admin", "incident lead"], "approvers": ["<user id>"]}], "updated_by": "admin", "updated": 1700000000}}
+*/
+func cslGetApprovalPolicies(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslApprovalPolicies(ctx, user.ActiveOrg.Id),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetApprovalPolicies")
+}
+
+/*
+Approvals:
+Sets who can answer the approval nodes of each workflow. A policy without
+node_id applies to every approval node of the workflow without its own
+policy. Roles are org roles or custom roles. Approvals that aren't answered
+within timeout_minutes escalate to the next stage in escalations, and
+on_timeout (approve or reject) answers them when the last stage times out.
+Pending approvals keep the policy they were requested with. Requires org
+admin.
+
+	{"policies": [{"workflow_id": "<workflow id>", "node_id": "", "roles": ["incident lead"], "approvers": [], "timeout_minutes": 30, "escalations": [{"roles": ["admin"], "approvers": [], "timeout_minutes": 60}], "on_timeout": "reject"}]}
+*/
+func cslSetApprovalPolicies(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	policies := CslApprovalPolicies{}
+	err = json.Unmarshal(body, &policies)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if policies.Policies == nil {
+		policies.Policies = []CslApprovalPolicy{}
+	}
+
+	err = validateCslApprovalPolicies(ctx, user.ActiveOrg.Id, policies.Policies)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	policies.UpdatedBy = user.Username
+	policies.Updated = time.Now().Unix()
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslApprovalPoliciesDocument, policies)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) changed the approval policies of org %s. Policies: %d", user.Username, user.Id, user.ActiveOrg.Id, len(policies.Policies))
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeSecurity, "approval_policies_changed", "Approval policies were changed", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    policies,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetApprovalPolicies")
+}
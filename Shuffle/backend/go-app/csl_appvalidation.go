@@ -0,0 +1,509 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/frikky/kin-openapi/openapi3"
+	gyaml "github.com/ghodss/yaml"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Validation of app definitions before they're activated. Python apps are
+// validated from their api.yaml or the zipped app folder, and OpenAPI apps
+// from their specification
+
+const MaxAppValidationSize = 20 * 1024 * 1024
+
+// Files a zipped Python app needs to be built
+var requiredAppFiles = []string{"api.yaml", "Dockerfile", "requirements.txt", "src/app.py"}
+
+// Names of the builtin apps, compared after normalizeAppName
+var reservedAppNames = []string{"shuffle_tools", "shuffle_subflow", "shuffle_workflow", "user_input", "http", "email", "testing", "integration_framework"}
+
+// Methods of the app SDK that actions can't override
+var reservedActionNames = []string{"run", "execute_action", "run_recursed_items", "validate_config", "get_file", "set_files", "update_file", "delete_file", "get_file_category_ids", "get_file_namespace_ids", "get_file_namespace", "get_cache", "set_cache", "get_cache_key", "set_cache_key"}
+
+var appNameRegex = regexp.MustCompile(`^[A-Za-z0-9_\- ]+$`)
+var pythonIdentifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var appVersionRegex = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+// Path points to the invalid part of the definition, e.g. actions[2].parameters[0].name
+type CslAppValidationIssue struct {
+	Path    string `json:"path"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type CslAppValidation struct {
+	Valid      bool                    `json:"valid"`
+	Kind       string                  `json:"kind"`
+	Name       string                  `json:"name"`
+	AppVersion string                  `json:"app_version"`
+	Actions    int                     `json:"actions"`
+	Errors     []CslAppValidationIssue `json:"errors"`
+	Warnings   []CslAppValidationIssue `json:"warnings"`
+}
+
+func (validation *CslAppValidation) addError(path, code, message string) {
+	validation.Errors = append(validation.Errors, CslAppValidationIssue{Path: path, Code: code, Message: message})
+}
+
+func (validation *CslAppValidation) addWarning(path, code, message string) {
+	validation.Warnings = append(validation.Warnings, CslAppValidationIssue{Path: path, Code: code, Message: message})
+}
+
+func normalizeAppName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.ReplaceAll(name, " ", "_")
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+// Checks the name against builtin apps and apps the user can already use
+func validateAppName(ctx context.Context, user shuffle.User, validation *CslAppValidation) {
+	if len(validation.Name) == 0 {
+		validation.addError("name", "missing_name", "the app needs a name")
+		return
+	}
+
+	if !appNameRegex.MatchString(validation.Name) {
+		validation.addError("name", "invalid_name", "app names can only contain letters, numbers, spaces, dashes and underscores")
+	}
+
+	if shuffle.ArrayContains(reservedAppNames, normalizeAppName(validation.Name)) {
+		validation.addError("name", "reserved_name", fmt.Sprintf("%s is the name of a builtin app", validation.Name))
+		return
+	}
+
+	apps, err := shuffle.GetPrioritizedApps(ctx, user)
+	if err != nil {
+		validation.addWarning("name", "name_not_checked", "existing apps couldn't be checked for the same name")
+		return
+	}
+
+	for _, app := range apps {
+		if normalizeAppName(app.Name) != normalizeAppName(validation.Name) {
+			continue
+		}
+
+		if app.AppVersion == validation.AppVersion {
+			validation.addError("app_version", "version_exists", fmt.Sprintf("version %s of %s already exists. Increase app_version to upload a new version", app.AppVersion, app.Name))
+		} else {
+			validation.addWarning("name", "new_version", fmt.Sprintf("%s already exists, and this will be added as version %s", app.Name, validation.AppVersion))
+		}
+
+		return
+	}
+}
+
+// Validates an api.yaml. Action and parameter names become Python function
+// and argument names, and auth fields are passed to every action
+func validatePythonApp(app shuffle.WorkflowApp, validation *CslAppValidation) {
+	validation.Name = app.Name
+	validation.AppVersion = app.AppVersion
+	validation.Actions = len(app.Actions)
+
+	if len(app.AppVersion) == 0 {
+		validation.addError("app_version", "missing_version", "the app needs an app_version")
+	} else if !appVersionRegex.MatchString(app.AppVersion) {
+		validation.addWarning("app_version", "invalid_version", "app_version should be in the format 1.0.0")
+	}
+
+	authFields := []string{}
+	if app.Authentication.Required && len(app.Authentication.Parameters) == 0 {
+		validation.addError("authentication.parameters", "missing_auth_fields", "authentication is required, but no parameters are defined")
+	}
+
+	for i, param := range app.Authentication.Parameters {
+		paramPath := fmt.Sprintf("authentication.parameters[%d]", i)
+		if len(param.Name) == 0 {
+			validation.addError(paramPath+".name", "missing_auth_field_name", "authentication parameters need a name")
+			continue
+		}
+
+		if !pythonIdentifierRegex.MatchString(param.Name) {
+			validation.addError(paramPath+".name", "invalid_auth_field_name", fmt.Sprintf("%s isn't a valid Python argument name", param.Name))
+		}
+
+		if shuffle.ArrayContains(authFields, param.Name) {
+			validation.addError(paramPath+".name", "duplicate_auth_field", fmt.Sprintf("%s is defined more than once", param.Name))
+		}
+
+		authFields = append(authFields, param.Name)
+	}
+
+	if len(app.Actions) == 0 {
+		validation.addError("actions", "missing_actions", "the app needs at least one action")
+	}
+
+	actionNames := []string{}
+	for i, action := range app.Actions {
+		actionPath := fmt.Sprintf("actions[%d]", i)
+		if len(action.Name) == 0 {
+			validation.addError(actionPath+".name", "missing_action_name", "actions need a name")
+			continue
+		}
+
+		if !pythonIdentifierRegex.MatchString(action.Name) {
+			validation.addError(actionPath+".name", "invalid_action_name", fmt.Sprintf("%s isn't a valid Python function name", action.Name))
+		}
+
+		if shuffle.ArrayContains(reservedActionNames, action.Name) || strings.HasPrefix(action.Name, "__") {
+			validation.addError(actionPath+".name", "reserved_action_name", fmt.Sprintf("%s is reserved by the app SDK", action.Name))
+		}
+
+		if shuffle.ArrayContains(actionNames, action.Name) {
+			validation.addError(actionPath+".name", "duplicate_action", fmt.Sprintf("%s is defined more than once", action.Name))
+		}
+
+		actionNames = append(actionNames, action.Name)
+
+		paramNames := []string{}
+		for j, param := range action.Parameters {
+			paramPath := fmt.Sprintf("%s.parameters[%d]", actionPath, j)
+			if len(param.Name) == 0 {
+				validation.addError(paramPath+".name", "missing_parameter_name", "parameters need a name")
+				continue
+			}
+
+			if !pythonIdentifierRegex.MatchString(param.Name) || param.Name == "self" {
+				validation.addError(paramPath+".name", "invalid_parameter_name", fmt.Sprintf("%s isn't a valid Python argument name", param.Name))
+			}
+
+			if shuffle.ArrayContains(paramNames, param.Name) {
+				validation.addError(paramPath+".name", "duplicate_parameter", fmt.Sprintf("%s is defined more than once in %s", param.Name, action.Name))
+			}
+
+			if shuffle.ArrayContains(authFields, param.Name) {
+				validation.addError(paramPath+".name", "auth_field_collision", fmt.Sprintf("%s is also an authentication parameter, which is passed to every action", param.Name))
+			}
+
+			if len(param.Options) > 0 && len(param.Value) > 0 && !shuffle.ArrayContains(param.Options, param.Value) {
+				validation.addWarning(paramPath+".value", "invalid_default", fmt.Sprintf("the default value of %s isn't one of its options", param.Name))
+			}
+
+			paramNames = append(paramNames, param.Name)
+		}
+	}
+}
+
+// Validates a zipped Python app folder. The folder can be at the root of the
+// zip or in a single directory, e.g. myapp/1.0.0/api.yaml
+func validateAppZip(data []byte, validation *CslAppValidation) (shuffle.WorkflowApp, bool) {
+	app := shuffle.WorkflowApp{}
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		validation.addError("", "invalid_zip", fmt.Sprintf("failed reading zip: %s", err))
+		return app, false
+	}
+
+	root := ""
+	for _, file := range reader.File {
+		if path.Base(file.Name) == "api.yaml" && (len(root) == 0 || len(path.Dir(file.Name)) < len(root)) {
+			root = path.Dir(file.Name)
+		}
+	}
+
+	files := map[string][]byte{}
+	for _, file := range reader.File {
+		relative := strings.TrimPrefix(file.Name, root+"/")
+		if root == "." {
+			relative = file.Name
+		}
+
+		if !shuffle.ArrayContains(requiredAppFiles, relative) || file.UncompressedSize64 > MaxAppValidationSize {
+			continue
+		}
+
+		opened, err := file.Open()
+		if err != nil {
+			continue
+		}
+
+		files[relative], err = ioutil.ReadAll(opened)
+		opened.Close()
+		if err != nil {
+			delete(files, relative)
+		}
+	}
+
+	for _, required := range requiredAppFiles {
+		if _, ok := files[required]; !ok {
+			validation.addError(required, "missing_file", fmt.Sprintf("%s is required to build the app", required))
+		}
+	}
+
+	if _, ok := files["api.yaml"]; !ok {
+		return app, false
+	}
+
+	err = gyaml.Unmarshal(files["api.yaml"], &app)
+	if err != nil {
+		validation.addError("api.yaml", "invalid_yaml", fmt.Sprintf("failed parsing api.yaml: %s", err))
+		return app, false
+	}
+
+	if source, ok := files["src/app.py"]; ok {
+		for i, action := range app.Actions {
+			if len(action.Name) == 0 {
+				continue
+			}
+
+			if !regexp.MustCompile(fmt.Sprintf(`def\s+%s\s*\(`, regexp.QuoteMeta(action.Name))).Match(source) {
+				validation.addError(fmt.Sprintf("actions[%d].name", i), "missing_action_function", fmt.Sprintf("src/app.py has no function named %s", action.Name))
+			}
+		}
+	}
+
+	return app, true
+}
+
+// Validates an OpenAPI specification the same way it's parsed when the app
+// is built, then checks what becomes actions and authentication
+func validateOpenApiApp(ctx context.Context, data []byte, validation *CslAppValidation) {
+	parsed, err := handleSwaggerValidation(data)
+	if err != nil {
+		validation.addError("", "invalid_openapi", fmt.Sprintf("failed parsing the specification: %s", err))
+		return
+	}
+
+	swaggerLoader := openapi3.NewSwaggerLoader()
+	swagger, err := swaggerLoader.LoadSwaggerFromData([]byte(parsed.Body))
+	if err != nil {
+		validation.addError("", "invalid_openapi", fmt.Sprintf("failed loading the specification: %s", err))
+		return
+	}
+
+	err = swagger.Validate(ctx)
+	if err != nil {
+		validation.addWarning("", "schema_warning", err.Error())
+	}
+
+	if swagger.Info != nil {
+		validation.Name = swagger.Info.Title
+		validation.AppVersion = swagger.Info.Version
+	}
+
+	if len(swagger.Servers) == 0 {
+		validation.addWarning("servers", "missing_server", "no servers are defined, so the URL has to be set in each action")
+	}
+
+	if len(swagger.Paths) == 0 {
+		validation.addError("paths", "missing_actions", "the specification has no paths")
+	}
+
+	paths := []string{}
+	for apiPath := range swagger.Paths {
+		paths = append(paths, apiPath)
+	}
+
+	sort.Strings(paths)
+
+	actionNames := map[string]string{}
+	for _, apiPath := range paths {
+		operations := swagger.Paths[apiPath].Operations()
+		methods := []string{}
+		for method := range operations {
+			methods = append(methods, method)
+		}
+
+		sort.Strings(methods)
+		for _, method := range methods {
+			operation := operations[method]
+			validation.Actions += 1
+			operationPath := fmt.Sprintf("paths.%s.%s", apiPath, strings.ToLower(method))
+
+			name := operation.Summary
+			if len(name) == 0 {
+				name = operation.OperationID
+			}
+
+			if len(name) == 0 {
+				validation.addWarning(operationPath, "unnamed_action", "operations without a summary or operationId get a generated action name")
+				continue
+			}
+
+			normalized := normalizeAppName(name)
+			if existing, ok := actionNames[normalized]; ok {
+				validation.addError(operationPath+".summary", "duplicate_action", fmt.Sprintf("%s results in the same action name as %s", name, existing))
+			}
+
+			actionNames[normalized] = operationPath
+		}
+	}
+
+	schemes := []string{}
+	for name, ref := range swagger.Components.SecuritySchemes {
+		schemes = append(schemes, name)
+		schemePath := fmt.Sprintf("components.securitySchemes.%s", name)
+		if ref == nil || ref.Value == nil {
+			validation.addError(schemePath, "invalid_auth_scheme", "the security scheme couldn't be resolved")
+			continue
+		}
+
+		scheme := ref.Value
+		switch scheme.Type {
+		case "apiKey":
+			if len(scheme.Name) == 0 {
+				validation.addError(schemePath+".name", "missing_auth_fields", "apiKey security schemes need the name of the header, query or cookie")
+			}
+
+			if scheme.In != "header" && scheme.In != "query" && scheme.In != "cookie" {
+				validation.addError(schemePath+".in", "missing_auth_fields", "apiKey security schemes need in to be header, query or cookie")
+			}
+		case "http":
+			if len(scheme.Scheme) == 0 {
+				validation.addError(schemePath+".scheme", "missing_auth_fields", "http security schemes need a scheme, e.g. basic or bearer")
+			}
+		case "oauth2":
+			if scheme.Flows == nil {
+				validation.addError(schemePath+".flows", "missing_auth_fields", "oauth2 security schemes need at least one flow")
+			}
+		case "openIdConnect":
+			if len(scheme.OpenIdConnectUrl) == 0 {
+				validation.addError(schemePath+".openIdConnectUrl", "missing_auth_fields", "openIdConnect security schemes need an openIdConnectUrl")
+			}
+		default:
+			validation.addError(schemePath+".type", "invalid_auth_scheme", fmt.Sprintf("%s isn't a supported security scheme type", scheme.Type))
+		}
+	}
+
+	for i, requirement := range swagger.Security {
+		for name := range requirement {
+			if !shuffle.ArrayContains(schemes, name) {
+				validation.addError(fmt.Sprintf("security[%d].%s", i, name), "unknown_auth_scheme", fmt.Sprintf("%s isn't defined in components.securitySchemes", name))
+			}
+		}
+	}
+}
+
+// Finds whether the data is a zipped app, an OpenAPI specification or an
+// api.yaml, and validates it
+func validateAppDefinition(ctx context.Context, user shuffle.User, data []byte) CslAppValidation {
+	validation := CslAppValidation{
+		Errors:   []CslAppValidationIssue{},
+		Warnings: []CslAppValidationIssue{},
+	}
+
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		validation.Kind = "python"
+		app, ok := validateAppZip(data, &validation)
+		if ok {
+			validatePythonApp(app, &validation)
+		}
+	} else {
+		version := map[string]interface{}{}
+		err := gyaml.Unmarshal(data, &version)
+		if err != nil {
+			validation.addError("", "invalid_yaml", fmt.Sprintf("the definition isn't valid yaml or json: %s", err))
+		} else if version["openapi"] != nil || version["swagger"] != nil || version["swaggerVersion"] != nil {
+			validation.Kind = "openapi"
+			validateOpenApiApp(ctx, data, &validation)
+		} else {
+			validation.Kind = "python"
+			app := shuffle.WorkflowApp{}
+			err = gyaml.Unmarshal(data, &app)
+			if err != nil {
+				validation.addError("", "invalid_yaml", fmt.Sprintf("failed parsing the app definition: %s", err))
+			} else {
+				validatePythonApp(app, &validation)
+			}
+		}
+	}
+
+	if len(validation.Kind) > 0 && (len(validation.Errors) == 0 || len(validation.Name) > 0) {
+		validateAppName(ctx, user, &validation)
+	}
+
+	validation.Valid = len(validation.Errors) == 0
+	return validation
+}
+
+/*
+Apps:
+Validates an app before it's uploaded and activated. The body is an api.yaml,
+an OpenAPI specification in json or yaml, or a zipped Python app folder with
+api.yaml, Dockerfile, requirements.txt and src/app.py. The zip can also be
+uploaded as the form file shuffle_file. Nothing is stored.
+
+Errors have to be fixed before the app works, while warnings point to things
+that are likely unintended.
+
+	{
+	    "success": true,
+	    "data": {
+	        "valid": false,
+	        "kind": "python",
+	        "name": "My app",
+	        "app_version": "1.0.0",
+	        "actions": 2,
+	        "errors": [
+	            {
+	                "path": "actions[1].parameters[0].name",
+	                "code": "auth_field_collision",
+	                "message": "apikey is also an authentication parameter, which is passed to every action"
+	            }
+	        ],
+	        "warnings": []
+	    }
+	}
+*/
+func cslValidateApp(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if user.Role == "org-reader" {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("read only user")))
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	var data []byte
+	var err error
+	request.Body = http.MaxBytesReader(resp, request.Body, MaxAppValidationSize)
+	if strings.HasPrefix(request.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, fileErr := request.FormFile("shuffle_file")
+		if fileErr != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New("the app has to be uploaded as shuffle_file")))
+			return
+		}
+
+		defer file.Close()
+		data, err = ioutil.ReadAll(file)
+	} else {
+		data, err = ioutil.ReadAll(request.Body)
+	}
+
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("no app definition was sent")))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    validateAppDefinition(ctx, *user, data),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslValidateApp")
+}
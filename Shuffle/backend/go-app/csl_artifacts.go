@@ -0,0 +1,693 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslArtifactsDocument = "artifacts"
+
+// Artifacts are stored as files in this namespace
+const ArtifactNamespace = "artifacts"
+
+const DefaultArtifactMaxFileBytes = 100 * 1024 * 1024
+const MaxArtifactFileBytes = 1024 * 1024 * 1024
+
+// Max amount of artifacts per org, regardless of the quota
+const MaxArtifactCount = 5000
+
+// Max amount of executions and tickets an artifact is linked to
+const MaxArtifactLinks = 50
+
+// Artifact types
+const (
+	ArtifactTypeFile       = "file"
+	ArtifactTypePcap       = "pcap"
+	ArtifactTypeMemoryDump = "memory_dump"
+	ArtifactTypeScreenshot = "screenshot"
+)
+
+var artifactTypes = []string{ArtifactTypeFile, ArtifactTypePcap, ArtifactTypeMemoryDump, ArtifactTypeScreenshot}
+
+// Magic numbers of pcap and pcapng files
+var pcapMagicNumbers = [][]byte{
+	{0xd4, 0xc3, 0xb2, 0xa1},
+	{0xa1, 0xb2, 0xc3, 0xd4},
+	{0x4d, 0x3c, 0xb2, 0xa1},
+	{0xa1, 0xb2, 0x3c, 0x4d},
+	{0x0a, 0x0d, 0x0d, 0x0a},
+}
+
+var memoryDumpExtensions = []string{".dmp", ".mem", ".vmem", ".raw", ".lime", ".core"}
+
+// MaxBytes and MaxCount of 0 means no limit. A MaxFileBytes of 0 uses the default
+type CslArtifactQuota struct {
+	MaxBytes     int64 `json:"max_bytes"`
+	MaxFileBytes int64 `json:"max_file_bytes"`
+	MaxCount     int   `json:"max_count"`
+}
+
+// ExecutionIds and Tickets link the artifact to executions and to the Jira
+// issues or ServiceNow incidents of escalated executions. YaraMatches holds
+// the matching YARA rules as <ruleset>:<rule>
+type CslArtifact struct {
+	Id           string   `json:"id"`
+	Filename     string   `json:"filename"`
+	Type         string   `json:"type"`
+	Description  string   `json:"description"`
+	Tags         []string `json:"tags"`
+	Sha256       string   `json:"sha256"`
+	Md5          string   `json:"md5"`
+	Size         int64    `json:"size"`
+	ContentType  string   `json:"content_type"`
+	ExecutionIds []string `json:"execution_ids"`
+	Tickets      []string `json:"tickets"`
+	YaraMatches  []string `json:"yara_matches"`
+	CreatedBy    string   `json:"created_by"`
+	Created      int64    `json:"created"`
+}
+
+type CslArtifacts struct {
+	Quota     CslArtifactQuota       `json:"quota"`
+	Artifacts map[string]CslArtifact `json:"artifacts"`
+}
+
+type CslArtifactUsage struct {
+	Quota CslArtifactQuota `json:"quota"`
+	Bytes int64            `json:"bytes"`
+	Count int              `json:"count"`
+}
+
+func getCslArtifacts(ctx context.Context, orgId string) CslArtifacts {
+	artifacts := CslArtifacts{}
+	_, err := getCslDocument(ctx, orgId, CslArtifactsDocument, &artifacts)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting artifacts for org %s: %s", orgId, err)
+	}
+
+	if artifacts.Artifacts == nil {
+		artifacts.Artifacts = map[string]CslArtifact{}
+	}
+
+	return artifacts
+}
+
+func getArtifactMaxFileBytes(quota CslArtifactQuota) int64 {
+	if quota.MaxFileBytes > 0 {
+		return quota.MaxFileBytes
+	}
+
+	return DefaultArtifactMaxFileBytes
+}
+
+func getArtifactUsage(artifacts CslArtifacts) CslArtifactUsage {
+	usage := CslArtifactUsage{
+		Quota: artifacts.Quota,
+		Count: len(artifacts.Artifacts),
+	}
+
+	usage.Quota.MaxFileBytes = getArtifactMaxFileBytes(artifacts.Quota)
+	for _, artifact := range artifacts.Artifacts {
+		usage.Bytes += artifact.Size
+	}
+
+	return usage
+}
+
+func validateCslArtifactQuota(quota CslArtifactQuota) error {
+	if quota.MaxBytes < 0 || quota.MaxFileBytes < 0 || quota.MaxCount < 0 {
+		return errors.New("quota limits can't be negative")
+	}
+
+	if quota.MaxFileBytes > MaxArtifactFileBytes {
+		return errors.New(fmt.Sprintf("max_file_bytes can't be above %d", MaxArtifactFileBytes))
+	}
+
+	if quota.MaxCount > MaxArtifactCount {
+		return errors.New(fmt.Sprintf("max_count can't be above %d", MaxArtifactCount))
+	}
+
+	return nil
+}
+
+// Checks whether an artifact of the size fits within the quota of the org
+func checkArtifactQuota(artifacts CslArtifacts, size int64) error {
+	usage := getArtifactUsage(artifacts)
+	if size > usage.Quota.MaxFileBytes {
+		return errors.New(fmt.Sprintf("artifact is larger than the max file size of %d bytes", usage.Quota.MaxFileBytes))
+	}
+
+	if usage.Count >= MaxArtifactCount || (usage.Quota.MaxCount > 0 && usage.Count >= usage.Quota.MaxCount) {
+		return errors.New("artifact count quota exceeded")
+	}
+
+	if usage.Quota.MaxBytes > 0 && usage.Bytes+size > usage.Quota.MaxBytes {
+		return errors.New(fmt.Sprintf("artifact storage quota exceeded. %d of %d bytes used", usage.Bytes, usage.Quota.MaxBytes))
+	}
+
+	return nil
+}
+
+// Guesses the type of an artifact from its contents and filename
+func detectArtifactType(filename string, contents []byte) string {
+	for _, magicNumber := range pcapMagicNumbers {
+		if bytes.HasPrefix(contents, magicNumber) {
+			return ArtifactTypePcap
+		}
+	}
+
+	if strings.HasPrefix(http.DetectContentType(contents), "image/") {
+		return ArtifactTypeScreenshot
+	}
+
+	if shuffle.ArrayContains(memoryDumpExtensions, strings.ToLower(filepath.Ext(filename))) {
+		return ArtifactTypeMemoryDump
+	}
+
+	return ArtifactTypeFile
+}
+
+func addArtifactLink(links []string, link string) []string {
+	if len(link) == 0 || shuffle.ArrayContains(links, link) || len(links) >= MaxArtifactLinks {
+		return links
+	}
+
+	return append(links, link)
+}
+
+// Stores the contents as an artifact of the org. The hashes, size, content
+// type and YARA matches of the artifact are set from the contents
+func storeCslArtifact(ctx context.Context, orgId string, artifact CslArtifact, contents []byte) (CslArtifact, error) {
+	file := shuffle.File{
+		Filename:    filepath.Base(artifact.Filename),
+		OrgId:       orgId,
+		Namespace:   ArtifactNamespace,
+		Tags:        append([]string{artifact.Type}, artifact.Tags...),
+		Description: truncateText(artifact.Description, 1000),
+		CreatedBy:   artifact.CreatedBy,
+	}
+
+	fileId, err := shuffle.UploadOrgFile(ctx, &file, contents)
+	if err != nil {
+		log.Printf("[ERROR] Failed storing artifact for org %s: %s", orgId, err)
+		return artifact, errors.New("failed storing artifact")
+	}
+
+	artifact.Id = fileId
+	artifact.Filename = file.Filename
+	artifact.Description = file.Description
+	artifact.Sha256 = fmt.Sprintf("%x", sha256.Sum256(contents))
+	artifact.Md5 = file.Md5sum
+	artifact.Size = int64(len(contents))
+	artifact.ContentType = http.DetectContentType(contents)
+	artifact.YaraMatches = scanCslArtifact(ctx, orgId, contents)
+	artifact.Created = time.Now().Unix()
+
+	artifacts := CslArtifacts{}
+	err = updateCslDocument(ctx, orgId, CslArtifactsDocument, &artifacts, func() error {
+		if artifacts.Artifacts == nil {
+			artifacts.Artifacts = map[string]CslArtifact{}
+		}
+
+		// Checked again as other uploads may have finished meanwhile
+		err := checkArtifactQuota(artifacts, artifact.Size)
+		if err != nil {
+			return err
+		}
+
+		artifacts.Artifacts[artifact.Id] = artifact
+		return nil
+	})
+	if err != nil {
+		shuffle.DeleteOrgFile(ctx, &file)
+		return artifact, err
+	}
+
+	log.Printf("[AUDIT] %s stored artifact %s (%s, sha256 %s) for org %s", artifact.CreatedBy, artifact.Id, artifact.Type, artifact.Sha256, orgId)
+	return artifact, nil
+}
+
+// Reads the contents of an artifact and verifies them against the SHA-256
+// hash recorded at upload
+func getCslArtifactContents(ctx context.Context, orgId string, artifact CslArtifact) ([]byte, error) {
+	file, err := shuffle.GetFile(ctx, artifact.Id)
+	if err != nil || file.OrgId != orgId || file.Status != "active" {
+		return nil, errors.New("artifact contents not found")
+	}
+
+	contents, err := shuffle.GetFileContent(ctx, file, nil)
+	if err != nil {
+		log.Printf("[ERROR] Failed reading artifact %s for org %s: %s", artifact.Id, orgId, err)
+		return nil, errors.New("failed reading artifact")
+	}
+
+	// Google storage writes contents with a trailing newline
+	if len(contents) == int(artifact.Size)+1 && contents[len(contents)-1] == '\n' {
+		contents = contents[:len(contents)-1]
+	}
+
+	if fmt.Sprintf("%x", sha256.Sum256(contents)) != artifact.Sha256 {
+		log.Printf("[ERROR] Integrity check failed for artifact %s in org %s", artifact.Id, orgId)
+		return nil, errors.New("artifact contents don't match the recorded sha256")
+	}
+
+	return contents, nil
+}
+
+// Artifacts linked to any of the executions
+func getExecutionArtifacts(ctx context.Context, orgId string, executionIds map[string]bool) []CslArtifact {
+	linked := []CslArtifact{}
+	for _, artifact := range getCslArtifacts(ctx, orgId).Artifacts {
+		for _, executionId := range artifact.ExecutionIds {
+			if executionIds[executionId] {
+				linked = append(linked, artifact)
+				break
+			}
+		}
+	}
+
+	return linked
+}
+
+/*
+Artifacts:
+Returns the evidence artifacts of the current organization, newest first, and
+the storage used. Optional ?execution_id=<id>, ?ticket=<key>, ?type=<type> and
+?sha256=<hash> filter the artifacts.
+
+	{
+	    "success": true,
+	    "data": {
+	        "usage": {
+	            "quota": {"max_bytes": 10737418240, "max_file_bytes": 104857600, "max_count": 0},
+	            "bytes": 52428800,
+	            "count": 12
+	        },
+	        "artifacts": [
+	            {
+	                "id": "file_...",
+	                "filename": "capture.pcap",
+	                "type": "pcap",
+	                "description": "Traffic from the infected host",
+	                "tags": ["phishing"],
+	                "sha256": "...",
+	                "md5": "...",
+	                "size": 1048576,
+	                "content_type": "application/octet-stream",
+	                "execution_ids": ["..."],
+	                "tickets": ["SOC-42"],
+	                "yara_matches": ["ransomware:ransom_note"],
+	                "created_by": "execution ...",
+	                "created": 1700000000
+	            }
+	        ]
+	    }
+	}
+*/
+func cslListArtifacts(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	stored := getCslArtifacts(ctx, user.ActiveOrg.Id)
+	artifacts := []CslArtifact{}
+	for _, artifact := range stored.Artifacts {
+		if len(query.Get("execution_id")) > 0 && !shuffle.ArrayContains(artifact.ExecutionIds, query.Get("execution_id")) {
+			continue
+		}
+
+		if len(query.Get("ticket")) > 0 && !shuffle.ArrayContains(artifact.Tickets, query.Get("ticket")) {
+			continue
+		}
+
+		if len(query.Get("type")) > 0 && artifact.Type != query.Get("type") {
+			continue
+		}
+
+		if len(query.Get("sha256")) > 0 && artifact.Sha256 != strings.ToLower(query.Get("sha256")) {
+			continue
+		}
+
+		artifacts = append(artifacts, artifact)
+	}
+
+	sort.SliceStable(artifacts, func(i, j int) bool {
+		return artifacts[i].Created > artifacts[j].Created
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"usage":     getArtifactUsage(stored),
+			"artifacts": artifacts,
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslListArtifacts")
+}
+
+/*
+Artifacts:
+Uploads an evidence artifact as multipart form data with the contents in the
+"file" field. Optional fields are type (file, pcap, memory_dump or screenshot,
+detected when left out), description, tags (comma separated), execution_id and
+ticket. Playbooks can upload with ?execution_id=<id>&authorization=<execution
+authorization>, which links the artifact to the execution. Returns the
+artifact, including its SHA-256 hash and the matching YARA rules when artifact
+scanning is enabled.
+*/
+func cslUploadArtifact(resp http.ResponseWriter, request *http.Request) {
+	orgId, uploader, executionId, ok := handleCslExecutionRequest(resp, request)
+	if !ok {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	stored := getCslArtifacts(ctx, orgId)
+	maxFileBytes := getArtifactMaxFileBytes(stored.Quota)
+	request.Body = http.MaxBytesReader(resp, request.Body, maxFileBytes+1024*1024)
+
+	err := request.ParseMultipartForm(32 << 20)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("failed parsing upload. Max file size is %d bytes: %s", maxFileBytes, err))))
+		return
+	}
+
+	defer request.MultipartForm.RemoveAll()
+	parsedFile, header, err := request.FormFile("file")
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("file is required")))
+		return
+	}
+
+	defer parsedFile.Close()
+	contents, err := ioutil.ReadAll(parsedFile)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if len(contents) == 0 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("file is empty")))
+		return
+	}
+
+	artifactType := request.FormValue("type")
+	if len(artifactType) == 0 {
+		artifactType = detectArtifactType(header.Filename, contents)
+	} else if !shuffle.ArrayContains(artifactTypes, artifactType) {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("type must be one of %s", strings.Join(artifactTypes, ", ")))))
+		return
+	}
+
+	if len(executionId) == 0 && len(request.FormValue("execution_id")) > 0 {
+		execution, err := shuffle.GetWorkflowExecution(ctx, request.FormValue("execution_id"))
+		if err != nil || execution.ExecutionOrg != orgId {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New("execution_id is not valid")))
+			return
+		}
+
+		executionId = execution.ExecutionId
+	}
+
+	err = checkArtifactQuota(stored, int64(len(contents)))
+	if err != nil {
+		resp.WriteHeader(413)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	tags := []string{}
+	for _, tag := range strings.Split(request.FormValue("tags"), ",") {
+		if len(strings.TrimSpace(tag)) > 0 {
+			tags = append(tags, strings.TrimSpace(tag))
+		}
+	}
+
+	artifact, err := storeCslArtifact(ctx, orgId, CslArtifact{
+		Filename:     header.Filename,
+		Type:         artifactType,
+		Description:  request.FormValue("description"),
+		Tags:         tags,
+		ExecutionIds: addArtifactLink([]string{}, executionId),
+		Tickets:      addArtifactLink([]string{}, request.FormValue("ticket")),
+		CreatedBy:    uploader,
+	}, contents)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    artifact,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslUploadArtifact")
+}
+
+/*
+Artifacts:
+Downloads an artifact. Requires ?id=<id>. The contents are verified against
+the SHA-256 hash recorded at upload, which is returned in the
+X-Artifact-Sha256 header.
+*/
+func cslDownloadArtifact(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	artifact, ok := getCslArtifacts(ctx, user.ActiveOrg.Id).Artifacts[request.URL.Query().Get("id")]
+	if !ok {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New("artifact not found")))
+		return
+	}
+
+	contents, err := getCslArtifactContents(ctx, user.ActiveOrg.Id, artifact)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) downloaded artifact %s for org %s", user.Username, user.Id, artifact.Id, user.ActiveOrg.Id)
+
+	resp.Header().Set("Content-Type", "application/octet-stream")
+	resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", strconv.Quote(artifact.Filename)))
+	resp.Header().Set("X-Artifact-Sha256", artifact.Sha256)
+	resp.WriteHeader(200)
+	resp.Write(contents)
+}
+
+/*
+Artifacts:
+Links an artifact to an execution or ticket. Requires ?id=<id>.
+
+	{
+	    "execution_id": "...",
+	    "ticket": "SOC-42"
+	}
+*/
+func cslLinkArtifact(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	link := struct {
+		ExecutionId string `json:"execution_id"`
+		Ticket      string `json:"ticket"`
+	}{}
+
+	err = json.Unmarshal(body, &link)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if len(link.ExecutionId) > 0 {
+		execution, err := shuffle.GetWorkflowExecution(ctx, link.ExecutionId)
+		if err != nil || execution.ExecutionOrg != user.ActiveOrg.Id {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New("execution_id is not valid")))
+			return
+		}
+	}
+
+	artifactId := request.URL.Query().Get("id")
+	artifact := CslArtifact{}
+	artifacts := CslArtifacts{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslArtifactsDocument, &artifacts, func() error {
+		var ok bool
+		artifact, ok = artifacts.Artifacts[artifactId]
+		if !ok {
+			return errors.New("artifact not found")
+		}
+
+		artifact.ExecutionIds = addArtifactLink(artifact.ExecutionIds, link.ExecutionId)
+		artifact.Tickets = addArtifactLink(artifact.Tickets, link.Ticket)
+		artifacts.Artifacts[artifactId] = artifact
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    artifact,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslLinkArtifact")
+}
+
+/*
+Artifacts:
+Deletes an artifact and its contents. Requires ?id=<id> and org admin.
+*/
+func cslDeleteArtifact(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	artifactId := request.URL.Query().Get("id")
+	artifacts := CslArtifacts{}
+	err := updateCslDocument(ctx, user.ActiveOrg.Id, CslArtifactsDocument, &artifacts, func() error {
+		if _, ok := artifacts.Artifacts[artifactId]; !ok {
+			return errors.New("artifact not found")
+		}
+
+		delete(artifacts.Artifacts, artifactId)
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	file, err := shuffle.GetFile(ctx, artifactId)
+	if err == nil && file.OrgId == user.ActiveOrg.Id {
+		err = shuffle.DeleteOrgFile(ctx, file)
+		if err != nil {
+			log.Printf("[ERROR] Failed deleting contents of artifact %s for org %s: %s", artifactId, user.ActiveOrg.Id, err)
+		}
+	}
+
+	log.Printf("[AUDIT] User %s (%s) deleted artifact %s for org %s", user.Username, user.Id, artifactId, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "artifact_deleted", fmt.Sprintf("Artifact %s was deleted", artifactId), user.Username, artifactId)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslDeleteArtifact")
+}
+
+/*
+Artifacts:
+Updates the artifact storage quota of the current organization. Requires org
+admin. Returns the usage with the new quota.
+
+	{
+	    "max_bytes": 10737418240,
+	    "max_file_bytes": 104857600,
+	    "max_count": 0
+	}
+*/
+func cslSetArtifactQuota(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	quota := CslArtifactQuota{}
+	err = json.Unmarshal(body, &quota)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	err = validateCslArtifactQuota(quota)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	artifacts := CslArtifacts{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslArtifactsDocument, &artifacts, func() error {
+		artifacts.Quota = quota
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated artifact quota for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "artifact_quota_updated", "Artifact storage quota was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    getArtifactUsage(artifacts),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetArtifactQuota")
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslStatsBackfillDocument = "stats_backfill"
+
+// Longest date range recomputed by a single backfill
+const MaxBackfillDays = 90
+
+// Most recent executions of each workflow read during a backfill. Older days
+// of workflows with more executions than this can't be fully recomputed
+const MaxBackfillExecutionsPerWorkflow = 10000
+
+// Progress is stored every time this many workflows have been processed
+const BackfillProgressInterval = 10
+
+// A backfill still running after this long was lost to a restart
+const BackfillTimeoutMinutes = 60
+
+// Backfill states
+const (
+	BackfillRunning  = "running"
+	BackfillFinished = "finished"
+	BackfillFailed   = "failed"
+)
+
+type CslStatsBackfillRequest struct {
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+type CslStatsBackfill struct {
+	Status             string  `json:"status"`
+	StartDate          string  `json:"start_date"`
+	EndDate            string  `json:"end_date"`
+	Workflows          int     `json:"workflows"`
+	ProcessedWorkflows int     `json:"processed_workflows"`
+	Progress           float64 `json:"progress"`
+	Executions         int64   `json:"executions"`
+	DaysUpdated        int     `json:"days_updated"`
+	TruncatedWorkflows int     `json:"truncated_workflows"`
+	StartedBy          string  `json:"started_by"`
+	StartedAt          int64   `json:"started_at"`
+	FinishedAt         int64   `json:"finished_at"`
+	Error              string  `json:"error,omitempty"`
+}
+
+// Recomputed statistics of a single day. Additions are keyed like the
+// additions in the org statistics
+type CslBackfillDay struct {
+	Stats     shuffle.DailyStatistics
+	Additions map[string]int64
+}
+
+func getCslStatsBackfill(ctx context.Context, orgId string) CslStatsBackfill {
+	backfill := CslStatsBackfill{}
+	_, err := getCslDocument(ctx, orgId, CslStatsBackfillDocument, &backfill)
+	if err != nil {
+		log.Printf("[WARNING] Failed loading stats backfill for org %s: %s", orgId, err)
+	}
+
+	if backfill.Status == BackfillRunning && !isBackfillRunning(backfill) {
+		backfill.Status = BackfillFailed
+		backfill.Error = "backfill was interrupted"
+	}
+
+	return backfill
+}
+
+func isBackfillRunning(backfill CslStatsBackfill) bool {
+	return backfill.Status == BackfillRunning && backfill.StartedAt > time.Now().Add(-BackfillTimeoutMinutes*time.Minute).Unix()
+}
+
+func updateCslStatsBackfill(ctx context.Context, orgId string, update func(backfill *CslStatsBackfill)) {
+	backfill := CslStatsBackfill{}
+	err := updateCslDocument(ctx, orgId, CslStatsBackfillDocument, &backfill, func() error {
+		update(&backfill)
+		if backfill.Workflows > 0 {
+			backfill.Progress = roundScore(float64(backfill.ProcessedWorkflows) / float64(backfill.Workflows) * 100)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed updating stats backfill for org %s: %s", orgId, err)
+	}
+}
+
+// Counts an execution the same way as HandleExecutionCacheIncrement. Aborted
+// executions without failed actions are counted as user aborts
+func countBackfillExecution(day *CslBackfillDay, execution shuffle.WorkflowExecution) {
+	day.Stats.WorkflowExecutions++
+	day.Additions[getTriggerStatKey(getExecutionTrigger(execution))]++
+
+	if execution.Status == "FINISHED" {
+		day.Stats.WorkflowExecutionsFinished++
+	} else if execution.Status == "ABORTED" || execution.Status == "FAILURE" {
+		day.Stats.WorkflowExecutionsFailed++
+	}
+
+	failures := 0
+	for _, result := range execution.Results {
+		if result.Status == "SUCCESS" {
+			day.Stats.AppExecutions++
+		} else if result.Status == "FAILURE" || result.Status == "ABORTED" {
+			day.Stats.AppExecutions++
+			day.Stats.AppExecutionsFailed++
+			day.Additions[shuffle.GetFailureStatKey(shuffle.GetFailureCategory(result))]++
+			failures++
+		}
+
+		if result.Action.AppName == "Shuffle Workflow" && result.Status == "SUCCESS" {
+			day.Stats.SubflowExecutions++
+		}
+	}
+
+	if execution.Status == "ABORTED" && failures == 0 {
+		day.Additions[shuffle.GetFailureStatKey(shuffle.FailureUserAbort)]++
+	}
+}
+
+// Overwrites the execution counts of a stored day. Api usage and the other
+// counts that can't be found from executions are kept
+func mergeBackfillDay(stored shuffle.DailyStatistics, day CslBackfillDay) shuffle.DailyStatistics {
+	stored.AppExecutions = day.Stats.AppExecutions
+	stored.AppExecutionsFailed = day.Stats.AppExecutionsFailed
+	stored.SubflowExecutions = day.Stats.SubflowExecutions
+	stored.WorkflowExecutions = day.Stats.WorkflowExecutions
+	stored.WorkflowExecutionsFinished = day.Stats.WorkflowExecutionsFinished
+	stored.WorkflowExecutionsFailed = day.Stats.WorkflowExecutionsFailed
+
+	additions := []shuffle.AdditionalUseConfig{}
+	for _, addition := range stored.Additions {
+		if value, ok := day.Additions[addition.Key]; ok {
+			addition.DailyValue = value
+			delete(day.Additions, addition.Key)
+		}
+
+		additions = append(additions, addition)
+	}
+
+	for key, value := range day.Additions {
+		additions = append(additions, shuffle.AdditionalUseConfig{
+			Key:        key,
+			DailyValue: value,
+		})
+	}
+
+	stored.Additions = additions
+	return stored
+}
+
+// Returns empty days from start to end, keyed by date
+func newBackfillDays(start, end time.Time) map[string]*CslBackfillDay {
+	days := map[string]*CslBackfillDay{}
+	for date := start; !date.After(end); date = date.AddDate(0, 0, 1) {
+		additions := map[string]int64{}
+		for _, trigger := range executionTriggers {
+			additions[getTriggerStatKey(trigger)] = 0
+		}
+
+		for _, category := range shuffle.FailureCategories {
+			additions[shuffle.GetFailureStatKey(category)] = 0
+		}
+
+		days[date.Format(UsageDateFormat)] = &CslBackfillDay{
+			Stats:     shuffle.DailyStatistics{Date: date},
+			Additions: additions,
+		}
+	}
+
+	return days
+}
+
+// Merges the days into the org statistics with merge, adding days that
+// aren't stored yet
+func storeBackfillDays(ctx context.Context, orgId string, days map[string]*CslBackfillDay, location *time.Location, merge func(stored shuffle.DailyStatistics, day CslBackfillDay) shuffle.DailyStatistics) error {
+	orgStats, err := shuffle.GetOrgStatistics(ctx, orgId)
+	if err != nil {
+		return err
+	}
+
+	for index, stored := range orgStats.DailyStatistics {
+		date := stored.Date.In(location).Format(UsageDateFormat)
+		if day, ok := days[date]; ok {
+			orgStats.DailyStatistics[index] = merge(stored, *day)
+			delete(days, date)
+		}
+	}
+
+	// Days missing entirely, e.g. when stats collection was down
+	for _, day := range days {
+		orgStats.DailyStatistics = append(orgStats.DailyStatistics, merge(shuffle.DailyStatistics{Date: day.Stats.Date}, *day))
+	}
+
+	sort.SliceStable(orgStats.DailyStatistics, func(i, j int) bool {
+		return orgStats.DailyStatistics[i].Date.Before(orgStats.DailyStatistics[j].Date)
+	})
+
+	if len(orgStats.OrgId) == 0 {
+		orgStats.OrgId = orgId
+	}
+
+	return shuffle.SetOrgStatistics(ctx, *orgStats, orgId)
+}
+
+// Recomputes the daily statistics of the org between start and end from its
+// executions, and stores progress in the stats_backfill document as it goes
+func runStatsBackfill(ctx context.Context, orgId string, start, end time.Time, location *time.Location) error {
+	workflows, err := getOrgWorkflows(ctx, orgId)
+	if err != nil {
+		return err
+	}
+
+	updateCslStatsBackfill(ctx, orgId, func(backfill *CslStatsBackfill) {
+		backfill.Workflows = len(workflows)
+	})
+
+	days := newBackfillDays(start, end)
+	var lock sync.Mutex
+	processed := 0
+	truncated := 0
+	executionCount := int64(0)
+	err = runConcurrentLookups(ctx, len(workflows), func(ctx context.Context, index int) error {
+		executions, err := shuffle.GetAllWorkflowExecutions(ctx, workflows[index].ID, MaxBackfillExecutionsPerWorkflow)
+		if err != nil {
+			return err
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		oldest := time.Now().Unix()
+		for _, execution := range executions {
+			if execution.StartedAt < oldest {
+				oldest = execution.StartedAt
+			}
+
+			day, ok := days[time.Unix(execution.StartedAt, 0).In(location).Format(UsageDateFormat)]
+			if !ok {
+				continue
+			}
+
+			countBackfillExecution(day, execution)
+			executionCount++
+		}
+
+		if len(executions) >= MaxBackfillExecutionsPerWorkflow && oldest > start.Unix() {
+			truncated++
+		}
+
+		processed++
+		if processed%BackfillProgressInterval == 0 {
+			updateCslStatsBackfill(ctx, orgId, func(backfill *CslStatsBackfill) {
+				backfill.ProcessedWorkflows = processed
+				backfill.Executions = executionCount
+				backfill.TruncatedWorkflows = truncated
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	dayCount := len(days)
+	err = storeBackfillDays(ctx, orgId, days, location, mergeBackfillDay)
+	if err != nil {
+		return err
+	}
+
+	updateCslStatsBackfill(ctx, orgId, func(backfill *CslStatsBackfill) {
+		backfill.Status = BackfillFinished
+		backfill.ProcessedWorkflows = processed
+		backfill.Executions = executionCount
+		backfill.TruncatedWorkflows = truncated
+		backfill.DaysUpdated = dayCount
+		backfill.FinishedAt = time.Now().Unix()
+	})
+
+	return nil
+}
+
+/*
+Dashboard:
+Returns the progress of the last statistics backfill for the current
+organization. Status is empty if no backfill has been started.
+
+	{
+	    "success": true,
+	    "data": {
+	        "status": "running",
+	        "start_date": "2024-03-01",
+	        "end_date": "2024-03-31",
+	        "workflows": 40,
+	        "processed_workflows": 20,
+	        "progress": 50,
+	        "executions": 18200,
+	        "days_updated": 0,
+	        "truncated_workflows": 0,
+	        "started_by": "admin",
+	        "started_at": 1712345678,
+	        "finished_at": 0
+	    }
+	}
+*/
+func cslGetStatsBackfill(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslStatsBackfill(ctx, user.ActiveOrg.Id),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetStatsBackfill")
+}
+
+/*
+Dashboard:
+Recomputes the daily statistics of the current organization from its
+executions for a date range, for when counters drifted or statistics weren't
+collected. Requires org admin. Dates are in the statistics timezone, the range
+is at most 90 days and has to end before today. Api usage is kept as it can't
+be found from executions. Runs in the background, use GET for progress.
+
+	{
+	    "start_date": "2024-03-01",
+	    "end_date": "2024-03-31"
+	}
+*/
+func cslStartStatsBackfill(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	backfillRequest := CslStatsBackfillRequest{}
+	err = json.Unmarshal(body, &backfillRequest)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling stats backfill request: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	location := getTimezoneLocation(getCslOrgSettings(ctx, user.ActiveOrg.Id).Timezone)
+	start, startErr := time.ParseInLocation(UsageDateFormat, backfillRequest.StartDate, location)
+	end, endErr := time.ParseInLocation(UsageDateFormat, backfillRequest.EndDate, location)
+	if startErr != nil || endErr != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("start_date and end_date must use the format %s", UsageDateFormat))))
+		return
+	}
+
+	today := time.Now().In(location).Format(UsageDateFormat)
+	if end.Before(start) || end.Format(UsageDateFormat) >= today {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("end_date must be after start_date and before today")))
+		return
+	}
+
+	if end.Sub(start).Hours()/24 >= MaxBackfillDays {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("date range can be at most %d days", MaxBackfillDays))))
+		return
+	}
+
+	backfill := CslStatsBackfill{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslStatsBackfillDocument, &backfill, func() error {
+		if isBackfillRunning(backfill) {
+			return errors.New("a backfill is already running")
+		}
+
+		backfill = CslStatsBackfill{
+			Status:    BackfillRunning,
+			StartDate: backfillRequest.StartDate,
+			EndDate:   backfillRequest.EndDate,
+			StartedBy: user.Username,
+			StartedAt: time.Now().Unix(),
+		}
+
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(409)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) started stats backfill from %s to %s for org %s", user.Username, user.Id, backfillRequest.StartDate, backfillRequest.EndDate, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "stats_backfill_started", fmt.Sprintf("Statistics from %s to %s are being recomputed", backfillRequest.StartDate, backfillRequest.EndDate), user.Username, "")
+
+	orgId := user.ActiveOrg.Id
+	go func() {
+		err := runStatsBackfill(context.Background(), orgId, start, end, location)
+		if err != nil {
+			log.Printf("[ERROR] Stats backfill failed for org %s: %s", orgId, err)
+			updateCslStatsBackfill(context.Background(), orgId, func(backfill *CslStatsBackfill) {
+				backfill.Status = BackfillFailed
+				backfill.Error = err.Error()
+				backfill.FinishedAt = time.Now().Unix()
+			})
+
+			return
+		}
+
+		log.Printf("[INFO] Stats backfill from %s to %s finished for org %s", start.Format(UsageDateFormat), end.Format(UsageDateFormat), orgId)
+	}()
+
+	res := CslResponse{
+		Success: true,
+		Data:    backfill,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslStartStatsBackfill")
+}
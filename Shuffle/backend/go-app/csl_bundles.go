@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+const BundleFormatVersion = 1
+const BundleSignatureAlgorithm = "hmac-sha256"
+
+// Max amount of workflows in a bundle, including subflows
+const MaxBundleWorkflows = 25
+
+// An app a bundled workflow depends on
+type CslBundleApp struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	AppVersion string `json:"app_version"`
+}
+
+// Redacted reference to an authentication used by a bundled workflow.
+// Only the field names are exported, never the values
+type CslBundleAuth struct {
+	Id      string   `json:"id"`
+	Label   string   `json:"label"`
+	AppName string   `json:"app_name"`
+	Fields  []string `json:"fields"`
+}
+
+type CslWorkflowBundle struct {
+	Version    int                `json:"version"`
+	ExportedAt int64              `json:"exported_at"`
+	SourceOrg  string             `json:"source_org"`
+	WorkflowId string             `json:"workflow_id"`
+	Workflows  []shuffle.Workflow `json:"workflows"`
+	Apps       []CslBundleApp     `json:"apps"`
+	Auth       []CslBundleAuth    `json:"auth"`
+}
+
+// The bundle is kept as raw json so the signature is verified over the exact bytes that were signed
+type CslSignedBundle struct {
+	Algorithm string          `json:"algorithm"`
+	Signature string          `json:"signature"`
+	Bundle    json.RawMessage `json:"bundle"`
+}
+
+type CslImportedWorkflow struct {
+	SourceId string `json:"source_id"`
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+}
+
+type CslBundleImportResponse struct {
+	Workflows   []CslImportedWorkflow `json:"workflows"`
+	MissingApps []CslBundleApp        `json:"missing_apps"`
+	MissingAuth []CslBundleAuth       `json:"missing_auth"`
+}
+
+// Bundles are signed with a key shared between the instances that exchange them
+func getBundleSigningKey() ([]byte, error) {
+	key := os.Getenv("SHUFFLE_BUNDLE_SIGNING_KEY")
+	if len(key) == 0 {
+		return []byte{}, errors.New("bundle signing is not configured. Set SHUFFLE_BUNDLE_SIGNING_KEY to the same value on all instances exchanging bundles")
+	}
+
+	return []byte(key), nil
+}
+
+func signBundle(data []byte) (string, error) {
+	key, err := getBundleSigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func verifyBundle(signed CslSignedBundle) error {
+	if signed.Algorithm != BundleSignatureAlgorithm {
+		return errors.New(fmt.Sprintf("unsupported signature algorithm %s", signed.Algorithm))
+	}
+
+	expected, err := signBundle(signed.Bundle)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signed.Signature))) {
+		return errors.New("invalid bundle signature")
+	}
+
+	return nil
+}
+
+func getSubflowIds(workflow shuffle.Workflow) []string {
+	subflowIds := []string{}
+	for _, trigger := range workflow.Triggers {
+		if trigger.TriggerType != "SUBFLOW" {
+			continue
+		}
+
+		for _, param := range trigger.Parameters {
+			if param.Name == "workflow" && len(param.Value) > 0 && param.Value != workflow.ID {
+				subflowIds = append(subflowIds, param.Value)
+			}
+		}
+	}
+
+	return subflowIds
+}
+
+// Builds a bundle from a workflow, its subflows and the apps and auth they use
+func createWorkflowBundle(ctx context.Context, user shuffle.User, workflow shuffle.Workflow) (CslWorkflowBundle, error) {
+	bundle := CslWorkflowBundle{
+		Version:    BundleFormatVersion,
+		ExportedAt: time.Now().Unix(),
+		SourceOrg:  user.ActiveOrg.Id,
+		WorkflowId: workflow.ID,
+		Workflows:  []shuffle.Workflow{},
+		Apps:       []CslBundleApp{},
+		Auth:       []CslBundleAuth{},
+	}
+
+	handled := map[string]bool{}
+	queue := []shuffle.Workflow{workflow}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if handled[current.ID] {
+			continue
+		}
+
+		if len(bundle.Workflows) >= MaxBundleWorkflows {
+			return bundle, errors.New(fmt.Sprintf("bundle can't contain more than %d workflows", MaxBundleWorkflows))
+		}
+
+		handled[current.ID] = true
+		bundle.Workflows = append(bundle.Workflows, current)
+
+		for _, subflowId := range getSubflowIds(current) {
+			if handled[subflowId] {
+				continue
+			}
+
+			subflow, err := getCslWorkflow(ctx, user, subflowId)
+			if err != nil {
+				log.Printf("[WARNING] Skipping subflow %s of workflow %s in bundle: %s", subflowId, current.ID, err)
+				continue
+			}
+
+			queue = append(queue, *subflow)
+		}
+	}
+
+	authIds := map[string]bool{}
+	appKeys := map[string]bool{}
+	for _, bundled := range bundle.Workflows {
+		for _, action := range bundled.Actions {
+			appKey := fmt.Sprintf("%s_%s", action.AppName, action.AppVersion)
+			if !appKeys[appKey] {
+				appKeys[appKey] = true
+				bundle.Apps = append(bundle.Apps, CslBundleApp{Id: action.AppID, Name: action.AppName, AppVersion: action.AppVersion})
+			}
+
+			if len(action.AuthenticationId) > 0 {
+				authIds[action.AuthenticationId] = true
+			}
+		}
+	}
+
+	if len(authIds) > 0 {
+		auths, err := shuffle.GetAllWorkflowAppAuth(ctx, user.ActiveOrg.Id)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting auth for bundle of workflow %s: %s", workflow.ID, err)
+		}
+
+		for _, auth := range auths {
+			if !authIds[auth.Id] {
+				continue
+			}
+
+			fields := []string{}
+			for _, field := range auth.Fields {
+				fields = append(fields, field.Key)
+			}
+
+			bundle.Auth = append(bundle.Auth, CslBundleAuth{Id: auth.Id, Label: auth.Label, AppName: auth.App.Name, Fields: fields})
+		}
+	}
+
+	return bundle, nil
+}
+
+func findMissingApps(ctx context.Context, user shuffle.User, apps []CslBundleApp) []CslBundleApp {
+	missing := []CslBundleApp{}
+	availableApps, err := shuffle.GetPrioritizedApps(ctx, user)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting apps for bundle import: %s", err)
+		return apps
+	}
+
+	for _, app := range apps {
+		found := false
+		for _, availableApp := range availableApps {
+			if availableApp.ID == app.Id || (strings.EqualFold(availableApp.Name, app.Name) && availableApp.AppVersion == app.AppVersion) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			missing = append(missing, app)
+		}
+	}
+
+	return missing
+}
+
+// Maps bundled auth to auth in the target org with the same app and label
+func mapBundleAuth(ctx context.Context, orgId string, bundleAuth []CslBundleAuth) (map[string]string, []CslBundleAuth) {
+	authMapping := map[string]string{}
+	missing := []CslBundleAuth{}
+
+	auths, err := shuffle.GetAllWorkflowAppAuth(ctx, orgId)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting auth for bundle import in org %s: %s", orgId, err)
+	}
+
+	for _, bundled := range bundleAuth {
+		found := false
+		for _, auth := range auths {
+			if auth.Label == bundled.Label && strings.EqualFold(auth.App.Name, bundled.AppName) {
+				authMapping[bundled.Id] = auth.Id
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			missing = append(missing, bundled)
+		}
+	}
+
+	return authMapping, missing
+}
+
+// Rewrites a bundled workflow for the target org. Workflow and trigger ids are
+// replaced so the import never collides with the source
+func prepareImportedWorkflow(workflow shuffle.Workflow, user shuffle.User, workflowMapping, authMapping map[string]string) shuffle.Workflow {
+	workflow.ID = workflowMapping[workflow.ID]
+	workflow.OrgId = user.ActiveOrg.Id
+	workflow.Owner = user.Id
+	workflow.Org = []shuffle.OrgMini{}
+	workflow.ExecutingOrg = shuffle.OrgMini{Id: user.ActiveOrg.Id}
+	workflow.Created = 0
+	workflow.UpdatedBy = user.Username
+	workflow.Public = false
+	workflow.Sharing = "private"
+
+	for i, action := range workflow.Actions {
+		if len(action.AuthenticationId) > 0 {
+			workflow.Actions[i].AuthenticationId = authMapping[action.AuthenticationId]
+		}
+	}
+
+	triggerMapping := map[string]string{}
+	for i, trigger := range workflow.Triggers {
+		newId := uuid.NewV4().String()
+		triggerMapping[trigger.ID] = newId
+		workflow.Triggers[i].ID = newId
+
+		// Webhooks and schedules have to be started again in the new org
+		if trigger.TriggerType != "SUBFLOW" && trigger.TriggerType != "USERINPUT" {
+			workflow.Triggers[i].Status = "stopped"
+		}
+
+		for j, param := range trigger.Parameters {
+			if trigger.TriggerType == "SUBFLOW" && param.Name == "workflow" {
+				workflow.Triggers[i].Parameters[j].Value = workflowMapping[param.Value]
+			}
+		}
+	}
+
+	for i, branch := range workflow.Branches {
+		if newId, ok := triggerMapping[branch.SourceID]; ok {
+			workflow.Branches[i].SourceID = newId
+		}
+
+		if newId, ok := triggerMapping[branch.DestinationID]; ok {
+			workflow.Branches[i].DestinationID = newId
+		}
+	}
+
+	return workflow
+}
+
+/*
+Bundles:
+Exports a workflow with its subflows, app dependencies and redacted auth
+placeholders as a signed bundle. Requires ?workflow_id=<id>.
+The signature uses SHUFFLE_BUNDLE_SIGNING_KEY, which must be the same on the
+instance importing the bundle.
+
+	{
+	    "success": true,
+	    "data": {
+	        "algorithm": "hmac-sha256",
+	        "signature": "9f86d0...",
+	        "bundle": {
+	            "version": 1,
+	            "exported_at": 1700000000,
+	            "source_org": "...",
+	            "workflow_id": "...",
+	            "workflows": [...],
+	            "apps": [
+	                {
+	                    "id": "...",
+	                    "name": "VirusTotal",
+	                    "app_version": "1.0.0"
+	                }
+	            ],
+	            "auth": [
+	                {
+	                    "id": "...",
+	                    "label": "VirusTotal prod",
+	                    "app_name": "VirusTotal",
+	                    "fields": ["apikey"]
+	                }
+	            ]
+	        }
+	    }
+	}
+*/
+func cslExportBundle(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	workflow, err := getCslWorkflow(ctx, *user, request.URL.Query().Get("workflow_id"))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	bundle, err := createWorkflowBundle(ctx, *user, *workflow)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	bundleData, err := json.Marshal(bundle)
+	if err != nil {
+		log.Printf("[ERROR] Failed marshalling bundle for workflow %s: %s", workflow.ID, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	signature, err := signBundle(bundleData)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) exported bundle of workflow %s with %d workflows", user.Username, user.Id, workflow.ID, len(bundle.Workflows))
+
+	res := CslResponse{
+		Success: true,
+		Data: CslSignedBundle{
+			Algorithm: BundleSignatureAlgorithm,
+			Signature: signature,
+			Bundle:    bundleData,
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslExportBundle")
+}
+
+/*
+Bundles:
+Imports a signed bundle into the current organization. The body is the data
+field returned from export. All workflows get new ids. Auth is mapped to
+existing auth with the same app and label, and anything that couldn't be
+mapped is returned so it can be configured after import.
+
+	{
+	    "success": true,
+	    "data": {
+	        "workflows": [
+	            {
+	                "source_id": "...",
+	                "id": "...",
+	                "name": "Phishing triage"
+	            }
+	        ],
+	        "missing_apps": [],
+	        "missing_auth": [...]
+	    }
+	}
+*/
+func cslImportBundle(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if user.Role == "org-reader" {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("read only user")))
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	signed := CslSignedBundle{}
+	err = json.Unmarshal(body, &signed)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	err = verifyBundle(signed)
+	if err != nil {
+		log.Printf("[WARNING] User %s (%s) tried importing a bundle that failed verification: %s", user.Username, user.Id, err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	bundle := CslWorkflowBundle{}
+	err = json.Unmarshal(signed.Bundle, &bundle)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if bundle.Version != BundleFormatVersion {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("unsupported bundle version %d", bundle.Version))))
+		return
+	}
+
+	if len(bundle.Workflows) == 0 || len(bundle.Workflows) > MaxBundleWorkflows {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("bundle has an invalid amount of workflows")))
+		return
+	}
+
+	workflowMapping := map[string]string{}
+	for _, workflow := range bundle.Workflows {
+		workflowMapping[workflow.ID] = uuid.NewV4().String()
+	}
+
+	authMapping, missingAuth := mapBundleAuth(ctx, user.ActiveOrg.Id, bundle.Auth)
+
+	response := CslBundleImportResponse{
+		Workflows:   []CslImportedWorkflow{},
+		MissingApps: findMissingApps(ctx, *user, bundle.Apps),
+		MissingAuth: missingAuth,
+	}
+
+	for _, workflow := range bundle.Workflows {
+		sourceId := workflow.ID
+		imported := prepareImportedWorkflow(workflow, *user, workflowMapping, authMapping)
+
+		err = shuffle.SetWorkflow(ctx, imported, imported.ID)
+		if err != nil {
+			log.Printf("[ERROR] Failed importing workflow %s from bundle: %s", sourceId, err)
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		response.Workflows = append(response.Workflows, CslImportedWorkflow{SourceId: sourceId, Id: imported.ID, Name: imported.Name})
+		recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeWorkflow, "imported", fmt.Sprintf("Workflow %s was imported from a bundle", imported.Name), user.Username, imported.ID)
+		emitCslLifecycleEvent(user.ActiveOrg.Id, LifecycleWorkflowCreated, user.Username, CslLifecycleWorkflow{WorkflowId: imported.ID, Name: imported.Name})
+	}
+
+	log.Printf("[AUDIT] User %s (%s) imported bundle from org %s with %d workflows into org %s", user.Username, user.Id, bundle.SourceOrg, len(response.Workflows), user.ActiveOrg.Id)
+
+	res := CslResponse{
+		Success: true,
+		Data:    response,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslImportBundle")
+}
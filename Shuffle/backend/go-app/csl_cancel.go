@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Bulk cancellation of queued and running executions, e.g. after a
+// misconfigured trigger flooded the queue. Executions are aborted through the
+// regular abort handler with the credentials of the caller, so they end up
+// the same as when aborted one by one.
+
+// Unfinished executions are looked up per workflow, up to 1000 each
+const MaxBulkCancelExecutions = 5000
+
+// Execution ids returned in the response
+const MaxBulkCancelIds = 100
+
+type CslBulkCancelRequest struct {
+	WorkflowIds []string `json:"workflow_ids"`
+	Since       int64    `json:"since"`
+	Until       int64    `json:"until"`
+	Trigger     string   `json:"trigger"`
+	Reason      string   `json:"reason"`
+	DryRun      bool     `json:"dry_run"`
+}
+
+type CslBulkCancelWorkflow struct {
+	WorkflowId string `json:"workflow_id"`
+	Name       string `json:"name"`
+	Matched    int    `json:"matched"`
+}
+
+type CslBulkCancelResult struct {
+	DryRun       bool                    `json:"dry_run"`
+	Matched      int                     `json:"matched"`
+	Cancelled    int                     `json:"cancelled"`
+	Failed       int                     `json:"failed"`
+	Truncated    bool                    `json:"truncated"`
+	Workflows    []CslBulkCancelWorkflow `json:"workflows"`
+	ExecutionIds []string                `json:"execution_ids"`
+}
+
+func matchesBulkCancel(execution shuffle.WorkflowExecution, filters CslBulkCancelRequest) bool {
+	if execution.Status != "EXECUTING" {
+		return false
+	}
+
+	if filters.Since > 0 && execution.StartedAt < filters.Since {
+		return false
+	}
+
+	if filters.Until > 0 && execution.StartedAt > filters.Until {
+		return false
+	}
+
+	if len(filters.Trigger) > 0 && getExecutionTrigger(execution) != filters.Trigger {
+		return false
+	}
+
+	return true
+}
+
+// Aborts an execution through the abort handler with the query parameters
+// of the abort endpoint. The abort is authenticated as the source request, or
+// with the execution authorization without one
+func abortCslExecution(ctx context.Context, source *http.Request, execution shuffle.WorkflowExecution, query url.Values) error {
+	abortUrl := fmt.Sprintf("/api/v1/workflows/%s/executions/%s/abort?%s", execution.Workflow.ID, execution.ExecutionId, query.Encode())
+	abortRequest, err := http.NewRequestWithContext(ctx, "GET", abortUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	if source != nil {
+		abortRequest.Header.Set("Authorization", source.Header.Get("Authorization"))
+		abortRequest.Header.Set("Org-Id", getCslRequestOrgId(source))
+		for _, cookie := range source.Cookies() {
+			abortRequest.AddCookie(cookie)
+		}
+	} else {
+		abortRequest.Header.Set("Authorization", fmt.Sprintf("Bearer %s", execution.Authorization))
+	}
+
+	recorder := httptest.NewRecorder()
+	cslKafkaOnAbort(shuffle.AbortExecution)(recorder, abortRequest)
+	if recorder.Code != 200 {
+		return errors.New(fmt.Sprintf("abort failed with status %d: %s", recorder.Code, recorder.Body.String()))
+	}
+
+	return nil
+}
+
+// Removes cancelled executions from the Orborus queues, so they aren't
+// started after being aborted
+func removeFromCslQueues(ctx context.Context, executions []shuffle.WorkflowExecution) {
+	environments := map[string][]string{}
+	for _, execution := range executions {
+		for _, environment := range getWorkflowEnvironments(execution.Workflow) {
+			environments[environment] = append(environments[environment], execution.ExecutionId)
+		}
+	}
+
+	for environment, ids := range environments {
+		queueName := fmt.Sprintf("workflowqueue-%s", strings.ReplaceAll(environment, " ", "-"))
+		err := shuffle.DeleteKeys(ctx, queueName, ids)
+		if err != nil {
+			log.Printf("[WARNING] Failed removing %d cancelled executions from the queue of environment %s: %s", len(ids), environment, err)
+		}
+	}
+}
+
+/*
+Executions:
+Cancels the queued and running executions of the current organization that
+match all filters. Requires org admin. Without workflow_ids every workflow is
+included. since and until are unix timestamps of when executions started, and
+trigger is one of webhook, schedule, manual, subflow or other. With dry_run
+nothing is cancelled and the matching executions are only counted.
+
+	{
+	    "workflow_ids": ["5ec3..."],
+	    "since": 1718000000,
+	    "until": 1718003600,
+	    "trigger": "webhook",
+	    "reason": "Webhook flood from misconfigured SIEM rule",
+	    "dry_run": true
+	}
+
+Response:
+
+	{
+	    "success": true,
+	    "data": {
+	        "dry_run": true,
+	        "matched": 4210,
+	        "cancelled": 0,
+	        "failed": 0,
+	        "truncated": false,
+	        "workflows": [
+	            {
+	                "workflow_id": "5ec3...",
+	                "name": "Enrich alerts",
+	                "matched": 4210
+	            }
+	        ],
+	        "execution_ids": ["8f0c...", "..."]
+	    }
+	}
+*/
+func cslBulkCancelExecutions(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	filters := CslBulkCancelRequest{}
+	err = json.Unmarshal(body, &filters)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if len(filters.Trigger) > 0 && !shuffle.ArrayContains(executionTriggers, filters.Trigger) {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("unknown trigger %s. Use one of %s", filters.Trigger, strings.Join(executionTriggers, ", ")))))
+		return
+	}
+
+	if filters.Since > 0 && filters.Until > 0 && filters.Since > filters.Until {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("since can't be after until")))
+		return
+	}
+
+	orgWorkflows, err := getOrgWorkflows(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	workflows := []shuffle.Workflow{}
+	for _, workflow := range orgWorkflows {
+		if len(filters.WorkflowIds) == 0 || shuffle.ArrayContains(filters.WorkflowIds, workflow.ID) {
+			workflows = append(workflows, workflow)
+		}
+	}
+
+	if len(filters.WorkflowIds) > 0 && len(workflows) != len(filters.WorkflowIds) {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("one or more workflows weren't found")))
+		return
+	}
+
+	workflowExecutions := make([][]shuffle.WorkflowExecution, len(workflows))
+	runConcurrentLookups(ctx, len(workflows), func(ctx context.Context, index int) error {
+		executions, err := shuffle.GetUnfinishedExecutions(ctx, workflows[index].ID)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting unfinished executions of workflow %s for bulk cancel: %s", workflows[index].ID, err)
+			return nil
+		}
+
+		workflowExecutions[index] = executions
+		return nil
+	})
+
+	result := CslBulkCancelResult{
+		DryRun:       filters.DryRun,
+		Workflows:    []CslBulkCancelWorkflow{},
+		ExecutionIds: []string{},
+	}
+
+	matched := []shuffle.WorkflowExecution{}
+	for index, workflow := range workflows {
+		workflowMatches := 0
+		for _, execution := range workflowExecutions[index] {
+			if !matchesBulkCancel(execution, filters) {
+				continue
+			}
+
+			if len(matched) >= MaxBulkCancelExecutions {
+				result.Truncated = true
+				break
+			}
+
+			matched = append(matched, execution)
+			workflowMatches += 1
+		}
+
+		if workflowMatches > 0 {
+			result.Workflows = append(result.Workflows, CslBulkCancelWorkflow{
+				WorkflowId: workflow.ID,
+				Name:       workflow.Name,
+				Matched:    workflowMatches,
+			})
+		}
+	}
+
+	result.Matched = len(matched)
+	for _, execution := range matched {
+		if len(result.ExecutionIds) >= MaxBulkCancelIds {
+			break
+		}
+
+		result.ExecutionIds = append(result.ExecutionIds, execution.ExecutionId)
+	}
+
+	if filters.DryRun || len(matched) == 0 {
+		res := CslResponse{
+			Success: true,
+			Data:    result,
+		}
+
+		marshalAndWriteResponse(resp, res, "cslBulkCancelExecutions")
+		return
+	}
+
+	reason := filters.Reason
+	if len(reason) == 0 {
+		reason = fmt.Sprintf("Cancelled in bulk by %s", user.Username)
+	}
+
+	cancelled := []shuffle.WorkflowExecution{}
+	for _, execution := range matched {
+		err = abortCslExecution(request.Context(), request, execution, url.Values{"reason": {reason}})
+		if err != nil {
+			log.Printf("[WARNING] Failed cancelling execution %s in bulk: %s", execution.ExecutionId, err)
+			result.Failed += 1
+			continue
+		}
+
+		cancelled = append(cancelled, execution)
+	}
+
+	result.Cancelled = len(cancelled)
+	removeFromCslQueues(ctx, cancelled)
+
+	log.Printf("[AUDIT] User %s (%s) cancelled %d executions in bulk in org %s (%d failed). Filters: %+v", user.Username, user.Id, result.Cancelled, user.ActiveOrg.Id, result.Failed, filters)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "executions_cancelled", fmt.Sprintf("%d executions were cancelled in bulk", result.Cancelled), user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    result,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslBulkCancelExecutions")
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Fault injection for resilience testing. With SHUFFLE_CHAOS=true, org
+// admins can make the backend misbehave for their own org only: database
+// calls made for its requests, executions put in environment queues and
+// action results from its apps get latency and fail at the given rates.
+// Faults stop when they expire, at most a day after they were set, so a
+// forgotten test doesn't keep failing. Never set SHUFFLE_CHAOS in production.
+//
+// Injected app failures look like the app returned an HTTP error, so they
+// are categorized and retried like real ones.
+
+const CslChaosDocument = "chaos"
+
+const DefaultChaosDurationMinutes = 60
+const MaxChaosDurationMinutes = 24 * 60
+const MaxFaultLatencyMs = 30000
+
+// Status of injected app failures, 503 is categorized as app_crash
+const DefaultAppFaultStatus = 503
+
+// Where faults are injected
+const (
+	FaultTargetDatastore = "datastore"
+	FaultTargetDispatch  = "dispatch"
+	FaultTargetApps      = "apps"
+)
+
+// Each call waits latency_ms plus up to jitter_ms, and fails with
+// probability error_rate
+type CslFault struct {
+	LatencyMs int     `json:"latency_ms"`
+	JitterMs  int     `json:"jitter_ms"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+type CslAppFault struct {
+	CslFault
+	Status int `json:"status"`
+}
+
+type CslChaosSettings struct {
+	Enabled         bool        `json:"enabled"`
+	DurationMinutes int         `json:"duration_minutes"`
+	ExpiresAt       int64       `json:"expires_at"`
+	Datastore       CslFault    `json:"datastore"`
+	Dispatch        CslFault    `json:"dispatch"`
+	Apps            CslAppFault `json:"apps"`
+	UpdatedBy       string      `json:"updated_by"`
+	Updated         int64       `json:"updated"`
+}
+
+type CslChaosStatus struct {
+	Active   bool             `json:"active"`
+	Settings CslChaosSettings `json:"settings"`
+	Injected map[string]int64 `json:"injected"`
+}
+
+// Faults injected per org and target on this replica since it started.
+// Datastore faults are counted by the shared library
+var cslChaosFaults = struct {
+	sync.Mutex
+	counts map[string]map[string]int64
+}{
+	counts: map[string]map[string]int64{},
+}
+
+func chaosEnabled() bool {
+	return os.Getenv("SHUFFLE_CHAOS") == "true"
+}
+
+func checkChaosEnabled(resp http.ResponseWriter) bool {
+	if chaosEnabled() {
+		return true
+	}
+
+	resp.WriteHeader(403)
+	resp.Write(createCslErrorResponse(errors.New("fault injection is disabled. Set SHUFFLE_CHAOS=true to enable it")))
+	return false
+}
+
+func getCslChaosSettings(ctx context.Context, orgId string) CslChaosSettings {
+	settings := CslChaosSettings{}
+	_, err := getCslDocument(ctx, orgId, CslChaosDocument, &settings)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting fault injection settings for org %s: %s", orgId, err)
+	}
+
+	return settings
+}
+
+func (settings CslChaosSettings) isActive() bool {
+	return chaosEnabled() && settings.Enabled && time.Now().Unix() < settings.ExpiresAt
+}
+
+// Returns the active fault injection settings of the org, if any
+func getActiveCslChaos(ctx context.Context, orgId string) (CslChaosSettings, bool) {
+	if !chaosEnabled() || len(orgId) == 0 {
+		return CslChaosSettings{}, false
+	}
+
+	settings := getCslChaosSettings(ctx, orgId)
+	return settings, settings.isActive()
+}
+
+func (fault CslFault) isEmpty() bool {
+	return fault.LatencyMs == 0 && fault.JitterMs == 0 && fault.ErrorRate == 0
+}
+
+func validateFault(name string, fault CslFault) error {
+	if fault.LatencyMs < 0 || fault.LatencyMs > MaxFaultLatencyMs {
+		return errors.New(fmt.Sprintf("latency_ms of %s must be between 0 and %d", name, MaxFaultLatencyMs))
+	}
+
+	if fault.JitterMs < 0 || fault.JitterMs > MaxFaultLatencyMs {
+		return errors.New(fmt.Sprintf("jitter_ms of %s must be between 0 and %d", name, MaxFaultLatencyMs))
+	}
+
+	if fault.ErrorRate < 0 || fault.ErrorRate > 1 {
+		return errors.New(fmt.Sprintf("error_rate of %s must be between 0 and 1", name))
+	}
+
+	return nil
+}
+
+// Waits the latency of the fault and returns whether the call should fail
+func injectCslFault(ctx context.Context, fault CslFault) bool {
+	delay := time.Duration(fault.LatencyMs) * time.Millisecond
+	if fault.JitterMs > 0 {
+		delay += time.Duration(rand.Intn(fault.JitterMs+1)) * time.Millisecond
+	}
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+
+	return fault.ErrorRate > 0 && rand.Float64() < fault.ErrorRate
+}
+
+func countCslChaosFault(orgId, target string) {
+	cslChaosFaults.Lock()
+	defer cslChaosFaults.Unlock()
+
+	if _, ok := cslChaosFaults.counts[orgId]; !ok {
+		cslChaosFaults.counts[orgId] = map[string]int64{}
+	}
+
+	cslChaosFaults.counts[orgId][target] += 1
+}
+
+func getCslChaosFaults(orgId string) map[string]int64 {
+	cslChaosFaults.Lock()
+	defer cslChaosFaults.Unlock()
+
+	return map[string]int64{
+		FaultTargetDatastore: shuffle.GetInjectedDatastoreFaults(orgId),
+		FaultTargetDispatch:  cslChaosFaults.counts[orgId][FaultTargetDispatch],
+		FaultTargetApps:      cslChaosFaults.counts[orgId][FaultTargetApps],
+	}
+}
+
+// Called before an execution of the org is put in an environment queue
+func injectCslDispatchFault(ctx context.Context, orgId string) error {
+	settings, active := getActiveCslChaos(ctx, orgId)
+	if !active || settings.Dispatch.isEmpty() {
+		return nil
+	}
+
+	if !injectCslFault(ctx, settings.Dispatch) {
+		return nil
+	}
+
+	countCslChaosFault(orgId, FaultTargetDispatch)
+	log.Printf("[DEBUG] Injected dispatch fault for org %s", orgId)
+	return errors.New("injected dispatch fault")
+}
+
+// Called with action results before retries are handled. Turns successful
+// results into failures with the status of the app fault, as if the app had
+// returned an HTTP error
+func injectCslAppFault(ctx context.Context, execution shuffle.WorkflowExecution, actionResult *shuffle.ActionResult) {
+	if actionResult.Status != "SUCCESS" || len(actionResult.Action.ID) == 0 {
+		return
+	}
+
+	settings, active := getActiveCslChaos(ctx, execution.ExecutionOrg)
+	if !active || settings.Apps.isEmpty() {
+		return
+	}
+
+	if !injectCslFault(ctx, settings.Apps.CslFault) {
+		return
+	}
+
+	status := settings.Apps.Status
+	if status == 0 {
+		status = DefaultAppFaultStatus
+	}
+
+	result, err := json.Marshal(shuffle.HTTPOutput{
+		Success: false,
+		Status:  status,
+		Body:    map[string]interface{}{"error": fmt.Sprintf("Injected fault: %s", http.StatusText(status))},
+	})
+	if err != nil {
+		return
+	}
+
+	actionResult.Status = "FAILURE"
+	actionResult.Result = string(result)
+
+	countCslChaosFault(execution.ExecutionOrg, FaultTargetApps)
+	log.Printf("[DEBUG] Injected %d app fault in node %s of execution %s", status, actionResult.Action.ID, execution.ExecutionId)
+}
+
+// Adds the datastore fault of the org to the request context, so database
+// calls made with the context of the request are faulted. The chaos
+// endpoints are left alone so faults can always be turned off
+func cslChaosMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, request *http.Request) {
+		if !chaosEnabled() || request.Method == "OPTIONS" || request.URL.Path == "/api/v1/csl/chaos" {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		user, err := getMiddlewareUser(resp, request)
+		if err != nil || len(user.ActiveOrg.Id) == 0 {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		settings, active := getActiveCslChaos(shuffle.GetContext(request), user.ActiveOrg.Id)
+		if !active || settings.Datastore.isEmpty() {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		ctx := shuffle.WithDatastoreFault(request.Context(), shuffle.DatastoreFault{
+			OrgId:     user.ActiveOrg.Id,
+			LatencyMs: settings.Datastore.LatencyMs,
+			JitterMs:  settings.Datastore.JitterMs,
+			ErrorRate: settings.Datastore.ErrorRate,
+		})
+
+		next.ServeHTTP(resp, request.WithContext(ctx))
+	})
+}
+
+/*
+Fault injection:
+Returns the fault injection settings of the current organization, whether
+they are active and the faults injected so far on this replica. Requires
+org admin and SHUFFLE_CHAOS=true.
+
+	{
+	    "success": true,
+	    "data": {
+	        "active": true,
+	        "settings": {
+	            "enabled": true,
+	            "duration_minutes": 60,
+	            "expires_at": 1712349278,
+	            "datastore": {"latency_ms": 200, "jitter_ms": 300, "error_rate": 0.05},
+	            "dispatch": {"latency_ms": 0, "jitter_ms": 0, "error_rate": 0.1},
+	            "apps": {"latency_ms": 0, "jitter_ms": 1000, "error_rate": 0.2, "status": 504},
+	            "updated_by": "admin",
+	            "updated": 1712345678
+	        },
+	        "injected": {
+	            "datastore": 31,
+	            "dispatch": 4,
+	            "apps": 18
+	        }
+	    }
+	}
+*/
+func cslGetChaos(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !checkChaosEnabled(resp) {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	settings := getCslChaosSettings(ctx, user.ActiveOrg.Id)
+
+	res := CslResponse{
+		Success: true,
+		Data: CslChaosStatus{
+			Active:   settings.isActive(),
+			Settings: settings,
+			Injected: getCslChaosFaults(user.ActiveOrg.Id),
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetChaos")
+}
+
+/*
+Fault injection:
+Sets the fault injection settings of the current organization in the format
+of settings from GET. Faults stop after duration_minutes (default 60, max
+1440), and {"enabled": false} stops them right away. The status of app
+faults decides their failure category, e.g. 504 for timeout and 401 for
+auth (default 503, app_crash). Requires org admin and SHUFFLE_CHAOS=true.
+*/
+func cslSetChaos(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !checkChaosEnabled(resp) {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	settings := CslChaosSettings{}
+	err = json.Unmarshal(body, &settings)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if settings.DurationMinutes == 0 {
+		settings.DurationMinutes = DefaultChaosDurationMinutes
+	}
+
+	if settings.DurationMinutes < 0 || settings.DurationMinutes > MaxChaosDurationMinutes {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("duration_minutes must be between 1 and %d", MaxChaosDurationMinutes))))
+		return
+	}
+
+	faults := map[string]CslFault{
+		FaultTargetDatastore: settings.Datastore,
+		FaultTargetDispatch:  settings.Dispatch,
+		FaultTargetApps:      settings.Apps.CslFault,
+	}
+
+	for name, fault := range faults {
+		err = validateFault(name, fault)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+	}
+
+	if settings.Apps.Status != 0 && (settings.Apps.Status < 400 || settings.Apps.Status > 599) {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("status of apps must be between 400 and 599")))
+		return
+	}
+
+	settings.Updated = time.Now().Unix()
+	settings.UpdatedBy = user.Username
+	settings.ExpiresAt = 0
+	if settings.Enabled {
+		settings.ExpiresAt = settings.Updated + int64(settings.DurationMinutes*60)
+	}
+
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslChaosDocument, settings)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if settings.Enabled {
+		log.Printf("[AUDIT] User %s (%s) enabled fault injection in org %s for %d minutes. Datastore: %#v, dispatch: %#v, apps: %#v", user.Username, user.Id, user.ActiveOrg.Id, settings.DurationMinutes, settings.Datastore, settings.Dispatch, settings.Apps)
+		recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "chaos_enabled", "Fault injection was enabled", user.Username, "")
+	} else {
+		log.Printf("[AUDIT] User %s (%s) disabled fault injection in org %s", user.Username, user.Id, user.ActiveOrg.Id)
+		recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "chaos_disabled", "Fault injection was disabled", user.Username, "")
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data: CslChaosStatus{
+			Active:   settings.isActive(),
+			Settings: settings,
+			Injected: getCslChaosFaults(user.ActiveOrg.Id),
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetChaos")
+}
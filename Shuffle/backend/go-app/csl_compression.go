@@ -0,0 +1,163 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Content types that are already compressed or are streamed to the client as is
+var uncompressedContentTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"application/zip",
+	"application/gzip",
+	"application/octet-stream",
+	"text/event-stream",
+}
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	},
+}
+
+// Picks gzip or deflate from Accept-Encoding, preferring gzip
+func getResponseEncoding(request *http.Request) string {
+	accepted := map[string]bool{}
+	for _, encoding := range strings.Split(request.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(strings.TrimSpace(encoding), ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+
+		// Encodings with q=0 are explicitly not accepted
+		if len(parts) > 1 && strings.ReplaceAll(parts[1], " ", "") == "q=0" {
+			continue
+		}
+
+		accepted[name] = true
+	}
+
+	if accepted["gzip"] {
+		return "gzip"
+	}
+
+	if accepted["deflate"] {
+		return "deflate"
+	}
+
+	return ""
+}
+
+func isCompressibleContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, uncompressed := range uncompressedContentTypes {
+		if strings.HasPrefix(contentType, uncompressed) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Compresses the response once the handler writes the header, unless the
+// handler already encoded it or the content type doesn't compress
+type cslCompressionWriter struct {
+	http.ResponseWriter
+	encoding    string
+	writer      io.WriteCloser
+	wroteHeader bool
+}
+
+func (writer *cslCompressionWriter) WriteHeader(status int) {
+	if writer.wroteHeader {
+		return
+	}
+
+	writer.wroteHeader = true
+
+	header := writer.Header()
+	header.Add("Vary", "Accept-Encoding")
+	if status == http.StatusNoContent || status == http.StatusNotModified || len(header.Get("Content-Encoding")) > 0 || !isCompressibleContentType(header.Get("Content-Type")) {
+		writer.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	header.Set("Content-Encoding", writer.encoding)
+	header.Del("Content-Length")
+
+	if writer.encoding == "gzip" {
+		gzipWriter := gzipWriterPool.Get().(*gzip.Writer)
+		gzipWriter.Reset(writer.ResponseWriter)
+		writer.writer = gzipWriter
+	} else {
+		flateWriter, _ := flate.NewWriter(writer.ResponseWriter, flate.DefaultCompression)
+		writer.writer = flateWriter
+	}
+
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *cslCompressionWriter) Write(data []byte) (int, error) {
+	if !writer.wroteHeader {
+		writer.WriteHeader(http.StatusOK)
+	}
+
+	if writer.writer == nil {
+		return writer.ResponseWriter.Write(data)
+	}
+
+	return writer.writer.Write(data)
+}
+
+func (writer *cslCompressionWriter) Flush() {
+	if gzipWriter, ok := writer.writer.(*gzip.Writer); ok {
+		gzipWriter.Flush()
+	} else if flateWriter, ok := writer.writer.(*flate.Writer); ok {
+		flateWriter.Flush()
+	}
+
+	if flusher, ok := writer.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (writer *cslCompressionWriter) Close() {
+	if writer.writer == nil {
+		return
+	}
+
+	writer.writer.Close()
+	if gzipWriter, ok := writer.writer.(*gzip.Writer); ok {
+		gzipWriter.Reset(io.Discard)
+		gzipWriterPool.Put(gzipWriter)
+	}
+}
+
+// Middleware compressing API responses with gzip or deflate when the client
+// accepts it. Disable with SHUFFLE_COMPRESSION_DISABLED=true
+func cslCompressionMiddleware(next http.Handler) http.Handler {
+	disabled := os.Getenv("SHUFFLE_COMPRESSION_DISABLED") == "true"
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, request *http.Request) {
+		if disabled || !strings.HasPrefix(request.URL.Path, "/api/") || request.Method == "HEAD" || len(request.Header.Get("Upgrade")) > 0 {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		encoding := getResponseEncoding(request)
+		if len(encoding) == 0 {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		writer := &cslCompressionWriter{ResponseWriter: resp, encoding: encoding}
+		defer writer.Close()
+
+		next.ServeHTTP(writer, request)
+	})
+}
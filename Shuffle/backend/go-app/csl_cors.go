@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslCorsDocument = "cors"
+
+// How often origins configured by orgs are reloaded into memory
+const CorsOriginRefreshMinutes = 5
+
+const DefaultCorsMethods = "POST, GET, PUT, DELETE, PATCH"
+const DefaultCorsHeaders = "Content-Type, Accept, X-Requested-With, remember-me, Org-Id, Authorization, X-Debug-Url, Idempotency-Key"
+
+var corsResponseHeaders = []string{
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Headers",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Allow-Credentials",
+}
+
+// Extra origins an org allows in addition to SHUFFLE_CORS_ALLOWED_ORIGINS
+type CslCorsSettings struct {
+	AllowedOrigins []string `json:"allowed_origins"`
+}
+
+type cslCorsPolicy struct {
+	Origins []string
+	Methods string
+	Headers string
+}
+
+// Origins from all orgs, kept in memory since preflight requests
+// carry no credentials to tell which org they are for
+var cslCorsOrgOrigins = struct {
+	sync.Mutex
+	origins []string
+	loaded  time.Time
+	loading bool
+}{}
+
+func splitCorsList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Origins are either * or [scheme://]host[:port], where host may start
+// with *. to allow any subdomain
+func validateCorsOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+
+	host := origin
+	if strings.Contains(origin, "://") {
+		parsedUrl, err := url.Parse(origin)
+		if err != nil || len(parsedUrl.Host) == 0 || (len(parsedUrl.Path) > 0 && parsedUrl.Path != "/") {
+			return errors.New(fmt.Sprintf("invalid origin %s", origin))
+		}
+
+		host = parsedUrl.Host
+	}
+
+	host = strings.TrimPrefix(host, "*.")
+	if len(host) == 0 || strings.ContainsAny(host, "*/ ") {
+		return errors.New(fmt.Sprintf("invalid origin %s. Wildcards are only allowed as a leading *.", origin))
+	}
+
+	return nil
+}
+
+func matchCorsOrigin(pattern, origin string) bool {
+	pattern = strings.TrimSuffix(strings.ToLower(pattern), "/")
+	origin = strings.ToLower(origin)
+	if pattern == "*" || pattern == origin {
+		return true
+	}
+
+	// Patterns without a scheme match any scheme
+	originHost := origin
+	if index := strings.Index(origin, "://"); index >= 0 {
+		if strings.Contains(pattern, "://") && !strings.HasPrefix(pattern, origin[:index+3]) {
+			return false
+		}
+
+		originHost = origin[index+3:]
+	}
+
+	if index := strings.Index(pattern, "://"); index >= 0 {
+		pattern = pattern[index+3:]
+	}
+
+	if pattern == originHost {
+		return true
+	}
+
+	if !strings.HasPrefix(pattern, "*.") {
+		return false
+	}
+
+	return strings.HasSuffix(originHost, pattern[1:]) && len(originHost) > len(pattern)-1
+}
+
+func getCslCorsSettings(ctx context.Context, orgId string) CslCorsSettings {
+	settings := CslCorsSettings{}
+	_, err := getCslDocument(ctx, orgId, CslCorsDocument, &settings)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting CORS settings for org %s: %s", orgId, err)
+	}
+
+	if settings.AllowedOrigins == nil {
+		settings.AllowedOrigins = []string{}
+	}
+
+	return settings
+}
+
+func loadCorsOrgOrigins(ctx context.Context) {
+	origins := []string{}
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[WARNING] Failed loading orgs for CORS origins: %s", err)
+	}
+
+	orgOrigins := make([][]string, len(orgs))
+	runConcurrentLookups(ctx, len(orgs), func(ctx context.Context, index int) error {
+		orgOrigins[index] = getCslCorsSettings(ctx, orgs[index].Id).AllowedOrigins
+		return nil
+	})
+
+	for _, allowedOrigins := range orgOrigins {
+		origins = append(origins, allowedOrigins...)
+	}
+
+	cslCorsOrgOrigins.Lock()
+	cslCorsOrgOrigins.origins = origins
+	cslCorsOrgOrigins.loaded = time.Now()
+	cslCorsOrgOrigins.loading = false
+	cslCorsOrgOrigins.Unlock()
+}
+
+// Returns the org origins in memory, reloading them in the background when stale
+func getCorsOrgOrigins() []string {
+	cslCorsOrgOrigins.Lock()
+	defer cslCorsOrgOrigins.Unlock()
+
+	if !cslCorsOrgOrigins.loading && time.Since(cslCorsOrgOrigins.loaded) > CorsOriginRefreshMinutes*time.Minute {
+		cslCorsOrgOrigins.loading = true
+		go loadCorsOrgOrigins(context.Background())
+	}
+
+	return cslCorsOrgOrigins.origins
+}
+
+// The policy is only active when SHUFFLE_CORS_ALLOWED_ORIGINS is set or an org
+// has allowed origins. Otherwise the handlers own CORS headers are kept as is
+func getCslCorsPolicy() (cslCorsPolicy, bool) {
+	policy := cslCorsPolicy{
+		Origins: splitCorsList(os.Getenv("SHUFFLE_CORS_ALLOWED_ORIGINS")),
+		Methods: strings.Join(splitCorsList(os.Getenv("SHUFFLE_CORS_ALLOWED_METHODS")), ", "),
+		Headers: strings.Join(splitCorsList(os.Getenv("SHUFFLE_CORS_ALLOWED_HEADERS")), ", "),
+	}
+
+	if len(policy.Methods) == 0 {
+		policy.Methods = DefaultCorsMethods
+	}
+
+	if len(policy.Headers) == 0 {
+		policy.Headers = DefaultCorsHeaders
+	}
+
+	policy.Origins = append(policy.Origins, getCorsOrgOrigins()...)
+	return policy, len(policy.Origins) > 0
+}
+
+// The backends own origin is always allowed so the bundled frontend keeps working
+func isCorsOriginAllowed(policy cslCorsPolicy, origin string, request *http.Request) bool {
+	if parsedUrl, err := url.Parse(origin); err == nil && strings.EqualFold(parsedUrl.Host, request.Host) {
+		return true
+	}
+
+	for _, pattern := range policy.Origins {
+		if matchCorsOrigin(pattern, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func setCorsHeaders(header http.Header, policy cslCorsPolicy, origin string, allowed bool) {
+	for _, name := range corsResponseHeaders {
+		header.Del(name)
+	}
+
+	header.Set("Vary", "Origin")
+	if !allowed {
+		return
+	}
+
+	header.Set("Access-Control-Allow-Origin", origin)
+	header.Set("Access-Control-Allow-Headers", policy.Headers)
+	header.Set("Access-Control-Allow-Methods", policy.Methods)
+	header.Set("Access-Control-Allow-Credentials", "true")
+}
+
+// Replaces the CORS headers set by HandleCors right before the response is written
+type cslCorsWriter struct {
+	http.ResponseWriter
+	policy      cslCorsPolicy
+	origin      string
+	allowed     bool
+	wroteHeader bool
+}
+
+func (writer *cslCorsWriter) WriteHeader(status int) {
+	if !writer.wroteHeader {
+		writer.wroteHeader = true
+		setCorsHeaders(writer.Header(), writer.policy, writer.origin, writer.allowed)
+	}
+
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *cslCorsWriter) Write(data []byte) (int, error) {
+	if !writer.wroteHeader {
+		writer.WriteHeader(http.StatusOK)
+	}
+
+	return writer.ResponseWriter.Write(data)
+}
+
+func (writer *cslCorsWriter) Flush() {
+	if flusher, ok := writer.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Wraps the router to apply the configured CORS policy. Preflight requests
+// are answered here so they work for every route
+func cslCorsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, request *http.Request) {
+		origin := request.Header.Get("Origin")
+		if len(origin) == 0 {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		policy, active := getCslCorsPolicy()
+		if !active {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		allowed := isCorsOriginAllowed(policy, origin, request)
+		if request.Method == "OPTIONS" && len(request.Header.Get("Access-Control-Request-Method")) > 0 {
+			setCorsHeaders(resp.Header(), policy, origin, allowed)
+			if !allowed {
+				log.Printf("[WARNING] Blocked CORS preflight from origin %s to %s", origin, request.URL.Path)
+				resp.WriteHeader(403)
+				return
+			}
+
+			resp.WriteHeader(200)
+			resp.Write([]byte("OK"))
+			return
+		}
+
+		next.ServeHTTP(&cslCorsWriter{ResponseWriter: resp, policy: policy, origin: origin, allowed: allowed}, request)
+	})
+}
+
+/*
+Settings:
+Returns the origins the current organization allows for cross origin requests,
+in addition to SHUFFLE_CORS_ALLOWED_ORIGINS. Requires org admin.
+
+	{
+	    "success": true,
+	    "data": {
+	        "allowed_origins": [
+	            "https://soc.example.com",
+	            "https://*.internal.example.com"
+	        ]
+	    }
+	}
+*/
+func cslGetCorsSettings(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslCorsSettings(ctx, user.ActiveOrg.Id),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetCorsSettings")
+}
+
+/*
+Settings:
+Replaces the allowed origins of the current organization. Requires org admin.
+Origins are [scheme://]host[:port], and the host may start with *. to allow
+any subdomain. Other backends pick up the change within 5 minutes.
+*/
+func cslSetCorsSettings(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	settings := CslCorsSettings{}
+	err = json.Unmarshal(body, &settings)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling CORS settings: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	origins := []string{}
+	for _, origin := range settings.AllowedOrigins {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin == "*" {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New("allowing every origin can only be done with SHUFFLE_CORS_ALLOWED_ORIGINS")))
+			return
+		}
+
+		err = validateCorsOrigin(origin)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		origins = append(origins, origin)
+	}
+
+	settings.AllowedOrigins = origins
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslCorsDocument, settings)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	loadCorsOrgOrigins(ctx)
+
+	log.Printf("[AUDIT] User %s (%s) updated CORS origins for org %s: %s", user.Username, user.Id, user.ActiveOrg.Id, strings.Join(origins, ", "))
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "cors_updated", "Allowed CORS origins were updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    settings,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetCorsSettings")
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Credential status values
+const (
+	CredentialStatusOk          = "ok"
+	CredentialStatusExpiring    = "expiring"
+	CredentialStatusExpired     = "expired"
+	CredentialStatusRefreshable = "refreshable"
+	CredentialStatusNoExpiry    = "no_expiry"
+)
+
+// Authentication fields that may hold an expiry timestamp, in order of priority.
+// "expiration" is set by the Oauth2 flow, the rest can be added manually to api tokens
+var credentialExpiryFields = []string{"refresh_token_expiration", "expires_at", "expiry", "expiration"}
+
+type CslCredentialStatus struct {
+	Id              string `json:"id"`
+	Label           string `json:"label"`
+	AppName         string `json:"app_name"`
+	Type            string `json:"type"`
+	Active          bool   `json:"active"`
+	Created         int64  `json:"created"`
+	Edited          int64  `json:"edited"`
+	AgeDays         int64  `json:"age_days"`
+	ExpiresAt       int64  `json:"expires_at,omitempty"`
+	ExpiresInDays   int64  `json:"expires_in_days,omitempty"`
+	HasRefreshToken bool   `json:"has_refresh_token"`
+	WorkflowCount   int64  `json:"workflow_count"`
+	Status          string `json:"status"`
+}
+
+type CslCredentialExpiryResponse struct {
+	WarningDays int                   `json:"warning_days"`
+	Credentials []CslCredentialStatus `json:"credentials"`
+}
+
+// Returns the plaintext value of an authentication field, decrypting it if needed
+func getAppAuthFieldValue(auth shuffle.AppAuthenticationStorage, key string) (string, bool) {
+	for _, field := range auth.Fields {
+		if field.Key != key {
+			continue
+		}
+
+		if !auth.Encrypted {
+			return field.Value, true
+		}
+
+		parsedKey := fmt.Sprintf("%s_%d_%s_%s", auth.OrgId, auth.Created, auth.Label, field.Key)
+		newValue, err := shuffle.HandleKeyDecryption([]byte(field.Value), parsedKey)
+		if err != nil {
+			log.Printf("[WARNING] Failed decrypting field %s for auth %s: %s", field.Key, auth.Id, err)
+			return "", false
+		}
+
+		return string(newValue), true
+	}
+
+	return "", false
+}
+
+// Parses an expiry value stored either as a unix timestamp or an RFC3339 / YYYY-MM-DD date
+func parseCredentialExpiry(value string) (int64, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0, false
+	}
+
+	if parsed, err := strconv.ParseInt(value, 10, 64); err == nil && parsed > 0 {
+		// Milliseconds
+		if parsed > 100000000000 {
+			parsed = parsed / 1000
+		}
+
+		return parsed, true
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		parsed, err := time.Parse(layout, value)
+		if err == nil {
+			return parsed.Unix(), true
+		}
+	}
+
+	return 0, false
+}
+
+// Calculates age and expiry for a single app authentication
+func getCredentialStatus(auth shuffle.AppAuthenticationStorage, warningDays int, timeNow int64) CslCredentialStatus {
+	status := CslCredentialStatus{
+		Id:            auth.Id,
+		Label:         auth.Label,
+		AppName:       auth.App.Name,
+		Type:          auth.Type,
+		Active:        auth.Active,
+		Created:       auth.Created,
+		Edited:        auth.Edited,
+		WorkflowCount: auth.WorkflowCount,
+		Status:        CredentialStatusNoExpiry,
+	}
+
+	if auth.Created > 0 {
+		status.AgeDays = (timeNow - auth.Created) / DaySeconds
+	}
+
+	_, status.HasRefreshToken = getAppAuthFieldValue(auth, "refresh_token")
+
+	expiryField := ""
+	for _, key := range credentialExpiryFields {
+		value, found := getAppAuthFieldValue(auth, key)
+		if !found {
+			continue
+		}
+
+		expiresAt, ok := parseCredentialExpiry(value)
+		if ok {
+			status.ExpiresAt = expiresAt
+			expiryField = key
+			break
+		}
+	}
+
+	if status.ExpiresAt == 0 {
+		return status
+	}
+
+	status.ExpiresInDays = (status.ExpiresAt - timeNow) / DaySeconds
+
+	// Access tokens with a refresh token are renewed on use
+	if expiryField == "expiration" && status.HasRefreshToken {
+		status.Status = CredentialStatusRefreshable
+	} else if status.ExpiresAt <= timeNow {
+		status.Status = CredentialStatusExpired
+	} else if status.ExpiresAt <= timeNow+int64(warningDays*DaySeconds) {
+		status.Status = CredentialStatusExpiring
+	} else {
+		status.Status = CredentialStatusOk
+	}
+
+	return status
+}
+
+// Returns the status of every app authentication in an org, soonest expiry first
+func getOrgCredentialStatuses(ctx context.Context, orgId string, warningDays int) ([]CslCredentialStatus, error) {
+	auths, err := shuffle.GetAllWorkflowAppAuth(ctx, orgId)
+	if err != nil {
+		return []CslCredentialStatus{}, err
+	}
+
+	timeNow := time.Now().Unix()
+	statuses := []CslCredentialStatus{}
+	for _, auth := range auths {
+		if auth.OrgId != orgId {
+			continue
+		}
+
+		statuses = append(statuses, getCredentialStatus(auth, warningDays, timeNow))
+	}
+
+	sort.SliceStable(statuses, func(i, j int) bool {
+		if statuses[i].ExpiresAt == 0 || statuses[j].ExpiresAt == 0 {
+			return statuses[i].ExpiresAt != 0
+		}
+
+		return statuses[i].ExpiresAt < statuses[j].ExpiresAt
+	})
+
+	return statuses, nil
+}
+
+func filterExpiringCredentials(statuses []CslCredentialStatus) []CslCredentialStatus {
+	expiring := []CslCredentialStatus{}
+	for _, status := range statuses {
+		if status.Status == CredentialStatusExpiring || status.Status == CredentialStatusExpired {
+			expiring = append(expiring, status)
+		}
+	}
+
+	return expiring
+}
+
+// Job: sends a warning to every org with credentials that are expired or nearing expiry
+func runCslCredentialExpiryJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for credential expiry job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		if !hasCslNotificationChannel(ctx, org.Id) {
+			continue
+		}
+
+		settings := getCslOrgSettings(ctx, org.Id)
+
+		statuses, err := getOrgCredentialStatuses(ctx, org.Id, settings.CredentialExpiryWarningDays)
+		if err != nil {
+			log.Printf("[ERROR] Failed getting credentials for org %s in expiry job: %s", org.Id, err)
+			continue
+		}
+
+		expiring := filterExpiringCredentials(statuses)
+		if len(expiring) == 0 {
+			continue
+		}
+
+		log.Printf("[INFO] Found %d expiring credentials for org %s. Sending warning", len(expiring), org.Id)
+		notifyCslEvent(ctx, org.Id, EventCredentialExpiry, CslCredentialExpiryResponse{
+			WarningDays: settings.CredentialExpiryWarningDays,
+			Credentials: expiring,
+		})
+	}
+}
+
+/*
+Credentials:
+Returns app authentications that are expired or expire within the warning window.
+Defaults to the orgs credential_expiry_warning_days setting, override with ?days=N.
+Use ?all=true to include every credential with its age and expiry.
+
+	{
+	    "success": true,
+	    "data": {
+	        "warning_days": 14,
+	        "credentials": [
+	            {
+	                "id": "...",
+	                "label": "VirusTotal prod",
+	                "app_name": "VirusTotal",
+	                "type": "",
+	                "active": true,
+	                "created": 1700000000,
+	                "edited": 1700000000,
+	                "age_days": 120,
+	                "expires_at": 1710000000,
+	                "expires_in_days": 3,
+	                "has_refresh_token": false,
+	                "workflow_count": 2,
+	                "status": "expiring"
+	            }
+	        ]
+	    }
+	}
+*/
+func cslCredentialExpiry(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	settings := getCslOrgSettings(ctx, user.ActiveOrg.Id)
+
+	warningDays := settings.CredentialExpiryWarningDays
+	if days, err := strconv.Atoi(request.URL.Query().Get("days")); err == nil && days > 0 {
+		warningDays = days
+	}
+
+	statuses, err := getOrgCredentialStatuses(ctx, user.ActiveOrg.Id, warningDays)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting app authentications for org %s: %s", user.ActiveOrg.Id, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if request.URL.Query().Get("all") != "true" {
+		statuses = filterExpiringCredentials(statuses)
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data: CslCredentialExpiryResponse{
+			WarningDays: warningDays,
+			Credentials: statuses,
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslCredentialExpiry")
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslCredentialUsageDocument = "credential_usage"
+
+// Max amount of uses kept per credential, and for how long. Oldest are dropped first
+const MaxCredentialUsagePerAuth = 500
+const CredentialUsageRetentionDays = 90
+
+const DefaultCredentialUsageLimit = 50
+const MaxCredentialUsageLimit = 500
+
+// One execution using a credential. Nodes are the actions in the execution
+// the credential was decrypted for
+type CslCredentialUsage struct {
+	AuthId       string   `json:"auth_id"`
+	AppName      string   `json:"app_name"`
+	WorkflowId   string   `json:"workflow_id"`
+	WorkflowName string   `json:"workflow_name"`
+	ExecutionId  string   `json:"execution_id"`
+	Nodes        []string `json:"nodes"`
+	Trigger      string   `json:"trigger"`
+	User         string   `json:"user,omitempty"`
+	Timestamp    int64    `json:"timestamp"`
+}
+
+type CslCredentialUsageLog struct {
+	Usage map[string][]CslCredentialUsage `json:"usage"`
+}
+
+type CslCredentialUsageSummary struct {
+	AuthId   string `json:"auth_id"`
+	Label    string `json:"label,omitempty"`
+	AppName  string `json:"app_name"`
+	Count    int    `json:"count"`
+	LastUsed int64  `json:"last_used"`
+}
+
+type CslCredentialUsageResponse struct {
+	Credentials []CslCredentialUsageSummary `json:"credentials"`
+	Usage       []CslCredentialUsage        `json:"usage"`
+}
+
+// Finds who started an execution. Only manual and API runs have a user, the
+// rest are started by their trigger
+func getExecutionUsername(request *http.Request, trigger string) string {
+	if request == nil || trigger != TriggerManual {
+		return ""
+	}
+
+	user, err := getMiddlewareUser(httptest.NewRecorder(), request)
+	if err != nil {
+		return ""
+	}
+
+	return user.Username
+}
+
+// Records which credentials an execution decrypts, one entry per credential.
+// Failures are logged, as recording should never stop the execution
+func recordCslCredentialUsage(ctx context.Context, execution shuffle.WorkflowExecution, request *http.Request) {
+	usages := map[string]*CslCredentialUsage{}
+	authIds := []string{}
+
+	trigger := getExecutionTrigger(execution)
+	username := ""
+	timeNow := time.Now().Unix()
+	for _, action := range execution.Workflow.Actions {
+		if len(action.AuthenticationId) == 0 {
+			continue
+		}
+
+		if len(authIds) == 0 {
+			username = getExecutionUsername(request, trigger)
+		}
+
+		usage, ok := usages[action.AuthenticationId]
+		if !ok {
+			usage = &CslCredentialUsage{
+				AuthId:       action.AuthenticationId,
+				AppName:      action.AppName,
+				WorkflowId:   execution.Workflow.ID,
+				WorkflowName: execution.Workflow.Name,
+				ExecutionId:  execution.ExecutionId,
+				Nodes:        []string{},
+				Trigger:      trigger,
+				User:         username,
+				Timestamp:    timeNow,
+			}
+
+			usages[action.AuthenticationId] = usage
+			authIds = append(authIds, action.AuthenticationId)
+		}
+
+		usage.Nodes = append(usage.Nodes, action.ID)
+	}
+
+	if len(authIds) == 0 {
+		return
+	}
+
+	oldest := timeNow - CredentialUsageRetentionDays*DaySeconds
+	usageLog := CslCredentialUsageLog{}
+	err := updateCslDocument(ctx, execution.OrgId, CslCredentialUsageDocument, &usageLog, func() error {
+		if usageLog.Usage == nil {
+			usageLog.Usage = map[string][]CslCredentialUsage{}
+		}
+
+		for _, authId := range authIds {
+			usageLog.Usage[authId] = append(usageLog.Usage[authId], *usages[authId])
+		}
+
+		for authId, authUsage := range usageLog.Usage {
+			kept := []CslCredentialUsage{}
+			for _, usage := range authUsage {
+				if usage.Timestamp > oldest {
+					kept = append(kept, usage)
+				}
+			}
+
+			if len(kept) > MaxCredentialUsagePerAuth {
+				kept = kept[len(kept)-MaxCredentialUsagePerAuth:]
+			}
+
+			if len(kept) == 0 {
+				delete(usageLog.Usage, authId)
+			} else {
+				usageLog.Usage[authId] = kept
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed recording credential usage of execution %s in org %s: %s", execution.ExecutionId, execution.OrgId, err)
+	}
+}
+
+func matchesCredentialUsage(usage CslCredentialUsage, workflowId, executionId, username string, since, until int64) bool {
+	if len(workflowId) > 0 && usage.WorkflowId != workflowId {
+		return false
+	}
+
+	if len(executionId) > 0 && usage.ExecutionId != executionId {
+		return false
+	}
+
+	if len(username) > 0 && usage.User != username {
+		return false
+	}
+
+	if usage.Timestamp < since || (until > 0 && usage.Timestamp > until) {
+		return false
+	}
+
+	return true
+}
+
+/*
+Credentials:
+Returns which workflows, executions and users used the credentials of the
+org, newest first. Every execution decrypting a credential is recorded once
+per credential, with the nodes it was used in. User is set for manual and
+API runs. History is kept for 90 days and the latest 500 uses per credential.
+
+Filter with ?auth_id=, ?workflow_id=, ?execution_id=, ?user=, ?since= and
+?until= (unix timestamps) and ?limit=N (default 50, max 500). credentials
+summarizes every credential matching the filters, including the ones left
+out by limit.
+
+	{
+	    "success": true,
+	    "data": {
+	        "credentials": [
+	            {
+	                "auth_id": "...",
+	                "label": "VirusTotal prod",
+	                "app_name": "VirusTotal",
+	                "count": 112,
+	                "last_used": 1718000000
+	            }
+	        ],
+	        "usage": [
+	            {
+	                "auth_id": "...",
+	                "app_name": "VirusTotal",
+	                "workflow_id": "...",
+	                "workflow_name": "Enrich alerts",
+	                "execution_id": "...",
+	                "nodes": ["..."],
+	                "trigger": "manual",
+	                "user": "analyst@example.com",
+	                "timestamp": 1718000000
+	            }
+	        ]
+	    }
+	}
+*/
+func cslGetCredentialUsage(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	limit := DefaultCredentialUsageLimit
+	if len(query.Get("limit")) > 0 {
+		parsedLimit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || parsedLimit <= 0 {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New("limit must be a positive number")))
+			return
+		}
+
+		limit = parsedLimit
+	}
+
+	if limit > MaxCredentialUsageLimit {
+		limit = MaxCredentialUsageLimit
+	}
+
+	var since, until int64
+	for key, value := range map[string]*int64{"since": &since, "until": &until} {
+		if len(query.Get(key)) == 0 {
+			continue
+		}
+
+		parsed, err := strconv.ParseInt(query.Get(key), 10, 64)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(key + " must be a unix timestamp")))
+			return
+		}
+
+		*value = parsed
+	}
+
+	usageLog := CslCredentialUsageLog{}
+	_, err := getCslDocument(ctx, user.ActiveOrg.Id, CslCredentialUsageDocument, &usageLog)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting credential usage for org %s: %s", user.ActiveOrg.Id, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	labels := map[string]string{}
+	auths, err := shuffle.GetAllWorkflowAppAuth(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting app auths of org %s for credential usage: %s", user.ActiveOrg.Id, err)
+	}
+
+	for _, auth := range auths {
+		if auth.OrgId == user.ActiveOrg.Id {
+			labels[auth.Id] = auth.Label
+		}
+	}
+
+	authId := query.Get("auth_id")
+	usageResponse := CslCredentialUsageResponse{
+		Credentials: []CslCredentialUsageSummary{},
+		Usage:       []CslCredentialUsage{},
+	}
+
+	for usageAuthId, authUsage := range usageLog.Usage {
+		if len(authId) > 0 && usageAuthId != authId {
+			continue
+		}
+
+		summary := CslCredentialUsageSummary{
+			AuthId: usageAuthId,
+			Label:  labels[usageAuthId],
+		}
+
+		for _, usage := range authUsage {
+			if !matchesCredentialUsage(usage, query.Get("workflow_id"), query.Get("execution_id"), query.Get("user"), since, until) {
+				continue
+			}
+
+			summary.Count += 1
+			summary.AppName = usage.AppName
+			if usage.Timestamp > summary.LastUsed {
+				summary.LastUsed = usage.Timestamp
+			}
+
+			usageResponse.Usage = append(usageResponse.Usage, usage)
+		}
+
+		if summary.Count > 0 {
+			usageResponse.Credentials = append(usageResponse.Credentials, summary)
+		}
+	}
+
+	sort.SliceStable(usageResponse.Credentials, func(i, j int) bool {
+		return usageResponse.Credentials[i].LastUsed > usageResponse.Credentials[j].LastUsed
+	})
+
+	sort.SliceStable(usageResponse.Usage, func(i, j int) bool {
+		return usageResponse.Usage[i].Timestamp > usageResponse.Usage[j].Timestamp
+	})
+
+	if len(usageResponse.Usage) > limit {
+		usageResponse.Usage = usageResponse.Usage[:limit]
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    usageResponse,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetCredentialUsage")
+}
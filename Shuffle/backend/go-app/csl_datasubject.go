@@ -0,0 +1,596 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Deletes data referencing a data subject, such as an email, username or IP,
+// across the stored data of an org, and keeps a report of what was deleted.
+// Reports only keep a hash of the identifier.
+
+const CslDeletionReportsDocument = "deletion_reports"
+
+// Max amount of reports kept per org. Oldest are dropped first
+const MaxDeletionReports = 200
+
+// Most recent executions of each workflow searched for the identifier
+const DeletionExecutionLimit = 1000
+
+// Places data is deleted from, as counted in reports
+const (
+	DeletionExecutions      = "executions"
+	DeletionCases           = "cases"
+	DeletionObservables     = "observables"
+	DeletionActivity        = "activity"
+	DeletionCredentialUsage = "credential_usage"
+	DeletionGeoEvents       = "geo_events"
+	DeletionSessions        = "sessions"
+	DeletionPiiFindings     = "pii_findings"
+)
+
+var deletionTargets = []string{DeletionExecutions, DeletionCases, DeletionObservables, DeletionActivity, DeletionCredentialUsage, DeletionGeoEvents, DeletionSessions, DeletionPiiFindings}
+
+type CslDeletionRequest struct {
+	Identifier string `json:"identifier"`
+	DryRun     bool   `json:"dry_run"`
+}
+
+type CslDeletionReport struct {
+	Id             string         `json:"id"`
+	IdentifierHash string         `json:"identifier_hash"`
+	DryRun         bool           `json:"dry_run"`
+	RequestedBy    string         `json:"requested_by"`
+	Started        int64          `json:"started"`
+	Finished       int64          `json:"finished"`
+	Deleted        map[string]int `json:"deleted"`
+	ExecutionIds   []string       `json:"execution_ids"`
+	Errors         []string       `json:"errors"`
+	Notes          []string       `json:"notes"`
+}
+
+type CslDeletionReports struct {
+	Reports []CslDeletionReport `json:"reports"`
+}
+
+// Returned from document updates of dry runs, so nothing is stored
+var errCslDryRun = errors.New("dry run")
+
+func (report *CslDeletionReport) commit() error {
+	if report.DryRun {
+		return errCslDryRun
+	}
+
+	return nil
+}
+
+// What a deletion looks for. Users are the org users matching the identifier,
+// so data stored by user id is found as well
+type cslDataSubject struct {
+	pattern      *regexp.Regexp
+	userIds      map[string]bool
+	executionIds map[string]bool
+}
+
+func getIdentifierHash(identifier string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(identifier)))
+	return hex.EncodeToString(hash[:])
+}
+
+// Matches the identifier as a whole value, so 10.0.0.1 doesn't match
+// 10.0.0.15 and bob doesn't match bob@example.com
+func getDataSubjectPattern(identifier string) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf(`(?i)(^|[^A-Za-z0-9._@-])%s($|[^A-Za-z0-9._@-])`, regexp.QuoteMeta(identifier)))
+}
+
+func (subject *cslDataSubject) matches(values ...string) bool {
+	for _, value := range values {
+		if len(value) > 0 && subject.pattern.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (subject *cslDataSubject) matchesJson(data interface{}) bool {
+	marshalled, err := json.Marshal(data)
+	if err != nil {
+		return false
+	}
+
+	return subject.matches(string(marshalled))
+}
+
+func deleteSubjectExecutions(ctx context.Context, orgId string, subject *cslDataSubject, report *CslDeletionReport) {
+	workflows, err := getOrgWorkflows(ctx, orgId)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("failed getting workflows: %s", err))
+		return
+	}
+
+	for _, workflow := range workflows {
+		executions, err := shuffle.GetAllWorkflowExecutions(ctx, workflow.ID, DeletionExecutionLimit)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("failed getting executions of workflow %s: %s", workflow.ID, err))
+			continue
+		}
+
+		if len(executions) >= DeletionExecutionLimit {
+			report.Notes = append(report.Notes, fmt.Sprintf("Only the latest %d executions of workflow %s were searched", DeletionExecutionLimit, workflow.ID))
+		}
+
+		deleted := false
+		for _, execution := range executions {
+			if !subject.matchesJson(execution) {
+				continue
+			}
+
+			subject.executionIds[execution.ExecutionId] = true
+			report.ExecutionIds = append(report.ExecutionIds, execution.ExecutionId)
+			report.Deleted[DeletionExecutions] += 1
+			if report.DryRun {
+				continue
+			}
+
+			err = shuffle.DeleteKey(ctx, "workflowexecution", execution.ExecutionId)
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("failed deleting execution %s: %s", execution.ExecutionId, err))
+				continue
+			}
+
+			deleted = true
+		}
+
+		// Same list caches SetWorkflowExecution clears
+		if deleted {
+			shuffle.DeleteCache(ctx, fmt.Sprintf("workflowexecution_%s", workflow.ID))
+			shuffle.DeleteCache(ctx, fmt.Sprintf("workflowexecution_%s_50", workflow.ID))
+			shuffle.DeleteCache(ctx, fmt.Sprintf("workflowexecution_%s_100", workflow.ID))
+		}
+	}
+}
+
+// Linked Jira issues and ServiceNow incidents. Comments by or about the
+// subject are removed from issues that are kept
+func deleteSubjectCases(ctx context.Context, orgId string, subject *cslDataSubject, report *CslDeletionReport) error {
+	issues := CslJiraIssues{}
+	err := updateCslDocument(ctx, orgId, CslJiraIssuesDocument, &issues, func() error {
+		for key, issue := range issues.Issues {
+			if subject.executionIds[issue.SourceKey] || subject.matches(issue.Summary) {
+				report.Deleted[DeletionCases] += 1
+				delete(issues.Issues, key)
+				continue
+			}
+
+			comments := []CslJiraComment{}
+			for _, comment := range issue.Comments {
+				if subject.matches(comment.Author, comment.Body) {
+					report.Deleted[DeletionCases] += 1
+					continue
+				}
+
+				comments = append(comments, comment)
+			}
+
+			issue.Comments = comments
+			issues.Issues[key] = issue
+		}
+
+		return report.commit()
+	})
+	if err != nil && err != errCslDryRun {
+		return err
+	}
+
+	incidents := CslServiceNowIncidents{}
+	return updateCslDocument(ctx, orgId, CslServiceNowIncidentsDocument, &incidents, func() error {
+		for key, incident := range incidents.Incidents {
+			if subject.executionIds[incident.SourceKey] || subject.matches(incident.ShortDescription) {
+				report.Deleted[DeletionCases] += 1
+				delete(incidents.Incidents, key)
+			}
+		}
+
+		return report.commit()
+	})
+}
+
+// Observables with the identifier as value are removed, and deleted
+// executions are removed from the sightings of the rest
+func deleteSubjectObservables(ctx context.Context, orgId string, subject *cslDataSubject, report *CslDeletionReport) error {
+	observables := CslObservables{}
+	return updateCslDocument(ctx, orgId, CslObservablesDocument, &observables, func() error {
+		for key, observable := range observables.Observables {
+			if subject.matches(observable.Value) {
+				report.Deleted[DeletionObservables] += 1
+				delete(observables.Observables, key)
+				continue
+			}
+
+			executionIds := []string{}
+			for _, executionId := range observable.ExecutionIds {
+				if !subject.executionIds[executionId] {
+					executionIds = append(executionIds, executionId)
+				}
+			}
+
+			if len(executionIds) == 0 && len(observable.ExecutionIds) > 0 {
+				report.Deleted[DeletionObservables] += 1
+				delete(observables.Observables, key)
+				continue
+			}
+
+			observable.ExecutionIds = executionIds
+			observables.Observables[key] = observable
+		}
+
+		return report.commit()
+	})
+}
+
+func deleteSubjectActivity(ctx context.Context, orgId string, subject *cslDataSubject, report *CslDeletionReport) error {
+	activityLog := CslActivityLog{}
+	return updateCslDocument(ctx, orgId, CslActivityDocument, &activityLog, func() error {
+		activities := []CslActivity{}
+		for _, activity := range activityLog.Activities {
+			if subject.matches(activity.Actor, activity.Title) || subject.executionIds[activity.ReferenceId] || subject.userIds[activity.ReferenceId] {
+				report.Deleted[DeletionActivity] += 1
+				continue
+			}
+
+			activities = append(activities, activity)
+		}
+
+		activityLog.Activities = activities
+		return report.commit()
+	})
+}
+
+func deleteSubjectCredentialUsage(ctx context.Context, orgId string, subject *cslDataSubject, report *CslDeletionReport) error {
+	usageLog := CslCredentialUsageLog{}
+	return updateCslDocument(ctx, orgId, CslCredentialUsageDocument, &usageLog, func() error {
+		for authId, authUsage := range usageLog.Usage {
+			kept := []CslCredentialUsage{}
+			for _, usage := range authUsage {
+				if subject.matches(usage.User) || subject.executionIds[usage.ExecutionId] {
+					report.Deleted[DeletionCredentialUsage] += 1
+					continue
+				}
+
+				kept = append(kept, usage)
+			}
+
+			usageLog.Usage[authId] = kept
+		}
+
+		return report.commit()
+	})
+}
+
+func matchesGeoEvent(subject *cslDataSubject, event CslGeoEvent) bool {
+	return subject.matches(event.Ip, event.Name) || subject.userIds[event.ReferenceId]
+}
+
+// Events waiting for the geo_flush job are removed as well
+func deleteSubjectGeoEvents(ctx context.Context, orgId string, subject *cslDataSubject, report *CslDeletionReport) error {
+	if !report.DryRun {
+		cslPendingGeoEvents.Lock()
+		pending := []CslGeoEvent{}
+		for _, event := range cslPendingGeoEvents.orgs[orgId] {
+			if !matchesGeoEvent(subject, event) {
+				pending = append(pending, event)
+			}
+		}
+
+		cslPendingGeoEvents.orgs[orgId] = pending
+		cslPendingGeoEvents.Unlock()
+	}
+
+	geoEvents := CslGeoEvents{}
+	return updateCslDocument(ctx, orgId, CslGeoEventsDocument, &geoEvents, func() error {
+		events := []CslGeoEvent{}
+		for _, event := range geoEvents.Events {
+			if matchesGeoEvent(subject, event) {
+				report.Deleted[DeletionGeoEvents] += 1
+				continue
+			}
+
+			events = append(events, event)
+		}
+
+		geoEvents.Events = events
+		return report.commit()
+	})
+}
+
+// Sessions of the subject are removed. Clients with the identifier as IP are
+// removed from the sessions of other users
+func deleteSubjectSessions(ctx context.Context, orgId string, subject *cslDataSubject, report *CslDeletionReport) error {
+	if !report.DryRun {
+		cslPendingSessions.Lock()
+		for key, session := range cslPendingSessions.sessions {
+			if session.orgId != orgId {
+				continue
+			}
+
+			if subject.userIds[session.userId] || subject.matches(session.username) {
+				delete(cslPendingSessions.sessions, key)
+				continue
+			}
+
+			for clientKey, client := range session.clients {
+				if subject.matches(client.Ip) {
+					delete(session.clients, clientKey)
+				}
+			}
+		}
+		cslPendingSessions.Unlock()
+	}
+
+	sessions := CslSessions{}
+	return updateCslDocument(ctx, orgId, CslSessionsDocument, &sessions, func() error {
+		for id, session := range sessions.Sessions {
+			if subject.userIds[session.UserId] || subject.matches(session.Username) {
+				report.Deleted[DeletionSessions] += 1
+				delete(sessions.Sessions, id)
+				continue
+			}
+
+			clients := []CslSessionClient{}
+			for _, client := range session.Clients {
+				if subject.matches(client.Ip) {
+					report.Deleted[DeletionSessions] += 1
+					continue
+				}
+
+				clients = append(clients, client)
+			}
+
+			session.Clients = clients
+			sessions.Sessions[id] = session
+		}
+
+		return report.commit()
+	})
+}
+
+func deleteSubjectPiiFindings(ctx context.Context, orgId string, subject *cslDataSubject, report *CslDeletionReport) error {
+	scan := CslPiiScan{}
+	return updateCslDocument(ctx, orgId, CslPiiScanDocument, &scan, func() error {
+		findings := []CslPiiFinding{}
+		for _, finding := range scan.Findings {
+			if subject.executionIds[finding.ExecutionId] {
+				report.Deleted[DeletionPiiFindings] += 1
+				continue
+			}
+
+			findings = append(findings, finding)
+		}
+
+		scan.Findings = findings
+		return report.commit()
+	})
+}
+
+// Deletes everything in the org referencing the identifier. Executions go
+// first, as the rest also removes data linked to the deleted executions. With
+// dryRun, the report counts what would be deleted without deleting it
+func deleteCslDataSubject(ctx context.Context, orgId, identifier string, dryRun bool, requestedBy string) CslDeletionReport {
+	report := CslDeletionReport{
+		Id:             uuid.NewV4().String(),
+		IdentifierHash: getIdentifierHash(identifier),
+		DryRun:         dryRun,
+		RequestedBy:    requestedBy,
+		Started:        time.Now().Unix(),
+		Deleted:        map[string]int{},
+		ExecutionIds:   []string{},
+		Errors:         []string{},
+		Notes: []string{
+			"Backend log output and database backups aren't covered",
+		},
+	}
+
+	for _, target := range deletionTargets {
+		report.Deleted[target] = 0
+	}
+
+	subject := &cslDataSubject{
+		pattern:      getDataSubjectPattern(identifier),
+		userIds:      map[string]bool{},
+		executionIds: map[string]bool{},
+	}
+
+	org, err := shuffle.GetOrg(ctx, orgId)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("failed getting org users: %s", err))
+	} else {
+		for _, user := range org.Users {
+			if subject.matches(user.Username, user.Id) {
+				subject.userIds[user.Id] = true
+			}
+		}
+	}
+
+	deleteSubjectExecutions(ctx, orgId, subject, &report)
+
+	deletions := []struct {
+		target string
+		delete func(context.Context, string, *cslDataSubject, *CslDeletionReport) error
+	}{
+		{DeletionCases, deleteSubjectCases},
+		{DeletionObservables, deleteSubjectObservables},
+		{DeletionActivity, deleteSubjectActivity},
+		{DeletionCredentialUsage, deleteSubjectCredentialUsage},
+		{DeletionGeoEvents, deleteSubjectGeoEvents},
+		{DeletionSessions, deleteSubjectSessions},
+		{DeletionPiiFindings, deleteSubjectPiiFindings},
+	}
+
+	for _, deletion := range deletions {
+		err := deletion.delete(ctx, orgId, subject, &report)
+		if err != nil && err != errCslDryRun {
+			report.Errors = append(report.Errors, fmt.Sprintf("failed deleting from %s: %s", deletion.target, err))
+		}
+	}
+
+	report.Finished = time.Now().Unix()
+	return report
+}
+
+/*
+Data subjects:
+Deletes everything in the org referencing an identifier, such as an email,
+username or IP: executions, linked Jira issues and ServiceNow incidents,
+observables, the activity feed, credential usage, geo events, sessions and
+PII findings. The identifier is matched as a whole value and case
+insensitively. Use dry_run to see what would be deleted first.
+
+The report is returned and kept for compliance evidence, with a sha256 hash
+of the lowercased identifier instead of the identifier itself.
+
+	{
+	    "identifier": "jane@example.com",
+	    "dry_run": false
+	}
+
+	{
+	    "success": true,
+	    "data": {
+	        "id": "...",
+	        "identifier_hash": "...",
+	        "dry_run": false,
+	        "requested_by": "admin@example.com",
+	        "started": 1718000000,
+	        "finished": 1718000012,
+	        "deleted": {
+	            "executions": 4,
+	            "cases": 1,
+	            "observables": 1,
+	            "activity": 2,
+	            "credential_usage": 0,
+	            "geo_events": 6,
+	            "sessions": 1,
+	            "pii_findings": 3
+	        },
+	        "execution_ids": ["..."],
+	        "errors": [],
+	        "notes": ["Backend log output and database backups aren't covered"]
+	    }
+	}
+*/
+func cslDeleteDataSubject(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	deletionRequest := CslDeletionRequest{}
+	err = json.Unmarshal(body, &deletionRequest)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	deletionRequest.Identifier = strings.TrimSpace(deletionRequest.Identifier)
+	if len(deletionRequest.Identifier) < 3 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("identifier must be at least 3 characters")))
+		return
+	}
+
+	report := deleteCslDataSubject(ctx, user.ActiveOrg.Id, deletionRequest.Identifier, deletionRequest.DryRun, user.Username)
+
+	if !report.DryRun {
+		log.Printf("[AUDIT] User %s (%s) deleted data of data subject %s in org %s. Report %s", user.Username, user.Id, report.IdentifierHash, user.ActiveOrg.Id, report.Id)
+		recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeSecurity, "data_subject_deleted", "Data of a data subject was deleted", user.Username, report.Id)
+
+		reports := CslDeletionReports{}
+		err = updateCslDocument(ctx, user.ActiveOrg.Id, CslDeletionReportsDocument, &reports, func() error {
+			reports.Reports = append(reports.Reports, report)
+			if len(reports.Reports) > MaxDeletionReports {
+				reports.Reports = reports.Reports[len(reports.Reports)-MaxDeletionReports:]
+			}
+
+			return nil
+		})
+		if err != nil {
+			log.Printf("[ERROR] Failed storing deletion report %s for org %s: %s", report.Id, user.ActiveOrg.Id, err)
+			report.Errors = append(report.Errors, fmt.Sprintf("failed storing the report: %s", err))
+		}
+	}
+
+	res := CslResponse{
+		Success: len(report.Errors) == 0,
+		Data:    report,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslDeleteDataSubject")
+}
+
+/*
+Data subjects:
+Returns the deletion reports of the org, newest first. Use ?identifier= to
+only get the reports of an identifier.
+*/
+func cslDataSubjectReports(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	stored := CslDeletionReports{}
+	_, err := getCslDocument(ctx, user.ActiveOrg.Id, CslDeletionReportsDocument, &stored)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting deletion reports for org %s: %s", user.ActiveOrg.Id, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	identifierHash := ""
+	if identifier := strings.TrimSpace(request.URL.Query().Get("identifier")); len(identifier) > 0 {
+		identifierHash = getIdentifierHash(identifier)
+	}
+
+	reports := []CslDeletionReport{}
+	for index := len(stored.Reports) - 1; index >= 0; index-- {
+		if len(identifierHash) > 0 && stored.Reports[index].IdentifierHash != identifierHash {
+			continue
+		}
+
+		reports = append(reports, stored.Reports[index])
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    reports,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslDataSubjectReports")
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Profiling and runtime diagnostics for users with support access, at
+// /api/v1/csl/debug, and on SHUFFLE_DEBUG_PORT at /debug if set. Profiles are
+// in the pprof format, so they can be downloaded and opened with
+//
+//	go tool pprof -http :8080 heap.pprof
+//
+// net/http/pprof isn't used, as it registers itself on the default mux the
+// backend serves without authentication. SHUFFLE_DEBUG_ENDPOINTS=false
+// turns all of it off.
+
+const DefaultProfileSeconds = 30
+const MaxProfileSeconds = 300
+
+var cslStarted = time.Now()
+
+// Only one CPU profile or trace can run at a time
+var cslProfiling sync.Mutex
+
+type CslProfile struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	Path  string `json:"path"`
+}
+
+type CslBuildInfo struct {
+	GoVersion     string            `json:"go_version"`
+	Module        string            `json:"module"`
+	Settings      map[string]string `json:"settings"`
+	Dependencies  map[string]string `json:"dependencies"`
+	Hostname      string            `json:"hostname"`
+	Started       int64             `json:"started"`
+	UptimeSeconds int64             `json:"uptime_seconds"`
+	Goroutines    int               `json:"goroutines"`
+	Cpus          int               `json:"cpus"`
+	HeapAllocMb   float64           `json:"heap_alloc_mb"`
+	HeapSysMb     float64           `json:"heap_sys_mb"`
+	HeapObjects   uint64            `json:"heap_objects"`
+	GcRuns        uint32            `json:"gc_runs"`
+	LastGc        int64             `json:"last_gc"`
+}
+
+func debugEndpointsEnabled() bool {
+	return os.Getenv("SHUFFLE_DEBUG_ENDPOINTS") != "false"
+}
+
+// Requires support access, and logs who looked
+func cslDebugAuth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		if !debugEndpointsEnabled() {
+			resp.WriteHeader(404)
+			resp.Write(createCslErrorResponse(errors.New("debug endpoints are disabled with SHUFFLE_DEBUG_ENDPOINTS=false")))
+			return
+		}
+
+		user := handleCslRequest(resp, request)
+		if user == nil {
+			return
+		}
+
+		if !user.SupportAccess {
+			resp.WriteHeader(403)
+			resp.Write(createCslErrorResponse(errors.New("debug endpoints require support access")))
+			return
+		}
+
+		log.Printf("[AUDIT] User %s (%s) requested %s", user.Username, user.Id, request.URL.Path)
+		handler(resp, request)
+	}
+}
+
+func getProfileSeconds(request *http.Request) (int, error) {
+	value := request.URL.Query().Get("seconds")
+	if len(value) == 0 {
+		return DefaultProfileSeconds, nil
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 1 || seconds > MaxProfileSeconds {
+		return 0, errors.New(fmt.Sprintf("seconds has to be between 1 and %d", MaxProfileSeconds))
+	}
+
+	return seconds, nil
+}
+
+func writeProfileHeaders(resp http.ResponseWriter, name string) {
+	resp.Header().Set("Content-Type", "application/octet-stream")
+	resp.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
+/*
+Debug:
+Lists the profiles that can be downloaded from /api/v1/csl/debug/pprof/{name},
+with how many samples each has. debug=1 returns a profile as text, and
+goroutine?debug=2 the stack of every goroutine. CPU profiles and execution
+traces are at /api/v1/csl/debug/pprof/profile and
+/api/v1/csl/debug/pprof/trace, and run for seconds (default 30).
+
+	{
+	    "success": true,
+	    "data": [
+	        {"name": "goroutine", "count": 412, "path": "/api/v1/csl/debug/pprof/goroutine"},
+	        {"name": "heap", "count": 96, "path": "/api/v1/csl/debug/pprof/heap"}
+	    ]
+	}
+*/
+func cslListProfiles(resp http.ResponseWriter, request *http.Request) {
+	prefix := getDebugPrefix(request)
+	profiles := []CslProfile{}
+	for _, profile := range pprof.Profiles() {
+		profiles = append(profiles, CslProfile{
+			Name:  profile.Name(),
+			Count: profile.Count(),
+			Path:  fmt.Sprintf("%s/pprof/%s", prefix, profile.Name()),
+		})
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data:    profiles,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslListProfiles")
+}
+
+func getDebugPrefix(request *http.Request) string {
+	if strings.HasPrefix(request.URL.Path, "/api/") {
+		return "/api/v1/csl/debug"
+	}
+
+	return "/debug"
+}
+
+func cslGetProfile(resp http.ResponseWriter, request *http.Request) {
+	name := mux.Vars(request)["profile"]
+	profile := pprof.Lookup(name)
+	if profile == nil {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("unknown profile %s", name))))
+		return
+	}
+
+	debugLevel, _ := strconv.Atoi(request.URL.Query().Get("debug"))
+	if request.URL.Query().Get("gc") == "1" && name == "heap" {
+		runtime.GC()
+	}
+
+	if debugLevel > 0 {
+		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		writeProfileHeaders(resp, name)
+	}
+
+	err := profile.WriteTo(resp, debugLevel)
+	if err != nil {
+		log.Printf("[WARNING] Failed writing profile %s: %s", name, err)
+	}
+}
+
+func cslGetCpuProfile(resp http.ResponseWriter, request *http.Request) {
+	seconds, err := getProfileSeconds(request)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if !cslProfiling.TryLock() {
+		resp.WriteHeader(409)
+		resp.Write(createCslErrorResponse(errors.New("a CPU profile or trace is already running")))
+		return
+	}
+
+	defer cslProfiling.Unlock()
+
+	writeProfileHeaders(resp, "profile")
+	err = pprof.StartCPUProfile(resp)
+	if err != nil {
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Header().Del("Content-Disposition")
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	select {
+	case <-time.After(time.Duration(seconds) * time.Second):
+	case <-request.Context().Done():
+	}
+
+	pprof.StopCPUProfile()
+}
+
+func cslGetTrace(resp http.ResponseWriter, request *http.Request) {
+	seconds, err := getProfileSeconds(request)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if !cslProfiling.TryLock() {
+		resp.WriteHeader(409)
+		resp.Write(createCslErrorResponse(errors.New("a CPU profile or trace is already running")))
+		return
+	}
+
+	defer cslProfiling.Unlock()
+
+	writeProfileHeaders(resp, "trace")
+	err = trace.Start(resp)
+	if err != nil {
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Header().Del("Content-Disposition")
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	select {
+	case <-time.After(time.Duration(seconds) * time.Second):
+	case <-request.Context().Done():
+	}
+
+	trace.Stop()
+}
+
+// Stacks of every goroutine as text, the same as goroutine?debug=2
+func cslGetGoroutines(resp http.ResponseWriter, request *http.Request) {
+	resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	pprof.Lookup("goroutine").WriteTo(resp, 2)
+}
+
+/*
+Debug:
+Returns how the backend was built and its runtime state.
+
+	{
+	    "success": true,
+	    "data": {
+	        "go_version": "go1.21.5",
+	        "module": "shuffle",
+	        "settings": {"vcs.revision": "31263f5...", "vcs.time": "2024-06-10T08:00:00Z"},
+	        "dependencies": {"github.com/shuffle/shuffle-shared": "v0.6.1"},
+	        "hostname": "backend-1",
+	        "started": 1718000000,
+	        "uptime_seconds": 86400,
+	        "goroutines": 412,
+	        "cpus": 4,
+	        "heap_alloc_mb": 312.4,
+	        "heap_sys_mb": 480.2,
+	        "heap_objects": 2210311,
+	        "gc_runs": 1520,
+	        "last_gc": 1718086390
+	    }
+	}
+*/
+func cslGetBuildInfo(resp http.ResponseWriter, request *http.Request) {
+	hostname, _ := os.Hostname()
+	info := CslBuildInfo{
+		GoVersion:     runtime.Version(),
+		Settings:      map[string]string{},
+		Dependencies:  map[string]string{},
+		Hostname:      hostname,
+		Started:       cslStarted.Unix(),
+		UptimeSeconds: int64(time.Since(cslStarted).Seconds()),
+		Goroutines:    runtime.NumGoroutine(),
+		Cpus:          runtime.NumCPU(),
+	}
+
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		info.Module = buildInfo.Main.Path
+		for _, setting := range buildInfo.Settings {
+			info.Settings[setting.Key] = setting.Value
+		}
+
+		for _, dependency := range buildInfo.Deps {
+			version := dependency.Version
+			if dependency.Replace != nil {
+				version = fmt.Sprintf("%s => %s %s", version, dependency.Replace.Path, dependency.Replace.Version)
+			}
+
+			info.Dependencies[dependency.Path] = version
+		}
+	}
+
+	memStats := runtime.MemStats{}
+	runtime.ReadMemStats(&memStats)
+	info.HeapAllocMb = float64(memStats.HeapAlloc/1024) / 1024
+	info.HeapSysMb = float64(memStats.HeapSys/1024) / 1024
+	info.HeapObjects = memStats.HeapObjects
+	info.GcRuns = memStats.NumGC
+	if memStats.LastGC > 0 {
+		info.LastGc = time.Unix(0, int64(memStats.LastGC)).Unix()
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    info,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetBuildInfo")
+}
+
+func addCslDebugRoutes(r *mux.Router, prefix string) {
+	r.HandleFunc(prefix+"/pprof", cslDebugAuth(cslListProfiles)).Methods("GET")
+	r.HandleFunc(prefix+"/pprof/profile", cslDebugAuth(cslGetCpuProfile)).Methods("GET")
+	r.HandleFunc(prefix+"/pprof/trace", cslDebugAuth(cslGetTrace)).Methods("GET")
+	r.HandleFunc(prefix+"/pprof/{profile}", cslDebugAuth(cslGetProfile)).Methods("GET")
+	r.HandleFunc(prefix+"/goroutines", cslDebugAuth(cslGetGoroutines)).Methods("GET")
+	r.HandleFunc(prefix+"/buildinfo", cslDebugAuth(cslGetBuildInfo)).Methods("GET")
+}
+
+// Serves the debug endpoints on their own port if SHUFFLE_DEBUG_PORT is set,
+// so they can be kept off the load balancer. Listens on SHUFFLE_DEBUG_ADDR,
+// by default only on localhost
+func initCslDebugServer() {
+	port := os.Getenv("SHUFFLE_DEBUG_PORT")
+	if len(port) == 0 || !debugEndpointsEnabled() {
+		return
+	}
+
+	addr := os.Getenv("SHUFFLE_DEBUG_ADDR")
+	if len(addr) == 0 {
+		addr = "127.0.0.1"
+	}
+
+	r := mux.NewRouter()
+	addCslDebugRoutes(r, "/debug")
+	r.Use(cslRecoveryMiddleware)
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf("%s:%s", addr, port),
+		Handler: r,
+	}
+
+	go func() {
+		log.Printf("[INFO] Serving debug endpoints on %s", server.Addr)
+		err := server.ListenAndServe()
+		if err != nil {
+			log.Printf("[ERROR] Debug server on %s stopped: %s", server.Addr, err)
+		}
+	}()
+}
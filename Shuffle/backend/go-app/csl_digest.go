@@ -0,0 +1,742 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Per-user email digests of executions, failures, SLA breaches and pending
+// approvals. Sent over SMTP, configured with SHUFFLE_SMTP_HOST,
+// SHUFFLE_SMTP_PORT (default 587), SHUFFLE_SMTP_USERNAME,
+// SHUFFLE_SMTP_PASSWORD and SHUFFLE_SMTP_FROM.
+
+const CslDigestsDocument = "digests"
+
+const DigestJobMinutes = 60
+
+// Executions per workflow looked through for SLA breaches and pending approvals
+const DigestExecutionsPerWorkflow = 100
+
+// Breaches and approvals listed in a digest. Counts include all of them
+const MaxDigestItems = 25
+
+// Guards against sending the same digest twice if the job runs twice within the hour
+const DigestMinIntervalSeconds = 12 * 60 * 60
+
+// Frequencies
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+type CslDigestPreferences struct {
+	Enabled   bool   `json:"enabled"`
+	Frequency string `json:"frequency"`
+
+	// Hour of the day and day of the week (0 is Sunday) in the org timezone
+	Hour    int `json:"hour"`
+	Weekday int `json:"weekday"`
+
+	// Defaults to the username if it is an email address
+	Email    string `json:"email"`
+	LastSent int64  `json:"last_sent"`
+}
+
+// Digest preferences of every user in an org, keyed by user id
+type CslDigestSubscriptions struct {
+	Users map[string]CslDigestPreferences `json:"users"`
+}
+
+type CslDigestSlaBreach struct {
+	ExecutionId     string `json:"execution_id"`
+	WorkflowId      string `json:"workflow_id"`
+	WorkflowName    string `json:"workflow_name"`
+	Status          string `json:"status"`
+	StartedAt       int64  `json:"started_at"`
+	DurationMinutes int64  `json:"duration_minutes"`
+}
+
+type CslDigestApproval struct {
+	ExecutionId  string `json:"execution_id"`
+	WorkflowId   string `json:"workflow_id"`
+	WorkflowName string `json:"workflow_name"`
+	NodeId       string `json:"node_id"`
+	Label        string `json:"label"`
+	WaitingSince int64  `json:"waiting_since"`
+}
+
+type CslDigest struct {
+	OrgId               string               `json:"org_id"`
+	OrgName             string               `json:"org_name"`
+	Frequency           string               `json:"frequency"`
+	Days                int                  `json:"days"`
+	Timezone            string               `json:"timezone"`
+	Since               int64                `json:"since"`
+	Executions          int64                `json:"executions"`
+	ExecutionsFinished  int64                `json:"executions_finished"`
+	ExecutionsFailed    int64                `json:"executions_failed"`
+	FailureRate         float64              `json:"failure_rate"`
+	AppExecutions       int64                `json:"app_executions"`
+	AppExecutionsFailed int64                `json:"app_executions_failed"`
+	Failures            []CslFailureCount    `json:"failures"`
+	SlaMinutes          int                  `json:"sla_minutes"`
+	SlaBreachCount      int                  `json:"sla_breach_count"`
+	SlaBreaches         []CslDigestSlaBreach `json:"sla_breaches"`
+	PendingCount        int                  `json:"pending_approval_count"`
+	PendingApprovals    []CslDigestApproval  `json:"pending_approvals"`
+	GeneratedAt         int64                `json:"generated_at"`
+}
+
+func getDefaultCslDigestPreferences() CslDigestPreferences {
+	return CslDigestPreferences{
+		Frequency: DigestWeekly,
+		Hour:      17,
+		Weekday:   int(time.Friday),
+	}
+}
+
+func getCslDigestSubscriptions(ctx context.Context, orgId string) CslDigestSubscriptions {
+	subscriptions := CslDigestSubscriptions{}
+	_, err := getCslDocument(ctx, orgId, CslDigestsDocument, &subscriptions)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting digest subscriptions for org %s: %s", orgId, err)
+	}
+
+	if subscriptions.Users == nil {
+		subscriptions.Users = map[string]CslDigestPreferences{}
+	}
+
+	return subscriptions
+}
+
+func getCslDigestPreferences(ctx context.Context, orgId, userId string) CslDigestPreferences {
+	preferences, ok := getCslDigestSubscriptions(ctx, orgId).Users[userId]
+	if !ok {
+		return getDefaultCslDigestPreferences()
+	}
+
+	return preferences
+}
+
+func validateCslDigestPreferences(preferences CslDigestPreferences, user shuffle.User) error {
+	if preferences.Frequency != DigestDaily && preferences.Frequency != DigestWeekly {
+		return errors.New(fmt.Sprintf("frequency must be %s or %s", DigestDaily, DigestWeekly))
+	}
+
+	if preferences.Hour < 0 || preferences.Hour > 23 {
+		return errors.New("hour must be between 0 and 23")
+	}
+
+	if preferences.Weekday < 0 || preferences.Weekday > 6 {
+		return errors.New("weekday must be between 0 (sunday) and 6 (saturday)")
+	}
+
+	if len(preferences.Email) > 0 {
+		_, err := mail.ParseAddress(preferences.Email)
+		if err != nil {
+			return errors.New(fmt.Sprintf("email %s is not a valid email address", preferences.Email))
+		}
+	}
+
+	if preferences.Enabled && len(getDigestEmail(preferences, user)) == 0 {
+		return errors.New("email is required when the username isn't an email address")
+	}
+
+	return nil
+}
+
+func getDigestEmail(preferences CslDigestPreferences, user shuffle.User) string {
+	if len(preferences.Email) > 0 {
+		return preferences.Email
+	}
+
+	address, err := mail.ParseAddress(user.Username)
+	if err != nil {
+		return ""
+	}
+
+	return address.Address
+}
+
+// Counts cover today and the previous days-1 days, the same as the dashboard
+// endpoints with ?days=1 and ?days=7
+func getDigestDays(frequency string) int {
+	if frequency == DigestDaily {
+		return 1
+	}
+
+	return WeekLength
+}
+
+// Due once in the configured hour, on the configured weekday for weekly digests
+func isDigestDue(preferences CslDigestPreferences, now time.Time) bool {
+	if !preferences.Enabled || now.Hour() != preferences.Hour {
+		return false
+	}
+
+	if preferences.Frequency == DigestWeekly && int(now.Weekday()) != preferences.Weekday {
+		return false
+	}
+
+	return now.Unix()-preferences.LastSent > DigestMinIntervalSeconds
+}
+
+// SLA breaches are executions started since the start of the digest period
+// that ran, or have been running, longer than slaMinutes. Pending approvals
+// are executions waiting for a User Input action, regardless of age
+func getDigestExecutions(ctx context.Context, orgId string, since int64, slaMinutes int) ([]CslDigestSlaBreach, []CslDigestApproval, error) {
+	workflows, err := getOrgWorkflows(ctx, orgId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	workflowExecutions := make([][]shuffle.WorkflowExecution, len(workflows))
+	runConcurrentLookups(ctx, len(workflows), func(ctx context.Context, index int) error {
+		executions, err := shuffle.GetAllWorkflowExecutions(ctx, workflows[index].ID, DigestExecutionsPerWorkflow)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting executions for workflow %s in digest: %s", workflows[index].ID, err)
+			return nil
+		}
+
+		workflowExecutions[index] = executions
+		return nil
+	})
+
+	timeNow := time.Now().Unix()
+	breaches := []CslDigestSlaBreach{}
+	approvals := []CslDigestApproval{}
+	for i, workflow := range workflows {
+		for _, execution := range workflowExecutions[i] {
+			if execution.Status == "WAITING" {
+				for _, result := range execution.Results {
+					if result.Status != "WAITING" || result.Action.AppName != "User Input" {
+						continue
+					}
+
+					approvals = append(approvals, CslDigestApproval{
+						ExecutionId:  execution.ExecutionId,
+						WorkflowId:   workflow.ID,
+						WorkflowName: workflow.Name,
+						NodeId:       result.Action.ID,
+						Label:        result.Action.Label,
+						WaitingSince: execution.StartedAt,
+					})
+				}
+			}
+
+			if slaMinutes <= 0 || execution.StartedAt < since {
+				continue
+			}
+
+			completedAt := execution.CompletedAt
+			if completedAt == 0 && (execution.Status == "EXECUTING" || execution.Status == "WAITING") {
+				completedAt = timeNow
+			}
+
+			duration := completedAt - execution.StartedAt
+			if completedAt == 0 || duration <= int64(slaMinutes)*60 {
+				continue
+			}
+
+			breaches = append(breaches, CslDigestSlaBreach{
+				ExecutionId:     execution.ExecutionId,
+				WorkflowId:      workflow.ID,
+				WorkflowName:    workflow.Name,
+				Status:          execution.Status,
+				StartedAt:       execution.StartedAt,
+				DurationMinutes: duration / 60,
+			})
+		}
+	}
+
+	sort.SliceStable(breaches, func(i, j int) bool {
+		return breaches[i].DurationMinutes > breaches[j].DurationMinutes
+	})
+
+	sort.SliceStable(approvals, func(i, j int) bool {
+		return approvals[i].WaitingSince < approvals[j].WaitingSince
+	})
+
+	return breaches, approvals, nil
+}
+
+func buildCslDigest(ctx context.Context, orgId, frequency string) (CslDigest, error) {
+	orgStats, err := shuffle.GetOrgStatistics(ctx, orgId)
+	if err != nil {
+		return CslDigest{}, err
+	}
+
+	settings := getCslOrgSettings(ctx, orgId)
+	location := getTimezoneLocation(settings.Timezone)
+	days := getDigestDays(frequency)
+
+	now := time.Now().In(location)
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location).AddDate(0, 0, -(days - 1)).Unix()
+
+	stats := sumRecentDailyStatistics(orgStats, days)
+	digest := CslDigest{
+		OrgId:               orgId,
+		Frequency:           frequency,
+		Days:                days,
+		Timezone:            location.String(),
+		Since:               since,
+		Executions:          stats.WorkflowExecutions,
+		ExecutionsFinished:  stats.WorkflowExecutionsFinished,
+		ExecutionsFailed:    stats.WorkflowExecutionsFailed,
+		AppExecutions:       stats.AppExecutions,
+		AppExecutionsFailed: stats.AppExecutionsFailed,
+		Failures:            []CslFailureCount{},
+		SlaMinutes:          settings.SlaMinutes,
+		GeneratedAt:         now.Unix(),
+	}
+
+	if stats.WorkflowExecutions > 0 {
+		digest.FailureRate = roundScore(float64(stats.WorkflowExecutionsFailed) / float64(stats.WorkflowExecutions) * 100)
+	}
+
+	for _, failure := range getFailureCounts(orgStats, days).Categories {
+		if failure.Count > 0 {
+			digest.Failures = append(digest.Failures, failure)
+		}
+	}
+
+	org, err := shuffle.GetOrg(ctx, orgId)
+	if err == nil {
+		digest.OrgName = org.Name
+	}
+
+	breaches, approvals, err := getDigestExecutions(ctx, orgId, since, settings.SlaMinutes)
+	if err != nil {
+		return CslDigest{}, err
+	}
+
+	digest.SlaBreachCount = len(breaches)
+	digest.PendingCount = len(approvals)
+	if len(breaches) > MaxDigestItems {
+		breaches = breaches[:MaxDigestItems]
+	}
+
+	if len(approvals) > MaxDigestItems {
+		approvals = approvals[:MaxDigestItems]
+	}
+
+	digest.SlaBreaches = breaches
+	digest.PendingApprovals = approvals
+	return digest, nil
+}
+
+var digestTemplate = template.Must(template.New("digest").Funcs(template.FuncMap{
+	"formatTime": func(timestamp int64, timezone string) string {
+		return time.Unix(timestamp, 0).In(getTimezoneLocation(timezone)).Format("2006-01-02 15:04")
+	},
+}).Parse(`<html>
+<body style="font-family: Arial, sans-serif; color: #222;">
+<h2>Shuffle {{.Frequency}} digest for {{.OrgName}}</h2>
+<p>Since {{formatTime .Since .Timezone}} ({{.Timezone}})</p>
+
+<h3>Executions</h3>
+<table cellpadding="4">
+<tr><td>Workflow executions</td><td>{{.Executions}}</td></tr>
+<tr><td>Finished</td><td>{{.ExecutionsFinished}}</td></tr>
+<tr><td>Failed</td><td>{{.ExecutionsFailed}} ({{.FailureRate}}%)</td></tr>
+<tr><td>App runs</td><td>{{.AppExecutions}}</td></tr>
+<tr><td>Failed app runs</td><td>{{.AppExecutionsFailed}}</td></tr>
+</table>
+
+<h3>Failures</h3>
+{{if .Failures}}<table cellpadding="4">
+{{range .Failures}}<tr><td>{{.Category}}</td><td>{{.Count}}</td><td>{{.Percentage}}%</td></tr>
+{{end}}</table>{{else}}<p>No failures</p>{{end}}
+
+<h3>SLA breaches</h3>
+{{if eq .SlaMinutes 0}}<p>No SLA is configured</p>{{else if .SlaBreaches}}<p>{{.SlaBreachCount}} executions ran longer than {{.SlaMinutes}} minutes</p>
+<table cellpadding="4">
+{{range .SlaBreaches}}<tr><td>{{.WorkflowName}}</td><td>{{.ExecutionId}}</td><td>{{.DurationMinutes}} minutes</td><td>{{.Status}}</td></tr>
+{{end}}</table>{{else}}<p>No executions ran longer than {{.SlaMinutes}} minutes</p>{{end}}
+
+<h3>Pending approvals</h3>
+{{if .PendingApprovals}}<p>{{.PendingCount}} actions are waiting for input</p>
+<table cellpadding="4">
+{{range .PendingApprovals}}<tr><td>{{.WorkflowName}}</td><td>{{.Label}}</td><td>{{.ExecutionId}}</td><td>since {{formatTime .WaitingSince $.Timezone}}</td></tr>
+{{end}}</table>{{else}}<p>No pending approvals</p>{{end}}
+</body>
+</html>`))
+
+func renderCslDigest(digest CslDigest) (string, error) {
+	var body bytes.Buffer
+	err := digestTemplate.Execute(&body, digest)
+	if err != nil {
+		return "", err
+	}
+
+	return body.String(), nil
+}
+
+// Sends an HTML email with the configured SMTP server. STARTTLS is used when
+// the server supports it
+func sendCslEmail(to []string, subject, htmlBody string) error {
+	host := os.Getenv("SHUFFLE_SMTP_HOST")
+	if len(host) == 0 {
+		return errors.New("no SMTP server configured. Set SHUFFLE_SMTP_HOST")
+	}
+
+	port := os.Getenv("SHUFFLE_SMTP_PORT")
+	if len(port) == 0 {
+		port = "587"
+	}
+
+	fromValue := os.Getenv("SHUFFLE_SMTP_FROM")
+	if len(fromValue) == 0 {
+		fromValue = os.Getenv("SHUFFLE_SMTP_USERNAME")
+	}
+
+	from, err := mail.ParseAddress(fromValue)
+	if err != nil {
+		return errors.New(fmt.Sprintf("SHUFFLE_SMTP_FROM %s is not a valid email address", fromValue))
+	}
+
+	var auth smtp.Auth
+	if len(os.Getenv("SHUFFLE_SMTP_USERNAME")) > 0 {
+		auth = smtp.PlainAuth("", os.Getenv("SHUFFLE_SMTP_USERNAME"), os.Getenv("SHUFFLE_SMTP_PASSWORD"), host)
+	}
+
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n%s",
+		from.String(),
+		strings.Join(to, ", "),
+		mime.QEncoding.Encode("utf-8", subject),
+		time.Now().Format(time.RFC1123Z),
+		htmlBody,
+	)
+
+	return smtp.SendMail(fmt.Sprintf("%s:%s", host, port), auth, from.Address, to, []byte(message))
+}
+
+func sendCslDigest(email string, digest CslDigest) error {
+	body, err := renderCslDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("Shuffle %s digest for %s", digest.Frequency, digest.OrgName)
+	return sendCslEmail([]string{email}, subject, body)
+}
+
+// Job: sends the digests that are due to every subscribed user. Digests are
+// built once per org and frequency
+func runCslDigestJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for digest job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		subscriptions := getCslDigestSubscriptions(ctx, org.Id)
+		if len(subscriptions.Users) == 0 {
+			continue
+		}
+
+		now := time.Now().In(getTimezoneLocation(getCslOrgSettings(ctx, org.Id).Timezone))
+		digests := map[string]CslDigest{}
+		for _, orgUser := range org.Users {
+			preferences, ok := subscriptions.Users[orgUser.Id]
+			if !ok || !isDigestDue(preferences, now) {
+				continue
+			}
+
+			email := getDigestEmail(preferences, orgUser)
+			if len(email) == 0 {
+				log.Printf("[WARNING] No email for digest of user %s in org %s", orgUser.Id, org.Id)
+				continue
+			}
+
+			digest, ok := digests[preferences.Frequency]
+			if !ok {
+				digest, err = buildCslDigest(ctx, org.Id, preferences.Frequency)
+				if err != nil {
+					log.Printf("[ERROR] Failed building %s digest for org %s: %s", preferences.Frequency, org.Id, err)
+					break
+				}
+
+				digests[preferences.Frequency] = digest
+			}
+
+			err = sendCslDigest(email, digest)
+			if err != nil {
+				log.Printf("[ERROR] Failed sending %s digest to user %s in org %s: %s", preferences.Frequency, orgUser.Id, org.Id, err)
+				continue
+			}
+
+			userId := orgUser.Id
+			updated := CslDigestSubscriptions{}
+			err = updateCslDocument(ctx, org.Id, CslDigestsDocument, &updated, func() error {
+				userPreferences, ok := updated.Users[userId]
+				if !ok {
+					return errors.New("digest subscription was removed")
+				}
+
+				userPreferences.LastSent = now.Unix()
+				updated.Users[userId] = userPreferences
+				return nil
+			})
+			if err != nil {
+				log.Printf("[WARNING] Failed updating last sent digest of user %s in org %s: %s", userId, org.Id, err)
+			}
+		}
+	}
+}
+
+/*
+Digest:
+Returns the email digest preferences of the current user in the current
+organization. Digests are sent at the hour, and for weekly digests the weekday
+(0 is sunday), in the org timezone. Counts cover today and the previous days,
+like the dashboard with ?days=1 or ?days=7, so digests are best sent at the end
+of the day.
+
+	{
+	    "success": true,
+	    "data": {
+	        "enabled": true,
+	        "frequency": "weekly",
+	        "hour": 17,
+	        "weekday": 5,
+	        "email": "",
+	        "last_sent": 1700000000
+	    }
+	}
+*/
+func cslGetDigest(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslDigestPreferences(ctx, user.ActiveOrg.Id, user.Id),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetDigest")
+}
+
+/*
+Digest:
+Updates the email digest preferences of the current user. Body uses the same
+format as the data field returned from GET. Email defaults to the username when
+it is an email address.
+*/
+func cslSetDigest(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	preferences := getCslDigestPreferences(ctx, user.ActiveOrg.Id, user.Id)
+	lastSent := preferences.LastSent
+	err = json.Unmarshal(body, &preferences)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling digest preferences: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	preferences.Frequency = strings.ToLower(preferences.Frequency)
+	preferences.LastSent = lastSent
+	err = validateCslDigestPreferences(preferences, *user)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	subscriptions := CslDigestSubscriptions{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslDigestsDocument, &subscriptions, func() error {
+		if subscriptions.Users == nil {
+			subscriptions.Users = map[string]CslDigestPreferences{}
+		}
+
+		subscriptions.Users[user.Id] = preferences
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated digest preferences for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+
+	res := CslResponse{
+		Success: true,
+		Data:    preferences,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetDigest")
+}
+
+/*
+Digest:
+Returns the digest of the current organization as it would be sent now. Uses
+the frequency of the current user unless ?frequency=daily|weekly is set.
+SLA breaches require sla_minutes in the CSL settings.
+
+	{
+	    "success": true,
+	    "data": {
+	        "org_id": "...",
+	        "org_name": "Security",
+	        "frequency": "weekly",
+	        "days": 7,
+	        "timezone": "Europe/Oslo",
+	        "since": 1700000000,
+	        "executions": 1200,
+	        "executions_finished": 1150,
+	        "executions_failed": 50,
+	        "failure_rate": 4.2,
+	        "app_executions": 9000,
+	        "app_executions_failed": 120,
+	        "failures": [
+	            {
+	                "category": "auth",
+	                "count": 64,
+	                "today": 10,
+	                "total": 410,
+	                "percentage": 53.33
+	            }
+	        ],
+	        "sla_minutes": 30,
+	        "sla_breach_count": 2,
+	        "sla_breaches": [
+	            {
+	                "execution_id": "...",
+	                "workflow_id": "...",
+	                "workflow_name": "Phishing triage",
+	                "status": "FINISHED",
+	                "started_at": 1700000000,
+	                "duration_minutes": 45
+	            }
+	        ],
+	        "pending_approval_count": 1,
+	        "pending_approvals": [
+	            {
+	                "execution_id": "...",
+	                "workflow_id": "...",
+	                "workflow_name": "Block IP",
+	                "node_id": "...",
+	                "label": "Approve block",
+	                "waiting_since": 1700000000
+	            }
+	        ],
+	        "generated_at": 1700000000
+	    }
+	}
+*/
+func cslPreviewDigest(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	frequency := strings.ToLower(request.URL.Query().Get("frequency"))
+	if len(frequency) == 0 {
+		frequency = getCslDigestPreferences(ctx, user.ActiveOrg.Id, user.Id).Frequency
+	}
+
+	if frequency != DigestDaily && frequency != DigestWeekly {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("frequency must be %s or %s", DigestDaily, DigestWeekly))))
+		return
+	}
+
+	digest, err := buildCslDigest(ctx, user.ActiveOrg.Id, frequency)
+	if err != nil {
+		log.Printf("[ERROR] Failed building digest for org %s: %s", user.ActiveOrg.Id, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    digest,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslPreviewDigest")
+}
+
+/*
+Digest:
+Sends the digest of the current user right away, regardless of the schedule
+and whether digests are enabled. Returns the digest that was sent, in the same
+format as the preview.
+*/
+func cslSendDigest(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	preferences := getCslDigestPreferences(ctx, user.ActiveOrg.Id, user.Id)
+	email := getDigestEmail(preferences, *user)
+	if len(email) == 0 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("no email set in the digest preferences")))
+		return
+	}
+
+	digest, err := buildCslDigest(ctx, user.ActiveOrg.Id, preferences.Frequency)
+	if err != nil {
+		log.Printf("[ERROR] Failed building digest for org %s: %s", user.ActiveOrg.Id, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	err = sendCslDigest(email, digest)
+	if err != nil {
+		log.Printf("[ERROR] Failed sending digest to user %s: %s", user.Id, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    digest,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSendDigest")
+}
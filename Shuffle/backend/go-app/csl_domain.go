@@ -0,0 +1,754 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+	"golang.org/x/net/publicsuffix"
+)
+
+const CslDomainEnrichmentDocument = "domain_enrichment"
+
+// Bootstrap service redirecting to the RDAP server of each TLD
+const RdapBootstrapUrl = "https://rdap.org"
+
+const DefaultCirclPdnsUrl = "https://www.circl.lu/pdns/query"
+const DefaultSecurityTrailsUrl = "https://api.securitytrails.com/v1"
+
+const MaxPassiveDnsProviders = 5
+
+// Passive DNS records returned per lookup, newest first
+const MaxPassiveDnsRecords = 200
+
+// Domains registered more recently than this are flagged as new
+const NewDomainDays = 30
+
+// Passive DNS provider types
+const (
+	PassiveDnsCircl          = "circl"
+	PassiveDnsSecurityTrails = "securitytrails"
+)
+
+var passiveDnsTypes = []string{PassiveDnsCircl, PassiveDnsSecurityTrails}
+
+// Lookup sources
+const (
+	DomainSourceWhois = "whois"
+	DomainSourcePdns  = "pdns"
+)
+
+// Url overrides the default API of the provider type, e.g. for a self-hosted
+// CIRCL compatible server. Username is used for basic auth with the API key
+// as password
+type CslPassiveDnsProvider struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Url      string `json:"url"`
+	Username string `json:"username"`
+	ApiKey   string `json:"api_key"`
+}
+
+type CslDomainEnrichmentConfig struct {
+	Enabled    bool                    `json:"enabled"`
+	Whois      bool                    `json:"whois"`
+	Providers  []CslPassiveDnsProvider `json:"providers"`
+	CacheHours int                     `json:"cache_hours"`
+}
+
+type CslDomainEnrichmentStats struct {
+	Since     int64  `json:"since"`
+	Lookups   int64  `json:"lookups"`
+	CacheHits int64  `json:"cache_hits"`
+	Errors    int64  `json:"errors"`
+	LastError string `json:"last_error"`
+}
+
+type CslDomainEnrichment struct {
+	Config CslDomainEnrichmentConfig `json:"config"`
+	Stats  CslDomainEnrichmentStats  `json:"stats"`
+}
+
+// AgeDays is the time since registration, or -1 when the registry doesn't
+// publish it
+type CslWhois struct {
+	Domain      string   `json:"domain"`
+	Registrar   string   `json:"registrar"`
+	Registered  string   `json:"registered"`
+	Updated     string   `json:"updated"`
+	Expires     string   `json:"expires"`
+	AgeDays     int      `json:"age_days"`
+	NewDomain   bool     `json:"new_domain"`
+	Status      []string `json:"status"`
+	Nameservers []string `json:"nameservers"`
+}
+
+type CslPassiveDnsRecord struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+	FirstSeen int64  `json:"first_seen"`
+	LastSeen  int64  `json:"last_seen"`
+	Count     int64  `json:"count"`
+	Provider  string `json:"provider"`
+}
+
+// Errors holds the sources and providers that failed, so the result is
+// based on the others
+type CslDomainInfo struct {
+	Domain           string                `json:"domain"`
+	RegisteredDomain string                `json:"registered_domain"`
+	Whois            *CslWhois             `json:"whois,omitempty"`
+	PassiveDns       []CslPassiveDnsRecord `json:"passive_dns"`
+	Errors           map[string]string     `json:"errors"`
+	Fetched          int64                 `json:"fetched"`
+	Cached           bool                  `json:"cached"`
+}
+
+func getCslDomainEnrichment(ctx context.Context, orgId string) CslDomainEnrichment {
+	enrichment := CslDomainEnrichment{}
+	found, err := getCslDocument(ctx, orgId, CslDomainEnrichmentDocument, &enrichment)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting domain enrichment config for org %s: %s", orgId, err)
+	}
+
+	// WHOIS needs no account, so it's on until turned off
+	if !found {
+		enrichment.Config.Whois = true
+	}
+
+	if enrichment.Config.Providers == nil {
+		enrichment.Config.Providers = []CslPassiveDnsProvider{}
+	}
+
+	if enrichment.Config.CacheHours == 0 {
+		enrichment.Config.CacheHours = DefaultReputationCacheHours
+	}
+
+	return enrichment
+}
+
+func redactCslDomainEnrichmentConfig(config CslDomainEnrichmentConfig) CslDomainEnrichmentConfig {
+	providers := []CslPassiveDnsProvider{}
+	for _, provider := range config.Providers {
+		if len(provider.ApiKey) > 0 {
+			provider.ApiKey = RedactedValue
+		}
+
+		providers = append(providers, provider)
+	}
+
+	config.Providers = providers
+	return config
+}
+
+func validateCslDomainEnrichmentConfig(config CslDomainEnrichmentConfig) error {
+	if len(config.Providers) > MaxPassiveDnsProviders {
+		return errors.New(fmt.Sprintf("can't have more than %d passive dns providers", MaxPassiveDnsProviders))
+	}
+
+	names := []string{}
+	for _, provider := range config.Providers {
+		if len(provider.Name) == 0 {
+			return errors.New("providers need a name")
+		}
+
+		if shuffle.ArrayContains(names, provider.Name) {
+			return errors.New(fmt.Sprintf("provider %s is defined twice", provider.Name))
+		}
+
+		names = append(names, provider.Name)
+		if !shuffle.ArrayContains(passiveDnsTypes, provider.Type) {
+			return errors.New(fmt.Sprintf("type of provider %s must be one of %s", provider.Name, strings.Join(passiveDnsTypes, ", ")))
+		}
+
+		if len(provider.Url) > 0 && !strings.HasPrefix(provider.Url, "https://") {
+			return errors.New(fmt.Sprintf("url of provider %s must start with https://", provider.Name))
+		}
+
+		if len(provider.ApiKey) == 0 {
+			return errors.New(fmt.Sprintf("provider %s needs an api_key", provider.Name))
+		}
+	}
+
+	if config.CacheHours < 0 || config.CacheHours > MaxVirusTotalCacheHours {
+		return errors.New(fmt.Sprintf("cache_hours must be between 1 and %d", MaxVirusTotalCacheHours))
+	}
+
+	return nil
+}
+
+// Accepts a defanged domain or a URL, and returns the lowercase host name
+func normalizeEnrichmentDomain(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(refangObservables(value)))
+	if strings.Contains(value, "://") {
+		parsedUrl, err := url.Parse(value)
+		if err != nil {
+			return "", errors.New("domain is not a valid url")
+		}
+
+		value = parsedUrl.Hostname()
+	}
+
+	value = strings.TrimSuffix(value, ".")
+	if !observableDomainPattern.MatchString(value) || observableDomainPattern.FindString(value) != value {
+		return "", errors.New("domain is not a valid domain name")
+	}
+
+	return value, nil
+}
+
+func domainRequest(ctx context.Context, requestUrl string, headers map[string]string, username, password string) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", requestUrl, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	if len(username) > 0 {
+		req.SetBasicAuth(username, password)
+	}
+
+	client := shuffle.GetExternalClient(requestUrl)
+	newresp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer newresp.Body.Close()
+	respBody, err := ioutil.ReadAll(newresp.Body)
+	if err != nil {
+		return nil, newresp.StatusCode, err
+	}
+
+	if newresp.StatusCode >= 300 && newresp.StatusCode != 404 {
+		return nil, newresp.StatusCode, errors.New(fmt.Sprintf("status code %d: %s", newresp.StatusCode, truncateText(string(respBody), 500)))
+	}
+
+	return respBody, newresp.StatusCode, nil
+}
+
+// Looks up registration data over RDAP, which has replaced port 43 WHOIS for
+// gTLDs and returns structured JSON
+func fetchCslWhois(ctx context.Context, domain string) (CslWhois, error) {
+	whois := CslWhois{
+		Domain:      domain,
+		AgeDays:     -1,
+		Status:      []string{},
+		Nameservers: []string{},
+	}
+
+	respBody, statusCode, err := domainRequest(ctx, fmt.Sprintf("%s/domain/%s", RdapBootstrapUrl, domain), map[string]string{"Accept": "application/rdap+json"}, "", "")
+	if err != nil {
+		return whois, err
+	}
+
+	if statusCode == 404 {
+		return whois, errors.New("domain is not registered or its registry has no rdap server")
+	}
+
+	rdap := struct {
+		Status []string `json:"status"`
+		Events []struct {
+			Action string `json:"eventAction"`
+			Date   string `json:"eventDate"`
+		} `json:"events"`
+		Entities []struct {
+			Roles      []string      `json:"roles"`
+			VcardArray []interface{} `json:"vcardArray"`
+		} `json:"entities"`
+		Nameservers []struct {
+			LdhName string `json:"ldhName"`
+		} `json:"nameservers"`
+	}{}
+
+	err = json.Unmarshal(respBody, &rdap)
+	if err != nil {
+		return whois, errors.New(fmt.Sprintf("failed parsing rdap response: %s", err))
+	}
+
+	whois.Status = append(whois.Status, rdap.Status...)
+	for _, nameserver := range rdap.Nameservers {
+		whois.Nameservers = append(whois.Nameservers, strings.ToLower(nameserver.LdhName))
+	}
+
+	for _, event := range rdap.Events {
+		switch event.Action {
+		case "registration":
+			whois.Registered = event.Date
+		case "last changed":
+			whois.Updated = event.Date
+		case "expiration":
+			whois.Expires = event.Date
+		}
+	}
+
+	if registered, err := time.Parse(time.RFC3339, whois.Registered); err == nil {
+		whois.AgeDays = int(time.Since(registered).Hours() / 24)
+		whois.NewDomain = whois.AgeDays < NewDomainDays
+	}
+
+	// The registrar is the fn property of the vCard of the registrar entity
+	for _, entity := range rdap.Entities {
+		if !shuffle.ArrayContains(entity.Roles, "registrar") || len(entity.VcardArray) < 2 {
+			continue
+		}
+
+		properties, _ := entity.VcardArray[1].([]interface{})
+		for _, property := range properties {
+			fields, ok := property.([]interface{})
+			if !ok || len(fields) < 4 || fields[0] != "fn" {
+				continue
+			}
+
+			whois.Registrar, _ = fields[3].(string)
+		}
+	}
+
+	return whois, nil
+}
+
+// CIRCL returns one JSON record per line
+func fetchCirclPassiveDns(ctx context.Context, provider CslPassiveDnsProvider, domain string) ([]CslPassiveDnsRecord, error) {
+	baseUrl := provider.Url
+	if len(baseUrl) == 0 {
+		baseUrl = DefaultCirclPdnsUrl
+	}
+
+	respBody, _, err := domainRequest(ctx, fmt.Sprintf("%s/%s", strings.TrimRight(baseUrl, "/"), domain), map[string]string{}, provider.Username, provider.ApiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []CslPassiveDnsRecord{}
+	scanner := bufio.NewScanner(bytes.NewReader(respBody))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		record := struct {
+			Rrname    string `json:"rrname"`
+			Rrtype    string `json:"rrtype"`
+			Rdata     string `json:"rdata"`
+			TimeFirst int64  `json:"time_first"`
+			TimeLast  int64  `json:"time_last"`
+			Count     int64  `json:"count"`
+		}{}
+
+		err := json.Unmarshal(line, &record)
+		if err != nil {
+			return records, errors.New(fmt.Sprintf("failed parsing passive dns record: %s", err))
+		}
+
+		records = append(records, CslPassiveDnsRecord{
+			Name:      strings.TrimSuffix(record.Rrname, "."),
+			Type:      record.Rrtype,
+			Value:     strings.TrimSuffix(record.Rdata, "."),
+			FirstSeen: record.TimeFirst,
+			LastSeen:  record.TimeLast,
+			Count:     record.Count,
+			Provider:  provider.Name,
+		})
+	}
+
+	return records, nil
+}
+
+// SecurityTrails keeps the history of A records with the first and last day
+// each set of addresses was seen
+func fetchSecurityTrailsPassiveDns(ctx context.Context, provider CslPassiveDnsProvider, domain string) ([]CslPassiveDnsRecord, error) {
+	baseUrl := provider.Url
+	if len(baseUrl) == 0 {
+		baseUrl = DefaultSecurityTrailsUrl
+	}
+
+	requestUrl := fmt.Sprintf("%s/history/%s/dns/a", strings.TrimRight(baseUrl, "/"), domain)
+	respBody, statusCode, err := domainRequest(ctx, requestUrl, map[string]string{"APIKEY": provider.ApiKey}, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	records := []CslPassiveDnsRecord{}
+	if statusCode == 404 {
+		return records, nil
+	}
+
+	history := struct {
+		Records []struct {
+			FirstSeen string `json:"first_seen"`
+			LastSeen  string `json:"last_seen"`
+			Values    []struct {
+				Ip string `json:"ip"`
+			} `json:"values"`
+		} `json:"records"`
+	}{}
+
+	err = json.Unmarshal(respBody, &history)
+	if err != nil {
+		return records, errors.New(fmt.Sprintf("failed parsing securitytrails response: %s", err))
+	}
+
+	for _, record := range history.Records {
+		firstSeen, _ := time.Parse("2006-01-02", record.FirstSeen)
+		lastSeen, _ := time.Parse("2006-01-02", record.LastSeen)
+		for _, value := range record.Values {
+			records = append(records, CslPassiveDnsRecord{
+				Name:      domain,
+				Type:      "A",
+				Value:     value.Ip,
+				FirstSeen: firstSeen.Unix(),
+				LastSeen:  lastSeen.Unix(),
+				Provider:  provider.Name,
+			})
+		}
+	}
+
+	return records, nil
+}
+
+// Looks up WHOIS and passive DNS for a domain through the cache of the org.
+// Sources that fail are listed in Errors and aren't cached, so they're tried
+// again on the next lookup
+func getCslDomainInfo(ctx context.Context, orgId string, config CslDomainEnrichmentConfig, domain string, sources []string) (CslDomainInfo, error) {
+	registeredDomain, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		registeredDomain = domain
+	}
+
+	info := CslDomainInfo{
+		Domain:           domain,
+		RegisteredDomain: registeredDomain,
+		PassiveDns:       []CslPassiveDnsRecord{},
+		Errors:           map[string]string{},
+		Fetched:          time.Now().Unix(),
+		Cached:           true,
+	}
+
+	cacheHit := func(key string, result interface{}) bool {
+		cache, err := shuffle.GetCache(ctx, key)
+		cacheData, ok := cache.([]uint8)
+		return err == nil && ok && json.Unmarshal(cacheData, result) == nil
+	}
+
+	cacheStore := func(key string, result interface{}) {
+		cacheData, err := json.Marshal(result)
+		if err == nil {
+			err = shuffle.SetCache(ctx, key, cacheData, int32(config.CacheHours*60))
+		}
+
+		if err != nil {
+			log.Printf("[WARNING] Failed caching domain enrichment for org %s: %s", orgId, err)
+		}
+	}
+
+	// Registration data belongs to the registered domain, not subdomains
+	if config.Whois && shuffle.ArrayContains(sources, DomainSourceWhois) {
+		whois := CslWhois{}
+		cacheKey := fmt.Sprintf("csl_whois_%s_%s", orgId, registeredDomain)
+		if !cacheHit(cacheKey, &whois) {
+			info.Cached = false
+			whois, err = fetchCslWhois(ctx, registeredDomain)
+			if err != nil {
+				info.Errors[DomainSourceWhois] = err.Error()
+			} else {
+				cacheStore(cacheKey, whois)
+			}
+		}
+
+		if _, failed := info.Errors[DomainSourceWhois]; !failed {
+			info.Whois = &whois
+		}
+	}
+
+	if shuffle.ArrayContains(sources, DomainSourcePdns) {
+		for _, provider := range config.Providers {
+			records := []CslPassiveDnsRecord{}
+			cacheKey := fmt.Sprintf("csl_pdns_%s_%s_%s", orgId, provider.Name, domain)
+			if !cacheHit(cacheKey, &records) {
+				info.Cached = false
+				if provider.Type == PassiveDnsCircl {
+					records, err = fetchCirclPassiveDns(ctx, provider, domain)
+				} else {
+					records, err = fetchSecurityTrailsPassiveDns(ctx, provider, domain)
+				}
+
+				if err != nil {
+					info.Errors[provider.Name] = err.Error()
+					continue
+				}
+
+				cacheStore(cacheKey, records)
+			}
+
+			info.PassiveDns = append(info.PassiveDns, records...)
+		}
+	}
+
+	sort.SliceStable(info.PassiveDns, func(i, j int) bool {
+		return info.PassiveDns[i].LastSeen > info.PassiveDns[j].LastSeen
+	})
+
+	info.PassiveDns = info.PassiveDns[:min(len(info.PassiveDns), MaxPassiveDnsRecords)]
+
+	enrichment := CslDomainEnrichment{}
+	updateErr := updateCslDocument(ctx, orgId, CslDomainEnrichmentDocument, &enrichment, func() error {
+		if enrichment.Stats.Since == 0 {
+			enrichment.Stats.Since = time.Now().Unix()
+		}
+
+		enrichment.Stats.Lookups += 1
+		if info.Cached {
+			enrichment.Stats.CacheHits += 1
+		}
+
+		for source, sourceErr := range info.Errors {
+			enrichment.Stats.Errors += 1
+			enrichment.Stats.LastError = fmt.Sprintf("%s: %s", source, sourceErr)
+		}
+
+		return nil
+	})
+	if updateErr != nil {
+		log.Printf("[WARNING] Failed recording domain enrichment for org %s: %s", orgId, updateErr)
+	}
+
+	if info.Whois == nil && len(info.PassiveDns) == 0 && len(info.Errors) > 0 {
+		return info, errors.New("every domain enrichment source failed")
+	}
+
+	return info, nil
+}
+
+/*
+Enrichment:
+Returns the WHOIS registration data and passive DNS history of a domain.
+Requires ?domain=<domain or url>, which may be defanged. Optional
+?sources=whois,pdns picks the sources, and defaults to both. Workflows use
+?execution_id=<id>&authorization=<execution authorization>. Results are
+cached for the organization, and sources that fail are listed in errors.
+
+	{
+	    "success": true,
+	    "data": {
+	        "domain": "login.evil.example.com",
+	        "registered_domain": "example.com",
+	        "whois": {
+	            "domain": "example.com",
+	            "registrar": "Example Registrar, Inc.",
+	            "registered": "2024-01-01T00:00:00Z",
+	            "updated": "2024-01-02T00:00:00Z",
+	            "expires": "2025-01-01T00:00:00Z",
+	            "age_days": 12,
+	            "new_domain": true,
+	            "status": ["client transfer prohibited"],
+	            "nameservers": ["ns1.example.net"]
+	        },
+	        "passive_dns": [
+	            {
+	                "name": "login.evil.example.com",
+	                "type": "A",
+	                "value": "203.0.113.7",
+	                "first_seen": 1700000000,
+	                "last_seen": 1700500000,
+	                "count": 14,
+	                "provider": "circl"
+	            }
+	        ],
+	        "errors": {},
+	        "fetched": 1700600000,
+	        "cached": false
+	    }
+	}
+*/
+func cslDomainEnrichment(resp http.ResponseWriter, request *http.Request) {
+	orgId, _, _, ok := handleCslExecutionRequest(resp, request)
+	if !ok {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	config := getCslDomainEnrichment(ctx, orgId).Config
+	if !config.Enabled {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("domain enrichment is not enabled")))
+		return
+	}
+
+	domain, err := normalizeEnrichmentDomain(query.Get("domain"))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	sources := []string{DomainSourceWhois, DomainSourcePdns}
+	if len(query.Get("sources")) > 0 {
+		sources = strings.Split(query.Get("sources"), ",")
+	}
+
+	info, err := getCslDomainInfo(ctx, orgId, config, domain, sources)
+	if err != nil {
+		resp.WriteHeader(502)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    info,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslDomainEnrichment")
+}
+
+/*
+Enrichment:
+Returns the domain enrichment configuration and lookup statistics. Requires
+org admin. WHOIS uses RDAP and needs no account. Passive DNS providers are of
+type circl or securitytrails.
+
+	{
+	    "success": true,
+	    "data": {
+	        "config": {
+	            "enabled": true,
+	            "whois": true,
+	            "providers": [
+	                {
+	                    "name": "circl",
+	                    "type": "circl",
+	                    "url": "",
+	                    "username": "soc",
+	                    "api_key": "********"
+	                }
+	            ],
+	            "cache_hours": 24
+	        },
+	        "stats": {
+	            "since": 1700000000,
+	            "lookups": 240,
+	            "cache_hits": 180,
+	            "errors": 2,
+	            "last_error": "circl: status code 401: ..."
+	        }
+	    }
+	}
+*/
+func cslGetDomainEnrichmentConfig(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	enrichment := getCslDomainEnrichment(ctx, user.ActiveOrg.Id)
+	enrichment.Config = redactCslDomainEnrichmentConfig(enrichment.Config)
+
+	res := CslResponse{
+		Success: true,
+		Data:    enrichment,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetDomainEnrichmentConfig")
+}
+
+/*
+Enrichment:
+Updates the domain enrichment configuration. Requires org admin. Body uses the
+format of the config field returned from GET, and redacted API keys are kept
+as they are for providers with the same name.
+*/
+func cslSetDomainEnrichmentConfig(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	config := CslDomainEnrichmentConfig{}
+	err = json.Unmarshal(body, &config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if config.Providers == nil {
+		config.Providers = []CslPassiveDnsProvider{}
+	}
+
+	previous := getCslDomainEnrichment(ctx, user.ActiveOrg.Id).Config
+	for index, provider := range config.Providers {
+		if provider.ApiKey != RedactedValue {
+			continue
+		}
+
+		config.Providers[index].ApiKey = ""
+		for _, previousProvider := range previous.Providers {
+			if previousProvider.Name == provider.Name {
+				config.Providers[index].ApiKey = previousProvider.ApiKey
+			}
+		}
+	}
+
+	err = validateCslDomainEnrichmentConfig(config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if config.CacheHours == 0 {
+		config.CacheHours = DefaultReputationCacheHours
+	}
+
+	enrichment := CslDomainEnrichment{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslDomainEnrichmentDocument, &enrichment, func() error {
+		enrichment.Config = config
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated domain enrichment config for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "domain_enrichment_updated", "Domain enrichment configuration was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslDomainEnrichmentConfig(config),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetDomainEnrichmentConfig")
+}
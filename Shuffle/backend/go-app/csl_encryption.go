@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Migrates app authentication values and webhook secrets to envelope
+// encryption once SHUFFLE_ENVELOPE_KMS is set, and rewraps them after the key
+// is rotated.
+// The encryption itself is in shuffle-shared envelope.go.
+
+// How often the encryption_migration job looks for values to migrate
+const EncryptionMigrationMinutes = 60
+
+const (
+	EncryptionUnencrypted = "unencrypted"
+	EncryptionLegacy      = "legacy"
+)
+
+type CslEncryptionMigration struct {
+	Started  int64    `json:"started"`
+	Finished int64    `json:"finished"`
+	Orgs     int      `json:"orgs"`
+	Migrated int      `json:"migrated"`
+	Failed   []string `json:"failed"`
+}
+
+type CslEncryptionStatus struct {
+	Enabled       bool                    `json:"enabled"`
+	Kms           string                  `json:"kms"`
+	ActiveKey     string                  `json:"active_key"`
+	Error         string                  `json:"error,omitempty"`
+	Values        map[string]int          `json:"values"`
+	LastMigration *CslEncryptionMigration `json:"last_migration"`
+}
+
+var cslEncryptionMigrations = struct {
+	sync.Mutex
+	running bool
+	last    *CslEncryptionMigration
+}{}
+
+// Where a value is encrypted: <kms>/<key> for envelope encrypted values,
+// legacy for values encrypted with SHUFFLE_ENCRYPTION_MODIFIER
+func getValueEncryption(auth shuffle.AppAuthenticationStorage, value string) string {
+	if !auth.Encrypted {
+		return EncryptionUnencrypted
+	}
+
+	kms, keyId, ok := shuffle.GetEnvelopeKey([]byte(value))
+	if !ok {
+		return EncryptionLegacy
+	}
+
+	return fmt.Sprintf("%s/%s", kms, keyId)
+}
+
+func migrateOrgEncryption(ctx context.Context, orgId string, migration *CslEncryptionMigration) {
+	auths, err := shuffle.GetAllWorkflowAppAuth(ctx, orgId)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting app auths of org %s for encryption migration: %s", orgId, err)
+		return
+	}
+
+	for _, auth := range auths {
+		if auth.OrgId != orgId {
+			continue
+		}
+
+		changed, err := shuffle.MigrateAuthEncryption(ctx, &auth)
+		if err == nil && changed {
+			err = shuffle.SetWorkflowAppAuthDatastore(ctx, auth, auth.Id)
+		}
+
+		if err != nil {
+			log.Printf("[ERROR] Failed migrating encryption of app auth %s in org %s: %s", auth.Id, orgId, err)
+			migration.Failed = append(migration.Failed, auth.Id)
+			continue
+		}
+
+		if changed {
+			migration.Migrated += 1
+		}
+	}
+
+	migrated, err := migrateCslWebhookSecrets(ctx, orgId)
+	if err != nil {
+		log.Printf("[ERROR] Failed migrating encryption of webhook secrets in org %s: %s", orgId, err)
+		migration.Failed = append(migration.Failed, CslWebhookSecurityDocument)
+	}
+
+	migration.Migrated += migrated
+}
+
+// Migrates every org. Only one migration runs at a time in this backend
+func runCslEncryptionMigration(ctx context.Context) (*CslEncryptionMigration, error) {
+	if !shuffle.IsEnvelopeEncryptionEnabled() {
+		return nil, errors.New("envelope encryption isn't enabled. Set SHUFFLE_ENVELOPE_KMS")
+	}
+
+	cslEncryptionMigrations.Lock()
+	if cslEncryptionMigrations.running {
+		cslEncryptionMigrations.Unlock()
+		return nil, errors.New("a migration is already running")
+	}
+
+	cslEncryptionMigrations.running = true
+	cslEncryptionMigrations.Unlock()
+
+	migration := &CslEncryptionMigration{
+		Started: time.Now().Unix(),
+		Failed:  []string{},
+	}
+
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for encryption migration: %s", err)
+	}
+
+	for _, org := range orgs {
+		migrateOrgEncryption(ctx, org.Id, migration)
+	}
+
+	migration.Orgs = len(orgs)
+	migration.Finished = time.Now().Unix()
+	if migration.Migrated > 0 || len(migration.Failed) > 0 {
+		log.Printf("[INFO] Migrated encryption of %d app auths in %d orgs. %d failed", migration.Migrated, migration.Orgs, len(migration.Failed))
+	}
+
+	cslEncryptionMigrations.Lock()
+	cslEncryptionMigrations.running = false
+	cslEncryptionMigrations.last = migration
+	cslEncryptionMigrations.Unlock()
+
+	return migration, err
+}
+
+func runCslEncryptionMigrationJob(ctx context.Context) {
+	if !shuffle.IsEnvelopeEncryptionEnabled() {
+		return
+	}
+
+	_, err := runCslEncryptionMigration(ctx)
+	if err != nil {
+		log.Printf("[WARNING] Encryption migration job: %s", err)
+	}
+}
+
+/*
+Encryption:
+Returns how the app authentication values of the org are encrypted, and the
+last migration in this backend. values counts fields by <kms>/<key> for
+envelope encrypted values, legacy for values encrypted before envelope
+encryption was enabled and unencrypted.
+
+	{
+	    "success": true,
+	    "data": {
+	        "enabled": true,
+	        "kms": "local",
+	        "active_key": "2024-06",
+	        "values": {
+	            "local/2024-06": 12,
+	            "local/2024-01": 3,
+	            "legacy": 1
+	        },
+	        "last_migration": {
+	            "started": 1718000000,
+	            "finished": 1718000004,
+	            "orgs": 4,
+	            "migrated": 9,
+	            "failed": []
+	        }
+	    }
+	}
+*/
+func cslGetEncryptionStatus(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	status := CslEncryptionStatus{
+		Values: map[string]int{},
+	}
+
+	kms, keyId, err := shuffle.GetEnvelopeActiveKey()
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	status.Enabled = len(kms) > 0
+	status.Kms = kms
+	status.ActiveKey = keyId
+
+	auths, err := shuffle.GetAllWorkflowAppAuth(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting app auths of org %s for encryption status: %s", user.ActiveOrg.Id, err)
+	}
+
+	for _, auth := range auths {
+		if auth.OrgId != user.ActiveOrg.Id {
+			continue
+		}
+
+		for _, field := range auth.Fields {
+			if len(field.Value) > 0 {
+				status.Values[getValueEncryption(auth, field.Value)] += 1
+			}
+		}
+	}
+
+	cslEncryptionMigrations.Lock()
+	status.LastMigration = cslEncryptionMigrations.last
+	cslEncryptionMigrations.Unlock()
+
+	res := CslResponse{
+		Success: true,
+		Data:    status,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetEncryptionStatus")
+}
+
+/*
+Encryption:
+Starts migrating app authentication values of every org to the active key
+right away, instead of waiting for the encryption_migration job. Use after
+rotating keys. Requires support access. Progress is in last_migration of
+GET /api/v1/csl/encryption.
+*/
+func cslMigrateEncryption(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !user.SupportAccess {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("migrating encryption requires support access")))
+		return
+	}
+
+	if !shuffle.IsEnvelopeEncryptionEnabled() {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("envelope encryption isn't enabled. Set SHUFFLE_ENVELOPE_KMS")))
+		return
+	}
+
+	cslEncryptionMigrations.Lock()
+	running := cslEncryptionMigrations.running
+	cslEncryptionMigrations.Unlock()
+	if running {
+		resp.WriteHeader(409)
+		resp.Write(createCslErrorResponse(errors.New("a migration is already running")))
+		return
+	}
+
+	go func() {
+		_, err := runCslEncryptionMigration(context.Background())
+		if err != nil {
+			log.Printf("[WARNING] Encryption migration started by %s: %s", user.Username, err)
+		}
+	}()
+
+	log.Printf("[AUDIT] User %s (%s) started an encryption migration of app authentication", user.Username, user.Id)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslMigrateEncryption")
+}
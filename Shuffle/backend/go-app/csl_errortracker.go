@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Reports backend errors to an error tracker: panics in handlers, routes
+// repeatedly answering with 5xx and internal errors when starting
+// executions. Events go to Sentry when SHUFFLE_SENTRY_DSN is set, and as JSON
+// to SHUFFLE_ERROR_TRACKER_URL for other trackers. Both can be set.
+//
+// Events are sent in the background and dropped if the tracker can't keep up,
+// so reporting never slows requests down. The same error is reported at most
+// once per minute.
+
+const (
+	ErrorKindPanic     = "panic"
+	ErrorKindHttp5xx   = "http_5xx"
+	ErrorKindExecution = "execution"
+)
+
+const ErrorTrackerQueueSize = 100
+const ErrorTrackerDedupSeconds = 60
+
+// 5xx responses of a route within the window before it's reported
+const DefaultServerErrorThreshold = 5
+const ServerErrorWindowSeconds = 300
+
+type CslErrorEvent struct {
+	Id          string            `json:"id"`
+	Kind        string            `json:"kind"`
+	Level       string            `json:"level"`
+	Message     string            `json:"message"`
+	Timestamp   int64             `json:"timestamp"`
+	Hostname    string            `json:"hostname"`
+	Environment string            `json:"environment"`
+	Release     string            `json:"release,omitempty"`
+	OrgId       string            `json:"org_id,omitempty"`
+	Route       string            `json:"route,omitempty"`
+	Method      string            `json:"method,omitempty"`
+	Path        string            `json:"path,omitempty"`
+	Status      int               `json:"status,omitempty"`
+	Count       int               `json:"count,omitempty"`
+	WorkflowId  string            `json:"workflow_id,omitempty"`
+	ExecutionId string            `json:"execution_id,omitempty"`
+	Stack       string            `json:"stack,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+type cslServerErrors struct {
+	windowStart int64
+	count       int
+	reported    bool
+}
+
+var cslErrorTracker = struct {
+	sync.Mutex
+	once         sync.Once
+	events       chan CslErrorEvent
+	lastSent     map[string]int64
+	serverErrors map[string]*cslServerErrors
+}{
+	lastSent:     map[string]int64{},
+	serverErrors: map[string]*cslServerErrors{},
+}
+
+func errorTrackerEnabled() bool {
+	return len(os.Getenv("SHUFFLE_SENTRY_DSN")) > 0 || len(os.Getenv("SHUFFLE_ERROR_TRACKER_URL")) > 0
+}
+
+func getServerErrorThreshold() int {
+	threshold, err := strconv.Atoi(os.Getenv("SHUFFLE_ERROR_TRACKER_5XX_THRESHOLD"))
+	if err != nil || threshold < 1 {
+		return DefaultServerErrorThreshold
+	}
+
+	return threshold
+}
+
+// Adds org and request details to the event
+func addErrorRequestContext(event *CslErrorEvent, request *http.Request) {
+	event.Route = getUsageEndpoint(request)
+	event.Method = request.Method
+	event.Path = request.URL.Path
+	event.OrgId = getCslRequestOrgId(request)
+	event.Extra = map[string]string{
+		"remote":     request.RemoteAddr,
+		"user_agent": request.Header.Get("User-Agent"),
+	}
+}
+
+func reportCslError(event CslErrorEvent) {
+	if !errorTrackerEnabled() {
+		return
+	}
+
+	timeNow := time.Now().Unix()
+	dedupKey := fmt.Sprintf("%s|%s|%s|%s", event.Kind, event.Route, event.OrgId, event.Message)
+
+	cslErrorTracker.Lock()
+	if timeNow-cslErrorTracker.lastSent[dedupKey] < ErrorTrackerDedupSeconds {
+		cslErrorTracker.Unlock()
+		return
+	}
+
+	cslErrorTracker.lastSent[dedupKey] = timeNow
+	for key, sent := range cslErrorTracker.lastSent {
+		if timeNow-sent >= ErrorTrackerDedupSeconds {
+			delete(cslErrorTracker.lastSent, key)
+		}
+	}
+	cslErrorTracker.Unlock()
+
+	cslErrorTracker.once.Do(func() {
+		cslErrorTracker.events = make(chan CslErrorEvent, ErrorTrackerQueueSize)
+		go sendCslErrorEvents(cslErrorTracker.events)
+	})
+
+	hostname, _ := os.Hostname()
+	event.Id = strings.ReplaceAll(uuid.NewV4().String(), "-", "")
+	event.Timestamp = timeNow
+	event.Hostname = hostname
+	event.Environment = os.Getenv("SHUFFLE_ERROR_TRACKER_ENVIRONMENT")
+	event.Release = os.Getenv("SHUFFLE_ERROR_TRACKER_RELEASE")
+	if len(event.Environment) == 0 {
+		event.Environment = "production"
+	}
+
+	if len(event.Level) == 0 {
+		event.Level = "error"
+	}
+
+	select {
+	case cslErrorTracker.events <- event:
+	default:
+		log.Printf("[WARNING] Error tracker queue is full. Dropped %s event: %s", event.Kind, event.Message)
+	}
+}
+
+func reportCslPanic(request *http.Request, value interface{}, stack string) {
+	event := CslErrorEvent{
+		Kind:    ErrorKindPanic,
+		Level:   "fatal",
+		Message: fmt.Sprintf("panic: %v", value),
+		Stack:   stack,
+	}
+
+	addErrorRequestContext(&event, request)
+	reportCslError(event)
+}
+
+// Counts 5xx responses per route, and reports the route when it reaches the
+// threshold within the window
+func trackCslServerError(request *http.Request, status int) {
+	if status < 500 || !errorTrackerEnabled() {
+		return
+	}
+
+	route := getUsageEndpoint(request)
+	timeNow := time.Now().Unix()
+
+	cslErrorTracker.Lock()
+	errorCount, ok := cslErrorTracker.serverErrors[route]
+	if !ok || timeNow-errorCount.windowStart >= ServerErrorWindowSeconds {
+		errorCount = &cslServerErrors{windowStart: timeNow}
+		cslErrorTracker.serverErrors[route] = errorCount
+	}
+
+	errorCount.count += 1
+	report := !errorCount.reported && errorCount.count >= getServerErrorThreshold()
+	if report {
+		errorCount.reported = true
+	}
+
+	count := errorCount.count
+	cslErrorTracker.Unlock()
+
+	if !report {
+		return
+	}
+
+	event := CslErrorEvent{
+		Kind:    ErrorKindHttp5xx,
+		Message: fmt.Sprintf("%s answered with %d server errors within %d minutes", route, count, ServerErrorWindowSeconds/60),
+		Status:  status,
+		Count:   count,
+	}
+
+	addErrorRequestContext(&event, request)
+	reportCslError(event)
+}
+
+// Internal errors when starting an execution, as opposed to invalid workflows
+func reportCslExecutionError(execution shuffle.WorkflowExecution, message string, err error) {
+	orgId := execution.ExecutionOrg
+	if len(orgId) == 0 {
+		orgId = execution.Workflow.OrgId
+	}
+
+	reportCslError(CslErrorEvent{
+		Kind:        ErrorKindExecution,
+		Message:     fmt.Sprintf("%s: %s", message, err),
+		OrgId:       orgId,
+		WorkflowId:  execution.Workflow.ID,
+		ExecutionId: execution.ExecutionId,
+	})
+}
+
+func sendCslErrorEvents(events chan CslErrorEvent) {
+	for event := range events {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+		if dsn := os.Getenv("SHUFFLE_SENTRY_DSN"); len(dsn) > 0 {
+			err := postSentryEvent(ctx, dsn, event)
+			if err != nil {
+				log.Printf("[WARNING] Failed sending %s event to Sentry: %s", event.Kind, err)
+			}
+		}
+
+		if trackerUrl := os.Getenv("SHUFFLE_ERROR_TRACKER_URL"); len(trackerUrl) > 0 {
+			err := postErrorTrackerEvent(ctx, trackerUrl, event)
+			if err != nil {
+				log.Printf("[WARNING] Failed sending %s event to the error tracker: %s", event.Kind, err)
+			}
+		}
+
+		cancel()
+	}
+}
+
+func postErrorTrackerJson(ctx context.Context, targetUrl string, headers map[string]string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", targetUrl, bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Add(key, value)
+	}
+
+	client := shuffle.GetExternalClient(targetUrl)
+	newresp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer newresp.Body.Close()
+	body, err := ioutil.ReadAll(newresp.Body)
+	if err != nil {
+		return err
+	}
+
+	if newresp.StatusCode < 200 || newresp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("status code %d: %s", newresp.StatusCode, string(body)))
+	}
+
+	return nil
+}
+
+func postErrorTrackerEvent(ctx context.Context, trackerUrl string, event CslErrorEvent) error {
+	headers := map[string]string{}
+	if token := os.Getenv("SHUFFLE_ERROR_TRACKER_TOKEN"); len(token) > 0 {
+		headers["Authorization"] = fmt.Sprintf("Bearer %s", token)
+	}
+
+	return postErrorTrackerJson(ctx, trackerUrl, headers, event)
+}
+
+// Sends the event to the store endpoint of the project in the DSN,
+// https://<public key>@<host>/<project id>
+func postSentryEvent(ctx context.Context, dsn string, event CslErrorEvent) error {
+	parsedDsn, err := url.Parse(dsn)
+	if err != nil || parsedDsn.User == nil || len(parsedDsn.User.Username()) == 0 {
+		return errors.New("invalid SHUFFLE_SENTRY_DSN")
+	}
+
+	pathParts := strings.Split(strings.Trim(parsedDsn.Path, "/"), "/")
+	projectId := pathParts[len(pathParts)-1]
+	basePath := strings.Join(pathParts[:len(pathParts)-1], "/")
+	if len(basePath) > 0 {
+		basePath = "/" + basePath
+	}
+
+	storeUrl := fmt.Sprintf("%s://%s%s/api/%s/store/", parsedDsn.Scheme, parsedDsn.Host, basePath, projectId)
+	headers := map[string]string{
+		"X-Sentry-Auth": fmt.Sprintf("Sentry sentry_version=7, sentry_client=shuffle-backend/1.0, sentry_key=%s", parsedDsn.User.Username()),
+	}
+
+	tags := map[string]string{
+		"kind": event.Kind,
+	}
+
+	for key, value := range map[string]string{"org_id": event.OrgId, "route": event.Route, "workflow_id": event.WorkflowId} {
+		if len(value) > 0 {
+			tags[key] = value
+		}
+	}
+
+	extra := map[string]interface{}{}
+	for key, value := range event.Extra {
+		extra[key] = value
+	}
+
+	for key, value := range map[string]string{"path": event.Path, "execution_id": event.ExecutionId, "stack": event.Stack} {
+		if len(value) > 0 {
+			extra[key] = value
+		}
+	}
+
+	if event.Count > 0 {
+		extra["count"] = event.Count
+		extra["status"] = event.Status
+	}
+
+	sentryEvent := map[string]interface{}{
+		"event_id":    event.Id,
+		"timestamp":   time.Unix(event.Timestamp, 0).UTC().Format(time.RFC3339),
+		"level":       event.Level,
+		"logger":      "shuffle-backend",
+		"platform":    "go",
+		"message":     truncateText(event.Message, 8192),
+		"server_name": event.Hostname,
+		"environment": event.Environment,
+		"tags":        tags,
+		"extra":       extra,
+	}
+
+	if len(event.Release) > 0 {
+		sentryEvent["release"] = event.Release
+	}
+
+	// Panics are grouped by where they happened rather than by message
+	if event.Kind == ErrorKindPanic {
+		sentryEvent["fingerprint"] = []string{event.Kind, event.Route}
+	}
+
+	return postErrorTrackerJson(ctx, storeUrl, headers, sentryEvent)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Escalation of approvals that aren't answered in time. Each stage of an
+// approval policy has its own roles, approvers and timeout. When a stage
+// times out the approvals job moves the approval to the next stage, so only
+// the next group can answer it, and when the last stage times out it is
+// approved or rejected as the policy says. Every step is recorded with the
+// approvals and shown on the execution timeline.
+
+const (
+	ApprovalTimeoutApprove = "approve"
+	ApprovalTimeoutReject  = "reject"
+)
+
+const (
+	EscalationEscalated = "escalated"
+	EscalationApproved  = "approved"
+	EscalationRejected  = "rejected"
+)
+
+// Oldest escalations are dropped past this
+const MaxApprovalEscalations = 1000
+
+// Actor of approvals answered when the last stage times out
+const ApprovalTimeoutActor = "timeout"
+
+type CslApprovalEscalation struct {
+	ApprovalId  string `json:"approval_id"`
+	ExecutionId string `json:"execution_id"`
+	NodeId      string `json:"node_id"`
+	Label       string `json:"label"`
+	Action      string `json:"action"`
+	FromStage   int    `json:"from_stage"`
+	ToStage     int    `json:"to_stage"`
+	Timestamp   int64  `json:"timestamp"`
+
+	// Who can answer after the escalation
+	Roles     []string `json:"roles"`
+	Approvers []string `json:"approvers"`
+}
+
+// Returns every stage of a policy, starting with its own roles and approvers
+func getCslApprovalStages(policy CslApprovalPolicy) []CslApprovalStage {
+	stages := []CslApprovalStage{{
+		Roles:          policy.Roles,
+		Approvers:      policy.Approvers,
+		TimeoutMinutes: policy.TimeoutMinutes,
+	}}
+
+	return append(stages, policy.Escalations...)
+}
+
+func validateCslApprovalEscalations(policy CslApprovalPolicy) error {
+	if len(policy.OnTimeout) > 0 && policy.OnTimeout != ApprovalTimeoutApprove && policy.OnTimeout != ApprovalTimeoutReject {
+		return errors.New(fmt.Sprintf("on_timeout must be %s or %s", ApprovalTimeoutApprove, ApprovalTimeoutReject))
+	}
+
+	stages := getCslApprovalStages(policy)
+	for index, stage := range stages {
+		if stage.TimeoutMinutes < 0 {
+			return errors.New("timeout_minutes can't be negative")
+		}
+
+		if index == 0 {
+			continue
+		}
+
+		if len(stage.Roles) == 0 && len(stage.Approvers) == 0 {
+			return errors.New(fmt.Sprintf("escalation %d of workflow %s needs roles or approvers", index, policy.WorkflowId))
+		}
+
+		if stages[index-1].TimeoutMinutes == 0 {
+			return errors.New(fmt.Sprintf("stage %d of workflow %s needs a timeout to escalate to the next one", index, policy.WorkflowId))
+		}
+	}
+
+	last := stages[len(stages)-1]
+	if last.TimeoutMinutes > 0 && len(policy.OnTimeout) == 0 {
+		return errors.New(fmt.Sprintf("the policy of workflow %s needs on_timeout, as its last stage has a timeout", policy.WorkflowId))
+	}
+
+	if last.TimeoutMinutes == 0 && len(policy.OnTimeout) > 0 {
+		return errors.New(fmt.Sprintf("on_timeout of workflow %s needs a timeout on the last stage", policy.WorkflowId))
+	}
+
+	return nil
+}
+
+func addCslApprovalEscalation(approvals *CslApprovals, escalation CslApprovalEscalation) {
+	approvals.Escalations = append(approvals.Escalations, escalation)
+	if len(approvals.Escalations) > MaxApprovalEscalations {
+		approvals.Escalations = approvals.Escalations[len(approvals.Escalations)-MaxApprovalEscalations:]
+	}
+}
+
+// Whether the current stage of the approval has timed out
+func isCslApprovalStageDue(approval CslApproval, timeNow int64) bool {
+	if approval.Stage >= len(approval.Stages) {
+		return false
+	}
+
+	timeout := approval.Stages[approval.Stage].TimeoutMinutes
+	return timeout > 0 && timeNow-approval.StageStartedAt >= int64(timeout)*60
+}
+
+// Moves timed out approvals to their next stage, and answers the ones whose
+// last stage timed out
+func escalateCslApprovals(ctx context.Context, orgId string) {
+	approvals := getCslApprovals(ctx, orgId)
+	timeNow := time.Now().Unix()
+	due := false
+	for _, approval := range approvals.Pending {
+		if isCslApprovalStageDue(approval, timeNow) {
+			due = true
+			break
+		}
+	}
+
+	if !due {
+		return
+	}
+
+	escalated := []CslApproval{}
+	timedOut := []CslApproval{}
+	err := updateCslDocument(ctx, orgId, CslApprovalsDocument, &approvals, func() error {
+		setCslApprovalsDefaults(&approvals)
+		for index, approval := range approvals.Pending {
+			if !isCslApprovalStageDue(approval, timeNow) {
+				continue
+			}
+
+			if approval.Stage == len(approval.Stages)-1 {
+				timedOut = append(timedOut, approval)
+				continue
+			}
+
+			from := approval.Stage
+			approval.Stage += 1
+			approval.StageStartedAt = timeNow
+			approval.Roles = approval.Stages[approval.Stage].Roles
+			approval.Approvers = approval.Stages[approval.Stage].Approvers
+			approvals.Pending[index] = approval
+
+			addCslApprovalEscalation(&approvals, CslApprovalEscalation{
+				ApprovalId:  approval.Id,
+				ExecutionId: approval.ExecutionId,
+				NodeId:      approval.NodeId,
+				Label:       approval.Label,
+				Action:      EscalationEscalated,
+				FromStage:   from,
+				ToStage:     approval.Stage,
+				Timestamp:   timeNow,
+				Roles:       approval.Roles,
+				Approvers:   approval.Approvers,
+			})
+
+			escalated = append(escalated, approval)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed escalating approvals for org %s: %s", orgId, err)
+		return
+	}
+
+	for _, approval := range escalated {
+		log.Printf("[AUDIT] Approval %s (%s) of execution %s in org %s was escalated to stage %d", approval.Id, approval.Label, approval.ExecutionId, orgId, approval.Stage+1)
+		recordCslActivity(ctx, orgId, ActivityTypeWorkflow, "approval_escalated", fmt.Sprintf("Approval %s of %s was escalated to stage %d", approval.Label, approval.WorkflowName, approval.Stage+1), "", approval.ExecutionId)
+		notifyCslEvent(ctx, orgId, EventApprovalEscalated, approval)
+	}
+
+	for _, approval := range timedOut {
+		answerTimedOutCslApproval(ctx, orgId, approval, timeNow)
+	}
+}
+
+// Approves or rejects an approval whose last stage timed out, as its policy
+// says. Approvals without on_timeout never get here, as they can't time out
+func answerTimedOutCslApproval(ctx context.Context, orgId string, approval CslApproval, timeNow int64) {
+	approved := approval.OnTimeout == ApprovalTimeoutApprove
+	status := ApprovalRejected
+	action := EscalationRejected
+	if approved {
+		status = ApprovalApproved
+		action = EscalationApproved
+	}
+
+	timeout := approval.Stages[approval.Stage].TimeoutMinutes
+	note := fmt.Sprintf("No answer within %d minutes", timeout)
+	finished, found := finishCslApproval(ctx, orgId, approval.ExecutionId, approval.NodeId, status, ApprovalTimeoutActor, note)
+	if !found {
+		return
+	}
+
+	err := runCslApprovalAnswer(ctx, finished, approved, note)
+	if err != nil {
+		log.Printf("[WARNING] Failed answering timed out approval %s of execution %s: %s", approval.Id, approval.ExecutionId, err)
+		restoreCslApproval(ctx, orgId, approval)
+		return
+	}
+
+	approvals := CslApprovals{}
+	err = updateCslDocument(ctx, orgId, CslApprovalsDocument, &approvals, func() error {
+		setCslApprovalsDefaults(&approvals)
+		addCslApprovalEscalation(&approvals, CslApprovalEscalation{
+			ApprovalId:  approval.Id,
+			ExecutionId: approval.ExecutionId,
+			NodeId:      approval.NodeId,
+			Label:       approval.Label,
+			Action:      action,
+			FromStage:   approval.Stage,
+			ToStage:     approval.Stage,
+			Timestamp:   timeNow,
+			Roles:       []string{},
+			Approvers:   []string{},
+		})
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed recording the timeout of approval %s in org %s: %s", approval.Id, orgId, err)
+	}
+
+	log.Printf("[AUDIT] Approval %s (%s) of execution %s in org %s was %s after no answer within %d minutes", approval.Id, approval.Label, approval.ExecutionId, orgId, status, timeout)
+	recordCslActivity(ctx, orgId, ActivityTypeWorkflow, "approval_"+status, fmt.Sprintf("Approval %s of %s was %s after no answer within %d minutes", approval.Label, approval.WorkflowName, status, timeout), ApprovalTimeoutActor, approval.ExecutionId)
+}
+
+// Escalations of approvals of executions in the chain
+func getApprovalTimelineEvents(ctx context.Context, orgId string, executionIds map[string]bool) []CslTimelineEvent {
+	events := []CslTimelineEvent{}
+	for _, escalation := range getCslApprovals(ctx, orgId).Escalations {
+		if !executionIds[escalation.ExecutionId] {
+			continue
+		}
+
+		event := CslTimelineEvent{
+			Timestamp:   escalation.Timestamp,
+			Type:        TimelineApprovalEscalated,
+			Status:      escalation.Action,
+			ExecutionId: escalation.ExecutionId,
+			Reference:   escalation.NodeId,
+		}
+
+		if escalation.Action == EscalationEscalated {
+			event.Title = fmt.Sprintf("Approval %s was escalated to stage %d", escalation.Label, escalation.ToStage+1)
+			event.Details = strings.Join(append(append([]string{}, escalation.Roles...), escalation.Approvers...), ", ")
+		} else {
+			event.Title = fmt.Sprintf("Approval %s was %s after it timed out", escalation.Label, escalation.Action)
+			event.Actor = ApprovalTimeoutActor
+		}
+
+		events = append(events, event)
+	}
+
+	return events
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslS3ExportDocument = "s3_export"
+
+// How often the s3_export job checks for days to export
+const ExportIntervalMinutes = 60
+
+// Days exported by a single run when catching up, e.g. after downtime
+const MaxExportDaysPerRun = 7
+
+// Most recent executions of each workflow read when exporting executions
+const MaxExportExecutionsPerWorkflow = 5000
+
+// Serializes exports so the job and manual runs don't export the same day twice
+var cslExportLock sync.Mutex
+
+type CslS3ExportConfig struct {
+	Enabled           bool   `json:"enabled"`
+	Endpoint          string `json:"endpoint"`
+	Region            string `json:"region"`
+	Bucket            string `json:"bucket"`
+	Prefix            string `json:"prefix"`
+	AccessKeyId       string `json:"access_key_id"`
+	SecretAccessKey   string `json:"secret_access_key"`
+	Insecure          bool   `json:"insecure"`
+	IncludeExecutions bool   `json:"include_executions"`
+}
+
+type CslS3ExportState struct {
+	LastExportedDate string `json:"last_exported_date"`
+	LastRun          int64  `json:"last_run"`
+	LastError        string `json:"last_error"`
+	ExportedFiles    int64  `json:"exported_files"`
+}
+
+type CslS3Export struct {
+	Config CslS3ExportConfig `json:"config"`
+	State  CslS3ExportState  `json:"state"`
+}
+
+// Written as <prefix>/<org_id>/statistics/<date>.json.gz
+type CslStatisticsExport struct {
+	OrgId      string                  `json:"org_id"`
+	Date       string                  `json:"date"`
+	Timezone   string                  `json:"timezone"`
+	Statistics shuffle.DailyStatistics `json:"statistics"`
+}
+
+// Gzipped JSON lines of the executions completed on one day
+type CslExecutionsExport struct {
+	buffer bytes.Buffer
+	writer *gzip.Writer
+	count  int
+}
+
+func getCslS3Export(ctx context.Context, orgId string) CslS3Export {
+	export := CslS3Export{}
+	_, err := getCslDocument(ctx, orgId, CslS3ExportDocument, &export)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting s3 export for org %s: %s", orgId, err)
+	}
+
+	return export
+}
+
+// Updates the export state. The config is left as it is stored
+func updateCslS3ExportState(ctx context.Context, orgId string, update func(state *CslS3ExportState)) error {
+	export := CslS3Export{}
+	return updateCslDocument(ctx, orgId, CslS3ExportDocument, &export, func() error {
+		update(&export.State)
+		return nil
+	})
+}
+
+func validateCslS3ExportConfig(config CslS3ExportConfig) error {
+	if !config.Enabled {
+		return nil
+	}
+
+	if len(config.Endpoint) == 0 || strings.Contains(config.Endpoint, "/") {
+		return errors.New("endpoint must be a host, e.g. s3.amazonaws.com or minio.example.com:9000")
+	}
+
+	if len(config.Bucket) == 0 {
+		return errors.New("bucket is required")
+	}
+
+	if len(config.AccessKeyId) == 0 || len(config.SecretAccessKey) == 0 {
+		return errors.New("access_key_id and secret_access_key are required")
+	}
+
+	if strings.Contains(config.Prefix, "..") || strings.HasPrefix(config.Prefix, "/") {
+		return errors.New("prefix must be a relative path inside the bucket")
+	}
+
+	return nil
+}
+
+func redactCslS3Export(export CslS3Export) CslS3Export {
+	if len(export.Config.SecretAccessKey) > 0 {
+		export.Config.SecretAccessKey = RedactedValue
+	}
+
+	return export
+}
+
+func getS3Client(config CslS3ExportConfig) (*minio.Client, error) {
+	return minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKeyId, config.SecretAccessKey, ""),
+		Secure: !config.Insecure,
+		Region: config.Region,
+	})
+}
+
+func checkS3Bucket(ctx context.Context, config CslS3ExportConfig) error {
+	client, err := getS3Client(config)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	exists, err := client.BucketExists(ctx, config.Bucket)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed reaching bucket %s: %s", config.Bucket, err))
+	}
+
+	if !exists {
+		return errors.New(fmt.Sprintf("bucket %s doesn't exist", config.Bucket))
+	}
+
+	return nil
+}
+
+func uploadS3Object(ctx context.Context, client *minio.Client, config CslS3ExportConfig, key string, data []byte) error {
+	_, err := client.PutObject(ctx, config.Bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/gzip",
+	})
+
+	return err
+}
+
+func gzipJson(data interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	err := json.NewEncoder(writer).Encode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// Reads the executions of the orgs workflows and writes the ones completed on
+// the given dates, keyed by date
+func getExecutionExports(ctx context.Context, orgId string, dates []string, location *time.Location) (map[string]*CslExecutionsExport, error) {
+	exports := map[string]*CslExecutionsExport{}
+	for _, date := range dates {
+		export := &CslExecutionsExport{}
+		export.writer = gzip.NewWriter(&export.buffer)
+		exports[date] = export
+	}
+
+	workflows, err := getOrgWorkflows(ctx, orgId)
+	if err != nil {
+		return exports, err
+	}
+
+	var lock sync.Mutex
+	err = runConcurrentLookups(ctx, len(workflows), func(ctx context.Context, index int) error {
+		executions, err := shuffle.GetAllWorkflowExecutions(ctx, workflows[index].ID, MaxExportExecutionsPerWorkflow)
+		if err != nil {
+			return err
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		for _, execution := range executions {
+			if execution.CompletedAt == 0 || (execution.Status != "FINISHED" && execution.Status != "ABORTED" && execution.Status != "FAILURE") {
+				continue
+			}
+
+			export, ok := exports[time.Unix(execution.CompletedAt, 0).In(location).Format(UsageDateFormat)]
+			if !ok {
+				continue
+			}
+
+			err = json.NewEncoder(export.writer).Encode(execution)
+			if err != nil {
+				return err
+			}
+
+			export.count++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return exports, err
+	}
+
+	for _, export := range exports {
+		err = export.writer.Close()
+		if err != nil {
+			return exports, err
+		}
+	}
+
+	return exports, nil
+}
+
+// Exports the days after the last exported day up to yesterday in the
+// statistics timezone. Returns the amount of files written
+func runCslS3Export(ctx context.Context, orgId string) (int, error) {
+	cslExportLock.Lock()
+	defer cslExportLock.Unlock()
+
+	export := getCslS3Export(ctx, orgId)
+	if !export.Config.Enabled {
+		return 0, nil
+	}
+
+	orgStats, err := shuffle.GetOrgStatistics(ctx, orgId)
+	if err != nil {
+		return 0, err
+	}
+
+	location := getTimezoneLocation(orgStats.Timezone)
+	yesterday := time.Now().In(location).AddDate(0, 0, -1)
+	start := yesterday
+	if len(export.State.LastExportedDate) > 0 {
+		lastExported, err := time.ParseInLocation(UsageDateFormat, export.State.LastExportedDate, location)
+		if err == nil {
+			start = lastExported.AddDate(0, 0, 1)
+		}
+	}
+
+	dates := []string{}
+	for date := start; date.Format(UsageDateFormat) <= yesterday.Format(UsageDateFormat) && len(dates) < MaxExportDaysPerRun; date = date.AddDate(0, 0, 1) {
+		dates = append(dates, date.Format(UsageDateFormat))
+	}
+
+	if len(dates) == 0 {
+		return 0, nil
+	}
+
+	client, err := getS3Client(export.Config)
+	if err != nil {
+		return 0, err
+	}
+
+	executionExports := map[string]*CslExecutionsExport{}
+	if export.Config.IncludeExecutions {
+		executionExports, err = getExecutionExports(ctx, orgId, dates, location)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	statistics := map[string]shuffle.DailyStatistics{}
+	for _, day := range orgStats.DailyStatistics {
+		statistics[day.Date.In(location).Format(UsageDateFormat)] = day
+	}
+
+	files := 0
+	folder := path.Join(export.Config.Prefix, orgId)
+	for _, date := range dates {
+		// Yesterday is rolled over into the daily statistics on the first
+		// increment of today. Waits for the next run if that hasn't happened
+		if _, ok := statistics[date]; !ok && date == yesterday.Format(UsageDateFormat) {
+			break
+		}
+
+		data, err := gzipJson(CslStatisticsExport{
+			OrgId:      orgId,
+			Date:       date,
+			Timezone:   location.String(),
+			Statistics: statistics[date],
+		})
+		if err != nil {
+			return files, err
+		}
+
+		err = uploadS3Object(ctx, client, export.Config, path.Join(folder, "statistics", fmt.Sprintf("%s.json.gz", date)), data)
+		if err != nil {
+			return files, err
+		}
+
+		files++
+
+		if executionExport, ok := executionExports[date]; ok && executionExport.count > 0 {
+			err = uploadS3Object(ctx, client, export.Config, path.Join(folder, "executions", fmt.Sprintf("%s.jsonl.gz", date)), executionExport.buffer.Bytes())
+			if err != nil {
+				return files, err
+			}
+
+			files++
+		}
+
+		// Stored per day so a failed run continues where it stopped
+		err = updateCslS3ExportState(ctx, orgId, func(state *CslS3ExportState) {
+			state.LastExportedDate = date
+		})
+		if err != nil {
+			return files, err
+		}
+	}
+
+	return files, nil
+}
+
+func runCslS3ExportForOrg(ctx context.Context, orgId string) {
+	files, exportErr := runCslS3Export(ctx, orgId)
+	if exportErr != nil {
+		log.Printf("[ERROR] S3 export failed for org %s: %s", orgId, exportErr)
+	} else if files > 0 {
+		log.Printf("[INFO] Exported %d files to S3 for org %s", files, orgId)
+	}
+
+	err := updateCslS3ExportState(ctx, orgId, func(state *CslS3ExportState) {
+		state.LastRun = time.Now().Unix()
+		state.ExportedFiles += int64(files)
+		state.LastError = ""
+		if exportErr != nil {
+			state.LastError = exportErr.Error()
+		}
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed updating s3 export state for org %s: %s", orgId, err)
+	}
+}
+
+func runCslS3ExportJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for s3 export job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		if !getCslS3Export(ctx, org.Id).Config.Enabled {
+			continue
+		}
+
+		runCslS3ExportForOrg(ctx, org.Id)
+	}
+}
+
+/*
+Export:
+Returns the S3 export configuration and state for the current organization.
+Requires org admin. The secret access key is redacted.
+
+	{
+	    "success": true,
+	    "data": {
+	        "config": {
+	            "enabled": true,
+	            "endpoint": "s3.amazonaws.com",
+	            "region": "eu-west-1",
+	            "bucket": "soar-archive",
+	            "prefix": "shuffle",
+	            "access_key_id": "AKIA...",
+	            "secret_access_key": "********",
+	            "insecure": false,
+	            "include_executions": true
+	        },
+	        "state": {
+	            "last_exported_date": "2024-04-05",
+	            "last_run": 1712345678,
+	            "last_error": "",
+	            "exported_files": 42
+	        }
+	    }
+	}
+*/
+func cslGetS3Export(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslS3Export(getCslS3Export(ctx, user.ActiveOrg.Id)),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetS3Export")
+}
+
+/*
+Export:
+Updates the S3 export configuration. Requires org admin. Body uses the format
+of the config field returned from GET, and a redacted secret is kept as it is.
+Any S3-compatible storage works, set insecure to use http. Each finished day
+is written to <prefix>/<org_id>/statistics/<date>.json.gz, and with
+include_executions the executions completed that day are written as gzipped
+JSON lines to <prefix>/<org_id>/executions/<date>.jsonl.gz. Dates are in the
+statistics timezone. The bucket is checked before the config is stored.
+*/
+func cslSetS3Export(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	export := getCslS3Export(ctx, user.ActiveOrg.Id)
+	previousConfig := export.Config
+
+	err = json.Unmarshal(body, &export.Config)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling s3 export config: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if export.Config.SecretAccessKey == RedactedValue {
+		export.Config.SecretAccessKey = previousConfig.SecretAccessKey
+	}
+
+	err = validateCslS3ExportConfig(export.Config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if export.Config.Enabled {
+		err = checkS3Bucket(ctx, export.Config)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+	}
+
+	stored := CslS3Export{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslS3ExportDocument, &stored, func() error {
+		stored.Config = export.Config
+		export = stored
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated s3 export config for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "s3_export_updated", "S3 export configuration was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslS3Export(export),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetS3Export")
+}
+
+/*
+Export:
+Exports the days that haven't been exported yet without waiting for the hourly
+job. Requires org admin. Runs in the background, use GET for the result.
+*/
+func cslRunS3Export(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	if !getCslS3Export(ctx, user.ActiveOrg.Id).Config.Enabled {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("s3 export is not enabled")))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) started s3 export for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+
+	orgId := user.ActiveOrg.Id
+	go runCslS3ExportForOrg(context.Background(), orgId)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslRunS3Export")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+type CslFailureCount struct {
+	Category   string  `json:"category"`
+	Count      int64   `json:"count"`
+	Today      int64   `json:"today"`
+	Total      int64   `json:"total"`
+	Percentage float64 `json:"percentage"`
+}
+
+type CslFailuresResponse struct {
+	Days       int               `json:"days"`
+	Failures   int64             `json:"failures"`
+	Categories []CslFailureCount `json:"categories"`
+}
+
+// Failures are categorized by shuffle.GetFailureCategory when results come
+// in, and when executions are aborted
+func getFailureCounts(orgStats *shuffle.ExecutionInfo, days int) CslFailuresResponse {
+	sums := sumRecentAdditions(orgStats, shuffle.FailureStatPrefix, shuffle.FailureCategories, days)
+
+	response := CslFailuresResponse{
+		Days:       days,
+		Categories: []CslFailureCount{},
+	}
+
+	for _, category := range shuffle.FailureCategories {
+		response.Failures += sums[category].Count
+	}
+
+	for _, category := range shuffle.FailureCategories {
+		count := CslFailureCount{
+			Category: category,
+			Count:    sums[category].Count,
+			Today:    sums[category].Today,
+			Total:    sums[category].Total,
+		}
+
+		if response.Failures > 0 {
+			count.Percentage = roundScore(float64(count.Count) / float64(response.Failures) * 100)
+		}
+
+		response.Categories = append(response.Categories, count)
+	}
+
+	sort.SliceStable(response.Categories, func(i, j int) bool {
+		return response.Categories[i].Count > response.Categories[j].Count
+	})
+
+	return response
+}
+
+/*
+Dashboard:
+Returns failed actions and aborted executions of the current organization by
+failure category for today and the previous ?days=N-1 days (default 7, max 30).
+Categories are auth, timeout, bad_input, app_crash, resource_limit, user_abort
+and other.
+Total is all failures since tracking started.
+
+	{
+	    "success": true,
+	    "data": {
+	        "days": 7,
+	        "failures": 120,
+	        "categories": [
+	            {
+	                "category": "auth",
+	                "count": 64,
+	                "today": 10,
+	                "total": 410,
+	                "percentage": 53.33
+	            },
+	            ...
+	        ]
+	    }
+	}
+*/
+func cslFailureStats(resp http.ResponseWriter, request *http.Request) {
+	orgStats := handleOrgStatsRequest(resp, request)
+	if orgStats == nil {
+		return
+	}
+
+	days := WeekLength
+	if value := request.URL.Query().Get("days"); len(value) > 0 {
+		parsedDays, err := strconv.Atoi(value)
+		if err != nil || parsedDays < 1 || parsedDays > MonthLength {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("days must be between 1 and %d", MonthLength))))
+			return
+		}
+
+		days = parsedDays
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    getFailureCounts(orgStats, days),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslFailureStats")
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Feature flags shared by every org, so experimental features can be enabled
+// for pilot orgs and users without separate builds. Flags are managed by
+// users with support access. A flag is evaluated from the first of:
+//
+//  1. The override of the user
+//  2. The override of the org
+//  3. The rollout percentage, where orgs are bucketed by a hash of the flag
+//     name and org id so the same orgs stay enabled as the percentage grows
+//  4. Whether the flag is enabled
+
+const CslFeatureFlagsOrgId = "csl_feature_flags"
+const CslFeatureFlagsDocument = "feature_flags"
+
+// Flags are kept in memory and reloaded this often, or when changed here
+const FeatureFlagRefreshMinutes = 1
+
+const MaxFeatureFlags = 100
+
+// Gates the AI endpoints such as /api/v1/conversation
+const FeatureAiEndpoints = "ai_endpoints"
+
+const (
+	FeatureReasonUser       = "user"
+	FeatureReasonOrg        = "org"
+	FeatureReasonRollout    = "rollout"
+	FeatureReasonDefault    = "default"
+	FeatureReasonUndefined  = "undefined"
+	FeatureReasonOutOfRange = "out_of_rollout"
+)
+
+var featureFlagNamePattern = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+
+type CslFeatureFlag struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Enabled     bool            `json:"enabled"`
+	Percentage  int             `json:"percentage"`
+	Orgs        map[string]bool `json:"orgs"`
+	Users       map[string]bool `json:"users"`
+	UpdatedBy   string          `json:"updated_by"`
+	Updated     int64           `json:"updated"`
+}
+
+type CslFeatureFlags struct {
+	Flags map[string]CslFeatureFlag `json:"flags"`
+}
+
+type CslFeatureEvaluation struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason"`
+}
+
+var cslFeatureFlags = struct {
+	sync.Mutex
+	flags  CslFeatureFlags
+	loaded time.Time
+}{}
+
+func getCslFeatureFlagsDocument(ctx context.Context) CslFeatureFlags {
+	flags := CslFeatureFlags{}
+	_, err := getCslDocument(ctx, CslFeatureFlagsOrgId, CslFeatureFlagsDocument, &flags)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting feature flags: %s", err)
+	}
+
+	if flags.Flags == nil {
+		flags.Flags = map[string]CslFeatureFlag{}
+	}
+
+	return flags
+}
+
+func getCslFeatureFlags(ctx context.Context) CslFeatureFlags {
+	cslFeatureFlags.Lock()
+	defer cslFeatureFlags.Unlock()
+
+	if time.Since(cslFeatureFlags.loaded) > FeatureFlagRefreshMinutes*time.Minute {
+		cslFeatureFlags.flags = getCslFeatureFlagsDocument(ctx)
+		cslFeatureFlags.loaded = time.Now()
+	}
+
+	return cslFeatureFlags.flags
+}
+
+func reloadCslFeatureFlags() {
+	cslFeatureFlags.Lock()
+	cslFeatureFlags.loaded = time.Time{}
+	cslFeatureFlags.Unlock()
+}
+
+// Bucket 0-99 of an org in the rollout of a flag
+func getFeatureRolloutBucket(name, orgId string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(fmt.Sprintf("%s:%s", name, orgId)))
+	return int(hash.Sum32() % 100)
+}
+
+func (flag CslFeatureFlag) evaluate(orgId, userId string) CslFeatureEvaluation {
+	evaluation := CslFeatureEvaluation{Name: flag.Name}
+
+	if enabled, ok := flag.Users[userId]; ok && len(userId) > 0 {
+		evaluation.Enabled = enabled
+		evaluation.Reason = FeatureReasonUser
+	} else if enabled, ok := flag.Orgs[orgId]; ok && len(orgId) > 0 {
+		evaluation.Enabled = enabled
+		evaluation.Reason = FeatureReasonOrg
+	} else if flag.Percentage > 0 && flag.Percentage < 100 {
+		evaluation.Enabled = getFeatureRolloutBucket(flag.Name, orgId) < flag.Percentage
+		evaluation.Reason = FeatureReasonRollout
+		if !evaluation.Enabled {
+			evaluation.Reason = FeatureReasonOutOfRange
+		}
+	} else {
+		evaluation.Enabled = flag.Enabled || flag.Percentage >= 100
+		evaluation.Reason = FeatureReasonDefault
+	}
+
+	return evaluation
+}
+
+// Evaluates a flag for a user in an org. Flags that aren't defined are
+// enabled, so gating a feature doesn't change anything until its flag is added
+func evaluateCslFeature(ctx context.Context, name, orgId, userId string) CslFeatureEvaluation {
+	flag, ok := getCslFeatureFlags(ctx).Flags[name]
+	if !ok {
+		return CslFeatureEvaluation{Name: name, Enabled: true, Reason: FeatureReasonUndefined}
+	}
+
+	return flag.evaluate(orgId, userId)
+}
+
+func isCslFeatureEnabled(ctx context.Context, name, orgId, userId string) bool {
+	return evaluateCslFeature(ctx, name, orgId, userId).Enabled
+}
+
+// Wraps a handler to only be reachable by users the feature is enabled for
+func cslFeatureGate(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		if request.Method == "OPTIONS" {
+			handler(resp, request)
+			return
+		}
+
+		ctx := shuffle.GetContext(request)
+		if _, ok := getCslFeatureFlags(ctx).Flags[name]; !ok {
+			handler(resp, request)
+			return
+		}
+
+		user, err := getMiddlewareUser(resp, request)
+		if err != nil {
+			handler(resp, request)
+			return
+		}
+
+		if !isCslFeatureEnabled(ctx, name, user.ActiveOrg.Id, user.Id) {
+			resp.WriteHeader(403)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("the %s feature isn't enabled for this organization", name))))
+			return
+		}
+
+		handler(resp, request)
+	}
+}
+
+func validateCslFeatureFlag(flag CslFeatureFlag) error {
+	if !featureFlagNamePattern.MatchString(flag.Name) {
+		return errors.New("name must be 1-64 lowercase letters, digits or underscores")
+	}
+
+	if flag.Percentage < 0 || flag.Percentage > 100 {
+		return errors.New("percentage must be between 0 and 100")
+	}
+
+	return nil
+}
+
+/*
+Feature flags:
+Returns whether each feature flag is enabled for the current user and
+organization, and why. reason is user or org for overrides, rollout or
+out_of_rollout for percentage rollouts and default otherwise. ?name= only
+evaluates one flag, which is enabled with reason undefined if it doesn't
+exist.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "name": "ai_endpoints",
+	            "enabled": true,
+	            "reason": "org"
+	        }
+	    ]
+	}
+*/
+func cslEvaluateFeatureFlags(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	evaluations := []CslFeatureEvaluation{}
+	if name := request.URL.Query().Get("name"); len(name) > 0 {
+		evaluations = append(evaluations, evaluateCslFeature(ctx, name, user.ActiveOrg.Id, user.Id))
+	} else {
+		for _, flag := range getCslFeatureFlags(ctx).Flags {
+			evaluations = append(evaluations, flag.evaluate(user.ActiveOrg.Id, user.Id))
+		}
+
+		sort.Slice(evaluations, func(i, j int) bool {
+			return evaluations[i].Name < evaluations[j].Name
+		})
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    evaluations,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslEvaluateFeatureFlags")
+}
+
+/*
+Feature flags:
+Returns every feature flag with its overrides. Requires support access.
+
+	{
+	    "success": true,
+	    "data": {
+	        "flags": {
+	            "ai_endpoints": {
+	                "name": "ai_endpoints",
+	                "description": "LLM backed endpoints",
+	                "enabled": false,
+	                "percentage": 10,
+	                "orgs": {
+	                    "a5d3...": true
+	                },
+	                "users": {},
+	                "updated_by": "support@example.com",
+	                "updated": 1718000000
+	            }
+	        }
+	    }
+	}
+*/
+func cslGetFeatureFlags(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !user.SupportAccess {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("managing feature flags requires support access")))
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslFeatureFlagsDocument(ctx),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetFeatureFlags")
+}
+
+/*
+Feature flags:
+Creates or replaces a feature flag in the format of a flag returned from GET.
+Requires support access. Changes apply within a minute on other instances.
+
+	{
+	    "name": "ai_endpoints",
+	    "description": "LLM backed endpoints",
+	    "enabled": false,
+	    "percentage": 10,
+	    "orgs": {
+	        "a5d3...": true
+	    },
+	    "users": {
+	        "e1b7...": false
+	    }
+	}
+*/
+func cslSetFeatureFlag(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !user.SupportAccess {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("managing feature flags requires support access")))
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	flag := CslFeatureFlag{}
+	err = json.Unmarshal(body, &flag)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	err = validateCslFeatureFlag(flag)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if flag.Orgs == nil {
+		flag.Orgs = map[string]bool{}
+	}
+
+	if flag.Users == nil {
+		flag.Users = map[string]bool{}
+	}
+
+	flag.UpdatedBy = user.Username
+	flag.Updated = time.Now().Unix()
+
+	flags := CslFeatureFlags{}
+	err = updateCslDocument(ctx, CslFeatureFlagsOrgId, CslFeatureFlagsDocument, &flags, func() error {
+		if flags.Flags == nil {
+			flags.Flags = map[string]CslFeatureFlag{}
+		}
+
+		if _, ok := flags.Flags[flag.Name]; !ok && len(flags.Flags) >= MaxFeatureFlags {
+			return errors.New(fmt.Sprintf("can't have more than %d feature flags", MaxFeatureFlags))
+		}
+
+		flags.Flags[flag.Name] = flag
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	reloadCslFeatureFlags()
+	log.Printf("[AUDIT] User %s (%s) set feature flag %s. Enabled: %t, percentage: %d, org overrides: %d, user overrides: %d", user.Username, user.Id, flag.Name, flag.Enabled, flag.Percentage, len(flag.Orgs), len(flag.Users))
+
+	res := CslResponse{
+		Success: true,
+		Data:    flag,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetFeatureFlag")
+}
+
+/*
+Feature flags:
+Deletes the feature flag ?name=. Requires support access. Features gated by a
+deleted flag are enabled for everyone.
+*/
+func cslDeleteFeatureFlag(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !user.SupportAccess {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("managing feature flags requires support access")))
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	name := request.URL.Query().Get("name")
+
+	flags := CslFeatureFlags{}
+	err := updateCslDocument(ctx, CslFeatureFlagsOrgId, CslFeatureFlagsDocument, &flags, func() error {
+		if _, ok := flags.Flags[name]; !ok {
+			return errors.New(fmt.Sprintf("feature flag %s not found", name))
+		}
+
+		delete(flags.Flags, name)
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	reloadCslFeatureFlags()
+	log.Printf("[AUDIT] User %s (%s) deleted feature flag %s", user.Username, user.Id, name)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslDeleteFeatureFlag")
+}
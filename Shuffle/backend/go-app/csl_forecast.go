@@ -0,0 +1,199 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Smoothing factors for the level and trend of the forecast. Higher values
+// follow recent days more closely
+const ForecastLevelSmoothing = 0.5
+const ForecastTrendSmoothing = 0.3
+
+// Forecasted metrics
+const (
+	ForecastWorkflowExecutions = "workflow_executions"
+	ForecastApiUsage           = "api_usage"
+	ForecastAppExecutions      = "app_executions"
+)
+
+type CslForecastDay struct {
+	Date  string `json:"date"`
+	Value int64  `json:"value"`
+}
+
+type CslForecast struct {
+	Metric   string           `json:"metric"`
+	Days     []CslForecastDay `json:"days"`
+	Total    int64            `json:"total"`
+	LastWeek int64            `json:"last_week"`
+	Change   float64          `json:"change"`
+
+	// Only set for metrics with a daily quota
+	Quota        string `json:"quota,omitempty"`
+	DaysOverSoft int    `json:"days_over_soft"`
+	DaysOverHard int    `json:"days_over_hard"`
+}
+
+type CslForecastResponse struct {
+	BasedOnDays int           `json:"based_on_days"`
+	Forecasts   []CslForecast `json:"forecasts"`
+}
+
+// Holt's linear exponential smoothing over the daily values, oldest first.
+// Returns the next days values, never below 0
+func forecastValues(values []float64, days int) []float64 {
+	forecast := make([]float64, days)
+	if len(values) == 0 {
+		return forecast
+	}
+
+	level := values[0]
+	trend := 0.0
+	if len(values) > 1 {
+		trend = values[1] - values[0]
+	}
+
+	for _, value := range values[1:] {
+		previousLevel := level
+		level = ForecastLevelSmoothing*value + (1-ForecastLevelSmoothing)*(level+trend)
+		trend = ForecastTrendSmoothing*(level-previousLevel) + (1-ForecastTrendSmoothing)*trend
+	}
+
+	for i := range forecast {
+		forecast[i] = math.Max(0, level+float64(i+1)*trend)
+	}
+
+	return forecast
+}
+
+// Finished days of the last month, oldest first. Today is left out as it isn't over
+func getDailyValues(orgStats *shuffle.ExecutionInfo, value func(day shuffle.DailyStatistics) int64) []float64 {
+	dailyStatistics := orgStats.DailyStatistics
+	if len(dailyStatistics) > MonthLength {
+		dailyStatistics = dailyStatistics[len(dailyStatistics)-MonthLength:]
+	}
+
+	values := []float64{}
+	for _, day := range dailyStatistics {
+		values = append(values, float64(value(day)))
+	}
+
+	return values
+}
+
+func getForecast(metric string, values []float64, location *time.Location) CslForecast {
+	forecast := CslForecast{
+		Metric: metric,
+		Days:   []CslForecastDay{},
+	}
+
+	for i := len(values) - 1; i >= 0 && i >= len(values)-WeekLength; i-- {
+		forecast.LastWeek += int64(values[i])
+	}
+
+	tomorrow := time.Now().In(location).AddDate(0, 0, 1)
+	for i, value := range forecastValues(values, WeekLength) {
+		day := CslForecastDay{
+			Date:  tomorrow.AddDate(0, 0, i).Format(UsageDateFormat),
+			Value: int64(math.Round(value)),
+		}
+
+		forecast.Days = append(forecast.Days, day)
+		forecast.Total += day.Value
+	}
+
+	if forecast.LastWeek > 0 {
+		forecast.Change = roundScore(float64(forecast.Total-forecast.LastWeek) / float64(forecast.LastWeek) * 100)
+	}
+
+	return forecast
+}
+
+// Counts the forecasted days that go over the soft and hard limit of a daily quota
+func setForecastQuota(forecast *CslForecast, quota string, limit CslQuotaLimit) {
+	forecast.Quota = quota
+	for _, day := range forecast.Days {
+		if limit.Soft > 0 && day.Value >= limit.Soft {
+			forecast.DaysOverSoft++
+		}
+
+		if limit.Hard > 0 && day.Value >= limit.Hard {
+			forecast.DaysOverHard++
+		}
+	}
+}
+
+/*
+Dashboard:
+Forecasts the daily workflow executions, api usage and app executions of the
+current organization for the next 7 days with exponential smoothing of the last
+30 days. Total is compared to the last 7 days as a percentage in change. For
+metrics with a daily quota, days_over_soft and days_over_hard count forecasted
+days at or above the limits.
+
+	{
+	    "success": true,
+	    "data": {
+	        "based_on_days": 30,
+	        "forecasts": [
+	            {
+	                "metric": "workflow_executions",
+	                "days": [
+	                    {
+	                        "date": "2024-04-06",
+	                        "value": 120
+	                    },
+	                    ...
+	                ],
+	                "total": 860,
+	                "last_week": 800,
+	                "change": 7.5,
+	                "quota": "executions_per_day",
+	                "days_over_soft": 0,
+	                "days_over_hard": 0
+	            },
+	            ...
+	        ]
+	    }
+	}
+*/
+func cslForecast(resp http.ResponseWriter, request *http.Request) {
+	orgStats := handleOrgStatsRequest(resp, request)
+	if orgStats == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	location := getTimezoneLocation(orgStats.Timezone)
+	quotas := getCslQuotas(ctx, orgStats.OrgId)
+
+	executionValues := getDailyValues(orgStats, func(day shuffle.DailyStatistics) int64 {
+		return day.WorkflowExecutions
+	})
+
+	executions := getForecast(ForecastWorkflowExecutions, executionValues, location)
+	setForecastQuota(&executions, QuotaExecutionsPerDay, quotas.ExecutionsPerDay)
+
+	apiUsage := getForecast(ForecastApiUsage, getDailyValues(orgStats, func(day shuffle.DailyStatistics) int64 {
+		return day.ApiUsage
+	}), location)
+	setForecastQuota(&apiUsage, QuotaApiCallsPerDay, quotas.ApiCallsPerDay)
+
+	appExecutions := getForecast(ForecastAppExecutions, getDailyValues(orgStats, func(day shuffle.DailyStatistics) int64 {
+		return day.AppExecutions
+	}), location)
+
+	res := CslResponse{
+		Success: true,
+		Data: CslForecastResponse{
+			BasedOnDays: len(executionValues),
+			Forecasts:   []CslForecast{executions, apiUsage, appExecutions},
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslForecast")
+}
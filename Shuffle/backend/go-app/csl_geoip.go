@@ -0,0 +1,552 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslGeoEventsDocument = "geo_events"
+
+// Events kept per org. The oldest are dropped first
+const MaxGeoEvents = 5000
+
+// Events buffered per org between flushes, so a flood of webhooks can't
+// grow the buffer without bound
+const MaxPendingGeoEvents = 1000
+
+const GeoFlushMinutes = 1
+
+// How often the database file is checked for updates
+const GeoIpReloadMinutes = 5
+
+// Event sources
+const (
+	GeoSourceWebhook = "webhook"
+	GeoSourceLogin   = "login"
+)
+
+var geoSources = []string{GeoSourceWebhook, GeoSourceLogin}
+
+// Country is the ISO code, and is empty for private addresses and addresses
+// missing from the database
+type CslGeoLocation struct {
+	Country     string  `json:"country"`
+	CountryName string  `json:"country_name"`
+	City        string  `json:"city"`
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+}
+
+// ReferenceId is the hook id of webhooks and the user id of logins. Status
+// is the HTTP status the request was answered with
+type CslGeoEvent struct {
+	CslGeoLocation
+	Source      string `json:"source"`
+	Ip          string `json:"ip"`
+	ReferenceId string `json:"reference_id"`
+	Name        string `json:"name"`
+	Status      int    `json:"status"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+// FirstSeen is when each country was first seen per source, keyed by
+// <source>:<country>, and outlives the events
+type CslGeoEvents struct {
+	Events    []CslGeoEvent    `json:"events"`
+	FirstSeen map[string]int64 `json:"first_seen"`
+}
+
+type CslGeoDatabase struct {
+	Loaded       bool   `json:"loaded"`
+	DatabaseType string `json:"database_type"`
+	BuildEpoch   int64  `json:"build_epoch"`
+}
+
+// New is set for countries first seen within the period, which are worth a
+// look when a webhook or account is only expected to be used from a few
+// places
+type CslGeoCountry struct {
+	Country     string  `json:"country"`
+	CountryName string  `json:"country_name"`
+	Count       int     `json:"count"`
+	UniqueIps   int     `json:"unique_ips"`
+	Share       float64 `json:"share"`
+	FirstSeen   int64   `json:"first_seen"`
+	LastSeen    int64   `json:"last_seen"`
+	New         bool    `json:"new"`
+}
+
+type CslGeoSources struct {
+	Database  CslGeoDatabase  `json:"database"`
+	Days      int             `json:"days"`
+	Total     int             `json:"total"`
+	Unknown   int             `json:"unknown"`
+	Countries []CslGeoCountry `json:"countries"`
+}
+
+// The database set in SHUFFLE_GEOIP_DATABASE, reloaded when the file changes
+var cslGeoIp = struct {
+	sync.Mutex
+	reader  *mmdbReader
+	modTime time.Time
+	checked time.Time
+}{}
+
+var cslPendingGeoEvents = struct {
+	sync.Mutex
+	orgs map[string][]CslGeoEvent
+}{
+	orgs: map[string][]CslGeoEvent{},
+}
+
+func getGeoIpReader() *mmdbReader {
+	path := os.Getenv("SHUFFLE_GEOIP_DATABASE")
+	if len(path) == 0 {
+		return nil
+	}
+
+	cslGeoIp.Lock()
+	defer cslGeoIp.Unlock()
+
+	if time.Since(cslGeoIp.checked) < GeoIpReloadMinutes*time.Minute {
+		return cslGeoIp.reader
+	}
+
+	cslGeoIp.checked = time.Now()
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Printf("[WARNING] Failed finding GeoIP database %s: %s", path, err)
+		return cslGeoIp.reader
+	}
+
+	if cslGeoIp.reader != nil && info.ModTime().Equal(cslGeoIp.modTime) {
+		return cslGeoIp.reader
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("[WARNING] Failed reading GeoIP database %s: %s", path, err)
+		return cslGeoIp.reader
+	}
+
+	reader, err := openMmdb(data)
+	if err != nil {
+		log.Printf("[WARNING] Failed loading GeoIP database %s: %s", path, err)
+		return cslGeoIp.reader
+	}
+
+	log.Printf("[INFO] Loaded GeoIP database %s (%s)", path, reader.databaseType)
+	cslGeoIp.reader = reader
+	cslGeoIp.modTime = info.ModTime()
+	return reader
+}
+
+func getGeoName(record map[string]interface{}, field string) string {
+	value, _ := record[field].(map[string]interface{})
+	names, _ := value["names"].(map[string]interface{})
+	name, _ := names["en"].(string)
+	return name
+}
+
+// Looks up an IP in the GeoLite2 Country or City database. Works with any
+// database following the GeoIP2 layout
+func lookupGeoIp(value string) CslGeoLocation {
+	location := CslGeoLocation{}
+	ip := net.ParseIP(strings.TrimSpace(value))
+	if ip == nil || ip.IsPrivate() || ip.IsLoopback() {
+		return location
+	}
+
+	reader := getGeoIpReader()
+	if reader == nil {
+		return location
+	}
+
+	result, err := reader.lookup(ip)
+	if err != nil {
+		log.Printf("[WARNING] Failed looking up %s in the GeoIP database: %s", value, err)
+		return location
+	}
+
+	record, ok := result.(map[string]interface{})
+	if !ok {
+		return location
+	}
+
+	// Anonymous proxies and satellite providers only have a registered country
+	countryField := "country"
+	if _, ok := record[countryField]; !ok {
+		countryField = "registered_country"
+	}
+
+	country, _ := record[countryField].(map[string]interface{})
+	location.Country, _ = country["iso_code"].(string)
+	location.CountryName = getGeoName(record, countryField)
+	location.City = getGeoName(record, "city")
+
+	coordinates, _ := record["location"].(map[string]interface{})
+	location.Latitude, _ = coordinates["latitude"].(float64)
+	location.Longitude, _ = coordinates["longitude"].(float64)
+	return location
+}
+
+// Geolocates the source of a webhook or login and buffers it for the
+// geo_flush job
+func recordCslGeoEvent(orgId, source, ip, referenceId, name string, status int) {
+	if len(orgId) == 0 || len(ip) == 0 {
+		return
+	}
+
+	event := CslGeoEvent{
+		CslGeoLocation: lookupGeoIp(ip),
+		Source:         source,
+		Ip:             strings.TrimSpace(ip),
+		ReferenceId:    referenceId,
+		Name:           name,
+		Status:         status,
+		Timestamp:      time.Now().Unix(),
+	}
+
+	cslPendingGeoEvents.Lock()
+	defer cslPendingGeoEvents.Unlock()
+
+	if len(cslPendingGeoEvents.orgs[orgId]) >= MaxPendingGeoEvents {
+		return
+	}
+
+	cslPendingGeoEvents.orgs[orgId] = append(cslPendingGeoEvents.orgs[orgId], event)
+}
+
+func runCslGeoFlushJob(ctx context.Context) {
+	cslPendingGeoEvents.Lock()
+	pending := cslPendingGeoEvents.orgs
+	cslPendingGeoEvents.orgs = map[string][]CslGeoEvent{}
+	cslPendingGeoEvents.Unlock()
+
+	for orgId, events := range pending {
+		geoEvents := CslGeoEvents{}
+		err := updateCslDocument(ctx, orgId, CslGeoEventsDocument, &geoEvents, func() error {
+			if geoEvents.FirstSeen == nil {
+				geoEvents.FirstSeen = map[string]int64{}
+			}
+
+			for _, event := range events {
+				if len(event.Country) == 0 {
+					continue
+				}
+
+				key := fmt.Sprintf("%s:%s", event.Source, event.Country)
+				if firstSeen, ok := geoEvents.FirstSeen[key]; !ok || event.Timestamp < firstSeen {
+					geoEvents.FirstSeen[key] = event.Timestamp
+				}
+			}
+
+			geoEvents.Events = append(geoEvents.Events, events...)
+			if len(geoEvents.Events) > MaxGeoEvents {
+				geoEvents.Events = geoEvents.Events[len(geoEvents.Events)-MaxGeoEvents:]
+			}
+
+			return nil
+		})
+		if err != nil {
+			log.Printf("[ERROR] Failed storing geo events for org %s: %s", orgId, err)
+		}
+	}
+}
+
+// Wraps the webhook handler to geolocate the callers of existing hooks
+func cslGeoOnWebhook(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		// The webhook handler changes the method of the request
+		method := request.Method
+		recorder := &cslStatusRecorder{ResponseWriter: resp, status: 200}
+		handler(recorder, request)
+
+		hookId, ok := getCslWebhookHookId(mux.Vars(request)["key"])
+		if method == "OPTIONS" || !ok {
+			return
+		}
+
+		hook, err := shuffle.GetHook(context.Background(), hookId)
+		if err != nil {
+			return
+		}
+
+		recordCslGeoEvent(hook.OrgId, GeoSourceWebhook, shuffle.GetRequestIp(request), hook.Id, hook.Info.Name, recorder.status)
+	}
+}
+
+// Wraps the password login handler to geolocate successful logins. The user
+// is found from the session cookie set by the login
+func cslGeoOnLogin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		recorder := &cslStatusRecorder{ResponseWriter: resp, status: 200}
+		handler(recorder, request)
+
+		if request.Method != "POST" || recorder.status != 200 {
+			return
+		}
+
+		cookies := (&http.Response{Header: http.Header{"Set-Cookie": resp.Header().Values("Set-Cookie")}}).Cookies()
+		for _, cookie := range cookies {
+			if cookie.Name != "session_token" || len(cookie.Value) == 0 {
+				continue
+			}
+
+			user, err := shuffle.GetSessionNew(context.Background(), cookie.Value)
+			if err != nil {
+				return
+			}
+
+			recordCslGeoEvent(user.ActiveOrg.Id, GeoSourceLogin, shuffle.GetRequestIp(request), user.Id, user.Username, recorder.status)
+			return
+		}
+	}
+}
+
+func getGeoDatabase() CslGeoDatabase {
+	reader := getGeoIpReader()
+	if reader == nil {
+		return CslGeoDatabase{}
+	}
+
+	return CslGeoDatabase{
+		Loaded:       true,
+		DatabaseType: reader.databaseType,
+		BuildEpoch:   int64(reader.buildEpoch),
+	}
+}
+
+/*
+Geo:
+Summarizes where webhook triggers and logins of the current organization came
+from in the last ?days=<1-30> days, 7 by default. Optional
+?source=<webhook|login> limits it to one source. Countries seen for the first
+time within the period are flagged as new. Requires the GeoLite2 Country or
+City database at SHUFFLE_GEOIP_DATABASE, and sources without a country, e.g.
+private addresses, are counted as unknown.
+
+	{
+	    "success": true,
+	    "data": {
+	        "database": {
+	            "loaded": true,
+	            "database_type": "GeoLite2-City",
+	            "build_epoch": 1700000000
+	        },
+	        "days": 7,
+	        "total": 1200,
+	        "unknown": 14,
+	        "countries": [
+	            {
+	                "country": "NO",
+	                "country_name": "Norway",
+	                "count": 1150,
+	                "unique_ips": 4,
+	                "share": 95.8,
+	                "first_seen": 1690000000,
+	                "last_seen": 1700600000,
+	                "new": false
+	            }
+	        ]
+	    }
+	}
+*/
+func cslGeoSources(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	days := 7
+	if len(query.Get("days")) > 0 {
+		parsedDays, err := strconv.Atoi(query.Get("days"))
+		if err != nil || parsedDays < 1 || parsedDays > 30 {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New("days must be between 1 and 30")))
+			return
+		}
+
+		days = parsedDays
+	}
+
+	source := query.Get("source")
+	if len(source) > 0 && !shuffle.ArrayContains(geoSources, source) {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("source must be one of %s", strings.Join(geoSources, ", ")))))
+		return
+	}
+
+	geoEvents := CslGeoEvents{}
+	_, err := getCslDocument(ctx, user.ActiveOrg.Id, CslGeoEventsDocument, &geoEvents)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	since := time.Now().AddDate(0, 0, -days).Unix()
+	summary := CslGeoSources{
+		Database:  getGeoDatabase(),
+		Days:      days,
+		Countries: []CslGeoCountry{},
+	}
+
+	countries := map[string]*CslGeoCountry{}
+	ips := map[string]map[string]bool{}
+	for _, event := range geoEvents.Events {
+		if event.Timestamp < since || (len(source) > 0 && event.Source != source) {
+			continue
+		}
+
+		summary.Total += 1
+		if len(event.Country) == 0 {
+			summary.Unknown += 1
+			continue
+		}
+
+		country, ok := countries[event.Country]
+		if !ok {
+			country = &CslGeoCountry{
+				Country:     event.Country,
+				CountryName: event.CountryName,
+			}
+
+			for _, geoSource := range geoSources {
+				firstSeen, ok := geoEvents.FirstSeen[fmt.Sprintf("%s:%s", geoSource, event.Country)]
+				if ok && (len(source) == 0 || geoSource == source) && (country.FirstSeen == 0 || firstSeen < country.FirstSeen) {
+					country.FirstSeen = firstSeen
+				}
+			}
+
+			country.New = country.FirstSeen >= since
+			countries[event.Country] = country
+			ips[event.Country] = map[string]bool{}
+		}
+
+		country.Count += 1
+		ips[event.Country][event.Ip] = true
+		if event.Timestamp > country.LastSeen {
+			country.LastSeen = event.Timestamp
+		}
+	}
+
+	for code, country := range countries {
+		country.UniqueIps = len(ips[code])
+		country.Share = float64(int64(float64(country.Count)/float64(summary.Total)*1000)) / 10
+		summary.Countries = append(summary.Countries, *country)
+	}
+
+	sort.Slice(summary.Countries, func(i, j int) bool {
+		if summary.Countries[i].Count != summary.Countries[j].Count {
+			return summary.Countries[i].Count > summary.Countries[j].Count
+		}
+
+		return summary.Countries[i].Country < summary.Countries[j].Country
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data:    summary,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGeoSources")
+}
+
+/*
+Geo:
+Returns the most recent geolocated webhook triggers and logins of the current
+organization, newest first. Optional ?source=<webhook|login>,
+?country=<iso code>, ?reference_id=<hook or user id> and ?limit=<1-1000>,
+100 by default.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "country": "NO",
+	            "country_name": "Norway",
+	            "city": "Oslo",
+	            "latitude": 59.91,
+	            "longitude": 10.75,
+	            "source": "webhook",
+	            "ip": "203.0.113.7",
+	            "reference_id": "...",
+	            "name": "Phishing intake",
+	            "status": 200,
+	            "timestamp": 1700600000
+	        }
+	    ]
+	}
+*/
+func cslGeoEvents(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	limit := 100
+	if len(query.Get("limit")) > 0 {
+		parsedLimit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || parsedLimit < 1 || parsedLimit > 1000 {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New("limit must be between 1 and 1000")))
+			return
+		}
+
+		limit = parsedLimit
+	}
+
+	geoEvents := CslGeoEvents{}
+	_, err := getCslDocument(ctx, user.ActiveOrg.Id, CslGeoEventsDocument, &geoEvents)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	events := []CslGeoEvent{}
+	for i := len(geoEvents.Events) - 1; i >= 0 && len(events) < limit; i-- {
+		event := geoEvents.Events[i]
+		if len(query.Get("source")) > 0 && event.Source != query.Get("source") {
+			continue
+		}
+
+		if len(query.Get("country")) > 0 && !strings.EqualFold(event.Country, query.Get("country")) {
+			continue
+		}
+
+		if len(query.Get("reference_id")) > 0 && event.ReferenceId != query.Get("reference_id") {
+			continue
+		}
+
+		events = append(events, event)
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    events,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGeoEvents")
+}
@@ -0,0 +1,331 @@
+package main
+
+// Minimal reader for MaxMind DB files, e.g. GeoLite2-City.mmdb and
+// GeoLite2-Country.mmdb, following https://maxmind.github.io/MaxMind-DB/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+)
+
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// Metadata is at most 128KiB at the end of the file
+const mmdbMetadataMaxSize = 128 * 1024
+
+// Nesting allowed while decoding, as a guard against corrupt files
+const mmdbMaxDepth = 32
+
+// Data types of the data section
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+type mmdbReader struct {
+	buf          []byte
+	nodeCount    uint64
+	recordSize   uint64
+	ipVersion    uint64
+	treeSize     uint64
+	ipv4Start    uint64
+	databaseType string
+	buildEpoch   uint64
+}
+
+func openMmdb(buf []byte) (*mmdbReader, error) {
+	searchStart := 0
+	if len(buf) > mmdbMetadataMaxSize {
+		searchStart = len(buf) - mmdbMetadataMaxSize
+	}
+
+	markerIndex := bytes.LastIndex(buf[searchStart:], mmdbMetadataMarker)
+	if markerIndex < 0 {
+		return nil, errors.New("not a maxmind db file")
+	}
+
+	metadataStart := uint64(searchStart + markerIndex + len(mmdbMetadataMarker))
+	reader := &mmdbReader{buf: buf}
+
+	value, _, err := reader.decode(metadataStart, metadataStart, 0)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed reading metadata: %s", err))
+	}
+
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("metadata is not a map")
+	}
+
+	reader.nodeCount, _ = metadata["node_count"].(uint64)
+	reader.recordSize, _ = metadata["record_size"].(uint64)
+	reader.ipVersion, _ = metadata["ip_version"].(uint64)
+	reader.databaseType, _ = metadata["database_type"].(string)
+	reader.buildEpoch, _ = metadata["build_epoch"].(uint64)
+
+	if reader.recordSize != 24 && reader.recordSize != 28 && reader.recordSize != 32 {
+		return nil, errors.New(fmt.Sprintf("unsupported record size %d", reader.recordSize))
+	}
+
+	reader.treeSize = reader.recordSize * 2 / 8 * reader.nodeCount
+	if reader.treeSize+16 > metadataStart {
+		return nil, errors.New("search tree is larger than the file")
+	}
+
+	// IPv4 addresses are stored under ::/96 in IPv6 databases
+	if reader.ipVersion == 6 {
+		for i := 0; i < 96 && reader.ipv4Start < reader.nodeCount; i++ {
+			reader.ipv4Start, err = reader.readNode(reader.ipv4Start, 0)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return reader, nil
+}
+
+func (reader *mmdbReader) readNode(node uint64, bit uint) (uint64, error) {
+	base := node * reader.recordSize * 2 / 8
+	if base+reader.recordSize*2/8 > uint64(len(reader.buf)) {
+		return 0, errors.New("node is outside of the file")
+	}
+
+	b := reader.buf[base:]
+	switch reader.recordSize {
+	case 24:
+		if bit == 0 {
+			return uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2]), nil
+		}
+
+		return uint64(b[3])<<16 | uint64(b[4])<<8 | uint64(b[5]), nil
+	case 28:
+		if bit == 0 {
+			return uint64(b[3]&0xf0)<<20 | uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2]), nil
+		}
+
+		return uint64(b[3]&0x0f)<<24 | uint64(b[4])<<16 | uint64(b[5])<<8 | uint64(b[6]), nil
+	}
+
+	if bit == 0 {
+		return uint64(binary.BigEndian.Uint32(b[0:4])), nil
+	}
+
+	return uint64(binary.BigEndian.Uint32(b[4:8])), nil
+}
+
+// Returns the record of the network containing ip, or nil if there is none
+func (reader *mmdbReader) lookup(ip net.IP) (interface{}, error) {
+	address := ip.To4()
+	node := uint64(0)
+	if address != nil && reader.ipVersion == 6 {
+		node = reader.ipv4Start
+	} else if address == nil {
+		if reader.ipVersion != 6 {
+			return nil, nil
+		}
+
+		address = ip.To16()
+	}
+
+	for i := 0; i < len(address)*8 && node < reader.nodeCount; i++ {
+		var err error
+		node, err = reader.readNode(node, uint(address[i/8]>>(7-uint(i%8))&1))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if node <= reader.nodeCount {
+		return nil, nil
+	}
+
+	dataStart := reader.treeSize + 16
+	value, _, err := reader.decode(node-reader.nodeCount+reader.treeSize, dataStart, 0)
+	return value, err
+}
+
+// Decodes the value at offset. Pointers are relative to base, which is the
+// start of the data section or of the metadata
+func (reader *mmdbReader) decode(offset, base uint64, depth int) (interface{}, uint64, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("data is nested too deep")
+	}
+
+	next := func() (byte, error) {
+		if offset >= uint64(len(reader.buf)) {
+			return 0, errors.New("unexpected end of data")
+		}
+
+		offset += 1
+		return reader.buf[offset-1], nil
+	}
+
+	ctrl, err := next()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	dataType := int(ctrl >> 5)
+	if dataType == mmdbPointer {
+		sizeBits := (ctrl >> 3) & 0x3
+		pointer := uint64(0)
+		if sizeBits < 3 {
+			pointer = uint64(ctrl & 0x7)
+		}
+
+		for i := 0; i <= int(sizeBits); i++ {
+			b, err := next()
+			if err != nil {
+				return nil, 0, err
+			}
+
+			pointer = pointer<<8 | uint64(b)
+		}
+
+		switch sizeBits {
+		case 1:
+			pointer += 2048
+		case 2:
+			pointer += 526336
+		}
+
+		value, _, err := reader.decode(base+pointer, base, depth+1)
+		return value, offset, err
+	}
+
+	if dataType == mmdbExtended {
+		b, err := next()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		dataType = 7 + int(b)
+	}
+
+	size := uint64(ctrl & 0x1f)
+	if size >= 29 && dataType != mmdbBool {
+		extraBytes := int(size) - 28
+		extra := uint64(0)
+		for i := 0; i < extraBytes; i++ {
+			b, err := next()
+			if err != nil {
+				return nil, 0, err
+			}
+
+			extra = extra<<8 | uint64(b)
+		}
+
+		switch extraBytes {
+		case 1:
+			size = 29 + extra
+		case 2:
+			size = 285 + extra
+		case 3:
+			size = 65821 + extra
+		}
+	}
+
+	switch dataType {
+	case mmdbMap:
+		result := map[string]interface{}{}
+		for i := uint64(0); i < size; i++ {
+			key, newOffset, err := reader.decode(offset, base, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+
+			value, newOffset, err := reader.decode(newOffset, base, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			result[keyString] = value
+			offset = newOffset
+		}
+
+		return result, offset, nil
+	case mmdbArray:
+		result := []interface{}{}
+		for i := uint64(0); i < size; i++ {
+			value, newOffset, err := reader.decode(offset, base, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			result = append(result, value)
+			offset = newOffset
+		}
+
+		return result, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	case mmdbContainer, mmdbEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint64(len(reader.buf)) {
+		return nil, 0, errors.New("value is outside of the file")
+	}
+
+	data := reader.buf[offset : offset+size]
+	offset += size
+	switch dataType {
+	case mmdbString:
+		return string(data), offset, nil
+	case mmdbBytes:
+		return append([]byte{}, data...), offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("double is not 8 bytes")
+		}
+
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("float is not 4 bytes")
+		}
+
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), offset, nil
+	case mmdbInt32:
+		value := int64(0)
+		for _, b := range data {
+			value = value<<8 | int64(b)
+		}
+
+		return int64(int32(value)), offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbUint128:
+		// 128 bit values don't fit, and aren't used by the GeoIP databases
+		value := uint64(0)
+		for _, b := range data {
+			value = value<<8 | uint64(b)
+		}
+
+		return value, offset, nil
+	}
+
+	return nil, 0, errors.New(fmt.Sprintf("unknown data type %d", dataType))
+}
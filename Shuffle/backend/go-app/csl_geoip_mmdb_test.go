@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Writes small MaxMind DB files with 24 bit records for the tests
+type mmdbTestWriter struct {
+	ipVersion int
+	nodes     [][2]int64
+	data      bytes.Buffer
+}
+
+func newMmdbTestWriter(ipVersion int) *mmdbTestWriter {
+	return &mmdbTestWriter{
+		ipVersion: ipVersion,
+		nodes:     [][2]int64{{-1, -1}},
+	}
+}
+
+func encodeMmdbControl(buf *bytes.Buffer, dataType int, size int) {
+	ctrlType := dataType
+	if dataType > 7 {
+		ctrlType = mmdbExtended
+	}
+
+	switch {
+	case size < 29:
+		buf.WriteByte(byte(ctrlType<<5 | size))
+	case size < 285:
+		buf.WriteByte(byte(ctrlType<<5 | 29))
+	default:
+		buf.WriteByte(byte(ctrlType<<5 | 30))
+	}
+
+	if dataType > 7 {
+		buf.WriteByte(byte(dataType - 7))
+	}
+
+	switch {
+	case size >= 285:
+		buf.WriteByte(byte((size - 285) >> 8))
+		buf.WriteByte(byte(size - 285))
+	case size >= 29:
+		buf.WriteByte(byte(size - 29))
+	}
+}
+
+func encodeMmdbValue(buf *bytes.Buffer, value interface{}) {
+	switch value := value.(type) {
+	case string:
+		encodeMmdbControl(buf, mmdbString, len(value))
+		buf.WriteString(value)
+	case uint64:
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, value)
+		data = bytes.TrimLeft(data, "\x00")
+		encodeMmdbControl(buf, mmdbUint64, len(data))
+		buf.Write(data)
+	case float64:
+		encodeMmdbControl(buf, mmdbDouble, 8)
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, math.Float64bits(value))
+		buf.Write(data)
+	case bool:
+		size := 0
+		if value {
+			size = 1
+		}
+
+		encodeMmdbControl(buf, mmdbBool, size)
+	case []interface{}:
+		encodeMmdbControl(buf, mmdbArray, len(value))
+		for _, item := range value {
+			encodeMmdbValue(buf, item)
+		}
+	case map[string]interface{}:
+		keys := []string{}
+		for key := range value {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+		encodeMmdbControl(buf, mmdbMap, len(keys))
+		for _, key := range keys {
+			encodeMmdbValue(buf, key)
+			encodeMmdbValue(buf, value[key])
+		}
+	}
+}
+
+// Adds a network with its record. IPv4 networks in IPv6 databases go under ::/96
+func (writer *mmdbTestWriter) insert(cidr string, record interface{}) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+
+	address := []byte(network.IP)
+	ones, _ := network.Mask.Size()
+	if writer.ipVersion == 6 && len(address) == 4 {
+		address = append(make([]byte, 12), address...)
+		ones += 96
+	}
+
+	dataOffset := int64(writer.data.Len())
+	encodeMmdbValue(&writer.data, record)
+
+	node := 0
+	for i := 0; i < ones; i++ {
+		bit := address[i/8] >> (7 - uint(i%8)) & 1
+		if i == ones-1 {
+			writer.nodes[node][bit] = -2 - dataOffset
+			break
+		}
+
+		if writer.nodes[node][bit] < 0 {
+			writer.nodes = append(writer.nodes, [2]int64{-1, -1})
+			writer.nodes[node][bit] = int64(len(writer.nodes) - 1)
+		}
+
+		node = int(writer.nodes[node][bit])
+	}
+}
+
+func (writer *mmdbTestWriter) bytes() []byte {
+	nodeCount := int64(len(writer.nodes))
+	buf := bytes.Buffer{}
+	for _, node := range writer.nodes {
+		for _, record := range node {
+			switch {
+			case record == -1:
+				record = nodeCount
+			case record <= -2:
+				record = nodeCount + 16 + (-2 - record)
+			}
+
+			buf.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+
+	buf.Write(make([]byte, 16))
+	buf.Write(writer.data.Bytes())
+	buf.Write(mmdbMetadataMarker)
+	encodeMmdbValue(&buf, map[string]interface{}{
+		"node_count":    uint64(nodeCount),
+		"record_size":   uint64(24),
+		"ip_version":    uint64(writer.ipVersion),
+		"database_type": "GeoLite2-City",
+		"build_epoch":   uint64(1718000000),
+	})
+
+	return buf.Bytes()
+}
+
+func getTestGeoRecord(isoCode, city string) map[string]interface{} {
+	return map[string]interface{}{
+		"country": map[string]interface{}{
+			"iso_code": isoCode,
+			"names":    map[string]interface{}{"en": "Country " + isoCode},
+		},
+		"city": map[string]interface{}{
+			"names": map[string]interface{}{"en": city},
+		},
+		"location": map[string]interface{}{
+			"latitude":  59.91,
+			"longitude": 10.75,
+		},
+		"is_in_european_union": true,
+		"subdivisions":         []interface{}{"a", "b"},
+	}
+}
+
+func TestMmdbLookup(t *testing.T) {
+	for _, ipVersion := range []int{4, 6} {
+		writer := newMmdbTestWriter(ipVersion)
+		writer.insert("81.0.0.0/8", getTestGeoRecord("NO", "Oslo"))
+		writer.insert("8.8.8.0/24", getTestGeoRecord("US", "Mountain View with a name longer than 29 bytes"))
+		if ipVersion == 6 {
+			writer.insert("2001:db8::/32", getTestGeoRecord("SE", "Stockholm"))
+		}
+
+		reader, err := openMmdb(writer.bytes())
+		if err != nil {
+			t.Fatalf("openMmdb failed for IPv%d: %s", ipVersion, err)
+		}
+
+		if reader.databaseType != "GeoLite2-City" || reader.buildEpoch != 1718000000 {
+			t.Errorf("got metadata %s %d", reader.databaseType, reader.buildEpoch)
+		}
+
+		tests := []struct {
+			ip      string
+			isoCode string
+			city    string
+		}{
+			{ip: "81.2.3.4", isoCode: "NO", city: "Oslo"},
+			{ip: "8.8.8.8", isoCode: "US", city: "Mountain View with a name longer than 29 bytes"},
+			{ip: "8.8.9.8"},
+			{ip: "1.1.1.1"},
+			{ip: "2001:db8::1", isoCode: "SE", city: "Stockholm"},
+			{ip: "2001:db9::1"},
+		}
+
+		for _, test := range tests {
+			if ipVersion == 4 && test.isoCode == "SE" {
+				continue
+			}
+
+			result, err := reader.lookup(net.ParseIP(test.ip))
+			if err != nil {
+				t.Errorf("IPv%d lookup of %s failed: %s", ipVersion, test.ip, err)
+				continue
+			}
+
+			if len(test.isoCode) == 0 {
+				if result != nil {
+					t.Errorf("IPv%d lookup of %s returned %v, expected nothing", ipVersion, test.ip, result)
+				}
+
+				continue
+			}
+
+			expected := getTestGeoRecord(test.isoCode, test.city)
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("IPv%d lookup of %s returned %v, expected %v", ipVersion, test.ip, result, expected)
+			}
+		}
+	}
+}
+
+func TestMmdbDecodePointer(t *testing.T) {
+	// "id" at 0, then a map whose value points back to it
+	data := []byte{0x42, 'i', 'd', 0xe1, 0x42, 'k', 'y', 0x20, 0x00}
+	reader := &mmdbReader{buf: data}
+
+	value, next, err := reader.decode(3, 0, 0)
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+
+	if !reflect.DeepEqual(value, map[string]interface{}{"ky": "id"}) || next != uint64(len(data)) {
+		t.Errorf("got %v ending at %d", value, next)
+	}
+}
+
+func TestOpenMmdbErrors(t *testing.T) {
+	valid := newMmdbTestWriter(4)
+	valid.insert("81.0.0.0/8", getTestGeoRecord("NO", "Oslo"))
+
+	badRecordSize := bytes.Buffer{}
+	badRecordSize.Write(mmdbMetadataMarker)
+	encodeMmdbValue(&badRecordSize, map[string]interface{}{"node_count": uint64(1), "record_size": uint64(20), "ip_version": uint64(4)})
+
+	largeTree := bytes.Buffer{}
+	largeTree.Write(make([]byte, 16))
+	largeTree.Write(mmdbMetadataMarker)
+	encodeMmdbValue(&largeTree, map[string]interface{}{"node_count": uint64(1000), "record_size": uint64(24), "ip_version": uint64(4)})
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "no metadata", data: []byte("not a database")},
+		{name: "truncated metadata", data: valid.bytes()[:len(valid.bytes())-10]},
+		{name: "metadata not a map", data: append(append([]byte{}, mmdbMetadataMarker...), 0x41, 'x')},
+		{name: "unsupported record size", data: badRecordSize.Bytes()},
+		{name: "tree larger than file", data: largeTree.Bytes()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := openMmdb(test.data)
+			if err == nil {
+				t.Errorf("openMmdb succeeded, expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,814 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/gorilla/mux"
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslGitSyncDocument = "git_sync"
+
+// Returned instead of secrets when the git sync config is read
+const RedactedValue = "********"
+
+// Serializes git operations so concurrent saves don't race each other on push
+var cslGitSyncLock sync.Mutex
+
+type CslGitSyncConfig struct {
+	Enabled       bool   `json:"enabled"`
+	RepositoryUrl string `json:"repository_url"`
+	Branch        string `json:"branch"`
+	Folder        string `json:"folder"`
+	Username      string `json:"username"`
+	Token         string `json:"token"`
+	WebhookSecret string `json:"webhook_secret"`
+}
+
+// Sync state of a single workflow. RemoteHash is the hash of the workflow
+// file in the repository at the time of the last sync
+type CslGitSyncedWorkflow struct {
+	RemoteHash string `json:"remote_hash"`
+	SyncedAt   int64  `json:"synced_at"`
+}
+
+type CslGitSyncConflict struct {
+	WorkflowId string `json:"workflow_id"`
+	Name       string `json:"name"`
+	Reason     string `json:"reason"`
+	DetectedAt int64  `json:"detected_at"`
+}
+
+type CslGitSyncState struct {
+	LastPush   int64                           `json:"last_push"`
+	LastPull   int64                           `json:"last_pull"`
+	LastCommit string                          `json:"last_commit"`
+	LastError  string                          `json:"last_error"`
+	Workflows  map[string]CslGitSyncedWorkflow `json:"workflows"`
+	Conflicts  []CslGitSyncConflict            `json:"conflicts"`
+}
+
+type CslGitSync struct {
+	Config CslGitSyncConfig `json:"config"`
+	State  CslGitSyncState  `json:"state"`
+}
+
+type CslGitPullResult struct {
+	Updated   []string             `json:"updated"`
+	Created   []string             `json:"created"`
+	Conflicts []CslGitSyncConflict `json:"conflicts"`
+}
+
+func getCslGitSync(ctx context.Context, orgId string) CslGitSync {
+	gitSync := CslGitSync{}
+	_, err := getCslDocument(ctx, orgId, CslGitSyncDocument, &gitSync)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting git sync for org %s: %s", orgId, err)
+	}
+
+	if len(gitSync.Config.Branch) == 0 {
+		gitSync.Config.Branch = "main"
+	}
+
+	if len(gitSync.Config.Folder) == 0 {
+		gitSync.Config.Folder = "workflows"
+	}
+
+	if gitSync.State.Workflows == nil {
+		gitSync.State.Workflows = map[string]CslGitSyncedWorkflow{}
+	}
+
+	return gitSync
+}
+
+// Updates the sync state. The config is left as it is stored
+func updateCslGitSyncState(ctx context.Context, orgId string, update func(state *CslGitSyncState)) error {
+	gitSync := CslGitSync{}
+	return updateCslDocument(ctx, orgId, CslGitSyncDocument, &gitSync, func() error {
+		if gitSync.State.Workflows == nil {
+			gitSync.State.Workflows = map[string]CslGitSyncedWorkflow{}
+		}
+
+		update(&gitSync.State)
+		return nil
+	})
+}
+
+func validateCslGitSyncConfig(config CslGitSyncConfig) error {
+	if !config.Enabled {
+		return nil
+	}
+
+	if !strings.HasPrefix(config.RepositoryUrl, "https://") && !strings.HasPrefix(config.RepositoryUrl, "http://") {
+		return errors.New("repository_url must start with http:// or https://")
+	}
+
+	if strings.Contains(config.Folder, "..") || strings.HasPrefix(config.Folder, "/") {
+		return errors.New("folder must be a relative path inside the repository")
+	}
+
+	return nil
+}
+
+func getGitSyncFilename(config CslGitSyncConfig, workflowId string) string {
+	return path.Join(config.Folder, fmt.Sprintf("%s.json", workflowId))
+}
+
+func getGitContentHash(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
+
+// Serialized form of a workflow in the repository
+func marshalGitSyncWorkflow(workflow shuffle.Workflow) ([]byte, error) {
+	b, err := json.MarshalIndent(workflow, "", "    ")
+	if err != nil {
+		return b, err
+	}
+
+	return append(b, '\n'), nil
+}
+
+func removeGitSyncConflict(state *CslGitSyncState, workflowId string) {
+	conflicts := []CslGitSyncConflict{}
+	for _, conflict := range state.Conflicts {
+		if conflict.WorkflowId != workflowId {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	state.Conflicts = conflicts
+}
+
+// Records a conflict and notifies the org
+func addGitSyncConflict(ctx context.Context, orgId string, conflict CslGitSyncConflict) {
+	log.Printf("[WARNING] Git sync conflict for workflow %s in org %s: %s", conflict.WorkflowId, orgId, conflict.Reason)
+
+	err := updateCslGitSyncState(ctx, orgId, func(state *CslGitSyncState) {
+		removeGitSyncConflict(state, conflict.WorkflowId)
+		state.Conflicts = append(state.Conflicts, conflict)
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed storing git sync conflict for org %s: %s", orgId, err)
+	}
+
+	notifyCslEvent(ctx, orgId, EventGitSyncConflict, conflict)
+}
+
+func getGitSyncAuth(config CslGitSyncConfig) *githttp.BasicAuth {
+	if len(config.Token) == 0 {
+		return nil
+	}
+
+	// Most providers accept any username when a token is used
+	username := config.Username
+	if len(username) == 0 {
+		username = "shuffle"
+	}
+
+	return &githttp.BasicAuth{
+		Username: username,
+		Password: config.Token,
+	}
+}
+
+// Clones the configured branch into memory
+func cloneGitSyncRepository(config CslGitSyncConfig) (*git.Repository, billy.Filesystem, error) {
+	fs := memfs.New()
+	storer := memory.NewStorage()
+
+	cloneOptions := &git.CloneOptions{
+		URL:           config.RepositoryUrl,
+		ReferenceName: plumbing.NewBranchReferenceName(config.Branch),
+		SingleBranch:  true,
+		Depth:         1,
+	}
+
+	if auth := getGitSyncAuth(config); auth != nil {
+		cloneOptions.Auth = auth
+	}
+
+	repo, err := git.Clone(storer, fs, cloneOptions)
+	if err != nil {
+		return nil, fs, err
+	}
+
+	return repo, fs, nil
+}
+
+func readGitSyncFile(fs billy.Filesystem, filename string) ([]byte, error) {
+	file, err := fs.Open(filename)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+// Pushes a workflow to the repository. Unless force is set, the push is
+// refused if the file was changed in the repository since the last sync
+func pushWorkflowToGit(ctx context.Context, orgId string, workflow shuffle.Workflow, actor string, force bool) error {
+	cslGitSyncLock.Lock()
+	defer cslGitSyncLock.Unlock()
+
+	gitSync := getCslGitSync(ctx, orgId)
+	if !gitSync.Config.Enabled {
+		return errors.New("git sync is not enabled")
+	}
+
+	repo, fs, err := cloneGitSyncRepository(gitSync.Config)
+	if err != nil {
+		log.Printf("[ERROR] Failed cloning git sync repository for org %s: %s", orgId, err)
+		updateCslGitSyncState(ctx, orgId, func(state *CslGitSyncState) {
+			state.LastError = err.Error()
+		})
+
+		return err
+	}
+
+	filename := getGitSyncFilename(gitSync.Config, workflow.ID)
+	synced := gitSync.State.Workflows[workflow.ID]
+
+	existing, err := readGitSyncFile(fs, filename)
+	if err == nil && !force && getGitContentHash(existing) != synced.RemoteHash {
+		addGitSyncConflict(ctx, orgId, CslGitSyncConflict{
+			WorkflowId: workflow.ID,
+			Name:       workflow.Name,
+			Reason:     "workflow was changed both in the repository and in Shuffle",
+			DetectedAt: time.Now().Unix(),
+		})
+
+		return errors.New("workflow was changed in the repository since the last sync")
+	}
+
+	content, err := marshalGitSyncWorkflow(workflow)
+	if err != nil {
+		return err
+	}
+
+	if fs.MkdirAll(gitSync.Config.Folder, 0755) != nil {
+		return errors.New(fmt.Sprintf("failed creating folder %s", gitSync.Config.Folder))
+	}
+
+	file, err := fs.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(content)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	_, err = worktree.Add(filename)
+	if err != nil {
+		return err
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return err
+	}
+
+	if len(actor) == 0 {
+		actor = "git-sync"
+	}
+
+	commitHash := ""
+	if !status.IsClean() {
+		commit, err := worktree.Commit(fmt.Sprintf("Update workflow %s", workflow.Name), &git.CommitOptions{
+			Author: &object.Signature{
+				Name:  actor,
+				Email: "git-sync@shuffler.io",
+				When:  time.Now(),
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		pushOptions := &git.PushOptions{}
+		if auth := getGitSyncAuth(gitSync.Config); auth != nil {
+			pushOptions.Auth = auth
+		}
+
+		err = repo.Push(pushOptions)
+		if err != nil {
+			log.Printf("[ERROR] Failed pushing workflow %s to git for org %s: %s", workflow.ID, orgId, err)
+			updateCslGitSyncState(ctx, orgId, func(state *CslGitSyncState) {
+				state.LastError = err.Error()
+			})
+
+			return err
+		}
+
+		commitHash = commit.String()
+		log.Printf("[INFO] Pushed workflow %s to git for org %s in commit %s", workflow.ID, orgId, commitHash)
+	}
+
+	return updateCslGitSyncState(ctx, orgId, func(state *CslGitSyncState) {
+		state.Workflows[workflow.ID] = CslGitSyncedWorkflow{
+			RemoteHash: getGitContentHash(content),
+			SyncedAt:   time.Now().Unix(),
+		}
+
+		state.LastPush = time.Now().Unix()
+		state.LastError = ""
+		if len(commitHash) > 0 {
+			state.LastCommit = commitHash
+		}
+
+		removeGitSyncConflict(state, workflow.ID)
+	})
+}
+
+// Applies a workflow from the repository. Org placement and ownership always stay local
+func applyGitSyncWorkflow(ctx context.Context, orgId string, remote shuffle.Workflow, local *shuffle.Workflow) error {
+	if local != nil {
+		err := shuffle.SetWorkflowRevision(ctx, *local)
+		if err != nil {
+			log.Printf("[WARNING] Failed storing revision of workflow %s before git pull: %s", local.ID, err)
+		}
+
+		remote.Owner = local.Owner
+		remote.Org = local.Org
+		remote.Created = local.Created
+		remote.ExecutingOrg = local.ExecutingOrg
+	} else {
+		remote.Org = []shuffle.OrgMini{}
+		remote.ExecutingOrg = shuffle.OrgMini{Id: orgId}
+	}
+
+	remote.OrgId = orgId
+	remote.UpdatedBy = "git-sync"
+	return shuffle.SetWorkflow(ctx, remote, remote.ID)
+}
+
+// Pulls workflows from the repository. If workflowId is set only that workflow
+// is pulled. Unless force is set, workflows changed locally since the last
+// sync are reported as conflicts instead of being overwritten
+func pullWorkflowsFromGit(ctx context.Context, orgId, workflowId string, force bool) (CslGitPullResult, error) {
+	cslGitSyncLock.Lock()
+	defer cslGitSyncLock.Unlock()
+
+	result := CslGitPullResult{
+		Updated:   []string{},
+		Created:   []string{},
+		Conflicts: []CslGitSyncConflict{},
+	}
+
+	gitSync := getCslGitSync(ctx, orgId)
+	if !gitSync.Config.Enabled {
+		return result, errors.New("git sync is not enabled")
+	}
+
+	_, fs, err := cloneGitSyncRepository(gitSync.Config)
+	if err != nil {
+		log.Printf("[ERROR] Failed cloning git sync repository for org %s: %s", orgId, err)
+		updateCslGitSyncState(ctx, orgId, func(state *CslGitSyncState) {
+			state.LastError = err.Error()
+		})
+
+		return result, err
+	}
+
+	files, err := fs.ReadDir(gitSync.Config.Folder)
+	if err != nil {
+		return result, errors.New(fmt.Sprintf("folder %s not found in repository", gitSync.Config.Folder))
+	}
+
+	synced := map[string]CslGitSyncedWorkflow{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		fileWorkflowId := strings.TrimSuffix(file.Name(), ".json")
+		if len(workflowId) > 0 && fileWorkflowId != workflowId {
+			continue
+		}
+
+		content, err := readGitSyncFile(fs, path.Join(gitSync.Config.Folder, file.Name()))
+		if err != nil {
+			log.Printf("[WARNING] Failed reading %s from git sync repository: %s", file.Name(), err)
+			continue
+		}
+
+		hash := getGitContentHash(content)
+		previous, found := gitSync.State.Workflows[fileWorkflowId]
+		if found && previous.RemoteHash == hash && !force {
+			continue
+		}
+
+		remote := shuffle.Workflow{}
+		err = json.Unmarshal(content, &remote)
+		if err != nil {
+			log.Printf("[WARNING] Failed parsing %s from git sync repository: %s", file.Name(), err)
+			continue
+		}
+
+		remote.ID = fileWorkflowId
+
+		conflict := CslGitSyncConflict{WorkflowId: remote.ID, Name: remote.Name, DetectedAt: time.Now().Unix()}
+		local, err := shuffle.GetWorkflow(ctx, remote.ID)
+		if err != nil || local == nil || len(local.ID) == 0 {
+			local = nil
+		} else if local.OrgId != orgId {
+			conflict.Reason = "workflow id belongs to another organization"
+		} else if !force && (!found || local.Edited > previous.SyncedAt) {
+			conflict.Reason = "workflow was changed both in the repository and in Shuffle"
+		}
+
+		if len(conflict.Reason) > 0 {
+			addGitSyncConflict(ctx, orgId, conflict)
+			result.Conflicts = append(result.Conflicts, conflict)
+			continue
+		}
+
+		err = applyGitSyncWorkflow(ctx, orgId, remote, local)
+		if err != nil {
+			log.Printf("[ERROR] Failed applying workflow %s from git for org %s: %s", remote.ID, orgId, err)
+			continue
+		}
+
+		synced[remote.ID] = CslGitSyncedWorkflow{RemoteHash: hash, SyncedAt: time.Now().Unix()}
+		if local == nil {
+			result.Created = append(result.Created, remote.ID)
+		} else {
+			result.Updated = append(result.Updated, remote.ID)
+		}
+
+		recordCslActivity(ctx, orgId, ActivityTypeWorkflow, "synced_from_git", fmt.Sprintf("Workflow %s was updated from git", remote.Name), "git-sync", remote.ID)
+	}
+
+	err = updateCslGitSyncState(ctx, orgId, func(state *CslGitSyncState) {
+		for id, syncedWorkflow := range synced {
+			state.Workflows[id] = syncedWorkflow
+			removeGitSyncConflict(state, id)
+		}
+
+		state.LastPull = time.Now().Unix()
+		state.LastError = ""
+	})
+
+	return result, err
+}
+
+type cslStatusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *cslStatusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+// Wraps the workflow save handler to push saved workflows to git for orgs with git sync enabled
+func cslGitSyncOnSave(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		recorder := &cslStatusRecorder{ResponseWriter: resp, status: 200}
+		handler(recorder, request)
+
+		if request.Method != "PUT" || recorder.status != 200 {
+			return
+		}
+
+		workflowId := mux.Vars(request)["key"]
+		go func() {
+			ctx := context.Background()
+			workflow, err := shuffle.GetWorkflow(ctx, workflowId)
+			if err != nil || len(workflow.OrgId) == 0 {
+				return
+			}
+
+			if !getCslGitSync(ctx, workflow.OrgId).Config.Enabled {
+				return
+			}
+
+			err = pushWorkflowToGit(ctx, workflow.OrgId, *workflow, workflow.UpdatedBy, false)
+			if err != nil {
+				log.Printf("[WARNING] Git sync push on save failed for workflow %s: %s", workflowId, err)
+			}
+		}()
+	}
+}
+
+// GitHub signs with X-Hub-Signature-256, GitLab sends the secret in X-Gitlab-Token
+func validateGitWebhookSignature(request *http.Request, body []byte, secret string) bool {
+	if len(secret) == 0 {
+		return false
+	}
+
+	gitlabToken := request.Header.Get("X-Gitlab-Token")
+	if len(gitlabToken) > 0 {
+		return subtle.ConstantTimeCompare([]byte(gitlabToken), []byte(secret)) == 1
+	}
+
+	signature := strings.TrimPrefix(request.Header.Get("X-Hub-Signature-256"), "sha256=")
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+func redactCslGitSync(gitSync CslGitSync) CslGitSync {
+	if len(gitSync.Config.Token) > 0 {
+		gitSync.Config.Token = RedactedValue
+	}
+
+	if len(gitSync.Config.WebhookSecret) > 0 {
+		gitSync.Config.WebhookSecret = RedactedValue
+	}
+
+	return gitSync
+}
+
+/*
+Git sync:
+Returns the git sync configuration and state for the current organization.
+Secrets are redacted.
+
+	{
+	    "success": true,
+	    "data": {
+	        "config": {
+	            "enabled": true,
+	            "repository_url": "https://github.com/example/playbooks",
+	            "branch": "main",
+	            "folder": "workflows",
+	            "username": "",
+	            "token": "********",
+	            "webhook_secret": "********"
+	        },
+	        "state": {
+	            "last_push": 1700000000,
+	            "last_pull": 1700000000,
+	            "last_commit": "4b825dc...",
+	            "last_error": "",
+	            "workflows": {...},
+	            "conflicts": [
+	                {
+	                    "workflow_id": "...",
+	                    "name": "Phishing triage",
+	                    "reason": "workflow was changed both in the repository and in Shuffle",
+	                    "detected_at": 1700000000
+	                }
+	            ]
+	        }
+	    }
+	}
+*/
+func cslGetGitSync(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslGitSync(getCslGitSync(ctx, user.ActiveOrg.Id)),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetGitSync")
+}
+
+/*
+Git sync:
+Updates the git sync configuration. Requires org admin. Body uses the format of
+the config field returned from GET. Redacted secrets are kept as they are.
+Workflows are pushed when saved, and pulled when the repository calls
+/api/v1/csl/gitSync/webhook?org_id=<org_id> signed with the webhook secret.
+*/
+func cslSetGitSync(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	gitSync := getCslGitSync(ctx, user.ActiveOrg.Id)
+	previousConfig := gitSync.Config
+
+	err = json.Unmarshal(body, &gitSync.Config)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling git sync config: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if gitSync.Config.Token == RedactedValue {
+		gitSync.Config.Token = previousConfig.Token
+	}
+
+	if gitSync.Config.WebhookSecret == RedactedValue {
+		gitSync.Config.WebhookSecret = previousConfig.WebhookSecret
+	}
+
+	err = validateCslGitSyncConfig(gitSync.Config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	// Sync state is only valid for the repository it was created against
+	resetState := gitSync.Config.RepositoryUrl != previousConfig.RepositoryUrl || gitSync.Config.Branch != previousConfig.Branch || gitSync.Config.Folder != previousConfig.Folder
+
+	stored := CslGitSync{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslGitSyncDocument, &stored, func() error {
+		stored.Config = gitSync.Config
+		if resetState {
+			stored.State = CslGitSyncState{Workflows: map[string]CslGitSyncedWorkflow{}}
+		}
+
+		gitSync = stored
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated git sync config for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "git_sync_updated", "Git sync configuration was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslGitSync(gitSync),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetGitSync")
+}
+
+/*
+Git sync:
+Pulls changed workflows from the repository. Requires org admin.
+Use ?workflow_id=<id> to only pull a single workflow.
+*/
+func cslGitSyncPull(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	result, err := pullWorkflowsFromGit(ctx, user.ActiveOrg.Id, request.URL.Query().Get("workflow_id"), false)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    result,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGitSyncPull")
+}
+
+/*
+Git sync:
+Resolves a conflict. Requires org admin.
+Requires ?workflow_id=<id>&keep=local|remote. "local" overwrites the repository
+with the workflow in Shuffle and "remote" overwrites Shuffle with the repository.
+*/
+func cslGitSyncResolve(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	workflowId := query.Get("workflow_id")
+	if len(workflowId) != 36 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("workflow_id is not valid")))
+		return
+	}
+
+	var data interface{}
+	var err error
+	switch query.Get("keep") {
+	case "local":
+		workflow, getErr := getCslWorkflow(ctx, *user, workflowId)
+		if getErr != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(getErr))
+			return
+		}
+
+		err = pushWorkflowToGit(ctx, user.ActiveOrg.Id, *workflow, user.Username, true)
+	case "remote":
+		data, err = pullWorkflowsFromGit(ctx, user.ActiveOrg.Id, workflowId, true)
+	default:
+		err = errors.New("keep must be local or remote")
+	}
+
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) resolved git sync conflict for workflow %s keeping %s", user.Username, user.Id, workflowId, query.Get("keep"))
+
+	res := CslResponse{
+		Success: true,
+		Data:    data,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGitSyncResolve")
+}
+
+/*
+Git sync:
+Webhook called by the git provider on push. Requires ?org_id=<org_id> and a
+valid signature using the configured webhook secret.
+*/
+func cslGitSyncWebhook(resp http.ResponseWriter, request *http.Request) {
+	ctx := shuffle.GetContext(request)
+
+	orgId := request.URL.Query().Get("org_id")
+	if len(orgId) == 0 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("org_id is required")))
+		return
+	}
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	gitSync := getCslGitSync(ctx, orgId)
+	if !gitSync.Config.Enabled || !validateGitWebhookSignature(request, body, gitSync.Config.WebhookSecret) {
+		log.Printf("[WARNING] Invalid git sync webhook for org %s", orgId)
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("invalid signature")))
+		return
+	}
+
+	go func() {
+		result, err := pullWorkflowsFromGit(context.Background(), orgId, "", false)
+		if err != nil {
+			log.Printf("[ERROR] Git sync pull from webhook failed for org %s: %s", orgId, err)
+			return
+		}
+
+		log.Printf("[INFO] Git sync pull for org %s: %d updated, %d created, %d conflicts", orgId, len(result.Updated), len(result.Created), len(result.Conflicts))
+	}()
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGitSyncWebhook")
+}
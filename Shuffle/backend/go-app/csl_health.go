@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslHealthScoreDocument = "health_score"
+
+// Amount of weekly scores kept for trends
+const MaxHealthScoreHistory = 52
+
+type CslHealthComponent struct {
+	Name      string  `json:"name"`
+	Score     float64 `json:"score"`
+	Weight    float64 `json:"weight"`
+	Available bool    `json:"available"`
+	Details   string  `json:"details,omitempty"`
+}
+
+type CslHealthScore struct {
+	Score        float64              `json:"score"`
+	CalculatedAt int64                `json:"calculated_at"`
+	Components   []CslHealthComponent `json:"components"`
+}
+
+type CslHealthScoreHistoryItem struct {
+	Score        float64 `json:"score"`
+	CalculatedAt int64   `json:"calculated_at"`
+}
+
+type CslHealthScoreHistory struct {
+	Latest  CslHealthScore              `json:"latest"`
+	History []CslHealthScoreHistoryItem `json:"history"`
+}
+
+type CslHealthScoreResponse struct {
+	CslHealthScore
+	Trend   float64                     `json:"trend"`
+	History []CslHealthScoreHistoryItem `json:"history"`
+}
+
+func roundScore(score float64) float64 {
+	return math.Round(score*10) / 10
+}
+
+// Percentage of part in total, 100 if total is 0
+func getPercentage(part, total int64) float64 {
+	if total <= 0 {
+		return 100
+	}
+
+	return float64(part) / float64(total) * 100
+}
+
+// Percentage of total that isn't bad, 100 if total is 0
+func getHealthyPercentage(bad, total int64) float64 {
+	if total <= 0 {
+		return 100
+	}
+
+	return 100 - getPercentage(bad, total)
+}
+
+// Coverage: how many of the orgs workflows have been executed at least once
+func getCoverageComponent(ctx context.Context, orgId string) CslHealthComponent {
+	component := CslHealthComponent{Name: "coverage", Weight: 0.25}
+
+	snapshot, err := getStatsSnapshot(ctx, orgId)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting workflows for health score of org %s: %s", orgId, err)
+		return component
+	}
+
+	return getSnapshotCoverageComponent(snapshot)
+}
+
+func getSnapshotCoverageComponent(snapshot CslStatsSnapshot) CslHealthComponent {
+	executed := int64(snapshot.Workflows - snapshot.UnexecutedWorkflows)
+	return CslHealthComponent{
+		Name:      "coverage",
+		Weight:    0.25,
+		Available: true,
+		Score:     getPercentage(executed, int64(snapshot.Workflows)),
+	}
+}
+
+// Failure rate: share of workflow executions that didn't finish the last week, inverted.
+// Uses the same definition of failure as cslWorkflowChart
+func getFailureRateComponent(weekStats shuffle.DailyStatistics) CslHealthComponent {
+	return CslHealthComponent{
+		Name:      "failure_rate",
+		Weight:    0.25,
+		Available: true,
+		Score:     getHealthyPercentage(weekStats.WorkflowExecutions-weekStats.WorkflowExecutionsFinished, weekStats.WorkflowExecutions),
+	}
+}
+
+// Integration health: share of credentials that aren't expired or about to expire
+func getIntegrationHealthComponent(ctx context.Context, orgId string) CslHealthComponent {
+	component := CslHealthComponent{Name: "integration_health", Weight: 0.2}
+
+	settings := getCslOrgSettings(ctx, orgId)
+	statuses, err := getOrgCredentialStatuses(ctx, orgId, settings.CredentialExpiryWarningDays)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting credentials for health score of org %s: %s", orgId, err)
+		return component
+	}
+
+	unhealthy := int64(len(filterExpiringCredentials(statuses)))
+
+	component.Available = true
+	component.Score = getHealthyPercentage(unhealthy, int64(len(statuses)))
+	return component
+}
+
+// Backlog: share of the last week's executions that neither finished nor failed
+func getBacklogComponent(weekStats shuffle.DailyStatistics) CslHealthComponent {
+	unfinished := weekStats.WorkflowExecutions - weekStats.WorkflowExecutionsFinished - weekStats.WorkflowExecutionsFailed
+	if unfinished < 0 {
+		unfinished = 0
+	}
+
+	return CslHealthComponent{
+		Name:      "backlog",
+		Weight:    0.15,
+		Available: true,
+		Score:     getHealthyPercentage(unfinished, weekStats.WorkflowExecutions),
+	}
+}
+
+// SLA compliance isn't tracked by the backend, so the component is reported
+// as unavailable and left out of the weighting
+func getSlaComplianceComponent() CslHealthComponent {
+	return CslHealthComponent{
+		Name:    "sla_compliance",
+		Weight:  0.15,
+		Details: "No SLA data available",
+	}
+}
+
+// Calculates the health score of an org as the weighted average of the available components
+func calculateHealthScore(ctx context.Context, orgId string) (CslHealthScore, error) {
+	orgStats, err := shuffle.GetOrgStatistics(ctx, orgId)
+	if err != nil {
+		return CslHealthScore{}, err
+	}
+
+	weekStats := sumRecentDailyStatistics(orgStats, WeekLength)
+	return weighHealthComponents([]CslHealthComponent{
+		getCoverageComponent(ctx, orgId),
+		getFailureRateComponent(weekStats),
+		getIntegrationHealthComponent(ctx, orgId),
+		getBacklogComponent(weekStats),
+		getSlaComplianceComponent(),
+	}), nil
+}
+
+// Scores the components as the weighted average of the available ones
+func weighHealthComponents(components []CslHealthComponent) CslHealthScore {
+	totalWeight := 0.0
+	weightedScore := 0.0
+	for i, component := range components {
+		components[i].Score = roundScore(component.Score)
+		if !component.Available {
+			continue
+		}
+
+		totalWeight += component.Weight
+		weightedScore += component.Score * component.Weight
+	}
+
+	score := 0.0
+	if totalWeight > 0 {
+		score = roundScore(weightedScore / totalWeight)
+	}
+
+	return CslHealthScore{
+		Score:        score,
+		CalculatedAt: time.Now().Unix(),
+		Components:   components,
+	}
+}
+
+// Calculates and stores a new health score for the org
+func updateHealthScore(ctx context.Context, orgId string) (CslHealthScoreHistory, error) {
+	healthScore, err := calculateHealthScore(ctx, orgId)
+	if err != nil {
+		return CslHealthScoreHistory{}, err
+	}
+
+	history := CslHealthScoreHistory{}
+	err = updateCslDocument(ctx, orgId, CslHealthScoreDocument, &history, func() error {
+		history.Latest = healthScore
+		history.History = append(history.History, CslHealthScoreHistoryItem{
+			Score:        healthScore.Score,
+			CalculatedAt: healthScore.CalculatedAt,
+		})
+
+		if len(history.History) > MaxHealthScoreHistory {
+			history.History = history.History[len(history.History)-MaxHealthScoreHistory:]
+		}
+
+		return nil
+	})
+
+	return history, err
+}
+
+func getHealthScoreResponse(history CslHealthScoreHistory) CslHealthScoreResponse {
+	response := CslHealthScoreResponse{
+		CslHealthScore: history.Latest,
+		History:        history.History,
+	}
+
+	if len(history.History) > 1 {
+		previous := history.History[len(history.History)-2]
+		response.Trend = roundScore(history.Latest.Score - previous.Score)
+	}
+
+	return response
+}
+
+// Job: recalculates the health score of every org and includes it in the weekly report
+func runCslHealthScoreJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for health score job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		history, err := updateHealthScore(ctx, org.Id)
+		if err != nil {
+			log.Printf("[ERROR] Failed updating health score for org %s: %s", org.Id, err)
+			continue
+		}
+
+		notifyCslEvent(ctx, org.Id, EventWeeklyReport, map[string]interface{}{
+			"health_score": getHealthScoreResponse(history),
+		})
+	}
+}
+
+/*
+Dashboard:
+Returns the orgs health score (0-100) with the component breakdown, the trend
+compared to the previous weekly score and the score history. Scores are
+recalculated weekly. The first request for an org calculates it immediately.
+
+	{
+	    "success": true,
+	    "data": {
+	        "score": 82.5,
+	        "calculated_at": 1700000000,
+	        "components": [
+	            {
+	                "name": "coverage",
+	                "score": 90,
+	                "weight": 0.25,
+	                "available": true
+	            },
+	            ...
+	        ],
+	        "trend": -2.5,
+	        "history": [
+	            {
+	                "score": 85,
+	                "calculated_at": 1699395200
+	            },
+	            ...
+	        ]
+	    }
+	}
+*/
+func cslHealthScore(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	if useCslMockData(resp, request) {
+		res := CslResponse{
+			Success: true,
+			Data:    getHealthScoreResponse(getMockHealthScoreHistory(getCslMockData(ctx, user.ActiveOrg.Id))),
+		}
+
+		marshalAndWriteResponse(resp, res, "cslHealthScore")
+		return
+	}
+
+	history := CslHealthScoreHistory{}
+	found, err := getCslDocument(ctx, user.ActiveOrg.Id, CslHealthScoreDocument, &history)
+	if err != nil || !found || len(history.History) == 0 {
+		history, err = updateHealthScore(ctx, user.ActiveOrg.Id)
+		if err != nil {
+			log.Printf("[ERROR] Failed calculating health score for org %s: %s", user.ActiveOrg.Id, err)
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    getHealthScoreResponse(history),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslHealthScore")
+}
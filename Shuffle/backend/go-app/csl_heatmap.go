@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const DefaultHeatmapWeeks = 4
+const MaxHeatmapWeeks = 12
+
+// Most recent executions of each workflow counted in the heatmap
+const MaxHeatmapExecutionsPerWorkflow = 2000
+
+// Days are ordered from monday, the same as ISO weeks
+var heatmapWeekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+
+type CslHeatmapDay struct {
+	Day   string  `json:"day"`
+	Hours []int64 `json:"hours"`
+	Total int64   `json:"total"`
+}
+
+type CslExecutionHeatmap struct {
+	Weeks    int             `json:"weeks"`
+	Timezone string          `json:"timezone"`
+	Total    int64           `json:"total"`
+	PeakDay  string          `json:"peak_day"`
+	PeakHour int             `json:"peak_hour"`
+	Days     []CslHeatmapDay `json:"days"`
+}
+
+func getHeatmapDayIndex(weekday time.Weekday) int {
+	return (int(weekday) + 6) % 7
+}
+
+func newExecutionHeatmap(weeks int, location *time.Location) CslExecutionHeatmap {
+	heatmap := CslExecutionHeatmap{
+		Weeks:    weeks,
+		Timezone: location.String(),
+		PeakHour: -1,
+		Days:     []CslHeatmapDay{},
+	}
+
+	for _, weekday := range heatmapWeekdays {
+		heatmap.Days = append(heatmap.Days, CslHeatmapDay{
+			Day:   weekday.String(),
+			Hours: make([]int64, 24),
+		})
+	}
+
+	return heatmap
+}
+
+func setHeatmapPeak(heatmap *CslExecutionHeatmap) {
+	peak := int64(0)
+	for _, day := range heatmap.Days {
+		for hour, count := range day.Hours {
+			if count > peak {
+				peak = count
+				heatmap.PeakDay = day.Day
+				heatmap.PeakHour = hour
+			}
+		}
+	}
+}
+
+// Buckets the executions of the orgs workflows started in the last weeks by
+// day of week and hour of day in the org timezone
+func getExecutionHeatmap(ctx context.Context, orgId string, weeks int) (CslExecutionHeatmap, error) {
+	location := getTimezoneLocation(getCslOrgSettings(ctx, orgId).Timezone)
+	heatmap := newExecutionHeatmap(weeks, location)
+	workflows, err := getOrgWorkflows(ctx, orgId)
+	if err != nil {
+		return heatmap, err
+	}
+
+	executions := make([][]shuffle.WorkflowExecution, len(workflows))
+	err = runConcurrentLookups(ctx, len(workflows), func(ctx context.Context, index int) error {
+		workflowExecutions, err := shuffle.GetAllWorkflowExecutions(ctx, workflows[index].ID, MaxHeatmapExecutionsPerWorkflow)
+		executions[index] = workflowExecutions
+		return err
+	})
+	if err != nil {
+		return heatmap, err
+	}
+
+	since := time.Now().AddDate(0, 0, -weeks*WeekLength).Unix()
+	for _, workflowExecutions := range executions {
+		for _, execution := range workflowExecutions {
+			if execution.StartedAt < since {
+				continue
+			}
+
+			started := time.Unix(execution.StartedAt, 0).In(location)
+			day := &heatmap.Days[getHeatmapDayIndex(started.Weekday())]
+			day.Hours[started.Hour()]++
+			day.Total++
+			heatmap.Total++
+		}
+	}
+
+	setHeatmapPeak(&heatmap)
+	return heatmap, nil
+}
+
+/*
+Dashboard:
+Returns workflow execution counts of the current organization for the last
+?weeks=N weeks (default 4, max 12) bucketed by day of week and hour of day,
+in the timezone set with the timezone CSL setting. Days start on Monday and
+each day has 24 hourly counts. Peak hour is -1 when there are no executions.
+
+	{
+	    "success": true,
+	    "data": {
+	        "weeks": 4,
+	        "timezone": "Europe/Oslo",
+	        "total": 5230,
+	        "peak_day": "Tuesday",
+	        "peak_hour": 9,
+	        "days": [
+	            {
+	                "day": "Monday",
+	                "hours": [
+	                    12,
+	                    8,
+	                    ...
+	                ],
+	                "total": 840
+	            },
+	            ...
+	        ]
+	    }
+	}
+*/
+func cslExecutionHeatmap(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	weeks := DefaultHeatmapWeeks
+	if value := request.URL.Query().Get("weeks"); len(value) > 0 {
+		parsedWeeks, err := strconv.Atoi(value)
+		if err != nil || parsedWeeks < 1 || parsedWeeks > MaxHeatmapWeeks {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("weeks must be between 1 and %d", MaxHeatmapWeeks))))
+			return
+		}
+
+		weeks = parsedWeeks
+	}
+
+	if useCslMockData(resp, request) {
+		res := CslResponse{
+			Success: true,
+			Data:    getMockExecutionHeatmap(getCslMockData(ctx, user.ActiveOrg.Id), weeks),
+		}
+
+		marshalAndWriteResponse(resp, res, "cslExecutionHeatmap")
+		return
+	}
+
+	heatmap, err := getExecutionHeatmap(ctx, user.ActiveOrg.Id, weeks)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting execution heatmap for org %s: %s", user.ActiveOrg.Id, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    heatmap,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslExecutionHeatmap")
+}
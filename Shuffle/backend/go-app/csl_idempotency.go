@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Idempotency keys for workflow runs and webhooks. Systems retrying a
+// delivery send the same Idempotency-Key header, and get the response of the
+// first execution back instead of starting a new one. Only responses with an
+// execution are kept, so failed requests can be retried with the same key.
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+const IdempotencyReplayedHeader = "Idempotent-Replayed"
+
+const IdempotencyExpirationMinutes = 24 * 60
+const MaxIdempotencyKeyLength = 255
+
+type CslIdempotentResponse struct {
+	RequestHash string `json:"request_hash"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	Body        string `json:"body"`
+	ExecutionId string `json:"execution_id"`
+	CreatedAt   int64  `json:"created_at"`
+}
+
+// Keys of requests being handled, so concurrent deliveries don't both start
+// an execution
+var cslIdempotencyInFlight = struct {
+	sync.Mutex
+	keys map[string]bool
+}{keys: map[string]bool{}}
+
+// Keys are scoped to the workflow or webhook they were sent to
+func getIdempotencyCacheKey(request *http.Request, key string) string {
+	target := fmt.Sprintf("workflow_%s", mux.Vars(request)["key"])
+	if strings.HasPrefix(request.URL.Path, "/api/v1/hooks/") {
+		target = fmt.Sprintf("hook_%s", mux.Vars(request)["key"])
+	}
+
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s_%s", target, key)))
+	return fmt.Sprintf("csl_idempotency_%s", hex.EncodeToString(hash[:]))
+}
+
+func getIdempotencyRequestHash(request *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(request.Method))
+	hash.Write([]byte(request.URL.RawQuery))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func getIdempotentResponse(request *http.Request, cacheKey string) (*CslIdempotentResponse, error) {
+	cache, err := shuffle.GetCache(shuffle.GetContext(request), cacheKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheData, ok := cache.([]uint8)
+	if !ok {
+		return nil, errors.New("bad idempotency cache data")
+	}
+
+	stored := CslIdempotentResponse{}
+	err = json.Unmarshal([]byte(cacheData), &stored)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stored, nil
+}
+
+func lockIdempotencyKey(cacheKey string) bool {
+	cslIdempotencyInFlight.Lock()
+	defer cslIdempotencyInFlight.Unlock()
+
+	if cslIdempotencyInFlight.keys[cacheKey] {
+		return false
+	}
+
+	cslIdempotencyInFlight.keys[cacheKey] = true
+	return true
+}
+
+func unlockIdempotencyKey(cacheKey string) {
+	cslIdempotencyInFlight.Lock()
+	delete(cslIdempotencyInFlight.keys, cacheKey)
+	cslIdempotencyInFlight.Unlock()
+}
+
+func writeIdempotentResponse(resp http.ResponseWriter, stored CslIdempotentResponse) {
+	if len(stored.ContentType) > 0 {
+		resp.Header().Set("Content-Type", stored.ContentType)
+	}
+
+	resp.Header().Set(IdempotencyReplayedHeader, "true")
+	resp.WriteHeader(stored.Status)
+	resp.Write([]byte(stored.Body))
+}
+
+// Wraps the execution and webhook handlers to return the response of the
+// first request for requests with an Idempotency-Key already used
+func cslIdempotency(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		key := strings.TrimSpace(request.Header.Get(IdempotencyKeyHeader))
+		if request.Method == "OPTIONS" || len(key) == 0 {
+			handler(resp, request)
+			return
+		}
+
+		if len(key) > MaxIdempotencyKeyLength {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("%s can be at most %d characters", IdempotencyKeyHeader, MaxIdempotencyKeyLength))))
+			return
+		}
+
+		body := []byte{}
+		if request.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(request.Body)
+			if err != nil {
+				resp.WriteHeader(400)
+				resp.Write(createCslErrorResponse(err))
+				return
+			}
+
+			request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		cacheKey := getIdempotencyCacheKey(request, key)
+		requestHash := getIdempotencyRequestHash(request, body)
+		if !lockIdempotencyKey(cacheKey) {
+			resp.WriteHeader(409)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("a request with this %s is already being handled", IdempotencyKeyHeader))))
+			return
+		}
+
+		defer unlockIdempotencyKey(cacheKey)
+
+		stored, err := getIdempotentResponse(request, cacheKey)
+		if err == nil {
+			if stored.RequestHash != requestHash {
+				resp.WriteHeader(422)
+				resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("%s was already used with a different request", IdempotencyKeyHeader))))
+				return
+			}
+
+			log.Printf("[INFO] Returning execution %s for duplicate request to %s with the same %s", stored.ExecutionId, request.URL.Path, IdempotencyKeyHeader)
+			writeIdempotentResponse(resp, *stored)
+			return
+		}
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+
+		for name, values := range recorder.Header() {
+			resp.Header()[name] = values
+		}
+
+		resp.WriteHeader(recorder.Code)
+		resp.Write(recorder.Body.Bytes())
+
+		if recorder.Code != 200 {
+			return
+		}
+
+		execution := struct {
+			ExecutionId string `json:"execution_id"`
+		}{}
+
+		err = json.Unmarshal(recorder.Body.Bytes(), &execution)
+		if err != nil || len(execution.ExecutionId) == 0 {
+			return
+		}
+
+		cacheData, err := json.Marshal(CslIdempotentResponse{
+			RequestHash: requestHash,
+			Status:      recorder.Code,
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.Body.String(),
+			ExecutionId: execution.ExecutionId,
+			CreatedAt:   time.Now().Unix(),
+		})
+		if err != nil {
+			log.Printf("[WARNING] Failed marshalling idempotent response of execution %s: %s", execution.ExecutionId, err)
+			return
+		}
+
+		err = shuffle.SetCache(shuffle.GetContext(request), cacheKey, cacheData, IdempotencyExpirationMinutes)
+		if err != nil {
+			log.Printf("[WARNING] Failed storing idempotent response of execution %s: %s", execution.ExecutionId, err)
+		}
+	}
+}
@@ -0,0 +1,947 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslJiraDocument = "jira"
+const CslJiraIssuesDocument = "jira_issues"
+
+const JiraSyncMinutes = 10
+
+// Max amount of linked issues kept per org. Oldest resolved issues are dropped first
+const MaxJiraIssues = 500
+const MaxJiraCommentLength = 10000
+
+// Jira returns comment timestamps in this format
+const JiraTimeFormat = "2006-01-02T15:04:05.000-0700"
+
+// Source of tickets for executions escalated by users, as opposed to alerts
+// opened by the alert rules
+const TicketSourceExecution = "execution"
+
+// Sources are alert events or "execution", with "*" matching all of them.
+// Summary, Description and the values of Fields are templates, see
+// renderNotificationTemplate. Fields maps Jira field ids such as customfield_10010
+// to text values. Priorities maps severities to Jira priority names. When
+// ResolveTransition is set, the issue of an alert is moved with the
+// transition of that name when the alert clears
+type CslJiraMapping struct {
+	Sources           []string          `json:"sources"`
+	ProjectKey        string            `json:"project_key"`
+	IssueType         string            `json:"issue_type"`
+	Summary           string            `json:"summary"`
+	Description       string            `json:"description"`
+	Labels            []string          `json:"labels"`
+	Priorities        map[string]string `json:"priorities"`
+	Fields            map[string]string `json:"fields"`
+	ResolveTransition string            `json:"resolve_transition"`
+}
+
+// Email and ApiToken are used for Jira Cloud. Without Email, ApiToken is sent
+// as a personal access token for Jira Server and Data Center. The first
+// mapping matching the source is used
+type CslJiraConfig struct {
+	Enabled  bool             `json:"enabled"`
+	BaseUrl  string           `json:"base_url"`
+	Email    string           `json:"email"`
+	ApiToken string           `json:"api_token"`
+	Mappings []CslJiraMapping `json:"mappings"`
+}
+
+type CslJiraState struct {
+	LastSync  int64  `json:"last_sync"`
+	LastError string `json:"last_error"`
+}
+
+type CslJira struct {
+	Config CslJiraConfig `json:"config"`
+	State  CslJiraState  `json:"state"`
+}
+
+// Origin is "shuffle" for comments added through Shuffle and "jira" for
+// comments synced from the issue
+type CslJiraComment struct {
+	Id      string `json:"id"`
+	Author  string `json:"author"`
+	Body    string `json:"body"`
+	Origin  string `json:"origin"`
+	Created int64  `json:"created"`
+}
+
+type CslJiraIssue struct {
+	Key       string           `json:"key"`
+	Url       string           `json:"url"`
+	Source    string           `json:"source"`
+	SourceKey string           `json:"source_key"`
+	Summary   string           `json:"summary"`
+	Status    string           `json:"status"`
+	Resolved  bool             `json:"resolved"`
+	Created   int64            `json:"created"`
+	Updated   int64            `json:"updated"`
+	Comments  []CslJiraComment `json:"comments"`
+}
+
+// Linked issues keyed by <source>:<source key>
+type CslJiraIssues struct {
+	Issues map[string]CslJiraIssue `json:"issues"`
+}
+
+type jiraComment struct {
+	Id     string `json:"id"`
+	Body   string `json:"body"`
+	Author struct {
+		DisplayName string `json:"displayName"`
+	} `json:"author"`
+	Created string `json:"created"`
+}
+
+type jiraIssue struct {
+	Key    string `json:"key"`
+	Fields struct {
+		Status struct {
+			Name           string `json:"name"`
+			StatusCategory struct {
+				Key string `json:"key"`
+			} `json:"statusCategory"`
+		} `json:"status"`
+		Comment struct {
+			Comments []jiraComment `json:"comments"`
+		} `json:"comment"`
+	} `json:"fields"`
+}
+
+func getCslJira(ctx context.Context, orgId string) CslJira {
+	jira := CslJira{}
+	_, err := getCslDocument(ctx, orgId, CslJiraDocument, &jira)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting jira config for org %s: %s", orgId, err)
+	}
+
+	if jira.Config.Mappings == nil {
+		jira.Config.Mappings = []CslJiraMapping{}
+	}
+
+	return jira
+}
+
+func updateCslJiraState(ctx context.Context, orgId string, update func(state *CslJiraState)) error {
+	jira := CslJira{}
+	return updateCslDocument(ctx, orgId, CslJiraDocument, &jira, func() error {
+		update(&jira.State)
+		return nil
+	})
+}
+
+func redactCslJira(jira CslJira) CslJira {
+	if len(jira.Config.ApiToken) > 0 {
+		jira.Config.ApiToken = RedactedValue
+	}
+
+	return jira
+}
+
+func validateCslJiraConfig(config CslJiraConfig) error {
+	if len(config.BaseUrl) > 0 && !strings.HasPrefix(config.BaseUrl, "https://") {
+		return errors.New("base_url must start with https://")
+	}
+
+	for i, mapping := range config.Mappings {
+		if len(mapping.Sources) == 0 {
+			return errors.New(fmt.Sprintf("mapping %d has no sources", i))
+		}
+
+		for _, source := range mapping.Sources {
+			if source != "*" && source != TicketSourceExecution && !shuffle.ArrayContains(alertEvents, source) {
+				return errors.New(fmt.Sprintf("unknown source %s in mapping %d. Available sources are %s, %s", source, i, TicketSourceExecution, strings.Join(alertEvents, ", ")))
+			}
+		}
+
+		if len(mapping.ProjectKey) == 0 || len(mapping.IssueType) == 0 {
+			return errors.New(fmt.Sprintf("project_key and issue_type are required in mapping %d", i))
+		}
+
+		for severity := range mapping.Priorities {
+			if _, ok := severityLevels[severity]; !ok {
+				return errors.New(fmt.Sprintf("unknown severity %s in the priorities of mapping %d", severity, i))
+			}
+		}
+	}
+
+	if config.Enabled && (len(config.BaseUrl) == 0 || len(config.ApiToken) == 0) {
+		return errors.New("base_url and api_token are required")
+	}
+
+	return nil
+}
+
+func getJiraMapping(config CslJiraConfig, source string) (CslJiraMapping, bool) {
+	for _, mapping := range config.Mappings {
+		for _, mappingSource := range mapping.Sources {
+			if mappingSource == source || mappingSource == "*" {
+				return mapping, true
+			}
+		}
+	}
+
+	return CslJiraMapping{}, false
+}
+
+// Tickets are linked by <source>:<source key>
+func getTicketId(source, sourceKey string) string {
+	return fmt.Sprintf("%s:%s", source, sourceKey)
+}
+
+func getJiraIssueFields(mapping CslJiraMapping, notification CslNotification) map[string]interface{} {
+	summary := mapping.Summary
+	if len(summary) == 0 {
+		summary = "{{title}}"
+	}
+
+	description := mapping.Description
+	if len(description) == 0 {
+		description = "{{text}}\n\n{{fields}}"
+	}
+
+	fields := map[string]interface{}{
+		"project":     map[string]string{"key": mapping.ProjectKey},
+		"issuetype":   map[string]string{"name": mapping.IssueType},
+		"summary":     truncateText(strings.ReplaceAll(renderNotificationTemplate(summary, notification), "\n", " "), 255),
+		"description": renderNotificationTemplate(description, notification),
+		"labels":      append([]string{"shuffle"}, mapping.Labels...),
+	}
+
+	if priority, ok := mapping.Priorities[notification.Severity]; ok {
+		fields["priority"] = map[string]string{"name": priority}
+	}
+
+	for field, template := range mapping.Fields {
+		fields[field] = renderNotificationTemplate(template, notification)
+	}
+
+	return fields
+}
+
+// Sends a request to the Jira REST API v2 and unmarshals the response into result
+func jiraRequest(ctx context.Context, config CslJiraConfig, method, path string, data interface{}, result interface{}) error {
+	var body *bytes.Buffer
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewBuffer(b)
+	} else {
+		body = bytes.NewBuffer([]byte{})
+	}
+
+	requestUrl := fmt.Sprintf("%s/rest/api/2/%s", strings.TrimRight(config.BaseUrl, "/"), path)
+	req, err := http.NewRequestWithContext(ctx, method, requestUrl, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+	if len(config.Email) > 0 {
+		req.SetBasicAuth(config.Email, config.ApiToken)
+	} else {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", config.ApiToken))
+	}
+
+	client := shuffle.GetExternalClient(requestUrl)
+	newresp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer newresp.Body.Close()
+	respBody, err := ioutil.ReadAll(newresp.Body)
+	if err != nil {
+		return err
+	}
+
+	if newresp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("jira returned status code %d for %s: %s", newresp.StatusCode, path, truncateText(string(respBody), 500)))
+	}
+
+	if result == nil || len(respBody) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(respBody, result)
+}
+
+func addJiraComment(ctx context.Context, config CslJiraConfig, issueKey, body string) (jiraComment, error) {
+	comment := jiraComment{}
+	err := jiraRequest(ctx, config, "POST", fmt.Sprintf("issue/%s/comment", url.PathEscape(issueKey)), map[string]string{"body": body}, &comment)
+	return comment, err
+}
+
+func transitionJiraIssue(ctx context.Context, config CslJiraConfig, issueKey, transitionName string) error {
+	transitions := struct {
+		Transitions []struct {
+			Id   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"transitions"`
+	}{}
+
+	err := jiraRequest(ctx, config, "GET", fmt.Sprintf("issue/%s/transitions", url.PathEscape(issueKey)), nil, &transitions)
+	if err != nil {
+		return err
+	}
+
+	for _, transition := range transitions.Transitions {
+		if strings.EqualFold(transition.Name, transitionName) {
+			return jiraRequest(ctx, config, "POST", fmt.Sprintf("issue/%s/transitions", url.PathEscape(issueKey)), map[string]interface{}{
+				"transition": map[string]string{"id": transition.Id},
+			}, nil)
+		}
+	}
+
+	return errors.New(fmt.Sprintf("transition %s isn't available for issue %s", transitionName, issueKey))
+}
+
+func parseJiraTime(value string) int64 {
+	timestamp, err := time.Parse(JiraTimeFormat, value)
+	if err != nil {
+		return time.Now().Unix()
+	}
+
+	return timestamp.Unix()
+}
+
+// Stores a linked issue, dropping the oldest resolved issues above MaxJiraIssues
+func storeCslJiraIssue(ctx context.Context, orgId string, issue CslJiraIssue) error {
+	issues := CslJiraIssues{}
+	return updateCslDocument(ctx, orgId, CslJiraIssuesDocument, &issues, func() error {
+		if issues.Issues == nil {
+			issues.Issues = map[string]CslJiraIssue{}
+		}
+
+		issues.Issues[getTicketId(issue.Source, issue.SourceKey)] = issue
+		if len(issues.Issues) <= MaxJiraIssues {
+			return nil
+		}
+
+		resolved := []string{}
+		for id, stored := range issues.Issues {
+			if stored.Resolved {
+				resolved = append(resolved, id)
+			}
+		}
+
+		sort.Slice(resolved, func(i, j int) bool {
+			return issues.Issues[resolved[i]].Created < issues.Issues[resolved[j]].Created
+		})
+
+		for _, id := range resolved {
+			if len(issues.Issues) <= MaxJiraIssues {
+				break
+			}
+
+			delete(issues.Issues, id)
+		}
+
+		return nil
+	})
+}
+
+func getCslJiraIssues(ctx context.Context, orgId string) CslJiraIssues {
+	issues := CslJiraIssues{}
+	_, err := getCslDocument(ctx, orgId, CslJiraIssuesDocument, &issues)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting jira issues for org %s: %s", orgId, err)
+	}
+
+	if issues.Issues == nil {
+		issues.Issues = map[string]CslJiraIssue{}
+	}
+
+	return issues
+}
+
+// Opens a Jira issue for a source if Jira is enabled for the org and a mapping
+// matches it. An unresolved issue that is already linked is returned as it is
+func openCslJiraIssue(ctx context.Context, orgId, source, sourceKey string, notification CslNotification) (*CslJiraIssue, error) {
+	jira := getCslJira(ctx, orgId)
+	if !jira.Config.Enabled {
+		return nil, nil
+	}
+
+	mapping, ok := getJiraMapping(jira.Config, source)
+	if !ok {
+		return nil, nil
+	}
+
+	// An alert that clears and triggers again while its issue is still being
+	// worked on is commented on the same issue
+	if existing, ok := getCslJiraIssues(ctx, orgId).Issues[getTicketId(source, sourceKey)]; ok && !existing.Resolved {
+		if source != TicketSourceExecution {
+			_, err := addJiraComment(ctx, jira.Config, existing.Key, fmt.Sprintf("The alert was triggered again in Shuffle: %s", notification.Title))
+			if err != nil {
+				log.Printf("[WARNING] Failed commenting on jira issue %s for org %s: %s", existing.Key, orgId, err)
+			}
+		}
+
+		return &existing, nil
+	}
+
+	created := struct {
+		Key string `json:"key"`
+	}{}
+
+	err := jiraRequest(ctx, jira.Config, "POST", "issue", map[string]interface{}{"fields": getJiraIssueFields(mapping, notification)}, &created)
+	if err != nil {
+		log.Printf("[ERROR] Failed creating jira issue for %s in org %s: %s", getTicketId(source, sourceKey), orgId, err)
+		updateCslJiraState(ctx, orgId, func(state *CslJiraState) {
+			state.LastError = err.Error()
+		})
+
+		return nil, err
+	}
+
+	issue := CslJiraIssue{
+		Key:       created.Key,
+		Url:       fmt.Sprintf("%s/browse/%s", strings.TrimRight(jira.Config.BaseUrl, "/"), created.Key),
+		Source:    source,
+		SourceKey: sourceKey,
+		Summary:   notification.Title,
+		Created:   time.Now().Unix(),
+		Updated:   time.Now().Unix(),
+		Comments:  []CslJiraComment{},
+	}
+
+	err = storeCslJiraIssue(ctx, orgId, issue)
+	if err != nil {
+		log.Printf("[ERROR] Failed storing jira issue %s for org %s: %s", issue.Key, orgId, err)
+	}
+
+	log.Printf("[INFO] Created jira issue %s for %s in org %s", issue.Key, getTicketId(source, sourceKey), orgId)
+	return &issue, nil
+}
+
+// Comments on the issue of a cleared alert and transitions it if the mapping
+// has a resolve transition. Without a transition the issue stays unresolved
+// until it is done in Jira
+func resolveCslJiraIssue(ctx context.Context, orgId, source, sourceKey string) {
+	jira := getCslJira(ctx, orgId)
+	if !jira.Config.Enabled {
+		return
+	}
+
+	issue, ok := getCslJiraIssues(ctx, orgId).Issues[getTicketId(source, sourceKey)]
+	if !ok || issue.Resolved {
+		return
+	}
+
+	comment, err := addJiraComment(ctx, jira.Config, issue.Key, "The alert was resolved in Shuffle")
+	if err != nil {
+		log.Printf("[WARNING] Failed commenting on jira issue %s for org %s: %s", issue.Key, orgId, err)
+	} else {
+		issue.Comments = append(issue.Comments, CslJiraComment{
+			Id:      comment.Id,
+			Author:  "shuffle",
+			Body:    comment.Body,
+			Origin:  "shuffle",
+			Created: time.Now().Unix(),
+		})
+	}
+
+	mapping, _ := getJiraMapping(jira.Config, source)
+	if len(mapping.ResolveTransition) > 0 {
+		err = transitionJiraIssue(ctx, jira.Config, issue.Key, mapping.ResolveTransition)
+		if err != nil {
+			log.Printf("[WARNING] Failed transitioning jira issue %s for org %s: %s", issue.Key, orgId, err)
+		} else {
+			issue.Status = mapping.ResolveTransition
+			issue.Resolved = true
+		}
+	}
+
+	issue.Updated = time.Now().Unix()
+	err = storeCslJiraIssue(ctx, orgId, issue)
+	if err != nil {
+		log.Printf("[ERROR] Failed storing jira issue %s for org %s: %s", issue.Key, orgId, err)
+	}
+}
+
+// Updates the status of an issue and adds comments that were made in Jira
+func syncCslJiraIssue(ctx context.Context, config CslJiraConfig, issue CslJiraIssue) (CslJiraIssue, error) {
+	remote := jiraIssue{}
+	err := jiraRequest(ctx, config, "GET", fmt.Sprintf("issue/%s?fields=status,comment", url.PathEscape(issue.Key)), nil, &remote)
+	if err != nil {
+		return issue, err
+	}
+
+	known := map[string]bool{}
+	for _, comment := range issue.Comments {
+		known[comment.Id] = true
+	}
+
+	for _, comment := range remote.Fields.Comment.Comments {
+		if known[comment.Id] {
+			continue
+		}
+
+		issue.Comments = append(issue.Comments, CslJiraComment{
+			Id:      comment.Id,
+			Author:  comment.Author.DisplayName,
+			Body:    comment.Body,
+			Origin:  "jira",
+			Created: parseJiraTime(comment.Created),
+		})
+		issue.Updated = time.Now().Unix()
+	}
+
+	issue.Status = remote.Fields.Status.Name
+	if remote.Fields.Status.StatusCategory.Key == "done" {
+		issue.Resolved = true
+	}
+
+	return issue, nil
+}
+
+// Job: syncs the status and comments of unresolved linked issues
+func runCslJiraSyncJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for jira sync job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		jira := getCslJira(ctx, org.Id)
+		if !jira.Config.Enabled {
+			continue
+		}
+
+		var syncErr error
+		for _, issue := range getCslJiraIssues(ctx, org.Id).Issues {
+			if issue.Resolved {
+				continue
+			}
+
+			synced, err := syncCslJiraIssue(ctx, jira.Config, issue)
+			if err != nil {
+				log.Printf("[WARNING] Failed syncing jira issue %s for org %s: %s", issue.Key, org.Id, err)
+				syncErr = err
+				continue
+			}
+
+			err = storeCslJiraIssue(ctx, org.Id, synced)
+			if err != nil {
+				log.Printf("[ERROR] Failed storing jira issue %s for org %s: %s", issue.Key, org.Id, err)
+			}
+		}
+
+		err = updateCslJiraState(ctx, org.Id, func(state *CslJiraState) {
+			state.LastSync = time.Now().Unix()
+			state.LastError = ""
+			if syncErr != nil {
+				state.LastError = syncErr.Error()
+			}
+		})
+		if err != nil {
+			log.Printf("[WARNING] Failed updating jira state for org %s: %s", org.Id, err)
+		}
+	}
+}
+
+/*
+Ticketing:
+Returns the Jira configuration and state for the current organization.
+Requires org admin. The api token is redacted.
+
+	{
+	    "success": true,
+	    "data": {
+	        "config": {
+	            "enabled": true,
+	            "base_url": "https://example.atlassian.net",
+	            "email": "soc@example.com",
+	            "api_token": "********",
+	            "mappings": [
+	                {
+	                    "sources": ["worker_outage"],
+	                    "project_key": "OPS",
+	                    "issue_type": "Incident",
+	                    "summary": "[Shuffle] {{title}}",
+	                    "description": "{{text}}\n\n{{fields}}",
+	                    "labels": ["soar"],
+	                    "priorities": {"critical": "Highest"},
+	                    "fields": {"customfield_10010": "{{running_on}}"},
+	                    "resolve_transition": "Done"
+	                },
+	                {
+	                    "sources": ["*"],
+	                    "project_key": "SOC",
+	                    "issue_type": "Task"
+	                }
+	            ]
+	        },
+	        "state": {
+	            "last_sync": 1700000000,
+	            "last_error": ""
+	        }
+	    }
+	}
+*/
+func cslGetJira(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslJira(getCslJira(ctx, user.ActiveOrg.Id)),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetJira")
+}
+
+/*
+Ticketing:
+Updates the Jira configuration. Requires org admin. Body uses the format of
+the config field returned from GET, and a redacted api token is kept as it is.
+Alerts open an issue in the project of the first mapping matching their event,
+and executions can be escalated to an issue by users. Templates can use
+{{title}}, {{text}}, {{event}}, {{severity}}, {{org_id}}, {{timestamp}},
+{{fields}} and every field of the event by name, such as {{failed_action}}.
+*/
+func cslSetJira(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	jira := getCslJira(ctx, user.ActiveOrg.Id)
+	previousConfig := jira.Config
+
+	err = json.Unmarshal(body, &jira.Config)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling jira config: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if jira.Config.ApiToken == RedactedValue {
+		jira.Config.ApiToken = previousConfig.ApiToken
+	}
+
+	if jira.Config.Mappings == nil {
+		jira.Config.Mappings = []CslJiraMapping{}
+	}
+
+	err = validateCslJiraConfig(jira.Config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	stored := CslJira{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslJiraDocument, &stored, func() error {
+		stored.Config = jira.Config
+		jira = stored
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated jira config for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "jira_updated", "Jira configuration was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslJira(jira),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetJira")
+}
+
+/*
+Ticketing:
+Checks the Jira credentials and that the project of every mapping exists.
+Requires org admin and Jira to be enabled. Returns the name of the Jira user.
+*/
+func cslTestJira(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	jira := getCslJira(ctx, user.ActiveOrg.Id)
+	if !jira.Config.Enabled {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("jira is not enabled")))
+		return
+	}
+
+	myself := struct {
+		DisplayName string `json:"displayName"`
+	}{}
+
+	err := jiraRequest(ctx, jira.Config, "GET", "myself", nil, &myself)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	for _, mapping := range jira.Config.Mappings {
+		err = jiraRequest(ctx, jira.Config, "GET", fmt.Sprintf("project/%s", url.PathEscape(mapping.ProjectKey)), nil, nil)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    myself.DisplayName,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslTestJira")
+}
+
+/*
+Ticketing:
+Returns the Jira issues linked to alerts and executions of the current
+organization, newest first. Optional ?source=<event|execution> filters the
+issues and ?source_key=<id> returns the issue of a single alert or execution.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "key": "SOC-42",
+	            "url": "https://example.atlassian.net/browse/SOC-42",
+	            "source": "execution",
+	            "source_key": "...",
+	            "summary": "Execution of Phishing triage (FAILURE)",
+	            "status": "In Progress",
+	            "resolved": false,
+	            "created": 1700000000,
+	            "updated": 1700000600,
+	            "comments": [
+	                {
+	                    "id": "10001",
+	                    "author": "Jane Analyst",
+	                    "body": "Looking into it",
+	                    "origin": "jira",
+	                    "created": 1700000600
+	                }
+	            ]
+	        }
+	    ]
+	}
+*/
+func cslListJiraIssues(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	issues := []CslJiraIssue{}
+	for _, issue := range getCslJiraIssues(ctx, user.ActiveOrg.Id).Issues {
+		if len(query.Get("source")) > 0 && issue.Source != query.Get("source") {
+			continue
+		}
+
+		if len(query.Get("source_key")) > 0 && issue.SourceKey != query.Get("source_key") {
+			continue
+		}
+
+		issues = append(issues, issue)
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Created > issues[j].Created
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data:    issues,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslListJiraIssues")
+}
+
+/*
+Ticketing:
+Escalates an execution to a Jira issue. Requires ?execution_id=<id> and a
+mapping for the "execution" source. Returns the linked issue, which is the
+existing one if the execution was already escalated and it isn't resolved.
+*/
+func cslCreateJiraIssue(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	execution, err := shuffle.GetWorkflowExecution(ctx, request.URL.Query().Get("execution_id"))
+	if err != nil || execution.ExecutionOrg != user.ActiveOrg.Id {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("execution_id is not valid")))
+		return
+	}
+
+	jira := getCslJira(ctx, user.ActiveOrg.Id)
+	if _, ok := getJiraMapping(jira.Config, TicketSourceExecution); !jira.Config.Enabled || !ok {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("jira is not enabled or has no mapping for executions")))
+		return
+	}
+
+	notification := getCslNotification(user.ActiveOrg.Id, TicketSourceExecution, getCslExecutionFailure(*execution))
+	notification.Title = fmt.Sprintf("Execution of %s (%s)", execution.Workflow.Name, execution.Status)
+	notification.Text = fmt.Sprintf("Execution %s was escalated by %s", execution.ExecutionId, user.Username)
+
+	issue, err := openCslJiraIssue(ctx, user.ActiveOrg.Id, TicketSourceExecution, execution.ExecutionId, notification)
+	if err != nil || issue == nil {
+		if err == nil {
+			err = errors.New("jira is not enabled or has no mapping for executions")
+		}
+
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) escalated execution %s to jira issue %s", user.Username, user.Id, execution.ExecutionId, issue.Key)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeCase, "jira_issue_created", fmt.Sprintf("Execution of %s was escalated to %s", execution.Workflow.Name, issue.Key), user.Username, execution.ExecutionId)
+
+	res := CslResponse{
+		Success: true,
+		Data:    issue,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslCreateJiraIssue")
+}
+
+/*
+Ticketing:
+Adds a comment to a linked Jira issue. Requires ?issue_key=<key>. The comment
+is prefixed with the name of the user.
+
+	{
+	    "body": "Contained the host, closing after review"
+	}
+*/
+func cslAddJiraComment(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	input := struct {
+		Body string `json:"body"`
+	}{}
+
+	err = json.Unmarshal(body, &input)
+	if err != nil || len(strings.TrimSpace(input.Body)) == 0 || len(input.Body) > MaxJiraCommentLength {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("body must be between 1 and %d characters", MaxJiraCommentLength))))
+		return
+	}
+
+	issueKey := request.URL.Query().Get("issue_key")
+	var issue *CslJiraIssue
+	for _, stored := range getCslJiraIssues(ctx, user.ActiveOrg.Id).Issues {
+		if stored.Key == issueKey {
+			issue = &stored
+			break
+		}
+	}
+
+	if issue == nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("issue_key isn't a linked issue")))
+		return
+	}
+
+	jira := getCslJira(ctx, user.ActiveOrg.Id)
+	if !jira.Config.Enabled {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("jira is not enabled")))
+		return
+	}
+
+	comment, err := addJiraComment(ctx, jira.Config, issue.Key, fmt.Sprintf("%s (Shuffle): %s", user.Username, input.Body))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	issue.Comments = append(issue.Comments, CslJiraComment{
+		Id:      comment.Id,
+		Author:  user.Username,
+		Body:    input.Body,
+		Origin:  "shuffle",
+		Created: time.Now().Unix(),
+	})
+	issue.Updated = time.Now().Unix()
+
+	err = storeCslJiraIssue(ctx, user.ActiveOrg.Id, *issue)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    issue,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslAddJiraComment")
+}
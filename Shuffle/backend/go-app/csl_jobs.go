@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	newscheduler "github.com/carlescere/scheduler"
+)
+
+type CslJob struct {
+	Name            string
+	IntervalMinutes int
+	Run             func(ctx context.Context)
+
+	// Runs on every replica instead of only on the leader (csl_leader.go)
+	PerReplica bool
+}
+
+// Background jobs for the CSL features. Started after the database is initialized
+var cslJobs = []CslJob{
+	{Name: "credential_expiry", IntervalMinutes: 24 * 60, Run: runCslCredentialExpiryJob},
+	{Name: "health_score", IntervalMinutes: WeekLength * 24 * 60, Run: runCslHealthScoreJob},
+	{Name: "oidc_refresh", IntervalMinutes: 5, Run: runCslOidcRefreshJob},
+	{Name: "api_usage_flush", IntervalMinutes: 1, Run: runCslApiUsageFlushJob, PerReplica: true},
+	{Name: "quota_check", IntervalMinutes: 15, Run: runCslQuotaJob},
+	{Name: "stats_snapshot", IntervalMinutes: StatsSnapshotMinutes, Run: runCslStatsSnapshotJob},
+	{Name: "s3_export", IntervalMinutes: ExportIntervalMinutes, Run: runCslS3ExportJob},
+	{Name: "digest", IntervalMinutes: DigestJobMinutes, Run: runCslDigestJob},
+	{Name: "failure_spike", IntervalMinutes: FailureSpikeMinutes, Run: runCslFailureSpikeJob},
+	{Name: "sla_check", IntervalMinutes: SlaCheckMinutes, Run: runCslSlaJob},
+	{Name: "worker_outage", IntervalMinutes: WorkerOutageCheckMinutes, Run: runCslWorkerOutageJob},
+	{Name: "jira_sync", IntervalMinutes: JiraSyncMinutes, Run: runCslJiraSyncJob},
+	{Name: "servicenow_sync", IntervalMinutes: ServiceNowSyncMinutes, Run: runCslServiceNowSyncJob},
+	{Name: "sandbox", IntervalMinutes: SandboxPollMinutes, Run: runCslSandboxJob},
+	{Name: "geo_flush", IntervalMinutes: GeoFlushMinutes, Run: runCslGeoFlushJob, PerReplica: true},
+	{Name: "session_flush", IntervalMinutes: SessionFlushMinutes, Run: runCslSessionFlushJob, PerReplica: true},
+	{Name: "ldap_sync", IntervalMinutes: LdapSyncJobMinutes, Run: runCslLdapSyncJob},
+	{Name: "runner_health_flush", IntervalMinutes: RunnerHealthFlushMinutes, Run: runCslRunnerHealthFlushJob, PerReplica: true},
+	{Name: "execution_timeout", IntervalMinutes: ExecutionTimeoutCheckMinutes, Run: runCslExecutionTimeoutJob},
+	{Name: "maintenance_release", IntervalMinutes: MaintenanceReleaseMinutes, Run: runCslMaintenanceReleaseJob},
+	{Name: "encryption_migration", IntervalMinutes: EncryptionMigrationMinutes, Run: runCslEncryptionMigrationJob},
+	{Name: "pii_scan", IntervalMinutes: PiiScanMinutes, Run: runCslPiiScanJob},
+	{Name: "org_export_cleanup", IntervalMinutes: OrgExportCleanupMinutes, Run: runCslOrgExportCleanupJob},
+	{Name: "replication", IntervalMinutes: ReplicationScanMinutes, Run: runCslReplicationJob},
+	{Name: "schedule_sync", IntervalMinutes: ScheduleSyncMinutes, Run: runCslScheduleSyncJob},
+	{Name: "lifecycle_retry", IntervalMinutes: LifecycleRetryMinutes, Run: runCslLifecycleRetryJob},
+	{Name: "approvals", IntervalMinutes: ApprovalsJobMinutes, Run: runCslApprovalsJob},
+}
+
+// Schedules all CSL jobs. Disable with SHUFFLE_CSL_JOBS_DISABLED=true. Jobs
+// only run on the leader unless they're PerReplica
+func initCslJobs(ctx context.Context) {
+	if os.Getenv("SHUFFLE_CSL_JOBS_DISABLED") == "true" {
+		log.Printf("[INFO] CSL jobs disabled with SHUFFLE_CSL_JOBS_DISABLED=true")
+		return
+	}
+
+	for _, cslJob := range cslJobs {
+		cslJob := cslJob
+		job := func() {
+			if !cslJob.PerReplica && !isCslLeader() {
+				return
+			}
+
+			log.Printf("[DEBUG] Running CSL job %s", cslJob.Name)
+			cslJob.Run(ctx)
+		}
+
+		_, err := newscheduler.Every(cslJob.IntervalMinutes).Minutes().NotImmediately().Run(job)
+		if err != nil {
+			log.Printf("[ERROR] Failed to schedule CSL job %s: %s", cslJob.Name, err)
+		} else {
+			log.Printf("[DEBUG] Scheduled CSL job %s every %d minutes", cslJob.Name, cslJob.IntervalMinutes)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Execution lifecycle events published to Kafka.
+// Enabled by setting SHUFFLE_KAFKA_BROKERS to a comma separated list of
+// host:port. Execution events go to SHUFFLE_KAFKA_EXECUTION_TOPIC and app runs
+// to SHUFFLE_KAFKA_APP_TOPIC. SHUFFLE_KAFKA_USERNAME and SHUFFLE_KAFKA_PASSWORD
+// enable SASL/PLAIN, and SHUFFLE_KAFKA_TLS=true connects with TLS.
+// Messages are keyed by execution id so events of an execution stay in order.
+// With a message bus (SHUFFLE_MESSAGE_BUS), the same events are also published
+// on shuffle.events.<event>.
+
+const DefaultKafkaExecutionTopic = "shuffle-executions"
+const DefaultKafkaAppTopic = "shuffle-app-runs"
+
+// Events
+const (
+	KafkaExecutionStarted  = "execution_started"
+	KafkaExecutionFinished = "execution_finished"
+	KafkaExecutionFailed   = "execution_failed"
+	KafkaAppRun            = "app_run"
+)
+
+var cslKafka = struct {
+	writer         *kafka.Writer
+	executionTopic string
+	appTopic       string
+}{}
+
+type CslKafkaEvent struct {
+	Event        string `json:"event"`
+	OrgId        string `json:"org_id"`
+	ExecutionId  string `json:"execution_id"`
+	WorkflowId   string `json:"workflow_id"`
+	WorkflowName string `json:"workflow_name"`
+	Status       string `json:"status"`
+	Trigger      string `json:"trigger"`
+	StartedAt    int64  `json:"started_at"`
+	CompletedAt  int64  `json:"completed_at,omitempty"`
+	Timestamp    int64  `json:"timestamp"`
+
+	// Only set for app runs
+	NodeId          string `json:"node_id,omitempty"`
+	AppName         string `json:"app_name,omitempty"`
+	AppVersion      string `json:"app_version,omitempty"`
+	ActionName      string `json:"action_name,omitempty"`
+	ActionLabel     string `json:"action_label,omitempty"`
+	FailureCategory string `json:"failure_category,omitempty"`
+}
+
+// Sets up the Kafka producer if SHUFFLE_KAFKA_BROKERS is set. Topics are
+// created by the brokers if they allow it
+func initCslKafka() {
+	brokers := []string{}
+	for _, broker := range strings.Split(os.Getenv("SHUFFLE_KAFKA_BROKERS"), ",") {
+		broker = strings.TrimSpace(broker)
+		if len(broker) > 0 {
+			brokers = append(brokers, broker)
+		}
+	}
+
+	if len(brokers) == 0 {
+		return
+	}
+
+	transport := &kafka.Transport{}
+	if len(os.Getenv("SHUFFLE_KAFKA_USERNAME")) > 0 {
+		transport.SASL = plain.Mechanism{
+			Username: os.Getenv("SHUFFLE_KAFKA_USERNAME"),
+			Password: os.Getenv("SHUFFLE_KAFKA_PASSWORD"),
+		}
+	}
+
+	if os.Getenv("SHUFFLE_KAFKA_TLS") == "true" {
+		transport.TLS = &tls.Config{}
+	}
+
+	cslKafka.executionTopic = os.Getenv("SHUFFLE_KAFKA_EXECUTION_TOPIC")
+	if len(cslKafka.executionTopic) == 0 {
+		cslKafka.executionTopic = DefaultKafkaExecutionTopic
+	}
+
+	cslKafka.appTopic = os.Getenv("SHUFFLE_KAFKA_APP_TOPIC")
+	if len(cslKafka.appTopic) == 0 {
+		cslKafka.appTopic = DefaultKafkaAppTopic
+	}
+
+	cslKafka.writer = &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Balancer:               &kafka.Hash{},
+		Transport:              transport,
+		RequiredAcks:           kafka.RequireOne,
+		BatchTimeout:           50 * time.Millisecond,
+		AllowAutoTopicCreation: true,
+		Async:                  true,
+		Completion: func(messages []kafka.Message, err error) {
+			if err != nil {
+				log.Printf("[WARNING] Failed publishing %d Kafka events: %s", len(messages), err)
+			}
+		},
+	}
+
+	log.Printf("[INFO] Publishing execution events to Kafka topics %s and %s on %s", cslKafka.executionTopic, cslKafka.appTopic, strings.Join(brokers, ","))
+}
+
+func useKafka() bool {
+	return cslKafka.writer != nil
+}
+
+func useExecutionEvents() bool {
+	return useKafka() || shuffle.UseMessageBus()
+}
+
+func getKafkaEvent(event string, execution shuffle.WorkflowExecution) CslKafkaEvent {
+	return CslKafkaEvent{
+		Event:        event,
+		OrgId:        execution.ExecutionOrg,
+		ExecutionId:  execution.ExecutionId,
+		WorkflowId:   execution.Workflow.ID,
+		WorkflowName: execution.Workflow.Name,
+		Status:       execution.Status,
+		Trigger:      getExecutionTrigger(execution),
+		StartedAt:    execution.StartedAt,
+		CompletedAt:  execution.CompletedAt,
+		Timestamp:    time.Now().Unix(),
+	}
+}
+
+// Kafka writes are async, so this only blocks if the producer buffer is full
+func publishKafkaEvent(topic string, event CslKafkaEvent) {
+	b, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[ERROR] Failed marshaling Kafka event %s for execution %s: %s", event.Event, event.ExecutionId, err)
+		return
+	}
+
+	err = shuffle.PublishMessage(shuffle.EventSubjectPrefix+event.Event, b)
+	if err != nil {
+		log.Printf("[WARNING] Failed publishing event %s for execution %s on message bus: %s", event.Event, event.ExecutionId, err)
+	}
+
+	if !useKafka() {
+		return
+	}
+
+	err = cslKafka.writer.WriteMessages(context.Background(), kafka.Message{
+		Topic: topic,
+		Key:   []byte(event.ExecutionId),
+		Value: b,
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed publishing Kafka event %s for execution %s: %s", event.Event, event.ExecutionId, err)
+	}
+}
+
+func isFinishedStatus(status string) bool {
+	return status == "FINISHED" || status == "ABORTED" || status == "FAILURE"
+}
+
+func publishExecutionStarted(execution shuffle.WorkflowExecution) {
+	if !useExecutionEvents() {
+		return
+	}
+
+	publishKafkaEvent(cslKafka.executionTopic, getKafkaEvent(KafkaExecutionStarted, execution))
+}
+
+// Publishes the finished or failed event the first time an execution is seen
+// with a finished status. Failed executions are also sent to the org's
+// notification channels, and observables are extracted from every execution
+func publishExecutionFinished(previousStatus string, execution shuffle.WorkflowExecution) {
+	if isFinishedStatus(previousStatus) || !isFinishedStatus(execution.Status) {
+		return
+	}
+
+	go onCslExecutionObservables(execution)
+
+	event := KafkaExecutionFinished
+	if execution.Status != "FINISHED" {
+		event = KafkaExecutionFailed
+		go notifyExecutionFailed(context.Background(), execution)
+	}
+
+	if !useExecutionEvents() {
+		return
+	}
+
+	publishKafkaEvent(cslKafka.executionTopic, getKafkaEvent(event, execution))
+}
+
+// Skipped and waiting nodes aren't app runs
+func publishAppRun(execution shuffle.WorkflowExecution, actionResult shuffle.ActionResult) {
+	if !useExecutionEvents() || (actionResult.Status != "SUCCESS" && actionResult.Status != "FAILURE" && actionResult.Status != "ABORTED") {
+		return
+	}
+
+	event := getKafkaEvent(KafkaAppRun, execution)
+	event.Status = actionResult.Status
+	event.StartedAt = actionResult.StartedAt
+	event.CompletedAt = actionResult.CompletedAt
+	event.NodeId = actionResult.Action.ID
+	event.AppName = actionResult.Action.AppName
+	event.AppVersion = actionResult.Action.AppVersion
+	event.ActionName = actionResult.Action.Name
+	event.ActionLabel = actionResult.Action.Label
+	if actionResult.Status != "SUCCESS" {
+		event.FailureCategory = shuffle.GetFailureCategory(actionResult)
+	}
+
+	publishKafkaEvent(cslKafka.appTopic, event)
+}
+
+// Wraps the abort handler to publish the failed event of aborted executions
+func cslKafkaOnAbort(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		// /api/v1/workflows/{key}/executions/{key}/abort
+		location := strings.Split(request.URL.Path, "/")
+		executionId := ""
+		if len(location) > 6 {
+			executionId = location[6]
+		}
+
+		previousStatus := ""
+		ctx := shuffle.GetContext(request)
+		execution, err := shuffle.GetWorkflowExecution(ctx, executionId)
+		if err == nil {
+			previousStatus = execution.Status
+		}
+
+		recorder := &cslStatusRecorder{ResponseWriter: resp, status: 200}
+		handler(recorder, request)
+
+		if request.Method != "GET" || recorder.status != 200 || err != nil {
+			return
+		}
+
+		execution, err = shuffle.GetWorkflowExecution(ctx, executionId)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting aborted execution %s for events and notifications: %s", executionId, err)
+			return
+		}
+
+		publishExecutionFinished(previousStatus, *execution)
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request counts, latency and error rates per endpoint of the backend
+// answering, kept in memory since it started. Exposed as histograms on
+// /api/v1/csl/metrics and per endpoint with percentiles on
+// /api/v1/csl/endpointStats, which also has the last minutes on their own
+// so degrading endpoints stand out from their history.
+
+// Upper bounds in seconds of the latency histogram buckets
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+const EndpointStatsRecentMinutes = 15
+
+type cslLatencyHistogram struct {
+	// One count per bucket, and the last for slower requests
+	buckets      []int64
+	count        int64
+	sumSeconds   float64
+	maxSeconds   float64
+	serverErrors int64
+	clientErrors int64
+}
+
+type cslEndpointStats struct {
+	total   cslLatencyHistogram
+	minutes [EndpointStatsRecentMinutes]cslLatencyHistogram
+	// Unix minute each entry of minutes is for
+	minuteStarts [EndpointStatsRecentMinutes]int64
+}
+
+type CslEndpointWindow struct {
+	Requests     int64   `json:"requests"`
+	ServerErrors int64   `json:"server_errors"`
+	ClientErrors int64   `json:"client_errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgMs        float64 `json:"avg_ms"`
+	P50Ms        float64 `json:"p50_ms"`
+	P95Ms        float64 `json:"p95_ms"`
+	P99Ms        float64 `json:"p99_ms"`
+	MaxMs        float64 `json:"max_ms"`
+}
+
+type CslEndpointStat struct {
+	Endpoint string            `json:"endpoint"`
+	Total    CslEndpointWindow `json:"total"`
+	Recent   CslEndpointWindow `json:"recent"`
+}
+
+type CslEndpointStats struct {
+	Since         int64             `json:"since"`
+	RecentMinutes int               `json:"recent_minutes"`
+	Endpoints     []CslEndpointStat `json:"endpoints"`
+}
+
+var cslEndpoints = struct {
+	sync.Mutex
+	since int64
+	stats map[string]*cslEndpointStats
+}{
+	since: time.Now().Unix(),
+	stats: map[string]*cslEndpointStats{},
+}
+
+type cslStatsWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (writer *cslStatsWriter) WriteHeader(status int) {
+	if writer.status == 0 {
+		writer.status = status
+	}
+
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *cslStatsWriter) Write(data []byte) (int, error) {
+	if writer.status == 0 {
+		writer.status = http.StatusOK
+	}
+
+	return writer.ResponseWriter.Write(data)
+}
+
+func (writer *cslStatsWriter) Flush() {
+	if flusher, ok := writer.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (histogram *cslLatencyHistogram) add(seconds float64, status int) {
+	if histogram.buckets == nil {
+		histogram.buckets = make([]int64, len(latencyBuckets)+1)
+	}
+
+	bucket := sort.SearchFloat64s(latencyBuckets, seconds)
+	histogram.buckets[bucket] += 1
+	histogram.count += 1
+	histogram.sumSeconds += seconds
+	histogram.maxSeconds = math.Max(histogram.maxSeconds, seconds)
+
+	if status >= 500 {
+		histogram.serverErrors += 1
+	} else if status >= 400 {
+		histogram.clientErrors += 1
+	}
+}
+
+func (histogram *cslLatencyHistogram) merge(other cslLatencyHistogram) {
+	if other.count == 0 {
+		return
+	}
+
+	if histogram.buckets == nil {
+		histogram.buckets = make([]int64, len(latencyBuckets)+1)
+	}
+
+	for index, count := range other.buckets {
+		histogram.buckets[index] += count
+	}
+
+	histogram.count += other.count
+	histogram.sumSeconds += other.sumSeconds
+	histogram.maxSeconds = math.Max(histogram.maxSeconds, other.maxSeconds)
+	histogram.serverErrors += other.serverErrors
+	histogram.clientErrors += other.clientErrors
+}
+
+// Estimates the quantile by interpolating within its bucket, the same way
+// Prometheus does with histogram_quantile
+func (histogram *cslLatencyHistogram) quantile(quantile float64) float64 {
+	if histogram.count == 0 {
+		return 0
+	}
+
+	rank := quantile * float64(histogram.count)
+	cumulative := int64(0)
+	for index, count := range histogram.buckets {
+		if float64(cumulative+count) < rank {
+			cumulative += count
+			continue
+		}
+
+		// Slower than the last bucket
+		if index == len(latencyBuckets) {
+			return histogram.maxSeconds
+		}
+
+		lower := 0.0
+		if index > 0 {
+			lower = latencyBuckets[index-1]
+		}
+
+		upper := math.Min(latencyBuckets[index], histogram.maxSeconds)
+		if count == 0 || upper <= lower {
+			return upper
+		}
+
+		return lower + (upper-lower)*(rank-float64(cumulative))/float64(count)
+	}
+
+	return histogram.maxSeconds
+}
+
+func (histogram *cslLatencyHistogram) window() CslEndpointWindow {
+	window := CslEndpointWindow{
+		Requests:     histogram.count,
+		ServerErrors: histogram.serverErrors,
+		ClientErrors: histogram.clientErrors,
+	}
+
+	if histogram.count == 0 {
+		return window
+	}
+
+	toMs := func(seconds float64) float64 {
+		return math.Round(seconds*100000) / 100
+	}
+
+	window.ErrorRate = math.Round(float64(histogram.serverErrors)/float64(histogram.count)*10000) / 10000
+	window.AvgMs = toMs(histogram.sumSeconds / float64(histogram.count))
+	window.P50Ms = toMs(histogram.quantile(0.5))
+	window.P95Ms = toMs(histogram.quantile(0.95))
+	window.P99Ms = toMs(histogram.quantile(0.99))
+	window.MaxMs = toMs(histogram.maxSeconds)
+	return window
+}
+
+func recordCslEndpointRequest(endpoint string, duration time.Duration, status int) {
+	seconds := duration.Seconds()
+	minute := time.Now().Unix() / 60
+	slot := minute % EndpointStatsRecentMinutes
+
+	cslEndpoints.Lock()
+	defer cslEndpoints.Unlock()
+
+	stats, ok := cslEndpoints.stats[endpoint]
+	if !ok {
+		stats = &cslEndpointStats{}
+		cslEndpoints.stats[endpoint] = stats
+	}
+
+	if stats.minuteStarts[slot] != minute {
+		stats.minutes[slot] = cslLatencyHistogram{}
+		stats.minuteStarts[slot] = minute
+	}
+
+	stats.total.add(seconds, status)
+	stats.minutes[slot].add(seconds, status)
+}
+
+func getCslEndpointStats() CslEndpointStats {
+	oldestMinute := time.Now().Unix()/60 - EndpointStatsRecentMinutes + 1
+
+	cslEndpoints.Lock()
+	defer cslEndpoints.Unlock()
+
+	result := CslEndpointStats{
+		Since:         cslEndpoints.since,
+		RecentMinutes: EndpointStatsRecentMinutes,
+		Endpoints:     []CslEndpointStat{},
+	}
+
+	for endpoint, stats := range cslEndpoints.stats {
+		recent := cslLatencyHistogram{}
+		for slot, minuteStart := range stats.minuteStarts {
+			if minuteStart >= oldestMinute {
+				recent.merge(stats.minutes[slot])
+			}
+		}
+
+		result.Endpoints = append(result.Endpoints, CslEndpointStat{
+			Endpoint: endpoint,
+			Total:    stats.total.window(),
+			Recent:   recent.window(),
+		})
+	}
+
+	return result
+}
+
+// Latency histograms and request counts since the backend started
+func getEndpointMetrics() []CslMetric {
+	duration := CslMetric{
+		Name: "shuffle_http_request_duration_seconds",
+		Help: "Latency of API requests to the backend answering the scrape.",
+		Type: "histogram",
+	}
+
+	requests := CslMetric{
+		Name: "shuffle_http_requests_total",
+		Help: "API requests to the backend answering the scrape, by status class.",
+		Type: "counter",
+	}
+
+	cslEndpoints.Lock()
+	endpoints := []string{}
+	for endpoint := range cslEndpoints.stats {
+		endpoints = append(endpoints, endpoint)
+	}
+
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		histogram := cslEndpoints.stats[endpoint].total
+		method, route := endpoint, ""
+		if parts := strings.SplitN(endpoint, " ", 2); len(parts) == 2 {
+			method, route = parts[0], parts[1]
+		}
+
+		cumulative := int64(0)
+		for index, upper := range latencyBuckets {
+			cumulative += histogram.buckets[index]
+			duration.Samples = append(duration.Samples, CslMetricSample{
+				Labels: map[string]string{"method": method, "route": route, "le": strconv.FormatFloat(upper, 'g', -1, 64)},
+				Value:  float64(cumulative),
+				Suffix: "_bucket",
+			})
+		}
+
+		labels := map[string]string{"method": method, "route": route}
+		duration.Samples = append(duration.Samples,
+			CslMetricSample{Labels: map[string]string{"method": method, "route": route, "le": "+Inf"}, Value: float64(histogram.count), Suffix: "_bucket"},
+			CslMetricSample{Labels: labels, Value: histogram.sumSeconds, Suffix: "_sum"},
+			CslMetricSample{Labels: labels, Value: float64(histogram.count), Suffix: "_count"},
+		)
+
+		ok := histogram.count - histogram.serverErrors - histogram.clientErrors
+		for _, class := range []struct {
+			name  string
+			count int64
+		}{{"2xx", ok}, {"4xx", histogram.clientErrors}, {"5xx", histogram.serverErrors}} {
+			requests.Samples = append(requests.Samples, CslMetricSample{
+				Labels: map[string]string{"method": method, "route": route, "status": class.name},
+				Value:  float64(class.count),
+			})
+		}
+	}
+	cslEndpoints.Unlock()
+
+	return []CslMetric{duration, requests}
+}
+
+// Middleware recording the latency and status of every API request
+func cslEndpointStatsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, request *http.Request) {
+		if request.Method == "OPTIONS" {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		writer := &cslStatsWriter{ResponseWriter: resp}
+		started := time.Now()
+		completed := false
+
+		defer func() {
+			status := writer.status
+			if !completed {
+				// Panicked, and answered by cslRecoveryMiddleware
+				status = http.StatusInternalServerError
+			} else if status == 0 {
+				status = http.StatusOK
+			}
+
+			recordCslEndpointRequest(getUsageEndpoint(request), time.Since(started), status)
+		}()
+
+		next.ServeHTTP(writer, request)
+		completed = true
+	})
+}
+
+/*
+Endpoint statistics:
+Returns request counts, error rates and latency per endpoint of the backend
+handling the request, since it started and for the last 15 minutes. Sorted
+by recent p95 latency, slowest first, or with sort=error_rate or
+sort=requests. Percentiles are estimated from histogram buckets. Requires
+support access.
+
+	{
+	    "success": true,
+	    "data": {
+	        "since": 1718000000,
+	        "recent_minutes": 15,
+	        "endpoints": [
+	            {
+	                "endpoint": "GET /api/v1/csl/reports",
+	                "total": {"requests": 1200, "server_errors": 3, "client_errors": 10, "error_rate": 0.0025, "avg_ms": 180.5, "p50_ms": 120, "p95_ms": 480, "p99_ms": 950, "max_ms": 2300},
+	                "recent": {"requests": 90, "server_errors": 2, "client_errors": 0, "error_rate": 0.0222, "avg_ms": 640.2, "p50_ms": 410, "p95_ms": 2100, "p99_ms": 2450, "max_ms": 2300}
+	            }
+	        ]
+	    }
+	}
+*/
+func cslGetEndpointStats(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !user.SupportAccess {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("endpoint statistics require support access")))
+		return
+	}
+
+	sortBy := request.URL.Query().Get("sort")
+	if sortBy != "" && sortBy != "p95" && sortBy != "error_rate" && sortBy != "requests" {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("unknown sort %s. Use p95, error_rate or requests", sortBy))))
+		return
+	}
+
+	stats := getCslEndpointStats()
+	sort.Slice(stats.Endpoints, func(i, j int) bool {
+		first, second := stats.Endpoints[i], stats.Endpoints[j]
+		switch sortBy {
+		case "", "p95":
+			if first.Recent.P95Ms != second.Recent.P95Ms {
+				return first.Recent.P95Ms > second.Recent.P95Ms
+			}
+		case "error_rate":
+			if first.Recent.ErrorRate != second.Recent.ErrorRate {
+				return first.Recent.ErrorRate > second.Recent.ErrorRate
+			}
+		case "requests":
+			if first.Total.Requests != second.Total.Requests {
+				return first.Total.Requests > second.Total.Requests
+			}
+		}
+
+		return first.Endpoint < second.Endpoint
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data:    stats,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetEndpointStats")
+}
@@ -0,0 +1,707 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// LDAP and Active Directory sync for orgs without a SCIM capable identity
+// provider. Users matching the filter are imported into the org and get roles
+// from the SSO group role mapping, with their groups as group names
+
+const CslLdapDocument = "ldap"
+
+// How often the job checks which orgs are due for a sync
+const LdapSyncJobMinutes = 5
+const DefaultLdapSyncInterval = 60
+
+const DefaultLdapUserFilter = "(&(objectClass=person)(mail=*))"
+const DefaultLdapUsernameAttribute = "mail"
+const DefaultLdapGroupAttribute = "memberOf"
+
+const LdapTimeout = 30 * time.Second
+const LdapPageSize = 500
+const MaxLdapUsers = 10000
+
+// Max amount of changes kept in a sync result
+const MaxLdapChanges = 500
+
+// Actions of sync changes
+const (
+	LdapActionCreate = "create"
+	LdapActionAdd    = "add"
+	LdapActionRole   = "role"
+	LdapActionRemove = "remove"
+)
+
+var cslLdapSyncLock sync.Mutex
+
+// Groups limits the import to users in one of the groups, by name or dn.
+// With RemoveMissing, users imported earlier who no longer match the filter
+// are removed from the org
+type CslLdapConfig struct {
+	Enabled             bool     `json:"enabled"`
+	Url                 string   `json:"url"`
+	StartTls            bool     `json:"start_tls"`
+	SkipVerify          bool     `json:"skip_verify"`
+	BindDn              string   `json:"bind_dn"`
+	BindPassword        string   `json:"bind_password"`
+	BaseDn              string   `json:"base_dn"`
+	UserFilter          string   `json:"user_filter"`
+	UsernameAttribute   string   `json:"username_attribute"`
+	GroupAttribute      string   `json:"group_attribute"`
+	Groups              []string `json:"groups"`
+	SyncIntervalMinutes int      `json:"sync_interval_minutes"`
+	RemoveMissing       bool     `json:"remove_missing"`
+}
+
+type CslLdapChange struct {
+	Action       string `json:"action"`
+	Username     string `json:"username"`
+	UserId       string `json:"user_id"`
+	Role         string `json:"role"`
+	PreviousRole string `json:"previous_role"`
+}
+
+type CslLdapSyncResult struct {
+	DryRun      bool            `json:"dry_run"`
+	Started     int64           `json:"started"`
+	Finished    int64           `json:"finished"`
+	Found       int             `json:"found"`
+	Skipped     int             `json:"skipped"`
+	Created     int             `json:"created"`
+	Added       int             `json:"added"`
+	RoleChanges int             `json:"role_changes"`
+	Removed     int             `json:"removed"`
+	Error       string          `json:"error"`
+	Changes     []CslLdapChange `json:"changes"`
+}
+
+// Users maps ids of users imported by the sync to their username
+type CslLdapState struct {
+	LastSync   int64              `json:"last_sync"`
+	LastError  string             `json:"last_error"`
+	LastResult *CslLdapSyncResult `json:"last_result"`
+	LastDryRun *CslLdapSyncResult `json:"last_dry_run"`
+	Users      map[string]string  `json:"users"`
+}
+
+type CslLdap struct {
+	Config CslLdapConfig `json:"config"`
+	State  CslLdapState  `json:"state"`
+}
+
+type CslLdapStatus struct {
+	Enabled       bool               `json:"enabled"`
+	LastSync      int64              `json:"last_sync"`
+	NextSync      int64              `json:"next_sync"`
+	LastError     string             `json:"last_error"`
+	ImportedUsers int                `json:"imported_users"`
+	LastResult    *CslLdapSyncResult `json:"last_result"`
+	LastDryRun    *CslLdapSyncResult `json:"last_dry_run"`
+}
+
+type cslLdapUser struct {
+	Username string
+	Groups   []string
+}
+
+func getCslLdap(ctx context.Context, orgId string) CslLdap {
+	ldap := CslLdap{}
+	_, err := getCslDocument(ctx, orgId, CslLdapDocument, &ldap)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting LDAP config for org %s: %s", orgId, err)
+	}
+
+	setLdapDefaults(&ldap.Config)
+	if ldap.State.Users == nil {
+		ldap.State.Users = map[string]string{}
+	}
+
+	return ldap
+}
+
+func setLdapDefaults(config *CslLdapConfig) {
+	if len(config.UserFilter) == 0 {
+		config.UserFilter = DefaultLdapUserFilter
+	}
+
+	if len(config.UsernameAttribute) == 0 {
+		config.UsernameAttribute = DefaultLdapUsernameAttribute
+	}
+
+	if len(config.GroupAttribute) == 0 {
+		config.GroupAttribute = DefaultLdapGroupAttribute
+	}
+
+	if config.SyncIntervalMinutes == 0 {
+		config.SyncIntervalMinutes = DefaultLdapSyncInterval
+	}
+
+	if config.Groups == nil {
+		config.Groups = []string{}
+	}
+}
+
+func redactCslLdapConfig(config CslLdapConfig) CslLdapConfig {
+	if len(config.BindPassword) > 0 {
+		config.BindPassword = RedactedValue
+	}
+
+	return config
+}
+
+func validateCslLdapConfig(config CslLdapConfig) error {
+	if len(config.Url) > 0 {
+		parsedUrl, err := url.Parse(config.Url)
+		if err != nil || (parsedUrl.Scheme != "ldap" && parsedUrl.Scheme != "ldaps") || len(parsedUrl.Hostname()) == 0 {
+			return errors.New("url must be ldap://host[:port] or ldaps://host[:port]")
+		}
+	}
+
+	_, err := compileLdapFilter(config.UserFilter)
+	if err != nil {
+		return errors.New(fmt.Sprintf("invalid user_filter: %s", err))
+	}
+
+	if config.SyncIntervalMinutes < LdapSyncJobMinutes {
+		return errors.New(fmt.Sprintf("sync_interval_minutes must be at least %d", LdapSyncJobMinutes))
+	}
+
+	if config.Enabled && (len(config.Url) == 0 || len(config.BaseDn) == 0) {
+		return errors.New("url and base_dn are required")
+	}
+
+	return nil
+}
+
+// Returns the common name of a dn, e.g. SOC Analysts for CN=SOC Analysts,OU=Groups,DC=example,DC=com
+func getLdapCommonName(dn string) string {
+	first := strings.SplitN(dn, ",", 2)[0]
+	if parts := strings.SplitN(first, "=", 2); len(parts) == 2 && strings.EqualFold(strings.TrimSpace(parts[0]), "cn") {
+		return strings.TrimSpace(parts[1])
+	}
+
+	return dn
+}
+
+// Searches the directory for users. Groups contains both the dn and common
+// name of every group, so either can be used in the role mapping
+func searchCslLdapUsers(config CslLdapConfig) ([]cslLdapUser, int, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.SkipVerify}
+	conn, err := dialLdap(config.Url, config.StartTls, tlsConfig, LdapTimeout)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer conn.Close()
+
+	if len(config.BindDn) > 0 {
+		err = conn.bind(config.BindDn, config.BindPassword)
+		if err != nil {
+			return nil, 0, errors.New(fmt.Sprintf("bind failed: %s", err))
+		}
+	}
+
+	entries, err := conn.search(config.BaseDn, config.UserFilter, []string{config.UsernameAttribute, config.GroupAttribute}, LdapPageSize, MaxLdapUsers)
+	if err != nil {
+		return nil, 0, errors.New(fmt.Sprintf("search failed: %s", err))
+	}
+
+	users := []cslLdapUser{}
+	skipped := 0
+	for _, entry := range entries {
+		usernames := entry.Attributes[strings.ToLower(config.UsernameAttribute)]
+		if len(usernames) == 0 || len(strings.TrimSpace(usernames[0])) == 0 {
+			log.Printf("[DEBUG] Skipping LDAP entry %s without %s", entry.Dn, config.UsernameAttribute)
+			skipped++
+			continue
+		}
+
+		user := cslLdapUser{
+			Username: strings.ToLower(strings.TrimSpace(usernames[0])),
+			Groups:   []string{},
+		}
+
+		for _, group := range entry.Attributes[strings.ToLower(config.GroupAttribute)] {
+			user.Groups = append(user.Groups, group)
+			if name := getLdapCommonName(group); name != group {
+				user.Groups = append(user.Groups, name)
+			}
+		}
+
+		if len(config.Groups) > 0 {
+			member := false
+			for _, group := range config.Groups {
+				for _, userGroup := range user.Groups {
+					if strings.EqualFold(group, userGroup) {
+						member = true
+					}
+				}
+			}
+
+			if !member {
+				skipped++
+				continue
+			}
+		}
+
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+
+	return users, skipped, nil
+}
+
+func addCslLdapChange(result *CslLdapSyncResult, change CslLdapChange) {
+	if len(result.Changes) < MaxLdapChanges {
+		result.Changes = append(result.Changes, change)
+	}
+}
+
+// Imports the users of the directory into the org. A dry run only returns the
+// changes a sync would make
+func syncCslLdap(ctx context.Context, org *shuffle.Org, ldap CslLdap, dryRun bool) CslLdapSyncResult {
+	result := CslLdapSyncResult{
+		DryRun:  dryRun,
+		Started: time.Now().Unix(),
+		Changes: []CslLdapChange{},
+	}
+
+	users, skipped, err := searchCslLdapUsers(ldap.Config)
+	result.Skipped = skipped
+	if err != nil {
+		log.Printf("[WARNING] LDAP sync for org %s failed: %s", org.Id, err)
+		result.Error = err.Error()
+		result.Finished = time.Now().Unix()
+		return result
+	}
+
+	result.Found = len(users)
+	mapping := getCslSsoRoleMapping(ctx, org.Id)
+	imported := map[string]string{}
+	for _, ldapUser := range users {
+		role := getRoleForGroups(mapping, ldapUser.Groups)
+		user, err := findSsoUser(ctx, ldapUser.Username)
+		if err != nil {
+			result.Created++
+			change := CslLdapChange{Action: LdapActionCreate, Username: ldapUser.Username, Role: mapping.DefaultRole}
+			if mapping.Enabled {
+				change.Role = role
+			}
+
+			if dryRun {
+				addCslLdapChange(&result, change)
+				continue
+			}
+
+			user, _, err = getOrCreateProvisionedUser(ctx, org, ldapUser.Username, "LDAP")
+			if err != nil {
+				log.Printf("[ERROR] Failed creating LDAP user %s in org %s: %s", ldapUser.Username, org.Id, err)
+				result.Error = err.Error()
+				continue
+			}
+
+			log.Printf("[AUDIT] Created user %s (%s) in org %s through LDAP sync", user.Username, user.Id, org.Id)
+			change.UserId = user.Id
+			addCslLdapChange(&result, change)
+			imported[user.Id] = user.Username
+
+			// New users start with the default role, so this isn't counted as a role change
+			err = applySsoRoleMapping(ctx, org.Id, user, mapping, "LDAP", ldapUser.Groups)
+			if err != nil {
+				log.Printf("[ERROR] Failed applying LDAP role mapping for %s: %s", user.Username, err)
+				result.Error = err.Error()
+			}
+
+			continue
+		} else if len(user.Orgs) > 0 && !shuffle.ArrayContains(user.Orgs, org.Id) {
+			// The directory of one org can't pull in users of other orgs
+			log.Printf("[WARNING] Skipped LDAP user %s in org %s, as the username belongs to a user of another org", ldapUser.Username, org.Id)
+			result.Skipped++
+			continue
+		} else if len(user.Orgs) == 0 {
+			// Users the sync removed from their last org are deactivated, and
+			// come back when they are in the directory again
+			result.Added++
+			addCslLdapChange(&result, CslLdapChange{Action: LdapActionAdd, Username: user.Username, UserId: user.Id, Role: mapping.DefaultRole})
+			if !dryRun {
+				err = addCslOrgMember(ctx, org, user, mapping.DefaultRole)
+				if err == nil && !user.Active {
+					user.Active = true
+					err = shuffle.SetUser(ctx, user, false)
+				}
+
+				if err != nil {
+					log.Printf("[ERROR] Failed adding LDAP user %s to org %s: %s", user.Username, org.Id, err)
+					result.Error = err.Error()
+					continue
+				}
+
+				log.Printf("[AUDIT] Added user %s (%s) to org %s through LDAP sync", user.Username, user.Id, org.Id)
+			}
+		}
+
+		imported[user.Id] = user.Username
+		previousRole, found := getCslOrgRole(org, user.Id)
+		if !found {
+			previousRole = mapping.DefaultRole
+		}
+
+		if !mapping.Enabled || role == previousRole {
+			continue
+		}
+
+		result.RoleChanges++
+		addCslLdapChange(&result, CslLdapChange{Action: LdapActionRole, Username: user.Username, UserId: user.Id, Role: role, PreviousRole: previousRole})
+		if !dryRun {
+			err = applySsoRoleMapping(ctx, org.Id, user, mapping, "LDAP", ldapUser.Groups)
+			if err != nil {
+				log.Printf("[ERROR] Failed applying LDAP role mapping for %s: %s", user.Username, err)
+				result.Error = err.Error()
+			}
+		}
+	}
+
+	// An empty result is more likely a broken filter than an empty directory
+	removed := []string{}
+	if ldap.Config.RemoveMissing && len(users) == 0 && len(ldap.State.Users) > 0 {
+		result.Error = "no users found. Skipped removing users"
+	} else if ldap.Config.RemoveMissing {
+		for userId, username := range ldap.State.Users {
+			if _, ok := imported[userId]; ok {
+				continue
+			}
+
+			user, err := shuffle.GetUser(ctx, userId)
+			if err != nil || !shuffle.ArrayContains(user.Orgs, org.Id) {
+				removed = append(removed, userId)
+				continue
+			}
+
+			result.Removed++
+			addCslLdapChange(&result, CslLdapChange{Action: LdapActionRemove, Username: username, UserId: userId, Role: user.Role})
+			if dryRun {
+				continue
+			}
+
+			err = removeCslOrgMember(ctx, org, user)
+			if err != nil {
+				log.Printf("[ERROR] Failed removing LDAP user %s from org %s: %s", username, org.Id, err)
+				result.Error = err.Error()
+				continue
+			}
+
+			removed = append(removed, userId)
+			log.Printf("[AUDIT] Removed user %s (%s) from org %s through LDAP sync", username, userId, org.Id)
+			recordCslActivity(ctx, org.Id, ActivityTypeAdmin, "user_deprovisioned", fmt.Sprintf("%s was removed from the org by LDAP sync", username), "LDAP", userId)
+		}
+	}
+
+	result.Finished = time.Now().Unix()
+	stored := CslLdap{}
+	err = updateCslDocument(ctx, org.Id, CslLdapDocument, &stored, func() error {
+		if dryRun {
+			stored.State.LastDryRun = &result
+			return nil
+		}
+
+		if stored.State.Users == nil {
+			stored.State.Users = map[string]string{}
+		}
+
+		for userId, username := range imported {
+			stored.State.Users[userId] = username
+		}
+
+		for _, userId := range removed {
+			delete(stored.State.Users, userId)
+		}
+
+		stored.State.LastSync = result.Finished
+		stored.State.LastError = result.Error
+		stored.State.LastResult = &result
+		return nil
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed storing LDAP sync result for org %s: %s", org.Id, err)
+	}
+
+	if !dryRun && result.Created+result.Added+result.RoleChanges+result.Removed > 0 {
+		recordCslActivity(ctx, org.Id, ActivityTypeAdmin, "ldap_sync", fmt.Sprintf("LDAP sync created %d, added %d, updated %d and removed %d users", result.Created, result.Added, result.RoleChanges, result.Removed), "LDAP", "")
+	}
+
+	return result
+}
+
+// Job: syncs orgs whose sync interval has passed
+func runCslLdapSyncJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for LDAP sync job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		org := org
+		ldap := getCslLdap(ctx, org.Id)
+		if !ldap.Config.Enabled || time.Now().Unix() < ldap.State.LastSync+int64(ldap.Config.SyncIntervalMinutes*60) {
+			continue
+		}
+
+		cslLdapSyncLock.Lock()
+		result := syncCslLdap(ctx, &org, ldap, false)
+		cslLdapSyncLock.Unlock()
+
+		log.Printf("[INFO] LDAP sync for org %s found %d users. Created %d, added %d, updated %d and removed %d", org.Id, result.Found, result.Created, result.Added, result.RoleChanges, result.Removed)
+	}
+}
+
+/*
+SSO:
+Returns the LDAP sync configuration for the current organization. Requires
+org admin. The bind password is redacted.
+
+	{
+	    "success": true,
+	    "data": {
+	        "enabled": true,
+	        "url": "ldaps://dc01.example.com",
+	        "start_tls": false,
+	        "skip_verify": false,
+	        "bind_dn": "CN=shuffle-sync,OU=Service,DC=example,DC=com",
+	        "bind_password": "********",
+	        "base_dn": "OU=Users,DC=example,DC=com",
+	        "user_filter": "(&(objectCategory=person)(objectClass=user)(!(userAccountControl:1.2.840.113556.1.4.803:=2)))",
+	        "username_attribute": "userPrincipalName",
+	        "group_attribute": "memberOf",
+	        "groups": ["SOC Analysts", "SOC Admins"],
+	        "sync_interval_minutes": 60,
+	        "remove_missing": true
+	    }
+	}
+*/
+func cslGetLdapConfig(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslLdapConfig(getCslLdap(ctx, user.ActiveOrg.Id).Config),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetLdapConfig")
+}
+
+/*
+SSO:
+Updates the LDAP sync configuration. Requires org admin. Body uses the format
+returned from GET, and a redacted bind password is kept as it is. Roles come
+from the SSO role mapping, with the dn and common name of every group as
+group names. Use /api/v1/csl/ldap/sync?dry_run=true to check the changes
+before enabling.
+*/
+func cslSetLdapConfig(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	ldap := getCslLdap(ctx, user.ActiveOrg.Id)
+	previousConfig := ldap.Config
+
+	config := CslLdapConfig{}
+	err = json.Unmarshal(body, &config)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling LDAP config: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if config.BindPassword == RedactedValue {
+		config.BindPassword = previousConfig.BindPassword
+	}
+
+	setLdapDefaults(&config)
+	err = validateCslLdapConfig(config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	stored := CslLdap{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslLdapDocument, &stored, func() error {
+		stored.Config = config
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated LDAP config for org %s. Enabled: %t", user.Username, user.Id, user.ActiveOrg.Id, config.Enabled)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "ldap_updated", "LDAP sync configuration was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslLdapConfig(config),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetLdapConfig")
+}
+
+/*
+SSO:
+Runs the LDAP sync now. Requires org admin. With ?dry_run=true nothing is
+changed, and the result lists what a sync would do. A dry run works while
+the sync is disabled, so the configuration can be checked first. Usernames
+belonging to users of other orgs are skipped.
+
+	{
+	    "success": true,
+	    "data": {
+	        "dry_run": true,
+	        "started": 1700000000,
+	        "finished": 1700000002,
+	        "found": 120,
+	        "skipped": 3,
+	        "created": 2,
+	        "added": 0,
+	        "role_changes": 1,
+	        "removed": 1,
+	        "error": "",
+	        "changes": [
+	            {"action": "create", "username": "new.analyst@example.com", "user_id": "", "role": "user", "previous_role": ""},
+	            {"action": "role", "username": "lead@example.com", "user_id": "...", "role": "admin", "previous_role": "user"},
+	            {"action": "remove", "username": "former@example.com", "user_id": "...", "role": "user", "previous_role": ""}
+	        ]
+	    }
+	}
+*/
+func cslRunLdapSync(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	dryRun := request.URL.Query().Get("dry_run") == "true"
+
+	ldap := getCslLdap(ctx, user.ActiveOrg.Id)
+	if len(ldap.Config.Url) == 0 || len(ldap.Config.BaseDn) == 0 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("LDAP is not configured")))
+		return
+	}
+
+	if !ldap.Config.Enabled && !dryRun {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("LDAP sync is not enabled. Use ?dry_run=true to test the configuration")))
+		return
+	}
+
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if !cslLdapSyncLock.TryLock() {
+		resp.WriteHeader(409)
+		resp.Write(createCslErrorResponse(errors.New("an LDAP sync is already running")))
+		return
+	}
+
+	result := syncCslLdap(ctx, org, ldap, dryRun)
+	cslLdapSyncLock.Unlock()
+
+	log.Printf("[AUDIT] User %s (%s) ran LDAP sync for org %s. Dry run: %t", user.Username, user.Id, user.ActiveOrg.Id, dryRun)
+
+	res := CslResponse{
+		Success: len(result.Error) == 0,
+		Data:    result,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslRunLdapSync")
+}
+
+/*
+SSO:
+Returns the status of the LDAP sync for the current organization with the
+result of the last sync and dry run. Requires org admin.
+
+	{
+	    "success": true,
+	    "data": {
+	        "enabled": true,
+	        "last_sync": 1700000000,
+	        "next_sync": 1700003600,
+	        "last_error": "",
+	        "imported_users": 118,
+	        "last_result": {...},
+	        "last_dry_run": {...}
+	    }
+	}
+*/
+func cslGetLdapStatus(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	ldap := getCslLdap(ctx, user.ActiveOrg.Id)
+	status := CslLdapStatus{
+		Enabled:       ldap.Config.Enabled,
+		LastSync:      ldap.State.LastSync,
+		LastError:     ldap.State.LastError,
+		ImportedUsers: len(ldap.State.Users),
+		LastResult:    ldap.State.LastResult,
+		LastDryRun:    ldap.State.LastDryRun,
+	}
+
+	if ldap.Config.Enabled {
+		status.NextSync = max(ldap.State.LastSync+int64(ldap.Config.SyncIntervalMinutes*60), time.Now().Unix())
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    status,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetLdapStatus")
+}
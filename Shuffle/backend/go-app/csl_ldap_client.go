@@ -0,0 +1,671 @@
+package main
+
+// Minimal LDAPv3 client for directory sync, following RFC 4511. Supports
+// simple bind, StartTLS and paged subtree searches
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// BER classes
+const (
+	berUniversal   = 0x00
+	berApplication = 0x40
+	berContext     = 0x80
+)
+
+// BER universal tags
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x10
+)
+
+// LDAP protocol operations, as application tags
+const (
+	ldapBindRequest       = 0
+	ldapBindResponse      = 1
+	ldapUnbindRequest     = 2
+	ldapSearchRequest     = 3
+	ldapSearchResultEntry = 4
+	ldapSearchResultDone  = 5
+	ldapExtendedRequest   = 23
+	ldapExtendedResponse  = 24
+)
+
+const ldapStartTlsOid = "1.3.6.1.4.1.1466.20037"
+const ldapPagedResultsOid = "1.2.840.113556.1.4.319"
+
+// Max size of a single LDAP message, as a guard against broken servers
+const ldapMaxMessageSize = 16 * 1024 * 1024
+
+// Nesting allowed in filters and messages
+const ldapMaxDepth = 32
+
+type berPacket struct {
+	class       byte
+	constructed bool
+	tag         byte
+	value       []byte
+	children    []*berPacket
+}
+
+type ldapEntry struct {
+	Dn         string
+	Attributes map[string][]string
+}
+
+type ldapConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	timeout   time.Duration
+	messageId int64
+}
+
+func berEncode(class byte, constructed bool, tag byte, content []byte) []byte {
+	identifier := class | tag
+	if constructed {
+		identifier |= 0x20
+	}
+
+	data := []byte{identifier}
+	if len(content) < 0x80 {
+		data = append(data, byte(len(content)))
+	} else {
+		length := []byte{}
+		for n := len(content); n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+
+		data = append(data, 0x80|byte(len(length)))
+		data = append(data, length...)
+	}
+
+	return append(data, content...)
+}
+
+func berInt(tag byte, value int64) []byte {
+	content := []byte{}
+	for {
+		content = append([]byte{byte(value)}, content...)
+		value >>= 8
+		if (value == 0 && content[0]&0x80 == 0) || (value == -1 && content[0]&0x80 != 0) {
+			break
+		}
+	}
+
+	return berEncode(berUniversal, false, tag, content)
+}
+
+func berString(value string) []byte {
+	return berEncode(berUniversal, false, berOctetString, []byte(value))
+}
+
+func berBool(value bool) []byte {
+	if value {
+		return berEncode(berUniversal, false, berBoolean, []byte{0xff})
+	}
+
+	return berEncode(berUniversal, false, berBoolean, []byte{0x00})
+}
+
+func berConcat(children ...[]byte) []byte {
+	content := []byte{}
+	for _, child := range children {
+		content = append(content, child...)
+	}
+
+	return content
+}
+
+func berSeq(children ...[]byte) []byte {
+	return berEncode(berUniversal, true, berSequence, berConcat(children...))
+}
+
+func readBerHeader(reader io.ByteReader) (byte, int, error) {
+	identifier, err := reader.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if identifier&0x1f == 0x1f {
+		return 0, 0, errors.New("multi-byte BER tags are not supported")
+	}
+
+	first, err := reader.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if first < 0x80 {
+		return identifier, int(first), nil
+	}
+
+	// Indefinite lengths aren't allowed in LDAP
+	octets := int(first & 0x7f)
+	if octets == 0 || octets > 4 {
+		return 0, 0, errors.New("invalid BER length")
+	}
+
+	length := 0
+	for i := 0; i < octets; i++ {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+
+		length = length<<8 | int(b)
+	}
+
+	return identifier, length, nil
+}
+
+func parseBer(data []byte, depth int) ([]*berPacket, error) {
+	if depth > ldapMaxDepth {
+		return nil, errors.New("BER nesting too deep")
+	}
+
+	packets := []*berPacket{}
+	reader := bytes.NewReader(data)
+	for reader.Len() > 0 {
+		identifier, length, err := readBerHeader(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		if length > reader.Len() {
+			return nil, errors.New("BER length out of bounds")
+		}
+
+		offset := len(data) - reader.Len()
+		packet := &berPacket{
+			class:       identifier & 0xc0,
+			constructed: identifier&0x20 != 0,
+			tag:         identifier & 0x1f,
+			value:       data[offset : offset+length],
+		}
+
+		if packet.constructed {
+			packet.children, err = parseBer(packet.value, depth+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		packets = append(packets, packet)
+		reader.Seek(int64(length), io.SeekCurrent)
+	}
+
+	return packets, nil
+}
+
+func (packet *berPacket) int() int64 {
+	if len(packet.value) == 0 {
+		return 0
+	}
+
+	value := int64(int8(packet.value[0]))
+	for _, b := range packet.value[1:] {
+		value = value<<8 | int64(b)
+	}
+
+	return value
+}
+
+func (packet *berPacket) child(index int) *berPacket {
+	if index >= len(packet.children) {
+		return &berPacket{}
+	}
+
+	return packet.children[index]
+}
+
+// Decodes \XX escapes of filter values
+func unescapeLdapFilterValue(value string) (string, error) {
+	if !strings.Contains(value, "\\") {
+		return value, nil
+	}
+
+	unescaped := []byte{}
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			unescaped = append(unescaped, value[i])
+			continue
+		}
+
+		if i+2 >= len(value) {
+			return "", errors.New(fmt.Sprintf("invalid escape in filter value %s", value))
+		}
+
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("invalid escape in filter value %s", value))
+		}
+
+		unescaped = append(unescaped, decoded...)
+		i += 2
+	}
+
+	return string(unescaped), nil
+}
+
+// Encodes a single filter item, like cn=admin*, without the parentheses
+func compileLdapFilterItem(item string) ([]byte, error) {
+	index := strings.Index(item, "=")
+	if index <= 0 {
+		return nil, errors.New(fmt.Sprintf("invalid filter item %s", item))
+	}
+
+	attribute := item[:index]
+	rawValue := item[index+1:]
+
+	tag := byte(3)
+	switch attribute[len(attribute)-1] {
+	case '~':
+		tag = 8
+	case '>':
+		tag = 5
+	case '<':
+		tag = 6
+	case ':':
+		tag = 9
+	}
+
+	if tag != 3 {
+		attribute = attribute[:len(attribute)-1]
+	}
+
+	if tag == 3 && rawValue == "*" {
+		return berEncode(berContext, false, 7, []byte(attribute)), nil
+	}
+
+	if tag == 3 && strings.Contains(rawValue, "*") {
+		parts := strings.Split(rawValue, "*")
+		substrings := []byte{}
+		for i, part := range parts {
+			if len(part) == 0 {
+				continue
+			}
+
+			value, err := unescapeLdapFilterValue(part)
+			if err != nil {
+				return nil, err
+			}
+
+			substringTag := byte(1)
+			if i == 0 {
+				substringTag = 0
+			} else if i == len(parts)-1 {
+				substringTag = 2
+			}
+
+			substrings = append(substrings, berEncode(berContext, false, substringTag, []byte(value))...)
+		}
+
+		return berEncode(berContext, true, 4, berConcat(berString(attribute), berEncode(berUniversal, true, berSequence, substrings))), nil
+	}
+
+	value, err := unescapeLdapFilterValue(rawValue)
+	if err != nil {
+		return nil, err
+	}
+
+	if tag != 9 {
+		return berEncode(berContext, true, tag, berConcat(berString(attribute), berString(value))), nil
+	}
+
+	// Extensible match: attribute[:dn][:rule]:=value
+	content := []byte{}
+	parts := strings.Split(attribute, ":")
+	if len(parts[0]) > 0 {
+		content = append(content, berEncode(berContext, false, 2, []byte(parts[0]))...)
+	}
+
+	dnAttributes := false
+	for _, part := range parts[1:] {
+		if strings.EqualFold(part, "dn") {
+			dnAttributes = true
+		} else if len(part) > 0 {
+			content = append(berEncode(berContext, false, 1, []byte(part)), content...)
+		}
+	}
+
+	content = append(content, berEncode(berContext, false, 3, []byte(value))...)
+	if dnAttributes {
+		content = append(content, berEncode(berContext, false, 4, []byte{0xff})...)
+	}
+
+	return berEncode(berContext, true, 9, content), nil
+}
+
+func compileLdapFilterAt(filter string, position, depth int) ([]byte, int, error) {
+	if depth > ldapMaxDepth {
+		return nil, 0, errors.New("filter nesting too deep")
+	}
+
+	if position >= len(filter) || filter[position] != '(' {
+		return nil, 0, errors.New(fmt.Sprintf("expected ( at position %d of filter", position))
+	}
+
+	position++
+	if position >= len(filter) {
+		return nil, 0, errors.New("unexpected end of filter")
+	}
+
+	switch filter[position] {
+	case '&', '|', '!':
+		operator := filter[position]
+		position++
+
+		content := []byte{}
+		count := 0
+		for position < len(filter) && filter[position] == '(' {
+			compiled, next, err := compileLdapFilterAt(filter, position, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			content = append(content, compiled...)
+			position = next
+			count++
+		}
+
+		if position >= len(filter) || filter[position] != ')' {
+			return nil, 0, errors.New(fmt.Sprintf("expected ) at position %d of filter", position))
+		}
+
+		if operator == '!' && count != 1 {
+			return nil, 0, errors.New("! takes exactly one filter")
+		}
+
+		tag := map[byte]byte{'&': 0, '|': 1, '!': 2}[operator]
+		return berEncode(berContext, true, tag, content), position + 1, nil
+	}
+
+	end := strings.Index(filter[position:], ")")
+	if end < 0 {
+		return nil, 0, errors.New("unexpected end of filter")
+	}
+
+	compiled, err := compileLdapFilterItem(filter[position : position+end])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return compiled, position + end + 1, nil
+}
+
+// Compiles a filter string like (&(objectClass=user)(mail=*)) to BER
+func compileLdapFilter(filter string) ([]byte, error) {
+	filter = strings.TrimSpace(filter)
+	if !strings.HasPrefix(filter, "(") {
+		filter = fmt.Sprintf("(%s)", filter)
+	}
+
+	compiled, position, err := compileLdapFilterAt(filter, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if position != len(filter) {
+		return nil, errors.New(fmt.Sprintf("unexpected data at position %d of filter", position))
+	}
+
+	return compiled, nil
+}
+
+// Connects to ldap://host[:389] or ldaps://host[:636]
+func dialLdap(rawUrl string, startTls bool, tlsConfig *tls.Config, timeout time.Duration) (*ldapConn, error) {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	host := parsedUrl.Host
+	if len(parsedUrl.Port()) == 0 {
+		port := "389"
+		if parsedUrl.Scheme == "ldaps" {
+			port = "636"
+		}
+
+		host = net.JoinHostPort(parsedUrl.Hostname(), port)
+	}
+
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = parsedUrl.Hostname()
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch parsedUrl.Scheme {
+	case "ldap":
+		conn, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported scheme %s. Use ldap:// or ldaps://", parsedUrl.Scheme))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	l := &ldapConn{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}
+	if startTls && parsedUrl.Scheme == "ldap" {
+		op := berEncode(berApplication, true, ldapExtendedRequest, berEncode(berContext, false, 0, []byte(ldapStartTlsOid)))
+		_, err = l.request(op, nil, ldapExtendedResponse)
+		if err != nil {
+			conn.Close()
+			return nil, errors.New(fmt.Sprintf("StartTLS failed: %s", err))
+		}
+
+		tlsConn := tls.Client(conn, tlsConfig)
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+		err = tlsConn.Handshake()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		l.conn = tlsConn
+		l.reader = bufio.NewReader(tlsConn)
+	}
+
+	return l, nil
+}
+
+func (l *ldapConn) Close() {
+	l.messageId++
+	l.conn.SetDeadline(time.Now().Add(l.timeout))
+	l.conn.Write(berSeq(berInt(berInteger, l.messageId), berEncode(berApplication, false, ldapUnbindRequest, nil)))
+	l.conn.Close()
+}
+
+func (l *ldapConn) readMessage() (*berPacket, error) {
+	identifier, length, err := readBerHeader(l.reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if identifier != berUniversal|0x20|berSequence || length > ldapMaxMessageSize {
+		return nil, errors.New("invalid LDAP message")
+	}
+
+	content := make([]byte, length)
+	_, err = io.ReadFull(l.reader, content)
+	if err != nil {
+		return nil, err
+	}
+
+	children, err := parseBer(content, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(children) < 2 {
+		return nil, errors.New("invalid LDAP message")
+	}
+
+	return &berPacket{constructed: true, tag: berSequence, value: content, children: children}, nil
+}
+
+func getLdapResultError(op *berPacket) error {
+	resultCode := op.child(0).int()
+	if resultCode == 0 {
+		return nil
+	}
+
+	message := string(op.child(2).value)
+	if len(message) == 0 {
+		message = "no diagnostic message"
+	}
+
+	return errors.New(fmt.Sprintf("LDAP result %d: %s", resultCode, message))
+}
+
+// Sends an operation and returns the messages until the final response.
+// Search entries come before the final response
+func (l *ldapConn) request(op []byte, controls []byte, responseTag byte) ([]*berPacket, error) {
+	l.messageId++
+	messageId := l.messageId
+
+	message := append(berInt(berInteger, messageId), op...)
+	if len(controls) > 0 {
+		message = append(message, berEncode(berContext, true, 0, controls)...)
+	}
+
+	l.conn.SetDeadline(time.Now().Add(l.timeout))
+	_, err := l.conn.Write(berEncode(berUniversal, true, berSequence, message))
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []*berPacket{}
+	for {
+		response, err := l.readMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		// Unsolicited notifications have message id 0, e.g. notice of disconnection
+		if response.child(0).int() != messageId {
+			if response.child(0).int() == 0 {
+				return nil, getLdapResultError(response.child(1))
+			}
+
+			continue
+		}
+
+		messages = append(messages, response)
+		if response.child(1).class == berApplication && response.child(1).tag == responseTag {
+			return messages, getLdapResultError(response.child(1))
+		}
+	}
+}
+
+func (l *ldapConn) bind(dn, password string) error {
+	op := berEncode(berApplication, true, ldapBindRequest, berConcat(
+		berInt(berInteger, 3),
+		berString(dn),
+		berEncode(berContext, false, 0, []byte(password)),
+	))
+
+	_, err := l.request(op, nil, ldapBindResponse)
+	return err
+}
+
+// Searches the subtree of the base dn with paged results. Returns an error
+// when more than limit entries match
+func (l *ldapConn) search(baseDn, filter string, attributes []string, pageSize, limit int) ([]ldapEntry, error) {
+	compiledFilter, err := compileLdapFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	attributeList := [][]byte{}
+	for _, attribute := range attributes {
+		attributeList = append(attributeList, berString(attribute))
+	}
+
+	entries := []ldapEntry{}
+	cookie := []byte{}
+	for {
+		// Whole subtree, never dereference aliases and no size limit
+		op := berEncode(berApplication, true, ldapSearchRequest, berConcat(
+			berString(baseDn),
+			berInt(berEnumerated, 2),
+			berInt(berEnumerated, 0),
+			berInt(berInteger, 0),
+			berInt(berInteger, int64(l.timeout.Seconds())),
+			berBool(false),
+			compiledFilter,
+			berSeq(attributeList...),
+		))
+
+		pagedValue := berSeq(berInt(berInteger, int64(pageSize)), berEncode(berUniversal, false, berOctetString, cookie))
+		control := berSeq(berString(ldapPagedResultsOid), berEncode(berUniversal, false, berOctetString, pagedValue))
+
+		messages, err := l.request(op, control, ldapSearchResultDone)
+		if err != nil {
+			return nil, err
+		}
+
+		cookie = []byte{}
+		for _, message := range messages {
+			response := message.child(1)
+			if response.class != berApplication {
+				continue
+			}
+
+			switch response.tag {
+			case ldapSearchResultEntry:
+				entry := ldapEntry{Dn: string(response.child(0).value), Attributes: map[string][]string{}}
+				for _, attribute := range response.child(1).children {
+					name := strings.ToLower(string(attribute.child(0).value))
+					for _, value := range attribute.child(1).children {
+						entry.Attributes[name] = append(entry.Attributes[name], string(value.value))
+					}
+				}
+
+				entries = append(entries, entry)
+				if len(entries) > limit {
+					return nil, errors.New(fmt.Sprintf("more than %d entries match the filter", limit))
+				}
+			case ldapSearchResultDone:
+				if len(message.children) < 3 {
+					continue
+				}
+
+				for _, control := range message.child(2).children {
+					if string(control.child(0).value) != ldapPagedResultsOid {
+						continue
+					}
+
+					value := control.child(len(control.children) - 1)
+					parsed, err := parseBer(value.value, 0)
+					if err != nil || len(parsed) == 0 {
+						continue
+					}
+
+					cookie = parsed[0].child(1).value
+				}
+			}
+		}
+
+		if len(cookie) == 0 {
+			return entries, nil
+		}
+	}
+}
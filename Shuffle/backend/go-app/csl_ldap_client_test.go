@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBerInt(t *testing.T) {
+	tests := []struct {
+		value    int64
+		expected string
+	}{
+		{value: 0, expected: "020100"},
+		{value: 127, expected: "02017f"},
+		{value: 128, expected: "02020080"},
+		{value: 256, expected: "02020100"},
+		{value: -1, expected: "0201ff"},
+		{value: -128, expected: "020180"},
+		{value: -129, expected: "0202ff7f"},
+	}
+
+	for _, test := range tests {
+		encoded := hex.EncodeToString(berInt(berInteger, test.value))
+		if encoded != test.expected {
+			t.Errorf("berInt(%d) = %s, expected %s", test.value, encoded, test.expected)
+		}
+
+		packets, err := parseBer(berInt(berInteger, test.value), 0)
+		if err != nil || len(packets) != 1 || packets[0].int() != test.value {
+			t.Errorf("parsing berInt(%d) failed: %v", test.value, err)
+		}
+	}
+}
+
+func TestBerEncodeLength(t *testing.T) {
+	tests := []struct {
+		length int
+		header string
+	}{
+		{length: 0, header: "0400"},
+		{length: 127, header: "047f"},
+		{length: 128, header: "048180"},
+		{length: 300, header: "0482012c"},
+	}
+
+	for _, test := range tests {
+		encoded := berString(strings.Repeat("a", test.length))
+		header := hex.EncodeToString(encoded[:len(encoded)-test.length])
+		if header != test.header {
+			t.Errorf("header of %d bytes = %s, expected %s", test.length, header, test.header)
+		}
+
+		packets, err := parseBer(encoded, 0)
+		if err != nil || len(packets) != 1 || len(packets[0].value) != test.length {
+			t.Errorf("parsing %d bytes failed: %v", test.length, err)
+		}
+	}
+}
+
+func TestParseBerErrors(t *testing.T) {
+	deep := []byte{0x04, 0x00}
+	for i := 0; i < ldapMaxDepth+2; i++ {
+		deep = berSeq(deep)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "length out of bounds", data: []byte{0x04, 0x05, 0x61}},
+		{name: "missing length", data: []byte{0x04}},
+		{name: "multi-byte tag", data: []byte{0x1f, 0x81, 0x00}},
+		{name: "indefinite length", data: []byte{0x30, 0x80, 0x00, 0x00}},
+		{name: "long length", data: []byte{0x04, 0x85, 0x01, 0x00, 0x00, 0x00, 0x00}},
+		{name: "broken child", data: []byte{0x30, 0x02, 0x04, 0x05}},
+		{name: "nested too deep", data: deep},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseBer(test.data, 0)
+			if err == nil {
+				t.Errorf("parseBer(%x) succeeded, expected an error", test.data)
+			}
+		})
+	}
+}
+
+func TestCompileLdapFilter(t *testing.T) {
+	tests := []struct {
+		filter   string
+		expected string
+	}{
+		{filter: "(cn=admin)", expected: "a30b0402636e040561646d696e"},
+		{filter: "cn=admin", expected: "a30b0402636e040561646d696e"},
+		{filter: "  (cn=admin)  ", expected: "a30b0402636e040561646d696e"},
+		{filter: "(mail=*)", expected: "87046d61696c"},
+		{filter: "(!(cn=a))", expected: "a209a3070402636e040161"},
+		{filter: "(&(a=1)(b=2))", expected: "a010a306040161040131a306040162040132"},
+		{filter: "(|(a=1)(b=2))", expected: "a110a306040161040131a306040162040132"},
+		{filter: "(cn=a*b*c)", expected: "a40f0402636e3009800161810162820163"},
+		{filter: "(cn=*x)", expected: "a4090402636e3003820178"},
+		{filter: "(cn=a\\2ab)", expected: "a3090402636e0403612a62"},
+		{filter: "(uid>=5)", expected: "a5080403756964040135"},
+		{filter: "(uid<=5)", expected: "a6080403756964040135"},
+		{filter: "(cn~=x)", expected: "a8070402636e040178"},
+		{filter: "(cn:dn:=x)", expected: "a90a8202636e8301788401ff"},
+	}
+
+	for _, test := range tests {
+		compiled, err := compileLdapFilter(test.filter)
+		if err != nil {
+			t.Errorf("compileLdapFilter(%s) failed: %s", test.filter, err)
+			continue
+		}
+
+		if hex.EncodeToString(compiled) != test.expected {
+			t.Errorf("compileLdapFilter(%s) = %x, expected %s", test.filter, compiled, test.expected)
+		}
+	}
+}
+
+func TestCompileLdapFilterErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"()",
+		"(cn=admin",
+		"(cn=admin))",
+		"(&(a=1)",
+		"(!(a=1)(b=2))",
+		"(!)",
+		"(=x)",
+		"(cn)",
+		"(cn=\\zz)",
+		"(cn=a\\4)",
+		strings.Repeat("(!", ldapMaxDepth+2) + "(a=1)" + strings.Repeat(")", ldapMaxDepth+2),
+	}
+
+	for _, filter := range tests {
+		_, err := compileLdapFilter(filter)
+		if err == nil {
+			t.Errorf("compileLdapFilter(%q) succeeded, expected an error", filter)
+		}
+	}
+}
+
+// Answers binds, and searches with two pages of entries. The bind password
+// "secret" is the only one accepted
+func runFakeLdapServer(t *testing.T, conn net.Conn, entries []string) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		_, length, err := readBerHeader(reader)
+		if err != nil {
+			return
+		}
+
+		content := make([]byte, length)
+		_, err = io.ReadFull(reader, content)
+		if err != nil {
+			return
+		}
+
+		message, err := parseBer(content, 0)
+		if err != nil {
+			t.Errorf("fake server got an invalid message: %s", err)
+			return
+		}
+
+		messageId := message[0].int()
+		op := message[1]
+		switch op.tag {
+		case ldapBindRequest:
+			resultCode, diagnostic := int64(0), ""
+			if string(op.child(2).value) != "secret" {
+				resultCode, diagnostic = 49, "invalid credentials"
+			}
+
+			conn.Write(berSeq(berInt(berInteger, messageId), berEncode(berApplication, true, ldapBindResponse, berConcat(
+				berInt(berEnumerated, resultCode), berString(""), berString(diagnostic),
+			))))
+		case ldapSearchRequest:
+			pagedValue, _ := parseBer(message[2].child(0).child(1).value, 0)
+			cookie := string(pagedValue[0].child(1).value)
+
+			page, nextCookie := entries[:2], "page2"
+			if cookie == "page2" {
+				page, nextCookie = entries[2:], ""
+			}
+
+			for _, dn := range page {
+				cn := strings.TrimPrefix(strings.Split(dn, ",")[0], "cn=")
+				attributes := berSeq(
+					berSeq(berString("CN"), berEncode(berUniversal, true, 0x11, berString(cn))),
+					berSeq(berString("memberOf"), berEncode(berUniversal, true, 0x11, berConcat(berString("g1"), berString("g2")))),
+				)
+
+				conn.Write(berSeq(berInt(berInteger, messageId), berEncode(berApplication, true, ldapSearchResultEntry, berConcat(berString(dn), attributes))))
+			}
+
+			control := berSeq(berString(ldapPagedResultsOid), berString(string(berSeq(berInt(berInteger, 0), berString(nextCookie)))))
+			conn.Write(berSeq(
+				berInt(berInteger, messageId),
+				berEncode(berApplication, true, ldapSearchResultDone, berConcat(berInt(berEnumerated, 0), berString(""), berString(""))),
+				berEncode(berContext, true, 0, control),
+			))
+		case ldapUnbindRequest:
+			return
+		}
+	}
+}
+
+func getFakeLdapConn(t *testing.T, entries []string) *ldapConn {
+	client, server := net.Pipe()
+	go runFakeLdapServer(t, server, entries)
+
+	return &ldapConn{
+		conn:    client,
+		reader:  bufio.NewReader(client),
+		timeout: 5 * time.Second,
+	}
+}
+
+func TestLdapBind(t *testing.T) {
+	tests := []struct {
+		password string
+		err      string
+	}{
+		{password: "secret"},
+		{password: "wrong", err: "LDAP result 49: invalid credentials"},
+	}
+
+	for _, test := range tests {
+		conn := getFakeLdapConn(t, nil)
+		err := conn.bind("cn=sync,dc=example,dc=com", test.password)
+		if (err == nil && len(test.err) > 0) || (err != nil && err.Error() != test.err) {
+			t.Errorf("bind with %s returned %v, expected %q", test.password, err, test.err)
+		}
+
+		conn.Close()
+	}
+}
+
+func TestLdapSearchPages(t *testing.T) {
+	dns := []string{"cn=a,dc=example,dc=com", "cn=b,dc=example,dc=com", "cn=c,dc=example,dc=com"}
+
+	conn := getFakeLdapConn(t, dns)
+	entries, err := conn.search("dc=example,dc=com", "(objectClass=user)", []string{"cn", "memberOf"}, 2, 10)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("search failed: %s", err)
+	}
+
+	if len(entries) != len(dns) {
+		t.Fatalf("got %d entries, expected %d", len(entries), len(dns))
+	}
+
+	for i, entry := range entries {
+		expected := ldapEntry{
+			Dn: dns[i],
+			Attributes: map[string][]string{
+				"cn":       {string(rune('a' + i))},
+				"memberof": {"g1", "g2"},
+			},
+		}
+
+		if !reflect.DeepEqual(entry, expected) {
+			t.Errorf("got entry %+v, expected %+v", entry, expected)
+		}
+	}
+
+	conn = getFakeLdapConn(t, dns)
+	_, err = conn.search("dc=example,dc=com", "(objectClass=user)", []string{"cn"}, 2, 2)
+	conn.Close()
+	if err == nil || !strings.Contains(err.Error(), "more than 2 entries") {
+		t.Errorf("search over the limit returned %v, expected an error", err)
+	}
+
+	conn = getFakeLdapConn(t, dns)
+	_, err = conn.search("dc=example,dc=com", "(objectClass=user", []string{"cn"}, 2, 10)
+	conn.Close()
+	if err == nil {
+		t.Errorf("search with an invalid filter succeeded")
+	}
+}
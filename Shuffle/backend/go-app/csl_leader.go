@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	newscheduler "github.com/carlescere/scheduler"
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Leader election between backend replicas, so background jobs and workflow
+// schedules run once when several replicas run behind a load balancer. The
+// replica holding the "backend_leader" lease (shuffle-shared lease.go) is the
+// leader, and renews it while it runs. If it stops, another replica takes
+// over once the lease expires.
+//
+// Every replica keeps its schedules loaded so it can take over, but only the
+// leader starts executions. Jobs flushing what a replica keeps in memory run
+// on every replica. Disable with SHUFFLE_LEADER_ELECTION=false to run
+// everything on every replica, as before.
+
+const CslLeaderLease = "backend_leader"
+
+// The lease is renewed three times per duration, so a slow renewal doesn't lose it
+const LeaderLeaseSeconds = 30
+const LeaderRenewSeconds = 10
+
+const ScheduleSyncMinutes = 1
+
+type CslLeaderStatus struct {
+	Enabled      bool   `json:"enabled"`
+	Holder       string `json:"holder"`
+	Leader       bool   `json:"leader"`
+	LeaderSince  int64  `json:"leader_since"`
+	CurrentOwner string `json:"current_owner"`
+	Expires      int64  `json:"expires"`
+	LastRenewal  int64  `json:"last_renewal"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+var cslLeader = struct {
+	sync.Mutex
+	holder      string
+	leader      bool
+	leaderSince int64
+	lastRenewal int64
+	lastError   string
+}{}
+
+// Guards scheduledJobs, which the schedule API and the schedule sync both change
+var scheduledJobsLock sync.Mutex
+
+func leaderElectionEnabled() bool {
+	return os.Getenv("SHUFFLE_LEADER_ELECTION") != "false"
+}
+
+func getCslLeaderHolder() string {
+	cslLeader.Lock()
+	defer cslLeader.Unlock()
+
+	if len(cslLeader.holder) == 0 {
+		hostname, _ := os.Hostname()
+		cslLeader.holder = fmt.Sprintf("%s_%s", hostname, uuid.NewV4().String()[:8])
+	}
+
+	return cslLeader.holder
+}
+
+// Returns true if this replica should run singleton work
+func isCslLeader() bool {
+	if !leaderElectionEnabled() {
+		return true
+	}
+
+	cslLeader.Lock()
+	defer cslLeader.Unlock()
+
+	// A lease that couldn't be renewed may already be someone else's
+	return cslLeader.leader && time.Now().Unix()-cslLeader.lastRenewal < LeaderLeaseSeconds
+}
+
+func renewCslLeadership(ctx context.Context) {
+	holder := getCslLeaderHolder()
+	acquired, err := shuffle.AcquireLease(ctx, CslLeaderLease, holder, LeaderLeaseSeconds*time.Second)
+
+	cslLeader.Lock()
+	wasLeader := cslLeader.leader
+	if err != nil {
+		cslLeader.lastError = err.Error()
+		cslLeader.Unlock()
+		log.Printf("[WARNING] Failed renewing the leader lease for %s: %s", holder, err)
+		return
+	}
+
+	cslLeader.lastError = ""
+	cslLeader.leader = acquired
+	if acquired {
+		cslLeader.lastRenewal = time.Now().Unix()
+		if !wasLeader {
+			cslLeader.leaderSince = cslLeader.lastRenewal
+		}
+	}
+	cslLeader.Unlock()
+
+	if acquired && !wasLeader {
+		log.Printf("[INFO] Backend %s is now the leader", holder)
+
+		// Schedules created on other replicas since this one started
+		go runCslScheduleSyncJob(ctx)
+	} else if !acquired && wasLeader {
+		log.Printf("[WARNING] Backend %s is no longer the leader", holder)
+	}
+}
+
+// Takes part in leader election until the context is done
+func initCslLeaderElection(ctx context.Context) {
+	if !leaderElectionEnabled() {
+		log.Printf("[INFO] Leader election disabled with SHUFFLE_LEADER_ELECTION=false. Jobs and schedules run on every replica")
+		return
+	}
+
+	renewCslLeadership(ctx)
+
+	go func() {
+		ticker := time.NewTicker(LeaderRenewSeconds * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Leadership is given up on shutdown (csl_shutdown.go)
+				if isCslShuttingDown() {
+					return
+				}
+
+				renewCslLeadership(ctx)
+			}
+		}
+	}()
+}
+
+// Starts a stored schedule on this replica. Executions only start on the leader
+func startCslSchedule(schedule shuffle.ScheduleOld) error {
+	job := func() {
+		if !isCslLeader() {
+			return
+		}
+
+		if holdCslScheduledExecution(context.Background(), schedule.Org, schedule.WorkflowId, schedule.Id, []byte(schedule.WrappedArgument)) {
+			return
+		}
+
+		request := &http.Request{
+			URL:    &url.URL{},
+			Method: "POST",
+			Body:   ioutil.NopCloser(strings.NewReader(schedule.WrappedArgument)),
+		}
+
+		_, _, err := handleExecution(schedule.WorkflowId, shuffle.Workflow{ExecutingOrg: shuffle.OrgMini{Id: schedule.Org}}, request, schedule.Org)
+		if err != nil {
+			log.Printf("[WARNING] Failed to execute schedule %s of workflow %s: %s", schedule.Id, schedule.WorkflowId, err)
+		}
+	}
+
+	jobret, err := newscheduler.Every(schedule.Seconds).Seconds().NotImmediately().Run(job)
+	if err != nil {
+		return err
+	}
+
+	scheduledJobsLock.Lock()
+	scheduledJobs[schedule.Id] = jobret
+	scheduledJobsLock.Unlock()
+	return nil
+}
+
+// Job: starts schedules created on other replicas and stops deleted ones, so
+// the leader runs every schedule
+func runCslScheduleSyncJob(ctx context.Context) {
+	schedules, err := shuffle.GetAllSchedules(ctx, "ALL")
+	if err != nil {
+		log.Printf("[ERROR] Failed getting schedules for schedule sync: %s", err)
+		return
+	}
+
+	stored := map[string]bool{}
+	for _, schedule := range schedules {
+		if strings.ToLower(schedule.Environment) == "cloud" || schedule.Seconds < 1 {
+			continue
+		}
+
+		stored[schedule.Id] = true
+
+		scheduledJobsLock.Lock()
+		_, exists := scheduledJobs[schedule.Id]
+		scheduledJobsLock.Unlock()
+		if exists {
+			continue
+		}
+
+		err = startCslSchedule(schedule)
+		if err != nil {
+			log.Printf("[ERROR] Failed starting schedule %s of workflow %s: %s", schedule.Id, schedule.WorkflowId, err)
+			continue
+		}
+
+		log.Printf("[INFO] Started schedule %s of workflow %s created on another replica", schedule.Id, schedule.WorkflowId)
+	}
+
+	scheduledJobsLock.Lock()
+	removed := []string{}
+	for scheduleId := range scheduledJobs {
+		if !stored[scheduleId] {
+			removed = append(removed, scheduleId)
+		}
+	}
+	scheduledJobsLock.Unlock()
+
+	for _, scheduleId := range removed {
+		// May have been created after the schedules were listed
+		schedule, err := shuffle.GetSchedule(ctx, scheduleId)
+		if err == nil && schedule.Id == scheduleId {
+			continue
+		}
+
+		scheduledJobsLock.Lock()
+		if job, exists := scheduledJobs[scheduleId]; exists {
+			job.Lock()
+			delete(scheduledJobs, scheduleId)
+			log.Printf("[INFO] Stopped schedule %s deleted on another replica", scheduleId)
+		}
+		scheduledJobsLock.Unlock()
+	}
+}
+
+/*
+Leader election:
+Returns the leader election status of the backend handling the request, and
+which replica currently holds the lease.
+
+	{
+	    "success": true,
+	    "data": {
+	        "enabled": true,
+	        "holder": "backend-1_3f2a9c1d",
+	        "leader": true,
+	        "leader_since": 1718000000,
+	        "current_owner": "backend-1_3f2a9c1d",
+	        "expires": 1718000030,
+	        "last_renewal": 1718000000
+	    }
+	}
+*/
+func cslGetLeaderStatus(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !user.SupportAccess {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("leader election status requires support access")))
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	status := CslLeaderStatus{
+		Enabled: leaderElectionEnabled(),
+		Holder:  getCslLeaderHolder(),
+		Leader:  isCslLeader(),
+	}
+
+	cslLeader.Lock()
+	status.LeaderSince = cslLeader.leaderSince
+	status.LastRenewal = cslLeader.lastRenewal
+	status.LastError = cslLeader.lastError
+	cslLeader.Unlock()
+
+	if status.Enabled {
+		lease, err := shuffle.GetLease(ctx, CslLeaderLease)
+		if err != nil {
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		status.CurrentOwner = lease.Holder
+		status.Expires = lease.Expires
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    status,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetLeaderStatus")
+}
@@ -0,0 +1,908 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Outbound webhooks for changes to the org, so external CMDB and ITSM
+// systems can stay in sync. Every webhook gets the events it subscribed to
+// as a POST, signed like the shuffle scheme of webhook triggers:
+//
+//	X-Shuffle-Event:     user_added
+//	X-Shuffle-Delivery:  <id, the same for every attempt>
+//	X-Shuffle-Timestamp: <unix seconds>
+//	X-Shuffle-Signature: sha256=<hmac of "timestamp.body" with the secret>
+//
+// Deliveries answered with a status above 299 or not answered at all are
+// retried by the lifecycle_retry job with exponential backoff. Retries can
+// arrive after later events, so receivers should order events by timestamp.
+
+const CslLifecycleWebhooksDocument = "lifecycle_webhooks"
+const CslLifecycleDeliveriesDocument = "lifecycle_deliveries"
+
+const (
+	LifecycleUserAdded         = "user_added"
+	LifecycleUserRemoved       = "user_removed"
+	LifecycleAppInstalled      = "app_installed"
+	LifecycleWorkflowCreated   = "workflow_created"
+	LifecycleWorkflowDeleted   = "workflow_deleted"
+	LifecycleCredentialChanged = "credential_changed"
+	LifecycleTest              = "test"
+)
+
+var lifecycleEvents = []string{LifecycleUserAdded, LifecycleUserRemoved, LifecycleAppInstalled, LifecycleWorkflowCreated, LifecycleWorkflowDeleted, LifecycleCredentialChanged}
+
+const (
+	LifecycleDeliveryPending   = "pending"
+	LifecycleDeliveryDelivered = "delivered"
+	LifecycleDeliveryFailed    = "failed"
+)
+
+const MaxLifecycleWebhooks = 10
+const LifecycleRetryMinutes = 1
+const LifecycleDeliveryTimeoutSeconds = 10
+
+// Attempt n is retried after LifecycleRetryBaseSeconds * 2^(n-1), so the
+// last attempt is a little over 4 hours after the event
+const MaxLifecycleDeliveryAttempts = 9
+const LifecycleRetryBaseSeconds = 60
+
+// Oldest deliveries are dropped past these
+const MaxPendingLifecycleDeliveries = 1000
+const MaxRecentLifecycleDeliveries = 100
+
+type CslLifecycleWebhook struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Url     string `json:"url"`
+	Secret  string `json:"secret"`
+	Enabled bool   `json:"enabled"`
+
+	// Every event if empty
+	Events []string `json:"events"`
+
+	CreatedBy string `json:"created_by"`
+	Created   int64  `json:"created"`
+}
+
+type CslLifecycleWebhooks struct {
+	Webhooks []CslLifecycleWebhook `json:"webhooks"`
+}
+
+type CslLifecycleEvent struct {
+	Id        string      `json:"id"`
+	Event     string      `json:"event"`
+	OrgId     string      `json:"org_id"`
+	Timestamp int64       `json:"timestamp"`
+	Actor     string      `json:"actor,omitempty"`
+	Data      interface{} `json:"data"`
+}
+
+type CslLifecycleUser struct {
+	UserId   string `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role,omitempty"`
+}
+
+type CslLifecycleApp struct {
+	AppId   string `json:"app_id"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type CslLifecycleWorkflow struct {
+	WorkflowId string `json:"workflow_id"`
+	Name       string `json:"name"`
+}
+
+// Action is created, updated or deleted. Values of the credential are never sent
+type CslLifecycleCredential struct {
+	AuthId  string `json:"auth_id"`
+	Label   string `json:"label"`
+	AppName string `json:"app_name"`
+	Action  string `json:"action"`
+}
+
+type CslLifecycleDelivery struct {
+	Id             string          `json:"id"`
+	WebhookId      string          `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	Created        int64           `json:"created"`
+	LastAttempt    int64           `json:"last_attempt"`
+	NextAttempt    int64           `json:"next_attempt,omitempty"`
+}
+
+type CslLifecycleDeliveries struct {
+	Pending []CslLifecycleDelivery `json:"pending"`
+	Recent  []CslLifecycleDelivery `json:"recent"`
+}
+
+func getCslLifecycleWebhooks(ctx context.Context, orgId string) CslLifecycleWebhooks {
+	webhooks := CslLifecycleWebhooks{}
+	_, err := getCslDocument(ctx, orgId, CslLifecycleWebhooksDocument, &webhooks)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting lifecycle webhooks for org %s: %s", orgId, err)
+	}
+
+	if webhooks.Webhooks == nil {
+		webhooks.Webhooks = []CslLifecycleWebhook{}
+	}
+
+	return webhooks
+}
+
+func getCslLifecycleDeliveries(ctx context.Context, orgId string) CslLifecycleDeliveries {
+	deliveries := CslLifecycleDeliveries{}
+	_, err := getCslDocument(ctx, orgId, CslLifecycleDeliveriesDocument, &deliveries)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting lifecycle webhook deliveries for org %s: %s", orgId, err)
+	}
+
+	setLifecycleDeliveriesDefaults(&deliveries)
+	return deliveries
+}
+
+func setLifecycleDeliveriesDefaults(deliveries *CslLifecycleDeliveries) {
+	if deliveries.Pending == nil {
+		deliveries.Pending = []CslLifecycleDelivery{}
+	}
+
+	if deliveries.Recent == nil {
+		deliveries.Recent = []CslLifecycleDelivery{}
+	}
+}
+
+func redactCslLifecycleWebhooks(webhooks CslLifecycleWebhooks) CslLifecycleWebhooks {
+	redacted := []CslLifecycleWebhook{}
+	for _, webhook := range webhooks.Webhooks {
+		if len(webhook.Secret) > 0 {
+			webhook.Secret = RedactedValue
+		}
+
+		redacted = append(redacted, webhook)
+	}
+
+	webhooks.Webhooks = redacted
+	return webhooks
+}
+
+func validateCslLifecycleWebhook(webhook CslLifecycleWebhook) error {
+	if len(webhook.Name) == 0 {
+		return errors.New("every webhook needs a name")
+	}
+
+	if !strings.HasPrefix(webhook.Url, "http://") && !strings.HasPrefix(webhook.Url, "https://") {
+		return errors.New(fmt.Sprintf("url of webhook %s must start with http:// or https://", webhook.Name))
+	}
+
+	if len(webhook.Secret) < MinWebhookSecretLength {
+		return errors.New(fmt.Sprintf("secret of webhook %s must be at least %d characters", webhook.Name, MinWebhookSecretLength))
+	}
+
+	for _, event := range webhook.Events {
+		if !shuffle.ArrayContains(lifecycleEvents, event) {
+			return errors.New(fmt.Sprintf("unknown event %s of webhook %s. Use any of %s", event, webhook.Name, strings.Join(lifecycleEvents, ", ")))
+		}
+	}
+
+	return nil
+}
+
+func (webhook CslLifecycleWebhook) subscribedTo(event string) bool {
+	return len(webhook.Events) == 0 || shuffle.ArrayContains(webhook.Events, event)
+}
+
+func getLifecycleRetryDelay(attempts int) int64 {
+	return int64(LifecycleRetryBaseSeconds) << uint(attempts-1)
+}
+
+func newCslLifecycleDelivery(webhookId string, event CslLifecycleEvent) (CslLifecycleDelivery, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return CslLifecycleDelivery{}, err
+	}
+
+	return CslLifecycleDelivery{
+		Id:        uuid.NewV4().String(),
+		WebhookId: webhookId,
+		Event:     event.Event,
+		Payload:   payload,
+		Status:    LifecycleDeliveryPending,
+		Created:   event.Timestamp,
+	}, nil
+}
+
+func postCslLifecycleDelivery(webhook CslLifecycleWebhook, delivery CslLifecycleDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), LifecycleDeliveryTimeoutSeconds*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", webhook.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signed := append([]byte(timestamp+"."), delivery.Payload...)
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Shuffle-Event", delivery.Event)
+	req.Header.Add("X-Shuffle-Delivery", delivery.Id)
+	req.Header.Add("X-Shuffle-Timestamp", timestamp)
+	req.Header.Add("X-Shuffle-Signature", fmt.Sprintf("sha256=%s", getWebhookHmac(webhook.Secret, signed)))
+
+	client := shuffle.GetExternalClient(webhook.Url)
+	newresp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	defer newresp.Body.Close()
+	ioutil.ReadAll(newresp.Body)
+
+	if newresp.StatusCode >= 300 {
+		return newresp.StatusCode, errors.New(fmt.Sprintf("webhook returned status code %d", newresp.StatusCode))
+	}
+
+	return newresp.StatusCode, nil
+}
+
+// Makes an attempt at a delivery and sets when to try again if it failed.
+// Deliveries without retries fail after the first attempt
+func attemptCslLifecycleDelivery(webhook CslLifecycleWebhook, delivery *CslLifecycleDelivery, retry bool) {
+	statusCode, err := postCslLifecycleDelivery(webhook, *delivery)
+
+	delivery.Attempts += 1
+	delivery.LastAttempt = time.Now().Unix()
+	delivery.LastStatusCode = statusCode
+	delivery.NextAttempt = 0
+	if err == nil {
+		delivery.Status = LifecycleDeliveryDelivered
+		delivery.LastError = ""
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if !retry || delivery.Attempts >= MaxLifecycleDeliveryAttempts {
+		log.Printf("[WARNING] Lifecycle webhook %s failed delivery %s of %s after %d attempts: %s", webhook.Id, delivery.Id, delivery.Event, delivery.Attempts, err)
+		delivery.Status = LifecycleDeliveryFailed
+		return
+	}
+
+	delivery.Status = LifecycleDeliveryPending
+	delivery.NextAttempt = delivery.LastAttempt + getLifecycleRetryDelay(delivery.Attempts)
+}
+
+// Stores deliveries after an attempt. Pending ones replace the previous
+// version in the queue, and the others move to the recent deliveries
+func storeCslLifecycleDeliveries(ctx context.Context, orgId string, attempted []CslLifecycleDelivery) error {
+	deliveries := CslLifecycleDeliveries{}
+	return updateCslDocument(ctx, orgId, CslLifecycleDeliveriesDocument, &deliveries, func() error {
+		setLifecycleDeliveriesDefaults(&deliveries)
+
+		updated := map[string]bool{}
+		for _, delivery := range attempted {
+			updated[delivery.Id] = true
+		}
+
+		pending := []CslLifecycleDelivery{}
+		for _, delivery := range deliveries.Pending {
+			if !updated[delivery.Id] {
+				pending = append(pending, delivery)
+			}
+		}
+
+		for _, delivery := range attempted {
+			if delivery.Status == LifecycleDeliveryPending {
+				pending = append(pending, delivery)
+			} else {
+				deliveries.Recent = append(deliveries.Recent, delivery)
+			}
+		}
+
+		if len(pending) > MaxPendingLifecycleDeliveries {
+			log.Printf("[WARNING] Dropping %d lifecycle webhook deliveries of org %s, as more than %d are pending", len(pending)-MaxPendingLifecycleDeliveries, orgId, MaxPendingLifecycleDeliveries)
+			pending = pending[len(pending)-MaxPendingLifecycleDeliveries:]
+		}
+
+		if len(deliveries.Recent) > MaxRecentLifecycleDeliveries {
+			deliveries.Recent = deliveries.Recent[len(deliveries.Recent)-MaxRecentLifecycleDeliveries:]
+		}
+
+		deliveries.Pending = pending
+		return nil
+	})
+}
+
+// Sends an event to the org's lifecycle webhooks subscribed to it. Runs in
+// the background, and failed deliveries are left for the lifecycle_retry job
+func emitCslLifecycleEvent(orgId, event, actor string, data interface{}) {
+	if len(orgId) == 0 {
+		return
+	}
+
+	go func() {
+		ctx := context.Background()
+
+		webhooks := []CslLifecycleWebhook{}
+		for _, webhook := range getCslLifecycleWebhooks(ctx, orgId).Webhooks {
+			if webhook.Enabled && webhook.subscribedTo(event) {
+				webhooks = append(webhooks, webhook)
+			}
+		}
+
+		if len(webhooks) == 0 {
+			return
+		}
+
+		lifecycleEvent := CslLifecycleEvent{
+			Id:        uuid.NewV4().String(),
+			Event:     event,
+			OrgId:     orgId,
+			Timestamp: time.Now().Unix(),
+			Actor:     actor,
+			Data:      data,
+		}
+
+		attempted := []CslLifecycleDelivery{}
+		for _, webhook := range webhooks {
+			delivery, err := newCslLifecycleDelivery(webhook.Id, lifecycleEvent)
+			if err != nil {
+				log.Printf("[ERROR] Failed creating lifecycle event %s for org %s: %s", event, orgId, err)
+				return
+			}
+
+			attemptCslLifecycleDelivery(webhook, &delivery, true)
+			attempted = append(attempted, delivery)
+		}
+
+		err := storeCslLifecycleDeliveries(ctx, orgId, attempted)
+		if err != nil {
+			log.Printf("[ERROR] Failed storing lifecycle webhook deliveries of %s for org %s: %s", event, orgId, err)
+		}
+	}()
+}
+
+// Retries the pending deliveries that are due. Deliveries of removed or
+// disabled webhooks fail
+func retryCslLifecycleDeliveries(ctx context.Context, orgId string) {
+	pending := getCslLifecycleDeliveries(ctx, orgId).Pending
+	if len(pending) == 0 {
+		return
+	}
+
+	webhooks := map[string]CslLifecycleWebhook{}
+	for _, webhook := range getCslLifecycleWebhooks(ctx, orgId).Webhooks {
+		webhooks[webhook.Id] = webhook
+	}
+
+	now := time.Now().Unix()
+	attempted := []CslLifecycleDelivery{}
+	for _, delivery := range pending {
+		if delivery.NextAttempt > now {
+			continue
+		}
+
+		webhook, ok := webhooks[delivery.WebhookId]
+		if !ok || !webhook.Enabled {
+			delivery.Status = LifecycleDeliveryFailed
+			delivery.NextAttempt = 0
+			delivery.LastError = "the webhook was removed or disabled"
+			attempted = append(attempted, delivery)
+			continue
+		}
+
+		attemptCslLifecycleDelivery(webhook, &delivery, true)
+		attempted = append(attempted, delivery)
+	}
+
+	if len(attempted) == 0 {
+		return
+	}
+
+	err := storeCslLifecycleDeliveries(ctx, orgId, attempted)
+	if err != nil {
+		log.Printf("[ERROR] Failed storing retried lifecycle webhook deliveries for org %s: %s", orgId, err)
+	}
+}
+
+func runCslLifecycleRetryJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for lifecycle webhook retries: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		retryCslLifecycleDeliveries(ctx, org.Id)
+	}
+}
+
+// Runs a shared handler with its response recorded, and returns whether it
+// succeeded. Some of them answer failures with 200 and {"success": false}
+func runRecordedHandler(handler http.HandlerFunc, resp http.ResponseWriter, request *http.Request) ([]byte, bool) {
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+
+	for name, values := range recorder.Header() {
+		resp.Header()[name] = values
+	}
+
+	resp.WriteHeader(recorder.Code)
+	resp.Write(recorder.Body.Bytes())
+
+	body := recorder.Body.Bytes()
+	if request.Method == "OPTIONS" || recorder.Code != 200 {
+		return body, false
+	}
+
+	parsed := struct {
+		Success *bool `json:"success"`
+	}{}
+
+	if json.Unmarshal(body, &parsed) == nil && parsed.Success != nil {
+		return body, *parsed.Success
+	}
+
+	return body, true
+}
+
+func getLifecycleActor(request *http.Request) (shuffle.User, error) {
+	return getMiddlewareUser(httptest.NewRecorder(), request)
+}
+
+func getLifecycleUser(user shuffle.User) CslLifecycleUser {
+	return CslLifecycleUser{
+		UserId:   user.Id,
+		Username: user.Username,
+		Role:     user.Role,
+	}
+}
+
+// Wraps the register handler, used by admins to add users to their org
+func cslLifecycleOnRegister(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		actor, actorErr := getLifecycleActor(request)
+
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		_, success := runRecordedHandler(handler, resp, request)
+		if !success || actorErr != nil {
+			return
+		}
+
+		registered := struct {
+			Username string `json:"username"`
+		}{}
+
+		json.Unmarshal(body, &registered)
+		user, err := findSsoUser(context.Background(), registered.Username)
+		if err != nil {
+			log.Printf("[WARNING] Failed finding registered user %s for lifecycle webhooks: %s", registered.Username, err)
+			return
+		}
+
+		emitCslLifecycleEvent(actor.ActiveOrg.Id, LifecycleUserAdded, actor.Username, getLifecycleUser(*user))
+	}
+}
+
+// Wraps the handlers removing a user from the org, and deleting their
+// account, which removes them from every org
+func cslLifecycleOnUserRemove(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		ctx := shuffle.GetContext(request)
+
+		userId := mux.Vars(request)["user"]
+		deleted := false
+		if len(userId) == 0 {
+			userId = mux.Vars(request)["userID"]
+			deleted = true
+		}
+
+		actor, actorErr := getLifecycleActor(request)
+		removed, userErr := shuffle.GetUser(ctx, userId)
+
+		_, success := runRecordedHandler(handler, resp, request)
+		if !success || actorErr != nil || userErr != nil {
+			return
+		}
+
+		orgIds := []string{actor.ActiveOrg.Id}
+		if deleted {
+			orgIds = removed.Orgs
+		}
+
+		for _, orgId := range orgIds {
+			emitCslLifecycleEvent(orgId, LifecycleUserRemoved, actor.Username, getLifecycleUser(*removed))
+		}
+	}
+}
+
+func cslLifecycleOnAppActivate(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		_, success := runRecordedHandler(handler, resp, request)
+		if !success {
+			return
+		}
+
+		actor, err := getLifecycleActor(request)
+		if err != nil {
+			return
+		}
+
+		appId := mux.Vars(request)["appId"]
+		data := CslLifecycleApp{AppId: appId}
+		app, err := shuffle.GetApp(context.Background(), appId, actor, false)
+		if err == nil {
+			data.Name = app.Name
+			data.Version = app.AppVersion
+		}
+
+		emitCslLifecycleEvent(actor.ActiveOrg.Id, LifecycleAppInstalled, actor.Username, data)
+	}
+}
+
+// Wraps the handlers adding, configuring and deleting app authentication
+func cslLifecycleOnAuthChange(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		ctx := shuffle.GetContext(request)
+
+		action := "updated"
+		authId := mux.Vars(request)["appauthId"]
+		if request.Method == "DELETE" {
+			action = "deleted"
+		}
+
+		if request.Method == "PUT" {
+			body, err := ioutil.ReadAll(request.Body)
+			if err != nil {
+				resp.WriteHeader(400)
+				resp.Write(createCslErrorResponse(err))
+				return
+			}
+
+			request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			existing := struct {
+				Id string `json:"id"`
+			}{}
+
+			json.Unmarshal(body, &existing)
+			_, err = shuffle.GetWorkflowAppAuthDatastore(ctx, existing.Id)
+			if len(existing.Id) == 0 || err != nil {
+				action = "created"
+			}
+		}
+
+		var auth *shuffle.AppAuthenticationStorage
+		if len(authId) > 0 {
+			auth, _ = shuffle.GetWorkflowAppAuthDatastore(ctx, authId)
+		}
+
+		body, success := runRecordedHandler(handler, resp, request)
+		if !success {
+			return
+		}
+
+		if auth == nil {
+			added := struct {
+				Id string `json:"id"`
+			}{}
+
+			json.Unmarshal(body, &added)
+
+			var err error
+			auth, err = shuffle.GetWorkflowAppAuthDatastore(context.Background(), added.Id)
+			if err != nil {
+				log.Printf("[WARNING] Failed getting app auth %s for lifecycle webhooks: %s", added.Id, err)
+				return
+			}
+		}
+
+		actor := ""
+		if user, err := getLifecycleActor(request); err == nil {
+			actor = user.Username
+		}
+
+		emitCslLifecycleEvent(auth.OrgId, LifecycleCredentialChanged, actor, CslLifecycleCredential{
+			AuthId:  auth.Id,
+			Label:   auth.Label,
+			AppName: auth.App.Name,
+			Action:  action,
+		})
+	}
+}
+
+func cslLifecycleOnWorkflowCreate(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		body, success := runRecordedHandler(handler, resp, request)
+		if !success {
+			return
+		}
+
+		workflow := shuffle.Workflow{}
+		err := json.Unmarshal(body, &workflow)
+		if err != nil || len(workflow.ID) == 0 {
+			return
+		}
+
+		actor := ""
+		if user, err := getLifecycleActor(request); err == nil {
+			actor = user.Username
+		}
+
+		emitCslLifecycleEvent(workflow.OrgId, LifecycleWorkflowCreated, actor, CslLifecycleWorkflow{
+			WorkflowId: workflow.ID,
+			Name:       workflow.Name,
+		})
+	}
+}
+
+func cslLifecycleOnWorkflowDelete(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		ctx := shuffle.GetContext(request)
+		workflow, workflowErr := shuffle.GetWorkflow(ctx, mux.Vars(request)["key"])
+
+		_, success := runRecordedHandler(handler, resp, request)
+		if !success || workflowErr != nil {
+			return
+		}
+
+		actor := ""
+		if user, err := getLifecycleActor(request); err == nil {
+			actor = user.Username
+		}
+
+		emitCslLifecycleEvent(workflow.OrgId, LifecycleWorkflowDeleted, actor, CslLifecycleWorkflow{
+			WorkflowId: workflow.ID,
+			Name:       workflow.Name,
+		})
+	}
+}
+
+/*
+Lifecycle webhooks:
+Returns the lifecycle webhooks of the current organization. Secrets are
+redacted. Requires org admin.
+
+	{"success": true, "data": {"webhooks": [{"id": "...", "name": "CMDB", "url": "https://cmdb.example.com/shuffle", "secret": "********", "enabled": true, "events": ["user_added", "user_removed"], "created_by": "admin", "created": 1700000000}]}}
+*/
+func cslGetLifecycleWebhooks(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslLifecycleWebhooks(getCslLifecycleWebhooks(ctx, user.ActiveOrg.Id)),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetLifecycleWebhooks")
+}
+
+/*
+Lifecycle webhooks:
+Sets the lifecycle webhooks in the format returned from GET. Requires org
+admin. Webhooks without an id are added, and ones left out are removed along
+with their pending deliveries. Secrets must be at least 16 characters, and
+redacted secrets are kept as they are. Events are user_added, user_removed,
+app_installed, workflow_created, workflow_deleted and credential_changed, and
+an empty list subscribes to all of them.
+*/
+func cslSetLifecycleWebhooks(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	webhooks := CslLifecycleWebhooks{}
+	err = json.Unmarshal(body, &webhooks)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if len(webhooks.Webhooks) > MaxLifecycleWebhooks {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("an org can have at most %d lifecycle webhooks", MaxLifecycleWebhooks))))
+		return
+	}
+
+	previous := map[string]CslLifecycleWebhook{}
+	for _, webhook := range getCslLifecycleWebhooks(ctx, user.ActiveOrg.Id).Webhooks {
+		previous[webhook.Id] = webhook
+	}
+
+	updated := []CslLifecycleWebhook{}
+	for _, webhook := range webhooks.Webhooks {
+		if len(webhook.Id) == 0 {
+			webhook.Id = uuid.NewV4().String()
+			webhook.CreatedBy = user.Username
+			webhook.Created = time.Now().Unix()
+		} else {
+			existing, ok := previous[webhook.Id]
+			if !ok {
+				resp.WriteHeader(400)
+				resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("webhook %s not found", webhook.Id))))
+				return
+			}
+
+			if webhook.Secret == RedactedValue {
+				webhook.Secret = existing.Secret
+			}
+
+			webhook.CreatedBy = existing.CreatedBy
+			webhook.Created = existing.Created
+		}
+
+		err = validateCslLifecycleWebhook(webhook)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		updated = append(updated, webhook)
+	}
+
+	webhooks.Webhooks = updated
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslLifecycleWebhooksDocument, webhooks)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) changed the lifecycle webhooks of org %s. Webhooks: %d", user.Username, user.Id, user.ActiveOrg.Id, len(webhooks.Webhooks))
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeIntegration, "lifecycle_webhooks_changed", "Lifecycle webhooks were changed", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslLifecycleWebhooks(webhooks),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetLifecycleWebhooks")
+}
+
+/*
+Lifecycle webhooks:
+Returns the pending and the last 100 finished deliveries of the lifecycle
+webhooks, optionally for ?webhook_id=<id> only. Requires org admin.
+
+	{"success": true, "data": {"pending": [{"id": "...", "webhook_id": "...", "event": "workflow_deleted", "payload": {...}, "status": "pending", "attempts": 2, "last_status_code": 502, "last_error": "webhook returned status code 502", "created": 1700000000, "last_attempt": 1700000060, "next_attempt": 1700000180}], "recent": [...]}}
+*/
+func cslGetLifecycleDeliveries(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	deliveries := getCslLifecycleDeliveries(ctx, user.ActiveOrg.Id)
+	webhookId := request.URL.Query().Get("webhook_id")
+	if len(webhookId) > 0 {
+		filtered := CslLifecycleDeliveries{
+			Pending: []CslLifecycleDelivery{},
+			Recent:  []CslLifecycleDelivery{},
+		}
+
+		for _, delivery := range deliveries.Pending {
+			if delivery.WebhookId == webhookId {
+				filtered.Pending = append(filtered.Pending, delivery)
+			}
+		}
+
+		for _, delivery := range deliveries.Recent {
+			if delivery.WebhookId == webhookId {
+				filtered.Recent = append(filtered.Recent, delivery)
+			}
+		}
+
+		deliveries = filtered
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    deliveries,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetLifecycleDeliveries")
+}
+
+/*
+Lifecycle webhooks:
+Sends a test event to ?webhook_id=<id> once, without retries, and returns the
+delivery. Requires org admin. The webhook doesn't have to be enabled.
+*/
+func cslTestLifecycleWebhook(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	webhookId := request.URL.Query().Get("webhook_id")
+	var webhook *CslLifecycleWebhook
+	for _, existing := range getCslLifecycleWebhooks(ctx, user.ActiveOrg.Id).Webhooks {
+		if existing.Id == webhookId {
+			webhook = &existing
+			break
+		}
+	}
+
+	if webhook == nil {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("webhook %s not found", webhookId))))
+		return
+	}
+
+	delivery, err := newCslLifecycleDelivery(webhook.Id, CslLifecycleEvent{
+		Id:        uuid.NewV4().String(),
+		Event:     LifecycleTest,
+		OrgId:     user.ActiveOrg.Id,
+		Timestamp: time.Now().Unix(),
+		Actor:     user.Username,
+		Data:      fmt.Sprintf("Test event sent by %s", user.Username),
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	attemptCslLifecycleDelivery(*webhook, &delivery, false)
+
+	err = storeCslLifecycleDeliveries(ctx, user.ActiveOrg.Id, []CslLifecycleDelivery{delivery})
+	if err != nil {
+		log.Printf("[WARNING] Failed storing test delivery of lifecycle webhook %s: %s", webhook.Id, err)
+	}
+
+	res := CslResponse{
+		Success: delivery.Status == LifecycleDeliveryDelivered,
+		Reason:  delivery.LastError,
+		Data:    delivery,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslTestLifecycleWebhook")
+}
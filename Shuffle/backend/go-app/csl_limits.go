@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// CPU, memory and time limits for app containers. Workers fetch the limits of
+// an execution when they start its apps, and report apps that exceed them as
+// failed actions in the resource_limit failure category
+
+const CslResourceLimitsDocument = "resource_limits"
+
+const MaxLimitCpuCores = 16
+const MinLimitMemoryMb = 64
+const MaxLimitMemoryMb = 65536
+const MaxLimitTimeoutSeconds = 86400
+
+// Zero values are unlimited. For app limits, zero values use the org default
+type CslResourceLimit struct {
+	CpuCores       float64 `json:"cpu_cores"`
+	MemoryMb       int     `json:"memory_mb"`
+	TimeoutSeconds int     `json:"timeout_seconds"`
+}
+
+// Apps is keyed by the lowercased app name
+type CslResourceLimits struct {
+	Default CslResourceLimit            `json:"default"`
+	Apps    map[string]CslResourceLimit `json:"apps"`
+}
+
+func getCslResourceLimits(ctx context.Context, orgId string) CslResourceLimits {
+	limits := CslResourceLimits{}
+	_, err := getCslDocument(ctx, orgId, CslResourceLimitsDocument, &limits)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting resource limits for org %s: %s", orgId, err)
+	}
+
+	if limits.Apps == nil {
+		limits.Apps = map[string]CslResourceLimit{}
+	}
+
+	return limits
+}
+
+func validateCslResourceLimit(name string, limit CslResourceLimit) error {
+	if limit.CpuCores < 0 || limit.CpuCores > MaxLimitCpuCores {
+		return errors.New(fmt.Sprintf("cpu_cores of %s must be between 0 and %d", name, MaxLimitCpuCores))
+	}
+
+	if limit.MemoryMb != 0 && (limit.MemoryMb < MinLimitMemoryMb || limit.MemoryMb > MaxLimitMemoryMb) {
+		return errors.New(fmt.Sprintf("memory_mb of %s must be 0 or between %d and %d", name, MinLimitMemoryMb, MaxLimitMemoryMb))
+	}
+
+	if limit.TimeoutSeconds < 0 || limit.TimeoutSeconds > MaxLimitTimeoutSeconds {
+		return errors.New(fmt.Sprintf("timeout_seconds of %s must be between 0 and %d", name, MaxLimitTimeoutSeconds))
+	}
+
+	return nil
+}
+
+// Fills the unset fields of an app limit from the org default
+func getEffectiveResourceLimit(limits CslResourceLimits, appName string) CslResourceLimit {
+	limit, ok := limits.Apps[strings.ToLower(appName)]
+	if !ok {
+		return limits.Default
+	}
+
+	if limit.CpuCores == 0 {
+		limit.CpuCores = limits.Default.CpuCores
+	}
+
+	if limit.MemoryMb == 0 {
+		limit.MemoryMb = limits.Default.MemoryMb
+	}
+
+	if limit.TimeoutSeconds == 0 {
+		limit.TimeoutSeconds = limits.Default.TimeoutSeconds
+	}
+
+	return limit
+}
+
+/*
+Resource limits:
+Returns the CPU, memory and time limits for app containers in the current
+organization. Apps without their own limits use the default, and zero values
+are unlimited.
+
+	{
+	    "success": true,
+	    "data": {
+	        "default": {
+	            "cpu_cores": 1,
+	            "memory_mb": 512,
+	            "timeout_seconds": 300
+	        },
+	        "apps": {
+	            "yara": {
+	                "cpu_cores": 2,
+	                "memory_mb": 2048,
+	                "timeout_seconds": 0
+	            }
+	        }
+	    }
+	}
+*/
+func cslGetResourceLimits(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslResourceLimits(ctx, user.ActiveOrg.Id),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetResourceLimits")
+}
+
+/*
+Resource limits:
+Sets the resource limits of the current organization in the format returned
+from GET. Requires org admin. cpu_cores can be fractions of a core, memory_mb
+is 0 or at least 64 and timeout_seconds at most a day. Apps are matched by
+name, and their unset fields use the default. Changes apply to apps started
+after the change.
+*/
+func cslSetResourceLimits(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	newLimits := CslResourceLimits{}
+	err = json.Unmarshal(body, &newLimits)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	err = validateCslResourceLimit("default", newLimits.Default)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	apps := map[string]CslResourceLimit{}
+	for appName, limit := range newLimits.Apps {
+		err = validateCslResourceLimit(appName, limit)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		apps[strings.ToLower(strings.TrimSpace(appName))] = limit
+	}
+
+	newLimits.Apps = apps
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslResourceLimitsDocument, newLimits)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) changed resource limits of org %s. Default: %+v, app limits: %d", user.Username, user.Id, user.ActiveOrg.Id, newLimits.Default, len(newLimits.Apps))
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "resource_limits_changed", "App resource limits were changed", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    newLimits,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetResourceLimits")
+}
+
+/*
+Resource limits:
+Returns the effective limits of every app in a workflow. Workers call it
+with ?execution_id=<id>&authorization=<execution authorization> before they
+start the apps of an execution, and users with ?workflow_id=<id>.
+
+	{
+	    "success": true,
+	    "data": {
+	        "default": {
+	            "cpu_cores": 1,
+	            "memory_mb": 512,
+	            "timeout_seconds": 300
+	        },
+	        "apps": {
+	            "shuffle tools": {
+	                "cpu_cores": 1,
+	                "memory_mb": 512,
+	                "timeout_seconds": 300
+	            }
+	        }
+	    }
+	}
+*/
+func cslEffectiveResourceLimits(resp http.ResponseWriter, request *http.Request) {
+	orgId, _, executionId, ok := handleCslExecutionRequest(resp, request)
+	if !ok {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	actions := []shuffle.Action{}
+	if len(executionId) > 0 {
+		execution, err := shuffle.GetWorkflowExecution(ctx, executionId)
+		if err != nil {
+			resp.WriteHeader(404)
+			resp.Write(createCslErrorResponse(errors.New("execution not found")))
+			return
+		}
+
+		actions = execution.Workflow.Actions
+	} else {
+		workflow, err := shuffle.GetWorkflow(ctx, request.URL.Query().Get("workflow_id"))
+		if err != nil || workflow.OrgId != orgId {
+			resp.WriteHeader(404)
+			resp.Write(createCslErrorResponse(errors.New("workflow not found")))
+			return
+		}
+
+		actions = workflow.Actions
+	}
+
+	limits := getCslResourceLimits(ctx, orgId)
+	effective := CslResourceLimits{
+		Default: limits.Default,
+		Apps:    map[string]CslResourceLimit{},
+	}
+
+	for _, action := range actions {
+		effective.Apps[strings.ToLower(action.AppName)] = getEffectiveResourceLimit(limits, action.AppName)
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    effective,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslEffectiveResourceLimits")
+}
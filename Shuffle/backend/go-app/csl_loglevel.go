@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Runtime log level of the backend. Log lines are filtered on their
+// [DEBUG], [INFO], [WARNING] and [ERROR] tags, and lines without a tag count
+// as info. [AUDIT] lines are always written. The level starts at
+// SHUFFLE_LOG_LEVEL (default debug, which writes everything as before) and
+// can be changed through the API without a restart, optionally only for a
+// number of minutes.
+//
+// Debug logging can also be turned on for some modules only. A module is the
+// source file a line is logged from, without .go and the csl_ prefix, such
+// as ldap for csl_ldap.go, walkoff, or db-connector in shuffle-shared.
+
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+var logLevels = []string{LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError}
+
+type CslLogLevel struct {
+	Level        string   `json:"level"`
+	DebugModules []string `json:"debug_modules"`
+	Expires      int64    `json:"expires"`
+	DefaultLevel string   `json:"default_level"`
+}
+
+type CslLogLevelRequest struct {
+	Level           string   `json:"level"`
+	DebugModules    []string `json:"debug_modules"`
+	DurationMinutes int      `json:"duration_minutes"`
+}
+
+var cslLogLevel = struct {
+	sync.Mutex
+	level        int
+	debugModules map[string]bool
+	expires      int64
+}{
+	debugModules: map[string]bool{},
+}
+
+// Writes the lines of the standard logger that pass the log level
+type cslLogWriter struct {
+	out io.Writer
+}
+
+func getLogLevelIndex(level string) int {
+	for index, name := range logLevels {
+		if name == level {
+			return index
+		}
+	}
+
+	return -1
+}
+
+func getDefaultLogLevel() string {
+	level := strings.ToLower(os.Getenv("SHUFFLE_LOG_LEVEL"))
+	if level == "warning" {
+		level = LogLevelWarn
+	}
+
+	if getLogLevelIndex(level) < 0 {
+		return LogLevelDebug
+	}
+
+	return level
+}
+
+// Returns the level of a line from its tag, and false for lines always written
+func getLogLineLevel(line []byte) (int, bool) {
+	// Skips the date and time the logger puts first
+	start := bytes.IndexByte(line, '[')
+	if start < 0 || start > 40 {
+		return getLogLevelIndex(LogLevelInfo), true
+	}
+
+	end := bytes.IndexByte(line[start:], ']')
+	if end < 0 {
+		return getLogLevelIndex(LogLevelInfo), true
+	}
+
+	switch string(line[start+1 : start+end]) {
+	case "DEBUG":
+		return getLogLevelIndex(LogLevelDebug), true
+	case "WARNING", "WARN":
+		return getLogLevelIndex(LogLevelWarn), true
+	case "ERROR", "ERORR":
+		return getLogLevelIndex(LogLevelError), true
+	case "AUDIT":
+		return 0, false
+	}
+
+	return getLogLevelIndex(LogLevelInfo), true
+}
+
+// Returns the module of the code calling the logger
+func getLogCallerModule() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "log.") && !strings.HasPrefix(frame.Function, "runtime.") && !strings.Contains(frame.Function, "cslLogWriter") && !strings.Contains(frame.Function, "getLogCallerModule") {
+			return strings.TrimPrefix(strings.TrimSuffix(filepath.Base(frame.File), ".go"), "csl_")
+		}
+
+		if !more {
+			return ""
+		}
+	}
+}
+
+func (writer *cslLogWriter) Write(line []byte) (int, error) {
+	lineLevel, filtered := getLogLineLevel(line)
+	if !filtered {
+		return writer.out.Write(line)
+	}
+
+	cslLogLevel.Lock()
+	if cslLogLevel.expires > 0 && time.Now().Unix() >= cslLogLevel.expires {
+		cslLogLevel.level = getLogLevelIndex(getDefaultLogLevel())
+		cslLogLevel.debugModules = map[string]bool{}
+		cslLogLevel.expires = 0
+	}
+
+	level := cslLogLevel.level
+	checkModule := lineLevel == getLogLevelIndex(LogLevelDebug) && len(cslLogLevel.debugModules) > 0
+	debugModules := cslLogLevel.debugModules
+	cslLogLevel.Unlock()
+
+	if lineLevel >= level || (checkModule && debugModules[getLogCallerModule()]) {
+		return writer.out.Write(line)
+	}
+
+	// Dropped lines are reported as written, so the logger doesn't fail
+	return len(line), nil
+}
+
+func initCslLogLevel() {
+	cslLogLevel.Lock()
+	cslLogLevel.level = getLogLevelIndex(getDefaultLogLevel())
+	cslLogLevel.Unlock()
+
+	log.SetOutput(&cslLogWriter{out: os.Stderr})
+	log.Printf("[INFO] Log level is %s", getDefaultLogLevel())
+}
+
+func getCslLogLevel() CslLogLevel {
+	cslLogLevel.Lock()
+	defer cslLogLevel.Unlock()
+
+	status := CslLogLevel{
+		Level:        logLevels[cslLogLevel.level],
+		DebugModules: []string{},
+		Expires:      cslLogLevel.expires,
+		DefaultLevel: getDefaultLogLevel(),
+	}
+
+	for module := range cslLogLevel.debugModules {
+		status.DebugModules = append(status.DebugModules, module)
+	}
+
+	sort.Strings(status.DebugModules)
+	return status
+}
+
+/*
+Log level:
+Returns the log level of the backend handling the request. Expires is when
+it goes back to the default level, or 0 if it stays until changed. Requires
+support access.
+
+	{
+	    "success": true,
+	    "data": {
+	        "level": "info",
+	        "debug_modules": ["ldap", "walkoff"],
+	        "expires": 1718003600,
+	        "default_level": "debug"
+	    }
+	}
+*/
+func cslGetLogLevel(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !user.SupportAccess {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("changing the log level requires support access")))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslLogLevel(),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetLogLevel")
+}
+
+/*
+Log level:
+Changes the log level of the backend handling the request, and which
+modules write debug lines regardless of the level. With more than one
+backend, each has to be changed. With duration_minutes, the default level
+from SHUFFLE_LOG_LEVEL is restored afterwards. Returns the same as GET.
+
+	{
+	    "level": "info",
+	    "debug_modules": ["ldap", "walkoff"],
+	    "duration_minutes": 60
+	}
+*/
+func cslSetLogLevel(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !user.SupportAccess {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("changing the log level requires support access")))
+		return
+	}
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	var levelRequest CslLogLevelRequest
+	err = json.Unmarshal(body, &levelRequest)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	levelRequest.Level = strings.ToLower(levelRequest.Level)
+	if levelRequest.Level == "warning" {
+		levelRequest.Level = LogLevelWarn
+	}
+
+	if getLogLevelIndex(levelRequest.Level) < 0 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("unknown level %s. Available levels are %s", levelRequest.Level, strings.Join(logLevels, ", ")))))
+		return
+	}
+
+	if levelRequest.DurationMinutes < 0 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("duration_minutes can't be negative")))
+		return
+	}
+
+	debugModules := map[string]bool{}
+	for _, module := range levelRequest.DebugModules {
+		module = strings.TrimPrefix(strings.TrimSuffix(strings.TrimSpace(module), ".go"), "csl_")
+		if len(module) > 0 {
+			debugModules[module] = true
+		}
+	}
+
+	cslLogLevel.Lock()
+	cslLogLevel.level = getLogLevelIndex(levelRequest.Level)
+	cslLogLevel.debugModules = debugModules
+	cslLogLevel.expires = 0
+	if levelRequest.DurationMinutes > 0 {
+		cslLogLevel.expires = time.Now().Add(time.Duration(levelRequest.DurationMinutes) * time.Minute).Unix()
+	}
+	cslLogLevel.Unlock()
+
+	status := getCslLogLevel()
+	log.Printf("[AUDIT] User %s (%s) changed the log level to %s. Debug modules: %s. Expires: %d", user.Username, user.Id, status.Level, strings.Join(status.DebugModules, ", "), status.Expires)
+
+	res := CslResponse{
+		Success: true,
+		Data:    status,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetLogLevel")
+}
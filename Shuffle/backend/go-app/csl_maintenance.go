@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Maintenance windows pause scheduled executions of an org. Windows are
+// either one-off between start and end, or recurring, starting at each fire
+// time of a cron expression in the org timezone and lasting duration_minutes.
+// Scheduled runs during a window are skipped, or queued and run once when no
+// window covers the workflow anymore.
+
+const CslMaintenanceWindowsDocument = "maintenance_windows"
+const CslMaintenanceQueueDocument = "maintenance_queue"
+
+const MaintenanceReleaseMinutes = 1
+const MaxMaintenanceWindows = 50
+const MaxMaintenanceDurationMinutes = 7 * 24 * 60
+
+const (
+	MaintenanceActionSkip  = "skip"
+	MaintenanceActionQueue = "queue"
+)
+
+var maintenanceActions = []string{MaintenanceActionSkip, MaintenanceActionQueue}
+
+// Suppressed runs are counted in the org statistics per window
+const MaintenanceSkippedStatPrefix = "maintenance_skipped_"
+const MaintenanceQueuedStatPrefix = "maintenance_queued_"
+
+// Without workflows the window covers every workflow of the org
+type CslMaintenanceWindow struct {
+	Id              string   `json:"id"`
+	Name            string   `json:"name"`
+	Start           int64    `json:"start"`
+	End             int64    `json:"end"`
+	Cron            string   `json:"cron"`
+	DurationMinutes int      `json:"duration_minutes"`
+	Workflows       []string `json:"workflows"`
+	Action          string   `json:"action"`
+}
+
+type CslMaintenanceWindows struct {
+	Windows []CslMaintenanceWindow `json:"windows"`
+}
+
+// Scheduled runs held back by a window. Each schedule is queued once
+type CslQueuedSchedule struct {
+	Key        string `json:"key"`
+	WorkflowId string `json:"workflow_id"`
+	WindowId   string `json:"window_id"`
+	Body       string `json:"body"`
+	QueuedAt   int64  `json:"queued_at"`
+	Suppressed int    `json:"suppressed"`
+}
+
+type CslMaintenanceQueue struct {
+	Schedules []CslQueuedSchedule `json:"schedules"`
+}
+
+type CslMaintenanceStatus struct {
+	Windows []CslMaintenanceWindow `json:"windows"`
+	Active  []string               `json:"active"`
+	Queued  []CslQueuedSchedule    `json:"queued"`
+}
+
+type CslMaintenanceWindowStats struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Skipped int64  `json:"skipped"`
+	Queued  int64  `json:"queued"`
+}
+
+type CslMaintenanceStats struct {
+	Days    int                         `json:"days"`
+	Skipped int64                       `json:"skipped"`
+	Queued  int64                       `json:"queued"`
+	Windows []CslMaintenanceWindowStats `json:"windows"`
+}
+
+func getCslMaintenanceWindows(ctx context.Context, orgId string) CslMaintenanceWindows {
+	windows := CslMaintenanceWindows{}
+	_, err := getCslDocument(ctx, orgId, CslMaintenanceWindowsDocument, &windows)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting maintenance windows for org %s: %s", orgId, err)
+	}
+
+	if windows.Windows == nil {
+		windows.Windows = []CslMaintenanceWindow{}
+	}
+
+	return windows
+}
+
+func getCslMaintenanceQueue(ctx context.Context, orgId string) CslMaintenanceQueue {
+	queue := CslMaintenanceQueue{}
+	_, err := getCslDocument(ctx, orgId, CslMaintenanceQueueDocument, &queue)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting maintenance queue for org %s: %s", orgId, err)
+	}
+
+	if queue.Schedules == nil {
+		queue.Schedules = []CslQueuedSchedule{}
+	}
+
+	return queue
+}
+
+func (window CslMaintenanceWindow) covers(workflowId string) bool {
+	return len(window.Workflows) == 0 || shuffle.ArrayContains(window.Workflows, workflowId)
+}
+
+func (window CslMaintenanceWindow) isActive(now time.Time) bool {
+	if len(window.Cron) == 0 {
+		return now.Unix() >= window.Start && now.Unix() < window.End
+	}
+
+	schedule, err := parseCronExpression(window.Cron)
+	if err != nil {
+		return false
+	}
+
+	// The last start is the first fire time after the window length ago
+	duration := time.Duration(window.DurationMinutes) * time.Minute
+	started := schedule.next(now.Add(-duration))
+	return !started.IsZero() && !started.After(now)
+}
+
+// The active window covering the workflow, if any
+func getActiveMaintenanceWindow(ctx context.Context, orgId, workflowId string) *CslMaintenanceWindow {
+	windows := getCslMaintenanceWindows(ctx, orgId).Windows
+	if len(windows) == 0 {
+		return nil
+	}
+
+	now := time.Now().In(getTimezoneLocation(getCslOrgSettings(ctx, orgId).Timezone))
+	for _, window := range windows {
+		if window.covers(workflowId) && window.isActive(now) {
+			return &window
+		}
+	}
+
+	return nil
+}
+
+func validateMaintenanceWindow(window CslMaintenanceWindow) error {
+	if len(window.Name) == 0 {
+		return errors.New("maintenance windows need a name")
+	}
+
+	if !shuffle.ArrayContains(maintenanceActions, window.Action) {
+		return errors.New(fmt.Sprintf("unknown action %s of window %s. Use one of %s", window.Action, window.Name, strings.Join(maintenanceActions, ", ")))
+	}
+
+	if len(window.Cron) == 0 {
+		if window.Start <= 0 || window.End <= window.Start {
+			return errors.New(fmt.Sprintf("window %s needs a start before its end, or a cron expression", window.Name))
+		}
+
+		return nil
+	}
+
+	if window.Start != 0 || window.End != 0 {
+		return errors.New(fmt.Sprintf("window %s can't have both a cron expression and a start or end", window.Name))
+	}
+
+	_, err := parseCronExpression(window.Cron)
+	if err != nil {
+		return errors.New(fmt.Sprintf("cron of window %s is invalid: %s", window.Name, err))
+	}
+
+	if window.DurationMinutes < 1 || window.DurationMinutes > MaxMaintenanceDurationMinutes {
+		return errors.New(fmt.Sprintf("duration_minutes of window %s must be between 1 and %d", window.Name, MaxMaintenanceDurationMinutes))
+	}
+
+	return nil
+}
+
+// Called by scheduled executions before they start. Returns true when a
+// maintenance window holds the run back, after skipping or queueing it. The
+// key identifies the schedule, and body is the execution request to queue
+func holdCslScheduledExecution(ctx context.Context, orgId, workflowId, key string, body []byte) bool {
+	window := getActiveMaintenanceWindow(ctx, orgId, workflowId)
+	if window == nil {
+		return false
+	}
+
+	if window.Action == MaintenanceActionSkip {
+		log.Printf("[INFO] Skipping scheduled execution of workflow %s during maintenance window %s in org %s", workflowId, window.Name, orgId)
+		shuffle.IncrementCache(ctx, orgId, fmt.Sprintf("%s%s", MaintenanceSkippedStatPrefix, window.Id))
+		return true
+	}
+
+	queue := CslMaintenanceQueue{}
+	err := updateCslDocument(ctx, orgId, CslMaintenanceQueueDocument, &queue, func() error {
+		for index, schedule := range queue.Schedules {
+			if schedule.Key == key {
+				queue.Schedules[index].Suppressed += 1
+				return nil
+			}
+		}
+
+		queue.Schedules = append(queue.Schedules, CslQueuedSchedule{
+			Key:        key,
+			WorkflowId: workflowId,
+			WindowId:   window.Id,
+			Body:       string(body),
+			QueuedAt:   time.Now().Unix(),
+			Suppressed: 1,
+		})
+
+		return nil
+	})
+	if err != nil {
+		// Runs are rather started than lost when they can't be queued
+		log.Printf("[ERROR] Failed queueing scheduled execution of workflow %s during maintenance in org %s: %s", workflowId, orgId, err)
+		return false
+	}
+
+	log.Printf("[INFO] Queued scheduled execution of workflow %s during maintenance window %s in org %s", workflowId, window.Name, orgId)
+	shuffle.IncrementCache(ctx, orgId, fmt.Sprintf("%s%s", MaintenanceQueuedStatPrefix, window.Id))
+	return true
+}
+
+// Job: starts queued scheduled runs of workflows no window covers anymore
+func runCslMaintenanceReleaseJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for maintenance release job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		if len(getCslMaintenanceQueue(ctx, org.Id).Schedules) == 0 {
+			continue
+		}
+
+		released := []CslQueuedSchedule{}
+		queue := CslMaintenanceQueue{}
+		err = updateCslDocument(ctx, org.Id, CslMaintenanceQueueDocument, &queue, func() error {
+			remaining := []CslQueuedSchedule{}
+			for _, schedule := range queue.Schedules {
+				if getActiveMaintenanceWindow(ctx, org.Id, schedule.WorkflowId) != nil {
+					remaining = append(remaining, schedule)
+					continue
+				}
+
+				released = append(released, schedule)
+			}
+
+			queue.Schedules = remaining
+			return nil
+		})
+		if err != nil {
+			log.Printf("[WARNING] Failed updating maintenance queue of org %s: %s", org.Id, err)
+			continue
+		}
+
+		for _, schedule := range released {
+			request := &http.Request{
+				URL:    &url.URL{},
+				Method: "POST",
+				Body:   ioutil.NopCloser(bytes.NewReader([]byte(schedule.Body))),
+			}
+
+			_, _, err := handleExecution(schedule.WorkflowId, shuffle.Workflow{ExecutingOrg: shuffle.OrgMini{Id: org.Id}}, request, org.Id)
+			if err != nil {
+				log.Printf("[WARNING] Failed starting queued scheduled execution of workflow %s after maintenance: %s", schedule.WorkflowId, err)
+				continue
+			}
+
+			log.Printf("[INFO] Started queued scheduled execution of workflow %s in org %s after maintenance. Runs held back: %d", schedule.WorkflowId, org.Id, schedule.Suppressed)
+		}
+	}
+}
+
+/*
+Maintenance windows:
+Returns the maintenance windows of the current organization, the ids of the
+active windows and the scheduled runs queued until they end. One-off windows
+have a unix start and end, and recurring windows start at each fire time of
+cron in the org timezone and last duration_minutes. Scheduled runs of the
+workflows in a window, or every workflow without workflows, are skipped or
+queued per its action. A queued schedule runs once after the window, however
+many runs it missed.
+
+	{
+	    "success": true,
+	    "data": {
+	        "windows": [
+	            {
+	                "id": "3b0e...",
+	                "name": "Weekly SIEM upgrade",
+	                "start": 0,
+	                "end": 0,
+	                "cron": "0 22 * * sat",
+	                "duration_minutes": 240,
+	                "workflows": [],
+	                "action": "queue"
+	            }
+	        ],
+	        "active": ["3b0e..."],
+	        "queued": [
+	            {
+	                "key": "9f2c...",
+	                "workflow_id": "5ec3...",
+	                "window_id": "3b0e...",
+	                "body": "{...}",
+	                "queued_at": 1718402400,
+	                "suppressed": 16
+	            }
+	        ]
+	    }
+	}
+*/
+func cslGetMaintenanceWindows(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	status := CslMaintenanceStatus{
+		Windows: getCslMaintenanceWindows(ctx, user.ActiveOrg.Id).Windows,
+		Active:  []string{},
+		Queued:  getCslMaintenanceQueue(ctx, user.ActiveOrg.Id).Schedules,
+	}
+
+	now := time.Now().In(getTimezoneLocation(getCslOrgSettings(ctx, user.ActiveOrg.Id).Timezone))
+	for _, window := range status.Windows {
+		if window.isActive(now) {
+			status.Active = append(status.Active, window.Id)
+		}
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    status,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetMaintenanceWindows")
+}
+
+/*
+Maintenance windows:
+Sets the maintenance windows of the current organization in the format of the
+windows field returned from GET. Requires org admin. Windows without an id get
+one, and action defaults to skip. Runs queued by removed windows still start
+once no window covers their workflow.
+*/
+func cslSetMaintenanceWindows(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	windows := CslMaintenanceWindows{}
+	err = json.Unmarshal(body, &windows)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if windows.Windows == nil {
+		windows.Windows = []CslMaintenanceWindow{}
+	}
+
+	if len(windows.Windows) > MaxMaintenanceWindows {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("can't have more than %d maintenance windows", MaxMaintenanceWindows))))
+		return
+	}
+
+	for index, window := range windows.Windows {
+		if len(window.Id) == 0 {
+			window.Id = uuid.NewV4().String()
+		}
+
+		if len(window.Action) == 0 {
+			window.Action = MaintenanceActionSkip
+		}
+
+		if window.Workflows == nil {
+			window.Workflows = []string{}
+		}
+
+		err = validateMaintenanceWindow(window)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		for _, workflowId := range window.Workflows {
+			workflow, err := shuffle.GetWorkflow(ctx, workflowId)
+			if err != nil || workflow.OrgId != user.ActiveOrg.Id {
+				resp.WriteHeader(400)
+				resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("workflow %s not found", workflowId))))
+				return
+			}
+		}
+
+		windows.Windows[index] = window
+	}
+
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslMaintenanceWindowsDocument, windows)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) changed maintenance windows of org %s. Windows: %d", user.Username, user.Id, user.ActiveOrg.Id, len(windows.Windows))
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "maintenance_windows_changed", "Maintenance windows were changed", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    windows,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetMaintenanceWindows")
+}
+
+/*
+Maintenance windows:
+Returns the scheduled runs skipped and queued during maintenance windows of
+the current organization for today and the previous ?days=N-1 days (default
+7, max 30), in total and per window.
+
+	{
+	    "success": true,
+	    "data": {
+	        "days": 7,
+	        "skipped": 12,
+	        "queued": 16,
+	        "windows": [
+	            {
+	                "id": "3b0e...",
+	                "name": "Weekly SIEM upgrade",
+	                "skipped": 0,
+	                "queued": 16
+	            }
+	        ]
+	    }
+	}
+*/
+func cslMaintenanceStats(resp http.ResponseWriter, request *http.Request) {
+	orgStats := handleOrgStatsRequest(resp, request)
+	if orgStats == nil {
+		return
+	}
+
+	days := WeekLength
+	if value := request.URL.Query().Get("days"); len(value) > 0 {
+		parsedDays, err := strconv.Atoi(value)
+		if err != nil || parsedDays < 1 || parsedDays > MonthLength {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("days must be between 1 and %d", MonthLength))))
+			return
+		}
+
+		days = parsedDays
+	}
+
+	ctx := shuffle.GetContext(request)
+	windows := getCslMaintenanceWindows(ctx, orgStats.OrgId).Windows
+
+	windowIds := []string{}
+	for _, window := range windows {
+		windowIds = append(windowIds, window.Id)
+	}
+
+	skipped := sumRecentAdditions(orgStats, MaintenanceSkippedStatPrefix, windowIds, days)
+	queued := sumRecentAdditions(orgStats, MaintenanceQueuedStatPrefix, windowIds, days)
+
+	stats := CslMaintenanceStats{
+		Days:    days,
+		Windows: []CslMaintenanceWindowStats{},
+	}
+
+	for _, window := range windows {
+		windowStats := CslMaintenanceWindowStats{
+			Id:      window.Id,
+			Name:    window.Name,
+			Skipped: skipped[window.Id].Count,
+			Queued:  queued[window.Id].Count,
+		}
+
+		stats.Skipped += windowStats.Skipped
+		stats.Queued += windowStats.Queued
+		stats.Windows = append(stats.Windows, windowStats)
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    stats,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslMaintenanceStats")
+}
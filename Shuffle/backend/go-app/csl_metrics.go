@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Metrics in the Prometheus text format. Scraped with an api key of the org
+// as bearer token, set in the scrape config as:
+//
+//	authorization:
+//	  credentials: <api key>
+
+// Suffix is added to the metric name, for the _bucket, _sum and _count
+// samples of histograms
+type CslMetricSample struct {
+	Labels map[string]string
+	Value  float64
+	Suffix string
+}
+
+type CslMetric struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []CslMetricSample
+}
+
+func escapeMetricLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
+
+func writeMetrics(buffer *bytes.Buffer, metrics []CslMetric) {
+	for _, metric := range metrics {
+		buffer.WriteString(fmt.Sprintf("# HELP %s %s\n", metric.Name, metric.Help))
+		buffer.WriteString(fmt.Sprintf("# TYPE %s %s\n", metric.Name, metric.Type))
+
+		for _, sample := range metric.Samples {
+			labelNames := []string{}
+			for name := range sample.Labels {
+				labelNames = append(labelNames, name)
+			}
+
+			sort.Strings(labelNames)
+
+			labels := []string{}
+			for _, name := range labelNames {
+				labels = append(labels, fmt.Sprintf(`%s="%s"`, name, escapeMetricLabel(sample.Labels[name])))
+			}
+
+			buffer.WriteString(metric.Name + sample.Suffix)
+			if len(labels) > 0 {
+				buffer.WriteString(fmt.Sprintf("{%s}", strings.Join(labels, ",")))
+			}
+
+			buffer.WriteString(fmt.Sprintf(" %s\n", strconv.FormatFloat(sample.Value, 'g', -1, 64)))
+		}
+	}
+}
+
+func getQueueMetrics(orgId string, backlog CslQueueBacklog) []CslMetric {
+	depth := CslMetric{
+		Name: "shuffle_execution_queue_depth",
+		Help: "Executions waiting to be picked up by Orborus.",
+		Type: "gauge",
+	}
+
+	oldestAge := CslMetric{
+		Name: "shuffle_execution_queue_oldest_age_seconds",
+		Help: "Seconds the oldest queued execution has waited.",
+		Type: "gauge",
+	}
+
+	for _, environment := range backlog.Environments {
+		labels := map[string]string{
+			"org_id":      orgId,
+			"environment": environment.Name,
+		}
+
+		depth.Samples = append(depth.Samples, CslMetricSample{Labels: labels, Value: float64(environment.Depth)})
+		oldestAge.Samples = append(oldestAge.Samples, CslMetricSample{Labels: labels, Value: float64(environment.OldestAgeSeconds)})
+	}
+
+	return []CslMetric{depth, oldestAge}
+}
+
+// Totals since tracking started, as counters only go up
+func getWebhookRejectionMetrics(orgId string, orgStats *shuffle.ExecutionInfo) []CslMetric {
+	rejected := CslMetric{
+		Name: "shuffle_webhook_rejected_total",
+		Help: "Webhook requests rejected before starting an execution.",
+		Type: "counter",
+	}
+
+	sums := sumRecentAdditions(orgStats, WebhookRejectedStatPrefix, webhookRejectReasons, 1)
+	for _, reason := range webhookRejectReasons {
+		rejected.Samples = append(rejected.Samples, CslMetricSample{
+			Labels: map[string]string{
+				"org_id": orgId,
+				"reason": reason,
+			},
+			Value: float64(sums[reason].Total),
+		})
+	}
+
+	return []CslMetric{rejected}
+}
+
+/*
+Metrics:
+Returns the metrics of the current organization in the Prometheus text format.
+
+	# HELP shuffle_execution_queue_depth Executions waiting to be picked up by Orborus.
+	# TYPE shuffle_execution_queue_depth gauge
+	shuffle_execution_queue_depth{environment="Shuffle",org_id="a5d3..."} 14
+	# HELP shuffle_execution_queue_oldest_age_seconds Seconds the oldest queued execution has waited.
+	# TYPE shuffle_execution_queue_oldest_age_seconds gauge
+	shuffle_execution_queue_oldest_age_seconds{environment="Shuffle",org_id="a5d3..."} 95
+	# HELP shuffle_webhook_rejected_total Webhook requests rejected before starting an execution.
+	# TYPE shuffle_webhook_rejected_total counter
+	shuffle_webhook_rejected_total{org_id="a5d3...",reason="signature"} 40
+	shuffle_webhook_rejected_total{org_id="a5d3...",reason="replay"} 7
+	shuffle_webhook_rejected_total{org_id="a5d3...",reason="payload_size"} 2
+	# HELP shuffle_backend_panics_total Panics recovered in API handlers of the backend answering the scrape.
+	# TYPE shuffle_backend_panics_total counter
+	shuffle_backend_panics_total{route="GET /api/v1/workflows/{key}"} 1
+	# HELP shuffle_http_request_duration_seconds Latency of API requests to the backend answering the scrape.
+	# TYPE shuffle_http_request_duration_seconds histogram
+	shuffle_http_request_duration_seconds_bucket{le="0.005",method="GET",route="/api/v1/workflows"} 0
+	...
+	shuffle_http_request_duration_seconds_bucket{le="+Inf",method="GET",route="/api/v1/workflows"} 310
+	shuffle_http_request_duration_seconds_sum{method="GET",route="/api/v1/workflows"} 41.2
+	shuffle_http_request_duration_seconds_count{method="GET",route="/api/v1/workflows"} 310
+	# HELP shuffle_http_requests_total API requests to the backend answering the scrape, by status class.
+	# TYPE shuffle_http_requests_total counter
+	shuffle_http_requests_total{method="GET",route="/api/v1/workflows",status="5xx"} 2
+	# HELP shuffle_slow_queries_total Database queries of the backend answering the scrape slower than SHUFFLE_SLOW_QUERY_MS.
+	# TYPE shuffle_slow_queries_total counter
+	shuffle_slow_queries_total{function="GetAllWorkflowsByQuery",handler="cslWorkflows",operation="workflow/_search"} 42
+*/
+func cslMetrics(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	backlog, err := getCslQueueBacklog(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	orgStats, err := shuffle.GetOrgStatistics(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	metrics := []CslMetric{}
+	metrics = append(metrics, getQueueMetrics(user.ActiveOrg.Id, backlog)...)
+	metrics = append(metrics, getWebhookRejectionMetrics(user.ActiveOrg.Id, orgStats)...)
+	metrics = append(metrics, getPanicMetrics()...)
+	metrics = append(metrics, getEndpointMetrics()...)
+	metrics = append(metrics, getSlowQueryMetrics()...)
+
+	buffer := bytes.Buffer{}
+	writeMetrics(&buffer, metrics)
+
+	resp.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	resp.WriteHeader(200)
+	resp.Write(buffer.Bytes())
+}
@@ -0,0 +1,919 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TOTP two-factor authentication on top of the MFA fields of Shuffle users.
+// The secret stays in user.MFA so the Shuffle login keeps working, while
+// backup codes and used codes are kept in a document scoped to the user id,
+// as users can be in several orgs.
+
+const CslMfaPolicyDocument = "mfa_policy"
+const CslMfaDocument = "mfa"
+
+const TotpPeriod = 30
+const TotpDigits = 6
+
+// Windows accepted on either side of the current one, for clock drift
+const MfaAllowedDrift = 1
+
+const DefaultMfaBackupCodes = 10
+const MaxMfaBackupCodes = 20
+
+// The required flag is the MFARequired flag of the org, which the Shuffle
+// login enforces. It isn't stored in the document
+type CslMfaPolicy struct {
+	Required    bool `json:"required"`
+	BackupCodes int  `json:"backup_codes"`
+}
+
+type CslMfaPolicyStatus struct {
+	Policy          CslMfaPolicy    `json:"policy"`
+	UsersWithoutMfa []CslMfaOrgUser `json:"users_without_mfa"`
+}
+
+type CslMfaOrgUser struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// BackupCodes are sha256 hashes. LastWindow is the last accepted TOTP window,
+// so a code can't be used twice
+type CslMfaUser struct {
+	EnrolledAt  int64    `json:"enrolled_at"`
+	BackupCodes []string `json:"backup_codes"`
+	LastWindow  int64    `json:"last_window"`
+	LastUsed    int64    `json:"last_used"`
+}
+
+type CslMfaStatus struct {
+	Enabled              bool  `json:"enabled"`
+	Pending              bool  `json:"pending"`
+	Required             bool  `json:"required"`
+	BackupCodesRemaining int   `json:"backup_codes_remaining"`
+	EnrolledAt           int64 `json:"enrolled_at"`
+	LastUsed             int64 `json:"last_used"`
+}
+
+type CslMfaEnrollment struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+
+type CslMfaBackupCodes struct {
+	BackupCodes []string `json:"backup_codes"`
+}
+
+type CslMfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func getCslMfaPolicy(ctx context.Context, orgId string) CslMfaPolicy {
+	policy := CslMfaPolicy{}
+	_, err := getCslDocument(ctx, orgId, CslMfaPolicyDocument, &policy)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting 2FA policy for org %s: %s", orgId, err)
+	}
+
+	if policy.BackupCodes <= 0 {
+		policy.BackupCodes = DefaultMfaBackupCodes
+	}
+
+	org, err := shuffle.GetOrg(ctx, orgId)
+	if err == nil {
+		policy.Required = org.MFARequired
+	}
+
+	return policy
+}
+
+// Returns the name of the first org of the user requiring 2FA, if any
+func getCslMfaRequiredBy(ctx context.Context, user shuffle.User) string {
+	for _, orgId := range user.Orgs {
+		org, err := shuffle.GetOrg(ctx, orgId)
+		if err == nil && org.MFARequired {
+			return org.Name
+		}
+	}
+
+	return ""
+}
+
+func getCslMfaUser(ctx context.Context, userId string) CslMfaUser {
+	mfa := CslMfaUser{}
+	_, err := getCslDocument(ctx, userId, CslMfaDocument, &mfa)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting 2FA state for user %s: %s", userId, err)
+	}
+
+	return mfa
+}
+
+// Accepts both padded secrets, as created by Shuffle, and unpadded ones
+func getTotpCode(secret string, window int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(window))
+
+	hash := hmac.New(sha1.New, key)
+	hash.Write(data)
+	sum := hash.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TotpDigits, value%1000000), nil
+}
+
+func isTotpCode(code string) bool {
+	if len(code) != TotpDigits {
+		return false
+	}
+
+	for _, char := range code {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the window the code belongs to. Codes from lastWindow or earlier
+// have been used already
+func validateTotpCode(secret, code string, lastWindow int64) (int64, error) {
+	current := time.Now().Unix() / TotpPeriod
+	for drift := -MfaAllowedDrift; drift <= MfaAllowedDrift; drift++ {
+		window := current + int64(drift)
+		expected, err := getTotpCode(secret, window)
+		if err != nil {
+			return 0, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+			continue
+		}
+
+		if window <= lastWindow {
+			return 0, errors.New("2FA code has already been used")
+		}
+
+		return window, nil
+	}
+
+	return 0, errors.New("invalid 2FA code")
+}
+
+func newTotpSecret() (string, error) {
+	data := make([]byte, 20)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.EncodeToString(data), nil
+}
+
+func hashMfaBackupCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
+
+// Returns the codes to show to the user and their hashes to store
+func newMfaBackupCodes(amount int) ([]string, []string, error) {
+	codes := []string{}
+	hashes := []string{}
+	for i := 0; i < amount; i++ {
+		data := make([]byte, 5)
+		_, err := rand.Read(data)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := hex.EncodeToString(data)
+		codes = append(codes, fmt.Sprintf("%s-%s", code[:5], code[5:]))
+		hashes = append(hashes, hashMfaBackupCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// Checks a TOTP code of the users active secret and marks it as used
+func useCslTotpCode(ctx context.Context, user shuffle.User, code string) error {
+	mfa := CslMfaUser{}
+	return updateCslDocument(ctx, user.Id, CslMfaDocument, &mfa, func() error {
+		window, err := validateTotpCode(user.MFA.ActiveCode, code, mfa.LastWindow)
+		if err != nil {
+			return err
+		}
+
+		mfa.LastWindow = window
+		mfa.LastUsed = time.Now().Unix()
+		return nil
+	})
+}
+
+// Checks a backup code and removes it, so it can only be used once
+func useCslBackupCode(ctx context.Context, user shuffle.User, code string) error {
+	hash := hashMfaBackupCode(code)
+	remaining := 0
+
+	mfa := CslMfaUser{}
+	err := updateCslDocument(ctx, user.Id, CslMfaDocument, &mfa, func() error {
+		for i, backupCode := range mfa.BackupCodes {
+			if subtle.ConstantTimeCompare([]byte(backupCode), []byte(hash)) != 1 {
+				continue
+			}
+
+			mfa.BackupCodes = append(mfa.BackupCodes[:i], mfa.BackupCodes[i+1:]...)
+			mfa.LastUsed = time.Now().Unix()
+			remaining = len(mfa.BackupCodes)
+			return nil
+		}
+
+		return errors.New("invalid 2FA code")
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[AUDIT] User %s (%s) used a 2FA backup code. %d backup codes left", user.Username, user.Id, remaining)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeSecurity, "mfa_backup_code_used", fmt.Sprintf("%s used a 2FA backup code, %d left", user.Username, remaining), user.Username, user.Id)
+	return nil
+}
+
+// Checks either a TOTP code or a backup code
+func useCslMfaCode(ctx context.Context, user shuffle.User, code string) error {
+	if isTotpCode(code) {
+		return useCslTotpCode(ctx, user, code)
+	}
+
+	return useCslBackupCode(ctx, user, code)
+}
+
+// Checks the 2FA code of a password login before Shuffle does. Shuffle only
+// accepts the code of the current window, so codes within the allowed drift
+// and backup codes are swapped for it. Logins with a bad password are left to
+// Shuffle, so a rejected code doesn't tell whether the password was right
+func checkCslMfaLogin(ctx context.Context, username, password, code string) (string, error) {
+	users, err := shuffle.FindUser(ctx, strings.ToLower(strings.TrimSpace(username)))
+	if err != nil || len(users) != 1 {
+		return "", nil
+	}
+
+	user := users[0]
+	if !user.MFA.Active || len(user.MFA.ActiveCode) == 0 {
+		return "", nil
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	if err != nil {
+		return "", nil
+	}
+
+	err = useCslMfaCode(ctx, user, code)
+	if err != nil {
+		return "", err
+	}
+
+	return getTotpCode(user.MFA.ActiveCode, time.Now().Unix()/TotpPeriod)
+}
+
+// Wraps the Shuffle login handler with the checks of checkCslMfaLogin. Orgs
+// requiring 2FA are enforced by Shuffle through the MFARequired flag
+func cslMfaOnLogin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		if request.Method != "POST" || request.Body == nil {
+			handler(resp, request)
+			return
+		}
+
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		login := map[string]interface{}{}
+		err = json.Unmarshal(body, &login)
+		if err != nil {
+			handler(resp, request)
+			return
+		}
+
+		username, _ := login["username"].(string)
+		password, _ := login["password"].(string)
+		code, _ := login["mfa_code"].(string)
+		if len(code) == 0 {
+			handler(resp, request)
+			return
+		}
+
+		currentCode, err := checkCslMfaLogin(shuffle.GetContext(request), username, password, strings.TrimSpace(code))
+		if err != nil {
+			log.Printf("[AUDIT] Rejected 2FA code in login of %s from %s: %s", username, shuffle.GetRequestIp(request), err)
+			resp.WriteHeader(401)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		if len(currentCode) > 0 {
+			login["mfa_code"] = currentCode
+			body, err = json.Marshal(login)
+			if err != nil {
+				resp.WriteHeader(500)
+				resp.Write(createCslErrorResponse(err))
+				return
+			}
+
+			request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		handler(resp, request)
+	}
+}
+
+func parseCslMfaCodeRequest(request *http.Request) (string, error) {
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return "", err
+	}
+
+	codeRequest := CslMfaCodeRequest{}
+	err = json.Unmarshal(body, &codeRequest)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.TrimSpace(codeRequest.Code)
+	if len(code) == 0 {
+		return "", errors.New("code is required")
+	}
+
+	return code, nil
+}
+
+// Stores new backup codes for the user, replacing the old ones
+func resetCslMfaBackupCodes(ctx context.Context, user shuffle.User, lastWindow int64) ([]string, error) {
+	codes, hashes, err := newMfaBackupCodes(getCslMfaPolicy(ctx, user.ActiveOrg.Id).BackupCodes)
+	if err != nil {
+		return nil, err
+	}
+
+	mfa := CslMfaUser{}
+	err = updateCslDocument(ctx, user.Id, CslMfaDocument, &mfa, func() error {
+		if lastWindow > 0 {
+			mfa.EnrolledAt = time.Now().Unix()
+			mfa.LastWindow = lastWindow
+		}
+
+		mfa.BackupCodes = hashes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func clearCslMfa(ctx context.Context, user *shuffle.User) error {
+	user.MFA = shuffle.MFAInfo{}
+	err := shuffle.SetUser(ctx, user, true)
+	if err != nil {
+		return err
+	}
+
+	return setCslDocument(ctx, user.Id, CslMfaDocument, CslMfaUser{})
+}
+
+/*
+MFA:
+Returns the 2FA status of the current user. Required is set if one of the orgs
+of the user requires 2FA.
+
+	{
+	    "success": true,
+	    "data": {
+	        "enabled": true,
+	        "pending": false,
+	        "required": true,
+	        "backup_codes_remaining": 9,
+	        "enrolled_at": 1700000000,
+	        "last_used": 1700600000
+	    }
+	}
+*/
+func cslGetMfaStatus(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	foundUser, err := shuffle.GetUser(ctx, user.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	mfa := getCslMfaUser(ctx, user.Id)
+	status := CslMfaStatus{
+		Enabled:              foundUser.MFA.Active,
+		Pending:              !foundUser.MFA.Active && len(foundUser.MFA.PreviousCode) > 0,
+		Required:             len(getCslMfaRequiredBy(ctx, *foundUser)) > 0,
+		BackupCodesRemaining: len(mfa.BackupCodes),
+		EnrolledAt:           mfa.EnrolledAt,
+		LastUsed:             mfa.LastUsed,
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    status,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetMfaStatus")
+}
+
+/*
+MFA:
+Starts 2FA enrollment for the current user. The secret is added to an
+authenticator app, either directly or through the otpauth:// uri as a QR code,
+and enrollment finishes with a code from the app at /api/v1/csl/mfa/verify.
+
+	{
+	    "success": true,
+	    "data": {
+	        "secret": "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP",
+	        "uri": "otpauth://totp/Shuffle:analyst%40example.com?secret=...&issuer=Shuffle&digits=6&period=30"
+	    }
+	}
+*/
+func cslEnrollMfa(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	foundUser, err := shuffle.GetUser(ctx, user.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if foundUser.MFA.Active {
+		resp.WriteHeader(409)
+		resp.Write(createCslErrorResponse(errors.New("2FA is already enabled")))
+		return
+	}
+
+	secret, err := newTotpSecret()
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	foundUser.MFA.PreviousCode = secret
+	err = shuffle.SetUser(ctx, foundUser, true)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	enrollment := CslMfaEnrollment{
+		Secret: secret,
+		Uri:    fmt.Sprintf("otpauth://totp/Shuffle:%s?secret=%s&issuer=Shuffle&digits=%d&period=%d", url.PathEscape(foundUser.Username), secret, TotpDigits, TotpPeriod),
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    enrollment,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslEnrollMfa")
+}
+
+/*
+MFA:
+Finishes 2FA enrollment with {"code": "123456"} from the authenticator app.
+Returns the backup codes, which are only shown once. Each backup code can be
+used once in place of a code from the app.
+
+	{
+	    "success": true,
+	    "data": {
+	        "backup_codes": ["3f2a9-c0e1b", "7d4a5-5e01c"]
+	    }
+	}
+*/
+func cslVerifyMfa(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	code, err := parseCslMfaCodeRequest(request)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	foundUser, err := shuffle.GetUser(ctx, user.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if foundUser.MFA.Active {
+		resp.WriteHeader(409)
+		resp.Write(createCslErrorResponse(errors.New("2FA is already enabled")))
+		return
+	}
+
+	if len(foundUser.MFA.PreviousCode) == 0 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("2FA enrollment hasn't been started")))
+		return
+	}
+
+	window, err := validateTotpCode(foundUser.MFA.PreviousCode, code, 0)
+	if err != nil {
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	foundUser.MFA.Active = true
+	foundUser.MFA.ActiveCode = foundUser.MFA.PreviousCode
+	foundUser.MFA.PreviousCode = ""
+	err = shuffle.SetUser(ctx, foundUser, true)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	codes, err := resetCslMfaBackupCodes(ctx, *foundUser, window)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) enabled 2FA", user.Username, user.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeSecurity, "mfa_enabled", fmt.Sprintf("%s enabled 2FA", user.Username), user.Username, user.Id)
+
+	res := CslResponse{
+		Success: true,
+		Data:    CslMfaBackupCodes{BackupCodes: codes},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslVerifyMfa")
+}
+
+/*
+MFA:
+Replaces the backup codes of the current user. Requires {"code": "123456"}
+from the authenticator app, and the old backup codes stop working.
+*/
+func cslRegenerateMfaBackupCodes(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	code, err := parseCslMfaCodeRequest(request)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	foundUser, err := shuffle.GetUser(ctx, user.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if !foundUser.MFA.Active {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("2FA isn't enabled")))
+		return
+	}
+
+	err = useCslTotpCode(ctx, *foundUser, code)
+	if err != nil {
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	codes, err := resetCslMfaBackupCodes(ctx, *foundUser, 0)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) regenerated 2FA backup codes", user.Username, user.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeSecurity, "mfa_backup_codes_regenerated", fmt.Sprintf("%s regenerated 2FA backup codes", user.Username), user.Username, user.Id)
+
+	res := CslResponse{
+		Success: true,
+		Data:    CslMfaBackupCodes{BackupCodes: codes},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslRegenerateMfaBackupCodes")
+}
+
+/*
+MFA:
+Disables 2FA for the current user with {"code": "123456"}, either from the
+authenticator app or a backup code. Not allowed while one of the orgs of the
+user requires 2FA.
+*/
+func cslDisableMfa(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	code, err := parseCslMfaCodeRequest(request)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	foundUser, err := shuffle.GetUser(ctx, user.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if !foundUser.MFA.Active {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("2FA isn't enabled")))
+		return
+	}
+
+	requiredBy := getCslMfaRequiredBy(ctx, *foundUser)
+	if len(requiredBy) > 0 {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("2FA is required by org %s", requiredBy))))
+		return
+	}
+
+	err = useCslMfaCode(ctx, *foundUser, code)
+	if err != nil {
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	err = clearCslMfa(ctx, foundUser)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) disabled 2FA", user.Username, user.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeSecurity, "mfa_disabled", fmt.Sprintf("%s disabled 2FA", user.Username), user.Username, user.Id)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslDisableMfa")
+}
+
+/*
+MFA:
+Resets 2FA for ?user_id=<id> in the org, e.g. when a device is lost. Requires
+org admin. The session of the user is ended, and if the org requires 2FA the
+user enrolls again at the next login.
+*/
+func cslResetUserMfa(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	orgId := user.ActiveOrg.Id
+
+	target, err := shuffle.GetUser(ctx, request.URL.Query().Get("user_id"))
+	if err != nil || !shuffle.ArrayContains(target.Orgs, orgId) {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New("user not found")))
+		return
+	}
+
+	err = clearCslMfa(ctx, target)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	err = endCslUserSession(ctx, orgId, target)
+	if err != nil {
+		log.Printf("[WARNING] Failed ending session of user %s after 2FA reset: %s", target.Id, err)
+	}
+
+	log.Printf("[AUDIT] User %s (%s) reset 2FA of user %s (%s) in org %s", user.Username, user.Id, target.Username, target.Id, orgId)
+	recordCslActivity(ctx, orgId, ActivityTypeSecurity, "mfa_reset", fmt.Sprintf("2FA of %s was reset by %s", target.Username, user.Username), user.Username, target.Id)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslResetUserMfa")
+}
+
+/*
+MFA:
+Returns the 2FA policy of the org and the users who haven't enabled 2FA.
+Requires org admin.
+
+	{
+	    "success": true,
+	    "data": {
+	        "policy": {
+	            "required": true,
+	            "backup_codes": 10
+	        },
+	        "users_without_mfa": [
+	            {
+	                "id": "...",
+	                "username": "analyst@example.com"
+	            }
+	        ]
+	    }
+	}
+*/
+func cslGetMfaPolicy(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	status := CslMfaPolicyStatus{
+		Policy:          getCslMfaPolicy(ctx, org.Id),
+		UsersWithoutMfa: []CslMfaOrgUser{},
+	}
+
+	for _, orgUser := range org.Users {
+		foundUser, err := shuffle.GetUser(ctx, orgUser.Id)
+		if err != nil || foundUser.MFA.Active {
+			continue
+		}
+
+		status.UsersWithoutMfa = append(status.UsersWithoutMfa, CslMfaOrgUser{
+			Id:       foundUser.Id,
+			Username: foundUser.Username,
+		})
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    status,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetMfaPolicy")
+}
+
+/*
+MFA:
+Updates the 2FA policy of the org. Requires org admin. With required set,
+users without 2FA are asked to enroll at their next password login. SSO and
+OpenID logins are left to the identity provider.
+
+	{
+	    "required": true,
+	    "backup_codes": 10
+	}
+*/
+func cslSetMfaPolicy(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	policy := CslMfaPolicy{}
+	err = json.Unmarshal(body, &policy)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if policy.BackupCodes < 0 || policy.BackupCodes > MaxMfaBackupCodes {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("backup_codes must be between 0 and %d", MaxMfaBackupCodes))))
+		return
+	}
+
+	if policy.BackupCodes == 0 {
+		policy.BackupCodes = DefaultMfaBackupCodes
+	}
+
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if org.MFARequired != policy.Required {
+		org.MFARequired = policy.Required
+		err = shuffle.SetOrg(ctx, *org, org.Id)
+		if err != nil {
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+	}
+
+	err = setCslDocument(ctx, org.Id, CslMfaPolicyDocument, policy)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated 2FA policy for org %s. Required: %t", user.Username, user.Id, org.Id, policy.Required)
+	recordCslActivity(ctx, org.Id, ActivityTypeSecurity, "mfa_policy_updated", "2FA policy was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    policy,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetMfaPolicy")
+}
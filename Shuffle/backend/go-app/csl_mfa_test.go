@@ -0,0 +1,171 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+// "12345678901234567890", the secret of the RFC 6238 test vectors
+const testTotpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGetTotpCode(t *testing.T) {
+	tests := []struct {
+		secret   string
+		time     int64
+		expected string
+	}{
+		{secret: testTotpSecret, time: 59, expected: "287082"},
+		{secret: testTotpSecret, time: 1111111109, expected: "081804"},
+		{secret: testTotpSecret, time: 1111111111, expected: "050471"},
+		{secret: testTotpSecret, time: 1234567890, expected: "005924"},
+		{secret: testTotpSecret, time: 2000000000, expected: "279037"},
+		{secret: testTotpSecret, time: 20000000000, expected: "353130"},
+		{secret: "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", time: 59, expected: "287082"},
+	}
+
+	for _, test := range tests {
+		code, err := getTotpCode(test.secret, test.time/TotpPeriod)
+		if err != nil {
+			t.Errorf("getTotpCode(%s, %d) failed: %s", test.secret, test.time, err)
+			continue
+		}
+
+		if code != test.expected {
+			t.Errorf("getTotpCode(%s, %d) = %s, expected %s", test.secret, test.time, code, test.expected)
+		}
+	}
+
+	// Secrets that aren't a multiple of 5 bytes are padded
+	padded, err := getTotpCode("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGE======", 1)
+	if err != nil {
+		t.Errorf("getTotpCode failed with a padded secret: %s", err)
+	}
+
+	unpadded, err := getTotpCode("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGE", 1)
+	if err != nil || unpadded != padded {
+		t.Errorf("got %s without padding and %s with, expected the same code", unpadded, padded)
+	}
+
+	_, err = getTotpCode("not base32!", 1)
+	if err == nil {
+		t.Errorf("getTotpCode accepted an invalid secret")
+	}
+}
+
+func TestIsTotpCode(t *testing.T) {
+	tests := []struct {
+		code  string
+		valid bool
+	}{
+		{code: "123456", valid: true},
+		{code: "000000", valid: true},
+		{code: "12345"},
+		{code: "1234567"},
+		{code: "12345a"},
+		{code: "abcde-12345"},
+		{code: ""},
+	}
+
+	for _, test := range tests {
+		if isTotpCode(test.code) != test.valid {
+			t.Errorf("isTotpCode(%q) = %t, expected %t", test.code, !test.valid, test.valid)
+		}
+	}
+}
+
+func TestValidateTotpCode(t *testing.T) {
+	secret, err := newTotpSecret()
+	if err != nil {
+		t.Fatalf("newTotpSecret failed: %s", err)
+	}
+
+	current := time.Now().Unix() / TotpPeriod
+	getCode := func(window int64) string {
+		code, err := getTotpCode(secret, window)
+		if err != nil {
+			t.Fatalf("getTotpCode failed: %s", err)
+		}
+
+		return code
+	}
+
+	tests := []struct {
+		name       string
+		code       string
+		lastWindow int64
+		valid      bool
+	}{
+		{name: "current window", code: getCode(current), valid: true},
+		{name: "previous window", code: getCode(current - 1), valid: true},
+		{name: "next window", code: getCode(current + 1), valid: true},
+		{name: "too old", code: getCode(current - 3)},
+		{name: "too new", code: getCode(current + 3)},
+		{name: "already used", code: getCode(current), lastWindow: current},
+		{name: "later code after use", code: getCode(current + 1), lastWindow: current, valid: true},
+		{name: "wrong code", code: "abcdef"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			window, err := validateTotpCode(secret, test.code, test.lastWindow)
+			if (err == nil) != test.valid {
+				t.Fatalf("validateTotpCode returned %v, expected valid=%t", err, test.valid)
+			}
+
+			if test.valid && window <= test.lastWindow {
+				t.Errorf("got window %d, expected one after %d", window, test.lastWindow)
+			}
+		})
+	}
+}
+
+func TestMfaBackupCodes(t *testing.T) {
+	codes, hashes, err := newMfaBackupCodes(DefaultMfaBackupCodes)
+	if err != nil {
+		t.Fatalf("newMfaBackupCodes failed: %s", err)
+	}
+
+	if len(codes) != DefaultMfaBackupCodes || len(hashes) != DefaultMfaBackupCodes {
+		t.Fatalf("got %d codes and %d hashes, expected %d", len(codes), len(hashes), DefaultMfaBackupCodes)
+	}
+
+	pattern := regexp.MustCompile(`^[0-9a-f]{5}-[0-9a-f]{5}$`)
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if !pattern.MatchString(code) {
+			t.Errorf("backup code %s doesn't look like xxxxx-xxxxx", code)
+		}
+
+		if hashMfaBackupCode(code) != hashes[i] {
+			t.Errorf("hash of backup code %s doesn't match the stored hash", code)
+		}
+
+		if seen[code] {
+			t.Errorf("backup code %s was created twice", code)
+		}
+
+		seen[code] = true
+	}
+}
+
+func TestHashMfaBackupCode(t *testing.T) {
+	tests := []struct {
+		code  string
+		equal bool
+	}{
+		{code: "abcde-12345", equal: true},
+		{code: "abcde12345", equal: true},
+		{code: "ABCDE-12345", equal: true},
+		{code: " abcde 12345 ", equal: true},
+		{code: "abcde-12346"},
+		{code: ""},
+	}
+
+	expected := hashMfaBackupCode("abcde-12345")
+	for _, test := range tests {
+		if (hashMfaBackupCode(test.code) == expected) != test.equal {
+			t.Errorf("hash of %q matching abcde-12345 is %t, expected %t", test.code, !test.equal, test.equal)
+		}
+	}
+}
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Mock data for developing the dashboard without a seeded backend. With
+// SHUFFLE_MOCK_DATA=true, authenticated requests with ?mock=true or the
+// X-Csl-Mock: true header get made up data instead of the orgs own, and the
+// response has the X-Csl-Mock: true header. handleCslRequest decides how
+// every endpoint answers a mock request:
+//   - Statistics endpoints answer with the mock data
+//   - Other data endpoints answer as if the org had no data yet
+//   - Settings endpoints answer with the orgs own settings, as they aren't data
+//   - Writes, and endpoints that aren't listed, are refused
+//
+// The data is made with the synthetic data generator, kept in memory per org
+// for the rest of the day and never stored. It goes through the same code as
+// real statistics, so the endpoints agree with each other.
+
+// Shape of the mock data of every org
+const (
+	MockDataWorkflows        = 8
+	MockDataExecutionsPerDay = 25
+	MockDataFailureRate      = 0.08
+)
+
+// Days of mock history, enough for the longest heatmap and month trends
+const MockDataDays = MaxHeatmapWeeks * WeekLength
+
+type cslMockData struct {
+	Date                string
+	Location            *time.Location
+	Workflows           int
+	UnexecutedWorkflows int
+
+	// The latest executions of each workflow, newest first and without
+	// results, like the ones getExecutionActivities looks at
+	WorkflowList     []shuffle.Workflow
+	RecentExecutions [][]shuffle.WorkflowExecution
+
+	// Oldest first, ending with today up to now
+	Days  []CslBackfillDay
+	Hours [][]int64
+
+	AppUsage []CslAppUsage
+}
+
+var cslMockOrgs = struct {
+	sync.Mutex
+	data map[string]*cslMockData
+}{
+	data: map[string]*cslMockData{},
+}
+
+func mockDataEnabled() bool {
+	return os.Getenv("SHUFFLE_MOCK_DATA") == "true"
+}
+
+func isCslMockRequest(request *http.Request) bool {
+	if !mockDataEnabled() {
+		return false
+	}
+
+	return request.URL.Query().Get("mock") == "true" || request.Header.Get("X-Csl-Mock") == "true"
+}
+
+// Whether the request should get mock data. Marks the response if so
+func useCslMockData(resp http.ResponseWriter, request *http.Request) bool {
+	if !isCslMockRequest(request) {
+		return false
+	}
+
+	resp.Header().Set("X-Csl-Mock", "true")
+	return true
+}
+
+// Endpoints that answer mock requests with the mock data themselves
+var cslMockDataEndpoints = []string{
+	"/api/v1/csl/activity",
+	"/api/v1/csl/apiUsage",
+	"/api/v1/csl/apiUsage/breakdown",
+	"/api/v1/csl/appChart",
+	"/api/v1/csl/appUsage",
+	"/api/v1/csl/apps",
+	"/api/v1/csl/executionHeatmap",
+	"/api/v1/csl/failures",
+	"/api/v1/csl/forecast",
+	"/api/v1/csl/healthScore",
+	"/api/v1/csl/maintenanceWindows/stats",
+	"/api/v1/csl/trends",
+	"/api/v1/csl/triggers",
+	"/api/v1/csl/workflowChart",
+	"/api/v1/csl/workflowExecutions",
+	"/api/v1/csl/workflowQuality",
+	"/api/v1/csl/workflows",
+}
+
+// Data endpoints without mock data, answered like for an org without any
+var cslMockEmptyEndpoints = map[string]func(ctx context.Context, orgId string) interface{}{
+	"/api/v1/csl/alerts": func(ctx context.Context, orgId string) interface{} {
+		return []CslAlert{}
+	},
+	"/api/v1/csl/approvals": func(ctx context.Context, orgId string) interface{} {
+		approvals := CslApprovals{}
+		setCslApprovalsDefaults(&approvals)
+		return approvals
+	},
+	"/api/v1/csl/credentials/expiry": func(ctx context.Context, orgId string) interface{} {
+		return CslCredentialExpiryResponse{
+			WarningDays: getCslOrgSettings(ctx, orgId).CredentialExpiryWarningDays,
+			Credentials: []CslCredentialStatus{},
+		}
+	},
+	"/api/v1/csl/credentials/usage": func(ctx context.Context, orgId string) interface{} {
+		return CslCredentialUsageResponse{
+			Credentials: []CslCredentialUsageSummary{},
+			Usage:       []CslCredentialUsage{},
+		}
+	},
+	"/api/v1/csl/dataSubject/reports": func(ctx context.Context, orgId string) interface{} {
+		return []CslDeletionReport{}
+	},
+	"/api/v1/csl/fleetHealth": func(ctx context.Context, orgId string) interface{} {
+		return CslFleetHealth{
+			GeneratedAt:  time.Now().Unix(),
+			Environments: []CslEnvironmentHealth{},
+		}
+	},
+	"/api/v1/csl/geo/events": func(ctx context.Context, orgId string) interface{} {
+		return []CslGeoEvent{}
+	},
+	"/api/v1/csl/lifecycleWebhooks/deliveries": func(ctx context.Context, orgId string) interface{} {
+		return CslLifecycleDeliveries{
+			Pending: []CslLifecycleDelivery{},
+			Recent:  []CslLifecycleDelivery{},
+		}
+	},
+	"/api/v1/csl/observables/stats": func(ctx context.Context, orgId string) interface{} {
+		return getObservableStats(map[string]CslObservable{})
+	},
+	"/api/v1/csl/orgExport": func(ctx context.Context, orgId string) interface{} {
+		return []CslOrgExport{}
+	},
+	"/api/v1/csl/password/locked": func(ctx context.Context, orgId string) interface{} {
+		return []CslLockedUser{}
+	},
+	"/api/v1/csl/pii": func(ctx context.Context, orgId string) interface{} {
+		return getPiiCounts(CslPiiScan{})
+	},
+	"/api/v1/csl/pii/findings": func(ctx context.Context, orgId string) interface{} {
+		return []CslPiiFinding{}
+	},
+	"/api/v1/csl/queueBacklog": func(ctx context.Context, orgId string) interface{} {
+		return CslQueueBacklog{
+			GeneratedAt:  time.Now().Unix(),
+			Environments: []CslEnvironmentBacklog{},
+		}
+	},
+	"/api/v1/csl/sandbox/submissions": func(ctx context.Context, orgId string) interface{} {
+		return []CslSandboxSubmission{}
+	},
+	"/api/v1/csl/sessions": func(ctx context.Context, orgId string) interface{} {
+		return []CslSession{}
+	},
+	"/api/v1/csl/ticketing/jira/issues": func(ctx context.Context, orgId string) interface{} {
+		return []CslJiraIssue{}
+	},
+	"/api/v1/csl/ticketing/servicenow/incidents": func(ctx context.Context, orgId string) interface{} {
+		return []CslServiceNowIncident{}
+	},
+}
+
+// Endpoints answered with the orgs own settings, as the dashboard needs them
+// to show the mock data
+var cslMockSettingsEndpoints = []string{
+	"/api/v1/csl/apiKeys",
+	"/api/v1/csl/approvals/policies",
+	"/api/v1/csl/chaos",
+	"/api/v1/csl/config/reload",
+	"/api/v1/csl/cors",
+	"/api/v1/csl/digest",
+	"/api/v1/csl/encryption",
+	"/api/v1/csl/enrichment/domain/config",
+	"/api/v1/csl/enrichment/ip/config",
+	"/api/v1/csl/enrichment/virustotal/config",
+	"/api/v1/csl/executionPriorities",
+	"/api/v1/csl/executionTimeouts",
+	"/api/v1/csl/featureFlags",
+	"/api/v1/csl/featureFlags/evaluate",
+	"/api/v1/csl/gitSync",
+	"/api/v1/csl/ipAllowlist",
+	"/api/v1/csl/ldap",
+	"/api/v1/csl/lifecycleWebhooks",
+	"/api/v1/csl/logLevel",
+	"/api/v1/csl/maintenanceWindows",
+	"/api/v1/csl/mfa/policy",
+	"/api/v1/csl/notifications/pagerduty",
+	"/api/v1/csl/notifications/preferences",
+	"/api/v1/csl/notifications/slack",
+	"/api/v1/csl/notifications/teams",
+	"/api/v1/csl/observables/config",
+	"/api/v1/csl/orgs/hierarchy",
+	"/api/v1/csl/password/policy",
+	"/api/v1/csl/redaction",
+	"/api/v1/csl/resourceLimits",
+	"/api/v1/csl/resourceLimits/effective",
+	"/api/v1/csl/retryPolicies",
+	"/api/v1/csl/roles",
+	"/api/v1/csl/roles/me",
+	"/api/v1/csl/sandbox",
+	"/api/v1/csl/scim",
+	"/api/v1/csl/secretsBackend",
+	"/api/v1/csl/settings",
+	"/api/v1/csl/siem",
+	"/api/v1/csl/sso/oidc",
+	"/api/v1/csl/sso/saml",
+	"/api/v1/csl/sso/roleMapping",
+	"/api/v1/csl/statsBackfill",
+	"/api/v1/csl/synthetic",
+	"/api/v1/csl/templates",
+	"/api/v1/csl/templates/categories",
+	"/api/v1/csl/templates/template",
+	"/api/v1/csl/ticketing/jira",
+	"/api/v1/csl/ticketing/servicenow",
+	"/api/v1/csl/webhookSecurity",
+	"/api/v1/csl/yara/config",
+	"/api/v1/csl/yara/rulesets",
+}
+
+// Answers mock requests the handler shouldn't get. Returns false if the
+// request was answered
+func handleCslMockRequest(resp http.ResponseWriter, request *http.Request, orgId string) bool {
+	if !useCslMockData(resp, request) {
+		return true
+	}
+
+	if request.Method != "GET" && request.Method != "HEAD" {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("mock data is read only")))
+		return false
+	}
+
+	path := strings.TrimSuffix(request.URL.Path, "/")
+	if shuffle.ArrayContains(cslMockDataEndpoints, path) || shuffle.ArrayContains(cslMockSettingsEndpoints, path) {
+		return true
+	}
+
+	if getEmpty, ok := cslMockEmptyEndpoints[path]; ok {
+		res := CslResponse{
+			Success: true,
+			Data:    getEmpty(shuffle.GetContext(request), orgId),
+		}
+
+		marshalAndWriteResponse(resp, res, "handleCslMockRequest")
+		return false
+	}
+
+	resp.WriteHeader(404)
+	resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("%s has no mock data", path))))
+	return false
+}
+
+func newCslMockData(orgId string, location *time.Location) *cslMockData {
+	now := time.Now().In(location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	start := today.AddDate(0, 0, -(MockDataDays - 1))
+
+	hash := fnv.New64a()
+	hash.Write([]byte(orgId + today.Format(UsageDateFormat)))
+	rng := rand.New(rand.NewSource(int64(hash.Sum64())))
+
+	user := shuffle.User{
+		Id:        "mock",
+		Username:  "mock",
+		ActiveOrg: shuffle.OrgMini{Id: orgId},
+	}
+
+	workflows := []syntheticWorkflow{}
+	for i := 0; i < MockDataWorkflows; i++ {
+		workflows = append(workflows, newSyntheticWorkflow(rng, i, user, start.Unix()))
+	}
+
+	// One workflow that has never run
+	workflows[len(workflows)-1].Factor = 0
+
+	data := &cslMockData{
+		Date:                today.Format(UsageDateFormat),
+		Location:            location,
+		Workflows:           len(workflows),
+		UnexecutedWorkflows: 1,
+		WorkflowList:        []shuffle.Workflow{},
+		RecentExecutions:    make([][]shuffle.WorkflowExecution, len(workflows)),
+		Days:                make([]CslBackfillDay, MockDataDays),
+		Hours:               make([][]int64, MockDataDays),
+	}
+
+	workflowIndexes := map[string]int{}
+	for index, workflow := range workflows {
+		data.WorkflowList = append(data.WorkflowList, workflow.Workflow)
+		workflowIndexes[workflow.Workflow.ID] = index
+	}
+
+	// Made from today and back, so app usage has the latest executions of
+	// each workflow like the stats snapshot
+	days := newBackfillDays(start, today)
+	appUsage := map[string]*CslAppUsage{}
+	appUsageCounts := map[string]int{}
+	for index := MockDataDays - 1; index >= 0; index-- {
+		date := start.AddDate(0, 0, index)
+		day := days[date.Format(UsageDateFormat)]
+		hours := make([]int64, 24)
+
+		executions := newSyntheticDay(rng, workflows, date, now, MockDataExecutionsPerDay, MockDataFailureRate)
+		sort.SliceStable(executions, func(i, j int) bool {
+			return executions[i].StartedAt > executions[j].StartedAt
+		})
+
+		for _, execution := range executions {
+			countBackfillExecution(day, execution)
+			hours[time.Unix(execution.StartedAt, 0).In(location).Hour()]++
+
+			if appUsageCounts[execution.WorkflowId] < AppUsageExecutionLimit {
+				countAppUsage(appUsage, execution)
+				appUsageCounts[execution.WorkflowId]++
+			}
+
+			workflowIndex := workflowIndexes[execution.WorkflowId]
+			if len(data.RecentExecutions[workflowIndex]) < ActivityExecutionsPerWorkflow {
+				execution.Workflow = shuffle.Workflow{}
+				execution.Results = nil
+				data.RecentExecutions[workflowIndex] = append(data.RecentExecutions[workflowIndex], execution)
+			}
+		}
+
+		// Timeouts are also counted on their own, and api usage can't be
+		// found from executions
+		day.Additions[TimeoutStatPrefix+TimeoutStatName] = day.Additions[shuffle.GetFailureStatKey(shuffle.FailureTimeout)]
+		day.Stats.ApiUsage = day.Stats.WorkflowExecutions*2 + int64(rng.Intn(50))
+
+		data.Days[index] = *day
+		data.Hours[index] = hours
+	}
+
+	data.AppUsage = sortAppUsage(appUsage)
+	return data
+}
+
+// Returns the mock data of the org, made again every day
+func getCslMockData(ctx context.Context, orgId string) *cslMockData {
+	location := getTimezoneLocation(getCslOrgSettings(ctx, orgId).Timezone)
+	date := time.Now().In(location).Format(UsageDateFormat)
+
+	cslMockOrgs.Lock()
+	defer cslMockOrgs.Unlock()
+
+	data, ok := cslMockOrgs.data[orgId]
+	if ok && data.Date == date && data.Location.String() == location.String() {
+		return data
+	}
+
+	data = newCslMockData(orgId, location)
+	cslMockOrgs.data[orgId] = data
+	return data
+}
+
+// Org statistics of the mock data, stored the way the backend stores them
+func getMockOrgStatistics(data *cslMockData, orgId string) *shuffle.ExecutionInfo {
+	orgStats := &shuffle.ExecutionInfo{
+		OrgId:           orgId,
+		Timezone:        data.Location.String(),
+		DailyStatistics: []shuffle.DailyStatistics{},
+		Additions:       []shuffle.AdditionalUseConfig{},
+	}
+
+	today := data.Days[len(data.Days)-1]
+	monthStart := time.Date(today.Stats.Date.Year(), today.Stats.Date.Month(), 1, 0, 0, 0, 0, data.Location)
+	totals := map[string]int64{}
+	for index, day := range data.Days {
+		for key, value := range day.Additions {
+			totals[key] += value
+		}
+
+		orgStats.TotalAppExecutions += day.Stats.AppExecutions
+		orgStats.TotalAppExecutionsFailed += day.Stats.AppExecutionsFailed
+		orgStats.TotalSubflowExecutions += day.Stats.SubflowExecutions
+		orgStats.TotalWorkflowExecutions += day.Stats.WorkflowExecutions
+		orgStats.TotalWorkflowExecutionsFinished += day.Stats.WorkflowExecutionsFinished
+		orgStats.TotalWorkflowExecutionsFailed += day.Stats.WorkflowExecutionsFailed
+		orgStats.TotalApiUsage += day.Stats.ApiUsage
+
+		if !day.Stats.Date.Before(monthStart) {
+			orgStats.MonthlyApiUsage += day.Stats.ApiUsage
+			orgStats.MonthlyAppExecutions += day.Stats.AppExecutions
+			orgStats.MonthlyAppExecutionsFailed += day.Stats.AppExecutionsFailed
+			orgStats.MonthlySubflowExecutions += day.Stats.SubflowExecutions
+			orgStats.MonthlyWorkflowExecutions += day.Stats.WorkflowExecutions
+			orgStats.MonthlyWorkflowExecutionsFinished += day.Stats.WorkflowExecutionsFinished
+			orgStats.MonthlyWorkflowExecutionsFailed += day.Stats.WorkflowExecutionsFailed
+		}
+
+		// Today is kept in the daily counters until the day is over
+		if index == len(data.Days)-1 {
+			break
+		}
+
+		stats := mergeBackfillDay(shuffle.DailyStatistics{Date: day.Stats.Date}, day)
+		stats.ApiUsage = day.Stats.ApiUsage
+		orgStats.DailyStatistics = append(orgStats.DailyStatistics, stats)
+	}
+
+	orgStats.DailyAppExecutions = today.Stats.AppExecutions
+	orgStats.DailyAppExecutionsFailed = today.Stats.AppExecutionsFailed
+	orgStats.DailySubflowExecutions = today.Stats.SubflowExecutions
+	orgStats.DailyWorkflowExecutions = today.Stats.WorkflowExecutions
+	orgStats.DailyWorkflowExecutionsFinished = today.Stats.WorkflowExecutionsFinished
+	orgStats.DailyWorkflowExecutionsFailed = today.Stats.WorkflowExecutionsFailed
+	orgStats.DailyApiUsage = today.Stats.ApiUsage
+
+	keys := []string{}
+	for key := range today.Additions {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		orgStats.Additions = append(orgStats.Additions, shuffle.AdditionalUseConfig{
+			Key:        key,
+			Value:      totals[key],
+			DailyValue: today.Additions[key],
+		})
+	}
+
+	return orgStats
+}
+
+func getMockStatsSnapshot(data *cslMockData) CslStatsSnapshot {
+	return CslStatsSnapshot{
+		Workflows:           data.Workflows,
+		UnexecutedWorkflows: data.UnexecutedWorkflows,
+		AppUsage:            data.AppUsage,
+		CalculatedAt:        time.Now().Unix(),
+	}
+}
+
+// Returns the stats snapshot of the org, or of the mock data for mock requests
+func getRequestStatsSnapshot(resp http.ResponseWriter, request *http.Request, orgId string) (CslStatsSnapshot, error) {
+	ctx := shuffle.GetContext(request)
+	if useCslMockData(resp, request) {
+		return getMockStatsSnapshot(getCslMockData(ctx, orgId)), nil
+	}
+
+	return getStatsSnapshot(ctx, orgId)
+}
+
+// Same as getExecutionHeatmap, counted by day instead of by execution
+func getMockExecutionHeatmap(data *cslMockData, weeks int) CslExecutionHeatmap {
+	heatmap := newExecutionHeatmap(weeks, data.Location)
+	since := time.Now().In(data.Location).AddDate(0, 0, -weeks*WeekLength).Format(UsageDateFormat)
+	for index, day := range data.Days {
+		if day.Stats.Date.Format(UsageDateFormat) < since {
+			continue
+		}
+
+		heatmapDay := &heatmap.Days[getHeatmapDayIndex(day.Stats.Date.Weekday())]
+		for hour, count := range data.Hours[index] {
+			heatmapDay.Hours[hour] += count
+			heatmapDay.Total += count
+			heatmap.Total += count
+		}
+	}
+
+	setHeatmapPeak(&heatmap)
+	return heatmap
+}
+
+// Api usage of the mock data. Every execution is started and looked at
+// with the api, the rest is the dashboard
+func getMockApiUsageBreakdown(data *cslMockData, days int) CslApiUsageBreakdown {
+	breakdown := CslApiUsageBreakdown{
+		Days:  days,
+		Daily: []CslDailyUsage{},
+	}
+
+	var executions int64
+	for i := len(data.Days) - 1; i >= 0 && i >= len(data.Days)-days; i-- {
+		day := data.Days[i]
+		breakdown.Total += day.Stats.ApiUsage
+		breakdown.Daily = append(breakdown.Daily, CslDailyUsage{Date: day.Stats.Date.Format(UsageDateFormat), Count: day.Stats.ApiUsage})
+		executions += day.Stats.WorkflowExecutions
+	}
+
+	breakdown.Endpoints = []CslEndpointUsage{
+		{Endpoint: "GET /api/v1/csl/workflows", Count: breakdown.Total - executions*2},
+		{Endpoint: "GET /api/v1/workflows/{key}/executions", Count: executions},
+		{Endpoint: "POST /api/v1/workflows/{key}/execute", Count: executions},
+	}
+
+	sort.SliceStable(breakdown.Endpoints, func(i, j int) bool {
+		return breakdown.Endpoints[i].Count > breakdown.Endpoints[j].Count
+	})
+
+	breakdown.ApiKeys = []CslApiKeyUsage{
+		{
+			KeyId:    "mock",
+			Name:     "Mock api key",
+			UserId:   "mock",
+			Username: "mock",
+			Count:    breakdown.Total,
+		},
+	}
+
+	return breakdown
+}
+
+// Weekly health scores of the mock data, calculated like the weekly job
+// would have. One credential of eight is about to expire
+func getMockHealthScoreHistory(data *cslMockData) CslHealthScoreHistory {
+	history := CslHealthScoreHistory{}
+	coverage := getSnapshotCoverageComponent(getMockStatsSnapshot(data))
+	// Weeks end on today
+	for end := WeekLength - 1 + (len(data.Days)-WeekLength)%WeekLength; end < len(data.Days); end += WeekLength {
+		weekStats := shuffle.DailyStatistics{}
+		for _, day := range data.Days[end-WeekLength+1 : end+1] {
+			weekStats.WorkflowExecutions += day.Stats.WorkflowExecutions
+			weekStats.WorkflowExecutionsFinished += day.Stats.WorkflowExecutionsFinished
+			weekStats.WorkflowExecutionsFailed += day.Stats.WorkflowExecutionsFailed
+		}
+
+		healthScore := weighHealthComponents([]CslHealthComponent{
+			coverage,
+			getFailureRateComponent(weekStats),
+			{
+				Name:      "integration_health",
+				Weight:    0.2,
+				Available: true,
+				Score:     getHealthyPercentage(1, 8),
+			},
+			getBacklogComponent(weekStats),
+			getSlaComplianceComponent(),
+		})
+
+		healthScore.CalculatedAt = data.Days[end].Stats.Date.Unix()
+		history.Latest = healthScore
+		history.History = append(history.History, CslHealthScoreHistoryItem{
+			Score:        healthScore.Score,
+			CalculatedAt: healthScore.CalculatedAt,
+		})
+	}
+
+	return history
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleCslMockRequest(t *testing.T) {
+	t.Setenv("SHUFFLE_MOCK_DATA", "true")
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		handled bool
+		status  int
+	}{
+		{name: "not mocked", method: "POST", target: "/api/v1/csl/settings", handled: true},
+		{name: "mock data", method: "GET", target: "/api/v1/csl/triggers?mock=true", handled: true},
+		{name: "trailing slash", method: "GET", target: "/api/v1/csl/activity/?mock=true", handled: true},
+		{name: "settings", method: "GET", target: "/api/v1/csl/settings?mock=true", handled: true},
+		{name: "empty data", method: "GET", target: "/api/v1/csl/alerts?mock=true", status: 200},
+		{name: "write", method: "POST", target: "/api/v1/csl/settings?mock=true", status: 400},
+		{name: "without mock data", method: "GET", target: "/api/v1/csl/timeline?mock=true", status: 404},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			request := httptest.NewRequest(test.method, test.target, nil)
+
+			handled := handleCslMockRequest(resp, request, "org")
+			if handled != test.handled {
+				t.Fatalf("handleCslMockRequest returned %t, expected %t", handled, test.handled)
+			}
+
+			if !handled && resp.Code != test.status {
+				t.Errorf("got status %d, expected %d", resp.Code, test.status)
+			}
+
+			mocked := resp.Header().Get("X-Csl-Mock") == "true"
+			if mocked != (test.name != "not mocked") {
+				t.Errorf("X-Csl-Mock header set: %t", mocked)
+			}
+		})
+	}
+
+	t.Setenv("SHUFFLE_MOCK_DATA", "")
+	request := httptest.NewRequest("POST", "/api/v1/csl/settings?mock=true", nil)
+	if !handleCslMockRequest(httptest.NewRecorder(), request, "org") {
+		t.Errorf("mock request was answered without SHUFFLE_MOCK_DATA")
+	}
+}
+
+func TestCslMockDataRecentExecutions(t *testing.T) {
+	data := newCslMockData("org", time.UTC)
+	if len(data.WorkflowList) != MockDataWorkflows || len(data.RecentExecutions) != MockDataWorkflows {
+		t.Fatalf("got %d workflows and executions of %d, expected %d", len(data.WorkflowList), len(data.RecentExecutions), MockDataWorkflows)
+	}
+
+	for index, executions := range data.RecentExecutions {
+		if len(executions) > ActivityExecutionsPerWorkflow {
+			t.Errorf("workflow %d has %d recent executions", index, len(executions))
+		}
+
+		for i, execution := range executions {
+			if execution.WorkflowId != data.WorkflowList[index].ID {
+				t.Errorf("execution of workflow %s is kept for %s", execution.WorkflowId, data.WorkflowList[index].ID)
+			}
+
+			if i > 0 && execution.StartedAt > executions[i-1].StartedAt {
+				t.Errorf("recent executions of workflow %d aren't newest first", index)
+			}
+		}
+	}
+
+	breakdown := getMockApiUsageBreakdown(data, 7)
+	if len(breakdown.Daily) != 7 || breakdown.Daily[0].Date != data.Days[len(data.Days)-1].Stats.Date.Format(UsageDateFormat) {
+		t.Errorf("got daily usage %+v, expected the last 7 days newest first", breakdown.Daily)
+	}
+
+	var total int64
+	for _, endpoint := range breakdown.Endpoints {
+		total += endpoint.Count
+	}
+
+	if total != breakdown.Total {
+		t.Errorf("endpoints add up to %d, expected %d", total, breakdown.Total)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Platform events. Every event is sent to the org webhook and the chat
+// integrations that are configured for the org
+const (
+	EventCredentialExpiry  = "credential_expiry_warning"
+	EventQuotaWarning      = "quota_warning"
+	EventQuotaExceeded     = "quota_exceeded"
+	EventGitSyncConflict   = "git_sync_conflict"
+	EventWeeklyReport      = "weekly_report"
+	EventFailureSpike      = "execution_failure_spike"
+	EventExecutionFailed   = "execution_failed"
+	EventSlaBreach         = "sla_breach"
+	EventWorkerOutage      = "worker_outage"
+	EventApprovalRequested = "approval_requested"
+	EventApprovalEscalated = "approval_escalated"
+	EventTest              = "test"
+)
+
+var cslEvents = []string{EventCredentialExpiry, EventQuotaWarning, EventQuotaExceeded, EventGitSyncConflict, EventWeeklyReport, EventFailureSpike, EventExecutionFailed, EventSlaBreach, EventWorkerOutage, EventApprovalRequested, EventApprovalEscalated, EventTest}
+
+// Events that are only sent to chat integrations subscribing to them by name.
+// They aren't matched by "*" or sent to the org webhook
+var optInEvents = []string{EventExecutionFailed}
+
+// Severities
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+var severityLevels = map[string]int{
+	SeverityInfo:     0,
+	SeverityWarning:  1,
+	SeverityCritical: 2,
+}
+
+type CslNotificationField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Chat friendly summary of an event
+type CslNotification struct {
+	Event     string                 `json:"event"`
+	OrgId     string                 `json:"org_id"`
+	Title     string                 `json:"title"`
+	Text      string                 `json:"text"`
+	Severity  string                 `json:"severity"`
+	Fields    []CslNotificationField `json:"fields"`
+	Timestamp int64                  `json:"timestamp"`
+}
+
+type CslExecutionFailure struct {
+	ExecutionId     string `json:"execution_id"`
+	WorkflowId      string `json:"workflow_id"`
+	WorkflowName    string `json:"workflow_name"`
+	Status          string `json:"status"`
+	FailedAction    string `json:"failed_action"`
+	FailureCategory string `json:"failure_category"`
+	StartedAt       int64  `json:"started_at"`
+	CompletedAt     int64  `json:"completed_at"`
+}
+
+func isCslEvent(event string) bool {
+	for _, cslEvent := range cslEvents {
+		if cslEvent == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Whether a notification matches a list of events with a minimum severity.
+// An empty minSeverity matches all severities
+func eventMatches(events []string, minSeverity string, notification CslNotification) bool {
+	if severityLevels[notification.Severity] < severityLevels[minSeverity] {
+		return false
+	}
+
+	for _, event := range events {
+		if event == notification.Event || (event == "*" && !shuffle.ArrayContains(optInEvents, notification.Event)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validateEventFilter(events []string, minSeverity string) error {
+	for _, event := range events {
+		if event != "*" && !isCslEvent(event) {
+			return errors.New(fmt.Sprintf("unknown event %s. Available events are %s", event, strings.Join(cslEvents, ", ")))
+		}
+	}
+
+	if _, ok := severityLevels[minSeverity]; len(minSeverity) > 0 && !ok {
+		return errors.New(fmt.Sprintf("min_severity must be %s, %s or %s", SeverityInfo, SeverityWarning, SeverityCritical))
+	}
+
+	return nil
+}
+
+func getCslNotification(orgId, event string, data interface{}) CslNotification {
+	notification := CslNotification{
+		Event:     event,
+		OrgId:     orgId,
+		Title:     strings.ReplaceAll(event, "_", " "),
+		Severity:  SeverityInfo,
+		Fields:    []CslNotificationField{},
+		Timestamp: time.Now().Unix(),
+	}
+
+	// The weekly report wraps the health score
+	if report, ok := data.(map[string]interface{}); ok {
+		if healthScore, ok := report["health_score"].(CslHealthScoreResponse); ok {
+			data = healthScore
+		}
+	}
+
+	switch value := data.(type) {
+	case CslCredentialExpiryResponse:
+		notification.Title = "Credentials are expiring"
+		notification.Severity = SeverityWarning
+		notification.Text = fmt.Sprintf("%d credentials are expired or expire within %d days", len(value.Credentials), value.WarningDays)
+		for _, credential := range value.Credentials {
+			notification.Fields = append(notification.Fields, CslNotificationField{
+				Name:  fmt.Sprintf("%s (%s)", credential.Label, credential.AppName),
+				Value: credential.Status,
+			})
+		}
+	case CslQuotaStatus:
+		notification.Title = fmt.Sprintf("Quota %s passed the %s limit", value.Name, value.Status)
+		notification.Severity = SeverityWarning
+		if value.Status == QuotaStatusExceeded {
+			notification.Severity = SeverityCritical
+		}
+
+		notification.Text = fmt.Sprintf("%d used in %s", value.Used, value.Period)
+		notification.Fields = append(notification.Fields,
+			CslNotificationField{Name: "Soft limit", Value: fmt.Sprintf("%d", value.Soft)},
+			CslNotificationField{Name: "Hard limit", Value: fmt.Sprintf("%d", value.Hard)},
+		)
+	case CslGitSyncConflict:
+		notification.Title = fmt.Sprintf("Git sync conflict for %s", value.Name)
+		notification.Severity = SeverityWarning
+		notification.Text = value.Reason
+		notification.Fields = append(notification.Fields, CslNotificationField{Name: "Workflow", Value: value.WorkflowId})
+	case CslFailureSpike:
+		notification.Title = "Execution failure spike"
+		notification.Severity = SeverityCritical
+		notification.Text = fmt.Sprintf("%d executions failed in the last %d minutes", value.Failures, value.IntervalMinutes)
+		notification.Fields = append(notification.Fields,
+			CslNotificationField{Name: "Executions", Value: fmt.Sprintf("%d", value.Executions)},
+			CslNotificationField{Name: "Failure rate", Value: fmt.Sprintf("%.1f%%", value.FailureRate)},
+			CslNotificationField{Name: "Threshold", Value: fmt.Sprintf("%d", value.Threshold)},
+		)
+	case CslExecutionFailure:
+		notification.Title = fmt.Sprintf("Execution of %s failed", value.WorkflowName)
+		notification.Severity = SeverityWarning
+		notification.Text = fmt.Sprintf("Execution %s ended with status %s", value.ExecutionId, value.Status)
+		if len(value.FailedAction) > 0 {
+			notification.Fields = append(notification.Fields, CslNotificationField{Name: "Failed action", Value: value.FailedAction})
+		}
+
+		if len(value.FailureCategory) > 0 {
+			notification.Fields = append(notification.Fields, CslNotificationField{Name: "Category", Value: value.FailureCategory})
+		}
+
+		notification.Fields = append(notification.Fields, CslNotificationField{Name: "Workflow", Value: value.WorkflowId})
+	case CslDigestSlaBreach:
+		notification.Title = fmt.Sprintf("Execution of %s breached the SLA", value.WorkflowName)
+		notification.Severity = SeverityWarning
+		notification.Text = fmt.Sprintf("Execution %s has been running for %d minutes", value.ExecutionId, value.DurationMinutes)
+		notification.Fields = append(notification.Fields,
+			CslNotificationField{Name: "Status", Value: value.Status},
+			CslNotificationField{Name: "Workflow", Value: value.WorkflowId},
+		)
+	case CslWorkerOutage:
+		notification.Title = fmt.Sprintf("Environment %s stopped checking in", value.Name)
+		notification.Severity = SeverityCritical
+		notification.Text = fmt.Sprintf("Orborus hasn't checked in for %d minutes. Executions in the environment won't run", value.MinutesSince)
+		notification.Fields = append(notification.Fields,
+			CslNotificationField{Name: "Last checkin", Value: time.Unix(value.LastCheckin, 0).UTC().Format(time.RFC3339)},
+			CslNotificationField{Name: "Running on", Value: value.RunningIp},
+		)
+	case CslApproval:
+		notification.Title = fmt.Sprintf("Approval needed for %s", value.WorkflowName)
+		notification.Severity = SeverityWarning
+		notification.Text = fmt.Sprintf("Execution %s is waiting for %s to be approved", value.ExecutionId, value.Label)
+		if event == EventApprovalEscalated {
+			notification.Title = fmt.Sprintf("Approval for %s was escalated", value.WorkflowName)
+			notification.Severity = SeverityCritical
+			notification.Text = fmt.Sprintf("%s of execution %s wasn't answered in time, and moved to stage %d", value.Label, value.ExecutionId, value.Stage+1)
+		}
+		notification.Fields = append(notification.Fields,
+			CslNotificationField{Name: "Approval", Value: value.Id},
+			CslNotificationField{Name: "Workflow", Value: value.WorkflowId},
+		)
+
+		if len(value.Roles) > 0 {
+			notification.Fields = append(notification.Fields, CslNotificationField{Name: "Roles", Value: strings.Join(value.Roles, ", ")})
+		}
+	case CslHealthScoreResponse:
+		notification.Title = "Weekly report"
+		notification.Text = fmt.Sprintf("Health score is %.1f (%+.1f since last week)", value.Score, value.Trend)
+		for _, component := range value.Components {
+			if component.Available {
+				notification.Fields = append(notification.Fields, CslNotificationField{Name: component.Name, Value: fmt.Sprintf("%.1f", component.Score)})
+			}
+		}
+	case string:
+		notification.Text = value
+	default:
+		b, err := json.Marshal(data)
+		if err == nil {
+			notification.Text = string(b)
+		}
+	}
+
+	return notification
+}
+
+// Replaces {{title}}, {{text}}, {{event}}, {{severity}}, {{org_id}},
+// {{timestamp}} and {{fields}}, which lists all fields, in a template. Every
+// field is also available by its lowercased name with underscores, such as
+// {{failed_action}}
+func renderNotificationTemplate(template string, notification CslNotification) string {
+	fieldLines := []string{}
+	replacements := []string{
+		"{{title}}", notification.Title,
+		"{{text}}", notification.Text,
+		"{{event}}", notification.Event,
+		"{{severity}}", notification.Severity,
+		"{{org_id}}", notification.OrgId,
+		"{{timestamp}}", time.Unix(notification.Timestamp, 0).UTC().Format(time.RFC3339),
+	}
+
+	for _, field := range notification.Fields {
+		fieldLines = append(fieldLines, fmt.Sprintf("%s: %s", field.Name, field.Value))
+		replacements = append(replacements, fmt.Sprintf("{{%s}}", strings.ToLower(strings.ReplaceAll(field.Name, " ", "_"))), field.Value)
+	}
+
+	replacements = append(replacements, "{{fields}}", strings.Join(fieldLines, "\n"))
+	return strings.NewReplacer(replacements...).Replace(template)
+}
+
+// Whether an event for the org would be sent anywhere
+func hasCslNotificationChannel(ctx context.Context, orgId string) bool {
+	return len(getCslOrgSettings(ctx, orgId).WebhookUrl) > 0 || getCslSlack(ctx, orgId).Config.Enabled || getCslTeams(ctx, orgId).Config.Enabled || getCslPagerDuty(ctx, orgId).Config.Enabled || getCslJira(ctx, orgId).Config.Enabled || getCslServiceNow(ctx, orgId).Config.Enabled
+}
+
+// Sends an event to the org webhook, the configured chat integrations and the
+// users subscribing to it (csl_notifyprefs.go). Errors are logged by each channel
+func notifyCslEvent(ctx context.Context, orgId, event string, data interface{}) {
+	if !shuffle.ArrayContains(optInEvents, event) {
+		sendCslWebhook(ctx, orgId, event, data)
+	}
+
+	notification := getCslNotification(orgId, event, data)
+	sendCslSlackNotification(ctx, orgId, notification)
+	sendCslTeamsNotification(ctx, orgId, notification)
+	notifyCslUsers(ctx, orgId, notification)
+}
+
+// Summary of an execution naming the first action that failed, if any
+func getCslExecutionFailure(execution shuffle.WorkflowExecution) CslExecutionFailure {
+	failure := CslExecutionFailure{
+		ExecutionId:  execution.ExecutionId,
+		WorkflowId:   execution.Workflow.ID,
+		WorkflowName: execution.Workflow.Name,
+		Status:       execution.Status,
+		StartedAt:    execution.StartedAt,
+		CompletedAt:  execution.CompletedAt,
+	}
+
+	for _, result := range execution.Results {
+		if result.Status == "FAILURE" || result.Status == "ABORTED" {
+			failure.FailedAction = result.Action.Label
+			failure.FailureCategory = shuffle.GetFailureCategory(result)
+			break
+		}
+	}
+
+	return failure
+}
+
+// Notifies the org of a failed or aborted execution
+func notifyExecutionFailed(ctx context.Context, execution shuffle.WorkflowExecution) {
+	if len(execution.ExecutionOrg) == 0 {
+		return
+	}
+
+	notifyCslEvent(ctx, execution.ExecutionOrg, EventExecutionFailed, getCslExecutionFailure(execution))
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Per-user notification preferences. Every member of an org can choose which
+// events they get themselves, on which channels and with which minimum
+// severity, in addition to the org wide webhook and chat integrations. During
+// quiet hours events aren't emailed, unless the user allows critical ones
+// through. In app notifications are always recorded, as they don't disturb
+// anyone. Users are only notified after they enable their preferences.
+
+const CslNotificationPreferencesDocument = "notification_preferences"
+
+// Channels
+const (
+	NotificationChannelEmail = "email"
+	NotificationChannelInApp = "in_app"
+)
+
+var notificationChannels = []string{NotificationChannelEmail, NotificationChannelInApp}
+
+type CslQuietHours struct {
+	Enabled bool `json:"enabled"`
+
+	// HH:MM in the timezone. Quiet hours ending before they start end the next day
+	Start string `json:"start"`
+	End   string `json:"end"`
+
+	// Defaults to the org timezone
+	Timezone string `json:"timezone"`
+
+	// Whether critical events are emailed during quiet hours
+	AllowCritical bool `json:"allow_critical"`
+}
+
+type CslNotificationPreferences struct {
+	Enabled     bool          `json:"enabled"`
+	Channels    []string      `json:"channels"`
+	Events      []string      `json:"events"`
+	MinSeverity string        `json:"min_severity"`
+	QuietHours  CslQuietHours `json:"quiet_hours"`
+
+	// Defaults to the username if it is an email address
+	Email   string `json:"email"`
+	Updated int64  `json:"updated"`
+}
+
+// Notification preferences of every user in an org, keyed by user id
+type CslNotificationSubscriptions struct {
+	Users map[string]CslNotificationPreferences `json:"users"`
+}
+
+// Preferences of a user along with the options a preferences page can show
+type CslNotificationPreferencesResponse struct {
+	Preferences CslNotificationPreferences `json:"preferences"`
+	Channels    []string                   `json:"channels"`
+	Events      []string                   `json:"events"`
+	Severities  []string                   `json:"severities"`
+}
+
+func getDefaultCslNotificationPreferences() CslNotificationPreferences {
+	return CslNotificationPreferences{
+		Channels:    []string{NotificationChannelInApp},
+		Events:      []string{"*"},
+		MinSeverity: SeverityWarning,
+		QuietHours: CslQuietHours{
+			Start:         "22:00",
+			End:           "07:00",
+			AllowCritical: true,
+		},
+	}
+}
+
+func getCslNotificationSubscriptions(ctx context.Context, orgId string) CslNotificationSubscriptions {
+	subscriptions := CslNotificationSubscriptions{}
+	_, err := getCslDocument(ctx, orgId, CslNotificationPreferencesDocument, &subscriptions)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting notification preferences for org %s: %s", orgId, err)
+	}
+
+	if subscriptions.Users == nil {
+		subscriptions.Users = map[string]CslNotificationPreferences{}
+	}
+
+	return subscriptions
+}
+
+func getCslNotificationPreferences(ctx context.Context, orgId, userId string) CslNotificationPreferences {
+	preferences, ok := getCslNotificationSubscriptions(ctx, orgId).Users[userId]
+	if !ok {
+		return getDefaultCslNotificationPreferences()
+	}
+
+	return preferences
+}
+
+func getNotificationEmail(preferences CslNotificationPreferences, user shuffle.User) string {
+	if len(preferences.Email) > 0 {
+		return preferences.Email
+	}
+
+	address, err := mail.ParseAddress(user.Username)
+	if err != nil {
+		return ""
+	}
+
+	return address.Address
+}
+
+// Minutes since midnight of a HH:MM time
+func parseQuietHoursTime(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("%s isn't a valid time. Use HH:MM", value))
+	}
+
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+func validateCslNotificationPreferences(preferences CslNotificationPreferences, user shuffle.User) error {
+	for _, channel := range preferences.Channels {
+		if !shuffle.ArrayContains(notificationChannels, channel) {
+			return errors.New(fmt.Sprintf("unknown channel %s. Available channels are %s", channel, strings.Join(notificationChannels, ", ")))
+		}
+	}
+
+	if preferences.Enabled && len(preferences.Channels) == 0 {
+		return errors.New("at least one channel is required")
+	}
+
+	err := validateEventFilter(preferences.Events, preferences.MinSeverity)
+	if err != nil {
+		return err
+	}
+
+	if len(preferences.Email) > 0 {
+		_, err := mail.ParseAddress(preferences.Email)
+		if err != nil {
+			return errors.New(fmt.Sprintf("email %s is not a valid email address", preferences.Email))
+		}
+	}
+
+	if preferences.Enabled && shuffle.ArrayContains(preferences.Channels, NotificationChannelEmail) && len(getNotificationEmail(preferences, user)) == 0 {
+		return errors.New("email is required when the username isn't an email address")
+	}
+
+	quietHours := preferences.QuietHours
+	if !quietHours.Enabled {
+		return nil
+	}
+
+	start, err := parseQuietHoursTime(quietHours.Start)
+	if err != nil {
+		return err
+	}
+
+	end, err := parseQuietHoursTime(quietHours.End)
+	if err != nil {
+		return err
+	}
+
+	if start == end {
+		return errors.New("quiet hours can't start and end at the same time")
+	}
+
+	if len(quietHours.Timezone) > 0 {
+		_, err := time.LoadLocation(quietHours.Timezone)
+		if err != nil {
+			return errors.New(fmt.Sprintf("unknown timezone %s", quietHours.Timezone))
+		}
+	}
+
+	return nil
+}
+
+// Whether a time is within quiet hours, which may wrap past midnight
+func isInQuietHours(quietHours CslQuietHours, now time.Time) bool {
+	if !quietHours.Enabled {
+		return false
+	}
+
+	start, err := parseQuietHoursTime(quietHours.Start)
+	if err != nil {
+		return false
+	}
+
+	end, err := parseQuietHoursTime(quietHours.End)
+	if err != nil {
+		return false
+	}
+
+	current := now.Hour()*60 + now.Minute()
+	if start < end {
+		return current >= start && current < end
+	}
+
+	return current >= start || current < end
+}
+
+// Channels an event is delivered on for a user, or none if it doesn't match
+// their preferences
+func getCslNotificationChannels(preferences CslNotificationPreferences, notification CslNotification, now time.Time) []string {
+	if !preferences.Enabled || !eventMatches(preferences.Events, preferences.MinSeverity, notification) {
+		return []string{}
+	}
+
+	quiet := isInQuietHours(preferences.QuietHours, now)
+	if quiet && preferences.QuietHours.AllowCritical && notification.Severity == SeverityCritical {
+		quiet = false
+	}
+
+	channels := []string{}
+	for _, channel := range preferences.Channels {
+		if channel == NotificationChannelEmail && quiet {
+			continue
+		}
+
+		channels = append(channels, channel)
+	}
+
+	return channels
+}
+
+func getCslNotificationEmailBody(notification CslNotification) string {
+	body := fmt.Sprintf("<h3>%s</h3><p>%s</p>", html.EscapeString(notification.Title), html.EscapeString(notification.Text))
+	if len(notification.Fields) > 0 {
+		body += "<ul>"
+		for _, field := range notification.Fields {
+			body += fmt.Sprintf("<li>%s: %s</li>", html.EscapeString(field.Name), html.EscapeString(field.Value))
+		}
+
+		body += "</ul>"
+	}
+
+	return body + fmt.Sprintf("<p>Severity %s. Change which events you get in your notification preferences.</p>", notification.Severity)
+}
+
+func sendCslInAppNotification(ctx context.Context, org *shuffle.Org, user shuffle.User, notification CslNotification) error {
+	timeNow := time.Now().Unix()
+	return shuffle.SetNotification(ctx, shuffle.Notification{
+		Id:          uuid.NewV4().String(),
+		Title:       notification.Title,
+		Description: notification.Text,
+		OrgId:       org.Id,
+		OrgName:     org.Name,
+		UserId:      user.Id,
+		Tags:        []string{notification.Event, notification.Severity},
+		Amount:      1,
+		Dismissable: true,
+		Personal:    true,
+		CreatedAt:   timeNow,
+		UpdatedAt:   timeNow,
+	})
+}
+
+// Sends an event to the members of the org whose preferences match it
+func notifyCslUsers(ctx context.Context, orgId string, notification CslNotification) {
+	subscriptions := getCslNotificationSubscriptions(ctx, orgId)
+	if len(subscriptions.Users) == 0 {
+		return
+	}
+
+	org, err := shuffle.GetOrg(ctx, orgId)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting org %s for user notifications: %s", orgId, err)
+		return
+	}
+
+	orgTimezone := getCslOrgSettings(ctx, orgId).Timezone
+	subject := fmt.Sprintf("[%s] %s", org.Name, notification.Title)
+	for _, user := range org.Users {
+		preferences, ok := subscriptions.Users[user.Id]
+		if !ok {
+			continue
+		}
+
+		timezone := preferences.QuietHours.Timezone
+		if len(timezone) == 0 {
+			timezone = orgTimezone
+		}
+
+		for _, channel := range getCslNotificationChannels(preferences, notification, time.Now().In(getTimezoneLocation(timezone))) {
+			switch channel {
+			case NotificationChannelEmail:
+				email := getNotificationEmail(preferences, user)
+				if len(email) == 0 {
+					continue
+				}
+
+				err = sendCslEmail([]string{email}, subject, getCslNotificationEmailBody(notification))
+			case NotificationChannelInApp:
+				err = sendCslInAppNotification(ctx, org, user, notification)
+			}
+
+			if err != nil {
+				log.Printf("[WARNING] Failed sending %s to user %s in org %s by %s: %s", notification.Event, user.Id, orgId, channel, err)
+			}
+		}
+	}
+}
+
+/*
+Notifications:
+Returns the notification preferences of the current user, along with the
+available channels, events and severities. Users get nothing until enabled is
+set. Quiet hours are in the org timezone unless timezone is set.
+
+	{
+	    "success": true,
+	    "data": {
+	        "preferences": {
+	            "enabled": true,
+	            "channels": ["email", "in_app"],
+	            "events": ["*"],
+	            "min_severity": "warning",
+	            "quiet_hours": {
+	                "enabled": true,
+	                "start": "22:00",
+	                "end": "07:00",
+	                "timezone": "Europe/Oslo",
+	                "allow_critical": true
+	            },
+	            "email": "",
+	            "updated": 1700000000
+	        },
+	        "channels": ["email", "in_app"],
+	        "events": ["credential_expiry_warning", "quota_warning"],
+	        "severities": ["info", "warning", "critical"]
+	    }
+	}
+*/
+func cslGetNotificationPreferences(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data: CslNotificationPreferencesResponse{
+			Preferences: getCslNotificationPreferences(ctx, user.ActiveOrg.Id, user.Id),
+			Channels:    notificationChannels,
+			Events:      cslEvents,
+			Severities:  []string{SeverityInfo, SeverityWarning, SeverityCritical},
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetNotificationPreferences")
+}
+
+/*
+Notifications:
+Updates the notification preferences of the current user. Body uses the same
+format as preferences in the data returned from GET. Email defaults to the
+username when it is an email address.
+*/
+func cslSetNotificationPreferences(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	preferences := getCslNotificationPreferences(ctx, user.ActiveOrg.Id, user.Id)
+	err = json.Unmarshal(body, &preferences)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling notification preferences: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	preferences.MinSeverity = strings.ToLower(preferences.MinSeverity)
+	preferences.Updated = time.Now().Unix()
+	err = validateCslNotificationPreferences(preferences, *user)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	subscriptions := CslNotificationSubscriptions{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslNotificationPreferencesDocument, &subscriptions, func() error {
+		if subscriptions.Users == nil {
+			subscriptions.Users = map[string]CslNotificationPreferences{}
+		}
+
+		subscriptions.Users[user.Id] = preferences
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated notification preferences for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+
+	res := CslResponse{
+		Success: true,
+		Data:    preferences,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetNotificationPreferences")
+}
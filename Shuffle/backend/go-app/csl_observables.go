@@ -0,0 +1,711 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslObservablesDocument = "observables"
+
+// Max observables stored per org. The least recently seen are dropped first
+const MaxObservables = 5000
+
+// Max observables extracted from a single execution
+const MaxExecutionObservables = 250
+
+// Max bytes of execution output scanned for observables
+const MaxObservableScanBytes = 5 * 1024 * 1024
+
+// Max executions and workflows remembered per observable
+const MaxObservableSightings = 10
+
+const MaxObservableIgnores = 200
+
+// Observable types
+const (
+	ObservableIp     = "ip"
+	ObservableDomain = "domain"
+	ObservableUrl    = "url"
+	ObservableMd5    = "md5"
+	ObservableSha1   = "sha1"
+	ObservableSha256 = "sha256"
+)
+
+var observableTypes = []string{ObservableIp, ObservableDomain, ObservableUrl, ObservableMd5, ObservableSha1, ObservableSha256}
+
+var (
+	observableUrlPattern    = regexp.MustCompile(`(?i)\bhttps?://[^\s"'<>\\{}|^\x60]+`)
+	observableIpPattern     = regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b`)
+	observableDomainPattern = regexp.MustCompile(`(?i)\b(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,24}\b`)
+	observableHashPattern   = regexp.MustCompile(`\b[a-fA-F0-9]{32,64}\b`)
+	observableDefangPattern = regexp.MustCompile(`(?i)\[\.\]|\(\.\)|\{\.\}|\[dot\]|\[:\]|\bhxxp`)
+)
+
+// Endings that match the domain pattern but are almost always file names or
+// field paths in JSON output
+var observableIgnoredTlds = []string{
+	"json", "txt", "log", "csv", "xml", "yaml", "yml", "md", "html", "htm",
+	"js", "py", "go", "sh", "ps", "bat", "exe", "dll", "sys", "bin", "tmp",
+	"zip", "gz", "tar", "rar", "pdf", "doc", "docx", "xls", "xlsx", "png",
+	"jpg", "jpeg", "gif", "svg", "eml", "msg", "pcap", "id", "status", "result",
+	"results", "data", "value", "name", "type", "body", "url", "key", "error",
+}
+
+// Types defaults to every observable type. Ignored holds values that are never
+// stored, where domains also ignore their subdomains and the URLs on them
+type CslObservableConfig struct {
+	Enabled bool     `json:"enabled"`
+	Types   []string `json:"types"`
+	Ignored []string `json:"ignored"`
+}
+
+type CslObservable struct {
+	Type         string   `json:"type"`
+	Value        string   `json:"value"`
+	FirstSeen    int64    `json:"first_seen"`
+	LastSeen     int64    `json:"last_seen"`
+	Count        int64    `json:"count"`
+	ExecutionIds []string `json:"execution_ids"`
+	WorkflowIds  []string `json:"workflow_ids"`
+
+	Reputation *CslObservableReputation `json:"reputation,omitempty"`
+}
+
+// Reputation of IP observables, added when enrichment is enabled for the org
+type CslObservableReputation struct {
+	Score   int      `json:"score"`
+	Verdict string   `json:"verdict"`
+	Sources []string `json:"sources"`
+	Checked int64    `json:"checked"`
+}
+
+type CslObservables struct {
+	Config      CslObservableConfig      `json:"config"`
+	Observables map[string]CslObservable `json:"observables"`
+}
+
+type CslObservableTypeStats struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+type CslObservableStats struct {
+	Total     int                      `json:"total"`
+	New24h    int                      `json:"new_24h"`
+	New7d     int                      `json:"new_7d"`
+	Seen24h   int                      `json:"seen_24h"`
+	Sightings int64                    `json:"sightings"`
+	Types     []CslObservableTypeStats `json:"types"`
+	Top       []CslObservable          `json:"top"`
+}
+
+func getObservableId(observableType, value string) string {
+	return fmt.Sprintf("%s:%s", observableType, value)
+}
+
+func getCslObservables(ctx context.Context, orgId string) CslObservables {
+	observables := CslObservables{}
+	_, err := getCslDocument(ctx, orgId, CslObservablesDocument, &observables)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting observables for org %s: %s", orgId, err)
+	}
+
+	if observables.Config.Types == nil {
+		observables.Config.Types = []string{}
+	}
+
+	if observables.Config.Ignored == nil {
+		observables.Config.Ignored = []string{}
+	}
+
+	if observables.Observables == nil {
+		observables.Observables = map[string]CslObservable{}
+	}
+
+	return observables
+}
+
+func validateCslObservableConfig(config CslObservableConfig) error {
+	for _, observableType := range config.Types {
+		if !shuffle.ArrayContains(observableTypes, observableType) {
+			return errors.New(fmt.Sprintf("type %s must be one of %s", observableType, strings.Join(observableTypes, ", ")))
+		}
+	}
+
+	if len(config.Ignored) > MaxObservableIgnores {
+		return errors.New(fmt.Sprintf("can't ignore more than %d values", MaxObservableIgnores))
+	}
+
+	return nil
+}
+
+// Reverts the common ways of defanging indicators, e.g. hxxp://evil[.]com
+func refangObservables(text string) string {
+	return observableDefangPattern.ReplaceAllStringFunc(text, func(match string) string {
+		switch strings.ToLower(match) {
+		case "hxxp":
+			return "http"
+		case "[:]":
+			return ":"
+		default:
+			return "."
+		}
+	})
+}
+
+func isObservableIp(value string) bool {
+	ip := net.ParseIP(value)
+	return ip != nil && !ip.IsLoopback() && !ip.IsUnspecified() && !ip.IsLinkLocalUnicast() && !ip.IsMulticast() && !ip.Equal(net.IPv4bcast)
+}
+
+func isObservableDomain(value string) bool {
+	tld := value[strings.LastIndex(value, ".")+1:]
+	return !shuffle.ArrayContains(observableIgnoredTlds, tld)
+}
+
+func isIgnoredObservable(config CslObservableConfig, observableType, value string) bool {
+	if len(config.Types) > 0 && !shuffle.ArrayContains(config.Types, observableType) {
+		return true
+	}
+
+	host := value
+	if observableType == ObservableUrl {
+		parsedUrl, err := url.Parse(value)
+		if err == nil {
+			host = strings.ToLower(parsedUrl.Hostname())
+		}
+	}
+
+	for _, ignored := range config.Ignored {
+		ignored = strings.ToLower(strings.TrimSpace(ignored))
+		if len(ignored) == 0 {
+			continue
+		}
+
+		if strings.ToLower(value) == ignored || host == ignored || strings.HasSuffix(host, "."+ignored) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Extracts observables from text, keyed by their observable id
+func extractObservables(text string, found map[string]CslObservable) {
+	text = refangObservables(text)
+	add := func(observableType, value string) {
+		if len(found) >= MaxExecutionObservables {
+			return
+		}
+
+		found[getObservableId(observableType, value)] = CslObservable{Type: observableType, Value: value}
+	}
+
+	for _, match := range observableUrlPattern.FindAllString(text, -1) {
+		match = strings.TrimRight(match, ".,;:!?)]'")
+		parsedUrl, err := url.Parse(match)
+		if err != nil || len(parsedUrl.Hostname()) == 0 {
+			continue
+		}
+
+		add(ObservableUrl, match)
+	}
+
+	for _, match := range observableIpPattern.FindAllString(text, -1) {
+		if isObservableIp(match) {
+			add(ObservableIp, match)
+		}
+	}
+
+	for _, match := range observableDomainPattern.FindAllString(text, -1) {
+		match = strings.ToLower(match)
+		if isObservableDomain(match) {
+			add(ObservableDomain, match)
+		}
+	}
+
+	for _, match := range observableHashPattern.FindAllString(text, -1) {
+		match = strings.ToLower(match)
+		switch len(match) {
+		case 32:
+			add(ObservableMd5, match)
+		case 40:
+			add(ObservableSha1, match)
+		case 64:
+			add(ObservableSha256, match)
+		}
+	}
+}
+
+// Texts of an execution scanned for observables, up to MaxObservableScanBytes
+func getObservableTexts(execution shuffle.WorkflowExecution) []string {
+	texts := []string{execution.ExecutionArgument}
+	scanned := len(execution.ExecutionArgument)
+	for _, result := range execution.Results {
+		if scanned >= MaxObservableScanBytes {
+			break
+		}
+
+		text := result.Result
+		if len(text) > MaxObservableScanBytes-scanned {
+			text = text[:MaxObservableScanBytes-scanned]
+		}
+
+		texts = append(texts, text)
+		scanned += len(text)
+	}
+
+	return texts
+}
+
+func addObservableSighting(sightings []string, sighting string) []string {
+	if len(sighting) == 0 || shuffle.ArrayContains(sightings, sighting) {
+		return sightings
+	}
+
+	sightings = append(sightings, sighting)
+	if len(sightings) > MaxObservableSightings {
+		sightings = sightings[len(sightings)-MaxObservableSightings:]
+	}
+
+	return sightings
+}
+
+// Drops the least recently seen observables above MaxObservables
+func pruneObservables(observables map[string]CslObservable) {
+	if len(observables) <= MaxObservables {
+		return
+	}
+
+	ids := []string{}
+	for id := range observables {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return observables[ids[i]].LastSeen < observables[ids[j]].LastSeen
+	})
+
+	for _, id := range ids[:len(ids)-MaxObservables] {
+		delete(observables, id)
+	}
+}
+
+// Extracts the IPs, domains, URLs and hashes in the output of an execution and
+// stores them as observables of its org. Returns the observables found
+func extractCslObservables(ctx context.Context, execution shuffle.WorkflowExecution) ([]CslObservable, error) {
+	orgId := execution.ExecutionOrg
+	config := getCslObservables(ctx, orgId).Config
+
+	found := map[string]CslObservable{}
+	for _, text := range getObservableTexts(execution) {
+		extractObservables(text, found)
+	}
+
+	for id, observable := range found {
+		if isIgnoredObservable(config, observable.Type, observable.Value) {
+			delete(found, id)
+		}
+	}
+
+	extracted := []CslObservable{}
+	if len(found) == 0 {
+		return extracted, nil
+	}
+
+	seen := execution.CompletedAt
+	if seen == 0 {
+		seen = time.Now().Unix()
+	}
+
+	observables := CslObservables{}
+	err := updateCslDocument(ctx, orgId, CslObservablesDocument, &observables, func() error {
+		if observables.Observables == nil {
+			observables.Observables = map[string]CslObservable{}
+		}
+
+		for id, observable := range found {
+			stored, ok := observables.Observables[id]
+			if !ok {
+				stored = observable
+				stored.FirstSeen = seen
+				stored.ExecutionIds = []string{}
+				stored.WorkflowIds = []string{}
+			} else if shuffle.ArrayContains(stored.ExecutionIds, execution.ExecutionId) {
+				// Extraction was already run for the execution
+				extracted = append(extracted, stored)
+				continue
+			}
+
+			if seen > stored.LastSeen {
+				stored.LastSeen = seen
+			}
+
+			stored.Count += 1
+			stored.ExecutionIds = addObservableSighting(stored.ExecutionIds, execution.ExecutionId)
+			stored.WorkflowIds = addObservableSighting(stored.WorkflowIds, execution.Workflow.ID)
+			observables.Observables[id] = stored
+			extracted = append(extracted, stored)
+		}
+
+		pruneObservables(observables.Observables)
+		return nil
+	})
+	if err != nil {
+		return extracted, err
+	}
+
+	return extracted, nil
+}
+
+// Runs extraction for a finished execution if it's enabled for the org
+func onCslExecutionObservables(execution shuffle.WorkflowExecution) {
+	ctx := context.Background()
+	if !getCslObservables(ctx, execution.ExecutionOrg).Config.Enabled {
+		return
+	}
+
+	extracted, err := extractCslObservables(ctx, execution)
+	if err != nil {
+		log.Printf("[WARNING] Failed extracting observables from execution %s: %s", execution.ExecutionId, err)
+		return
+	}
+
+	enrichCslObservables(ctx, execution.ExecutionOrg, extracted)
+}
+
+func getObservableStats(observables map[string]CslObservable) CslObservableStats {
+	stats := CslObservableStats{
+		Total: len(observables),
+		Types: []CslObservableTypeStats{},
+		Top:   []CslObservable{},
+	}
+
+	now := time.Now().Unix()
+	typeCounts := map[string]int{}
+	for _, observable := range observables {
+		typeCounts[observable.Type] += 1
+		stats.Sightings += observable.Count
+		if observable.FirstSeen >= now-86400 {
+			stats.New24h += 1
+		}
+
+		if observable.FirstSeen >= now-7*86400 {
+			stats.New7d += 1
+		}
+
+		if observable.LastSeen >= now-86400 {
+			stats.Seen24h += 1
+		}
+
+		stats.Top = append(stats.Top, observable)
+	}
+
+	for _, observableType := range observableTypes {
+		stats.Types = append(stats.Types, CslObservableTypeStats{
+			Type:  observableType,
+			Count: typeCounts[observableType],
+		})
+	}
+
+	sort.SliceStable(stats.Top, func(i, j int) bool {
+		if stats.Top[i].Count != stats.Top[j].Count {
+			return stats.Top[i].Count > stats.Top[j].Count
+		}
+
+		return stats.Top[i].LastSeen > stats.Top[j].LastSeen
+	})
+
+	stats.Top = stats.Top[:min(len(stats.Top), 10)]
+	return stats
+}
+
+/*
+Observables:
+Searches the observables extracted from executions in the current
+organization, most recently seen first. Optional filters are ?type=<ip|domain|
+url|md5|sha1|sha256>, ?q=<substring of the value>, ?execution_id=<id>,
+?workflow_id=<id> and ?since=<unix timestamp of last seen>. ?limit=<n> defaults
+to 100, max 1000.
+
+	{
+	    "success": true,
+	    "data": {
+	        "total": 1,
+	        "observables": [
+	            {
+	                "type": "domain",
+	                "value": "evil.example.com",
+	                "first_seen": 1700000000,
+	                "last_seen": 1700086400,
+	                "count": 3,
+	                "execution_ids": ["..."],
+	                "workflow_ids": ["..."]
+	            }
+	        ]
+	    }
+	}
+*/
+func cslSearchObservables(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx, cancel := shuffle.GetRequestContext(request)
+	defer cancel()
+	query := request.URL.Query()
+
+	limit := 100
+	if len(query.Get("limit")) > 0 {
+		parsedLimit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || parsedLimit <= 0 {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New("limit must be a positive number")))
+			return
+		}
+
+		limit = min(parsedLimit, 1000)
+	}
+
+	since := int64(0)
+	if len(query.Get("since")) > 0 {
+		parsedSince, err := strconv.ParseInt(query.Get("since"), 10, 64)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New("since must be a unix timestamp")))
+			return
+		}
+
+		since = parsedSince
+	}
+
+	search := strings.ToLower(query.Get("q"))
+	observables := []CslObservable{}
+	for _, observable := range getCslObservables(ctx, user.ActiveOrg.Id).Observables {
+		if len(query.Get("type")) > 0 && observable.Type != query.Get("type") {
+			continue
+		}
+
+		if len(search) > 0 && !strings.Contains(strings.ToLower(observable.Value), search) {
+			continue
+		}
+
+		if len(query.Get("execution_id")) > 0 && !shuffle.ArrayContains(observable.ExecutionIds, query.Get("execution_id")) {
+			continue
+		}
+
+		if len(query.Get("workflow_id")) > 0 && !shuffle.ArrayContains(observable.WorkflowIds, query.Get("workflow_id")) {
+			continue
+		}
+
+		if observable.LastSeen < since {
+			continue
+		}
+
+		observables = append(observables, observable)
+	}
+
+	sort.SliceStable(observables, func(i, j int) bool {
+		if observables[i].LastSeen != observables[j].LastSeen {
+			return observables[i].LastSeen > observables[j].LastSeen
+		}
+
+		return observables[i].Value < observables[j].Value
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"total":       len(observables),
+			"observables": observables[:min(len(observables), limit)],
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSearchObservables")
+}
+
+/*
+Observables:
+Returns statistics of the observables in the current organization: totals,
+new in the last day and week, counts per type and the 10 most seen.
+
+	{
+	    "success": true,
+	    "data": {
+	        "total": 1200,
+	        "new_24h": 35,
+	        "new_7d": 210,
+	        "seen_24h": 80,
+	        "sightings": 5400,
+	        "types": [{"type": "ip", "count": 400}],
+	        "top": [{"type": "ip", "value": "203.0.113.7", "count": 52, ...}]
+	    }
+	}
+*/
+func cslObservableStats(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getObservableStats(getCslObservables(ctx, user.ActiveOrg.Id).Observables),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslObservableStats")
+}
+
+/*
+Observables:
+Extracts observables from a single execution, e.g. one that finished before
+extraction was enabled. Requires ?execution_id=<id>. Returns the observables
+found in it.
+*/
+func cslExtractObservables(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	execution, err := shuffle.GetWorkflowExecution(ctx, request.URL.Query().Get("execution_id"))
+	if err != nil || execution.ExecutionOrg != user.ActiveOrg.Id {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New("execution not found")))
+		return
+	}
+
+	if !isFinishedStatus(execution.Status) {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("execution hasn't finished")))
+		return
+	}
+
+	observables, err := extractCslObservables(ctx, *execution)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    observables,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslExtractObservables")
+}
+
+/*
+Observables:
+Returns the observable extraction configuration. Requires org admin.
+
+	{
+	    "success": true,
+	    "data": {
+	        "enabled": true,
+	        "types": ["ip", "domain", "url", "sha256"],
+	        "ignored": ["example.com", "10.0.0.1"]
+	    }
+	}
+*/
+func cslGetObservableConfig(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslObservables(ctx, user.ActiveOrg.Id).Config,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetObservableConfig")
+}
+
+/*
+Observables:
+Updates the observable extraction configuration. Requires org admin. Body uses
+the format returned from GET. Without types every type is extracted. Ignored
+domains also ignore their subdomains and the URLs on them.
+*/
+func cslSetObservableConfig(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	config := CslObservableConfig{}
+	err = json.Unmarshal(body, &config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if config.Types == nil {
+		config.Types = []string{}
+	}
+
+	if config.Ignored == nil {
+		config.Ignored = []string{}
+	}
+
+	err = validateCslObservableConfig(config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	observables := CslObservables{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslObservablesDocument, &observables, func() error {
+		observables.Config = config
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated observable config for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "observables_updated", "Observable extraction configuration was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    config,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetObservableConfig")
+}
@@ -0,0 +1,739 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslOidcSettingsDocument = "oidc"
+const CslOidcSessionsDocument = "oidc_sessions"
+
+// Minutes a login attempt is valid between redirect and callback
+const OidcStateExpiration = 10
+
+// Tokens expiring within this amount of seconds are refreshed by the job
+const OidcRefreshWindow = 15 * 60
+
+// Claims commonly used by IdPs for group membership
+var defaultGroupClaims = []string{"groups", "roles"}
+
+// Asymmetric algorithms accepted for ID token signatures
+var oidcSigningAlgorithms = []string{oidc.RS256, oidc.RS384, oidc.RS512, oidc.ES256, oidc.ES384, oidc.ES512, oidc.PS256, oidc.PS384, oidc.PS512}
+
+// Keys are cached by the key set and refetched when an unknown key id shows up
+var oidcKeySets = map[string]*oidc.RemoteKeySet{}
+var oidcKeySetsLock sync.Mutex
+
+// OIDC login options. The IdP itself (client id, secret, authorization and
+// token urls) is configured in the orgs SSO config. Issuer and JwksUrl are
+// used to verify the signature of ID tokens
+type CslOidcSettings struct {
+	Enabled         bool   `json:"enabled"`
+	Issuer          string `json:"issuer"`
+	JwksUrl         string `json:"jwks_url"`
+	Scopes          string `json:"scopes"`
+	UsernameClaim   string `json:"username_claim"`
+	RefreshTokens   bool   `json:"refresh_tokens"`
+	JitProvisioning bool   `json:"jit_provisioning"`
+}
+
+type CslOidcState struct {
+	OrgId       string `json:"org_id"`
+	Verifier    string `json:"verifier"`
+	Nonce       string `json:"nonce"`
+	RedirectUri string `json:"redirect_uri"`
+}
+
+type CslOidcTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	IdToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Error        string `json:"error"`
+}
+
+// IdP tokens kept for a Shuffle session. SessionHash is used to notice when
+// the user has logged out or logged in again
+type CslOidcSession struct {
+	Username     string `json:"username"`
+	SessionHash  string `json:"session_hash"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+type CslOidcSessions struct {
+	Sessions map[string]CslOidcSession `json:"sessions"`
+}
+
+func getCslOidcSettings(ctx context.Context, orgId string) CslOidcSettings {
+	settings := CslOidcSettings{}
+	found, err := getCslDocument(ctx, orgId, CslOidcSettingsDocument, &settings)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting OIDC settings for org %s: %s", orgId, err)
+	}
+
+	if !found {
+		settings.RefreshTokens = true
+	}
+
+	if len(settings.Scopes) == 0 {
+		settings.Scopes = "openid email profile offline_access"
+	}
+
+	if len(settings.UsernameClaim) == 0 {
+		settings.UsernameClaim = "sub"
+	}
+
+	return settings
+}
+
+func getSessionHash(session string) string {
+	hash := sha256.Sum256([]byte(session))
+	return hex.EncodeToString(hash[:])
+}
+
+// PKCE verifier and S256 challenge
+func createPkceVerifier() (string, string, error) {
+	data := make([]byte, 32)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", "", err
+	}
+
+	verifier := base64.RawURLEncoding.EncodeToString(data)
+	hash := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
+
+// Url of the backend as the IdP redirects to it
+func getSsoBaseUrl(request *http.Request) string {
+	baseUrl := os.Getenv("SSO_REDIRECT_URL")
+	if len(baseUrl) == 0 {
+		baseUrl = os.Getenv("BASE_URL")
+	}
+
+	if len(baseUrl) == 0 {
+		baseUrl = fmt.Sprintf("http://%s", request.Host)
+	}
+
+	return strings.TrimRight(baseUrl, "/")
+}
+
+// The callback url has to be registered with the IdP
+func getOidcRedirectUri(request *http.Request) string {
+	return fmt.Sprintf("%s/api/v1/csl/login/oidc/callback", getSsoBaseUrl(request))
+}
+
+func getOidcFrontendUrl() string {
+	baseUrl := os.Getenv("SSO_REDIRECT_URL")
+	if len(baseUrl) == 0 {
+		baseUrl = os.Getenv("BASE_URL")
+	}
+
+	if len(baseUrl) == 0 {
+		baseUrl = "http://localhost:3000"
+	}
+
+	return fmt.Sprintf("%s/workflows", strings.TrimRight(baseUrl, "/"))
+}
+
+// Posts to the IdPs token endpoint. Used for both code exchange and refresh
+func requestOidcTokens(org shuffle.Org, form url.Values) (CslOidcTokenResponse, int, error) {
+	tokens := CslOidcTokenResponse{}
+
+	form.Set("client_id", org.SSOConfig.OpenIdClientId)
+	if len(org.SSOConfig.OpenIdClientSecret) > 0 {
+		form.Set("client_secret", org.SSOConfig.OpenIdClientSecret)
+	}
+
+	req, err := http.NewRequest("POST", org.SSOConfig.OpenIdToken, bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		return tokens, 0, err
+	}
+
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", "application/json")
+
+	client := shuffle.GetExternalClient(org.SSOConfig.OpenIdToken)
+	newresp, err := client.Do(req)
+	if err != nil {
+		return tokens, 0, err
+	}
+
+	defer newresp.Body.Close()
+	body, err := ioutil.ReadAll(newresp.Body)
+	if err != nil {
+		return tokens, newresp.StatusCode, err
+	}
+
+	err = json.Unmarshal(body, &tokens)
+	if err != nil {
+		return tokens, newresp.StatusCode, err
+	}
+
+	if newresp.StatusCode != 200 || len(tokens.Error) > 0 {
+		return tokens, newresp.StatusCode, errors.New(fmt.Sprintf("token endpoint returned status %d: %s", newresp.StatusCode, tokens.Error))
+	}
+
+	return tokens, newresp.StatusCode, nil
+}
+
+func getOidcKeySet(jwksUrl string) oidc.KeySet {
+	oidcKeySetsLock.Lock()
+	defer oidcKeySetsLock.Unlock()
+
+	keySet, ok := oidcKeySets[jwksUrl]
+	if !ok {
+		// Not the request context, as the key set outlives the request
+		keyContext := oidc.ClientContext(context.Background(), shuffle.GetExternalClient(jwksUrl))
+		keySet = oidc.NewRemoteKeySet(keyContext, jwksUrl)
+		oidcKeySets[jwksUrl] = keySet
+	}
+
+	return keySet
+}
+
+// Verifies the signature, issuer, audience and expiry of an ID token and
+// returns its claims. The nonce is only checked when given, as refreshed
+// tokens don't have to contain it
+func verifyIdToken(ctx context.Context, keySet oidc.KeySet, issuer, clientId, rawToken, nonce string, now time.Time) (map[string]interface{}, error) {
+	claims := map[string]interface{}{}
+	if len(issuer) == 0 {
+		return claims, errors.New("no issuer configured to verify the id_token")
+	}
+
+	verifier := oidc.NewVerifier(issuer, keySet, &oidc.Config{
+		ClientID:             clientId,
+		SupportedSigningAlgs: oidcSigningAlgorithms,
+		Now:                  func() time.Time { return now },
+	})
+
+	idToken, err := verifier.Verify(ctx, rawToken)
+	if err != nil {
+		return claims, err
+	}
+
+	if len(nonce) > 0 && idToken.Nonce != nonce {
+		return claims, errors.New("id_token nonce doesn't match")
+	}
+
+	err = idToken.Claims(&claims)
+	return claims, err
+}
+
+func getIdTokenClaims(ctx context.Context, org shuffle.Org, settings CslOidcSettings, rawToken, nonce string) (map[string]interface{}, error) {
+	if len(settings.JwksUrl) == 0 {
+		return map[string]interface{}{}, errors.New("no jwks_url configured to verify the id_token")
+	}
+
+	return verifyIdToken(ctx, getOidcKeySet(settings.JwksUrl), settings.Issuer, org.SSOConfig.OpenIdClientId, rawToken, nonce, time.Now())
+}
+
+func getStringClaim(claims map[string]interface{}, name string) string {
+	value, ok := claims[name].(string)
+	if !ok {
+		return ""
+	}
+
+	return value
+}
+
+// Claims can be a single string or a list of strings
+func getListClaim(claims map[string]interface{}, name string) []string {
+	values := []string{}
+	switch value := claims[name].(type) {
+	case string:
+		values = append(values, value)
+	case []interface{}:
+		for _, item := range value {
+			if itemString, ok := item.(string); ok {
+				values = append(values, itemString)
+			}
+		}
+	}
+
+	return values
+}
+
+func getOidcGroups(claims map[string]interface{}, groupClaim string) []string {
+	claimNames := defaultGroupClaims
+	if len(groupClaim) > 0 {
+		claimNames = []string{groupClaim}
+	}
+
+	groups := []string{}
+	for _, name := range claimNames {
+		groups = append(groups, getListClaim(claims, name)...)
+	}
+
+	return groups
+}
+
+// Same session cookies as the login handlers in shuffle-shared
+func setSessionCookies(resp http.ResponseWriter, sessionToken string, expiration time.Time) {
+	newCookie := &http.Cookie{
+		Name:    "session_token",
+		Value:   sessionToken,
+		Expires: expiration,
+		Path:    "/",
+	}
+
+	http.SetCookie(resp, newCookie)
+
+	newCookie.Name = "__session"
+	http.SetCookie(resp, newCookie)
+}
+
+// Finds the OIDC user in the org. Users that don't exist anywhere are created
+// in the org if just-in-time provisioning is on. Users of other orgs are
+// refused, as the IdP of one org can't vouch for them
+func getOrCreateOidcUser(ctx context.Context, org *shuffle.Org, settings CslOidcSettings, username string) (*shuffle.User, error) {
+	user, err := findSsoUser(ctx, username)
+	if err != nil {
+		if !settings.JitProvisioning {
+			return nil, errors.New("user not found")
+		}
+
+		user, _, err = getOrCreateProvisionedUser(ctx, org, username, "OpenID")
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("[AUDIT] Created user %s (%s) in org %s through OpenID Connect", user.Username, user.Id, org.Id)
+		recordCslActivity(ctx, org.Id, ActivityTypeAdmin, "user_provisioned", fmt.Sprintf("User %s was created through OpenID Connect", username), "OpenID", user.Id)
+
+		// The org now has the user in its member list
+		org, err = shuffle.GetOrg(ctx, org.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = setCslActiveOrg(user, org)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func storeOidcSession(ctx context.Context, orgId, userId string, session CslOidcSession) {
+	sessions := CslOidcSessions{}
+	err := updateCslDocument(ctx, orgId, CslOidcSessionsDocument, &sessions, func() error {
+		if sessions.Sessions == nil {
+			sessions.Sessions = map[string]CslOidcSession{}
+		}
+
+		if len(session.RefreshToken) == 0 {
+			delete(sessions.Sessions, userId)
+		} else {
+			sessions.Sessions[userId] = session
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed storing OIDC session for user %s in org %s: %s", userId, orgId, err)
+	}
+}
+
+// Ends the Shuffle session of a user whose IdP session can't be refreshed anymore
+func revokeOidcSession(ctx context.Context, orgId string, user *shuffle.User, reason string) {
+	err := endCslUserSession(ctx, orgId, user)
+	if err != nil {
+		log.Printf("[ERROR] Failed revoking session of user %s (%s): %s", user.Username, user.Id, err)
+		return
+	}
+
+	log.Printf("[AUDIT] Revoked session of user %s (%s) in org %s: %s", user.Username, user.Id, orgId, reason)
+	recordCslActivity(ctx, orgId, ActivityTypeAdmin, "session_revoked", fmt.Sprintf("Session of %s was ended: %s", user.Username, reason), "OpenID", user.Id)
+}
+
+// Refreshes a single session. Returns false if the session should be dropped
+func refreshOidcSession(ctx context.Context, org shuffle.Org, userId string, session CslOidcSession) (CslOidcSession, bool) {
+	user, err := shuffle.GetUser(ctx, userId)
+	if err != nil || len(user.Session) == 0 || getSessionHash(user.Session) != session.SessionHash {
+		// Logged out, or logged in again with a new session
+		return session, false
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", session.RefreshToken)
+
+	tokens, status, err := requestOidcTokens(org, form)
+	if err != nil {
+		// 400/401 means the IdP rejected the grant, e.g. the user was disabled
+		if status == 400 || status == 401 {
+			revokeOidcSession(ctx, org.Id, user, "the identity provider rejected the token refresh")
+			return session, false
+		}
+
+		log.Printf("[WARNING] Failed refreshing OIDC token for user %s in org %s: %s", user.Username, org.Id, err)
+		return session, true
+	}
+
+	if len(tokens.RefreshToken) > 0 {
+		session.RefreshToken = tokens.RefreshToken
+	}
+
+	session.ExpiresAt = time.Now().Unix() + tokens.ExpiresIn
+
+	// Group changes at the IdP are applied on refresh
+	if len(tokens.IdToken) > 0 {
+		claims, err := getIdTokenClaims(ctx, org, getCslOidcSettings(ctx, org.Id), tokens.IdToken, "")
+		if err != nil {
+			log.Printf("[WARNING] Ignoring invalid id_token on refresh for %s in org %s: %s", user.Username, org.Id, err)
+		} else {
+			mapping := getCslSsoRoleMapping(ctx, org.Id)
+			err = applySsoRoleMapping(ctx, org.Id, user, mapping, "OpenID", getOidcGroups(claims, mapping.GroupAttribute))
+			if err != nil {
+				log.Printf("[WARNING] Failed applying OIDC role mapping on refresh for %s: %s", user.Username, err)
+			}
+		}
+	}
+
+	return session, true
+}
+
+// Job: refreshes IdP tokens that are about to expire and ends sessions that can't be refreshed
+func runCslOidcRefreshJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for OIDC refresh job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		sessions := CslOidcSessions{}
+		found, err := getCslDocument(ctx, org.Id, CslOidcSessionsDocument, &sessions)
+		if err != nil || !found || len(sessions.Sessions) == 0 {
+			continue
+		}
+
+		for userId, session := range sessions.Sessions {
+			if session.ExpiresAt-time.Now().Unix() > OidcRefreshWindow {
+				continue
+			}
+
+			refreshed, keep := refreshOidcSession(ctx, org, userId, session)
+			if !keep {
+				refreshed.RefreshToken = ""
+			}
+
+			storeOidcSession(ctx, org.Id, userId, refreshed)
+		}
+	}
+}
+
+/*
+SSO:
+Starts an OpenID Connect login (authorization code flow with PKCE) for an org.
+Requires ?org_id=<org_id>. The IdP is configured in the orgs SSO config and
+has to allow <base url>/api/v1/csl/login/oidc/callback as redirect url.
+*/
+func cslOidcLogin(resp http.ResponseWriter, request *http.Request) {
+	ctx := shuffle.GetContext(request)
+
+	org, err := shuffle.GetOrg(ctx, request.URL.Query().Get("org_id"))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("org not found")))
+		return
+	}
+
+	settings := getCslOidcSettings(ctx, org.Id)
+	if !settings.Enabled || len(settings.Issuer) == 0 || len(settings.JwksUrl) == 0 || len(org.SSOConfig.OpenIdAuthorization) == 0 || len(org.SSOConfig.OpenIdToken) == 0 || len(org.SSOConfig.OpenIdClientId) == 0 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("OpenID Connect is not configured for this org")))
+		return
+	}
+
+	verifier, challenge, err := createPkceVerifier()
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	state := uuid.NewV4().String()
+	oidcState := CslOidcState{
+		OrgId:       org.Id,
+		Verifier:    verifier,
+		Nonce:       uuid.NewV4().String(),
+		RedirectUri: getOidcRedirectUri(request),
+	}
+
+	stateData, err := json.Marshal(oidcState)
+	if err == nil {
+		err = shuffle.SetCache(ctx, fmt.Sprintf("csl_oidc_state_%s", state), stateData, OidcStateExpiration)
+	}
+
+	if err != nil {
+		log.Printf("[ERROR] Failed storing OIDC state for org %s: %s", org.Id, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", org.SSOConfig.OpenIdClientId)
+	query.Set("redirect_uri", oidcState.RedirectUri)
+	query.Set("scope", settings.Scopes)
+	query.Set("state", state)
+	query.Set("nonce", oidcState.Nonce)
+	query.Set("code_challenge", challenge)
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(org.SSOConfig.OpenIdAuthorization, "?") {
+		separator = "&"
+	}
+
+	http.Redirect(resp, request, org.SSOConfig.OpenIdAuthorization+separator+query.Encode(), http.StatusFound)
+}
+
+/*
+SSO:
+Callback for the OpenID Connect login. Exchanges the code for tokens, verifies
+the ID token against the issuers JWKS, logs in a member of the org (or creates
+the user if it doesn't exist anywhere and jit_provisioning is on), applies the
+SSO group to role mapping and redirects to the frontend with a session.
+*/
+func cslOidcCallback(resp http.ResponseWriter, request *http.Request) {
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	if len(query.Get("error")) > 0 {
+		log.Printf("[WARNING] OIDC login failed at the IdP: %s %s", query.Get("error"), query.Get("error_description"))
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("login failed: %s", query.Get("error")))))
+		return
+	}
+
+	stateKey := fmt.Sprintf("csl_oidc_state_%s", query.Get("state"))
+	cache, err := shuffle.GetCache(ctx, stateKey)
+	if err != nil || len(query.Get("state")) == 0 {
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("login expired or state is invalid")))
+		return
+	}
+
+	// States can only be used once
+	shuffle.DeleteCache(ctx, stateKey)
+
+	oidcState := CslOidcState{}
+	cacheData, ok := cache.([]uint8)
+	if !ok || json.Unmarshal([]byte(cacheData), &oidcState) != nil {
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("login state is invalid")))
+		return
+	}
+
+	org, err := shuffle.GetOrg(ctx, oidcState.OrgId)
+	if err != nil {
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("org not found")))
+		return
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", query.Get("code"))
+	form.Set("redirect_uri", oidcState.RedirectUri)
+	form.Set("code_verifier", oidcState.Verifier)
+
+	tokens, _, err := requestOidcTokens(*org, form)
+	if err != nil {
+		log.Printf("[WARNING] OIDC code exchange failed for org %s: %s", org.Id, err)
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("failed exchanging code for tokens")))
+		return
+	}
+
+	settings := getCslOidcSettings(ctx, org.Id)
+	claims, err := getIdTokenClaims(ctx, *org, settings, tokens.IdToken, oidcState.Nonce)
+	if err != nil {
+		log.Printf("[WARNING] Invalid id_token in OIDC login for org %s: %s", org.Id, err)
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("id_token is invalid")))
+		return
+	}
+
+	username := strings.ToLower(strings.TrimSpace(getStringClaim(claims, settings.UsernameClaim)))
+	if len(username) == 0 {
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("id_token is missing the %s claim", settings.UsernameClaim))))
+		return
+	}
+
+	user, err := getOrCreateOidcUser(ctx, org, settings, username)
+	if err != nil {
+		log.Printf("[WARNING] Refused OIDC login of %s to org %s: %s", username, org.Id, err)
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("user is not a member of this org")))
+		return
+	}
+
+	if !user.Active {
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("user is deactivated")))
+		return
+	}
+
+	sessionToken := uuid.NewV4().String()
+	setSessionCookies(resp, sessionToken, time.Now().Add(3600*time.Second))
+
+	err = shuffle.SetSession(ctx, *user, sessionToken)
+	if err != nil {
+		log.Printf("[WARNING] Error creating session for OIDC user %s: %s", username, err)
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("failed setting session")))
+		return
+	}
+
+	user.Session = sessionToken
+	user.LoginInfo = append(user.LoginInfo, shuffle.LoginInfo{
+		IP:        shuffle.GetRequestIp(request),
+		Timestamp: time.Now().Unix(),
+	})
+
+	err = shuffle.SetUser(ctx, user, false)
+	if err != nil {
+		log.Printf("[WARNING] Failed updating OIDC user %s when setting session: %s", username, err)
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("failed updating user")))
+		return
+	}
+
+	mapping := getCslSsoRoleMapping(ctx, org.Id)
+	err = applySsoRoleMapping(ctx, org.Id, user, mapping, "OpenID", getOidcGroups(claims, mapping.GroupAttribute))
+	if err != nil {
+		log.Printf("[ERROR] Failed applying OIDC role mapping for %s: %s", username, err)
+	}
+
+	if settings.RefreshTokens && len(tokens.RefreshToken) > 0 {
+		storeOidcSession(ctx, org.Id, user.Id, CslOidcSession{
+			Username:     user.Username,
+			SessionHash:  getSessionHash(sessionToken),
+			RefreshToken: tokens.RefreshToken,
+			ExpiresAt:    time.Now().Unix() + tokens.ExpiresIn,
+		})
+	}
+
+	log.Printf("[AUDIT] User %s (%s) logged in to org %s with OpenID Connect", user.Username, user.Id, org.Id)
+	recordCslGeoEvent(org.Id, GeoSourceLogin, shuffle.GetRequestIp(request), user.Id, user.Username, http.StatusSeeOther)
+	http.Redirect(resp, request, getOidcFrontendUrl(), http.StatusSeeOther)
+}
+
+/*
+SSO:
+Returns the OpenID Connect login options for the current organization. Requires org admin.
+
+	{
+	    "success": true,
+	    "data": {
+	        "enabled": true,
+	        "issuer": "https://idp.example.com",
+	        "jwks_url": "https://idp.example.com/.well-known/jwks.json",
+	        "scopes": "openid email profile offline_access",
+	        "username_claim": "email",
+	        "refresh_tokens": true,
+	        "jit_provisioning": false
+	    }
+	}
+*/
+func cslGetOidcSettings(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslOidcSettings(ctx, user.ActiveOrg.Id),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetOidcSettings")
+}
+
+/*
+SSO:
+Updates the OpenID Connect login options. Requires org admin. Body uses the
+same format as the data field returned from GET.
+*/
+func cslSetOidcSettings(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	settings := getCslOidcSettings(ctx, user.ActiveOrg.Id)
+	err = json.Unmarshal(body, &settings)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling OIDC settings: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if !strings.Contains(" "+settings.Scopes+" ", " openid ") {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("scopes must include openid")))
+		return
+	}
+
+	if settings.Enabled && (len(settings.Issuer) == 0 || len(settings.JwksUrl) == 0) {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("issuer and jwks_url are required to verify ID tokens")))
+		return
+	}
+
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslOidcSettingsDocument, settings)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated OIDC settings for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "oidc_updated", "OpenID Connect settings were updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    settings,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetOidcSettings")
+}
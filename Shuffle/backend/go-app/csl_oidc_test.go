@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// Creates an RS256 signed JWT with the given claims
+func signTestIdToken(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("failed marshaling claims: %s", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatalf("failed signing token: %s", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyIdToken(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed generating key: %s", err)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed generating key: %s", err)
+	}
+
+	keySet := &oidc.StaticKeySet{PublicKeys: []crypto.PublicKey{&key.PublicKey}}
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		result := map[string]interface{}{
+			"iss":   "https://idp.example.com",
+			"aud":   "shuffle",
+			"sub":   "user@example.com",
+			"nonce": "nonce",
+			"iat":   now.Add(-time.Minute).Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+		}
+
+		for name, value := range changes {
+			result[name] = value
+		}
+
+		return result
+	}
+
+	valid := signTestIdToken(t, key, claims(nil))
+	parts := strings.Split(valid, ".")
+	forgedPayload, _ := json.Marshal(claims(map[string]interface{}{"sub": "admin@example.com"}))
+	unsignedHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+		valid bool
+	}{
+		{name: "valid", token: valid, nonce: "nonce", valid: true},
+		{name: "nonce not checked", token: valid, valid: true},
+		{name: "wrong nonce", token: valid, nonce: "other"},
+		{name: "other key", token: signTestIdToken(t, otherKey, claims(nil))},
+		{name: "changed claims", token: parts[0] + "." + base64.RawURLEncoding.EncodeToString(forgedPayload) + "." + parts[2]},
+		{name: "unsigned", token: unsignedHeader + "." + parts[1] + "."},
+		{name: "other issuer", token: signTestIdToken(t, key, claims(map[string]interface{}{"iss": "https://other.example.com"}))},
+		{name: "other audience", token: signTestIdToken(t, key, claims(map[string]interface{}{"aud": "other"}))},
+		{name: "expired", token: signTestIdToken(t, key, claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()}))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := verifyIdToken(context.Background(), keySet, "https://idp.example.com", "shuffle", test.token, test.nonce, now)
+			if (err == nil) != test.valid {
+				t.Fatalf("got error %v, expected valid %t", err, test.valid)
+			}
+
+			if test.valid && getStringClaim(result, "sub") != "user@example.com" {
+				t.Errorf("got claims %v, expected sub user@example.com", result)
+			}
+		})
+	}
+
+	_, err = verifyIdToken(context.Background(), keySet, "", "shuffle", valid, "", now)
+	if err == nil {
+		t.Errorf("expected an error without an issuer")
+	}
+}
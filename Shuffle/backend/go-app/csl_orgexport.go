@@ -0,0 +1,698 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Exports a whole org as a signed tar.gz archive for backup, or for moving
+// the org to another instance. Archives are built in the background into
+// SHUFFLE_ORG_EXPORT_DIR and downloaded with range requests, so a download
+// can be resumed. They are signed with SHUFFLE_BUNDLE_SIGNING_KEY, the same
+// key as workflow bundles.
+//
+// The archive holds:
+//
+//	manifest.json     format version, counts and the sha256 of every entry
+//	org.json          the org, without users and secrets
+//	users.json        users and their roles
+//	workflows.json
+//	apps.json         apps used, private apps and redacted auth placeholders
+//	schedules.json
+//	files.json        file metadata, with contents in files/<id>
+//	statistics.json
+//	csl/<name>.json   org configuration stored by this backend
+
+const CslOrgExportsDocument = "org_exports"
+
+const OrgExportFormatVersion = 1
+
+// How long archives can be downloaded, and how often expired ones are removed
+const OrgExportRetentionHours = 24
+const OrgExportCleanupMinutes = 60
+
+// Files larger than this are listed in the manifest instead of exported
+const MaxOrgExportFileSize = 100 * 1024 * 1024
+
+// Org export status values
+const (
+	OrgExportBuilding = "building"
+	OrgExportReady    = "ready"
+	OrgExportFailed   = "failed"
+)
+
+// Configuration documents exported with the org. Documents holding
+// integration credentials, and data collected while running, are left out
+var orgExportDocuments = []string{
+	CslSettingsDocument,
+	CslTeamsDocument,
+	CslAppPinsDocument,
+	CslRetryPoliciesDocument,
+	CslExecutionTimeoutsDocument,
+	CslExecutionPrioritiesDocument,
+	CslMaintenanceWindowsDocument,
+	CslResourceLimitsDocument,
+	CslQuotasDocument,
+	CslMfaPolicyDocument,
+	CslPasswordPolicyDocument,
+	CslSsoRoleMappingDocument,
+	CslCorsDocument,
+	CslIpAllowlistDocument,
+}
+
+type CslOrgExport struct {
+	Id          string         `json:"id"`
+	Status      string         `json:"status"`
+	Error       string         `json:"error,omitempty"`
+	RequestedBy string         `json:"requested_by"`
+	Created     int64          `json:"created"`
+	Finished    int64          `json:"finished"`
+	Expires     int64          `json:"expires"`
+	Size        int64          `json:"size"`
+	Sha256      string         `json:"sha256"`
+	Algorithm   string         `json:"algorithm"`
+	Signature   string         `json:"signature"`
+	Counts      map[string]int `json:"counts"`
+}
+
+type CslOrgExports struct {
+	Exports []CslOrgExport `json:"exports"`
+}
+
+type CslOrgExportManifest struct {
+	Version       int               `json:"version"`
+	ExportedAt    int64             `json:"exported_at"`
+	SourceOrg     string            `json:"source_org"`
+	SourceOrgName string            `json:"source_org_name"`
+	Counts        map[string]int    `json:"counts"`
+	Files         map[string]string `json:"files"`
+	SkippedFiles  []string          `json:"skipped_files"`
+}
+
+type CslExportUser struct {
+	Id        string   `json:"id"`
+	Username  string   `json:"username"`
+	Role      string   `json:"role"`
+	Roles     []string `json:"roles"`
+	Active    bool     `json:"active"`
+	LoginType string   `json:"login_type"`
+	Created   int64    `json:"created"`
+}
+
+type CslExportApps struct {
+	Apps        []CslBundleApp        `json:"apps"`
+	PrivateApps []shuffle.WorkflowApp `json:"private_apps"`
+	Auth        []CslBundleAuth       `json:"auth"`
+}
+
+func getOrgExportDir() string {
+	dir := os.Getenv("SHUFFLE_ORG_EXPORT_DIR")
+	if len(dir) == 0 {
+		dir = filepath.Join(os.TempDir(), "shuffle-org-exports")
+	}
+
+	return dir
+}
+
+func getOrgExportPath(orgId, exportId string) string {
+	return filepath.Join(getOrgExportDir(), fmt.Sprintf("%s_%s.tar.gz", orgId, exportId))
+}
+
+// Writes entries to the archive and keeps the sha256 of each for the manifest
+type orgExportWriter struct {
+	tar    *tar.Writer
+	hashes map[string]string
+}
+
+func (writer *orgExportWriter) add(name string, data []byte) error {
+	err := writer.tar.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.tar.Write(data)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256(data)
+	writer.hashes[name] = hex.EncodeToString(hash[:])
+	return nil
+}
+
+func (writer *orgExportWriter) addJson(name string, data interface{}) error {
+	marshalled, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writer.add(name, marshalled)
+}
+
+// Strips users and credentials from the org. Users are exported separately
+func sanitizeExportedOrg(org shuffle.Org) shuffle.Org {
+	org.Users = []shuffle.User{}
+	org.SSOConfig.OpenIdClientSecret = ""
+	org.SyncConfig.Apikey = ""
+	org.OrgAuth = shuffle.OrgAuth{}
+	return org
+}
+
+func getExportUsers(org shuffle.Org) []CslExportUser {
+	users := []CslExportUser{}
+	for _, user := range org.Users {
+		users = append(users, CslExportUser{
+			Id:        user.Id,
+			Username:  user.Username,
+			Role:      user.Role,
+			Roles:     user.Roles,
+			Active:    user.Active,
+			LoginType: user.LoginType,
+			Created:   user.CreationTime,
+		})
+	}
+
+	return users
+}
+
+func getExportApps(ctx context.Context, org shuffle.Org, workflows []shuffle.Workflow) CslExportApps {
+	exportApps := CslExportApps{
+		Apps:        []CslBundleApp{},
+		PrivateApps: []shuffle.WorkflowApp{},
+		Auth:        []CslBundleAuth{},
+	}
+
+	appKeys := map[string]bool{}
+	for _, workflow := range workflows {
+		for _, action := range workflow.Actions {
+			appKey := fmt.Sprintf("%s_%s", action.AppName, action.AppVersion)
+			if !appKeys[appKey] {
+				appKeys[appKey] = true
+				exportApps.Apps = append(exportApps.Apps, CslBundleApp{Id: action.AppID, Name: action.AppName, AppVersion: action.AppVersion})
+			}
+		}
+	}
+
+	apps, err := shuffle.GetPrioritizedApps(ctx, shuffle.User{Role: "admin", ActiveOrg: shuffle.OrgMini{Id: org.Id}})
+	if err != nil {
+		log.Printf("[WARNING] Failed getting apps of org %s for export: %s", org.Id, err)
+	}
+
+	for _, app := range apps {
+		if !app.Public && app.ReferenceOrg == org.Id {
+			exportApps.PrivateApps = append(exportApps.PrivateApps, app)
+		}
+	}
+
+	auths, err := shuffle.GetAllWorkflowAppAuth(ctx, org.Id)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting app auths of org %s for export: %s", org.Id, err)
+	}
+
+	for _, auth := range auths {
+		if auth.OrgId != org.Id {
+			continue
+		}
+
+		fields := []string{}
+		for _, field := range auth.Fields {
+			fields = append(fields, field.Key)
+		}
+
+		exportApps.Auth = append(exportApps.Auth, CslBundleAuth{Id: auth.Id, Label: auth.Label, AppName: auth.App.Name, Fields: fields})
+	}
+
+	return exportApps
+}
+
+// Adds every file of the org with its contents
+func addExportFiles(ctx context.Context, writer *orgExportWriter, orgId string, manifest *CslOrgExportManifest) error {
+	files, err := shuffle.GetAllFiles(ctx, orgId, "")
+	if err != nil {
+		return err
+	}
+
+	exported := []shuffle.File{}
+	for _, file := range files {
+		if file.OrgId != orgId || file.Status != "active" {
+			continue
+		}
+
+		if file.FileSize > MaxOrgExportFileSize {
+			manifest.SkippedFiles = append(manifest.SkippedFiles, file.Id)
+			continue
+		}
+
+		contents, err := shuffle.GetFileContent(ctx, &file, nil)
+		if err != nil {
+			log.Printf("[WARNING] Failed reading file %s of org %s for export: %s", file.Id, orgId, err)
+			manifest.SkippedFiles = append(manifest.SkippedFiles, file.Id)
+			continue
+		}
+
+		err = writer.add(fmt.Sprintf("files/%s", file.Id), contents)
+		if err != nil {
+			return err
+		}
+
+		exported = append(exported, file)
+	}
+
+	manifest.Counts["files"] = len(exported)
+	return writer.addJson("files.json", exported)
+}
+
+func writeOrgExportArchive(ctx context.Context, output io.Writer, orgId string) (CslOrgExportManifest, error) {
+	manifest := CslOrgExportManifest{
+		Version:      OrgExportFormatVersion,
+		ExportedAt:   time.Now().Unix(),
+		SourceOrg:    orgId,
+		Counts:       map[string]int{},
+		SkippedFiles: []string{},
+	}
+
+	org, err := shuffle.GetOrg(ctx, orgId)
+	if err != nil {
+		return manifest, err
+	}
+
+	manifest.SourceOrgName = org.Name
+
+	gzipWriter := gzip.NewWriter(output)
+	writer := &orgExportWriter{
+		tar:    tar.NewWriter(gzipWriter),
+		hashes: map[string]string{},
+	}
+
+	workflows, err := getOrgWorkflows(ctx, orgId)
+	if err != nil {
+		return manifest, err
+	}
+
+	schedules, err := shuffle.GetAllSchedules(ctx, orgId)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting schedules of org %s for export: %s", orgId, err)
+		schedules = []shuffle.ScheduleOld{}
+	}
+
+	statistics, err := shuffle.GetOrgStatistics(ctx, orgId)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting statistics of org %s for export: %s", orgId, err)
+		statistics = &shuffle.ExecutionInfo{}
+	}
+
+	users := getExportUsers(*org)
+	apps := getExportApps(ctx, *org, workflows)
+
+	manifest.Counts["workflows"] = len(workflows)
+	manifest.Counts["users"] = len(users)
+	manifest.Counts["apps"] = len(apps.Apps)
+	manifest.Counts["private_apps"] = len(apps.PrivateApps)
+	manifest.Counts["auth"] = len(apps.Auth)
+	manifest.Counts["schedules"] = len(schedules)
+
+	entries := []struct {
+		name string
+		data interface{}
+	}{
+		{"org.json", sanitizeExportedOrg(*org)},
+		{"users.json", users},
+		{"workflows.json", workflows},
+		{"apps.json", apps},
+		{"schedules.json", schedules},
+		{"statistics.json", statistics},
+	}
+
+	for _, entry := range entries {
+		err = writer.addJson(entry.name, entry.data)
+		if err != nil {
+			return manifest, err
+		}
+	}
+
+	err = addExportFiles(ctx, writer, orgId, &manifest)
+	if err != nil {
+		return manifest, err
+	}
+
+	documents := 0
+	for _, name := range orgExportDocuments {
+		document := json.RawMessage{}
+		found, err := getCslDocument(ctx, orgId, name, &document)
+		if err != nil || !found {
+			continue
+		}
+
+		err = writer.add(fmt.Sprintf("csl/%s.json", name), document)
+		if err != nil {
+			return manifest, err
+		}
+
+		documents += 1
+	}
+
+	manifest.Counts["csl_documents"] = documents
+	manifest.Files = writer.hashes
+
+	err = writer.addJson("manifest.json", manifest)
+	if err != nil {
+		return manifest, err
+	}
+
+	err = writer.tar.Close()
+	if err != nil {
+		return manifest, err
+	}
+
+	return manifest, gzipWriter.Close()
+}
+
+func updateOrgExport(ctx context.Context, orgId string, export CslOrgExport) error {
+	exports := CslOrgExports{}
+	return updateCslDocument(ctx, orgId, CslOrgExportsDocument, &exports, func() error {
+		for index, existing := range exports.Exports {
+			if existing.Id == export.Id {
+				exports.Exports[index] = export
+				return nil
+			}
+		}
+
+		exports.Exports = append(exports.Exports, export)
+		return nil
+	})
+}
+
+// Builds the archive, hashing and signing it while it's written
+func buildOrgExport(ctx context.Context, orgId string, export CslOrgExport) {
+	key, err := getBundleSigningKey()
+	if err == nil {
+		err = os.MkdirAll(getOrgExportDir(), 0700)
+	}
+
+	var file *os.File
+	if err == nil {
+		file, err = os.OpenFile(getOrgExportPath(orgId, export.Id), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	}
+
+	if err == nil {
+		hash := sha256.New()
+		mac := hmac.New(sha256.New, key)
+		counter := &countingWriter{}
+
+		var manifest CslOrgExportManifest
+		manifest, err = writeOrgExportArchive(ctx, io.MultiWriter(file, hash, mac, counter), orgId)
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+
+		export.Counts = manifest.Counts
+		export.Size = counter.written
+		export.Sha256 = hex.EncodeToString(hash.Sum(nil))
+		export.Signature = hex.EncodeToString(mac.Sum(nil))
+	}
+
+	export.Finished = time.Now().Unix()
+	if err != nil {
+		log.Printf("[ERROR] Failed exporting org %s: %s", orgId, err)
+		export.Status = OrgExportFailed
+		export.Error = err.Error()
+		os.Remove(getOrgExportPath(orgId, export.Id))
+	} else {
+		log.Printf("[INFO] Exported org %s to %s (%d bytes)", orgId, getOrgExportPath(orgId, export.Id), export.Size)
+		export.Status = OrgExportReady
+		export.Expires = export.Finished + OrgExportRetentionHours*60*60
+	}
+
+	err = updateOrgExport(ctx, orgId, export)
+	if err != nil {
+		log.Printf("[ERROR] Failed storing export %s of org %s: %s", export.Id, orgId, err)
+	}
+}
+
+type countingWriter struct {
+	written int64
+}
+
+func (writer *countingWriter) Write(data []byte) (int, error) {
+	writer.written += int64(len(data))
+	return len(data), nil
+}
+
+// Job: removes archives past their expiry
+func runCslOrgExportCleanupJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for org export cleanup: %s", err)
+		return
+	}
+
+	timeNow := time.Now().Unix()
+	for _, org := range orgs {
+		exports := CslOrgExports{}
+		found, err := getCslDocument(ctx, org.Id, CslOrgExportsDocument, &exports)
+		if err != nil || !found || len(exports.Exports) == 0 {
+			continue
+		}
+
+		err = updateCslDocument(ctx, org.Id, CslOrgExportsDocument, &exports, func() error {
+			kept := []CslOrgExport{}
+			for _, export := range exports.Exports {
+				expired := export.Expires > 0 && export.Expires < timeNow
+				failed := export.Status == OrgExportFailed && export.Finished < timeNow-OrgExportRetentionHours*60*60
+				if expired || failed {
+					os.Remove(getOrgExportPath(org.Id, export.Id))
+					continue
+				}
+
+				kept = append(kept, export)
+			}
+
+			exports.Exports = kept
+			return nil
+		})
+		if err != nil {
+			log.Printf("[ERROR] Failed cleaning up exports of org %s: %s", org.Id, err)
+		}
+	}
+}
+
+/*
+Org export:
+Starts exporting the whole org as a signed archive. The archive is built in
+the background. Follow its status with GET /api/v1/csl/orgExport, and
+download it from /api/v1/csl/orgExport/download?id=<id> once it's ready.
+Requires SHUFFLE_BUNDLE_SIGNING_KEY.
+
+	{
+	    "success": true,
+	    "data": {
+	        "id": "...",
+	        "status": "building",
+	        "requested_by": "admin@example.com",
+	        "created": 1718000000
+	    }
+	}
+*/
+func cslStartOrgExport(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	_, err := getBundleSigningKey()
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	export := CslOrgExport{
+		Id:          uuid.NewV4().String(),
+		Status:      OrgExportBuilding,
+		RequestedBy: user.Username,
+		Created:     time.Now().Unix(),
+		Algorithm:   BundleSignatureAlgorithm,
+		Counts:      map[string]int{},
+	}
+
+	exports := CslOrgExports{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslOrgExportsDocument, &exports, func() error {
+		for _, existing := range exports.Exports {
+			if existing.Status == OrgExportBuilding {
+				return errors.New(fmt.Sprintf("export %s is already being built", existing.Id))
+			}
+		}
+
+		exports.Exports = append(exports.Exports, export)
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(409)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	orgId := user.ActiveOrg.Id
+	go buildOrgExport(context.Background(), orgId, export)
+
+	log.Printf("[AUDIT] User %s (%s) started export %s of org %s", user.Username, user.Id, export.Id, orgId)
+	recordCslActivity(ctx, orgId, ActivityTypeAdmin, "org_exported", "The org was exported", user.Username, export.Id)
+
+	res := CslResponse{
+		Success: true,
+		Data:    export,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslStartOrgExport")
+}
+
+/*
+Org export:
+Returns the exports of the org, newest first. signature is the hmac-sha256
+of the archive with SHUFFLE_BUNDLE_SIGNING_KEY.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "id": "...",
+	            "status": "ready",
+	            "requested_by": "admin@example.com",
+	            "created": 1718000000,
+	            "finished": 1718000030,
+	            "expires": 1718086430,
+	            "size": 1048576,
+	            "sha256": "...",
+	            "algorithm": "hmac-sha256",
+	            "signature": "...",
+	            "counts": {
+	                "workflows": 24,
+	                "users": 6,
+	                "files": 12
+	            }
+	        }
+	    ]
+	}
+*/
+func cslGetOrgExports(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	stored := CslOrgExports{}
+	_, err := getCslDocument(ctx, user.ActiveOrg.Id, CslOrgExportsDocument, &stored)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	exports := []CslOrgExport{}
+	for index := len(stored.Exports) - 1; index >= 0; index-- {
+		exports = append(exports, stored.Exports[index])
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    exports,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetOrgExports")
+}
+
+/*
+Org export:
+Downloads the archive of export ?id=. Range requests are supported, so an
+interrupted download can be resumed with Range and If-Range set to the
+ETag. The signature is in the X-Shuffle-Signature header.
+*/
+func cslDownloadOrgExport(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	exportId := request.URL.Query().Get("id")
+
+	stored := CslOrgExports{}
+	_, err := getCslDocument(ctx, user.ActiveOrg.Id, CslOrgExportsDocument, &stored)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	export := CslOrgExport{}
+	for _, existing := range stored.Exports {
+		if existing.Id == exportId {
+			export = existing
+			break
+		}
+	}
+
+	if len(export.Id) == 0 {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("export %s not found", exportId))))
+		return
+	}
+
+	if export.Status != OrgExportReady {
+		resp.WriteHeader(409)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("export %s is %s", export.Id, export.Status))))
+		return
+	}
+
+	file, err := os.Open(getOrgExportPath(user.ActiveOrg.Id, export.Id))
+	if err != nil {
+		// Archives are stored locally, so another backend replica may have built it
+		log.Printf("[WARNING] Failed opening export %s of org %s: %s", export.Id, user.ActiveOrg.Id, err)
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New("the archive isn't available on this backend")))
+		return
+	}
+
+	defer file.Close()
+
+	// Logged once per download, not for every resumed range
+	if len(request.Header.Get("Range")) == 0 {
+		log.Printf("[AUDIT] User %s (%s) downloaded export %s of org %s", user.Username, user.Id, export.Id, user.ActiveOrg.Id)
+	}
+
+	filename := fmt.Sprintf("%s_%s.tar.gz", strings.ReplaceAll(strings.ToLower(user.ActiveOrg.Name), " ", "_"), time.Unix(export.Finished, 0).UTC().Format("20060102"))
+	resp.Header().Set("Content-Type", "application/gzip")
+	resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	resp.Header().Set("ETag", fmt.Sprintf("%q", export.Sha256))
+	resp.Header().Set("X-Shuffle-Signature", export.Signature)
+	resp.Header().Set("X-Shuffle-Signature-Algorithm", export.Algorithm)
+
+	http.ServeContent(resp, request, filename, time.Unix(export.Finished, 0), file)
+}
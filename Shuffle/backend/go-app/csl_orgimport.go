@@ -0,0 +1,817 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Restores an org export (csl_orgexport.go) into the active org, or into a
+// new sub-org of it. Everything in the archive is checked against the
+// signature and the manifest before anything is written. Existing items
+// with the same id or name are conflicts, resolved the same way for the
+// whole import:
+//
+//	skip       keep the existing item (default)
+//	overwrite  replace the existing item with the exported one
+//	rename     import the exported item next to the existing one
+//
+// Ids already used elsewhere on the instance are remapped, and file ids
+// always are. Credentials and passwords are never exported, so app auth
+// and users not on this instance are listed in the report instead.
+
+// Largest archive accepted, compressed and uncompressed
+const MaxOrgImportSize = 1024 * 1024 * 1024
+
+// Org import conflict resolutions
+const (
+	OrgImportSkip      = "skip"
+	OrgImportOverwrite = "overwrite"
+	OrgImportRename    = "rename"
+)
+
+// Orgs with an import running
+var orgImports = struct {
+	sync.Mutex
+	running map[string]bool
+}{running: map[string]bool{}}
+
+type CslOrgImportConflict struct {
+	Type       string `json:"type"`
+	SourceId   string `json:"source_id"`
+	ExistingId string `json:"existing_id"`
+	Name       string `json:"name"`
+	Resolution string `json:"resolution"`
+}
+
+type CslOrgImportRemap struct {
+	Type     string `json:"type"`
+	SourceId string `json:"source_id"`
+	Id       string `json:"id"`
+}
+
+type CslOrgImportReport struct {
+	DryRun        bool                   `json:"dry_run"`
+	Resolution    string                 `json:"resolution"`
+	SourceOrg     string                 `json:"source_org"`
+	SourceOrgName string                 `json:"source_org_name"`
+	ExportedAt    int64                  `json:"exported_at"`
+	OrgId         string                 `json:"org_id"`
+	CreatedOrg    bool                   `json:"created_org"`
+	Restored      map[string]int         `json:"restored"`
+	Conflicts     []CslOrgImportConflict `json:"conflicts"`
+	Remapped      []CslOrgImportRemap    `json:"remapped"`
+	MissingApps   []CslBundleApp         `json:"missing_apps"`
+	MissingAuth   []CslBundleAuth        `json:"missing_auth"`
+	MissingUsers  []string               `json:"missing_users"`
+	Warnings      []string               `json:"warnings"`
+}
+
+type orgImport struct {
+	ctx    context.Context
+	user   shuffle.User
+	orgId  string
+	newOrg bool
+	dryRun bool
+
+	resolution string
+	entries    map[string][]byte
+	report     *CslOrgImportReport
+}
+
+// Reads every entry of the archive, and checks them against the manifest
+func readOrgExportArchive(data []byte) (map[string][]byte, CslOrgExportManifest, error) {
+	manifest := CslOrgExportManifest{}
+	entries := map[string][]byte{}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return entries, manifest, err
+	}
+
+	total := int64(0)
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return entries, manifest, err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		total += header.Size
+		if total > MaxOrgImportSize {
+			return entries, manifest, errors.New("the archive is too large")
+		}
+
+		contents, err := ioutil.ReadAll(io.LimitReader(tarReader, header.Size))
+		if err != nil {
+			return entries, manifest, err
+		}
+
+		entries[header.Name] = contents
+	}
+
+	err = json.Unmarshal(entries["manifest.json"], &manifest)
+	if err != nil {
+		return entries, manifest, errors.New("the archive has no valid manifest")
+	}
+
+	if manifest.Version != OrgExportFormatVersion {
+		return entries, manifest, errors.New(fmt.Sprintf("unsupported export version %d", manifest.Version))
+	}
+
+	for name, expected := range manifest.Files {
+		contents, ok := entries[name]
+		if !ok {
+			return entries, manifest, errors.New(fmt.Sprintf("%s is missing from the archive", name))
+		}
+
+		hash := sha256.Sum256(contents)
+		if hex.EncodeToString(hash[:]) != expected {
+			return entries, manifest, errors.New(fmt.Sprintf("%s doesn't match the manifest", name))
+		}
+	}
+
+	for name := range entries {
+		if _, ok := manifest.Files[name]; !ok && name != "manifest.json" {
+			return entries, manifest, errors.New(fmt.Sprintf("%s isn't listed in the manifest", name))
+		}
+	}
+
+	return entries, manifest, nil
+}
+
+func (imp *orgImport) readEntry(name string, data interface{}) error {
+	contents, ok := imp.entries[name]
+	if !ok {
+		return nil
+	}
+
+	err := json.Unmarshal(contents, data)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed reading %s: %s", name, err))
+	}
+
+	return nil
+}
+
+// Records a conflict and returns how it's resolved. Items that can't exist
+// twice are skipped instead of renamed
+func (imp *orgImport) conflict(itemType, sourceId, existingId, name string, renamable bool) string {
+	resolution := imp.resolution
+	if resolution == OrgImportRename && !renamable {
+		resolution = OrgImportSkip
+	}
+
+	imp.report.Conflicts = append(imp.report.Conflicts, CslOrgImportConflict{
+		Type:       itemType,
+		SourceId:   sourceId,
+		ExistingId: existingId,
+		Name:       name,
+		Resolution: resolution,
+	})
+
+	return resolution
+}
+
+func (imp *orgImport) remap(itemType, sourceId, id string) {
+	if sourceId != id {
+		imp.report.Remapped = append(imp.report.Remapped, CslOrgImportRemap{Type: itemType, SourceId: sourceId, Id: id})
+	}
+}
+
+func (imp *orgImport) restored(itemType string) {
+	imp.report.Restored[itemType] += 1
+}
+
+// Creates the sub-org an import goes into, the same way sub-orgs are
+// created in the UI, with the importing user as its admin
+func createCslImportOrg(ctx context.Context, user *shuffle.User, name string, exported shuffle.Org) (*shuffle.Org, error) {
+	parent, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := exported.Roles
+	if len(roles) == 0 {
+		roles = []string{"admin", "user"}
+	}
+
+	orgUser := *user
+	orgUser.Role = "admin"
+
+	org := shuffle.Org{
+		Id:          uuid.NewV4().String(),
+		Name:        name,
+		Org:         name,
+		Description: exported.Description,
+		Image:       exported.Image,
+		Users:       []shuffle.User{orgUser},
+		Roles:       roles,
+		CreatorOrg:  parent.Id,
+		ManagerOrgs: []shuffle.OrgMini{shuffle.OrgMini{Id: parent.Id, Name: parent.Name}},
+		Region:      parent.Region,
+		RegionUrl:   parent.RegionUrl,
+		Defaults:    exported.Defaults,
+	}
+
+	err = shuffle.SetOrg(ctx, org, org.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	parent.ChildOrgs = append(parent.ChildOrgs, shuffle.OrgMini{Id: org.Id, Name: org.Name})
+	shuffle.DeleteCache(ctx, fmt.Sprintf("%s_childorgs", parent.Id))
+	err = shuffle.SetOrg(ctx, *parent, parent.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	storedUser, err := shuffle.GetUser(ctx, user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &org, addCslOrgMember(ctx, &org, storedUser, "admin")
+}
+
+// Merges the redaction rules of the exported org
+func (imp *orgImport) importOrgSettings(exported shuffle.Org) error {
+	if len(exported.RedactionRules) == 0 {
+		return nil
+	}
+
+	org := &shuffle.Org{}
+	if !imp.newOrg || !imp.dryRun {
+		var err error
+		org, err = shuffle.GetOrg(imp.ctx, imp.orgId)
+		if err != nil {
+			return err
+		}
+	}
+
+	changed := false
+	for _, rule := range exported.RedactionRules {
+		existingIndex := -1
+		for index, existing := range org.RedactionRules {
+			if existing.Id == rule.Id {
+				existingIndex = index
+				break
+			}
+		}
+
+		if existingIndex >= 0 {
+			switch imp.conflict("redaction_rule", rule.Id, rule.Id, rule.Name, true) {
+			case OrgImportSkip:
+				continue
+			case OrgImportOverwrite:
+				org.RedactionRules[existingIndex] = rule
+			case OrgImportRename:
+				rule.Id = uuid.NewV4().String()
+				imp.remap("redaction_rule", org.RedactionRules[existingIndex].Id, rule.Id)
+				org.RedactionRules = append(org.RedactionRules, rule)
+			}
+		} else {
+			org.RedactionRules = append(org.RedactionRules, rule)
+		}
+
+		changed = true
+		imp.restored("redaction_rules")
+	}
+
+	if !changed || imp.dryRun {
+		return nil
+	}
+
+	return shuffle.SetOrg(imp.ctx, *org, org.Id)
+}
+
+// Matches the exported users with the members of the org. Users who aren't
+// members are listed as missing instead of being added, as an import can't
+// pull users of other orgs into the org. Roles of members are kept
+func (imp *orgImport) importUsers(users []CslExportUser) {
+	org := &shuffle.Org{}
+	if !imp.newOrg || !imp.dryRun {
+		var err error
+		org, err = shuffle.GetOrg(imp.ctx, imp.orgId)
+		if err != nil {
+			imp.report.Warnings = append(imp.report.Warnings, fmt.Sprintf("failed getting the users of the org: %s", err))
+			return
+		}
+	}
+
+	for _, exported := range users {
+		member := false
+		for _, orgUser := range org.Users {
+			if orgUser.Id == exported.Id || strings.EqualFold(orgUser.Username, exported.Username) {
+				member = true
+				if orgUser.Role != exported.Role {
+					imp.conflict("user_role", exported.Id, orgUser.Id, exported.Username, false)
+				}
+
+				break
+			}
+		}
+
+		if !member {
+			imp.report.MissingUsers = append(imp.report.MissingUsers, exported.Username)
+		}
+	}
+}
+
+// Restores private apps missing on this instance, and maps the exported
+// auth to auth with the same label in the org
+func (imp *orgImport) importApps(apps CslExportApps) map[string]string {
+	restoredApps := map[string]bool{}
+	for _, app := range apps.PrivateApps {
+		existing, err := shuffle.GetApp(imp.ctx, app.ID, imp.user, false)
+		if err == nil && len(existing.ID) > 0 {
+			continue
+		}
+
+		restoredApps[app.ID] = true
+		imp.restored("apps")
+		if imp.dryRun {
+			continue
+		}
+
+		app.ReferenceOrg = imp.orgId
+		app.Public = false
+		err = shuffle.SetWorkflowAppDatastore(imp.ctx, app, app.ID)
+		if err != nil {
+			imp.report.Warnings = append(imp.report.Warnings, fmt.Sprintf("failed restoring app %s: %s", app.Name, err))
+		}
+	}
+
+	for _, app := range findMissingApps(imp.ctx, imp.user, apps.Apps) {
+		if !restoredApps[app.Id] {
+			imp.report.MissingApps = append(imp.report.MissingApps, app)
+		}
+	}
+
+	if imp.newOrg {
+		imp.report.MissingAuth = apps.Auth
+		return map[string]string{}
+	}
+
+	authMapping, missingAuth := mapBundleAuth(imp.ctx, imp.orgId, apps.Auth)
+	imp.report.MissingAuth = missingAuth
+	return authMapping
+}
+
+// Restores workflows, keeping their ids when they're free. Returns the ids
+// of the exported workflows in the org
+func (imp *orgImport) importWorkflows(workflows []shuffle.Workflow, authMapping map[string]string) (map[string]string, error) {
+	existingWorkflows := []shuffle.Workflow{}
+	if !imp.newOrg {
+		var err error
+		existingWorkflows, err = getOrgWorkflows(imp.ctx, imp.orgId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	workflowMapping := map[string]string{}
+	imported := []shuffle.Workflow{}
+	for _, workflow := range workflows {
+		existingId := ""
+		for _, existing := range existingWorkflows {
+			if existing.ID == workflow.ID || strings.EqualFold(existing.Name, workflow.Name) {
+				existingId = existing.ID
+				break
+			}
+		}
+
+		if len(existingId) > 0 {
+			switch imp.conflict("workflow", workflow.ID, existingId, workflow.Name, true) {
+			case OrgImportSkip:
+				workflowMapping[workflow.ID] = existingId
+				continue
+			case OrgImportOverwrite:
+				workflowMapping[workflow.ID] = existingId
+			case OrgImportRename:
+				workflowMapping[workflow.ID] = uuid.NewV4().String()
+				workflow.Name = fmt.Sprintf("%s (restored)", workflow.Name)
+			}
+		} else if other, err := shuffle.GetWorkflow(imp.ctx, workflow.ID); err == nil && len(other.ID) > 0 {
+			// Used by another org on this instance
+			workflowMapping[workflow.ID] = uuid.NewV4().String()
+		} else {
+			workflowMapping[workflow.ID] = workflow.ID
+		}
+
+		imp.remap("workflow", workflow.ID, workflowMapping[workflow.ID])
+		imported = append(imported, workflow)
+	}
+
+	// Mapped after every id is known, so subflows point to the restored workflows
+	for _, workflow := range imported {
+		restored := prepareImportedWorkflow(workflow, imp.user, workflowMapping, authMapping)
+		imp.restored("workflows")
+		if imp.dryRun {
+			continue
+		}
+
+		err := shuffle.SetWorkflow(imp.ctx, restored, restored.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(imported) > 0 {
+		imp.report.Warnings = append(imp.report.Warnings, "webhooks and schedules of restored workflows are stopped, and have to be started again")
+	}
+
+	return workflowMapping, nil
+}
+
+// Restores files as new files of the org. Files with the same name and
+// namespace in the org are conflicts
+func (imp *orgImport) importFiles(files []shuffle.File, workflowMapping map[string]string) {
+	existingFiles := []shuffle.File{}
+	if !imp.newOrg {
+		var err error
+		existingFiles, err = shuffle.GetAllFiles(imp.ctx, imp.orgId, "")
+		if err != nil {
+			imp.report.Warnings = append(imp.report.Warnings, fmt.Sprintf("failed getting the files of the org: %s", err))
+			return
+		}
+	}
+
+	for _, exported := range files {
+		contents, ok := imp.entries[fmt.Sprintf("files/%s", exported.Id)]
+		if !ok {
+			continue
+		}
+
+		var existing *shuffle.File
+		for index, file := range existingFiles {
+			if file.OrgId == imp.orgId && file.Status == "active" && file.Filename == exported.Filename && file.Namespace == exported.Namespace {
+				existing = &existingFiles[index]
+				break
+			}
+		}
+
+		filename := exported.Filename
+		if existing != nil {
+			switch imp.conflict("file", exported.Id, existing.Id, exported.Filename, true) {
+			case OrgImportSkip:
+				continue
+			case OrgImportRename:
+				filename = fmt.Sprintf("restored_%s", filename)
+			}
+		}
+
+		imp.restored("files")
+		if imp.dryRun {
+			continue
+		}
+
+		if existing != nil && imp.resolution == OrgImportOverwrite {
+			err := shuffle.DeleteOrgFile(imp.ctx, existing)
+			if err != nil {
+				imp.report.Warnings = append(imp.report.Warnings, fmt.Sprintf("failed replacing file %s: %s", existing.Filename, err))
+				continue
+			}
+		}
+
+		file := shuffle.File{
+			Filename:    filename,
+			OrgId:       imp.orgId,
+			WorkflowId:  workflowMapping[exported.WorkflowId],
+			Namespace:   exported.Namespace,
+			Tags:        exported.Tags,
+			Description: exported.Description,
+			ContentType: exported.ContentType,
+			CreatedBy:   imp.user.Username,
+		}
+
+		fileId, err := shuffle.UploadOrgFile(imp.ctx, &file, contents)
+		if err != nil {
+			imp.report.Warnings = append(imp.report.Warnings, fmt.Sprintf("failed restoring file %s: %s", exported.Filename, err))
+			continue
+		}
+
+		imp.remap("file", exported.Id, fileId)
+	}
+}
+
+// Restores the configuration documents of the org
+func (imp *orgImport) importDocuments() error {
+	for _, name := range orgExportDocuments {
+		contents, ok := imp.entries[fmt.Sprintf("csl/%s.json", name)]
+		if !ok {
+			continue
+		}
+
+		if !imp.newOrg {
+			existing := json.RawMessage{}
+			found, err := getCslDocument(imp.ctx, imp.orgId, name, &existing)
+			if err != nil {
+				return err
+			}
+
+			if found && !bytes.Equal(existing, contents) && imp.conflict("configuration", name, name, name, false) == OrgImportSkip {
+				continue
+			}
+		}
+
+		imp.restored("configuration")
+		if imp.dryRun {
+			continue
+		}
+
+		err := setCslDocument(imp.ctx, imp.orgId, name, json.RawMessage(contents))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (imp *orgImport) run() error {
+	exportedOrg := shuffle.Org{}
+	users := []CslExportUser{}
+	workflows := []shuffle.Workflow{}
+	apps := CslExportApps{}
+	files := []shuffle.File{}
+
+	reads := []struct {
+		name string
+		data interface{}
+	}{
+		{"org.json", &exportedOrg},
+		{"users.json", &users},
+		{"workflows.json", &workflows},
+		{"apps.json", &apps},
+		{"files.json", &files},
+	}
+
+	for _, read := range reads {
+		err := imp.readEntry(read.name, read.data)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := imp.importOrgSettings(exportedOrg)
+	if err != nil {
+		return err
+	}
+
+	imp.importUsers(users)
+	authMapping := imp.importApps(apps)
+
+	workflowMapping, err := imp.importWorkflows(workflows, authMapping)
+	if err != nil {
+		return err
+	}
+
+	imp.importFiles(files, workflowMapping)
+
+	schedules := []json.RawMessage{}
+	imp.readEntry("schedules.json", &schedules)
+	if len(schedules) > 0 {
+		imp.report.Warnings = append(imp.report.Warnings, fmt.Sprintf("%d schedules were not restored. Start the schedule triggers of the restored workflows to create them again", len(schedules)))
+	}
+
+	return imp.importDocuments()
+}
+
+/*
+Org import:
+Restores an org export. The body is the archive from
+/api/v1/csl/orgExport/download, and the X-Shuffle-Signature header its
+signature from the export. Options are query parameters:
+
+	dry_run=true       validate and report without changing anything
+	conflicts=<mode>   skip (default), overwrite or rename
+	new_org=<name>     restore into a new sub-org of the active org
+
+Statistics are not restored. Users aren't added to the org: exported users
+who aren't members are listed in missing_users and have to be invited.
+
+	{
+	    "success": true,
+	    "data": {
+	        "dry_run": true,
+	        "resolution": "skip",
+	        "source_org": "...",
+	        "source_org_name": "SOC",
+	        "exported_at": 1718000000,
+	        "org_id": "...",
+	        "created_org": false,
+	        "restored": {
+	            "workflows": 20,
+	            "files": 12,
+	            "configuration": 6
+	        },
+	        "conflicts": [
+	            {
+	                "type": "workflow",
+	                "source_id": "...",
+	                "existing_id": "...",
+	                "name": "Phishing triage",
+	                "resolution": "skip"
+	            }
+	        ],
+	        "remapped": [
+	            {
+	                "type": "file",
+	                "source_id": "file_...",
+	                "id": "file_..."
+	            }
+	        ],
+	        "missing_apps": [],
+	        "missing_auth": [
+	            {
+	                "id": "...",
+	                "label": "Jira prod",
+	                "app_name": "Jira",
+	                "fields": ["username", "apikey"]
+	            }
+	        ],
+	        "missing_users": ["analyst@example.com"],
+	        "warnings": []
+	    }
+	}
+*/
+func cslImportOrg(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	resolution := strings.ToLower(query.Get("conflicts"))
+	if len(resolution) == 0 {
+		resolution = OrgImportSkip
+	}
+
+	if resolution != OrgImportSkip && resolution != OrgImportOverwrite && resolution != OrgImportRename {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("conflicts must be skip, overwrite or rename")))
+		return
+	}
+
+	newOrgName := strings.TrimSpace(query.Get("new_org"))
+	if len(newOrgName) > 100 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("new_org can be at most 100 characters")))
+		return
+	}
+
+	algorithm := request.Header.Get("X-Shuffle-Signature-Algorithm")
+	if len(algorithm) > 0 && algorithm != BundleSignatureAlgorithm {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("unsupported signature algorithm %s", algorithm))))
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(resp, request.Body, MaxOrgImportSize))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	expected, err := signBundle(body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(request.Header.Get("X-Shuffle-Signature")))) {
+		log.Printf("[WARNING] User %s (%s) tried importing an org export that failed verification", user.Username, user.Id)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("invalid export signature")))
+		return
+	}
+
+	entries, manifest, err := readOrgExportArchive(body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	dryRun := query.Get("dry_run") == "true"
+	lockId := user.ActiveOrg.Id
+	if len(newOrgName) > 0 {
+		lockId = fmt.Sprintf("%s_%s", user.ActiveOrg.Id, newOrgName)
+	}
+
+	orgImports.Lock()
+	if orgImports.running[lockId] {
+		orgImports.Unlock()
+		resp.WriteHeader(409)
+		resp.Write(createCslErrorResponse(errors.New("an import into this org is already running")))
+		return
+	}
+
+	orgImports.running[lockId] = true
+	orgImports.Unlock()
+
+	defer func() {
+		orgImports.Lock()
+		delete(orgImports.running, lockId)
+		orgImports.Unlock()
+	}()
+
+	report := CslOrgImportReport{
+		DryRun:        dryRun,
+		Resolution:    resolution,
+		SourceOrg:     manifest.SourceOrg,
+		SourceOrgName: manifest.SourceOrgName,
+		ExportedAt:    manifest.ExportedAt,
+		OrgId:         user.ActiveOrg.Id,
+		Restored:      map[string]int{},
+		Conflicts:     []CslOrgImportConflict{},
+		Remapped:      []CslOrgImportRemap{},
+		MissingApps:   []CslBundleApp{},
+		MissingAuth:   []CslBundleAuth{},
+		MissingUsers:  []string{},
+		Warnings:      []string{},
+	}
+
+	imp := &orgImport{
+		ctx:        ctx,
+		user:       *user,
+		orgId:      user.ActiveOrg.Id,
+		newOrg:     len(newOrgName) > 0,
+		dryRun:     dryRun,
+		resolution: resolution,
+		entries:    entries,
+		report:     &report,
+	}
+
+	if imp.newOrg {
+		report.OrgId = ""
+		if !dryRun {
+			exportedOrg := shuffle.Org{}
+			imp.readEntry("org.json", &exportedOrg)
+
+			org, err := createCslImportOrg(ctx, user, newOrgName, exportedOrg)
+			if err != nil {
+				log.Printf("[ERROR] Failed creating org %s for import: %s", newOrgName, err)
+				resp.WriteHeader(500)
+				resp.Write(createCslErrorResponse(err))
+				return
+			}
+
+			imp.orgId = org.Id
+			report.OrgId = org.Id
+			report.CreatedOrg = true
+		}
+
+		imp.user.ActiveOrg = shuffle.OrgMini{Id: imp.orgId, Name: newOrgName, Role: "admin"}
+	}
+
+	err = imp.run()
+	if err != nil {
+		log.Printf("[ERROR] Failed importing export of org %s into org %s: %s", manifest.SourceOrg, imp.orgId, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if !dryRun {
+		log.Printf("[AUDIT] User %s (%s) imported the export of org %s from %s into org %s with %d conflicts resolved as %s", user.Username, user.Id, manifest.SourceOrg, time.Unix(manifest.ExportedAt, 0).UTC().Format(time.RFC3339), imp.orgId, len(report.Conflicts), resolution)
+		recordCslActivity(ctx, imp.orgId, ActivityTypeAdmin, "org_imported", fmt.Sprintf("An export of %s was imported", manifest.SourceOrgName), user.Username, manifest.SourceOrg)
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    report,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslImportOrg")
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Sub-org hierarchy. Shuffle only creates sub-orgs one level below a parent,
+// so deeper levels are made by attaching an existing sub-org to another one.
+// Parents can include the statistics of their sub-orgs in the dashboard endpoints
+
+// Levels of sub-orgs below an org, as a guard against broken hierarchies
+const MaxOrgHierarchyDepth = 5
+
+type CslOrgNode struct {
+	Id                 string       `json:"id"`
+	Name               string       `json:"name"`
+	ParentId           string       `json:"parent_id"`
+	Depth              int          `json:"depth"`
+	Workflows          int          `json:"workflows"`
+	WorkflowExecutions int64        `json:"workflow_executions"`
+	ExecutionsFailed   int64        `json:"workflow_executions_failed"`
+	MonthlyApiUsage    int64        `json:"monthly_api_usage"`
+	RolledUpExecutions int64        `json:"rolled_up_workflow_executions"`
+	RolledUpApiUsage   int64        `json:"rolled_up_monthly_api_usage"`
+	RolledUpWorkflows  int          `json:"rolled_up_workflows"`
+	Children           []CslOrgNode `json:"children"`
+}
+
+// Returns the sub-orgs below an org, parents before their children
+func getCslDescendantOrgs(ctx context.Context, orgId string) ([]CslOrgNode, error) {
+	descendants := []CslOrgNode{}
+	visited := map[string]bool{orgId: true}
+
+	parents := []string{orgId}
+	for depth := 1; depth <= MaxOrgHierarchyDepth && len(parents) > 0; depth++ {
+		children := []string{}
+		for _, parentId := range parents {
+			parent, err := shuffle.GetOrg(ctx, parentId)
+			if err != nil {
+				if parentId == orgId {
+					return nil, err
+				}
+
+				log.Printf("[WARNING] Failed getting sub-org %s: %s", parentId, err)
+				continue
+			}
+
+			for _, child := range parent.ChildOrgs {
+				if len(child.Id) == 0 || visited[child.Id] {
+					continue
+				}
+
+				visited[child.Id] = true
+				children = append(children, child.Id)
+				descendants = append(descendants, CslOrgNode{
+					Id:       child.Id,
+					Name:     child.Name,
+					ParentId: parentId,
+					Depth:    depth,
+				})
+			}
+		}
+
+		parents = children
+	}
+
+	return descendants, nil
+}
+
+// Returns the sub-orgs a request includes with ?include_children=true, or
+// the ones listed in ?child_org_ids=<id>,<id> together with their own
+// sub-orgs. Including sub-orgs requires admin of the active org. Writes the
+// error response and returns false on errors
+func handleCslChildOrgsRequest(resp http.ResponseWriter, request *http.Request, user *shuffle.User) ([]string, bool) {
+	query := request.URL.Query()
+	childOrgIds := []string{}
+	for _, childOrgId := range strings.Split(query.Get("child_org_ids"), ",") {
+		if len(strings.TrimSpace(childOrgId)) > 0 {
+			childOrgIds = append(childOrgIds, strings.TrimSpace(childOrgId))
+		}
+	}
+
+	if query.Get("include_children") != "true" && len(childOrgIds) == 0 {
+		return []string{}, true
+	}
+
+	ctx := shuffle.GetContext(request)
+	err := checkUserOrgAdmin(ctx, *user)
+	if err != nil {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("including sub-orgs requires admin of the organization")))
+		return nil, false
+	}
+
+	descendants, err := getCslDescendantOrgs(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return nil, false
+	}
+
+	if len(childOrgIds) == 0 {
+		orgIds := []string{}
+		for _, descendant := range descendants {
+			orgIds = append(orgIds, descendant.Id)
+		}
+
+		return orgIds, true
+	}
+
+	// Selected sub-orgs include everything below them
+	selected := map[string]bool{}
+	for _, childOrgId := range childOrgIds {
+		selected[childOrgId] = true
+	}
+
+	orgIds := []string{}
+	found := map[string]bool{}
+	for _, descendant := range descendants {
+		if selected[descendant.Id] || selected[descendant.ParentId] {
+			selected[descendant.Id] = true
+			found[descendant.Id] = true
+			orgIds = append(orgIds, descendant.Id)
+		}
+	}
+
+	for _, childOrgId := range childOrgIds {
+		if !found[childOrgId] {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("org %s isn't a sub-org of the organization", childOrgId))))
+			return nil, false
+		}
+	}
+
+	return orgIds, true
+}
+
+// Adds every int64 field of other to total
+func addInt64Fields(total, other reflect.Value) {
+	for i := 0; i < total.NumField(); i++ {
+		if total.Field(i).Kind() == reflect.Int64 {
+			total.Field(i).SetInt(total.Field(i).Int() + other.Field(i).Int())
+		}
+	}
+}
+
+func addAdditions(total []shuffle.AdditionalUseConfig, other []shuffle.AdditionalUseConfig) []shuffle.AdditionalUseConfig {
+	for _, addition := range other {
+		found := false
+		for i := range total {
+			if total[i].Key == addition.Key {
+				total[i].Value += addition.Value
+				total[i].DailyValue += addition.DailyValue
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			total = append(total, addition)
+		}
+	}
+
+	return total
+}
+
+// Adds the statistics of a sub-org to the statistics of its parent. Daily
+// statistics are matched by how many days ago they are, as sub-orgs can roll
+// over days in another timezone
+func addOrgStatistics(total *shuffle.ExecutionInfo, other *shuffle.ExecutionInfo) {
+	addInt64Fields(reflect.ValueOf(total).Elem(), reflect.ValueOf(other).Elem())
+	total.Additions = addAdditions(total.Additions, other.Additions)
+
+	if missing := len(other.DailyStatistics) - len(total.DailyStatistics); missing > 0 {
+		padding := make([]shuffle.DailyStatistics, missing)
+		for i := range padding {
+			padding[i].Date = other.DailyStatistics[i].Date
+		}
+
+		total.DailyStatistics = append(padding, total.DailyStatistics...)
+	}
+
+	offset := len(total.DailyStatistics) - len(other.DailyStatistics)
+	for i, dayStats := range other.DailyStatistics {
+		totalDay := &total.DailyStatistics[offset+i]
+		addInt64Fields(reflect.ValueOf(totalDay).Elem(), reflect.ValueOf(&dayStats).Elem())
+		totalDay.Additions = addAdditions(totalDay.Additions, dayStats.Additions)
+	}
+}
+
+// Returns the org statistics with the statistics of the sub-orgs added
+func getRolledUpOrgStatistics(ctx context.Context, orgStats *shuffle.ExecutionInfo, childOrgIds []string) (*shuffle.ExecutionInfo, error) {
+	childStats := make([]*shuffle.ExecutionInfo, len(childOrgIds))
+	err := runConcurrentLookups(ctx, len(childOrgIds), func(ctx context.Context, index int) error {
+		stats, err := shuffle.GetOrgStatistics(ctx, childOrgIds[index])
+		if err != nil {
+			log.Printf("[WARNING] Failed getting stats for sub-org %s: %s", childOrgIds[index], err)
+			return nil
+		}
+
+		childStats[index] = stats
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Copied so cached statistics aren't changed
+	rolledUp := *orgStats
+	rolledUp.DailyStatistics = append([]shuffle.DailyStatistics{}, orgStats.DailyStatistics...)
+	for i := range rolledUp.DailyStatistics {
+		rolledUp.DailyStatistics[i].Additions = append([]shuffle.AdditionalUseConfig{}, rolledUp.DailyStatistics[i].Additions...)
+	}
+
+	rolledUp.Additions = append([]shuffle.AdditionalUseConfig{}, orgStats.Additions...)
+	for _, stats := range childStats {
+		if stats != nil {
+			addOrgStatistics(&rolledUp, stats)
+		}
+	}
+
+	return &rolledUp, nil
+}
+
+// Finds an org the user is an admin of
+func getCslAdminOrg(ctx context.Context, user *shuffle.User, orgId string) (*shuffle.Org, error) {
+	org, err := shuffle.GetOrg(ctx, orgId)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("org %s not found", orgId))
+	}
+
+	for _, orgUser := range org.Users {
+		if orgUser.Id == user.Id && orgUser.Role == "admin" {
+			return org, nil
+		}
+	}
+
+	if user.SupportAccess {
+		return org, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("you must be an admin of org %s", orgId))
+}
+
+// Removes a sub-org from the child orgs of its parent
+func removeCslChildOrg(ctx context.Context, parentId, childId string) error {
+	parent, err := shuffle.GetOrg(ctx, parentId)
+	if err != nil {
+		return err
+	}
+
+	childOrgs := []shuffle.OrgMini{}
+	for _, childOrg := range parent.ChildOrgs {
+		if childOrg.Id != childId {
+			childOrgs = append(childOrgs, childOrg)
+		}
+	}
+
+	parent.ChildOrgs = childOrgs
+	shuffle.DeleteCache(ctx, fmt.Sprintf("%s_childorgs", parent.Id))
+	return shuffle.SetOrg(ctx, *parent, parent.Id)
+}
+
+/*
+Organizations:
+Returns the sub-org hierarchy of the current organization with the
+statistics of every org. The rolled_up fields include every org below.
+Requires org admin.
+
+	{
+	    "success": true,
+	    "data": {
+	        "id": "...",
+	        "name": "Security team",
+	        "parent_id": "",
+	        "depth": 0,
+	        "workflows": 12,
+	        "workflow_executions": 3400,
+	        "workflow_executions_failed": 40,
+	        "monthly_api_usage": 900,
+	        "rolled_up_workflow_executions": 5100,
+	        "rolled_up_monthly_api_usage": 1300,
+	        "rolled_up_workflows": 20,
+	        "children": [
+	            {
+	                "id": "...",
+	                "name": "Business unit EU",
+	                "parent_id": "...",
+	                "depth": 1,
+	                ...
+	                "children": []
+	            }
+	        ]
+	    }
+	}
+*/
+func cslGetOrgHierarchy(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	descendants, err := getCslDescendantOrgs(ctx, org.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	nodes := append([]CslOrgNode{{Id: org.Id, Name: org.Name}}, descendants...)
+	err = runConcurrentLookups(ctx, len(nodes), func(ctx context.Context, index int) error {
+		node := &nodes[index]
+		stats, err := shuffle.GetOrgStatistics(ctx, node.Id)
+		if err == nil {
+			node.WorkflowExecutions = stats.MonthlyWorkflowExecutions
+			node.ExecutionsFailed = stats.MonthlyWorkflowExecutions - stats.MonthlyWorkflowExecutionsFinished
+			node.MonthlyApiUsage = stats.MonthlyApiUsage
+		} else {
+			log.Printf("[WARNING] Failed getting stats for org %s in hierarchy: %s", node.Id, err)
+		}
+
+		snapshot, err := getStatsSnapshot(ctx, node.Id)
+		if err == nil {
+			node.Workflows = snapshot.Workflows
+		}
+
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	// Children come after their parents, so the tree is built bottom up
+	for i := len(nodes) - 1; i >= 0; i-- {
+		node := &nodes[i]
+		node.RolledUpExecutions += node.WorkflowExecutions
+		node.RolledUpApiUsage += node.MonthlyApiUsage
+		node.RolledUpWorkflows += node.Workflows
+		if node.Children == nil {
+			node.Children = []CslOrgNode{}
+		}
+
+		for j := range nodes[:i] {
+			parent := &nodes[j]
+			if parent.Id != node.ParentId {
+				continue
+			}
+
+			parent.RolledUpExecutions += node.RolledUpExecutions
+			parent.RolledUpApiUsage += node.RolledUpApiUsage
+			parent.RolledUpWorkflows += node.RolledUpWorkflows
+			parent.Children = append([]CslOrgNode{*node}, parent.Children...)
+		}
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    nodes[0],
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetOrgHierarchy")
+}
+
+/*
+Organizations:
+Moves an org below the current organization with ?child_org_id=<id>, e.g. to
+nest a business unit below another sub-org. Requires admin of both orgs. The
+org is removed from its previous parent, and can't be moved below itself or
+below one of its own sub-orgs.
+*/
+func cslAttachChildOrg(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	childOrgId := request.URL.Query().Get("child_org_id")
+
+	if len(childOrgId) == 0 || childOrgId == user.ActiveOrg.Id {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("child_org_id must be another org")))
+		return
+	}
+
+	child, err := getCslAdminOrg(ctx, user, childOrgId)
+	if err != nil {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	parent, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	// Moving an org below one of its own sub-orgs would make a loop
+	descendants, err := getCslDescendantOrgs(ctx, child.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	childDepth := 0
+	for _, descendant := range descendants {
+		if descendant.Id == parent.Id {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New("an org can't be moved below one of its own sub-orgs")))
+			return
+		}
+
+		childDepth = max(childDepth, descendant.Depth)
+	}
+
+	// The depth of the parent is found from the top of the hierarchy
+	parentDepth := 0
+	for ancestor := parent; len(ancestor.ManagerOrgs) > 0 && parentDepth <= MaxOrgHierarchyDepth; parentDepth++ {
+		ancestor, err = shuffle.GetOrg(ctx, ancestor.ManagerOrgs[0].Id)
+		if err != nil {
+			break
+		}
+	}
+
+	if parentDepth+1+childDepth > MaxOrgHierarchyDepth {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("sub-orgs can be at most %d levels deep", MaxOrgHierarchyDepth))))
+		return
+	}
+
+	for _, managerOrg := range child.ManagerOrgs {
+		if managerOrg.Id == parent.Id {
+			continue
+		}
+
+		err = removeCslChildOrg(ctx, managerOrg.Id, child.Id)
+		if err != nil {
+			log.Printf("[WARNING] Failed removing org %s from previous parent %s: %s", child.Id, managerOrg.Id, err)
+		}
+	}
+
+	alreadyChild := false
+	for _, childOrg := range parent.ChildOrgs {
+		if childOrg.Id == child.Id {
+			alreadyChild = true
+		}
+	}
+
+	if !alreadyChild {
+		parent.ChildOrgs = append(parent.ChildOrgs, shuffle.OrgMini{Id: child.Id, Name: child.Name})
+		err = shuffle.SetOrg(ctx, *parent, parent.Id)
+		if err != nil {
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+	}
+
+	previousParent := child.CreatorOrg
+	child.ManagerOrgs = []shuffle.OrgMini{{Id: parent.Id, Name: parent.Name}}
+	child.CreatorOrg = parent.Id
+	err = shuffle.SetOrg(ctx, *child, child.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	shuffle.DeleteCache(ctx, fmt.Sprintf("%s_childorgs", parent.Id))
+	if len(previousParent) > 0 {
+		shuffle.DeleteCache(ctx, fmt.Sprintf("%s_childorgs", previousParent))
+	}
+
+	log.Printf("[AUDIT] User %s (%s) moved org %s (%s) below org %s", user.Username, user.Id, child.Name, child.Id, parent.Id)
+	recordCslActivity(ctx, parent.Id, ActivityTypeAdmin, "suborg_attached", fmt.Sprintf("%s was added as a sub-org", child.Name), user.Username, child.Id)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslAttachChildOrg")
+}
+
+/*
+Organizations:
+Removes the sub-org ?child_org_id=<id> from the current organization, making
+it a top level org. Its own sub-orgs stay below it. Requires org admin.
+*/
+func cslDetachChildOrg(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	childOrgId := request.URL.Query().Get("child_org_id")
+
+	parent, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	found := false
+	for _, childOrg := range parent.ChildOrgs {
+		if childOrg.Id == childOrgId {
+			found = true
+		}
+	}
+
+	if !found {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("org %s isn't a sub-org of the organization", childOrgId))))
+		return
+	}
+
+	err = removeCslChildOrg(ctx, parent.Id, childOrgId)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	child, err := shuffle.GetOrg(ctx, childOrgId)
+	if err == nil {
+		child.ManagerOrgs = []shuffle.OrgMini{}
+		child.CreatorOrg = ""
+		err = shuffle.SetOrg(ctx, *child, child.Id)
+	}
+
+	if err != nil {
+		log.Printf("[WARNING] Failed updating detached org %s: %s", childOrgId, err)
+	}
+
+	log.Printf("[AUDIT] User %s (%s) removed sub-org %s from org %s", user.Username, user.Id, childOrgId, parent.Id)
+	recordCslActivity(ctx, parent.Id, ActivityTypeAdmin, "suborg_detached", "A sub-org was removed", user.Username, childOrgId)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslDetachChildOrg")
+}
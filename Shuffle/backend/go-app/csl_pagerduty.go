@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslPagerDutyDocument = "pagerduty"
+
+const PagerDutyEventsUrl = "https://events.pagerduty.com/v2/enqueue"
+
+// Event actions of the Events API v2
+const (
+	PagerDutyTrigger = "trigger"
+	PagerDutyResolve = "resolve"
+)
+
+// RoutingKey is the integration key of an Events API v2 integration on a
+// PagerDuty service. Events defaults to every alert. An empty MinSeverity
+// matches all severities
+type CslPagerDutyConfig struct {
+	Enabled     bool     `json:"enabled"`
+	RoutingKey  string   `json:"routing_key"`
+	Events      []string `json:"events"`
+	MinSeverity string   `json:"min_severity"`
+}
+
+type CslPagerDutyState struct {
+	LastSent  int64  `json:"last_sent"`
+	LastError string `json:"last_error"`
+}
+
+type CslPagerDuty struct {
+	Config CslPagerDutyConfig `json:"config"`
+	State  CslPagerDutyState  `json:"state"`
+}
+
+func getCslPagerDuty(ctx context.Context, orgId string) CslPagerDuty {
+	pagerDuty := CslPagerDuty{}
+	_, err := getCslDocument(ctx, orgId, CslPagerDutyDocument, &pagerDuty)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting pagerduty config for org %s: %s", orgId, err)
+	}
+
+	if pagerDuty.Config.Events == nil {
+		pagerDuty.Config.Events = []string{}
+	}
+
+	return pagerDuty
+}
+
+func updateCslPagerDutyState(ctx context.Context, orgId string, update func(state *CslPagerDutyState)) error {
+	pagerDuty := CslPagerDuty{}
+	return updateCslDocument(ctx, orgId, CslPagerDutyDocument, &pagerDuty, func() error {
+		update(&pagerDuty.State)
+		return nil
+	})
+}
+
+func redactCslPagerDuty(pagerDuty CslPagerDuty) CslPagerDuty {
+	if len(pagerDuty.Config.RoutingKey) > 0 {
+		pagerDuty.Config.RoutingKey = RedactedValue
+	}
+
+	return pagerDuty
+}
+
+func validateCslPagerDutyConfig(config CslPagerDutyConfig) error {
+	for _, event := range config.Events {
+		// Only alerts open incidents, as they are the events that are resolved again
+		if !shuffle.ArrayContains(alertEvents, event) {
+			return errors.New(fmt.Sprintf("unknown event %s. Available events are %s", event, strings.Join(alertEvents, ", ")))
+		}
+	}
+
+	err := validateEventFilter(config.Events, config.MinSeverity)
+	if err != nil {
+		return err
+	}
+
+	if config.Enabled && len(config.RoutingKey) == 0 {
+		return errors.New("routing_key is required")
+	}
+
+	return nil
+}
+
+func pagerDutyEventMatches(config CslPagerDutyConfig, notification CslNotification) bool {
+	events := config.Events
+	if len(events) == 0 {
+		events = alertEvents
+	}
+
+	return eventMatches(events, config.MinSeverity, notification)
+}
+
+// Incidents are deduplicated per alert, so a trigger and resolve with the
+// same org, event and key refer to the same incident
+func getPagerDutyDedupKey(orgId, event, key string) string {
+	return fmt.Sprintf("shuffle-%s-%s-%s", orgId, event, key)
+}
+
+func getPagerDutyEvent(routingKey, action, dedupKey string, notification CslNotification) map[string]interface{} {
+	event := map[string]interface{}{
+		"routing_key":  routingKey,
+		"event_action": action,
+		"dedup_key":    dedupKey,
+	}
+
+	if action != PagerDutyTrigger {
+		return event
+	}
+
+	details := map[string]string{}
+	for _, field := range notification.Fields {
+		details[field.Name] = field.Value
+	}
+
+	if len(notification.Text) > 0 {
+		details["Details"] = notification.Text
+	}
+
+	event["payload"] = map[string]interface{}{
+		"summary":        truncateText(notification.Title, 1024),
+		"source":         "shuffle",
+		"severity":       notification.Severity,
+		"timestamp":      time.Unix(notification.Timestamp, 0).UTC().Format(time.RFC3339),
+		"component":      notification.OrgId,
+		"group":          notification.Event,
+		"custom_details": details,
+	}
+
+	return event
+}
+
+func postPagerDutyEvent(ctx context.Context, event map[string]interface{}) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", PagerDutyEventsUrl, bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	client := shuffle.GetExternalClient(PagerDutyEventsUrl)
+	newresp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer newresp.Body.Close()
+	body, err := ioutil.ReadAll(newresp.Body)
+	if err != nil {
+		return err
+	}
+
+	if newresp.StatusCode != 202 {
+		return errors.New(fmt.Sprintf("pagerduty returned status code %d: %s", newresp.StatusCode, string(body)))
+	}
+
+	return nil
+}
+
+// Triggers or resolves the incident of an alert if PagerDuty is enabled for
+// the org and subscribes to the event
+func sendCslPagerDutyEvent(ctx context.Context, orgId, action, key string, notification CslNotification) error {
+	pagerDuty := getCslPagerDuty(ctx, orgId)
+	if !pagerDuty.Config.Enabled || !pagerDutyEventMatches(pagerDuty.Config, notification) {
+		return nil
+	}
+
+	event := getPagerDutyEvent(pagerDuty.Config.RoutingKey, action, getPagerDutyDedupKey(orgId, notification.Event, key), notification)
+	sendErr := postPagerDutyEvent(ctx, event)
+	if sendErr != nil {
+		log.Printf("[ERROR] Failed sending %s of %s to PagerDuty for org %s: %s", action, notification.Event, orgId, sendErr)
+	}
+
+	err := updateCslPagerDutyState(ctx, orgId, func(state *CslPagerDutyState) {
+		state.LastSent = time.Now().Unix()
+		state.LastError = ""
+		if sendErr != nil {
+			state.LastError = sendErr.Error()
+		}
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed updating pagerduty state for org %s: %s", orgId, err)
+	}
+
+	return sendErr
+}
+
+/*
+Notifications:
+Returns the PagerDuty configuration and state for the current organization.
+Requires org admin. The routing key is redacted.
+
+	{
+	    "success": true,
+	    "data": {
+	        "config": {
+	            "enabled": true,
+	            "routing_key": "********",
+	            "events": ["sla_breach", "worker_outage"],
+	            "min_severity": ""
+	        },
+	        "state": {
+	            "last_sent": 1700000000,
+	            "last_error": ""
+	        }
+	    }
+	}
+*/
+func cslGetPagerDuty(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslPagerDuty(getCslPagerDuty(ctx, user.ActiveOrg.Id)),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetPagerDuty")
+}
+
+/*
+Notifications:
+Updates the PagerDuty configuration. Requires org admin. Body uses the format
+of the config field returned from GET, and a redacted routing key is kept as
+it is. Incidents are opened for execution_failure_spike, sla_breach and
+worker_outage alerts, and resolved when the alert clears. Without events all
+three are sent.
+*/
+func cslSetPagerDuty(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	pagerDuty := getCslPagerDuty(ctx, user.ActiveOrg.Id)
+	previousConfig := pagerDuty.Config
+
+	err = json.Unmarshal(body, &pagerDuty.Config)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling pagerduty config: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if pagerDuty.Config.RoutingKey == RedactedValue {
+		pagerDuty.Config.RoutingKey = previousConfig.RoutingKey
+	}
+
+	if pagerDuty.Config.Events == nil {
+		pagerDuty.Config.Events = []string{}
+	}
+
+	err = validateCslPagerDutyConfig(pagerDuty.Config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	stored := CslPagerDuty{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslPagerDutyDocument, &stored, func() error {
+		stored.Config = pagerDuty.Config
+		pagerDuty = stored
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated pagerduty config for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "pagerduty_updated", "PagerDuty configuration was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslPagerDuty(pagerDuty),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetPagerDuty")
+}
+
+/*
+Notifications:
+Triggers a test incident in PagerDuty and resolves it right away. Requires org
+admin and PagerDuty to be enabled.
+*/
+func cslTestPagerDuty(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	pagerDuty := getCslPagerDuty(ctx, user.ActiveOrg.Id)
+	if !pagerDuty.Config.Enabled {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("pagerduty is not enabled")))
+		return
+	}
+
+	notification := getCslNotification(user.ActiveOrg.Id, EventTest, fmt.Sprintf("Test incident sent by %s", user.Username))
+	notification.Title = "Shuffle test incident"
+
+	dedupKey := getPagerDutyDedupKey(user.ActiveOrg.Id, EventTest, fmt.Sprintf("%d", notification.Timestamp))
+	for _, action := range []string{PagerDutyTrigger, PagerDutyResolve} {
+		err := postPagerDutyEvent(ctx, getPagerDutyEvent(pagerDuty.Config.RoutingKey, action, dedupKey, notification))
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+	}
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslTestPagerDuty")
+}
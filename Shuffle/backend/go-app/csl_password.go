@@ -0,0 +1,661 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/shuffle/shuffle-shared"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password complexity, rotation and failed login lockout per org. The policy
+// of the active org of a user applies. Lockout state belongs to the user, so
+// like 2FA state it's stored in a document scoped to the user id.
+
+const CslPasswordPolicyDocument = "password_policy"
+const CslPasswordStateDocument = "password_state"
+
+// Shuffle itself requires 4 characters
+const MinPasswordLength = 4
+const MaxPasswordLength = 128
+
+const DefaultLockoutWindowMinutes = 15
+const DefaultLockoutMinutes = 30
+
+// Returned as the reason when a login is refused because the password has
+// expired. The password is then changed at /api/v1/csl/password/expired
+const PasswordExpiredReason = "PASSWORD_EXPIRED"
+
+// MaxAgeDays and LockoutThreshold of 0 disable rotation and lockout
+type CslPasswordPolicy struct {
+	MinLength            int  `json:"min_length"`
+	RequireUppercase     bool `json:"require_uppercase"`
+	RequireLowercase     bool `json:"require_lowercase"`
+	RequireDigit         bool `json:"require_digit"`
+	RequireSymbol        bool `json:"require_symbol"`
+	MaxAgeDays           int  `json:"max_age_days"`
+	LockoutThreshold     int  `json:"lockout_threshold"`
+	LockoutWindowMinutes int  `json:"lockout_window_minutes"`
+	LockoutMinutes       int  `json:"lockout_minutes"`
+}
+
+// ChangedAt is 0 until the first login after rotation is enabled, so existing
+// passwords don't all expire at once
+type CslPasswordState struct {
+	ChangedAt    int64   `json:"changed_at"`
+	FailedLogins []int64 `json:"failed_logins"`
+	LockedUntil  int64   `json:"locked_until"`
+	Lockouts     int     `json:"lockouts"`
+}
+
+type CslLockedUser struct {
+	Id          string `json:"id"`
+	Username    string `json:"username"`
+	LockedUntil int64  `json:"locked_until"`
+	Lockouts    int    `json:"lockouts"`
+}
+
+type CslLoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type CslExpiredPasswordRequest struct {
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	NewPassword string `json:"new_password"`
+}
+
+func setPasswordPolicyDefaults(policy *CslPasswordPolicy) {
+	if policy.MinLength < MinPasswordLength {
+		policy.MinLength = MinPasswordLength
+	}
+
+	if policy.LockoutWindowMinutes <= 0 {
+		policy.LockoutWindowMinutes = DefaultLockoutWindowMinutes
+	}
+
+	if policy.LockoutMinutes <= 0 {
+		policy.LockoutMinutes = DefaultLockoutMinutes
+	}
+}
+
+func validateCslPasswordPolicy(policy CslPasswordPolicy) error {
+	if policy.MinLength < 0 || policy.MinLength > MaxPasswordLength {
+		return errors.New(fmt.Sprintf("min_length must be at most %d", MaxPasswordLength))
+	}
+
+	if policy.MaxAgeDays < 0 || policy.LockoutThreshold < 0 || policy.LockoutWindowMinutes < 0 || policy.LockoutMinutes < 0 {
+		return errors.New("max_age_days and lockout values can't be negative")
+	}
+
+	return nil
+}
+
+func getCslPasswordPolicy(ctx context.Context, orgId string) CslPasswordPolicy {
+	policy := CslPasswordPolicy{}
+	_, err := getCslDocument(ctx, orgId, CslPasswordPolicyDocument, &policy)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting password policy for org %s: %s", orgId, err)
+	}
+
+	setPasswordPolicyDefaults(&policy)
+	return policy
+}
+
+func getCslPasswordState(ctx context.Context, userId string) CslPasswordState {
+	state := CslPasswordState{}
+	_, err := getCslDocument(ctx, userId, CslPasswordStateDocument, &state)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting password state for user %s: %s", userId, err)
+	}
+
+	return state
+}
+
+func validateCslPassword(policy CslPasswordPolicy, password string) error {
+	if len(password) < policy.MinLength {
+		return errors.New(fmt.Sprintf("Password must be at least %d characters", policy.MinLength))
+	}
+
+	if len(password) > MaxPasswordLength {
+		return errors.New(fmt.Sprintf("Password can be at most %d characters", MaxPasswordLength))
+	}
+
+	hasUpper, hasLower, hasDigit, hasSymbol := false, false, false, false
+	for _, char := range password {
+		switch {
+		case unicode.IsUpper(char):
+			hasUpper = true
+		case unicode.IsLower(char):
+			hasLower = true
+		case unicode.IsDigit(char):
+			hasDigit = true
+		case unicode.IsPunct(char) || unicode.IsSymbol(char) || unicode.IsSpace(char):
+			hasSymbol = true
+		}
+	}
+
+	missing := []string{}
+	if policy.RequireUppercase && !hasUpper {
+		missing = append(missing, "an uppercase letter")
+	}
+
+	if policy.RequireLowercase && !hasLower {
+		missing = append(missing, "a lowercase letter")
+	}
+
+	if policy.RequireDigit && !hasDigit {
+		missing = append(missing, "a digit")
+	}
+
+	if policy.RequireSymbol && !hasSymbol {
+		missing = append(missing, "a symbol")
+	}
+
+	if len(missing) > 0 {
+		return errors.New(fmt.Sprintf("Password must contain %s", strings.Join(missing, ", ")))
+	}
+
+	return nil
+}
+
+func isCslPasswordExpired(policy CslPasswordPolicy, state CslPasswordState, now int64) bool {
+	if policy.MaxAgeDays <= 0 || state.ChangedAt == 0 {
+		return false
+	}
+
+	return now-state.ChangedAt > int64(policy.MaxAgeDays)*24*60*60
+}
+
+// Adds a failed login at now, dropping those outside the lockout window.
+// Returns true if the account got locked
+func addCslFailedLogin(state *CslPasswordState, policy CslPasswordPolicy, now int64) bool {
+	oldest := now - int64(policy.LockoutWindowMinutes)*60
+	failedLogins := []int64{}
+	for _, timestamp := range state.FailedLogins {
+		if timestamp > oldest {
+			failedLogins = append(failedLogins, timestamp)
+		}
+	}
+
+	state.FailedLogins = append(failedLogins, now)
+	if len(state.FailedLogins) < policy.LockoutThreshold {
+		return false
+	}
+
+	state.LockedUntil = now + int64(policy.LockoutMinutes)*60
+	state.FailedLogins = []int64{}
+	state.Lockouts += 1
+	return true
+}
+
+// Counts a failed login and locks the account when the policy threshold is
+// reached within the lockout window
+func recordCslFailedLogin(ctx context.Context, user shuffle.User, policy CslPasswordPolicy, ip string) {
+	now := time.Now().Unix()
+	locked := false
+
+	state := CslPasswordState{}
+	err := updateCslDocument(ctx, user.Id, CslPasswordStateDocument, &state, func() error {
+		locked = addCslFailedLogin(&state, policy, now)
+		return nil
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed recording failed login for user %s: %s", user.Id, err)
+		return
+	}
+
+	if locked {
+		log.Printf("[AUDIT] User %s (%s) was locked out for %d minutes after %d failed logins. Last attempt from %s", user.Username, user.Id, policy.LockoutMinutes, policy.LockoutThreshold, ip)
+		recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeSecurity, "account_locked", fmt.Sprintf("%s was locked out after %d failed logins, last from %s", user.Username, policy.LockoutThreshold, ip), user.Username, user.Id)
+	}
+}
+
+// Clears failed logins after a successful login, and starts the rotation clock
+// for passwords that haven't been changed since rotation was enabled
+func recordCslSuccessfulLogin(ctx context.Context, user shuffle.User, state CslPasswordState) {
+	if len(state.FailedLogins) == 0 && state.ChangedAt > 0 {
+		return
+	}
+
+	err := updateCslDocument(ctx, user.Id, CslPasswordStateDocument, &state, func() error {
+		state.FailedLogins = []int64{}
+		if state.ChangedAt == 0 {
+			state.ChangedAt = time.Now().Unix()
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed clearing failed logins for user %s: %s", user.Id, err)
+	}
+}
+
+func setCslPasswordChanged(ctx context.Context, user shuffle.User) {
+	state := CslPasswordState{}
+	err := updateCslDocument(ctx, user.Id, CslPasswordStateDocument, &state, func() error {
+		state.ChangedAt = time.Now().Unix()
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed storing password change for user %s: %s", user.Id, err)
+	}
+}
+
+// Finds the single user with a username, as the Shuffle login does
+func findCslLoginUser(ctx context.Context, username string) (shuffle.User, bool) {
+	users, err := shuffle.FindUser(ctx, strings.ToLower(strings.TrimSpace(username)))
+	if err != nil || len(users) != 1 {
+		return shuffle.User{}, false
+	}
+
+	return users[0], true
+}
+
+// Wraps the Shuffle login handler with lockout and rotation. Locked accounts
+// are refused before the password is checked, and failed logins are counted
+// from the status of the login
+func cslPasswordOnLogin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		if request.Method != "POST" || request.Body == nil {
+			handler(resp, request)
+			return
+		}
+
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		login := CslLoginRequest{}
+		err = json.Unmarshal(body, &login)
+		if err != nil {
+			handler(resp, request)
+			return
+		}
+
+		ctx := shuffle.GetContext(request)
+		user, found := findCslLoginUser(ctx, login.Username)
+		if !found {
+			handler(resp, request)
+			return
+		}
+
+		policy := getCslPasswordPolicy(ctx, user.ActiveOrg.Id)
+		state := getCslPasswordState(ctx, user.Id)
+		now := time.Now().Unix()
+		ip := shuffle.GetRequestIp(request)
+
+		if state.LockedUntil > now {
+			log.Printf("[AUDIT] Refused login of locked user %s (%s) from %s", user.Username, user.Id, ip)
+			resp.WriteHeader(401)
+			resp.Write(createCslErrorResponse(errors.New("Account is locked after too many failed logins. Try again later")))
+			return
+		}
+
+		if isCslPasswordExpired(policy, state, now) && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(login.Password)) == nil {
+			log.Printf("[AUDIT] Refused login of user %s (%s) with an expired password", user.Username, user.Id)
+			resp.WriteHeader(403)
+			resp.Write(createCslErrorResponse(errors.New(PasswordExpiredReason)))
+			return
+		}
+
+		recorder := &cslStatusRecorder{ResponseWriter: resp, status: 200}
+		handler(recorder, request)
+
+		if recorder.status == 401 && policy.LockoutThreshold > 0 {
+			recordCslFailedLogin(ctx, user, policy, ip)
+		} else if recorder.status == 200 {
+			recordCslSuccessfulLogin(ctx, user, state)
+		}
+	}
+}
+
+// Wraps the Shuffle password change handler with the complexity policy of
+// the org of the user whose password is changed
+func cslPasswordOnChange(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		if request.Method != "POST" || request.Body == nil {
+			handler(resp, request)
+			return
+		}
+
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		change := shuffle.PasswordChange{}
+		err = json.Unmarshal(body, &change)
+		if err != nil {
+			handler(resp, request)
+			return
+		}
+
+		ctx := shuffle.GetContext(request)
+		user, found := findCslLoginUser(ctx, change.Username)
+		if !found {
+			handler(resp, request)
+			return
+		}
+
+		err = validateCslPassword(getCslPasswordPolicy(ctx, user.ActiveOrg.Id), change.Newpassword)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		recorder := &cslStatusRecorder{ResponseWriter: resp, status: 200}
+		handler(recorder, request)
+
+		if recorder.status == 200 {
+			setCslPasswordChanged(ctx, user)
+			recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeSecurity, "password_changed", fmt.Sprintf("Password of %s was changed", user.Username), user.Username, user.Id)
+		}
+	}
+}
+
+/*
+Passwords:
+Changes an expired password. Used after a login is refused with the reason
+PASSWORD_EXPIRED, and doesn't need a session. Failed attempts count towards
+the lockout like failed logins.
+
+	{
+	    "username": "analyst@example.com",
+	    "password": "old password",
+	    "new_password": "new password"
+	}
+*/
+func cslChangeExpiredPassword(resp http.ResponseWriter, request *http.Request) {
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	change := CslExpiredPasswordRequest{}
+	err = json.Unmarshal(body, &change)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	invalidErr := errors.New("Username and/or password is incorrect")
+	user, found := findCslLoginUser(ctx, change.Username)
+	if !found {
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(invalidErr))
+		return
+	}
+
+	policy := getCslPasswordPolicy(ctx, user.ActiveOrg.Id)
+	state := getCslPasswordState(ctx, user.Id)
+	ip := shuffle.GetRequestIp(request)
+	if state.LockedUntil > time.Now().Unix() {
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("Account is locked after too many failed logins. Try again later")))
+		return
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(change.Password))
+	if err != nil {
+		if policy.LockoutThreshold > 0 {
+			recordCslFailedLogin(ctx, user, policy, ip)
+		}
+
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(invalidErr))
+		return
+	}
+
+	err = validateCslPassword(policy, change.NewPassword)
+	if err == nil && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(change.NewPassword)) == nil {
+		err = errors.New("The new password must be different from the old one")
+	}
+
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(change.NewPassword), 8)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	user.Password = string(hashedPassword)
+	err = shuffle.SetUser(ctx, &user, true)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	setCslPasswordChanged(ctx, user)
+
+	log.Printf("[AUDIT] User %s (%s) changed an expired password from %s", user.Username, user.Id, ip)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeSecurity, "password_changed", fmt.Sprintf("Expired password of %s was changed", user.Username), user.Username, user.Id)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslChangeExpiredPassword")
+}
+
+/*
+Passwords:
+Returns the password and lockout policy of the org. Requires org admin.
+Lockout and rotation are disabled while lockout_threshold and max_age_days
+are 0.
+
+	{
+	    "success": true,
+	    "data": {
+	        "min_length": 12,
+	        "require_uppercase": true,
+	        "require_lowercase": true,
+	        "require_digit": true,
+	        "require_symbol": false,
+	        "max_age_days": 90,
+	        "lockout_threshold": 5,
+	        "lockout_window_minutes": 15,
+	        "lockout_minutes": 30
+	    }
+	}
+*/
+func cslGetPasswordPolicy(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslPasswordPolicy(ctx, user.ActiveOrg.Id),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetPasswordPolicy")
+}
+
+/*
+Passwords:
+Updates the password and lockout policy of the org. Requires org admin. Body
+uses the format returned from GET. Complexity applies to new passwords, while
+existing ones are only affected through rotation.
+*/
+func cslSetPasswordPolicy(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	policy := CslPasswordPolicy{}
+	err = json.Unmarshal(body, &policy)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	err = validateCslPasswordPolicy(policy)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	setPasswordPolicyDefaults(&policy)
+
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslPasswordPolicyDocument, policy)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated password policy for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeSecurity, "password_policy_updated", "Password policy was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    policy,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetPasswordPolicy")
+}
+
+/*
+Passwords:
+Returns the users of the org who are locked out. Requires org admin.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "id": "...",
+	            "username": "analyst@example.com",
+	            "locked_until": 1700001800,
+	            "lockouts": 1
+	        }
+	    ]
+	}
+*/
+func cslGetLockedUsers(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	now := time.Now().Unix()
+	locked := []CslLockedUser{}
+	for _, orgUser := range org.Users {
+		state := getCslPasswordState(ctx, orgUser.Id)
+		if state.LockedUntil <= now {
+			continue
+		}
+
+		locked = append(locked, CslLockedUser{
+			Id:          orgUser.Id,
+			Username:    orgUser.Username,
+			LockedUntil: state.LockedUntil,
+			Lockouts:    state.Lockouts,
+		})
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    locked,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetLockedUsers")
+}
+
+/*
+Passwords:
+Unlocks ?user_id=<id> in the org before the lockout ends. Requires org admin.
+*/
+func cslUnlockUser(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	orgId := user.ActiveOrg.Id
+
+	target, err := shuffle.GetUser(ctx, request.URL.Query().Get("user_id"))
+	if err != nil || !shuffle.ArrayContains(target.Orgs, orgId) {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New("user not found")))
+		return
+	}
+
+	state := CslPasswordState{}
+	err = updateCslDocument(ctx, target.Id, CslPasswordStateDocument, &state, func() error {
+		state.LockedUntil = 0
+		state.FailedLogins = []int64{}
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) unlocked user %s (%s) in org %s", user.Username, user.Id, target.Username, target.Id, orgId)
+	recordCslActivity(ctx, orgId, ActivityTypeSecurity, "account_unlocked", fmt.Sprintf("%s was unlocked by %s", target.Username, user.Username), user.Username, target.Id)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslUnlockUser")
+}
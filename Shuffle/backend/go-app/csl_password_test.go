@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateCslPassword(t *testing.T) {
+	strict := CslPasswordPolicy{
+		MinLength:        8,
+		RequireUppercase: true,
+		RequireLowercase: true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+	}
+
+	tests := []struct {
+		name     string
+		policy   CslPasswordPolicy
+		password string
+		err      string
+	}{
+		{name: "default policy", policy: CslPasswordPolicy{MinLength: MinPasswordLength}, password: "abcd"},
+		{name: "too short", policy: CslPasswordPolicy{MinLength: MinPasswordLength}, password: "abc", err: "Password must be at least 4 characters"},
+		{name: "too long", policy: CslPasswordPolicy{MinLength: MinPasswordLength}, password: strings.Repeat("a", MaxPasswordLength+1), err: "Password can be at most 128 characters"},
+		{name: "strict", policy: strict, password: "Abcdef1!"},
+		{name: "strict with space as symbol", policy: strict, password: "Abcdef1 "},
+		{name: "strict with unicode", policy: strict, password: "Ærlig1234!"},
+		{name: "strict missing uppercase", policy: strict, password: "abcdef1!", err: "Password must contain an uppercase letter"},
+		{name: "strict missing several", policy: strict, password: "abcdefgh", err: "Password must contain an uppercase letter, a digit, a symbol"},
+		{name: "strict missing lowercase", policy: strict, password: "ABCDEF1!", err: "Password must contain a lowercase letter"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateCslPassword(test.policy, test.password)
+			if len(test.err) == 0 && err != nil {
+				t.Errorf("validateCslPassword failed: %s", err)
+			}
+
+			if len(test.err) > 0 && (err == nil || err.Error() != test.err) {
+				t.Errorf("validateCslPassword returned %v, expected %s", err, test.err)
+			}
+		})
+	}
+}
+
+func TestValidateCslPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy CslPasswordPolicy
+		valid  bool
+	}{
+		{name: "empty", policy: CslPasswordPolicy{}, valid: true},
+		{name: "lockout", policy: CslPasswordPolicy{MinLength: 12, MaxAgeDays: 90, LockoutThreshold: 5, LockoutWindowMinutes: 10, LockoutMinutes: 60}, valid: true},
+		{name: "min length too long", policy: CslPasswordPolicy{MinLength: MaxPasswordLength + 1}},
+		{name: "negative max age", policy: CslPasswordPolicy{MaxAgeDays: -1}},
+		{name: "negative threshold", policy: CslPasswordPolicy{LockoutThreshold: -1}},
+		{name: "negative lockout", policy: CslPasswordPolicy{LockoutMinutes: -1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateCslPasswordPolicy(test.policy)
+			if (err == nil) != test.valid {
+				t.Errorf("validateCslPasswordPolicy returned %v, expected valid=%t", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestSetPasswordPolicyDefaults(t *testing.T) {
+	policy := CslPasswordPolicy{MinLength: 2}
+	setPasswordPolicyDefaults(&policy)
+	if policy.MinLength != MinPasswordLength || policy.LockoutWindowMinutes != DefaultLockoutWindowMinutes || policy.LockoutMinutes != DefaultLockoutMinutes {
+		t.Errorf("got %+v, expected the defaults", policy)
+	}
+
+	policy = CslPasswordPolicy{MinLength: 10, LockoutWindowMinutes: 5, LockoutMinutes: 1}
+	setPasswordPolicyDefaults(&policy)
+	if policy.MinLength != 10 || policy.LockoutWindowMinutes != 5 || policy.LockoutMinutes != 1 {
+		t.Errorf("got %+v, expected the values to be kept", policy)
+	}
+}
+
+func TestIsCslPasswordExpired(t *testing.T) {
+	day := int64(24 * 60 * 60)
+	now := int64(1718000000)
+
+	tests := []struct {
+		name     string
+		policy   CslPasswordPolicy
+		state    CslPasswordState
+		expected bool
+	}{
+		{name: "rotation disabled", policy: CslPasswordPolicy{}, state: CslPasswordState{ChangedAt: now - 1000*day}},
+		{name: "never changed", policy: CslPasswordPolicy{MaxAgeDays: 30}, state: CslPasswordState{}},
+		{name: "recent", policy: CslPasswordPolicy{MaxAgeDays: 30}, state: CslPasswordState{ChangedAt: now - 29*day}},
+		{name: "exactly max age", policy: CslPasswordPolicy{MaxAgeDays: 30}, state: CslPasswordState{ChangedAt: now - 30*day}},
+		{name: "expired", policy: CslPasswordPolicy{MaxAgeDays: 30}, state: CslPasswordState{ChangedAt: now - 30*day - 1}, expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if isCslPasswordExpired(test.policy, test.state, now) != test.expected {
+				t.Errorf("isCslPasswordExpired = %t, expected %t", !test.expected, test.expected)
+			}
+		})
+	}
+}
+
+func TestAddCslFailedLogin(t *testing.T) {
+	policy := CslPasswordPolicy{LockoutThreshold: 3, LockoutWindowMinutes: 10, LockoutMinutes: 30}
+	now := int64(1718000000)
+
+	tests := []struct {
+		name         string
+		state        CslPasswordState
+		locked       bool
+		failedLogins int
+	}{
+		{name: "first failure", state: CslPasswordState{}, failedLogins: 1},
+		{name: "below threshold", state: CslPasswordState{FailedLogins: []int64{now - 60}}, failedLogins: 2},
+		{name: "reaches threshold", state: CslPasswordState{FailedLogins: []int64{now - 120, now - 60}}, locked: true},
+		{name: "old failures expire", state: CslPasswordState{FailedLogins: []int64{now - 601, now - 60}}, failedLogins: 2},
+		{name: "failure at the window edge expires", state: CslPasswordState{FailedLogins: []int64{now - 600, now - 60}}, failedLogins: 2},
+		{name: "second lockout", state: CslPasswordState{FailedLogins: []int64{now - 2, now - 1}, Lockouts: 1}, locked: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := test.state
+			lockouts := state.Lockouts
+			locked := addCslFailedLogin(&state, policy, now)
+			if locked != test.locked {
+				t.Fatalf("addCslFailedLogin returned %t, expected %t", locked, test.locked)
+			}
+
+			if len(state.FailedLogins) != test.failedLogins {
+				t.Errorf("got %d failed logins, expected %d", len(state.FailedLogins), test.failedLogins)
+			}
+
+			if test.locked && (state.LockedUntil != now+30*60 || state.Lockouts != lockouts+1) {
+				t.Errorf("got locked until %d with %d lockouts, expected %d with %d", state.LockedUntil, state.Lockouts, now+30*60, lockouts+1)
+			}
+
+			if !test.locked && (state.LockedUntil != 0 || state.Lockouts != lockouts) {
+				t.Errorf("account was locked until %d", state.LockedUntil)
+			}
+		})
+	}
+}
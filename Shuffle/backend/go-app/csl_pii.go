@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslPiiScanDocument = "pii_scan"
+
+// How often the pii_scan job looks at executions finished since the last scan
+const PiiScanMinutes = 60
+
+// Most recent executions of each workflow looked at per scan
+const PiiScanExecutionLimit = 50
+
+// Max amount of findings kept per org. Oldest are dropped first
+const MaxPiiFindings = 1000
+
+const DefaultPiiFindingLimit = 50
+const MaxPiiFindingLimit = 500
+
+// PII types
+const (
+	PiiTypeEmail      = "email"
+	PiiTypeSsn        = "ssn"
+	PiiTypeCreditCard = "credit_card"
+)
+
+var piiTypes = []string{PiiTypeEmail, PiiTypeSsn, PiiTypeCreditCard}
+
+var piiPatterns = map[string]*regexp.Regexp{
+	PiiTypeEmail:      regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	PiiTypeSsn:        regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`),
+	PiiTypeCreditCard: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+}
+
+// Where PII was found in an execution. Node is the action id, or "argument"
+// for the execution argument. Samples are masked
+type CslPiiFinding struct {
+	ExecutionId  string   `json:"execution_id"`
+	WorkflowId   string   `json:"workflow_id"`
+	WorkflowName string   `json:"workflow_name"`
+	Node         string   `json:"node"`
+	AppName      string   `json:"app_name,omitempty"`
+	Type         string   `json:"type"`
+	Count        int      `json:"count"`
+	Samples      []string `json:"samples"`
+	ExecutedAt   int64    `json:"executed_at"`
+	FoundAt      int64    `json:"found_at"`
+}
+
+type CslPiiScan struct {
+	LastScan          int64           `json:"last_scan"`
+	ScannedExecutions int64           `json:"scanned_executions"`
+	Findings          []CslPiiFinding `json:"findings"`
+}
+
+type CslPiiWorkflowCount struct {
+	WorkflowId   string `json:"workflow_id"`
+	WorkflowName string `json:"workflow_name"`
+	Executions   int    `json:"executions"`
+}
+
+type CslPiiCounts struct {
+	LastScan          int64                 `json:"last_scan"`
+	ScannedExecutions int64                 `json:"scanned_executions"`
+	Executions        int                   `json:"executions"`
+	Types             map[string]int        `json:"types"`
+	Workflows         []CslPiiWorkflowCount `json:"workflows"`
+}
+
+var cslPiiScans = struct {
+	sync.Mutex
+	running map[string]bool
+}{running: map[string]bool{}}
+
+// Checks the Luhn checksum, so random long numbers aren't flagged as cards
+func isLuhnValid(digits string) bool {
+	sum := 0
+	double := false
+	for index := len(digits) - 1; index >= 0; index-- {
+		digit := int(digits[index] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+
+		sum += digit
+		double = !double
+	}
+
+	return sum%10 == 0
+}
+
+// Area 000, 666 and 900-999, group 00 and serial 0000 are never issued
+func isValidSsn(ssn string) bool {
+	parts := strings.Split(ssn, "-")
+	if len(parts) != 3 {
+		return false
+	}
+
+	return parts[0] != "000" && parts[0] != "666" && parts[0][0] != '9' && parts[1] != "00" && parts[2] != "0000"
+}
+
+func maskPii(piiType, value string) string {
+	switch piiType {
+	case PiiTypeEmail:
+		parts := strings.SplitN(value, "@", 2)
+		return fmt.Sprintf("%s***@%s", parts[0][:1], parts[1])
+	case PiiTypeSsn:
+		return fmt.Sprintf("***-**-%s", value[len(value)-4:])
+	case PiiTypeCreditCard:
+		return fmt.Sprintf("**** %s", value[len(value)-4:])
+	}
+
+	return "***"
+}
+
+// Returns the matches of each PII type in the value, masked
+func detectPii(value string) map[string][]string {
+	found := map[string][]string{}
+	if len(value) == 0 {
+		return found
+	}
+
+	for _, piiType := range piiTypes {
+		for _, match := range piiPatterns[piiType].FindAllString(value, -1) {
+			switch piiType {
+			case PiiTypeSsn:
+				if !isValidSsn(match) {
+					continue
+				}
+			case PiiTypeCreditCard:
+				match = strings.NewReplacer(" ", "", "-", "").Replace(match)
+				if !isLuhnValid(match) {
+					continue
+				}
+			}
+
+			found[piiType] = append(found[piiType], maskPii(piiType, match))
+		}
+	}
+
+	return found
+}
+
+func getPiiFindings(execution shuffle.WorkflowExecution, node, appName, value string, timeNow int64) []CslPiiFinding {
+	findings := []CslPiiFinding{}
+	for piiType, matches := range detectPii(value) {
+		samples := []string{}
+		for _, match := range matches {
+			if len(samples) < 3 && !shuffle.ArrayContains(samples, match) {
+				samples = append(samples, match)
+			}
+		}
+
+		findings = append(findings, CslPiiFinding{
+			ExecutionId:  execution.ExecutionId,
+			WorkflowId:   execution.Workflow.ID,
+			WorkflowName: execution.Workflow.Name,
+			Node:         node,
+			AppName:      appName,
+			Type:         piiType,
+			Count:        len(matches),
+			Samples:      samples,
+			ExecutedAt:   execution.StartedAt,
+			FoundAt:      timeNow,
+		})
+	}
+
+	return findings
+}
+
+// Scans the argument and every result of an execution
+func scanExecutionPii(execution shuffle.WorkflowExecution, timeNow int64) []CslPiiFinding {
+	findings := getPiiFindings(execution, "argument", "", execution.ExecutionArgument, timeNow)
+	for _, result := range execution.Results {
+		findings = append(findings, getPiiFindings(execution, result.Action.ID, result.Action.AppName, result.Result, timeNow)...)
+	}
+
+	return findings
+}
+
+// Scans executions of the org finished since the last scan. Only one scan
+// runs per org at a time
+func runOrgPiiScan(ctx context.Context, orgId string) error {
+	cslPiiScans.Lock()
+	if cslPiiScans.running[orgId] {
+		cslPiiScans.Unlock()
+		return errors.New("a scan is already running")
+	}
+
+	cslPiiScans.running[orgId] = true
+	cslPiiScans.Unlock()
+
+	defer func() {
+		cslPiiScans.Lock()
+		delete(cslPiiScans.running, orgId)
+		cslPiiScans.Unlock()
+	}()
+
+	scan := CslPiiScan{}
+	_, err := getCslDocument(ctx, orgId, CslPiiScanDocument, &scan)
+	if err != nil {
+		return err
+	}
+
+	// Taken before the lookups, so executions finishing meanwhile are in the next scan
+	timeNow := time.Now().Unix()
+	workflows, err := getOrgWorkflows(ctx, orgId)
+	if err != nil {
+		return err
+	}
+
+	executions := make([][]shuffle.WorkflowExecution, len(workflows))
+	err = runConcurrentLookups(ctx, len(workflows), func(ctx context.Context, index int) error {
+		workflowExecutions, err := shuffle.GetAllWorkflowExecutions(ctx, workflows[index].ID, PiiScanExecutionLimit)
+		executions[index] = workflowExecutions
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	lastScan := scan.LastScan
+	scanned := int64(0)
+	findings := []CslPiiFinding{}
+	for _, workflowExecutions := range executions {
+		for _, execution := range workflowExecutions {
+			if execution.Status == "EXECUTING" || execution.CompletedAt <= lastScan {
+				continue
+			}
+
+			scanned += 1
+			findings = append(findings, scanExecutionPii(execution, timeNow)...)
+		}
+	}
+
+	return updateCslDocument(ctx, orgId, CslPiiScanDocument, &scan, func() error {
+		scan.LastScan = timeNow
+		scan.ScannedExecutions += scanned
+		scan.Findings = append(scan.Findings, findings...)
+		if len(scan.Findings) > MaxPiiFindings {
+			scan.Findings = scan.Findings[len(scan.Findings)-MaxPiiFindings:]
+		}
+
+		return nil
+	})
+}
+
+// Job: scans recently finished executions of every org
+func runCslPiiScanJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for PII scan job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		err = runOrgPiiScan(ctx, org.Id)
+		if err != nil {
+			log.Printf("[WARNING] Failed scanning executions of org %s for PII: %s", org.Id, err)
+		}
+	}
+}
+
+func getPiiCounts(scan CslPiiScan) CslPiiCounts {
+	counts := CslPiiCounts{
+		LastScan:          scan.LastScan,
+		ScannedExecutions: scan.ScannedExecutions,
+		Types:             map[string]int{},
+		Workflows:         []CslPiiWorkflowCount{},
+	}
+
+	for _, piiType := range piiTypes {
+		counts.Types[piiType] = 0
+	}
+
+	executions := map[string]bool{}
+	workflows := map[string]*CslPiiWorkflowCount{}
+	for _, finding := range scan.Findings {
+		counts.Types[finding.Type] += finding.Count
+		if executions[finding.ExecutionId] {
+			continue
+		}
+
+		executions[finding.ExecutionId] = true
+		workflow, ok := workflows[finding.WorkflowId]
+		if !ok {
+			workflow = &CslPiiWorkflowCount{
+				WorkflowId:   finding.WorkflowId,
+				WorkflowName: finding.WorkflowName,
+			}
+
+			workflows[finding.WorkflowId] = workflow
+		}
+
+		workflow.Executions += 1
+	}
+
+	counts.Executions = len(executions)
+	for _, workflow := range workflows {
+		counts.Workflows = append(counts.Workflows, *workflow)
+	}
+
+	sort.SliceStable(counts.Workflows, func(i, j int) bool {
+		if counts.Workflows[i].Executions == counts.Workflows[j].Executions {
+			return counts.Workflows[i].WorkflowName < counts.Workflows[j].WorkflowName
+		}
+
+		return counts.Workflows[i].Executions > counts.Workflows[j].Executions
+	})
+
+	return counts
+}
+
+/*
+Dashboard:
+Returns how much likely PII (emails, SSNs and credit card numbers) was found
+in stored executions of the org. types counts matches, executions and
+workflows count executions with at least one finding. Executions are scanned
+every hour once they finish.
+
+	{
+	    "success": true,
+	    "data": {
+	        "last_scan": 1718000000,
+	        "scanned_executions": 5120,
+	        "executions": 14,
+	        "types": {
+	            "email": 40,
+	            "ssn": 2,
+	            "credit_card": 0
+	        },
+	        "workflows": [
+	            {
+	                "workflow_id": "...",
+	                "workflow_name": "Phishing triage",
+	                "executions": 12
+	            }
+	        ]
+	    }
+	}
+*/
+func cslPiiCounts(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	scan := CslPiiScan{}
+	_, err := getCslDocument(ctx, user.ActiveOrg.Id, CslPiiScanDocument, &scan)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting PII scan of org %s: %s", user.ActiveOrg.Id, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    getPiiCounts(scan),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslPiiCounts")
+}
+
+/*
+PII:
+Returns where likely PII was found in stored executions, newest first.
+Samples are masked. Filter with ?type=email|ssn|credit_card, ?workflow_id=
+and ?execution_id=, and limit with ?limit=N (default 50, max 500).
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "execution_id": "...",
+	            "workflow_id": "...",
+	            "workflow_name": "Phishing triage",
+	            "node": "...",
+	            "app_name": "email",
+	            "type": "email",
+	            "count": 3,
+	            "samples": ["j***@example.com"],
+	            "executed_at": 1718000000,
+	            "found_at": 1718003600
+	        }
+	    ]
+	}
+*/
+func cslPiiFindings(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	piiType := query.Get("type")
+	if len(piiType) > 0 && !shuffle.ArrayContains(piiTypes, piiType) {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("type must be one of %v", piiTypes))))
+		return
+	}
+
+	limit := DefaultPiiFindingLimit
+	if parsedLimit, err := strconv.Atoi(query.Get("limit")); err == nil && parsedLimit > 0 {
+		limit = parsedLimit
+	}
+
+	if limit > MaxPiiFindingLimit {
+		limit = MaxPiiFindingLimit
+	}
+
+	scan := CslPiiScan{}
+	_, err := getCslDocument(ctx, user.ActiveOrg.Id, CslPiiScanDocument, &scan)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting PII scan of org %s: %s", user.ActiveOrg.Id, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	findings := []CslPiiFinding{}
+	for _, finding := range scan.Findings {
+		if len(piiType) > 0 && finding.Type != piiType {
+			continue
+		}
+
+		if len(query.Get("workflow_id")) > 0 && finding.WorkflowId != query.Get("workflow_id") {
+			continue
+		}
+
+		if len(query.Get("execution_id")) > 0 && finding.ExecutionId != query.Get("execution_id") {
+			continue
+		}
+
+		findings = append(findings, finding)
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].ExecutedAt > findings[j].ExecutedAt
+	})
+
+	if len(findings) > limit {
+		findings = findings[:limit]
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    findings,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslPiiFindings")
+}
+
+/*
+PII:
+Scans executions of the org finished since the last scan right away,
+instead of waiting for the pii_scan job. The scan runs in the background.
+*/
+func cslStartPiiScan(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	cslPiiScans.Lock()
+	running := cslPiiScans.running[user.ActiveOrg.Id]
+	cslPiiScans.Unlock()
+	if running {
+		resp.WriteHeader(409)
+		resp.Write(createCslErrorResponse(errors.New("a scan is already running")))
+		return
+	}
+
+	orgId := user.ActiveOrg.Id
+	go func() {
+		err := runOrgPiiScan(context.Background(), orgId)
+		if err != nil {
+			log.Printf("[WARNING] PII scan of org %s started by %s: %s", orgId, user.Username, err)
+		}
+	}()
+
+	log.Printf("[AUDIT] User %s (%s) started a PII scan of org %s", user.Username, user.Id, orgId)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslStartPiiScan")
+}
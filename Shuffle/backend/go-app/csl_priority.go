@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Execution priority levels. Workflows are given a level by org admins, which
+// sets the priority of their executions in the Orborus queue. Queue batches
+// are ordered by weighted round robin between the levels, so bulk executions
+// still get a share while urgent ones go first. Starting an execution is
+// rejected with 429 while its level has too many executions queued.
+
+const CslExecutionPrioritiesDocument = "execution_priorities"
+
+const (
+	PriorityCritical = "critical"
+	PriorityHigh     = "high"
+	PriorityNormal   = "normal"
+	PriorityBulk     = "bulk"
+)
+
+var priorityLevels = []string{PriorityCritical, PriorityHigh, PriorityNormal, PriorityBulk}
+
+// Share of each queue batch per level
+var priorityWeights = map[string]int{
+	PriorityCritical: 8,
+	PriorityHigh:     4,
+	PriorityNormal:   2,
+	PriorityBulk:     1,
+}
+
+// Queue priorities of the levels. Normal keeps the priority set by the
+// execution, which is at most 11 for user input continuations
+const (
+	QueuePriorityCritical = 30
+	QueuePriorityHigh     = 20
+	QueuePriorityBulk     = 1
+)
+
+const DefaultRetryAfterSeconds = 30
+const MaxRetryAfterSeconds = 3600
+
+// Queue depths are reused for a few seconds, as every started execution
+// checks them
+const QueueDepthCacheSeconds = 5
+
+type CslExecutionPriorities struct {
+	// Workflow id to level. Workflows not here are normal
+	Workflows map[string]string `json:"workflows"`
+
+	// Max executions of a level queued per environment. 0 is unlimited
+	MaxQueued map[string]int `json:"max_queued"`
+
+	RetryAfterSeconds int `json:"retry_after_seconds"`
+}
+
+type CslPriorityQueue struct {
+	Environment string         `json:"environment"`
+	Queued      map[string]int `json:"queued"`
+}
+
+type CslPriorityStatus struct {
+	CslExecutionPriorities
+	Queues []CslPriorityQueue `json:"queues"`
+}
+
+var cslQueueDepths = struct {
+	sync.Mutex
+	environments map[string]cslQueueDepth
+}{
+	environments: map[string]cslQueueDepth{},
+}
+
+type cslQueueDepth struct {
+	updated int64
+	queued  map[string]int
+}
+
+func resetCslQueueDepths() {
+	cslQueueDepths.Lock()
+	cslQueueDepths.environments = map[string]cslQueueDepth{}
+	cslQueueDepths.Unlock()
+}
+
+func getCslExecutionPriorities(ctx context.Context, orgId string) CslExecutionPriorities {
+	priorities := CslExecutionPriorities{}
+	_, err := getCslDocument(ctx, orgId, CslExecutionPrioritiesDocument, &priorities)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting execution priorities for org %s: %s", orgId, err)
+	}
+
+	if priorities.Workflows == nil {
+		priorities.Workflows = map[string]string{}
+	}
+
+	if priorities.MaxQueued == nil {
+		priorities.MaxQueued = map[string]int{}
+	}
+
+	if priorities.RetryAfterSeconds == 0 {
+		priorities.RetryAfterSeconds = DefaultRetryAfterSeconds
+	}
+
+	return priorities
+}
+
+func (priorities CslExecutionPriorities) getLevel(workflowId string) string {
+	if level, ok := priorities.Workflows[workflowId]; ok {
+		return level
+	}
+
+	return PriorityNormal
+}
+
+// Queue priority of an execution from the level of its workflow
+func getCslQueuePriority(ctx context.Context, workflowExecution shuffle.WorkflowExecution) int64 {
+	priorities := getCslExecutionPriorities(ctx, workflowExecution.ExecutionOrg)
+	switch priorities.getLevel(workflowExecution.Workflow.ID) {
+	case PriorityCritical:
+		return QueuePriorityCritical
+	case PriorityHigh:
+		return QueuePriorityHigh
+	case PriorityBulk:
+		return QueuePriorityBulk
+	}
+
+	return workflowExecution.Priority
+}
+
+func getQueuedPriorityLevel(priority int64) string {
+	if priority >= QueuePriorityCritical {
+		return PriorityCritical
+	}
+
+	if priority >= QueuePriorityHigh {
+		return PriorityHigh
+	}
+
+	if priority > 0 && priority <= QueuePriorityBulk {
+		return PriorityBulk
+	}
+
+	return PriorityNormal
+}
+
+// Orders a queue batch by smooth weighted round robin between the levels,
+// keeping the order within each level
+func scheduleWeightedExecutions(executionRequests []shuffle.ExecutionRequest) []shuffle.ExecutionRequest {
+	levels := map[string][]shuffle.ExecutionRequest{}
+	for _, executionRequest := range executionRequests {
+		level := getQueuedPriorityLevel(executionRequest.Priority)
+		levels[level] = append(levels[level], executionRequest)
+	}
+
+	if len(levels) <= 1 {
+		return executionRequests
+	}
+
+	current := map[string]int{}
+	scheduled := []shuffle.ExecutionRequest{}
+	for len(scheduled) < len(executionRequests) {
+		total := 0
+		selected := ""
+		for _, level := range priorityLevels {
+			if len(levels[level]) == 0 {
+				continue
+			}
+
+			current[level] += priorityWeights[level]
+			total += priorityWeights[level]
+			if len(selected) == 0 || current[level] > current[selected] {
+				selected = level
+			}
+		}
+
+		current[selected] -= total
+		scheduled = append(scheduled, levels[selected][0])
+		levels[selected] = levels[selected][1:]
+	}
+
+	return scheduled
+}
+
+func getQueuedByLevel(ctx context.Context, environment string) (map[string]int, error) {
+	cslQueueDepths.Lock()
+	depth, ok := cslQueueDepths.environments[environment]
+	cslQueueDepths.Unlock()
+
+	if ok && time.Now().Unix()-depth.updated < QueueDepthCacheSeconds {
+		return depth.queued, nil
+	}
+
+	queue, err := shuffle.GetWorkflowQueue(ctx, environment, MaxBacklogCount)
+	if err != nil {
+		return map[string]int{}, err
+	}
+
+	queued := map[string]int{}
+	for _, executionRequest := range queue.Data {
+		queued[getQueuedPriorityLevel(executionRequest.Priority)] += 1
+	}
+
+	cslQueueDepths.Lock()
+	cslQueueDepths.environments[environment] = cslQueueDepth{
+		updated: time.Now().Unix(),
+		queued:  queued,
+	}
+	cslQueueDepths.Unlock()
+
+	return queued, nil
+}
+
+// Environments the workflow runs in, found from its actions
+func getWorkflowEnvironments(workflow shuffle.Workflow) []string {
+	environments := []string{}
+	for _, action := range workflow.Actions {
+		if len(action.Environment) == 0 || strings.ToLower(action.Environment) == "cloud" {
+			continue
+		}
+
+		if !shuffle.ArrayContains(environments, action.Environment) {
+			environments = append(environments, action.Environment)
+		}
+	}
+
+	return environments
+}
+
+// Returns an error when an environment of the workflow has too many
+// executions of its level queued
+func checkCslBackpressure(ctx context.Context, workflow shuffle.Workflow, priorities CslExecutionPriorities) error {
+	level := priorities.getLevel(workflow.ID)
+	maxQueued := priorities.MaxQueued[level]
+	if maxQueued <= 0 {
+		return nil
+	}
+
+	for _, environment := range getWorkflowEnvironments(workflow) {
+		queued, err := getQueuedByLevel(ctx, environment)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting queue of environment %s for backpressure, allowing execution: %s", environment, err)
+			continue
+		}
+
+		if queued[level] >= maxQueued {
+			return errors.New(fmt.Sprintf("the %s queue of environment %s is full (%d/%d executions). Try again later", level, environment, queued[level], maxQueued))
+		}
+	}
+
+	return nil
+}
+
+// Wraps the execution and webhook handlers to reject executions with 429
+// while the queue of their priority level is full
+func cslBackpressure(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		if request.Method == "OPTIONS" {
+			handler(resp, request)
+			return
+		}
+
+		ctx := shuffle.GetContext(request)
+
+		workflowId := mux.Vars(request)["key"]
+		if strings.HasPrefix(request.URL.Path, "/api/v1/hooks/") {
+			hook, err := shuffle.GetHook(ctx, strings.TrimPrefix(workflowId, "webhook_"))
+			if err != nil || len(hook.Workflows) == 0 {
+				handler(resp, request)
+				return
+			}
+
+			workflowId = hook.Workflows[0]
+		}
+
+		workflow, err := shuffle.GetWorkflow(ctx, workflowId)
+		if err != nil || len(workflow.OrgId) == 0 {
+			handler(resp, request)
+			return
+		}
+
+		priorities := getCslExecutionPriorities(ctx, workflow.OrgId)
+		err = checkCslBackpressure(ctx, *workflow, priorities)
+		if err != nil {
+			log.Printf("[WARNING] Rejecting execution of workflow %s in org %s: %s", workflow.ID, workflow.OrgId, err)
+
+			resp.Header().Set("Retry-After", strconv.Itoa(priorities.RetryAfterSeconds))
+			resp.WriteHeader(429)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		handler(resp, request)
+	}
+}
+
+/*
+Execution priorities:
+Returns the priority level of workflows in the current organization, the max
+executions queued per level and environment, and what is queued now. Levels
+are critical, high, normal and bulk. Workflows without a level are normal.
+
+	{
+	    "success": true,
+	    "data": {
+	        "workflows": {
+	            "5ec3...": "critical",
+	            "9ba1...": "bulk"
+	        },
+	        "max_queued": {
+	            "bulk": 200,
+	            "normal": 500
+	        },
+	        "retry_after_seconds": 30,
+	        "queues": [
+	            {
+	                "environment": "Shuffle",
+	                "queued": {
+	                    "bulk": 150,
+	                    "critical": 1
+	                }
+	            }
+	        ]
+	    }
+	}
+*/
+func cslGetExecutionPriorities(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	status := CslPriorityStatus{
+		CslExecutionPriorities: getCslExecutionPriorities(ctx, user.ActiveOrg.Id),
+		Queues:                 []CslPriorityQueue{},
+	}
+
+	environments, err := shuffle.GetEnvironments(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting environments of org %s for execution priorities: %s", user.ActiveOrg.Id, err)
+	}
+
+	for _, environment := range environments {
+		if environment.Archived || environment.Type == "cloud" {
+			continue
+		}
+
+		queued, err := getQueuedByLevel(ctx, environment.Name)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting queue of environment %s: %s", environment.Name, err)
+		}
+
+		status.Queues = append(status.Queues, CslPriorityQueue{
+			Environment: environment.Name,
+			Queued:      queued,
+		})
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    status,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetExecutionPriorities")
+}
+
+/*
+Execution priorities:
+Sets the execution priorities of the current organization in the format
+returned from GET, without queues. Requires org admin. Levels apply to
+executions started after the change.
+*/
+func cslSetExecutionPriorities(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	newPriorities := CslExecutionPriorities{}
+	err = json.Unmarshal(body, &newPriorities)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if newPriorities.RetryAfterSeconds < 0 || newPriorities.RetryAfterSeconds > MaxRetryAfterSeconds {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("retry_after_seconds must be between 0 and %d", MaxRetryAfterSeconds))))
+		return
+	}
+
+	for workflowId, level := range newPriorities.Workflows {
+		if !shuffle.ArrayContains(priorityLevels, level) {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("unknown level %s. Use one of %s", level, strings.Join(priorityLevels, ", ")))))
+			return
+		}
+
+		workflow, err := shuffle.GetWorkflow(ctx, workflowId)
+		if err != nil || workflow.OrgId != user.ActiveOrg.Id {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("workflow %s not found", workflowId))))
+			return
+		}
+	}
+
+	for level, maxQueued := range newPriorities.MaxQueued {
+		if !shuffle.ArrayContains(priorityLevels, level) {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("unknown level %s. Use one of %s", level, strings.Join(priorityLevels, ", ")))))
+			return
+		}
+
+		if maxQueued < 0 {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New("max_queued can't be negative")))
+			return
+		}
+	}
+
+	if newPriorities.Workflows == nil {
+		newPriorities.Workflows = map[string]string{}
+	}
+
+	if newPriorities.MaxQueued == nil {
+		newPriorities.MaxQueued = map[string]int{}
+	}
+
+	if newPriorities.RetryAfterSeconds == 0 {
+		newPriorities.RetryAfterSeconds = DefaultRetryAfterSeconds
+	}
+
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslExecutionPrioritiesDocument, newPriorities)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) changed execution priorities of org %s. Workflows: %d, max queued: %v", user.Username, user.Id, user.ActiveOrg.Id, len(newPriorities.Workflows), newPriorities.MaxQueued)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "execution_priorities_changed", "Execution priorities were changed", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    newPriorities,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetExecutionPriorities")
+}
@@ -0,0 +1,906 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Workflow publishing between orgs. A publication keeps versions of a
+// workflow bundle in the source org, and target orgs install a copy of it.
+// Installs pinned to a version stay on it, others are updated when a new
+// version is published. Auth is never shared, only placeholders that each
+// target org maps to its own auth
+
+const CslPublicationsDocument = "publications"
+const CslSubscriptionsDocument = "subscriptions"
+
+// Publications shared with other orgs are listed in an index that isn't
+// owned by a real org
+const CslPublicationIndexOrgId = "csl_publication_index"
+const CslPublicationIndexDocument = "publication_index"
+
+// Versions kept per publication. Installs pinned to a removed version stay
+// on it until they're updated
+const MaxPublicationVersions = 10
+
+type CslPublishedVersion struct {
+	Version     int                `json:"version"`
+	PublishedAt int64              `json:"published_at"`
+	PublishedBy string             `json:"published_by"`
+	Notes       string             `json:"notes"`
+	Bundle      *CslWorkflowBundle `json:"bundle,omitempty"`
+}
+
+// Installs maps the orgs that installed the publication to their version
+type CslPublication struct {
+	Id          string                `json:"id"`
+	Name        string                `json:"name"`
+	Description string                `json:"description"`
+	SourceOrg   string                `json:"source_org"`
+	WorkflowId  string                `json:"workflow_id"`
+	Catalog     bool                  `json:"catalog"`
+	TargetOrgs  []string              `json:"target_orgs"`
+	Versions    []CslPublishedVersion `json:"versions"`
+	Installs    map[string]int        `json:"installs"`
+	Created     int64                 `json:"created"`
+	Updated     int64                 `json:"updated"`
+}
+
+type CslPublications struct {
+	Publications map[string]CslPublication `json:"publications"`
+}
+
+type CslPublicationIndexEntry struct {
+	Id            string   `json:"id"`
+	Name          string   `json:"name"`
+	Description   string   `json:"description"`
+	SourceOrg     string   `json:"source_org"`
+	SourceOrgName string   `json:"source_org_name"`
+	Catalog       bool     `json:"catalog"`
+	TargetOrgs    []string `json:"target_orgs"`
+	LatestVersion int      `json:"latest_version"`
+	Updated       int64    `json:"updated"`
+}
+
+type CslPublicationIndex struct {
+	Publications map[string]CslPublicationIndexEntry `json:"publications"`
+}
+
+// An installed publication. PinnedVersion 0 follows the latest version.
+// Workflows maps workflow ids of the bundle to the installed workflows, and
+// AuthMapping maps auth placeholders to auth in the org
+type CslSubscription struct {
+	PublicationId    string            `json:"publication_id"`
+	SourceOrg        string            `json:"source_org"`
+	Name             string            `json:"name"`
+	PinnedVersion    int               `json:"pinned_version"`
+	InstalledVersion int               `json:"installed_version"`
+	Workflows        map[string]string `json:"workflows"`
+	AuthMapping      map[string]string `json:"auth_mapping"`
+	MissingAuth      []CslBundleAuth   `json:"missing_auth"`
+	InstalledBy      string            `json:"installed_by"`
+	InstalledById    string            `json:"installed_by_id"`
+	InstalledAt      int64             `json:"installed_at"`
+	UpdatedAt        int64             `json:"updated_at"`
+}
+
+type CslSubscriptions struct {
+	Subscriptions map[string]CslSubscription `json:"subscriptions"`
+}
+
+type CslPublishRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Notes       string   `json:"notes"`
+	Catalog     bool     `json:"catalog"`
+	TargetOrgs  []string `json:"target_orgs"`
+}
+
+// Auth placeholder ids of the bundle mapped to auth ids in the org
+type CslInstallRequest struct {
+	AuthMapping map[string]string `json:"auth_mapping"`
+}
+
+type CslAvailablePublication struct {
+	CslPublicationIndexEntry
+	InstalledVersion int `json:"installed_version"`
+	PinnedVersion    int `json:"pinned_version"`
+}
+
+func getCslPublications(ctx context.Context, orgId string) CslPublications {
+	publications := CslPublications{}
+	_, err := getCslDocument(ctx, orgId, CslPublicationsDocument, &publications)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting publications for org %s: %s", orgId, err)
+	}
+
+	if publications.Publications == nil {
+		publications.Publications = map[string]CslPublication{}
+	}
+
+	return publications
+}
+
+func getCslPublicationIndex(ctx context.Context) CslPublicationIndex {
+	index := CslPublicationIndex{}
+	_, err := getCslDocument(ctx, CslPublicationIndexOrgId, CslPublicationIndexDocument, &index)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting publication index: %s", err)
+	}
+
+	if index.Publications == nil {
+		index.Publications = map[string]CslPublicationIndexEntry{}
+	}
+
+	return index
+}
+
+func getCslSubscriptions(ctx context.Context, orgId string) CslSubscriptions {
+	subscriptions := CslSubscriptions{}
+	_, err := getCslDocument(ctx, orgId, CslSubscriptionsDocument, &subscriptions)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting subscriptions for org %s: %s", orgId, err)
+	}
+
+	if subscriptions.Subscriptions == nil {
+		subscriptions.Subscriptions = map[string]CslSubscription{}
+	}
+
+	return subscriptions
+}
+
+func canAccessPublication(entry CslPublicationIndexEntry, orgId string) bool {
+	return entry.Catalog || entry.SourceOrg == orgId || shuffle.ArrayContains(entry.TargetOrgs, orgId)
+}
+
+// Finds a publication the org can install, with the requested version or the
+// latest one when version is 0
+func getAccessiblePublication(ctx context.Context, orgId, publicationId string, version int) (CslPublication, CslPublishedVersion, error) {
+	entry, ok := getCslPublicationIndex(ctx).Publications[publicationId]
+	if !ok || !canAccessPublication(entry, orgId) {
+		return CslPublication{}, CslPublishedVersion{}, errors.New("publication not found")
+	}
+
+	publication, ok := getCslPublications(ctx, entry.SourceOrg).Publications[publicationId]
+	if !ok || len(publication.Versions) == 0 {
+		return CslPublication{}, CslPublishedVersion{}, errors.New("publication not found")
+	}
+
+	if version == 0 {
+		return publication, publication.Versions[len(publication.Versions)-1], nil
+	}
+
+	for _, publishedVersion := range publication.Versions {
+		if publishedVersion.Version == version {
+			return publication, publishedVersion, nil
+		}
+	}
+
+	return CslPublication{}, CslPublishedVersion{}, errors.New(fmt.Sprintf("version %d of the publication isn't available", version))
+}
+
+// Keeps the ids and status of triggers that were already installed, matched
+// by type and label, so webhooks and schedules keep working after an update
+func keepInstalledTriggers(workflow *shuffle.Workflow, installed shuffle.Workflow) {
+	used := map[string]bool{}
+	for i, trigger := range workflow.Triggers {
+		for _, installedTrigger := range installed.Triggers {
+			if used[installedTrigger.ID] || installedTrigger.TriggerType != trigger.TriggerType || installedTrigger.Label != trigger.Label {
+				continue
+			}
+
+			used[installedTrigger.ID] = true
+			workflow.Triggers[i].ID = installedTrigger.ID
+			workflow.Triggers[i].Status = installedTrigger.Status
+			for j, branch := range workflow.Branches {
+				if branch.SourceID == trigger.ID {
+					workflow.Branches[j].SourceID = installedTrigger.ID
+				}
+
+				if branch.DestinationID == trigger.ID {
+					workflow.Branches[j].DestinationID = installedTrigger.ID
+				}
+			}
+
+			break
+		}
+	}
+}
+
+// Bundle workflows installed in an org. Workflows maps the workflow ids of
+// the bundle to the installed workflows
+type CslBundleInstall struct {
+	Workflows   map[string]string `json:"workflows"`
+	AuthMapping map[string]string `json:"auth_mapping"`
+	MissingAuth []CslBundleAuth   `json:"missing_auth"`
+}
+
+// Installs the workflows of a bundle in an org. Workflows already installed
+// from the bundle are overwritten, while the others get new ids. Auth
+// placeholders are mapped with authOverrides first, then the previous mapping
+// and last to auth with the same app and label
+func installCslBundle(ctx context.Context, orgId string, installer shuffle.User, source CslWorkflowBundle, previous CslBundleInstall, authOverrides map[string]string) (CslBundleInstall, error) {
+	result := CslBundleInstall{
+		Workflows:   map[string]string{},
+		AuthMapping: map[string]string{},
+		MissingAuth: []CslBundleAuth{},
+	}
+
+	// Bundles are copied, as the workflows are rewritten for the org
+	bundle := CslWorkflowBundle{}
+	bundleData, err := json.Marshal(source)
+	if err == nil {
+		err = json.Unmarshal(bundleData, &bundle)
+	}
+
+	if err != nil {
+		return result, err
+	}
+
+	workflowMapping := map[string]string{}
+	for _, workflow := range bundle.Workflows {
+		workflowMapping[workflow.ID] = previous.Workflows[workflow.ID]
+		if len(workflowMapping[workflow.ID]) == 0 {
+			workflowMapping[workflow.ID] = uuid.NewV4().String()
+		}
+	}
+
+	authMapping, _ := mapBundleAuth(ctx, orgId, bundle.Auth)
+	for placeholder, authId := range previous.AuthMapping {
+		authMapping[placeholder] = authId
+	}
+
+	if len(authOverrides) > 0 {
+		auths, err := shuffle.GetAllWorkflowAppAuth(ctx, orgId)
+		if err != nil {
+			return result, err
+		}
+
+		for placeholder, authId := range authOverrides {
+			found := false
+			for _, auth := range auths {
+				if auth.Id == authId {
+					found = true
+				}
+			}
+
+			if !found {
+				return result, errors.New(fmt.Sprintf("auth %s for placeholder %s doesn't exist in the organization", authId, placeholder))
+			}
+
+			authMapping[placeholder] = authId
+		}
+	}
+
+	for _, placeholder := range bundle.Auth {
+		if len(authMapping[placeholder.Id]) > 0 {
+			result.AuthMapping[placeholder.Id] = authMapping[placeholder.Id]
+		} else {
+			result.MissingAuth = append(result.MissingAuth, placeholder)
+		}
+	}
+
+	installer.ActiveOrg = shuffle.OrgMini{Id: orgId}
+	for _, workflow := range bundle.Workflows {
+		sourceId := workflow.ID
+		prepared := prepareImportedWorkflow(workflow, installer, workflowMapping, authMapping)
+
+		installed, err := shuffle.GetWorkflow(ctx, prepared.ID)
+		if err == nil && installed.OrgId == orgId {
+			keepInstalledTriggers(&prepared, *installed)
+			prepared.Created = installed.Created
+		}
+
+		err = shuffle.SetWorkflow(ctx, prepared, prepared.ID)
+		if err != nil {
+			log.Printf("[ERROR] Failed installing workflow %s in org %s: %s", sourceId, orgId, err)
+			return result, err
+		}
+
+		result.Workflows[sourceId] = prepared.ID
+	}
+
+	return result, nil
+}
+
+// Installs or updates a version of a publication in an org
+func installCslPublication(ctx context.Context, orgId string, installer shuffle.User, version CslPublishedVersion, subscription CslSubscription, authOverrides map[string]string) (CslSubscription, error) {
+	if version.Bundle == nil {
+		return subscription, errors.New("the publication version has no workflows")
+	}
+
+	previous := CslBundleInstall{
+		Workflows:   subscription.Workflows,
+		AuthMapping: subscription.AuthMapping,
+	}
+
+	installed, err := installCslBundle(ctx, orgId, installer, *version.Bundle, previous, authOverrides)
+	if err != nil {
+		return subscription, err
+	}
+
+	subscription.Workflows = installed.Workflows
+	subscription.AuthMapping = installed.AuthMapping
+	subscription.MissingAuth = installed.MissingAuth
+
+	now := time.Now().Unix()
+	if subscription.InstalledAt == 0 {
+		subscription.InstalledAt = now
+	}
+
+	subscription.InstalledVersion = version.Version
+	subscription.UpdatedAt = now
+
+	subscriptions := CslSubscriptions{}
+	err = updateCslDocument(ctx, orgId, CslSubscriptionsDocument, &subscriptions, func() error {
+		if subscriptions.Subscriptions == nil {
+			subscriptions.Subscriptions = map[string]CslSubscription{}
+		}
+
+		subscriptions.Subscriptions[subscription.PublicationId] = subscription
+		return nil
+	})
+	if err != nil {
+		return subscription, err
+	}
+
+	publications := CslPublications{}
+	err = updateCslDocument(ctx, subscription.SourceOrg, CslPublicationsDocument, &publications, func() error {
+		publication, ok := publications.Publications[subscription.PublicationId]
+		if !ok {
+			return nil
+		}
+
+		if publication.Installs == nil {
+			publication.Installs = map[string]int{}
+		}
+
+		publication.Installs[orgId] = version.Version
+		publications.Publications[publication.Id] = publication
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed recording install of publication %s in org %s: %s", subscription.PublicationId, orgId, err)
+	}
+
+	return subscription, nil
+}
+
+// Updates installs that follow the latest version of a publication
+func updateCslPublicationInstalls(ctx context.Context, publication CslPublication) {
+	version := publication.Versions[len(publication.Versions)-1]
+	for orgId := range publication.Installs {
+		subscription, ok := getCslSubscriptions(ctx, orgId).Subscriptions[publication.Id]
+		if !ok || subscription.PinnedVersion != 0 || subscription.InstalledVersion == version.Version {
+			continue
+		}
+
+		if !publication.Catalog && !shuffle.ArrayContains(publication.TargetOrgs, orgId) {
+			continue
+		}
+
+		installer := shuffle.User{Id: subscription.InstalledById, Username: subscription.InstalledBy}
+		_, err := installCslPublication(ctx, orgId, installer, version, subscription, nil)
+		if err != nil {
+			log.Printf("[ERROR] Failed updating publication %s in org %s to version %d: %s", publication.Id, orgId, version.Version, err)
+			continue
+		}
+
+		log.Printf("[AUDIT] Updated publication %s in org %s to version %d", publication.Id, orgId, version.Version)
+		recordCslActivity(ctx, orgId, ActivityTypeWorkflow, "publication_updated", fmt.Sprintf("%s was updated to version %d", publication.Name, version.Version), "publishing", publication.Id)
+	}
+}
+
+func getVersionParameter(request *http.Request) (int, error) {
+	value := request.URL.Query().Get("version")
+	if len(value) == 0 || value == "latest" {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, errors.New("version must be a positive number or latest")
+	}
+
+	return version, nil
+}
+
+/*
+Sharing:
+Publishes a workflow with ?workflow_id=<id> to other orgs, or to the shared
+catalog every org can install from. Requires org admin. Publishing a
+workflow again adds a new version and updates the targets. Target orgs must
+be orgs the publisher is a member of. Auth is replaced with placeholders
+that target orgs map to their own auth when installing.
+
+	{
+	    "name": "Phishing triage",
+	    "description": "Golden phishing playbook",
+	    "notes": "Adds URL detonation",
+	    "catalog": false,
+	    "target_orgs": ["<org id>", "<org id>"]
+	}
+
+Returns the publication without bundles.
+*/
+func cslPublishWorkflow(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	workflow, err := getCslWorkflow(ctx, *user, request.URL.Query().Get("workflow_id"))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	publishRequest := CslPublishRequest{}
+	err = json.Unmarshal(body, &publishRequest)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	for _, targetOrg := range publishRequest.TargetOrgs {
+		if targetOrg == user.ActiveOrg.Id || !shuffle.ArrayContains(user.Orgs, targetOrg) {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("target org %s must be another org you're a member of", targetOrg))))
+			return
+		}
+	}
+
+	if !publishRequest.Catalog && len(publishRequest.TargetOrgs) == 0 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("target_orgs is required unless publishing to the catalog")))
+		return
+	}
+
+	bundle, err := createWorkflowBundle(ctx, *user, *workflow)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	now := time.Now().Unix()
+	publication := CslPublication{}
+	publications := CslPublications{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslPublicationsDocument, &publications, func() error {
+		if publications.Publications == nil {
+			publications.Publications = map[string]CslPublication{}
+		}
+
+		for _, existing := range publications.Publications {
+			if existing.WorkflowId == workflow.ID {
+				publication = existing
+			}
+		}
+
+		if len(publication.Id) == 0 {
+			publication = CslPublication{
+				Id:         uuid.NewV4().String(),
+				SourceOrg:  user.ActiveOrg.Id,
+				WorkflowId: workflow.ID,
+				Installs:   map[string]int{},
+				Created:    now,
+			}
+		}
+
+		version := 1
+		if len(publication.Versions) > 0 {
+			version = publication.Versions[len(publication.Versions)-1].Version + 1
+		}
+
+		publication.Name = publishRequest.Name
+		if len(publication.Name) == 0 {
+			publication.Name = workflow.Name
+		}
+
+		publication.Description = publishRequest.Description
+		publication.Catalog = publishRequest.Catalog
+		publication.TargetOrgs = publishRequest.TargetOrgs
+		publication.Updated = now
+		publication.Versions = append(publication.Versions, CslPublishedVersion{
+			Version:     version,
+			PublishedAt: now,
+			PublishedBy: user.Username,
+			Notes:       publishRequest.Notes,
+			Bundle:      &bundle,
+		})
+
+		if len(publication.Versions) > MaxPublicationVersions {
+			publication.Versions = publication.Versions[len(publication.Versions)-MaxPublicationVersions:]
+		}
+
+		publications.Publications[publication.Id] = publication
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	index := CslPublicationIndex{}
+	err = updateCslDocument(ctx, CslPublicationIndexOrgId, CslPublicationIndexDocument, &index, func() error {
+		if index.Publications == nil {
+			index.Publications = map[string]CslPublicationIndexEntry{}
+		}
+
+		index.Publications[publication.Id] = CslPublicationIndexEntry{
+			Id:            publication.Id,
+			Name:          publication.Name,
+			Description:   publication.Description,
+			SourceOrg:     publication.SourceOrg,
+			SourceOrgName: user.ActiveOrg.Name,
+			Catalog:       publication.Catalog,
+			TargetOrgs:    publication.TargetOrgs,
+			LatestVersion: publication.Versions[len(publication.Versions)-1].Version,
+			Updated:       now,
+		}
+
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	latest := publication.Versions[len(publication.Versions)-1].Version
+	log.Printf("[AUDIT] User %s (%s) published version %d of workflow %s as publication %s. Catalog: %t, targets: %v", user.Username, user.Id, latest, workflow.ID, publication.Id, publication.Catalog, publication.TargetOrgs)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeWorkflow, "published", fmt.Sprintf("Version %d of %s was published", latest, publication.Name), user.Username, workflow.ID)
+
+	updateCslPublicationInstalls(ctx, publication)
+
+	for i := range publication.Versions {
+		publication.Versions[i].Bundle = nil
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    publication,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslPublishWorkflow")
+}
+
+/*
+Sharing:
+Returns the publications of the current organization with their versions and
+which orgs installed which version. Bundles are left out.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "id": "...",
+	            "name": "Phishing triage",
+	            "description": "Golden phishing playbook",
+	            "source_org": "...",
+	            "workflow_id": "...",
+	            "catalog": false,
+	            "target_orgs": ["..."],
+	            "versions": [
+	                {
+	                    "version": 2,
+	                    "published_at": 1700000000,
+	                    "published_by": "admin@example.com",
+	                    "notes": "Adds URL detonation"
+	                }
+	            ],
+	            "installs": {"<org id>": 2},
+	            "created": 1690000000,
+	            "updated": 1700000000
+	        }
+	    ]
+	}
+*/
+func cslListPublications(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	publications := []CslPublication{}
+	for _, publication := range getCslPublications(ctx, user.ActiveOrg.Id).Publications {
+		for i := range publication.Versions {
+			publication.Versions[i].Bundle = nil
+		}
+
+		publications = append(publications, publication)
+	}
+
+	sort.Slice(publications, func(i, j int) bool {
+		return publications[i].Updated > publications[j].Updated
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data:    publications,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslListPublications")
+}
+
+/*
+Sharing:
+Stops sharing the publication ?publication_id=<id>. Requires org admin of
+the source org. Installed copies stay in the target orgs, but aren't
+updated anymore.
+*/
+func cslUnpublishWorkflow(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	publicationId := request.URL.Query().Get("publication_id")
+
+	publications := CslPublications{}
+	err := updateCslDocument(ctx, user.ActiveOrg.Id, CslPublicationsDocument, &publications, func() error {
+		if _, ok := publications.Publications[publicationId]; !ok {
+			return errors.New("publication not found")
+		}
+
+		delete(publications.Publications, publicationId)
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	index := CslPublicationIndex{}
+	err = updateCslDocument(ctx, CslPublicationIndexOrgId, CslPublicationIndexDocument, &index, func() error {
+		delete(index.Publications, publicationId)
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) unpublished publication %s of org %s", user.Username, user.Id, publicationId, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeWorkflow, "unpublished", "A workflow publication was removed", user.Username, publicationId)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslUnpublishWorkflow")
+}
+
+/*
+Sharing:
+Returns the publications the current organization can install, which are the
+ones shared with it and the ones in the catalog. Only catalog publications
+are returned with ?catalog=true. Installed publications have their installed
+and pinned versions, where pinned_version 0 follows the latest version.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "id": "...",
+	            "name": "Phishing triage",
+	            "description": "Golden phishing playbook",
+	            "source_org": "...",
+	            "source_org_name": "MSSP",
+	            "catalog": false,
+	            "target_orgs": ["..."],
+	            "latest_version": 2,
+	            "updated": 1700000000,
+	            "installed_version": 1,
+	            "pinned_version": 1
+	        }
+	    ]
+	}
+*/
+func cslAvailablePublications(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	catalogOnly := request.URL.Query().Get("catalog") == "true"
+
+	subscriptions := getCslSubscriptions(ctx, user.ActiveOrg.Id).Subscriptions
+	available := []CslAvailablePublication{}
+	for _, entry := range getCslPublicationIndex(ctx).Publications {
+		if entry.SourceOrg == user.ActiveOrg.Id || !canAccessPublication(entry, user.ActiveOrg.Id) || (catalogOnly && !entry.Catalog) {
+			continue
+		}
+
+		// Target orgs of other orgs aren't shown
+		if entry.Catalog {
+			entry.TargetOrgs = []string{}
+		} else {
+			entry.TargetOrgs = []string{user.ActiveOrg.Id}
+		}
+
+		subscription := subscriptions[entry.Id]
+		available = append(available, CslAvailablePublication{
+			CslPublicationIndexEntry: entry,
+			InstalledVersion:         subscription.InstalledVersion,
+			PinnedVersion:            subscription.PinnedVersion,
+		})
+	}
+
+	sort.Slice(available, func(i, j int) bool {
+		return available[i].Updated > available[j].Updated
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data:    available,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslAvailablePublications")
+}
+
+/*
+Sharing:
+Installs ?publication_id=<id> in the current organization, or updates an
+installed one. Requires org admin. With ?version=<n> the install is pinned
+to that version and isn't updated when new versions are published, while
+?version=latest or no version follows the latest version. Auth placeholders
+are mapped to auth with the same app and label, or with the optional body:
+
+	{
+	    "auth_mapping": {"<placeholder id>": "<auth id>"}
+	}
+
+Placeholders that couldn't be mapped are returned as missing_auth, and the
+actions using them have no auth until the publication is installed again
+with a mapping.
+
+	{
+	    "success": true,
+	    "data": {
+	        "publication_id": "...",
+	        "source_org": "...",
+	        "name": "Phishing triage",
+	        "pinned_version": 0,
+	        "installed_version": 2,
+	        "workflows": {"<source workflow id>": "<installed workflow id>"},
+	        "auth_mapping": {"<placeholder id>": "<auth id>"},
+	        "missing_auth": [
+	            {"id": "...", "label": "VirusTotal prod", "app_name": "VirusTotal", "fields": ["apikey"]}
+	        ],
+	        "installed_by": "admin@example.com",
+	        "installed_at": 1700000000,
+	        "updated_at": 1700000000
+	    }
+	}
+*/
+func cslInstallPublication(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	publicationId := request.URL.Query().Get("publication_id")
+
+	version, err := getVersionParameter(request)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	installRequest := CslInstallRequest{}
+	body, err := ioutil.ReadAll(request.Body)
+	if err == nil && len(body) > 0 {
+		err = json.Unmarshal(body, &installRequest)
+	}
+
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	publication, publishedVersion, err := getAccessiblePublication(ctx, user.ActiveOrg.Id, publicationId, version)
+	if err != nil {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if publication.SourceOrg == user.ActiveOrg.Id {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("publications can't be installed in their source org")))
+		return
+	}
+
+	subscription, ok := getCslSubscriptions(ctx, user.ActiveOrg.Id).Subscriptions[publication.Id]
+	if !ok {
+		subscription = CslSubscription{
+			PublicationId: publication.Id,
+			SourceOrg:     publication.SourceOrg,
+			InstalledBy:   user.Username,
+			InstalledById: user.Id,
+		}
+	}
+
+	subscription.Name = publication.Name
+	subscription.PinnedVersion = version
+
+	subscription, err = installCslPublication(ctx, user.ActiveOrg.Id, *user, publishedVersion, subscription, installRequest.AuthMapping)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) installed version %d of publication %s from org %s in org %s", user.Username, user.Id, publishedVersion.Version, publication.Id, publication.SourceOrg, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeWorkflow, "publication_installed", fmt.Sprintf("Version %d of %s was installed", publishedVersion.Version, publication.Name), user.Username, publication.Id)
+
+	res := CslResponse{
+		Success: true,
+		Data:    subscription,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslInstallPublication")
+}
+
+/*
+Sharing:
+Returns the publications installed in the current organization, in the
+format returned from install.
+*/
+func cslListSubscriptions(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	subscriptions := []CslSubscription{}
+	for _, subscription := range getCslSubscriptions(ctx, user.ActiveOrg.Id).Subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].Name < subscriptions[j].Name
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data:    subscriptions,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslListSubscriptions")
+}
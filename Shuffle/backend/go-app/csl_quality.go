@@ -0,0 +1,377 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Thresholds for complexity findings
+const MaxRecommendedNodes = 50
+const MaxRecommendedDepth = 15
+
+// Finding severities and the penalty they give on the quality score
+const (
+	FindingSeverityHigh   = "high"
+	FindingSeverityMedium = "medium"
+	FindingSeverityLow    = "low"
+)
+
+var findingPenalties = map[string]float64{
+	FindingSeverityHigh:   20,
+	FindingSeverityMedium: 10,
+	FindingSeverityLow:    5,
+}
+
+// Parameter names that usually hold secrets
+var secretParameterPattern = regexp.MustCompile(`(?i)(password|passwd|secret|token|api_?key|apikey|authorization|private_?key|client_?secret)`)
+
+// Values that look like well known secret formats, regardless of parameter name
+var secretValuePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`AKIA[0-9A-Z]{16}`),
+	regexp.MustCompile(`gh[pousr]_[A-Za-z0-9]{36}`),
+	regexp.MustCompile(`xox[baprs]-[A-Za-z0-9-]{10,}`),
+	regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9\-_\.=]{20,}`),
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+}
+
+type CslWorkflowFinding struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	NodeId   string `json:"node_id,omitempty"`
+}
+
+type CslWorkflowQuality struct {
+	WorkflowId      string               `json:"workflow_id"`
+	Name            string               `json:"name"`
+	NodeCount       int                  `json:"node_count"`
+	BranchCount     int                  `json:"branch_count"`
+	MaxDepth        int                  `json:"max_depth"`
+	ComplexityScore float64              `json:"complexity_score"`
+	QualityScore    float64              `json:"quality_score"`
+	Findings        []CslWorkflowFinding `json:"findings"`
+}
+
+type CslWorkflowQualityResponse struct {
+	AverageQualityScore float64              `json:"average_quality_score"`
+	TotalFindings       int                  `json:"total_findings"`
+	Workflows           []CslWorkflowQuality `json:"workflows"`
+}
+
+// Values referencing variables or other nodes are resolved at runtime and aren't hardcoded
+func isVariableReference(value string) bool {
+	return strings.Contains(value, "$") || strings.Contains(value, "{{")
+}
+
+func looksLikeHardcodedSecret(param shuffle.WorkflowAppActionParameter) bool {
+	value := strings.TrimSpace(param.Value)
+	if len(value) == 0 || isVariableReference(value) {
+		return false
+	}
+
+	for _, pattern := range secretValuePatterns {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+
+	// Short values in secret fields are usually placeholders or booleans
+	return secretParameterPattern.MatchString(param.Name) && len(value) >= 8
+}
+
+// Longest path from the start node following branches. Cycles are cut at the first revisit
+func getWorkflowMaxDepth(workflow shuffle.Workflow) int {
+	children := map[string][]string{}
+	for _, branch := range workflow.Branches {
+		children[branch.SourceID] = append(children[branch.SourceID], branch.DestinationID)
+	}
+
+	depths := map[string]int{}
+	inProgress := map[string]bool{}
+
+	var walk func(nodeId string) int
+	walk = func(nodeId string) int {
+		if depth, ok := depths[nodeId]; ok {
+			return depth
+		}
+
+		if inProgress[nodeId] {
+			return 0
+		}
+
+		inProgress[nodeId] = true
+		maxChildDepth := 0
+		for _, child := range children[nodeId] {
+			depth := walk(child)
+			if depth > maxChildDepth {
+				maxChildDepth = depth
+			}
+		}
+
+		inProgress[nodeId] = false
+		depths[nodeId] = maxChildDepth + 1
+		return depths[nodeId]
+	}
+
+	maxDepth := 0
+	startNodes := []string{workflow.Start}
+	for _, trigger := range workflow.Triggers {
+		startNodes = append(startNodes, trigger.ID)
+	}
+
+	for _, startNode := range startNodes {
+		if len(startNode) == 0 {
+			continue
+		}
+
+		depth := walk(startNode)
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	}
+
+	return maxDepth
+}
+
+// Nodes that can't be reached from the start node or a trigger
+func getUnreachableActions(workflow shuffle.Workflow) []shuffle.Action {
+	children := map[string][]string{}
+	for _, branch := range workflow.Branches {
+		children[branch.SourceID] = append(children[branch.SourceID], branch.DestinationID)
+	}
+
+	reached := map[string]bool{}
+	queue := []string{workflow.Start}
+	for _, trigger := range workflow.Triggers {
+		queue = append(queue, trigger.ID)
+	}
+
+	for len(queue) > 0 {
+		nodeId := queue[0]
+		queue = queue[1:]
+		if reached[nodeId] {
+			continue
+		}
+
+		reached[nodeId] = true
+		queue = append(queue, children[nodeId]...)
+	}
+
+	unreachable := []shuffle.Action{}
+	for _, action := range workflow.Actions {
+		if !reached[action.ID] {
+			unreachable = append(unreachable, action)
+		}
+	}
+
+	return unreachable
+}
+
+// A workflow handles errors if it exits on error or has branch conditions checking results
+func hasErrorHandling(workflow shuffle.Workflow) bool {
+	if workflow.Configuration.ExitOnError {
+		return true
+	}
+
+	for _, branch := range workflow.Branches {
+		for _, condition := range branch.Conditions {
+			source := strings.ToLower(condition.Source.Value + condition.Destination.Value)
+			if strings.Contains(source, "success") || strings.Contains(source, "status") || strings.Contains(source, "error") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func getActionName(action shuffle.Action) string {
+	if len(action.Label) > 0 {
+		return action.Label
+	}
+
+	return action.Name
+}
+
+// Scores a workflow on complexity and quality and returns findings that can be acted on
+func analyzeWorkflowQuality(workflow shuffle.Workflow) CslWorkflowQuality {
+	quality := CslWorkflowQuality{
+		WorkflowId:  workflow.ID,
+		Name:        workflow.Name,
+		NodeCount:   len(workflow.Actions) + len(workflow.Triggers),
+		BranchCount: len(workflow.Branches),
+		MaxDepth:    getWorkflowMaxDepth(workflow),
+		Findings:    []CslWorkflowFinding{},
+	}
+
+	// Complexity grows with nodes, decision points and depth
+	quality.ComplexityScore = roundScore(float64(quality.NodeCount) + float64(quality.BranchCount)*0.5 + float64(quality.MaxDepth)*2)
+
+	if quality.NodeCount > MaxRecommendedNodes {
+		quality.Findings = append(quality.Findings, CslWorkflowFinding{
+			Severity: FindingSeverityMedium,
+			Code:     "too_many_nodes",
+			Message:  fmt.Sprintf("Workflow has %d nodes. Consider splitting it into subflows (recommended max %d)", quality.NodeCount, MaxRecommendedNodes),
+		})
+	}
+
+	if quality.MaxDepth > MaxRecommendedDepth {
+		quality.Findings = append(quality.Findings, CslWorkflowFinding{
+			Severity: FindingSeverityLow,
+			Code:     "deep_branching",
+			Message:  fmt.Sprintf("Longest path is %d nodes deep (recommended max %d)", quality.MaxDepth, MaxRecommendedDepth),
+		})
+	}
+
+	if len(workflow.Actions) > 1 && !hasErrorHandling(workflow) {
+		quality.Findings = append(quality.Findings, CslWorkflowFinding{
+			Severity: FindingSeverityMedium,
+			Code:     "missing_error_handling",
+			Message:  "No branch checks node results and exit on error is disabled. Failures will go unnoticed",
+		})
+	}
+
+	for _, action := range workflow.Actions {
+		for _, param := range action.Parameters {
+			if looksLikeHardcodedSecret(param) {
+				quality.Findings = append(quality.Findings, CslWorkflowFinding{
+					Severity: FindingSeverityHigh,
+					Code:     "hardcoded_secret",
+					Message:  fmt.Sprintf("Parameter %s in %s looks like a hardcoded secret. Use app authentication instead", param.Name, getActionName(action)),
+					NodeId:   action.ID,
+				})
+			}
+		}
+
+		if len(action.Errors) > 0 {
+			quality.Findings = append(quality.Findings, CslWorkflowFinding{
+				Severity: FindingSeverityMedium,
+				Code:     "node_errors",
+				Message:  fmt.Sprintf("%s has errors: %s", getActionName(action), strings.Join(action.Errors, ", ")),
+				NodeId:   action.ID,
+			})
+		}
+	}
+
+	for _, action := range getUnreachableActions(workflow) {
+		quality.Findings = append(quality.Findings, CslWorkflowFinding{
+			Severity: FindingSeverityLow,
+			Code:     "unreachable_node",
+			Message:  fmt.Sprintf("%s can't be reached from the start node", getActionName(action)),
+			NodeId:   action.ID,
+		})
+	}
+
+	quality.QualityScore = 100
+	for _, finding := range quality.Findings {
+		quality.QualityScore -= findingPenalties[finding.Severity]
+	}
+
+	if quality.QualityScore < 0 {
+		quality.QualityScore = 0
+	}
+
+	return quality
+}
+
+/*
+Dashboard:
+Returns complexity and quality scores for the orgs workflows, lowest quality first,
+with findings such as hardcoded secrets, missing error handling and unreachable nodes.
+Use ?workflow_id=<id> to analyze a single workflow.
+
+	{
+	    "success": true,
+	    "data": {
+	        "average_quality_score": 85,
+	        "total_findings": 3,
+	        "workflows": [
+	            {
+	                "workflow_id": "...",
+	                "name": "Phishing triage",
+	                "node_count": 12,
+	                "branch_count": 11,
+	                "max_depth": 8,
+	                "complexity_score": 33.5,
+	                "quality_score": 70,
+	                "findings": [
+	                    {
+	                        "severity": "high",
+	                        "code": "hardcoded_secret",
+	                        "message": "Parameter apikey in Get report looks like a hardcoded secret. Use app authentication instead",
+	                        "node_id": "..."
+	                    }
+	                ]
+	            }
+	        ]
+	    }
+	}
+*/
+func cslWorkflowQuality(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	var workflows []shuffle.Workflow
+	var err error
+	if useCslMockData(resp, request) {
+		workflows = getCslMockData(ctx, user.ActiveOrg.Id).WorkflowList
+	} else {
+		workflows, err = shuffle.GetAllWorkflowsByQuery(ctx, *user)
+		if err != nil {
+			log.Printf("[ERROR] Failed getting workflows for user %s: %s", user.Username, err)
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+	}
+
+	workflowId := request.URL.Query().Get("workflow_id")
+
+	response := CslWorkflowQualityResponse{
+		Workflows: []CslWorkflowQuality{},
+	}
+
+	totalScore := 0.0
+	for _, workflow := range workflows {
+		if len(workflowId) > 0 && workflow.ID != workflowId {
+			continue
+		}
+
+		quality := analyzeWorkflowQuality(workflow)
+		response.Workflows = append(response.Workflows, quality)
+		response.TotalFindings += len(quality.Findings)
+		totalScore += quality.QualityScore
+	}
+
+	if len(workflowId) > 0 && len(response.Workflows) == 0 {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New("workflow not found")))
+		return
+	}
+
+	if len(response.Workflows) > 0 {
+		response.AverageQualityScore = roundScore(totalScore / float64(len(response.Workflows)))
+	}
+
+	sort.SliceStable(response.Workflows, func(i, j int) bool {
+		return response.Workflows[i].QualityScore < response.Workflows[j].QualityScore
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data:    response,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslWorkflowQuality")
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Long-polling of the workflow queue.
+// Orborus can send X-Queue-Wait: <seconds> to have an empty queue request held
+// open until an execution is queued for the environment, instead of polling
+// every few seconds. Notifications are delivered across replicas through the
+// message bus (SHUFFLE_MESSAGE_BUS), or only within this replica without one.
+
+const QueueWaitHeader = "X-Queue-Wait"
+const MaxQueueWaitSeconds = 30
+
+// The waiter is registered before the queue is read, so executions queued
+// in between aren't missed
+func getWorkflowQueueWithWait(ctx context.Context, request *http.Request, environment string) (shuffle.ExecutionRequestWrapper, error) {
+	wait, err := strconv.Atoi(request.Header.Get(QueueWaitHeader))
+	if err != nil || wait <= 0 {
+		return shuffle.GetWorkflowQueue(ctx, environment, 100)
+	}
+
+	if wait > MaxQueueWaitSeconds {
+		wait = MaxQueueWaitSeconds
+	}
+
+	notify, done := shuffle.WaitForWorkflowQueue(environment)
+	defer done()
+
+	timer := time.NewTimer(time.Duration(wait) * time.Second)
+	defer timer.Stop()
+
+	for {
+		executionRequests, err := shuffle.GetWorkflowQueue(ctx, environment, 100)
+		if err != nil || len(executionRequests.Data) > 0 {
+			return executionRequests, err
+		}
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return executionRequests, nil
+		case <-request.Context().Done():
+			return executionRequests, nil
+		}
+	}
+}
+
+// Queue backlog. Depth is counted up to MaxBacklogCount executions, and the
+// age of the oldest queued execution is found among the first
+// BacklogAgeSamples, as each needs the execution to be loaded.
+
+const MaxBacklogCount = 1000
+const BacklogAgeSamples = 20
+
+type CslEnvironmentBacklog struct {
+	Id                string `json:"id"`
+	Name              string `json:"name"`
+	Depth             int    `json:"depth"`
+	OldestAgeSeconds  int64  `json:"oldest_age_seconds"`
+	OldestExecutionId string `json:"oldest_execution_id,omitempty"`
+}
+
+type CslQueueBacklog struct {
+	GeneratedAt      int64                   `json:"generated_at"`
+	Depth            int                     `json:"depth"`
+	OldestAgeSeconds int64                   `json:"oldest_age_seconds"`
+	Environments     []CslEnvironmentBacklog `json:"environments"`
+}
+
+func getEnvironmentBacklog(ctx context.Context, environment shuffle.Environment) (CslEnvironmentBacklog, error) {
+	backlog := CslEnvironmentBacklog{
+		Id:   environment.Id,
+		Name: environment.Name,
+	}
+
+	queue, err := shuffle.GetWorkflowQueue(ctx, environment.Name, MaxBacklogCount)
+	if err != nil {
+		return backlog, err
+	}
+
+	backlog.Depth = len(queue.Data)
+
+	oldest := int64(0)
+	for index, executionRequest := range queue.Data {
+		if index >= BacklogAgeSamples {
+			break
+		}
+
+		execution, err := shuffle.GetWorkflowExecution(ctx, executionRequest.ExecutionId)
+		if err != nil || execution.StartedAt == 0 {
+			continue
+		}
+
+		if oldest == 0 || execution.StartedAt < oldest {
+			oldest = execution.StartedAt
+			backlog.OldestExecutionId = execution.ExecutionId
+		}
+	}
+
+	if oldest > 0 && time.Now().Unix() > oldest {
+		backlog.OldestAgeSeconds = time.Now().Unix() - oldest
+	}
+
+	return backlog, nil
+}
+
+// Backlog of every active Orborus environment of an org
+func getCslQueueBacklog(ctx context.Context, orgId string) (CslQueueBacklog, error) {
+	backlog := CslQueueBacklog{
+		GeneratedAt:  time.Now().Unix(),
+		Environments: []CslEnvironmentBacklog{},
+	}
+
+	environments, err := shuffle.GetEnvironments(ctx, orgId)
+	if err != nil {
+		return backlog, err
+	}
+
+	for _, environment := range environments {
+		if environment.Archived || environment.Type == "cloud" {
+			continue
+		}
+
+		environmentBacklog, err := getEnvironmentBacklog(ctx, environment)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting queue backlog of environment %s in org %s: %s", environment.Name, orgId, err)
+		}
+
+		backlog.Depth += environmentBacklog.Depth
+		if environmentBacklog.OldestAgeSeconds > backlog.OldestAgeSeconds {
+			backlog.OldestAgeSeconds = environmentBacklog.OldestAgeSeconds
+		}
+
+		backlog.Environments = append(backlog.Environments, environmentBacklog)
+	}
+
+	return backlog, nil
+}
+
+/*
+Dashboard:
+Returns the executions waiting to be picked up by Orborus in the current
+organization, in total and per environment. oldest_age_seconds is how long the
+oldest queued execution has waited. The same numbers are exported for
+Prometheus at /api/v1/csl/metrics.
+
+	{
+	    "success": true,
+	    "data": {
+	        "generated_at": 1718000000,
+	        "depth": 14,
+	        "oldest_age_seconds": 95,
+	        "environments": [
+	            {
+	                "id": "a5d3...",
+	                "name": "Shuffle",
+	                "depth": 14,
+	                "oldest_age_seconds": 95,
+	                "oldest_execution_id": "8f0c..."
+	            }
+	        ]
+	    }
+	}
+*/
+func cslQueueBacklog(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	backlog, err := getCslQueueBacklog(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    backlog,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslQueueBacklog")
+}
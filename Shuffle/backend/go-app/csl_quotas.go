@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslQuotasDocument = "quotas"
+
+// Quota names
+const (
+	QuotaExecutionsPerDay = "executions_per_day"
+	QuotaApiCallsPerDay   = "api_calls_per_day"
+	QuotaAppRunsPerMonth  = "app_runs_per_month"
+)
+
+// Quota statuses
+const (
+	QuotaStatusUnlimited = "unlimited"
+	QuotaStatusOk        = "ok"
+	QuotaStatusWarning   = "warning"
+	QuotaStatusExceeded  = "exceeded"
+)
+
+// Routes counted against each quota, as registered in main.go
+var quotaRoutes = map[string][]string{
+	QuotaExecutionsPerDay: {
+		"/api/v1/workflows/{key}/run",
+		"/api/v1/workflows/{key}/execute",
+	},
+	QuotaAppRunsPerMonth: {
+		"/api/v1/apps/{key}/run",
+		"/api/v1/apps/{key}/execute",
+		"/api/v1/apps/categories/run",
+	},
+}
+
+// Soft limits send a warning, hard limits reject requests with 429.
+// 0 means no limit
+type CslQuotaLimit struct {
+	Soft int64 `json:"soft"`
+	Hard int64 `json:"hard"`
+}
+
+type CslQuotas struct {
+	ExecutionsPerDay CslQuotaLimit `json:"executions_per_day"`
+	ApiCallsPerDay   CslQuotaLimit `json:"api_calls_per_day"`
+	AppRunsPerMonth  CslQuotaLimit `json:"app_runs_per_month"`
+
+	// The period each quota was last warned about, so warnings are sent once per period
+	Notified map[string]string `json:"notified,omitempty"`
+}
+
+type CslQuotaStatus struct {
+	Name      string `json:"name"`
+	Period    string `json:"period"`
+	Used      int64  `json:"used"`
+	Soft      int64  `json:"soft"`
+	Hard      int64  `json:"hard"`
+	Remaining int64  `json:"remaining"`
+	Status    string `json:"status"`
+	ResetsAt  int64  `json:"resets_at"`
+}
+
+func getCslQuotas(ctx context.Context, orgId string) CslQuotas {
+	quotas := CslQuotas{}
+	_, err := getCslDocument(ctx, orgId, CslQuotasDocument, &quotas)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting quotas for org %s: %s", orgId, err)
+	}
+
+	if quotas.Notified == nil {
+		quotas.Notified = map[string]string{}
+	}
+
+	return quotas
+}
+
+func (quotas CslQuotas) hasLimits() bool {
+	for _, limit := range []CslQuotaLimit{quotas.ExecutionsPerDay, quotas.ApiCallsPerDay, quotas.AppRunsPerMonth} {
+		if limit.Soft > 0 || limit.Hard > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validateCslQuotas(quotas CslQuotas) error {
+	limits := map[string]CslQuotaLimit{
+		QuotaExecutionsPerDay: quotas.ExecutionsPerDay,
+		QuotaApiCallsPerDay:   quotas.ApiCallsPerDay,
+		QuotaAppRunsPerMonth:  quotas.AppRunsPerMonth,
+	}
+
+	for name, limit := range limits {
+		if limit.Soft < 0 || limit.Hard < 0 {
+			return errors.New(fmt.Sprintf("%s limits can't be negative", name))
+		}
+
+		if limit.Soft > 0 && limit.Hard > 0 && limit.Soft > limit.Hard {
+			return errors.New(fmt.Sprintf("%s soft limit can't be above the hard limit", name))
+		}
+	}
+
+	return nil
+}
+
+// Daily quotas reset at midnight and monthly quotas on the first of the month,
+// in the timezone of now, which is the one the org statistics roll over in
+func getQuotaPeriod(name string, now time.Time) (string, time.Time) {
+	if name == QuotaAppRunsPerMonth {
+		return now.Format("2006-01"), time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
+	}
+
+	return now.Format("2006-01-02"), time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+}
+
+func getQuotaStatus(name string, limit CslQuotaLimit, used int64, now time.Time) CslQuotaStatus {
+	period, reset := getQuotaPeriod(name, now)
+	status := CslQuotaStatus{
+		Name:      name,
+		Period:    period,
+		Used:      used,
+		Soft:      limit.Soft,
+		Hard:      limit.Hard,
+		Remaining: -1,
+		Status:    QuotaStatusUnlimited,
+		ResetsAt:  reset.Unix(),
+	}
+
+	if limit.Hard > 0 {
+		status.Remaining = limit.Hard - used
+		if status.Remaining < 0 {
+			status.Remaining = 0
+		}
+	}
+
+	if limit.Hard > 0 && used >= limit.Hard {
+		status.Status = QuotaStatusExceeded
+	} else if limit.Soft > 0 && used >= limit.Soft {
+		status.Status = QuotaStatusWarning
+	} else if limit.Soft > 0 || limit.Hard > 0 {
+		status.Status = QuotaStatusOk
+	}
+
+	return status
+}
+
+// Usage comes from the org statistics, which are updated in batches and can lag slightly behind
+func getOrgQuotaStatuses(ctx context.Context, orgId string, quotas CslQuotas) ([]CslQuotaStatus, error) {
+	orgStats, err := shuffle.GetOrgStatistics(ctx, orgId)
+	if err != nil {
+		return []CslQuotaStatus{}, err
+	}
+
+	now := time.Now().In(getTimezoneLocation(orgStats.Timezone))
+	return []CslQuotaStatus{
+		getQuotaStatus(QuotaExecutionsPerDay, quotas.ExecutionsPerDay, orgStats.DailyWorkflowExecutions, now),
+		getQuotaStatus(QuotaApiCallsPerDay, quotas.ApiCallsPerDay, orgStats.DailyApiUsage, now),
+		getQuotaStatus(QuotaAppRunsPerMonth, quotas.AppRunsPerMonth, orgStats.MonthlyAppExecutions, now),
+	}, nil
+}
+
+// Returns the quotas a request counts against
+func getRequestQuotas(request *http.Request) []string {
+	names := []string{}
+	if strings.HasPrefix(request.Header.Get("Authorization"), "Bearer ") {
+		names = append(names, QuotaApiCallsPerDay)
+	}
+
+	route := mux.CurrentRoute(request)
+	if route == nil {
+		return names
+	}
+
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return names
+	}
+
+	for name, routes := range quotaRoutes {
+		for _, quotaRoute := range routes {
+			if quotaRoute == template {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
+
+// Middleware rejecting requests with 429 once an org is over a hard limit.
+// Executions started by webhooks and schedules aren't blocked here
+func cslQuotaMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, request *http.Request) {
+		if request.Method == "OPTIONS" {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		names := getRequestQuotas(request)
+		if len(names) == 0 {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		user, err := getMiddlewareUser(resp, request)
+		if err != nil || len(user.ActiveOrg.Id) == 0 {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		ctx := shuffle.GetContext(request)
+		quotas := getCslQuotas(ctx, user.ActiveOrg.Id)
+		if !quotas.hasLimits() {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		statuses, err := getOrgQuotaStatuses(ctx, user.ActiveOrg.Id, quotas)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting quota usage for org %s, allowing request: %s", user.ActiveOrg.Id, err)
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		for _, status := range statuses {
+			if status.Status != QuotaStatusExceeded || !shuffle.ArrayContains(names, status.Name) {
+				continue
+			}
+
+			reset := time.Unix(status.ResetsAt, 0)
+			log.Printf("[WARNING] Org %s is over the %s quota (%d/%d). Rejecting %s from user %s", user.ActiveOrg.Id, status.Name, status.Used, status.Hard, request.URL.Path, user.Id)
+
+			resp.Header().Set("Retry-After", strconv.FormatInt(int64(time.Until(reset).Seconds())+1, 10))
+			resp.WriteHeader(429)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("the organization has reached its %s quota of %d", status.Name, status.Hard))))
+			return
+		}
+
+		next.ServeHTTP(resp, request)
+	})
+}
+
+// Job: sends a warning the first time in a period an org passes a soft or hard limit
+func runCslQuotaJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for quota job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		quotas := getCslQuotas(ctx, org.Id)
+		if !quotas.hasLimits() {
+			continue
+		}
+
+		statuses, err := getOrgQuotaStatuses(ctx, org.Id, quotas)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting quota usage for org %s: %s", org.Id, err)
+			continue
+		}
+
+		for _, status := range statuses {
+			if status.Status != QuotaStatusWarning && status.Status != QuotaStatusExceeded {
+				continue
+			}
+
+			// Stored as <status>:<period> so crossing the hard limit after a warning is sent too
+			notification := fmt.Sprintf("%s:%s", status.Status, status.Period)
+			if quotas.Notified[status.Name] == notification {
+				continue
+			}
+
+			event := EventQuotaWarning
+			if status.Status == QuotaStatusExceeded {
+				event = EventQuotaExceeded
+			}
+
+			log.Printf("[INFO] Org %s passed the %s limit of %s (%d used). Sending warning", org.Id, status.Status, status.Name, status.Used)
+			notifyCslEvent(ctx, org.Id, event, status)
+
+			err = updateCslDocument(ctx, org.Id, CslQuotasDocument, &quotas, func() error {
+				if quotas.Notified == nil {
+					quotas.Notified = map[string]string{}
+				}
+
+				quotas.Notified[status.Name] = notification
+				return nil
+			})
+			if err != nil {
+				log.Printf("[ERROR] Failed storing quota notification for org %s: %s", org.Id, err)
+			}
+		}
+	}
+}
+
+/*
+Settings:
+Returns usage and remaining quota of the current organization. Remaining is -1
+when there is no hard limit. Daily quotas reset at midnight in the timezone
+of the org statistics, set with the timezone CSL setting.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "name": "executions_per_day",
+	            "period": "2024-04-05",
+	            "used": 850,
+	            "soft": 800,
+	            "hard": 1000,
+	            "remaining": 150,
+	            "status": "warning",
+	            "resets_at": 1712354400
+	        },
+	        {
+	            "name": "api_calls_per_day",
+	            "period": "2024-04-05",
+	            "used": 1200,
+	            "soft": 0,
+	            "hard": 0,
+	            "remaining": -1,
+	            "status": "unlimited",
+	            "resets_at": 1712354400
+	        },
+	        ...
+	    ]
+	}
+*/
+func cslGetQuotas(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	statuses, err := getOrgQuotaStatuses(ctx, user.ActiveOrg.Id, getCslQuotas(ctx, user.ActiveOrg.Id))
+	if err != nil {
+		log.Printf("[ERROR] Failed getting quota usage for org %s: %s", user.ActiveOrg.Id, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    statuses,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetQuotas")
+}
+
+/*
+Settings:
+Sets the quotas of the current organization. Requires support access, since
+org admins shouldn't be able to lift their own limits. 0 removes a limit.
+
+	{
+	    "executions_per_day": {
+	        "soft": 800,
+	        "hard": 1000
+	    },
+	    "api_calls_per_day": {
+	        "soft": 0,
+	        "hard": 0
+	    },
+	    "app_runs_per_month": {
+	        "soft": 40000,
+	        "hard": 50000
+	    }
+	}
+*/
+func cslSetQuotas(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !user.SupportAccess {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("setting quotas requires support access")))
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	newQuotas := CslQuotas{}
+	err = json.Unmarshal(body, &newQuotas)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling quotas: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	err = validateCslQuotas(newQuotas)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	quotas := CslQuotas{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslQuotasDocument, &quotas, func() error {
+		quotas.ExecutionsPerDay = newQuotas.ExecutionsPerDay
+		quotas.ApiCallsPerDay = newQuotas.ApiCallsPerDay
+		quotas.AppRunsPerMonth = newQuotas.AppRunsPerMonth
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated quotas for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "quotas_updated", "Organization quotas were updated", user.Username, "")
+
+	quotas.Notified = nil
+	res := CslResponse{
+		Success: true,
+		Data:    quotas,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetQuotas")
+}
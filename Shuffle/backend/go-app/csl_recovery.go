@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Recovers panics in handlers, so one bad request gets a 500 instead of
+// taking the backend down with it. The stack trace is logged with the
+// request, and panics are counted per route in shuffle_backend_panics_total.
+// Panics and 5xx responses are passed on to the error tracker
+// (csl_errortracker.go).
+
+var cslPanics = struct {
+	sync.Mutex
+	routes map[string]int64
+}{
+	routes: map[string]int64{},
+}
+
+// Tracks whether the response was started, as a 500 can't be sent after
+// that. The status is passed on to the error tracker
+type cslRecoveryWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	status      int
+}
+
+func (writer *cslRecoveryWriter) WriteHeader(status int) {
+	if !writer.wroteHeader {
+		writer.status = status
+	}
+
+	writer.wroteHeader = true
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *cslRecoveryWriter) Write(data []byte) (int, error) {
+	if !writer.wroteHeader {
+		writer.status = http.StatusOK
+	}
+
+	writer.wroteHeader = true
+	return writer.ResponseWriter.Write(data)
+}
+
+func (writer *cslRecoveryWriter) Flush() {
+	if flusher, ok := writer.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func recordCslPanic(route string) {
+	cslPanics.Lock()
+	defer cslPanics.Unlock()
+
+	cslPanics.routes[route] += 1
+}
+
+// Panics since the backend started, as the counter is kept in memory
+func getPanicMetrics() []CslMetric {
+	panics := CslMetric{
+		Name: "shuffle_backend_panics_total",
+		Help: "Panics recovered in API handlers of the backend answering the scrape.",
+		Type: "counter",
+	}
+
+	cslPanics.Lock()
+	routes := []string{}
+	for route := range cslPanics.routes {
+		routes = append(routes, route)
+	}
+
+	sort.Strings(routes)
+	for _, route := range routes {
+		panics.Samples = append(panics.Samples, CslMetricSample{
+			Labels: map[string]string{"route": route},
+			Value:  float64(cslPanics.routes[route]),
+		})
+	}
+	cslPanics.Unlock()
+
+	return []CslMetric{panics}
+}
+
+// Middleware answering panics in the handlers after it with a 500
+func cslRecoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, request *http.Request) {
+		writer := &cslRecoveryWriter{ResponseWriter: resp}
+
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+
+			stack := debug.Stack()
+
+			// Used by net/http to abort a response on purpose
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			route := getUsageEndpoint(request)
+			recordCslPanic(route)
+
+			trimmedStack := strings.TrimSpace(string(stack))
+			log.Printf("[ERROR] Recovered panic in %s (path %s, remote %s, user agent %s): %v\n%s", route, request.URL.Path, request.RemoteAddr, request.Header.Get("User-Agent"), err, trimmedStack)
+			reportCslPanic(request, err, trimmedStack)
+
+			if writer.wroteHeader {
+				// The client gets a cut off response, which is the best left to do
+				return
+			}
+
+			resp.Header().Set("Content-Type", "application/json")
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(errors.New("an internal error occurred while handling the request")))
+		}()
+
+		next.ServeHTTP(writer, request)
+		trackCslServerError(request, writer.status)
+	})
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Manages the redaction rules of an org. Rules are stored on the org and
+// applied by shuffle-shared redaction.go when executions are stored and
+// returned.
+
+type CslRedactionTestRequest struct {
+	Value string                  `json:"value"`
+	Rules []shuffle.RedactionRule `json:"rules"`
+}
+
+type CslRedactionTestResponse struct {
+	Value    string `json:"value"`
+	Redacted bool   `json:"redacted"`
+}
+
+/*
+Redaction:
+Returns the redaction rules of the org.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "id": "...",
+	            "name": "Bearer tokens",
+	            "type": "regex",
+	            "pattern": "Bearer [A-Za-z0-9._-]+",
+	            "replacement": "Bearer [REDACTED]",
+	            "enabled": true,
+	            "created": 1718000000,
+	            "created_by": "admin@example.com"
+	        },
+	        {
+	            "id": "...",
+	            "name": "Passwords",
+	            "type": "field",
+	            "pattern": "password",
+	            "replacement": "",
+	            "enabled": true,
+	            "created": 1718000000,
+	            "created_by": "admin@example.com"
+	        }
+	    ]
+	}
+*/
+func cslGetRedactionRules(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	rules := org.RedactionRules
+	if rules == nil {
+		rules = []shuffle.RedactionRule{}
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    rules,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetRedactionRules")
+}
+
+/*
+Redaction:
+Adds a redaction rule, or updates the rule with the same id. type is regex
+or field. Regex rules replace every match in results and parameters. Field
+rules replace values of JSON keys, matched against the end of the key path,
+so "password" matches the key at any depth and "headers.authorization" only
+under headers. * matches any key. replacement defaults to [REDACTED].
+
+Rules apply to executions stored from now on, not to stored executions.
+
+	{
+	    "name": "Bearer tokens",
+	    "type": "regex",
+	    "pattern": "Bearer [A-Za-z0-9._-]+",
+	    "replacement": "Bearer [REDACTED]",
+	    "enabled": true
+	}
+*/
+func cslSetRedactionRule(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	rule := shuffle.RedactionRule{}
+	err = json.Unmarshal(body, &rule)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	rule.Type = strings.ToLower(strings.TrimSpace(rule.Type))
+	err = shuffle.ValidateRedactionRule(rule)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	action := "redaction_rule_added"
+	found := false
+	for index, existing := range org.RedactionRules {
+		if len(rule.Id) == 0 || existing.Id != rule.Id {
+			continue
+		}
+
+		rule.Created = existing.Created
+		rule.CreatedBy = existing.CreatedBy
+		org.RedactionRules[index] = rule
+		action = "redaction_rule_updated"
+		found = true
+		break
+	}
+
+	if !found {
+		if len(rule.Id) > 0 {
+			resp.WriteHeader(404)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("redaction rule %s not found", rule.Id))))
+			return
+		}
+
+		rule.Id = uuid.NewV4().String()
+		rule.Created = time.Now().Unix()
+		rule.CreatedBy = user.Username
+		org.RedactionRules = append(org.RedactionRules, rule)
+	}
+
+	err = shuffle.SetOrg(ctx, *org, org.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) saved %s redaction rule %s (%s) in org %s", user.Username, user.Id, rule.Type, rule.Name, rule.Id, org.Id)
+	recordCslActivity(ctx, org.Id, ActivityTypeSecurity, action, fmt.Sprintf("Redaction rule %s was saved", rule.Name), user.Username, rule.Id)
+
+	res := CslResponse{
+		Success: true,
+		Data:    rule,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetRedactionRule")
+}
+
+/*
+Redaction:
+Deletes the redaction rule with ?id=. Executions stored while the rule was
+enabled stay redacted.
+*/
+func cslDeleteRedactionRule(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	ruleId := request.URL.Query().Get("id")
+
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	rules := []shuffle.RedactionRule{}
+	deleted := shuffle.RedactionRule{}
+	for _, rule := range org.RedactionRules {
+		if rule.Id == ruleId {
+			deleted = rule
+			continue
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if len(deleted.Id) == 0 {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("redaction rule %s not found", ruleId))))
+		return
+	}
+
+	org.RedactionRules = rules
+	err = shuffle.SetOrg(ctx, *org, org.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) deleted redaction rule %s (%s) in org %s", user.Username, user.Id, deleted.Name, deleted.Id, org.Id)
+	recordCslActivity(ctx, org.Id, ActivityTypeSecurity, "redaction_rule_deleted", fmt.Sprintf("Redaction rule %s was deleted", deleted.Name), user.Username, deleted.Id)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslDeleteRedactionRule")
+}
+
+/*
+Redaction:
+Shows what a value looks like once redacted, without storing anything. Uses
+the rules in the request, or the rules of the org if none are given.
+
+	{
+	    "value": "{\"user\": \"alice\", \"password\": \"hunter2\"}",
+	    "rules": [
+	        {
+	            "type": "field",
+	            "pattern": "password",
+	            "enabled": true
+	        }
+	    ]
+	}
+*/
+func cslTestRedactionRules(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	testRequest := CslRedactionTestRequest{}
+	err = json.Unmarshal(body, &testRequest)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	rules := testRequest.Rules
+	if len(rules) == 0 {
+		org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+		if err != nil {
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		rules = org.RedactionRules
+	}
+
+	for index, rule := range rules {
+		rules[index].Type = strings.ToLower(strings.TrimSpace(rule.Type))
+		err = shuffle.ValidateRedactionRule(rules[index])
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("rule %d: %s", index+1, err))))
+			return
+		}
+	}
+
+	value, redacted := shuffle.RedactValue(testRequest.Value, rules)
+
+	res := CslResponse{
+		Success: true,
+		Data: CslRedactionTestResponse{
+			Value:    value,
+			Redacted: redacted,
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslTestRedactionRules")
+}
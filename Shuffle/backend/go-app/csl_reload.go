@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Reloads backend configuration without restarting, on SIGHUP or through the
+// API. Settings read from env are reloaded from the env file in
+// SHUFFLE_CONFIG_FILE, and settings kept in memory are dropped so they're
+// loaded again on next use. Executions in flight aren't affected.
+//
+// Only the keys below are applied from the file. Changes to other keys are
+// reported as requiring a restart
+
+var reloadableConfigPrefixes = []string{
+	// CORS
+	"SHUFFLE_CORS_",
+
+	// Notifications
+	"SHUFFLE_SMTP_",
+	"SHUFFLE_NOTIFICATION_",
+	"SENDGRID_API_KEY",
+
+	// LLM providers
+	"OPENAI_",
+}
+
+type CslConfigReload struct {
+	Trigger         string   `json:"trigger"`
+	File            string   `json:"file"`
+	Changed         []string `json:"changed"`
+	RestartRequired []string `json:"restart_required"`
+	Reloaded        []string `json:"reloaded"`
+	Error           string   `json:"error,omitempty"`
+	Time            int64    `json:"time"`
+}
+
+type cslReloadHook struct {
+	Name string
+	Run  func(ctx context.Context)
+}
+
+// In-memory settings dropped on reload. CORS origins are loaded right away
+// since preflight requests would otherwise see the old ones until refreshed
+var cslReloadHooks = []cslReloadHook{
+	{Name: "cors_origins", Run: loadCorsOrgOrigins},
+	{Name: "feature_flags", Run: func(ctx context.Context) { reloadCslFeatureFlags() }},
+	{Name: "queue_depths", Run: func(ctx context.Context) { resetCslQueueDepths() }},
+}
+
+var cslConfigReloads = struct {
+	sync.Mutex
+	fileValues map[string]string
+	last       *CslConfigReload
+}{}
+
+func isReloadableConfigKey(key string) bool {
+	for _, prefix := range reloadableConfigPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parses KEY=VALUE lines as written in a docker compose .env file
+func readConfigFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || len(key) == 0 {
+			return nil, errors.New(fmt.Sprintf("invalid line %d in %s", lineNumber, path))
+		}
+
+		value := strings.TrimSpace(parts[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		values[key] = value
+	}
+
+	return values, scanner.Err()
+}
+
+// Applies reloadable keys from the config file to env. Keys removed from the
+// file since it was last read are unset
+func applyConfigFile(path string, reload *CslConfigReload) error {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	previous := cslConfigReloads.fileValues
+	for key, value := range values {
+		current, isSet := os.LookupEnv(key)
+		if isSet && current == value {
+			continue
+		}
+
+		if !isReloadableConfigKey(key) {
+			if previousValue, ok := previous[key]; !ok || previousValue != value {
+				reload.RestartRequired = append(reload.RestartRequired, key)
+			}
+
+			continue
+		}
+
+		os.Setenv(key, value)
+		reload.Changed = append(reload.Changed, key)
+	}
+
+	for key := range previous {
+		if _, ok := values[key]; ok || !isReloadableConfigKey(key) {
+			continue
+		}
+
+		if _, isSet := os.LookupEnv(key); isSet {
+			os.Unsetenv(key)
+			reload.Changed = append(reload.Changed, key)
+		}
+	}
+
+	cslConfigReloads.fileValues = values
+	return nil
+}
+
+// Reloads the configuration of this backend. trigger is signal or the
+// username of who reloaded it. Only key names are logged, never values
+func reloadCslConfig(ctx context.Context, trigger string) CslConfigReload {
+	cslConfigReloads.Lock()
+	defer cslConfigReloads.Unlock()
+
+	reload := CslConfigReload{
+		Trigger:         trigger,
+		File:            os.Getenv("SHUFFLE_CONFIG_FILE"),
+		Changed:         []string{},
+		RestartRequired: []string{},
+		Reloaded:        []string{},
+		Time:            time.Now().Unix(),
+	}
+
+	if len(reload.File) > 0 {
+		err := applyConfigFile(reload.File, &reload)
+		if err != nil {
+			log.Printf("[ERROR] Failed reloading config file %s, keeping the previous configuration: %s", reload.File, err)
+			reload.Error = err.Error()
+		}
+	}
+
+	sort.Strings(reload.Changed)
+	sort.Strings(reload.RestartRequired)
+
+	for _, hook := range cslReloadHooks {
+		hook.Run(ctx)
+		reload.Reloaded = append(reload.Reloaded, hook.Name)
+	}
+
+	log.Printf("[INFO] Reloaded configuration (%s). Changed: %s", trigger, strings.Join(reload.Changed, ", "))
+	if len(reload.RestartRequired) > 0 {
+		log.Printf("[WARNING] Changes to %s in %s require a restart", strings.Join(reload.RestartRequired, ", "), reload.File)
+	}
+
+	cslConfigReloads.last = &reload
+	return reload
+}
+
+// Reads the config file and reloads the configuration on SIGHUP
+func initCslConfigReload(ctx context.Context) {
+	path := os.Getenv("SHUFFLE_CONFIG_FILE")
+	if len(path) > 0 {
+		cslConfigReloads.Lock()
+		values, err := readConfigFile(path)
+		if err != nil {
+			log.Printf("[WARNING] Failed reading config file %s: %s", path, err)
+		}
+
+		cslConfigReloads.fileValues = values
+		cslConfigReloads.Unlock()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			reloadCslConfig(ctx, "signal")
+		}
+	}()
+}
+
+/*
+Config reload:
+Reloads the configuration of the backend handling the request, the same as
+sending it SIGHUP. With more than one backend, each has to be reloaded.
+Requires support access. Only the names of changed keys are returned.
+
+	{
+	    "success": true,
+	    "data": {
+	        "trigger": "support@example.com",
+	        "file": "/etc/shuffle/.env",
+	        "changed": ["OPENAI_API_KEY", "SHUFFLE_CORS_ALLOWED_ORIGINS"],
+	        "restart_required": ["SHUFFLE_OPENSEARCH_URL"],
+	        "reloaded": ["cors_origins", "feature_flags", "queue_depths"],
+	        "time": 1718000000
+	    }
+	}
+*/
+func cslReloadConfig(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !user.SupportAccess {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("reloading the configuration requires support access")))
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	reload := reloadCslConfig(ctx, user.Username)
+	log.Printf("[AUDIT] User %s (%s) reloaded the configuration. Changed: %s", user.Username, user.Id, strings.Join(reload.Changed, ", "))
+
+	res := CslResponse{
+		Success: len(reload.Error) == 0,
+		Data:    reload,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslReloadConfig")
+}
+
+/*
+Config reload:
+Returns the last reload of the backend handling the request, in the format
+of POST. data is null if it hasn't been reloaded since it started.
+*/
+func cslGetConfigReload(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !user.SupportAccess {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("reloading the configuration requires support access")))
+		return
+	}
+
+	cslConfigReloads.Lock()
+	last := cslConfigReloads.last
+	cslConfigReloads.Unlock()
+
+	res := CslResponse{
+		Success: true,
+		Data:    last,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetConfigReload")
+}
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Replicates orgs, users, workflows, apps, app auth and org configuration to
+// a warm standby instance for disaster recovery. Executions are not
+// replicated.
+//
+// The primary sets SHUFFLE_REPLICATION_TARGET to the URL of the standby
+// backend, and both set SHUFFLE_REPLICATION_KEY to the same secret. Every
+// minute the primary compares everything with what the standby acknowledged,
+// and sends what changed in signed batches. The hashes of what was sent are
+// kept in memory, so everything is sent again after the primary restarts.
+// Applying a change twice is harmless.
+//
+// App auth is stored encrypted, so the standby needs the same
+// SHUFFLE_ENCRYPTION_MODIFIER, or access to the same KMS keys. Webhooks and
+// schedules are not replicated, and have to be started on the standby when
+// it takes over.
+
+const ReplicationScanMinutes = 1
+
+// Changes per request to the standby
+const ReplicationBatchSize = 50
+
+// Requests older than this are rejected by the standby
+const ReplicationMaxSkewSeconds = 300
+
+// Replicated item types
+const (
+	ReplicationOrg      = "org"
+	ReplicationUser     = "user"
+	ReplicationWorkflow = "workflow"
+	ReplicationApp      = "app"
+	ReplicationAppAuth  = "app_auth"
+	ReplicationDocument = "document"
+)
+
+type CslReplicationChange struct {
+	Type     string          `json:"type"`
+	Id       string          `json:"id"`
+	OrgId    string          `json:"org_id"`
+	Deleted  bool            `json:"deleted"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	Detected int64           `json:"detected"`
+
+	hash string
+}
+
+type CslReplicationBatch struct {
+	Source  string                 `json:"source"`
+	Sent    int64                  `json:"sent"`
+	Changes []CslReplicationChange `json:"changes"`
+}
+
+type CslReplicationStatus struct {
+	Mode          string `json:"mode"`
+	Target        string `json:"target,omitempty"`
+	LastScan      int64  `json:"last_scan"`
+	LastSent      int64  `json:"last_sent"`
+	LastError     string `json:"last_error,omitempty"`
+	LastErrorTime int64  `json:"last_error_time,omitempty"`
+	Tracked       int    `json:"tracked"`
+	Pending       int    `json:"pending"`
+	OldestPending int64  `json:"oldest_pending"`
+	LagSeconds    int64  `json:"lag_seconds"`
+	SentTotal     int64  `json:"sent_total"`
+	Failures      int64  `json:"failures"`
+
+	// Set on the standby
+	LastReceived  int64  `json:"last_received"`
+	LastBatchSent int64  `json:"last_batch_sent"`
+	AppliedTotal  int64  `json:"applied_total"`
+	ApplyFailures int64  `json:"apply_failures"`
+	Source        string `json:"source,omitempty"`
+}
+
+var cslReplication = struct {
+	sync.Mutex
+	scanning bool
+
+	// Hash of every item the standby acknowledged, and when unsent changes were first seen
+	hashes       map[string]string
+	orgs         map[string]string
+	pendingSince map[string]int64
+	status       CslReplicationStatus
+}{
+	hashes:       map[string]string{},
+	orgs:         map[string]string{},
+	pendingSince: map[string]int64{},
+}
+
+func getReplicationTarget() string {
+	return strings.TrimRight(os.Getenv("SHUFFLE_REPLICATION_TARGET"), "/")
+}
+
+func getReplicationKey() ([]byte, error) {
+	key := os.Getenv("SHUFFLE_REPLICATION_KEY")
+	if len(key) == 0 {
+		return []byte{}, errors.New("replication is not configured. Set SHUFFLE_REPLICATION_KEY to the same value on the primary and the standby")
+	}
+
+	return []byte(key), nil
+}
+
+// The signature covers the timestamp, so captured requests can't be replayed later
+func signReplicationBatch(key []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func getReplicationItemKey(itemType, id string) string {
+	return fmt.Sprintf("%s:%s", itemType, id)
+}
+
+// Adds an item to the scan result with the hash of its contents
+func addReplicationItem(items map[string]CslReplicationChange, itemType, id, orgId string, data interface{}) {
+	if len(id) == 0 {
+		return
+	}
+
+	marshalled, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("[WARNING] Failed marshalling %s %s for replication: %s", itemType, id, err)
+		return
+	}
+
+	hash := sha256.Sum256(marshalled)
+	items[getReplicationItemKey(itemType, id)] = CslReplicationChange{
+		Type:  itemType,
+		Id:    id,
+		OrgId: orgId,
+		Data:  marshalled,
+		hash:  hex.EncodeToString(hash[:]),
+	}
+}
+
+// Collects everything replicated for an org
+func getOrgReplicationItems(ctx context.Context, org shuffle.Org, items map[string]CslReplicationChange) error {
+	addReplicationItem(items, ReplicationOrg, org.Id, org.Id, org)
+
+	for _, orgUser := range org.Users {
+		user, err := shuffle.GetUser(ctx, orgUser.Id)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting user %s for replication: %s", orgUser.Id, err)
+			continue
+		}
+
+		addReplicationItem(items, ReplicationUser, user.Id, org.Id, user)
+	}
+
+	workflows, err := getOrgWorkflows(ctx, org.Id)
+	if err != nil {
+		return err
+	}
+
+	for _, workflow := range workflows {
+		addReplicationItem(items, ReplicationWorkflow, workflow.ID, org.Id, workflow)
+	}
+
+	apps, err := shuffle.GetPrioritizedApps(ctx, shuffle.User{Role: "admin", ActiveOrg: shuffle.OrgMini{Id: org.Id}})
+	if err != nil {
+		return err
+	}
+
+	for _, app := range apps {
+		if !app.Public && app.ReferenceOrg == org.Id {
+			addReplicationItem(items, ReplicationApp, app.ID, org.Id, app)
+		}
+	}
+
+	auths, err := shuffle.GetAllWorkflowAppAuth(ctx, org.Id)
+	if err != nil {
+		return err
+	}
+
+	for _, auth := range auths {
+		if auth.OrgId == org.Id {
+			addReplicationItem(items, ReplicationAppAuth, auth.Id, org.Id, auth)
+		}
+	}
+
+	for _, name := range orgExportDocuments {
+		document := json.RawMessage{}
+		found, err := getCslDocument(ctx, org.Id, name, &document)
+		if err != nil || !found {
+			continue
+		}
+
+		addReplicationItem(items, ReplicationDocument, fmt.Sprintf("%s/%s", org.Id, name), org.Id, document)
+	}
+
+	return nil
+}
+
+// Returns the changes since the last acknowledged state, oldest first.
+// Items of orgs that failed to load are left as they are
+func getReplicationChanges(ctx context.Context) ([]CslReplicationChange, error) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	items := map[string]CslReplicationChange{}
+	failedOrgs := map[string]bool{}
+	for _, org := range orgs {
+		err = getOrgReplicationItems(ctx, org, items)
+		if err != nil {
+			log.Printf("[WARNING] Failed collecting org %s for replication: %s", org.Id, err)
+			failedOrgs[org.Id] = true
+		}
+	}
+
+	timeNow := time.Now().Unix()
+	changes := []CslReplicationChange{}
+
+	cslReplication.Lock()
+	defer cslReplication.Unlock()
+
+	for key, item := range items {
+		if cslReplication.hashes[key] == item.hash {
+			delete(cslReplication.pendingSince, key)
+			continue
+		}
+
+		if _, ok := cslReplication.pendingSince[key]; !ok {
+			cslReplication.pendingSince[key] = timeNow
+		}
+
+		item.Detected = cslReplication.pendingSince[key]
+		changes = append(changes, item)
+	}
+
+	// Orgs and users are never deleted on the standby, as users can belong to
+	// other orgs. Configuration documents are never deleted
+	for key := range cslReplication.hashes {
+		if _, ok := items[key]; ok {
+			continue
+		}
+
+		itemType, id, _ := strings.Cut(key, ":")
+		orgId := cslReplication.orgs[key]
+		if failedOrgs[orgId] || itemType == ReplicationOrg || itemType == ReplicationUser || itemType == ReplicationDocument {
+			continue
+		}
+
+		if _, ok := cslReplication.pendingSince[key]; !ok {
+			cslReplication.pendingSince[key] = timeNow
+		}
+
+		changes = append(changes, CslReplicationChange{
+			Type:     itemType,
+			Id:       id,
+			OrgId:    orgId,
+			Deleted:  true,
+			Detected: cslReplication.pendingSince[key],
+		})
+	}
+
+	// Orgs go first, so the standby has them before anything referencing them
+	typeOrder := map[string]int{ReplicationOrg: 0, ReplicationUser: 1, ReplicationApp: 2, ReplicationAppAuth: 3, ReplicationWorkflow: 4, ReplicationDocument: 5}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if typeOrder[changes[i].Type] != typeOrder[changes[j].Type] {
+			return typeOrder[changes[i].Type] < typeOrder[changes[j].Type]
+		}
+
+		return changes[i].Detected < changes[j].Detected
+	})
+
+	return changes, nil
+}
+
+func sendReplicationBatch(ctx context.Context, target string, key []byte, changes []CslReplicationChange) error {
+	hostname, _ := os.Hostname()
+	body, err := json.Marshal(CslReplicationBatch{
+		Source:  hostname,
+		Sent:    time.Now().Unix(),
+		Changes: changes,
+	})
+	if err != nil {
+		return err
+	}
+
+	requestUrl := fmt.Sprintf("%s/api/v1/csl/replication/receive", target)
+	req, err := http.NewRequestWithContext(ctx, "POST", requestUrl, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Shuffle-Replication-Timestamp", timestamp)
+	req.Header.Add("X-Shuffle-Replication-Signature", signReplicationBatch(key, timestamp, body))
+
+	client := shuffle.GetExternalClient(requestUrl)
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		resBody, _ := ioutil.ReadAll(res.Body)
+		return errors.New(fmt.Sprintf("standby returned %d: %s", res.StatusCode, truncateText(string(resBody), 200)))
+	}
+
+	return nil
+}
+
+// Sends changes to the standby. Changes are acknowledged per batch, so a
+// failure only resends what wasn't delivered
+func runCslReplication(ctx context.Context) error {
+	target := getReplicationTarget()
+	if len(target) == 0 {
+		return errors.New("SHUFFLE_REPLICATION_TARGET is not set")
+	}
+
+	key, err := getReplicationKey()
+	if err != nil {
+		return err
+	}
+
+	cslReplication.Lock()
+	if cslReplication.scanning {
+		cslReplication.Unlock()
+		return errors.New("replication is already running")
+	}
+
+	cslReplication.scanning = true
+	cslReplication.Unlock()
+
+	defer func() {
+		cslReplication.Lock()
+		cslReplication.scanning = false
+		cslReplication.Unlock()
+	}()
+
+	changes, err := getReplicationChanges(ctx)
+	if err == nil {
+		for start := 0; start < len(changes); start += ReplicationBatchSize {
+			end := start + ReplicationBatchSize
+			if end > len(changes) {
+				end = len(changes)
+			}
+
+			batch := changes[start:end]
+			err = sendReplicationBatch(ctx, target, key, batch)
+			if err != nil {
+				break
+			}
+
+			cslReplication.Lock()
+			for _, change := range batch {
+				changeKey := getReplicationItemKey(change.Type, change.Id)
+				delete(cslReplication.pendingSince, changeKey)
+				if change.Deleted {
+					delete(cslReplication.hashes, changeKey)
+					delete(cslReplication.orgs, changeKey)
+				} else {
+					cslReplication.hashes[changeKey] = change.hash
+					cslReplication.orgs[changeKey] = change.OrgId
+				}
+			}
+
+			cslReplication.status.SentTotal += int64(len(batch))
+			cslReplication.status.LastSent = time.Now().Unix()
+			cslReplication.Unlock()
+		}
+	}
+
+	cslReplication.Lock()
+	defer cslReplication.Unlock()
+
+	cslReplication.status.LastScan = time.Now().Unix()
+	if err != nil {
+		cslReplication.status.LastError = err.Error()
+		cslReplication.status.LastErrorTime = time.Now().Unix()
+		cslReplication.status.Failures += 1
+		log.Printf("[ERROR] Failed replicating to %s: %s", target, err)
+		return err
+	}
+
+	cslReplication.status.LastError = ""
+	if len(changes) > 0 {
+		log.Printf("[INFO] Replicated %d changes to %s", len(changes), target)
+	}
+
+	return nil
+}
+
+// Job: sends changes to the standby, if this is a primary
+func runCslReplicationJob(ctx context.Context) {
+	if len(getReplicationTarget()) == 0 {
+		return
+	}
+
+	runCslReplication(ctx)
+}
+
+func applyReplicationChange(ctx context.Context, change CslReplicationChange) error {
+	switch change.Type {
+	case ReplicationOrg:
+		org := shuffle.Org{}
+		err := json.Unmarshal(change.Data, &org)
+		if err != nil {
+			return err
+		}
+
+		return shuffle.SetOrg(ctx, org, org.Id)
+	case ReplicationUser:
+		user := shuffle.User{}
+		err := json.Unmarshal(change.Data, &user)
+		if err != nil {
+			return err
+		}
+
+		return shuffle.SetUser(ctx, &user, false)
+	case ReplicationWorkflow:
+		if change.Deleted {
+			return shuffle.DeleteKey(ctx, "workflow", change.Id)
+		}
+
+		workflow := shuffle.Workflow{}
+		err := json.Unmarshal(change.Data, &workflow)
+		if err != nil {
+			return err
+		}
+
+		return shuffle.SetWorkflow(ctx, workflow, workflow.ID)
+	case ReplicationApp:
+		if change.Deleted {
+			return shuffle.DeleteKey(ctx, "workflowapp", change.Id)
+		}
+
+		app := shuffle.WorkflowApp{}
+		err := json.Unmarshal(change.Data, &app)
+		if err != nil {
+			return err
+		}
+
+		return shuffle.SetWorkflowAppDatastore(ctx, app, app.ID)
+	case ReplicationAppAuth:
+		if change.Deleted {
+			return shuffle.DeleteKey(ctx, "workflowappauth", change.Id)
+		}
+
+		auth := shuffle.AppAuthenticationStorage{}
+		err := json.Unmarshal(change.Data, &auth)
+		if err != nil {
+			return err
+		}
+
+		return shuffle.SetWorkflowAppAuthDatastore(ctx, auth, auth.Id)
+	case ReplicationDocument:
+		_, name, _ := strings.Cut(change.Id, "/")
+		if !shuffle.ArrayContains(orgExportDocuments, name) {
+			return errors.New(fmt.Sprintf("unknown document %s", name))
+		}
+
+		return setCslDocument(ctx, change.OrgId, name, change.Data)
+	}
+
+	return errors.New(fmt.Sprintf("unknown type %s", change.Type))
+}
+
+func getCslReplicationStatus() CslReplicationStatus {
+	cslReplication.Lock()
+	defer cslReplication.Unlock()
+
+	status := cslReplication.status
+	status.Mode = "disabled"
+	if len(getReplicationTarget()) > 0 {
+		status.Mode = "primary"
+		status.Target = getReplicationTarget()
+	} else if len(os.Getenv("SHUFFLE_REPLICATION_KEY")) > 0 {
+		status.Mode = "standby"
+	}
+
+	status.Tracked = len(cslReplication.hashes)
+	status.Pending = len(cslReplication.pendingSince)
+	for _, detected := range cslReplication.pendingSince {
+		if status.OldestPending == 0 || detected < status.OldestPending {
+			status.OldestPending = detected
+		}
+	}
+
+	if status.OldestPending > 0 {
+		status.LagSeconds = time.Now().Unix() - status.OldestPending
+	} else if status.Mode == "standby" && status.LastBatchSent > 0 {
+		status.LagSeconds = status.LastReceived - status.LastBatchSent
+	}
+
+	return status
+}
+
+/*
+Replication:
+Receives changes from the primary. Requests are signed with
+SHUFFLE_REPLICATION_KEY in X-Shuffle-Replication-Signature.
+*/
+func cslReceiveReplication(resp http.ResponseWriter, request *http.Request) {
+	ctx := shuffle.GetContext(request)
+
+	key, err := getReplicationKey()
+	if err != nil {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New("replication is not enabled")))
+		return
+	}
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	timestamp := request.Header.Get("X-Shuffle-Replication-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	skew := time.Now().Unix() - sent
+	if err != nil || skew > ReplicationMaxSkewSeconds || skew < -ReplicationMaxSkewSeconds {
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("invalid or expired timestamp")))
+		return
+	}
+
+	expected := signReplicationBatch(key, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(request.Header.Get("X-Shuffle-Replication-Signature")))) {
+		log.Printf("[WARNING] Invalid replication signature from %s", request.RemoteAddr)
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("invalid signature")))
+		return
+	}
+
+	batch := CslReplicationBatch{}
+	err = json.Unmarshal(body, &batch)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	applied := 0
+	failures := []string{}
+	for _, change := range batch.Changes {
+		err = applyReplicationChange(ctx, change)
+		if err != nil {
+			log.Printf("[ERROR] Failed applying replicated %s %s: %s", change.Type, change.Id, err)
+			failures = append(failures, fmt.Sprintf("%s %s: %s", change.Type, change.Id, err))
+			continue
+		}
+
+		applied += 1
+	}
+
+	cslReplication.Lock()
+	cslReplication.status.LastReceived = time.Now().Unix()
+	cslReplication.status.LastBatchSent = batch.Sent
+	cslReplication.status.AppliedTotal += int64(applied)
+	cslReplication.status.ApplyFailures += int64(len(failures))
+	cslReplication.status.Source = batch.Source
+	cslReplication.Unlock()
+
+	// Failed changes are sent again on the next scan
+	if len(failures) > 0 {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(errors.New(strings.Join(failures, "; "))))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslReceiveReplication")
+}
+
+/*
+Replication:
+Returns the replication status of the backend handling the request.
+lag_seconds is how long the oldest change not yet acknowledged by the
+standby has waited. On the standby, it's the delay of the last batch.
+
+	{
+	    "success": true,
+	    "data": {
+	        "mode": "primary",
+	        "target": "https://standby.example.com",
+	        "last_scan": 1718000060,
+	        "last_sent": 1718000060,
+	        "tracked": 840,
+	        "pending": 0,
+	        "oldest_pending": 0,
+	        "lag_seconds": 0,
+	        "sent_total": 1204,
+	        "failures": 1,
+	        "last_received": 0,
+	        "last_batch_sent": 0,
+	        "applied_total": 0,
+	        "apply_failures": 0
+	    }
+	}
+*/
+func cslGetReplicationStatus(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !user.SupportAccess {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("replication requires support access")))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslReplicationStatus(),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetReplicationStatus")
+}
+
+/*
+Replication:
+Sends changes to the standby right away instead of waiting for the next
+scan, and returns the status afterwards.
+*/
+func cslSyncReplication(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !user.SupportAccess {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("replication requires support access")))
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	err := runCslReplication(ctx)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) synced replication to %s", user.Username, user.Id, getReplicationTarget())
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslReplicationStatus(),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSyncReplication")
+}
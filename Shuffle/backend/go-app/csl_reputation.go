@@ -0,0 +1,869 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslIpReputationDocument = "ip_reputation"
+
+const AbuseIpdbApiUrl = "https://api.abuseipdb.com/api/v2"
+const GreyNoiseApiUrl = "https://api.greynoise.io/v3/community"
+
+// Reputation providers
+const (
+	ReputationAbuseIpdb = "abuseipdb"
+	ReputationGreyNoise = "greynoise"
+)
+
+// Defaults match the free plans of the providers
+const (
+	DefaultAbuseIpdbDailyQuota  = 1000
+	DefaultGreyNoiseDailyQuota  = 50
+	DefaultReputationCacheHours = 24
+	DefaultAbuseIpdbMaxAgeDays  = 90
+)
+
+// How long a provider is left alone after rate limiting without saying for
+// how long
+const ReputationBackoffMinutes = 15
+
+// New IP observables of an execution that are enriched at most
+const MaxObservableReputationLookups = 10
+
+// Combined verdicts
+const (
+	ReputationMalicious  = "malicious"
+	ReputationSuspicious = "suspicious"
+	ReputationBenign     = "benign"
+	ReputationUnknown    = "unknown"
+)
+
+var errReputationRateLimited = errors.New("rate limited")
+
+// Providers without an API key aren't used, except GreyNoise that can be
+// used without one at a lower rate limit when greynoise_enabled is set.
+// EnrichObservables looks up new IP observables extracted from executions
+type CslIpReputationConfig struct {
+	Enabled             bool   `json:"enabled"`
+	AbuseIpdbApiKey     string `json:"abuseipdb_api_key"`
+	AbuseIpdbDailyQuota int    `json:"abuseipdb_daily_quota"`
+	AbuseIpdbMaxAgeDays int    `json:"abuseipdb_max_age_days"`
+	GreyNoiseEnabled    bool   `json:"greynoise_enabled"`
+	GreyNoiseApiKey     string `json:"greynoise_api_key"`
+	GreyNoiseDailyQuota int    `json:"greynoise_daily_quota"`
+	CacheHours          int    `json:"cache_hours"`
+	EnrichObservables   bool   `json:"enrich_observables"`
+}
+
+// Remaining is the last quota reported by the provider, or -1 if unknown.
+// LimitedUntil is set when the provider rate limited a request
+type CslReputationProviderState struct {
+	Day          string `json:"day"`
+	DayCount     int    `json:"day_count"`
+	Remaining    int    `json:"remaining"`
+	LimitedUntil int64  `json:"limited_until"`
+	Requests     int64  `json:"requests"`
+	Errors       int64  `json:"errors"`
+	RateLimited  int64  `json:"rate_limited"`
+	LastError    string `json:"last_error"`
+}
+
+type CslIpReputationStats struct {
+	Since     int64 `json:"since"`
+	Lookups   int64 `json:"lookups"`
+	CacheHits int64 `json:"cache_hits"`
+}
+
+type CslIpReputationService struct {
+	Config    CslIpReputationConfig                 `json:"config"`
+	Providers map[string]CslReputationProviderState `json:"providers"`
+	Stats     CslIpReputationStats                  `json:"stats"`
+}
+
+type CslAbuseIpdbResult struct {
+	AbuseConfidenceScore int    `json:"abuseConfidenceScore"`
+	TotalReports         int    `json:"totalReports"`
+	NumDistinctUsers     int    `json:"numDistinctUsers"`
+	CountryCode          string `json:"countryCode"`
+	UsageType            string `json:"usageType"`
+	Isp                  string `json:"isp"`
+	Domain               string `json:"domain"`
+	IsTor                bool   `json:"isTor"`
+	IsWhitelisted        bool   `json:"isWhitelisted"`
+	LastReportedAt       string `json:"lastReportedAt"`
+}
+
+// Noise is set for IPs scanning the internet, and Riot for IPs of common
+// business services
+type CslGreyNoiseResult struct {
+	Found          bool   `json:"found"`
+	Noise          bool   `json:"noise"`
+	Riot           bool   `json:"riot"`
+	Classification string `json:"classification"`
+	Name           string `json:"name"`
+	LastSeen       string `json:"last_seen"`
+	Link           string `json:"link"`
+}
+
+// Score is 0-100. Errors holds providers that couldn't be used, e.g. while
+// rate limited, so the result is based on the other providers
+type CslIpReputation struct {
+	Ip        string              `json:"ip"`
+	Score     int                 `json:"score"`
+	Verdict   string              `json:"verdict"`
+	Sources   []string            `json:"sources"`
+	AbuseIpdb *CslAbuseIpdbResult `json:"abuseipdb,omitempty"`
+	GreyNoise *CslGreyNoiseResult `json:"greynoise,omitempty"`
+	Errors    map[string]string   `json:"errors"`
+	Cached    bool                `json:"cached"`
+}
+
+type CslIpReputationProviderStats struct {
+	CslReputationProviderState
+	Provider       string `json:"provider"`
+	DailyQuota     int    `json:"daily_quota"`
+	DailyRemaining int    `json:"daily_remaining"`
+}
+
+type CslIpReputationStatsResponse struct {
+	CslIpReputationStats
+	HitRate   float64                        `json:"hit_rate"`
+	Providers []CslIpReputationProviderStats `json:"providers"`
+}
+
+// Rate limit details from the response headers of a provider
+type reputationRateLimit struct {
+	remaining    int
+	limitedUntil int64
+}
+
+func getCslIpReputationService(ctx context.Context, orgId string) CslIpReputationService {
+	service := CslIpReputationService{}
+	_, err := getCslDocument(ctx, orgId, CslIpReputationDocument, &service)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting ip reputation config for org %s: %s", orgId, err)
+	}
+
+	setIpReputationDefaults(&service)
+	return service
+}
+
+func setIpReputationDefaults(service *CslIpReputationService) {
+	if service.Config.AbuseIpdbDailyQuota == 0 {
+		service.Config.AbuseIpdbDailyQuota = DefaultAbuseIpdbDailyQuota
+	}
+
+	if service.Config.AbuseIpdbMaxAgeDays == 0 {
+		service.Config.AbuseIpdbMaxAgeDays = DefaultAbuseIpdbMaxAgeDays
+	}
+
+	if service.Config.GreyNoiseDailyQuota == 0 {
+		service.Config.GreyNoiseDailyQuota = DefaultGreyNoiseDailyQuota
+	}
+
+	if service.Config.CacheHours == 0 {
+		service.Config.CacheHours = DefaultReputationCacheHours
+	}
+
+	if service.Providers == nil {
+		service.Providers = map[string]CslReputationProviderState{}
+	}
+}
+
+func redactCslIpReputationConfig(config CslIpReputationConfig) CslIpReputationConfig {
+	if len(config.AbuseIpdbApiKey) > 0 {
+		config.AbuseIpdbApiKey = RedactedValue
+	}
+
+	if len(config.GreyNoiseApiKey) > 0 {
+		config.GreyNoiseApiKey = RedactedValue
+	}
+
+	return config
+}
+
+func validateCslIpReputationConfig(config CslIpReputationConfig) error {
+	if config.Enabled && len(getReputationProviders(config)) == 0 {
+		return errors.New("abuseipdb_api_key or greynoise_enabled is required")
+	}
+
+	if config.AbuseIpdbDailyQuota < 0 || config.GreyNoiseDailyQuota < 0 {
+		return errors.New("quotas can't be negative")
+	}
+
+	if config.AbuseIpdbMaxAgeDays < 0 || config.AbuseIpdbMaxAgeDays > 365 {
+		return errors.New("abuseipdb_max_age_days must be between 1 and 365")
+	}
+
+	if config.CacheHours < 0 || config.CacheHours > MaxVirusTotalCacheHours {
+		return errors.New(fmt.Sprintf("cache_hours must be between 1 and %d", MaxVirusTotalCacheHours))
+	}
+
+	return nil
+}
+
+func getReputationProviders(config CslIpReputationConfig) []string {
+	providers := []string{}
+	if len(config.AbuseIpdbApiKey) > 0 {
+		providers = append(providers, ReputationAbuseIpdb)
+	}
+
+	if config.GreyNoiseEnabled {
+		providers = append(providers, ReputationGreyNoise)
+	}
+
+	return providers
+}
+
+func getReputationDailyQuota(config CslIpReputationConfig, provider string) int {
+	if provider == ReputationAbuseIpdb {
+		return config.AbuseIpdbDailyQuota
+	}
+
+	return config.GreyNoiseDailyQuota
+}
+
+// Only public addresses have a reputation
+func isReputationIp(value string) bool {
+	ip := net.ParseIP(value)
+	return isObservableIp(value) && !ip.IsPrivate()
+}
+
+// Reads the rate limit headers of AbuseIPDB and GreyNoise. Retry-After is in
+// seconds and X-RateLimit-Reset is a unix timestamp
+func getReputationRateLimit(header http.Header, statusCode int) reputationRateLimit {
+	rateLimit := reputationRateLimit{remaining: -1}
+	if remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining")); err == nil {
+		rateLimit.remaining = remaining
+	}
+
+	if statusCode != 429 && rateLimit.remaining != 0 {
+		return rateLimit
+	}
+
+	rateLimit.limitedUntil = time.Now().Add(ReputationBackoffMinutes * time.Minute).Unix()
+	if retryAfter, err := strconv.Atoi(header.Get("Retry-After")); err == nil && retryAfter > 0 {
+		rateLimit.limitedUntil = time.Now().Unix() + int64(retryAfter)
+	} else if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil && reset > time.Now().Unix() {
+		rateLimit.limitedUntil = reset
+	}
+
+	return rateLimit
+}
+
+func reputationRequest(ctx context.Context, requestUrl string, headers map[string]string) ([]byte, int, reputationRateLimit, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", requestUrl, nil)
+	if err != nil {
+		return nil, 0, reputationRateLimit{remaining: -1}, err
+	}
+
+	req.Header.Add("Accept", "application/json")
+	for key, value := range headers {
+		req.Header.Add(key, value)
+	}
+
+	client := shuffle.GetExternalClient(requestUrl)
+	newresp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, reputationRateLimit{remaining: -1}, err
+	}
+
+	defer newresp.Body.Close()
+	rateLimit := getReputationRateLimit(newresp.Header, newresp.StatusCode)
+	respBody, err := ioutil.ReadAll(newresp.Body)
+	if err != nil {
+		return nil, newresp.StatusCode, rateLimit, err
+	}
+
+	if newresp.StatusCode == 429 {
+		return nil, newresp.StatusCode, rateLimit, errReputationRateLimited
+	}
+
+	// GreyNoise answers 404 for IPs it hasn't seen
+	if newresp.StatusCode >= 300 && newresp.StatusCode != 404 {
+		return nil, newresp.StatusCode, rateLimit, errors.New(fmt.Sprintf("status code %d: %s", newresp.StatusCode, truncateText(string(respBody), 500)))
+	}
+
+	return respBody, newresp.StatusCode, rateLimit, nil
+}
+
+func fetchAbuseIpdb(ctx context.Context, config CslIpReputationConfig, ip string) (interface{}, reputationRateLimit, error) {
+	requestUrl := fmt.Sprintf("%s/check?ipAddress=%s&maxAgeInDays=%d", AbuseIpdbApiUrl, ip, config.AbuseIpdbMaxAgeDays)
+	respBody, statusCode, rateLimit, err := reputationRequest(ctx, requestUrl, map[string]string{"Key": config.AbuseIpdbApiKey})
+	if err != nil {
+		return nil, rateLimit, err
+	}
+
+	if statusCode == 404 {
+		return nil, rateLimit, errors.New("abuseipdb returned status code 404")
+	}
+
+	parsed := struct {
+		Data CslAbuseIpdbResult `json:"data"`
+	}{}
+
+	err = json.Unmarshal(respBody, &parsed)
+	if err != nil {
+		return nil, rateLimit, errors.New(fmt.Sprintf("failed parsing abuseipdb response: %s", err))
+	}
+
+	return parsed.Data, rateLimit, nil
+}
+
+func fetchGreyNoise(ctx context.Context, config CslIpReputationConfig, ip string) (interface{}, reputationRateLimit, error) {
+	headers := map[string]string{}
+	if len(config.GreyNoiseApiKey) > 0 {
+		headers["key"] = config.GreyNoiseApiKey
+	}
+
+	respBody, statusCode, rateLimit, err := reputationRequest(ctx, fmt.Sprintf("%s/%s", GreyNoiseApiUrl, ip), headers)
+	if err != nil {
+		return nil, rateLimit, err
+	}
+
+	result := CslGreyNoiseResult{}
+	if statusCode == 404 {
+		return result, rateLimit, nil
+	}
+
+	err = json.Unmarshal(respBody, &result)
+	if err != nil {
+		return nil, rateLimit, errors.New(fmt.Sprintf("failed parsing greynoise response: %s", err))
+	}
+
+	result.Found = true
+	return result, rateLimit, nil
+}
+
+// Looks up an IP from one provider through the cache of the org. Requests
+// count against the daily quota of the provider, and aren't sent at all while
+// the provider is rate limiting
+func lookupReputationProvider(ctx context.Context, orgId string, config CslIpReputationConfig, provider, ip string, result interface{}) (bool, error) {
+	cacheKey := fmt.Sprintf("csl_reputation_%s_%s_%s", orgId, provider, ip)
+	cache, err := shuffle.GetCache(ctx, cacheKey)
+	if cacheData, ok := cache.([]uint8); err == nil && ok && json.Unmarshal(cacheData, result) == nil {
+		return true, nil
+	}
+
+	limited := false
+	service := CslIpReputationService{}
+	err = updateCslDocument(ctx, orgId, CslIpReputationDocument, &service, func() error {
+		setIpReputationDefaults(&service)
+		state, ok := service.Providers[provider]
+		if !ok {
+			state.Remaining = -1
+		}
+
+		if state.Day != time.Now().UTC().Format(UsageDateFormat) {
+			state.Day = time.Now().UTC().Format(UsageDateFormat)
+			state.DayCount = 0
+		}
+
+		if state.LimitedUntil > time.Now().Unix() || state.DayCount >= getReputationDailyQuota(service.Config, provider) {
+			state.RateLimited += 1
+			service.Providers[provider] = state
+			limited = true
+			return nil
+		}
+
+		state.DayCount += 1
+		state.Requests += 1
+		service.Providers[provider] = state
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if limited {
+		return false, errReputationRateLimited
+	}
+
+	var fetched interface{}
+	var rateLimit reputationRateLimit
+	if provider == ReputationAbuseIpdb {
+		fetched, rateLimit, err = fetchAbuseIpdb(ctx, config, ip)
+	} else {
+		fetched, rateLimit, err = fetchGreyNoise(ctx, config, ip)
+	}
+
+	updateErr := updateCslDocument(ctx, orgId, CslIpReputationDocument, &service, func() error {
+		setIpReputationDefaults(&service)
+		state := service.Providers[provider]
+		state.Remaining = rateLimit.remaining
+		state.LimitedUntil = rateLimit.limitedUntil
+		if err == errReputationRateLimited {
+			state.RateLimited += 1
+		} else if err != nil {
+			state.Errors += 1
+			state.LastError = err.Error()
+		}
+
+		service.Providers[provider] = state
+		return nil
+	})
+	if updateErr != nil {
+		log.Printf("[WARNING] Failed updating %s state for org %s: %s", provider, orgId, updateErr)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	cacheData, err := json.Marshal(fetched)
+	if err != nil {
+		return false, err
+	}
+
+	err = shuffle.SetCache(ctx, cacheKey, cacheData, int32(config.CacheHours*60))
+	if err != nil {
+		log.Printf("[WARNING] Failed caching %s result for org %s: %s", provider, orgId, err)
+	}
+
+	return false, json.Unmarshal(cacheData, result)
+}
+
+// Combines the provider results into one score. GreyNoise RIOT and benign
+// classifications lower the verdict, as those IPs are known services or
+// research scanners
+func scoreIpReputation(reputation *CslIpReputation) {
+	reputation.Verdict = ReputationUnknown
+	if reputation.AbuseIpdb != nil {
+		reputation.Score = reputation.AbuseIpdb.AbuseConfidenceScore
+		if reputation.AbuseIpdb.IsWhitelisted {
+			reputation.Score = 0
+		}
+	}
+
+	if reputation.GreyNoise != nil && reputation.GreyNoise.Found {
+		switch {
+		case reputation.GreyNoise.Riot || reputation.GreyNoise.Classification == "benign":
+			reputation.Score = min(reputation.Score, 10)
+		case reputation.GreyNoise.Classification == "malicious":
+			reputation.Score = max(reputation.Score, 90)
+		case reputation.GreyNoise.Noise:
+			reputation.Score = max(reputation.Score, 30)
+		}
+	}
+
+	switch {
+	case reputation.Score >= 75:
+		reputation.Verdict = ReputationMalicious
+	case reputation.Score >= 25:
+		reputation.Verdict = ReputationSuspicious
+	case reputation.AbuseIpdb != nil || (reputation.GreyNoise != nil && reputation.GreyNoise.Found):
+		reputation.Verdict = ReputationBenign
+	}
+}
+
+// Looks up the reputation of a public IP from every configured provider.
+// Providers that fail are listed in Errors, and the error is only returned
+// when none of them could be used
+func getCslIpReputation(ctx context.Context, orgId string, config CslIpReputationConfig, ip string) (CslIpReputation, error) {
+	reputation := CslIpReputation{
+		Ip:      ip,
+		Sources: []string{},
+		Errors:  map[string]string{},
+		Cached:  true,
+	}
+
+	var lastErr error
+	for _, provider := range getReputationProviders(config) {
+		var cached bool
+		var err error
+		if provider == ReputationAbuseIpdb {
+			result := CslAbuseIpdbResult{}
+			cached, err = lookupReputationProvider(ctx, orgId, config, provider, ip, &result)
+			if err == nil {
+				reputation.AbuseIpdb = &result
+			}
+		} else {
+			result := CslGreyNoiseResult{}
+			cached, err = lookupReputationProvider(ctx, orgId, config, provider, ip, &result)
+			if err == nil {
+				reputation.GreyNoise = &result
+			}
+		}
+
+		if err != nil {
+			reputation.Errors[provider] = err.Error()
+			lastErr = err
+			continue
+		}
+
+		reputation.Sources = append(reputation.Sources, provider)
+		reputation.Cached = reputation.Cached && cached
+	}
+
+	if len(reputation.Sources) == 0 {
+		reputation.Cached = false
+		if lastErr == nil {
+			lastErr = errors.New("no reputation providers are configured")
+		}
+
+		return reputation, lastErr
+	}
+
+	service := CslIpReputationService{}
+	err := updateCslDocument(ctx, orgId, CslIpReputationDocument, &service, func() error {
+		if service.Stats.Since == 0 {
+			service.Stats.Since = time.Now().Unix()
+		}
+
+		service.Stats.Lookups += 1
+		if reputation.Cached {
+			service.Stats.CacheHits += 1
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed recording ip reputation lookup for org %s: %s", orgId, err)
+	}
+
+	scoreIpReputation(&reputation)
+	return reputation, nil
+}
+
+// Adds the reputation of new IP observables from an execution when enabled
+// for the org. Lookups stop at the first rate limit, as the rest would be
+// rejected too
+func enrichCslObservables(ctx context.Context, orgId string, extracted []CslObservable) {
+	config := getCslIpReputationService(ctx, orgId).Config
+	if !config.Enabled || !config.EnrichObservables {
+		return
+	}
+
+	reputations := map[string]CslObservableReputation{}
+	for _, observable := range extracted {
+		if len(reputations) >= MaxObservableReputationLookups {
+			break
+		}
+
+		if observable.Type != ObservableIp || observable.Reputation != nil || !isReputationIp(observable.Value) {
+			continue
+		}
+
+		reputation, err := getCslIpReputation(ctx, orgId, config, observable.Value)
+		if err != nil {
+			log.Printf("[WARNING] Failed enriching observable %s for org %s: %s", observable.Value, orgId, err)
+			if err == errReputationRateLimited {
+				break
+			}
+
+			continue
+		}
+
+		reputations[getObservableId(observable.Type, observable.Value)] = CslObservableReputation{
+			Score:   reputation.Score,
+			Verdict: reputation.Verdict,
+			Sources: reputation.Sources,
+			Checked: time.Now().Unix(),
+		}
+	}
+
+	if len(reputations) == 0 {
+		return
+	}
+
+	observables := CslObservables{}
+	err := updateCslDocument(ctx, orgId, CslObservablesDocument, &observables, func() error {
+		for id, reputation := range reputations {
+			observable, ok := observables.Observables[id]
+			if !ok {
+				continue
+			}
+
+			reputation := reputation
+			observable.Reputation = &reputation
+			observables.Observables[id] = observable
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed storing observable reputations for org %s: %s", orgId, err)
+	}
+}
+
+/*
+Enrichment:
+Returns the combined AbuseIPDB and GreyNoise reputation of a public IP.
+Requires ?ip=<ip>, which may be defanged. Workflows use
+?execution_id=<id>&authorization=<execution authorization>. Results are
+cached for the organization, and a provider that is rate limited is left out
+and listed in errors. Returns 429 when every provider is rate limited.
+
+	{
+	    "success": true,
+	    "data": {
+	        "ip": "203.0.113.7",
+	        "score": 90,
+	        "verdict": "malicious",
+	        "sources": ["abuseipdb", "greynoise"],
+	        "abuseipdb": {
+	            "abuseConfidenceScore": 100,
+	            "totalReports": 312,
+	            "numDistinctUsers": 80,
+	            "countryCode": "NL",
+	            "usageType": "Data Center/Web Hosting/Transit",
+	            "isp": "Example Hosting",
+	            "domain": "example.net",
+	            "isTor": false,
+	            "isWhitelisted": false,
+	            "lastReportedAt": "2024-01-01T12:00:00+00:00"
+	        },
+	        "greynoise": {
+	            "found": true,
+	            "noise": true,
+	            "riot": false,
+	            "classification": "malicious",
+	            "name": "unknown",
+	            "last_seen": "2024-01-01",
+	            "link": "https://viz.greynoise.io/ip/203.0.113.7"
+	        },
+	        "errors": {},
+	        "cached": false
+	    }
+	}
+*/
+func cslIpReputation(resp http.ResponseWriter, request *http.Request) {
+	orgId, _, _, ok := handleCslExecutionRequest(resp, request)
+	if !ok {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	config := getCslIpReputationService(ctx, orgId).Config
+	if !config.Enabled {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("ip reputation enrichment is not enabled")))
+		return
+	}
+
+	ip := strings.TrimSpace(refangObservables(request.URL.Query().Get("ip")))
+	if !isReputationIp(ip) {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("ip must be a public ip address")))
+		return
+	}
+
+	reputation, err := getCslIpReputation(ctx, orgId, config, ip)
+	if err == errReputationRateLimited {
+		resp.WriteHeader(429)
+		resp.Write(createCslErrorResponse(errors.New("every reputation provider is rate limited")))
+		return
+	}
+
+	if err != nil {
+		resp.WriteHeader(502)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    reputation,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslIpReputation")
+}
+
+/*
+Enrichment:
+Returns the IP reputation statistics of the current organization, with the
+cache hit rate and the quota left for each provider today. Limited_until is
+set while a provider is rate limiting.
+
+	{
+	    "success": true,
+	    "data": {
+	        "since": 1700000000,
+	        "lookups": 800,
+	        "cache_hits": 620,
+	        "hit_rate": 77.5,
+	        "providers": [
+	            {
+	                "provider": "abuseipdb",
+	                "day": "2024-01-01",
+	                "day_count": 40,
+	                "remaining": 960,
+	                "limited_until": 0,
+	                "requests": 180,
+	                "errors": 0,
+	                "rate_limited": 0,
+	                "last_error": "",
+	                "daily_quota": 1000,
+	                "daily_remaining": 960
+	            }
+	        ]
+	    }
+	}
+*/
+func cslIpReputationStats(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	service := getCslIpReputationService(ctx, user.ActiveOrg.Id)
+	stats := CslIpReputationStatsResponse{
+		CslIpReputationStats: service.Stats,
+		Providers:            []CslIpReputationProviderStats{},
+	}
+
+	if stats.Lookups > 0 {
+		stats.HitRate = float64(int64(float64(stats.CacheHits)/float64(stats.Lookups)*1000)) / 10
+	}
+
+	for _, provider := range []string{ReputationAbuseIpdb, ReputationGreyNoise} {
+		state, ok := service.Providers[provider]
+		if !ok && !shuffle.ArrayContains(getReputationProviders(service.Config), provider) {
+			continue
+		}
+
+		if state.Day != time.Now().UTC().Format(UsageDateFormat) {
+			state.DayCount = 0
+		}
+
+		providerStats := CslIpReputationProviderStats{
+			CslReputationProviderState: state,
+			Provider:                   provider,
+			DailyQuota:                 getReputationDailyQuota(service.Config, provider),
+		}
+
+		providerStats.DailyRemaining = max(providerStats.DailyQuota-state.DayCount, 0)
+		if state.Remaining >= 0 && ok {
+			providerStats.DailyRemaining = min(providerStats.DailyRemaining, state.Remaining)
+		}
+
+		stats.Providers = append(stats.Providers, providerStats)
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    stats,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslIpReputationStats")
+}
+
+/*
+Enrichment:
+Returns the IP reputation configuration. Requires org admin.
+
+	{
+	    "success": true,
+	    "data": {
+	        "enabled": true,
+	        "abuseipdb_api_key": "********",
+	        "abuseipdb_daily_quota": 1000,
+	        "abuseipdb_max_age_days": 90,
+	        "greynoise_enabled": true,
+	        "greynoise_api_key": "",
+	        "greynoise_daily_quota": 50,
+	        "cache_hours": 24,
+	        "enrich_observables": false
+	    }
+	}
+*/
+func cslGetIpReputationConfig(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslIpReputationConfig(getCslIpReputationService(ctx, user.ActiveOrg.Id).Config),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetIpReputationConfig")
+}
+
+/*
+Enrichment:
+Updates the IP reputation configuration. Requires org admin. Body uses the
+format returned from GET, and redacted API keys are kept as they are. With
+enrich_observables, new IP observables extracted from executions get their
+reputation looked up.
+*/
+func cslSetIpReputationConfig(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	config := CslIpReputationConfig{}
+	err = json.Unmarshal(body, &config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	previous := getCslIpReputationService(ctx, user.ActiveOrg.Id).Config
+	if config.AbuseIpdbApiKey == RedactedValue {
+		config.AbuseIpdbApiKey = previous.AbuseIpdbApiKey
+	}
+
+	if config.GreyNoiseApiKey == RedactedValue {
+		config.GreyNoiseApiKey = previous.GreyNoiseApiKey
+	}
+
+	err = validateCslIpReputationConfig(config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	service := CslIpReputationService{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslIpReputationDocument, &service, func() error {
+		service.Config = config
+		setIpReputationDefaults(&service)
+		config = service.Config
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated ip reputation config for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "ip_reputation_updated", "IP reputation enrichment configuration was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslIpReputationConfig(config),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetIpReputationConfig")
+}
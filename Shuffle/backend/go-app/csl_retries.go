@@ -0,0 +1,536 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Retries of failed actions. When an action result fails in a category its
+// retry policy retries on, the failure isn't stored and the execution is put
+// back in the queue after the backoff, so a worker runs the action again. The
+// attempts so far are kept in the cache and stored as retry_count on the
+// final result of the action.
+
+const CslRetryPoliciesDocument = "retry_policies"
+
+const MaxRetryAttempts = 10
+const MaxRetryBackoffSeconds = 60 * 60
+
+// Attempt counts are kept for as long as an execution can run
+const RetryCountExpirationMinutes = 7 * 24 * 60
+
+// Most recent executions counted in the node statistics
+const DefaultNodeStatsExecutions = 100
+const MaxNodeStatsExecutions = 1000
+
+// max_attempts includes the first run, so 0 and 1 are no retries. The wait
+// before retry N is backoff_seconds * multiplier^(N-1), at most
+// max_backoff_seconds. Without retry_on every failure category is retried
+type CslRetryPolicy struct {
+	MaxAttempts       int      `json:"max_attempts"`
+	BackoffSeconds    int      `json:"backoff_seconds"`
+	Multiplier        float64  `json:"multiplier"`
+	MaxBackoffSeconds int      `json:"max_backoff_seconds"`
+	RetryOn           []string `json:"retry_on"`
+}
+
+// Nodes without their own policy use the default of the workflow
+type CslWorkflowRetryPolicy struct {
+	Default CslRetryPolicy            `json:"default"`
+	Nodes   map[string]CslRetryPolicy `json:"nodes"`
+}
+
+type CslRetryPolicies struct {
+	Workflows map[string]CslWorkflowRetryPolicy `json:"workflows"`
+}
+
+type CslNodeStats struct {
+	NodeId    string `json:"node_id"`
+	Label     string `json:"label"`
+	AppName   string `json:"app_name"`
+	Runs      int64  `json:"runs"`
+	Successes int64  `json:"successes"`
+	Failures  int64  `json:"failures"`
+	Skipped   int64  `json:"skipped"`
+	Retried   int64  `json:"retried"`
+	Retries   int64  `json:"retries"`
+}
+
+type CslWorkflowNodeStats struct {
+	WorkflowId string         `json:"workflow_id"`
+	Executions int            `json:"executions"`
+	Nodes      []CslNodeStats `json:"nodes"`
+}
+
+func getCslRetryPolicies(ctx context.Context, orgId string) CslRetryPolicies {
+	policies := CslRetryPolicies{}
+	_, err := getCslDocument(ctx, orgId, CslRetryPoliciesDocument, &policies)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting retry policies for org %s: %s", orgId, err)
+	}
+
+	if policies.Workflows == nil {
+		policies.Workflows = map[string]CslWorkflowRetryPolicy{}
+	}
+
+	return policies
+}
+
+func (policies CslRetryPolicies) getPolicy(workflowId, nodeId string) CslRetryPolicy {
+	workflowPolicy, ok := policies.Workflows[workflowId]
+	if !ok {
+		return CslRetryPolicy{}
+	}
+
+	if policy, ok := workflowPolicy.Nodes[nodeId]; ok {
+		return policy
+	}
+
+	return workflowPolicy.Default
+}
+
+func (policy CslRetryPolicy) retriesOn(category string) bool {
+	if len(policy.RetryOn) == 0 {
+		return category != shuffle.FailureUserAbort
+	}
+
+	return shuffle.ArrayContains(policy.RetryOn, category)
+}
+
+// Wait before the given retry, starting at 1
+func (policy CslRetryPolicy) getBackoff(retry int64) time.Duration {
+	multiplier := policy.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	seconds := float64(policy.BackoffSeconds) * math.Pow(multiplier, float64(retry-1))
+	if policy.MaxBackoffSeconds > 0 && seconds > float64(policy.MaxBackoffSeconds) {
+		seconds = float64(policy.MaxBackoffSeconds)
+	}
+
+	if seconds > MaxRetryBackoffSeconds {
+		seconds = MaxRetryBackoffSeconds
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func validateRetryPolicy(name string, policy CslRetryPolicy) error {
+	if policy.MaxAttempts < 0 || policy.MaxAttempts > MaxRetryAttempts {
+		return errors.New(fmt.Sprintf("max_attempts of %s must be between 0 and %d", name, MaxRetryAttempts))
+	}
+
+	if policy.BackoffSeconds < 0 || policy.BackoffSeconds > MaxRetryBackoffSeconds {
+		return errors.New(fmt.Sprintf("backoff_seconds of %s must be between 0 and %d", name, MaxRetryBackoffSeconds))
+	}
+
+	if policy.MaxBackoffSeconds < 0 || policy.MaxBackoffSeconds > MaxRetryBackoffSeconds {
+		return errors.New(fmt.Sprintf("max_backoff_seconds of %s must be between 0 and %d", name, MaxRetryBackoffSeconds))
+	}
+
+	if policy.Multiplier != 0 && (policy.Multiplier < 1 || policy.Multiplier > 10) {
+		return errors.New(fmt.Sprintf("multiplier of %s must be between 1 and 10", name))
+	}
+
+	for _, category := range policy.RetryOn {
+		if !shuffle.ArrayContains(shuffle.FailureCategories, category) {
+			return errors.New(fmt.Sprintf("unknown failure category %s in %s. Use one of %s", category, name, strings.Join(shuffle.FailureCategories, ", ")))
+		}
+	}
+
+	return nil
+}
+
+func getRetryCountKey(executionId, nodeId string) string {
+	return fmt.Sprintf("csl_retry_%s_%s", executionId, nodeId)
+}
+
+func getRetryCount(ctx context.Context, executionId, nodeId string) int64 {
+	cache, err := shuffle.GetCache(ctx, getRetryCountKey(executionId, nodeId))
+	if err != nil {
+		return 0
+	}
+
+	cacheData, ok := cache.([]uint8)
+	if !ok {
+		return 0
+	}
+
+	count, err := strconv.ParseInt(string(cacheData), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return count
+}
+
+// Puts the execution back in the queue of the environment of the failed
+// action, or of every environment of the workflow
+func requeueCslExecution(ctx context.Context, execution shuffle.WorkflowExecution, action shuffle.Action) error {
+	environments := []string{}
+	if len(action.Environment) > 0 && strings.ToLower(action.Environment) != "cloud" {
+		environments = append(environments, action.Environment)
+	} else {
+		environments = getWorkflowEnvironments(execution.Workflow)
+	}
+
+	if len(environments) == 0 {
+		return errors.New("no environment to run the action in")
+	}
+
+	executionRequest := shuffle.ExecutionRequest{
+		ExecutionId:   execution.ExecutionId,
+		WorkflowId:    execution.Workflow.ID,
+		Authorization: execution.Authorization,
+		Environments:  environments,
+		Priority:      execution.Priority,
+	}
+
+	for _, environment := range environments {
+		err := injectCslDispatchFault(ctx, execution.ExecutionOrg)
+		if err != nil {
+			return err
+		}
+
+		err = shuffle.SetWorkflowQueue(ctx, executionRequest, environment)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Called with action results before they are stored. Returns true when the
+// failed action is retried, in which case the result should be dropped.
+// Otherwise the retries so far are set on the result
+func retryCslAction(ctx context.Context, execution shuffle.WorkflowExecution, actionResult *shuffle.ActionResult) bool {
+	nodeId := actionResult.Action.ID
+	if len(nodeId) == 0 {
+		return false
+	}
+
+	retries := getRetryCount(ctx, execution.ExecutionId, nodeId)
+	actionResult.RetryCount = retries
+	if actionResult.Status != "FAILURE" {
+		return false
+	}
+
+	policy := getCslRetryPolicies(ctx, execution.ExecutionOrg).getPolicy(execution.Workflow.ID, nodeId)
+	if int64(policy.MaxAttempts) <= retries+1 {
+		return false
+	}
+
+	category := shuffle.GetFailureCategory(*actionResult)
+	if !policy.retriesOn(category) {
+		return false
+	}
+
+	retries += 1
+	err := shuffle.SetCache(ctx, getRetryCountKey(execution.ExecutionId, nodeId), []byte(strconv.FormatInt(retries, 10)), RetryCountExpirationMinutes)
+	if err != nil {
+		log.Printf("[WARNING] Failed storing retry count of node %s in execution %s: %s", nodeId, execution.ExecutionId, err)
+		return false
+	}
+
+	backoff := policy.getBackoff(retries)
+	log.Printf("[INFO] Retrying node %s in execution %s in %s after a %s failure (retry %d of %d)", nodeId, execution.ExecutionId, backoff, category, retries, policy.MaxAttempts-1)
+
+	action := actionResult.Action
+	time.AfterFunc(backoff, func() {
+		ctx := context.Background()
+		latest, err := shuffle.GetWorkflowExecution(ctx, execution.ExecutionId)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting execution %s to retry node %s: %s", execution.ExecutionId, nodeId, err)
+			return
+		}
+
+		if latest.Status != "EXECUTING" {
+			log.Printf("[INFO] Not retrying node %s as execution %s has status %s", nodeId, execution.ExecutionId, latest.Status)
+			return
+		}
+
+		err = requeueCslExecution(ctx, *latest, action)
+		if err != nil {
+			log.Printf("[WARNING] Failed requeueing execution %s to retry node %s: %s", execution.ExecutionId, nodeId, err)
+		}
+	})
+
+	return true
+}
+
+// Counts the results of each node in the most recent executions
+func getWorkflowNodeStats(ctx context.Context, workflow shuffle.Workflow, limit int) (CslWorkflowNodeStats, error) {
+	stats := CslWorkflowNodeStats{
+		WorkflowId: workflow.ID,
+		Nodes:      []CslNodeStats{},
+	}
+
+	executions, err := shuffle.GetAllWorkflowExecutions(ctx, workflow.ID, limit)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Executions = len(executions)
+
+	nodes := map[string]*CslNodeStats{}
+	for _, action := range workflow.Actions {
+		stats.Nodes = append(stats.Nodes, CslNodeStats{
+			NodeId:  action.ID,
+			Label:   action.Label,
+			AppName: action.AppName,
+		})
+	}
+
+	for index := range stats.Nodes {
+		nodes[stats.Nodes[index].NodeId] = &stats.Nodes[index]
+	}
+
+	for _, execution := range executions {
+		for _, result := range execution.Results {
+			node, ok := nodes[result.Action.ID]
+			if !ok {
+				continue
+			}
+
+			node.Runs += 1
+			if result.Status == "SUCCESS" {
+				node.Successes += 1
+			} else if result.Status == "FAILURE" || result.Status == "ABORTED" {
+				node.Failures += 1
+			} else if result.Status == "SKIPPED" {
+				node.Skipped += 1
+			}
+
+			if result.RetryCount > 0 {
+				node.Retried += 1
+				node.Retries += result.RetryCount
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+/*
+Retry policies:
+Returns the retry policies of failed actions in the current organization per
+workflow. Nodes without their own policy use the default of their workflow.
+max_attempts includes the first run, and the wait before each retry grows by
+multiplier up to max_backoff_seconds. retry_on is a list of failure
+categories, and every category except user_abort is retried without it.
+
+	{
+	    "success": true,
+	    "data": {
+	        "workflows": {
+	            "5ec3...": {
+	                "default": {
+	                    "max_attempts": 3,
+	                    "backoff_seconds": 10,
+	                    "multiplier": 2,
+	                    "max_backoff_seconds": 300,
+	                    "retry_on": ["timeout", "app_crash"]
+	                },
+	                "nodes": {
+	                    "c0f1...": {
+	                        "max_attempts": 1
+	                    }
+	                }
+	            }
+	        }
+	    }
+	}
+*/
+func cslGetRetryPolicies(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslRetryPolicies(ctx, user.ActiveOrg.Id),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetRetryPolicies")
+}
+
+/*
+Retry policies:
+Sets the retry policies of the current organization in the format returned
+from GET. Requires org admin. Actions can be run at most 10 times, with at
+most an hour between attempts.
+*/
+func cslSetRetryPolicies(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	newPolicies := CslRetryPolicies{}
+	err = json.Unmarshal(body, &newPolicies)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	for workflowId, workflowPolicy := range newPolicies.Workflows {
+		workflow, err := shuffle.GetWorkflow(ctx, workflowId)
+		if err != nil || workflow.OrgId != user.ActiveOrg.Id {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("workflow %s not found", workflowId))))
+			return
+		}
+
+		err = validateRetryPolicy(fmt.Sprintf("workflow %s", workflowId), workflowPolicy.Default)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		for nodeId, policy := range workflowPolicy.Nodes {
+			found := false
+			for _, action := range workflow.Actions {
+				if action.ID == nodeId {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				resp.WriteHeader(400)
+				resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("node %s not found in workflow %s", nodeId, workflowId))))
+				return
+			}
+
+			err = validateRetryPolicy(fmt.Sprintf("node %s", nodeId), policy)
+			if err != nil {
+				resp.WriteHeader(400)
+				resp.Write(createCslErrorResponse(err))
+				return
+			}
+		}
+
+		if workflowPolicy.Nodes == nil {
+			workflowPolicy.Nodes = map[string]CslRetryPolicy{}
+			newPolicies.Workflows[workflowId] = workflowPolicy
+		}
+	}
+
+	if newPolicies.Workflows == nil {
+		newPolicies.Workflows = map[string]CslWorkflowRetryPolicy{}
+	}
+
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslRetryPoliciesDocument, newPolicies)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) changed retry policies of org %s. Workflows: %d", user.Username, user.Id, user.ActiveOrg.Id, len(newPolicies.Workflows))
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "retry_policies_changed", "Retry policies were changed", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    newPolicies,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetRetryPolicies")
+}
+
+/*
+Node statistics:
+Returns result counts of each node in ?workflow_id= over its most recent
+?executions=N executions (default 100, max 1000). retried is how many results
+of the node needed retries, and retries is the sum of their retries.
+
+	{
+	    "success": true,
+	    "data": {
+	        "workflow_id": "5ec3...",
+	        "executions": 100,
+	        "nodes": [
+	            {
+	                "node_id": "c0f1...",
+	                "label": "Get alert",
+	                "app_name": "Splunk",
+	                "runs": 100,
+	                "successes": 97,
+	                "failures": 3,
+	                "skipped": 0,
+	                "retried": 12,
+	                "retries": 15
+	            }
+	        ]
+	    }
+	}
+*/
+func cslNodeStats(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	limit := DefaultNodeStatsExecutions
+	if len(query.Get("executions")) > 0 {
+		value, err := strconv.Atoi(query.Get("executions"))
+		if err != nil || value < 1 || value > MaxNodeStatsExecutions {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("executions must be between 1 and %d", MaxNodeStatsExecutions))))
+			return
+		}
+
+		limit = value
+	}
+
+	workflow, err := getCslWorkflow(ctx, *user, query.Get("workflow_id"))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	stats, err := getWorkflowNodeStats(ctx, *workflow, limit)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    stats,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslNodeStats")
+}
@@ -0,0 +1,776 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Custom roles with a set of permissions, for least privilege access in
+// large orgs. Members with a custom role can only make the requests their
+// permissions allow, and get admin access for those requests, so a role can
+// hand out parts of the admin role. Org admins and users without a custom
+// role are not affected.
+//
+// Requests are matched against cslPermissionRules in order, and requests
+// without a matching rule are denied, so new endpoints are admin only for
+// custom roles until they are added here.
+
+const CslRolesDocument = "roles"
+
+const MaxCslRoles = 50
+const MaxCslRoleNameLength = 50
+
+// How long other replicas can use roles that were changed
+const RolesCacheMinutes = 1
+
+const (
+	PermissionStatsRead         = "stats:read"
+	PermissionAuditRead         = "audit:read"
+	PermissionWorkflowsRead     = "workflows:read"
+	PermissionWorkflowsWrite    = "workflows:write"
+	PermissionWorkflowsExecute  = "workflows:execute"
+	PermissionAppsManage        = "apps:manage"
+	PermissionCredentialsManage = "credentials:manage"
+	PermissionUsersManage       = "users:manage"
+	PermissionSettingsManage    = "settings:manage"
+
+	// Requests every role can make, such as getting your own user
+	PermissionBase = ""
+)
+
+type CslPermission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+var cslPermissions = []CslPermission{
+	{Name: PermissionStatsRead, Description: "View statistics, charts and reports"},
+	{Name: PermissionAuditRead, Description: "View the activity feed, timeline, sessions and login locations"},
+	{Name: PermissionWorkflowsRead, Description: "View workflows, their executions, versions and templates"},
+	{Name: PermissionWorkflowsWrite, Description: "Create, edit, import and delete workflows, triggers and artifacts"},
+	{Name: PermissionWorkflowsExecute, Description: "Run workflows and actions, and abort or cancel executions"},
+	{Name: PermissionAppsManage, Description: "Install, upload, update, delete and publish apps"},
+	{Name: PermissionCredentialsManage, Description: "Add, change and delete app authentication"},
+	{Name: PermissionUsersManage, Description: "Add and remove users, and manage sessions, MFA, passwords, SSO, SCIM and LDAP"},
+	{Name: PermissionSettingsManage, Description: "Change org settings, notifications, ticketing, quotas, security policies and integrations"},
+}
+
+// Roles of orgs that haven't set their own yet
+var defaultCslRoles = []CslRole{
+	{Name: "stats viewer", Description: "Read only access to statistics", Permissions: []string{PermissionStatsRead}},
+	{Name: "workflow editor", Description: "Builds and runs workflows", Permissions: []string{PermissionWorkflowsRead, PermissionWorkflowsWrite, PermissionWorkflowsExecute}},
+	{Name: "app manager", Description: "Manages apps and their authentication", Permissions: []string{PermissionAppsManage, PermissionCredentialsManage}},
+}
+
+type CslRole struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type CslRoles struct {
+	Roles []CslRole `json:"roles"`
+
+	// Role of each user id
+	Assignments map[string]string `json:"assignments"`
+
+	UpdatedBy string `json:"updated_by"`
+	Updated   int64  `json:"updated"`
+}
+
+type CslRolesResponse struct {
+	CslRoles
+	Permissions []CslPermission `json:"permissions"`
+}
+
+type CslUserPermissions struct {
+	Role        string   `json:"role"`
+	Admin       bool     `json:"admin"`
+	Permissions []string `json:"permissions"`
+}
+
+const (
+	RuleMethodsRead  = "read"
+	RuleMethodsWrite = "write"
+)
+
+// Path segments of * match any segment. Paths below the pattern match too,
+// unless it's exact
+type cslPermissionRule struct {
+	Pattern    string
+	Methods    string
+	Exact      bool
+	Permission string
+}
+
+var cslPermissionRules = []cslPermissionRule{
+	// Every role
+	{Pattern: "/api/v1/getinfo", Permission: PermissionBase},
+	{Pattern: "/api/v1/getsettings", Permission: PermissionBase},
+	{Pattern: "/api/v1/login", Permission: PermissionBase},
+	{Pattern: "/api/v1/logout", Permission: PermissionBase},
+	{Pattern: "/api/v1/passwordchange", Permission: PermissionBase},
+	{Pattern: "/api/v1/generateapikey", Permission: PermissionBase},
+	{Pattern: "/api/v1/notifications", Permission: PermissionBase},
+	{Pattern: "/api/v1/users/getinfo", Permission: PermissionBase},
+	{Pattern: "/api/v1/users/getsettings", Permission: PermissionBase},
+	{Pattern: "/api/v1/users/login", Permission: PermissionBase},
+	{Pattern: "/api/v1/users/logout", Permission: PermissionBase},
+	{Pattern: "/api/v1/users/passwordchange", Permission: PermissionBase},
+	{Pattern: "/api/v1/users/generateapikey", Permission: PermissionBase},
+	{Pattern: "/api/v1/users/notifications", Permission: PermissionBase},
+	{Pattern: "/api/v1/users/apps", Methods: RuleMethodsRead, Permission: PermissionBase},
+	{Pattern: "/api/v1/users/*/get2fa", Permission: PermissionBase},
+	{Pattern: "/api/v1/users/*/set2fa", Permission: PermissionBase},
+	{Pattern: "/api/v1/health", Methods: RuleMethodsRead, Permission: PermissionBase},
+	{Pattern: "/api/v1/docs", Methods: RuleMethodsRead, Permission: PermissionBase},
+	{Pattern: "/api/v1/getorgs", Methods: RuleMethodsRead, Permission: PermissionBase},
+	{Pattern: "/api/v1/orgs", Methods: RuleMethodsRead, Exact: true, Permission: PermissionBase},
+	{Pattern: "/api/v1/orgs/*", Methods: RuleMethodsRead, Exact: true, Permission: PermissionBase},
+	{Pattern: "/api/v1/orgs/*/change", Permission: PermissionBase},
+	{Pattern: "/api/v1/csl/login", Permission: PermissionBase},
+	{Pattern: "/api/v1/csl/mfa", Methods: RuleMethodsRead, Exact: true, Permission: PermissionBase},
+	{Pattern: "/api/v1/csl/mfa/enroll", Permission: PermissionBase},
+	{Pattern: "/api/v1/csl/mfa/verify", Permission: PermissionBase},
+	{Pattern: "/api/v1/csl/mfa/backup_codes", Permission: PermissionBase},
+	{Pattern: "/api/v1/csl/mfa/disable", Permission: PermissionBase},
+	{Pattern: "/api/v1/csl/password/expired", Permission: PermissionBase},
+	{Pattern: "/api/v1/csl/apiKeys", Permission: PermissionBase},
+	{Pattern: "/api/v1/csl/roles/me", Methods: RuleMethodsRead, Permission: PermissionBase},
+	{Pattern: "/api/v1/csl/workflowAcl", Permission: PermissionBase},
+	{Pattern: "/api/v1/csl/approvals/policies", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/approvals", Permission: PermissionBase},
+	{Pattern: "/api/v1/csl/notifications/preferences", Permission: PermissionBase},
+
+	// Statistics
+	{Pattern: "/api/v1/orgs/*/stats", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/orgs/*/statistics", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/stats", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/workflows", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/apps", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/apiUsage", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/workflowExecutions", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/workflowChart", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/appChart", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/appUsage", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/executionHeatmap", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/forecast", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/trends", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/triggers", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/failures", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/healthScore", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/fleetHealth", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/queueBacklog", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/workflowQuality", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/nodeStats", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/alerts", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/statsBackfill", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/digest/preview", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/maintenanceWindows/stats", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/observables/stats", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/yara/stats", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/templates/stats", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/enrichment/*/stats", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+
+	// Audit
+	{Pattern: "/api/v1/csl/activity", Methods: RuleMethodsRead, Permission: PermissionAuditRead},
+	{Pattern: "/api/v1/csl/timeline", Methods: RuleMethodsRead, Permission: PermissionAuditRead},
+	{Pattern: "/api/v1/csl/geo", Methods: RuleMethodsRead, Permission: PermissionAuditRead},
+	{Pattern: "/api/v1/csl/sessions", Methods: RuleMethodsRead, Exact: true, Permission: PermissionAuditRead},
+	{Pattern: "/api/v1/csl/password/locked", Methods: RuleMethodsRead, Permission: PermissionAuditRead},
+	{Pattern: "/api/v1/csl/dataSubject/reports", Methods: RuleMethodsRead, Permission: PermissionAuditRead},
+
+	// Credentials, before apps as they share the path
+	{Pattern: "/api/v1/apps/authentication", Permission: PermissionCredentialsManage},
+	{Pattern: "/api/v1/csl/credentials", Permission: PermissionCredentialsManage},
+
+	// Running workflows and actions
+	{Pattern: "/api/v1/workflows/*/execute", Permission: PermissionWorkflowsExecute},
+	{Pattern: "/api/v1/workflows/*/run", Permission: PermissionWorkflowsExecute},
+	{Pattern: "/api/v1/workflows/*/executions/*/rerun", Permission: PermissionWorkflowsExecute},
+	{Pattern: "/api/v1/workflows/*/executions/*/abort", Permission: PermissionWorkflowsExecute},
+	{Pattern: "/api/v1/apps/*/execute", Permission: PermissionWorkflowsExecute},
+	{Pattern: "/api/v1/apps/*/run", Permission: PermissionWorkflowsExecute},
+	{Pattern: "/api/v1/apps/categories/run", Permission: PermissionWorkflowsExecute},
+	{Pattern: "/api/v1/environments/*/rerun", Permission: PermissionWorkflowsExecute},
+	{Pattern: "/api/v1/environments/*/stop", Permission: PermissionWorkflowsExecute},
+	{Pattern: "/api/v1/csl/workflowExecutions/cancel", Permission: PermissionWorkflowsExecute},
+
+	// Apps. Every role can see them, as they're needed to read workflows
+	{Pattern: "/api/v1/apps/*/activate", Permission: PermissionAppsManage},
+	{Pattern: "/api/v1/apps/run_hotload", Permission: PermissionAppsManage},
+	{Pattern: "/api/v1/apps/search", Permission: PermissionBase},
+	{Pattern: "/api/v1/apps/get_existing", Permission: PermissionBase},
+	{Pattern: "/api/v1/apps", Methods: RuleMethodsRead, Permission: PermissionBase},
+	{Pattern: "/api/v1/apps", Methods: RuleMethodsWrite, Permission: PermissionAppsManage},
+	{Pattern: "/api/v1/workflows/apps/validate", Permission: PermissionWorkflowsWrite},
+	{Pattern: "/api/v1/workflows/apps", Methods: RuleMethodsRead, Permission: PermissionBase},
+	{Pattern: "/api/v1/workflows/apps", Methods: RuleMethodsWrite, Permission: PermissionAppsManage},
+	{Pattern: "/api/v1/verify_swagger", Permission: PermissionAppsManage},
+	{Pattern: "/api/v1/verify_openapi", Permission: PermissionAppsManage},
+	{Pattern: "/api/v1/validate_openapi", Permission: PermissionAppsManage},
+	{Pattern: "/api/v1/get_openapi_uri", Permission: PermissionAppsManage},
+	{Pattern: "/api/v1/get_openapi", Permission: PermissionAppsManage},
+	{Pattern: "/api/v1/get_docker_image", Permission: PermissionAppsManage},
+	{Pattern: "/api/v1/csl/apps/validate", Permission: PermissionAppsManage},
+	{Pattern: "/api/v1/csl/publications", Permission: PermissionAppsManage},
+	{Pattern: "/api/v1/csl/subscriptions", Permission: PermissionAppsManage},
+
+	// Workflows
+	{Pattern: "/api/v1/workflows/search", Permission: PermissionWorkflowsRead},
+	{Pattern: "/api/v1/workflows", Methods: RuleMethodsRead, Permission: PermissionWorkflowsRead},
+	{Pattern: "/api/v1/workflows", Methods: RuleMethodsWrite, Permission: PermissionWorkflowsWrite},
+	{Pattern: "/api/v1/streams/results", Permission: PermissionWorkflowsRead},
+	{Pattern: "/api/v1/getenvironments", Methods: RuleMethodsRead, Permission: PermissionWorkflowsRead},
+	{Pattern: "/api/v1/triggers", Methods: RuleMethodsRead, Permission: PermissionWorkflowsRead},
+	{Pattern: "/api/v1/triggers", Permission: PermissionWorkflowsWrite},
+	{Pattern: "/api/v1/pipelines", Permission: PermissionWorkflowsWrite},
+	{Pattern: "/api/v1/hooks", Permission: PermissionWorkflowsWrite},
+	{Pattern: "/api/v1/recommendations", Permission: PermissionWorkflowsWrite},
+	{Pattern: "/api/v1/files", Methods: RuleMethodsRead, Permission: PermissionWorkflowsRead},
+	{Pattern: "/api/v1/files", Permission: PermissionWorkflowsWrite},
+	{Pattern: "/api/v1/dashboards", Methods: RuleMethodsRead, Permission: PermissionStatsRead},
+	{Pattern: "/api/v1/csl/workflowVersions", Methods: RuleMethodsRead, Permission: PermissionWorkflowsRead},
+	{Pattern: "/api/v1/csl/workflowVersions", Permission: PermissionWorkflowsWrite},
+	{Pattern: "/api/v1/csl/workflowApps", Methods: RuleMethodsRead, Permission: PermissionWorkflowsRead},
+	{Pattern: "/api/v1/csl/workflowApps", Permission: PermissionWorkflowsWrite},
+	{Pattern: "/api/v1/csl/bundles/export", Permission: PermissionWorkflowsRead},
+	{Pattern: "/api/v1/csl/bundles/import", Permission: PermissionWorkflowsWrite},
+	{Pattern: "/api/v1/csl/schedules", Methods: RuleMethodsRead, Permission: PermissionWorkflowsRead},
+	{Pattern: "/api/v1/csl/templates", Methods: RuleMethodsRead, Permission: PermissionWorkflowsRead},
+	{Pattern: "/api/v1/csl/templates", Permission: PermissionWorkflowsWrite},
+	{Pattern: "/api/v1/csl/artifacts", Methods: RuleMethodsRead, Permission: PermissionWorkflowsRead},
+	{Pattern: "/api/v1/csl/artifacts/quota", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/artifacts", Permission: PermissionWorkflowsWrite},
+	{Pattern: "/api/v1/csl/sigma/translate", Permission: PermissionWorkflowsRead},
+
+	// Users
+	{Pattern: "/api/v1/users/register", Permission: PermissionUsersManage},
+	{Pattern: "/api/v1/register", Permission: PermissionUsersManage},
+	{Pattern: "/api/v1/users/getusers", Permission: PermissionUsersManage},
+	{Pattern: "/api/v1/getusers", Permission: PermissionUsersManage},
+	{Pattern: "/api/v1/users", Methods: RuleMethodsRead, Exact: true, Permission: PermissionUsersManage},
+	{Pattern: "/api/v1/users/updateuser", Permission: PermissionUsersManage},
+	{Pattern: "/api/v1/users/*", Methods: RuleMethodsWrite, Exact: true, Permission: PermissionUsersManage},
+	{Pattern: "/api/v1/csl/sessions", Permission: PermissionUsersManage},
+	{Pattern: "/api/v1/csl/mfa", Permission: PermissionUsersManage},
+	{Pattern: "/api/v1/csl/password", Permission: PermissionUsersManage},
+	{Pattern: "/api/v1/csl/sso", Permission: PermissionUsersManage},
+	{Pattern: "/api/v1/csl/scim", Methods: RuleMethodsRead, Exact: true, Permission: PermissionUsersManage},
+	{Pattern: "/api/v1/csl/scim", Methods: RuleMethodsWrite, Exact: true, Permission: PermissionUsersManage},
+	{Pattern: "/api/v1/csl/scim/token", Permission: PermissionUsersManage},
+	{Pattern: "/api/v1/csl/ldap", Permission: PermissionUsersManage},
+
+	// Settings
+	{Pattern: "/api/v1/setenvironments", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/orgs/*/cache", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/orgs/*/datastore", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/orgs/*/list_cache", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/orgs/*/get_cache", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/orgs/*/set_cache", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/orgs/*/delete_cache", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/settings", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/notifications", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/digest", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/ticketing", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/quotas", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/resourceLimits", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/executionPriorities", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/executionTimeouts", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/retryPolicies", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/maintenanceWindows", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/ipAllowlist", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/cors", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/webhookSecurity", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/lifecycleWebhooks", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/redaction", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/pii", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/gitSync", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/observables", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/yara", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/sandbox", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/enrichment", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/siem", Permission: PermissionSettingsManage},
+	{Pattern: "/api/v1/csl/featureFlags", Permission: PermissionSettingsManage},
+}
+
+func isCslRuleMethod(methods, method string) bool {
+	read := method == "GET" || method == "HEAD"
+	switch methods {
+	case RuleMethodsRead:
+		return read
+	case RuleMethodsWrite:
+		return !read
+	}
+
+	return true
+}
+
+func matchCslPermissionRule(method, path string) *cslPermissionRule {
+	pathParts := strings.Split(strings.TrimRight(path, "/"), "/")
+	for i := range cslPermissionRules {
+		rule := &cslPermissionRules[i]
+		if !isCslRuleMethod(rule.Methods, method) {
+			continue
+		}
+
+		patternParts := strings.Split(rule.Pattern, "/")
+		if len(pathParts) < len(patternParts) || (rule.Exact && len(pathParts) != len(patternParts)) {
+			continue
+		}
+
+		matched := true
+		for j, part := range patternParts {
+			if part != "*" && part != pathParts[j] {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// Permission an endpoint needs, or an empty string for endpoints only admins
+// can use
+func getCslRequestPermission(request *http.Request) string {
+	rule := matchCslPermissionRule(request.Method, request.URL.Path)
+	if rule == nil {
+		return ""
+	}
+
+	return rule.Permission
+}
+
+func getCslRoles(ctx context.Context, orgId string) CslRoles {
+	roles := CslRoles{}
+	found, err := getCslDocument(ctx, orgId, CslRolesDocument, &roles)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting roles for org %s: %s", orgId, err)
+	}
+
+	if !found {
+		roles.Roles = defaultCslRoles
+	}
+
+	setCslRolesDefaults(&roles)
+	return roles
+}
+
+func getCslRolesCacheKey(orgId string) string {
+	return fmt.Sprintf("csl_roles_%s", orgId)
+}
+
+// Same as getCslRoles, but cached as the roles middleware needs them on every
+// request. The cache is cleared when roles or assignments change
+func getCachedCslRoles(ctx context.Context, orgId string) CslRoles {
+	cacheKey := getCslRolesCacheKey(orgId)
+	cache, err := shuffle.GetCache(ctx, cacheKey)
+	if cacheData, ok := cache.([]uint8); err == nil && ok {
+		roles := CslRoles{}
+		err = json.Unmarshal(cacheData, &roles)
+		if err == nil {
+			setCslRolesDefaults(&roles)
+			return roles
+		}
+	}
+
+	roles := getCslRoles(ctx, orgId)
+	cacheData, err := json.Marshal(roles)
+	if err == nil {
+		shuffle.SetCache(ctx, cacheKey, cacheData, RolesCacheMinutes)
+	}
+
+	return roles
+}
+
+func setCslRolesDefaults(roles *CslRoles) {
+	if roles.Roles == nil {
+		roles.Roles = []CslRole{}
+	}
+
+	if roles.Assignments == nil {
+		roles.Assignments = map[string]string{}
+	}
+}
+
+func (roles CslRoles) getRole(name string) (CslRole, bool) {
+	for _, role := range roles.Roles {
+		if role.Name == name {
+			return role, true
+		}
+	}
+
+	return CslRole{}, false
+}
+
+func isCslPermission(name string) bool {
+	for _, permission := range cslPermissions {
+		if permission.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validateCslRoles(roles []CslRole) error {
+	if len(roles) > MaxCslRoles {
+		return errors.New(fmt.Sprintf("an org can have at most %d roles", MaxCslRoles))
+	}
+
+	names := map[string]bool{}
+	for _, role := range roles {
+		if len(strings.TrimSpace(role.Name)) == 0 || len(role.Name) > MaxCslRoleNameLength {
+			return errors.New(fmt.Sprintf("role names must be between 1 and %d characters", MaxCslRoleNameLength))
+		}
+
+		if shuffle.ArrayContains(ssoRoles, role.Name) {
+			return errors.New(fmt.Sprintf("%s is a built in role", role.Name))
+		}
+
+		if names[role.Name] {
+			return errors.New(fmt.Sprintf("role %s is defined more than once", role.Name))
+		}
+
+		names[role.Name] = true
+		for _, permission := range role.Permissions {
+			if !isCslPermission(permission) {
+				return errors.New(fmt.Sprintf("unknown permission %s of role %s", permission, role.Name))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Whether the user has the admin role in their active org. Unlike
+// checkUserOrgAdmin, support access doesn't count
+func isCslOrgMemberAdmin(ctx context.Context, user shuffle.User) bool {
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		return false
+	}
+
+	for _, orgUser := range org.Users {
+		if orgUser.Id == user.Id {
+			return orgUser.Role == "admin"
+		}
+	}
+
+	return false
+}
+
+// Enforces the permissions of custom roles, and grants admin access to
+// requests the role allows (shuffle-shared delegation.go)
+func cslRolesMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, request *http.Request) {
+		if !strings.HasPrefix(request.URL.Path, "/api/") || request.Method == "OPTIONS" {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		user, err := getMiddlewareUser(resp, request)
+		if err != nil || len(user.ActiveOrg.Id) == 0 || user.SupportAccess {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		ctx := shuffle.GetContext(request)
+		roles := getCachedCslRoles(ctx, user.ActiveOrg.Id)
+		roleName, ok := roles.Assignments[user.Id]
+		if !ok || isCslOrgMemberAdmin(ctx, user) {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		// Assignments are removed with the role, so this only happens if
+		// the document was changed by hand. The user gets no permissions
+		role, _ := roles.getRole(roleName)
+
+		rule := matchCslPermissionRule(request.Method, request.URL.Path)
+		if rule == nil || (rule.Permission != PermissionBase && !shuffle.ArrayContains(role.Permissions, rule.Permission)) {
+			log.Printf("[WARNING] Role %s of user %s (%s) in org %s doesn't allow %s %s", roleName, user.Username, user.Id, user.ActiveOrg.Id, request.Method, request.URL.Path)
+			resp.WriteHeader(403)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("your role %s doesn't allow this request", roleName))))
+			return
+		}
+
+		if rule.Permission != PermissionBase {
+			request = request.WithContext(shuffle.WithDelegatedGrant(request.Context(), shuffle.DelegatedGrant{
+				UserId:     user.Id,
+				OrgId:      user.ActiveOrg.Id,
+				Role:       roleName,
+				Permission: rule.Permission,
+			}))
+		}
+
+		next.ServeHTTP(resp, request)
+	})
+}
+
+/*
+Roles:
+Returns the custom roles of the current organization, which users have them
+and every permission a role can have. Orgs that haven't set roles get stats
+viewer, workflow editor and app manager. Requires org admin.
+
+	{"success": true, "data": {"roles": [{"name": "stats viewer", "description": "Read only access to statistics", "permissions": ["stats:read"]}], "assignments": {"<user id>": "stats viewer"}, "updated_by": "admin", "updated": 1700000000, "permissions": [{"name": "stats:read", "description": "View statistics, charts and reports"}]}}
+*/
+func cslGetRoles(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data: CslRolesResponse{
+			CslRoles:    getCslRoles(ctx, user.ActiveOrg.Id),
+			Permissions: cslPermissions,
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetRoles")
+}
+
+/*
+Roles:
+Sets the custom roles of the current organization. Users with a role that
+was removed lose it, and go back to their org role. Requires org admin.
+
+	{"roles": [{"name": "stats viewer", "description": "Read only access to statistics", "permissions": ["stats:read"]}]}
+*/
+func cslSetRoles(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	updated := CslRoles{}
+	err = json.Unmarshal(body, &updated)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	setCslRolesDefaults(&updated)
+	err = validateCslRoles(updated.Roles)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	roles := CslRoles{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslRolesDocument, &roles, func() error {
+		setCslRolesDefaults(&roles)
+		for userId, roleName := range roles.Assignments {
+			if _, ok := updated.getRole(roleName); !ok {
+				log.Printf("[AUDIT] Removed role %s from user %s in org %s, as the role was removed", roleName, userId, user.ActiveOrg.Id)
+				delete(roles.Assignments, userId)
+			}
+		}
+
+		roles.Roles = updated.Roles
+		roles.UpdatedBy = user.Username
+		roles.Updated = time.Now().Unix()
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	shuffle.DeleteCache(ctx, getCslRolesCacheKey(user.ActiveOrg.Id))
+	log.Printf("[AUDIT] User %s (%s) changed the roles of org %s. Roles: %d", user.Username, user.Id, user.ActiveOrg.Id, len(roles.Roles))
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeSecurity, "roles_changed", "Custom roles were changed", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data: CslRolesResponse{
+			CslRoles:    roles,
+			Permissions: cslPermissions,
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetRoles")
+}
+
+/*
+Roles:
+Gives a member of the organization a custom role, or removes it with an
+empty role. Admins can't have a custom role. Requires org admin.
+
+	{"user_id": "<user id>", "role": "workflow editor"}
+*/
+func cslAssignRole(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	assignment := struct {
+		UserId string `json:"user_id"`
+		Role   string `json:"role"`
+	}{}
+
+	err = json.Unmarshal(body, &assignment)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	var member *shuffle.User
+	for i := range org.Users {
+		if org.Users[i].Id == assignment.UserId {
+			member = &org.Users[i]
+			break
+		}
+	}
+
+	if member == nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("user %s isn't a member of the organization", assignment.UserId))))
+		return
+	}
+
+	if member.Role == "admin" && len(assignment.Role) > 0 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("admins already have every permission, so they can't have a custom role")))
+		return
+	}
+
+	roles := CslRoles{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslRolesDocument, &roles, func() error {
+		setCslRolesDefaults(&roles)
+		if len(roles.Roles) == 0 && len(roles.Assignments) == 0 && roles.Updated == 0 {
+			roles.Roles = defaultCslRoles
+		}
+
+		if len(assignment.Role) == 0 {
+			delete(roles.Assignments, assignment.UserId)
+			return nil
+		}
+
+		if _, ok := roles.getRole(assignment.Role); !ok {
+			return errors.New(fmt.Sprintf("role %s not found", assignment.Role))
+		}
+
+		roles.Assignments[assignment.UserId] = assignment.Role
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	shuffle.DeleteCache(ctx, getCslRolesCacheKey(user.ActiveOrg.Id))
+	if len(assignment.Role) == 0 {
+		log.Printf("[AUDIT] User %s (%s) removed the custom role of user %s (%s) in org %s", user.Username, user.Id, member.Username, member.Id, user.ActiveOrg.Id)
+		recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeSecurity, "role_removed", fmt.Sprintf("%s no longer has a custom role", member.Username), user.Username, member.Id)
+	} else {
+		log.Printf("[AUDIT] User %s (%s) gave user %s (%s) the role %s in org %s", user.Username, user.Id, member.Username, member.Id, assignment.Role, user.ActiveOrg.Id)
+		recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeSecurity, "role_assigned", fmt.Sprintf("%s now has the role %s", member.Username, assignment.Role), user.Username, member.Id)
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data: CslRolesResponse{
+			CslRoles:    roles,
+			Permissions: cslPermissions,
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslAssignRole")
+}
+
+/*
+Roles:
+Returns the custom role and permissions of the current user, so the frontend
+can hide what they can't do. Admins have every permission.
+
+	{"success": true, "data": {"role": "stats viewer", "admin": false, "permissions": ["stats:read"]}}
+*/
+func cslGetMyPermissions(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	permissions := CslUserPermissions{
+		Permissions: []string{},
+	}
+
+	roles := getCslRoles(ctx, user.ActiveOrg.Id)
+	roleName, assigned := roles.Assignments[user.Id]
+	if user.SupportAccess || isCslOrgMemberAdmin(ctx, *user) {
+		permissions.Admin = true
+		assigned = false
+	}
+
+	if assigned {
+		role, _ := roles.getRole(roleName)
+		permissions.Role = roleName
+		if role.Permissions != nil {
+			permissions.Permissions = role.Permissions
+		}
+	} else if permissions.Admin {
+		for _, permission := range cslPermissions {
+			permissions.Permissions = append(permissions.Permissions, permission.Name)
+		}
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    permissions,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetMyPermissions")
+}
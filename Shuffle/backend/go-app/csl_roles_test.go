@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+func TestIsCslRuleMethod(t *testing.T) {
+	tests := []struct {
+		methods  string
+		method   string
+		expected bool
+	}{
+		{methods: "", method: "GET", expected: true},
+		{methods: "", method: "DELETE", expected: true},
+		{methods: RuleMethodsRead, method: "GET", expected: true},
+		{methods: RuleMethodsRead, method: "HEAD", expected: true},
+		{methods: RuleMethodsRead, method: "POST"},
+		{methods: RuleMethodsWrite, method: "PUT", expected: true},
+		{methods: RuleMethodsWrite, method: "GET"},
+	}
+
+	for _, test := range tests {
+		if isCslRuleMethod(test.methods, test.method) != test.expected {
+			t.Errorf("isCslRuleMethod(%q, %s) = %t, expected %t", test.methods, test.method, !test.expected, test.expected)
+		}
+	}
+}
+
+func TestMatchCslPermissionRule(t *testing.T) {
+	tests := []struct {
+		method     string
+		path       string
+		matched    bool
+		permission string
+	}{
+		{method: "GET", path: "/api/v1/getinfo", matched: true, permission: PermissionBase},
+		{method: "GET", path: "/api/v1/csl/workflows", matched: true, permission: PermissionStatsRead},
+		{method: "POST", path: "/api/v1/csl/workflows", matched: false},
+		{method: "GET", path: "/api/v1/workflows", matched: true, permission: PermissionWorkflowsRead},
+		{method: "GET", path: "/api/v1/workflows/abc/", matched: true, permission: PermissionWorkflowsRead},
+		{method: "PUT", path: "/api/v1/workflows/abc", matched: true, permission: PermissionWorkflowsWrite},
+		{method: "POST", path: "/api/v1/workflows/abc/execute", matched: true, permission: PermissionWorkflowsExecute},
+		{method: "POST", path: "/api/v1/workflows/abc/executions/def/abort", matched: true, permission: PermissionWorkflowsExecute},
+		{method: "GET", path: "/api/v1/apps/authentication", matched: true, permission: PermissionCredentialsManage},
+		{method: "GET", path: "/api/v1/apps", matched: true, permission: PermissionBase},
+		{method: "DELETE", path: "/api/v1/apps/abc", matched: true, permission: PermissionAppsManage},
+		{method: "GET", path: "/api/v1/orgs/abc", matched: true, permission: PermissionBase},
+		{method: "POST", path: "/api/v1/orgs/abc", matched: false},
+		{method: "GET", path: "/api/v1/orgs/abc/stats", matched: true, permission: PermissionStatsRead},
+		{method: "POST", path: "/api/v1/orgs/abc/set_cache", matched: true, permission: PermissionSettingsManage},
+		{method: "GET", path: "/api/v1/csl/sessions", matched: true, permission: PermissionAuditRead},
+		{method: "DELETE", path: "/api/v1/csl/sessions/abc", matched: true, permission: PermissionUsersManage},
+		{method: "GET", path: "/api/v1/csl/mfa", matched: true, permission: PermissionBase},
+		{method: "GET", path: "/api/v1/csl/mfa/users", matched: true, permission: PermissionUsersManage},
+		{method: "GET", path: "/api/v1/users", matched: true, permission: PermissionUsersManage},
+		{method: "DELETE", path: "/api/v1/users/abc", matched: true, permission: PermissionUsersManage},
+		{method: "GET", path: "/api/v1/users/abc/get2fa", matched: true, permission: PermissionBase},
+		{method: "GET", path: "/api/v1/csl/roles/me", matched: true, permission: PermissionBase},
+		{method: "GET", path: "/api/v1/workflowsabc", matched: false},
+		{method: "GET", path: "/api/v2/workflows", matched: false},
+	}
+
+	for _, test := range tests {
+		rule := matchCslPermissionRule(test.method, test.path)
+		if (rule != nil) != test.matched {
+			t.Errorf("%s %s matched %v, expected matched=%t", test.method, test.path, rule, test.matched)
+			continue
+		}
+
+		if rule != nil && rule.Permission != test.permission {
+			t.Errorf("%s %s requires %q, expected %q", test.method, test.path, rule.Permission, test.permission)
+		}
+	}
+}
+
+// Managing roles stays with org admins, so no role can grant itself more
+func TestCslRolesAdminOnly(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{method: "GET", path: "/api/v1/csl/roles"},
+		{method: "POST", path: "/api/v1/csl/roles"},
+		{method: "POST", path: "/api/v1/csl/roles/assign"},
+		{method: "POST", path: "/api/v1/csl/roles/me"},
+	}
+
+	for _, test := range tests {
+		rule := matchCslPermissionRule(test.method, test.path)
+		if rule != nil {
+			t.Errorf("%s %s is allowed with %q, expected it to be admin only", test.method, test.path, rule.Permission)
+		}
+	}
+}
+
+func TestValidateCslRoles(t *testing.T) {
+	tooMany := []CslRole{}
+	for i := 0; i <= MaxCslRoles; i++ {
+		tooMany = append(tooMany, CslRole{Name: strings.Repeat("r", i+1)})
+	}
+
+	tests := []struct {
+		name  string
+		roles []CslRole
+		valid bool
+	}{
+		{name: "defaults", roles: defaultCslRoles, valid: true},
+		{name: "empty", roles: []CslRole{}, valid: true},
+		{name: "without permissions", roles: []CslRole{{Name: "nothing"}}, valid: true},
+		{name: "every permission", roles: []CslRole{{Name: "all", Permissions: []string{PermissionStatsRead, PermissionAuditRead, PermissionWorkflowsRead, PermissionWorkflowsWrite, PermissionWorkflowsExecute, PermissionAppsManage, PermissionCredentialsManage, PermissionUsersManage, PermissionSettingsManage}}}, valid: true},
+		{name: "too many", roles: tooMany},
+		{name: "blank name", roles: []CslRole{{Name: "  "}}},
+		{name: "long name", roles: []CslRole{{Name: strings.Repeat("r", MaxCslRoleNameLength+1)}}},
+		{name: "built in name", roles: []CslRole{{Name: "admin"}}},
+		{name: "duplicate", roles: []CslRole{{Name: "viewer"}, {Name: "viewer"}}},
+		{name: "unknown permission", roles: []CslRole{{Name: "viewer", Permissions: []string{"roles:manage"}}}},
+		{name: "base permission", roles: []CslRole{{Name: "viewer", Permissions: []string{PermissionBase}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateCslRoles(test.roles)
+			if (err == nil) != test.valid {
+				t.Errorf("validateCslRoles returned %v, expected valid=%t", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestCslRolesGetRole(t *testing.T) {
+	roles := CslRoles{}
+	setCslRolesDefaults(&roles)
+	if roles.Roles == nil || roles.Assignments == nil {
+		t.Fatalf("setCslRolesDefaults left nil fields: %+v", roles)
+	}
+
+	roles.Roles = defaultCslRoles
+	role, ok := roles.getRole("workflow editor")
+	if !ok || len(role.Permissions) != 3 {
+		t.Errorf("getRole(workflow editor) = %+v, %t", role, ok)
+	}
+
+	role, ok = roles.getRole("Workflow Editor")
+	if ok || len(role.Permissions) > 0 {
+		t.Errorf("getRole matched a role with different case: %+v", role)
+	}
+}
+
+func TestHasCslDelegatedPermission(t *testing.T) {
+	user := shuffle.User{Id: "user"}
+	user.ActiveOrg.Id = "org"
+
+	grant := shuffle.DelegatedGrant{UserId: "user", OrgId: "org", Role: "stats viewer", Permission: PermissionStatsRead}
+	otherUser := grant
+	otherUser.UserId = "other"
+	otherOrg := grant
+	otherOrg.OrgId = "other"
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		permission string
+		expected   bool
+	}{
+		{name: "granted", ctx: shuffle.WithDelegatedGrant(context.Background(), grant), permission: PermissionStatsRead, expected: true},
+		{name: "other permission", ctx: shuffle.WithDelegatedGrant(context.Background(), grant), permission: PermissionUsersManage},
+		{name: "admin only", ctx: shuffle.WithDelegatedGrant(context.Background(), grant), permission: ""},
+		{name: "no grant", ctx: context.Background(), permission: PermissionStatsRead},
+		{name: "other user", ctx: shuffle.WithDelegatedGrant(context.Background(), otherUser), permission: PermissionStatsRead},
+		{name: "other org", ctx: shuffle.WithDelegatedGrant(context.Background(), otherOrg), permission: PermissionStatsRead},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if hasCslDelegatedPermission(test.ctx, user, test.permission) != test.expected {
+				t.Errorf("hasCslDelegatedPermission(%q) = %t, expected %t", test.permission, !test.expected, test.expected)
+			}
+		})
+	}
+}
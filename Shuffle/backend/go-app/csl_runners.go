@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Runner health of the Orborus instances polling the workflow queue. Every
+// poll is a heartbeat with the stats Orborus sends in the body. Orborus can
+// also send the start times of the workers it deployed since its last poll
+// as X-Container-Start-Ms: <ms>,<ms>,...
+
+const CslRunnerHealthDocument = "runner_health"
+
+const ContainerStartHeader = "X-Container-Start-Ms"
+
+const RunnerHealthFlushMinutes = 1
+
+// Runners without a heartbeat for longer are disconnected, the same as for
+// the running ip of environments
+const RunnerConnectedSeconds = 90
+
+// Runners without a heartbeat for longer are removed
+const RunnerRetentionDays = 7
+
+// Container start latency is averaged over the last starts
+const ContainerStartSamples = 100
+
+// Environments where the oldest queued execution waited longer are degraded
+const QueueLagWarningSeconds = 120
+
+const (
+	EnvironmentHealthy  = "healthy"
+	EnvironmentDegraded = "degraded"
+	EnvironmentOffline  = "offline"
+	EnvironmentUnused   = "unused"
+)
+
+type CslRunner struct {
+	Label            string  `json:"label"`
+	EnvironmentId    string  `json:"environment_id"`
+	Environment      string  `json:"environment"`
+	Ip               string  `json:"ip"`
+	RunMode          string  `json:"run_mode"`
+	Connected        bool    `json:"connected"`
+	FirstSeen        int64   `json:"first_seen"`
+	LastHeartbeat    int64   `json:"last_heartbeat"`
+	Queue            int     `json:"queue"`
+	MaxQueue         int     `json:"max_queue"`
+	WorkerContainers int     `json:"worker_containers"`
+	AppContainers    int     `json:"app_containers"`
+	CPUPercent       float64 `json:"cpu_percent"`
+	MemoryPercent    float64 `json:"memory_percent"`
+	ContainerStartMs int64   `json:"container_start_ms"`
+	ContainerStarts  int64   `json:"container_starts"`
+
+	// Worker starts reported since the last flush
+	pendingStartMs int64
+	pendingStarts  int64
+}
+
+// Runners are keyed by environment id and label
+type CslRunnerHealth struct {
+	Runners map[string]CslRunner `json:"runners"`
+}
+
+type CslEnvironmentHealth struct {
+	Id               string      `json:"id"`
+	Name             string      `json:"name"`
+	RunType          string      `json:"run_type"`
+	Status           string      `json:"status"`
+	LastHeartbeat    int64       `json:"last_heartbeat"`
+	ConnectedRunners int         `json:"connected_runners"`
+	QueueDepth       int         `json:"queue_depth"`
+	QueueLagSeconds  int64       `json:"queue_lag_seconds"`
+	ContainerStartMs int64       `json:"container_start_ms"`
+	Runners          []CslRunner `json:"runners"`
+}
+
+type CslFleetHealth struct {
+	GeneratedAt      int64                  `json:"generated_at"`
+	ConnectedRunners int                    `json:"connected_runners"`
+	Environments     []CslEnvironmentHealth `json:"environments"`
+}
+
+// Heartbeats are kept in memory and flushed to the org documents by the
+// runner_health_flush job, as Orborus polls every few seconds
+var cslPendingRunners = struct {
+	sync.Mutex
+	orgs map[string]map[string]*CslRunner
+}{
+	orgs: map[string]map[string]*CslRunner{},
+}
+
+func getRunnerKey(environmentId, label string) string {
+	return fmt.Sprintf("%s|%s", environmentId, label)
+}
+
+func parseContainerStarts(header string) []int64 {
+	starts := []int64{}
+	for _, item := range strings.Split(header, ",") {
+		startMs, err := strconv.ParseInt(strings.TrimSpace(item), 10, 64)
+		if err != nil || startMs < 0 {
+			continue
+		}
+
+		starts = append(starts, startMs)
+	}
+
+	return starts
+}
+
+// Called for every workflow queue request. The body is put back for the
+// queue handler
+func recordCslRunnerHeartbeat(request *http.Request, env *shuffle.Environment) {
+	if env == nil || len(env.Id) == 0 || len(env.OrgId) == 0 {
+		return
+	}
+
+	stats := shuffle.OrborusStats{}
+	if request.Method == "POST" && request.Body != nil {
+		body, err := ioutil.ReadAll(request.Body)
+		if err == nil {
+			request.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+			json.Unmarshal(body, &stats)
+		}
+	}
+
+	ip := shuffle.GetRequestIp(request)
+	label := request.Header.Get("x-orborus-label")
+	if len(label) == 0 {
+		label = ip
+	}
+
+	runMode := request.Header.Get("X-Orborus-Runmode")
+	if stats.Kubernetes {
+		runMode = "Kubernetes"
+	}
+
+	timeNow := time.Now().Unix()
+
+	cslPendingRunners.Lock()
+	defer cslPendingRunners.Unlock()
+
+	orgRunners, ok := cslPendingRunners.orgs[env.OrgId]
+	if !ok {
+		orgRunners = map[string]*CslRunner{}
+		cslPendingRunners.orgs[env.OrgId] = orgRunners
+	}
+
+	key := getRunnerKey(env.Id, label)
+	runner, ok := orgRunners[key]
+	if !ok {
+		runner = &CslRunner{
+			Label:         label,
+			EnvironmentId: env.Id,
+			FirstSeen:     timeNow,
+		}
+
+		orgRunners[key] = runner
+	}
+
+	runner.Environment = env.Name
+	runner.Ip = ip
+	runner.RunMode = runMode
+	runner.LastHeartbeat = timeNow
+	runner.Queue = stats.Queue
+	runner.MaxQueue = stats.MaxQueue
+	runner.WorkerContainers = stats.WorkerContainers
+	runner.AppContainers = stats.AppContainers
+	runner.CPUPercent = stats.CPUPercent
+	runner.MemoryPercent = stats.MemoryPercent
+
+	for _, startMs := range parseContainerStarts(request.Header.Get(ContainerStartHeader)) {
+		runner.pendingStartMs += startMs
+		runner.pendingStarts += 1
+	}
+}
+
+// Merges a pending heartbeat into the stored runner. The start latency is a
+// running average over the last ContainerStartSamples starts
+func mergeCslRunner(existing CslRunner, found bool, pending CslRunner) CslRunner {
+	merged := pending
+	merged.pendingStartMs = 0
+	merged.pendingStarts = 0
+	if !found {
+		if pending.pendingStarts > 0 {
+			merged.ContainerStartMs = pending.pendingStartMs / pending.pendingStarts
+			merged.ContainerStarts = pending.pendingStarts
+		}
+
+		return merged
+	}
+
+	if existing.FirstSeen > 0 && existing.FirstSeen < merged.FirstSeen {
+		merged.FirstSeen = existing.FirstSeen
+	}
+
+	merged.ContainerStartMs = existing.ContainerStartMs
+	merged.ContainerStarts = existing.ContainerStarts
+	if pending.pendingStarts > 0 {
+		samples := existing.ContainerStarts
+		if samples > ContainerStartSamples {
+			samples = ContainerStartSamples
+		}
+
+		total := existing.ContainerStartMs*samples + pending.pendingStartMs
+		merged.ContainerStartMs = total / (samples + pending.pendingStarts)
+		merged.ContainerStarts += pending.pendingStarts
+	}
+
+	return merged
+}
+
+func getCslRunnerHealth(ctx context.Context, orgId string) CslRunnerHealth {
+	health := CslRunnerHealth{}
+	_, err := getCslDocument(ctx, orgId, CslRunnerHealthDocument, &health)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting runner health for org %s: %s", orgId, err)
+	}
+
+	if health.Runners == nil {
+		health.Runners = map[string]CslRunner{}
+	}
+
+	return health
+}
+
+// Job: writes the heartbeats since the last run to each org and removes
+// runners that stopped polling a week ago
+func runCslRunnerHealthFlushJob(ctx context.Context) {
+	cslPendingRunners.Lock()
+	pending := cslPendingRunners.orgs
+	cslPendingRunners.orgs = map[string]map[string]*CslRunner{}
+	cslPendingRunners.Unlock()
+
+	oldest := time.Now().AddDate(0, 0, -RunnerRetentionDays).Unix()
+	for orgId, orgRunners := range pending {
+		health := CslRunnerHealth{}
+		err := updateCslDocument(ctx, orgId, CslRunnerHealthDocument, &health, func() error {
+			if health.Runners == nil {
+				health.Runners = map[string]CslRunner{}
+			}
+
+			for key, runner := range orgRunners {
+				existing, found := health.Runners[key]
+				health.Runners[key] = mergeCslRunner(existing, found, *runner)
+			}
+
+			for key, runner := range health.Runners {
+				if runner.LastHeartbeat < oldest {
+					delete(health.Runners, key)
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			log.Printf("[ERROR] Failed flushing runner health for org %s: %s", orgId, err)
+		}
+	}
+}
+
+// Stored runners with the heartbeats this replica hasn't flushed yet
+func getCurrentCslRunners(ctx context.Context, orgId string) map[string]CslRunner {
+	runners := getCslRunnerHealth(ctx, orgId).Runners
+
+	cslPendingRunners.Lock()
+	for key, runner := range cslPendingRunners.orgs[orgId] {
+		existing, found := runners[key]
+		runners[key] = mergeCslRunner(existing, found, *runner)
+	}
+	cslPendingRunners.Unlock()
+
+	return runners
+}
+
+func getEnvironmentHealth(ctx context.Context, environment shuffle.Environment, runners map[string]CslRunner) CslEnvironmentHealth {
+	timeNow := time.Now().Unix()
+	health := CslEnvironmentHealth{
+		Id:            environment.Id,
+		Name:          environment.Name,
+		RunType:       environment.RunType,
+		LastHeartbeat: environment.Checkin,
+		Runners:       []CslRunner{},
+	}
+
+	startTotal := int64(0)
+	startSamples := int64(0)
+	for _, runner := range runners {
+		if runner.EnvironmentId != environment.Id {
+			continue
+		}
+
+		runner.Connected = timeNow-runner.LastHeartbeat <= RunnerConnectedSeconds
+		if runner.Connected {
+			health.ConnectedRunners += 1
+		}
+
+		if runner.LastHeartbeat > health.LastHeartbeat {
+			health.LastHeartbeat = runner.LastHeartbeat
+		}
+
+		if runner.ContainerStarts > 0 {
+			samples := runner.ContainerStarts
+			if samples > ContainerStartSamples {
+				samples = ContainerStartSamples
+			}
+
+			startTotal += runner.ContainerStartMs * samples
+			startSamples += samples
+		}
+
+		health.Runners = append(health.Runners, runner)
+	}
+
+	sort.Slice(health.Runners, func(i, j int) bool {
+		return health.Runners[i].Label < health.Runners[j].Label
+	})
+
+	if startSamples > 0 {
+		health.ContainerStartMs = startTotal / startSamples
+	}
+
+	backlog, err := getEnvironmentBacklog(ctx, environment)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting queue of environment %s for runner health: %s", environment.Name, err)
+	} else {
+		health.QueueDepth = backlog.Depth
+		health.QueueLagSeconds = backlog.OldestAgeSeconds
+	}
+
+	if health.LastHeartbeat == 0 {
+		health.Status = EnvironmentUnused
+	} else if health.ConnectedRunners == 0 && timeNow-health.LastHeartbeat > RunnerConnectedSeconds {
+		health.Status = EnvironmentOffline
+	} else if health.QueueLagSeconds > QueueLagWarningSeconds {
+		health.Status = EnvironmentDegraded
+	} else {
+		health.Status = EnvironmentHealthy
+	}
+
+	return health
+}
+
+/*
+Dashboard:
+Returns the health of the Orborus runners of every environment in the current
+organization. Runners are connected when they polled the queue within 90
+seconds. queue_lag_seconds is how long the oldest queued execution has waited,
+and container_start_ms the average time to start a worker. Environments are
+offline without connected runners, and degraded when executions wait longer
+than two minutes.
+
+	{
+	    "success": true,
+	    "data": {
+	        "generated_at": 1718000000,
+	        "connected_runners": 1,
+	        "environments": [
+	            {
+	                "id": "a5d3...",
+	                "name": "Shuffle",
+	                "run_type": "docker",
+	                "status": "healthy",
+	                "last_heartbeat": 1717999998,
+	                "connected_runners": 1,
+	                "queue_depth": 2,
+	                "queue_lag_seconds": 4,
+	                "container_start_ms": 850,
+	                "runners": [
+	                    {
+	                        "label": "orborus-1",
+	                        "environment_id": "a5d3...",
+	                        "environment": "Shuffle",
+	                        "ip": "10.0.0.5",
+	                        "run_mode": "Default",
+	                        "connected": true,
+	                        "first_seen": 1717000000,
+	                        "last_heartbeat": 1717999998,
+	                        "queue": 1,
+	                        "max_queue": 7,
+	                        "worker_containers": 1,
+	                        "app_containers": 3,
+	                        "cpu_percent": 12.5,
+	                        "memory_percent": 0.4,
+	                        "container_start_ms": 850,
+	                        "container_starts": 1203
+	                    }
+	                ]
+	            }
+	        ]
+	    }
+	}
+*/
+func cslFleetHealth(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	environments, err := shuffle.GetEnvironments(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	runners := getCurrentCslRunners(ctx, user.ActiveOrg.Id)
+	fleet := CslFleetHealth{
+		GeneratedAt:  time.Now().Unix(),
+		Environments: []CslEnvironmentHealth{},
+	}
+
+	for _, environment := range environments {
+		if environment.Archived || environment.Type == "cloud" {
+			continue
+		}
+
+		health := getEnvironmentHealth(ctx, environment, runners)
+		fleet.ConnectedRunners += health.ConnectedRunners
+		fleet.Environments = append(fleet.Environments, health)
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    fleet,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslFleetHealth")
+}
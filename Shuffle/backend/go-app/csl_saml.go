@@ -0,0 +1,858 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	xrv "github.com/mattermost/xml-roundtrip-validator"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslSamlSettingsDocument = "saml"
+
+// Minutes a login can take between the redirect to the IdP and its response,
+// and how long used assertions are remembered
+const SamlRequestExpiration = 10
+
+// Seconds of clock difference allowed with the IdP
+const SamlClockSkew = 180
+
+const (
+	samlProtocolNamespace  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlAssertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlStatusSuccess      = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlBearer             = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+)
+
+// SAML login options. The IdP sign-on url and signing certificate are
+// configured in the orgs SSO config. Orgs without SAML enabled here keep
+// using the SSO login that matches orgs by certificate
+type CslSamlSettings struct {
+	Enabled     bool   `json:"enabled"`
+	IdpEntityId string `json:"idp_entity_id"`
+
+	// Defaults to the assertion consumer service url
+	SpEntityId string `json:"sp_entity_id"`
+
+	// Defaults to the NameID of the subject
+	UsernameAttribute string `json:"username_attribute"`
+
+	// Creates users that don't exist on the instance in the org. Off by default
+	JitProvisioning bool `json:"jit_provisioning"`
+
+	// Allows logins started at the IdP, with the org id as RelayState
+	AllowIdpInitiated bool `json:"allow_idp_initiated"`
+}
+
+type CslSamlState struct {
+	OrgId     string `json:"org_id"`
+	RequestId string `json:"request_id"`
+}
+
+// What a valid SAML response says about the user
+type cslSamlLoginResult struct {
+	AssertionId string
+	Username    string
+	Attributes  []cslSamlAttribute
+}
+
+// What a SAML response has to match to be accepted
+type cslSamlExpectations struct {
+	IdpEntityId string
+	SpEntityId  string
+	AcsUrl      string
+	RequestId   string
+}
+
+func getCslSamlSettings(ctx context.Context, orgId string) CslSamlSettings {
+	settings := CslSamlSettings{}
+	_, err := getCslDocument(ctx, orgId, CslSamlSettingsDocument, &settings)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting SAML settings for org %s: %s", orgId, err)
+	}
+
+	return settings
+}
+
+// The existing SSO login url, so IdPs don't have to be reconfigured
+func getSamlAcsUrl(request *http.Request) string {
+	return fmt.Sprintf("%s/api/v1/login_sso", getSsoBaseUrl(request))
+}
+
+func getSamlSpEntityId(settings CslSamlSettings, request *http.Request) string {
+	if len(settings.SpEntityId) > 0 {
+		return settings.SpEntityId
+	}
+
+	return getSamlAcsUrl(request)
+}
+
+// Accepts certificates with or without PEM armor, like the SSO config does
+func parseSamlCertificate(value string) (*x509.Certificate, error) {
+	value = strings.Replace(value, "&#13;", "", -1)
+	value = strings.Replace(value, "-----BEGIN CERTIFICATE-----", "", -1)
+	value = strings.Replace(value, "-----END CERTIFICATE-----", "", -1)
+
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), ""))
+	if err != nil {
+		return nil, errors.New("certificate is not valid base64")
+	}
+
+	return x509.ParseCertificate(data)
+}
+
+func parseSamlTime(value string) (time.Time, error) {
+	return time.Parse(time.RFC3339, strings.TrimSpace(value))
+}
+
+// Parses a SAML document. Documents that change in an encoding/xml round
+// trip or have a DTD are refused, as the signature validation and the code
+// reading them could see different content
+func parseSamlDocument(data []byte) (*etree.Element, error) {
+	err := xrv.Validate(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// etree doesn't check that elements are closed in order
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	document := etree.NewDocument()
+	err = document.ReadFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, token := range document.Child {
+		if _, ok := token.(*etree.Directive); ok {
+			return nil, errors.New("SAML documents with a DTD are not allowed")
+		}
+	}
+
+	if document.Root() == nil {
+		return nil, errors.New("SAML document has no root element")
+	}
+
+	return document.Root(), nil
+}
+
+// Child elements in the namespace. etree only keeps the prefix of elements,
+// which the IdP is free to choose
+func getSamlChildren(element *etree.Element, namespace, tag string) []*etree.Element {
+	children := []*etree.Element{}
+	if element == nil {
+		return children
+	}
+
+	for _, child := range element.ChildElements() {
+		if child.Tag == tag && child.NamespaceURI() == namespace {
+			children = append(children, child)
+		}
+	}
+
+	return children
+}
+
+// The only child element in the namespace, nil if there are none or several
+func getSamlChild(element *etree.Element, namespace, tag string) *etree.Element {
+	children := getSamlChildren(element, namespace, tag)
+	if len(children) != 1 {
+		return nil
+	}
+
+	return children[0]
+}
+
+// Attribute without a prefix. etree matches any prefix when none is given
+func getSamlAttribute(element *etree.Element, key string) (string, bool) {
+	if element == nil {
+		return "", false
+	}
+
+	for _, attr := range element.Attr {
+		if len(attr.Space) == 0 && attr.Key == key {
+			return attr.Value, true
+		}
+	}
+
+	return "", false
+}
+
+func getSamlText(element *etree.Element) string {
+	if element == nil {
+		return ""
+	}
+
+	return strings.TrimSpace(element.Text())
+}
+
+func getSamlTreeAttributes(assertion *etree.Element) []cslSamlAttribute {
+	attributes := []cslSamlAttribute{}
+	for _, statement := range getSamlChildren(assertion, samlAssertionNamespace, "AttributeStatement") {
+		for _, attribute := range getSamlChildren(statement, samlAssertionNamespace, "Attribute") {
+			name, _ := getSamlAttribute(attribute, "Name")
+			samlAttribute := cslSamlAttribute{Name: name}
+			for _, value := range getSamlChildren(attribute, samlAssertionNamespace, "AttributeValue") {
+				samlAttribute.Values = append(samlAttribute.Values, getSamlText(value))
+			}
+
+			attributes = append(attributes, samlAttribute)
+		}
+	}
+
+	return attributes
+}
+
+// Verifies the enveloped signature of the element with goxmldsig and returns
+// the signed content. Namespaces declared on its ancestors are moved onto the
+// element first, so it canonicalizes the same as when the IdP signed it
+func verifySamlSignature(element *etree.Element, certificate *x509.Certificate, now time.Time) (*etree.Element, error) {
+	if getSamlChild(element, dsig.Namespace, dsig.SignatureTag) == nil {
+		return nil, errors.New(fmt.Sprintf("%s must have one signature", element.Tag))
+	}
+
+	namespaces, err := etreeutils.NSBuildParentContext(element)
+	if err == nil {
+		namespaces, err = namespaces.SubContext(element)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	detached, err := etreeutils.NSDetatch(namespaces, element)
+	if err != nil {
+		return nil, err
+	}
+
+	validation := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{certificate},
+	})
+
+	// Checks the certificate validity at the same time as the assertion
+	validation.Clock = dsig.NewFakeClockAt(now)
+	return validation.Validate(detached)
+}
+
+// Checks a bearer subject confirmation is meant for this login
+func checkSamlSubjectConfirmation(subject *etree.Element, expected cslSamlExpectations, now time.Time) error {
+	for _, confirmation := range getSamlChildren(subject, samlAssertionNamespace, "SubjectConfirmation") {
+		if method, _ := getSamlAttribute(confirmation, "Method"); method != samlBearer {
+			continue
+		}
+
+		data := getSamlChild(confirmation, samlAssertionNamespace, "SubjectConfirmationData")
+		if recipient, ok := getSamlAttribute(data, "Recipient"); ok && recipient != expected.AcsUrl {
+			continue
+		}
+
+		if inResponseTo, ok := getSamlAttribute(data, "InResponseTo"); ok && inResponseTo != expected.RequestId {
+			continue
+		}
+
+		notOnOrAfter, ok := getSamlAttribute(data, "NotOnOrAfter")
+		if !ok {
+			continue
+		}
+
+		expiration, err := parseSamlTime(notOnOrAfter)
+		if err != nil || !now.Add(-SamlClockSkew*time.Second).Before(expiration) {
+			continue
+		}
+
+		return nil
+	}
+
+	return errors.New("assertion has no valid bearer subject confirmation")
+}
+
+func checkSamlConditions(assertion *etree.Element, expected cslSamlExpectations, now time.Time) error {
+	conditions := getSamlChild(assertion, samlAssertionNamespace, "Conditions")
+	if conditions == nil {
+		return nil
+	}
+
+	if notBefore, ok := getSamlAttribute(conditions, "NotBefore"); ok {
+		start, err := parseSamlTime(notBefore)
+		if err != nil || now.Add(SamlClockSkew*time.Second).Before(start) {
+			return errors.New("assertion is not valid yet")
+		}
+	}
+
+	if notOnOrAfter, ok := getSamlAttribute(conditions, "NotOnOrAfter"); ok {
+		expiration, err := parseSamlTime(notOnOrAfter)
+		if err != nil || !now.Add(-SamlClockSkew*time.Second).Before(expiration) {
+			return errors.New("assertion has expired")
+		}
+	}
+
+	for _, restriction := range getSamlChildren(conditions, samlAssertionNamespace, "AudienceRestriction") {
+		audiences := []string{}
+		for _, audience := range getSamlChildren(restriction, samlAssertionNamespace, "Audience") {
+			audiences = append(audiences, getSamlText(audience))
+		}
+
+		if !shuffle.ArrayContains(audiences, expected.SpEntityId) {
+			return errors.New(fmt.Sprintf("assertion is not meant for %s", expected.SpEntityId))
+		}
+	}
+
+	return nil
+}
+
+func hasDuplicateSamlIds(element *etree.Element, ids map[string]bool) bool {
+	if id, ok := getSamlAttribute(element, "ID"); ok {
+		if ids[id] {
+			return true
+		}
+
+		ids[id] = true
+	}
+
+	for _, child := range element.ChildElements() {
+		if hasDuplicateSamlIds(child, ids) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validates a SAML response signed by the certificate and returns its
+// assertion. The assertion is read from the verified content only, so
+// nothing unsigned can be slipped in next to it
+func validateSamlResponse(response *etree.Element, certificate *x509.Certificate, expected cslSamlExpectations, now time.Time) (*etree.Element, error) {
+	if response.Tag != "Response" || response.NamespaceURI() != samlProtocolNamespace {
+		return nil, errors.New("not a SAML response")
+	}
+
+	if hasDuplicateSamlIds(response, map[string]bool{}) {
+		return nil, errors.New("response has duplicate IDs")
+	}
+
+	statusCode, _ := getSamlAttribute(getSamlChild(getSamlChild(response, samlProtocolNamespace, "Status"), samlProtocolNamespace, "StatusCode"), "Value")
+	if statusCode != samlStatusSuccess {
+		return nil, errors.New(fmt.Sprintf("login failed at the IdP with status %s", statusCode))
+	}
+
+	if len(getSamlChildren(response, samlAssertionNamespace, "EncryptedAssertion")) > 0 {
+		return nil, errors.New("encrypted assertions are not supported")
+	}
+
+	// Either signature is enough, but one that's there has to be valid
+	responseSigned := len(getSamlChildren(response, dsig.Namespace, dsig.SignatureTag)) > 0
+	if responseSigned {
+		verified, err := verifySamlSignature(response, certificate, now)
+		if err != nil {
+			return nil, err
+		}
+
+		response = verified
+	}
+
+	assertion := getSamlChild(response, samlAssertionNamespace, "Assertion")
+	if assertion == nil {
+		return nil, errors.New("response must have one assertion")
+	}
+
+	if len(getSamlChildren(assertion, dsig.Namespace, dsig.SignatureTag)) > 0 {
+		verified, err := verifySamlSignature(assertion, certificate, now)
+		if err != nil {
+			return nil, err
+		}
+
+		assertion = verified
+	} else if !responseSigned {
+		return nil, errors.New("response is not signed")
+	}
+
+	if destination, ok := getSamlAttribute(response, "Destination"); ok && destination != expected.AcsUrl {
+		return nil, errors.New(fmt.Sprintf("response is meant for %s", destination))
+	}
+
+	if inResponseTo, _ := getSamlAttribute(response, "InResponseTo"); inResponseTo != expected.RequestId {
+		return nil, errors.New("response doesn't answer the login request")
+	}
+
+	issuer := getSamlText(getSamlChild(assertion, samlAssertionNamespace, "Issuer"))
+	if issuer != expected.IdpEntityId {
+		return nil, errors.New(fmt.Sprintf("assertion is issued by %s", issuer))
+	}
+
+	err := checkSamlConditions(assertion, expected, now)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := getSamlChild(assertion, samlAssertionNamespace, "Subject")
+	if subject == nil {
+		return nil, errors.New("assertion has no subject")
+	}
+
+	err = checkSamlSubjectConfirmation(subject, expected, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return assertion, nil
+}
+
+// Finds the username and attributes in a validated assertion
+func getSamlLoginResult(assertion *etree.Element, settings CslSamlSettings) (cslSamlLoginResult, error) {
+	result := cslSamlLoginResult{
+		Attributes: getSamlTreeAttributes(assertion),
+	}
+
+	result.AssertionId, _ = getSamlAttribute(assertion, "ID")
+
+	username := getSamlText(getSamlChild(getSamlChild(assertion, samlAssertionNamespace, "Subject"), samlAssertionNamespace, "NameID"))
+	if len(settings.UsernameAttribute) > 0 {
+		username = ""
+		for _, attribute := range result.Attributes {
+			if strings.EqualFold(attribute.Name, settings.UsernameAttribute) && len(attribute.Values) > 0 {
+				username = attribute.Values[0]
+				break
+			}
+		}
+	}
+
+	result.Username = strings.ToLower(strings.TrimSpace(username))
+	if len(result.Username) == 0 {
+		return result, errors.New("assertion has no username")
+	}
+
+	return result, nil
+}
+
+// Finds the org a SAML response is for: the org of the login request it
+// answers, or the org in RelayState when it allows logins started at the
+// IdP. Returns nil for responses to the certificate matched SSO login
+func getSamlLoginOrg(ctx context.Context, response *etree.Element, relayState string) (*shuffle.Org, string) {
+	inResponseTo, _ := getSamlAttribute(response, "InResponseTo")
+	if len(inResponseTo) > 0 {
+		stateKey := fmt.Sprintf("csl_saml_request_%s", inResponseTo)
+		cache, err := shuffle.GetCache(ctx, stateKey)
+		if err != nil {
+			return nil, ""
+		}
+
+		// Requests can only be answered once
+		shuffle.DeleteCache(ctx, stateKey)
+
+		state := CslSamlState{}
+		cacheData, ok := cache.([]uint8)
+		if !ok || json.Unmarshal([]byte(cacheData), &state) != nil || state.RequestId != inResponseTo {
+			return nil, ""
+		}
+
+		org, err := shuffle.GetOrg(ctx, state.OrgId)
+		if err != nil {
+			return nil, ""
+		}
+
+		return org, state.RequestId
+	}
+
+	if len(relayState) == 0 {
+		return nil, ""
+	}
+
+	org, err := shuffle.GetOrg(ctx, relayState)
+	if err != nil {
+		return nil, ""
+	}
+
+	settings := getCslSamlSettings(ctx, org.Id)
+	if !settings.Enabled || !settings.AllowIdpInitiated {
+		return nil, ""
+	}
+
+	return org, ""
+}
+
+// Finds the user of a SAML login. Only members of the org can log in, as the
+// orgs IdP has no say over users of other orgs. With just-in-time
+// provisioning on, usernames that don't exist anywhere are created in the org
+func getSamlLoginUser(ctx context.Context, org *shuffle.Org, settings CslSamlSettings, username string) (*shuffle.User, error) {
+	user, err := findSsoUser(ctx, username)
+	if err != nil {
+		if !settings.JitProvisioning {
+			return nil, errors.New("user not found")
+		}
+
+		user, _, err = getOrCreateProvisionedUser(ctx, org, username, "SSO")
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("[AUDIT] Created user %s (%s) in org %s through SAML", user.Username, user.Id, org.Id)
+		recordCslActivity(ctx, org.Id, ActivityTypeAdmin, "user_provisioned", fmt.Sprintf("%s was created through SAML", user.Username), "SAML", user.Id)
+
+		// The org now has the user in its member list
+		org, err = shuffle.GetOrg(ctx, org.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !user.Active {
+		return nil, errors.New("user is deactivated")
+	}
+
+	err = setCslActiveOrg(user, org)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// AuthnRequest for the HTTP-Redirect binding
+func createSamlAuthnRequest(requestId, destination, acsUrl, spEntityId string, now time.Time) (string, error) {
+	escape := func(value string) string {
+		buffer := &bytes.Buffer{}
+		xml.EscapeText(buffer, []byte(value))
+		return buffer.String()
+	}
+
+	authnRequest := fmt.Sprintf(`<samlp:AuthnRequest xmlns:samlp="%s" xmlns:saml="%s" ID="%s" Version="2.0" IssueInstant="%s" Destination="%s" ProtocolBinding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" AssertionConsumerServiceURL="%s"><saml:Issuer>%s</saml:Issuer><samlp:NameIDPolicy AllowCreate="true"/></samlp:AuthnRequest>`,
+		samlProtocolNamespace,
+		samlAssertionNamespace,
+		escape(requestId),
+		now.UTC().Format(time.RFC3339),
+		escape(destination),
+		escape(acsUrl),
+		escape(spEntityId),
+	)
+
+	buffer := &bytes.Buffer{}
+	writer, err := flate.NewWriter(buffer, flate.DefaultCompression)
+	if err != nil {
+		return "", err
+	}
+
+	writer.Write([]byte(authnRequest))
+	writer.Close()
+	return base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
+}
+
+/*
+SSO:
+Starts a SAML login for an org. Requires ?org_id=<org_id>. The IdP sign-on url
+and signing certificate are configured in the orgs SSO config, and the IdP
+has to send its response to <base url>/api/v1/login_sso.
+*/
+func cslSamlLoginStart(resp http.ResponseWriter, request *http.Request) {
+	ctx := shuffle.GetContext(request)
+
+	org, err := shuffle.GetOrg(ctx, request.URL.Query().Get("org_id"))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("org not found")))
+		return
+	}
+
+	settings := getCslSamlSettings(ctx, org.Id)
+	if !settings.Enabled || len(org.SSOConfig.SSOEntrypoint) == 0 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("SAML is not configured for this org")))
+		return
+	}
+
+	// IDs must not start with a digit
+	state := CslSamlState{
+		OrgId:     org.Id,
+		RequestId: "_" + uuid.NewV4().String(),
+	}
+
+	samlRequest, err := createSamlAuthnRequest(state.RequestId, org.SSOConfig.SSOEntrypoint, getSamlAcsUrl(request), getSamlSpEntityId(settings, request), time.Now())
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	stateData, err := json.Marshal(state)
+	if err == nil {
+		err = shuffle.SetCache(ctx, fmt.Sprintf("csl_saml_request_%s", state.RequestId), stateData, SamlRequestExpiration)
+	}
+
+	if err != nil {
+		log.Printf("[ERROR] Failed storing SAML request for org %s: %s", org.Id, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	query := url.Values{}
+	query.Set("SAMLRequest", samlRequest)
+	query.Set("RelayState", org.Id)
+
+	separator := "?"
+	if strings.Contains(org.SSOConfig.SSOEntrypoint, "?") {
+		separator = "&"
+	}
+
+	http.Redirect(resp, request, org.SSOConfig.SSOEntrypoint+separator+query.Encode(), http.StatusFound)
+}
+
+// Assertion consumer service for SAML logins. Responses for orgs with SAML
+// enabled are verified against the orgs certificate and IdP. Only members of
+// the org, or users just-in-time provisioned into it, are logged in before the
+// group mapping is applied. Other responses go to the certificate matched SSO
+// login
+func cslSamlLogin(resp http.ResponseWriter, request *http.Request) {
+	if shuffle.HandleCors(resp, request) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	request.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+
+	values, err := url.ParseQuery(string(body))
+	samlResponse := strings.Replace(values.Get("SAMLResponse"), " ", "+", -1)
+	if err != nil || len(samlResponse) == 0 {
+		cslLegacySamlLogin(resp, request, body, nil)
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("SAMLResponse is not valid base64")))
+		return
+	}
+
+	response, err := parseSamlDocument(data)
+	if err != nil {
+		log.Printf("[WARNING] Failed parsing SAML response: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("SAMLResponse is not valid XML")))
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	org, requestId := getSamlLoginOrg(ctx, response, values.Get("RelayState"))
+	if org == nil {
+		cslLegacySamlLogin(resp, request, body, response)
+		return
+	}
+
+	settings := getCslSamlSettings(ctx, org.Id)
+	certificate, err := parseSamlCertificate(org.SSOConfig.SSOCertificate)
+	if !settings.Enabled || err != nil {
+		log.Printf("[WARNING] SAML login for org %s without SAML configured: %v", org.Id, err)
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("SAML is not configured for this org")))
+		return
+	}
+
+	expected := cslSamlExpectations{
+		IdpEntityId: settings.IdpEntityId,
+		SpEntityId:  getSamlSpEntityId(settings, request),
+		AcsUrl:      getSamlAcsUrl(request),
+		RequestId:   requestId,
+	}
+
+	assertion, err := validateSamlResponse(response, certificate, expected, time.Now())
+	if err != nil {
+		log.Printf("[WARNING] Invalid SAML response for org %s: %s", org.Id, err)
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("SAML response is not valid")))
+		return
+	}
+
+	result, err := getSamlLoginResult(assertion, settings)
+	if err != nil {
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	// Assertions can only be used once
+	assertionKey := fmt.Sprintf("csl_saml_assertion_%s", result.AssertionId)
+	if _, err := shuffle.GetCache(ctx, assertionKey); err == nil {
+		log.Printf("[WARNING] Replayed SAML assertion %s for org %s", result.AssertionId, org.Id)
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("SAML response has already been used")))
+		return
+	}
+
+	shuffle.SetCache(ctx, assertionKey, []byte(result.Username), SamlRequestExpiration)
+
+	user, err := getSamlLoginUser(ctx, org, settings, result.Username)
+	if err != nil {
+		log.Printf("[WARNING] SAML login of %s to org %s failed: %s", result.Username, org.Id, err)
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	sessionToken := uuid.NewV4().String()
+	setSessionCookies(resp, sessionToken, time.Now().Add(3600*time.Second))
+
+	err = shuffle.SetSession(ctx, *user, sessionToken)
+	if err != nil {
+		log.Printf("[WARNING] Error creating session for SAML user %s: %s", result.Username, err)
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("failed setting session")))
+		return
+	}
+
+	user.Session = sessionToken
+	user.LoginInfo = append(user.LoginInfo, shuffle.LoginInfo{
+		IP:        shuffle.GetRequestIp(request),
+		Timestamp: time.Now().Unix(),
+	})
+
+	err = shuffle.SetUser(ctx, user, false)
+	if err != nil {
+		log.Printf("[WARNING] Failed updating SAML user %s when setting session: %s", result.Username, err)
+		resp.WriteHeader(401)
+		resp.Write(createCslErrorResponse(errors.New("failed updating user")))
+		return
+	}
+
+	mapping := getCslSsoRoleMapping(ctx, org.Id)
+	err = applySsoRoleMapping(ctx, org.Id, user, mapping, "SAML", getSamlGroups(result.Attributes, mapping.GroupAttribute))
+	if err != nil {
+		log.Printf("[ERROR] Failed applying SAML role mapping for %s: %s", result.Username, err)
+	}
+
+	log.Printf("[AUDIT] User %s (%s) logged in to org %s with SAML", user.Username, user.Id, org.Id)
+	recordCslGeoEvent(org.Id, GeoSourceLogin, shuffle.GetRequestIp(request), user.Id, user.Username, http.StatusSeeOther)
+	http.Redirect(resp, request, getOidcFrontendUrl(), http.StatusSeeOther)
+}
+
+/*
+SSO:
+Returns the SAML login options for the current organization. Requires org admin.
+
+	{
+	    "success": true,
+	    "data": {
+	        "enabled": true,
+	        "idp_entity_id": "https://idp.example.com/saml",
+	        "sp_entity_id": "",
+	        "username_attribute": "",
+	        "jit_provisioning": false,
+	        "allow_idp_initiated": false
+	    }
+	}
+*/
+func cslGetSamlSettings(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslSamlSettings(ctx, user.ActiveOrg.Id),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetSamlSettings")
+}
+
+/*
+SSO:
+Updates the SAML login options. Requires org admin. Body uses the same format
+as the data field returned from GET. Enabling SAML requires the IdP entity id,
+and the sign-on url and signing certificate in the orgs SSO config.
+*/
+func cslSetSamlSettings(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	settings := getCslSamlSettings(ctx, user.ActiveOrg.Id)
+	err = json.Unmarshal(body, &settings)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling SAML settings: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	settings.IdpEntityId = strings.TrimSpace(settings.IdpEntityId)
+	settings.SpEntityId = strings.TrimSpace(settings.SpEntityId)
+	settings.UsernameAttribute = strings.TrimSpace(settings.UsernameAttribute)
+	if settings.Enabled {
+		org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+		if err != nil {
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		_, certificateErr := parseSamlCertificate(org.SSOConfig.SSOCertificate)
+		if len(settings.IdpEntityId) == 0 || len(org.SSOConfig.SSOEntrypoint) == 0 || certificateErr != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New("enabling SAML requires idp_entity_id, and a sign-on url and valid certificate in the SSO config")))
+			return
+		}
+	}
+
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslSamlSettingsDocument, settings)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated SAML settings for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "saml_updated", "SAML settings were updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    settings,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetSamlSettings")
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+func newTestSamlSigner(t *testing.T, now time.Time) (*dsig.SigningContext, *x509.Certificate) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed generating key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+	}
+
+	data, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed creating certificate: %s", err)
+	}
+
+	certificate, err := x509.ParseCertificate(data)
+	if err != nil {
+		t.Fatalf("failed parsing certificate: %s", err)
+	}
+
+	signer, err := dsig.NewSigningContext(key, [][]byte{data})
+	if err != nil {
+		t.Fatalf("failed creating signer: %s", err)
+	}
+
+	signer.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	return signer, certificate
+}
+
+// Signs the element with the ID in the document with an enveloped signature
+func signTestSaml(t *testing.T, signer *dsig.SigningContext, document, id string) string {
+	t.Helper()
+
+	parsed := etree.NewDocument()
+	err := parsed.ReadFromString(document)
+	if err != nil {
+		t.Fatalf("failed parsing document: %s", err)
+	}
+
+	element := parsed.FindElement(fmt.Sprintf("//[@ID='%s']", id))
+	if element == nil {
+		t.Fatalf("no element with ID %s", id)
+	}
+
+	signed, err := signer.SignEnveloped(element)
+	if err != nil {
+		t.Fatalf("failed signing: %s", err)
+	}
+
+	if parent := element.Parent(); parent != nil && parent != &parsed.Element {
+		parent.InsertChildAt(element.Index(), signed)
+		parent.RemoveChild(element)
+	} else {
+		parsed.SetRoot(signed)
+	}
+
+	result, err := parsed.WriteToString()
+	if err != nil {
+		t.Fatalf("failed writing document: %s", err)
+	}
+
+	return result
+}
+
+func TestParseSamlDocument(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		valid    bool
+	}{
+		{name: "valid", document: `<?xml version="1.0"?><root><child/></root>`, valid: true},
+		{name: "doctype", document: `<!DOCTYPE root [<!ENTITY x "y">]><root>&x;</root>`},
+		{name: "mismatched end", document: `<root><child></root></child>`},
+		{name: "unclosed", document: `<root><child/>`},
+		{name: "no root", document: `<?xml version="1.0"?>`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseSamlDocument([]byte(test.document))
+			if (err == nil) != test.valid {
+				t.Errorf("got error %v, expected valid %t", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestValidateSamlResponse(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	signer, certificate := newTestSamlSigner(t, now)
+	otherSigner, _ := newTestSamlSigner(t, now)
+
+	expected := cslSamlExpectations{
+		IdpEntityId: "https://idp.example.com",
+		SpEntityId:  "https://shuffle.example.com",
+		AcsUrl:      "https://shuffle.example.com/api/v1/login_sso",
+		RequestId:   "_request",
+	}
+
+	assertion := `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_assertion" Version="2.0" IssueInstant="2026-01-01T12:00:00Z"><saml:Issuer>https://idp.example.com</saml:Issuer><saml:Subject><saml:NameID>User@Example.com</saml:NameID><saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer"><saml:SubjectConfirmationData InResponseTo="_request" NotOnOrAfter="2026-01-01T12:05:00Z" Recipient="https://shuffle.example.com/api/v1/login_sso"/></saml:SubjectConfirmation></saml:Subject><saml:Conditions NotBefore="2026-01-01T11:55:00Z" NotOnOrAfter="2026-01-01T12:05:00Z"><saml:AudienceRestriction><saml:Audience>https://shuffle.example.com</saml:Audience></saml:AudienceRestriction></saml:Conditions><saml:AttributeStatement><saml:Attribute Name="groups"><saml:AttributeValue>soc</saml:AttributeValue><saml:AttributeValue>admins</saml:AttributeValue></saml:Attribute></saml:AttributeStatement></saml:Assertion>`
+	response := `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="_response" Version="2.0" InResponseTo="_request" Destination="https://shuffle.example.com/api/v1/login_sso"><samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>%s</samlp:Response>`
+
+	signedAssertion := signTestSaml(t, signer, assertion, "_assertion")
+	signed := fmt.Sprintf(response, signedAssertion)
+
+	// IdPs often declare the assertion namespace on the response only
+	inheritedNamespace := strings.Replace(strings.Replace(signed, ` xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion"`, "", 1), `<samlp:Response `, `<samlp:Response xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" `, 1)
+
+	tests := []struct {
+		name      string
+		response  string
+		now       time.Time
+		requestId string
+		valid     bool
+	}{
+		{name: "valid", response: signed, valid: true},
+		{name: "signed response", response: signTestSaml(t, signer, fmt.Sprintf(response, assertion), "_response"), valid: true},
+		{name: "inherited namespace", response: inheritedNamespace, valid: true},
+		{name: "unsigned", response: fmt.Sprintf(response, assertion)},
+		{name: "other certificate", response: fmt.Sprintf(response, signTestSaml(t, otherSigner, assertion, "_assertion"))},
+		{name: "changed username", response: strings.Replace(signed, "User@Example.com", "admin@example.com", 1)},
+		{name: "second assertion", response: fmt.Sprintf(response, signedAssertion+strings.Replace(signedAssertion, `ID="_assertion"`, `ID="_other"`, 1))},
+		{name: "duplicate IDs", response: fmt.Sprintf(response, signedAssertion+`<samlp:Extensions ID="_assertion"/>`)},
+		{name: "failed status", response: strings.Replace(signed, "status:Success", "status:Requester", 1)},
+		{name: "wrong destination", response: strings.Replace(signed, `Destination="https://shuffle.example.com/api/v1/login_sso"`, `Destination="https://other.example.com"`, 1)},
+		{name: "other request", response: signed, requestId: "_other"},
+		{name: "expired", response: signed, now: now.Add(10 * time.Minute)},
+		{name: "not valid yet", response: signed, now: now.Add(-10 * time.Minute)},
+		{name: "within clock skew", response: signed, now: now.Add(7 * time.Minute), valid: true},
+		{name: "expired certificate", response: signed, now: now.Add(2 * time.Hour)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root, err := parseSamlDocument([]byte(test.response))
+			if err != nil {
+				t.Fatalf("failed parsing: %s", err)
+			}
+
+			testNow := now
+			if !test.now.IsZero() {
+				testNow = test.now
+			}
+
+			testExpected := expected
+			if len(test.requestId) > 0 {
+				testExpected.RequestId = test.requestId
+			}
+
+			result, err := validateSamlResponse(root, certificate, testExpected, testNow)
+			if (err == nil) != test.valid {
+				t.Fatalf("got error %v, expected valid %t", err, test.valid)
+			}
+
+			if !test.valid {
+				return
+			}
+
+			login, err := getSamlLoginResult(result, CslSamlSettings{})
+			if err != nil || login.Username != "user@example.com" || login.AssertionId != "_assertion" {
+				t.Errorf("got %+v (%v), expected user@example.com from _assertion", login, err)
+			}
+
+			groups := getSamlGroups(login.Attributes, "")
+			if len(groups) != 2 || groups[0] != "soc" || groups[1] != "admins" {
+				t.Errorf("got groups %v, expected [soc admins]", groups)
+			}
+		})
+	}
+}
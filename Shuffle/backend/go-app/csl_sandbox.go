@@ -0,0 +1,930 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslSandboxDocument = "sandbox"
+const CslSandboxSubmissionsDocument = "sandbox_submissions"
+
+const SandboxPollMinutes = 1
+
+// Max submissions kept per org. The oldest finished are dropped first
+const MaxSandboxSubmissions = 500
+
+const DefaultSandboxConcurrency = 2
+const MaxSandboxConcurrency = 20
+
+// Tasks without a report this long after their analysis timeout fail
+const SandboxReportGraceMinutes = 30
+
+// Sandbox types
+const (
+	SandboxCuckoo = "cuckoo"
+	SandboxCape   = "cape"
+)
+
+// Submission statuses
+const (
+	SandboxQueued    = "queued"
+	SandboxSubmitted = "submitted"
+	SandboxReported  = "reported"
+	SandboxFailed    = "failed"
+)
+
+// Verdicts from the 0-10 score of the sandbox
+const (
+	SandboxMalicious  = "malicious"
+	SandboxSuspicious = "suspicious"
+	SandboxClean      = "clean"
+)
+
+// MaxConcurrent is the amount of tasks running in the sandbox at once, and
+// further submissions wait in the queue. AnalysisTimeout is in seconds, where
+// 0 uses the default of the sandbox
+type CslSandboxConfig struct {
+	Enabled         bool   `json:"enabled"`
+	Type            string `json:"type"`
+	Url             string `json:"url"`
+	ApiToken        string `json:"api_token"`
+	MaxConcurrent   int    `json:"max_concurrent"`
+	AnalysisTimeout int    `json:"analysis_timeout"`
+}
+
+type CslSandboxState struct {
+	LastPoll  int64  `json:"last_poll"`
+	LastError string `json:"last_error"`
+}
+
+type CslSandbox struct {
+	Config CslSandboxConfig `json:"config"`
+	State  CslSandboxState  `json:"state"`
+}
+
+type CslSandboxSignature struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Severity    int    `json:"severity"`
+}
+
+// ReportArtifactId is the artifact holding the full JSON report
+type CslSandboxSubmission struct {
+	Id               string                `json:"id"`
+	ArtifactId       string                `json:"artifact_id"`
+	Filename         string                `json:"filename"`
+	Sha256           string                `json:"sha256"`
+	Status           string                `json:"status"`
+	TaskId           int                   `json:"task_id"`
+	Score            float64               `json:"score"`
+	Verdict          string                `json:"verdict"`
+	Family           string                `json:"family"`
+	Signatures       []CslSandboxSignature `json:"signatures"`
+	ReportArtifactId string                `json:"report_artifact_id"`
+	ExecutionIds     []string              `json:"execution_ids"`
+	Tickets          []string              `json:"tickets"`
+	Error            string                `json:"error"`
+	SubmittedBy      string                `json:"submitted_by"`
+	Created          int64                 `json:"created"`
+	Submitted        int64                 `json:"submitted"`
+	Finished         int64                 `json:"finished"`
+}
+
+type CslSandboxSubmissions struct {
+	Submissions map[string]CslSandboxSubmission `json:"submissions"`
+}
+
+// The parts of Cuckoo and CAPE reports that are kept on the submission.
+// Cuckoo scores in info.score and CAPE in malscore
+type sandboxReport struct {
+	Info struct {
+		Score float64 `json:"score"`
+	} `json:"info"`
+	Malscore   float64               `json:"malscore"`
+	Detections interface{}           `json:"detections"`
+	Signatures []CslSandboxSignature `json:"signatures"`
+}
+
+func getCslSandbox(ctx context.Context, orgId string) CslSandbox {
+	sandbox := CslSandbox{}
+	_, err := getCslDocument(ctx, orgId, CslSandboxDocument, &sandbox)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting sandbox config for org %s: %s", orgId, err)
+	}
+
+	if sandbox.Config.MaxConcurrent == 0 {
+		sandbox.Config.MaxConcurrent = DefaultSandboxConcurrency
+	}
+
+	return sandbox
+}
+
+func updateCslSandboxState(ctx context.Context, orgId string, update func(state *CslSandboxState)) error {
+	sandbox := CslSandbox{}
+	return updateCslDocument(ctx, orgId, CslSandboxDocument, &sandbox, func() error {
+		update(&sandbox.State)
+		return nil
+	})
+}
+
+func redactCslSandbox(sandbox CslSandbox) CslSandbox {
+	if len(sandbox.Config.ApiToken) > 0 {
+		sandbox.Config.ApiToken = RedactedValue
+	}
+
+	return sandbox
+}
+
+func validateCslSandboxConfig(config CslSandboxConfig) error {
+	if config.Type != SandboxCuckoo && config.Type != SandboxCape {
+		return errors.New(fmt.Sprintf("type must be %s or %s", SandboxCuckoo, SandboxCape))
+	}
+
+	if len(config.Url) > 0 && !strings.HasPrefix(config.Url, "https://") && !strings.HasPrefix(config.Url, "http://") {
+		return errors.New("url must start with http:// or https://")
+	}
+
+	if config.Enabled && len(config.Url) == 0 {
+		return errors.New("url is required")
+	}
+
+	if config.MaxConcurrent < 0 || config.MaxConcurrent > MaxSandboxConcurrency {
+		return errors.New(fmt.Sprintf("max_concurrent must be between 1 and %d", MaxSandboxConcurrency))
+	}
+
+	if config.AnalysisTimeout < 0 || config.AnalysisTimeout > 3600 {
+		return errors.New("analysis_timeout must be between 0 and 3600 seconds")
+	}
+
+	return nil
+}
+
+func getSandboxVerdict(score float64) string {
+	if score >= 7 {
+		return SandboxMalicious
+	}
+
+	if score >= 4 {
+		return SandboxSuspicious
+	}
+
+	return SandboxClean
+}
+
+// Paths of the Cuckoo and CAPE REST APIs
+func getSandboxPath(config CslSandboxConfig, action string, taskId int) string {
+	if config.Type == SandboxCape {
+		switch action {
+		case "submit":
+			return "apiv2/tasks/create/file/"
+		case "view":
+			return fmt.Sprintf("apiv2/tasks/view/%d/", taskId)
+		case "report":
+			return fmt.Sprintf("apiv2/tasks/get/report/%d/", taskId)
+		}
+
+		return "apiv2/cuckoo/status/"
+	}
+
+	switch action {
+	case "submit":
+		return "tasks/create/file"
+	case "view":
+		return fmt.Sprintf("tasks/view/%d", taskId)
+	case "report":
+		return fmt.Sprintf("tasks/report/%d", taskId)
+	}
+
+	return "cuckoo/status"
+}
+
+func sandboxRequest(ctx context.Context, config CslSandboxConfig, method, path, contentType string, body io.Reader) ([]byte, error) {
+	if body == nil {
+		body = bytes.NewBuffer([]byte{})
+	}
+
+	requestUrl := fmt.Sprintf("%s/%s", strings.TrimRight(config.Url, "/"), path)
+	req, err := http.NewRequestWithContext(ctx, method, requestUrl, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(contentType) > 0 {
+		req.Header.Add("Content-Type", contentType)
+	}
+
+	if len(config.ApiToken) > 0 {
+		if config.Type == SandboxCape {
+			req.Header.Add("Authorization", fmt.Sprintf("Token %s", config.ApiToken))
+		} else {
+			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", config.ApiToken))
+		}
+	}
+
+	client := shuffle.GetExternalClient(requestUrl)
+	newresp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer newresp.Body.Close()
+	respBody, err := ioutil.ReadAll(newresp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if newresp.StatusCode >= 300 {
+		return nil, errors.New(fmt.Sprintf("sandbox returned status code %d for %s: %s", newresp.StatusCode, path, truncateText(string(respBody), 500)))
+	}
+
+	return respBody, nil
+}
+
+// CAPE wraps responses in {"error": false, "data": ...}
+func parseSandboxResponse(config CslSandboxConfig, body []byte, result interface{}) error {
+	if config.Type != SandboxCape {
+		return json.Unmarshal(body, result)
+	}
+
+	wrapper := struct {
+		Error  bool            `json:"error"`
+		Errors interface{}     `json:"errors"`
+		Data   json.RawMessage `json:"data"`
+	}{}
+
+	err := json.Unmarshal(body, &wrapper)
+	if err != nil {
+		return err
+	}
+
+	if wrapper.Error {
+		return errors.New(fmt.Sprintf("sandbox returned an error: %v", wrapper.Errors))
+	}
+
+	return json.Unmarshal(wrapper.Data, result)
+}
+
+func submitSandboxFile(ctx context.Context, config CslSandboxConfig, filename string, contents []byte) (int, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return 0, err
+	}
+
+	part.Write(contents)
+	if config.AnalysisTimeout > 0 {
+		writer.WriteField("timeout", fmt.Sprintf("%d", config.AnalysisTimeout))
+	}
+
+	writer.Close()
+	respBody, err := sandboxRequest(ctx, config, "POST", getSandboxPath(config, "submit", 0), writer.FormDataContentType(), body)
+	if err != nil {
+		return 0, err
+	}
+
+	created := struct {
+		TaskId  int   `json:"task_id"`
+		TaskIds []int `json:"task_ids"`
+	}{}
+
+	err = parseSandboxResponse(config, respBody, &created)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(created.TaskIds) > 0 {
+		return created.TaskIds[0], nil
+	}
+
+	if created.TaskId == 0 {
+		return 0, errors.New("sandbox didn't return a task id")
+	}
+
+	return created.TaskId, nil
+}
+
+func getSandboxTaskStatus(ctx context.Context, config CslSandboxConfig, taskId int) (string, error) {
+	respBody, err := sandboxRequest(ctx, config, "GET", getSandboxPath(config, "view", taskId), "", nil)
+	if err != nil {
+		return "", err
+	}
+
+	task := struct {
+		Status string `json:"status"`
+		Task   struct {
+			Status string `json:"status"`
+		} `json:"task"`
+	}{}
+
+	err = parseSandboxResponse(config, respBody, &task)
+	if err != nil {
+		return "", err
+	}
+
+	if len(task.Task.Status) > 0 {
+		return task.Task.Status, nil
+	}
+
+	return task.Status, nil
+}
+
+func getSandboxFamily(detections interface{}) string {
+	switch typedDetections := detections.(type) {
+	case string:
+		return typedDetections
+	case []interface{}:
+		for _, detection := range typedDetections {
+			detectionMap, ok := detection.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			if family, ok := detectionMap["family"].(string); ok {
+				return family
+			}
+		}
+	}
+
+	return ""
+}
+
+func storeCslSandboxSubmission(ctx context.Context, orgId string, submission CslSandboxSubmission) error {
+	submissions := CslSandboxSubmissions{}
+	return updateCslDocument(ctx, orgId, CslSandboxSubmissionsDocument, &submissions, func() error {
+		if submissions.Submissions == nil {
+			submissions.Submissions = map[string]CslSandboxSubmission{}
+		}
+
+		submissions.Submissions[submission.Id] = submission
+		if len(submissions.Submissions) <= MaxSandboxSubmissions {
+			return nil
+		}
+
+		finished := []CslSandboxSubmission{}
+		for _, stored := range submissions.Submissions {
+			if stored.Status == SandboxReported || stored.Status == SandboxFailed {
+				finished = append(finished, stored)
+			}
+		}
+
+		sort.Slice(finished, func(i, j int) bool {
+			return finished[i].Created < finished[j].Created
+		})
+
+		for _, stored := range finished[:min(len(finished), len(submissions.Submissions)-MaxSandboxSubmissions)] {
+			delete(submissions.Submissions, stored.Id)
+		}
+
+		return nil
+	})
+}
+
+func getCslSandboxSubmissions(ctx context.Context, orgId string) CslSandboxSubmissions {
+	submissions := CslSandboxSubmissions{}
+	_, err := getCslDocument(ctx, orgId, CslSandboxSubmissionsDocument, &submissions)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting sandbox submissions for org %s: %s", orgId, err)
+	}
+
+	if submissions.Submissions == nil {
+		submissions.Submissions = map[string]CslSandboxSubmission{}
+	}
+
+	return submissions
+}
+
+// Adds a note to a linked Jira issue or ServiceNow incident
+func noteCslTicket(ctx context.Context, orgId, ticket, note string) error {
+	for _, issue := range getCslJiraIssues(ctx, orgId).Issues {
+		if issue.Key != ticket {
+			continue
+		}
+
+		jira := getCslJira(ctx, orgId)
+		if !jira.Config.Enabled {
+			return errors.New("jira is not enabled")
+		}
+
+		comment, err := addJiraComment(ctx, jira.Config, issue.Key, note)
+		if err != nil {
+			return err
+		}
+
+		issue.Comments = append(issue.Comments, CslJiraComment{
+			Id:      comment.Id,
+			Author:  "Shuffle",
+			Body:    note,
+			Origin:  "shuffle",
+			Created: time.Now().Unix(),
+		})
+		issue.Updated = time.Now().Unix()
+		return storeCslJiraIssue(ctx, orgId, issue)
+	}
+
+	for _, incident := range getCslServiceNowIncidents(ctx, orgId).Incidents {
+		if incident.Number != ticket {
+			continue
+		}
+
+		serviceNow := getCslServiceNow(ctx, orgId)
+		if !serviceNow.Config.Enabled {
+			return errors.New("servicenow is not enabled")
+		}
+
+		updated := serviceNowIncident{}
+		return serviceNowRequest(ctx, serviceNow.Config, "PATCH", incident.SysId, map[string]string{"work_notes": note}, &updated)
+	}
+
+	return errors.New(fmt.Sprintf("ticket %s isn't linked", ticket))
+}
+
+// Fetches the report of a finished task, stores it as an artifact linked to
+// the executions and tickets of the submission and notes the verdict on the
+// tickets
+func finishSandboxSubmission(ctx context.Context, orgId string, config CslSandboxConfig, submission CslSandboxSubmission) CslSandboxSubmission {
+	respBody, err := sandboxRequest(ctx, config, "GET", getSandboxPath(config, "report", submission.TaskId), "", nil)
+	if err != nil {
+		submission.Status = SandboxFailed
+		submission.Error = err.Error()
+		return submission
+	}
+
+	report := sandboxReport{}
+	err = json.Unmarshal(respBody, &report)
+	if err != nil {
+		submission.Status = SandboxFailed
+		submission.Error = fmt.Sprintf("failed parsing report: %s", err)
+		return submission
+	}
+
+	submission.Status = SandboxReported
+	submission.Finished = time.Now().Unix()
+	submission.Score = report.Info.Score
+	if report.Malscore > submission.Score {
+		submission.Score = report.Malscore
+	}
+
+	submission.Verdict = getSandboxVerdict(submission.Score)
+	submission.Family = getSandboxFamily(report.Detections)
+	submission.Signatures = []CslSandboxSignature{}
+	for _, signature := range report.Signatures {
+		signature.Description = truncateText(signature.Description, 500)
+		submission.Signatures = append(submission.Signatures, signature)
+	}
+
+	sort.SliceStable(submission.Signatures, func(i, j int) bool {
+		return submission.Signatures[i].Severity > submission.Signatures[j].Severity
+	})
+
+	submission.Signatures = submission.Signatures[:min(len(submission.Signatures), 25)]
+
+	artifact, err := storeCslArtifact(ctx, orgId, CslArtifact{
+		Filename:     fmt.Sprintf("%s_%s_report.json", config.Type, submission.Sha256[:min(len(submission.Sha256), 12)]),
+		Type:         ArtifactTypeFile,
+		Description:  fmt.Sprintf("%s report of %s: %s (score %.1f)", config.Type, submission.Filename, submission.Verdict, submission.Score),
+		Tags:         []string{"sandbox_report", submission.Verdict},
+		ExecutionIds: submission.ExecutionIds,
+		Tickets:      submission.Tickets,
+		CreatedBy:    "sandbox",
+	}, respBody)
+	if err != nil {
+		log.Printf("[WARNING] Failed storing sandbox report of submission %s for org %s: %s", submission.Id, orgId, err)
+	} else {
+		submission.ReportArtifactId = artifact.Id
+	}
+
+	note := fmt.Sprintf("Sandbox verdict for %s (sha256 %s): %s with score %.1f", submission.Filename, submission.Sha256, submission.Verdict, submission.Score)
+	if len(submission.Family) > 0 {
+		note += fmt.Sprintf(", family %s", submission.Family)
+	}
+
+	for _, ticket := range submission.Tickets {
+		err := noteCslTicket(ctx, orgId, ticket, note)
+		if err != nil {
+			log.Printf("[WARNING] Failed noting sandbox verdict on ticket %s for org %s: %s", ticket, orgId, err)
+		}
+	}
+
+	recordCslActivity(ctx, orgId, ActivityTypeCase, "sandbox_reported", note, "sandbox", submission.Id)
+	return submission
+}
+
+// Polls submitted tasks, then submits queued artifacts while there's room
+// under the concurrency limit of the sandbox
+func runCslSandboxJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for sandbox job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		sandbox := getCslSandbox(ctx, org.Id)
+		if !sandbox.Config.Enabled {
+			continue
+		}
+
+		config := sandbox.Config
+		submissions := []CslSandboxSubmission{}
+		for _, submission := range getCslSandboxSubmissions(ctx, org.Id).Submissions {
+			submissions = append(submissions, submission)
+		}
+
+		if len(submissions) == 0 {
+			continue
+		}
+
+		sort.Slice(submissions, func(i, j int) bool {
+			return submissions[i].Created < submissions[j].Created
+		})
+
+		var pollErr error
+		running := 0
+		deadline := int64(config.AnalysisTimeout + SandboxReportGraceMinutes*60)
+		for _, submission := range submissions {
+			if submission.Status != SandboxSubmitted {
+				continue
+			}
+
+			status, err := getSandboxTaskStatus(ctx, config, submission.TaskId)
+			switch {
+			case err != nil:
+				pollErr = err
+				running += 1
+				continue
+			case status == "reported":
+				submission = finishSandboxSubmission(ctx, org.Id, config, submission)
+			case strings.HasPrefix(status, "failed"):
+				submission.Status = SandboxFailed
+				submission.Error = fmt.Sprintf("analysis failed with status %s", status)
+			case time.Now().Unix()-submission.Submitted > deadline:
+				submission.Status = SandboxFailed
+				submission.Error = "timed out waiting for the report"
+			default:
+				running += 1
+				continue
+			}
+
+			if submission.Status == SandboxFailed {
+				submission.Finished = time.Now().Unix()
+			}
+
+			err = storeCslSandboxSubmission(ctx, org.Id, submission)
+			if err != nil {
+				log.Printf("[ERROR] Failed storing sandbox submission %s for org %s: %s", submission.Id, org.Id, err)
+			}
+		}
+
+		artifacts := getCslArtifacts(ctx, org.Id).Artifacts
+		for _, submission := range submissions {
+			if submission.Status != SandboxQueued || running >= config.MaxConcurrent {
+				continue
+			}
+
+			artifact, ok := artifacts[submission.ArtifactId]
+			if !ok {
+				submission.Status = SandboxFailed
+				submission.Error = "artifact was deleted"
+			} else {
+				contents, err := getCslArtifactContents(ctx, org.Id, artifact)
+				if err == nil {
+					submission.TaskId, err = submitSandboxFile(ctx, config, artifact.Filename, contents)
+				}
+
+				if err != nil {
+					// Kept in the queue, as the sandbox may be unavailable
+					pollErr = err
+					break
+				}
+
+				submission.Status = SandboxSubmitted
+				submission.Submitted = time.Now().Unix()
+				running += 1
+			}
+
+			err = storeCslSandboxSubmission(ctx, org.Id, submission)
+			if err != nil {
+				log.Printf("[ERROR] Failed storing sandbox submission %s for org %s: %s", submission.Id, org.Id, err)
+			}
+		}
+
+		err = updateCslSandboxState(ctx, org.Id, func(state *CslSandboxState) {
+			state.LastPoll = time.Now().Unix()
+			state.LastError = ""
+			if pollErr != nil {
+				state.LastError = pollErr.Error()
+			}
+		})
+		if err != nil {
+			log.Printf("[WARNING] Failed updating sandbox state for org %s: %s", org.Id, err)
+		}
+	}
+}
+
+/*
+Sandbox:
+Returns the Cuckoo or CAPE sandbox configuration. Requires org admin.
+
+	{
+	    "success": true,
+	    "data": {
+	        "config": {
+	            "enabled": true,
+	            "type": "cape",
+	            "url": "https://cape.example.com",
+	            "api_token": "********",
+	            "max_concurrent": 2,
+	            "analysis_timeout": 300
+	        },
+	        "state": {
+	            "last_poll": 1700000000,
+	            "last_error": ""
+	        }
+	    }
+	}
+*/
+func cslGetSandbox(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslSandbox(getCslSandbox(ctx, user.ActiveOrg.Id)),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetSandbox")
+}
+
+/*
+Sandbox:
+Updates the sandbox configuration. Requires org admin. Body uses the format
+of the config field returned from GET, and a redacted API token is kept as it
+is. Type is cuckoo or cape.
+*/
+func cslSetSandbox(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	sandbox := CslSandbox{}
+	err = json.Unmarshal(body, &sandbox.Config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if sandbox.Config.ApiToken == RedactedValue {
+		sandbox.Config.ApiToken = getCslSandbox(ctx, user.ActiveOrg.Id).Config.ApiToken
+	}
+
+	err = validateCslSandboxConfig(sandbox.Config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	stored := CslSandbox{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslSandboxDocument, &stored, func() error {
+		stored.Config = sandbox.Config
+		sandbox = stored
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated sandbox config for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "sandbox_updated", "Sandbox configuration was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslSandbox(sandbox),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetSandbox")
+}
+
+/*
+Sandbox:
+Checks that the sandbox is reachable with the configured API token. Requires
+org admin.
+*/
+func cslTestSandbox(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	config := getCslSandbox(ctx, user.ActiveOrg.Id).Config
+	if len(config.Url) == 0 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("sandbox is not configured")))
+		return
+	}
+
+	_, err := sandboxRequest(ctx, config, "GET", getSandboxPath(config, "status", 0), "", nil)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslTestSandbox")
+}
+
+/*
+Sandbox:
+Queues an artifact for detonation. Requires ?artifact_id=<id>, and optional
+?ticket=<jira issue key|servicenow incident number> gets a note with the
+verdict. Workflows submit with ?execution_id=<id>&authorization=<execution
+authorization>, and the report is linked to the execution. An artifact that
+is already queued or running isn't submitted again, and the links are added
+to the existing submission instead.
+
+	{
+	    "success": true,
+	    "data": {
+	        "id": "...",
+	        "artifact_id": "file_...",
+	        "filename": "invoice.exe",
+	        "sha256": "...",
+	        "status": "queued",
+	        "execution_ids": ["..."],
+	        "tickets": ["SOC-42"],
+	        "created": 1700000000
+	    }
+	}
+*/
+func cslSubmitSandbox(resp http.ResponseWriter, request *http.Request) {
+	orgId, caller, executionId, ok := handleCslExecutionRequest(resp, request)
+	if !ok {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	if !getCslSandbox(ctx, orgId).Config.Enabled {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("sandbox is not enabled")))
+		return
+	}
+
+	artifact, ok := getCslArtifacts(ctx, orgId).Artifacts[query.Get("artifact_id")]
+	if !ok {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New("artifact not found")))
+		return
+	}
+
+	if len(executionId) == 0 && len(query.Get("execution_id")) > 0 {
+		execution, err := shuffle.GetWorkflowExecution(ctx, query.Get("execution_id"))
+		if err != nil || execution.ExecutionOrg != orgId {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New("execution_id is not valid")))
+			return
+		}
+
+		executionId = execution.ExecutionId
+	}
+
+	submission := CslSandboxSubmission{
+		Id:           uuid.NewV4().String(),
+		ArtifactId:   artifact.Id,
+		Filename:     artifact.Filename,
+		Sha256:       artifact.Sha256,
+		Status:       SandboxQueued,
+		Signatures:   []CslSandboxSignature{},
+		ExecutionIds: addArtifactLink([]string{}, executionId),
+		Tickets:      addArtifactLink([]string{}, query.Get("ticket")),
+		SubmittedBy:  caller,
+		Created:      time.Now().Unix(),
+	}
+
+	submissions := CslSandboxSubmissions{}
+	err := updateCslDocument(ctx, orgId, CslSandboxSubmissionsDocument, &submissions, func() error {
+		if submissions.Submissions == nil {
+			submissions.Submissions = map[string]CslSandboxSubmission{}
+		}
+
+		for _, existing := range submissions.Submissions {
+			if existing.Sha256 == submission.Sha256 && (existing.Status == SandboxQueued || existing.Status == SandboxSubmitted) {
+				existing.ExecutionIds = addArtifactLink(existing.ExecutionIds, executionId)
+				existing.Tickets = addArtifactLink(existing.Tickets, query.Get("ticket"))
+				submissions.Submissions[existing.Id] = existing
+				submission = existing
+				return nil
+			}
+		}
+
+		if len(submissions.Submissions) >= MaxSandboxSubmissions*2 {
+			return errors.New("too many sandbox submissions")
+		}
+
+		submissions.Submissions[submission.Id] = submission
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] %s submitted artifact %s to the sandbox for org %s", caller, artifact.Id, orgId)
+
+	res := CslResponse{
+		Success: true,
+		Data:    submission,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSubmitSandbox")
+}
+
+/*
+Sandbox:
+Returns the sandbox submissions of the current organization, newest first.
+Optional ?id=<submission id>, ?status=<queued|submitted|reported|failed>,
+?artifact_id=<id> and ?execution_id=<id> filter them. Reported submissions
+include the score, verdict, malware family, top signatures and the artifact
+with the full report.
+*/
+func cslListSandboxSubmissions(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	submissions := []CslSandboxSubmission{}
+	for _, submission := range getCslSandboxSubmissions(ctx, user.ActiveOrg.Id).Submissions {
+		if len(query.Get("id")) > 0 && submission.Id != query.Get("id") {
+			continue
+		}
+
+		if len(query.Get("status")) > 0 && submission.Status != query.Get("status") {
+			continue
+		}
+
+		if len(query.Get("artifact_id")) > 0 && submission.ArtifactId != query.Get("artifact_id") {
+			continue
+		}
+
+		if len(query.Get("execution_id")) > 0 && !shuffle.ArrayContains(submission.ExecutionIds, query.Get("execution_id")) {
+			continue
+		}
+
+		submissions = append(submissions, submission)
+	}
+
+	sort.SliceStable(submissions, func(i, j int) bool {
+		return submissions[i].Created > submissions[j].Created
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data:    submissions,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslListSandboxSubmissions")
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Schedule frequencies are either seconds between runs, as used by onprem
+// schedules, or a cron expression with five fields as used in the cloud.
+// Cron expressions are evaluated in the org timezone.
+
+const (
+	ScheduleTypeInterval = "interval"
+	ScheduleTypeCron     = "cron"
+)
+
+const DefaultSchedulePreviewRuns = 5
+const MaxSchedulePreviewRuns = 50
+
+// Fire times the shortest interval of cron expressions is found from
+const ScheduleIntervalSamples = 500
+
+// Cron expressions that never fire within this many years are invalid
+const MaxCronSearchYears = 5
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var cronDayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: cronMonthNames},
+	{name: "day of week", min: 0, max: 7, names: cronDayNames},
+}
+
+// Bitmasks of the values each field matches
+type cronSchedule struct {
+	minutes     uint64
+	hours       uint64
+	daysOfMonth uint64
+	months      uint64
+	daysOfWeek  uint64
+
+	// Days match either field when both are restricted, the same as cron
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+type CslSchedulePreview struct {
+	Frequency          string   `json:"frequency"`
+	Type               string   `json:"type"`
+	Timezone           string   `json:"timezone"`
+	IntervalSeconds    int64    `json:"interval_seconds"`
+	MinIntervalSeconds int      `json:"min_interval_seconds"`
+	Allowed            bool     `json:"allowed"`
+	Reason             string   `json:"reason,omitempty"`
+	NextRuns           []string `json:"next_runs"`
+}
+
+func parseCronValue(value string, field cronField) (int, error) {
+	for index, name := range field.names {
+		if strings.ToLower(value) == name {
+			return index + field.min, nil
+		}
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < field.min || number > field.max {
+		return 0, errors.New(fmt.Sprintf("%s must be between %d and %d, not %s", field.name, field.min, field.max, value))
+	}
+
+	return number, nil
+}
+
+// Parses lists of values, ranges and steps such as 1,5-10,*/15
+func parseCronField(value string, field cronField) (uint64, error) {
+	mask := uint64(0)
+	for _, item := range strings.Split(value, ",") {
+		step := 1
+		if parts := strings.SplitN(item, "/", 2); len(parts) == 2 {
+			parsedStep, err := strconv.Atoi(parts[1])
+			if err != nil || parsedStep < 1 {
+				return 0, errors.New(fmt.Sprintf("invalid step %s in %s", parts[1], field.name))
+			}
+
+			item = parts[0]
+			step = parsedStep
+		}
+
+		start, end := field.min, field.max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+
+			var err error
+			start, err = parseCronValue(bounds[0], field)
+			if err != nil {
+				return 0, err
+			}
+
+			end = start
+			if len(bounds) == 2 {
+				end, err = parseCronValue(bounds[1], field)
+				if err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				end = field.max
+			}
+
+			if end < start {
+				return 0, errors.New(fmt.Sprintf("invalid range %s in %s", item, field.name))
+			}
+		}
+
+		for i := start; i <= end; i += step {
+			mask |= 1 << uint(i)
+		}
+	}
+
+	return mask, nil
+}
+
+func parseCronExpression(expression string) (*cronSchedule, error) {
+	expression = strings.TrimSpace(expression)
+	if macro, ok := cronMacros[strings.ToLower(expression)]; ok {
+		expression = macro
+	}
+
+	values := strings.Fields(expression)
+	if len(values) != len(cronFields) {
+		return nil, errors.New(fmt.Sprintf("cron expressions need %d fields: minute, hour, day of month, month and day of week", len(cronFields)))
+	}
+
+	masks := make([]uint64, len(cronFields))
+	for index, field := range cronFields {
+		mask, err := parseCronField(values[index], field)
+		if err != nil {
+			return nil, err
+		}
+
+		masks[index] = mask
+	}
+
+	// 7 is sunday as well
+	if masks[4]&(1<<7) != 0 {
+		masks[4] |= 1
+	}
+
+	schedule := &cronSchedule{
+		minutes:       masks[0],
+		hours:         masks[1],
+		daysOfMonth:   masks[2],
+		months:        masks[3],
+		daysOfWeek:    masks[4],
+		anyDayOfMonth: strings.HasPrefix(values[2], "*"),
+		anyDayOfWeek:  strings.HasPrefix(values[4], "*"),
+	}
+
+	if schedule.next(time.Now()).IsZero() {
+		return nil, errors.New("cron expression never fires")
+	}
+
+	return schedule, nil
+}
+
+func (schedule *cronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := schedule.daysOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := schedule.daysOfWeek&(1<<uint(t.Weekday())) != 0
+
+	if !schedule.anyDayOfMonth && !schedule.anyDayOfWeek {
+		return dayOfMonth || dayOfWeek
+	}
+
+	return dayOfMonth && dayOfWeek
+}
+
+// The first fire time after t in the location of t, or zero if there is none
+func (schedule *cronSchedule) next(t time.Time) time.Time {
+	location := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(MaxCronSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		if schedule.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, location)
+			continue
+		}
+
+		if !schedule.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location)
+			continue
+		}
+
+		if schedule.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, location)
+			continue
+		}
+
+		if schedule.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// Returns the type of the frequency, the next fire times from now and the
+// shortest interval between runs in seconds
+func getScheduleRuns(frequency string, location *time.Location, count int) (string, []time.Time, int64, error) {
+	runs := []time.Time{}
+	timeNow := time.Now().In(location)
+
+	if seconds, err := strconv.Atoi(strings.TrimSpace(frequency)); err == nil {
+		if seconds < 1 {
+			return ScheduleTypeInterval, runs, 0, errors.New("frequency has to be more than 0 seconds")
+		}
+
+		for i := 1; i <= count; i++ {
+			runs = append(runs, timeNow.Add(time.Duration(seconds*i)*time.Second))
+		}
+
+		return ScheduleTypeInterval, runs, int64(seconds), nil
+	}
+
+	schedule, err := parseCronExpression(frequency)
+	if err != nil {
+		return ScheduleTypeCron, runs, 0, err
+	}
+
+	interval := int64(0)
+	previous := time.Time{}
+	next := timeNow
+	for i := 0; i < ScheduleIntervalSamples || len(runs) < count; i++ {
+		next = schedule.next(next)
+		if next.IsZero() {
+			break
+		}
+
+		if len(runs) < count {
+			runs = append(runs, next)
+		}
+
+		if !previous.IsZero() {
+			gap := int64(next.Sub(previous).Seconds())
+			if interval == 0 || gap < interval {
+				interval = gap
+			}
+		}
+
+		previous = next
+	}
+
+	return ScheduleTypeCron, runs, interval, nil
+}
+
+func checkScheduleInterval(interval int64, minInterval int) error {
+	if minInterval > 0 && interval > 0 && interval < int64(minInterval) {
+		return errors.New(fmt.Sprintf("schedule fires every %d seconds, but the organization allows at most every %d seconds", interval, minInterval))
+	}
+
+	return nil
+}
+
+// Wraps the schedule handler to reject schedules firing more often than the
+// org allows
+func cslScheduleInterval(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		if request.Method == "OPTIONS" || request.Body == nil {
+			handler(resp, request)
+			return
+		}
+
+		ctx := shuffle.GetContext(request)
+		workflow, err := shuffle.GetWorkflow(ctx, mux.Vars(request)["key"])
+		if err != nil || len(workflow.OrgId) == 0 {
+			handler(resp, request)
+			return
+		}
+
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		schedule := shuffle.Schedule{}
+		err = json.Unmarshal(body, &schedule)
+		if err != nil || len(schedule.Frequency) == 0 {
+			handler(resp, request)
+			return
+		}
+
+		settings := getCslOrgSettings(ctx, workflow.OrgId)
+		if settings.MinScheduleIntervalSeconds == 0 {
+			handler(resp, request)
+			return
+		}
+
+		_, _, interval, err := getScheduleRuns(schedule.Frequency, getTimezoneLocation(settings.Timezone), 0)
+		if err == nil {
+			err = checkScheduleInterval(interval, settings.MinScheduleIntervalSeconds)
+		}
+
+		if err != nil {
+			log.Printf("[WARNING] Rejecting schedule %s of workflow %s in org %s: %s", schedule.Frequency, workflow.ID, workflow.OrgId, err)
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		handler(resp, request)
+	}
+}
+
+/*
+Schedules:
+Validates the schedule ?frequency= and returns its next ?count=N fire times
+(default 5, max 50) in the timezone of the current organization. Frequency
+is either seconds between runs or a cron expression with five fields, such as
+0,15,30,45 8-16 * * mon-fri, or a macro such as @daily. interval_seconds is the
+shortest time between runs, and schedules firing more often than the
+min_schedule_interval_seconds setting aren't allowed.
+
+	{
+	    "success": true,
+	    "data": {
+	        "frequency": "0,15,30,45 8-16 * * mon-fri",
+	        "type": "cron",
+	        "timezone": "Europe/Oslo",
+	        "interval_seconds": 900,
+	        "min_interval_seconds": 300,
+	        "allowed": true,
+	        "next_runs": [
+	            "2024-06-10T08:00:00+02:00",
+	            "2024-06-10T08:15:00+02:00",
+	            ...
+	        ]
+	    }
+	}
+*/
+func cslSchedulePreview(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	count := DefaultSchedulePreviewRuns
+	if len(query.Get("count")) > 0 {
+		value, err := strconv.Atoi(query.Get("count"))
+		if err != nil || value < 1 || value > MaxSchedulePreviewRuns {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("count must be between 1 and %d", MaxSchedulePreviewRuns))))
+			return
+		}
+
+		count = value
+	}
+
+	frequency := query.Get("frequency")
+	if len(strings.TrimSpace(frequency)) == 0 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("frequency is required")))
+		return
+	}
+
+	settings := getCslOrgSettings(ctx, user.ActiveOrg.Id)
+	location := getTimezoneLocation(settings.Timezone)
+
+	scheduleType, runs, interval, err := getScheduleRuns(frequency, location, count)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	preview := CslSchedulePreview{
+		Frequency:          frequency,
+		Type:               scheduleType,
+		Timezone:           location.String(),
+		IntervalSeconds:    interval,
+		MinIntervalSeconds: settings.MinScheduleIntervalSeconds,
+		Allowed:            true,
+		NextRuns:           []string{},
+	}
+
+	err = checkScheduleInterval(interval, settings.MinScheduleIntervalSeconds)
+	if err != nil {
+		preview.Allowed = false
+		preview.Reason = err.Error()
+	}
+
+	for _, run := range runs {
+		preview.NextRuns = append(preview.NextRuns, run.Format(time.RFC3339))
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    preview,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSchedulePreview")
+}
@@ -0,0 +1,1646 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// SCIM 2.0 server (RFC 7643 and 7644) for identity providers to provision
+// users and groups in an org. Roles follow the SSO group role mapping, so the
+// same groups give the same roles whether they come from SCIM or a login.
+
+const CslScimDocument = "scim"
+
+// SCIM tokens look like cslscim_<org id>_<secret>
+const ScimTokenPrefix = "cslscim"
+
+const DefaultScimPageSize = 100
+const MaxScimPageSize = 500
+
+const (
+	ScimSchemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	ScimSchemaGroup        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ScimSchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	ScimSchemaPatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ScimSchemaError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ScimSchemaConfig       = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ScimSchemaResourceType = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+)
+
+type CslScimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type CslScimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type CslScimMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+type CslScimMeta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// A provisioned user. Users removed from the org with active set to false
+// are kept, so the identity provider can activate them again
+type CslScimUserRecord struct {
+	UserName     string         `json:"user_name"`
+	ExternalId   string         `json:"external_id"`
+	DisplayName  string         `json:"display_name"`
+	Name         CslScimName    `json:"name"`
+	Emails       []CslScimEmail `json:"emails"`
+	Active       bool           `json:"active"`
+	Created      int64          `json:"created"`
+	LastModified int64          `json:"last_modified"`
+}
+
+type CslScimGroup struct {
+	Id           string   `json:"id"`
+	DisplayName  string   `json:"display_name"`
+	ExternalId   string   `json:"external_id"`
+	Members      []string `json:"members"`
+	Created      int64    `json:"created"`
+	LastModified int64    `json:"last_modified"`
+}
+
+// Users and groups are keyed by id. Users use their Shuffle user id
+type CslScim struct {
+	Enabled      bool                         `json:"enabled"`
+	TokenHash    string                       `json:"token_hash"`
+	TokenPreview string                       `json:"token_preview"`
+	TokenCreated int64                        `json:"token_created"`
+	Users        map[string]CslScimUserRecord `json:"users"`
+	Groups       map[string]CslScimGroup      `json:"groups"`
+}
+
+type CslScimConfig struct {
+	Enabled      bool   `json:"enabled"`
+	TokenPreview string `json:"token_preview"`
+	TokenCreated int64  `json:"token_created"`
+	Users        int    `json:"users"`
+	Groups       int    `json:"groups"`
+}
+
+type CslScimToken struct {
+	Token string `json:"token"`
+}
+
+// User resource as sent to and from the identity provider
+type CslScimUser struct {
+	Schemas     []string        `json:"schemas"`
+	Id          string          `json:"id,omitempty"`
+	ExternalId  string          `json:"externalId,omitempty"`
+	UserName    string          `json:"userName"`
+	DisplayName string          `json:"displayName,omitempty"`
+	Name        *CslScimName    `json:"name,omitempty"`
+	Emails      []CslScimEmail  `json:"emails,omitempty"`
+	Active      *bool           `json:"active,omitempty"`
+	Groups      []CslScimMember `json:"groups,omitempty"`
+	Meta        *CslScimMeta    `json:"meta,omitempty"`
+}
+
+type CslScimGroupResource struct {
+	Schemas     []string        `json:"schemas"`
+	Id          string          `json:"id,omitempty"`
+	ExternalId  string          `json:"externalId,omitempty"`
+	DisplayName string          `json:"displayName"`
+	Members     []CslScimMember `json:"members"`
+	Meta        *CslScimMeta    `json:"meta,omitempty"`
+}
+
+type CslScimListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type CslScimPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type CslScimPatchRequest struct {
+	Schemas    []string                `json:"schemas"`
+	Operations []CslScimPatchOperation `json:"Operations"`
+}
+
+type CslScimError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+func getCslScim(ctx context.Context, orgId string) CslScim {
+	scim := CslScim{}
+	_, err := getCslDocument(ctx, orgId, CslScimDocument, &scim)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting SCIM config for org %s: %s", orgId, err)
+	}
+
+	setScimDefaults(&scim)
+	return scim
+}
+
+func setScimDefaults(scim *CslScim) {
+	if scim.Users == nil {
+		scim.Users = map[string]CslScimUserRecord{}
+	}
+
+	if scim.Groups == nil {
+		scim.Groups = map[string]CslScimGroup{}
+	}
+}
+
+func formatScimTime(timestamp int64) string {
+	if timestamp == 0 {
+		return ""
+	}
+
+	return time.Unix(timestamp, 0).UTC().Format(time.RFC3339)
+}
+
+func writeScimResponse(resp http.ResponseWriter, status int, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("[ERROR] Failed marshalling SCIM response: %s", err)
+		status = 500
+		b = []byte(`{"schemas": ["urn:ietf:params:scim:api:messages:2.0:Error"], "status": "500", "detail": "Failed marshalling"}`)
+	}
+
+	resp.Header().Set("Content-Type", "application/scim+json")
+	resp.WriteHeader(status)
+	resp.Write(b)
+}
+
+func writeScimError(resp http.ResponseWriter, status int, scimType string, err error) {
+	writeScimResponse(resp, status, CslScimError{
+		Schemas:  []string{ScimSchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   err.Error(),
+	})
+}
+
+// Changes affecting users of other orgs are conflicts, other errors use the
+// status of the endpoint
+func writeCslScimApplyError(resp http.ResponseWriter, status int, err error) {
+	if err == errCslUserOfOtherOrg {
+		writeScimError(resp, 409, "uniqueness", err)
+		return
+	}
+
+	scimType := ""
+	if status == 400 {
+		scimType = "invalidValue"
+	}
+
+	writeScimError(resp, status, scimType, err)
+}
+
+// Authenticates the bearer token of the identity provider and returns the org
+func handleCslScimRequest(resp http.ResponseWriter, request *http.Request) string {
+	authorization := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+	parts := strings.Split(authorization, "_")
+	if len(parts) != 3 || parts[0] != ScimTokenPrefix {
+		writeScimError(resp, 401, "", errors.New("invalid SCIM token"))
+		return ""
+	}
+
+	orgId := parts[1]
+	scim := getCslScim(shuffle.GetContext(request), orgId)
+	if !scim.Enabled || len(scim.TokenHash) == 0 || subtle.ConstantTimeCompare([]byte(hashApiKeySecret(parts[2])), []byte(scim.TokenHash)) != 1 {
+		log.Printf("[WARNING] Invalid SCIM token for org %s from %s", orgId, shuffle.GetRequestIp(request))
+		writeScimError(resp, 401, "", errors.New("invalid SCIM token"))
+		return ""
+	}
+
+	return orgId
+}
+
+// Parses filters like userName eq "analyst@example.com", which is what
+// identity providers use to look up users and groups
+func parseScimFilter(filter string) (string, string, error) {
+	parts := strings.SplitN(strings.TrimSpace(filter), " ", 3)
+	if len(parts) != 3 || !strings.EqualFold(parts[1], "eq") {
+		return "", "", errors.New("only eq filters are supported")
+	}
+
+	return parts[0], strings.Trim(strings.TrimSpace(parts[2]), "\""), nil
+}
+
+// Returns the requested page of resources with 1-based startIndex
+func getScimPage(request *http.Request, resources []interface{}) CslScimListResponse {
+	startIndex := 1
+	if parsed, err := strconv.Atoi(request.URL.Query().Get("startIndex")); err == nil && parsed > 1 {
+		startIndex = parsed
+	}
+
+	count := DefaultScimPageSize
+	if parsed, err := strconv.Atoi(request.URL.Query().Get("count")); err == nil && parsed >= 0 {
+		count = min(parsed, MaxScimPageSize)
+	}
+
+	page := []interface{}{}
+	if startIndex <= len(resources) {
+		page = resources[startIndex-1 : min(len(resources), startIndex-1+count)]
+	}
+
+	return CslScimListResponse{
+		Schemas:      []string{ScimSchemaListResponse},
+		TotalResults: len(resources),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}
+}
+
+func getScimUserGroups(scim CslScim, userId string) []CslScimGroup {
+	groups := []CslScimGroup{}
+	for _, group := range scim.Groups {
+		if shuffle.ArrayContains(group.Members, userId) {
+			groups = append(groups, group)
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].DisplayName < groups[j].DisplayName
+	})
+
+	return groups
+}
+
+func getScimUserResource(scim CslScim, userId, username string, member bool) CslScimUser {
+	record, ok := scim.Users[userId]
+	if !ok {
+		record = CslScimUserRecord{UserName: username, Active: member}
+	}
+
+	active := record.Active && member
+	resource := CslScimUser{
+		Schemas:     []string{ScimSchemaUser},
+		Id:          userId,
+		ExternalId:  record.ExternalId,
+		UserName:    username,
+		DisplayName: record.DisplayName,
+		Emails:      record.Emails,
+		Active:      &active,
+		Groups:      []CslScimMember{},
+		Meta: &CslScimMeta{
+			ResourceType: "User",
+			Created:      formatScimTime(record.Created),
+			LastModified: formatScimTime(record.LastModified),
+		},
+	}
+
+	if len(record.Name.GivenName) > 0 || len(record.Name.FamilyName) > 0 || len(record.Name.Formatted) > 0 {
+		name := record.Name
+		resource.Name = &name
+	}
+
+	for _, group := range getScimUserGroups(scim, userId) {
+		resource.Groups = append(resource.Groups, CslScimMember{Value: group.Id, Display: group.DisplayName})
+	}
+
+	return resource
+}
+
+func getScimGroupResource(scim CslScim, group CslScimGroup) CslScimGroupResource {
+	resource := CslScimGroupResource{
+		Schemas:     []string{ScimSchemaGroup},
+		Id:          group.Id,
+		ExternalId:  group.ExternalId,
+		DisplayName: group.DisplayName,
+		Members:     []CslScimMember{},
+		Meta: &CslScimMeta{
+			ResourceType: "Group",
+			Created:      formatScimTime(group.Created),
+			LastModified: formatScimTime(group.LastModified),
+		},
+	}
+
+	for _, member := range group.Members {
+		resource.Members = append(resource.Members, CslScimMember{Value: member, Display: scim.Users[member].UserName})
+	}
+
+	return resource
+}
+
+// Adds a user to the org with a role in that org. Members keep their role.
+// Only this org is changed: the user list is updated here instead of through
+// SetUser, which copies the users role into every org, and the users active
+// state is left to the orgs it already belongs to
+func addCslOrgMember(ctx context.Context, org *shuffle.Org, user *shuffle.User, role string) error {
+	if _, found := getCslOrgRole(org, user.Id); !found {
+		orgUser := *user
+		orgUser.Password = ""
+		orgUser.Session = ""
+		orgUser.PrivateApps = []shuffle.WorkflowApp{}
+		orgUser.Executions = shuffle.ExecutionInfo{}
+		orgUser.Limits = shuffle.UserLimits{}
+		orgUser.Authentication = []shuffle.UserAuth{}
+		orgUser.Role = role
+		orgUser.Roles = []string{role}
+		orgUser.ActiveOrg = shuffle.OrgMini{
+			Id:   org.Id,
+			Name: org.Name,
+			Role: role,
+		}
+
+		org.Users = append(org.Users, orgUser)
+		err := shuffle.SetOrg(ctx, *org, org.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	added := !shuffle.ArrayContains(user.Orgs, org.Id)
+	if added {
+		user.Orgs = append(user.Orgs, org.Id)
+	}
+
+	if len(user.ActiveOrg.Id) == 0 {
+		setCslActiveOrg(user, org)
+	}
+
+	err := shuffle.SetUser(ctx, user, false)
+	if err == nil && added {
+		emitCslLifecycleEvent(org.Id, LifecycleUserAdded, "", getLifecycleUser(*user))
+	}
+
+	return err
+}
+
+// Removes a user from the org and ends their session. Users without other
+// orgs are deactivated
+func removeCslOrgMember(ctx context.Context, org *shuffle.Org, user *shuffle.User) error {
+	orgUsers := []shuffle.User{}
+	for _, orgUser := range org.Users {
+		if orgUser.Id != user.Id {
+			orgUsers = append(orgUsers, orgUser)
+		}
+	}
+
+	if len(orgUsers) != len(org.Users) {
+		org.Users = orgUsers
+		err := shuffle.SetOrg(ctx, *org, org.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	orgs := []string{}
+	for _, orgId := range user.Orgs {
+		if orgId != org.Id {
+			orgs = append(orgs, orgId)
+		}
+	}
+
+	removed := len(orgs) != len(user.Orgs)
+	user.Orgs = orgs
+	if user.ActiveOrg.Id == org.Id {
+		user.ActiveOrg = shuffle.OrgMini{}
+		if len(orgs) > 0 {
+			user.ActiveOrg.Id = orgs[0]
+			if otherOrg, err := shuffle.GetOrg(ctx, orgs[0]); err == nil {
+				user.ActiveOrg.Name = otherOrg.Name
+			}
+		}
+	}
+
+	user.Active = len(orgs) > 0
+	err := endCslUserSession(ctx, org.Id, user)
+	if err == nil && removed {
+		emitCslLifecycleEvent(org.Id, LifecycleUserRemoved, "", getLifecycleUser(*user))
+	}
+
+	return err
+}
+
+// Applies the SSO group role mapping to users after their groups changed
+func syncCslScimRoles(ctx context.Context, orgId string, scim CslScim, userIds []string) {
+	mapping := getCslSsoRoleMapping(ctx, orgId)
+	if !mapping.Enabled {
+		return
+	}
+
+	handled := map[string]bool{}
+	for _, userId := range userIds {
+		if handled[userId] {
+			continue
+		}
+
+		handled[userId] = true
+		user, err := shuffle.GetUser(ctx, userId)
+		if err != nil || !shuffle.ArrayContains(user.Orgs, orgId) {
+			continue
+		}
+
+		groups := []string{}
+		for _, group := range getScimUserGroups(scim, userId) {
+			groups = append(groups, group.DisplayName)
+		}
+
+		err = applySsoRoleMapping(ctx, orgId, user, mapping, "SCIM", groups)
+		if err != nil {
+			log.Printf("[ERROR] Failed applying SCIM role mapping for %s: %s", user.Username, err)
+		}
+	}
+}
+
+// Returned when a username belongs to a user outside the org. Provisioning
+// for one org can't take over or change users of other orgs
+var errCslUserOfOtherOrg = errors.New("the username belongs to a user outside this org")
+
+// Finds the member with the username or creates a user for a provisioning
+// source such as SCIM or LDAP. Created users get a random password, as they
+// log in through the identity provider
+func getOrCreateProvisionedUser(ctx context.Context, org *shuffle.Org, username, loginType string) (*shuffle.User, bool, error) {
+	user, err := findSsoUser(ctx, username)
+	if err == nil {
+		if !shuffle.ArrayContains(user.Orgs, org.Id) {
+			return nil, false, errCslUserOfOtherOrg
+		}
+
+		return user, false, nil
+	}
+
+	newUser := &shuffle.User{}
+	newUser.Password = uuid.NewV4().String()
+	newUser.Id = uuid.NewV4().String()
+	newUser.Username = username
+	newUser.GeneratedUsername = username
+	newUser.Verified = true
+	newUser.Active = true
+	newUser.CreationTime = time.Now().Unix()
+	newUser.Orgs = []string{org.Id}
+	newUser.LoginType = loginType
+	newUser.Role = getCslSsoRoleMapping(ctx, org.Id).DefaultRole
+	newUser.Roles = []string{newUser.Role}
+	newUser.VerificationToken = uuid.NewV4().String()
+	newUser.ActiveOrg = shuffle.OrgMini{
+		Name: org.Name,
+		Id:   org.Id,
+		Role: newUser.Role,
+	}
+
+	err = shuffle.SetUser(ctx, newUser, true)
+	if err != nil {
+		return nil, false, err
+	}
+
+	emitCslLifecycleEvent(org.Id, LifecycleUserAdded, "", getLifecycleUser(*newUser))
+	return newUser, true, nil
+}
+
+// Finds a user known to the org, either a member or a deactivated SCIM user
+func getCslScimUser(ctx context.Context, org *shuffle.Org, scim CslScim, userId string) (*shuffle.User, bool, error) {
+	user, err := shuffle.GetUser(ctx, userId)
+	if err != nil {
+		return nil, false, errors.New("user not found")
+	}
+
+	member := shuffle.ArrayContains(user.Orgs, org.Id)
+	if _, ok := scim.Users[userId]; !ok && !member {
+		return nil, false, errors.New("user not found")
+	}
+
+	return user, member, nil
+}
+
+func parseScimUser(body []byte) (CslScimUser, error) {
+	scimUser := CslScimUser{}
+	err := json.Unmarshal(body, &scimUser)
+	if err != nil {
+		return scimUser, err
+	}
+
+	scimUser.UserName = strings.ToLower(strings.TrimSpace(scimUser.UserName))
+	if len(scimUser.UserName) == 0 {
+		return scimUser, errors.New("userName is required")
+	}
+
+	return scimUser, nil
+}
+
+// The username is shared by every org of a user, so only users of this org
+// alone can be renamed through its SCIM token
+func canRenameCslScimUser(org *shuffle.Org, user *shuffle.User) bool {
+	for _, orgId := range user.Orgs {
+		if orgId != org.Id {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Stores the attributes of a user and adds or removes them from the org
+// based on active. Returns the updated resource
+func applyCslScimUser(ctx context.Context, org *shuffle.Org, user *shuffle.User, member bool, scimUser CslScimUser) (CslScimUser, error) {
+	active := scimUser.Active == nil || *scimUser.Active
+	if scimUser.UserName != user.Username {
+		if !canRenameCslScimUser(org, user) {
+			return CslScimUser{}, errCslUserOfOtherOrg
+		}
+
+		existing, err := findSsoUser(ctx, scimUser.UserName)
+		if err == nil && existing.Id != user.Id {
+			return CslScimUser{}, errors.New(fmt.Sprintf("userName %s is already in use", scimUser.UserName))
+		}
+
+		log.Printf("[AUDIT] Changing username of user %s (%s) to %s through SCIM in org %s", user.Username, user.Id, scimUser.UserName, org.Id)
+		user.Username = scimUser.UserName
+		user.GeneratedUsername = scimUser.UserName
+		if member && active {
+			err = shuffle.SetUser(ctx, user, true)
+			if err != nil {
+				return CslScimUser{}, err
+			}
+		}
+	}
+
+	var err error
+	if active && !member {
+		err = addCslOrgMember(ctx, org, user, getCslSsoRoleMapping(ctx, org.Id).DefaultRole)
+		if err == nil && !user.Active && len(user.Orgs) == 1 {
+			// Deprovisioning deactivated the user when this org was its last
+			user.Active = true
+			err = shuffle.SetUser(ctx, user, false)
+		}
+
+		if err == nil {
+			log.Printf("[AUDIT] Added user %s (%s) to org %s through SCIM", user.Username, user.Id, org.Id)
+			recordCslActivity(ctx, org.Id, ActivityTypeAdmin, "user_provisioned", fmt.Sprintf("%s was added to the org through SCIM", user.Username), "SCIM", user.Id)
+		}
+	} else if !active && member {
+		err = removeCslOrgMember(ctx, org, user)
+		if err == nil {
+			log.Printf("[AUDIT] Deactivated user %s (%s) in org %s through SCIM", user.Username, user.Id, org.Id)
+			recordCslActivity(ctx, org.Id, ActivityTypeAdmin, "user_deprovisioned", fmt.Sprintf("%s was deactivated through SCIM", user.Username), "SCIM", user.Id)
+		}
+	}
+
+	if err != nil {
+		return CslScimUser{}, err
+	}
+
+	now := time.Now().Unix()
+	scim := CslScim{}
+	err = updateCslDocument(ctx, org.Id, CslScimDocument, &scim, func() error {
+		setScimDefaults(&scim)
+		record := scim.Users[user.Id]
+		if record.Created == 0 {
+			record.Created = now
+		}
+
+		record.UserName = user.Username
+		record.ExternalId = scimUser.ExternalId
+		record.DisplayName = scimUser.DisplayName
+		record.Name = CslScimName{}
+		if scimUser.Name != nil {
+			record.Name = *scimUser.Name
+		}
+
+		record.Emails = scimUser.Emails
+		record.Active = active
+		record.LastModified = now
+		scim.Users[user.Id] = record
+		return nil
+	})
+	if err != nil {
+		return CslScimUser{}, err
+	}
+
+	if active {
+		syncCslScimRoles(ctx, org.Id, scim, []string{user.Id})
+	}
+
+	return getScimUserResource(scim, user.Id, user.Username, active), nil
+}
+
+// Applies patch operations to a resource through its JSON form. Paths are
+// attribute names, optionally with a sub-attribute like name.givenName
+func applyScimPatch(resource interface{}, operations []CslScimPatchOperation) (map[string]interface{}, error) {
+	b, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := map[string]interface{}{}
+	err = json.Unmarshal(b, &attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return nil, errors.New(fmt.Sprintf("unsupported patch op %s", operation.Op))
+		}
+
+		var value interface{}
+		if len(operation.Value) > 0 {
+			err = json.Unmarshal(operation.Value, &value)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(operation.Path) == 0 {
+			values, ok := value.(map[string]interface{})
+			if !ok || op == "remove" {
+				return nil, errors.New("patch without path needs an object value")
+			}
+
+			for key, value := range values {
+				attributes[key] = value
+			}
+
+			continue
+		}
+
+		// Filters on multi-valued attributes, e.g. emails[type eq "work"].value, apply to the whole attribute
+		path := operation.Path
+		if index := strings.Index(path, "["); index > 0 {
+			path = path[:index]
+		}
+
+		parts := strings.SplitN(path, ".", 2)
+		if len(parts) == 2 {
+			parent, ok := attributes[parts[0]].(map[string]interface{})
+			if !ok {
+				parent = map[string]interface{}{}
+			}
+
+			if op == "remove" {
+				delete(parent, parts[1])
+			} else {
+				parent[parts[1]] = value
+			}
+
+			attributes[parts[0]] = parent
+			continue
+		}
+
+		if op == "remove" {
+			delete(attributes, path)
+		} else {
+			attributes[path] = value
+		}
+	}
+
+	// Some identity providers send booleans as strings
+	if active, ok := attributes["active"].(string); ok {
+		attributes["active"] = strings.EqualFold(active, "true")
+	}
+
+	return attributes, nil
+}
+
+/*
+SSO:
+Returns the SCIM configuration of the org. Requires org admin. Identity
+providers use /api/v1/csl/scim/v2 as the SCIM base url, with a token from
+/api/v1/csl/scim/token as the bearer token.
+
+	{
+	    "success": true,
+	    "data": {
+	        "enabled": true,
+	        "token_preview": "3f2a9c",
+	        "token_created": 1700000000,
+	        "users": 42,
+	        "groups": 3
+	    }
+	}
+*/
+func cslGetScimConfig(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	scim := getCslScim(ctx, user.ActiveOrg.Id)
+	config := CslScimConfig{
+		Enabled:      scim.Enabled,
+		TokenPreview: scim.TokenPreview,
+		TokenCreated: scim.TokenCreated,
+		Users:        len(scim.Users),
+		Groups:       len(scim.Groups),
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    config,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetScimConfig")
+}
+
+/*
+SSO:
+Enables or disables SCIM for the org with {"enabled": true}. Requires org
+admin. Provisioned users stay as they are when SCIM is disabled.
+*/
+func cslSetScimConfig(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	config := CslScimConfig{}
+	err = json.Unmarshal(body, &config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	scim := CslScim{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslScimDocument, &scim, func() error {
+		scim.Enabled = config.Enabled
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated SCIM config for org %s. Enabled: %t", user.Username, user.Id, user.ActiveOrg.Id, config.Enabled)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "scim_updated", "SCIM provisioning configuration was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetScimConfig")
+}
+
+/*
+SSO:
+Creates a new SCIM bearer token for the org, replacing the previous one.
+Requires org admin. The token is only returned here.
+
+	{
+	    "success": true,
+	    "data": {
+	        "token": "cslscim_<org id>_<secret>"
+	    }
+	}
+*/
+func cslCreateScimToken(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	data := make([]byte, 32)
+	_, err := rand.Read(data)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	secret := hex.EncodeToString(data)
+	scim := CslScim{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslScimDocument, &scim, func() error {
+		scim.TokenHash = hashApiKeySecret(secret)
+		scim.TokenPreview = secret[:6]
+		scim.TokenCreated = time.Now().Unix()
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) created a new SCIM token for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeSecurity, "scim_token_created", "A new SCIM token was created", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    CslScimToken{Token: fmt.Sprintf("%s_%s_%s", ScimTokenPrefix, user.ActiveOrg.Id, secret)},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslCreateScimToken")
+}
+
+/*
+SSO:
+SCIM service provider configuration. Supports PATCH and eq filters.
+*/
+func cslScimServiceProviderConfig(resp http.ResponseWriter, request *http.Request) {
+	if len(handleCslScimRequest(resp, request)) == 0 {
+		return
+	}
+
+	writeScimResponse(resp, 200, map[string]interface{}{
+		"schemas":        []string{ScimSchemaConfig},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": MaxScimPageSize},
+		"changePassword": map[string]bool{"supported": false},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]string{
+			{"type": "oauthbearertoken", "name": "Bearer token", "description": "Token from /api/v1/csl/scim/token"},
+		},
+	})
+}
+
+/*
+SSO:
+SCIM resource types, which are User and Group.
+*/
+func cslScimResourceTypes(resp http.ResponseWriter, request *http.Request) {
+	if len(handleCslScimRequest(resp, request)) == 0 {
+		return
+	}
+
+	resources := []interface{}{
+		map[string]interface{}{"schemas": []string{ScimSchemaResourceType}, "id": "User", "name": "User", "endpoint": "/Users", "schema": ScimSchemaUser},
+		map[string]interface{}{"schemas": []string{ScimSchemaResourceType}, "id": "Group", "name": "Group", "endpoint": "/Groups", "schema": ScimSchemaGroup},
+	}
+
+	writeScimResponse(resp, 200, getScimPage(request, resources))
+}
+
+/*
+SSO:
+Lists the users of the org, including users deactivated through SCIM.
+Supports ?filter=userName eq "..." or externalId eq "...", and paging with
+?startIndex and ?count.
+*/
+func cslScimListUsers(resp http.ResponseWriter, request *http.Request) {
+	orgId := handleCslScimRequest(resp, request)
+	if len(orgId) == 0 {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	attribute, value := "", ""
+	if filter := request.URL.Query().Get("filter"); len(filter) > 0 {
+		var err error
+		attribute, value, err = parseScimFilter(filter)
+		if err != nil {
+			writeScimError(resp, 400, "invalidFilter", err)
+			return
+		}
+	}
+
+	org, err := shuffle.GetOrg(ctx, orgId)
+	if err != nil {
+		writeScimError(resp, 500, "", err)
+		return
+	}
+
+	scim := getCslScim(ctx, orgId)
+	users := []CslScimUser{}
+	members := map[string]bool{}
+	for _, orgUser := range org.Users {
+		members[orgUser.Id] = true
+		users = append(users, getScimUserResource(scim, orgUser.Id, orgUser.Username, true))
+	}
+
+	for userId, record := range scim.Users {
+		if !members[userId] {
+			users = append(users, getScimUserResource(scim, userId, record.UserName, false))
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].UserName < users[j].UserName
+	})
+
+	resources := []interface{}{}
+	for _, scimUser := range users {
+		switch {
+		case strings.EqualFold(attribute, "userName") && !strings.EqualFold(scimUser.UserName, value):
+			continue
+		case strings.EqualFold(attribute, "externalId") && scimUser.ExternalId != value:
+			continue
+		case strings.EqualFold(attribute, "id") && scimUser.Id != value:
+			continue
+		}
+
+		resources = append(resources, scimUser)
+	}
+
+	writeScimResponse(resp, 200, getScimPage(request, resources))
+}
+
+/*
+SSO:
+Returns a user by id.
+*/
+func cslScimGetUser(resp http.ResponseWriter, request *http.Request) {
+	orgId := handleCslScimRequest(resp, request)
+	if len(orgId) == 0 {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	org, err := shuffle.GetOrg(ctx, orgId)
+	if err != nil {
+		writeScimError(resp, 500, "", err)
+		return
+	}
+
+	scim := getCslScim(ctx, orgId)
+	user, member, err := getCslScimUser(ctx, org, scim, mux.Vars(request)["key"])
+	if err != nil {
+		writeScimError(resp, 404, "", err)
+		return
+	}
+
+	writeScimResponse(resp, 200, getScimUserResource(scim, user.Id, user.Username, member))
+}
+
+/*
+SSO:
+Provisions a user in the org. Existing members with the same username are
+linked instead of being created again. Usernames of users outside the org
+return 409.
+*/
+func cslScimCreateUser(resp http.ResponseWriter, request *http.Request) {
+	orgId := handleCslScimRequest(resp, request)
+	if len(orgId) == 0 {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		writeScimError(resp, 400, "", err)
+		return
+	}
+
+	scimUser, err := parseScimUser(body)
+	if err != nil {
+		writeScimError(resp, 400, "invalidValue", err)
+		return
+	}
+
+	org, err := shuffle.GetOrg(ctx, orgId)
+	if err != nil {
+		writeScimError(resp, 500, "", err)
+		return
+	}
+
+	user, created, err := getOrCreateProvisionedUser(ctx, org, scimUser.UserName, "SSO")
+	if err == errCslUserOfOtherOrg {
+		writeScimError(resp, 409, "uniqueness", err)
+		return
+	} else if err != nil {
+		writeScimError(resp, 500, "", err)
+		return
+	}
+
+	scim := getCslScim(ctx, orgId)
+	if _, ok := scim.Users[user.Id]; ok {
+		writeScimError(resp, 409, "uniqueness", errors.New(fmt.Sprintf("user %s already exists", scimUser.UserName)))
+		return
+	}
+
+	if created {
+		log.Printf("[AUDIT] Created user %s (%s) in org %s through SCIM", user.Username, user.Id, orgId)
+		recordCslActivity(ctx, orgId, ActivityTypeAdmin, "user_provisioned", fmt.Sprintf("%s was created through SCIM", user.Username), "SCIM", user.Id)
+	}
+
+	resource, err := applyCslScimUser(ctx, org, user, shuffle.ArrayContains(user.Orgs, orgId), scimUser)
+	if err != nil {
+		writeCslScimApplyError(resp, 500, err)
+		return
+	}
+
+	writeScimResponse(resp, 201, resource)
+}
+
+/*
+SSO:
+Replaces the attributes of a user. Setting active to false removes the user
+from the org and ends their session, and setting it to true adds them again.
+Changing the userName of a user who also belongs to other orgs returns 409.
+*/
+func cslScimReplaceUser(resp http.ResponseWriter, request *http.Request) {
+	orgId := handleCslScimRequest(resp, request)
+	if len(orgId) == 0 {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		writeScimError(resp, 400, "", err)
+		return
+	}
+
+	scimUser, err := parseScimUser(body)
+	if err != nil {
+		writeScimError(resp, 400, "invalidValue", err)
+		return
+	}
+
+	org, err := shuffle.GetOrg(ctx, orgId)
+	if err != nil {
+		writeScimError(resp, 500, "", err)
+		return
+	}
+
+	user, member, err := getCslScimUser(ctx, org, getCslScim(ctx, orgId), mux.Vars(request)["key"])
+	if err != nil {
+		writeScimError(resp, 404, "", err)
+		return
+	}
+
+	resource, err := applyCslScimUser(ctx, org, user, member, scimUser)
+	if err != nil {
+		writeCslScimApplyError(resp, 400, err)
+		return
+	}
+
+	writeScimResponse(resp, 200, resource)
+}
+
+/*
+SSO:
+Updates a user with SCIM patch operations, e.g.
+{"Operations": [{"op": "replace", "path": "active", "value": false}]}
+*/
+func cslScimPatchUser(resp http.ResponseWriter, request *http.Request) {
+	orgId := handleCslScimRequest(resp, request)
+	if len(orgId) == 0 {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		writeScimError(resp, 400, "", err)
+		return
+	}
+
+	patch := CslScimPatchRequest{}
+	err = json.Unmarshal(body, &patch)
+	if err != nil {
+		writeScimError(resp, 400, "invalidSyntax", err)
+		return
+	}
+
+	org, err := shuffle.GetOrg(ctx, orgId)
+	if err != nil {
+		writeScimError(resp, 500, "", err)
+		return
+	}
+
+	scim := getCslScim(ctx, orgId)
+	user, member, err := getCslScimUser(ctx, org, scim, mux.Vars(request)["key"])
+	if err != nil {
+		writeScimError(resp, 404, "", err)
+		return
+	}
+
+	current := getScimUserResource(scim, user.Id, user.Username, member)
+	current.Groups = nil
+	current.Meta = nil
+
+	attributes, err := applyScimPatch(current, patch.Operations)
+	if err != nil {
+		writeScimError(resp, 400, "invalidPath", err)
+		return
+	}
+
+	b, err := json.Marshal(attributes)
+	if err != nil {
+		writeScimError(resp, 500, "", err)
+		return
+	}
+
+	scimUser, err := parseScimUser(b)
+	if err != nil {
+		writeScimError(resp, 400, "invalidValue", err)
+		return
+	}
+
+	resource, err := applyCslScimUser(ctx, org, user, member, scimUser)
+	if err != nil {
+		writeCslScimApplyError(resp, 400, err)
+		return
+	}
+
+	writeScimResponse(resp, 200, resource)
+}
+
+/*
+SSO:
+Deprovisions a user. The user is removed from the org and its groups, and
+their session is ended. The Shuffle user is kept for other orgs.
+*/
+func cslScimDeleteUser(resp http.ResponseWriter, request *http.Request) {
+	orgId := handleCslScimRequest(resp, request)
+	if len(orgId) == 0 {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	org, err := shuffle.GetOrg(ctx, orgId)
+	if err != nil {
+		writeScimError(resp, 500, "", err)
+		return
+	}
+
+	user, member, err := getCslScimUser(ctx, org, getCslScim(ctx, orgId), mux.Vars(request)["key"])
+	if err != nil {
+		writeScimError(resp, 404, "", err)
+		return
+	}
+
+	if member {
+		err = removeCslOrgMember(ctx, org, user)
+		if err != nil {
+			writeScimError(resp, 500, "", err)
+			return
+		}
+	}
+
+	scim := CslScim{}
+	err = updateCslDocument(ctx, orgId, CslScimDocument, &scim, func() error {
+		setScimDefaults(&scim)
+		delete(scim.Users, user.Id)
+		for id, group := range scim.Groups {
+			members := []string{}
+			for _, member := range group.Members {
+				if member != user.Id {
+					members = append(members, member)
+				}
+			}
+
+			group.Members = members
+			scim.Groups[id] = group
+		}
+
+		return nil
+	})
+	if err != nil {
+		writeScimError(resp, 500, "", err)
+		return
+	}
+
+	log.Printf("[AUDIT] Deprovisioned user %s (%s) from org %s through SCIM", user.Username, user.Id, orgId)
+	recordCslActivity(ctx, orgId, ActivityTypeAdmin, "user_deprovisioned", fmt.Sprintf("%s was removed from the org through SCIM", user.Username), "SCIM", user.Id)
+
+	resp.WriteHeader(204)
+}
+
+// Keeps the members that are users of the org
+func getScimGroupMembers(org *shuffle.Org, members []CslScimMember) []string {
+	orgUsers := map[string]bool{}
+	for _, orgUser := range org.Users {
+		orgUsers[orgUser.Id] = true
+	}
+
+	memberIds := []string{}
+	for _, member := range members {
+		if !orgUsers[member.Value] {
+			log.Printf("[WARNING] Skipping SCIM group member %s, which isn't a user of org %s", member.Value, org.Id)
+			continue
+		}
+
+		if !shuffle.ArrayContains(memberIds, member.Value) {
+			memberIds = append(memberIds, member.Value)
+		}
+	}
+
+	return memberIds
+}
+
+// Stores a group and applies role changes to users who joined or left it
+func storeCslScimGroup(ctx context.Context, orgId string, group CslScimGroup, previousMembers []string) (CslScim, error) {
+	scim := CslScim{}
+	err := updateCslDocument(ctx, orgId, CslScimDocument, &scim, func() error {
+		setScimDefaults(&scim)
+		for id, existing := range scim.Groups {
+			if id != group.Id && strings.EqualFold(existing.DisplayName, group.DisplayName) {
+				return errors.New(fmt.Sprintf("group %s already exists", group.DisplayName))
+			}
+		}
+
+		scim.Groups[group.Id] = group
+		return nil
+	})
+	if err != nil {
+		return scim, err
+	}
+
+	syncCslScimRoles(ctx, orgId, scim, append(previousMembers, group.Members...))
+	return scim, nil
+}
+
+func parseScimGroup(body []byte) (CslScimGroupResource, error) {
+	resource := CslScimGroupResource{}
+	err := json.Unmarshal(body, &resource)
+	if err != nil {
+		return resource, err
+	}
+
+	resource.DisplayName = strings.TrimSpace(resource.DisplayName)
+	if len(resource.DisplayName) == 0 {
+		return resource, errors.New("displayName is required")
+	}
+
+	return resource, nil
+}
+
+/*
+SSO:
+Lists the groups of the org. Supports ?filter=displayName eq "..." and paging
+with ?startIndex and ?count.
+*/
+func cslScimListGroups(resp http.ResponseWriter, request *http.Request) {
+	orgId := handleCslScimRequest(resp, request)
+	if len(orgId) == 0 {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	attribute, value := "", ""
+	if filter := request.URL.Query().Get("filter"); len(filter) > 0 {
+		var err error
+		attribute, value, err = parseScimFilter(filter)
+		if err != nil {
+			writeScimError(resp, 400, "invalidFilter", err)
+			return
+		}
+	}
+
+	scim := getCslScim(ctx, orgId)
+	groups := []CslScimGroup{}
+	for _, group := range scim.Groups {
+		switch {
+		case strings.EqualFold(attribute, "displayName") && !strings.EqualFold(group.DisplayName, value):
+			continue
+		case strings.EqualFold(attribute, "externalId") && group.ExternalId != value:
+			continue
+		case strings.EqualFold(attribute, "id") && group.Id != value:
+			continue
+		}
+
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].DisplayName < groups[j].DisplayName
+	})
+
+	resources := []interface{}{}
+	for _, group := range groups {
+		resources = append(resources, getScimGroupResource(scim, group))
+	}
+
+	writeScimResponse(resp, 200, getScimPage(request, resources))
+}
+
+/*
+SSO:
+Returns a group by id.
+*/
+func cslScimGetGroup(resp http.ResponseWriter, request *http.Request) {
+	orgId := handleCslScimRequest(resp, request)
+	if len(orgId) == 0 {
+		return
+	}
+
+	scim := getCslScim(shuffle.GetContext(request), orgId)
+	group, ok := scim.Groups[mux.Vars(request)["key"]]
+	if !ok {
+		writeScimError(resp, 404, "", errors.New("group not found"))
+		return
+	}
+
+	writeScimResponse(resp, 200, getScimGroupResource(scim, group))
+}
+
+/*
+SSO:
+Creates a group. Members get the role the SSO group role mapping gives the
+group name, if role mapping is enabled.
+*/
+func cslScimCreateGroup(resp http.ResponseWriter, request *http.Request) {
+	orgId := handleCslScimRequest(resp, request)
+	if len(orgId) == 0 {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		writeScimError(resp, 400, "", err)
+		return
+	}
+
+	resource, err := parseScimGroup(body)
+	if err != nil {
+		writeScimError(resp, 400, "invalidValue", err)
+		return
+	}
+
+	org, err := shuffle.GetOrg(ctx, orgId)
+	if err != nil {
+		writeScimError(resp, 500, "", err)
+		return
+	}
+
+	now := time.Now().Unix()
+	group := CslScimGroup{
+		Id:           uuid.NewV4().String(),
+		DisplayName:  resource.DisplayName,
+		ExternalId:   resource.ExternalId,
+		Members:      getScimGroupMembers(org, resource.Members),
+		Created:      now,
+		LastModified: now,
+	}
+
+	scim, err := storeCslScimGroup(ctx, orgId, group, []string{})
+	if err != nil {
+		writeScimError(resp, 409, "uniqueness", err)
+		return
+	}
+
+	log.Printf("[AUDIT] Created group %s (%s) in org %s through SCIM", group.DisplayName, group.Id, orgId)
+	writeScimResponse(resp, 201, getScimGroupResource(scim, group))
+}
+
+/*
+SSO:
+Replaces the name and members of a group.
+*/
+func cslScimReplaceGroup(resp http.ResponseWriter, request *http.Request) {
+	orgId := handleCslScimRequest(resp, request)
+	if len(orgId) == 0 {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		writeScimError(resp, 400, "", err)
+		return
+	}
+
+	resource, err := parseScimGroup(body)
+	if err != nil {
+		writeScimError(resp, 400, "invalidValue", err)
+		return
+	}
+
+	org, err := shuffle.GetOrg(ctx, orgId)
+	if err != nil {
+		writeScimError(resp, 500, "", err)
+		return
+	}
+
+	group, ok := getCslScim(ctx, orgId).Groups[mux.Vars(request)["key"]]
+	if !ok {
+		writeScimError(resp, 404, "", errors.New("group not found"))
+		return
+	}
+
+	previousMembers := group.Members
+	group.DisplayName = resource.DisplayName
+	group.ExternalId = resource.ExternalId
+	group.Members = getScimGroupMembers(org, resource.Members)
+	group.LastModified = time.Now().Unix()
+
+	scim, err := storeCslScimGroup(ctx, orgId, group, previousMembers)
+	if err != nil {
+		writeScimError(resp, 409, "uniqueness", err)
+		return
+	}
+
+	writeScimResponse(resp, 200, getScimGroupResource(scim, group))
+}
+
+/*
+SSO:
+Updates a group with SCIM patch operations. Members are added with
+{"op": "add", "path": "members", "value": [{"value": "<user id>"}]} and
+removed with {"op": "remove", "path": "members[value eq \"<user id>\"]"}.
+*/
+func cslScimPatchGroup(resp http.ResponseWriter, request *http.Request) {
+	orgId := handleCslScimRequest(resp, request)
+	if len(orgId) == 0 {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		writeScimError(resp, 400, "", err)
+		return
+	}
+
+	patch := CslScimPatchRequest{}
+	err = json.Unmarshal(body, &patch)
+	if err != nil {
+		writeScimError(resp, 400, "invalidSyntax", err)
+		return
+	}
+
+	org, err := shuffle.GetOrg(ctx, orgId)
+	if err != nil {
+		writeScimError(resp, 500, "", err)
+		return
+	}
+
+	group, ok := getCslScim(ctx, orgId).Groups[mux.Vars(request)["key"]]
+	if !ok {
+		writeScimError(resp, 404, "", errors.New("group not found"))
+		return
+	}
+
+	previousMembers := group.Members
+	members := append([]string{}, group.Members...)
+	for _, operation := range patch.Operations {
+		op := strings.ToLower(operation.Op)
+		path := strings.TrimSpace(operation.Path)
+
+		values := []CslScimMember{}
+		if len(operation.Value) > 0 && strings.HasPrefix(strings.TrimSpace(string(operation.Value)), "[") {
+			err = json.Unmarshal(operation.Value, &values)
+			if err != nil {
+				writeScimError(resp, 400, "invalidValue", err)
+				return
+			}
+		}
+
+		switch {
+		case len(path) == 0 && op != "remove":
+			resource := CslScimGroupResource{}
+			err = json.Unmarshal(operation.Value, &resource)
+			if err != nil {
+				writeScimError(resp, 400, "invalidValue", err)
+				return
+			}
+
+			if len(resource.DisplayName) > 0 {
+				group.DisplayName = resource.DisplayName
+			}
+
+			if resource.Members != nil {
+				members = getScimGroupMembers(org, resource.Members)
+			}
+		case strings.EqualFold(path, "displayName") && op != "remove":
+			err = json.Unmarshal(operation.Value, &group.DisplayName)
+		case strings.EqualFold(path, "externalId"):
+			group.ExternalId = ""
+			if op != "remove" {
+				err = json.Unmarshal(operation.Value, &group.ExternalId)
+			}
+		case strings.EqualFold(path, "members") && op == "add":
+			for _, member := range getScimGroupMembers(org, values) {
+				if !shuffle.ArrayContains(members, member) {
+					members = append(members, member)
+				}
+			}
+		case strings.EqualFold(path, "members") && op == "replace":
+			members = getScimGroupMembers(org, values)
+		case strings.HasPrefix(strings.ToLower(path), "members") && op == "remove":
+			removed := []string{}
+			for _, value := range values {
+				removed = append(removed, value.Value)
+			}
+
+			if index := strings.Index(path, "["); index > 0 {
+				_, value, filterErr := parseScimFilter(strings.TrimSuffix(path[index+1:], "]"))
+				if filterErr != nil {
+					writeScimError(resp, 400, "invalidFilter", filterErr)
+					return
+				}
+
+				removed = append(removed, value)
+			} else if len(values) == 0 {
+				removed = members
+			}
+
+			remaining := []string{}
+			for _, member := range members {
+				if !shuffle.ArrayContains(removed, member) {
+					remaining = append(remaining, member)
+				}
+			}
+
+			members = remaining
+		default:
+			writeScimError(resp, 400, "invalidPath", errors.New(fmt.Sprintf("unsupported patch of %s with op %s", path, operation.Op)))
+			return
+		}
+
+		if err != nil {
+			writeScimError(resp, 400, "invalidValue", err)
+			return
+		}
+	}
+
+	group.Members = members
+	group.LastModified = time.Now().Unix()
+
+	scim, err := storeCslScimGroup(ctx, orgId, group, previousMembers)
+	if err != nil {
+		writeScimError(resp, 409, "uniqueness", err)
+		return
+	}
+
+	writeScimResponse(resp, 200, getScimGroupResource(scim, group))
+}
+
+/*
+SSO:
+Deletes a group. Roles of its members are mapped again without it.
+*/
+func cslScimDeleteGroup(resp http.ResponseWriter, request *http.Request) {
+	orgId := handleCslScimRequest(resp, request)
+	if len(orgId) == 0 {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	groupId := mux.Vars(request)["key"]
+
+	members := []string{}
+	scim := CslScim{}
+	err := updateCslDocument(ctx, orgId, CslScimDocument, &scim, func() error {
+		setScimDefaults(&scim)
+		group, ok := scim.Groups[groupId]
+		if !ok {
+			return errors.New("group not found")
+		}
+
+		members = group.Members
+		delete(scim.Groups, groupId)
+		return nil
+	})
+	if err != nil {
+		writeScimError(resp, 404, "", err)
+		return
+	}
+
+	syncCslScimRoles(ctx, orgId, scim, members)
+	log.Printf("[AUDIT] Deleted group %s in org %s through SCIM", groupId, orgId)
+
+	resp.WriteHeader(204)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+func TestParseScimFilter(t *testing.T) {
+	tests := []struct {
+		filter    string
+		attribute string
+		value     string
+		valid     bool
+	}{
+		{filter: `userName eq "analyst@example.com"`, attribute: "userName", value: "analyst@example.com", valid: true},
+		{filter: `  displayName EQ "SOC analysts"  `, attribute: "displayName", value: "SOC analysts", valid: true},
+		{filter: `externalId eq abc`, attribute: "externalId", value: "abc", valid: true},
+		{filter: `userName sw "analyst"`},
+		{filter: `userName eq`},
+		{filter: ``},
+	}
+
+	for _, test := range tests {
+		attribute, value, err := parseScimFilter(test.filter)
+		if (err == nil) != test.valid {
+			t.Errorf("parseScimFilter(%q) returned %v, expected valid=%t", test.filter, err, test.valid)
+			continue
+		}
+
+		if attribute != test.attribute || value != test.value {
+			t.Errorf("parseScimFilter(%q) = %q, %q, expected %q, %q", test.filter, attribute, value, test.attribute, test.value)
+		}
+	}
+}
+
+func TestGetScimPage(t *testing.T) {
+	resources := []interface{}{}
+	for i := 1; i <= 5; i++ {
+		resources = append(resources, i)
+	}
+
+	tests := []struct {
+		query      string
+		startIndex int
+		expected   []interface{}
+	}{
+		{query: "", startIndex: 1, expected: []interface{}{1, 2, 3, 4, 5}},
+		{query: "?startIndex=2&count=2", startIndex: 2, expected: []interface{}{2, 3}},
+		{query: "?startIndex=4&count=10", startIndex: 4, expected: []interface{}{4, 5}},
+		{query: "?startIndex=6", startIndex: 6, expected: []interface{}{}},
+		{query: "?startIndex=0", startIndex: 1, expected: []interface{}{1, 2, 3, 4, 5}},
+		{query: "?startIndex=-3&count=1", startIndex: 1, expected: []interface{}{1}},
+		{query: "?count=0", startIndex: 1, expected: []interface{}{}},
+		{query: "?count=abc", startIndex: 1, expected: []interface{}{1, 2, 3, 4, 5}},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/api/v1/csl/scim/v2/Users"+test.query, nil)
+		page := getScimPage(request, resources)
+		if page.StartIndex != test.startIndex || page.TotalResults != len(resources) || page.ItemsPerPage != len(test.expected) {
+			t.Errorf("%s: got start %d, total %d and %d items", test.query, page.StartIndex, page.TotalResults, page.ItemsPerPage)
+		}
+
+		if !reflect.DeepEqual(page.Resources, test.expected) {
+			t.Errorf("%s: got %v, expected %v", test.query, page.Resources, test.expected)
+		}
+	}
+}
+
+func TestParseScimUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		userName string
+		valid    bool
+	}{
+		{name: "valid", body: `{"userName": " Analyst@Example.com ", "active": true}`, userName: "analyst@example.com", valid: true},
+		{name: "missing userName", body: `{"displayName": "Analyst"}`},
+		{name: "blank userName", body: `{"userName": "   "}`},
+		{name: "invalid json", body: `{"userName": `},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scimUser, err := parseScimUser([]byte(test.body))
+			if (err == nil) != test.valid {
+				t.Fatalf("parseScimUser returned %v, expected valid=%t", err, test.valid)
+			}
+
+			if test.valid && scimUser.UserName != test.userName {
+				t.Errorf("got userName %q, expected %q", scimUser.UserName, test.userName)
+			}
+		})
+	}
+}
+
+func TestParseScimGroup(t *testing.T) {
+	group, err := parseScimGroup([]byte(`{"displayName": " SOC ", "members": [{"value": "u1"}]}`))
+	if err != nil || group.DisplayName != "SOC" || len(group.Members) != 1 {
+		t.Errorf("got %+v, %v", group, err)
+	}
+
+	for _, body := range []string{`{"displayName": ""}`, `{}`, `[]`} {
+		_, err := parseScimGroup([]byte(body))
+		if err == nil {
+			t.Errorf("parseScimGroup(%s) succeeded, expected an error", body)
+		}
+	}
+}
+
+func TestApplyScimPatch(t *testing.T) {
+	active := true
+	resource := CslScimUser{
+		Schemas:     []string{ScimSchemaUser},
+		UserName:    "analyst@example.com",
+		DisplayName: "Analyst",
+		Name:        &CslScimName{GivenName: "Ana", FamilyName: "Lyst"},
+		Active:      &active,
+	}
+
+	tests := []struct {
+		name       string
+		operations string
+		check      map[string]interface{}
+		valid      bool
+	}{
+		{
+			name:       "replace attribute",
+			operations: `[{"op": "Replace", "path": "displayName", "value": "Lead"}]`,
+			check:      map[string]interface{}{"displayName": "Lead"},
+			valid:      true,
+		},
+		{
+			name:       "replace without path",
+			operations: `[{"op": "replace", "value": {"active": false, "displayName": "Gone"}}]`,
+			check:      map[string]interface{}{"active": false, "displayName": "Gone"},
+			valid:      true,
+		},
+		{
+			name:       "string boolean",
+			operations: `[{"op": "replace", "path": "active", "value": "False"}]`,
+			check:      map[string]interface{}{"active": false},
+			valid:      true,
+		},
+		{
+			name:       "sub attribute",
+			operations: `[{"op": "replace", "path": "name.givenName", "value": "Anna"}]`,
+			check:      map[string]interface{}{"name": map[string]interface{}{"givenName": "Anna", "familyName": "Lyst"}},
+			valid:      true,
+		},
+		{
+			name:       "remove sub attribute",
+			operations: `[{"op": "remove", "path": "name.familyName"}]`,
+			check:      map[string]interface{}{"name": map[string]interface{}{"givenName": "Ana"}},
+			valid:      true,
+		},
+		{
+			name:       "filtered path",
+			operations: `[{"op": "add", "path": "emails[type eq \"work\"].value", "value": [{"value": "a@example.com"}]}]`,
+			check:      map[string]interface{}{"emails": []interface{}{map[string]interface{}{"value": "a@example.com"}}},
+			valid:      true,
+		},
+		{
+			name:       "remove attribute",
+			operations: `[{"op": "remove", "path": "displayName"}]`,
+			check:      map[string]interface{}{"displayName": nil},
+			valid:      true,
+		},
+		{
+			name:       "unsupported op",
+			operations: `[{"op": "move", "path": "displayName", "value": "x"}]`,
+		},
+		{
+			name:       "remove without path",
+			operations: `[{"op": "remove", "value": {"displayName": "x"}}]`,
+		},
+		{
+			name:       "no path and no object",
+			operations: `[{"op": "replace", "value": "x"}]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			operations := []CslScimPatchOperation{}
+			err := json.Unmarshal([]byte(test.operations), &operations)
+			if err != nil {
+				t.Fatalf("failed parsing operations: %s", err)
+			}
+
+			attributes, err := applyScimPatch(resource, operations)
+			if (err == nil) != test.valid {
+				t.Fatalf("applyScimPatch returned %v, expected valid=%t", err, test.valid)
+			}
+
+			for key, expected := range test.check {
+				if !reflect.DeepEqual(attributes[key], expected) {
+					t.Errorf("got %s = %v, expected %v", key, attributes[key], expected)
+				}
+			}
+
+			if test.valid && attributes["userName"] != "analyst@example.com" {
+				t.Errorf("userName was changed to %v", attributes["userName"])
+			}
+		})
+	}
+}
+
+func TestGetScimGroupMembers(t *testing.T) {
+	org := &shuffle.Org{
+		Id:    "org",
+		Users: []shuffle.User{{Id: "u1"}, {Id: "u2"}},
+	}
+
+	members := getScimGroupMembers(org, []CslScimMember{{Value: "u1"}, {Value: "outsider"}, {Value: "u2"}, {Value: "u1"}})
+	if !reflect.DeepEqual(members, []string{"u1", "u2"}) {
+		t.Errorf("got members %v, expected u1 and u2", members)
+	}
+}
+
+func TestGetScimUserResource(t *testing.T) {
+	scim := CslScim{
+		Users: map[string]CslScimUserRecord{
+			"u1": {UserName: "a@example.com", Active: true, Name: CslScimName{GivenName: "A"}},
+			"u2": {UserName: "b@example.com", Active: false},
+		},
+		Groups: map[string]CslScimGroup{
+			"g2": {Id: "g2", DisplayName: "Responders", Members: []string{"u1"}},
+			"g1": {Id: "g1", DisplayName: "Analysts", Members: []string{"u1", "u2"}},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		userId string
+		member bool
+		active bool
+		groups []CslScimMember
+	}{
+		{name: "active member", userId: "u1", member: true, active: true, groups: []CslScimMember{{Value: "g1", Display: "Analysts"}, {Value: "g2", Display: "Responders"}}},
+		{name: "removed from org", userId: "u1", member: false, active: false, groups: []CslScimMember{{Value: "g1", Display: "Analysts"}, {Value: "g2", Display: "Responders"}}},
+		{name: "deactivated", userId: "u2", member: true, active: false, groups: []CslScimMember{{Value: "g1", Display: "Analysts"}}},
+		{name: "not provisioned", userId: "u3", member: true, active: true, groups: []CslScimMember{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resource := getScimUserResource(scim, test.userId, "user", test.member)
+			if resource.Active == nil || *resource.Active != test.active {
+				t.Errorf("got active %v, expected %t", resource.Active, test.active)
+			}
+
+			if !reflect.DeepEqual(resource.Groups, test.groups) {
+				t.Errorf("got groups %v, expected %v", resource.Groups, test.groups)
+			}
+		})
+	}
+}
+
+// Malformed tokens are refused before the org is looked up
+func TestHandleCslScimRequestMalformedToken(t *testing.T) {
+	tests := []string{
+		"",
+		"Bearer ",
+		"Bearer cslscim_org",
+		"Bearer apikey_org_secret",
+		"Bearer cslscim_org_secret_extra",
+		"Basic dXNlcjpwYXNz",
+	}
+
+	for _, authorization := range tests {
+		request := httptest.NewRequest("GET", "/api/v1/csl/scim/v2/Users", nil)
+		request.Header.Set("Authorization", authorization)
+		resp := httptest.NewRecorder()
+
+		orgId := handleCslScimRequest(resp, request)
+		if len(orgId) > 0 || resp.Code != 401 {
+			t.Errorf("%q: got org %q and status %d, expected 401", authorization, orgId, resp.Code)
+		}
+	}
+}
+
+func TestCanRenameCslScimUser(t *testing.T) {
+	org := &shuffle.Org{Id: "org"}
+	tests := []struct {
+		name  string
+		orgs  []string
+		valid bool
+	}{
+		{name: "only this org", orgs: []string{"org"}, valid: true},
+		{name: "deprovisioned", orgs: []string{}, valid: true},
+		{name: "other org too", orgs: []string{"org", "other"}},
+		{name: "other org only", orgs: []string{"other"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := canRenameCslScimUser(org, &shuffle.User{Id: "user", Orgs: test.orgs})
+			if result != test.valid {
+				t.Errorf("got %t, expected %t", result, test.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Selects where the values of app authentications of an org are stored. The
+// backends themselves are in shuffle-shared secrets.go, and Vault is set up
+// with the SHUFFLE_VAULT_* variables.
+
+type CslSecretsBackendStatus struct {
+	Backend   string         `json:"backend"`
+	Available []string       `json:"available"`
+	Auths     map[string]int `json:"auths"`
+}
+
+type CslSecretsBackendRequest struct {
+	Backend string `json:"backend"`
+	Migrate bool   `json:"migrate"`
+}
+
+type CslSecretsBackendMigration struct {
+	Backend string   `json:"backend"`
+	Moved   int      `json:"moved"`
+	Failed  []string `json:"failed"`
+}
+
+// Backends the instance can use. Vault is only listed once it can be reached
+func getAvailableSecretsBackends() []string {
+	available := []string{}
+	for _, name := range shuffle.SecretsBackends {
+		if name != shuffle.SecretsBackendDatastore {
+			if _, err := shuffle.GetSecretsBackend(name); err != nil {
+				continue
+			}
+		}
+
+		available = append(available, name)
+	}
+
+	return available
+}
+
+func getSecretsBackendName(name string) string {
+	if len(name) == 0 {
+		return shuffle.SecretsBackendDatastore
+	}
+
+	return name
+}
+
+/*
+Secrets backend:
+Returns where app authentication values of the org are stored, the
+backends available and how many auths are stored in each. Auths created
+before the backend was changed stay where they are until saved again or
+migrated.
+
+	{
+	    "success": true,
+	    "data": {
+	        "backend": "vault",
+	        "available": ["datastore", "vault"],
+	        "auths": {
+	            "datastore": 2,
+	            "vault": 14
+	        }
+	    }
+	}
+*/
+func cslGetSecretsBackend(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	auths, err := shuffle.GetAllWorkflowAppAuth(ctx, org.Id)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting app auths for org %s: %s", org.Id, err)
+	}
+
+	status := CslSecretsBackendStatus{
+		Backend:   getSecretsBackendName(org.SecretsBackend),
+		Available: getAvailableSecretsBackends(),
+		Auths:     map[string]int{},
+	}
+
+	for _, auth := range auths {
+		if auth.OrgId == org.Id {
+			status.Auths[getSecretsBackendName(auth.SecretsBackend)] += 1
+		}
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    status,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetSecretsBackend")
+}
+
+/*
+Secrets backend:
+Sets where app authentication values of the org are stored. New and updated
+auths are stored in the backend. With migrate, every auth of the org is
+moved right away, and auths that couldn't be moved are returned.
+
+	{
+	    "backend": "vault",
+	    "migrate": true
+	}
+*/
+func cslSetSecretsBackend(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	backendRequest := CslSecretsBackendRequest{}
+	err = json.Unmarshal(body, &backendRequest)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	backendRequest.Backend = getSecretsBackendName(backendRequest.Backend)
+	if !shuffle.ArrayContains(shuffle.SecretsBackends, backendRequest.Backend) {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("backend must be one of %v", shuffle.SecretsBackends))))
+		return
+	}
+
+	if backendRequest.Backend != shuffle.SecretsBackendDatastore {
+		_, err = shuffle.GetSecretsBackend(backendRequest.Backend)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("%s isn't available: %s", backendRequest.Backend, err))))
+			return
+		}
+	}
+
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	previousBackend := getSecretsBackendName(org.SecretsBackend)
+	org.SecretsBackend = backendRequest.Backend
+	err = shuffle.SetOrg(ctx, *org, org.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) changed the secrets backend of org %s from %s to %s", user.Username, user.Id, org.Id, previousBackend, backendRequest.Backend)
+	recordCslActivity(ctx, org.Id, ActivityTypeSecurity, "secrets_backend_changed", fmt.Sprintf("App authentication values are now stored in %s", backendRequest.Backend), user.Username, "")
+
+	migration := CslSecretsBackendMigration{
+		Backend: backendRequest.Backend,
+		Failed:  []string{},
+	}
+
+	if backendRequest.Migrate {
+		auths, err := shuffle.GetAllWorkflowAppAuth(ctx, org.Id)
+		if err != nil {
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		for _, auth := range auths {
+			if auth.OrgId != org.Id || getSecretsBackendName(auth.SecretsBackend) == backendRequest.Backend {
+				continue
+			}
+
+			// Saving the auth stores it in the backend of the org
+			err = shuffle.SetWorkflowAppAuthDatastore(ctx, auth, auth.Id)
+			if err != nil {
+				log.Printf("[ERROR] Failed moving app auth %s of org %s to %s: %s", auth.Id, org.Id, backendRequest.Backend, err)
+				migration.Failed = append(migration.Failed, auth.Id)
+				continue
+			}
+
+			migration.Moved += 1
+		}
+
+		log.Printf("[INFO] Moved %d app auths of org %s to %s. %d failed", migration.Moved, org.Id, backendRequest.Backend, len(migration.Failed))
+	}
+
+	res := CslResponse{
+		Success: len(migration.Failed) == 0,
+		Data:    migration,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetSecretsBackend")
+}
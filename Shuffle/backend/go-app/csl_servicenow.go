@@ -0,0 +1,845 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslServiceNowDocument = "servicenow"
+const CslServiceNowIncidentsDocument = "servicenow_incidents"
+
+const ServiceNowSyncMinutes = 10
+
+// Max amount of linked incidents kept per org. Oldest resolved incidents are dropped first
+const MaxServiceNowIncidents = 500
+const MaxServiceNowNoteLength = 10000
+
+// Incident states of the ServiceNow incident table
+const (
+	ServiceNowStateNew        = "1"
+	ServiceNowStateInProgress = "2"
+	ServiceNowStateOnHold     = "3"
+	ServiceNowStateResolved   = "6"
+	ServiceNowStateClosed     = "7"
+	ServiceNowStateCanceled   = "8"
+)
+
+var serviceNowStateNames = map[string]string{
+	ServiceNowStateNew:        "new",
+	ServiceNowStateInProgress: "in_progress",
+	ServiceNowStateOnHold:     "on_hold",
+	ServiceNowStateResolved:   "resolved",
+	ServiceNowStateClosed:     "closed",
+	ServiceNowStateCanceled:   "canceled",
+}
+
+// Urgency and impact of incidents by severity. 1 is high and 3 is low
+var serviceNowSeverityLevels = map[string]string{
+	SeverityInfo:     "3",
+	SeverityWarning:  "2",
+	SeverityCritical: "1",
+}
+
+// InstanceUrl is the url of the instance, such as https://example.service-now.com,
+// and the user needs the itil role. Sources are alert events or "execution",
+// with "*" matching all of them. ShortDescription, Description and the values
+// of Fields are templates, see renderNotificationTemplate. CloseCode is used
+// when an incident is resolved from Shuffle and has to exist on the instance
+type CslServiceNowConfig struct {
+	Enabled          bool              `json:"enabled"`
+	InstanceUrl      string            `json:"instance_url"`
+	Username         string            `json:"username"`
+	Password         string            `json:"password"`
+	Sources          []string          `json:"sources"`
+	AssignmentGroup  string            `json:"assignment_group"`
+	Category         string            `json:"category"`
+	ShortDescription string            `json:"short_description"`
+	Description      string            `json:"description"`
+	Fields           map[string]string `json:"fields"`
+	CloseCode        string            `json:"close_code"`
+}
+
+type CslServiceNowState struct {
+	LastSync  int64  `json:"last_sync"`
+	LastError string `json:"last_error"`
+}
+
+type CslServiceNow struct {
+	Config CslServiceNowConfig `json:"config"`
+	State  CslServiceNowState  `json:"state"`
+}
+
+type CslServiceNowIncident struct {
+	SysId            string `json:"sys_id"`
+	Number           string `json:"number"`
+	Url              string `json:"url"`
+	Source           string `json:"source"`
+	SourceKey        string `json:"source_key"`
+	ShortDescription string `json:"short_description"`
+	State            string `json:"state"`
+	Resolved         bool   `json:"resolved"`
+	Created          int64  `json:"created"`
+	Updated          int64  `json:"updated"`
+}
+
+// Linked incidents keyed by <source>:<source key>
+type CslServiceNowIncidents struct {
+	Incidents map[string]CslServiceNowIncident `json:"incidents"`
+}
+
+type serviceNowIncident struct {
+	SysId  string `json:"sys_id"`
+	Number string `json:"number"`
+	State  string `json:"state"`
+}
+
+func getCslServiceNow(ctx context.Context, orgId string) CslServiceNow {
+	serviceNow := CslServiceNow{}
+	_, err := getCslDocument(ctx, orgId, CslServiceNowDocument, &serviceNow)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting servicenow config for org %s: %s", orgId, err)
+	}
+
+	if serviceNow.Config.Sources == nil {
+		serviceNow.Config.Sources = []string{}
+	}
+
+	if len(serviceNow.Config.CloseCode) == 0 {
+		serviceNow.Config.CloseCode = "Solution provided"
+	}
+
+	return serviceNow
+}
+
+func updateCslServiceNowState(ctx context.Context, orgId string, update func(state *CslServiceNowState)) error {
+	serviceNow := CslServiceNow{}
+	return updateCslDocument(ctx, orgId, CslServiceNowDocument, &serviceNow, func() error {
+		update(&serviceNow.State)
+		return nil
+	})
+}
+
+func redactCslServiceNow(serviceNow CslServiceNow) CslServiceNow {
+	if len(serviceNow.Config.Password) > 0 {
+		serviceNow.Config.Password = RedactedValue
+	}
+
+	return serviceNow
+}
+
+func validateCslServiceNowConfig(config CslServiceNowConfig) error {
+	if len(config.InstanceUrl) > 0 && !strings.HasPrefix(config.InstanceUrl, "https://") {
+		return errors.New("instance_url must start with https://")
+	}
+
+	for _, source := range config.Sources {
+		if source != "*" && source != TicketSourceExecution && !shuffle.ArrayContains(alertEvents, source) {
+			return errors.New(fmt.Sprintf("unknown source %s. Available sources are %s, %s", source, TicketSourceExecution, strings.Join(alertEvents, ", ")))
+		}
+	}
+
+	if config.Enabled && (len(config.InstanceUrl) == 0 || len(config.Username) == 0 || len(config.Password) == 0) {
+		return errors.New("instance_url, username and password are required")
+	}
+
+	return nil
+}
+
+func serviceNowSourceMatches(config CslServiceNowConfig, source string) bool {
+	return shuffle.ArrayContains(config.Sources, source) || shuffle.ArrayContains(config.Sources, "*")
+}
+
+func isServiceNowResolved(state string) bool {
+	return state == ServiceNowStateResolved || state == ServiceNowStateClosed || state == ServiceNowStateCanceled
+}
+
+func getServiceNowIncidentFields(config CslServiceNowConfig, notification CslNotification) map[string]string {
+	shortDescription := config.ShortDescription
+	if len(shortDescription) == 0 {
+		shortDescription = "{{title}}"
+	}
+
+	description := config.Description
+	if len(description) == 0 {
+		description = "{{text}}\n\n{{fields}}"
+	}
+
+	fields := map[string]string{
+		"short_description": truncateText(strings.ReplaceAll(renderNotificationTemplate(shortDescription, notification), "\n", " "), 160),
+		"description":       renderNotificationTemplate(description, notification),
+		"urgency":           serviceNowSeverityLevels[notification.Severity],
+		"impact":            serviceNowSeverityLevels[notification.Severity],
+	}
+
+	if len(config.AssignmentGroup) > 0 {
+		fields["assignment_group"] = config.AssignmentGroup
+	}
+
+	if len(config.Category) > 0 {
+		fields["category"] = config.Category
+	}
+
+	for field, template := range config.Fields {
+		fields[field] = renderNotificationTemplate(template, notification)
+	}
+
+	return fields
+}
+
+// Sends a request to the ServiceNow table API for incidents and unmarshals the
+// incident in the response into result
+func serviceNowRequest(ctx context.Context, config CslServiceNowConfig, method, sysId string, data interface{}, result *serviceNowIncident) error {
+	var body *bytes.Buffer
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewBuffer(b)
+	} else {
+		body = bytes.NewBuffer([]byte{})
+	}
+
+	requestUrl := fmt.Sprintf("%s/api/now/table/incident", strings.TrimRight(config.InstanceUrl, "/"))
+	if len(sysId) > 0 {
+		requestUrl = fmt.Sprintf("%s/%s", requestUrl, url.PathEscape(sysId))
+	}
+
+	requestUrl = fmt.Sprintf("%s?sysparm_fields=sys_id,number,state&sysparm_exclude_reference_link=true", requestUrl)
+	req, err := http.NewRequestWithContext(ctx, method, requestUrl, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+	req.SetBasicAuth(config.Username, config.Password)
+
+	client := shuffle.GetExternalClient(requestUrl)
+	newresp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer newresp.Body.Close()
+	respBody, err := ioutil.ReadAll(newresp.Body)
+	if err != nil {
+		return err
+	}
+
+	if newresp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("servicenow returned status code %d: %s", newresp.StatusCode, truncateText(string(respBody), 500)))
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	parsed := struct {
+		Result serviceNowIncident `json:"result"`
+	}{}
+
+	err = json.Unmarshal(respBody, &parsed)
+	if err != nil {
+		return err
+	}
+
+	*result = parsed.Result
+	return nil
+}
+
+// Stores a linked incident, dropping the oldest resolved incidents above MaxServiceNowIncidents
+func storeCslServiceNowIncident(ctx context.Context, orgId string, incident CslServiceNowIncident) error {
+	incidents := CslServiceNowIncidents{}
+	return updateCslDocument(ctx, orgId, CslServiceNowIncidentsDocument, &incidents, func() error {
+		if incidents.Incidents == nil {
+			incidents.Incidents = map[string]CslServiceNowIncident{}
+		}
+
+		incidents.Incidents[getTicketId(incident.Source, incident.SourceKey)] = incident
+		if len(incidents.Incidents) <= MaxServiceNowIncidents {
+			return nil
+		}
+
+		resolved := []string{}
+		for id, stored := range incidents.Incidents {
+			if stored.Resolved {
+				resolved = append(resolved, id)
+			}
+		}
+
+		sort.Slice(resolved, func(i, j int) bool {
+			return incidents.Incidents[resolved[i]].Created < incidents.Incidents[resolved[j]].Created
+		})
+
+		for _, id := range resolved {
+			if len(incidents.Incidents) <= MaxServiceNowIncidents {
+				break
+			}
+
+			delete(incidents.Incidents, id)
+		}
+
+		return nil
+	})
+}
+
+func getCslServiceNowIncidents(ctx context.Context, orgId string) CslServiceNowIncidents {
+	incidents := CslServiceNowIncidents{}
+	_, err := getCslDocument(ctx, orgId, CslServiceNowIncidentsDocument, &incidents)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting servicenow incidents for org %s: %s", orgId, err)
+	}
+
+	if incidents.Incidents == nil {
+		incidents.Incidents = map[string]CslServiceNowIncident{}
+	}
+
+	return incidents
+}
+
+// Applies a state from ServiceNow to a linked incident
+func setServiceNowIncidentState(incident *CslServiceNowIncident, state string) {
+	incident.State = serviceNowStateNames[state]
+	if len(incident.State) == 0 {
+		incident.State = state
+	}
+
+	incident.Resolved = isServiceNowResolved(state)
+	incident.Updated = time.Now().Unix()
+}
+
+// Creates a ServiceNow incident for a source if ServiceNow is enabled for the
+// org and subscribes to it. An unresolved incident that is already linked is
+// updated with a work note instead
+func openCslServiceNowIncident(ctx context.Context, orgId, source, sourceKey string, notification CslNotification) (*CslServiceNowIncident, error) {
+	serviceNow := getCslServiceNow(ctx, orgId)
+	if !serviceNow.Config.Enabled || !serviceNowSourceMatches(serviceNow.Config, source) {
+		return nil, nil
+	}
+
+	if existing, ok := getCslServiceNowIncidents(ctx, orgId).Incidents[getTicketId(source, sourceKey)]; ok && !existing.Resolved {
+		if source != TicketSourceExecution {
+			err := serviceNowRequest(ctx, serviceNow.Config, "PATCH", existing.SysId, map[string]string{
+				"work_notes": fmt.Sprintf("The alert was triggered again in Shuffle: %s", notification.Title),
+			}, nil)
+			if err != nil {
+				log.Printf("[WARNING] Failed updating servicenow incident %s for org %s: %s", existing.Number, orgId, err)
+			}
+		}
+
+		return &existing, nil
+	}
+
+	created := serviceNowIncident{}
+	err := serviceNowRequest(ctx, serviceNow.Config, "POST", "", getServiceNowIncidentFields(serviceNow.Config, notification), &created)
+	if err != nil {
+		log.Printf("[ERROR] Failed creating servicenow incident for %s in org %s: %s", getTicketId(source, sourceKey), orgId, err)
+		updateCslServiceNowState(ctx, orgId, func(state *CslServiceNowState) {
+			state.LastError = err.Error()
+		})
+
+		return nil, err
+	}
+
+	incident := CslServiceNowIncident{
+		SysId:            created.SysId,
+		Number:           created.Number,
+		Url:              fmt.Sprintf("%s/nav_to.do?uri=incident.do?sys_id=%s", strings.TrimRight(serviceNow.Config.InstanceUrl, "/"), created.SysId),
+		Source:           source,
+		SourceKey:        sourceKey,
+		ShortDescription: notification.Title,
+		Created:          time.Now().Unix(),
+	}
+	setServiceNowIncidentState(&incident, created.State)
+
+	err = storeCslServiceNowIncident(ctx, orgId, incident)
+	if err != nil {
+		log.Printf("[ERROR] Failed storing servicenow incident %s for org %s: %s", incident.Number, orgId, err)
+	}
+
+	log.Printf("[INFO] Created servicenow incident %s for %s in org %s", incident.Number, getTicketId(source, sourceKey), orgId)
+	return &incident, nil
+}
+
+// Sets the state of a linked incident in ServiceNow. Resolving requires the
+// close code of the config and notes
+func updateCslServiceNowIncident(ctx context.Context, orgId string, incident CslServiceNowIncident, state, notes string) (CslServiceNowIncident, error) {
+	serviceNow := getCslServiceNow(ctx, orgId)
+	if !serviceNow.Config.Enabled {
+		return incident, errors.New("servicenow is not enabled")
+	}
+
+	fields := map[string]string{
+		"state":      state,
+		"work_notes": notes,
+	}
+
+	if state == ServiceNowStateResolved {
+		fields["close_code"] = serviceNow.Config.CloseCode
+		fields["close_notes"] = notes
+	}
+
+	updated := serviceNowIncident{}
+	err := serviceNowRequest(ctx, serviceNow.Config, "PATCH", incident.SysId, fields, &updated)
+	if err != nil {
+		return incident, err
+	}
+
+	setServiceNowIncidentState(&incident, updated.State)
+	err = storeCslServiceNowIncident(ctx, orgId, incident)
+	return incident, err
+}
+
+// Resolves the incident of a cleared alert
+func resolveCslServiceNowIncident(ctx context.Context, orgId, source, sourceKey string) {
+	serviceNow := getCslServiceNow(ctx, orgId)
+	if !serviceNow.Config.Enabled {
+		return
+	}
+
+	incident, ok := getCslServiceNowIncidents(ctx, orgId).Incidents[getTicketId(source, sourceKey)]
+	if !ok || incident.Resolved {
+		return
+	}
+
+	_, err := updateCslServiceNowIncident(ctx, orgId, incident, ServiceNowStateResolved, "The alert was resolved in Shuffle")
+	if err != nil {
+		log.Printf("[WARNING] Failed resolving servicenow incident %s for org %s: %s", incident.Number, orgId, err)
+	}
+}
+
+// Job: syncs the state of unresolved linked incidents from ServiceNow.
+// Escalated executions are recorded in the activity feed when their incident
+// is resolved
+func runCslServiceNowSyncJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for servicenow sync job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		serviceNow := getCslServiceNow(ctx, org.Id)
+		if !serviceNow.Config.Enabled {
+			continue
+		}
+
+		var syncErr error
+		for _, incident := range getCslServiceNowIncidents(ctx, org.Id).Incidents {
+			if incident.Resolved {
+				continue
+			}
+
+			remote := serviceNowIncident{}
+			err := serviceNowRequest(ctx, serviceNow.Config, "GET", incident.SysId, nil, &remote)
+			if err != nil {
+				log.Printf("[WARNING] Failed syncing servicenow incident %s for org %s: %s", incident.Number, org.Id, err)
+				syncErr = err
+				continue
+			}
+
+			previousState := incident.State
+			setServiceNowIncidentState(&incident, remote.State)
+			if incident.State == previousState {
+				continue
+			}
+
+			err = storeCslServiceNowIncident(ctx, org.Id, incident)
+			if err != nil {
+				log.Printf("[ERROR] Failed storing servicenow incident %s for org %s: %s", incident.Number, org.Id, err)
+			}
+
+			if incident.Resolved && incident.Source == TicketSourceExecution {
+				recordCslActivity(ctx, org.Id, ActivityTypeCase, "servicenow_incident_resolved", fmt.Sprintf("ServiceNow incident %s was %s", incident.Number, incident.State), "", incident.SourceKey)
+			}
+		}
+
+		err = updateCslServiceNowState(ctx, org.Id, func(state *CslServiceNowState) {
+			state.LastSync = time.Now().Unix()
+			state.LastError = ""
+			if syncErr != nil {
+				state.LastError = syncErr.Error()
+			}
+		})
+		if err != nil {
+			log.Printf("[WARNING] Failed updating servicenow state for org %s: %s", org.Id, err)
+		}
+	}
+}
+
+/*
+Ticketing:
+Returns the ServiceNow configuration and state for the current organization.
+Requires org admin. The password is redacted.
+
+	{
+	    "success": true,
+	    "data": {
+	        "config": {
+	            "enabled": true,
+	            "instance_url": "https://example.service-now.com",
+	            "username": "shuffle.integration",
+	            "password": "********",
+	            "sources": ["execution", "worker_outage"],
+	            "assignment_group": "SOC",
+	            "category": "security",
+	            "short_description": "[Shuffle] {{title}}",
+	            "description": "{{text}}\n\n{{fields}}",
+	            "fields": {"u_source_system": "shuffle"},
+	            "close_code": "Solution provided"
+	        },
+	        "state": {
+	            "last_sync": 1700000000,
+	            "last_error": ""
+	        }
+	    }
+	}
+*/
+func cslGetServiceNow(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslServiceNow(getCslServiceNow(ctx, user.ActiveOrg.Id)),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetServiceNow")
+}
+
+/*
+Ticketing:
+Updates the ServiceNow configuration. Requires org admin. Body uses the format
+of the config field returned from GET, and a redacted password is kept as it
+is. Alerts of the listed sources create an incident that is resolved when the
+alert clears, and executions can be escalated to an incident by users. State
+changes made in ServiceNow are synced back every 10 minutes.
+*/
+func cslSetServiceNow(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	serviceNow := getCslServiceNow(ctx, user.ActiveOrg.Id)
+	previousConfig := serviceNow.Config
+
+	err = json.Unmarshal(body, &serviceNow.Config)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling servicenow config: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if serviceNow.Config.Password == RedactedValue {
+		serviceNow.Config.Password = previousConfig.Password
+	}
+
+	if serviceNow.Config.Sources == nil {
+		serviceNow.Config.Sources = []string{}
+	}
+
+	err = validateCslServiceNowConfig(serviceNow.Config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	stored := CslServiceNow{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslServiceNowDocument, &stored, func() error {
+		stored.Config = serviceNow.Config
+		serviceNow = stored
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated servicenow config for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "servicenow_updated", "ServiceNow configuration was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslServiceNow(serviceNow),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetServiceNow")
+}
+
+/*
+Ticketing:
+Checks that the ServiceNow credentials can read incidents. Requires org admin
+and ServiceNow to be enabled.
+*/
+func cslTestServiceNow(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	serviceNow := getCslServiceNow(ctx, user.ActiveOrg.Id)
+	if !serviceNow.Config.Enabled {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("servicenow is not enabled")))
+		return
+	}
+
+	requestUrl := fmt.Sprintf("%s/api/now/table/incident?sysparm_limit=1&sysparm_fields=sys_id", strings.TrimRight(serviceNow.Config.InstanceUrl, "/"))
+	req, err := http.NewRequestWithContext(ctx, "GET", requestUrl, nil)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	req.Header.Add("Accept", "application/json")
+	req.SetBasicAuth(serviceNow.Config.Username, serviceNow.Config.Password)
+
+	client := shuffle.GetExternalClient(requestUrl)
+	newresp, err := client.Do(req)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	defer newresp.Body.Close()
+	if newresp.StatusCode != 200 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("servicenow returned status code %d", newresp.StatusCode))))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslTestServiceNow")
+}
+
+/*
+Ticketing:
+Returns the ServiceNow incidents linked to alerts and executions of the current
+organization, newest first. Optional ?source=<event|execution> filters the
+incidents and ?source_key=<id> returns the incident of a single alert or execution.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "sys_id": "...",
+	            "number": "INC0010042",
+	            "url": "https://example.service-now.com/nav_to.do?uri=incident.do?sys_id=...",
+	            "source": "execution",
+	            "source_key": "...",
+	            "short_description": "Execution of Phishing triage (FAILURE)",
+	            "state": "in_progress",
+	            "resolved": false,
+	            "created": 1700000000,
+	            "updated": 1700000600
+	        }
+	    ]
+	}
+*/
+func cslListServiceNowIncidents(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	incidents := []CslServiceNowIncident{}
+	for _, incident := range getCslServiceNowIncidents(ctx, user.ActiveOrg.Id).Incidents {
+		if len(query.Get("source")) > 0 && incident.Source != query.Get("source") {
+			continue
+		}
+
+		if len(query.Get("source_key")) > 0 && incident.SourceKey != query.Get("source_key") {
+			continue
+		}
+
+		incidents = append(incidents, incident)
+	}
+
+	sort.SliceStable(incidents, func(i, j int) bool {
+		return incidents[i].Created > incidents[j].Created
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data:    incidents,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslListServiceNowIncidents")
+}
+
+/*
+Ticketing:
+Escalates an execution to a ServiceNow incident. Requires ?execution_id=<id>
+and "execution" in the sources of the config. Returns the linked incident,
+which is the existing one if the execution was already escalated and it isn't
+resolved.
+*/
+func cslCreateServiceNowIncident(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	execution, err := shuffle.GetWorkflowExecution(ctx, request.URL.Query().Get("execution_id"))
+	if err != nil || execution.ExecutionOrg != user.ActiveOrg.Id {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("execution_id is not valid")))
+		return
+	}
+
+	notification := getCslNotification(user.ActiveOrg.Id, TicketSourceExecution, getCslExecutionFailure(*execution))
+	notification.Title = fmt.Sprintf("Execution of %s (%s)", execution.Workflow.Name, execution.Status)
+	notification.Text = fmt.Sprintf("Execution %s was escalated by %s", execution.ExecutionId, user.Username)
+
+	incident, err := openCslServiceNowIncident(ctx, user.ActiveOrg.Id, TicketSourceExecution, execution.ExecutionId, notification)
+	if err != nil || incident == nil {
+		if err == nil {
+			err = errors.New("servicenow is not enabled or doesn't accept executions")
+		}
+
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) escalated execution %s to servicenow incident %s", user.Username, user.Id, execution.ExecutionId, incident.Number)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeCase, "servicenow_incident_created", fmt.Sprintf("Execution of %s was escalated to %s", execution.Workflow.Name, incident.Number), user.Username, execution.ExecutionId)
+
+	res := CslResponse{
+		Success: true,
+		Data:    incident,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslCreateServiceNowIncident")
+}
+
+/*
+Ticketing:
+Updates the state of a linked ServiceNow incident. Requires ?number=<number>.
+State is in_progress, on_hold or resolved, and notes are added as work notes.
+
+	{
+	    "state": "resolved",
+	    "notes": "Host was reimaged"
+	}
+*/
+func cslUpdateServiceNowIncident(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	input := struct {
+		State string `json:"state"`
+		Notes string `json:"notes"`
+	}{}
+
+	err = json.Unmarshal(body, &input)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	states := map[string]string{
+		"in_progress": ServiceNowStateInProgress,
+		"on_hold":     ServiceNowStateOnHold,
+		"resolved":    ServiceNowStateResolved,
+	}
+
+	state, ok := states[input.State]
+	if !ok {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("state must be in_progress, on_hold or resolved")))
+		return
+	}
+
+	if len(strings.TrimSpace(input.Notes)) == 0 || len(input.Notes) > MaxServiceNowNoteLength {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("notes must be between 1 and %d characters", MaxServiceNowNoteLength))))
+		return
+	}
+
+	number := request.URL.Query().Get("number")
+	var incident *CslServiceNowIncident
+	for _, stored := range getCslServiceNowIncidents(ctx, user.ActiveOrg.Id).Incidents {
+		if stored.Number == number {
+			incident = &stored
+			break
+		}
+	}
+
+	if incident == nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("number isn't a linked incident")))
+		return
+	}
+
+	updated, err := updateCslServiceNowIncident(ctx, user.ActiveOrg.Id, *incident, state, fmt.Sprintf("%s (Shuffle): %s", user.Username, input.Notes))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) set servicenow incident %s to %s", user.Username, user.Id, updated.Number, input.State)
+
+	res := CslResponse{
+		Success: true,
+		Data:    updated,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslUpdateServiceNowIncident")
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslSessionsDocument = "sessions"
+
+const SessionFlushMinutes = 1
+
+// Sessions without activity for this long are dropped from the list
+const SessionIdleDays = 30
+
+// Clients kept per session, the most recently active first
+const MaxSessionClients = 10
+
+// Length of the session ids shown in the API. The full hash is never shown
+const SessionIdLength = 16
+
+// A browser or device using a session. Shuffle gives every login of a user
+// the same session token, so one session can have several clients
+type CslSessionClient struct {
+	Ip           string `json:"ip"`
+	UserAgent    string `json:"user_agent"`
+	Country      string `json:"country"`
+	FirstSeen    int64  `json:"first_seen"`
+	LastActivity int64  `json:"last_activity"`
+	Requests     int64  `json:"requests"`
+}
+
+type CslSession struct {
+	Id           string             `json:"id"`
+	UserId       string             `json:"user_id"`
+	Username     string             `json:"username"`
+	FirstSeen    int64              `json:"first_seen"`
+	LastActivity int64              `json:"last_activity"`
+	Clients      []CslSessionClient `json:"clients"`
+}
+
+// Hash is the sha256 of the session token, and isn't returned from the API
+type cslStoredSession struct {
+	CslSession
+	Hash string `json:"hash"`
+}
+
+// Sessions keyed by id
+type CslSessions struct {
+	Sessions map[string]cslStoredSession `json:"sessions"`
+}
+
+type cslPendingSession struct {
+	orgId    string
+	userId   string
+	username string
+	clients  map[string]*CslSessionClient
+}
+
+// Session activity is collected in memory and flushed to the org documents
+// by the session_flush job, so requests don't each need a write
+var cslPendingSessions = struct {
+	sync.Mutex
+	sessions map[string]*cslPendingSession
+}{
+	sessions: map[string]*cslPendingSession{},
+}
+
+func getCslSessions(ctx context.Context, orgId string) CslSessions {
+	sessions := CslSessions{}
+	_, err := getCslDocument(ctx, orgId, CslSessionsDocument, &sessions)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting sessions for org %s: %s", orgId, err)
+	}
+
+	if sessions.Sessions == nil {
+		sessions.Sessions = map[string]cslStoredSession{}
+	}
+
+	return sessions
+}
+
+// Counts a request made with a session cookie. The user is only looked up
+// for the first request of a session between flushes
+func recordCslSessionActivity(ctx context.Context, request *http.Request, sessionToken string) {
+	hash := getSessionHash(sessionToken)
+	ip := shuffle.GetRequestIp(request)
+	userAgent := truncateText(request.Header.Get("User-Agent"), 300)
+
+	cslPendingSessions.Lock()
+	pending, ok := cslPendingSessions.sessions[hash]
+	cslPendingSessions.Unlock()
+
+	if !ok {
+		user, err := shuffle.GetSessionNew(ctx, sessionToken)
+		if err != nil || len(user.Id) == 0 || user.Session != sessionToken {
+			return
+		}
+
+		pending = &cslPendingSession{
+			orgId:    user.ActiveOrg.Id,
+			userId:   user.Id,
+			username: user.Username,
+			clients:  map[string]*CslSessionClient{},
+		}
+	}
+
+	cslPendingSessions.Lock()
+	defer cslPendingSessions.Unlock()
+
+	if existing, ok := cslPendingSessions.sessions[hash]; ok {
+		pending = existing
+	} else {
+		cslPendingSessions.sessions[hash] = pending
+	}
+
+	clientKey := fmt.Sprintf("%s|%s", ip, userAgent)
+	client, ok := pending.clients[clientKey]
+	if !ok {
+		if len(pending.clients) >= MaxSessionClients {
+			return
+		}
+
+		client = &CslSessionClient{
+			Ip:        ip,
+			UserAgent: userAgent,
+			FirstSeen: time.Now().Unix(),
+		}
+
+		pending.clients[clientKey] = client
+	}
+
+	client.LastActivity = time.Now().Unix()
+	client.Requests += 1
+}
+
+// Middleware tracking the requests made with session cookies, which is how
+// the frontend authenticates. Api key requests aren't sessions
+func cslSessionActivityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, request *http.Request) {
+		if !strings.HasPrefix(request.URL.Path, "/api/") || request.Method == "OPTIONS" || len(request.Header.Get("Authorization")) > 0 {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		cookie, err := request.Cookie("session_token")
+		if err == nil && len(cookie.Value) > 0 {
+			recordCslSessionActivity(shuffle.GetContext(request), request, cookie.Value)
+		}
+
+		next.ServeHTTP(resp, request)
+	})
+}
+
+func mergeSessionClients(clients []CslSessionClient, pending map[string]*CslSessionClient) []CslSessionClient {
+	for _, client := range pending {
+		merged := false
+		for i := range clients {
+			if clients[i].Ip != client.Ip || clients[i].UserAgent != client.UserAgent {
+				continue
+			}
+
+			clients[i].LastActivity = client.LastActivity
+			clients[i].Requests += client.Requests
+			merged = true
+			break
+		}
+
+		if !merged {
+			newClient := *client
+			newClient.Country = lookupGeoIp(client.Ip).Country
+			clients = append(clients, newClient)
+		}
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].LastActivity > clients[j].LastActivity
+	})
+
+	return clients[:min(len(clients), MaxSessionClients)]
+}
+
+func runCslSessionFlushJob(ctx context.Context) {
+	cslPendingSessions.Lock()
+	pending := cslPendingSessions.sessions
+	cslPendingSessions.sessions = map[string]*cslPendingSession{}
+	cslPendingSessions.Unlock()
+
+	orgs := map[string]map[string]*cslPendingSession{}
+	for hash, session := range pending {
+		if _, ok := orgs[session.orgId]; !ok {
+			orgs[session.orgId] = map[string]*cslPendingSession{}
+		}
+
+		orgs[session.orgId][hash] = session
+	}
+
+	oldest := time.Now().AddDate(0, 0, -SessionIdleDays).Unix()
+	for orgId, orgPending := range orgs {
+		sessions := CslSessions{}
+		err := updateCslDocument(ctx, orgId, CslSessionsDocument, &sessions, func() error {
+			if sessions.Sessions == nil {
+				sessions.Sessions = map[string]cslStoredSession{}
+			}
+
+			for hash, session := range orgPending {
+				id := hash[:SessionIdLength]
+				stored, ok := sessions.Sessions[id]
+				if !ok {
+					stored = cslStoredSession{
+						CslSession: CslSession{
+							Id:        id,
+							UserId:    session.userId,
+							Username:  session.username,
+							FirstSeen: time.Now().Unix(),
+							Clients:   []CslSessionClient{},
+						},
+						Hash: hash,
+					}
+				}
+
+				stored.Clients = mergeSessionClients(stored.Clients, session.clients)
+				for _, client := range stored.Clients {
+					if client.FirstSeen < stored.FirstSeen {
+						stored.FirstSeen = client.FirstSeen
+					}
+
+					if client.LastActivity > stored.LastActivity {
+						stored.LastActivity = client.LastActivity
+					}
+				}
+
+				sessions.Sessions[id] = stored
+			}
+
+			for id, stored := range sessions.Sessions {
+				if stored.LastActivity < oldest {
+					delete(sessions.Sessions, id)
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			log.Printf("[ERROR] Failed storing sessions for org %s: %s", orgId, err)
+		}
+	}
+}
+
+// Returns the sessions of the org that are still the active session of
+// their user. Sessions replaced by logging out are dropped
+func getActiveCslSessions(ctx context.Context, orgId string) []CslSession {
+	active := []CslSession{}
+	ended := []string{}
+	for id, stored := range getCslSessions(ctx, orgId).Sessions {
+		user, err := shuffle.GetUser(ctx, stored.UserId)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting user %s for session %s: %s", stored.UserId, id, err)
+			continue
+		}
+
+		if len(user.Session) == 0 || getSessionHash(user.Session) != stored.Hash {
+			ended = append(ended, id)
+			continue
+		}
+
+		active = append(active, stored.CslSession)
+	}
+
+	if len(ended) > 0 {
+		sessions := CslSessions{}
+		err := updateCslDocument(ctx, orgId, CslSessionsDocument, &sessions, func() error {
+			for _, id := range ended {
+				delete(sessions.Sessions, id)
+			}
+
+			return nil
+		})
+		if err != nil {
+			log.Printf("[WARNING] Failed dropping ended sessions for org %s: %s", orgId, err)
+		}
+	}
+
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].LastActivity > active[j].LastActivity
+	})
+
+	return active
+}
+
+// Ends the Shuffle session of a user, logging out every client using it
+func endCslUserSession(ctx context.Context, orgId string, user *shuffle.User) error {
+	hash := getSessionHash(user.Session)
+	shuffle.DeleteCache(ctx, fmt.Sprintf("user_%s", strings.ToLower(user.Username)))
+	shuffle.DeleteCache(ctx, fmt.Sprintf("session_%s", user.Session))
+	shuffle.DeleteCache(ctx, user.Session)
+
+	user.Session = ""
+	user.ValidatedSessionOrgs = []string{}
+	err := shuffle.SetUser(ctx, user, false)
+	if err != nil {
+		return err
+	}
+
+	cslPendingSessions.Lock()
+	delete(cslPendingSessions.sessions, hash)
+	cslPendingSessions.Unlock()
+
+	sessions := CslSessions{}
+	return updateCslDocument(ctx, orgId, CslSessionsDocument, &sessions, func() error {
+		delete(sessions.Sessions, hash[:SessionIdLength])
+		return nil
+	})
+}
+
+// Finds a user of the org whose sessions the caller may manage. Admins and
+// roles with users:manage may manage every user in the org, others only
+// themselves
+func getCslSessionUser(ctx context.Context, caller *shuffle.User, userId string) (*shuffle.User, int, error) {
+	if len(userId) == 0 || userId == caller.Id {
+		user, err := shuffle.GetUser(ctx, caller.Id)
+		if err != nil {
+			return nil, 500, err
+		}
+
+		return user, 200, nil
+	}
+
+	if checkUserOrgPermission(ctx, *caller, PermissionUsersManage) != nil {
+		return nil, 403, errors.New("only org admins can manage the sessions of other users")
+	}
+
+	user, err := shuffle.GetUser(ctx, userId)
+	if err != nil || !shuffle.ArrayContains(user.Orgs, caller.ActiveOrg.Id) {
+		return nil, 404, errors.New("user not found")
+	}
+
+	return user, 200, nil
+}
+
+func revokeCslSession(resp http.ResponseWriter, request *http.Request, caller *shuffle.User, target *shuffle.User, action string) {
+	ctx := shuffle.GetContext(request)
+	orgId := caller.ActiveOrg.Id
+
+	err := endCslUserSession(ctx, orgId, target)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) revoked the session of user %s (%s) in org %s", caller.Username, caller.Id, target.Username, target.Id, orgId)
+	recordCslActivity(ctx, orgId, ActivityTypeSecurity, action, fmt.Sprintf("Session of %s was revoked by %s", target.Username, caller.Username), caller.Username, target.Id)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "revokeCslSession")
+}
+
+/*
+Sessions:
+Returns the active sessions of the current user, with the IP, user agent and
+last activity of each client using them. Org admins may pass ?user_id=<id> for
+another user of the org, or ?all=true for every user of the org. Activity is
+updated every minute.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "id": "3f2a9c0e1b7d4a55",
+	            "user_id": "...",
+	            "username": "analyst@example.com",
+	            "first_seen": 1700000000,
+	            "last_activity": 1700600000,
+	            "clients": [
+	                {
+	                    "ip": "203.0.113.7",
+	                    "user_agent": "Mozilla/5.0 ...",
+	                    "country": "NO",
+	                    "first_seen": 1700000000,
+	                    "last_activity": 1700600000,
+	                    "requests": 420
+	                }
+	            ]
+	        }
+	    ]
+	}
+*/
+func cslListSessions(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	userId := user.Id
+	if query.Get("all") == "true" || len(query.Get("user_id")) > 0 {
+		err := checkUserOrgPermission(ctx, *user, PermissionAuditRead)
+		if err != nil && query.Get("user_id") != user.Id {
+			resp.WriteHeader(403)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		userId = query.Get("user_id")
+	}
+
+	sessions := []CslSession{}
+	for _, session := range getActiveCslSessions(ctx, user.ActiveOrg.Id) {
+		if len(userId) > 0 && session.UserId != userId {
+			continue
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    sessions,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslListSessions")
+}
+
+/*
+Sessions:
+Revokes a session by ?id=<session id> from the session list, logging out every
+client using it. Users may revoke their own sessions, and org admins any
+session in the org.
+*/
+func cslRevokeSession(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	session, ok := getCslSessions(ctx, user.ActiveOrg.Id).Sessions[request.URL.Query().Get("id")]
+	if !ok {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New("session not found")))
+		return
+	}
+
+	target, status, err := getCslSessionUser(ctx, user, session.UserId)
+	if err != nil {
+		resp.WriteHeader(status)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if len(target.Session) == 0 || getSessionHash(target.Session) != session.Hash {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New("session has already ended")))
+		return
+	}
+
+	revokeCslSession(resp, request, user, target, "session_revoked")
+}
+
+/*
+Sessions:
+Revokes every session of a user, e.g. when an account is compromised. Defaults
+to the current user, and org admins may pass ?user_id=<id> for another user of
+the org. API keys aren't affected.
+*/
+func cslRevokeAllSessions(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	target, status, err := getCslSessionUser(ctx, user, request.URL.Query().Get("user_id"))
+	if err != nil {
+		resp.WriteHeader(status)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if len(target.Session) == 0 {
+		res := CslResponse{
+			Success: true,
+		}
+
+		marshalAndWriteResponse(resp, res, "cslRevokeAllSessions")
+		return
+	}
+
+	revokeCslSession(resp, request, user, target, "sessions_revoked")
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslSettingsDocument = "settings"
+
+// Org level configuration for the CSL features
+type CslOrgSettings struct {
+	WebhookUrl                  string `json:"webhook_url"`
+	CredentialExpiryWarningDays int    `json:"credential_expiry_warning_days"`
+	Timezone                    string `json:"timezone"`
+
+	// Executions running longer than this breach the SLA. 0 disables it
+	SlaMinutes int `json:"sla_minutes"`
+
+	// Failed executions within 15 minutes that trigger a failure spike alert. 0 disables it
+	FailureSpikeThreshold int `json:"failure_spike_threshold"`
+
+	// Schedules can't fire more often than this. 0 allows any interval
+	MinScheduleIntervalSeconds int `json:"min_schedule_interval_seconds"`
+}
+
+type CslWebhookEvent struct {
+	Event     string      `json:"event"`
+	OrgId     string      `json:"org_id"`
+	Timestamp int64       `json:"timestamp"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+func getDefaultCslOrgSettings() CslOrgSettings {
+	return CslOrgSettings{
+		CredentialExpiryWarningDays: 14,
+	}
+}
+
+// Retrieves the CSL settings for an org, falling back to defaults for
+// anything that hasn't been configured
+func getCslOrgSettings(ctx context.Context, orgId string) CslOrgSettings {
+	settings := getDefaultCslOrgSettings()
+
+	_, err := getCslDocument(ctx, orgId, CslSettingsDocument, &settings)
+	if err != nil {
+		log.Printf("[WARNING] Using default CSL settings for org %s: %s", orgId, err)
+		return getDefaultCslOrgSettings()
+	}
+
+	if settings.CredentialExpiryWarningDays <= 0 {
+		settings.CredentialExpiryWarningDays = getDefaultCslOrgSettings().CredentialExpiryWarningDays
+	}
+
+	return settings
+}
+
+func validateCslOrgSettings(settings CslOrgSettings) error {
+	if len(settings.WebhookUrl) > 0 && !strings.HasPrefix(settings.WebhookUrl, "http://") && !strings.HasPrefix(settings.WebhookUrl, "https://") {
+		return errors.New("webhook_url must start with http:// or https://")
+	}
+
+	if settings.CredentialExpiryWarningDays < 0 {
+		return errors.New("credential_expiry_warning_days can't be negative")
+	}
+
+	if settings.SlaMinutes < 0 {
+		return errors.New("sla_minutes can't be negative")
+	}
+
+	if settings.FailureSpikeThreshold < 0 {
+		return errors.New("failure_spike_threshold can't be negative")
+	}
+
+	if settings.MinScheduleIntervalSeconds < 0 {
+		return errors.New("min_schedule_interval_seconds can't be negative")
+	}
+
+	if len(settings.Timezone) > 0 {
+		_, err := time.LoadLocation(settings.Timezone)
+		if err != nil {
+			return errors.New(fmt.Sprintf("timezone %s is not a valid IANA timezone such as Europe/Oslo", settings.Timezone))
+		}
+	}
+
+	return nil
+}
+
+// Daily statistics roll over at midnight in the org timezone.
+// Orgs without one use the timezone of the server
+func getTimezoneLocation(timezone string) *time.Location {
+	if len(timezone) == 0 {
+		return time.Local
+	}
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Local
+	}
+
+	return location
+}
+
+// The daily statistics are rolled over by shuffle-shared, so the timezone is stored with them
+func setOrgStatisticsTimezone(ctx context.Context, orgId, timezone string) error {
+	orgStats, err := shuffle.GetOrgStatistics(ctx, orgId)
+	if err != nil {
+		return err
+	}
+
+	orgStats.Timezone = timezone
+	return shuffle.SetOrgStatistics(ctx, *orgStats, orgId)
+}
+
+// Sends an event to the org's configured webhook. Does nothing if no webhook is configured
+func sendCslWebhook(ctx context.Context, orgId, event string, data interface{}) error {
+	settings := getCslOrgSettings(ctx, orgId)
+	if len(settings.WebhookUrl) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(CslWebhookEvent{
+		Event:     event,
+		OrgId:     orgId,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed marshaling CSL webhook %s for org %s: %s", event, orgId, err)
+		return err
+	}
+
+	req, err := http.NewRequest("POST", settings.WebhookUrl, bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	client := shuffle.GetExternalClient(settings.WebhookUrl)
+	newresp, err := client.Do(req)
+	if err != nil {
+		log.Printf("[ERROR] Failed sending CSL webhook %s for org %s: %s", event, orgId, err)
+		return err
+	}
+
+	defer newresp.Body.Close()
+	ioutil.ReadAll(newresp.Body)
+
+	if newresp.StatusCode >= 300 {
+		log.Printf("[WARNING] CSL webhook %s for org %s returned status %d", event, orgId, newresp.StatusCode)
+		return errors.New(fmt.Sprintf("webhook returned status code %d", newresp.StatusCode))
+	}
+
+	return nil
+}
+
+/*
+Settings:
+Returns the CSL settings for the current organization
+
+	{
+	    "success": true,
+	    "data": {
+	        "webhook_url": "https://example.com/hook",
+	        "credential_expiry_warning_days": 14,
+	        "timezone": "Europe/Oslo",
+	        "sla_minutes": 30,
+	        "failure_spike_threshold": 10,
+	        "min_schedule_interval_seconds": 300
+	    }
+	}
+*/
+func cslGetSettings(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslOrgSettings(ctx, user.ActiveOrg.Id),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetSettings")
+}
+
+/*
+Settings:
+Updates the CSL settings for the current organization. Requires org admin.
+Body uses the same format as the data field returned from GET. The timezone
+decides when daily statistics roll over, and applies from the next day.
+*/
+func cslSetSettings(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	settings := getCslOrgSettings(ctx, user.ActiveOrg.Id)
+	previousTimezone := settings.Timezone
+	err = json.Unmarshal(body, &settings)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling CSL settings: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	err = validateCslOrgSettings(settings)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslSettingsDocument, settings)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if settings.Timezone != previousTimezone {
+		err = setOrgStatisticsTimezone(ctx, user.ActiveOrg.Id, settings.Timezone)
+		if err != nil {
+			log.Printf("[ERROR] Failed setting statistics timezone for org %s: %s", user.ActiveOrg.Id, err)
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated CSL settings for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "settings_updated", "CSL settings were updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    settings,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetSettings")
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Graceful shutdown on SIGTERM or SIGINT, so rolling upgrades don't leave
+// executions in limbo. The backend gives up leadership so another replica
+// takes over jobs and schedules, stops accepting connections and waits for
+// requests in flight and execution dispatches (handleExecution) to finish.
+// Executions that were stored but not yet put in their environment queues
+// when time runs out are queued before exit, so Orborus picks them up.
+//
+// Everything has to finish within SHUFFLE_SHUTDOWN_TIMEOUT seconds (default
+// 25), which should be below the grace period of the container runtime. A
+// second signal exits right away.
+
+const DefaultShutdownTimeoutSeconds = 25
+
+// An execution being set up by handleExecution
+type cslDispatch struct {
+	id           string
+	workflowId   string
+	started      time.Time
+	execution    *shuffle.WorkflowExecution
+	environments []string
+	queued       map[string]bool
+}
+
+var cslShutdown = struct {
+	sync.Mutex
+	shuttingDown bool
+	dispatches   map[string]*cslDispatch
+}{
+	dispatches: map[string]*cslDispatch{},
+}
+
+func getShutdownTimeout() time.Duration {
+	timeout := DefaultShutdownTimeoutSeconds
+	if value := os.Getenv("SHUFFLE_SHUTDOWN_TIMEOUT"); len(value) > 0 {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			log.Printf("[WARNING] Invalid SHUFFLE_SHUTDOWN_TIMEOUT %s. Using %d seconds", value, DefaultShutdownTimeoutSeconds)
+		} else {
+			timeout = parsed
+		}
+	}
+
+	return time.Duration(timeout) * time.Second
+}
+
+func isCslShuttingDown() bool {
+	cslShutdown.Lock()
+	defer cslShutdown.Unlock()
+
+	return cslShutdown.shuttingDown
+}
+
+func beginCslDispatch(workflowId string) *cslDispatch {
+	dispatch := &cslDispatch{
+		id:         uuid.NewV4().String(),
+		workflowId: workflowId,
+		started:    time.Now(),
+		queued:     map[string]bool{},
+	}
+
+	cslShutdown.Lock()
+	cslShutdown.dispatches[dispatch.id] = dispatch
+	cslShutdown.Unlock()
+
+	return dispatch
+}
+
+func endCslDispatch(dispatch *cslDispatch) {
+	cslShutdown.Lock()
+	delete(cslShutdown.dispatches, dispatch.id)
+	cslShutdown.Unlock()
+}
+
+// Called once the execution is stored, and it only has to be queued
+func setCslDispatchExecution(dispatch *cslDispatch, execution shuffle.WorkflowExecution, environments []string) {
+	cslShutdown.Lock()
+	defer cslShutdown.Unlock()
+
+	dispatch.execution = &execution
+	dispatch.environments = environments
+}
+
+func setCslDispatchQueued(dispatch *cslDispatch, environment string) {
+	cslShutdown.Lock()
+	defer cslShutdown.Unlock()
+
+	dispatch.queued[environment] = true
+}
+
+// Waits for the dispatches in flight, and queues the stored executions of
+// those still running when ctx is done. Returns how many were queued and how
+// many had to be left
+func drainCslDispatches(ctx context.Context) (int, int) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		cslShutdown.Lock()
+		remaining := len(cslShutdown.dispatches)
+		cslShutdown.Unlock()
+		if remaining == 0 {
+			return 0, 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return requeueCslDispatches()
+		case <-ticker.C:
+		}
+	}
+}
+
+func requeueCslDispatches() (int, int) {
+	cslShutdown.Lock()
+	dispatches := []cslDispatch{}
+	for _, dispatch := range cslShutdown.dispatches {
+		copied := *dispatch
+		copied.queued = map[string]bool{}
+		for environment := range dispatch.queued {
+			copied.queued[environment] = true
+		}
+
+		dispatches = append(dispatches, copied)
+	}
+	cslShutdown.Unlock()
+
+	// The shutdown deadline has passed by now
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	requeued := 0
+	abandoned := 0
+	for _, dispatch := range dispatches {
+		if dispatch.execution == nil {
+			log.Printf("[WARNING] Execution of workflow %s was still being set up after %s, and wasn't started", dispatch.workflowId, time.Since(dispatch.started).Round(time.Second))
+			abandoned += 1
+			continue
+		}
+
+		execution := *dispatch.execution
+		for _, environment := range dispatch.environments {
+			if dispatch.queued[environment] {
+				continue
+			}
+
+			executionRequest := shuffle.ExecutionRequest{
+				ExecutionId:   execution.ExecutionId,
+				WorkflowId:    execution.Workflow.ID,
+				Authorization: execution.Authorization,
+				Environments:  dispatch.environments,
+			}
+
+			executionRequest.Priority = getCslQueuePriority(ctx, execution)
+			err := shuffle.SetWorkflowQueue(ctx, executionRequest, environment)
+			if err != nil {
+				log.Printf("[ERROR][%s] Failed queueing execution in environment %s during shutdown: %s", execution.ExecutionId, environment, err)
+				reportCslExecutionError(execution, fmt.Sprintf("Failed queueing execution in environment %s during shutdown", environment), err)
+				abandoned += 1
+				continue
+			}
+
+			log.Printf("[INFO][%s] Queued execution in environment %s during shutdown", execution.ExecutionId, environment)
+			requeued += 1
+		}
+	}
+
+	return requeued, abandoned
+}
+
+// Gives up leadership so another replica takes over jobs and schedules right
+// away instead of when the lease expires
+func releaseCslLeadership(ctx context.Context) {
+	if !leaderElectionEnabled() {
+		return
+	}
+
+	cslLeader.Lock()
+	wasLeader := cslLeader.leader
+	cslLeader.leader = false
+	cslLeader.Unlock()
+
+	if !wasLeader {
+		return
+	}
+
+	holder := getCslLeaderHolder()
+	err := shuffle.ReleaseLease(ctx, CslLeaderLease, holder)
+	if err != nil {
+		log.Printf("[WARNING] Failed releasing the leader lease for %s: %s", holder, err)
+		return
+	}
+
+	log.Printf("[INFO] Backend %s released leadership", holder)
+}
+
+func shutdownCsl(server *http.Server) {
+	timeout := getShutdownTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cslShutdown.Lock()
+	cslShutdown.shuttingDown = true
+	cslShutdown.Unlock()
+
+	log.Printf("[INFO] Shutting down. Waiting up to %s for requests and executions in flight", timeout)
+	releaseCslLeadership(ctx)
+
+	// Stops accepting connections, and waits for the requests in flight
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("[WARNING] Requests were still running at shutdown: %s", err)
+	}
+
+	requeued, abandoned := drainCslDispatches(ctx)
+
+	// What the replica keeps in memory would otherwise be lost
+	for _, cslJob := range cslJobs {
+		if cslJob.PerReplica {
+			cslJob.Run(context.Background())
+		}
+	}
+
+	log.Printf("[INFO] Shutdown done. Executions queued: %d, not started: %d", requeued, abandoned)
+}
+
+// Runs serve until a shutdown signal, then shuts the server down gracefully
+func serveCslUntilShutdown(server *http.Server, serve func() error) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	finished := make(chan struct{})
+	go func() {
+		received := <-signals
+		log.Printf("[INFO] Received %s", received)
+
+		go func() {
+			received := <-signals
+			log.Printf("[WARNING] Received %s during shutdown. Exiting right away", received)
+			os.Exit(1)
+		}()
+
+		shutdownCsl(server)
+		close(finished)
+	}()
+
+	err := serve()
+	if err != http.ErrServerClosed {
+		return err
+	}
+
+	<-finished
+	return nil
+}
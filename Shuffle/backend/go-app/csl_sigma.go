@@ -0,0 +1,1021 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/shuffle/shuffle-shared"
+	"gopkg.in/yaml.v3"
+)
+
+const CslSiemDocument = "siem"
+
+const MaxSiemBackends = 20
+const MaxSigmaRuleBytes = 64 * 1024
+
+// SIEM backend types
+const (
+	SiemSplunk   = "splunk"
+	SiemElastic  = "elastic"
+	SiemSentinel = "sentinel"
+)
+
+var siemTypes = []string{SiemSplunk, SiemElastic, SiemSentinel}
+
+var kqlIdentifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Index is the Splunk index, the Elastic index pattern or the Sentinel table
+// queries run against. FieldMapping renames Sigma fields to the fields of the
+// backend, e.g. {"Image": "process.executable"}
+type CslSiemBackend struct {
+	Name         string            `json:"name"`
+	Type         string            `json:"type"`
+	Index        string            `json:"index"`
+	FieldMapping map[string]string `json:"field_mapping"`
+}
+
+type CslSiem struct {
+	Backends []CslSiemBackend `json:"backends"`
+}
+
+type CslSigmaRule struct {
+	Title       string                 `json:"title" yaml:"title"`
+	Id          string                 `json:"id" yaml:"id"`
+	Status      string                 `json:"status" yaml:"status"`
+	Description string                 `json:"description" yaml:"description"`
+	Level       string                 `json:"level" yaml:"level"`
+	Tags        []string               `json:"tags" yaml:"tags"`
+	Logsource   map[string]string      `json:"logsource" yaml:"logsource"`
+	Detection   map[string]interface{} `json:"-" yaml:"detection"`
+}
+
+// Query is SPL or KQL text, or the Elastic query DSL
+type CslSigmaQuery struct {
+	Backend string      `json:"backend"`
+	Type    string      `json:"type"`
+	Index   string      `json:"index"`
+	Query   interface{} `json:"query"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// Match kinds of a detection node
+const (
+	sigmaGlob   = "glob"
+	sigmaRegex  = "re"
+	sigmaCidr   = "cidr"
+	sigmaExists = "exists"
+	sigmaNull   = "null"
+	sigmaValue  = "value"
+)
+
+// A detection as a tree of and, or and not nodes with field matches and
+// keywords as leaves. Glob values use the Sigma wildcards, where * and ?
+// match unless escaped with a backslash
+type sigmaNode struct {
+	Op       string
+	Children []*sigmaNode
+	Field    string
+	Kind     string
+	Value    interface{}
+}
+
+type sigmaGlobPart struct {
+	Text     string
+	Wildcard byte
+}
+
+func getCslSiem(ctx context.Context, orgId string) CslSiem {
+	siem := CslSiem{}
+	_, err := getCslDocument(ctx, orgId, CslSiemDocument, &siem)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting siem backends for org %s: %s", orgId, err)
+	}
+
+	if siem.Backends == nil {
+		siem.Backends = []CslSiemBackend{}
+	}
+
+	return siem
+}
+
+func validateCslSiem(siem CslSiem) error {
+	if len(siem.Backends) > MaxSiemBackends {
+		return errors.New(fmt.Sprintf("can't have more than %d backends", MaxSiemBackends))
+	}
+
+	names := []string{}
+	for _, backend := range siem.Backends {
+		if len(backend.Name) == 0 {
+			return errors.New("backends need a name")
+		}
+
+		if shuffle.ArrayContains(names, backend.Name) {
+			return errors.New(fmt.Sprintf("duplicate backend %s", backend.Name))
+		}
+
+		if !shuffle.ArrayContains(siemTypes, backend.Type) {
+			return errors.New(fmt.Sprintf("type of backend %s must be one of %s", backend.Name, strings.Join(siemTypes, ", ")))
+		}
+
+		names = append(names, backend.Name)
+	}
+
+	return nil
+}
+
+func parseSigmaGlob(pattern string) []sigmaGlobPart {
+	parts := []sigmaGlobPart{}
+	text := ""
+	for i := 0; i < len(pattern); i++ {
+		char := pattern[i]
+		if char == '\\' && i+1 < len(pattern) && strings.ContainsRune(`*?\`, rune(pattern[i+1])) {
+			text += string(pattern[i+1])
+			i += 1
+			continue
+		}
+
+		if char == '*' || char == '?' {
+			if len(text) > 0 {
+				parts = append(parts, sigmaGlobPart{Text: text})
+				text = ""
+			}
+
+			parts = append(parts, sigmaGlobPart{Wildcard: char})
+			continue
+		}
+
+		text += string(char)
+	}
+
+	if len(text) > 0 {
+		parts = append(parts, sigmaGlobPart{Text: text})
+	}
+
+	return parts
+}
+
+func newSigmaNode(op string, children []*sigmaNode) *sigmaNode {
+	if len(children) == 1 {
+		return children[0]
+	}
+
+	return &sigmaNode{Op: op, Children: children}
+}
+
+func parseSigmaValue(field string, modifiers []string, value interface{}) (*sigmaNode, error) {
+	node := &sigmaNode{Op: "match", Field: field, Kind: sigmaGlob}
+	if len(field) == 0 {
+		node.Op = "keyword"
+	}
+
+	if shuffle.ArrayContains(modifiers, "exists") {
+		exists, ok := value.(bool)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("exists modifier of %s needs true or false", field))
+		}
+
+		node.Kind = sigmaExists
+		node.Value = exists
+		return node, nil
+	}
+
+	switch typedValue := value.(type) {
+	case nil:
+		node.Kind = sigmaNull
+		return node, nil
+	case int, int64, float64, bool:
+		if len(modifiers) > 0 {
+			return nil, errors.New(fmt.Sprintf("modifiers of %s only apply to strings", field))
+		}
+
+		node.Kind = sigmaValue
+		node.Value = typedValue
+		return node, nil
+	case string:
+		node.Value = typedValue
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported value for %s", field))
+	}
+
+	for _, modifier := range modifiers {
+		switch modifier {
+		case "contains":
+			node.Value = "*" + node.Value.(string) + "*"
+		case "startswith":
+			node.Value = node.Value.(string) + "*"
+		case "endswith":
+			node.Value = "*" + node.Value.(string)
+		case "re":
+			node.Kind = sigmaRegex
+		case "cidr":
+			node.Kind = sigmaCidr
+		case "all":
+		default:
+			return nil, errors.New(fmt.Sprintf("modifier %s isn't supported", modifier))
+		}
+	}
+
+	return node, nil
+}
+
+// Fields in a map are and'ed, and a list of values is or'ed unless the all
+// modifier is used
+func parseSigmaSelection(selection interface{}) (*sigmaNode, error) {
+	switch typedSelection := selection.(type) {
+	case map[string]interface{}:
+		keys := []string{}
+		for key := range typedSelection {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+		children := []*sigmaNode{}
+		for _, key := range keys {
+			parts := strings.Split(key, "|")
+			field, modifiers := parts[0], parts[1:]
+
+			values, ok := typedSelection[key].([]interface{})
+			if !ok {
+				values = []interface{}{typedSelection[key]}
+			}
+
+			if len(values) == 0 {
+				return nil, errors.New(fmt.Sprintf("%s has no values", field))
+			}
+
+			valueNodes := []*sigmaNode{}
+			for _, value := range values {
+				node, err := parseSigmaValue(field, modifiers, value)
+				if err != nil {
+					return nil, err
+				}
+
+				valueNodes = append(valueNodes, node)
+			}
+
+			op := "or"
+			if shuffle.ArrayContains(modifiers, "all") {
+				op = "and"
+			}
+
+			children = append(children, newSigmaNode(op, valueNodes))
+		}
+
+		if len(children) == 0 {
+			return nil, errors.New("empty selection")
+		}
+
+		return newSigmaNode("and", children), nil
+	case []interface{}:
+		children := []*sigmaNode{}
+		for _, item := range typedSelection {
+			var node *sigmaNode
+			var err error
+			if _, ok := item.(map[string]interface{}); ok {
+				node, err = parseSigmaSelection(item)
+			} else {
+				node, err = parseSigmaValue("", []string{}, item)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			children = append(children, node)
+		}
+
+		if len(children) == 0 {
+			return nil, errors.New("empty selection")
+		}
+
+		return newSigmaNode("or", children), nil
+	case string:
+		return parseSigmaValue("", []string{}, typedSelection)
+	}
+
+	return nil, errors.New("selections must be a map or a list")
+}
+
+type sigmaConditionParser struct {
+	tokens     []string
+	pos        int
+	selections map[string]*sigmaNode
+}
+
+func (p *sigmaConditionParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+
+	return p.tokens[p.pos]
+}
+
+func (p *sigmaConditionParser) next() string {
+	token := p.peek()
+	p.pos += 1
+	return token
+}
+
+func (p *sigmaConditionParser) parseOr() (*sigmaNode, error) {
+	children := []*sigmaNode{}
+	for {
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		children = append(children, node)
+		if strings.ToLower(p.peek()) != "or" {
+			return newSigmaNode("or", children), nil
+		}
+
+		p.next()
+	}
+}
+
+func (p *sigmaConditionParser) parseAnd() (*sigmaNode, error) {
+	children := []*sigmaNode{}
+	for {
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		children = append(children, node)
+		if strings.ToLower(p.peek()) != "and" {
+			return newSigmaNode("and", children), nil
+		}
+
+		p.next()
+	}
+}
+
+func (p *sigmaConditionParser) parseNot() (*sigmaNode, error) {
+	if strings.ToLower(p.peek()) != "not" {
+		return p.parsePrimary()
+	}
+
+	p.next()
+	node, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &sigmaNode{Op: "not", Children: []*sigmaNode{node}}, nil
+}
+
+// Selections matching a name where * is a wildcard, or every selection for them
+func (p *sigmaConditionParser) matchSelections(pattern string) []*sigmaNode {
+	names := []string{}
+	for name := range p.selections {
+		if pattern == "them" || name == pattern || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(name, strings.TrimSuffix(pattern, "*"))) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	nodes := []*sigmaNode{}
+	for _, name := range names {
+		nodes = append(nodes, p.selections[name])
+	}
+
+	return nodes
+}
+
+func (p *sigmaConditionParser) parsePrimary() (*sigmaNode, error) {
+	token := p.next()
+	switch strings.ToLower(token) {
+	case "":
+		return nil, errors.New("unexpected end of condition")
+	case "(":
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.next() != ")" {
+			return nil, errors.New("expected ) in condition")
+		}
+
+		return node, nil
+	case "1", "any", "all":
+		if strings.ToLower(p.next()) != "of" {
+			return nil, errors.New(fmt.Sprintf("expected of after %s in condition", token))
+		}
+
+		pattern := p.next()
+		nodes := p.matchSelections(pattern)
+		if len(nodes) == 0 {
+			return nil, errors.New(fmt.Sprintf("no selections match %s", pattern))
+		}
+
+		if strings.ToLower(token) == "all" {
+			return newSigmaNode("and", nodes), nil
+		}
+
+		return newSigmaNode("or", nodes), nil
+	case "|":
+		return nil, errors.New("aggregations aren't supported")
+	}
+
+	node, ok := p.selections[token]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("undefined selection %s in condition", token))
+	}
+
+	return node, nil
+}
+
+// Parses the detection of a rule into a single tree
+func parseSigmaDetection(detection map[string]interface{}) (*sigmaNode, error) {
+	conditions := []string{}
+	switch condition := detection["condition"].(type) {
+	case string:
+		conditions = append(conditions, condition)
+	case []interface{}:
+		for _, item := range condition {
+			if text, ok := item.(string); ok {
+				conditions = append(conditions, text)
+			}
+		}
+	}
+
+	if len(conditions) == 0 {
+		return nil, errors.New("detection needs a condition")
+	}
+
+	selections := map[string]*sigmaNode{}
+	for name, selection := range detection {
+		if name == "condition" || name == "timeframe" {
+			continue
+		}
+
+		node, err := parseSigmaSelection(selection)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("selection %s: %s", name, err))
+		}
+
+		selections[name] = node
+	}
+
+	nodes := []*sigmaNode{}
+	for _, condition := range conditions {
+		tokens := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ", "|", " | ").Replace(condition))
+		parser := &sigmaConditionParser{tokens: tokens, selections: selections}
+		node, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if parser.pos < len(tokens) {
+			return nil, errors.New(fmt.Sprintf("unexpected %s in condition", parser.peek()))
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return newSigmaNode("or", nodes), nil
+}
+
+func parseSigmaRule(source []byte) (CslSigmaRule, *sigmaNode, error) {
+	rule := CslSigmaRule{}
+	err := yaml.Unmarshal(source, &rule)
+	if err != nil {
+		return rule, nil, errors.New(fmt.Sprintf("invalid sigma rule: %s", err))
+	}
+
+	if len(rule.Title) == 0 || len(rule.Detection) == 0 {
+		return rule, nil, errors.New("sigma rules need a title and detection")
+	}
+
+	if rule.Tags == nil {
+		rule.Tags = []string{}
+	}
+
+	if rule.Logsource == nil {
+		rule.Logsource = map[string]string{}
+	}
+
+	node, err := parseSigmaDetection(rule.Detection)
+	return rule, node, err
+}
+
+func getSiemField(backend CslSiemBackend, field string) string {
+	if mapped, ok := backend.FieldMapping[field]; ok && len(mapped) > 0 {
+		return mapped
+	}
+
+	return field
+}
+
+func quoteSiemString(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// Wraps and/or children of a different operator in parentheses
+func joinSiemChildren(node *sigmaNode, separator string, render func(*sigmaNode) (string, error)) (string, error) {
+	rendered := []string{}
+	for _, child := range node.Children {
+		text, err := render(child)
+		if err != nil {
+			return "", err
+		}
+
+		if (child.Op == "and" || child.Op == "or") && child.Op != node.Op {
+			text = "(" + text + ")"
+		}
+
+		rendered = append(rendered, text)
+	}
+
+	return strings.Join(rendered, separator), nil
+}
+
+func renderSplunk(backend CslSiemBackend, node *sigmaNode) (string, error) {
+	render := func(child *sigmaNode) (string, error) {
+		return renderSplunk(backend, child)
+	}
+
+	switch node.Op {
+	case "and":
+		return joinSiemChildren(node, " AND ", render)
+	case "or":
+		return joinSiemChildren(node, " OR ", render)
+	case "not":
+		child, err := render(node.Children[0])
+		return "NOT (" + child + ")", err
+	}
+
+	value := ""
+	switch node.Kind {
+	case sigmaGlob:
+		for _, part := range parseSigmaGlob(node.Value.(string)) {
+			if part.Wildcard != 0 {
+				value += "*"
+			} else {
+				value += part.Text
+			}
+		}
+
+		value = quoteSiemString(value)
+	case sigmaCidr:
+		value = quoteSiemString(node.Value.(string))
+	case sigmaValue:
+		value = quoteSiemString(fmt.Sprintf("%v", node.Value))
+	case sigmaRegex:
+		return "", errors.New("regular expressions aren't supported in SPL search conditions")
+	}
+
+	if node.Op == "keyword" {
+		if node.Kind != sigmaGlob {
+			return "", errors.New("keywords must be strings")
+		}
+
+		return value, nil
+	}
+
+	field := getSiemField(backend, node.Field)
+	switch node.Kind {
+	case sigmaNull:
+		return fmt.Sprintf("NOT %s=*", field), nil
+	case sigmaExists:
+		if node.Value.(bool) {
+			return fmt.Sprintf("%s=*", field), nil
+		}
+
+		return fmt.Sprintf("NOT %s=*", field), nil
+	}
+
+	return fmt.Sprintf("%s=%s", field, value), nil
+}
+
+// Escapes the characters with a meaning in Elastic wildcard queries
+func escapeElasticWildcard(parts []sigmaGlobPart) string {
+	value := ""
+	for _, part := range parts {
+		if part.Wildcard != 0 {
+			value += string(part.Wildcard)
+		} else {
+			value += strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`).Replace(part.Text)
+		}
+	}
+
+	return value
+}
+
+func escapeLucene(parts []sigmaGlobPart) string {
+	value := ""
+	for _, part := range parts {
+		if part.Wildcard != 0 {
+			value += string(part.Wildcard)
+			continue
+		}
+
+		for _, char := range part.Text {
+			if strings.ContainsRune(`+-=&|><!(){}[]^"~*?:\/ `, char) {
+				value += `\`
+			}
+
+			value += string(char)
+		}
+	}
+
+	return value
+}
+
+func renderElastic(backend CslSiemBackend, node *sigmaNode) (map[string]interface{}, error) {
+	switch node.Op {
+	case "and", "or", "not":
+		children := []interface{}{}
+		for _, child := range node.Children {
+			rendered, err := renderElastic(backend, child)
+			if err != nil {
+				return nil, err
+			}
+
+			children = append(children, rendered)
+		}
+
+		switch node.Op {
+		case "and":
+			return map[string]interface{}{"bool": map[string]interface{}{"must": children}}, nil
+		case "or":
+			return map[string]interface{}{"bool": map[string]interface{}{"should": children, "minimum_should_match": 1}}, nil
+		}
+
+		return map[string]interface{}{"bool": map[string]interface{}{"must_not": children}}, nil
+	case "keyword":
+		if node.Kind != sigmaGlob {
+			return nil, errors.New("keywords must be strings")
+		}
+
+		return map[string]interface{}{"query_string": map[string]interface{}{"query": escapeLucene(parseSigmaGlob(node.Value.(string)))}}, nil
+	}
+
+	field := getSiemField(backend, node.Field)
+	switch node.Kind {
+	case sigmaGlob:
+		return map[string]interface{}{"wildcard": map[string]interface{}{
+			field: map[string]interface{}{"value": escapeElasticWildcard(parseSigmaGlob(node.Value.(string))), "case_insensitive": true},
+		}}, nil
+	case sigmaRegex:
+		return map[string]interface{}{"regexp": map[string]interface{}{field: map[string]interface{}{"value": node.Value}}}, nil
+	case sigmaNull:
+		return map[string]interface{}{"bool": map[string]interface{}{"must_not": []interface{}{
+			map[string]interface{}{"exists": map[string]interface{}{"field": field}},
+		}}}, nil
+	case sigmaExists:
+		exists := map[string]interface{}{"exists": map[string]interface{}{"field": field}}
+		if node.Value.(bool) {
+			return exists, nil
+		}
+
+		return map[string]interface{}{"bool": map[string]interface{}{"must_not": []interface{}{exists}}}, nil
+	}
+
+	// CIDR ranges work in term queries on ip fields
+	return map[string]interface{}{"term": map[string]interface{}{field: node.Value}}, nil
+}
+
+func quoteKqlField(field string) string {
+	if kqlIdentifierPattern.MatchString(field) {
+		return field
+	}
+
+	return fmt.Sprintf("['%s']", strings.ReplaceAll(field, "'", `\'`))
+}
+
+func renderKql(backend CslSiemBackend, node *sigmaNode) (string, error) {
+	render := func(child *sigmaNode) (string, error) {
+		return renderKql(backend, child)
+	}
+
+	switch node.Op {
+	case "and":
+		return joinSiemChildren(node, " and ", render)
+	case "or":
+		return joinSiemChildren(node, " or ", render)
+	case "not":
+		child, err := render(node.Children[0])
+		return "not(" + child + ")", err
+	}
+
+	field := "*"
+	if node.Op == "match" {
+		field = quoteKqlField(getSiemField(backend, node.Field))
+	}
+
+	switch node.Kind {
+	case sigmaNull:
+		return fmt.Sprintf("isempty(%s)", field), nil
+	case sigmaExists:
+		if node.Value.(bool) {
+			return fmt.Sprintf("isnotempty(%s)", field), nil
+		}
+
+		return fmt.Sprintf("isempty(%s)", field), nil
+	case sigmaValue:
+		if flag, ok := node.Value.(bool); ok {
+			return fmt.Sprintf("%s == %t", field, flag), nil
+		}
+
+		return fmt.Sprintf("%s == %v", field, node.Value), nil
+	case sigmaCidr:
+		return fmt.Sprintf("ipv4_is_in_range(%s, %s)", field, quoteSiemString(node.Value.(string))), nil
+	case sigmaRegex:
+		return fmt.Sprintf("%s matches regex %s", field, quoteSiemString(node.Value.(string))), nil
+	}
+
+	// Leading and trailing wildcards map to the string operators, others to a regex
+	parts := parseSigmaGlob(node.Value.(string))
+	leading := len(parts) > 0 && parts[0].Wildcard == '*'
+	trailing := len(parts) > 1 && parts[len(parts)-1].Wildcard == '*'
+	inner := parts
+	if leading {
+		inner = inner[1:]
+	}
+
+	if trailing {
+		inner = inner[:len(inner)-1]
+	}
+
+	if len(inner) == 1 && inner[0].Wildcard == 0 {
+		value := quoteSiemString(inner[0].Text)
+		switch {
+		case node.Op == "keyword" || (leading && trailing):
+			return fmt.Sprintf("%s contains %s", field, value), nil
+		case leading:
+			return fmt.Sprintf("%s endswith %s", field, value), nil
+		case trailing:
+			return fmt.Sprintf("%s startswith %s", field, value), nil
+		}
+
+		return fmt.Sprintf("%s =~ %s", field, value), nil
+	}
+
+	if node.Op == "keyword" {
+		return "", errors.New("keywords with wildcards in the middle aren't supported in KQL")
+	}
+
+	pattern := ""
+	for _, part := range parts {
+		switch part.Wildcard {
+		case '*':
+			pattern += ".*"
+		case '?':
+			pattern += "."
+		default:
+			pattern += regexp.QuoteMeta(part.Text)
+		}
+	}
+
+	return fmt.Sprintf("%s matches regex %s", field, quoteSiemString("(?i)^"+pattern+"$")), nil
+}
+
+// Translates a parsed rule for a backend. Errors are set on the query so one
+// unsupported backend doesn't fail the others
+func translateSigmaRule(backend CslSiemBackend, node *sigmaNode) CslSigmaQuery {
+	query := CslSigmaQuery{
+		Backend: backend.Name,
+		Type:    backend.Type,
+		Index:   backend.Index,
+	}
+
+	var err error
+	switch backend.Type {
+	case SiemSplunk:
+		query.Query, err = renderSplunk(backend, node)
+		if err == nil && len(backend.Index) > 0 {
+			query.Query = fmt.Sprintf("index=%s (%s)", backend.Index, query.Query)
+		}
+	case SiemElastic:
+		var dsl map[string]interface{}
+		dsl, err = renderElastic(backend, node)
+		query.Query = map[string]interface{}{"query": dsl}
+		if len(query.Index) == 0 {
+			query.Index = "*"
+		}
+	case SiemSentinel:
+		var kql string
+		kql, err = renderKql(backend, node)
+		table := backend.Index
+		if len(table) == 0 {
+			table = "union *"
+		}
+
+		query.Query = fmt.Sprintf("%s\n| where %s", table, kql)
+		query.Index = table
+	}
+
+	if err != nil {
+		query.Query = nil
+		query.Error = err.Error()
+	}
+
+	return query
+}
+
+/*
+Detection:
+Translates a Sigma rule into queries for the SIEM backends of the current
+organization. The body is the rule as YAML. Optional ?backends=<a,b> limits
+the configured backends, and ?types=<splunk,elastic,sentinel> translates
+for default backends without configuration, which is also used when none are
+configured. Backends that can't express the rule return an error next to the
+others.
+
+	{
+	    "success": true,
+	    "data": {
+	        "rule": {"title": "Suspicious certutil download", "id": "...", "level": "high", ...},
+	        "queries": [
+	            {
+	                "backend": "splunk",
+	                "type": "splunk",
+	                "index": "windows",
+	                "query": "index=windows (Image=\"*\\\\certutil.exe\" AND CommandLine=\"*urlcache*\")"
+	            },
+	            {
+	                "backend": "sentinel",
+	                "type": "sentinel",
+	                "index": "SecurityEvent",
+	                "query": "SecurityEvent\n| where Image endswith \"\\\\certutil.exe\" and CommandLine contains \"urlcache\""
+	            }
+	        ]
+	    }
+	}
+*/
+func cslTranslateSigma(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(resp, request.Body, MaxSigmaRuleBytes))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("failed reading rule. Max size is %d bytes", MaxSigmaRuleBytes))))
+		return
+	}
+
+	rule, node, err := parseSigmaRule(body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	backends := []CslSiemBackend{}
+	if len(query.Get("types")) > 0 {
+		for _, backendType := range strings.Split(query.Get("types"), ",") {
+			if !shuffle.ArrayContains(siemTypes, backendType) {
+				resp.WriteHeader(400)
+				resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("types must be one of %s", strings.Join(siemTypes, ", ")))))
+				return
+			}
+
+			backends = append(backends, CslSiemBackend{Name: backendType, Type: backendType})
+		}
+	} else {
+		names := []string{}
+		if len(query.Get("backends")) > 0 {
+			names = strings.Split(query.Get("backends"), ",")
+		}
+
+		configured := getCslSiem(ctx, user.ActiveOrg.Id).Backends
+		for _, backend := range configured {
+			if len(names) == 0 || shuffle.ArrayContains(names, backend.Name) {
+				backends = append(backends, backend)
+			}
+		}
+
+		if len(names) > 0 && len(backends) != len(names) {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New("backends contains an unknown backend")))
+			return
+		}
+
+		if len(configured) == 0 {
+			for _, backendType := range siemTypes {
+				backends = append(backends, CslSiemBackend{Name: backendType, Type: backendType})
+			}
+		}
+	}
+
+	queries := []CslSigmaQuery{}
+	for _, backend := range backends {
+		queries = append(queries, translateSigmaRule(backend, node))
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"rule":    rule,
+			"queries": queries,
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslTranslateSigma")
+}
+
+/*
+Detection:
+Returns the SIEM backends Sigma rules are translated for. Requires org admin.
+
+	{
+	    "success": true,
+	    "data": {
+	        "backends": [
+	            {
+	                "name": "splunk-prod",
+	                "type": "splunk",
+	                "index": "windows",
+	                "field_mapping": {"Image": "process_path"}
+	            }
+	        ]
+	    }
+	}
+*/
+func cslGetSiem(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslSiem(ctx, user.ActiveOrg.Id),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetSiem")
+}
+
+/*
+Detection:
+Replaces the SIEM backends. Requires org admin. Body uses the format returned
+from GET, and type is one of splunk, elastic or sentinel.
+*/
+func cslSetSiem(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	siem := CslSiem{}
+	err = json.Unmarshal(body, &siem)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if siem.Backends == nil {
+		siem.Backends = []CslSiemBackend{}
+	}
+
+	err = validateCslSiem(siem)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslSiemDocument, siem)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated siem config for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "siem_updated", "SIEM backends were updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    siem,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetSiem")
+}
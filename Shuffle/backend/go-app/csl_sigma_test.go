@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseSigmaRuleErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		err    string
+	}{
+		{
+			name:   "no detection",
+			source: "title: t",
+			err:    "sigma rules need a title and detection",
+		},
+		{
+			name:   "no title",
+			source: "detection:\n  sel:\n    a: b\n  condition: sel",
+			err:    "sigma rules need a title and detection",
+		},
+		{
+			name:   "no condition",
+			source: "title: t\ndetection:\n  sel:\n    a: b",
+			err:    "detection needs a condition",
+		},
+		{
+			name:   "undefined selection",
+			source: "title: t\ndetection:\n  sel:\n    a: b\n  condition: other",
+			err:    "undefined selection other in condition",
+		},
+		{
+			name:   "unknown modifier",
+			source: "title: t\ndetection:\n  sel:\n    a|foo: b\n  condition: sel",
+			err:    "selection sel: modifier foo isn't supported",
+		},
+		{
+			name:   "incomplete condition",
+			source: "title: t\ndetection:\n  sel:\n    a: b\n  condition: sel and",
+			err:    "unexpected end of condition",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := parseSigmaRule([]byte(test.source))
+			if err == nil || err.Error() != test.err {
+				t.Errorf("parseSigmaRule returned %v, expected %s", err, test.err)
+			}
+		})
+	}
+}
+
+func TestTranslateSigmaRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		backend  CslSiemBackend
+		expected string
+		err      string
+	}{
+		{
+			name:     "splunk modifiers",
+			source:   "title: t\ndetection:\n  sel:\n    Image|endswith: '\\certutil.exe'\n    CommandLine|contains: urlcache\n  condition: sel",
+			backend:  CslSiemBackend{Name: "splunk", Type: SiemSplunk},
+			expected: `CommandLine="*urlcache*" AND Image="*\\certutil.exe"`,
+		},
+		{
+			name:     "splunk index",
+			source:   "title: t\ndetection:\n  sel:\n    User: admin\n  condition: sel",
+			backend:  CslSiemBackend{Name: "splunk", Type: SiemSplunk, Index: "windows"},
+			expected: `index=windows (User="admin")`,
+		},
+		{
+			name:     "splunk field mapping",
+			source:   "title: t\ndetection:\n  sel:\n    User: admin\n  condition: sel",
+			backend:  CslSiemBackend{Name: "splunk", Type: SiemSplunk, FieldMapping: map[string]string{"User": "user.name"}},
+			expected: `user.name="admin"`,
+		},
+		{
+			name:     "splunk lists and not",
+			source:   "title: t\ndetection:\n  sel:\n    EventID:\n      - 4624\n      - 4625\n  filter:\n    User: SYSTEM\n  condition: sel and not filter",
+			backend:  CslSiemBackend{Name: "splunk", Type: SiemSplunk},
+			expected: `(EventID="4624" OR EventID="4625") AND NOT (User="SYSTEM")`,
+		},
+		{
+			name:    "splunk regex",
+			source:  "title: t\ndetection:\n  sel:\n    b|re: '^ab+$'\n  condition: sel",
+			backend: CslSiemBackend{Name: "splunk", Type: SiemSplunk},
+			err:     "regular expressions aren't supported in SPL search conditions",
+		},
+		{
+			name:     "splunk keywords",
+			source:   "title: t\ndetection:\n  keywords:\n    - mimikatz\n  condition: keywords",
+			backend:  CslSiemBackend{Name: "splunk", Type: SiemSplunk},
+			expected: `"mimikatz"`,
+		},
+		{
+			name:     "sentinel modifiers",
+			source:   "title: t\ndetection:\n  sel:\n    Image|endswith: '\\certutil.exe'\n    CommandLine|contains: urlcache\n  condition: sel",
+			backend:  CslSiemBackend{Name: "sentinel", Type: SiemSentinel, Index: "SecurityEvent"},
+			expected: "SecurityEvent\n| where CommandLine contains \"urlcache\" and Image endswith \"\\\\certutil.exe\"",
+		},
+		{
+			name:     "sentinel lists and not",
+			source:   "title: t\ndetection:\n  sel:\n    EventID:\n      - 4624\n      - 4625\n  filter:\n    User: SYSTEM\n  condition: sel and not filter",
+			backend:  CslSiemBackend{Name: "sentinel", Type: SiemSentinel},
+			expected: "union *\n| where (EventID == 4624 or EventID == 4625) and not(User =~ \"SYSTEM\")",
+		},
+		{
+			name:     "sentinel wildcard selections",
+			source:   "title: t\ndetection:\n  sel1:\n    a: x*y\n  sel2:\n    b|re: '^ab+$'\n  condition: 1 of sel*",
+			backend:  CslSiemBackend{Name: "sentinel", Type: SiemSentinel},
+			expected: "union *\n| where a matches regex \"(?i)^x.*y$\" or b matches regex \"^ab+$\"",
+		},
+		{
+			name:     "sentinel cidr",
+			source:   "title: t\ndetection:\n  sel:\n    src|cidr: 10.0.0.0/8\n  condition: sel",
+			backend:  CslSiemBackend{Name: "sentinel", Type: SiemSentinel},
+			expected: "union *\n| where ipv4_is_in_range(src, \"10.0.0.0/8\")",
+		},
+		{
+			name:     "elastic lists and not",
+			source:   "title: t\ndetection:\n  sel:\n    EventID:\n      - 4624\n      - 4625\n  filter:\n    User: SYSTEM\n  condition: sel and not filter",
+			backend:  CslSiemBackend{Name: "elastic", Type: SiemElastic},
+			expected: `{"query":{"bool":{"must":[{"bool":{"minimum_should_match":1,"should":[{"term":{"EventID":4624}},{"term":{"EventID":4625}}]}},{"bool":{"must_not":[{"wildcard":{"User":{"case_insensitive":true,"value":"SYSTEM"}}}]}}]}}}`,
+		},
+		{
+			name:     "elastic regex",
+			source:   "title: t\ndetection:\n  sel:\n    b|re: '^ab+$'\n  condition: sel",
+			backend:  CslSiemBackend{Name: "elastic", Type: SiemElastic},
+			expected: `{"query":{"regexp":{"b":{"value":"^ab+$"}}}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, node, err := parseSigmaRule([]byte(test.source))
+			if err != nil {
+				t.Fatalf("parseSigmaRule failed: %s", err)
+			}
+
+			query := translateSigmaRule(test.backend, node)
+			if query.Error != test.err {
+				t.Fatalf("got error %q, expected %q", query.Error, test.err)
+			}
+
+			if len(test.err) > 0 {
+				return
+			}
+
+			text, ok := query.Query.(string)
+			if !ok {
+				b, err := json.Marshal(query.Query)
+				if err != nil {
+					t.Fatalf("failed marshalling query: %s", err)
+				}
+
+				text = string(b)
+			}
+
+			if text != test.expected {
+				t.Errorf("got query %s, expected %s", text, test.expected)
+			}
+		})
+	}
+}
+
+func TestValidateCslSiem(t *testing.T) {
+	tests := []struct {
+		name  string
+		siem  CslSiem
+		valid bool
+	}{
+		{name: "empty", siem: CslSiem{}, valid: true},
+		{name: "valid", siem: CslSiem{Backends: []CslSiemBackend{{Name: "a", Type: SiemSplunk}, {Name: "b", Type: SiemElastic}}}, valid: true},
+		{name: "missing name", siem: CslSiem{Backends: []CslSiemBackend{{Type: SiemSplunk}}}},
+		{name: "duplicate name", siem: CslSiem{Backends: []CslSiemBackend{{Name: "a", Type: SiemSplunk}, {Name: "a", Type: SiemSentinel}}}},
+		{name: "unknown type", siem: CslSiem{Backends: []CslSiemBackend{{Name: "a", Type: "qradar"}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateCslSiem(test.siem)
+			if (err == nil) != test.valid {
+				t.Errorf("validateCslSiem returned %v, expected valid=%t", err, test.valid)
+			}
+		})
+	}
+}
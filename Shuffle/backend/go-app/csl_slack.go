@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslSlackDocument = "slack"
+
+const SlackPostMessageUrl = "https://slack.com/api/chat.postMessage"
+
+// Slack limits for header and section text
+const (
+	SlackMaxHeaderLength  = 150
+	SlackMaxTextLength    = 3000
+	SlackMaxSectionFields = 10
+)
+
+// Sends events with severity of at least MinSeverity to Channel. An empty
+// MinSeverity matches all, and "*" in Events matches every event except
+// execution_failed, which has to be listed
+type CslSlackRoute struct {
+	Events      []string `json:"events"`
+	Channel     string   `json:"channel"`
+	MinSeverity string   `json:"min_severity"`
+}
+
+// Token is a bot token with chat:write, which allows routing to channels.
+// WebhookUrl is an incoming webhook, which always posts to its own channel
+type CslSlackConfig struct {
+	Enabled        bool            `json:"enabled"`
+	Token          string          `json:"token"`
+	WebhookUrl     string          `json:"webhook_url"`
+	DefaultChannel string          `json:"default_channel"`
+	Routes         []CslSlackRoute `json:"routes"`
+}
+
+type CslSlackState struct {
+	LastSent  int64  `json:"last_sent"`
+	LastError string `json:"last_error"`
+}
+
+type CslSlack struct {
+	Config CslSlackConfig `json:"config"`
+	State  CslSlackState  `json:"state"`
+}
+
+var slackSeverityEmoji = map[string]string{
+	SeverityInfo:     ":information_source:",
+	SeverityWarning:  ":warning:",
+	SeverityCritical: ":rotating_light:",
+}
+
+func getCslSlack(ctx context.Context, orgId string) CslSlack {
+	slack := CslSlack{}
+	_, err := getCslDocument(ctx, orgId, CslSlackDocument, &slack)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting slack config for org %s: %s", orgId, err)
+	}
+
+	if slack.Config.Routes == nil {
+		slack.Config.Routes = []CslSlackRoute{}
+	}
+
+	return slack
+}
+
+func updateCslSlackState(ctx context.Context, orgId string, update func(state *CslSlackState)) error {
+	slack := CslSlack{}
+	return updateCslDocument(ctx, orgId, CslSlackDocument, &slack, func() error {
+		update(&slack.State)
+		return nil
+	})
+}
+
+func redactCslSlack(slack CslSlack) CslSlack {
+	if len(slack.Config.Token) > 0 {
+		slack.Config.Token = RedactedValue
+	}
+
+	if len(slack.Config.WebhookUrl) > 0 {
+		slack.Config.WebhookUrl = RedactedValue
+	}
+
+	return slack
+}
+
+func validateCslSlackConfig(config CslSlackConfig) error {
+	if len(config.WebhookUrl) > 0 && !strings.HasPrefix(config.WebhookUrl, "https://") {
+		return errors.New("webhook_url must start with https://")
+	}
+
+	if len(config.Token) > 0 && !strings.HasPrefix(config.Token, "xoxb-") {
+		return errors.New("token must be a bot token starting with xoxb-")
+	}
+
+	for _, route := range config.Routes {
+		if len(route.Channel) == 0 {
+			return errors.New("every route needs a channel")
+		}
+
+		if len(route.Events) == 0 {
+			return errors.New(fmt.Sprintf("route to %s needs at least one event", route.Channel))
+		}
+
+		err := validateEventFilter(route.Events, route.MinSeverity)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !config.Enabled {
+		return nil
+	}
+
+	if len(config.Token) == 0 && len(config.WebhookUrl) == 0 {
+		return errors.New("token or webhook_url is required")
+	}
+
+	if len(config.Token) == 0 && len(config.Routes) > 0 {
+		return errors.New("routing to channels requires a bot token")
+	}
+
+	if len(config.Token) > 0 && len(config.DefaultChannel) == 0 && len(config.Routes) == 0 {
+		return errors.New("default_channel or at least one route is required")
+	}
+
+	return nil
+}
+
+// Channels of every matching route, or the default channel if no route
+// matches. Incoming webhooks have a single channel, returned as ""
+func getSlackChannels(config CslSlackConfig, notification CslNotification) []string {
+	if len(config.Token) == 0 {
+		return []string{""}
+	}
+
+	channels := []string{}
+	for _, route := range config.Routes {
+		if eventMatches(route.Events, route.MinSeverity, notification) && !shuffle.ArrayContains(channels, route.Channel) {
+			channels = append(channels, route.Channel)
+		}
+	}
+
+	if len(channels) == 0 && len(config.DefaultChannel) > 0 {
+		channels = append(channels, config.DefaultChannel)
+	}
+
+	return channels
+}
+
+func truncateText(text string, maxLength int) string {
+	runes := []rune(text)
+	if len(runes) <= maxLength {
+		return text
+	}
+
+	return string(runes[:maxLength-3]) + "..."
+}
+
+// Slack mrkdwn requires &, < and > to be escaped
+func escapeSlackText(text string, maxLength int) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(truncateText(text, maxLength))
+}
+
+func getSlackMessage(channel string, notification CslNotification) map[string]interface{} {
+	title := truncateText(fmt.Sprintf("%s %s", slackSeverityEmoji[notification.Severity], notification.Title), SlackMaxHeaderLength)
+	blocks := []map[string]interface{}{
+		{
+			"type": "header",
+			"text": map[string]interface{}{"type": "plain_text", "text": title, "emoji": true},
+		},
+	}
+
+	if len(notification.Text) > 0 {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section",
+			"text": map[string]interface{}{"type": "mrkdwn", "text": escapeSlackText(notification.Text, SlackMaxTextLength)},
+		})
+	}
+
+	for i := 0; i < len(notification.Fields); i += SlackMaxSectionFields {
+		fields := []map[string]interface{}{}
+		for _, field := range notification.Fields[i:min(i+SlackMaxSectionFields, len(notification.Fields))] {
+			fields = append(fields, map[string]interface{}{
+				"type": "mrkdwn",
+				"text": fmt.Sprintf("*%s*\n%s", escapeSlackText(field.Name, 500), escapeSlackText(field.Value, 1000)),
+			})
+		}
+
+		blocks = append(blocks, map[string]interface{}{
+			"type":   "section",
+			"fields": fields,
+		})
+	}
+
+	blocks = append(blocks, map[string]interface{}{
+		"type": "context",
+		"elements": []map[string]interface{}{
+			{"type": "mrkdwn", "text": fmt.Sprintf("Shuffle | %s | org %s", notification.Event, notification.OrgId)},
+		},
+	})
+
+	message := map[string]interface{}{
+		"text":   fmt.Sprintf("%s: %s", notification.Title, notification.Text),
+		"blocks": blocks,
+	}
+
+	if len(channel) > 0 {
+		message["channel"] = channel
+	}
+
+	return message
+}
+
+func postSlackMessage(ctx context.Context, config CslSlackConfig, channel string, notification CslNotification) error {
+	b, err := json.Marshal(getSlackMessage(channel, notification))
+	if err != nil {
+		return err
+	}
+
+	url := config.WebhookUrl
+	if len(config.Token) > 0 {
+		url = SlackPostMessageUrl
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Content-Type", "application/json; charset=utf-8")
+	if len(config.Token) > 0 {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", config.Token))
+	}
+
+	client := shuffle.GetExternalClient(url)
+	newresp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer newresp.Body.Close()
+	body, err := ioutil.ReadAll(newresp.Body)
+	if err != nil {
+		return err
+	}
+
+	if newresp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("slack returned status code %d: %s", newresp.StatusCode, string(body)))
+	}
+
+	// The web api returns 200 with ok=false on errors
+	if len(config.Token) > 0 {
+		result := struct {
+			Ok    bool   `json:"ok"`
+			Error string `json:"error"`
+		}{}
+
+		err = json.Unmarshal(body, &result)
+		if err != nil {
+			return err
+		}
+
+		if !result.Ok {
+			return errors.New(fmt.Sprintf("slack returned error %s", result.Error))
+		}
+	}
+
+	return nil
+}
+
+// Sends the notification to every channel it is routed to. Does nothing if
+// Slack isn't enabled for the org
+func sendCslSlackNotification(ctx context.Context, orgId string, notification CslNotification) error {
+	slack := getCslSlack(ctx, orgId)
+	if !slack.Config.Enabled {
+		return nil
+	}
+
+	var lastErr error
+	for _, channel := range getSlackChannels(slack.Config, notification) {
+		err := postSlackMessage(ctx, slack.Config, channel, notification)
+		if err != nil {
+			log.Printf("[ERROR] Failed sending %s to Slack channel %s for org %s: %s", notification.Event, channel, orgId, err)
+			lastErr = err
+		}
+	}
+
+	err := updateCslSlackState(ctx, orgId, func(state *CslSlackState) {
+		state.LastSent = time.Now().Unix()
+		state.LastError = ""
+		if lastErr != nil {
+			state.LastError = lastErr.Error()
+		}
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed updating slack state for org %s: %s", orgId, err)
+	}
+
+	return lastErr
+}
+
+/*
+Notifications:
+Returns the Slack notification configuration and state for the current
+organization. Requires org admin. The token and webhook url are redacted.
+
+	{
+	    "success": true,
+	    "data": {
+	        "config": {
+	            "enabled": true,
+	            "token": "********",
+	            "webhook_url": "",
+	            "default_channel": "#shuffle",
+	            "routes": [
+	                {
+	                    "events": ["execution_failure_spike", "quota_exceeded"],
+	                    "channel": "#soc-oncall",
+	                    "min_severity": "critical"
+	                },
+	                {
+	                    "events": ["credential_expiry_warning"],
+	                    "channel": "#integrations",
+	                    "min_severity": ""
+	                }
+	            ]
+	        },
+	        "state": {
+	            "last_sent": 1700000000,
+	            "last_error": ""
+	        }
+	    }
+	}
+*/
+func cslGetSlack(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslSlack(getCslSlack(ctx, user.ActiveOrg.Id)),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetSlack")
+}
+
+/*
+Notifications:
+Updates the Slack notification configuration. Requires org admin. Body uses
+the format of the config field returned from GET, and redacted values are kept
+as they are. Events are sent to the channels of every matching route, or the
+default channel when no route matches. Routing requires a bot token with
+chat:write, an incoming webhook posts everything to its own channel. Events are
+credential_expiry_warning, quota_warning, quota_exceeded, git_sync_conflict,
+weekly_report, execution_failure_spike, which is sent when more executions
+fail within 15 minutes than the failure_spike_threshold CSL setting,
+sla_breach for executions running longer than sla_minutes, worker_outage when
+an Orborus environment stops checking in, and execution_failed for every
+failed execution.
+*/
+func cslSetSlack(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	slack := getCslSlack(ctx, user.ActiveOrg.Id)
+	previousConfig := slack.Config
+
+	err = json.Unmarshal(body, &slack.Config)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling slack config: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if slack.Config.Token == RedactedValue {
+		slack.Config.Token = previousConfig.Token
+	}
+
+	if slack.Config.WebhookUrl == RedactedValue {
+		slack.Config.WebhookUrl = previousConfig.WebhookUrl
+	}
+
+	if slack.Config.Routes == nil {
+		slack.Config.Routes = []CslSlackRoute{}
+	}
+
+	err = validateCslSlackConfig(slack.Config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	stored := CslSlack{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslSlackDocument, &stored, func() error {
+		stored.Config = slack.Config
+		slack = stored
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated slack config for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "slack_updated", "Slack notification configuration was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslSlack(slack),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetSlack")
+}
+
+/*
+Notifications:
+Sends a test message to the default channel and every routed channel, or to
+the incoming webhook. Requires org admin and Slack to be enabled.
+*/
+func cslTestSlack(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	slack := getCslSlack(ctx, user.ActiveOrg.Id)
+	if !slack.Config.Enabled {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("slack is not enabled")))
+		return
+	}
+
+	// A route for every channel, so the test reaches all of them
+	config := slack.Config
+	for _, route := range slack.Config.Routes {
+		config.Routes = append(config.Routes, CslSlackRoute{Events: []string{EventTest}, Channel: route.Channel})
+	}
+
+	if len(config.DefaultChannel) > 0 {
+		config.Routes = append(config.Routes, CslSlackRoute{Events: []string{EventTest}, Channel: config.DefaultChannel})
+	}
+
+	notification := getCslNotification(user.ActiveOrg.Id, EventTest, fmt.Sprintf("Test message sent by %s", user.Username))
+	notification.Title = "Shuffle test notification"
+
+	channels := getSlackChannels(config, notification)
+	for _, channel := range channels {
+		err := postSlackMessage(ctx, config, channel, notification)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("failed sending to %s: %s", channel, err))))
+			return
+		}
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"channels": channels,
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslTestSlack")
+}
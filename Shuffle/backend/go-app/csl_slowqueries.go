@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Slow database queries are logged and counted by the database client in
+// shuffle-shared (slow-queries.go). They're exposed here per handler, so
+// endpoints can be tuned with what they actually query.
+
+type CslSlowQueries struct {
+	ThresholdMs int                     `json:"threshold_ms"`
+	Queries     []shuffle.SlowQueryStat `json:"queries"`
+}
+
+// Slow queries since the backend started
+func getSlowQueryMetrics() []CslMetric {
+	queries := CslMetric{
+		Name: "shuffle_slow_queries_total",
+		Help: "Database queries of the backend answering the scrape slower than SHUFFLE_SLOW_QUERY_MS.",
+		Type: "counter",
+	}
+
+	stats := shuffle.GetSlowQueryStats()
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Handler != stats[j].Handler {
+			return stats[i].Handler < stats[j].Handler
+		}
+
+		if stats[i].Function != stats[j].Function {
+			return stats[i].Function < stats[j].Function
+		}
+
+		return stats[i].Operation < stats[j].Operation
+	})
+
+	for _, stat := range stats {
+		queries.Samples = append(queries.Samples, CslMetricSample{
+			Labels: map[string]string{
+				"handler":   stat.Handler,
+				"function":  stat.Function,
+				"operation": stat.Operation,
+			},
+			Value: float64(stat.Count),
+		})
+	}
+
+	return []CslMetric{queries}
+}
+
+/*
+Slow queries:
+Returns the database queries of the backend handling the request that took
+longer than SHUFFLE_SLOW_QUERY_MS since it started, grouped by the handler
+or job they were made for, the function making them and the index and API
+queried. Sorted by total time, highest first. Requires support access.
+
+	{
+	    "success": true,
+	    "data": {
+	        "threshold_ms": 500,
+	        "queries": [
+	            {
+	                "handler": "cslWorkflows",
+	                "function": "GetAllWorkflowsByQuery",
+	                "operation": "workflow/_search",
+	                "count": 42,
+	                "total_ms": 61200,
+	                "max_ms": 3100,
+	                "last_seen": 1718000000
+	            }
+	        ]
+	    }
+	}
+*/
+func cslGetSlowQueries(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !user.SupportAccess {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("slow queries require support access")))
+		return
+	}
+
+	stats := shuffle.GetSlowQueryStats()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].TotalMs > stats[j].TotalMs
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data: CslSlowQueries{
+			ThresholdMs: int(shuffle.GetSlowQueryThreshold().Milliseconds()),
+			Queries:     stats,
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetSlowQueries")
+}
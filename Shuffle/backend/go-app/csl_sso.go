@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslSsoRoleMappingDocument = "sso_role_mapping"
+
+// Org roles in order of increasing privilege
+var ssoRoles = []string{"org-reader", "user", "admin"}
+
+// Attribute names commonly used by IdPs for group membership
+var defaultGroupAttributes = []string{
+	"groups",
+	"memberOf",
+	"http://schemas.microsoft.com/ws/2008/06/identity/claims/groups",
+	"http://schemas.xmlsoap.org/claims/Group",
+}
+
+type CslGroupRoleMapping struct {
+	Group string `json:"group"`
+	Role  string `json:"role"`
+}
+
+// Maps IdP groups to org roles for users logging in with SSO.
+// When a user is in multiple mapped groups the most privileged role is used
+type CslSsoRoleMapping struct {
+	Enabled        bool                  `json:"enabled"`
+	GroupAttribute string                `json:"group_attribute"`
+	DefaultRole    string                `json:"default_role"`
+	Mappings       []CslGroupRoleMapping `json:"mappings"`
+}
+
+type cslSamlAttribute struct {
+	Name   string   `xml:"Name,attr"`
+	Values []string `xml:"AttributeValue"`
+}
+
+// Minimal SAML assertion with only what's needed for role mapping
+type cslSamlAssertion struct {
+	Assertion struct {
+		Subject struct {
+			NameID string `xml:"NameID"`
+		} `xml:"Subject"`
+		AttributeStatement struct {
+			Attributes []cslSamlAttribute `xml:"Attribute"`
+		} `xml:"AttributeStatement"`
+	} `xml:"Assertion"`
+}
+
+func getCslSsoRoleMapping(ctx context.Context, orgId string) CslSsoRoleMapping {
+	mapping := CslSsoRoleMapping{}
+	_, err := getCslDocument(ctx, orgId, CslSsoRoleMappingDocument, &mapping)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting SSO role mapping for org %s: %s", orgId, err)
+	}
+
+	if len(mapping.DefaultRole) == 0 {
+		mapping.DefaultRole = "user"
+	}
+
+	if mapping.Mappings == nil {
+		mapping.Mappings = []CslGroupRoleMapping{}
+	}
+
+	return mapping
+}
+
+func getSsoRoleRank(role string) int {
+	for i, ssoRole := range ssoRoles {
+		if ssoRole == role {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func validateCslSsoRoleMapping(mapping CslSsoRoleMapping) error {
+	if getSsoRoleRank(mapping.DefaultRole) < 0 {
+		return errors.New(fmt.Sprintf("default_role must be one of %s", strings.Join(ssoRoles, ", ")))
+	}
+
+	for _, groupMapping := range mapping.Mappings {
+		if len(groupMapping.Group) == 0 {
+			return errors.New("group can't be empty")
+		}
+
+		if getSsoRoleRank(groupMapping.Role) < 0 {
+			return errors.New(fmt.Sprintf("role for group %s must be one of %s", groupMapping.Group, strings.Join(ssoRoles, ", ")))
+		}
+	}
+
+	return nil
+}
+
+// Returns the most privileged role mapped from the groups, or the default role
+func getRoleForGroups(mapping CslSsoRoleMapping, groups []string) string {
+	role := ""
+	for _, group := range groups {
+		for _, groupMapping := range mapping.Mappings {
+			if !strings.EqualFold(groupMapping.Group, strings.TrimSpace(group)) {
+				continue
+			}
+
+			if getSsoRoleRank(groupMapping.Role) > getSsoRoleRank(role) {
+				role = groupMapping.Role
+			}
+		}
+	}
+
+	if len(role) == 0 {
+		return mapping.DefaultRole
+	}
+
+	return role
+}
+
+// Finds the local user matching the SSO username. SSO created users have it as GeneratedUsername
+func findSsoUser(ctx context.Context, username string) (*shuffle.User, error) {
+	users, err := shuffle.FindGeneratedUser(ctx, username)
+	if err == nil {
+		for _, user := range users {
+			if user.GeneratedUsername == username {
+				return &user, nil
+			}
+		}
+	}
+
+	users, err = shuffle.FindUser(ctx, username)
+	if err == nil {
+		for _, user := range users {
+			if user.Username == username {
+				return &user, nil
+			}
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("no user found for %s", username))
+}
+
+// Role of the user in the org. Every org keeps its own role for each member
+func getCslOrgRole(org *shuffle.Org, userId string) (string, bool) {
+	for _, orgUser := range org.Users {
+		if orgUser.Id == userId {
+			return orgUser.Role, true
+		}
+	}
+
+	return "", false
+}
+
+// Makes the org the active org of a member with its role there, the same
+// way changing org does
+func setCslActiveOrg(user *shuffle.User, org *shuffle.Org) error {
+	role, found := getCslOrgRole(org, user.Id)
+	if !found || !shuffle.ArrayContains(user.Orgs, org.Id) {
+		return errors.New("user is not a member of the org")
+	}
+
+	user.ActiveOrg = shuffle.OrgMini{
+		Name: org.Name,
+		Id:   org.Id,
+		Role: role,
+	}
+
+	user.Role = role
+	user.Roles = []string{role}
+	return nil
+}
+
+// Sets the role of a member in one org, leaving its roles in other orgs as
+// they are. The users own role is the role in its active org, so it only
+// follows when this org is active, like role changes from the user admin
+func setCslOrgRole(ctx context.Context, org *shuffle.Org, user *shuffle.User, role string) error {
+	found := false
+	for index := range org.Users {
+		if org.Users[index].Id == user.Id {
+			org.Users[index].Role = role
+			org.Users[index].Roles = []string{role}
+			found = true
+		}
+	}
+
+	if !found {
+		return errors.New("user is not a member of the org")
+	}
+
+	err := shuffle.SetOrg(ctx, *org, org.Id)
+	if err != nil {
+		return err
+	}
+
+	if user.ActiveOrg.Id != org.Id {
+		return nil
+	}
+
+	user.Role = role
+	user.Roles = []string{role}
+	user.ActiveOrg.Role = role
+
+	// Not updating orgs, as that copies the users role into every org
+	return shuffle.SetUser(ctx, user, false)
+}
+
+// Applies the group mapping of an org to a member who logged in with SSO or
+// whose groups were provisioned. Only the role in that org is changed
+func applySsoRoleMapping(ctx context.Context, orgId string, user *shuffle.User, mapping CslSsoRoleMapping, loginType string, groups []string) error {
+	if !mapping.Enabled {
+		return nil
+	}
+
+	org, err := shuffle.GetOrg(ctx, orgId)
+	if err != nil {
+		return err
+	}
+
+	previousRole, found := getCslOrgRole(org, user.Id)
+	if !found {
+		return errors.New("user is not a member of the org")
+	}
+
+	role := getRoleForGroups(mapping, groups)
+	if role == previousRole {
+		return nil
+	}
+
+	err = setCslOrgRole(ctx, org, user, role)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[AUDIT] Changed role of user %s (%s) in org %s from %s to %s based on %s groups", user.Username, user.Id, orgId, previousRole, role, loginType)
+	recordCslActivity(ctx, orgId, ActivityTypeAdmin, "sso_role_updated", fmt.Sprintf("Role of %s was changed from %s to %s by %s group mapping", user.Username, previousRole, role, loginType), loginType, user.Id)
+	return nil
+}
+
+func getSamlGroups(attributes []cslSamlAttribute, groupAttribute string) []string {
+	attributeNames := defaultGroupAttributes
+	if len(groupAttribute) > 0 {
+		attributeNames = []string{groupAttribute}
+	}
+
+	groups := []string{}
+	for _, attribute := range attributes {
+		for _, name := range attributeNames {
+			if strings.EqualFold(attribute.Name, name) {
+				groups = append(groups, attribute.Values...)
+			}
+		}
+	}
+
+	return groups
+}
+
+// Decodes the SAMLResponse from a login form body the same way HandleSSO does
+func parseSamlLoginBody(body []byte) (cslSamlAssertion, error) {
+	assertion := cslSamlAssertion{}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return assertion, err
+	}
+
+	samlResponse := strings.Replace(values.Get("SAMLResponse"), " ", "+", -1)
+	if len(samlResponse) == 0 {
+		return assertion, errors.New("no SAMLResponse in body")
+	}
+
+	bytesXML, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return assertion, err
+	}
+
+	err = xml.Unmarshal(bytesXML, &assertion)
+	return assertion, err
+}
+
+// Certificate in a SAML response signature, normalized the way SSO configs
+// store it
+func getSamlResponseCertificate(response *etree.Element) string {
+	certificate := ""
+	for _, element := range []*etree.Element{response, getSamlChild(response, samlAssertionNamespace, "Assertion")} {
+		signature := getSamlChild(element, dsig.Namespace, dsig.SignatureTag)
+		certificate = getSamlText(getSamlChild(getSamlChild(getSamlChild(signature, dsig.Namespace, "KeyInfo"), dsig.Namespace, "X509Data"), dsig.Namespace, "X509Certificate"))
+		if len(certificate) > 0 {
+			break
+		}
+	}
+
+	certificate = strings.Replace(certificate, "&#13;", "", -1)
+	certificate = strings.Replace(certificate, "-----BEGIN CERTIFICATE-----", "", -1)
+	certificate = strings.Replace(certificate, "-----END CERTIFICATE-----", "", -1)
+	return strings.Join(strings.Fields(certificate), "")
+}
+
+// SSO login for orgs without SAML enabled. Login itself, including
+// just-in-time user creation, is handled by shuffle.HandleSSO which finds
+// the org by the certificate in the response. Orgs with SAML enabled only
+// accept verified responses, so they are refused here. On a successful
+// login the orgs group mapping is applied to the user
+func cslLegacySamlLogin(resp http.ResponseWriter, request *http.Request, body []byte, response *etree.Element) {
+	ctx := shuffle.GetContext(request)
+	if response != nil {
+		certificate := getSamlResponseCertificate(response)
+		orgs, _ := shuffle.GetOrgByField(ctx, "sso_config.sso_certificate", certificate)
+		for _, org := range orgs {
+			if len(certificate) > 0 && getCslSamlSettings(ctx, org.Id).Enabled {
+				log.Printf("[WARNING] Refused unverified SSO login for org %s with SAML enabled", org.Id)
+				resp.WriteHeader(401)
+				resp.Write(createCslErrorResponse(errors.New("SAML logins for this org have to be started from /api/v1/csl/login/saml")))
+				return
+			}
+		}
+	}
+
+	recorder := &cslStatusRecorder{ResponseWriter: resp, status: 200}
+	shuffle.HandleSSO(recorder, request)
+
+	// HandleSSO redirects to the frontend after a successful login
+	if recorder.status != http.StatusSeeOther {
+		return
+	}
+
+	assertion, err := parseSamlLoginBody(body)
+	if err != nil {
+		log.Printf("[WARNING] Failed parsing SAML response for role mapping: %s", err)
+		return
+	}
+
+	username := strings.ToLower(strings.TrimSpace(assertion.Assertion.Subject.NameID))
+
+	user, err := findSsoUser(ctx, username)
+	if err != nil {
+		log.Printf("[WARNING] Failed finding SAML user %s for role mapping: %s", username, err)
+		return
+	}
+
+	recordCslGeoEvent(user.ActiveOrg.Id, GeoSourceLogin, shuffle.GetRequestIp(request), user.Id, user.Username, recorder.status)
+
+	mapping := getCslSsoRoleMapping(ctx, user.ActiveOrg.Id)
+	err = applySsoRoleMapping(ctx, user.ActiveOrg.Id, user, mapping, "SAML", getSamlGroups(assertion.Assertion.AttributeStatement.Attributes, mapping.GroupAttribute))
+	if err != nil {
+		log.Printf("[ERROR] Failed applying SAML role mapping for %s: %s", username, err)
+	}
+}
+
+/*
+SSO:
+Returns the SSO group to role mapping for the current organization. Requires org admin.
+
+	{
+	    "success": true,
+	    "data": {
+	        "enabled": true,
+	        "group_attribute": "groups",
+	        "default_role": "user",
+	        "mappings": [
+	            {
+	                "group": "soc-leads",
+	                "role": "admin"
+	            },
+	            {
+	                "group": "auditors",
+	                "role": "org-reader"
+	            }
+	        ]
+	    }
+	}
+*/
+func cslGetSsoRoleMapping(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslSsoRoleMapping(ctx, user.ActiveOrg.Id),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetSsoRoleMapping")
+}
+
+/*
+SSO:
+Updates the SSO group to role mapping. Requires org admin. Body uses the same
+format as the data field returned from GET. An empty group_attribute looks
+for the group attributes most IdPs use.
+*/
+func cslSetSsoRoleMapping(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	mapping := getCslSsoRoleMapping(ctx, user.ActiveOrg.Id)
+	err = json.Unmarshal(body, &mapping)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling SSO role mapping: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	err = validateCslSsoRoleMapping(mapping)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslSsoRoleMappingDocument, mapping)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated SSO role mapping for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "sso_mapping_updated", "SSO role mapping was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    mapping,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetSsoRoleMapping")
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+func TestSetCslActiveOrg(t *testing.T) {
+	org := &shuffle.Org{
+		Id:   "org",
+		Name: "Org",
+		Users: []shuffle.User{
+			{Id: "member", Role: "org-reader"},
+			{Id: "listed", Role: "admin"},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		user  shuffle.User
+		role  string
+		valid bool
+	}{
+		{name: "member", user: shuffle.User{Id: "member", Role: "admin", Orgs: []string{"other", "org"}}, role: "org-reader", valid: true},
+		{name: "not in org users", user: shuffle.User{Id: "outsider", Role: "admin", Orgs: []string{"org"}}},
+		{name: "not in user orgs", user: shuffle.User{Id: "listed", Role: "user", Orgs: []string{"other"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user := test.user
+			previousRole := user.Role
+
+			err := setCslActiveOrg(&user, org)
+			if (err == nil) != test.valid {
+				t.Fatalf("got error %v, expected valid %t", err, test.valid)
+			}
+
+			if !test.valid {
+				if user.Role != previousRole || len(user.ActiveOrg.Id) > 0 {
+					t.Errorf("user was changed without being a member: %+v", user)
+				}
+
+				return
+			}
+
+			if user.ActiveOrg.Id != org.Id || user.ActiveOrg.Role != test.role || user.Role != test.role {
+				t.Errorf("got active org %+v and role %s, expected %s in %s", user.ActiveOrg, user.Role, test.role, org.Id)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslStatsSnapshotDocument = "stats_snapshot"
+
+// How often the stats_snapshot job recalculates the snapshots of every org
+const StatsSnapshotMinutes = 15
+
+// Most recent executions of each workflow counted towards app usage
+const AppUsageExecutionLimit = 50
+
+const MaxStatsQueueSize = 1000
+
+type CslAppUsage struct {
+	AppId      string `json:"app_id"`
+	AppName    string `json:"app_name"`
+	Executions int64  `json:"executions"`
+	Failures   int64  `json:"failures"`
+	LastUsed   int64  `json:"last_used"`
+}
+
+// Statistics that need a lookup per workflow, calculated in the background
+// so the dashboard endpoints don't have to
+type CslStatsSnapshot struct {
+	Workflows           int           `json:"workflows"`
+	UnexecutedWorkflows int           `json:"unexecuted_workflows"`
+	AppUsage            []CslAppUsage `json:"app_usage"`
+	CalculatedAt        int64         `json:"calculated_at"`
+}
+
+// Orgs waiting for a new snapshot. An org is only queued once at a time
+var cslStatsQueue = struct {
+	sync.Mutex
+	pending map[string]bool
+	orgs    chan string
+	started sync.Once
+}{
+	pending: map[string]bool{},
+	orgs:    make(chan string, MaxStatsQueueSize),
+}
+
+// Counts the app runs of an execution into appUsage, keyed by app name
+func countAppUsage(appUsage map[string]*CslAppUsage, execution shuffle.WorkflowExecution) {
+	for _, result := range execution.Results {
+		appName := result.Action.AppName
+		if len(appName) == 0 {
+			continue
+		}
+
+		usage, ok := appUsage[appName]
+		if !ok {
+			usage = &CslAppUsage{AppName: appName}
+			appUsage[appName] = usage
+		}
+
+		usage.Executions++
+		if result.Status == "FAILURE" {
+			usage.Failures++
+		}
+
+		if result.StartedAt > usage.LastUsed {
+			usage.LastUsed = result.StartedAt
+			usage.AppId = result.Action.AppID
+		}
+	}
+}
+
+// Most used apps first
+func sortAppUsage(appUsage map[string]*CslAppUsage) []CslAppUsage {
+	sorted := []CslAppUsage{}
+	for _, usage := range appUsage {
+		sorted = append(sorted, *usage)
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Executions == sorted[j].Executions {
+			return sorted[i].AppName < sorted[j].AppName
+		}
+
+		return sorted[i].Executions > sorted[j].Executions
+	})
+
+	return sorted
+}
+
+func calculateStatsSnapshot(ctx context.Context, orgId string) (CslStatsSnapshot, error) {
+	workflows, err := getOrgWorkflows(ctx, orgId)
+	if err != nil {
+		return CslStatsSnapshot{}, err
+	}
+
+	snapshot := CslStatsSnapshot{
+		Workflows:    len(workflows),
+		AppUsage:     []CslAppUsage{},
+		CalculatedAt: time.Now().Unix(),
+	}
+
+	executions := make([][]shuffle.WorkflowExecution, len(workflows))
+	err = runConcurrentLookups(ctx, len(workflows), func(ctx context.Context, index int) error {
+		workflowExecutions, err := shuffle.GetAllWorkflowExecutions(ctx, workflows[index].ID, AppUsageExecutionLimit)
+		executions[index] = workflowExecutions
+		return err
+	})
+	if err != nil {
+		return CslStatsSnapshot{}, err
+	}
+
+	appUsage := map[string]*CslAppUsage{}
+	for _, workflowExecutions := range executions {
+		if len(workflowExecutions) == 0 {
+			snapshot.UnexecutedWorkflows++
+			continue
+		}
+
+		for _, execution := range workflowExecutions {
+			countAppUsage(appUsage, execution)
+		}
+	}
+
+	snapshot.AppUsage = sortAppUsage(appUsage)
+	return snapshot, nil
+}
+
+// Calculates and stores a new snapshot for the org
+func updateStatsSnapshot(ctx context.Context, orgId string) (CslStatsSnapshot, error) {
+	snapshot, err := calculateStatsSnapshot(ctx, orgId)
+	if err != nil {
+		return snapshot, err
+	}
+
+	err = setCslDocument(ctx, orgId, CslStatsSnapshotDocument, snapshot)
+	return snapshot, err
+}
+
+func runCslStatsWorker(ctx context.Context) {
+	for orgId := range cslStatsQueue.orgs {
+		cslStatsQueue.Lock()
+		delete(cslStatsQueue.pending, orgId)
+		cslStatsQueue.Unlock()
+
+		_, err := updateStatsSnapshot(ctx, orgId)
+		if err != nil {
+			log.Printf("[ERROR] Failed updating stats snapshot for org %s: %s", orgId, err)
+		}
+	}
+}
+
+// Queues a snapshot update for the org. Starts the worker on first use
+func enqueueStatsSnapshot(orgId string) {
+	cslStatsQueue.started.Do(func() {
+		go runCslStatsWorker(context.Background())
+	})
+
+	cslStatsQueue.Lock()
+	defer cslStatsQueue.Unlock()
+
+	if cslStatsQueue.pending[orgId] {
+		return
+	}
+
+	select {
+	case cslStatsQueue.orgs <- orgId:
+		cslStatsQueue.pending[orgId] = true
+	default:
+		log.Printf("[WARNING] Stats queue is full. Skipping snapshot for org %s", orgId)
+	}
+}
+
+// Returns the stored snapshot of the org. The first request for an org
+// calculates it immediately, and snapshots the job missed are queued
+func getStatsSnapshot(ctx context.Context, orgId string) (CslStatsSnapshot, error) {
+	snapshot := CslStatsSnapshot{}
+	found, err := getCslDocument(ctx, orgId, CslStatsSnapshotDocument, &snapshot)
+	if err != nil || !found || snapshot.CalculatedAt == 0 {
+		return updateStatsSnapshot(ctx, orgId)
+	}
+
+	if time.Since(time.Unix(snapshot.CalculatedAt, 0)) > 2*StatsSnapshotMinutes*time.Minute {
+		enqueueStatsSnapshot(orgId)
+	}
+
+	if snapshot.AppUsage == nil {
+		snapshot.AppUsage = []CslAppUsage{}
+	}
+
+	return snapshot, nil
+}
+
+// Job: queues a new stats snapshot for every org
+func runCslStatsSnapshotJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for stats snapshot job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		enqueueStatsSnapshot(org.Id)
+	}
+}
+
+/*
+Dashboard:
+Returns how often each app was used in the most recent executions of the
+current organizations workflows, most used first. Usage is recalculated every
+15 minutes.
+
+	{
+	    "success": true,
+	    "data": {
+	        "apps": [
+	            {
+	                "app_id": "...",
+	                "app_name": "http",
+	                "executions": 120,
+	                "failures": 4,
+	                "last_used": 1700000000
+	            },
+	            ...
+	        ],
+	        "calculated_at": 1700000000
+	    }
+	}
+*/
+func cslAppUsage(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	snapshot, err := getRequestStatsSnapshot(resp, request, user.ActiveOrg.Id)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting stats snapshot for org %s: %s", user.ActiveOrg.Id, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	fields := map[string]interface{}{
+		"calculated_at": snapshot.CalculatedAt,
+	}
+
+	streamCslResponse(resp, fields, "apps", snapshot.AppUsage, "cslAppUsage")
+}
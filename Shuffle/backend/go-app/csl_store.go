@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// CSL documents are stored as org documents (shuffle-shared org-documents.go),
+// which only the backend can read and write. They used to be org scoped cache
+// keys with this prefix, and are moved over the first time they're used.
+const CslKeyPrefix = "csl"
+
+// Documents of orgs that have been checked for an old copy by this backend
+var cslMigratedDocuments sync.Map
+
+func getLegacyCslDocumentKey(orgId, name string) string {
+	return fmt.Sprintf("%s_%s_%s", orgId, CslKeyPrefix, name)
+}
+
+// Moves a document still stored in org_cache to the org documents, unless it
+// has been stored there since
+func migrateLegacyCslDocument(ctx context.Context, orgId, name string) {
+	key := getLegacyCslDocumentKey(orgId, name)
+	if _, checked := cslMigratedDocuments.Load(key); checked {
+		return
+	}
+
+	cacheData, err := shuffle.GetCacheKey(ctx, key)
+	if err != nil || cacheData == nil || cacheData.OrgId != orgId || len(cacheData.Value) == 0 {
+		cslMigratedDocuments.Store(key, true)
+		return
+	}
+
+	err = shuffle.UpdateOrgDocument(ctx, orgId, name, func(document *shuffle.OrgDocument) error {
+		if len(document.Value) == 0 {
+			document.Value = cacheData.Value
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed moving CSL document %s for org %s out of the org cache: %s", name, orgId, err)
+		return
+	}
+
+	err = shuffle.DeleteKey(ctx, "org_cache", url.QueryEscape(key))
+	if err != nil {
+		log.Printf("[WARNING] Failed removing the old copy of CSL document %s for org %s: %s", name, orgId, err)
+	}
+
+	log.Printf("[INFO] Moved CSL document %s for org %s out of the org cache", name, orgId)
+	cslMigratedDocuments.Store(key, true)
+}
+
+// Loads a CSL document belonging to an org into the value pointed to by data.
+// Returns found=false without error if the document hasn't been stored yet
+func getCslDocument(ctx context.Context, orgId, name string, data interface{}) (bool, error) {
+	migrateLegacyCslDocument(ctx, orgId, name)
+
+	document, found, err := shuffle.GetOrgDocument(ctx, orgId, name)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting CSL document %s for org %s: %s", name, orgId, err)
+		return false, nil
+	}
+
+	if !found || len(document.Value) == 0 {
+		return false, nil
+	}
+
+	err = json.Unmarshal([]byte(document.Value), data)
+	if err != nil {
+		log.Printf("[ERROR] Failed unmarshalling CSL document %s for org %s: %s", name, orgId, err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Stores a CSL document for an org, overwriting any previous value
+func setCslDocument(ctx context.Context, orgId, name string, data interface{}) error {
+	migrateLegacyCslDocument(ctx, orgId, name)
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("[ERROR] Failed marshalling CSL document %s for org %s: %s", name, orgId, err)
+		return err
+	}
+
+	err = shuffle.UpdateOrgDocument(ctx, orgId, name, func(document *shuffle.OrgDocument) error {
+		document.Value = string(b)
+		return nil
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed storing CSL document %s for org %s: %s", name, orgId, err)
+		return err
+	}
+
+	return nil
+}
+
+// Loads a CSL document into data, runs update on it and stores the result.
+// No other backend updates the document in between. The document is left
+// unchanged if update returns an error
+func updateCslDocument(ctx context.Context, orgId, name string, data interface{}, update func() error) error {
+	migrateLegacyCslDocument(ctx, orgId, name)
+
+	return shuffle.UpdateOrgDocument(ctx, orgId, name, func(document *shuffle.OrgDocument) error {
+		if len(document.Value) > 0 {
+			err := json.Unmarshal([]byte(document.Value), data)
+			if err != nil {
+				log.Printf("[ERROR] Failed unmarshalling CSL document %s for org %s: %s", name, orgId, err)
+				return err
+			}
+		}
+
+		err := update()
+		if err != nil {
+			return err
+		}
+
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		document.Value = string(b)
+		return nil
+	})
+}
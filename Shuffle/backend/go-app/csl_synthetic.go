@@ -0,0 +1,833 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Fills an org with made up workflows, executions and daily statistics, so
+// the dashboards and CSL endpoints can be tried out and load tested without
+// weeks of real data. Only available with SHUFFLE_SYNTHETIC_DATA=true, which
+// should never be set in production.
+//
+// Executions are written in bulk instead of through SetWorkflowExecution, as
+// that would count every one of them on today's statistics. Their counts are
+// added to the statistics of the days they're spread over instead.
+
+const CslSyntheticDataDocument = "synthetic_data"
+
+// Generated workflows are tagged with this, and can be removed again
+const SyntheticWorkflowTag = "synthetic"
+
+const (
+	DefaultSyntheticWorkflows        = 10
+	MaxSyntheticWorkflows            = 100
+	DefaultSyntheticDays             = 30
+	MaxSyntheticDays                 = 90
+	DefaultSyntheticExecutionsPerDay = 20
+	DefaultSyntheticFailureRate      = 0.1
+)
+
+// Most executions a single request may generate, going by executions_per_day
+const MaxSyntheticExecutions = 100000
+
+// Executions are removed this many at a time per workflow
+const SyntheticDeleteBatch = 1000
+
+// A generation still running after this long was lost to a restart
+const SyntheticTimeoutMinutes = 60
+
+// Synthetic data states
+const (
+	SyntheticRunning  = "running"
+	SyntheticFinished = "finished"
+	SyntheticDeleting = "deleting"
+	SyntheticDeleted  = "deleted"
+	SyntheticFailed   = "failed"
+)
+
+type CslSyntheticDataRequest struct {
+	Workflows        int      `json:"workflows"`
+	Days             int      `json:"days"`
+	ExecutionsPerDay int      `json:"executions_per_day"`
+	FailureRate      *float64 `json:"failure_rate"`
+	Seed             int64    `json:"seed"`
+}
+
+type CslSyntheticData struct {
+	Status           string   `json:"status"`
+	Workflows        int      `json:"workflows"`
+	Days             int      `json:"days"`
+	ExecutionsPerDay int      `json:"executions_per_day"`
+	FailureRate      float64  `json:"failure_rate"`
+	Seed             int64    `json:"seed"`
+	WorkflowIds      []string `json:"workflow_ids"`
+	Executions       int64    `json:"executions"`
+	FailedExecutions int64    `json:"failed_executions"`
+	DaysUpdated      int      `json:"days_updated"`
+	StartedBy        string   `json:"started_by"`
+	StartedAt        int64    `json:"started_at"`
+	FinishedAt       int64    `json:"finished_at"`
+	Error            string   `json:"error,omitempty"`
+}
+
+type syntheticApp struct {
+	AppName string
+	Action  string
+}
+
+var syntheticApps = []syntheticApp{
+	{"http", "GET"},
+	{"Shuffle Tools", "repeat_back_to_me"},
+	{"Shuffle Tools", "parse_ioc"},
+	{"VirusTotal v3", "get_a_file_report"},
+	{"AbuseIPDB", "check_ip"},
+	{"TheHive", "create_alert"},
+	{"Outlook Office365", "get_emails"},
+	{"Slack", "send_message"},
+	{"Jira", "create_issue"},
+	{"Shuffle Workflow", "run_subflow"},
+}
+
+var syntheticWorkflowNames = []string{
+	"Phishing triage",
+	"Suspicious login enrichment",
+	"IOC enrichment",
+	"EDR alert triage",
+	"Malware alert response",
+	"Vulnerability ticketing",
+	"User offboarding",
+	"Threat intel digest",
+}
+
+// Status codes of failed actions, picked so each failure category shows up
+var syntheticFailureStatus = []int{401, 403, 504, 500, 400}
+
+// Relative amount of webhook and manual executions started in each hour
+var syntheticHourWeights = []float64{1, 1, 1, 1, 1, 2, 3, 5, 8, 10, 10, 9, 8, 9, 10, 10, 9, 7, 5, 4, 3, 2, 2, 1}
+
+// A generated workflow with the source its executions get
+type syntheticWorkflow struct {
+	Workflow shuffle.Workflow
+	Source   string
+	Factor   float64
+}
+
+func syntheticDataEnabled() bool {
+	return os.Getenv("SHUFFLE_SYNTHETIC_DATA") == "true"
+}
+
+func getCslSyntheticData(ctx context.Context, orgId string) CslSyntheticData {
+	synthetic := CslSyntheticData{}
+	_, err := getCslDocument(ctx, orgId, CslSyntheticDataDocument, &synthetic)
+	if err != nil {
+		log.Printf("[WARNING] Failed loading synthetic data for org %s: %s", orgId, err)
+	}
+
+	if (synthetic.Status == SyntheticRunning || synthetic.Status == SyntheticDeleting) && !isSyntheticDataBusy(synthetic) {
+		synthetic.Status = SyntheticFailed
+		synthetic.Error = "synthetic data job was interrupted"
+	}
+
+	return synthetic
+}
+
+func isSyntheticDataBusy(synthetic CslSyntheticData) bool {
+	if synthetic.Status != SyntheticRunning && synthetic.Status != SyntheticDeleting {
+		return false
+	}
+
+	return synthetic.StartedAt > time.Now().Add(-SyntheticTimeoutMinutes*time.Minute).Unix()
+}
+
+func updateCslSyntheticData(ctx context.Context, orgId string, update func(synthetic *CslSyntheticData)) {
+	synthetic := CslSyntheticData{}
+	err := updateCslDocument(ctx, orgId, CslSyntheticDataDocument, &synthetic, func() error {
+		update(&synthetic)
+		return nil
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed updating synthetic data for org %s: %s", orgId, err)
+	}
+}
+
+func pickSyntheticHour(rng *rand.Rand) int {
+	total := 0.0
+	for _, weight := range syntheticHourWeights {
+		total += weight
+	}
+
+	value := rng.Float64() * total
+	for hour, weight := range syntheticHourWeights {
+		value -= weight
+		if value < 0 {
+			return hour
+		}
+	}
+
+	return len(syntheticHourWeights) - 1
+}
+
+func newSyntheticWorkflow(rng *rand.Rand, index int, user shuffle.User, created int64) syntheticWorkflow {
+	name := syntheticWorkflowNames[index%len(syntheticWorkflowNames)]
+	if index >= len(syntheticWorkflowNames) {
+		name = fmt.Sprintf("%s %d", name, index/len(syntheticWorkflowNames)+1)
+	}
+
+	workflow := shuffle.Workflow{
+		ID:              uuid.NewV4().String(),
+		Name:            name,
+		Description:     "Generated test data",
+		Tags:            []string{SyntheticWorkflowTag},
+		OrgId:           user.ActiveOrg.Id,
+		Owner:           user.Id,
+		Org:             []shuffle.OrgMini{},
+		ExecutingOrg:    shuffle.OrgMini{Id: user.ActiveOrg.Id},
+		Sharing:         "private",
+		UpdatedBy:       user.Username,
+		IsValid:         true,
+		PreviouslySaved: true,
+		Created:         created,
+		Edited:          created,
+	}
+
+	actionCount := 3 + rng.Intn(4)
+	for i := 0; i < actionCount; i++ {
+		app := syntheticApps[rng.Intn(len(syntheticApps))]
+		action := shuffle.Action{
+			ID:          uuid.NewV4().String(),
+			AppName:     app.AppName,
+			AppID:       uuid.NewV5(uuid.NamespaceOID, app.AppName).String(),
+			AppVersion:  "1.0.0",
+			Name:        app.Action,
+			Label:       fmt.Sprintf("%s_%d", app.Action, i+1),
+			IsValid:     true,
+			IsStartNode: i == 0,
+			Environment: "Shuffle",
+			Errors:      []string{},
+			Parameters:  []shuffle.WorkflowAppActionParameter{},
+		}
+		action.Position.X = float64(i * 250)
+
+		if i > 0 {
+			workflow.Branches = append(workflow.Branches, shuffle.Branch{
+				ID:            uuid.NewV4().String(),
+				SourceID:      workflow.Actions[i-1].ID,
+				DestinationID: action.ID,
+			})
+		}
+
+		workflow.Actions = append(workflow.Actions, action)
+	}
+
+	workflow.Start = workflow.Actions[0].ID
+
+	// Half webhooks, a quarter schedules and the rest started by hand.
+	// Triggers are stopped, so nothing real starts the workflows
+	source := "default"
+	trigger := shuffle.Trigger{
+		ID:          uuid.NewV4().String(),
+		Status:      "stopped",
+		Environment: "cloud",
+		IsValid:     true,
+		Errors:      []string{},
+		Parameters:  []shuffle.WorkflowAppActionParameter{},
+	}
+
+	if index%4 < 2 {
+		source = TriggerWebhook
+		trigger.AppName = "Webhook"
+		trigger.TriggerType = "WEBHOOK"
+		trigger.Name = "Webhook"
+		trigger.Label = "webhook_1"
+	} else if index%4 == 2 {
+		source = TriggerSchedule
+		trigger.AppName = "Schedule"
+		trigger.TriggerType = "SCHEDULE"
+		trigger.Name = "Schedule"
+		trigger.Label = "schedule_1"
+	}
+
+	if source != "default" {
+		trigger.Position.X = -250
+		workflow.Triggers = append(workflow.Triggers, trigger)
+		workflow.Branches = append(workflow.Branches, shuffle.Branch{
+			ID:            uuid.NewV4().String(),
+			SourceID:      trigger.ID,
+			DestinationID: workflow.Start,
+		})
+	}
+
+	return syntheticWorkflow{
+		Workflow: workflow,
+		Source:   source,
+		Factor:   0.3 + rng.Float64()*1.4,
+	}
+}
+
+func newSyntheticResult(action shuffle.Action, status int) string {
+	output := shuffle.HTTPOutput{
+		Success: status < 300,
+		Status:  status,
+		Url:     fmt.Sprintf("https://api.example.com/%s", action.Name),
+		Body:    map[string]interface{}{"id": uuid.NewV4().String()},
+	}
+
+	if status >= 300 {
+		output.Body = map[string]interface{}{"error": http.StatusText(status)}
+	}
+
+	result, err := json.Marshal(output)
+	if err != nil {
+		return "{}"
+	}
+
+	return string(result)
+}
+
+// Makes one execution of workflow started at startedAt. Failed executions
+// stop at a failed action, aborted ones are stopped by a user
+func newSyntheticExecution(rng *rand.Rand, synthetic syntheticWorkflow, startedAt time.Time, failureRate float64) shuffle.WorkflowExecution {
+	workflow := synthetic.Workflow
+	execution := shuffle.WorkflowExecution{
+		Type:            "workflow",
+		ExecutionId:     uuid.NewV4().String(),
+		Authorization:   uuid.NewV4().String(),
+		ExecutionOrg:    workflow.OrgId,
+		OrgId:           workflow.OrgId,
+		WorkflowId:      workflow.ID,
+		Workflow:        workflow,
+		Start:           workflow.Start,
+		StartedAt:       startedAt.Unix(),
+		ExecutionSource: synthetic.Source,
+		Status:          "FINISHED",
+		Results:         []shuffle.ActionResult{},
+	}
+
+	if synthetic.Source == TriggerWebhook {
+		execution.ExecutionArgument = fmt.Sprintf(`{"alert_id": "%d", "severity": "%s"}`, rng.Intn(100000), []string{"low", "medium", "high"}[rng.Intn(3)])
+	}
+
+	stopAt := -1
+	value := rng.Float64()
+	if value < failureRate*0.9 {
+		execution.Status = "FAILURE"
+		stopAt = rng.Intn(len(workflow.Actions))
+	} else if value < failureRate {
+		execution.Status = "ABORTED"
+		stopAt = rng.Intn(len(workflow.Actions))
+	}
+
+	// Action times are kept in milliseconds like the workers do
+	timestamp := startedAt.UnixMilli()
+	for index, action := range workflow.Actions {
+		result := shuffle.ActionResult{
+			Action:        action,
+			ExecutionId:   execution.ExecutionId,
+			Authorization: execution.Authorization,
+			StartedAt:     timestamp,
+			Status:        "SUCCESS",
+		}
+
+		if stopAt >= 0 && index > stopAt || execution.Status == "ABORTED" && index == stopAt {
+			result.Status = "SKIPPED"
+			result.Result = `{"success": false, "reason": "Skipped because of previous node"}`
+		} else if execution.Status == "FAILURE" && index == stopAt {
+			timestamp += int64(200 + rng.Intn(30000))
+			result.Status = "FAILURE"
+			result.Result = newSyntheticResult(action, syntheticFailureStatus[rng.Intn(len(syntheticFailureStatus))])
+		} else {
+			timestamp += int64(200 + rng.Intn(4000))
+			result.Result = newSyntheticResult(action, 200)
+			execution.LastNode = action.ID
+		}
+
+		result.CompletedAt = timestamp
+		execution.Results = append(execution.Results, result)
+	}
+
+	execution.CompletedAt = int64(math.Ceil(float64(timestamp) / 1000))
+	return execution
+}
+
+// Makes the executions of the workflows started on date and before until.
+// Less happens on weekends and outside working hours, except for schedules
+func newSyntheticDay(rng *rand.Rand, workflows []syntheticWorkflow, date, until time.Time, executionsPerDay int, failureRate float64) []shuffle.WorkflowExecution {
+	weekdayFactor := 1.0
+	if date.Weekday() == time.Saturday || date.Weekday() == time.Sunday {
+		weekdayFactor = 0.35
+	}
+
+	executions := []shuffle.WorkflowExecution{}
+	for _, workflow := range workflows {
+		factor := workflow.Factor
+		if workflow.Source != TriggerSchedule {
+			factor *= weekdayFactor
+		}
+
+		count := int(math.Round(float64(executionsPerDay) * factor * (0.75 + rng.Float64()*0.5)))
+		for i := 0; i < count; i++ {
+			hour := rng.Intn(24)
+			if workflow.Source != TriggerSchedule {
+				hour = pickSyntheticHour(rng)
+			}
+
+			startedAt := date.Add(time.Duration(hour)*time.Hour + time.Duration(rng.Intn(3600))*time.Second)
+			execution := newSyntheticExecution(rng, workflow, startedAt, failureRate)
+			if startedAt.Before(until) {
+				executions = append(executions, execution)
+			}
+		}
+	}
+
+	return executions
+}
+
+// Adds the counts of a day to the stored statistics, keeping what's there
+func addSyntheticDay(stored shuffle.DailyStatistics, day CslBackfillDay) shuffle.DailyStatistics {
+	stored.AppExecutions += day.Stats.AppExecutions
+	stored.AppExecutionsFailed += day.Stats.AppExecutionsFailed
+	stored.SubflowExecutions += day.Stats.SubflowExecutions
+	stored.WorkflowExecutions += day.Stats.WorkflowExecutions
+	stored.WorkflowExecutionsFinished += day.Stats.WorkflowExecutionsFinished
+	stored.WorkflowExecutionsFailed += day.Stats.WorkflowExecutionsFailed
+
+	additions := []shuffle.AdditionalUseConfig{}
+	for _, addition := range stored.Additions {
+		if value, ok := day.Additions[addition.Key]; ok {
+			addition.DailyValue += value
+			delete(day.Additions, addition.Key)
+		}
+
+		additions = append(additions, addition)
+	}
+
+	for key, value := range day.Additions {
+		if value == 0 {
+			continue
+		}
+
+		additions = append(additions, shuffle.AdditionalUseConfig{
+			Key:        key,
+			DailyValue: value,
+		})
+	}
+
+	stored.Additions = additions
+	return stored
+}
+
+// Generates the workflows and the executions of the days before today one
+// day at a time, then adds their counts to the org statistics
+func runSyntheticData(ctx context.Context, user shuffle.User, synthetic CslSyntheticData, location *time.Location) error {
+	orgId := user.ActiveOrg.Id
+	rng := rand.New(rand.NewSource(synthetic.Seed))
+
+	now := time.Now().In(location)
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location).AddDate(0, 0, -1)
+	start := end.AddDate(0, 0, -(synthetic.Days - 1))
+
+	workflows := []syntheticWorkflow{}
+	workflowIds := []string{}
+	for i := 0; i < synthetic.Workflows; i++ {
+		generated := newSyntheticWorkflow(rng, i, user, start.Unix())
+		workflows = append(workflows, generated)
+		workflowIds = append(workflowIds, generated.Workflow.ID)
+	}
+
+	// Stored first, so whatever gets created can be deleted if this fails
+	updateCslSyntheticData(ctx, orgId, func(stored *CslSyntheticData) {
+		stored.WorkflowIds = workflowIds
+	})
+
+	for _, workflow := range workflows {
+		err := shuffle.SetWorkflow(ctx, workflow.Workflow, workflow.Workflow.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	days := newBackfillDays(start, end)
+	executionCount := int64(0)
+	failedCount := int64(0)
+	for date := start; !date.After(end); date = date.AddDate(0, 0, 1) {
+		day := days[date.Format(UsageDateFormat)]
+
+		executions := newSyntheticDay(rng, workflows, date, date.AddDate(0, 0, 1), synthetic.ExecutionsPerDay, synthetic.FailureRate)
+		for _, execution := range executions {
+			countBackfillExecution(day, execution)
+			if execution.Status != "FINISHED" {
+				failedCount++
+			}
+		}
+
+		err := shuffle.SetWorkflowExecutionsBulk(ctx, executions)
+		if err != nil {
+			return err
+		}
+
+		executionCount += int64(len(executions))
+		updateCslSyntheticData(ctx, orgId, func(stored *CslSyntheticData) {
+			stored.Executions = executionCount
+			stored.FailedExecutions = failedCount
+		})
+	}
+
+	for _, workflow := range workflows {
+		shuffle.DeleteCache(ctx, fmt.Sprintf("workflowexecution_%s", workflow.Workflow.ID))
+	}
+
+	dayCount := len(days)
+	err := storeBackfillDays(ctx, orgId, days, location, addSyntheticDay)
+	if err != nil {
+		return err
+	}
+
+	updateCslSyntheticData(ctx, orgId, func(stored *CslSyntheticData) {
+		stored.Status = SyntheticFinished
+		stored.Executions = executionCount
+		stored.FailedExecutions = failedCount
+		stored.DaysUpdated = dayCount
+		stored.FinishedAt = time.Now().Unix()
+	})
+
+	return nil
+}
+
+// Removes the generated workflows and their executions. The statistics they
+// were counted in are kept, as real executions are counted on the same days
+func deleteSyntheticData(ctx context.Context, orgId string, workflowIds []string) error {
+	for _, workflowId := range workflowIds {
+		for {
+			executions, err := shuffle.GetAllWorkflowExecutions(ctx, workflowId, SyntheticDeleteBatch)
+			if err != nil {
+				return err
+			}
+
+			if len(executions) == 0 {
+				break
+			}
+
+			ids := []string{}
+			for _, execution := range executions {
+				ids = append(ids, execution.ExecutionId)
+			}
+
+			err = shuffle.DeleteKeys(ctx, "workflowexecution", ids)
+			if err != nil {
+				return err
+			}
+
+			if len(executions) < SyntheticDeleteBatch {
+				break
+			}
+		}
+
+		// Same list caches SetWorkflowExecution clears
+		shuffle.DeleteCache(ctx, fmt.Sprintf("workflowexecution_%s", workflowId))
+		shuffle.DeleteCache(ctx, fmt.Sprintf("workflowexecution_%s_50", workflowId))
+		shuffle.DeleteCache(ctx, fmt.Sprintf("workflowexecution_%s_100", workflowId))
+
+		err := shuffle.DeleteKey(ctx, "workflow", workflowId)
+		if err != nil {
+			return err
+		}
+	}
+
+	shuffle.DeleteCache(ctx, fmt.Sprintf("%s_workflows", orgId))
+	return nil
+}
+
+func checkSyntheticDataEnabled(resp http.ResponseWriter) bool {
+	if syntheticDataEnabled() {
+		return true
+	}
+
+	resp.WriteHeader(403)
+	resp.Write(createCslErrorResponse(errors.New("synthetic data is disabled. Set SHUFFLE_SYNTHETIC_DATA=true to enable it")))
+	return false
+}
+
+/*
+Synthetic data:
+Returns the last synthetic data generation of the current organization.
+Status is empty if nothing has been generated. Requires org admin.
+
+	{
+	    "success": true,
+	    "data": {
+	        "status": "finished",
+	        "workflows": 10,
+	        "days": 30,
+	        "executions_per_day": 20,
+	        "failure_rate": 0.1,
+	        "seed": 42,
+	        "workflow_ids": ["8b0c0a4e-..."],
+	        "executions": 5210,
+	        "failed_executions": 498,
+	        "days_updated": 30,
+	        "started_by": "admin",
+	        "started_at": 1712345678,
+	        "finished_at": 1712345720
+	    }
+	}
+*/
+func cslGetSyntheticData(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !checkSyntheticDataEnabled(resp) {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslSyntheticData(ctx, user.ActiveOrg.Id),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetSyntheticData")
+}
+
+/*
+Synthetic data:
+Generates workflows tagged "synthetic" in the current organization, with
+executions spread over the days before today and their counts added to the
+daily statistics. Executions follow working hours and weekdays, except for
+scheduled workflows, and fail at failure_rate with a mix of failure
+categories. The same seed gives the same shape of data. Requires org admin
+and SHUFFLE_SYNTHETIC_DATA=true. Runs in the background, use GET for
+progress. All fields are optional.
+
+	{
+	    "workflows": 10,
+	    "days": 30,
+	    "executions_per_day": 20,
+	    "failure_rate": 0.1,
+	    "seed": 42
+	}
+*/
+func cslStartSyntheticData(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !checkSyntheticDataEnabled(resp) {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	syntheticRequest := CslSyntheticDataRequest{}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &syntheticRequest)
+		if err != nil {
+			log.Printf("[WARNING] Failed unmarshaling synthetic data request: %s", err)
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+	}
+
+	if syntheticRequest.Workflows == 0 {
+		syntheticRequest.Workflows = DefaultSyntheticWorkflows
+	}
+
+	if syntheticRequest.Days == 0 {
+		syntheticRequest.Days = DefaultSyntheticDays
+	}
+
+	if syntheticRequest.ExecutionsPerDay == 0 {
+		syntheticRequest.ExecutionsPerDay = DefaultSyntheticExecutionsPerDay
+	}
+
+	failureRate := DefaultSyntheticFailureRate
+	if syntheticRequest.FailureRate != nil {
+		failureRate = *syntheticRequest.FailureRate
+	}
+
+	if syntheticRequest.Seed == 0 {
+		syntheticRequest.Seed = time.Now().UnixNano()
+	}
+
+	if syntheticRequest.Workflows < 0 || syntheticRequest.Workflows > MaxSyntheticWorkflows {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("workflows must be between 1 and %d", MaxSyntheticWorkflows))))
+		return
+	}
+
+	if syntheticRequest.Days < 0 || syntheticRequest.Days > MaxSyntheticDays {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("days must be between 1 and %d", MaxSyntheticDays))))
+		return
+	}
+
+	if failureRate < 0 || failureRate > 1 {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("failure_rate must be between 0 and 1")))
+		return
+	}
+
+	if syntheticRequest.ExecutionsPerDay < 0 || syntheticRequest.Workflows*syntheticRequest.Days*syntheticRequest.ExecutionsPerDay > MaxSyntheticExecutions {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("workflows * days * executions_per_day can be at most %d", MaxSyntheticExecutions))))
+		return
+	}
+
+	synthetic := CslSyntheticData{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslSyntheticDataDocument, &synthetic, func() error {
+		if isSyntheticDataBusy(synthetic) {
+			return errors.New("synthetic data is already being generated or deleted")
+		}
+
+		if synthetic.Status != SyntheticDeleted && len(synthetic.WorkflowIds) > 0 {
+			return errors.New("synthetic data already exists. Delete it before generating more")
+		}
+
+		synthetic = CslSyntheticData{
+			Status:           SyntheticRunning,
+			Workflows:        syntheticRequest.Workflows,
+			Days:             syntheticRequest.Days,
+			ExecutionsPerDay: syntheticRequest.ExecutionsPerDay,
+			FailureRate:      failureRate,
+			Seed:             syntheticRequest.Seed,
+			WorkflowIds:      []string{},
+			StartedBy:        user.Username,
+			StartedAt:        time.Now().Unix(),
+		}
+
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(409)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) started generating synthetic data with %d workflows over %d days for org %s", user.Username, user.Id, synthetic.Workflows, synthetic.Days, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "synthetic_data_started", fmt.Sprintf("Synthetic data with %d workflows over %d days is being generated", synthetic.Workflows, synthetic.Days), user.Username, "")
+
+	location := getTimezoneLocation(getCslOrgSettings(ctx, user.ActiveOrg.Id).Timezone)
+	generatingUser := *user
+	go func() {
+		orgId := generatingUser.ActiveOrg.Id
+		err := runSyntheticData(context.Background(), generatingUser, synthetic, location)
+		if err != nil {
+			log.Printf("[ERROR] Synthetic data generation failed for org %s: %s", orgId, err)
+			updateCslSyntheticData(context.Background(), orgId, func(stored *CslSyntheticData) {
+				stored.Status = SyntheticFailed
+				stored.Error = err.Error()
+				stored.FinishedAt = time.Now().Unix()
+			})
+
+			return
+		}
+
+		log.Printf("[INFO] Synthetic data generation finished for org %s", orgId)
+	}()
+
+	res := CslResponse{
+		Success: true,
+		Data:    synthetic,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslStartSyntheticData")
+}
+
+/*
+Synthetic data:
+Removes the generated workflows and their executions from the current
+organization. The daily statistics they were added to are kept. Requires org
+admin and SHUFFLE_SYNTHETIC_DATA=true. Runs in the background, use GET for
+progress.
+*/
+func cslDeleteSyntheticData(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !checkSyntheticDataEnabled(resp) {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	synthetic := CslSyntheticData{}
+	err := updateCslDocument(ctx, user.ActiveOrg.Id, CslSyntheticDataDocument, &synthetic, func() error {
+		if isSyntheticDataBusy(synthetic) {
+			return errors.New("synthetic data is already being generated or deleted")
+		}
+
+		if len(synthetic.WorkflowIds) == 0 || synthetic.Status == SyntheticDeleted {
+			return errors.New("there is no synthetic data to delete")
+		}
+
+		synthetic.Status = SyntheticDeleting
+		synthetic.Error = ""
+		synthetic.StartedAt = time.Now().Unix()
+		synthetic.FinishedAt = 0
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(409)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) started deleting %d synthetic workflows for org %s", user.Username, user.Id, len(synthetic.WorkflowIds), user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "synthetic_data_deleted", fmt.Sprintf("%d synthetic workflows are being deleted", len(synthetic.WorkflowIds)), user.Username, "")
+
+	orgId := user.ActiveOrg.Id
+	workflowIds := synthetic.WorkflowIds
+	go func() {
+		err := deleteSyntheticData(context.Background(), orgId, workflowIds)
+		if err != nil {
+			log.Printf("[ERROR] Synthetic data deletion failed for org %s: %s", orgId, err)
+			updateCslSyntheticData(context.Background(), orgId, func(stored *CslSyntheticData) {
+				stored.Status = SyntheticFailed
+				stored.Error = err.Error()
+				stored.FinishedAt = time.Now().Unix()
+			})
+
+			return
+		}
+
+		updateCslSyntheticData(context.Background(), orgId, func(stored *CslSyntheticData) {
+			stored.Status = SyntheticDeleted
+			stored.FinishedAt = time.Now().Unix()
+		})
+
+		log.Printf("[INFO] Synthetic data deleted for org %s", orgId)
+	}()
+
+	res := CslResponse{
+		Success: true,
+		Data:    synthetic,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslDeleteSyntheticData")
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslTeamsDocument = "teams"
+
+// Teams shows at most this much text in a card
+const TeamsMaxTextLength = 2000
+
+// WebhookUrl is an incoming webhook or a Workflows webhook of a Teams channel.
+// Events defaults to every event except execution_failed, which has to be
+// listed. An empty MinSeverity matches all severities
+type CslTeamsConfig struct {
+	Enabled     bool     `json:"enabled"`
+	WebhookUrl  string   `json:"webhook_url"`
+	Events      []string `json:"events"`
+	MinSeverity string   `json:"min_severity"`
+}
+
+type CslTeamsState struct {
+	LastSent  int64  `json:"last_sent"`
+	LastError string `json:"last_error"`
+}
+
+type CslTeams struct {
+	Config CslTeamsConfig `json:"config"`
+	State  CslTeamsState  `json:"state"`
+}
+
+// Adaptive card colors of the title
+var teamsSeverityColors = map[string]string{
+	SeverityInfo:     "Default",
+	SeverityWarning:  "Warning",
+	SeverityCritical: "Attention",
+}
+
+func getCslTeams(ctx context.Context, orgId string) CslTeams {
+	teams := CslTeams{}
+	_, err := getCslDocument(ctx, orgId, CslTeamsDocument, &teams)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting teams config for org %s: %s", orgId, err)
+	}
+
+	if teams.Config.Events == nil {
+		teams.Config.Events = []string{}
+	}
+
+	return teams
+}
+
+func updateCslTeamsState(ctx context.Context, orgId string, update func(state *CslTeamsState)) error {
+	teams := CslTeams{}
+	return updateCslDocument(ctx, orgId, CslTeamsDocument, &teams, func() error {
+		update(&teams.State)
+		return nil
+	})
+}
+
+func redactCslTeams(teams CslTeams) CslTeams {
+	if len(teams.Config.WebhookUrl) > 0 {
+		teams.Config.WebhookUrl = RedactedValue
+	}
+
+	return teams
+}
+
+func validateCslTeamsConfig(config CslTeamsConfig) error {
+	if len(config.WebhookUrl) > 0 && !strings.HasPrefix(config.WebhookUrl, "https://") {
+		return errors.New("webhook_url must start with https://")
+	}
+
+	err := validateEventFilter(config.Events, config.MinSeverity)
+	if err != nil {
+		return err
+	}
+
+	if config.Enabled && len(config.WebhookUrl) == 0 {
+		return errors.New("webhook_url is required")
+	}
+
+	return nil
+}
+
+func teamsEventMatches(config CslTeamsConfig, notification CslNotification) bool {
+	events := config.Events
+	if len(events) == 0 {
+		events = []string{"*"}
+	}
+
+	return eventMatches(events, config.MinSeverity, notification)
+}
+
+// Message with a single adaptive card, accepted by both incoming webhooks and
+// Workflows webhooks
+func getTeamsMessage(notification CslNotification) map[string]interface{} {
+	body := []map[string]interface{}{
+		{
+			"type":   "TextBlock",
+			"text":   notification.Title,
+			"weight": "Bolder",
+			"size":   "Medium",
+			"color":  teamsSeverityColors[notification.Severity],
+			"wrap":   true,
+		},
+	}
+
+	if len(notification.Text) > 0 {
+		body = append(body, map[string]interface{}{
+			"type": "TextBlock",
+			"text": truncateText(notification.Text, TeamsMaxTextLength),
+			"wrap": true,
+		})
+	}
+
+	if len(notification.Fields) > 0 {
+		facts := []map[string]interface{}{}
+		for _, field := range notification.Fields {
+			facts = append(facts, map[string]interface{}{
+				"title": field.Name,
+				"value": field.Value,
+			})
+		}
+
+		body = append(body, map[string]interface{}{
+			"type":  "FactSet",
+			"facts": facts,
+		})
+	}
+
+	body = append(body, map[string]interface{}{
+		"type":     "TextBlock",
+		"text":     fmt.Sprintf("Shuffle | %s | org %s | %s", notification.Event, notification.OrgId, time.Unix(notification.Timestamp, 0).UTC().Format(time.RFC3339)),
+		"isSubtle": true,
+		"size":     "Small",
+		"wrap":     true,
+	})
+
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{
+			{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]interface{}{
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"body":    body,
+					"msteams": map[string]interface{}{"width": "Full"},
+				},
+			},
+		},
+	}
+}
+
+func postTeamsMessage(ctx context.Context, webhookUrl string, notification CslNotification) error {
+	b, err := json.Marshal(getTeamsMessage(notification))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", webhookUrl, bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	client := shuffle.GetExternalClient(webhookUrl)
+	newresp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer newresp.Body.Close()
+	body, err := ioutil.ReadAll(newresp.Body)
+	if err != nil {
+		return err
+	}
+
+	if newresp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("teams returned status code %d: %s", newresp.StatusCode, string(body)))
+	}
+
+	return nil
+}
+
+// Sends the notification if Teams is enabled for the org and subscribes to the event
+func sendCslTeamsNotification(ctx context.Context, orgId string, notification CslNotification) error {
+	teams := getCslTeams(ctx, orgId)
+	if !teams.Config.Enabled || !teamsEventMatches(teams.Config, notification) {
+		return nil
+	}
+
+	sendErr := postTeamsMessage(ctx, teams.Config.WebhookUrl, notification)
+	if sendErr != nil {
+		log.Printf("[ERROR] Failed sending %s to Teams for org %s: %s", notification.Event, orgId, sendErr)
+	}
+
+	err := updateCslTeamsState(ctx, orgId, func(state *CslTeamsState) {
+		state.LastSent = time.Now().Unix()
+		state.LastError = ""
+		if sendErr != nil {
+			state.LastError = sendErr.Error()
+		}
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed updating teams state for org %s: %s", orgId, err)
+	}
+
+	return sendErr
+}
+
+/*
+Notifications:
+Returns the Microsoft Teams notification configuration and state for the
+current organization. Requires org admin. The webhook url is redacted.
+
+	{
+	    "success": true,
+	    "data": {
+	        "config": {
+	            "enabled": true,
+	            "webhook_url": "********",
+	            "events": ["execution_failed", "execution_failure_spike", "quota_exceeded"],
+	            "min_severity": "warning"
+	        },
+	        "state": {
+	            "last_sent": 1700000000,
+	            "last_error": ""
+	        }
+	    }
+	}
+*/
+func cslGetTeams(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslTeams(getCslTeams(ctx, user.ActiveOrg.Id)),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetTeams")
+}
+
+/*
+Notifications:
+Updates the Microsoft Teams notification configuration. Requires org admin.
+Body uses the format of the config field returned from GET, and a redacted
+webhook url is kept as it is. Events are sent as adaptive cards. Without events
+every event except execution_failed is sent, the same events as for Slack.
+*/
+func cslSetTeams(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	teams := getCslTeams(ctx, user.ActiveOrg.Id)
+	previousConfig := teams.Config
+
+	err = json.Unmarshal(body, &teams.Config)
+	if err != nil {
+		log.Printf("[WARNING] Failed unmarshaling teams config: %s", err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if teams.Config.WebhookUrl == RedactedValue {
+		teams.Config.WebhookUrl = previousConfig.WebhookUrl
+	}
+
+	if teams.Config.Events == nil {
+		teams.Config.Events = []string{}
+	}
+
+	err = validateCslTeamsConfig(teams.Config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	stored := CslTeams{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslTeamsDocument, &stored, func() error {
+		stored.Config = teams.Config
+		teams = stored
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated teams config for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "teams_updated", "Teams notification configuration was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslTeams(teams),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetTeams")
+}
+
+/*
+Notifications:
+Sends a test card to the Teams webhook. Requires org admin and Teams to be enabled.
+*/
+func cslTestTeams(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	teams := getCslTeams(ctx, user.ActiveOrg.Id)
+	if !teams.Config.Enabled {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("teams is not enabled")))
+		return
+	}
+
+	notification := getCslNotification(user.ActiveOrg.Id, EventTest, fmt.Sprintf("Test message sent by %s", user.Username))
+	notification.Title = "Shuffle test notification"
+
+	err := postTeamsMessage(ctx, teams.Config.WebhookUrl, notification)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslTestTeams")
+}
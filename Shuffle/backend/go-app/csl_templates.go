@@ -0,0 +1,587 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Curated workflow templates shared by every org. Templates are managed by
+// users with support access, and installing one creates a new copy of its
+// workflows in the org
+
+const CslTemplateCatalogOrgId = "csl_template_catalog"
+const CslTemplateCatalogDocument = "templates"
+
+const MaxTemplateCategories = 10
+
+// Deployments counts installs, and Orgs the installs per org
+type CslTemplate struct {
+	Id           string             `json:"id"`
+	Name         string             `json:"name"`
+	Description  string             `json:"description"`
+	Categories   []string           `json:"categories"`
+	RequiredApps []CslBundleApp     `json:"required_apps"`
+	RequiredAuth []CslBundleAuth    `json:"required_auth"`
+	Bundle       *CslWorkflowBundle `json:"bundle,omitempty"`
+	CreatedBy    string             `json:"created_by"`
+	Created      int64              `json:"created"`
+	Updated      int64              `json:"updated"`
+	Deployments  int                `json:"deployments"`
+	Orgs         map[string]int     `json:"orgs,omitempty"`
+	LastDeployed int64              `json:"last_deployed"`
+}
+
+type CslTemplateCatalog struct {
+	Templates map[string]CslTemplate `json:"templates"`
+}
+
+type CslTemplateRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Categories  []string `json:"categories"`
+}
+
+type CslTemplateCategory struct {
+	Name      string `json:"name"`
+	Templates int    `json:"templates"`
+}
+
+type CslTemplateStats struct {
+	Id           string   `json:"id"`
+	Name         string   `json:"name"`
+	Categories   []string `json:"categories"`
+	Deployments  int      `json:"deployments"`
+	Orgs         int      `json:"orgs"`
+	LastDeployed int64    `json:"last_deployed"`
+}
+
+type CslTemplateInstall struct {
+	TemplateId string `json:"template_id"`
+	CslBundleInstall
+	MissingApps []CslBundleApp `json:"missing_apps"`
+}
+
+func getCslTemplateCatalog(ctx context.Context) CslTemplateCatalog {
+	catalog := CslTemplateCatalog{}
+	_, err := getCslDocument(ctx, CslTemplateCatalogOrgId, CslTemplateCatalogDocument, &catalog)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting template catalog: %s", err)
+	}
+
+	if catalog.Templates == nil {
+		catalog.Templates = map[string]CslTemplate{}
+	}
+
+	return catalog
+}
+
+// Lowercases and deduplicates categories so filtering is exact
+func normalizeTemplateCategories(categories []string) ([]string, error) {
+	normalized := []string{}
+	for _, category := range categories {
+		category = strings.ToLower(strings.TrimSpace(category))
+		if len(category) > 0 && !shuffle.ArrayContains(normalized, category) {
+			normalized = append(normalized, category)
+		}
+	}
+
+	if len(normalized) > MaxTemplateCategories {
+		return normalized, errors.New(fmt.Sprintf("templates can have at most %d categories", MaxTemplateCategories))
+	}
+
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// Removes what other orgs shouldn't see from a template
+func getPublicTemplate(template CslTemplate) CslTemplate {
+	template.Bundle = nil
+	template.Orgs = nil
+	return template
+}
+
+/*
+Templates:
+Returns the templates in the catalog, most deployed first. Filter with
+?category=<category> and ?search=<text>, which matches the name and
+description.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "id": "...",
+	            "name": "Phishing triage",
+	            "description": "Enriches and triages reported emails",
+	            "categories": ["email", "phishing"],
+	            "required_apps": [
+	                {"id": "...", "name": "VirusTotal", "app_version": "1.0.0"}
+	            ],
+	            "required_auth": [
+	                {"id": "...", "label": "VirusTotal", "app_name": "VirusTotal", "fields": ["apikey"]}
+	            ],
+	            "created_by": "support@example.com",
+	            "created": 1700000000,
+	            "updated": 1700000000,
+	            "deployments": 12,
+	            "last_deployed": 1700000000
+	        }
+	    ]
+	}
+*/
+func cslListTemplates(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	category := strings.ToLower(request.URL.Query().Get("category"))
+	search := strings.ToLower(request.URL.Query().Get("search"))
+
+	templates := []CslTemplate{}
+	for _, template := range getCslTemplateCatalog(ctx).Templates {
+		if len(category) > 0 && !shuffle.ArrayContains(template.Categories, category) {
+			continue
+		}
+
+		if len(search) > 0 && !strings.Contains(strings.ToLower(template.Name), search) && !strings.Contains(strings.ToLower(template.Description), search) {
+			continue
+		}
+
+		templates = append(templates, getPublicTemplate(template))
+	}
+
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].Deployments != templates[j].Deployments {
+			return templates[i].Deployments > templates[j].Deployments
+		}
+
+		return templates[i].Name < templates[j].Name
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data:    templates,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslListTemplates")
+}
+
+/*
+Templates:
+Returns ?template_id=<id> with the required apps that aren't available and
+the required auth that doesn't exist in the current organization. Auth is
+matched by app and label, the same way as when installing.
+
+	{
+	    "success": true,
+	    "data": {
+	        "template": {...},
+	        "missing_apps": [],
+	        "missing_auth": [
+	            {"id": "...", "label": "VirusTotal", "app_name": "VirusTotal", "fields": ["apikey"]}
+	        ]
+	    }
+	}
+*/
+func cslGetTemplate(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	template, ok := getCslTemplateCatalog(ctx).Templates[request.URL.Query().Get("template_id")]
+	if !ok {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New("template not found")))
+		return
+	}
+
+	_, missingAuth := mapBundleAuth(ctx, user.ActiveOrg.Id, template.RequiredAuth)
+
+	res := CslResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"template":     getPublicTemplate(template),
+			"missing_apps": findMissingApps(ctx, *user, template.RequiredApps),
+			"missing_auth": missingAuth,
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetTemplate")
+}
+
+/*
+Templates:
+Returns the categories in the catalog with the amount of templates in each.
+
+	{
+	    "success": true,
+	    "data": [
+	        {"name": "email", "templates": 3},
+	        {"name": "phishing", "templates": 2}
+	    ]
+	}
+*/
+func cslListTemplateCategories(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	counts := map[string]int{}
+	for _, template := range getCslTemplateCatalog(ctx).Templates {
+		for _, category := range template.Categories {
+			counts[category] += 1
+		}
+	}
+
+	categories := []CslTemplateCategory{}
+	for name, count := range counts {
+		categories = append(categories, CslTemplateCategory{
+			Name:      name,
+			Templates: count,
+		})
+	}
+
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].Name < categories[j].Name
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data:    categories,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslListTemplateCategories")
+}
+
+/*
+Templates:
+Adds the workflow ?workflow_id=<id> and its subflows to the catalog, or
+replaces the workflows of ?template_id=<id>. Requires support access. Apps
+and auth the workflows use become the required apps and auth of the
+template, and auth values are never stored.
+
+	{
+	    "name": "Phishing triage",
+	    "description": "Enriches and triages reported emails",
+	    "categories": ["email", "phishing"]
+	}
+*/
+func cslSetTemplate(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !user.SupportAccess {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("managing templates requires support access")))
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	workflow, err := getCslWorkflow(ctx, *user, request.URL.Query().Get("workflow_id"))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	templateRequest := CslTemplateRequest{}
+	err = json.Unmarshal(body, &templateRequest)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	categories, err := normalizeTemplateCategories(templateRequest.Categories)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	bundle, err := createWorkflowBundle(ctx, *user, *workflow)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	templateId := request.URL.Query().Get("template_id")
+	now := time.Now().Unix()
+	template := CslTemplate{}
+	catalog := CslTemplateCatalog{}
+	err = updateCslDocument(ctx, CslTemplateCatalogOrgId, CslTemplateCatalogDocument, &catalog, func() error {
+		if catalog.Templates == nil {
+			catalog.Templates = map[string]CslTemplate{}
+		}
+
+		if len(templateId) > 0 {
+			existing, ok := catalog.Templates[templateId]
+			if !ok {
+				return errors.New("template not found")
+			}
+
+			template = existing
+		} else {
+			template = CslTemplate{
+				Id:        uuid.NewV4().String(),
+				CreatedBy: user.Username,
+				Created:   now,
+				Orgs:      map[string]int{},
+			}
+		}
+
+		template.Name = templateRequest.Name
+		if len(template.Name) == 0 {
+			template.Name = workflow.Name
+		}
+
+		template.Description = templateRequest.Description
+		template.Categories = categories
+		template.RequiredApps = bundle.Apps
+		template.RequiredAuth = bundle.Auth
+		template.Bundle = &bundle
+		template.Updated = now
+
+		catalog.Templates[template.Id] = template
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) saved workflow %s as template %s", user.Username, user.Id, workflow.ID, template.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "template_saved", fmt.Sprintf("%s was saved to the template catalog", template.Name), user.Username, template.Id)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getPublicTemplate(template),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetTemplate")
+}
+
+/*
+Templates:
+Removes ?template_id=<id> from the catalog. Requires support access.
+Installed copies aren't changed.
+*/
+func cslDeleteTemplate(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if !user.SupportAccess {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("managing templates requires support access")))
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	templateId := request.URL.Query().Get("template_id")
+
+	catalog := CslTemplateCatalog{}
+	err := updateCslDocument(ctx, CslTemplateCatalogOrgId, CslTemplateCatalogDocument, &catalog, func() error {
+		if _, ok := catalog.Templates[templateId]; !ok {
+			return errors.New("template not found")
+		}
+
+		delete(catalog.Templates, templateId)
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) deleted template %s", user.Username, user.Id, templateId)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "template_deleted", "A template was removed from the catalog", user.Username, templateId)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslDeleteTemplate")
+}
+
+/*
+Templates:
+Installs ?template_id=<id> in the current organization as new workflows.
+Requires org admin. Required auth is mapped to auth with the same app and
+label, or with the optional body:
+
+	{
+	    "auth_mapping": {"<required auth id>": "<auth id>"}
+	}
+
+Triggers are installed stopped. Apps and auth that are missing are returned
+so they can be set up before the workflows are used.
+
+	{
+	    "success": true,
+	    "data": {
+	        "template_id": "...",
+	        "workflows": {"<template workflow id>": "<installed workflow id>"},
+	        "auth_mapping": {"<required auth id>": "<auth id>"},
+	        "missing_auth": [],
+	        "missing_apps": []
+	    }
+	}
+*/
+func cslInstallTemplate(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	template, ok := getCslTemplateCatalog(ctx).Templates[request.URL.Query().Get("template_id")]
+	if !ok || template.Bundle == nil {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(errors.New("template not found")))
+		return
+	}
+
+	installRequest := CslInstallRequest{}
+	body, err := ioutil.ReadAll(request.Body)
+	if err == nil && len(body) > 0 {
+		err = json.Unmarshal(body, &installRequest)
+	}
+
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	installed, err := installCslBundle(ctx, user.ActiveOrg.Id, *user, *template.Bundle, CslBundleInstall{}, installRequest.AuthMapping)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	catalog := CslTemplateCatalog{}
+	err = updateCslDocument(ctx, CslTemplateCatalogOrgId, CslTemplateCatalogDocument, &catalog, func() error {
+		updated, ok := catalog.Templates[template.Id]
+		if !ok {
+			return nil
+		}
+
+		if updated.Orgs == nil {
+			updated.Orgs = map[string]int{}
+		}
+
+		updated.Deployments += 1
+		updated.Orgs[user.ActiveOrg.Id] += 1
+		updated.LastDeployed = time.Now().Unix()
+		catalog.Templates[template.Id] = updated
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed recording deployment of template %s: %s", template.Id, err)
+	}
+
+	log.Printf("[AUDIT] User %s (%s) installed template %s in org %s", user.Username, user.Id, template.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeWorkflow, "template_installed", fmt.Sprintf("%s was installed from the template catalog", template.Name), user.Username, template.Id)
+
+	res := CslResponse{
+		Success: true,
+		Data: CslTemplateInstall{
+			TemplateId:       template.Id,
+			CslBundleInstall: installed,
+			MissingApps:      findMissingApps(ctx, *user, template.RequiredApps),
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslInstallTemplate")
+}
+
+/*
+Templates:
+Returns deployment statistics of the templates, most deployed first. orgs is
+the amount of orgs that installed the template.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "id": "...",
+	            "name": "Phishing triage",
+	            "categories": ["email", "phishing"],
+	            "deployments": 12,
+	            "orgs": 7,
+	            "last_deployed": 1700000000
+	        }
+	    ]
+	}
+*/
+func cslTemplateStats(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	stats := []CslTemplateStats{}
+	for _, template := range getCslTemplateCatalog(ctx).Templates {
+		stats = append(stats, CslTemplateStats{
+			Id:           template.Id,
+			Name:         template.Name,
+			Categories:   template.Categories,
+			Deployments:  template.Deployments,
+			Orgs:         len(template.Orgs),
+			LastDeployed: template.LastDeployed,
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Deployments != stats[j].Deployments {
+			return stats[i].Deployments > stats[j].Deployments
+		}
+
+		return stats[i].Name < stats[j].Name
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data:    stats,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslTemplateStats")
+}
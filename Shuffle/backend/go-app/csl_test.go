@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetCslRequestOrgId(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		headers  map[string]string
+		expected string
+	}{
+		{name: "none", url: "/api/v1/workflows"},
+		{name: "org-id header", url: "/api/v1/workflows?org_id=query", headers: map[string]string{"Org-Id": "header", "OrgId": "other"}, expected: "header"},
+		{name: "query", url: "/api/v1/workflows?org_id=query", headers: map[string]string{"OrgId": "other"}, expected: "query"},
+		{name: "orgid header", url: "/api/v1/workflows", headers: map[string]string{"OrgId": "other"}, expected: "other"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", test.url, nil)
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+
+			result := getCslRequestOrgId(request)
+			if result != test.expected {
+				t.Errorf("got %q, expected %q", result, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Max amount of executions in a chain of parent executions and subflows
+const MaxTimelineExecutions = 25
+
+// Timeline event types
+const (
+	TimelineTrigger           = "trigger"
+	TimelineAction            = "action"
+	TimelineApprovalRequested = "approval_requested"
+	TimelineApprovalAnswered  = "approval_answered"
+	TimelineApprovalEscalated = "approval_escalated"
+	TimelineSubflow           = "subflow"
+	TimelineExecutionFinished = "execution_finished"
+	TimelineTicket            = "ticket"
+	TimelineComment           = "comment"
+	TimelineArtifact          = "artifact"
+)
+
+type CslTimelineEvent struct {
+	Timestamp   int64  `json:"timestamp"`
+	Type        string `json:"type"`
+	Title       string `json:"title"`
+	Status      string `json:"status,omitempty"`
+	Actor       string `json:"actor,omitempty"`
+	ExecutionId string `json:"execution_id"`
+	Reference   string `json:"reference,omitempty"`
+	Details     string `json:"details,omitempty"`
+}
+
+type CslTimelineExecution struct {
+	ExecutionId     string `json:"execution_id"`
+	ParentId        string `json:"parent_id,omitempty"`
+	WorkflowId      string `json:"workflow_id"`
+	WorkflowName    string `json:"workflow_name"`
+	Status          string `json:"status"`
+	StartedAt       int64  `json:"started_at"`
+	CompletedAt     int64  `json:"completed_at"`
+	ExecutionSource string `json:"execution_source"`
+}
+
+type CslTimeline struct {
+	RootExecutionId string                 `json:"root_execution_id"`
+	Executions      []CslTimelineExecution `json:"executions"`
+	Events          []CslTimelineEvent     `json:"events"`
+}
+
+// Action results are stored in seconds, milliseconds or nanoseconds depending
+// on what wrote them
+func normalizeTimestamp(timestamp int64) int64 {
+	switch {
+	case timestamp > 100000000000000000:
+		return timestamp / 1000000000
+	case timestamp > 100000000000000:
+		return timestamp / 1000000
+	case timestamp > 100000000000:
+		return timestamp / 1000
+	}
+
+	return timestamp
+}
+
+// Subflow executions started by an action, read from the results of subflow
+// and User Input actions
+func getResultSubflowIds(result shuffle.ActionResult) []string {
+	executionIds := []string{}
+	if !strings.Contains(result.Result, "execution_id") {
+		return executionIds
+	}
+
+	subflow := shuffle.SubflowData{}
+	if json.Unmarshal([]byte(result.Result), &subflow) == nil && len(subflow.ExecutionId) > 0 {
+		return append(executionIds, subflow.ExecutionId)
+	}
+
+	// Subflows running in parallel return a list
+	subflows := []shuffle.SubflowData{}
+	if json.Unmarshal([]byte(result.Result), &subflows) == nil {
+		for _, subflow := range subflows {
+			if len(subflow.ExecutionId) > 0 {
+				executionIds = append(executionIds, subflow.ExecutionId)
+			}
+		}
+
+		return executionIds
+	}
+
+	userInput := shuffle.UserInputResponse{}
+	if json.Unmarshal([]byte(result.Result), &userInput) == nil && len(userInput.Subflow.ExecutionID) > 0 {
+		executionIds = append(executionIds, userInput.Subflow.ExecutionID)
+	}
+
+	return executionIds
+}
+
+// Finds the root of the chain the execution belongs to through its parents,
+// then every subflow below it. Executions of other orgs are left out
+func getExecutionChain(ctx context.Context, orgId string, execution *shuffle.WorkflowExecution) []shuffle.WorkflowExecution {
+	root := execution
+	for i := 0; i < MaxTimelineExecutions && len(root.ExecutionParent) > 0; i++ {
+		parent, err := shuffle.GetWorkflowExecution(ctx, root.ExecutionParent)
+		if err != nil || parent.ExecutionOrg != orgId || parent.ExecutionId == root.ExecutionId {
+			break
+		}
+
+		root = parent
+	}
+
+	chain := []shuffle.WorkflowExecution{*root}
+	seen := map[string]bool{root.ExecutionId: true}
+	for i := 0; i < len(chain) && len(chain) < MaxTimelineExecutions; i++ {
+		executionIds := []string{}
+		for _, result := range chain[i].Results {
+			executionIds = append(executionIds, getResultSubflowIds(result)...)
+		}
+
+		for _, executionId := range executionIds {
+			if seen[executionId] || len(chain) >= MaxTimelineExecutions {
+				continue
+			}
+
+			seen[executionId] = true
+			subflow, err := shuffle.GetWorkflowExecution(ctx, executionId)
+			if err != nil || subflow.ExecutionOrg != orgId {
+				continue
+			}
+
+			if len(subflow.ExecutionParent) == 0 {
+				subflow.ExecutionParent = chain[i].ExecutionId
+			}
+
+			chain = append(chain, *subflow)
+		}
+	}
+
+	return chain
+}
+
+func getExecutionTimelineEvents(execution shuffle.WorkflowExecution) []CslTimelineEvent {
+	events := []CslTimelineEvent{}
+
+	trigger := CslTimelineEvent{
+		Timestamp:   execution.StartedAt,
+		Type:        TimelineTrigger,
+		Title:       fmt.Sprintf("%s was triggered", execution.Workflow.Name),
+		ExecutionId: execution.ExecutionId,
+		Reference:   execution.Start,
+		Details:     fmt.Sprintf("source: %s", execution.ExecutionSource),
+	}
+
+	if len(execution.ExecutionParent) > 0 {
+		trigger.Type = TimelineSubflow
+		trigger.Title = fmt.Sprintf("Subflow %s was started", execution.Workflow.Name)
+		trigger.Details = fmt.Sprintf("parent execution: %s", execution.ExecutionParent)
+	}
+
+	events = append(events, trigger)
+
+	for _, result := range execution.Results {
+		startedAt := normalizeTimestamp(result.StartedAt)
+		if startedAt == 0 {
+			startedAt = execution.StartedAt
+		}
+
+		if result.Action.AppName == "User Input" {
+			events = append(events, CslTimelineEvent{
+				Timestamp:   startedAt,
+				Type:        TimelineApprovalRequested,
+				Title:       fmt.Sprintf("Approval %s was requested", result.Action.Label),
+				Status:      result.Status,
+				ExecutionId: execution.ExecutionId,
+				Reference:   result.Action.ID,
+			})
+
+			userInput := shuffle.UserInputResponse{}
+			if json.Unmarshal([]byte(result.Result), &userInput) != nil || !userInput.ClickInfo.Clicked {
+				continue
+			}
+
+			answer := "approved"
+			if result.Status != "SUCCESS" {
+				answer = "denied"
+			}
+
+			events = append(events, CslTimelineEvent{
+				Timestamp:   normalizeTimestamp(userInput.ClickInfo.Time),
+				Type:        TimelineApprovalAnswered,
+				Title:       fmt.Sprintf("Approval %s was %s", result.Action.Label, answer),
+				Status:      answer,
+				Actor:       userInput.ClickInfo.User,
+				ExecutionId: execution.ExecutionId,
+				Reference:   result.Action.ID,
+				Details:     userInput.ClickInfo.Note,
+			})
+
+			continue
+		}
+
+		// Skipped actions didn't run, and subflow actions are shown by the
+		// trigger of the subflow they started
+		if result.Status == "SKIPPED" || len(getResultSubflowIds(result)) > 0 {
+			continue
+		}
+
+		event := CslTimelineEvent{
+			Timestamp:   startedAt,
+			Type:        TimelineAction,
+			Title:       fmt.Sprintf("%s ran %s", result.Action.Label, result.Action.Name),
+			Status:      result.Status,
+			ExecutionId: execution.ExecutionId,
+			Reference:   result.Action.ID,
+		}
+
+		if len(result.Action.AppName) > 0 {
+			event.Details = fmt.Sprintf("app: %s", result.Action.AppName)
+		}
+
+		if completedAt := normalizeTimestamp(result.CompletedAt); completedAt >= startedAt && result.CompletedAt > 0 {
+			event.Details = strings.TrimPrefix(fmt.Sprintf("%s, duration: %ds", event.Details, completedAt-startedAt), ", ")
+		}
+
+		events = append(events, event)
+	}
+
+	if execution.CompletedAt > 0 {
+		events = append(events, CslTimelineEvent{
+			Timestamp:   execution.CompletedAt,
+			Type:        TimelineExecutionFinished,
+			Title:       fmt.Sprintf("%s ended with status %s", execution.Workflow.Name, execution.Status),
+			Status:      execution.Status,
+			ExecutionId: execution.ExecutionId,
+		})
+	}
+
+	return events
+}
+
+// Jira issues and ServiceNow incidents linked to executions of the chain, and
+// the comments made on the issues
+func getTicketTimelineEvents(ctx context.Context, orgId string, executionIds map[string]bool) []CslTimelineEvent {
+	events := []CslTimelineEvent{}
+	for _, issue := range getCslJiraIssues(ctx, orgId).Issues {
+		if issue.Source != TicketSourceExecution || !executionIds[issue.SourceKey] {
+			continue
+		}
+
+		events = append(events, CslTimelineEvent{
+			Timestamp:   issue.Created,
+			Type:        TimelineTicket,
+			Title:       fmt.Sprintf("Jira issue %s was created", issue.Key),
+			Status:      issue.Status,
+			ExecutionId: issue.SourceKey,
+			Reference:   issue.Key,
+			Details:     issue.Url,
+		})
+
+		for _, comment := range issue.Comments {
+			events = append(events, CslTimelineEvent{
+				Timestamp:   comment.Created,
+				Type:        TimelineComment,
+				Title:       fmt.Sprintf("%s commented on %s", comment.Author, issue.Key),
+				Actor:       comment.Author,
+				ExecutionId: issue.SourceKey,
+				Reference:   issue.Key,
+				Details:     comment.Body,
+			})
+		}
+	}
+
+	for _, incident := range getCslServiceNowIncidents(ctx, orgId).Incidents {
+		if incident.Source != TicketSourceExecution || !executionIds[incident.SourceKey] {
+			continue
+		}
+
+		events = append(events, CslTimelineEvent{
+			Timestamp:   incident.Created,
+			Type:        TimelineTicket,
+			Title:       fmt.Sprintf("ServiceNow incident %s was created", incident.Number),
+			Status:      incident.State,
+			ExecutionId: incident.SourceKey,
+			Reference:   incident.Number,
+			Details:     incident.Url,
+		})
+	}
+
+	return events
+}
+
+// Execution linked to a Jira issue key or ServiceNow incident number
+func getTicketExecutionId(ctx context.Context, orgId, ticket string) string {
+	for _, issue := range getCslJiraIssues(ctx, orgId).Issues {
+		if issue.Key == ticket && issue.Source == TicketSourceExecution {
+			return issue.SourceKey
+		}
+	}
+
+	for _, incident := range getCslServiceNowIncidents(ctx, orgId).Incidents {
+		if incident.Number == ticket && incident.Source == TicketSourceExecution {
+			return incident.SourceKey
+		}
+	}
+
+	return ""
+}
+
+/*
+Dashboard:
+Returns a chronological timeline of an execution chain for post-incident
+review. Requires ?execution_id=<id>, or ?ticket=<jira issue key|servicenow
+incident number> for an escalated execution. The chain is the root execution
+and every subflow below it, and includes triggers, app actions, approvals,
+linked tickets, the comments on them and stored artifacts.
+
+	{
+	    "success": true,
+	    "data": {
+	        "root_execution_id": "...",
+	        "executions": [
+	            {
+	                "execution_id": "...",
+	                "workflow_id": "...",
+	                "workflow_name": "Phishing triage",
+	                "status": "FINISHED",
+	                "started_at": 1700000000,
+	                "completed_at": 1700000300,
+	                "execution_source": "webhook"
+	            }
+	        ],
+	        "events": [
+	            {
+	                "timestamp": 1700000120,
+	                "type": "approval_answered",
+	                "title": "Approval Block sender was approved",
+	                "status": "approved",
+	                "actor": "analyst@example.com",
+	                "execution_id": "...",
+	                "reference": "..."
+	            }
+	        ]
+	    }
+	}
+*/
+func cslExecutionTimeline(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	executionId := query.Get("execution_id")
+	if len(query.Get("ticket")) > 0 {
+		executionId = getTicketExecutionId(ctx, user.ActiveOrg.Id, query.Get("ticket"))
+	}
+
+	execution, err := shuffle.GetWorkflowExecution(ctx, executionId)
+	if len(executionId) == 0 || err != nil || execution.ExecutionOrg != user.ActiveOrg.Id {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("execution_id or ticket is not valid")))
+		return
+	}
+
+	chain := getExecutionChain(ctx, user.ActiveOrg.Id, execution)
+	timeline := CslTimeline{
+		RootExecutionId: chain[0].ExecutionId,
+		Executions:      []CslTimelineExecution{},
+		Events:          []CslTimelineEvent{},
+	}
+
+	executionIds := map[string]bool{}
+	for _, chainExecution := range chain {
+		executionIds[chainExecution.ExecutionId] = true
+		timeline.Executions = append(timeline.Executions, CslTimelineExecution{
+			ExecutionId:     chainExecution.ExecutionId,
+			ParentId:        chainExecution.ExecutionParent,
+			WorkflowId:      chainExecution.Workflow.ID,
+			WorkflowName:    chainExecution.Workflow.Name,
+			Status:          chainExecution.Status,
+			StartedAt:       chainExecution.StartedAt,
+			CompletedAt:     chainExecution.CompletedAt,
+			ExecutionSource: chainExecution.ExecutionSource,
+		})
+	}
+
+	for _, chainExecution := range chain {
+		timeline.Events = append(timeline.Events, getExecutionTimelineEvents(chainExecution)...)
+	}
+
+	timeline.Events = append(timeline.Events, getTicketTimelineEvents(ctx, user.ActiveOrg.Id, executionIds)...)
+	timeline.Events = append(timeline.Events, getApprovalTimelineEvents(ctx, user.ActiveOrg.Id, executionIds)...)
+	for _, artifact := range getExecutionArtifacts(ctx, user.ActiveOrg.Id, executionIds) {
+		event := CslTimelineEvent{
+			Timestamp: artifact.Created,
+			Type:      TimelineArtifact,
+			Title:     fmt.Sprintf("Artifact %s was stored", artifact.Filename),
+			Status:    artifact.Type,
+			Actor:     artifact.CreatedBy,
+			Reference: artifact.Id,
+			Details:   artifact.Sha256,
+		}
+
+		for _, executionId := range artifact.ExecutionIds {
+			if executionIds[executionId] {
+				event.ExecutionId = executionId
+				break
+			}
+		}
+
+		timeline.Events = append(timeline.Events, event)
+	}
+	sort.SliceStable(timeline.Events, func(i, j int) bool {
+		return timeline.Events[i].Timestamp < timeline.Events[j].Timestamp
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data:    timeline,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslExecutionTimeline")
+}
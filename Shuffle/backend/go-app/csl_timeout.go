@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Request deadlines. Every API request gets a deadline of
+// SHUFFLE_REQUEST_TIMEOUT seconds (default 60, 0 disables) on its context.
+// Handlers that get their context with shuffle.GetRequestContext have
+// database calls give up at the deadline instead of piling up.
+// shuffle.GetContext has no deadline, as it's also used for work that
+// continues after the response. If the handler returns after the deadline
+// without having written a response, the client gets a 504.
+//
+// Responses aren't buffered, so streamed responses are sent as they are
+// written. Streams, long transfers, app builds and app executions are exempt.
+// More path prefixes can be exempted with
+// SHUFFLE_REQUEST_TIMEOUT_EXEMPT=<prefix>,<prefix>.
+
+const DefaultRequestTimeoutSeconds = 60
+
+var requestTimeoutExemptSuffixes = []string{
+	"/stream",
+	"/content",
+	"/upload",
+}
+
+var requestTimeoutExemptPrefixes = []string{
+	"/api/v1/streams",
+	"/api/v1/get_docker_image",
+	"/api/v1/apps/download_remote",
+	"/api/v1/workflows/download_remote",
+	"/api/v1/files/download_remote",
+	"/api/v1/csl/orgExport/download",
+	"/api/v1/csl/orgImport",
+	"/api/v1/csl/debug/pprof",
+	"/api/v1/verify_openapi",
+	"/api/v1/verify_swagger",
+	"/api/v1/apps/run_hotload",
+}
+
+// Route templates, for routes with variables in the middle of the path
+var requestTimeoutExemptRoutes = []string{
+	"/api/v1/apps/{key}/execute",
+}
+
+func getRequestTimeout() time.Duration {
+	timeout := DefaultRequestTimeoutSeconds
+	if value := os.Getenv("SHUFFLE_REQUEST_TIMEOUT"); len(value) > 0 {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			log.Printf("[WARNING] Invalid SHUFFLE_REQUEST_TIMEOUT %s. Using %d seconds", value, DefaultRequestTimeoutSeconds)
+		} else {
+			timeout = parsed
+		}
+	}
+
+	return time.Duration(timeout) * time.Second
+}
+
+func isRequestTimeoutExempt(request *http.Request, exemptPrefixes []string) bool {
+	path := request.URL.Path
+	for _, suffix := range requestTimeoutExemptSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+
+	for _, prefix := range exemptPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	if route := mux.CurrentRoute(request); route != nil {
+		template, err := route.GetPathTemplate()
+		if err == nil && shuffle.ArrayContains(requestTimeoutExemptRoutes, template) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Tracks whether the handler started the response, so a 504 is only sent
+// when it didn't
+type cslTimeoutWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (writer *cslTimeoutWriter) WriteHeader(status int) {
+	writer.wroteHeader = true
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *cslTimeoutWriter) Write(data []byte) (int, error) {
+	writer.wroteHeader = true
+	return writer.ResponseWriter.Write(data)
+}
+
+func (writer *cslTimeoutWriter) Flush() {
+	if flusher, ok := writer.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Middleware giving every request a deadline, and returning 504 when the
+// handler gave up at the deadline without responding
+func cslTimeoutMiddleware(next http.Handler) http.Handler {
+	timeout := getRequestTimeout()
+	exemptPrefixes := append([]string{}, requestTimeoutExemptPrefixes...)
+	for _, prefix := range strings.Split(os.Getenv("SHUFFLE_REQUEST_TIMEOUT_EXEMPT"), ",") {
+		if len(strings.TrimSpace(prefix)) > 0 {
+			exemptPrefixes = append(exemptPrefixes, strings.TrimSpace(prefix))
+		}
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, request *http.Request) {
+		if timeout == 0 || !strings.HasPrefix(request.URL.Path, "/api/") || len(request.Header.Get("Upgrade")) > 0 || isRequestTimeoutExempt(request, exemptPrefixes) {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(request.Context(), timeout)
+		defer cancel()
+
+		writer := &cslTimeoutWriter{ResponseWriter: resp}
+		next.ServeHTTP(writer, request.WithContext(ctx))
+
+		if writer.wroteHeader || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return
+		}
+
+		log.Printf("[WARNING] %s %s timed out after %s", request.Method, request.URL.Path, timeout)
+		resp.Header().Set("Content-Type", "application/json")
+		resp.WriteHeader(http.StatusGatewayTimeout)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("the request timed out after %d seconds", int(timeout.Seconds())))))
+	})
+}
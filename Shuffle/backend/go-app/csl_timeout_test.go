@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCslTimeoutMiddleware(t *testing.T) {
+	t.Setenv("SHUFFLE_REQUEST_TIMEOUT", "1")
+
+	var recorder *httptest.ResponseRecorder
+	flushed := false
+	router := mux.NewRouter()
+	router.HandleFunc("/api/v1/slow", func(resp http.ResponseWriter, request *http.Request) {
+		<-request.Context().Done()
+	})
+	router.HandleFunc("/api/v1/slow_error", func(resp http.ResponseWriter, request *http.Request) {
+		<-request.Context().Done()
+		resp.WriteHeader(500)
+	})
+	router.HandleFunc("/api/v1/items", func(resp http.ResponseWriter, request *http.Request) {
+		resp.Write([]byte("["))
+		resp.(http.Flusher).Flush()
+		flushed = recorder.Flushed && recorder.Body.String() == "["
+		resp.Write([]byte("]"))
+	})
+	router.HandleFunc("/api/v1/apps/{key}/execute", func(resp http.ResponseWriter, request *http.Request) {
+		if _, ok := request.Context().Deadline(); ok {
+			resp.WriteHeader(500)
+		}
+	})
+	router.Use(cslTimeoutMiddleware)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{path: "/api/v1/slow", status: http.StatusGatewayTimeout},
+		{path: "/api/v1/slow_error", status: 500},
+		{path: "/api/v1/items", status: 200},
+		{path: "/api/v1/apps/app/execute", status: 200},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			started := time.Now()
+			recorder = httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest("GET", test.path, nil))
+
+			if recorder.Code != test.status {
+				t.Errorf("got status %d, expected %d", recorder.Code, test.status)
+			}
+
+			if time.Since(started) > 3*time.Second {
+				t.Errorf("request took %s", time.Since(started))
+			}
+		})
+	}
+
+	if !flushed {
+		t.Errorf("streamed response was not flushed before the handler returned")
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Max duration of executions per workflow. The execution_timeout job aborts
+// executions running longer, with the failed action in the timeout failure
+// category. Orborus still stops workers after SHUFFLE_ORBORUS_EXECUTION_TIMEOUT,
+// so longer timeouts need it raised as well.
+
+const CslExecutionTimeoutsDocument = "execution_timeouts"
+
+const ExecutionTimeoutCheckMinutes = 1
+const MaxExecutionTimeoutSeconds = 7 * 24 * 60 * 60
+
+// Counted in the org statistics for every aborted execution
+const TimeoutStatPrefix = "workflow_executions_"
+const TimeoutStatName = "timeout"
+
+// 0 is no timeout. Workflows not in Workflows use the default
+type CslExecutionTimeouts struct {
+	DefaultSeconds int            `json:"default_seconds"`
+	Workflows      map[string]int `json:"workflows"`
+}
+
+func getCslExecutionTimeouts(ctx context.Context, orgId string) CslExecutionTimeouts {
+	timeouts := CslExecutionTimeouts{}
+	_, err := getCslDocument(ctx, orgId, CslExecutionTimeoutsDocument, &timeouts)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting execution timeouts for org %s: %s", orgId, err)
+	}
+
+	if timeouts.Workflows == nil {
+		timeouts.Workflows = map[string]int{}
+	}
+
+	return timeouts
+}
+
+func (timeouts CslExecutionTimeouts) getTimeout(workflowId string) int {
+	if seconds, ok := timeouts.Workflows[workflowId]; ok {
+		return seconds
+	}
+
+	return timeouts.DefaultSeconds
+}
+
+func (timeouts CslExecutionTimeouts) hasTimeouts() bool {
+	if timeouts.DefaultSeconds > 0 {
+		return true
+	}
+
+	for _, seconds := range timeouts.Workflows {
+		if seconds > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The first action without a result, which the timeout failure is set on
+func getTimedOutNode(execution shuffle.WorkflowExecution) string {
+	for _, action := range execution.Workflow.Actions {
+		found := false
+		for _, result := range execution.Results {
+			if result.Action.ID == action.ID {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return action.ID
+		}
+	}
+
+	return ""
+}
+
+func abortTimedOutExecution(ctx context.Context, execution shuffle.WorkflowExecution, timeout int) error {
+	query := url.Values{
+		"reason": {fmt.Sprintf("Execution timed out after running longer than the workflow timeout of %d seconds", timeout)},
+	}
+
+	node := getTimedOutNode(execution)
+	if len(node) > 0 {
+		query.Set("node", node)
+	}
+
+	err := abortCslExecution(ctx, nil, execution, query)
+	if err != nil {
+		return err
+	}
+
+	shuffle.IncrementCache(ctx, execution.ExecutionOrg, fmt.Sprintf("%s%s", TimeoutStatPrefix, TimeoutStatName))
+	return nil
+}
+
+// Job: aborts executions running longer than the timeout of their workflow
+func runCslExecutionTimeoutJob(ctx context.Context) {
+	orgs, err := shuffle.GetAllOrgs(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting orgs for execution timeout job: %s", err)
+		return
+	}
+
+	for _, org := range orgs {
+		timeouts := getCslExecutionTimeouts(ctx, org.Id)
+		if !timeouts.hasTimeouts() {
+			continue
+		}
+
+		orgWorkflows, err := getOrgWorkflows(ctx, org.Id)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting workflows for org %s in execution timeout job: %s", org.Id, err)
+			continue
+		}
+
+		workflows := []shuffle.Workflow{}
+		for _, workflow := range orgWorkflows {
+			if timeouts.getTimeout(workflow.ID) > 0 {
+				workflows = append(workflows, workflow)
+			}
+		}
+
+		workflowExecutions := make([][]shuffle.WorkflowExecution, len(workflows))
+		runConcurrentLookups(ctx, len(workflows), func(ctx context.Context, index int) error {
+			executions, err := shuffle.GetUnfinishedExecutions(ctx, workflows[index].ID)
+			if err != nil {
+				log.Printf("[WARNING] Failed getting unfinished executions of workflow %s in execution timeout job: %s", workflows[index].ID, err)
+				return nil
+			}
+
+			workflowExecutions[index] = executions
+			return nil
+		})
+
+		timeNow := time.Now().Unix()
+		for i, workflow := range workflows {
+			timeout := timeouts.getTimeout(workflow.ID)
+			for _, execution := range workflowExecutions[i] {
+				if execution.Status != "EXECUTING" || execution.StartedAt == 0 || timeNow-execution.StartedAt <= int64(timeout) {
+					continue
+				}
+
+				err = abortTimedOutExecution(ctx, execution, timeout)
+				if err != nil {
+					log.Printf("[WARNING] Failed aborting execution %s of workflow %s after timeout: %s", execution.ExecutionId, workflow.ID, err)
+					continue
+				}
+
+				log.Printf("[INFO] Aborted execution %s of workflow %s in org %s after the timeout of %d seconds", execution.ExecutionId, workflow.ID, org.Id, timeout)
+				recordCslActivity(ctx, org.Id, ActivityTypeExecution, "timed_out", fmt.Sprintf("An execution of %s timed out after %d seconds", workflow.Name, timeout), "", execution.ExecutionId)
+			}
+		}
+	}
+}
+
+func validateExecutionTimeout(name string, seconds int) error {
+	if seconds < 0 || seconds > MaxExecutionTimeoutSeconds {
+		return errors.New(fmt.Sprintf("timeout of %s must be between 0 and %d seconds", name, MaxExecutionTimeoutSeconds))
+	}
+
+	return nil
+}
+
+/*
+Execution timeouts:
+Returns the max execution duration of workflows in the current organization,
+in seconds. Workflows without their own timeout use the default, and 0 is no
+timeout.
+
+	{
+	    "success": true,
+	    "data": {
+	        "default_seconds": 3600,
+	        "workflows": {
+	            "5ec3...": 300,
+	            "9ba1...": 0
+	        }
+	    }
+	}
+*/
+func cslGetExecutionTimeouts(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslExecutionTimeouts(ctx, user.ActiveOrg.Id),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetExecutionTimeouts")
+}
+
+/*
+Execution timeouts:
+Sets the execution timeouts of the current organization in the format
+returned from GET. Requires org admin. Timeouts can be at most a week, and
+running executions are checked every minute.
+*/
+func cslSetExecutionTimeouts(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	newTimeouts := CslExecutionTimeouts{}
+	err = json.Unmarshal(body, &newTimeouts)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	err = validateExecutionTimeout("default", newTimeouts.DefaultSeconds)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	for workflowId, seconds := range newTimeouts.Workflows {
+		err = validateExecutionTimeout(workflowId, seconds)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		workflow, err := shuffle.GetWorkflow(ctx, workflowId)
+		if err != nil || workflow.OrgId != user.ActiveOrg.Id {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("workflow %s not found", workflowId))))
+			return
+		}
+	}
+
+	if newTimeouts.Workflows == nil {
+		newTimeouts.Workflows = map[string]int{}
+	}
+
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslExecutionTimeoutsDocument, newTimeouts)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) changed execution timeouts of org %s. Default: %d seconds, workflow timeouts: %d", user.Username, user.Id, user.ActiveOrg.Id, newTimeouts.DefaultSeconds, len(newTimeouts.Workflows))
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "execution_timeouts_changed", "Execution timeouts were changed", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    newTimeouts,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetExecutionTimeouts")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Builds the TLS config for the backend from env. Returns nil if
+// SHUFFLE_TLS_CERT_FILE isn't set, in which case plain HTTP is served.
+// With SHUFFLE_MTLS_CA_FILE set, clients must present a certificate signed
+// by that CA unless SHUFFLE_MTLS_OPTIONAL is true
+func getCslTlsConfig() (*tls.Config, error) {
+	certFile := os.Getenv("SHUFFLE_TLS_CERT_FILE")
+	keyFile := os.Getenv("SHUFFLE_TLS_KEY_FILE")
+	caFile := os.Getenv("SHUFFLE_MTLS_CA_FILE")
+	if len(certFile) == 0 {
+		if len(caFile) > 0 {
+			return nil, errors.New("SHUFFLE_MTLS_CA_FILE requires SHUFFLE_TLS_CERT_FILE and SHUFFLE_TLS_KEY_FILE")
+		}
+
+		return nil, nil
+	}
+
+	if len(keyFile) == 0 {
+		return nil, errors.New("SHUFFLE_TLS_KEY_FILE is required with SHUFFLE_TLS_CERT_FILE")
+	}
+
+	reloader, err := shuffle.NewCertReloader(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if len(caFile) == 0 {
+		return tlsConfig, nil
+	}
+
+	clientAuth := tls.RequireAndVerifyClientCert
+	if strings.ToLower(os.Getenv("SHUFFLE_MTLS_OPTIONAL")) == "true" {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	// The CA pool is set per connection so a rotated CA is picked up too
+	tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		return &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+			ClientAuth:     clientAuth,
+			ClientCAs:      reloader.GetCaPool(),
+		}, nil
+	}
+
+	return tlsConfig, nil
+}
+
+// Serves the backend on addr, using TLS and mTLS if configured
+func runCslServer(addr string) error {
+	tlsConfig, err := getCslTlsConfig()
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:      addr,
+		TLSConfig: tlsConfig,
+	}
+
+	if tlsConfig == nil {
+		return serveCslUntilShutdown(server, server.ListenAndServe)
+	}
+
+	if tlsConfig.GetConfigForClient != nil {
+		log.Printf("[INFO] Serving with mutual TLS")
+	} else {
+		log.Printf("[INFO] Serving with TLS")
+	}
+
+	// Certificates come from TLSConfig.GetCertificate
+	return serveCslUntilShutdown(server, func() error {
+		return server.ListenAndServeTLS("", "")
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Trend directions
+const (
+	TrendUp   = "up"
+	TrendDown = "down"
+	TrendFlat = "flat"
+)
+
+type CslPeriodDelta struct {
+	Current       int64   `json:"current"`
+	Previous      int64   `json:"previous"`
+	Change        int64   `json:"change"`
+	ChangePercent float64 `json:"change_percent"`
+	Trend         string  `json:"trend"`
+}
+
+type CslPeriodComparison struct {
+	Days       int            `json:"days"`
+	Executions CslPeriodDelta `json:"executions"`
+	Failures   CslPeriodDelta `json:"failures"`
+	ApiUsage   CslPeriodDelta `json:"api_usage"`
+}
+
+type CslTrendsResponse struct {
+	Week  CslPeriodComparison `json:"week"`
+	Month CslPeriodComparison `json:"month"`
+}
+
+func getPeriodDelta(current, previous int64) CslPeriodDelta {
+	delta := CslPeriodDelta{
+		Current:  current,
+		Previous: previous,
+		Change:   current - previous,
+		Trend:    TrendFlat,
+	}
+
+	if delta.Change > 0 {
+		delta.Trend = TrendUp
+	} else if delta.Change < 0 {
+		delta.Trend = TrendDown
+	}
+
+	if previous > 0 {
+		delta.ChangePercent = roundScore(float64(delta.Change) / float64(previous) * 100)
+	}
+
+	return delta
+}
+
+// Failures use the same definition as cslWorkflowChart
+func getPeriodComparison(orgStats *shuffle.ExecutionInfo, days int) CslPeriodComparison {
+	current := sumRecentDailyStatistics(orgStats, days)
+	previous := sumPreviousDailyStatistics(orgStats, days)
+
+	return CslPeriodComparison{
+		Days:       days,
+		Executions: getPeriodDelta(current.WorkflowExecutions, previous.WorkflowExecutions),
+		Failures:   getPeriodDelta(current.WorkflowExecutions-current.WorkflowExecutionsFinished, previous.WorkflowExecutions-previous.WorkflowExecutionsFinished),
+		ApiUsage:   getPeriodDelta(current.ApiUsage, previous.ApiUsage),
+	}
+}
+
+/*
+Dashboard:
+Compares workflow executions, failed executions and api usage of the last 7
+and 30 days, including today, with the 7 and 30 days before them. Change
+percent is 0 when the previous period had nothing to compare with.
+
+	{
+	    "success": true,
+	    "data": {
+	        "week": {
+	            "days": 7,
+	            "executions": {
+	                "current": 840,
+	                "previous": 700,
+	                "change": 140,
+	                "change_percent": 20,
+	                "trend": "up"
+	            },
+	            "failures": {
+	            ...
+	            },
+	            "api_usage": {
+	            ...
+	            }
+	        },
+	        "month": {
+	        ...
+	        }
+	    }
+	}
+*/
+func cslTrends(resp http.ResponseWriter, request *http.Request) {
+	orgStats := handleOrgStatsRequest(resp, request)
+	if orgStats == nil {
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data: CslTrendsResponse{
+			Week:  getPeriodComparison(orgStats, WeekLength),
+			Month: getPeriodComparison(orgStats, MonthLength),
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslTrends")
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Trigger sources executions are counted by
+const (
+	TriggerWebhook  = "webhook"
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+	TriggerSubflow  = "subflow"
+	TriggerOther    = "other"
+)
+
+var executionTriggers = []string{TriggerWebhook, TriggerSchedule, TriggerManual, TriggerSubflow, TriggerOther}
+
+// Counts are stored as additions in the org statistics with this prefix
+const TriggerStatPrefix = "workflow_executions_trigger_"
+
+type CslTriggerCount struct {
+	Trigger    string  `json:"trigger"`
+	Count      int64   `json:"count"`
+	Today      int64   `json:"today"`
+	Total      int64   `json:"total"`
+	Percentage float64 `json:"percentage"`
+}
+
+// Webhook requests rejected before starting an execution
+type CslWebhookRejectionCount struct {
+	Reason string `json:"reason"`
+	Count  int64  `json:"count"`
+	Today  int64  `json:"today"`
+	Total  int64  `json:"total"`
+}
+
+type CslTriggersResponse struct {
+	Days      int                        `json:"days"`
+	Automated int64                      `json:"automated"`
+	Manual    int64                      `json:"manual"`
+	Triggers  []CslTriggerCount          `json:"triggers"`
+	Rejected  []CslWebhookRejectionCount `json:"rejected_webhooks"`
+}
+
+// Executions started from the UI or the API have the default source.
+// Subflows have their parent execution, or the parent workflow as source
+func getExecutionTrigger(execution shuffle.WorkflowExecution) string {
+	source := strings.ToLower(execution.ExecutionSource)
+	if source == TriggerWebhook || source == TriggerSchedule {
+		return source
+	}
+
+	if len(execution.ExecutionParent) > 0 || len(source) == 36 {
+		return TriggerSubflow
+	}
+
+	if source == "default" || len(source) == 0 {
+		return TriggerManual
+	}
+
+	return TriggerOther
+}
+
+func getTriggerStatKey(trigger string) string {
+	return fmt.Sprintf("%s%s", TriggerStatPrefix, trigger)
+}
+
+// Sums the trigger counts of today and the previous days-1 days
+func getTriggerCounts(orgStats *shuffle.ExecutionInfo, days int) CslTriggersResponse {
+	sums := sumRecentAdditions(orgStats, TriggerStatPrefix, executionTriggers, days)
+	counts := map[string]*CslTriggerCount{}
+	for _, trigger := range executionTriggers {
+		counts[trigger] = &CslTriggerCount{
+			Trigger: trigger,
+			Count:   sums[trigger].Count,
+			Today:   sums[trigger].Today,
+			Total:   sums[trigger].Total,
+		}
+	}
+
+	response := CslTriggersResponse{
+		Days:     days,
+		Triggers: []CslTriggerCount{},
+		Rejected: []CslWebhookRejectionCount{},
+	}
+
+	sum := int64(0)
+	for _, trigger := range executionTriggers {
+		sum += counts[trigger].Count
+		if trigger == TriggerManual {
+			response.Manual += counts[trigger].Count
+		} else if trigger != TriggerOther {
+			response.Automated += counts[trigger].Count
+		}
+	}
+
+	for _, trigger := range executionTriggers {
+		count := *counts[trigger]
+		if sum > 0 {
+			count.Percentage = roundScore(float64(count.Count) / float64(sum) * 100)
+		}
+
+		response.Triggers = append(response.Triggers, count)
+	}
+
+	sort.SliceStable(response.Triggers, func(i, j int) bool {
+		return response.Triggers[i].Count > response.Triggers[j].Count
+	})
+
+	rejections := sumRecentAdditions(orgStats, WebhookRejectedStatPrefix, webhookRejectReasons, days)
+	for _, reason := range webhookRejectReasons {
+		response.Rejected = append(response.Rejected, CslWebhookRejectionCount{
+			Reason: reason,
+			Count:  rejections[reason].Count,
+			Today:  rejections[reason].Today,
+			Total:  rejections[reason].Total,
+		})
+	}
+
+	return response
+}
+
+/*
+Dashboard:
+Returns workflow executions of the current organization by what triggered
+them for today and the previous ?days=N-1 days (default 7, max 30). Webhook,
+schedule and subflow executions count as automated, manual executions were
+started from the UI or the API. Total is all executions since tracking started.
+rejected_webhooks counts webhook requests rejected by reason, which is one of
+signature, replay or payload_size.
+
+	{
+	    "success": true,
+	    "data": {
+	        "days": 7,
+	        "automated": 900,
+	        "manual": 40,
+	        "triggers": [
+	            {
+	                "trigger": "webhook",
+	                "count": 700,
+	                "today": 95,
+	                "total": 5400,
+	                "percentage": 74.47
+	            },
+	            ...
+	        ],
+	        "rejected_webhooks": [
+	            {
+	                "reason": "signature",
+	                "count": 12,
+	                "today": 3,
+	                "total": 40
+	            }
+	        ]
+	    }
+	}
+*/
+func cslTriggerStats(resp http.ResponseWriter, request *http.Request) {
+	orgStats := handleOrgStatsRequest(resp, request)
+	if orgStats == nil {
+		return
+	}
+
+	days := WeekLength
+	if value := request.URL.Query().Get("days"); len(value) > 0 {
+		parsedDays, err := strconv.Atoi(value)
+		if err != nil || parsedDays < 1 || parsedDays > MonthLength {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("days must be between 1 and %d", MonthLength))))
+			return
+		}
+
+		days = parsedDays
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    getTriggerCounts(orgStats, days),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslTriggerStats")
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslApiUsageDocument = "api_usage"
+
+const UsageDateFormat = "2006-01-02"
+
+// Personal api keys are tracked per user, named keys per key
+const (
+	UsageKeyUser  = "user"
+	UsageKeyNamed = "key"
+)
+
+type cslContextKey string
+
+// Set by cslApiKeyMiddleware when a request used a named api key
+const cslApiKeyIdContextKey cslContextKey = "csl_api_key_id"
+
+// Api calls of one day by endpoint and by api key
+type CslApiUsageDay struct {
+	Date      string           `json:"date"`
+	Total     int64            `json:"total"`
+	Endpoints map[string]int64 `json:"endpoints"`
+	ApiKeys   map[string]int64 `json:"api_keys"`
+}
+
+type CslApiUsageHistory struct {
+	Days []CslApiUsageDay `json:"days"`
+}
+
+type CslEndpointUsage struct {
+	Endpoint string `json:"endpoint"`
+	Count    int64  `json:"count"`
+}
+
+type CslApiKeyUsage struct {
+	KeyId    string `json:"key_id"`
+	Name     string `json:"name"`
+	UserId   string `json:"user_id"`
+	Username string `json:"username"`
+	Count    int64  `json:"count"`
+}
+
+type CslDailyUsage struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
+
+type CslApiUsageBreakdown struct {
+	Days      int                `json:"days"`
+	Total     int64              `json:"total"`
+	Endpoints []CslEndpointUsage `json:"endpoints"`
+	ApiKeys   []CslApiKeyUsage   `json:"api_keys"`
+	Daily     []CslDailyUsage    `json:"daily"`
+}
+
+// Usage is counted in memory and flushed to the org documents by the
+// api_usage_flush job, so requests don't each need a write
+var cslPendingUsage = struct {
+	sync.Mutex
+	orgs map[string]map[string]*CslApiUsageDay
+}{
+	orgs: map[string]map[string]*CslApiUsageDay{},
+}
+
+func newApiUsageDay(date string) *CslApiUsageDay {
+	return &CslApiUsageDay{
+		Date:      date,
+		Endpoints: map[string]int64{},
+		ApiKeys:   map[string]int64{},
+	}
+}
+
+// Endpoints are grouped by route template, so /api/v1/workflows/{key}
+// is one endpoint regardless of the workflow
+func getUsageEndpoint(request *http.Request) string {
+	path := request.URL.Path
+	route := mux.CurrentRoute(request)
+	if route != nil {
+		template, err := route.GetPathTemplate()
+		if err == nil {
+			path = template
+		}
+	}
+
+	return fmt.Sprintf("%s %s", request.Method, path)
+}
+
+func recordApiUsage(orgId, date, endpoint, usageKey string) {
+	cslPendingUsage.Lock()
+	defer cslPendingUsage.Unlock()
+
+	orgUsage, ok := cslPendingUsage.orgs[orgId]
+	if !ok {
+		orgUsage = map[string]*CslApiUsageDay{}
+		cslPendingUsage.orgs[orgId] = orgUsage
+	}
+
+	day, ok := orgUsage[date]
+	if !ok {
+		day = newApiUsageDay(date)
+		orgUsage[date] = day
+	}
+
+	day.Total += 1
+	day.Endpoints[endpoint] += 1
+	day.ApiKeys[usageKey] += 1
+}
+
+// Middleware attributing api key requests to endpoint and key. Only requests
+// with an api key are counted, the same as the api usage in org statistics
+func cslApiUsageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, request *http.Request) {
+		if !strings.HasPrefix(request.Header.Get("Authorization"), "Bearer ") || request.Method == "OPTIONS" {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		user, err := getMiddlewareUser(resp, request)
+		if err == nil && len(user.ActiveOrg.Id) > 0 {
+			usageKey := fmt.Sprintf("%s:%s", UsageKeyUser, user.Id)
+			if keyId, ok := request.Context().Value(cslApiKeyIdContextKey).(string); ok && len(keyId) > 0 {
+				usageKey = fmt.Sprintf("%s:%s", UsageKeyNamed, keyId)
+			}
+
+			// Dates follow the org timezone, the same as the daily statistics
+			location := getTimezoneLocation(getCslOrgSettings(shuffle.GetContext(request), user.ActiveOrg.Id).Timezone)
+			date := time.Now().In(location).Format(UsageDateFormat)
+
+			recordApiUsage(user.ActiveOrg.Id, date, getUsageEndpoint(request), usageKey)
+		}
+
+		next.ServeHTTP(resp, request)
+	})
+}
+
+func mergeApiUsageDay(history *CslApiUsageHistory, pending *CslApiUsageDay) {
+	for i := range history.Days {
+		day := &history.Days[i]
+		if day.Date != pending.Date {
+			continue
+		}
+
+		day.Total += pending.Total
+		for endpoint, count := range pending.Endpoints {
+			day.Endpoints[endpoint] += count
+		}
+
+		for usageKey, count := range pending.ApiKeys {
+			day.ApiKeys[usageKey] += count
+		}
+
+		return
+	}
+
+	history.Days = append(history.Days, *pending)
+}
+
+// Writes the usage counted since the last run to each org and drops days
+// older than a month
+func runCslApiUsageFlushJob(ctx context.Context) {
+	cslPendingUsage.Lock()
+	pending := cslPendingUsage.orgs
+	cslPendingUsage.orgs = map[string]map[string]*CslApiUsageDay{}
+	cslPendingUsage.Unlock()
+
+	oldest := time.Now().UTC().AddDate(0, 0, -MonthLength).Format(UsageDateFormat)
+	for orgId, orgUsage := range pending {
+		history := CslApiUsageHistory{}
+		err := updateCslDocument(ctx, orgId, CslApiUsageDocument, &history, func() error {
+			for i := range history.Days {
+				if history.Days[i].Endpoints == nil {
+					history.Days[i].Endpoints = map[string]int64{}
+				}
+
+				if history.Days[i].ApiKeys == nil {
+					history.Days[i].ApiKeys = map[string]int64{}
+				}
+			}
+
+			for _, day := range orgUsage {
+				mergeApiUsageDay(&history, day)
+			}
+
+			days := []CslApiUsageDay{}
+			for _, day := range history.Days {
+				if day.Date >= oldest {
+					days = append(days, day)
+				}
+			}
+
+			sort.Slice(days, func(i, j int) bool {
+				return days[i].Date < days[j].Date
+			})
+
+			history.Days = days
+			return nil
+		})
+		if err != nil {
+			log.Printf("[ERROR] Failed storing api usage for org %s: %s", orgId, err)
+		}
+	}
+}
+
+// Resolves usage keys to the named key or the user owning a personal key
+func getApiKeyUsageList(ctx context.Context, orgId string, counts map[string]int64) []CslApiKeyUsage {
+	apiKeys := CslApiKeys{}
+	_, err := getCslDocument(ctx, orgId, CslApiKeysDocument, &apiKeys)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting api keys for org %s: %s", orgId, err)
+	}
+
+	usage := []CslApiKeyUsage{}
+	for usageKey, count := range counts {
+		parts := strings.SplitN(usageKey, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		keyUsage := CslApiKeyUsage{
+			Count: count,
+		}
+
+		if parts[0] == UsageKeyNamed {
+			keyUsage.KeyId = parts[1]
+			keyUsage.Name = "Deleted api key"
+			index := findApiKeyIndex(apiKeys, parts[1])
+			if index >= 0 {
+				keyUsage.Name = apiKeys.Keys[index].Name
+				keyUsage.UserId = apiKeys.Keys[index].UserId
+				keyUsage.Username = apiKeys.Keys[index].Username
+			}
+		} else {
+			keyUsage.Name = "Personal api key"
+			keyUsage.UserId = parts[1]
+			user, err := shuffle.GetUser(ctx, parts[1])
+			if err == nil {
+				keyUsage.Username = user.Username
+			}
+		}
+
+		usage = append(usage, keyUsage)
+	}
+
+	sort.SliceStable(usage, func(i, j int) bool {
+		return usage[i].Count > usage[j].Count
+	})
+
+	return usage
+}
+
+func getApiUsageBreakdown(ctx context.Context, orgId string, days int) CslApiUsageBreakdown {
+	history := CslApiUsageHistory{}
+	_, err := getCslDocument(ctx, orgId, CslApiUsageDocument, &history)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting api usage for org %s: %s", orgId, err)
+	}
+
+	breakdown := CslApiUsageBreakdown{
+		Days:      days,
+		Endpoints: []CslEndpointUsage{},
+		Daily:     []CslDailyUsage{},
+	}
+
+	location := getTimezoneLocation(getCslOrgSettings(ctx, orgId).Timezone)
+	oldest := time.Now().In(location).AddDate(0, 0, -days+1).Format(UsageDateFormat)
+	endpoints := map[string]int64{}
+	apiKeys := map[string]int64{}
+	for i := len(history.Days) - 1; i >= 0; i-- {
+		day := history.Days[i]
+		if day.Date < oldest {
+			continue
+		}
+
+		breakdown.Total += day.Total
+		breakdown.Daily = append(breakdown.Daily, CslDailyUsage{Date: day.Date, Count: day.Total})
+		for endpoint, count := range day.Endpoints {
+			endpoints[endpoint] += count
+		}
+
+		for usageKey, count := range day.ApiKeys {
+			apiKeys[usageKey] += count
+		}
+	}
+
+	for endpoint, count := range endpoints {
+		breakdown.Endpoints = append(breakdown.Endpoints, CslEndpointUsage{Endpoint: endpoint, Count: count})
+	}
+
+	sort.SliceStable(breakdown.Endpoints, func(i, j int) bool {
+		if breakdown.Endpoints[i].Count == breakdown.Endpoints[j].Count {
+			return breakdown.Endpoints[i].Endpoint < breakdown.Endpoints[j].Endpoint
+		}
+
+		return breakdown.Endpoints[i].Count > breakdown.Endpoints[j].Count
+	})
+
+	breakdown.ApiKeys = getApiKeyUsageList(ctx, orgId, apiKeys)
+	return breakdown
+}
+
+/*
+Dashboard:
+Returns api calls of the current organization by endpoint and by api key for
+the last ?days=N days (default and max 30), most used first. Daily counts are
+ordered from most recent to oldest. Usage is stored once a minute, so the last
+minute may be missing.
+
+	{
+	    "success": true,
+	    "data": {
+	        "days": 30,
+	        "total": 1520,
+	        "endpoints": [
+	            {
+	                "endpoint": "POST /api/v1/workflows/{key}/execute",
+	                "count": 1200
+	            },
+	            ...
+	        ],
+	        "api_keys": [
+	            {
+	                "key_id": "...",
+	                "name": "SIEM integration",
+	                "user_id": "...",
+	                "username": "analyst@example.com",
+	                "count": 1300
+	            },
+	            ...
+	        ],
+	        "daily": [
+	            {
+	                "date": "2024-04-05",
+	                "count": 52
+	            },
+	            ...
+	        ]
+	    }
+	}
+*/
+func cslApiUsageBreakdown(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	days := MonthLength
+	if value := request.URL.Query().Get("days"); len(value) > 0 {
+		parsedDays, err := strconv.Atoi(value)
+		if err != nil || parsedDays < 1 || parsedDays > MonthLength {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("days must be between 1 and %d", MonthLength))))
+			return
+		}
+
+		days = parsedDays
+	}
+
+	if useCslMockData(resp, request) {
+		res := CslResponse{
+			Success: true,
+			Data:    getMockApiUsageBreakdown(getCslMockData(ctx, user.ActiveOrg.Id), days),
+		}
+
+		marshalAndWriteResponse(resp, res, "cslApiUsageBreakdown")
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    getApiUsageBreakdown(ctx, user.ActiveOrg.Id, days),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslApiUsageBreakdown")
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Revision id used to reference the currently saved workflow
+const CurrentWorkflowVersion = "current"
+
+type CslWorkflowVersion struct {
+	RevisionId  string `json:"revision_id"`
+	Name        string `json:"name"`
+	Edited      int64  `json:"edited"`
+	UpdatedBy   string `json:"updated_by,omitempty"`
+	NodeCount   int    `json:"node_count"`
+	BranchCount int    `json:"branch_count"`
+}
+
+type CslNodeSummary struct {
+	Id      string `json:"id"`
+	Label   string `json:"label"`
+	AppName string `json:"app_name,omitempty"`
+}
+
+type CslNodeChange struct {
+	CslNodeSummary
+	Changes []string `json:"changes"`
+}
+
+type CslBranchSummary struct {
+	SourceId      string `json:"source_id"`
+	DestinationId string `json:"destination_id"`
+}
+
+type CslWorkflowDiff struct {
+	From             string             `json:"from"`
+	To               string             `json:"to"`
+	ChangeCount      int                `json:"change_count"`
+	FieldChanges     []string           `json:"field_changes"`
+	ActionsAdded     []CslNodeSummary   `json:"actions_added"`
+	ActionsRemoved   []CslNodeSummary   `json:"actions_removed"`
+	ActionsModified  []CslNodeChange    `json:"actions_modified"`
+	TriggersAdded    []CslNodeSummary   `json:"triggers_added"`
+	TriggersRemoved  []CslNodeSummary   `json:"triggers_removed"`
+	BranchesAdded    []CslBranchSummary `json:"branches_added"`
+	BranchesRemoved  []CslBranchSummary `json:"branches_removed"`
+	VariablesChanged []string           `json:"variables_changed"`
+}
+
+// Returns the workflows revisions, newest first
+func getWorkflowVersions(ctx context.Context, workflowId string) ([]shuffle.Workflow, error) {
+	revisions, err := shuffle.ListWorkflowRevisions(ctx, workflowId)
+	if err != nil {
+		return revisions, err
+	}
+
+	sort.SliceStable(revisions, func(i, j int) bool {
+		return revisions[i].Edited > revisions[j].Edited
+	})
+
+	return revisions, nil
+}
+
+// Finds a revision by id. "current" returns the saved workflow itself
+func findWorkflowVersion(workflow shuffle.Workflow, revisions []shuffle.Workflow, revisionId string) (shuffle.Workflow, error) {
+	if revisionId == CurrentWorkflowVersion {
+		return workflow, nil
+	}
+
+	for _, revision := range revisions {
+		if revision.RevisionId == revisionId {
+			return revision, nil
+		}
+	}
+
+	return shuffle.Workflow{}, errors.New(fmt.Sprintf("revision %s not found", revisionId))
+}
+
+func getParameterMap(params []shuffle.WorkflowAppActionParameter) map[string]string {
+	paramMap := map[string]string{}
+	for _, param := range params {
+		paramMap[param.Name] = param.Value
+	}
+
+	return paramMap
+}
+
+// Lists what changed in a single action between two versions
+func getActionChanges(from, to shuffle.Action) []string {
+	changes := []string{}
+	if from.Label != to.Label {
+		changes = append(changes, fmt.Sprintf("label changed from %s to %s", from.Label, to.Label))
+	}
+
+	if from.Name != to.Name {
+		changes = append(changes, fmt.Sprintf("action changed from %s to %s", from.Name, to.Name))
+	}
+
+	if from.AppVersion != to.AppVersion {
+		changes = append(changes, fmt.Sprintf("app version changed from %s to %s", from.AppVersion, to.AppVersion))
+	}
+
+	if from.AuthenticationId != to.AuthenticationId {
+		changes = append(changes, "authentication changed")
+	}
+
+	if from.Environment != to.Environment {
+		changes = append(changes, fmt.Sprintf("environment changed from %s to %s", from.Environment, to.Environment))
+	}
+
+	fromParams := getParameterMap(from.Parameters)
+	toParams := getParameterMap(to.Parameters)
+	for name, value := range toParams {
+		previous, found := fromParams[name]
+		if !found {
+			changes = append(changes, fmt.Sprintf("parameter %s added", name))
+		} else if previous != value {
+			changes = append(changes, fmt.Sprintf("parameter %s changed", name))
+		}
+	}
+
+	for name := range fromParams {
+		if _, found := toParams[name]; !found {
+			changes = append(changes, fmt.Sprintf("parameter %s removed", name))
+		}
+	}
+
+	sort.Strings(changes)
+	return changes
+}
+
+func getBranchKey(branch shuffle.Branch) string {
+	return fmt.Sprintf("%s_%s", branch.SourceID, branch.DestinationID)
+}
+
+// Structural diff between two workflow versions
+func diffWorkflowVersions(from, to shuffle.Workflow, fromId, toId string) CslWorkflowDiff {
+	diff := CslWorkflowDiff{
+		From:             fromId,
+		To:               toId,
+		FieldChanges:     []string{},
+		ActionsAdded:     []CslNodeSummary{},
+		ActionsRemoved:   []CslNodeSummary{},
+		ActionsModified:  []CslNodeChange{},
+		TriggersAdded:    []CslNodeSummary{},
+		TriggersRemoved:  []CslNodeSummary{},
+		BranchesAdded:    []CslBranchSummary{},
+		BranchesRemoved:  []CslBranchSummary{},
+		VariablesChanged: []string{},
+	}
+
+	if from.Name != to.Name {
+		diff.FieldChanges = append(diff.FieldChanges, fmt.Sprintf("name changed from %s to %s", from.Name, to.Name))
+	}
+
+	if from.Description != to.Description {
+		diff.FieldChanges = append(diff.FieldChanges, "description changed")
+	}
+
+	if from.Start != to.Start {
+		diff.FieldChanges = append(diff.FieldChanges, "start node changed")
+	}
+
+	if from.Configuration != to.Configuration {
+		diff.FieldChanges = append(diff.FieldChanges, "configuration changed")
+	}
+
+	fromActions := map[string]shuffle.Action{}
+	for _, action := range from.Actions {
+		fromActions[action.ID] = action
+	}
+
+	toActions := map[string]bool{}
+	for _, action := range to.Actions {
+		toActions[action.ID] = true
+		summary := CslNodeSummary{Id: action.ID, Label: getActionName(action), AppName: action.AppName}
+
+		previous, found := fromActions[action.ID]
+		if !found {
+			diff.ActionsAdded = append(diff.ActionsAdded, summary)
+			continue
+		}
+
+		changes := getActionChanges(previous, action)
+		if len(changes) > 0 {
+			diff.ActionsModified = append(diff.ActionsModified, CslNodeChange{CslNodeSummary: summary, Changes: changes})
+		}
+	}
+
+	for _, action := range from.Actions {
+		if !toActions[action.ID] {
+			diff.ActionsRemoved = append(diff.ActionsRemoved, CslNodeSummary{Id: action.ID, Label: getActionName(action), AppName: action.AppName})
+		}
+	}
+
+	fromTriggers := map[string]bool{}
+	for _, trigger := range from.Triggers {
+		fromTriggers[trigger.ID] = true
+	}
+
+	toTriggers := map[string]bool{}
+	for _, trigger := range to.Triggers {
+		toTriggers[trigger.ID] = true
+		if !fromTriggers[trigger.ID] {
+			diff.TriggersAdded = append(diff.TriggersAdded, CslNodeSummary{Id: trigger.ID, Label: trigger.Label, AppName: trigger.AppName})
+		}
+	}
+
+	for _, trigger := range from.Triggers {
+		if !toTriggers[trigger.ID] {
+			diff.TriggersRemoved = append(diff.TriggersRemoved, CslNodeSummary{Id: trigger.ID, Label: trigger.Label, AppName: trigger.AppName})
+		}
+	}
+
+	fromBranches := map[string]bool{}
+	for _, branch := range from.Branches {
+		fromBranches[getBranchKey(branch)] = true
+	}
+
+	toBranches := map[string]bool{}
+	for _, branch := range to.Branches {
+		toBranches[getBranchKey(branch)] = true
+		if !fromBranches[getBranchKey(branch)] {
+			diff.BranchesAdded = append(diff.BranchesAdded, CslBranchSummary{SourceId: branch.SourceID, DestinationId: branch.DestinationID})
+		}
+	}
+
+	for _, branch := range from.Branches {
+		if !toBranches[getBranchKey(branch)] {
+			diff.BranchesRemoved = append(diff.BranchesRemoved, CslBranchSummary{SourceId: branch.SourceID, DestinationId: branch.DestinationID})
+		}
+	}
+
+	fromVariables := map[string]string{}
+	for _, variable := range from.WorkflowVariables {
+		fromVariables[variable.Name] = variable.Value
+	}
+
+	toVariables := map[string]bool{}
+	for _, variable := range to.WorkflowVariables {
+		toVariables[variable.Name] = true
+		previous, found := fromVariables[variable.Name]
+		if !found {
+			diff.VariablesChanged = append(diff.VariablesChanged, fmt.Sprintf("%s added", variable.Name))
+		} else if previous != variable.Value {
+			diff.VariablesChanged = append(diff.VariablesChanged, fmt.Sprintf("%s changed", variable.Name))
+		}
+	}
+
+	for _, variable := range from.WorkflowVariables {
+		if !toVariables[variable.Name] {
+			diff.VariablesChanged = append(diff.VariablesChanged, fmt.Sprintf("%s removed", variable.Name))
+		}
+	}
+
+	diff.ChangeCount = len(diff.FieldChanges) + len(diff.ActionsAdded) + len(diff.ActionsRemoved) + len(diff.ActionsModified) + len(diff.TriggersAdded) + len(diff.TriggersRemoved) + len(diff.BranchesAdded) + len(diff.BranchesRemoved) + len(diff.VariablesChanged)
+	return diff
+}
+
+/*
+Workflows:
+Returns the saved versions of a workflow, newest first. Versions are stored
+every time the workflow is saved.
+Requires ?workflow_id=<id>
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "revision_id": "5f0c...",
+	            "name": "Phishing triage",
+	            "edited": 1700000000,
+	            "updated_by": "analyst@example.com",
+	            "node_count": 12,
+	            "branch_count": 11
+	        }
+	    ]
+	}
+*/
+func cslWorkflowVersions(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	workflow, err := getCslWorkflow(ctx, *user, request.URL.Query().Get("workflow_id"))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	revisions, err := getWorkflowVersions(ctx, workflow.ID)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting revisions for workflow %s: %s", workflow.ID, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	versions := []CslWorkflowVersion{}
+	for _, revision := range revisions {
+		versions = append(versions, CslWorkflowVersion{
+			RevisionId:  revision.RevisionId,
+			Name:        revision.Name,
+			Edited:      revision.Edited,
+			UpdatedBy:   revision.UpdatedBy,
+			NodeCount:   len(revision.Actions) + len(revision.Triggers),
+			BranchCount: len(revision.Branches),
+		})
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    versions,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslWorkflowVersions")
+}
+
+/*
+Workflows:
+Returns a structural diff between two versions of a workflow.
+Requires ?workflow_id=<id>. ?to=<revision_id> defaults to "current" (the saved
+workflow) and ?from=<revision_id> defaults to the version before "to".
+
+	{
+	    "success": true,
+	    "data": {
+	        "from": "5f0c...",
+	        "to": "current",
+	        "change_count": 2,
+	        "field_changes": [],
+	        "actions_added": [
+	            {
+	                "id": "...",
+	                "label": "Block IP",
+	                "app_name": "Firewall"
+	            }
+	        ],
+	        "actions_removed": [],
+	        "actions_modified": [
+	            {
+	                "id": "...",
+	                "label": "Get report",
+	                "app_name": "VirusTotal",
+	                "changes": ["parameter apikey changed"]
+	            }
+	        ],
+	        ...
+	    }
+	}
+*/
+func cslWorkflowVersionDiff(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	workflow, err := getCslWorkflow(ctx, *user, query.Get("workflow_id"))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	revisions, err := getWorkflowVersions(ctx, workflow.ID)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting revisions for workflow %s: %s", workflow.ID, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	toId := query.Get("to")
+	if len(toId) == 0 {
+		toId = CurrentWorkflowVersion
+	}
+
+	to, err := findWorkflowVersion(*workflow, revisions, toId)
+	if err != nil {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	fromId := query.Get("from")
+	if len(fromId) == 0 {
+		// The version before "to". Revisions are sorted newest first
+		for _, revision := range revisions {
+			if revision.Edited < to.Edited && revision.RevisionId != to.RevisionId {
+				fromId = revision.RevisionId
+				break
+			}
+		}
+
+		if len(fromId) == 0 {
+			resp.WriteHeader(404)
+			resp.Write(createCslErrorResponse(errors.New("no earlier version to compare with")))
+			return
+		}
+	}
+
+	from, err := findWorkflowVersion(*workflow, revisions, fromId)
+	if err != nil {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    diffWorkflowVersions(from, to, fromId, toId),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslWorkflowVersionDiff")
+}
+
+/*
+Workflows:
+Rolls a workflow back to a previous version. The current workflow is stored as
+a version first, so the rollback itself can be undone.
+Requires ?workflow_id=<id>&revision_id=<revision_id>. Returns the restored version
+*/
+func cslWorkflowRollback(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	if user.Role == "org-reader" {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("read only user")))
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	workflow, err := getCslWorkflow(ctx, *user, query.Get("workflow_id"))
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	revisions, err := getWorkflowVersions(ctx, workflow.ID)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting revisions for workflow %s: %s", workflow.ID, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	revisionId := query.Get("revision_id")
+	if len(revisionId) == 0 || revisionId == CurrentWorkflowVersion {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("revision_id is required")))
+		return
+	}
+
+	revision, err := findWorkflowVersion(*workflow, revisions, revisionId)
+	if err != nil {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	err = shuffle.SetWorkflowRevision(ctx, *workflow)
+	if err != nil {
+		log.Printf("[ERROR] Failed storing current version of workflow %s before rollback: %s", workflow.ID, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	// Ownership and org placement always stay as they are now
+	restored := revision
+	restored.ID = workflow.ID
+	restored.OrgId = workflow.OrgId
+	restored.Owner = workflow.Owner
+	restored.Org = workflow.Org
+	restored.Created = workflow.Created
+	restored.UpdatedBy = user.Username
+
+	err = shuffle.SetWorkflow(ctx, restored, restored.ID)
+	if err != nil {
+		log.Printf("[ERROR] Failed rolling back workflow %s to revision %s: %s", workflow.ID, revisionId, err)
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) rolled back workflow %s to revision %s", user.Username, user.Id, workflow.ID, revisionId)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeWorkflow, "rolled_back", fmt.Sprintf("Workflow %s was rolled back", workflow.Name), user.Username, workflow.ID)
+
+	res := CslResponse{
+		Success: true,
+		Data: CslWorkflowVersion{
+			RevisionId:  revisionId,
+			Name:        restored.Name,
+			Edited:      revision.Edited,
+			UpdatedBy:   revision.UpdatedBy,
+			NodeCount:   len(restored.Actions) + len(restored.Triggers),
+			BranchCount: len(restored.Branches),
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslWorkflowRollback")
+}
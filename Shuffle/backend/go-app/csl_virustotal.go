@@ -0,0 +1,743 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslVirusTotalDocument = "virustotal"
+
+const VirusTotalApiUrl = "https://www.virustotal.com/api/v3"
+const VirusTotalGuiUrl = "https://www.virustotal.com/gui"
+
+// Defaults match the quota of the public VirusTotal API
+const (
+	DefaultVirusTotalPerMinute  = 4
+	DefaultVirusTotalDailyQuota = 500
+	DefaultVirusTotalCacheHours = 24
+	MaxVirusTotalCacheHours     = 24 * 30
+)
+
+const MonthDateFormat = "2006-01"
+
+// Lookup types. MD5, SHA1 and SHA256 hashes are all looked up as files
+const (
+	VirusTotalIp     = "ip"
+	VirusTotalDomain = "domain"
+	VirusTotalUrl    = "url"
+	VirusTotalFile   = "file"
+)
+
+var virusTotalTypes = []string{VirusTotalIp, VirusTotalDomain, VirusTotalUrl, VirusTotalFile}
+
+var errVirusTotalQuota = errors.New("virustotal quota is used up")
+
+// MonthlyQuota of 0 is unlimited, as the public API has no monthly limit
+// besides the daily one
+type CslVirusTotalConfig struct {
+	Enabled      bool   `json:"enabled"`
+	ApiKey       string `json:"api_key"`
+	PerMinute    int    `json:"per_minute"`
+	DailyQuota   int    `json:"daily_quota"`
+	MonthlyQuota int    `json:"monthly_quota"`
+	CacheHours   int    `json:"cache_hours"`
+}
+
+// Requests sent to VirusTotal in the current minute, day and month
+type CslVirusTotalQuotaState struct {
+	Minute      int64  `json:"minute"`
+	MinuteCount int    `json:"minute_count"`
+	Day         string `json:"day"`
+	DayCount    int    `json:"day_count"`
+	Month       string `json:"month"`
+	MonthCount  int    `json:"month_count"`
+}
+
+// Lookups is every request to the proxy. Deduplicated lookups waited for an
+// identical lookup that was already in flight
+type CslVirusTotalStats struct {
+	Since         int64  `json:"since"`
+	Lookups       int64  `json:"lookups"`
+	CacheHits     int64  `json:"cache_hits"`
+	Deduplicated  int64  `json:"deduplicated"`
+	Requests      int64  `json:"requests"`
+	NotFound      int64  `json:"not_found"`
+	Errors        int64  `json:"errors"`
+	QuotaRejected int64  `json:"quota_rejected"`
+	LastError     string `json:"last_error"`
+}
+
+type CslVirusTotal struct {
+	Config CslVirusTotalConfig     `json:"config"`
+	Quota  CslVirusTotalQuotaState `json:"quota"`
+	Stats  CslVirusTotalStats      `json:"stats"`
+}
+
+type CslVirusTotalSummary struct {
+	Malicious  int `json:"malicious"`
+	Suspicious int `json:"suspicious"`
+	Harmless   int `json:"harmless"`
+	Undetected int `json:"undetected"`
+	Reputation int `json:"reputation"`
+}
+
+// Result is the data object returned by VirusTotal, and is empty when the
+// indicator wasn't found
+type CslVirusTotalLookup struct {
+	Type     string               `json:"type"`
+	Value    string               `json:"value"`
+	Found    bool                 `json:"found"`
+	Summary  CslVirusTotalSummary `json:"summary"`
+	Link     string               `json:"link"`
+	Result   json.RawMessage      `json:"result,omitempty"`
+	Fetched  int64                `json:"fetched"`
+	Cached   bool                 `json:"cached"`
+	CacheAge int64                `json:"cache_age"`
+}
+
+type CslVirusTotalQuotaUsage struct {
+	MinuteRemaining int `json:"minute_remaining"`
+	DailyUsed       int `json:"daily_used"`
+	DailyRemaining  int `json:"daily_remaining"`
+	MonthlyUsed     int `json:"monthly_used"`
+	// -1 without a monthly quota
+	MonthlyRemaining int `json:"monthly_remaining"`
+}
+
+type CslVirusTotalStatsResponse struct {
+	CslVirusTotalStats
+	HitRate float64                 `json:"hit_rate"`
+	Quota   CslVirusTotalQuotaUsage `json:"quota"`
+}
+
+type virusTotalPending struct {
+	done   chan struct{}
+	lookup CslVirusTotalLookup
+	err    error
+}
+
+// Lookups in flight, so concurrent workflows asking for the same indicator
+// share one request to VirusTotal
+var cslVirusTotalPending = struct {
+	sync.Mutex
+	lookups map[string]*virusTotalPending
+}{
+	lookups: map[string]*virusTotalPending{},
+}
+
+func getCslVirusTotal(ctx context.Context, orgId string) CslVirusTotal {
+	virusTotal := CslVirusTotal{}
+	_, err := getCslDocument(ctx, orgId, CslVirusTotalDocument, &virusTotal)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting virustotal config for org %s: %s", orgId, err)
+	}
+
+	setVirusTotalDefaults(&virusTotal.Config)
+	return virusTotal
+}
+
+func setVirusTotalDefaults(config *CslVirusTotalConfig) {
+	if config.PerMinute == 0 {
+		config.PerMinute = DefaultVirusTotalPerMinute
+	}
+
+	if config.DailyQuota == 0 {
+		config.DailyQuota = DefaultVirusTotalDailyQuota
+	}
+
+	if config.CacheHours == 0 {
+		config.CacheHours = DefaultVirusTotalCacheHours
+	}
+}
+
+func redactCslVirusTotalConfig(config CslVirusTotalConfig) CslVirusTotalConfig {
+	if len(config.ApiKey) > 0 {
+		config.ApiKey = RedactedValue
+	}
+
+	return config
+}
+
+func validateCslVirusTotalConfig(config CslVirusTotalConfig) error {
+	if config.Enabled && len(config.ApiKey) == 0 {
+		return errors.New("api_key is required")
+	}
+
+	if config.PerMinute < 0 || config.DailyQuota < 0 || config.MonthlyQuota < 0 {
+		return errors.New("quotas can't be negative")
+	}
+
+	if config.CacheHours < 0 || config.CacheHours > MaxVirusTotalCacheHours {
+		return errors.New(fmt.Sprintf("cache_hours must be between 1 and %d", MaxVirusTotalCacheHours))
+	}
+
+	return nil
+}
+
+// Resets the counters of windows that have passed
+func rollVirusTotalQuota(quota *CslVirusTotalQuotaState, now time.Time) {
+	if quota.Minute != now.Unix()/60 {
+		quota.Minute = now.Unix() / 60
+		quota.MinuteCount = 0
+	}
+
+	if quota.Day != now.UTC().Format(UsageDateFormat) {
+		quota.Day = now.UTC().Format(UsageDateFormat)
+		quota.DayCount = 0
+	}
+
+	if quota.Month != now.UTC().Format(MonthDateFormat) {
+		quota.Month = now.UTC().Format(MonthDateFormat)
+		quota.MonthCount = 0
+	}
+}
+
+func getVirusTotalQuotaUsage(config CslVirusTotalConfig, quota CslVirusTotalQuotaState) CslVirusTotalQuotaUsage {
+	rollVirusTotalQuota(&quota, time.Now())
+
+	usage := CslVirusTotalQuotaUsage{
+		MinuteRemaining:  max(config.PerMinute-quota.MinuteCount, 0),
+		DailyUsed:        quota.DayCount,
+		DailyRemaining:   max(config.DailyQuota-quota.DayCount, 0),
+		MonthlyUsed:      quota.MonthCount,
+		MonthlyRemaining: -1,
+	}
+
+	if config.MonthlyQuota > 0 {
+		usage.MonthlyRemaining = max(config.MonthlyQuota-quota.MonthCount, 0)
+	}
+
+	return usage
+}
+
+// Figures out the type of a refanged indicator when none is given
+func getVirusTotalType(value string) string {
+	if net.ParseIP(value) != nil {
+		return VirusTotalIp
+	}
+
+	if strings.Contains(value, "://") {
+		return VirusTotalUrl
+	}
+
+	if observableHashPattern.MatchString(value) && (len(value) == 32 || len(value) == 40 || len(value) == 64) {
+		return VirusTotalFile
+	}
+
+	return VirusTotalDomain
+}
+
+func validateVirusTotalLookup(lookupType, value string) error {
+	switch lookupType {
+	case VirusTotalIp:
+		if net.ParseIP(value) == nil {
+			return errors.New("value is not a valid ip")
+		}
+	case VirusTotalDomain:
+		if !observableDomainPattern.MatchString(value) || strings.ContainsAny(value, "/: ") {
+			return errors.New("value is not a valid domain")
+		}
+	case VirusTotalUrl:
+		parsedUrl, err := url.Parse(value)
+		if err != nil || len(parsedUrl.Host) == 0 {
+			return errors.New("value is not a valid url")
+		}
+	case VirusTotalFile:
+		if !observableHashPattern.MatchString(value) || (len(value) != 32 && len(value) != 40 && len(value) != 64) {
+			return errors.New("value is not a md5, sha1 or sha256 hash")
+		}
+	default:
+		return errors.New(fmt.Sprintf("type must be one of %s", strings.Join(virusTotalTypes, ", ")))
+	}
+
+	return nil
+}
+
+// Paths of the VirusTotal v3 API and GUI. URLs are identified by their
+// unpadded base64
+func getVirusTotalPath(lookupType, value string) (string, string) {
+	switch lookupType {
+	case VirusTotalIp:
+		return fmt.Sprintf("ip_addresses/%s", value), fmt.Sprintf("ip-address/%s", value)
+	case VirusTotalDomain:
+		return fmt.Sprintf("domains/%s", value), fmt.Sprintf("domain/%s", value)
+	case VirusTotalUrl:
+		urlId := base64.RawURLEncoding.EncodeToString([]byte(value))
+		return fmt.Sprintf("urls/%s", urlId), fmt.Sprintf("url/%x", sha256.Sum256([]byte(value)))
+	}
+
+	return fmt.Sprintf("files/%s", value), fmt.Sprintf("file/%s", value)
+}
+
+// Cache keys are hashed, as URLs can contain anything
+func getVirusTotalCacheKey(orgId, lookupType, value string) string {
+	return fmt.Sprintf("csl_virustotal_%s_%x", orgId, sha256.Sum256([]byte(lookupType+"_"+value)))
+}
+
+func getCachedVirusTotalLookup(ctx context.Context, cacheKey string) (CslVirusTotalLookup, bool) {
+	lookup := CslVirusTotalLookup{}
+	cache, err := shuffle.GetCache(ctx, cacheKey)
+	if err != nil {
+		return lookup, false
+	}
+
+	cacheData, ok := cache.([]uint8)
+	if !ok || json.Unmarshal(cacheData, &lookup) != nil || lookup.Fetched == 0 {
+		return lookup, false
+	}
+
+	return lookup, true
+}
+
+// Counts a lookup in the stats, and reserves a request in the quota when the
+// lookup goes to VirusTotal. Returns errVirusTotalQuota without a reservation
+// when any quota is used up
+func recordVirusTotalLookup(ctx context.Context, orgId string, update func(virusTotal *CslVirusTotal) error) error {
+	virusTotal := CslVirusTotal{}
+	return updateCslDocument(ctx, orgId, CslVirusTotalDocument, &virusTotal, func() error {
+		setVirusTotalDefaults(&virusTotal.Config)
+		if virusTotal.Stats.Since == 0 {
+			virusTotal.Stats.Since = time.Now().Unix()
+		}
+
+		virusTotal.Stats.Lookups += 1
+		rollVirusTotalQuota(&virusTotal.Quota, time.Now())
+		return update(&virusTotal)
+	})
+}
+
+func reserveVirusTotalRequest(virusTotal *CslVirusTotal) error {
+	config := virusTotal.Config
+	quota := &virusTotal.Quota
+	if quota.MinuteCount >= config.PerMinute || quota.DayCount >= config.DailyQuota || (config.MonthlyQuota > 0 && quota.MonthCount >= config.MonthlyQuota) {
+		virusTotal.Stats.QuotaRejected += 1
+		return errVirusTotalQuota
+	}
+
+	quota.MinuteCount += 1
+	quota.DayCount += 1
+	quota.MonthCount += 1
+	virusTotal.Stats.Requests += 1
+	return nil
+}
+
+func fetchVirusTotalLookup(ctx context.Context, config CslVirusTotalConfig, lookupType, value string) (CslVirusTotalLookup, error) {
+	apiPath, guiPath := getVirusTotalPath(lookupType, value)
+	lookup := CslVirusTotalLookup{
+		Type:    lookupType,
+		Value:   value,
+		Link:    fmt.Sprintf("%s/%s", VirusTotalGuiUrl, guiPath),
+		Fetched: time.Now().Unix(),
+	}
+
+	requestUrl := fmt.Sprintf("%s/%s", VirusTotalApiUrl, apiPath)
+	req, err := http.NewRequestWithContext(ctx, "GET", requestUrl, nil)
+	if err != nil {
+		return lookup, err
+	}
+
+	req.Header.Add("x-apikey", config.ApiKey)
+	req.Header.Add("Accept", "application/json")
+
+	client := shuffle.GetExternalClient(requestUrl)
+	newresp, err := client.Do(req)
+	if err != nil {
+		return lookup, err
+	}
+
+	defer newresp.Body.Close()
+	respBody, err := ioutil.ReadAll(newresp.Body)
+	if err != nil {
+		return lookup, err
+	}
+
+	if newresp.StatusCode == 404 {
+		return lookup, nil
+	}
+
+	if newresp.StatusCode == 429 {
+		return lookup, errVirusTotalQuota
+	}
+
+	if newresp.StatusCode >= 300 {
+		return lookup, errors.New(fmt.Sprintf("virustotal returned status code %d: %s", newresp.StatusCode, truncateText(string(respBody), 500)))
+	}
+
+	raw := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+
+	parsed := struct {
+		Attributes struct {
+			Reputation        int                  `json:"reputation"`
+			LastAnalysisStats CslVirusTotalSummary `json:"last_analysis_stats"`
+		} `json:"attributes"`
+	}{}
+
+	err = json.Unmarshal(respBody, &raw)
+	if err == nil {
+		err = json.Unmarshal(raw.Data, &parsed)
+	}
+
+	if err != nil {
+		return lookup, errors.New(fmt.Sprintf("failed parsing virustotal response: %s", err))
+	}
+
+	lookup.Found = true
+	lookup.Result = raw.Data
+	lookup.Summary = parsed.Attributes.LastAnalysisStats
+	lookup.Summary.Reputation = parsed.Attributes.Reputation
+
+	return lookup, nil
+}
+
+// Looks up an indicator from the cache of the org, or from VirusTotal when
+// it isn't cached and the quota allows it. Identical lookups in flight share
+// the same request
+func lookupCslVirusTotal(ctx context.Context, orgId string, config CslVirusTotalConfig, lookupType, value string) (CslVirusTotalLookup, error) {
+	cacheKey := getVirusTotalCacheKey(orgId, lookupType, value)
+	if lookup, ok := getCachedVirusTotalLookup(ctx, cacheKey); ok {
+		err := recordVirusTotalLookup(ctx, orgId, func(virusTotal *CslVirusTotal) error {
+			virusTotal.Stats.CacheHits += 1
+			return nil
+		})
+		if err != nil {
+			log.Printf("[WARNING] Failed recording virustotal lookup for org %s: %s", orgId, err)
+		}
+
+		lookup.Cached = true
+		lookup.CacheAge = time.Now().Unix() - lookup.Fetched
+		return lookup, nil
+	}
+
+	cslVirusTotalPending.Lock()
+	pending, ok := cslVirusTotalPending.lookups[cacheKey]
+	if ok {
+		cslVirusTotalPending.Unlock()
+		<-pending.done
+
+		err := recordVirusTotalLookup(ctx, orgId, func(virusTotal *CslVirusTotal) error {
+			virusTotal.Stats.Deduplicated += 1
+			return nil
+		})
+		if err != nil {
+			log.Printf("[WARNING] Failed recording virustotal lookup for org %s: %s", orgId, err)
+		}
+
+		return pending.lookup, pending.err
+	}
+
+	pending = &virusTotalPending{done: make(chan struct{})}
+	cslVirusTotalPending.lookups[cacheKey] = pending
+	cslVirusTotalPending.Unlock()
+
+	defer func() {
+		cslVirusTotalPending.Lock()
+		delete(cslVirusTotalPending.lookups, cacheKey)
+		cslVirusTotalPending.Unlock()
+		close(pending.done)
+	}()
+
+	pending.err = recordVirusTotalLookup(ctx, orgId, reserveVirusTotalRequest)
+	if pending.err != nil {
+		return pending.lookup, pending.err
+	}
+
+	pending.lookup, pending.err = fetchVirusTotalLookup(ctx, config, lookupType, value)
+	if pending.err != nil {
+		log.Printf("[WARNING] Failed virustotal lookup of %s %s for org %s: %s", lookupType, value, orgId, pending.err)
+
+		virusTotal := CslVirusTotal{}
+		err := updateCslDocument(ctx, orgId, CslVirusTotalDocument, &virusTotal, func() error {
+			if pending.err == errVirusTotalQuota {
+				// VirusTotal knows the quota better than the counters do
+				virusTotal.Quota.MinuteCount = max(virusTotal.Quota.MinuteCount, virusTotal.Config.PerMinute)
+				virusTotal.Stats.QuotaRejected += 1
+				return nil
+			}
+
+			virusTotal.Stats.Errors += 1
+			virusTotal.Stats.LastError = pending.err.Error()
+			return nil
+		})
+		if err != nil {
+			log.Printf("[WARNING] Failed recording virustotal error for org %s: %s", orgId, err)
+		}
+
+		return pending.lookup, pending.err
+	}
+
+	if !pending.lookup.Found {
+		virusTotal := CslVirusTotal{}
+		updateCslDocument(ctx, orgId, CslVirusTotalDocument, &virusTotal, func() error {
+			virusTotal.Stats.NotFound += 1
+			return nil
+		})
+	}
+
+	cacheData, err := json.Marshal(pending.lookup)
+	if err == nil {
+		err = shuffle.SetCache(ctx, cacheKey, cacheData, int32(config.CacheHours*60))
+	}
+
+	if err != nil {
+		log.Printf("[WARNING] Failed caching virustotal lookup for org %s: %s", orgId, err)
+	}
+
+	return pending.lookup, nil
+}
+
+/*
+Enrichment:
+Looks up an indicator in VirusTotal through the shared cache of the
+organization, so workflows don't each spend the API quota on the same
+indicators. Requires ?value=<indicator>, which may be defanged, and optional
+?type=<ip|domain|url|file> that is detected from the value when left out.
+Workflows use ?execution_id=<id>&authorization=<execution authorization>.
+Returns 429 when the quota is used up and the indicator isn't cached.
+
+	{
+	    "success": true,
+	    "data": {
+	        "type": "domain",
+	        "value": "evil.example.com",
+	        "found": true,
+	        "summary": {
+	            "malicious": 12,
+	            "suspicious": 1,
+	            "harmless": 60,
+	            "undetected": 20,
+	            "reputation": -30
+	        },
+	        "link": "https://www.virustotal.com/gui/domain/evil.example.com",
+	        "result": {"id": "evil.example.com", "type": "domain", "attributes": {...}},
+	        "fetched": 1700000000,
+	        "cached": true,
+	        "cache_age": 3600
+	    }
+	}
+*/
+func cslVirusTotalLookup(resp http.ResponseWriter, request *http.Request) {
+	orgId, _, _, ok := handleCslExecutionRequest(resp, request)
+	if !ok {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	config := getCslVirusTotal(ctx, orgId).Config
+	if !config.Enabled {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New("virustotal enrichment is not enabled")))
+		return
+	}
+
+	value := strings.TrimSpace(refangObservables(query.Get("value")))
+	lookupType := strings.ToLower(query.Get("type"))
+	if len(lookupType) == 0 {
+		lookupType = getVirusTotalType(value)
+	}
+
+	// Hashes and domains are case insensitive, so they share the cache
+	if lookupType == VirusTotalFile || lookupType == VirusTotalDomain {
+		value = strings.ToLower(value)
+	}
+
+	err := validateVirusTotalLookup(lookupType, value)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	lookup, err := lookupCslVirusTotal(ctx, orgId, config, lookupType, value)
+	if err == errVirusTotalQuota {
+		resp.WriteHeader(429)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if err != nil {
+		resp.WriteHeader(502)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    lookup,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslVirusTotalLookup")
+}
+
+/*
+Enrichment:
+Returns the VirusTotal proxy statistics of the current organization. Hit rate
+is the share of lookups answered from the cache or by an identical lookup in
+flight.
+
+	{
+	    "success": true,
+	    "data": {
+	        "since": 1700000000,
+	        "lookups": 1200,
+	        "cache_hits": 900,
+	        "deduplicated": 40,
+	        "requests": 260,
+	        "not_found": 35,
+	        "errors": 0,
+	        "quota_rejected": 0,
+	        "last_error": "",
+	        "hit_rate": 78.3,
+	        "quota": {
+	            "minute_remaining": 4,
+	            "daily_used": 120,
+	            "daily_remaining": 380,
+	            "monthly_used": 2400,
+	            "monthly_remaining": -1
+	        }
+	    }
+	}
+*/
+func cslVirusTotalStats(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	virusTotal := getCslVirusTotal(ctx, user.ActiveOrg.Id)
+	stats := CslVirusTotalStatsResponse{
+		CslVirusTotalStats: virusTotal.Stats,
+		Quota:              getVirusTotalQuotaUsage(virusTotal.Config, virusTotal.Quota),
+	}
+
+	if stats.Lookups > 0 {
+		stats.HitRate = float64(int64(float64(stats.CacheHits+stats.Deduplicated)/float64(stats.Lookups)*1000)) / 10
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    stats,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslVirusTotalStats")
+}
+
+/*
+Enrichment:
+Returns the VirusTotal proxy configuration. Requires org admin. Quotas default
+to those of the public API, and monthly_quota of 0 is unlimited.
+
+	{
+	    "success": true,
+	    "data": {
+	        "enabled": true,
+	        "api_key": "********",
+	        "per_minute": 4,
+	        "daily_quota": 500,
+	        "monthly_quota": 0,
+	        "cache_hours": 24
+	    }
+	}
+*/
+func cslGetVirusTotalConfig(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslVirusTotalConfig(getCslVirusTotal(ctx, user.ActiveOrg.Id).Config),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetVirusTotalConfig")
+}
+
+/*
+Enrichment:
+Updates the VirusTotal proxy configuration. Requires org admin. Body uses the
+format returned from GET, and a redacted API key is kept as it is. Changing
+the API key resets the quota counters.
+*/
+func cslSetVirusTotalConfig(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	config := CslVirusTotalConfig{}
+	err = json.Unmarshal(body, &config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if config.ApiKey == RedactedValue {
+		config.ApiKey = getCslVirusTotal(ctx, user.ActiveOrg.Id).Config.ApiKey
+	}
+
+	err = validateCslVirusTotalConfig(config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	setVirusTotalDefaults(&config)
+
+	virusTotal := CslVirusTotal{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslVirusTotalDocument, &virusTotal, func() error {
+		if virusTotal.Config.ApiKey != config.ApiKey {
+			virusTotal.Quota = CslVirusTotalQuotaState{}
+		}
+
+		virusTotal.Config = config
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated virustotal config for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "virustotal_updated", "VirusTotal enrichment configuration was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslVirusTotalConfig(config),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetVirusTotalConfig")
+}
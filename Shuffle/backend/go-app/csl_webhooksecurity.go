@@ -0,0 +1,627 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Signature verification of webhook triggers with a secret shared with the
+// sender, so knowing the webhook url isn't enough to start executions.
+// Schemes:
+//
+//	shuffle: X-Shuffle-Timestamp and X-Shuffle-Signature: sha256=<hmac of "timestamp.body">
+//	stripe:  Stripe-Signature: t=<timestamp>,v1=<hmac of "timestamp.body">
+//	github:  X-Hub-Signature-256: sha256=<hmac of body>, without a timestamp
+//
+// With replay protection, requests need a timestamp within the tolerance and
+// a nonce that wasn't seen within it. The signature is the nonce of signed
+// requests, as it can't be changed without the secret. Unsigned requests send
+// X-Shuffle-Nonce or X-GitHub-Delivery, and the timestamp in
+// X-Shuffle-Timestamp.
+//
+// Secrets are stored encrypted the same way as app authentication values, so
+// SHUFFLE_ENCRYPTION_MODIFIER or SHUFFLE_ENVELOPE_KMS has to be set to use them.
+
+const CslWebhookSecurityDocument = "webhook_security"
+
+const (
+	SignatureSchemeShuffle = "shuffle"
+	SignatureSchemeStripe  = "stripe"
+	SignatureSchemeGithub  = "github"
+)
+
+var signatureSchemes = []string{SignatureSchemeShuffle, SignatureSchemeStripe, SignatureSchemeGithub}
+
+const DefaultSignatureToleranceSeconds = 300
+const MaxSignatureToleranceSeconds = 60 * 60
+const MinWebhookSecretLength = 16
+
+// Requests to hooks without their own limit can be as large as the server allows
+const MaxWebhookPayloadBytes = 50 * 1024 * 1024
+
+// Rejected webhook requests are counted in the org statistics by reason
+const WebhookRejectedStatPrefix = "webhook_rejected_"
+const (
+	WebhookRejectSignature   = "signature"
+	WebhookRejectReplay      = "replay"
+	WebhookRejectPayloadSize = "payload_size"
+)
+
+var webhookRejectReasons = []string{WebhookRejectSignature, WebhookRejectReplay, WebhookRejectPayloadSize}
+
+// Signatures are only verified for hooks with a secret. Only EncryptedSecret
+// is stored, and Secret is decrypted when the settings are read
+type CslWebhookSecurity struct {
+	Secret           string `json:"secret"`
+	EncryptedSecret  string `json:"encrypted_secret,omitempty"`
+	Scheme           string `json:"scheme"`
+	ToleranceSeconds int    `json:"tolerance_seconds"`
+	ReplayProtection bool   `json:"replay_protection"`
+	MaxPayloadBytes  int64  `json:"max_payload_bytes"`
+
+	// Set when the secret couldn't be decrypted. Requests are rejected
+	// instead of being let through without a signature
+	unavailable bool
+}
+
+// Nonces seen by this instance with when they expire, so concurrent replays
+// aren't both let through before the nonce is in the cache
+var cslWebhookNonces = struct {
+	sync.Mutex
+	nonces map[string]int64
+}{nonces: map[string]int64{}}
+
+type CslWebhookSecuritySettings struct {
+	Hooks map[string]CslWebhookSecurity `json:"hooks"`
+}
+
+func getCslWebhookSecurity(ctx context.Context, orgId string) CslWebhookSecuritySettings {
+	settings := CslWebhookSecuritySettings{}
+	_, err := getCslDocument(ctx, orgId, CslWebhookSecurityDocument, &settings)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting webhook security for org %s: %s", orgId, err)
+	}
+
+	if settings.Hooks == nil {
+		settings.Hooks = map[string]CslWebhookSecurity{}
+	}
+
+	plaintext := false
+	for hookId, security := range settings.Hooks {
+		if len(security.EncryptedSecret) == 0 {
+			plaintext = plaintext || len(security.Secret) > 0
+			continue
+		}
+
+		secret, err := shuffle.HandleKeyDecryption([]byte(security.EncryptedSecret), getWebhookSecretPassphrase(orgId, hookId))
+		if err != nil {
+			log.Printf("[ERROR] Failed decrypting the secret of webhook %s in org %s: %s", hookId, orgId, err)
+			security.unavailable = true
+		} else {
+			security.Secret = string(secret)
+			security.EncryptedSecret = ""
+		}
+
+		settings.Hooks[hookId] = security
+	}
+
+	// Secrets stored before they were encrypted
+	if plaintext {
+		_, err := migrateCslWebhookSecrets(ctx, orgId)
+		if err != nil {
+			log.Printf("[WARNING] Failed encrypting webhook secrets of org %s: %s", orgId, err)
+		}
+	}
+
+	return settings
+}
+
+// Secrets are bound to their org and hook, so they can't be moved to another
+func getWebhookSecretPassphrase(orgId, hookId string) string {
+	return fmt.Sprintf("%s_%s_webhook_secret", orgId, hookId)
+}
+
+// Returns the settings as they are stored, with every secret encrypted.
+// Secrets that couldn't be decrypted are kept as they are
+func encryptCslWebhookSecrets(orgId string, settings CslWebhookSecuritySettings) (CslWebhookSecuritySettings, error) {
+	hooks := map[string]CslWebhookSecurity{}
+	for hookId, security := range settings.Hooks {
+		if len(security.Secret) > 0 {
+			encrypted, err := shuffle.HandleKeyEncryption([]byte(security.Secret), getWebhookSecretPassphrase(orgId, hookId))
+			if err != nil {
+				return settings, err
+			}
+
+			security.Secret = ""
+			security.EncryptedSecret = string(encrypted)
+		}
+
+		hooks[hookId] = security
+	}
+
+	return CslWebhookSecuritySettings{Hooks: hooks}, nil
+}
+
+// Whether a stored secret is in plaintext, or isn't wrapped with the active
+// envelope encryption key
+func needsWebhookSecretMigration(security CslWebhookSecurity) bool {
+	if len(security.EncryptedSecret) == 0 {
+		return len(security.Secret) > 0
+	}
+
+	if !shuffle.IsEnvelopeEncryptionEnabled() {
+		return false
+	}
+
+	activeKms, activeKey, err := shuffle.GetEnvelopeActiveKey()
+	if err != nil {
+		return false
+	}
+
+	kms, keyId, ok := shuffle.GetEnvelopeKey([]byte(security.EncryptedSecret))
+	return !ok || kms != activeKms || keyId != activeKey
+}
+
+// Encrypts secrets stored in plaintext, and re-encrypts the ones that aren't
+// wrapped with the active envelope encryption key. Returns how many changed
+func migrateCslWebhookSecrets(ctx context.Context, orgId string) (int, error) {
+	migrated := 0
+	settings := CslWebhookSecuritySettings{}
+	err := updateCslDocument(ctx, orgId, CslWebhookSecurityDocument, &settings, func() error {
+		for hookId, security := range settings.Hooks {
+			if !needsWebhookSecretMigration(security) {
+				continue
+			}
+
+			secret := security.Secret
+			if len(security.EncryptedSecret) > 0 {
+				decrypted, err := shuffle.HandleKeyDecryption([]byte(security.EncryptedSecret), getWebhookSecretPassphrase(orgId, hookId))
+				if err != nil {
+					return err
+				}
+
+				secret = string(decrypted)
+			}
+
+			encrypted, err := shuffle.HandleKeyEncryption([]byte(secret), getWebhookSecretPassphrase(orgId, hookId))
+			if err != nil {
+				return err
+			}
+
+			security.Secret = ""
+			security.EncryptedSecret = string(encrypted)
+			settings.Hooks[hookId] = security
+			migrated += 1
+		}
+
+		return nil
+	})
+
+	return migrated, err
+}
+
+func redactCslWebhookSecurity(settings CslWebhookSecuritySettings) CslWebhookSecuritySettings {
+	hooks := map[string]CslWebhookSecurity{}
+	for hookId, security := range settings.Hooks {
+		if len(security.Secret) > 0 || len(security.EncryptedSecret) > 0 {
+			security.Secret = RedactedValue
+		}
+
+		security.EncryptedSecret = ""
+		hooks[hookId] = security
+	}
+
+	settings.Hooks = hooks
+	return settings
+}
+
+func (security CslWebhookSecurity) getTolerance() int64 {
+	if security.ToleranceSeconds == 0 {
+		return DefaultSignatureToleranceSeconds
+	}
+
+	return int64(security.ToleranceSeconds)
+}
+
+func validateCslWebhookSecurity(hookId string, security CslWebhookSecurity) error {
+	if len(security.Secret) > 0 && len(security.Secret) < MinWebhookSecretLength {
+		return errors.New(fmt.Sprintf("secret of hook %s must be at least %d characters", hookId, MinWebhookSecretLength))
+	}
+
+	if security.MaxPayloadBytes < 0 || security.MaxPayloadBytes > MaxWebhookPayloadBytes {
+		return errors.New(fmt.Sprintf("max_payload_bytes of hook %s must be between 0 and %d", hookId, MaxWebhookPayloadBytes))
+	}
+
+	if !shuffle.ArrayContains(signatureSchemes, security.Scheme) {
+		return errors.New(fmt.Sprintf("unknown scheme %s of hook %s. Use one of %s", security.Scheme, hookId, strings.Join(signatureSchemes, ", ")))
+	}
+
+	if security.ToleranceSeconds < 0 || security.ToleranceSeconds > MaxSignatureToleranceSeconds {
+		return errors.New(fmt.Sprintf("tolerance_seconds of hook %s must be between 0 and %d", hookId, MaxSignatureToleranceSeconds))
+	}
+
+	return nil
+}
+
+func getWebhookRejectedStatKey(reason string) string {
+	return fmt.Sprintf("%s%s", WebhookRejectedStatPrefix, reason)
+}
+
+func getWebhookHmac(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Returns the signed timestamp and signatures of a request in the scheme
+func getWebhookSignatures(request *http.Request, scheme string) (string, []string) {
+	if scheme == SignatureSchemeGithub {
+		return "", []string{strings.TrimPrefix(request.Header.Get("X-Hub-Signature-256"), "sha256=")}
+	}
+
+	if scheme == SignatureSchemeStripe {
+		timestamp := ""
+		signatures := []string{}
+		for _, item := range strings.Split(request.Header.Get("Stripe-Signature"), ",") {
+			parts := strings.SplitN(strings.TrimSpace(item), "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+
+			if parts[0] == "t" {
+				timestamp = parts[1]
+			} else if parts[0] == "v1" {
+				signatures = append(signatures, parts[1])
+			}
+		}
+
+		return timestamp, signatures
+	}
+
+	return request.Header.Get("X-Shuffle-Timestamp"), []string{strings.TrimPrefix(request.Header.Get("X-Shuffle-Signature"), "sha256=")}
+}
+
+func verifyWebhookSignature(request *http.Request, body []byte, security CslWebhookSecurity) error {
+	timestamp, signatures := getWebhookSignatures(request, security.Scheme)
+
+	payload := body
+	if security.Scheme != SignatureSchemeGithub {
+		err := checkWebhookTimestamp(timestamp, security.getTolerance())
+		if err != nil {
+			return err
+		}
+
+		payload = append([]byte(fmt.Sprintf("%s.", timestamp)), body...)
+	}
+
+	expected := getWebhookHmac(security.Secret, payload)
+	for _, signature := range signatures {
+		if len(signature) > 0 && hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+			return nil
+		}
+	}
+
+	return errors.New("invalid signature")
+}
+
+func checkWebhookTimestamp(timestamp string, tolerance int64) error {
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid request timestamp")
+	}
+
+	age := time.Now().Unix() - signedAt
+	if age > tolerance || age < -tolerance {
+		return errors.New("request timestamp is outside the tolerance")
+	}
+
+	return nil
+}
+
+func getWebhookNonce(request *http.Request, security CslWebhookSecurity) string {
+	if len(security.Secret) > 0 {
+		_, signatures := getWebhookSignatures(request, security.Scheme)
+		return strings.Join(signatures, ",")
+	}
+
+	for _, header := range []string{"X-Shuffle-Nonce", "X-GitHub-Delivery"} {
+		if nonce := request.Header.Get(header); len(nonce) > 0 {
+			return nonce
+		}
+	}
+
+	return ""
+}
+
+// Stores the nonce of the request for the tolerance, and returns an error
+// when it was already seen. Without a signature the timestamp is checked here
+func checkWebhookReplay(ctx context.Context, request *http.Request, hookId string, security CslWebhookSecurity) error {
+	if len(security.Secret) == 0 {
+		err := checkWebhookTimestamp(request.Header.Get("X-Shuffle-Timestamp"), security.getTolerance())
+		if err != nil {
+			return err
+		}
+	}
+
+	nonce := getWebhookNonce(request, security)
+	if len(nonce) == 0 {
+		return errors.New("missing request nonce")
+	}
+
+	hash := sha256.Sum256([]byte(nonce))
+	cacheKey := fmt.Sprintf("csl_webhook_nonce_%s_%s", hookId, hex.EncodeToString(hash[:]))
+
+	cslWebhookNonces.Lock()
+	defer cslWebhookNonces.Unlock()
+
+	timeNow := time.Now().Unix()
+	for key, expiresAt := range cslWebhookNonces.nonces {
+		if expiresAt < timeNow {
+			delete(cslWebhookNonces.nonces, key)
+		}
+	}
+
+	if _, ok := cslWebhookNonces.nonces[cacheKey]; ok {
+		return errors.New("request was already received")
+	}
+
+	_, err := shuffle.GetCache(ctx, cacheKey)
+	if err == nil {
+		return errors.New("request was already received")
+	}
+
+	// Twice the tolerance, as timestamps can be ahead as well
+	expiration := int32(security.getTolerance()*2/60 + 1)
+	err = shuffle.SetCache(ctx, cacheKey, []byte("1"), expiration)
+	if err != nil {
+		log.Printf("[WARNING] Failed storing nonce of webhook %s: %s", hookId, err)
+	}
+
+	cslWebhookNonces.nonces[cacheKey] = timeNow + int64(expiration)*60
+	return nil
+}
+
+func rejectCslWebhook(ctx context.Context, resp http.ResponseWriter, hook *shuffle.Hook, reason string, status int, err error) {
+	log.Printf("[WARNING] Rejected request to webhook %s in org %s (%s): %s", hook.Id, hook.OrgId, reason, err)
+	shuffle.IncrementCache(ctx, hook.OrgId, getWebhookRejectedStatKey(reason))
+
+	resp.WriteHeader(status)
+	resp.Write(createCslErrorResponse(err))
+}
+
+// Returns the hook ID of a webhook key (webhook_<id>). The webhook handler
+// and the wrappers around it have to agree on this, or the checks of the
+// wrappers can be skipped with a key the handler still accepts
+func getCslWebhookHookId(key string) (string, bool) {
+	if len(key) != 44 || !strings.HasPrefix(key, "webhook_") {
+		return "", false
+	}
+
+	return strings.TrimPrefix(key, "webhook_"), true
+}
+
+// Wraps the webhook handler to check the payload size, signature and nonce
+// of requests to hooks with security settings
+func cslWebhookSecurity(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		if request.Method == "OPTIONS" {
+			handler(resp, request)
+			return
+		}
+
+		hookId, ok := getCslWebhookHookId(mux.Vars(request)["key"])
+		if !ok {
+			resp.WriteHeader(401)
+			resp.Write([]byte(`{"success": false, "reason": "Hook ID not valid"}`))
+			return
+		}
+
+		ctx := shuffle.GetContext(request)
+		hook, err := shuffle.GetHook(ctx, hookId)
+		if err != nil || len(hook.OrgId) == 0 {
+			handler(resp, request)
+			return
+		}
+
+		security, ok := getCslWebhookSecurity(ctx, hook.OrgId).Hooks[hook.Id]
+		if !ok {
+			handler(resp, request)
+			return
+		}
+
+		maxPayload := security.MaxPayloadBytes
+		if maxPayload == 0 {
+			maxPayload = MaxWebhookPayloadBytes
+		}
+
+		if request.ContentLength > maxPayload {
+			rejectCslWebhook(ctx, resp, hook, WebhookRejectPayloadSize, 413, errors.New(fmt.Sprintf("payload is larger than %d bytes", maxPayload)))
+			return
+		}
+
+		body := []byte{}
+		if request.Body != nil {
+			body, err = ioutil.ReadAll(io.LimitReader(request.Body, maxPayload+1))
+			if err != nil {
+				resp.WriteHeader(400)
+				resp.Write(createCslErrorResponse(err))
+				return
+			}
+
+			if int64(len(body)) > maxPayload {
+				rejectCslWebhook(ctx, resp, hook, WebhookRejectPayloadSize, 413, errors.New(fmt.Sprintf("payload is larger than %d bytes", maxPayload)))
+				return
+			}
+
+			request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		if security.unavailable {
+			rejectCslWebhook(ctx, resp, hook, WebhookRejectSignature, 500, errors.New("the webhook secret can't be decrypted"))
+			return
+		}
+
+		if len(security.Secret) > 0 {
+			err = verifyWebhookSignature(request, body, security)
+			if err != nil {
+				rejectCslWebhook(ctx, resp, hook, WebhookRejectSignature, 401, err)
+				return
+			}
+		}
+
+		if security.ReplayProtection {
+			err = checkWebhookReplay(ctx, request, hook.Id, security)
+			if err != nil {
+				rejectCslWebhook(ctx, resp, hook, WebhookRejectReplay, 409, err)
+				return
+			}
+		}
+
+		handler(resp, request)
+	}
+}
+
+/*
+Webhook security:
+Returns the security settings of webhooks in the current organization.
+Requires org admin. Secrets are encrypted and redacted. Hooks with a secret require signed
+requests, where scheme is one of shuffle, stripe or github, and signatures
+older than tolerance_seconds (default 300) are rejected. The github scheme has
+no timestamp. With replay_protection a request nonce can only be used once,
+and max_payload_bytes limits the size of requests (default 50MB).
+
+	{
+	    "success": true,
+	    "data": {
+	        "hooks": {
+	            "8c2e...": {
+	                "secret": "********",
+	                "scheme": "shuffle",
+	                "tolerance_seconds": 300,
+	                "replay_protection": true,
+	                "max_payload_bytes": 1048576
+	            }
+	        }
+	    }
+	}
+*/
+func cslGetWebhookSecurity(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslWebhookSecurity(getCslWebhookSecurity(ctx, user.ActiveOrg.Id)),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetWebhookSecurity")
+}
+
+/*
+Webhook security:
+Sets the webhook security settings in the format returned from GET. Requires
+org admin. Secrets must be at least 16 characters, and redacted secrets are
+kept as they are. Hooks left out accept any request again.
+*/
+func cslSetWebhookSecurity(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	settings := CslWebhookSecuritySettings{}
+	err = json.Unmarshal(body, &settings)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if settings.Hooks == nil {
+		settings.Hooks = map[string]CslWebhookSecurity{}
+	}
+
+	previous := getCslWebhookSecurity(ctx, user.ActiveOrg.Id)
+	for hookId, security := range settings.Hooks {
+		hook, err := shuffle.GetHook(ctx, hookId)
+		if err != nil || hook.OrgId != user.ActiveOrg.Id {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("hook %s not found", hookId))))
+			return
+		}
+
+		security.EncryptedSecret = ""
+		if security.Secret == RedactedValue {
+			security.Secret = previous.Hooks[hookId].Secret
+			security.EncryptedSecret = previous.Hooks[hookId].EncryptedSecret
+		}
+
+		if len(security.Scheme) == 0 {
+			security.Scheme = SignatureSchemeShuffle
+		}
+
+		err = validateCslWebhookSecurity(hookId, security)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		settings.Hooks[hookId] = security
+	}
+
+	stored, err := encryptCslWebhookSecrets(user.ActiveOrg.Id, settings)
+	if err != nil {
+		log.Printf("[WARNING] Failed encrypting webhook secrets of org %s: %s", user.ActiveOrg.Id, err)
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("webhook secrets can't be encrypted: %s", err))))
+		return
+	}
+
+	err = setCslDocument(ctx, user.ActiveOrg.Id, CslWebhookSecurityDocument, stored)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) changed webhook security of org %s. Hooks: %d", user.Username, user.Id, user.ActiveOrg.Id, len(settings.Hooks))
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeSecurity, "webhook_security_changed", "Webhook security settings were changed", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    redactCslWebhookSecurity(settings),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetWebhookSecurity")
+}
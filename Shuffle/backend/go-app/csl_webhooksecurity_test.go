@@ -0,0 +1,349 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shuffle/shuffle-shared"
+)
+
+const testWebhookSecret = "0123456789abcdef0123"
+
+func TestGetWebhookHmac(t *testing.T) {
+	signature := getWebhookHmac("key", []byte("The quick brown fox jumps over the lazy dog"))
+	expected := "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if signature != expected {
+		t.Errorf("getWebhookHmac = %s, expected %s", signature, expected)
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"alert": "suspicious login"}`)
+	now := fmt.Sprintf("%d", time.Now().Unix())
+	old := fmt.Sprintf("%d", time.Now().Unix()-DefaultSignatureToleranceSeconds-10)
+	future := fmt.Sprintf("%d", time.Now().Unix()+DefaultSignatureToleranceSeconds+10)
+	sign := func(timestamp string, body []byte) string {
+		if len(timestamp) == 0 {
+			return getWebhookHmac(testWebhookSecret, body)
+		}
+
+		return getWebhookHmac(testWebhookSecret, append([]byte(timestamp+"."), body...))
+	}
+
+	tests := []struct {
+		name    string
+		scheme  string
+		headers map[string]string
+		body    []byte
+		err     string
+	}{
+		{
+			name:    "shuffle",
+			scheme:  SignatureSchemeShuffle,
+			headers: map[string]string{"X-Shuffle-Timestamp": now, "X-Shuffle-Signature": "sha256=" + sign(now, body)},
+		},
+		{
+			name:    "shuffle without prefix",
+			scheme:  SignatureSchemeShuffle,
+			headers: map[string]string{"X-Shuffle-Timestamp": now, "X-Shuffle-Signature": sign(now, body)},
+		},
+		{
+			name:    "shuffle uppercase hex",
+			scheme:  SignatureSchemeShuffle,
+			headers: map[string]string{"X-Shuffle-Timestamp": now, "X-Shuffle-Signature": "sha256=" + strings.ToUpper(sign(now, body))},
+		},
+		{
+			name:    "shuffle tampered body",
+			scheme:  SignatureSchemeShuffle,
+			headers: map[string]string{"X-Shuffle-Timestamp": now, "X-Shuffle-Signature": "sha256=" + sign(now, body)},
+			body:    []byte(`{"alert": "nothing to see"}`),
+			err:     "invalid signature",
+		},
+		{
+			name:    "shuffle wrong secret",
+			scheme:  SignatureSchemeShuffle,
+			headers: map[string]string{"X-Shuffle-Timestamp": now, "X-Shuffle-Signature": "sha256=" + getWebhookHmac("another secret of a hook", append([]byte(now+"."), body...))},
+			err:     "invalid signature",
+		},
+		{
+			name:    "shuffle missing signature",
+			scheme:  SignatureSchemeShuffle,
+			headers: map[string]string{"X-Shuffle-Timestamp": now},
+			err:     "invalid signature",
+		},
+		{
+			name:    "shuffle missing timestamp",
+			scheme:  SignatureSchemeShuffle,
+			headers: map[string]string{"X-Shuffle-Signature": "sha256=" + sign(now, body)},
+			err:     "missing or invalid request timestamp",
+		},
+		{
+			name:    "shuffle old timestamp",
+			scheme:  SignatureSchemeShuffle,
+			headers: map[string]string{"X-Shuffle-Timestamp": old, "X-Shuffle-Signature": "sha256=" + sign(old, body)},
+			err:     "request timestamp is outside the tolerance",
+		},
+		{
+			name:    "shuffle future timestamp",
+			scheme:  SignatureSchemeShuffle,
+			headers: map[string]string{"X-Shuffle-Timestamp": future, "X-Shuffle-Signature": "sha256=" + sign(future, body)},
+			err:     "request timestamp is outside the tolerance",
+		},
+		{
+			name:    "shuffle timestamp not signed",
+			scheme:  SignatureSchemeShuffle,
+			headers: map[string]string{"X-Shuffle-Timestamp": now, "X-Shuffle-Signature": "sha256=" + sign("", body)},
+			err:     "invalid signature",
+		},
+		{
+			name:    "stripe",
+			scheme:  SignatureSchemeStripe,
+			headers: map[string]string{"Stripe-Signature": fmt.Sprintf("t=%s,v1=%s", now, sign(now, body))},
+		},
+		{
+			name:    "stripe with rotated secrets",
+			scheme:  SignatureSchemeStripe,
+			headers: map[string]string{"Stripe-Signature": fmt.Sprintf("t=%s, v1=%s, v1=%s, v0=abc", now, strings.Repeat("0", 64), sign(now, body))},
+		},
+		{
+			name:    "stripe without v1",
+			scheme:  SignatureSchemeStripe,
+			headers: map[string]string{"Stripe-Signature": fmt.Sprintf("t=%s,v0=%s", now, sign(now, body))},
+			err:     "invalid signature",
+		},
+		{
+			name:    "stripe old timestamp",
+			scheme:  SignatureSchemeStripe,
+			headers: map[string]string{"Stripe-Signature": fmt.Sprintf("t=%s,v1=%s", old, sign(old, body))},
+			err:     "request timestamp is outside the tolerance",
+		},
+		{
+			name:    "github",
+			scheme:  SignatureSchemeGithub,
+			headers: map[string]string{"X-Hub-Signature-256": "sha256=" + sign("", body)},
+		},
+		{
+			name:    "github with timestamped signature",
+			scheme:  SignatureSchemeGithub,
+			headers: map[string]string{"X-Hub-Signature-256": "sha256=" + sign(now, body)},
+			err:     "invalid signature",
+		},
+		{
+			name:    "github missing signature",
+			scheme:  SignatureSchemeGithub,
+			headers: map[string]string{},
+			err:     "invalid signature",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest("POST", "/api/v1/hooks/webhook_1", nil)
+			for key, value := range test.headers {
+				request.Header.Set(key, value)
+			}
+
+			requestBody := body
+			if test.body != nil {
+				requestBody = test.body
+			}
+
+			err := verifyWebhookSignature(request, requestBody, CslWebhookSecurity{Secret: testWebhookSecret, Scheme: test.scheme})
+			if len(test.err) == 0 && err != nil {
+				t.Errorf("verifyWebhookSignature failed: %s", err)
+			}
+
+			if len(test.err) > 0 && (err == nil || err.Error() != test.err) {
+				t.Errorf("verifyWebhookSignature returned %v, expected %s", err, test.err)
+			}
+		})
+	}
+}
+
+func TestCheckWebhookTimestamp(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		timestamp string
+		tolerance int64
+		valid     bool
+	}{
+		{timestamp: fmt.Sprintf("%d", now), tolerance: 60, valid: true},
+		{timestamp: fmt.Sprintf("%d", now-50), tolerance: 60, valid: true},
+		{timestamp: fmt.Sprintf("%d", now+50), tolerance: 60, valid: true},
+		{timestamp: fmt.Sprintf("%d", now-70), tolerance: 60},
+		{timestamp: fmt.Sprintf("%d", now+70), tolerance: 60},
+		{timestamp: "", tolerance: 60},
+		{timestamp: "yesterday", tolerance: 60},
+	}
+
+	for _, test := range tests {
+		err := checkWebhookTimestamp(test.timestamp, test.tolerance)
+		if (err == nil) != test.valid {
+			t.Errorf("checkWebhookTimestamp(%q, %d) returned %v, expected valid=%t", test.timestamp, test.tolerance, err, test.valid)
+		}
+	}
+}
+
+func TestGetCslWebhookHookId(t *testing.T) {
+	hookId := "0b7a1c2e-3d4f-4a5b-8c6d-7e8f9a0b1c2d"
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{key: "webhook_" + hookId, valid: true},
+		{key: "XXXXXXXX" + hookId},
+		{key: "webhook-" + hookId},
+		{key: hookId},
+		{key: "webhook_" + hookId + "0"},
+		{key: ""},
+	}
+
+	for _, test := range tests {
+		result, ok := getCslWebhookHookId(test.key)
+		if ok != test.valid || (ok && result != hookId) {
+			t.Errorf("getCslWebhookHookId(%q) returned %q, %t, expected valid=%t", test.key, result, ok, test.valid)
+		}
+	}
+}
+
+func TestCslWebhookSecurityBadPrefix(t *testing.T) {
+	called := false
+	handler := cslWebhookSecurity(func(resp http.ResponseWriter, request *http.Request) {
+		called = true
+	})
+
+	// The webhook handler used to accept any 8 character prefix
+	request := httptest.NewRequest("POST", "/api/v1/hooks/XXXXXXXX0b7a1c2e-3d4f-4a5b-8c6d-7e8f9a0b1c2d", strings.NewReader("{}"))
+	request = mux.SetURLVars(request, map[string]string{"key": "XXXXXXXX0b7a1c2e-3d4f-4a5b-8c6d-7e8f9a0b1c2d"})
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+
+	if called || recorder.Code != 401 {
+		t.Errorf("got status %d and called=%t, expected the request to be rejected", recorder.Code, called)
+	}
+}
+
+func TestValidateCslWebhookSecurity(t *testing.T) {
+	tests := []struct {
+		name     string
+		security CslWebhookSecurity
+		valid    bool
+	}{
+		{name: "without secret", security: CslWebhookSecurity{Scheme: SignatureSchemeShuffle, ReplayProtection: true}, valid: true},
+		{name: "with secret", security: CslWebhookSecurity{Secret: testWebhookSecret, Scheme: SignatureSchemeGithub, ToleranceSeconds: MaxSignatureToleranceSeconds, MaxPayloadBytes: MaxWebhookPayloadBytes}, valid: true},
+		{name: "short secret", security: CslWebhookSecurity{Secret: "short", Scheme: SignatureSchemeShuffle}},
+		{name: "unknown scheme", security: CslWebhookSecurity{Scheme: "slack"}},
+		{name: "empty scheme", security: CslWebhookSecurity{}},
+		{name: "negative payload limit", security: CslWebhookSecurity{Scheme: SignatureSchemeShuffle, MaxPayloadBytes: -1}},
+		{name: "payload limit too large", security: CslWebhookSecurity{Scheme: SignatureSchemeShuffle, MaxPayloadBytes: MaxWebhookPayloadBytes + 1}},
+		{name: "negative tolerance", security: CslWebhookSecurity{Scheme: SignatureSchemeStripe, ToleranceSeconds: -1}},
+		{name: "tolerance too large", security: CslWebhookSecurity{Scheme: SignatureSchemeStripe, ToleranceSeconds: MaxSignatureToleranceSeconds + 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateCslWebhookSecurity("webhook_1", test.security)
+			if (err == nil) != test.valid {
+				t.Errorf("validateCslWebhookSecurity returned %v, expected valid=%t", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestGetWebhookNonce(t *testing.T) {
+	request := httptest.NewRequest("POST", "/api/v1/hooks/webhook_1", nil)
+	request.Header.Set("X-Shuffle-Signature", "sha256=abc")
+	request.Header.Set("X-Shuffle-Nonce", "nonce")
+	request.Header.Set("X-GitHub-Delivery", "delivery")
+
+	tests := []struct {
+		name     string
+		security CslWebhookSecurity
+		expected string
+	}{
+		{name: "signature", security: CslWebhookSecurity{Secret: testWebhookSecret, Scheme: SignatureSchemeShuffle}, expected: "abc"},
+		{name: "nonce header", security: CslWebhookSecurity{Scheme: SignatureSchemeShuffle}, expected: "nonce"},
+	}
+
+	for _, test := range tests {
+		nonce := getWebhookNonce(request, test.security)
+		if nonce != test.expected {
+			t.Errorf("%s: got nonce %q, expected %q", test.name, nonce, test.expected)
+		}
+	}
+
+	request.Header.Del("X-Shuffle-Nonce")
+	if nonce := getWebhookNonce(request, CslWebhookSecurity{}); nonce != "delivery" {
+		t.Errorf("got nonce %q, expected the GitHub delivery id", nonce)
+	}
+
+	request.Header.Del("X-GitHub-Delivery")
+	if nonce := getWebhookNonce(request, CslWebhookSecurity{}); nonce != "" {
+		t.Errorf("got nonce %q, expected none", nonce)
+	}
+}
+
+func TestRedactCslWebhookSecurity(t *testing.T) {
+	settings := CslWebhookSecuritySettings{Hooks: map[string]CslWebhookSecurity{
+		"plain":     {Secret: testWebhookSecret, Scheme: SignatureSchemeShuffle},
+		"encrypted": {EncryptedSecret: "ciphertext", Scheme: SignatureSchemeShuffle},
+		"none":      {Scheme: SignatureSchemeShuffle, ReplayProtection: true},
+	}}
+
+	redacted := redactCslWebhookSecurity(settings)
+	for hookId, expected := range map[string]string{"plain": RedactedValue, "encrypted": RedactedValue, "none": ""} {
+		security := redacted.Hooks[hookId]
+		if security.Secret != expected || len(security.EncryptedSecret) > 0 {
+			t.Errorf("hook %s was redacted to %+v", hookId, security)
+		}
+	}
+
+	if settings.Hooks["plain"].Secret != testWebhookSecret {
+		t.Errorf("redacting changed the original settings")
+	}
+}
+
+func TestEncryptCslWebhookSecrets(t *testing.T) {
+	t.Setenv("SHUFFLE_ENCRYPTION_MODIFIER", "webhook-test-modifier")
+
+	settings := CslWebhookSecuritySettings{Hooks: map[string]CslWebhookSecurity{
+		"webhook_1": {Secret: testWebhookSecret, Scheme: SignatureSchemeShuffle},
+		"webhook_2": {Scheme: SignatureSchemeShuffle},
+	}}
+
+	encrypted, err := encryptCslWebhookSecrets("org", settings)
+	if err != nil {
+		t.Fatalf("encryptCslWebhookSecrets failed: %s", err)
+	}
+
+	security := encrypted.Hooks["webhook_1"]
+	if len(security.Secret) > 0 || len(security.EncryptedSecret) == 0 || strings.Contains(security.EncryptedSecret, testWebhookSecret) {
+		t.Fatalf("secret wasn't encrypted: %+v", security)
+	}
+
+	if !needsWebhookSecretMigration(settings.Hooks["webhook_1"]) || needsWebhookSecretMigration(security) {
+		t.Errorf("only the plaintext secret should need migration")
+	}
+
+	if encrypted.Hooks["webhook_2"] != settings.Hooks["webhook_2"] {
+		t.Errorf("hook without secret was changed to %+v", encrypted.Hooks["webhook_2"])
+	}
+
+	decrypted, err := shuffle.HandleKeyDecryption([]byte(security.EncryptedSecret), getWebhookSecretPassphrase("org", "webhook_1"))
+	if err != nil || string(decrypted) != testWebhookSecret {
+		t.Errorf("decrypting the secret returned %q, %v", decrypted, err)
+	}
+
+	// The secret is bound to its org and hook
+	for _, passphrase := range []string{getWebhookSecretPassphrase("org", "webhook_2"), getWebhookSecretPassphrase("other", "webhook_1")} {
+		decrypted, err = shuffle.HandleKeyDecryption([]byte(security.EncryptedSecret), passphrase)
+		if err == nil && string(decrypted) == testWebhookSecret {
+			t.Errorf("secret was decrypted with the passphrase %s", passphrase)
+		}
+	}
+}
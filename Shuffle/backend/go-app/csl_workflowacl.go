@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Per-workflow sharing, so sensitive playbooks aren't visible to every
+// member of a large org. The ACLs themselves are in shuffle-shared
+// (workflow-acl.go), where workflow lists are filtered. Requests for a single
+// workflow are checked here before they reach the handler.
+
+const MaxWorkflowAclUsers = 500
+
+const (
+	workflowAclView       = "view"
+	workflowAclExecute    = "execute"
+	workflowAclExecutions = "executions"
+	workflowAclEdit       = "edit"
+	workflowAclManage     = "manage"
+)
+
+type CslWorkflowAcl struct {
+	Restricted bool                   `json:"restricted"`
+	Acl        shuffle.WorkflowAcl    `json:"acl"`
+	Access     shuffle.WorkflowAccess `json:"access"`
+}
+
+// Returns the workflow a request is for and what access it needs, or an
+// empty id for requests that aren't for a single workflow
+func getWorkflowAclRequirement(request *http.Request) (string, string) {
+	parts := strings.Split(strings.TrimRight(request.URL.Path, "/"), "/")
+
+	// Workflow ids are UUIDs, which tells them apart from paths like
+	// /api/v1/workflows/search
+	if len(parts) >= 5 && parts[1] == "api" && parts[3] == "workflows" && len(parts[4]) == 36 {
+		workflowId := parts[4]
+		read := request.Method == "GET"
+		rest := parts[5:]
+
+		if len(rest) == 0 {
+			if read {
+				return workflowId, workflowAclView
+			} else if request.Method == "DELETE" {
+				return workflowId, workflowAclManage
+			}
+
+			return workflowId, workflowAclEdit
+		}
+
+		switch rest[0] {
+		case "execute", "run":
+			return workflowId, workflowAclExecute
+		case "executions":
+			if len(rest) >= 3 && (rest[2] == "abort" || rest[2] == "rerun") {
+				return workflowId, workflowAclExecute
+			}
+
+			return workflowId, workflowAclExecutions
+		}
+
+		if read {
+			return workflowId, workflowAclView
+		}
+
+		return workflowId, workflowAclEdit
+	}
+
+	if strings.HasPrefix(request.URL.Path, "/api/v1/csl/") && !strings.HasPrefix(request.URL.Path, "/api/v1/csl/workflowAcl") {
+		workflowId := request.URL.Query().Get("workflow_id")
+		if len(workflowId) == 0 {
+			return "", ""
+		}
+
+		if request.Method == "GET" {
+			return workflowId, workflowAclView
+		}
+
+		return workflowId, workflowAclEdit
+	}
+
+	return "", ""
+}
+
+func hasWorkflowAclAccess(access shuffle.WorkflowAccess, required string) bool {
+	switch required {
+	case workflowAclView:
+		return access.View
+	case workflowAclExecute:
+		return access.Execute
+	case workflowAclExecutions:
+		return access.View || access.Execute
+	case workflowAclEdit:
+		return access.Edit
+	case workflowAclManage:
+		return access.Manage
+	}
+
+	return false
+}
+
+// Denies requests for restricted workflows from users not in their ACL
+func cslWorkflowAclMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, request *http.Request) {
+		if request.Method == "OPTIONS" {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		workflowId, required := getWorkflowAclRequirement(request)
+		if len(workflowId) == 0 {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		user, err := getMiddlewareUser(resp, request)
+		if err != nil || len(user.ActiveOrg.Id) == 0 {
+			next.ServeHTTP(resp, request)
+			return
+		}
+
+		ctx := shuffle.GetContext(request)
+		org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+		if err != nil {
+			log.Printf("[WARNING] Failed getting org %s to check access to workflow %s: %s", user.ActiveOrg.Id, workflowId, err)
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(errors.New("failed checking access to the workflow")))
+			return
+		}
+
+		access := shuffle.GetWorkflowAccess(org, user, workflowId)
+		if !hasWorkflowAclAccess(access, required) {
+			log.Printf("[WARNING] User %s (%s) doesn't have %s access to workflow %s in org %s", user.Username, user.Id, required, workflowId, org.Id)
+			resp.WriteHeader(403)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("you don't have %s access to this workflow", required))))
+			return
+		}
+
+		next.ServeHTTP(resp, request)
+	})
+}
+
+// Returns the workflow if it's in the users' active org
+func getCslAclWorkflow(request *http.Request, user *shuffle.User, workflowId string) (*shuffle.Workflow, error) {
+	if len(workflowId) == 0 {
+		return nil, errors.New("workflow_id is required")
+	}
+
+	workflow, err := shuffle.GetWorkflow(shuffle.GetContext(request), workflowId)
+	if err != nil || workflow.OrgId != user.ActiveOrg.Id {
+		return nil, errors.New(fmt.Sprintf("workflow %s not found", workflowId))
+	}
+
+	return workflow, nil
+}
+
+func validateWorkflowAcl(org *shuffle.Org, acl shuffle.WorkflowAcl) error {
+	members := map[string]bool{}
+	for _, orgUser := range org.Users {
+		members[orgUser.Id] = true
+	}
+
+	if !members[acl.Owner] {
+		return errors.New(fmt.Sprintf("owner %s isn't a member of the organization", acl.Owner))
+	}
+
+	if len(acl.Editors)+len(acl.Viewers)+len(acl.Executors) > MaxWorkflowAclUsers {
+		return errors.New(fmt.Sprintf("a workflow can be shared with at most %d users", MaxWorkflowAclUsers))
+	}
+
+	for _, userIds := range [][]string{acl.Editors, acl.Viewers, acl.Executors} {
+		for _, userId := range userIds {
+			if !members[userId] {
+				return errors.New(fmt.Sprintf("user %s isn't a member of the organization", userId))
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+Workflow access:
+Returns who can access a workflow and what the current user can do with it.
+Workflows that aren't restricted are available to everyone in the org.
+
+	{"success": true, "data": {"restricted": true, "acl": {"workflow_id": "<workflow id>", "owner": "<user id>", "editors": [], "viewers": ["<user id>"], "executors": [], "updated_by": "admin", "updated": 1700000000}, "access": {"view": true, "execute": true, "edit": true, "manage": true}}}
+*/
+func cslGetWorkflowAcl(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	workflow, err := getCslAclWorkflow(request, user, request.URL.Query().Get("workflow_id"))
+	if err != nil {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	access := shuffle.GetWorkflowAccess(org, *user, workflow.ID)
+	if !access.View && !access.Execute {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("you don't have access to this workflow")))
+		return
+	}
+
+	acl, restricted := shuffle.GetWorkflowAcl(org, workflow.ID)
+	if !restricted {
+		acl = shuffle.WorkflowAcl{
+			WorkflowId: workflow.ID,
+			Owner:      workflow.Owner,
+			Editors:    []string{},
+			Viewers:    []string{},
+			Executors:  []string{},
+		}
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data: CslWorkflowAcl{
+			Restricted: restricted,
+			Acl:        acl,
+			Access:     access,
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetWorkflowAcl")
+}
+
+/*
+Workflow access:
+Restricts a workflow to its owner, editors, viewers and executors, or
+changes who they are. Executors can run the workflow without seeing how it's
+built. Requires org admin or the owner of the workflow. The owner defaults
+to the creator of the workflow.
+
+	{"workflow_id": "<workflow id>", "owner": "<user id>", "editors": ["<user id>"], "viewers": [], "executors": ["<user id>"]}
+*/
+func cslSetWorkflowAcl(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	acl := shuffle.WorkflowAcl{}
+	err = json.Unmarshal(body, &acl)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	workflow, err := getCslAclWorkflow(request, user, acl.WorkflowId)
+	if err != nil {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if !shuffle.GetWorkflowAccess(org, *user, workflow.ID).Manage {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("only the owner of the workflow and org admins can change who has access")))
+		return
+	}
+
+	if len(acl.Owner) == 0 {
+		acl.Owner = workflow.Owner
+		if existing, found := shuffle.GetWorkflowAcl(org, workflow.ID); found {
+			acl.Owner = existing.Owner
+		}
+	}
+
+	if acl.Editors == nil {
+		acl.Editors = []string{}
+	}
+
+	if acl.Viewers == nil {
+		acl.Viewers = []string{}
+	}
+
+	if acl.Executors == nil {
+		acl.Executors = []string{}
+	}
+
+	err = validateWorkflowAcl(org, acl)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	acl.WorkflowId = workflow.ID
+	acl.UpdatedBy = user.Username
+	acl.Updated = time.Now().Unix()
+
+	found := false
+	for index, existing := range org.WorkflowAcls {
+		if existing.WorkflowId == workflow.ID {
+			org.WorkflowAcls[index] = acl
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		org.WorkflowAcls = append(org.WorkflowAcls, acl)
+	}
+
+	err = shuffle.SetOrg(ctx, *org, org.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) restricted workflow %s (%s) in org %s. Owner: %s, editors: %d, viewers: %d, executors: %d", user.Username, user.Id, workflow.Name, workflow.ID, org.Id, acl.Owner, len(acl.Editors), len(acl.Viewers), len(acl.Executors))
+	recordCslActivity(ctx, org.Id, ActivityTypeSecurity, "workflow_acl_changed", fmt.Sprintf("Access to workflow %s was changed", workflow.Name), user.Username, workflow.ID)
+
+	res := CslResponse{
+		Success: true,
+		Data: CslWorkflowAcl{
+			Restricted: true,
+			Acl:        acl,
+			Access:     shuffle.GetWorkflowAccess(org, *user, workflow.ID),
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetWorkflowAcl")
+}
+
+/*
+Workflow access:
+Removes the ACL of a workflow, making it available to everyone in the org
+again. Requires org admin or the owner of the workflow.
+
+	DELETE /api/v1/csl/workflowAcl?workflow_id=<workflow id>
+*/
+func cslDeleteWorkflowAcl(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	workflow, err := getCslAclWorkflow(request, user, request.URL.Query().Get("workflow_id"))
+	if err != nil {
+		resp.WriteHeader(404)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	org, err := shuffle.GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	if !shuffle.GetWorkflowAccess(org, *user, workflow.ID).Manage {
+		resp.WriteHeader(403)
+		resp.Write(createCslErrorResponse(errors.New("only the owner of the workflow and org admins can change who has access")))
+		return
+	}
+
+	acls := []shuffle.WorkflowAcl{}
+	for _, acl := range org.WorkflowAcls {
+		if acl.WorkflowId != workflow.ID {
+			acls = append(acls, acl)
+		}
+	}
+
+	if len(acls) != len(org.WorkflowAcls) {
+		org.WorkflowAcls = acls
+		err = shuffle.SetOrg(ctx, *org, org.Id)
+		if err != nil {
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		log.Printf("[AUDIT] User %s (%s) removed the restrictions of workflow %s (%s) in org %s", user.Username, user.Id, workflow.Name, workflow.ID, org.Id)
+		recordCslActivity(ctx, org.Id, ActivityTypeSecurity, "workflow_acl_removed", fmt.Sprintf("Workflow %s is available to everyone in the organization", workflow.Name), user.Username, workflow.ID)
+	}
+
+	res := CslResponse{
+		Success: true,
+		Reason:  fmt.Sprintf("Workflow %s is available to everyone in the organization", workflow.Name),
+	}
+
+	marshalAndWriteResponse(resp, res, "cslDeleteWorkflowAcl")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const testWorkflowId = "3b2f9a4e-6c1d-4f0e-9a8b-7c6d5e4f3a2b"
+
+func TestGetWorkflowAclRequirement(t *testing.T) {
+	tests := []struct {
+		method     string
+		path       string
+		workflowId string
+		required   string
+	}{
+		{method: "GET", path: "/api/v1/workflows/" + testWorkflowId, workflowId: testWorkflowId, required: workflowAclView},
+		{method: "GET", path: "/api/v1/workflows/" + testWorkflowId + "/", workflowId: testWorkflowId, required: workflowAclView},
+		{method: "PUT", path: "/api/v1/workflows/" + testWorkflowId, workflowId: testWorkflowId, required: workflowAclEdit},
+		{method: "DELETE", path: "/api/v1/workflows/" + testWorkflowId, workflowId: testWorkflowId, required: workflowAclManage},
+		{method: "POST", path: "/api/v1/workflows/" + testWorkflowId + "/execute", workflowId: testWorkflowId, required: workflowAclExecute},
+		{method: "GET", path: "/api/v1/workflows/" + testWorkflowId + "/run", workflowId: testWorkflowId, required: workflowAclExecute},
+		{method: "GET", path: "/api/v1/workflows/" + testWorkflowId + "/executions", workflowId: testWorkflowId, required: workflowAclExecutions},
+		{method: "GET", path: "/api/v1/workflows/" + testWorkflowId + "/executions/abc/abort", workflowId: testWorkflowId, required: workflowAclExecute},
+		{method: "POST", path: "/api/v1/workflows/" + testWorkflowId + "/executions/abc/rerun", workflowId: testWorkflowId, required: workflowAclExecute},
+		{method: "GET", path: "/api/v1/workflows/" + testWorkflowId + "/revisions", workflowId: testWorkflowId, required: workflowAclView},
+		{method: "POST", path: "/api/v1/workflows/" + testWorkflowId + "/schedule", workflowId: testWorkflowId, required: workflowAclEdit},
+		{method: "GET", path: "/api/v2/workflows/" + testWorkflowId, workflowId: testWorkflowId, required: workflowAclView},
+		{method: "GET", path: "/api/v1/workflows/search"},
+		{method: "GET", path: "/api/v1/workflows"},
+		{method: "GET", path: "/api/v1/csl/workflowVersions?workflow_id=" + testWorkflowId, workflowId: testWorkflowId, required: workflowAclView},
+		{method: "POST", path: "/api/v1/csl/workflowVersions?workflow_id=" + testWorkflowId, workflowId: testWorkflowId, required: workflowAclEdit},
+		{method: "GET", path: "/api/v1/csl/workflows"},
+		{method: "POST", path: "/api/v1/csl/workflowAcl?workflow_id=" + testWorkflowId},
+		{method: "GET", path: "/api/v1/apps?workflow_id=" + testWorkflowId},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.path, nil)
+		workflowId, required := getWorkflowAclRequirement(request)
+		if workflowId != test.workflowId || required != test.required {
+			t.Errorf("%s %s = %q, %q, expected %q, %q", test.method, test.path, workflowId, required, test.workflowId, test.required)
+		}
+	}
+}
+
+func TestHasWorkflowAclAccess(t *testing.T) {
+	viewer := shuffle.WorkflowAccess{View: true}
+	executor := shuffle.WorkflowAccess{Execute: true}
+	editor := shuffle.WorkflowAccess{View: true, Execute: true, Edit: true}
+	owner := shuffle.WorkflowAccess{View: true, Execute: true, Edit: true, Manage: true}
+
+	tests := []struct {
+		name     string
+		access   shuffle.WorkflowAccess
+		allowed  []string
+		required []string
+	}{
+		{name: "none", access: shuffle.WorkflowAccess{}},
+		{name: "viewer", access: viewer, allowed: []string{workflowAclView, workflowAclExecutions}},
+		{name: "executor", access: executor, allowed: []string{workflowAclExecute, workflowAclExecutions}},
+		{name: "editor", access: editor, allowed: []string{workflowAclView, workflowAclExecute, workflowAclExecutions, workflowAclEdit}},
+		{name: "owner", access: owner, allowed: []string{workflowAclView, workflowAclExecute, workflowAclExecutions, workflowAclEdit, workflowAclManage}},
+	}
+
+	for _, test := range tests {
+		for _, required := range []string{workflowAclView, workflowAclExecute, workflowAclExecutions, workflowAclEdit, workflowAclManage, "unknown"} {
+			expected := shuffle.ArrayContains(test.allowed, required)
+			if hasWorkflowAclAccess(test.access, required) != expected {
+				t.Errorf("%s with %s access = %t, expected %t", test.name, required, !expected, expected)
+			}
+		}
+	}
+}
+
+func TestValidateWorkflowAcl(t *testing.T) {
+	org := &shuffle.Org{Users: []shuffle.User{{Id: "u1"}, {Id: "u2"}, {Id: "u3"}}}
+
+	tooMany := []string{}
+	for i := 0; i <= MaxWorkflowAclUsers; i++ {
+		tooMany = append(tooMany, "u2")
+	}
+
+	tests := []struct {
+		name string
+		acl  shuffle.WorkflowAcl
+		err  string
+	}{
+		{name: "valid", acl: shuffle.WorkflowAcl{Owner: "u1", Editors: []string{"u2"}, Viewers: []string{"u3"}, Executors: []string{"u3"}}},
+		{name: "owner only", acl: shuffle.WorkflowAcl{Owner: "u1"}},
+		{name: "owner outside org", acl: shuffle.WorkflowAcl{Owner: "outsider"}, err: "owner outsider"},
+		{name: "missing owner", acl: shuffle.WorkflowAcl{Editors: []string{"u2"}}, err: "owner  isn't"},
+		{name: "viewer outside org", acl: shuffle.WorkflowAcl{Owner: "u1", Viewers: []string{"outsider"}}, err: "user outsider"},
+		{name: "executor outside org", acl: shuffle.WorkflowAcl{Owner: "u1", Executors: []string{"u2", "outsider"}}, err: "user outsider"},
+		{name: "too many users", acl: shuffle.WorkflowAcl{Owner: "u1", Viewers: tooMany}, err: "at most"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateWorkflowAcl(org, test.acl)
+			if len(test.err) == 0 && err != nil {
+				t.Errorf("validateWorkflowAcl failed: %s", err)
+			}
+
+			if len(test.err) > 0 && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("validateWorkflowAcl returned %v, expected an error with %q", err, test.err)
+			}
+		})
+	}
+}
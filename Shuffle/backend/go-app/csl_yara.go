@@ -0,0 +1,667 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const CslYaraDocument = "yara"
+
+const MaxYaraRulesets = 50
+const MaxYaraRulesetBytes = 256 * 1024
+
+// Max size of files scanned with YARA
+const MaxYaraScanBytes = 64 * 1024 * 1024
+
+var yaraRulesetNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ScanArtifacts scans every artifact uploaded to the artifact store with the
+// enabled rulesets
+type CslYaraConfig struct {
+	ScanArtifacts bool `json:"scan_artifacts"`
+}
+
+type CslYaraRuleset struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Source      string   `json:"source"`
+	Enabled     bool     `json:"enabled"`
+	Rules       []string `json:"rules"`
+	Updated     int64    `json:"updated"`
+	UpdatedBy   string   `json:"updated_by"`
+}
+
+type CslYaraRuleStats struct {
+	Ruleset   string `json:"ruleset"`
+	Rule      string `json:"rule"`
+	Matches   int64  `json:"matches"`
+	LastMatch int64  `json:"last_match"`
+}
+
+// Rules is keyed by <ruleset>:<rule>
+type CslYaraStats struct {
+	Scans        int64                       `json:"scans"`
+	MatchedScans int64                       `json:"matched_scans"`
+	LastScan     int64                       `json:"last_scan"`
+	Rules        map[string]CslYaraRuleStats `json:"rules"`
+}
+
+type CslYara struct {
+	Config   CslYaraConfig             `json:"config"`
+	Rulesets map[string]CslYaraRuleset `json:"rulesets"`
+	Stats    CslYaraStats              `json:"stats"`
+}
+
+type CslYaraScanResult struct {
+	Filename string         `json:"filename"`
+	Sha256   string         `json:"sha256"`
+	Size     int            `json:"size"`
+	Rulesets []string       `json:"rulesets"`
+	Matches  []CslYaraMatch `json:"matches"`
+}
+
+func getCslYara(ctx context.Context, orgId string) CslYara {
+	yara := CslYara{}
+	_, err := getCslDocument(ctx, orgId, CslYaraDocument, &yara)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting yara rules for org %s: %s", orgId, err)
+	}
+
+	if yara.Rulesets == nil {
+		yara.Rulesets = map[string]CslYaraRuleset{}
+	}
+
+	if yara.Stats.Rules == nil {
+		yara.Stats.Rules = map[string]CslYaraRuleStats{}
+	}
+
+	return yara
+}
+
+// Compiles the source and returns the names of its rules
+func validateCslYaraRuleset(ruleset CslYaraRuleset) ([]string, error) {
+	if !yaraRulesetNamePattern.MatchString(ruleset.Name) {
+		return nil, errors.New("name must be 1-64 letters, numbers, _ or -")
+	}
+
+	if len(ruleset.Source) > MaxYaraRulesetBytes {
+		return nil, errors.New(fmt.Sprintf("source can't be larger than %d bytes", MaxYaraRulesetBytes))
+	}
+
+	rules, err := compileYaraRules(ruleset.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, rule := range rules.Rules {
+		names = append(names, rule.Name)
+	}
+
+	return names, nil
+}
+
+// Scans data with the enabled rulesets, or the named ones if any are given
+func scanCslYara(yara CslYara, rulesetNames []string, data []byte) (CslYaraScanResult, error) {
+	result := CslYaraScanResult{
+		Sha256:   fmt.Sprintf("%x", sha256.Sum256(data)),
+		Size:     len(data),
+		Rulesets: []string{},
+		Matches:  []CslYaraMatch{},
+	}
+
+	for _, name := range rulesetNames {
+		if _, ok := yara.Rulesets[name]; !ok {
+			return result, errors.New(fmt.Sprintf("ruleset %s not found", name))
+		}
+	}
+
+	for _, ruleset := range yara.Rulesets {
+		if (len(rulesetNames) == 0 && !ruleset.Enabled) || (len(rulesetNames) > 0 && !shuffle.ArrayContains(rulesetNames, ruleset.Name)) {
+			continue
+		}
+
+		rules, err := compileYaraRules(ruleset.Source)
+		if err != nil {
+			return result, errors.New(fmt.Sprintf("ruleset %s doesn't compile: %s", ruleset.Name, err))
+		}
+
+		for _, match := range rules.scan(data) {
+			match.Ruleset = ruleset.Name
+			result.Matches = append(result.Matches, match)
+		}
+
+		result.Rulesets = append(result.Rulesets, ruleset.Name)
+	}
+
+	sort.Strings(result.Rulesets)
+	sort.SliceStable(result.Matches, func(i, j int) bool {
+		if result.Matches[i].Ruleset != result.Matches[j].Ruleset {
+			return result.Matches[i].Ruleset < result.Matches[j].Ruleset
+		}
+
+		return result.Matches[i].Rule < result.Matches[j].Rule
+	})
+
+	return result, nil
+}
+
+func recordCslYaraScan(ctx context.Context, orgId string, matches []CslYaraMatch) {
+	now := time.Now().Unix()
+	yara := CslYara{}
+	err := updateCslDocument(ctx, orgId, CslYaraDocument, &yara, func() error {
+		if yara.Stats.Rules == nil {
+			yara.Stats.Rules = map[string]CslYaraRuleStats{}
+		}
+
+		yara.Stats.Scans += 1
+		yara.Stats.LastScan = now
+		if len(matches) > 0 {
+			yara.Stats.MatchedScans += 1
+		}
+
+		for _, match := range matches {
+			key := fmt.Sprintf("%s:%s", match.Ruleset, match.Rule)
+			stats := yara.Stats.Rules[key]
+			stats.Ruleset = match.Ruleset
+			stats.Rule = match.Rule
+			stats.Matches += 1
+			stats.LastMatch = now
+			yara.Stats.Rules[key] = stats
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARNING] Failed recording yara scan for org %s: %s", orgId, err)
+	}
+}
+
+func getYaraMatchNames(matches []CslYaraMatch) []string {
+	names := []string{}
+	for _, match := range matches {
+		names = append(names, fmt.Sprintf("%s:%s", match.Ruleset, match.Rule))
+	}
+
+	return names
+}
+
+// Scans an artifact before it's stored when artifact scanning is enabled.
+// Returns the matching rules as <ruleset>:<rule>
+func scanCslArtifact(ctx context.Context, orgId string, contents []byte) []string {
+	yara := getCslYara(ctx, orgId)
+	if !yara.Config.ScanArtifacts || len(contents) > MaxYaraScanBytes {
+		return []string{}
+	}
+
+	result, err := scanCslYara(yara, []string{}, contents)
+	if err != nil {
+		log.Printf("[WARNING] Failed scanning artifact for org %s: %s", orgId, err)
+		return []string{}
+	}
+
+	recordCslYaraScan(ctx, orgId, result.Matches)
+	return getYaraMatchNames(result.Matches)
+}
+
+/*
+YARA:
+Returns the YARA rulesets of the current organization.
+
+	{
+	    "success": true,
+	    "data": [
+	        {
+	            "name": "ransomware",
+	            "description": "Curated ransomware rules",
+	            "source": "rule ...",
+	            "enabled": true,
+	            "rules": ["ransom_note", "lockbit_loader"],
+	            "updated": 1700000000,
+	            "updated_by": "admin@example.com"
+	        }
+	    ]
+	}
+*/
+func cslListYaraRulesets(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	rulesets := []CslYaraRuleset{}
+	for _, ruleset := range getCslYara(ctx, user.ActiveOrg.Id).Rulesets {
+		rulesets = append(rulesets, ruleset)
+	}
+
+	sort.SliceStable(rulesets, func(i, j int) bool {
+		return rulesets[i].Name < rulesets[j].Name
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data:    rulesets,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslListYaraRulesets")
+}
+
+/*
+YARA:
+Creates or replaces a YARA ruleset by name. Requires org admin. The source is
+compiled before it's stored, and compile errors include the line number.
+
+	{
+	    "name": "ransomware",
+	    "description": "Curated ransomware rules",
+	    "source": "rule ransom_note { strings: $a = \"your files are encrypted\" nocase condition: $a }",
+	    "enabled": true
+	}
+*/
+func cslSetYaraRuleset(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	ruleset := CslYaraRuleset{}
+	err = json.Unmarshal(body, &ruleset)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	ruleset.Rules, err = validateCslYaraRuleset(ruleset)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	ruleset.Description = truncateText(ruleset.Description, 1000)
+	ruleset.Updated = time.Now().Unix()
+	ruleset.UpdatedBy = user.Username
+
+	yara := CslYara{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslYaraDocument, &yara, func() error {
+		if yara.Rulesets == nil {
+			yara.Rulesets = map[string]CslYaraRuleset{}
+		}
+
+		if _, ok := yara.Rulesets[ruleset.Name]; !ok && len(yara.Rulesets) >= MaxYaraRulesets {
+			return errors.New(fmt.Sprintf("can't have more than %d rulesets", MaxYaraRulesets))
+		}
+
+		yara.Rulesets[ruleset.Name] = ruleset
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated yara ruleset %s for org %s", user.Username, user.Id, ruleset.Name, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "yara_ruleset_updated", fmt.Sprintf("YARA ruleset %s was updated", ruleset.Name), user.Username, ruleset.Name)
+
+	res := CslResponse{
+		Success: true,
+		Data:    ruleset,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetYaraRuleset")
+}
+
+/*
+YARA:
+Deletes a YARA ruleset. Requires ?name=<name> and org admin.
+*/
+func cslDeleteYaraRuleset(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	name := request.URL.Query().Get("name")
+	yara := CslYara{}
+	err := updateCslDocument(ctx, user.ActiveOrg.Id, CslYaraDocument, &yara, func() error {
+		if _, ok := yara.Rulesets[name]; !ok {
+			return errors.New("ruleset not found")
+		}
+
+		delete(yara.Rulesets, name)
+		for key, stats := range yara.Stats.Rules {
+			if stats.Ruleset == name {
+				delete(yara.Stats.Rules, key)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) deleted yara ruleset %s for org %s", user.Username, user.Id, name, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "yara_ruleset_deleted", fmt.Sprintf("YARA ruleset %s was deleted", name), user.Username, name)
+
+	res := CslResponse{
+		Success: true,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslDeleteYaraRuleset")
+}
+
+/*
+YARA:
+Scans a file with the enabled YARA rulesets, or with ?rulesets=<a,b> when
+given. The file is either uploaded as multipart form data in the "file"
+field, a Shuffle file with ?file_id=<id> or an artifact with
+?artifact_id=<id>. Scanning an artifact stores the matches on it. Workflows
+can scan with ?execution_id=<id>&authorization=<execution authorization>.
+
+	{
+	    "success": true,
+	    "data": {
+	        "filename": "invoice.exe",
+	        "sha256": "...",
+	        "size": 52224,
+	        "rulesets": ["ransomware"],
+	        "matches": [
+	            {
+	                "ruleset": "ransomware",
+	                "rule": "ransom_note",
+	                "tags": ["crime"],
+	                "meta": {"author": "soc"},
+	                "strings": [{"id": "$a", "count": 1, "offsets": [4096], "data": "796f75722066696c6573"}]
+	            }
+	        ]
+	    }
+	}
+*/
+func cslYaraScan(resp http.ResponseWriter, request *http.Request) {
+	orgId, caller, _, ok := handleCslExecutionRequest(resp, request)
+	if !ok {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+	query := request.URL.Query()
+
+	filename := ""
+	contents := []byte{}
+	artifactId := query.Get("artifact_id")
+	if len(artifactId) > 0 {
+		artifact, ok := getCslArtifacts(ctx, orgId).Artifacts[artifactId]
+		if !ok {
+			resp.WriteHeader(404)
+			resp.Write(createCslErrorResponse(errors.New("artifact not found")))
+			return
+		}
+
+		if artifact.Size > MaxYaraScanBytes {
+			resp.WriteHeader(413)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("files larger than %d bytes can't be scanned", MaxYaraScanBytes))))
+			return
+		}
+
+		var err error
+		contents, err = getCslArtifactContents(ctx, orgId, artifact)
+		if err != nil {
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		filename = artifact.Filename
+	} else if len(query.Get("file_id")) > 0 {
+		file, err := shuffle.GetFile(ctx, query.Get("file_id"))
+		if err != nil || file.OrgId != orgId || file.Status != "active" {
+			resp.WriteHeader(404)
+			resp.Write(createCslErrorResponse(errors.New("file not found")))
+			return
+		}
+
+		if file.FileSize > MaxYaraScanBytes {
+			resp.WriteHeader(413)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("files larger than %d bytes can't be scanned", MaxYaraScanBytes))))
+			return
+		}
+
+		contents, err = shuffle.GetFileContent(ctx, file, nil)
+		if err != nil {
+			log.Printf("[ERROR] Failed reading file %s for yara scan in org %s: %s", file.Id, orgId, err)
+			resp.WriteHeader(500)
+			resp.Write(createCslErrorResponse(errors.New("failed reading file")))
+			return
+		}
+
+		filename = file.Filename
+	} else {
+		request.Body = http.MaxBytesReader(resp, request.Body, MaxYaraScanBytes+1024*1024)
+		err := request.ParseMultipartForm(32 << 20)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New(fmt.Sprintf("failed parsing upload. Max file size is %d bytes: %s", MaxYaraScanBytes, err))))
+			return
+		}
+
+		defer request.MultipartForm.RemoveAll()
+		parsedFile, header, err := request.FormFile("file")
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(errors.New("file, file_id or artifact_id is required")))
+			return
+		}
+
+		defer parsedFile.Close()
+		contents, err = ioutil.ReadAll(parsedFile)
+		if err != nil {
+			resp.WriteHeader(400)
+			resp.Write(createCslErrorResponse(err))
+			return
+		}
+
+		filename = header.Filename
+	}
+
+	rulesetNames := []string{}
+	for _, name := range strings.Split(query.Get("rulesets"), ",") {
+		if len(strings.TrimSpace(name)) > 0 {
+			rulesetNames = append(rulesetNames, strings.TrimSpace(name))
+		}
+	}
+
+	result, err := scanCslYara(getCslYara(ctx, orgId), rulesetNames, contents)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	result.Filename = filename
+	recordCslYaraScan(ctx, orgId, result.Matches)
+	if len(artifactId) > 0 {
+		artifacts := CslArtifacts{}
+		err = updateCslDocument(ctx, orgId, CslArtifactsDocument, &artifacts, func() error {
+			artifact, ok := artifacts.Artifacts[artifactId]
+			if !ok {
+				return errors.New("artifact not found")
+			}
+
+			artifact.YaraMatches = getYaraMatchNames(result.Matches)
+			artifacts.Artifacts[artifactId] = artifact
+			return nil
+		})
+		if err != nil {
+			log.Printf("[WARNING] Failed storing yara matches of artifact %s in org %s: %s", artifactId, orgId, err)
+		}
+	}
+
+	if len(result.Matches) > 0 {
+		log.Printf("[INFO] %s matched %d yara rules for %s in org %s", result.Sha256, len(result.Matches), caller, orgId)
+	}
+
+	res := CslResponse{
+		Success: true,
+		Data:    result,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslYaraScan")
+}
+
+/*
+YARA:
+Returns the YARA scan statistics of the current organization, with the rules
+that matched the most first.
+
+	{
+	    "success": true,
+	    "data": {
+	        "scans": 420,
+	        "matched_scans": 12,
+	        "last_scan": 1700000000,
+	        "rules": [{"ruleset": "ransomware", "rule": "ransom_note", "matches": 7, "last_match": 1700000000}]
+	    }
+	}
+*/
+func cslYaraStats(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	stats := getCslYara(ctx, user.ActiveOrg.Id).Stats
+	rules := []CslYaraRuleStats{}
+	for _, rule := range stats.Rules {
+		rules = append(rules, rule)
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Matches != rules[j].Matches {
+			return rules[i].Matches > rules[j].Matches
+		}
+
+		return rules[i].LastMatch > rules[j].LastMatch
+	})
+
+	res := CslResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"scans":         stats.Scans,
+			"matched_scans": stats.MatchedScans,
+			"last_scan":     stats.LastScan,
+			"rules":         rules,
+		},
+	}
+
+	marshalAndWriteResponse(resp, res, "cslYaraStats")
+}
+
+/*
+YARA:
+Returns the YARA configuration. Requires org admin.
+
+	{
+	    "success": true,
+	    "data": {
+	        "scan_artifacts": true
+	    }
+	}
+*/
+func cslGetYaraConfig(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	res := CslResponse{
+		Success: true,
+		Data:    getCslYara(ctx, user.ActiveOrg.Id).Config,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslGetYaraConfig")
+}
+
+/*
+YARA:
+Updates the YARA configuration. Requires org admin. Body uses the format
+returned from GET. With scan_artifacts, uploaded artifacts are scanned with the
+enabled rulesets and the matches are stored on the artifact.
+*/
+func cslSetYaraConfig(resp http.ResponseWriter, request *http.Request) {
+	user := handleCslAdminRequest(resp, request)
+	if user == nil {
+		return
+	}
+
+	ctx := shuffle.GetContext(request)
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	config := CslYaraConfig{}
+	err = json.Unmarshal(body, &config)
+	if err != nil {
+		resp.WriteHeader(400)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	yara := CslYara{}
+	err = updateCslDocument(ctx, user.ActiveOrg.Id, CslYaraDocument, &yara, func() error {
+		yara.Config = config
+		return nil
+	})
+	if err != nil {
+		resp.WriteHeader(500)
+		resp.Write(createCslErrorResponse(err))
+		return
+	}
+
+	log.Printf("[AUDIT] User %s (%s) updated yara config for org %s", user.Username, user.Id, user.ActiveOrg.Id)
+	recordCslActivity(ctx, user.ActiveOrg.Id, ActivityTypeAdmin, "yara_updated", "YARA configuration was updated", user.Username, "")
+
+	res := CslResponse{
+		Success: true,
+		Data:    config,
+	}
+
+	marshalAndWriteResponse(resp, res, "cslSetYaraConfig")
+}
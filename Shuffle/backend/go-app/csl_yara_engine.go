@@ -0,0 +1,1519 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// Max matches recorded per string
+const MaxYaraStringMatches = 1000
+
+// Max matcher steps per scan, to stop hex strings with many jumps from
+// running forever. Strings stop matching once it's used up
+const MaxYaraScanSteps = 100000000
+
+const (
+	yaraStringText = iota
+	yaraStringHex
+	yaraStringRegex
+)
+
+var yaraReadIntPattern = regexp.MustCompile(`^(u?)int(8|16|32)(be)?$`)
+
+var yaraKeywords = []string{
+	"all", "and", "any", "ascii", "at", "condition", "contains", "entrypoint",
+	"false", "filesize", "for", "fullword", "global", "import", "in", "include",
+	"matches", "meta", "nocase", "none", "not", "of", "or", "private", "rule",
+	"strings", "them", "true", "wide",
+}
+
+type yaraHexToken struct {
+	Value        byte
+	Mask         byte
+	Jump         bool
+	JumpMin      int
+	JumpMax      int
+	Alternatives [][]yaraHexToken
+}
+
+type yaraString struct {
+	Id       string
+	Kind     int
+	Text     []byte
+	Hex      []yaraHexToken
+	Regex    *regexp.Regexp
+	Nocase   bool
+	Wide     bool
+	Ascii    bool
+	Fullword bool
+	Private  bool
+}
+
+type yaraMatch struct {
+	Offset int
+	Length int
+}
+
+type yaraRule struct {
+	Name      string
+	Tags      []string
+	Meta      map[string]interface{}
+	Strings   []*yaraString
+	Condition yaraExpr
+	Private   bool
+	Global    bool
+}
+
+// Compiled YARA rules. The engine covers the parts of the language used by
+// most curated rule sets: text, hex and regex strings with the nocase, wide,
+// ascii, fullword and private modifiers, and conditions with boolean and
+// arithmetic operators, string counts and offsets, "of" quantifiers, filesize,
+// integer reads and references to earlier rules. Modules, includes and for
+// loops fail compilation
+type yaraRules struct {
+	Rules []*yaraRule
+}
+
+type yaraScan struct {
+	data    []byte
+	lower   []byte
+	steps   int
+	matches map[string][]yaraMatch
+	results map[string]bool
+}
+
+type CslYaraStringMatch struct {
+	Id      string `json:"id"`
+	Count   int    `json:"count"`
+	Offsets []int  `json:"offsets"`
+	Data    string `json:"data"`
+}
+
+type CslYaraMatch struct {
+	Ruleset string                 `json:"ruleset"`
+	Rule    string                 `json:"rule"`
+	Tags    []string               `json:"tags"`
+	Meta    map[string]interface{} `json:"meta"`
+	Strings []CslYaraStringMatch   `json:"strings"`
+}
+
+// Conditions evaluate to integers, where booleans are 1 and 0
+type yaraExpr interface {
+	eval(scan *yaraScan) int64
+}
+
+type yaraConst struct {
+	value int64
+}
+
+type yaraFilesize struct{}
+
+type yaraUnary struct {
+	op   string
+	expr yaraExpr
+}
+
+type yaraBinary struct {
+	op    string
+	left  yaraExpr
+	right yaraExpr
+}
+
+type yaraStringRef struct {
+	id     string
+	at     yaraExpr
+	inFrom yaraExpr
+	inTo   yaraExpr
+}
+
+type yaraStringCount struct {
+	id string
+}
+
+type yaraStringOffset struct {
+	id     string
+	index  yaraExpr
+	length bool
+}
+
+type yaraOf struct {
+	quantifier string
+	count      yaraExpr
+	ids        []string
+}
+
+type yaraReadInt struct {
+	size      int
+	signed    bool
+	bigEndian bool
+	offset    yaraExpr
+}
+
+type yaraRuleRef struct {
+	name string
+}
+
+func yaraBool(value bool) int64 {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+func (e yaraConst) eval(scan *yaraScan) int64 {
+	return e.value
+}
+
+func (e yaraFilesize) eval(scan *yaraScan) int64 {
+	return int64(len(scan.data))
+}
+
+func (e yaraUnary) eval(scan *yaraScan) int64 {
+	value := e.expr.eval(scan)
+	switch e.op {
+	case "not":
+		return yaraBool(value == 0)
+	case "-":
+		return -value
+	case "~":
+		return ^value
+	}
+
+	return 0
+}
+
+func (e yaraBinary) eval(scan *yaraScan) int64 {
+	// Short circuit so referenced rules and strings are only evaluated when needed
+	switch e.op {
+	case "and":
+		return yaraBool(e.left.eval(scan) != 0 && e.right.eval(scan) != 0)
+	case "or":
+		return yaraBool(e.left.eval(scan) != 0 || e.right.eval(scan) != 0)
+	}
+
+	left := e.left.eval(scan)
+	right := e.right.eval(scan)
+	switch e.op {
+	case "==":
+		return yaraBool(left == right)
+	case "!=":
+		return yaraBool(left != right)
+	case "<":
+		return yaraBool(left < right)
+	case "<=":
+		return yaraBool(left <= right)
+	case ">":
+		return yaraBool(left > right)
+	case ">=":
+		return yaraBool(left >= right)
+	case "+":
+		return left + right
+	case "-":
+		return left - right
+	case "*":
+		return left * right
+	case "\\":
+		if right == 0 {
+			return 0
+		}
+
+		return left / right
+	case "%":
+		if right == 0 {
+			return 0
+		}
+
+		return left % right
+	case "|":
+		return left | right
+	case "^":
+		return left ^ right
+	case "&":
+		return left & right
+	case "<<":
+		if right < 0 || right > 63 {
+			return 0
+		}
+
+		return left << uint(right)
+	case ">>":
+		if right < 0 || right > 63 {
+			return 0
+		}
+
+		return left >> uint(right)
+	}
+
+	return 0
+}
+
+func (e yaraStringRef) eval(scan *yaraScan) int64 {
+	matches := scan.matches[e.id]
+	if e.at != nil {
+		at := e.at.eval(scan)
+		for _, match := range matches {
+			if int64(match.Offset) == at {
+				return 1
+			}
+		}
+
+		return 0
+	}
+
+	if e.inFrom != nil {
+		from := e.inFrom.eval(scan)
+		to := e.inTo.eval(scan)
+		for _, match := range matches {
+			if int64(match.Offset) >= from && int64(match.Offset) <= to {
+				return 1
+			}
+		}
+
+		return 0
+	}
+
+	return yaraBool(len(matches) > 0)
+}
+
+func (e yaraStringCount) eval(scan *yaraScan) int64 {
+	return int64(len(scan.matches[e.id]))
+}
+
+// Undefined offsets and lengths are -1, as indexes start at 1
+func (e yaraStringOffset) eval(scan *yaraScan) int64 {
+	index := int64(1)
+	if e.index != nil {
+		index = e.index.eval(scan)
+	}
+
+	matches := scan.matches[e.id]
+	if index < 1 || index > int64(len(matches)) {
+		return -1
+	}
+
+	if e.length {
+		return int64(matches[index-1].Length)
+	}
+
+	return int64(matches[index-1].Offset)
+}
+
+func (e yaraOf) eval(scan *yaraScan) int64 {
+	matched := int64(0)
+	for _, id := range e.ids {
+		if len(scan.matches[id]) > 0 {
+			matched += 1
+		}
+	}
+
+	total := int64(len(e.ids))
+	switch e.quantifier {
+	case "any":
+		return yaraBool(matched > 0)
+	case "all":
+		return yaraBool(matched == total)
+	case "none":
+		return yaraBool(matched == 0)
+	case "percent":
+		return yaraBool(matched*100 >= e.count.eval(scan)*total)
+	}
+
+	return yaraBool(matched >= e.count.eval(scan))
+}
+
+// Reads outside the data are undefined and evaluate to 0
+func (e yaraReadInt) eval(scan *yaraScan) int64 {
+	offset := e.offset.eval(scan)
+	if offset < 0 || offset+int64(e.size) > int64(len(scan.data)) {
+		return 0
+	}
+
+	raw := scan.data[offset : offset+int64(e.size)]
+	var order binary.ByteOrder = binary.LittleEndian
+	if e.bigEndian {
+		order = binary.BigEndian
+	}
+
+	switch e.size {
+	case 1:
+		if e.signed {
+			return int64(int8(raw[0]))
+		}
+
+		return int64(raw[0])
+	case 2:
+		if e.signed {
+			return int64(int16(order.Uint16(raw)))
+		}
+
+		return int64(order.Uint16(raw))
+	default:
+		if e.signed {
+			return int64(int32(order.Uint32(raw)))
+		}
+
+		return int64(order.Uint32(raw))
+	}
+}
+
+func (e yaraRuleRef) eval(scan *yaraScan) int64 {
+	return yaraBool(scan.results[e.name])
+}
+
+type yaraParser struct {
+	src       string
+	pos       int
+	rules     map[string]bool
+	stringIds []string
+}
+
+func (p *yaraParser) errorf(format string, args ...interface{}) error {
+	line := strings.Count(p.src[:min(p.pos, len(p.src))], "\n") + 1
+	return errors.New(fmt.Sprintf("line %d: %s", line, fmt.Sprintf(format, args...)))
+}
+
+func (p *yaraParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *yaraParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+
+	return p.src[p.pos]
+}
+
+// Skips whitespace and comments
+func (p *yaraParser) skip() {
+	for !p.eof() {
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(p.peek())):
+			p.pos += 1
+		case strings.HasPrefix(p.src[p.pos:], "//"):
+			end := strings.IndexByte(p.src[p.pos:], '\n')
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end + 1
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			end := strings.Index(p.src[p.pos+2:], "*/")
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end + 4
+			}
+		default:
+			return
+		}
+	}
+}
+
+func isYaraIdentByte(char byte, first bool) bool {
+	if char == '_' || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') {
+		return true
+	}
+
+	return !first && char >= '0' && char <= '9'
+}
+
+func (p *yaraParser) readIdent() string {
+	p.skip()
+	start := p.pos
+	for !p.eof() && isYaraIdentByte(p.peek(), p.pos == start) {
+		p.pos += 1
+	}
+
+	return p.src[start:p.pos]
+}
+
+func (p *yaraParser) peekIdent() string {
+	start := p.pos
+	ident := p.readIdent()
+	p.pos = start
+	return ident
+}
+
+func (p *yaraParser) acceptKeyword(keyword string) bool {
+	start := p.pos
+	if p.readIdent() == keyword {
+		return true
+	}
+
+	p.pos = start
+	return false
+}
+
+func (p *yaraParser) acceptOp(op string) bool {
+	p.skip()
+	if !strings.HasPrefix(p.src[p.pos:], op) {
+		return false
+	}
+
+	// Keeps < from matching the start of << or <=
+	if len(op) == 1 && strings.Contains("<>", op) && p.pos+1 < len(p.src) && strings.ContainsRune("<>=", rune(p.src[p.pos+1])) {
+		return false
+	}
+
+	p.pos += len(op)
+	return true
+}
+
+func (p *yaraParser) expect(op string) error {
+	if !p.acceptOp(op) {
+		return p.errorf("expected %s", op)
+	}
+
+	return nil
+}
+
+func (p *yaraParser) readNumber() (int64, error) {
+	p.skip()
+	start := p.pos
+	for !p.eof() && (isYaraIdentByte(p.peek(), false)) {
+		p.pos += 1
+	}
+
+	raw := p.src[start:p.pos]
+	multiplier := int64(1)
+	if strings.HasSuffix(raw, "KB") {
+		multiplier = 1024
+		raw = strings.TrimSuffix(raw, "KB")
+	} else if strings.HasSuffix(raw, "MB") {
+		multiplier = 1024 * 1024
+		raw = strings.TrimSuffix(raw, "MB")
+	}
+
+	value, err := strconv.ParseInt(raw, 0, 64)
+	if err != nil {
+		return 0, p.errorf("invalid number %s", p.src[start:p.pos])
+	}
+
+	return value * multiplier, nil
+}
+
+func (p *yaraParser) readQuoted() (string, error) {
+	p.skip()
+	if p.peek() != '"' {
+		return "", p.errorf("expected string")
+	}
+
+	p.pos += 1
+	value := []byte{}
+	for !p.eof() {
+		char := p.peek()
+		p.pos += 1
+		switch char {
+		case '"':
+			return string(value), nil
+		case '\n':
+			return "", p.errorf("unterminated string")
+		case '\\':
+			if p.eof() {
+				return "", p.errorf("unterminated string")
+			}
+
+			escaped := p.peek()
+			p.pos += 1
+			switch escaped {
+			case 'n':
+				value = append(value, '\n')
+			case 't':
+				value = append(value, '\t')
+			case 'r':
+				value = append(value, '\r')
+			case 'x':
+				if p.pos+2 > len(p.src) {
+					return "", p.errorf("invalid escape")
+				}
+
+				parsed, err := strconv.ParseUint(p.src[p.pos:p.pos+2], 16, 8)
+				if err != nil {
+					return "", p.errorf("invalid escape \\x%s", p.src[p.pos:p.pos+2])
+				}
+
+				value = append(value, byte(parsed))
+				p.pos += 2
+			default:
+				value = append(value, escaped)
+			}
+		default:
+			value = append(value, char)
+		}
+	}
+
+	return "", p.errorf("unterminated string")
+}
+
+func (p *yaraParser) parseRules() (*yaraRules, error) {
+	rules := &yaraRules{}
+	for {
+		p.skip()
+		if p.eof() {
+			break
+		}
+
+		rule := &yaraRule{
+			Tags: []string{},
+			Meta: map[string]interface{}{},
+		}
+
+		ident := p.readIdent()
+		for ident == "private" || ident == "global" {
+			rule.Private = rule.Private || ident == "private"
+			rule.Global = rule.Global || ident == "global"
+			ident = p.readIdent()
+		}
+
+		if ident == "import" || ident == "include" {
+			return nil, p.errorf("%s isn't supported", ident)
+		}
+
+		if ident != "rule" {
+			return nil, p.errorf("expected rule")
+		}
+
+		rule.Name = p.readIdent()
+		if len(rule.Name) == 0 || shuffle.ArrayContains(yaraKeywords, rule.Name) {
+			return nil, p.errorf("invalid rule name")
+		}
+
+		if p.rules[rule.Name] {
+			return nil, p.errorf("duplicate rule %s", rule.Name)
+		}
+
+		if p.acceptOp(":") {
+			for p.peekIdent() != "" {
+				rule.Tags = append(rule.Tags, p.readIdent())
+			}
+		}
+
+		err := p.expect("{")
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.parseRuleBody(rule)
+		if err != nil {
+			return nil, err
+		}
+
+		p.rules[rule.Name] = true
+		rules.Rules = append(rules.Rules, rule)
+	}
+
+	if len(rules.Rules) == 0 {
+		return nil, errors.New("no rules found")
+	}
+
+	return rules, nil
+}
+
+func (p *yaraParser) parseRuleBody(rule *yaraRule) error {
+	p.stringIds = []string{}
+	section := p.readIdent()
+	if section == "meta" {
+		err := p.expect(":")
+		if err != nil {
+			return err
+		}
+
+		for next := p.peekIdent(); next != "" && next != "strings" && next != "condition"; next = p.peekIdent() {
+			key := p.readIdent()
+			err := p.expect("=")
+			if err != nil {
+				return err
+			}
+
+			p.skip()
+			switch {
+			case p.peek() == '"':
+				value, err := p.readQuoted()
+				if err != nil {
+					return err
+				}
+
+				rule.Meta[key] = value
+			case p.acceptKeyword("true"):
+				rule.Meta[key] = true
+			case p.acceptKeyword("false"):
+				rule.Meta[key] = false
+			default:
+				negative := p.acceptOp("-")
+				value, err := p.readNumber()
+				if err != nil {
+					return err
+				}
+
+				if negative {
+					value = -value
+				}
+
+				rule.Meta[key] = value
+			}
+		}
+
+		section = p.readIdent()
+	}
+
+	if section == "strings" {
+		err := p.expect(":")
+		if err != nil {
+			return err
+		}
+
+		for p.skip(); p.peek() == '$'; p.skip() {
+			yaraString, err := p.parseString(len(rule.Strings))
+			if err != nil {
+				return err
+			}
+
+			rule.Strings = append(rule.Strings, yaraString)
+			p.stringIds = append(p.stringIds, yaraString.Id)
+		}
+
+		section = p.readIdent()
+	}
+
+	if section != "condition" {
+		return p.errorf("expected condition")
+	}
+
+	err := p.expect(":")
+	if err != nil {
+		return err
+	}
+
+	rule.Condition, err = p.parseOr()
+	if err != nil {
+		return err
+	}
+
+	return p.expect("}")
+}
+
+// Anonymous strings get an id of their index, which only "them" and
+// wildcards can refer to
+func (p *yaraParser) parseString(index int) (*yaraString, error) {
+	p.pos += 1
+	yaraString := &yaraString{Id: "$" + p.readIdent()}
+	if yaraString.Id == "$" {
+		yaraString.Id = fmt.Sprintf("$%d", index)
+	} else if shuffle.ArrayContains(p.stringIds, yaraString.Id) {
+		return nil, p.errorf("duplicate string %s", yaraString.Id)
+	}
+
+	err := p.expect("=")
+	if err != nil {
+		return nil, err
+	}
+
+	p.skip()
+	regexFlags := ""
+	switch p.peek() {
+	case '"':
+		text, err := p.readQuoted()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(text) == 0 {
+			return nil, p.errorf("empty string %s", yaraString.Id)
+		}
+
+		yaraString.Kind = yaraStringText
+		yaraString.Text = []byte(text)
+	case '{':
+		p.pos += 1
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if end < 0 {
+			return nil, p.errorf("unterminated hex string")
+		}
+
+		tokens, err := parseYaraHex(p.src[p.pos : p.pos+end])
+		if err != nil {
+			return nil, p.errorf("%s in %s", err, yaraString.Id)
+		}
+
+		p.pos += end + 1
+		yaraString.Kind = yaraStringHex
+		yaraString.Hex = tokens
+	case '/':
+		p.pos += 1
+		start := p.pos
+		for !p.eof() && p.peek() != '/' {
+			if p.peek() == '\\' {
+				p.pos += 1
+			}
+
+			p.pos += 1
+		}
+
+		if p.eof() {
+			return nil, p.errorf("unterminated regex")
+		}
+
+		pattern := p.src[start:p.pos]
+		p.pos += 1
+		for !p.eof() && (p.peek() == 'i' || p.peek() == 's') {
+			regexFlags += string(p.peek())
+			p.pos += 1
+		}
+
+		yaraString.Kind = yaraStringRegex
+		yaraString.Text = []byte(pattern)
+	default:
+		return nil, p.errorf("expected text, hex or regex string")
+	}
+
+	for {
+		modifier := p.peekIdent()
+		switch modifier {
+		case "nocase":
+			yaraString.Nocase = true
+		case "wide":
+			yaraString.Wide = true
+		case "ascii":
+			yaraString.Ascii = true
+		case "fullword":
+			yaraString.Fullword = true
+		case "private":
+			yaraString.Private = true
+		case "xor", "base64", "base64wide":
+			return nil, p.errorf("modifier %s isn't supported", modifier)
+		default:
+			if yaraString.Kind == yaraStringHex && (yaraString.Nocase || yaraString.Wide || yaraString.Ascii || yaraString.Fullword) {
+				return nil, p.errorf("hex string %s only supports the private modifier", yaraString.Id)
+			}
+
+			if yaraString.Kind == yaraStringRegex {
+				if yaraString.Wide {
+					return nil, p.errorf("regex string %s doesn't support the wide modifier", yaraString.Id)
+				}
+
+				if yaraString.Nocase && !strings.Contains(regexFlags, "i") {
+					regexFlags += "i"
+				}
+
+				pattern := string(yaraString.Text)
+				if len(regexFlags) > 0 {
+					pattern = fmt.Sprintf("(?%s)%s", regexFlags, pattern)
+				}
+
+				yaraString.Regex, err = regexp.Compile(pattern)
+				if err != nil {
+					return nil, p.errorf("invalid regex %s: %s", yaraString.Id, err)
+				}
+			}
+
+			if !yaraString.Wide {
+				yaraString.Ascii = true
+			}
+
+			return yaraString, nil
+		}
+
+		p.readIdent()
+	}
+}
+
+func parseYaraHex(src string) ([]yaraHexToken, error) {
+	tokens, rest, err := parseYaraHexSequence(src, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(strings.TrimSpace(rest)) > 0 {
+		return nil, errors.New("unexpected ) or |")
+	}
+
+	if len(tokens) == 0 || tokens[0].Jump || tokens[len(tokens)-1].Jump {
+		return nil, errors.New("hex strings can't start or end with a jump")
+	}
+
+	return tokens, nil
+}
+
+// Parses hex tokens until the end, or until | or ) inside an alternative
+func parseYaraHexSequence(src string, alternative bool) ([]yaraHexToken, string, error) {
+	tokens := []yaraHexToken{}
+	for {
+		src = strings.TrimLeft(src, " \t\r\n")
+		if len(src) == 0 {
+			if alternative {
+				return nil, "", errors.New("unterminated alternative")
+			}
+
+			return tokens, src, nil
+		}
+
+		switch src[0] {
+		case '|', ')':
+			if !alternative {
+				return nil, "", errors.New(fmt.Sprintf("unexpected %c", src[0]))
+			}
+
+			if len(tokens) == 0 {
+				return nil, "", errors.New("empty alternative")
+			}
+
+			return tokens, src, nil
+		case '[':
+			end := strings.IndexByte(src, ']')
+			if end < 0 {
+				return nil, "", errors.New("unterminated jump")
+			}
+
+			jump := yaraHexToken{Jump: true, JumpMax: -1}
+			bounds := strings.SplitN(strings.ReplaceAll(src[1:end], " ", ""), "-", 2)
+			if len(bounds[0]) > 0 {
+				value, err := strconv.Atoi(bounds[0])
+				if err != nil || value < 0 {
+					return nil, "", errors.New(fmt.Sprintf("invalid jump [%s]", src[1:end]))
+				}
+
+				jump.JumpMin = value
+			}
+
+			if len(bounds) == 1 {
+				jump.JumpMax = jump.JumpMin
+			} else if len(bounds[1]) > 0 {
+				value, err := strconv.Atoi(bounds[1])
+				if err != nil || value < jump.JumpMin {
+					return nil, "", errors.New(fmt.Sprintf("invalid jump [%s]", src[1:end]))
+				}
+
+				jump.JumpMax = value
+			}
+
+			tokens = append(tokens, jump)
+			src = src[end+1:]
+		case '(':
+			token := yaraHexToken{}
+			src = src[1:]
+			for {
+				sequence, rest, err := parseYaraHexSequence(src, true)
+				if err != nil {
+					return nil, "", err
+				}
+
+				token.Alternatives = append(token.Alternatives, sequence)
+				src = rest[1:]
+				if rest[0] == ')' {
+					break
+				}
+			}
+
+			tokens = append(tokens, token)
+		default:
+			if len(src) < 2 {
+				return nil, "", errors.New("incomplete byte")
+			}
+
+			token := yaraHexToken{}
+			for i, char := range src[:2] {
+				shift := uint(4 * (1 - i))
+				if char == '?' {
+					continue
+				}
+
+				value, err := strconv.ParseUint(string(char), 16, 8)
+				if err != nil {
+					return nil, "", errors.New(fmt.Sprintf("invalid byte %s", src[:2]))
+				}
+
+				token.Value |= byte(value) << shift
+				token.Mask |= 0xF << shift
+			}
+
+			tokens = append(tokens, token)
+			src = src[2:]
+		}
+	}
+}
+
+func (p *yaraParser) parseBinary(ops []string, next func() (yaraExpr, error)) (yaraExpr, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		matched := ""
+		for _, op := range ops {
+			if (isYaraIdentByte(op[0], true) && p.acceptKeyword(op)) || (!isYaraIdentByte(op[0], true) && p.acceptOp(op)) {
+				matched = op
+				break
+			}
+		}
+
+		if len(matched) == 0 {
+			return left, nil
+		}
+
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+
+		left = yaraBinary{op: matched, left: left, right: right}
+	}
+}
+
+func (p *yaraParser) parseOr() (yaraExpr, error) {
+	return p.parseBinary([]string{"or"}, p.parseAnd)
+}
+
+func (p *yaraParser) parseAnd() (yaraExpr, error) {
+	return p.parseBinary([]string{"and"}, p.parseNot)
+}
+
+func (p *yaraParser) parseNot() (yaraExpr, error) {
+	if p.acceptKeyword("not") {
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return yaraUnary{op: "not", expr: expr}, nil
+	}
+
+	return p.parseBinary([]string{"==", "!=", "<=", ">=", "<", ">"}, p.parseBitOr)
+}
+
+func (p *yaraParser) parseBitOr() (yaraExpr, error) {
+	return p.parseBinary([]string{"|"}, p.parseBitXor)
+}
+
+func (p *yaraParser) parseBitXor() (yaraExpr, error) {
+	return p.parseBinary([]string{"^"}, p.parseBitAnd)
+}
+
+func (p *yaraParser) parseBitAnd() (yaraExpr, error) {
+	return p.parseBinary([]string{"&"}, p.parseShift)
+}
+
+func (p *yaraParser) parseShift() (yaraExpr, error) {
+	return p.parseBinary([]string{"<<", ">>"}, p.parseAdditive)
+}
+
+func (p *yaraParser) parseAdditive() (yaraExpr, error) {
+	return p.parseBinary([]string{"+", "-"}, p.parseMultiplicative)
+}
+
+func (p *yaraParser) parseMultiplicative() (yaraExpr, error) {
+	return p.parseBinary([]string{"*", "\\", "%"}, p.parseUnary)
+}
+
+func (p *yaraParser) parseUnary() (yaraExpr, error) {
+	for _, op := range []string{"-", "~"} {
+		if p.acceptOp(op) {
+			expr, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+
+			return yaraUnary{op: op, expr: expr}, nil
+		}
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *yaraParser) readStringId(prefix byte) (string, error) {
+	p.pos += 1
+	id := "$"
+	for !p.eof() && isYaraIdentByte(p.peek(), false) {
+		id += string(p.peek())
+		p.pos += 1
+	}
+
+	if !shuffle.ArrayContains(p.stringIds, id) {
+		return "", p.errorf("undefined string %c%s", prefix, id[1:])
+	}
+
+	return id, nil
+}
+
+func (p *yaraParser) parseRange() (yaraExpr, yaraExpr, error) {
+	err := p.expect("(")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	from, err := p.parseAdditive()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = p.expect("..")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	to, err := p.parseAdditive()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return from, to, p.expect(")")
+}
+
+func (p *yaraParser) parseIndex() (yaraExpr, error) {
+	if !p.acceptOp("[") {
+		return nil, nil
+	}
+
+	index, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	return index, p.expect("]")
+}
+
+// Parses "them" or a list of string ids, where $a* matches every id
+// starting with $a
+func (p *yaraParser) parseStringSet() ([]string, error) {
+	if p.acceptKeyword("them") {
+		if len(p.stringIds) == 0 {
+			return nil, p.errorf("rule has no strings")
+		}
+
+		return p.stringIds, nil
+	}
+
+	err := p.expect("(")
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for {
+		p.skip()
+		if p.peek() != '$' {
+			return nil, p.errorf("expected string identifier")
+		}
+
+		p.pos += 1
+		id := "$"
+		for !p.eof() && isYaraIdentByte(p.peek(), false) {
+			id += string(p.peek())
+			p.pos += 1
+		}
+
+		if p.peek() == '*' {
+			p.pos += 1
+			found := false
+			for _, stringId := range p.stringIds {
+				if strings.HasPrefix(stringId, id) {
+					found = true
+					if !shuffle.ArrayContains(ids, stringId) {
+						ids = append(ids, stringId)
+					}
+				}
+			}
+
+			if !found {
+				return nil, p.errorf("no strings match %s*", id)
+			}
+		} else if !shuffle.ArrayContains(p.stringIds, id) {
+			return nil, p.errorf("undefined string %s", id)
+		} else if !shuffle.ArrayContains(ids, id) {
+			ids = append(ids, id)
+		}
+
+		if p.acceptOp(")") {
+			return ids, nil
+		}
+
+		err := p.expect(",")
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *yaraParser) parseOf(quantifier string, count yaraExpr) (yaraExpr, error) {
+	ids, err := p.parseStringSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return yaraOf{quantifier: quantifier, count: count, ids: ids}, nil
+}
+
+func (p *yaraParser) parsePrimary() (yaraExpr, error) {
+	p.skip()
+	if p.eof() {
+		return nil, p.errorf("unexpected end of condition")
+	}
+
+	switch char := p.peek(); {
+	case char == '(':
+		p.pos += 1
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		return expr, p.expect(")")
+	case char == '$':
+		id, err := p.readStringId('$')
+		if err != nil {
+			return nil, err
+		}
+
+		ref := yaraStringRef{id: id}
+		if p.acceptKeyword("at") {
+			ref.at, err = p.parseAdditive()
+		} else if p.acceptKeyword("in") {
+			ref.inFrom, ref.inTo, err = p.parseRange()
+		}
+
+		return ref, err
+	case char == '#':
+		id, err := p.readStringId('#')
+		return yaraStringCount{id: id}, err
+	case char == '@' || char == '!':
+		id, err := p.readStringId(char)
+		if err != nil {
+			return nil, err
+		}
+
+		index, err := p.parseIndex()
+		return yaraStringOffset{id: id, index: index, length: char == '!'}, err
+	case char >= '0' && char <= '9':
+		value, err := p.readNumber()
+		if err != nil {
+			return nil, err
+		}
+
+		start := p.pos
+		if p.acceptOp("%") && p.acceptKeyword("of") {
+			return p.parseOf("percent", yaraConst{value: value})
+		}
+
+		p.pos = start
+		if p.acceptKeyword("of") {
+			return p.parseOf("count", yaraConst{value: value})
+		}
+
+		return yaraConst{value: value}, nil
+	case isYaraIdentByte(char, true):
+		ident := p.readIdent()
+		switch ident {
+		case "true":
+			return yaraConst{value: 1}, nil
+		case "false":
+			return yaraConst{value: 0}, nil
+		case "filesize":
+			return yaraFilesize{}, nil
+		case "any", "all", "none":
+			if !p.acceptKeyword("of") {
+				return nil, p.errorf("expected of after %s", ident)
+			}
+
+			return p.parseOf(ident, nil)
+		}
+
+		readInt := yaraReadIntPattern.FindStringSubmatch(ident)
+		if len(readInt) > 0 {
+			size, _ := strconv.Atoi(readInt[2])
+			err := p.expect("(")
+			if err != nil {
+				return nil, err
+			}
+
+			offset, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+
+			return yaraReadInt{size: size / 8, signed: readInt[1] == "", bigEndian: readInt[3] == "be", offset: offset}, p.expect(")")
+		}
+
+		if shuffle.ArrayContains(yaraKeywords, ident) {
+			return nil, p.errorf("%s isn't supported", ident)
+		}
+
+		if !p.rules[ident] {
+			return nil, p.errorf("undefined identifier %s", ident)
+		}
+
+		return yaraRuleRef{name: ident}, nil
+	}
+
+	return nil, p.errorf("unexpected %c", p.peek())
+}
+
+// Compiles YARA rule source
+func compileYaraRules(source string) (*yaraRules, error) {
+	parser := &yaraParser{
+		src:   source,
+		rules: map[string]bool{},
+	}
+
+	return parser.parseRules()
+}
+
+func isYaraWordByte(char byte) bool {
+	return isYaraIdentByte(char, false)
+}
+
+func toYaraWide(text []byte) []byte {
+	wide := make([]byte, 0, len(text)*2)
+	for _, char := range text {
+		wide = append(wide, char, 0)
+	}
+
+	return wide
+}
+
+// Checks that a match isn't surrounded by alphanumeric characters
+func isYaraFullword(data []byte, offset, length int, wide bool) bool {
+	step := 1
+	if wide {
+		step = 2
+	}
+
+	before := offset - step
+	if before >= 0 && isYaraWordByte(data[before]) && (!wide || data[before+1] == 0) {
+		return false
+	}
+
+	after := offset + length
+	if after < len(data) && isYaraWordByte(data[after]) && (!wide || (after+1 < len(data) && data[after+1] == 0)) {
+		return false
+	}
+
+	return true
+}
+
+// Lowercases ASCII only, as bytes.ToLower replaces invalid UTF-8 in binary
+// data and would move the offsets
+func toYaraLower(data []byte) []byte {
+	lower := make([]byte, len(data))
+	for i, char := range data {
+		if char >= 'A' && char <= 'Z' {
+			char += 'a' - 'A'
+		}
+
+		lower[i] = char
+	}
+
+	return lower
+}
+
+func (scan *yaraScan) getLower() []byte {
+	if scan.lower == nil {
+		scan.lower = toYaraLower(scan.data)
+	}
+
+	return scan.lower
+}
+
+func (scan *yaraScan) findText(yaraString *yaraString) []yaraMatch {
+	needles := [][]byte{}
+	if yaraString.Ascii {
+		needles = append(needles, yaraString.Text)
+	}
+
+	if yaraString.Wide {
+		needles = append(needles, toYaraWide(yaraString.Text))
+	}
+
+	data := scan.data
+	if yaraString.Nocase {
+		data = scan.getLower()
+	}
+
+	matches := []yaraMatch{}
+	for i, needle := range needles {
+		if yaraString.Nocase {
+			needle = toYaraLower(needle)
+		}
+
+		for offset := 0; offset < len(data) && len(matches) < MaxYaraStringMatches; {
+			index := bytes.Index(data[offset:], needle)
+			if index < 0 {
+				break
+			}
+
+			offset += index
+			if !yaraString.Fullword || isYaraFullword(scan.data, offset, len(needle), yaraString.Wide && i == len(needles)-1) {
+				matches = append(matches, yaraMatch{Offset: offset, Length: len(needle)})
+			}
+
+			offset += 1
+		}
+	}
+
+	return matches
+}
+
+// Matches hex tokens at an offset. Returns the end of the match
+func (scan *yaraScan) matchHex(tokens []yaraHexToken, offset int) (int, bool) {
+	scan.steps -= 1
+	if scan.steps < 0 {
+		return 0, false
+	}
+
+	if len(tokens) == 0 {
+		return offset, true
+	}
+
+	token := tokens[0]
+	switch {
+	case token.Jump:
+		jumpMax := token.JumpMax
+		if jumpMax < 0 {
+			jumpMax = len(scan.data)
+		}
+
+		for jump := token.JumpMin; jump <= jumpMax && offset+jump <= len(scan.data); jump++ {
+			end, ok := scan.matchHex(tokens[1:], offset+jump)
+			if ok {
+				return end, true
+			}
+		}
+	case token.Alternatives != nil:
+		for _, alternative := range token.Alternatives {
+			sequence := append(append([]yaraHexToken{}, alternative...), tokens[1:]...)
+			end, ok := scan.matchHex(sequence, offset)
+			if ok {
+				return end, true
+			}
+		}
+	default:
+		if offset < len(scan.data) && scan.data[offset]&token.Mask == token.Value {
+			return scan.matchHex(tokens[1:], offset+1)
+		}
+	}
+
+	return 0, false
+}
+
+func (scan *yaraScan) findHex(yaraString *yaraString) []yaraMatch {
+	matches := []yaraMatch{}
+	first := yaraString.Hex[0]
+	for offset := 0; offset < len(scan.data) && len(matches) < MaxYaraStringMatches && scan.steps > 0; offset++ {
+		// Skips ahead to the first byte when it's fixed
+		if first.Mask == 0xFF && first.Alternatives == nil {
+			index := bytes.IndexByte(scan.data[offset:], first.Value)
+			if index < 0 {
+				break
+			}
+
+			offset += index
+		}
+
+		end, ok := scan.matchHex(yaraString.Hex, offset)
+		if ok {
+			matches = append(matches, yaraMatch{Offset: offset, Length: end - offset})
+		}
+	}
+
+	return matches
+}
+
+func (scan *yaraScan) findRegex(yaraString *yaraString) []yaraMatch {
+	matches := []yaraMatch{}
+	for _, index := range yaraString.Regex.FindAllIndex(scan.data, MaxYaraStringMatches) {
+		if index[1] == index[0] {
+			continue
+		}
+
+		if yaraString.Fullword && !isYaraFullword(scan.data, index[0], index[1]-index[0], false) {
+			continue
+		}
+
+		matches = append(matches, yaraMatch{Offset: index[0], Length: index[1] - index[0]})
+	}
+
+	return matches
+}
+
+func (scan *yaraScan) findString(yaraString *yaraString) []yaraMatch {
+	switch yaraString.Kind {
+	case yaraStringHex:
+		return scan.findHex(yaraString)
+	case yaraStringRegex:
+		return scan.findRegex(yaraString)
+	}
+
+	return scan.findText(yaraString)
+}
+
+// Scans data with the rules. Returns the matching rules that aren't private.
+// If a global rule doesn't match, no rule matches
+func (rules *yaraRules) scan(data []byte) []CslYaraMatch {
+	scan := &yaraScan{
+		data:    data,
+		steps:   MaxYaraScanSteps,
+		results: map[string]bool{},
+	}
+
+	matches := []CslYaraMatch{}
+	for _, rule := range rules.Rules {
+		scan.matches = map[string][]yaraMatch{}
+		for _, yaraString := range rule.Strings {
+			scan.matches[yaraString.Id] = scan.findString(yaraString)
+		}
+
+		matched := rule.Condition.eval(scan) != 0
+		scan.results[rule.Name] = matched
+		if !matched {
+			if rule.Global {
+				return []CslYaraMatch{}
+			}
+
+			continue
+		}
+
+		if rule.Private {
+			continue
+		}
+
+		match := CslYaraMatch{
+			Rule:    rule.Name,
+			Tags:    rule.Tags,
+			Meta:    rule.Meta,
+			Strings: []CslYaraStringMatch{},
+		}
+
+		for _, yaraString := range rule.Strings {
+			stringMatches := scan.matches[yaraString.Id]
+			if yaraString.Private || len(stringMatches) == 0 {
+				continue
+			}
+
+			stringMatch := CslYaraStringMatch{
+				Id:      yaraString.Id,
+				Count:   len(stringMatches),
+				Offsets: []int{},
+			}
+
+			for _, offsetMatch := range stringMatches[:min(len(stringMatches), 10)] {
+				stringMatch.Offsets = append(stringMatch.Offsets, offsetMatch.Offset)
+			}
+
+			first := stringMatches[0]
+			stringMatch.Data = fmt.Sprintf("%x", data[first.Offset:first.Offset+min(first.Length, 32)])
+			match.Strings = append(match.Strings, stringMatch)
+		}
+
+		matches = append(matches, match)
+	}
+
+	return matches
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func getYaraRuleNames(matches []CslYaraMatch) []string {
+	names := []string{}
+	for _, match := range matches {
+		names = append(names, match.Rule)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func TestCompileYaraRulesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{name: "empty condition", source: `rule a { condition: }`},
+		{name: "missing condition", source: `rule a { strings: $a = "x" }`},
+		{name: "undefined string", source: `rule a { condition: $b }`},
+		{name: "unterminated text", source: `rule a { strings: $a = "x condition: $a }`},
+		{name: "odd hex nibbles", source: `rule a { strings: $a = { 4D 5 } condition: $a }`},
+		{name: "invalid regex", source: `rule a { strings: $a = /(/ condition: $a }`},
+		{name: "duplicate rule", source: `rule a { condition: true } rule a { condition: true }`},
+		{name: "unknown rule reference", source: `rule a { condition: b }`},
+		{name: "module import", source: `import "pe" rule a { condition: true }`},
+		{name: "for loop", source: `rule a { strings: $a = "x" condition: for any i in (1..2) : ( $a ) }`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := compileYaraRules(test.source)
+			if err == nil {
+				t.Errorf("compileYaraRules(%q) succeeded, expected an error", test.source)
+			}
+		})
+	}
+}
+
+func TestYaraRulesScan(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		data     string
+		expected []string
+	}{
+		{
+			name:     "text string",
+			source:   `rule a { strings: $a = "evil" condition: $a }`,
+			data:     "some evil payload",
+			expected: []string{"a"},
+		},
+		{
+			name:     "text string missing",
+			source:   `rule a { strings: $a = "evil" condition: $a }`,
+			data:     "some payload",
+			expected: []string{},
+		},
+		{
+			name:     "nocase",
+			source:   `rule a { strings: $a = "EVIL" nocase condition: $a }`,
+			data:     "some evil payload",
+			expected: []string{"a"},
+		},
+		{
+			name:     "wide",
+			source:   `rule a { strings: $a = "ab" wide condition: $a }`,
+			data:     "a\x00b\x00",
+			expected: []string{"a"},
+		},
+		{
+			name:     "fullword",
+			source:   `rule a { strings: $a = "evil" fullword condition: $a }`,
+			data:     "devilish",
+			expected: []string{},
+		},
+		{
+			name:     "hex with wildcard and jump",
+			source:   `rule a { strings: $a = { 4D 5A ?? [1-2] 50 } condition: $a }`,
+			data:     "MZx12P",
+			expected: []string{"a"},
+		},
+		{
+			name:     "hex alternatives",
+			source:   `rule a { strings: $a = { 41 ( 42 | 43 ) 44 } condition: $a }`,
+			data:     "xACD",
+			expected: []string{"a"},
+		},
+		{
+			name:     "regex",
+			source:   `rule a { strings: $a = /ba+d/ condition: $a }`,
+			data:     "a baaad thing",
+			expected: []string{"a"},
+		},
+		{
+			name:     "string count",
+			source:   `rule a { strings: $a = "x" condition: #a >= 3 }`,
+			data:     "x x",
+			expected: []string{},
+		},
+		{
+			name:     "string at offset",
+			source:   `rule a { strings: $a = "MZ" condition: $a at 0 }`,
+			data:     "MZ header",
+			expected: []string{"a"},
+		},
+		{
+			name:     "of quantifier",
+			source:   `rule a { strings: $a = "one" $b = "two" $c = "three" condition: 2 of them }`,
+			data:     "one three",
+			expected: []string{"a"},
+		},
+		{
+			name:     "filesize",
+			source:   `rule a { condition: filesize < 4 }`,
+			data:     "12345",
+			expected: []string{},
+		},
+		{
+			name:     "integer read",
+			source:   `rule a { condition: uint16(0) == 0x5A4D }`,
+			data:     "MZ",
+			expected: []string{"a"},
+		},
+		{
+			name:     "private rule reference",
+			source:   `private rule p { strings: $a = "x" condition: $a } rule a { condition: p }`,
+			data:     "x",
+			expected: []string{"a"},
+		},
+		{
+			name:     "global rule not matching",
+			source:   `global rule g { condition: filesize > 100 } rule a { condition: true }`,
+			data:     "x",
+			expected: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules, err := compileYaraRules(test.source)
+			if err != nil {
+				t.Fatalf("compileYaraRules(%q) failed: %s", test.source, err)
+			}
+
+			names := getYaraRuleNames(rules.scan([]byte(test.data)))
+			if !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("scan(%q) matched %v, expected %v", test.data, names, test.expected)
+			}
+		})
+	}
+}
+
+func TestYaraRulesScanStringMatches(t *testing.T) {
+	rules, err := compileYaraRules(`rule a { strings: $a = "ab" $b = "zz" private condition: $a }`)
+	if err != nil {
+		t.Fatalf("compileYaraRules failed: %s", err)
+	}
+
+	matches := rules.scan([]byte("ab ab zz"))
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 match, got %d", len(matches))
+	}
+
+	expected := []CslYaraStringMatch{{Id: "$a", Count: 2, Offsets: []int{0, 3}, Data: "6162"}}
+	if !reflect.DeepEqual(matches[0].Strings, expected) {
+		t.Errorf("got string matches %+v, expected %+v", matches[0].Strings, expected)
+	}
+}
@@ -411,6 +411,12 @@ func createNewUser(username, password, role, apikey string, org shuffle.OrgMini)
 	}
 
 	ctx := context.Background()
+	err = validateCslPassword(getCslPasswordPolicy(ctx, org.Id), password)
+	if err != nil {
+		log.Printf("[WARNING] Password doesn't match the policy of org %s: %s", org.Id, err)
+		return err
+	}
+
 	//users, err := FindUser(ctx context.Context, username string) ([]User, error) {
 
 	users, err := shuffle.FindUser(ctx, strings.ToLower(strings.TrimSpace(username)))
@@ -1805,14 +1811,15 @@ func handleWebhookCallback(resp http.ResponseWriter, request *http.Request) {
 	}
 
 	// ID: webhook_<UID>
-	if len(hookId) != 44 {
-		log.Printf("[INFO] Couldn't handle hookId. Too short in webhook: %d", len(hookId))
+	parsedHookId, ok := getCslWebhookHookId(hookId)
+	if !ok {
+		log.Printf("[INFO] Couldn't handle hookId. Invalid webhook key with length %d", len(hookId))
 		resp.WriteHeader(401)
 		resp.Write([]byte(`{"success": false, "reason": "Hook ID not valid"}`))
 		return
 	}
 
-	hookId = hookId[8:len(hookId)]
+	hookId = parsedHookId
 
 	//log.Printf("HookID: %s", hookId)
 	hook, err := shuffle.GetHook(ctx, hookId)
@@ -3345,6 +3352,10 @@ func handleCloudExecutionOnprem(workflowId, startNode, executionSource, executio
 		return err
 	}
 
+	if executionSource == "schedule" && holdCslScheduledExecution(ctx, workflow.OrgId, workflowId, fmt.Sprintf("%s_%s", workflowId, startNode), b) {
+		return nil
+	}
+
 	//log.Println(string(b))
 	newRequest := &http.Request{
 		URL:    &url.URL{},
@@ -3831,6 +3842,10 @@ func runInitEs(ctx context.Context) {
 		url := &url.URL{}
 		job := func(schedule shuffle.ScheduleOld) func() {
 			return func() {
+				if !isCslLeader() {
+					return
+				}
+
 				log.Printf("[INFO] Running schedule %s with interval %d.", schedule.Id, schedule.Seconds)
 
 				request := &http.Request{
@@ -3874,7 +3889,9 @@ func runInitEs(ctx context.Context) {
 				log.Printf("[DEBUG] Successfully started schedule for workflow %s", schedule.WorkflowId)
 			}
 
+			scheduledJobsLock.Lock()
 			scheduledJobs[schedule.Id] = jobret
+			scheduledJobsLock.Unlock()
 		}
 	}
 
@@ -3977,6 +3994,10 @@ func runInitEs(ctx context.Context) {
 
 		log.Printf("[DEBUG] Should start cloud schedule for org %s (%s)", org.Name, org.Id)
 		job := func() {
+			if !isCslLeader() {
+				return
+			}
+
 			err := remoteOrgJobHandler(org, interval)
 			if err != nil {
 				log.Printf("[ERROR] Failed request with remote org sync for org %s (2): %s", org.Id, err)
@@ -4038,6 +4059,10 @@ func runInitEs(ctx context.Context) {
 
 		cleanupJob := func() func() {
 			return func() {
+				if !isCslLeader() {
+					return
+				}
+
 				log.Printf("[INFO] Running schedule for cleaning up or re-running unfinished workflows in %d environments.", len(environments))
 
 				for _, environment := range environments {
@@ -4218,6 +4243,10 @@ func runInitEs(ctx context.Context) {
 		healthcheckInterval := 30
 		log.Printf("[INFO] Starting healthcheck job every %d minute. Stats available on /api/v1/health/stats. Disable with SHUFFLE_HEALTHCHECK_DISABLED=true", healthcheckInterval)
 		job := func() {
+			if !isCslLeader() {
+				return
+			}
+
 			// Prepare a fake http.responsewriter
 			resp := httptest.NewRecorder()
 
@@ -4589,6 +4618,10 @@ func handleCloudSetup(resp http.ResponseWriter, request *http.Request) {
 	interval := int(responseData.IntervalSeconds)
 	log.Printf("[INFO] Starting cloud sync on interval %d", interval)
 	job := func() {
+		if !isCslLeader() {
+			return
+		}
+
 		err := remoteOrgJobHandler(*org, interval)
 		if err != nil {
 			log.Printf("[ERROR] Failed request with remote org sync (1): %s", err)
@@ -4881,6 +4914,7 @@ func handleAppZipUpload(resp http.ResponseWriter, request *http.Request) {
 func initHandlers() {
 	var err error
 	ctx := context.Background()
+	initCslLogLevel()
 
 	log.Printf("[DEBUG] Starting Shuffle backend - initializing database connection")
 	//requestCache = cache.New(5*time.Minute, 10*time.Minute)
@@ -4907,6 +4941,10 @@ func initHandlers() {
 	if elasticConfig == "elasticsearch" {
 		time.Sleep(10 * time.Second)
 		go runInitEs(ctx)
+		initCslLeaderElection(ctx)
+		go initCslJobs(ctx)
+		initCslKafka()
+		initCslConfigReload(ctx)
 	} else {
 		//go shuffle.runInit(ctx)
 		log.Printf("[ERROR] Opensearch is the only viable option. Please set SHUFFLE_ELASTIC=true")
@@ -4920,8 +4958,8 @@ func initHandlers() {
 
 	// Make user related locations
 	// Fix user changes with org
-	r.HandleFunc("/api/v1/users/login", shuffle.HandleLogin).Methods("POST", "OPTIONS")
-	r.HandleFunc("/api/v1/users/register", handleRegister).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/v1/users/login", cslGeoOnLogin(cslPasswordOnLogin(cslMfaOnLogin(shuffle.HandleLogin)))).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/v1/users/register", cslLifecycleOnRegister(handleRegister)).Methods("POST", "OPTIONS")
 	r.HandleFunc("/api/v1/users/checkusers", checkAdminLogin).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/users/getinfo", handleInfo).Methods("GET", "OPTIONS")
 
@@ -4931,23 +4969,23 @@ func initHandlers() {
 	r.HandleFunc("/api/v1/users/getsettings", shuffle.HandleSettings).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/users/getusers", shuffle.HandleGetUsers).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/users/updateuser", shuffle.HandleUpdateUser).Methods("PUT", "OPTIONS")
-	r.HandleFunc("/api/v1/users/{userID}/remove", shuffle.HandleDeleteUsersAccount).Methods("DELETE", "OPTIONS")
-	r.HandleFunc("/api/v1/users/{user}", shuffle.DeleteUser).Methods("DELETE", "OPTIONS")
-	r.HandleFunc("/api/v1/users/passwordchange", shuffle.HandlePasswordChange).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/v1/users/{userID}/remove", cslLifecycleOnUserRemove(shuffle.HandleDeleteUsersAccount)).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/api/v1/users/{user}", cslLifecycleOnUserRemove(shuffle.DeleteUser)).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/api/v1/users/passwordchange", cslPasswordOnChange(shuffle.HandlePasswordChange)).Methods("POST", "OPTIONS")
 	r.HandleFunc("/api/v1/users/{key}/get2fa", shuffle.HandleGet2fa).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/users/{key}/set2fa", shuffle.HandleSet2fa).Methods("POST", "OPTIONS")
 	r.HandleFunc("/api/v1/users", shuffle.HandleGetUsers).Methods("GET", "OPTIONS")
 
 	// General - duplicates and old.
 	r.HandleFunc("/api/v1/getusers", shuffle.HandleGetUsers).Methods("GET", "OPTIONS")
-	r.HandleFunc("/api/v1/login", shuffle.HandleLogin).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/v1/login", cslGeoOnLogin(cslPasswordOnLogin(cslMfaOnLogin(shuffle.HandleLogin)))).Methods("POST", "OPTIONS")
 	r.HandleFunc("/api/v1/logout", shuffle.HandleLogout).Methods("POST", "OPTIONS")
-	r.HandleFunc("/api/v1/register", handleRegister).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/v1/register", cslLifecycleOnRegister(handleRegister)).Methods("POST", "OPTIONS")
 	r.HandleFunc("/api/v1/checkusers", checkAdminLogin).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/getinfo", handleInfo).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/getsettings", shuffle.HandleSettings).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/generateapikey", shuffle.HandleApiGeneration).Methods("GET", "POST", "OPTIONS")
-	r.HandleFunc("/api/v1/passwordchange", shuffle.HandlePasswordChange).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/v1/passwordchange", cslPasswordOnChange(shuffle.HandlePasswordChange)).Methods("POST", "OPTIONS")
 
 	r.HandleFunc("/api/v1/getenvironments", shuffle.HandleGetEnvironments).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/setenvironments", shuffle.HandleSetEnvironments).Methods("PUT", "OPTIONS")
@@ -4973,7 +5011,7 @@ func initHandlers() {
 	r.HandleFunc("/api/v1/apps/categories", shuffle.GetActiveCategories).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/apps/categories/run", shuffle.RunCategoryAction).Methods("POST", "OPTIONS")
 	r.HandleFunc("/api/v1/apps/upload", handleAppZipUpload).Methods("POST", "OPTIONS")
-	r.HandleFunc("/api/v1/apps/{appId}/activate", activateWorkflowAppDocker).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/v1/apps/{appId}/activate", cslLifecycleOnAppActivate(activateWorkflowAppDocker)).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/apps/frameworkConfiguration", shuffle.GetFrameworkConfiguration).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/apps/frameworkConfiguration", shuffle.SetFrameworkConfiguration).Methods("POST", "OPTIONS")
 	r.HandleFunc("/api/v1/apps/{appId}", shuffle.UpdateWorkflowAppConfig).Methods("PATCH", "OPTIONS")
@@ -4988,9 +5026,9 @@ func initHandlers() {
 	r.HandleFunc("/api/v1/apps/search", getSpecificApps).Methods("POST", "OPTIONS")
 
 	r.HandleFunc("/api/v1/apps/authentication", shuffle.GetAppAuthentication).Methods("GET", "OPTIONS")
-	r.HandleFunc("/api/v1/apps/authentication", shuffle.AddAppAuthentication).Methods("PUT", "OPTIONS")
-	r.HandleFunc("/api/v1/apps/authentication/{appauthId}/config", shuffle.SetAuthenticationConfig).Methods("POST", "OPTIONS")
-	r.HandleFunc("/api/v1/apps/authentication/{appauthId}", shuffle.DeleteAppAuthentication).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/api/v1/apps/authentication", cslLifecycleOnAuthChange(shuffle.AddAppAuthentication)).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/api/v1/apps/authentication/{appauthId}/config", cslLifecycleOnAuthChange(shuffle.SetAuthenticationConfig)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/v1/apps/authentication/{appauthId}", cslLifecycleOnAuthChange(shuffle.DeleteAppAuthentication)).Methods("DELETE", "OPTIONS")
 
 	// Related to use-cases that are not directly workflows.
 	r.HandleFunc("/api/v1/workflows/usecases/{key}", shuffle.HandleGetUsecase).Methods("GET", "OPTIONS")
@@ -5006,22 +5044,22 @@ func initHandlers() {
 	// FIXME - implement the queue counter lol
 	/* Everything below here increases the counters*/
 	r.HandleFunc("/api/v1/workflows", shuffle.GetWorkflows).Methods("GET", "OPTIONS")
-	r.HandleFunc("/api/v1/workflows", shuffle.SetNewWorkflow).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/v1/workflows", cslLifecycleOnWorkflowCreate(shuffle.SetNewWorkflow)).Methods("POST", "OPTIONS")
 	r.HandleFunc("/api/v1/workflows/search", shuffle.HandleWorkflowRunSearch).Methods("POST", "OPTIONS")
 	r.HandleFunc("/api/v1/workflows/schedules", shuffle.HandleGetSchedules).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/workflows/{key}/executions", shuffle.GetWorkflowExecutions).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/workflows/{key}/executions/count", shuffle.HandleGetWorkflowRunCount).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/workflows/{key}/executions/{key}/rerun", checkUnfinishedExecution).Methods("GET", "POST", "OPTIONS")
-	r.HandleFunc("/api/v1/workflows/{key}/executions/{key}/abort", shuffle.AbortExecution).Methods("GET", "OPTIONS")
-	r.HandleFunc("/api/v1/workflows/{key}/schedule", scheduleWorkflow).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/v1/workflows/{key}/executions/{key}/abort", cslKafkaOnAbort(shuffle.AbortExecution)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/v1/workflows/{key}/schedule", cslScheduleInterval(scheduleWorkflow)).Methods("POST", "OPTIONS")
 	r.HandleFunc("/api/v1/workflows/download_remote", loadSpecificWorkflows).Methods("POST", "OPTIONS")
-	r.HandleFunc("/api/v1/workflows/{key}/run", executeWorkflow).Methods("GET", "POST", "OPTIONS")
-	r.HandleFunc("/api/v1/workflows/{key}/execute", executeWorkflow).Methods("GET", "POST", "OPTIONS")
+	r.HandleFunc("/api/v1/workflows/{key}/run", cslApprovalsOnAnswer(cslIdempotency(cslBackpressure(executeWorkflow)))).Methods("GET", "POST", "OPTIONS")
+	r.HandleFunc("/api/v1/workflows/{key}/execute", cslApprovalsOnAnswer(cslIdempotency(cslBackpressure(executeWorkflow)))).Methods("GET", "POST", "OPTIONS")
 	r.HandleFunc("/api/v1/workflows/{key}/schedule/{schedule}", stopSchedule).Methods("DELETE", "OPTIONS")
 	r.HandleFunc("/api/v1/workflows/{key}/stream", shuffle.HandleStreamWorkflow).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/workflows/{key}/stream", shuffle.HandleStreamWorkflowUpdate).Methods("POST", "OPTIONS")
-	r.HandleFunc("/api/v1/workflows/{key}", deleteWorkflow).Methods("DELETE", "OPTIONS")
-	r.HandleFunc("/api/v1/workflows/{key}", shuffle.SaveWorkflow).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/api/v1/workflows/{key}", cslLifecycleOnWorkflowDelete(deleteWorkflow)).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/api/v1/workflows/{key}", cslAppPinsOnSave(cslGitSyncOnSave(shuffle.SaveWorkflow))).Methods("PUT", "OPTIONS")
 	r.HandleFunc("/api/v1/workflows/{key}", shuffle.GetSpecificWorkflow).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/workflows/recommend", shuffle.HandleActionRecommendation).Methods("POST", "OPTIONS")
 
@@ -5036,7 +5074,7 @@ func initHandlers() {
 	// Triggers
 	r.HandleFunc("/api/v1/hooks/new", shuffle.HandleNewHook).Methods("POST", "OPTIONS")
 	r.HandleFunc("/api/v1/hooks", shuffle.HandleNewHook).Methods("POST", "OPTIONS")
-	r.HandleFunc("/api/v1/hooks/{key}", handleWebhookCallback).Methods("POST", "GET", "PATCH", "PUT", "DELETE", "OPTIONS")
+	r.HandleFunc("/api/v1/hooks/{key}", cslWebhookSecurity(cslIdempotency(cslBackpressure(cslGeoOnWebhook(handleWebhookCallback))))).Methods("POST", "GET", "PATCH", "PUT", "DELETE", "OPTIONS")
 	r.HandleFunc("/api/v1/hooks/{key}/delete", shuffle.HandleDeleteHook).Methods("DELETE", "OPTIONS")
 	r.HandleFunc("/api/v1/hooks/{key}", shuffle.HandleDeleteHook).Methods("DELETE", "OPTIONS")
 
@@ -5107,7 +5145,7 @@ func initHandlers() {
 
 	// Docker orborus specific - downloads an image
 	r.HandleFunc("/api/v1/get_docker_image", getDockerImage).Methods("POST", "OPTIONS")
-	r.HandleFunc("/api/v1/login_sso", shuffle.HandleSSO).Methods("GET", "POST", "OPTIONS")
+	r.HandleFunc("/api/v1/login_sso", cslSamlLogin).Methods("GET", "POST", "OPTIONS")
 	r.HandleFunc("/api/v1/login_openid", shuffle.HandleOpenId).Methods("GET", "POST", "OPTIONS")
 
 	// Important for email, IDS etc. Create this by:
@@ -5135,7 +5173,7 @@ func initHandlers() {
 	r.HandleFunc("/api/v1/users/notifications/clear", shuffle.HandleClearNotifications).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/users/notifications/{notificationId}/markasread", shuffle.HandleMarkAsRead).Methods("GET", "OPTIONS")
 
-	r.HandleFunc("/api/v1/conversation", shuffle.RunActionAI).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/v1/conversation", cslFeatureGate(FeatureAiEndpoints, shuffle.RunActionAI)).Methods("POST", "OPTIONS")
 
 	//r.HandleFunc("/api/v1/users/notifications/{notificationId}/markasread", shuffle.HandleMarkAsRead).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/v1/dashboards/{key}/widgets", shuffle.HandleNewWidget).Methods("POST", "OPTIONS")
@@ -5144,17 +5182,362 @@ func initHandlers() {
 	// CSL Endpoints
 
 	// Dashboard
-	r.HandleFunc("/api/v1/csl/testSuccess", cslTestSuccess).Methods("GET")
-	r.HandleFunc("/api/v1/csl/testFailure", cslTestFailure).Methods("GET")
 	r.HandleFunc("/api/v1/csl/workflows", cslWorkflows).Methods("GET")
 	r.HandleFunc("/api/v1/csl/apps", cslApps).Methods("GET")
 	r.HandleFunc("/api/v1/csl/apiUsage", cslApiUsage).Methods("GET")
+	r.HandleFunc("/api/v1/csl/apiUsage/breakdown", cslApiUsageBreakdown).Methods("GET")
 	r.HandleFunc("/api/v1/csl/workflowExecutions", cslWorkflowExecutions).Methods("GET")
+	r.HandleFunc("/api/v1/csl/workflowExecutions/cancel", cslBulkCancelExecutions).Methods("POST")
 	r.HandleFunc("/api/v1/csl/workflowChart", cslWorkflowChart).Methods("GET")
 	r.HandleFunc("/api/v1/csl/appChart", cslAppChart).Methods("GET")
-
+	r.HandleFunc("/api/v1/csl/appUsage", cslAppUsage).Methods("GET")
+	r.HandleFunc("/api/v1/csl/executionHeatmap", cslExecutionHeatmap).Methods("GET")
+	r.HandleFunc("/api/v1/csl/forecast", cslForecast).Methods("GET")
+	r.HandleFunc("/api/v1/csl/trends", cslTrends).Methods("GET")
+	r.HandleFunc("/api/v1/csl/triggers", cslTriggerStats).Methods("GET")
+	r.HandleFunc("/api/v1/csl/failures", cslFailureStats).Methods("GET")
+	r.HandleFunc("/api/v1/csl/activity", cslActivityFeed).Methods("GET")
+	r.HandleFunc("/api/v1/csl/healthScore", cslHealthScore).Methods("GET")
+	r.HandleFunc("/api/v1/csl/fleetHealth", cslFleetHealth).Methods("GET")
+	r.HandleFunc("/api/v1/csl/queueBacklog", cslQueueBacklog).Methods("GET")
+	r.HandleFunc("/api/v1/csl/metrics", cslMetrics).Methods("GET")
+	r.HandleFunc("/api/v1/csl/workflowQuality", cslWorkflowQuality).Methods("GET")
+	r.HandleFunc("/api/v1/csl/statsBackfill", cslGetStatsBackfill).Methods("GET")
+	r.HandleFunc("/api/v1/csl/statsBackfill", cslStartStatsBackfill).Methods("POST")
+	r.HandleFunc("/api/v1/csl/synthetic", cslGetSyntheticData).Methods("GET")
+	r.HandleFunc("/api/v1/csl/synthetic", cslStartSyntheticData).Methods("POST")
+	r.HandleFunc("/api/v1/csl/synthetic", cslDeleteSyntheticData).Methods("DELETE")
+	r.HandleFunc("/api/v1/csl/chaos", cslGetChaos).Methods("GET")
+	r.HandleFunc("/api/v1/csl/chaos", cslSetChaos).Methods("POST")
+	r.HandleFunc("/api/v1/csl/alerts", cslOpenAlerts).Methods("GET")
+	r.HandleFunc("/api/v1/csl/timeline", cslExecutionTimeline).Methods("GET")
+	r.HandleFunc("/api/v1/csl/pii", cslPiiCounts).Methods("GET")
+	r.HandleFunc("/api/v1/csl/pii/findings", cslPiiFindings).Methods("GET")
+	r.HandleFunc("/api/v1/csl/pii/scan", cslStartPiiScan).Methods("POST")
+
+	// Settings
+	r.HandleFunc("/api/v1/csl/settings", cslGetSettings).Methods("GET")
+	r.HandleFunc("/api/v1/csl/settings", cslSetSettings).Methods("POST")
+
+	// Credentials
+	r.HandleFunc("/api/v1/csl/credentials/expiry", cslCredentialExpiry).Methods("GET")
+	r.HandleFunc("/api/v1/csl/credentials/usage", cslGetCredentialUsage).Methods("GET")
+
+	// Workflow versions
+	r.HandleFunc("/api/v1/csl/workflowVersions", cslWorkflowVersions).Methods("GET")
+	r.HandleFunc("/api/v1/csl/workflowVersions/diff", cslWorkflowVersionDiff).Methods("GET")
+	r.HandleFunc("/api/v1/csl/workflowVersions/rollback", cslWorkflowRollback).Methods("POST")
+	r.HandleFunc("/api/v1/csl/workflowApps", cslWorkflowApps).Methods("GET")
+	r.HandleFunc("/api/v1/csl/workflowApps/pin", cslPinWorkflowApp).Methods("POST")
+	r.HandleFunc("/api/v1/csl/workflowApps/unpin", cslUnpinWorkflowApp).Methods("POST")
+	r.HandleFunc("/api/v1/csl/workflowApps/diff", cslWorkflowAppDiff).Methods("GET")
+	r.HandleFunc("/api/v1/csl/workflowApps/upgrade", cslUpgradeWorkflowApp).Methods("POST")
+
+	// Git sync
+	r.HandleFunc("/api/v1/csl/gitSync", cslGetGitSync).Methods("GET")
+	r.HandleFunc("/api/v1/csl/gitSync", cslSetGitSync).Methods("POST")
+	r.HandleFunc("/api/v1/csl/gitSync/pull", cslGitSyncPull).Methods("POST")
+	r.HandleFunc("/api/v1/csl/gitSync/resolve", cslGitSyncResolve).Methods("POST")
+	r.HandleFunc("/api/v1/csl/gitSync/webhook", cslGitSyncWebhook).Methods("POST")
+
+	// Bundles
+	r.HandleFunc("/api/v1/csl/bundles/export", cslExportBundle).Methods("GET")
+	r.HandleFunc("/api/v1/csl/bundles/import", cslImportBundle).Methods("POST")
+
+	// SSO
+	r.HandleFunc("/api/v1/csl/sso/roleMapping", cslGetSsoRoleMapping).Methods("GET")
+	r.HandleFunc("/api/v1/csl/sso/roleMapping", cslSetSsoRoleMapping).Methods("POST")
+	r.HandleFunc("/api/v1/csl/sso/oidc", cslGetOidcSettings).Methods("GET")
+	r.HandleFunc("/api/v1/csl/sso/oidc", cslSetOidcSettings).Methods("POST")
+	r.HandleFunc("/api/v1/csl/login/oidc", cslOidcLogin).Methods("GET")
+	r.HandleFunc("/api/v1/csl/login/oidc/callback", cslOidcCallback).Methods("GET")
+	r.HandleFunc("/api/v1/csl/sso/saml", cslGetSamlSettings).Methods("GET")
+	r.HandleFunc("/api/v1/csl/sso/saml", cslSetSamlSettings).Methods("POST")
+	r.HandleFunc("/api/v1/csl/login/saml", cslSamlLoginStart).Methods("GET")
+
+	// Api keys
+	r.HandleFunc("/api/v1/csl/apiKeys", cslListApiKeys).Methods("GET")
+	r.HandleFunc("/api/v1/csl/apiKeys", cslCreateApiKey).Methods("POST")
+	r.HandleFunc("/api/v1/csl/apiKeys/rotate", cslRotateApiKey).Methods("POST")
+	r.HandleFunc("/api/v1/csl/apiKeys/revoke", cslRevokeApiKey).Methods("POST")
+
+	// IP allowlist
+	r.HandleFunc("/api/v1/csl/ipAllowlist", cslGetIpAllowlist).Methods("GET")
+	r.HandleFunc("/api/v1/csl/ipAllowlist", cslSetIpAllowlist).Methods("POST")
+
+	// CORS
+	r.HandleFunc("/api/v1/csl/cors", cslGetCorsSettings).Methods("GET")
+	r.HandleFunc("/api/v1/csl/cors", cslSetCorsSettings).Methods("POST")
+
+	// Webhook security
+	r.HandleFunc("/api/v1/csl/webhookSecurity", cslGetWebhookSecurity).Methods("GET")
+	r.HandleFunc("/api/v1/csl/webhookSecurity", cslSetWebhookSecurity).Methods("POST")
+
+	// Lifecycle webhooks
+	r.HandleFunc("/api/v1/csl/lifecycleWebhooks", cslGetLifecycleWebhooks).Methods("GET")
+	r.HandleFunc("/api/v1/csl/lifecycleWebhooks", cslSetLifecycleWebhooks).Methods("POST")
+	r.HandleFunc("/api/v1/csl/lifecycleWebhooks/deliveries", cslGetLifecycleDeliveries).Methods("GET")
+	r.HandleFunc("/api/v1/csl/lifecycleWebhooks/test", cslTestLifecycleWebhook).Methods("POST")
+
+	// Roles
+	r.HandleFunc("/api/v1/csl/roles", cslGetRoles).Methods("GET")
+	r.HandleFunc("/api/v1/csl/roles", cslSetRoles).Methods("POST")
+	r.HandleFunc("/api/v1/csl/roles/assign", cslAssignRole).Methods("POST")
+	r.HandleFunc("/api/v1/csl/roles/me", cslGetMyPermissions).Methods("GET")
+
+	// Workflow access
+	r.HandleFunc("/api/v1/csl/workflowAcl", cslGetWorkflowAcl).Methods("GET")
+	r.HandleFunc("/api/v1/csl/workflowAcl", cslSetWorkflowAcl).Methods("POST")
+	r.HandleFunc("/api/v1/csl/workflowAcl", cslDeleteWorkflowAcl).Methods("DELETE")
+
+	// Approvals
+	r.HandleFunc("/api/v1/csl/approvals", cslGetApprovals).Methods("GET")
+	r.HandleFunc("/api/v1/csl/approvals/approve", cslApproveApproval).Methods("POST")
+	r.HandleFunc("/api/v1/csl/approvals/reject", cslRejectApproval).Methods("POST")
+	r.HandleFunc("/api/v1/csl/approvals/policies", cslGetApprovalPolicies).Methods("GET")
+	r.HandleFunc("/api/v1/csl/approvals/policies", cslSetApprovalPolicies).Methods("POST")
+
+	// Secrets
+	r.HandleFunc("/api/v1/csl/secretsBackend", cslGetSecretsBackend).Methods("GET")
+	r.HandleFunc("/api/v1/csl/secretsBackend", cslSetSecretsBackend).Methods("POST")
+	r.HandleFunc("/api/v1/csl/encryption", cslGetEncryptionStatus).Methods("GET")
+	r.HandleFunc("/api/v1/csl/encryption/migrate", cslMigrateEncryption).Methods("POST")
+
+	// Redaction
+	r.HandleFunc("/api/v1/csl/redaction", cslGetRedactionRules).Methods("GET")
+	r.HandleFunc("/api/v1/csl/redaction", cslSetRedactionRule).Methods("POST")
+	r.HandleFunc("/api/v1/csl/redaction", cslDeleteRedactionRule).Methods("DELETE")
+	r.HandleFunc("/api/v1/csl/redaction/test", cslTestRedactionRules).Methods("POST")
+
+	// Data subjects
+	r.HandleFunc("/api/v1/csl/dataSubject/delete", cslDeleteDataSubject).Methods("POST")
+	r.HandleFunc("/api/v1/csl/dataSubject/reports", cslDataSubjectReports).Methods("GET")
+
+	// Org export
+	r.HandleFunc("/api/v1/csl/orgExport", cslGetOrgExports).Methods("GET")
+	r.HandleFunc("/api/v1/csl/orgExport", cslStartOrgExport).Methods("POST")
+	r.HandleFunc("/api/v1/csl/orgExport/download", cslDownloadOrgExport).Methods("GET")
+	r.HandleFunc("/api/v1/csl/orgImport", cslImportOrg).Methods("POST")
+
+	// Replication
+	r.HandleFunc("/api/v1/csl/replication", cslGetReplicationStatus).Methods("GET")
+	r.HandleFunc("/api/v1/csl/replication/sync", cslSyncReplication).Methods("POST")
+	r.HandleFunc("/api/v1/csl/replication/receive", cslReceiveReplication).Methods("POST")
+
+	// Leader election
+	r.HandleFunc("/api/v1/csl/leader", cslGetLeaderStatus).Methods("GET")
+
+	// Feature flags
+	r.HandleFunc("/api/v1/csl/featureFlags", cslGetFeatureFlags).Methods("GET")
+	r.HandleFunc("/api/v1/csl/featureFlags", cslSetFeatureFlag).Methods("POST")
+	r.HandleFunc("/api/v1/csl/featureFlags", cslDeleteFeatureFlag).Methods("DELETE")
+	r.HandleFunc("/api/v1/csl/featureFlags/evaluate", cslEvaluateFeatureFlags).Methods("GET")
+
+	// Config reload
+	r.HandleFunc("/api/v1/csl/config/reload", cslGetConfigReload).Methods("GET")
+	r.HandleFunc("/api/v1/csl/config/reload", cslReloadConfig).Methods("POST")
+	r.HandleFunc("/api/v1/csl/logLevel", cslGetLogLevel).Methods("GET")
+	r.HandleFunc("/api/v1/csl/logLevel", cslSetLogLevel).Methods("POST")
+	r.HandleFunc("/api/v1/csl/endpointStats", cslGetEndpointStats).Methods("GET")
+	r.HandleFunc("/api/v1/csl/slowQueries", cslGetSlowQueries).Methods("GET")
+	addCslDebugRoutes(r, "/api/v1/csl/debug")
+
+	// Quotas
+	r.HandleFunc("/api/v1/csl/quotas", cslGetQuotas).Methods("GET")
+	r.HandleFunc("/api/v1/csl/quotas", cslSetQuotas).Methods("POST")
+	r.HandleFunc("/api/v1/csl/executionPriorities", cslGetExecutionPriorities).Methods("GET")
+	r.HandleFunc("/api/v1/csl/executionPriorities", cslSetExecutionPriorities).Methods("POST")
+	r.HandleFunc("/api/v1/csl/executionTimeouts", cslGetExecutionTimeouts).Methods("GET")
+	r.HandleFunc("/api/v1/csl/executionTimeouts", cslSetExecutionTimeouts).Methods("POST")
+	r.HandleFunc("/api/v1/csl/retryPolicies", cslGetRetryPolicies).Methods("GET")
+	r.HandleFunc("/api/v1/csl/retryPolicies", cslSetRetryPolicies).Methods("POST")
+	r.HandleFunc("/api/v1/csl/nodeStats", cslNodeStats).Methods("GET")
+	r.HandleFunc("/api/v1/csl/schedules/preview", cslSchedulePreview).Methods("GET")
+	r.HandleFunc("/api/v1/csl/maintenanceWindows", cslGetMaintenanceWindows).Methods("GET")
+	r.HandleFunc("/api/v1/csl/maintenanceWindows", cslSetMaintenanceWindows).Methods("POST")
+	r.HandleFunc("/api/v1/csl/maintenanceWindows/stats", cslMaintenanceStats).Methods("GET")
+
+	// Export
+	r.HandleFunc("/api/v1/csl/export/s3", cslGetS3Export).Methods("GET")
+	r.HandleFunc("/api/v1/csl/export/s3", cslSetS3Export).Methods("POST")
+	r.HandleFunc("/api/v1/csl/export/s3/run", cslRunS3Export).Methods("POST")
+
+	// Digest
+	r.HandleFunc("/api/v1/csl/digest", cslGetDigest).Methods("GET")
+	r.HandleFunc("/api/v1/csl/digest", cslSetDigest).Methods("POST")
+	r.HandleFunc("/api/v1/csl/digest/preview", cslPreviewDigest).Methods("GET")
+	r.HandleFunc("/api/v1/csl/digest/send", cslSendDigest).Methods("POST")
+
+	// Notifications
+	r.HandleFunc("/api/v1/csl/notifications/slack", cslGetSlack).Methods("GET")
+	r.HandleFunc("/api/v1/csl/notifications/slack", cslSetSlack).Methods("POST")
+	r.HandleFunc("/api/v1/csl/notifications/slack/test", cslTestSlack).Methods("POST")
+	r.HandleFunc("/api/v1/csl/notifications/teams", cslGetTeams).Methods("GET")
+	r.HandleFunc("/api/v1/csl/notifications/teams", cslSetTeams).Methods("POST")
+	r.HandleFunc("/api/v1/csl/notifications/teams/test", cslTestTeams).Methods("POST")
+	r.HandleFunc("/api/v1/csl/notifications/pagerduty", cslGetPagerDuty).Methods("GET")
+	r.HandleFunc("/api/v1/csl/notifications/pagerduty", cslSetPagerDuty).Methods("POST")
+	r.HandleFunc("/api/v1/csl/notifications/pagerduty/test", cslTestPagerDuty).Methods("POST")
+	r.HandleFunc("/api/v1/csl/notifications/preferences", cslGetNotificationPreferences).Methods("GET")
+	r.HandleFunc("/api/v1/csl/notifications/preferences", cslSetNotificationPreferences).Methods("POST")
+
+	// Ticketing
+	r.HandleFunc("/api/v1/csl/ticketing/jira", cslGetJira).Methods("GET")
+	r.HandleFunc("/api/v1/csl/ticketing/jira", cslSetJira).Methods("POST")
+	r.HandleFunc("/api/v1/csl/ticketing/jira/test", cslTestJira).Methods("POST")
+	r.HandleFunc("/api/v1/csl/ticketing/jira/issues", cslListJiraIssues).Methods("GET")
+	r.HandleFunc("/api/v1/csl/ticketing/jira/issues", cslCreateJiraIssue).Methods("POST")
+	r.HandleFunc("/api/v1/csl/ticketing/jira/comment", cslAddJiraComment).Methods("POST")
+	r.HandleFunc("/api/v1/csl/ticketing/servicenow", cslGetServiceNow).Methods("GET")
+	r.HandleFunc("/api/v1/csl/ticketing/servicenow", cslSetServiceNow).Methods("POST")
+	r.HandleFunc("/api/v1/csl/ticketing/servicenow/test", cslTestServiceNow).Methods("POST")
+	r.HandleFunc("/api/v1/csl/ticketing/servicenow/incidents", cslListServiceNowIncidents).Methods("GET")
+	r.HandleFunc("/api/v1/csl/ticketing/servicenow/incidents", cslCreateServiceNowIncident).Methods("POST")
+	r.HandleFunc("/api/v1/csl/ticketing/servicenow/incidents/state", cslUpdateServiceNowIncident).Methods("POST")
+
+	// Artifacts
+	r.HandleFunc("/api/v1/csl/artifacts", cslListArtifacts).Methods("GET")
+	r.HandleFunc("/api/v1/csl/artifacts", cslUploadArtifact).Methods("POST")
+	r.HandleFunc("/api/v1/csl/artifacts/download", cslDownloadArtifact).Methods("GET")
+	r.HandleFunc("/api/v1/csl/artifacts/link", cslLinkArtifact).Methods("POST")
+	r.HandleFunc("/api/v1/csl/artifacts/delete", cslDeleteArtifact).Methods("POST")
+	r.HandleFunc("/api/v1/csl/artifacts/quota", cslSetArtifactQuota).Methods("POST")
+
+	// Observables
+	r.HandleFunc("/api/v1/csl/observables", cslSearchObservables).Methods("GET")
+	r.HandleFunc("/api/v1/csl/observables/stats", cslObservableStats).Methods("GET")
+	r.HandleFunc("/api/v1/csl/observables/extract", cslExtractObservables).Methods("POST")
+	r.HandleFunc("/api/v1/csl/observables/config", cslGetObservableConfig).Methods("GET")
+	r.HandleFunc("/api/v1/csl/observables/config", cslSetObservableConfig).Methods("POST")
+
+	// YARA
+	r.HandleFunc("/api/v1/csl/yara/rulesets", cslListYaraRulesets).Methods("GET")
+	r.HandleFunc("/api/v1/csl/yara/rulesets", cslSetYaraRuleset).Methods("POST")
+	r.HandleFunc("/api/v1/csl/yara/rulesets/delete", cslDeleteYaraRuleset).Methods("POST")
+	r.HandleFunc("/api/v1/csl/yara/scan", cslYaraScan).Methods("POST")
+	r.HandleFunc("/api/v1/csl/yara/stats", cslYaraStats).Methods("GET")
+	r.HandleFunc("/api/v1/csl/yara/config", cslGetYaraConfig).Methods("GET")
+	r.HandleFunc("/api/v1/csl/yara/config", cslSetYaraConfig).Methods("POST")
+
+	// Detection
+	r.HandleFunc("/api/v1/csl/sigma/translate", cslTranslateSigma).Methods("POST")
+	r.HandleFunc("/api/v1/csl/siem", cslGetSiem).Methods("GET")
+	r.HandleFunc("/api/v1/csl/siem", cslSetSiem).Methods("POST")
+
+	// Sandbox
+	r.HandleFunc("/api/v1/csl/sandbox", cslGetSandbox).Methods("GET")
+	r.HandleFunc("/api/v1/csl/sandbox", cslSetSandbox).Methods("POST")
+	r.HandleFunc("/api/v1/csl/sandbox/test", cslTestSandbox).Methods("POST")
+	r.HandleFunc("/api/v1/csl/sandbox/submit", cslSubmitSandbox).Methods("POST")
+	r.HandleFunc("/api/v1/csl/sandbox/submissions", cslListSandboxSubmissions).Methods("GET")
+
+	// Enrichment
+	r.HandleFunc("/api/v1/csl/enrichment/virustotal", cslVirusTotalLookup).Methods("GET")
+	r.HandleFunc("/api/v1/csl/enrichment/virustotal/stats", cslVirusTotalStats).Methods("GET")
+	r.HandleFunc("/api/v1/csl/enrichment/virustotal/config", cslGetVirusTotalConfig).Methods("GET")
+	r.HandleFunc("/api/v1/csl/enrichment/virustotal/config", cslSetVirusTotalConfig).Methods("POST")
+	r.HandleFunc("/api/v1/csl/enrichment/ip", cslIpReputation).Methods("GET")
+	r.HandleFunc("/api/v1/csl/enrichment/ip/stats", cslIpReputationStats).Methods("GET")
+	r.HandleFunc("/api/v1/csl/enrichment/ip/config", cslGetIpReputationConfig).Methods("GET")
+	r.HandleFunc("/api/v1/csl/enrichment/ip/config", cslSetIpReputationConfig).Methods("POST")
+	r.HandleFunc("/api/v1/csl/enrichment/domain", cslDomainEnrichment).Methods("GET")
+	r.HandleFunc("/api/v1/csl/enrichment/domain/config", cslGetDomainEnrichmentConfig).Methods("GET")
+	r.HandleFunc("/api/v1/csl/enrichment/domain/config", cslSetDomainEnrichmentConfig).Methods("POST")
+
+	// Geo
+	r.HandleFunc("/api/v1/csl/geo/sources", cslGeoSources).Methods("GET")
+	r.HandleFunc("/api/v1/csl/geo/events", cslGeoEvents).Methods("GET")
+
+	// Sessions
+	r.HandleFunc("/api/v1/csl/sessions", cslListSessions).Methods("GET")
+	r.HandleFunc("/api/v1/csl/sessions/revoke", cslRevokeSession).Methods("POST")
+	r.HandleFunc("/api/v1/csl/sessions/revoke_all", cslRevokeAllSessions).Methods("POST")
+
+	// MFA
+	r.HandleFunc("/api/v1/csl/mfa", cslGetMfaStatus).Methods("GET")
+	r.HandleFunc("/api/v1/csl/mfa/enroll", cslEnrollMfa).Methods("POST")
+	r.HandleFunc("/api/v1/csl/mfa/verify", cslVerifyMfa).Methods("POST")
+	r.HandleFunc("/api/v1/csl/mfa/backup_codes", cslRegenerateMfaBackupCodes).Methods("POST")
+	r.HandleFunc("/api/v1/csl/mfa/disable", cslDisableMfa).Methods("POST")
+	r.HandleFunc("/api/v1/csl/mfa/reset", cslResetUserMfa).Methods("POST")
+	r.HandleFunc("/api/v1/csl/mfa/policy", cslGetMfaPolicy).Methods("GET")
+	r.HandleFunc("/api/v1/csl/mfa/policy", cslSetMfaPolicy).Methods("POST")
+
+	// Passwords
+	r.HandleFunc("/api/v1/csl/password/policy", cslGetPasswordPolicy).Methods("GET")
+	r.HandleFunc("/api/v1/csl/password/policy", cslSetPasswordPolicy).Methods("POST")
+	r.HandleFunc("/api/v1/csl/password/expired", cslChangeExpiredPassword).Methods("POST")
+	r.HandleFunc("/api/v1/csl/password/locked", cslGetLockedUsers).Methods("GET")
+	r.HandleFunc("/api/v1/csl/password/unlock", cslUnlockUser).Methods("POST")
+
+	// SCIM
+	r.HandleFunc("/api/v1/csl/scim", cslGetScimConfig).Methods("GET")
+	r.HandleFunc("/api/v1/csl/scim", cslSetScimConfig).Methods("POST")
+	r.HandleFunc("/api/v1/csl/scim/token", cslCreateScimToken).Methods("POST")
+	r.HandleFunc("/api/v1/csl/scim/v2/ServiceProviderConfig", cslScimServiceProviderConfig).Methods("GET")
+	r.HandleFunc("/api/v1/csl/scim/v2/ResourceTypes", cslScimResourceTypes).Methods("GET")
+	r.HandleFunc("/api/v1/csl/scim/v2/Users", cslScimListUsers).Methods("GET")
+	r.HandleFunc("/api/v1/csl/scim/v2/Users", cslScimCreateUser).Methods("POST")
+	r.HandleFunc("/api/v1/csl/scim/v2/Users/{key}", cslScimGetUser).Methods("GET")
+	r.HandleFunc("/api/v1/csl/scim/v2/Users/{key}", cslScimReplaceUser).Methods("PUT")
+	r.HandleFunc("/api/v1/csl/scim/v2/Users/{key}", cslScimPatchUser).Methods("PATCH")
+	r.HandleFunc("/api/v1/csl/scim/v2/Users/{key}", cslScimDeleteUser).Methods("DELETE")
+	r.HandleFunc("/api/v1/csl/scim/v2/Groups", cslScimListGroups).Methods("GET")
+	r.HandleFunc("/api/v1/csl/scim/v2/Groups", cslScimCreateGroup).Methods("POST")
+	r.HandleFunc("/api/v1/csl/scim/v2/Groups/{key}", cslScimGetGroup).Methods("GET")
+	r.HandleFunc("/api/v1/csl/scim/v2/Groups/{key}", cslScimReplaceGroup).Methods("PUT")
+	r.HandleFunc("/api/v1/csl/scim/v2/Groups/{key}", cslScimPatchGroup).Methods("PATCH")
+	r.HandleFunc("/api/v1/csl/scim/v2/Groups/{key}", cslScimDeleteGroup).Methods("DELETE")
+
+	// LDAP
+	r.HandleFunc("/api/v1/csl/ldap", cslGetLdapConfig).Methods("GET")
+	r.HandleFunc("/api/v1/csl/ldap", cslSetLdapConfig).Methods("POST")
+	r.HandleFunc("/api/v1/csl/ldap/sync", cslRunLdapSync).Methods("POST")
+	r.HandleFunc("/api/v1/csl/ldap/status", cslGetLdapStatus).Methods("GET")
+
+	// Sub-orgs
+	r.HandleFunc("/api/v1/csl/orgs/hierarchy", cslGetOrgHierarchy).Methods("GET")
+	r.HandleFunc("/api/v1/csl/orgs/children", cslAttachChildOrg).Methods("POST")
+	r.HandleFunc("/api/v1/csl/orgs/children/detach", cslDetachChildOrg).Methods("POST")
+
+	// Publishing
+	r.HandleFunc("/api/v1/csl/publications", cslListPublications).Methods("GET")
+	r.HandleFunc("/api/v1/csl/publications", cslPublishWorkflow).Methods("POST")
+	r.HandleFunc("/api/v1/csl/publications/unpublish", cslUnpublishWorkflow).Methods("POST")
+	r.HandleFunc("/api/v1/csl/publications/available", cslAvailablePublications).Methods("GET")
+	r.HandleFunc("/api/v1/csl/publications/install", cslInstallPublication).Methods("POST")
+	r.HandleFunc("/api/v1/csl/subscriptions", cslListSubscriptions).Methods("GET")
+
+	// Templates
+	r.HandleFunc("/api/v1/csl/templates", cslListTemplates).Methods("GET")
+	r.HandleFunc("/api/v1/csl/templates", cslSetTemplate).Methods("POST")
+	r.HandleFunc("/api/v1/csl/templates/template", cslGetTemplate).Methods("GET")
+	r.HandleFunc("/api/v1/csl/templates/categories", cslListTemplateCategories).Methods("GET")
+	r.HandleFunc("/api/v1/csl/templates/delete", cslDeleteTemplate).Methods("POST")
+	r.HandleFunc("/api/v1/csl/templates/install", cslInstallTemplate).Methods("POST")
+	r.HandleFunc("/api/v1/csl/templates/stats", cslTemplateStats).Methods("GET")
+
+	// App SDK
+	r.HandleFunc("/api/v1/csl/apps/validate", cslValidateApp).Methods("POST")
+	r.HandleFunc("/api/v1/csl/resourceLimits", cslGetResourceLimits).Methods("GET")
+	r.HandleFunc("/api/v1/csl/resourceLimits", cslSetResourceLimits).Methods("POST")
+	r.HandleFunc("/api/v1/csl/resourceLimits/effective", cslEffectiveResourceLimits).Methods("GET")
+
+	r.Use(cslRecoveryMiddleware)
+	r.Use(cslEndpointStatsMiddleware)
+	r.Use(cslTimeoutMiddleware)
 	r.Use(shuffle.RequestMiddleware)
-	http.Handle("/", r)
+	r.Use(cslApiKeyMiddleware)
+	r.Use(cslIpAllowlistMiddleware)
+	r.Use(cslRolesMiddleware)
+	r.Use(cslWorkflowAclMiddleware)
+	r.Use(cslApiUsageMiddleware)
+	r.Use(cslSessionActivityMiddleware)
+	r.Use(cslQuotaMiddleware)
+	r.Use(cslChaosMiddleware)
+	r.Use(cslCompressionMiddleware)
+	http.Handle("/", cslCorsHandler(r))
+	initCslDebugServer()
 }
 
 // Had to move away from mux, which means Method is fucked up right now.
@@ -5169,9 +5552,14 @@ func main() {
 	innerPort := os.Getenv("BACKEND_PORT")
 	if innerPort == "" {
 		log.Printf("[DEBUG] Running on %s:5001", hostname)
-		log.Fatal(http.ListenAndServe(":5001", nil))
+		err = runCslServer(":5001")
 	} else {
 		log.Printf("[DEBUG] Running on %s:%s", hostname, innerPort)
-		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", innerPort), nil))
+		err = runCslServer(fmt.Sprintf(":%s", innerPort))
+	}
+
+	// Returns without error after a graceful shutdown
+	if err != nil {
+		log.Fatal(err)
 	}
 }
@@ -93,6 +93,14 @@ func createSchedule(ctx context.Context, scheduleId, workflowId, name, startNode
 	bodyWrapper := fmt.Sprintf(`{"start": "%s", "execution_source": "schedule", "execution_argument": "%s"}`, startNode, parsedArgument)
 	log.Printf("[INFO] Body for schedule %s in workflow %s: \n%s", scheduleId, workflowId, bodyWrapper)
 	job := func() {
+		if !isCslLeader() {
+			return
+		}
+
+		if holdCslScheduledExecution(context.Background(), orgId, workflowId, scheduleId, []byte(bodyWrapper)) {
+			return
+		}
+
 		request := &http.Request{
 			URL:    &url.URL{},
 			Method: "POST",
@@ -116,7 +124,9 @@ func createSchedule(ctx context.Context, scheduleId, workflowId, name, startNode
 	}
 
 	//scheduledJobs = append(scheduledJobs, jobret)
+	scheduledJobsLock.Lock()
 	scheduledJobs[scheduleId] = jobret
+	scheduledJobsLock.Unlock()
 
 	// Doesn't need running/not running. If stopped, we just delete it.
 	timeNow := int64(time.Now().Unix())
@@ -291,6 +301,10 @@ func handleGetWorkflowqueue(resp http.ResponseWriter, request *http.Request) {
 
 	ctx := shuffle.GetContext(request)
 	env, err := shuffle.GetEnvironment(ctx, orgId, "")
+	if err == nil {
+		recordCslRunnerHeartbeat(request, env)
+	}
+
 	timeNow := time.Now().Unix()
 	if err == nil && len(env.Id) > 0 && len(env.Name) > 0 {
 		// Updates every 60 seconds~
@@ -479,7 +493,7 @@ func handleGetWorkflowqueue(resp http.ResponseWriter, request *http.Request) {
 		}
 	}
 
-	executionRequests, err := shuffle.GetWorkflowQueue(ctx, orgId, 100)
+	executionRequests, err := getWorkflowQueueWithWait(ctx, request, orgId)
 	if err != nil {
 		// Skipping as this comes up over and over
 		//log.Printf("(2) Failed reading body for workflowqueue: %s", err)
@@ -527,6 +541,7 @@ func handleGetWorkflowqueue(resp http.ResponseWriter, request *http.Request) {
 			}
 		}
 
+		executionRequests.Data = scheduleWeightedExecutions(executionRequests.Data)
 		if len(executionRequests.Data) > 50 {
 			executionRequests.Data = executionRequests.Data[0:49]
 		}
@@ -585,7 +600,8 @@ func handleGetStreamResults(resp http.ResponseWriter, request *http.Request) {
 	}
 
 	// Authorization is done here
-	if workflowExecution.Authorization != actionResult.Authorization {
+	userRequest := workflowExecution.Authorization != actionResult.Authorization
+	if userRequest {
 		user, err := shuffle.HandleApiAuthentication(resp, request)
 		if err != nil {
 			log.Printf("[WARNING] Api authentication failed in exec grabbing workflow: %s", err)
@@ -638,6 +654,12 @@ func handleGetStreamResults(resp http.ResponseWriter, request *http.Request) {
 		}
 	}
 
+	// Workers get the values as they are, users get them redacted
+	if userRequest {
+		redactedExecution, _ := shuffle.RedactExecution(ctx, *workflowExecution)
+		workflowExecution = &redactedExecution
+	}
+
 	newjson, err := json.Marshal(workflowExecution)
 	if err != nil {
 		resp.WriteHeader(401)
@@ -734,6 +756,13 @@ func handleWorkflowQueue(resp http.ResponseWriter, request *http.Request) {
 		}
 	}
 
+	injectCslAppFault(ctx, *workflowExecution, &actionResult)
+	if retryCslAction(ctx, *workflowExecution, &actionResult) {
+		resp.WriteHeader(200)
+		resp.Write([]byte(fmt.Sprintf(`{"success": true, "reason": "Action failed and will be retried"}`)))
+		return
+	}
+
 	runWorkflowExecutionTransaction(ctx, 0, workflowExecution.ExecutionId, actionResult, resp)
 }
 
@@ -752,6 +781,7 @@ func runWorkflowExecutionTransaction(ctx context.Context, attempts int64, workfl
 	}
 
 	//log.Printf("BASE LENGTH: %d", len(workflowExecution.Results))
+	previousStatus := workflowExecution.Status
 	workflowExecution, dbSave, err := shuffle.ParsedExecutionResult(ctx, *workflowExecution, actionResult, false, 0)
 	if err != nil {
 		b, suberr := json.Marshal(actionResult)
@@ -777,6 +807,9 @@ func runWorkflowExecutionTransaction(ctx context.Context, attempts int64, workfl
 			resp.Write([]byte(fmt.Sprintf(`{"success": false, "reason": "Failed setting workflowexecution actionresult: %s"}`, err)))
 			return
 		}
+
+		publishAppRun(*workflowExecution, actionResult)
+		publishExecutionFinished(previousStatus, *workflowExecution)
 		//handleExecutionResult(ctx, *workflowExecution)
 	} else {
 		log.Printf("Skipping setexec with status %s", workflowExecution.Status)
@@ -988,6 +1021,9 @@ func handleExecution(id string, workflow shuffle.Workflow, request *http.Request
 	//}()
 
 	ctx := context.Background()
+	dispatch := beginCslDispatch(id)
+	defer endCslDispatch(dispatch)
+
 	if workflow.ID == "" || workflow.ID != id {
 		tmpworkflow, err := shuffle.GetWorkflow(ctx, id)
 		if err != nil {
@@ -1078,6 +1114,7 @@ func handleExecution(id string, workflow shuffle.Workflow, request *http.Request
 	err := imageCheckBuilder(execInfo.ImageNames)
 	if err != nil {
 		log.Printf("[ERROR] Failed building the required images from %#v: %s", execInfo.ImageNames, err)
+		reportCslExecutionError(workflowExecution, "Failed building images", err)
 		return shuffle.WorkflowExecution{}, "Failed unmarshal during execution", err
 	}
 
@@ -1662,15 +1699,21 @@ func handleExecution(id string, workflow shuffle.Workflow, request *http.Request
 	err = imageCheckBuilder(imageNames)
 	if err != nil {
 		log.Printf("[ERROR] Failed building the required images from %#v: %s", imageNames, err)
+		reportCslExecutionError(workflowExecution, "Failed building images", err)
 		return shuffle.WorkflowExecution{}, "Failed building missing Docker images", err
 	}
 
 	err = shuffle.SetWorkflowExecution(ctx, workflowExecution, true)
 	if err != nil {
 		log.Printf("[WARNING] Error saving workflow execution for updates %s", err)
+		reportCslExecutionError(workflowExecution, "Failed storing execution", err)
 		return shuffle.WorkflowExecution{}, fmt.Sprintf("Failed setting workflowexecution: %s", err), err
 	}
 
+	if execInfo.OnpremExecution {
+		setCslDispatchExecution(dispatch, workflowExecution, execInfo.Environments)
+	}
+
 	// Adds queue for onprem execution
 	// FIXME - add specifics to executionRequest, e.g. specific environment (can run multi onprem)
 	if execInfo.OnpremExecution {
@@ -1696,10 +1739,17 @@ func handleExecution(id string, workflow shuffle.Workflow, request *http.Request
 			//}
 
 			//log.Printf("Execution request: %#v", executionRequest)
-			executionRequest.Priority = workflowExecution.Priority
-			err = shuffle.SetWorkflowQueue(ctx, executionRequest, environment)
+			executionRequest.Priority = getCslQueuePriority(ctx, workflowExecution)
+			err = injectCslDispatchFault(ctx, workflowExecution.ExecutionOrg)
+			if err == nil {
+				err = shuffle.SetWorkflowQueue(ctx, executionRequest, environment)
+			}
+
 			if err != nil {
 				log.Printf("[ERROR] Failed adding execution to db: %s", err)
+				reportCslExecutionError(workflowExecution, fmt.Sprintf("Failed queueing execution in environment %s", environment), err)
+			} else {
+				setCslDispatchQueued(dispatch, environment)
 			}
 		}
 	}
@@ -1733,6 +1783,9 @@ func handleExecution(id string, workflow shuffle.Workflow, request *http.Request
 	}
 
 	shuffle.IncrementCache(ctx, workflowExecution.OrgId, "workflow_executions")
+	shuffle.IncrementCache(ctx, workflowExecution.OrgId, getTriggerStatKey(getExecutionTrigger(workflowExecution)))
+	publishExecutionStarted(workflowExecution)
+	recordCslCredentialUsage(ctx, workflowExecution, request)
 	return workflowExecution, "", nil
 }
 
@@ -2161,7 +2214,12 @@ func deleteSchedule(ctx context.Context, id string) error {
 		log.Printf("[ERROR] Failed to delete schedule: %s", err)
 		return err
 	} else {
-		if value, exists := scheduledJobs[id]; exists {
+		scheduledJobsLock.Lock()
+		value, exists := scheduledJobs[id]
+		delete(scheduledJobs, id)
+		scheduledJobsLock.Unlock()
+
+		if exists {
 			// Stops the schedule properly
 			value.Lock()
 		} else {
@@ -0,0 +1,172 @@
+package sdk
+
+// Typed Go client for the CSL and admin APIs of the Shuffle backend. The
+// methods and types in api.go are generated from the backend handlers, so
+// run go generate here after changing a CSL handler or its types.
+//
+//	client := sdk.NewClient("https://shuffle:3443", apikey)
+//	trends, err := client.Trends(ctx, nil)
+//
+// Every method takes optional query parameters, and returns the data of the
+// response or an *Error with the reason from the backend.
+
+//go:generate go run ./gen -backend ../go-app -output api.go
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	BaseUrl string
+	Apikey  string
+
+	// Runs requests in another org the user belongs to instead of the
+	// active one
+	OrgId string
+
+	HTTPClient *http.Client
+}
+
+// Error of a failed request, with the reason the backend gave
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Reason     string
+}
+
+type response struct {
+	Success bool            `json:"success"`
+	Reason  string          `json:"reason"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func NewClient(baseUrl, apikey string) *Client {
+	return &Client{
+		BaseUrl: strings.TrimRight(baseUrl, "/"),
+		Apikey:  apikey,
+		HTTPClient: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+	}
+}
+
+// Returns a copy of the client that runs requests in the given org
+func (c *Client) WithOrg(orgId string) *Client {
+	copied := *c
+	copied.OrgId = orgId
+	return &copied
+}
+
+func (e *Error) Error() string {
+	if len(e.Reason) == 0 {
+		return fmt.Sprintf("%s %s failed with status %d", e.Method, e.Path, e.StatusCode)
+	}
+
+	return fmt.Sprintf("%s %s failed with status %d: %s", e.Method, e.Path, e.StatusCode, e.Reason)
+}
+
+// Sends a request and returns the raw response body. Bodies are sent as
+// JSON, except for []byte which is sent as is
+func (c *Client) doRaw(ctx context.Context, method, path string, query url.Values, body interface{}) ([]byte, error) {
+	requestUrl := c.BaseUrl + path
+	if len(query) > 0 {
+		requestUrl += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	contentType := "application/json"
+	if raw, ok := body.([]byte); ok {
+		reader = bytes.NewReader(raw)
+		contentType = "application/octet-stream"
+	} else if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestUrl, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.Apikey)
+	if reader != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if len(c.OrgId) > 0 {
+		req.Header.Set("Org-Id", c.OrgId)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+	respBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 300 {
+		parsed := response{}
+		json.Unmarshal(respBody, &parsed)
+		return nil, &Error{
+			Method:     method,
+			Path:       path,
+			StatusCode: res.StatusCode,
+			Reason:     parsed.Reason,
+		}
+	}
+
+	return respBody, nil
+}
+
+// Sends a request to a CSL API and unmarshals the data of the response into
+// data, unless it's nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body interface{}, data interface{}) error {
+	respBody, err := c.doRaw(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+
+	parsed := response{}
+	err = json.Unmarshal(respBody, &parsed)
+	if err != nil {
+		return fmt.Errorf("unexpected response from %s: %s", path, err)
+	}
+
+	if !parsed.Success {
+		return &Error{
+			Method:     method,
+			Path:       path,
+			StatusCode: http.StatusOK,
+			Reason:     parsed.Reason,
+		}
+	}
+
+	if data == nil || len(parsed.Data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(parsed.Data, data)
+}
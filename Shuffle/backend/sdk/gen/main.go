@@ -0,0 +1,886 @@
+package main
+
+// Generates api.go, the typed methods of the SDK, from the CSL handlers of
+// the backend. Routes are read from main.go, and for each handler the type
+// checker finds the type of the data in its CslResponse, the type the request
+// body is unmarshalled into and the query parameters it reads. Handlers that
+// hand the request to a helper are followed one call down. Every named type
+// used is copied into the SDK with its JSON fields.
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const routePrefix = "/api/v1/csl/"
+
+// Protocol endpoints for other systems than API clients
+var skippedRoutes = []string{
+	"/api/v1/csl/scim/v2/",
+	"/api/v1/csl/login/",
+	"/api/v1/csl/gitSync/webhook",
+	"/api/v1/csl/replication/receive",
+}
+
+const sharedPackage = "github.com/shuffle/shuffle-shared"
+
+var pathParamPattern = regexp.MustCompile(`\{([a-zA-Z_]+)\}`)
+
+type endpoint struct {
+	Method     string
+	Path       string
+	Handler    string
+	Name       string
+	Doc        []string
+	Query      []string
+	PathParams []string
+
+	// Nil when the handler has no body or data
+	Body types.Type
+	Data types.Type
+
+	// Handlers that don't answer with a CslResponse return the raw body
+	Raw bool
+
+	// Bodies that aren't JSON are sent as is
+	RawBody bool
+
+	responds  bool
+	readsBody bool
+	multipart bool
+}
+
+// The parsed and type checked backend
+type backendPackage struct {
+	Fset      *token.FileSet
+	Syntax    []*ast.File
+	TypesInfo *types.Info
+}
+
+type listedPackage struct {
+	Dir        string
+	ImportPath string
+	Export     string
+	GoFiles    []string
+}
+
+type generator struct {
+	pkg   *backendPackage
+	funcs map[string]*ast.FuncDecl
+	docs  map[string]string
+
+	names   map[*types.TypeName]string
+	objects map[string]*types.TypeName
+	queue   []*types.Named
+
+	localTypes []string
+
+	imports map[string]bool
+}
+
+func exportName(name string) string {
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+func getMethodName(handler string) string {
+	return exportName(strings.TrimPrefix(handler, "csl"))
+}
+
+func isSkippedRoute(path string) bool {
+	for _, skipped := range skippedRoutes {
+		if strings.HasPrefix(path, skipped) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Doc comments of handlers are a section line, a description and an
+// indented example. The description is kept
+func getHandlerDoc(decl *ast.FuncDecl) []string {
+	if decl.Doc == nil {
+		return []string{}
+	}
+
+	lines := []string{}
+	for index, line := range strings.Split(decl.Doc.Text(), "\n") {
+		if index == 0 && strings.HasSuffix(strings.TrimSpace(line), ":") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "    ") {
+			break
+		}
+
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+func (g *generator) isNamed(t types.Type, pkgPath, name string) bool {
+	named, ok := types.Unalias(t).(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return false
+	}
+
+	return named.Obj().Pkg().Path() == pkgPath && named.Obj().Name() == name
+}
+
+// Functions of the backend called from the handler with the request or
+// response, which often do the work of the handler
+func (g *generator) getHelpers(decl *ast.FuncDecl) []*ast.FuncDecl {
+	helpers := []*ast.FuncDecl{}
+	seen := map[string]bool{}
+	ast.Inspect(decl.Body, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+
+		ident, ok := call.Fun.(*ast.Ident)
+		if !ok || seen[ident.Name] {
+			return true
+		}
+
+		helper, ok := g.funcs[ident.Name]
+		if !ok || helper == decl {
+			return true
+		}
+
+		for _, arg := range call.Args {
+			argIdent, ok := arg.(*ast.Ident)
+			if ok && (argIdent.Name == "request" || argIdent.Name == "resp") {
+				seen[ident.Name] = true
+				helpers = append(helpers, helper)
+				break
+			}
+		}
+
+		return true
+	})
+
+	return helpers
+}
+
+// Fields of a streamed response: the array and the other fields of the map
+// passed to streamCslResponse
+func (g *generator) getStreamedType(decl *ast.FuncDecl, call *ast.CallExpr) types.Type {
+	info := g.pkg.TypesInfo
+	if len(call.Args) < 4 {
+		return nil
+	}
+
+	arrayLiteral, ok := call.Args[2].(*ast.BasicLit)
+	if !ok {
+		return nil
+	}
+
+	arrayField, _ := strconv.Unquote(arrayLiteral.Value)
+	fields := []*types.Var{types.NewField(token.NoPos, nil, getFieldName(arrayField), info.TypeOf(call.Args[3]), false)}
+	tags := []string{fmt.Sprintf(`json:"%s"`, arrayField)}
+
+	fieldsIdent, ok := call.Args[1].(*ast.Ident)
+	if !ok {
+		return types.NewStruct(fields, tags)
+	}
+
+	ast.Inspect(decl.Body, func(node ast.Node) bool {
+		assign, ok := node.(*ast.AssignStmt)
+		if !ok || len(assign.Lhs) != 1 || len(assign.Rhs) != 1 {
+			return true
+		}
+
+		target, ok := assign.Lhs[0].(*ast.Ident)
+		literal, literalOk := assign.Rhs[0].(*ast.CompositeLit)
+		if !ok || !literalOk || target.Name != fieldsIdent.Name {
+			return true
+		}
+
+		for _, element := range literal.Elts {
+			keyValue, ok := element.(*ast.KeyValueExpr)
+			if !ok {
+				continue
+			}
+
+			key, ok := keyValue.Key.(*ast.BasicLit)
+			if !ok {
+				continue
+			}
+
+			name, _ := strconv.Unquote(key.Value)
+			fields = append(fields, types.NewField(token.NoPos, nil, getFieldName(name), types.Default(info.TypeOf(keyValue.Value)), false))
+			tags = append(tags, fmt.Sprintf(`json:"%s,omitempty"`, name))
+		}
+
+		return false
+	})
+
+	return types.NewStruct(fields, tags)
+}
+
+// Go field name of a JSON key, e.g. calculated_at to CalculatedAt
+func getFieldName(key string) string {
+	name := ""
+	for _, part := range strings.Split(key, "_") {
+		if len(part) > 0 {
+			name += exportName(part)
+		}
+	}
+
+	return name
+}
+
+func (g *generator) inspectHandler(decl *ast.FuncDecl, current *endpoint) {
+	info := g.pkg.TypesInfo
+	queries := map[string]bool{}
+	for _, query := range current.Query {
+		queries[query] = true
+	}
+
+	ast.Inspect(decl.Body, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.CompositeLit:
+			if !g.isNamed(info.TypeOf(node), "shuffle", "CslResponse") {
+				return true
+			}
+
+			for _, element := range node.Elts {
+				keyValue, ok := element.(*ast.KeyValueExpr)
+				if !ok {
+					continue
+				}
+
+				key, ok := keyValue.Key.(*ast.Ident)
+				if !ok || key.Name != "Data" {
+					continue
+				}
+
+				if ident, ok := keyValue.Value.(*ast.Ident); ok && ident.Name == "nil" {
+					continue
+				}
+
+				if current.Data == nil {
+					current.Data = types.Default(info.TypeOf(keyValue.Value))
+				}
+			}
+
+			current.responds = true
+
+		case *ast.SelectorExpr:
+			if ident, ok := node.X.(*ast.Ident); ok && ident.Name == "request" && node.Sel.Name == "Body" {
+				current.readsBody = true
+			}
+
+			if node.Sel.Name == "FormFile" || node.Sel.Name == "ParseMultipartForm" || node.Sel.Name == "MultipartReader" {
+				current.multipart = true
+			}
+
+		case *ast.CallExpr:
+			if ident, ok := node.Fun.(*ast.Ident); ok && ident.Name == "streamCslResponse" {
+				if current.Data == nil {
+					current.Data = g.getStreamedType(decl, node)
+				}
+
+				current.responds = true
+				return true
+			}
+
+			selector, ok := node.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+
+			if selector.Sel.Name == "Get" && len(node.Args) == 1 && g.isNamed(info.TypeOf(selector.X), "net/url", "Values") {
+				literal, ok := node.Args[0].(*ast.BasicLit)
+				if ok && literal.Kind == token.STRING {
+					name, err := strconv.Unquote(literal.Value)
+					if err == nil && !queries[name] {
+						queries[name] = true
+						current.Query = append(current.Query, name)
+					}
+				}
+			}
+
+			pkgIdent, ok := selector.X.(*ast.Ident)
+			if !ok || pkgIdent.Name != "json" || selector.Sel.Name != "Unmarshal" || len(node.Args) != 2 || current.Body != nil {
+				return true
+			}
+
+			source, ok := node.Args[0].(*ast.Ident)
+			if !ok || source.Name != "body" {
+				return true
+			}
+
+			target, ok := node.Args[1].(*ast.UnaryExpr)
+			if ok && target.Op == token.AND {
+				current.Body = info.TypeOf(target.X)
+			}
+		}
+
+		return true
+	})
+}
+
+func (g *generator) getEndpoint(method, path, handler string) (endpoint, error) {
+	decl, ok := g.funcs[handler]
+	if !ok {
+		return endpoint{}, fmt.Errorf("handler %s not found", handler)
+	}
+
+	current := endpoint{
+		Method:  method,
+		Path:    path,
+		Handler: handler,
+		Name:    getMethodName(handler),
+		Doc:     getHandlerDoc(decl),
+		Query:   []string{},
+	}
+
+	for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		current.PathParams = append(current.PathParams, match[1])
+	}
+
+	g.inspectHandler(decl, &current)
+	for _, helper := range g.getHelpers(decl) {
+		g.inspectHandler(helper, &current)
+	}
+
+	current.Raw = !current.responds
+	if method == "GET" || method == "DELETE" {
+		current.Body = nil
+	} else if current.Body == nil && current.readsBody {
+		current.RawBody = true
+	}
+
+	return current, nil
+}
+
+// Routes of main.go under the CSL prefix
+func (g *generator) getEndpoints() ([]endpoint, error) {
+	endpoints := []endpoint{}
+	for _, file := range g.pkg.Syntax {
+		if !strings.HasSuffix(g.pkg.Fset.File(file.Pos()).Name(), "/main.go") {
+			continue
+		}
+
+		var err error
+		ast.Inspect(file, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok || err != nil {
+				return true
+			}
+
+			methods, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || methods.Sel.Name != "Methods" {
+				return true
+			}
+
+			route, ok := methods.X.(*ast.CallExpr)
+			if !ok || len(route.Args) != 2 {
+				return true
+			}
+
+			handleFunc, ok := route.Fun.(*ast.SelectorExpr)
+			if !ok || handleFunc.Sel.Name != "HandleFunc" {
+				return true
+			}
+
+			pathLiteral, ok := route.Args[0].(*ast.BasicLit)
+			handler, handlerOk := route.Args[1].(*ast.Ident)
+			if !ok || !handlerOk {
+				return true
+			}
+
+			path, _ := strconv.Unquote(pathLiteral.Value)
+			if !strings.HasPrefix(path, routePrefix) || isSkippedRoute(path) {
+				return true
+			}
+
+			method := ""
+			for _, arg := range call.Args {
+				literal, ok := arg.(*ast.BasicLit)
+				if !ok {
+					continue
+				}
+
+				value, _ := strconv.Unquote(literal.Value)
+				if value != "OPTIONS" {
+					method = value
+					break
+				}
+			}
+
+			var current endpoint
+			current, err = g.getEndpoint(method, path, handler.Name)
+			if err != nil {
+				return true
+			}
+
+			if current.multipart {
+				log.Printf("[INFO] Skipping %s %s, multipart uploads aren't supported", method, path)
+				return true
+			}
+
+			endpoints = append(endpoints, current)
+
+			return true
+		})
+
+		if err != nil {
+			return endpoints, err
+		}
+	}
+
+	return endpoints, nil
+}
+
+// Name of a named type in the SDK. Types of the backend keep their names,
+// and shared types get a Shuffle prefix if the name is taken
+func (g *generator) getTypeName(named *types.Named) string {
+	obj := named.Obj()
+	if name, ok := g.names[obj]; ok {
+		return name
+	}
+
+	name := exportName(obj.Name())
+	if existing, ok := g.objects[name]; ok && existing != obj {
+		name = "Shuffle" + name
+		for index := 2; g.objects[name] != nil; index++ {
+			name = fmt.Sprintf("Shuffle%s%d", exportName(obj.Name()), index)
+		}
+	}
+
+	g.names[obj] = name
+	g.objects[name] = obj
+	g.queue = append(g.queue, named)
+	return name
+}
+
+// Go source of a type in the SDK. Returns an empty string for types that
+// can't be sent as JSON
+func (g *generator) typeString(t types.Type) string {
+	switch t := types.Unalias(t).(type) {
+	case *types.Basic:
+		if t.Kind() == types.UnsafePointer || t.Kind() == types.Invalid {
+			return ""
+		}
+
+		return t.Name()
+	case *types.Pointer:
+		elem := g.typeString(t.Elem())
+		if len(elem) == 0 {
+			return ""
+		}
+
+		return "*" + elem
+	case *types.Slice:
+		elem := g.typeString(t.Elem())
+		if len(elem) == 0 {
+			return ""
+		}
+
+		return "[]" + elem
+	case *types.Array:
+		elem := g.typeString(t.Elem())
+		if len(elem) == 0 {
+			return ""
+		}
+
+		return fmt.Sprintf("[%d]%s", t.Len(), elem)
+	case *types.Map:
+		key := g.typeString(t.Key())
+		elem := g.typeString(t.Elem())
+		if len(key) == 0 || len(elem) == 0 {
+			return ""
+		}
+
+		return fmt.Sprintf("map[%s]%s", key, elem)
+	case *types.Interface:
+		return "interface{}"
+	case *types.Struct:
+		return g.structString(t)
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() == nil {
+			// error
+			return ""
+		}
+
+		switch obj.Pkg().Path() {
+		case "time":
+			if obj.Name() == "Time" || obj.Name() == "Duration" {
+				g.imports["time"] = true
+				return "time." + obj.Name()
+			}
+		case "encoding/json":
+			if obj.Name() == "RawMessage" {
+				g.imports["encoding/json"] = true
+				return "json.RawMessage"
+			}
+		case "shuffle", sharedPackage:
+			return g.getTypeName(t)
+		}
+
+		if _, ok := t.Underlying().(*types.Signature); ok {
+			return ""
+		}
+
+		if _, ok := t.Underlying().(*types.Chan); ok {
+			return ""
+		}
+
+		g.imports["encoding/json"] = true
+		return "json.RawMessage"
+	}
+
+	return ""
+}
+
+func (g *generator) structString(st *types.Struct) string {
+	fields := []string{}
+	for index := 0; index < st.NumFields(); index++ {
+		field := st.Field(index)
+		tag := reflect.StructTag(st.Tag(index)).Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		if !field.Exported() && !field.Embedded() {
+			continue
+		}
+
+		fieldType := g.typeString(field.Type())
+		if len(fieldType) == 0 {
+			continue
+		}
+
+		tagString := ""
+		if len(tag) > 0 {
+			tagString = fmt.Sprintf(" `json:%s`", strconv.Quote(tag))
+		}
+
+		if field.Embedded() {
+			fields = append(fields, fieldType+tagString)
+		} else {
+			fields = append(fields, field.Name()+" "+fieldType+tagString)
+		}
+	}
+
+	if len(fields) == 0 {
+		return "struct{}"
+	}
+
+	return "struct {\n" + strings.Join(fields, "\n") + "\n}"
+}
+
+// Anonymous structs in method signatures get a type of their own
+func (g *generator) getLocalTypeName(t types.Type, name string) string {
+	st, ok := types.Unalias(t).(*types.Struct)
+	if !ok {
+		return g.typeString(t)
+	}
+
+	g.localTypes = append(g.localTypes, fmt.Sprintf("type %s %s", name, g.structString(st)))
+	return name
+}
+
+func (g *generator) writeTypes(buf *bytes.Buffer) {
+	for _, localType := range g.localTypes {
+		fmt.Fprintf(buf, "%s\n\n", localType)
+	}
+
+	written := map[string]bool{}
+	for len(g.queue) > 0 {
+		named := g.queue[0]
+		g.queue = g.queue[1:]
+
+		name := g.names[named.Obj()]
+		if written[name] {
+			continue
+		}
+
+		written[name] = true
+		underlying := g.typeString(named.Underlying())
+		if len(underlying) == 0 {
+			g.imports["encoding/json"] = true
+			underlying = "json.RawMessage"
+		}
+
+		if named.Obj().Pkg().Path() == "shuffle" {
+			if doc, ok := g.docs[named.Obj().Name()]; ok {
+				for _, line := range strings.Split(strings.TrimSpace(doc), "\n") {
+					fmt.Fprintf(buf, "// %s\n", line)
+				}
+			}
+		}
+
+		fmt.Fprintf(buf, "type %s %s\n\n", name, underlying)
+	}
+}
+
+func (g *generator) writeMethod(buf *bytes.Buffer, current endpoint) {
+	params := []string{"ctx context.Context"}
+	for _, param := range current.PathParams {
+		params = append(params, param+" string")
+	}
+
+	bodyArg := "nil"
+	if current.RawBody {
+		params = append(params, "body []byte")
+		bodyArg = "body"
+	} else if current.Body != nil {
+		bodyType := g.getLocalTypeName(current.Body, current.Name+"Request")
+		if len(bodyType) > 0 {
+			params = append(params, "body "+bodyType)
+			bodyArg = "body"
+		}
+	}
+
+	params = append(params, "query url.Values")
+
+	path := strconv.Quote(current.Path)
+	if len(current.PathParams) > 0 {
+		parts := []string{}
+		for _, part := range pathParamPattern.Split(current.Path, -1) {
+			parts = append(parts, strconv.Quote(part))
+		}
+
+		path = ""
+		for index, part := range parts {
+			if part != `""` {
+				if len(path) > 0 {
+					path += " + "
+				}
+
+				path += part
+			}
+
+			if index < len(current.PathParams) {
+				if len(path) > 0 {
+					path += " + "
+				}
+
+				path += fmt.Sprintf("url.PathEscape(%s)", current.PathParams[index])
+			}
+		}
+	}
+
+	fmt.Fprintf(buf, "// %s calls %s %s.\n", current.Name, current.Method, current.Path)
+	if len(current.Doc) > 0 {
+		buf.WriteString("//\n")
+		for _, line := range current.Doc {
+			if len(line) == 0 {
+				buf.WriteString("//\n")
+				continue
+			}
+
+			fmt.Fprintf(buf, "// %s\n", line)
+		}
+	}
+
+	if len(current.Query) > 0 {
+		fmt.Fprintf(buf, "//\n// Query parameters: %s\n", strings.Join(current.Query, ", "))
+	}
+
+	signature := fmt.Sprintf("func (c *Client) %s(%s)", current.Name, strings.Join(params, ", "))
+
+	dataType := ""
+	if current.Data != nil {
+		dataType = g.getLocalTypeName(current.Data, current.Name+"Response")
+		if len(dataType) == 0 || dataType == "interface{}" {
+			g.imports["encoding/json"] = true
+			dataType = "json.RawMessage"
+		}
+	}
+
+	if current.Raw {
+		fmt.Fprintf(buf, "%s ([]byte, error) {\n", signature)
+		fmt.Fprintf(buf, "return c.doRaw(ctx, %s, %s, query, %s)\n}\n\n", strconv.Quote(current.Method), path, bodyArg)
+	} else if len(dataType) == 0 {
+		fmt.Fprintf(buf, "%s error {\n", signature)
+		fmt.Fprintf(buf, "return c.do(ctx, %s, %s, query, %s, nil)\n}\n\n", strconv.Quote(current.Method), path, bodyArg)
+	} else {
+		fmt.Fprintf(buf, "%s (%s, error) {\n", signature, dataType)
+		fmt.Fprintf(buf, "var data %s\n", dataType)
+		fmt.Fprintf(buf, "err := c.do(ctx, %s, %s, query, %s, &data)\n", strconv.Quote(current.Method), path, bodyArg)
+		buf.WriteString("return data, err\n}\n\n")
+	}
+}
+
+// Type checks the backend against the export data of its dependencies from
+// go list, so the generator needs nothing but the go command
+func loadBackend(dir string) (*backendPackage, error) {
+	cmd := exec.Command("go", "list", "-export", "-deps", "-json", ".")
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	exports := map[string]string{}
+	backend := listedPackage{}
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	for {
+		listed := listedPackage{}
+		err = decoder.Decode(&listed)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		exports[listed.ImportPath] = listed.Export
+		backend = listed
+	}
+
+	pkg := &backendPackage{
+		Fset: token.NewFileSet(),
+		TypesInfo: &types.Info{
+			Types: map[ast.Expr]types.TypeAndValue{},
+			Defs:  map[*ast.Ident]types.Object{},
+			Uses:  map[*ast.Ident]types.Object{},
+		},
+	}
+
+	for _, name := range backend.GoFiles {
+		file, err := parser.ParseFile(pkg.Fset, filepath.Join(backend.Dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+
+		pkg.Syntax = append(pkg.Syntax, file)
+	}
+
+	config := types.Config{
+		Importer: importer.ForCompiler(pkg.Fset, "gc", func(path string) (io.ReadCloser, error) {
+			export, ok := exports[path]
+			if !ok || len(export) == 0 {
+				return nil, fmt.Errorf("no export data for %s", path)
+			}
+
+			return os.Open(export)
+		}),
+	}
+
+	_, err = config.Check(backend.ImportPath, pkg.Fset, pkg.Syntax, pkg.TypesInfo)
+	return pkg, err
+}
+
+func main() {
+	backend := flag.String("backend", "../go-app", "directory of the backend")
+	output := flag.String("output", "api.go", "file to write")
+	flag.Parse()
+
+	pkg, err := loadBackend(*backend)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed loading the backend: %s", err)
+	}
+
+	g := &generator{
+		pkg:     pkg,
+		funcs:   map[string]*ast.FuncDecl{},
+		docs:    map[string]string{},
+		names:   map[*types.TypeName]string{},
+		objects: map[string]*types.TypeName{},
+		imports: map[string]bool{"context": true, "net/url": true},
+	}
+
+	for _, file := range g.pkg.Syntax {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil {
+					g.funcs[decl.Name.Name] = decl
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					typeSpec, ok := spec.(*ast.TypeSpec)
+					if !ok {
+						continue
+					}
+
+					if typeSpec.Doc != nil {
+						g.docs[typeSpec.Name.Name] = typeSpec.Doc.Text()
+					} else if decl.Doc != nil && len(decl.Specs) == 1 {
+						g.docs[typeSpec.Name.Name] = decl.Doc.Text()
+					}
+				}
+			}
+		}
+	}
+
+	endpoints, err := g.getEndpoints()
+	if err != nil {
+		log.Fatalf("[ERROR] Failed finding endpoints: %s", err)
+	}
+
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		return endpoints[i].Path < endpoints[j].Path
+	})
+
+	methods := bytes.Buffer{}
+	for _, current := range endpoints {
+		g.writeMethod(&methods, current)
+	}
+
+	typeDecls := bytes.Buffer{}
+	g.writeTypes(&typeDecls)
+
+	imports := []string{}
+	for name := range g.imports {
+		imports = append(imports, strconv.Quote(name))
+	}
+
+	sort.Strings(imports)
+
+	source := bytes.Buffer{}
+	source.WriteString("// Code generated by go run ./gen. DO NOT EDIT.\n\n")
+	source.WriteString("package sdk\n\n")
+	fmt.Fprintf(&source, "import (\n%s\n)\n\n", strings.Join(imports, "\n"))
+	source.Write(methods.Bytes())
+	source.Write(typeDecls.Bytes())
+
+	formatted, err := format.Source(source.Bytes())
+	if err != nil {
+		ioutil.WriteFile(*output, source.Bytes(), 0644)
+		log.Fatalf("[ERROR] Failed formatting %s: %s", *output, err)
+	}
+
+	err = ioutil.WriteFile(*output, formatted, 0644)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed writing %s: %s", *output, err)
+	}
+
+	log.Printf("[INFO] Generated %d methods and %d types in %s", len(endpoints), len(g.names), *output)
+}
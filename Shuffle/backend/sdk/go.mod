@@ -0,0 +1,3 @@
+module shuffle-sdk
+
+go 1.22.0
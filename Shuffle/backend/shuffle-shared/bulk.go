@@ -0,0 +1,148 @@
+package shuffle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+// Writes many executions at once, for imports and generated test data.
+// Unlike SetWorkflowExecution, the executions go straight to the database:
+// they aren't cached, redacted or counted in the org statistics.
+
+const bulkBatchSize = 500
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+func bulkIndexEs(ctx context.Context, nameKey string, ids []string, items []interface{}) error {
+	body := bytes.Buffer{}
+	index := strings.ToLower(GetESIndexPrefix(nameKey))
+	for itemIndex, item := range items {
+		action, err := json.Marshal(map[string]interface{}{
+			"index": map[string]string{"_index": index, "_id": ids[itemIndex]},
+		})
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(data)
+		body.WriteByte('\n')
+	}
+
+	req := opensearchapi.BulkRequest{
+		Body:    &body,
+		Refresh: "true",
+	}
+
+	res, err := req.Do(ctx, &project.Es)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+	respBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != 200 && res.StatusCode != 201 {
+		return errors.New(fmt.Sprintf("Bad statuscode from database: %d. Reason: %s", res.StatusCode, string(respBody)))
+	}
+
+	parsed := bulkResponse{}
+	err = json.Unmarshal(respBody, &parsed)
+	if err != nil || !parsed.Errors {
+		return nil
+	}
+
+	failed := 0
+	reason := ""
+	for _, item := range parsed.Items {
+		for _, result := range item {
+			if result.Status >= 300 {
+				failed += 1
+				reason = result.Error.Reason
+			}
+		}
+	}
+
+	return errors.New(fmt.Sprintf("%d of %d items failed. Last reason: %s", failed, len(items), reason))
+}
+
+func SetWorkflowExecutionsBulk(ctx context.Context, executions []WorkflowExecution) error {
+	nameKey := "workflowexecution"
+	for start := 0; start < len(executions); start += bulkBatchSize {
+		end := start + bulkBatchSize
+		if end > len(executions) {
+			end = len(executions)
+		}
+
+		batch := executions[start:end]
+		for _, execution := range batch {
+			if len(execution.ExecutionId) == 0 || len(execution.Authorization) == 0 {
+				return errors.New("ExecutionId and Authorization can't be empty.")
+			}
+		}
+
+		if project.DbType == "opensearch" {
+			ids := []string{}
+			items := []interface{}{}
+			for _, execution := range batch {
+				// Same as SetWorkflowExecution, to not break the position mapping
+				for actionIndex := range execution.Workflow.Actions {
+					execution.Workflow.Actions[actionIndex].Position.X = float64(0)
+					execution.Workflow.Actions[actionIndex].Position.Y = float64(0)
+				}
+
+				for triggerIndex := range execution.Workflow.Triggers {
+					execution.Workflow.Triggers[triggerIndex].Position.X = float64(0)
+					execution.Workflow.Triggers[triggerIndex].Position.Y = float64(0)
+				}
+
+				ids = append(ids, execution.ExecutionId)
+				items = append(items, execution)
+			}
+
+			err := bulkIndexEs(ctx, nameKey, ids, items)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		keys := []*datastore.Key{}
+		for _, execution := range batch {
+			keys = append(keys, datastore.NameKey(nameKey, strings.ToLower(execution.ExecutionId), nil))
+		}
+
+		_, err := project.Dbclient.PutMulti(ctx, keys, batch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
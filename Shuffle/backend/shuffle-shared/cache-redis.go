@@ -0,0 +1,124 @@
+package shuffle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis cache shared between backend replicas.
+// Enabled by setting SHUFFLE_REDIS_URL, e.g. redis://:password@redis:6379/0
+// Takes priority over SHUFFLE_MEMCACHED and the local in-memory cache.
+// Sessions, org statistic counters and app metadata are all read through
+// GetCache, so every replica sees the same state and requests don't have to
+// stick to the backend that cached them.
+
+// Cache calls use their own timeout since they are often made in goroutines
+// after the request context is done
+const redisTimeout = 5 * time.Second
+
+func initRedis(ctx context.Context, redisUrl string) (*redis.Client, error) {
+	options, err := redis.ParseURL(redisUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(options)
+
+	pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	err = client.Ping(pingCtx).Err()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// Sets up the Redis connection if SHUFFLE_REDIS_URL is set
+func setupRedis(ctx context.Context) error {
+	redisUrl := os.Getenv("SHUFFLE_REDIS_URL")
+	if len(redisUrl) == 0 {
+		return nil
+	}
+
+	client, err := initRedis(ctx, redisUrl)
+	if err != nil {
+		return err
+	}
+
+	project.Redis = client
+	log.Printf("[INFO] Using Redis at %s as shared cache", client.Options().Addr)
+	return nil
+}
+
+func useRedis() bool {
+	return project.Redis != nil
+}
+
+func redisGet(name string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	data, err := project.Redis.Get(ctx, name).Bytes()
+	if err == redis.Nil {
+		return nil, errors.New(fmt.Sprintf("No cache found in SHUFFLE_REDIS_URL for %s", name))
+	}
+
+	return data, err
+}
+
+// Expiration is in minutes, the same as SetCache
+func redisSet(name string, data []byte, expiration int32) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	return project.Redis.Set(ctx, name, data, time.Minute*time.Duration(expiration)).Err()
+}
+
+func redisDelete(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	return project.Redis.Del(ctx, name).Err()
+}
+
+// Increments a counter and returns the new value. The expiration is only set
+// when the key is created
+func redisIncrement(name string, amount int64, expiration int32) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	value, err := project.Redis.IncrBy(ctx, name, amount).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if value == amount {
+		project.Redis.Expire(ctx, name, time.Minute*time.Duration(expiration))
+	}
+
+	return value, nil
+}
+
+// Resets a counter and returns what it held. Only one replica gets the
+// value, so counters aren't dumped twice when several reach the limit at once
+func redisTakeCounter(name string, expiration int32) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	value, err := project.Redis.GetSet(ctx, name, 0).Int64()
+	if err != nil {
+		return 0, err
+	}
+
+	project.Redis.Expire(ctx, name, time.Minute*time.Duration(expiration))
+	return value, nil
+}
@@ -0,0 +1,162 @@
+package shuffle
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Keeps a certificate and CA pool in memory and reloads them when the files
+// on disk change, so certificates can be rotated without a restart. Used for
+// TLS and mutual TLS between the backend, Orborus and workers.
+
+// How often the files are checked for changes
+const certReloadInterval = 30 * time.Second
+
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mutex     sync.RWMutex
+	cert      *tls.Certificate
+	caPool    *x509.CertPool
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func getLatestModTime(files ...string) (time.Time, error) {
+	latest := time.Time{}
+	for _, file := range files {
+		if len(file) == 0 {
+			continue
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// Loads a certificate and key, and optionally a CA bundle. caFile can be empty
+func NewCertReloader(certFile, keyFile, caFile string) (*CertReloader, error) {
+	reloader := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+	}
+
+	err := reloader.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+func (reloader *CertReloader) load() error {
+	modTime, err := getLatestModTime(reloader.certFile, reloader.keyFile, reloader.caFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed loading certificate: %s", err))
+	}
+
+	var caPool *x509.CertPool
+	if len(reloader.caFile) > 0 {
+		caData, err := ioutil.ReadFile(reloader.caFile)
+		if err != nil {
+			return err
+		}
+
+		caPool = x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caData) {
+			return errors.New(fmt.Sprintf("no certificates found in %s", reloader.caFile))
+		}
+	}
+
+	reloader.mutex.Lock()
+	reloader.cert = &cert
+	reloader.caPool = caPool
+	reloader.modTime = modTime
+	reloader.lastCheck = time.Now()
+	reloader.mutex.Unlock()
+
+	return nil
+}
+
+// Reloads the files if they changed since last load. A failed reload keeps
+// the previous certificate so a half written file doesn't break connections
+func (reloader *CertReloader) maybeReload() {
+	reloader.mutex.Lock()
+	if time.Since(reloader.lastCheck) < certReloadInterval {
+		reloader.mutex.Unlock()
+		return
+	}
+
+	reloader.lastCheck = time.Now()
+	previousModTime := reloader.modTime
+	reloader.mutex.Unlock()
+
+	modTime, err := getLatestModTime(reloader.certFile, reloader.keyFile, reloader.caFile)
+	if err != nil {
+		log.Printf("[WARNING] Failed checking TLS certificate files: %s", err)
+		return
+	}
+
+	if !modTime.After(previousModTime) {
+		return
+	}
+
+	err = reloader.load()
+	if err != nil {
+		log.Printf("[ERROR] Failed reloading TLS certificates, keeping the previous ones: %s", err)
+		return
+	}
+
+	log.Printf("[INFO] Reloaded TLS certificates from %s", reloader.certFile)
+}
+
+func (reloader *CertReloader) getCertificate() *tls.Certificate {
+	reloader.maybeReload()
+
+	reloader.mutex.RLock()
+	defer reloader.mutex.RUnlock()
+	return reloader.cert
+}
+
+// For tls.Config.GetCertificate when serving
+func (reloader *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return reloader.getCertificate(), nil
+}
+
+// For tls.Config.GetClientCertificate when connecting with mutual TLS
+func (reloader *CertReloader) GetClientCertificate(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return reloader.getCertificate(), nil
+}
+
+// The CA pool from caFile, or nil if there is none. A new pool is returned
+// after the CA has been reloaded
+func (reloader *CertReloader) GetCaPool() *x509.CertPool {
+	reloader.maybeReload()
+
+	reloader.mutex.RLock()
+	defer reloader.mutex.RUnlock()
+	return reloader.caPool
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/md5"
 	"crypto/tls"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -35,6 +36,7 @@ import (
 
 	"cloud.google.com/go/storage"
 	gomemcache "github.com/bradfitz/gomemcache/memcache"
+	"github.com/redis/go-redis/v9"
 	"google.golang.org/appengine/memcache"
 
 	//opensearch "github.com/shuffle/opensearch-go"
@@ -64,6 +66,9 @@ type ShuffleStorage struct {
 	CacheDb       bool
 	Es            opensearch.Client
 	DbType        string
+	Pg            *sql.DB
+	Redis         *redis.Client
+	MessageBus    MessageBus
 	CloudUrl      string
 	BucketName    string
 }
@@ -84,13 +89,24 @@ func GetESIndexPrefix(index string) string {
 // 2. If there isn't, set it and clear out the daily records
 // Also: can we dump a list of apps that run? Maybe a list of them?
 func handleDailyCacheUpdate(executionInfo *ExecutionInfo) *ExecutionInfo {
-	timeYesterday := time.Now().AddDate(0, 0, -1)
+	// Days roll over at midnight in the orgs timezone
+	location := time.Local
+	if len(executionInfo.Timezone) > 0 {
+		orgLocation, err := time.LoadLocation(executionInfo.Timezone)
+		if err != nil {
+			log.Printf("[WARNING] Invalid timezone %s for org %s: %s", executionInfo.Timezone, executionInfo.OrgId, err)
+		} else {
+			location = orgLocation
+		}
+	}
+
+	timeYesterday := time.Now().In(location).AddDate(0, 0, -1)
 	timeYesterdayFormatted := timeYesterday.Format("2006-12-02")
 
 	for _, day := range executionInfo.DailyStatistics {
 
 		// Check if the day.Date is the same as yesterday and return if it is
-		if day.Date.Format("2006-12-02") == timeYesterdayFormatted {
+		if day.Date.In(location).Format("2006-12-02") == timeYesterdayFormatted {
 			//log.Printf("[DEBUG] Daily stats already updated for %s. Data: %#v", day.Date, day)
 			return executionInfo
 		}
@@ -113,7 +129,8 @@ func handleDailyCacheUpdate(executionInfo *ExecutionInfo) *ExecutionInfo {
 
 		ApiUsage: executionInfo.DailyApiUsage,
 
-		Additions: executionInfo.Additions,
+		// Copied since the daily values are reset below
+		Additions: append([]AdditionalUseConfig{}, executionInfo.Additions...),
 	}
 
 	executionInfo.DailyStatistics = append(executionInfo.DailyStatistics, newDay)
@@ -350,7 +367,12 @@ func SetOrgStatistics(ctx context.Context, stats ExecutionInfo, id string) error
 		return nil
 	}
 
-	if project.DbType == "opensearch" {
+	if usePostgres(nameKey) {
+		err := pgSet(ctx, nameKey, id, id, data)
+		if err != nil {
+			return err
+		}
+	} else if project.DbType == "opensearch" {
 		err := indexEs(ctx, nameKey, id, data)
 		if err != nil {
 			log.Printf("[ERROR] Failed indexing in set stats: %s", err)
@@ -419,7 +441,7 @@ func IncrementCacheDump(ctx context.Context, orgId, dataType string, amount ...i
 		// Get it from opensearch (may be prone to more issues at scale (thousands/second) due to no transactional locking)
 
 		id := strings.ToLower(orgId)
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error in org STATS get: %s", err)
 			return
@@ -567,7 +589,28 @@ func IncrementCache(ctx context.Context, orgId, dataType string, amount...int) {
 	// 2. Update it
 	dbDumpInterval := uint8(dbInterval)
 	key := fmt.Sprintf("cache_%s_%s", orgId, dataType)
-	if len(memcached) > 0 {
+	if useRedis() {
+		// Counted atomically so increments from all replicas end up in the same dump
+		count, err := redisIncrement(key, int64(incrementAmount), 86400)
+		if err != nil {
+			log.Printf("[ERROR] Failed incrementing redis cache for key %s: %s", key, err)
+			return
+		}
+
+		if count >= int64(dbDumpInterval) {
+			count, err = redisTakeCounter(key, 86400)
+			if err != nil {
+				log.Printf("[ERROR] Failed resetting redis cache for key %s: %s", key, err)
+				return
+			}
+
+			if count > 0 {
+				IncrementCacheDump(ctx, orgId, dataType, int(count))
+			}
+		}
+
+		return
+	} else if len(memcached) > 0 {
 		item, err := mc.Get(key)
 		if err == gomemcache.ErrCacheMiss {
 			//log.Printf("[DEBUG] Increment memcache miss for %s: %s", key, err)
@@ -692,6 +735,10 @@ func IncrementCache(ctx context.Context, orgId, dataType string, amount...int) {
 
 // Cache handlers
 func DeleteCache(ctx context.Context, name string) error {
+	if useRedis() {
+		return redisDelete(name)
+	}
+
 	if len(memcached) > 0 {
 		return mc.Delete(name)
 	}
@@ -720,6 +767,15 @@ func GetCache(ctx context.Context, name string) (interface{}, error) {
 
 	name = strings.Replace(name, " ", "_", -1)
 
+	if useRedis() {
+		data, err := redisGet(name)
+		if err != nil {
+			return "", err
+		}
+
+		return data, nil
+	}
+
 	if len(memcached) > 0 {
 		item, err := mc.Get(name)
 		if err == gomemcache.ErrCacheMiss {
@@ -834,6 +890,16 @@ func SetCache(ctx context.Context, name string, data []byte, expiration int32) e
 	// Maxsize ish~
 	name = strings.Replace(name, " ", "_", -1)
 
+	// No need to split large items in Redis
+	if useRedis() {
+		err := redisSet(name, data, expiration)
+		if err != nil {
+			log.Printf("[WARNING] Failed setting redis cache for key '%s' with data size %d: %s", name, len(data), err)
+		}
+
+		return nil
+	}
+
 	// Splitting into multiple cache items
 	//if project.Environment == "cloud" || len(memcached) > 0 {
 	if len(memcached) > 0 {
@@ -1102,6 +1168,17 @@ func SetWorkflowExecution(ctx context.Context, workflowExecution WorkflowExecuti
 		}
 	}
 
+	// Redacted before it's stored for good. The cache above keeps the values
+	// for the rest of the execution
+	if redactedExecution, redacted := RedactExecution(ctx, workflowExecution); redacted {
+		workflowExecution = redactedExecution
+		executionData, err = json.Marshal(workflowExecution)
+		if err != nil {
+			log.Printf("[ERROR][%s] Failed marshalling redacted execution: %s", workflowExecution.ExecutionId, err)
+			return err
+		}
+	}
+
 	// New struct, to not add body, author etc
 	//log.Printf("[DEBUG][%s] Adding execution to database, not just cache. Workflow: %s (%s)", workflowExecution.ExecutionId, workflowExecution.Workflow.Name, workflowExecution.Workflow.ID)
 	if project.DbType == "opensearch" {
@@ -1835,7 +1912,7 @@ func GetWorkflowExecution(ctx context.Context, id string) (*WorkflowExecution, e
 	}
 
 	if project.DbType == "opensearch" {
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING][%s] Error for %s: %s", workflowExecution.ExecutionId, cacheKey, err)
 			return workflowExecution, err
@@ -2049,7 +2126,7 @@ func GetApp(ctx context.Context, id string, user User, skipCache bool) (*Workflo
 	}
 
 	if project.DbType == "opensearch" {
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return workflowApp, err
@@ -2184,7 +2261,7 @@ func GetSubscriptionRecipient(ctx context.Context, id string) (*SubscriptionReci
 	}
 
 	if project.DbType == "opensearch" {
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return sub, err
@@ -2966,8 +3043,13 @@ func GetWorkflow(ctx context.Context, id string) (*Workflow, error) {
 		}
 	}
 
-	if project.DbType == "opensearch" {
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id)
+	if usePostgres(nameKey) {
+		err := pgGet(ctx, nameKey, id, workflow)
+		if err != nil {
+			return workflow, errors.New("Workflow doesn't exist")
+		}
+	} else if project.DbType == "opensearch" {
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return workflow, err
@@ -3051,8 +3133,13 @@ func GetOrgStatistics(ctx context.Context, orgId string) (*ExecutionInfo, error)
 		}
 	}
 
-	if project.DbType == "opensearch" {
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), orgId)
+	if usePostgres(nameKey) {
+		err := pgGet(ctx, nameKey, orgId, stats)
+		if err != nil {
+			return stats, errors.New(fmt.Sprintf("Org stats for %s doesn't exist", orgId))
+		}
+	} else if project.DbType == "opensearch" {
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), orgId, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return stats, err
@@ -3105,7 +3192,18 @@ func GetOrgStatistics(ctx context.Context, orgId string) (*ExecutionInfo, error)
 	return stats, nil
 }
 
+// Returns the workflows of the users' active org that they have access to.
+// Workflows with an ACL are left out for users not in it (workflow-acl.go)
 func GetAllWorkflowsByQuery(ctx context.Context, user User) ([]Workflow, error) {
+	workflows, err := getAllWorkflowsByQuery(ctx, user)
+	if err != nil {
+		return workflows, err
+	}
+
+	return FilterWorkflowsByAcl(ctx, user, workflows), nil
+}
+
+func getAllWorkflowsByQuery(ctx context.Context, user User) ([]Workflow, error) {
 	var workflows []Workflow
 	limit := 30
 
@@ -3137,7 +3235,12 @@ func GetAllWorkflowsByQuery(ctx context.Context, user User) ([]Workflow, error)
 	// Appending the users' workflows
 	nameKey := "workflow"
 	log.Printf("[AUDIT] Getting workflows for user %s (%s - %s)", user.Username, user.Role, user.Id)
-	if project.DbType == "opensearch" {
+	if usePostgres(nameKey) {
+		workflows, err = pgGetWorkflows(ctx, user)
+		if err != nil {
+			return workflows, err
+		}
+	} else if project.DbType == "opensearch" {
 		var buf bytes.Buffer
 		query := map[string]interface{}{
 			"size": 1000,
@@ -3509,7 +3612,12 @@ func GetOrgByCreatorId(ctx context.Context, id string) (*Org, error) {
 	}
 
 	setOrg := false
-	if project.DbType == "opensearch" {
+	if usePostgres(nameKey) {
+		err := pgGet(ctx, nameKey, id, curOrg)
+		if err != nil {
+			return &Org{}, errors.New("Org doesn't exist")
+		}
+	} else if project.DbType == "opensearch" {
 	} else {
 		query := datastore.NewQuery(nameKey).Filter("creator_id =", id).Limit(1)
 
@@ -3601,7 +3709,7 @@ func GetOrg(ctx context.Context, id string) (*Org, error) {
 
 	setOrg := false
 	if project.DbType == "opensearch" {
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error in org get: %s", err)
 			return &Org{}, err
@@ -4220,7 +4328,18 @@ func SetOrg(ctx context.Context, data Org, id string) error {
 	}
 
 	// clear session_token and API_token for user
-	if project.DbType == "opensearch" {
+	if usePostgres(nameKey) {
+		b, err := json.Marshal(data)
+		if err != nil {
+			log.Printf("[WARNING] Failed marshalling %s - %s: %s", id, nameKey, err)
+			return err
+		}
+
+		err = pgSet(ctx, nameKey, id, id, b)
+		if err != nil {
+			return err
+		}
+	} else if project.DbType == "opensearch" {
 		b, err := json.Marshal(data)
 		if err != nil {
 			log.Printf("[WARNING] Failed marshalling %s - %s: %s", id, nameKey, err)
@@ -4300,7 +4419,7 @@ func GetSession(ctx context.Context, thissession string) (*Session, error) {
 	nameKey := "sessions"
 	if project.DbType == "opensearch" {
 		//log.Printf("GETTING ES USER %s",
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), thissession)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), thissession, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return session, err
@@ -4359,10 +4478,12 @@ func DeleteKey(ctx context.Context, entity string, value string) error {
 		return errors.New("Value to delete must be larger than 0")
 	}
 
-	if project.DbType == "opensearch" {
+	if usePostgres(entity) {
+		return pgDelete(ctx, entity, value)
+	} else if project.DbType == "opensearch" {
 		log.Printf("[DEBUG] Deleting from index '%s' with item '%s' from opensearch", entity, value)
 
-		res, err := project.Es.Delete(strings.ToLower(GetESIndexPrefix(entity)), value)
+		res, err := project.Es.Delete(strings.ToLower(GetESIndexPrefix(entity)), value, project.Es.Delete.WithContext(ctx))
 
 		if err != nil {
 			log.Printf("[WARNING] Error in DELETE: %s", err)
@@ -4523,7 +4644,7 @@ func GetOpenApiDatastore(ctx context.Context, id string) (ParsedOpenApi, error)
 
 	if project.DbType == "opensearch" {
 		//log.Printf("GETTING ES USER %s",
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return *api, err
@@ -5042,7 +5163,7 @@ func GetUser(ctx context.Context, username string) (*User, error) {
 	nameKey := "Users"
 	if project.DbType == "opensearch" {
 		//log.Printf("GETTING ES USER %s",
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), parsedKey)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), parsedKey, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return curUser, err
@@ -5208,7 +5329,7 @@ func DeleteUsersAccount(ctx context.Context, user *User) error {
 
 	nameKey := "Users"
 	if project.DbType == "opensearch" {
-		res, err := project.Es.Delete(strings.ToLower(GetESIndexPrefix(nameKey)), user.Id)
+		res, err := project.Es.Delete(strings.ToLower(GetESIndexPrefix(nameKey)), user.Id, project.Es.Delete.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return err
@@ -5327,6 +5448,12 @@ func fixUserOrg(ctx context.Context, user *User) *User {
 }
 
 func GetAllWorkflowAppAuth(ctx context.Context, orgId string) ([]AppAuthenticationStorage, error) {
+	allworkflowappAuths, err := getAllWorkflowAppAuth(ctx, orgId)
+	resolveAllAuthSecrets(ctx, allworkflowappAuths)
+	return allworkflowappAuths, err
+}
+
+func getAllWorkflowAppAuth(ctx context.Context, orgId string) ([]AppAuthenticationStorage, error) {
 	var allworkflowappAuths []AppAuthenticationStorage
 	nameKey := "workflowappauth"
 
@@ -6759,6 +6886,7 @@ func SetWorkflowQueue(ctx context.Context, executionRequest ExecutionRequest, en
 		}
 	}
 
+	notifyWorkflowQueue(env)
 	return nil
 }
 
@@ -7126,7 +7254,7 @@ func GetOpenseaAsset(ctx context.Context, id string) (*OpenseaAsset, error) {
 
 	if project.DbType == "opensearch" {
 		//log.Printf("GETTING ES USER %s",
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return workflowExecution, err
@@ -7843,7 +7971,12 @@ func SetWorkflow(ctx context.Context, workflow Workflow, id string, optionalEdit
 		return nil
 	}
 
-	if project.DbType == "opensearch" {
+	if usePostgres(nameKey) {
+		err = pgSet(ctx, nameKey, id, workflow.OrgId, data)
+		if err != nil {
+			return err
+		}
+	} else if project.DbType == "opensearch" {
 		err = indexEs(ctx, nameKey, id, data)
 		if err != nil {
 			return err
@@ -7971,7 +8104,7 @@ func SetWorkflowAppAuthDatastore(ctx context.Context, workflowappauth AppAuthent
 			//}
 
 			parsedKey := fmt.Sprintf("%s_%d_%s_%s", workflowappauth.OrgId, workflowappauth.Created, workflowappauth.Label, field.Key)
-			newKey, err := handleKeyEncryption([]byte(field.Value), parsedKey)
+			newKey, err := encryptAuthValue([]byte(field.Value), parsedKey)
 			if err != nil {
 				//log.Printf("[WARNING] Failed encrypting key '%s': %s", field.Key, err)
 				setEncrypted = false
@@ -7989,6 +8122,13 @@ func SetWorkflowAppAuthDatastore(ctx context.Context, workflowappauth AppAuthent
 		}
 	}
 
+	// Moves the encrypted values to the secrets backend of the org, if any
+	previousBackend, err := storeAuthSecrets(ctx, &workflowappauth, id)
+	if err != nil {
+		log.Printf("[ERROR] Failed storing values of app auth %s (%s) in secrets backend: %s", workflowappauth.Label, id, err)
+		return err
+	}
+
 	// New struct, to not add body, author etc
 	if project.DbType == "opensearch" {
 		data, err := json.Marshal(workflowappauth)
@@ -8015,6 +8155,15 @@ func SetWorkflowAppAuthDatastore(ctx context.Context, workflowappauth AppAuthent
 	cacheKey = fmt.Sprintf("%s_%s", nameKey, workflowappauth.OrgId)
 	DeleteCache(ctx, cacheKey)
 
+	// Cleans up after auths moved to another backend
+	if isExternalSecretsBackend(previousBackend) && previousBackend != workflowappauth.SecretsBackend {
+		previousAuth := AppAuthenticationStorage{OrgId: workflowappauth.OrgId, SecretsBackend: previousBackend}
+		err = deleteAuthSecrets(ctx, previousAuth, id)
+		if err != nil {
+			log.Printf("[WARNING] Failed deleting values of app auth %s from %s after moving it: %s", id, previousBackend, err)
+		}
+	}
+
 	return nil
 }
 
@@ -8037,7 +8186,7 @@ func GetAppAuthGroup(ctx context.Context, id string) (*AppAuthenticationGroup, e
 	}
 
 	if project.DbType == "opensearch" {
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return authGroup, err
@@ -8262,7 +8411,7 @@ func GetSchedule(ctx context.Context, schedulename string) (*ScheduleOld, error)
 	curUser := &ScheduleOld{}
 	if project.DbType == "opensearch" {
 		//log.Printf("GETTING ES USER %s",
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), strings.ToLower(schedulename))
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), strings.ToLower(schedulename), project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return &ScheduleOld{}, err
@@ -8747,7 +8896,7 @@ func GetHook(ctx context.Context, hookId string) (*Hook, error) {
 	var err error
 	if project.DbType == "opensearch" {
 		//log.Printf("GETTING ES USER %s",
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), hookId)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), hookId, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
@@ -8839,7 +8988,7 @@ func GetPipeline(ctx context.Context, triggerId string) (*Pipeline, error) {
 
 	if project.DbType == "opensearch" {
 
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), triggerId)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), triggerId, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			return &Pipeline{}, err
 		}
@@ -8878,7 +9027,7 @@ func GetNotification(ctx context.Context, id string) (*Notification, error) {
 	curFile := &Notification{}
 	if project.DbType == "opensearch" {
 		//log.Printf("GETTING ES USER %s",
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return &Notification{}, err
@@ -8931,7 +9080,7 @@ func GetFile(ctx context.Context, id string) (*File, error) {
 	curFile := &File{}
 	if project.DbType == "opensearch" {
 		//log.Printf("GETTING ES USER %s",
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return &File{}, err
@@ -9504,7 +9653,24 @@ func GetAllFiles(ctx context.Context, orgId, namespace string) ([]File, error) {
 	return files, nil
 }
 
+// Values stored in a secrets backend are filled in after the cache, so they
+// are never cached
 func GetWorkflowAppAuthDatastore(ctx context.Context, id string) (*AppAuthenticationStorage, error) {
+	appAuth, err := getWorkflowAppAuthDatastore(ctx, id)
+	if err != nil {
+		return appAuth, err
+	}
+
+	err = resolveAuthSecrets(ctx, appAuth, id)
+	if err != nil {
+		log.Printf("[ERROR] Failed getting values of app auth %s from %s: %s", id, appAuth.SecretsBackend, err)
+		return appAuth, err
+	}
+
+	return appAuth, nil
+}
+
+func getWorkflowAppAuthDatastore(ctx context.Context, id string) (*AppAuthenticationStorage, error) {
 	nameKey := "workflowappauth"
 	cacheKey := fmt.Sprintf("%s_%s", nameKey, id)
 
@@ -9525,7 +9691,7 @@ func GetWorkflowAppAuthDatastore(ctx context.Context, id string) (*AppAuthentica
 	// New struct, to not add body, author etc
 	if project.DbType == "opensearch" {
 		//log.Printf("GETTING ES USER %s",
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return appAuth, err
@@ -9766,7 +9932,7 @@ func GetTriggerAuth(ctx context.Context, id string) (*TriggerAuth, error) {
 	triggerauth := &TriggerAuth{}
 	if project.DbType == "opensearch" {
 		//log.Printf("GETTING ES USER %s",
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), strings.ToLower(id))
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), strings.ToLower(id), project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return &TriggerAuth{}, err
@@ -10912,7 +11078,19 @@ func GetAllOrgs(ctx context.Context) ([]Org, error) {
 	index := "Organizations"
 
 	var orgs []Org
-	if project.DbType == "opensearch" {
+	if usePostgres(index) {
+		documents, err := pgList(ctx, index, "")
+		if err != nil {
+			return []Org{}, err
+		}
+
+		for _, document := range documents {
+			org := Org{}
+			if json.Unmarshal(document, &org) == nil && len(org.Id) > 0 {
+				orgs = append(orgs, org)
+			}
+		}
+	} else if project.DbType == "opensearch" {
 		var buf bytes.Buffer
 		query := map[string]interface{}{
 			"size": 1000,
@@ -11235,7 +11413,7 @@ func GetCacheKey(ctx context.Context, id string) (*CacheKeyData, error) {
 
 	if project.DbType == "opensearch" {
 		//log.Printf("GETTING ES USER %s",
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return cacheData, err
@@ -11340,7 +11518,7 @@ func GetCacheKey(ctx context.Context, id string) (*CacheKeyData, error) {
 
 var retryCount int
 
-func RunInit(dbclient datastore.Client, storageClient storage.Client, gceProject, environment string, cacheDb bool, dbType string, defaultCreds bool, count int) (ShuffleStorage, error) {
+func RunInit(dbclient datastore.Client, storageClient storage.Client, gceProject, environment string, cacheDb bool, dbType string, defaultCreds bool, count int) (*ShuffleStorage, error) {
 	if dbType == "elasticsearch" {
 		dbType = "opensearch"
 	}
@@ -11381,6 +11559,26 @@ func RunInit(dbclient datastore.Client, storageClient storage.Client, gceProject
 	}
 
 	requestCache = cache.New(35*time.Minute, 35*time.Minute)
+	if strings.ToLower(environment) != "worker" {
+		err := setupPostgres(context.Background())
+		if err != nil {
+			log.Printf("[ERROR] Failed setting up PostgreSQL (SHUFFLE_POSTGRES_URL): %s", err)
+			return &project, err
+		}
+
+		err = setupRedis(context.Background())
+		if err != nil {
+			log.Printf("[ERROR] Failed setting up Redis (SHUFFLE_REDIS_URL): %s", err)
+			return &project, err
+		}
+
+		err = setupMessageBus()
+		if err != nil {
+			log.Printf("[ERROR] Failed setting up message bus (SHUFFLE_MESSAGE_BUS): %s", err)
+			return &project, err
+		}
+	}
+
 	if strings.ToLower(environment) != "worker" && (strings.ToLower(dbType) == "opensearch" || strings.ToLower(dbType) == "opensearch") {
 
 		project.Es = *GetEsConfig(defaultCreds)
@@ -11416,14 +11614,14 @@ func RunInit(dbclient datastore.Client, storageClient storage.Client, gceProject
 				}
 			}
 
-			return project, err
+			return &project, err
 		}
 
 		if ret.StatusCode >= 300 {
 			respBody, err := ioutil.ReadAll(ret.Body)
 			if err != nil {
 				log.Printf("[ERROR] Failed handling ES setup: %s", ret)
-				return project, errors.New(fmt.Sprintf("Bad status code from ES: %d", ret.StatusCode))
+				return &project, errors.New(fmt.Sprintf("Bad status code from ES: %d", ret.StatusCode))
 			}
 
 			log.Printf("[ERROR] Bad Status from ES: %d", ret.StatusCode)
@@ -11435,7 +11633,7 @@ func RunInit(dbclient datastore.Client, storageClient storage.Client, gceProject
 				return RunInit(dbclient, storageClient, gceProject, environment, cacheDb, dbType, true, count)
 			}
 
-			return project, errors.New(fmt.Sprintf("Bad status code from ES: %d", ret.StatusCode))
+			return &project, errors.New(fmt.Sprintf("Bad status code from ES: %d", ret.StatusCode))
 		} else {
 			//log.Printf("\n\n[INFO] Should check for SSO during setup - finding main org\n\n")
 			ctx := context.Background()
@@ -11456,7 +11654,7 @@ func RunInit(dbclient datastore.Client, storageClient storage.Client, gceProject
 		// Fix potential cloud init problems here
 	}
 
-	return project, nil
+	return &project, nil
 }
 
 func GetEsConfig(defaultCreds bool) *opensearch.Client {
@@ -11553,6 +11751,11 @@ func GetEsConfig(defaultCreds bool) *opensearch.Client {
 	}
 	config.Transport = transport
 
+	err := wrapSlowQueryTransport(&config)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed setting up the database transport: %s", err)
+	}
+
 	es, err := opensearch.NewClient(config)
 	if err != nil {
 		log.Fatalf("[DEBUG] Database client for ELASTICSEARCH error during init (fatal): %s", err)
@@ -11708,7 +11911,7 @@ func GetUsecase(ctx context.Context, name string) (*Usecase, error) {
 
 	if project.DbType == "opensearch" {
 		//log.Printf("GETTING ES USER %s",
-		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id)
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(nameKey)), id, project.Es.Get.WithContext(ctx))
 		if err != nil {
 			log.Printf("[WARNING] Error for %s: %s", cacheKey, err)
 			return usecase, err
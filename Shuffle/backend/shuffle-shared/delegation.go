@@ -0,0 +1,52 @@
+package shuffle
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// Delegated admin access for users with a custom role. The backend checks
+// the permissions of the role before a request reaches a handler, and adds a
+// DelegatedGrant to the request when the role allows it. Admin checks then
+// let the user through for that request only, so a role can hand out parts
+// of the admin role without making the user an admin.
+
+type DelegatedGrant struct {
+	UserId     string `json:"user_id"`
+	OrgId      string `json:"org_id"`
+	Role       string `json:"role"`
+	Permission string `json:"permission"`
+}
+
+type delegatedGrantKey struct{}
+
+func WithDelegatedGrant(ctx context.Context, grant DelegatedGrant) context.Context {
+	return context.WithValue(ctx, delegatedGrantKey{}, grant)
+}
+
+func GetDelegatedGrant(ctx context.Context) (DelegatedGrant, bool) {
+	if ctx == nil {
+		return DelegatedGrant{}, false
+	}
+
+	grant, ok := ctx.Value(delegatedGrantKey{}).(DelegatedGrant)
+	return grant, ok
+}
+
+// Gives the user the admin role in their active org if the request was
+// granted a delegated permission for them
+func applyDelegatedGrant(request *http.Request, user User) User {
+	grant, ok := GetDelegatedGrant(request.Context())
+	if !ok || grant.UserId != user.Id || grant.OrgId != user.ActiveOrg.Id {
+		return user
+	}
+
+	if user.Role != "admin" {
+		log.Printf("[AUDIT] User %s (%s) has admin access to %s %s in org %s through permission %s of role %s", user.Username, user.Id, request.Method, request.URL.Path, grant.OrgId, grant.Permission, grant.Role)
+	}
+
+	user.Role = "admin"
+	user.ActiveOrg.Role = "admin"
+	return user
+}
@@ -0,0 +1,686 @@
+package shuffle
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+// Envelope encryption of app authentication values. Every value is encrypted
+// with its own random data key, and the data key is encrypted (wrapped) by a
+// key encryption key kept in a KMS. The passphrase used by
+// handleKeyEncryption is bound to the value as additional data, so values
+// can't be moved between fields or auths. Enabled with
+// SHUFFLE_ENVELOPE_KMS set to one of:
+//
+//	local   SHUFFLE_ENVELOPE_MASTER_KEYS=<id>:<base64 32 bytes>[,<id>:<key>...]
+//	        The first key wraps new data keys, the rest are kept for rotation
+//	aws     SHUFFLE_ENVELOPE_AWS_KEY_ID with AWS_REGION, AWS_ACCESS_KEY_ID,
+//	        AWS_SECRET_ACCESS_KEY and optionally AWS_SESSION_TOKEN
+//	gcp     SHUFFLE_ENVELOPE_GCP_KEY_NAME, e.g.
+//	        projects/p/locations/global/keyRings/r/cryptoKeys/k, with
+//	        application default credentials
+//
+// Values encrypted before are still decrypted with SHUFFLE_ENCRYPTION_MODIFIER,
+// and are re-encrypted by MigrateAuthEncryption. AWS and GCP rotate key
+// versions on their own, so data keys only have to be rewrapped when the
+// configured key itself changes.
+
+const EnvelopeKmsLocal = "local"
+const EnvelopeKmsAws = "aws"
+const EnvelopeKmsGcp = "gcp"
+
+const envelopePrefix = "envelope:"
+
+const envelopeKmsTimeout = 10 * time.Second
+
+// Unwrapped data keys are kept in memory, as executions decrypt every auth
+// of an org and each unwrap is a call to the KMS
+const envelopeDataKeyCacheMinutes = 10
+const envelopeDataKeyCacheSize = 10000
+
+type envelopeValue struct {
+	Kms        string `json:"kms"`
+	KeyId      string `json:"key_id"`
+	WrappedKey string `json:"wrapped_key"`
+	Data       string `json:"data"`
+}
+
+type envelopeKms interface {
+	name() string
+	activeKeyId() string
+	wrap(ctx context.Context, dataKey []byte) ([]byte, error)
+	unwrap(ctx context.Context, keyId string, wrapped []byte) ([]byte, error)
+}
+
+type envelopeCachedKey struct {
+	key     []byte
+	expires time.Time
+}
+
+var envelope = struct {
+	sync.Mutex
+	kms      envelopeKms
+	loaded   bool
+	err      error
+	dataKeys map[string]envelopeCachedKey
+}{
+	dataKeys: map[string]envelopeCachedKey{},
+}
+
+func getEnvelopeKms() (envelopeKms, error) {
+	envelope.Lock()
+	defer envelope.Unlock()
+
+	if envelope.loaded {
+		return envelope.kms, envelope.err
+	}
+
+	envelope.loaded = true
+	switch strings.ToLower(os.Getenv("SHUFFLE_ENVELOPE_KMS")) {
+	case "":
+		return nil, nil
+	case EnvelopeKmsLocal:
+		envelope.kms, envelope.err = newLocalEnvelopeKms(os.Getenv("SHUFFLE_ENVELOPE_MASTER_KEYS"))
+	case EnvelopeKmsAws:
+		envelope.kms, envelope.err = newAwsEnvelopeKms()
+	case EnvelopeKmsGcp:
+		envelope.kms, envelope.err = newGcpEnvelopeKms()
+	default:
+		envelope.err = errors.New(fmt.Sprintf("unknown SHUFFLE_ENVELOPE_KMS %s", os.Getenv("SHUFFLE_ENVELOPE_KMS")))
+	}
+
+	if envelope.err != nil {
+		log.Printf("[ERROR] Failed setting up envelope encryption: %s", envelope.err)
+	} else {
+		log.Printf("[INFO] Encrypting app authentication with envelope encryption through %s key %s", envelope.kms.name(), envelope.kms.activeKeyId())
+	}
+
+	return envelope.kms, envelope.err
+}
+
+func IsEnvelopeEncryptionEnabled() bool {
+	kms, err := getEnvelopeKms()
+	return err == nil && kms != nil
+}
+
+// Returns the KMS and key new values are wrapped with
+func GetEnvelopeActiveKey() (string, string, error) {
+	kms, err := getEnvelopeKms()
+	if err != nil || kms == nil {
+		return "", "", err
+	}
+
+	return kms.name(), kms.activeKeyId(), nil
+}
+
+func isEnvelopeValue(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(envelopePrefix))
+}
+
+func parseEnvelopeValue(data []byte) (envelopeValue, error) {
+	value := envelopeValue{}
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimPrefix(data, []byte(envelopePrefix))))
+	if err != nil {
+		return value, err
+	}
+
+	err = json.Unmarshal(decoded, &value)
+	return value, err
+}
+
+func formatEnvelopeValue(value envelopeValue) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return []byte(envelopePrefix + base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// Returns the KMS and key an envelope encrypted value is wrapped with
+func GetEnvelopeKey(data []byte) (string, string, bool) {
+	if !isEnvelopeValue(data) {
+		return "", "", false
+	}
+
+	value, err := parseEnvelopeValue(data)
+	if err != nil {
+		return "", "", false
+	}
+
+	return value.Kms, value.KeyId, true
+}
+
+func envelopeSeal(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return []byte{}, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func envelopeOpen(key, sealed, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return []byte{}, errors.New("envelope data is too short")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func encryptEnvelope(ctx context.Context, kms envelopeKms, data []byte, passphrase string) ([]byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return []byte{}, err
+	}
+
+	sealed, err := envelopeSeal(dataKey, data, []byte(passphrase))
+	if err != nil {
+		return []byte{}, err
+	}
+
+	wrapped, err := kms.wrap(ctx, dataKey)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return formatEnvelopeValue(envelopeValue{
+		Kms:        kms.name(),
+		KeyId:      kms.activeKeyId(),
+		WrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+		Data:       base64.StdEncoding.EncodeToString(sealed),
+	})
+}
+
+func unwrapEnvelopeDataKey(ctx context.Context, kms envelopeKms, value envelopeValue) ([]byte, error) {
+	if value.Kms != kms.name() {
+		return []byte{}, errors.New(fmt.Sprintf("value is wrapped with %s, but SHUFFLE_ENVELOPE_KMS is %s", value.Kms, kms.name()))
+	}
+
+	hash := sha256.Sum256([]byte(value.KeyId + value.WrappedKey))
+	cacheKey := hex.EncodeToString(hash[:])
+
+	envelope.Lock()
+	cached, ok := envelope.dataKeys[cacheKey]
+	envelope.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.key, nil
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(value.WrappedKey)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	dataKey, err := kms.unwrap(ctx, value.KeyId, wrapped)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	envelope.Lock()
+	if len(envelope.dataKeys) >= envelopeDataKeyCacheSize {
+		envelope.dataKeys = map[string]envelopeCachedKey{}
+	}
+
+	envelope.dataKeys[cacheKey] = envelopeCachedKey{key: dataKey, expires: time.Now().Add(envelopeDataKeyCacheMinutes * time.Minute)}
+	envelope.Unlock()
+
+	return dataKey, nil
+}
+
+func decryptEnvelope(ctx context.Context, data []byte, passphrase string) ([]byte, error) {
+	kms, err := getEnvelopeKms()
+	if err != nil {
+		return []byte{}, err
+	}
+
+	if kms == nil {
+		return []byte{}, errors.New("value is envelope encrypted, but SHUFFLE_ENVELOPE_KMS isn't set")
+	}
+
+	value, err := parseEnvelopeValue(data)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	dataKey, err := unwrapEnvelopeDataKey(ctx, kms, value)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(value.Data)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return envelopeOpen(dataKey, sealed, []byte(passphrase))
+}
+
+// Encrypts a value of an app auth, with envelope encryption if enabled
+func encryptAuthValue(data []byte, passphrase string) ([]byte, error) {
+	kms, err := getEnvelopeKms()
+	if err != nil {
+		return []byte{}, err
+	}
+
+	if kms == nil {
+		return handleKeyEncryption(data, passphrase)
+	}
+
+	return encryptEnvelope(context.Background(), kms, data, passphrase)
+}
+
+// Encrypts other secrets kept by the backend the same way as app auth values.
+// Decrypt them with HandleKeyDecryption and the same passphrase
+func HandleKeyEncryption(data []byte, passphrase string) ([]byte, error) {
+	return encryptAuthValue(data, passphrase)
+}
+
+// Wraps the data key of a value with the active key. The data itself stays
+// the same
+func rewrapEnvelope(ctx context.Context, kms envelopeKms, data []byte) ([]byte, error) {
+	value, err := parseEnvelopeValue(data)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	dataKey, err := unwrapEnvelopeDataKey(ctx, kms, value)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	wrapped, err := kms.wrap(ctx, dataKey)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	value.KeyId = kms.activeKeyId()
+	value.WrappedKey = base64.StdEncoding.EncodeToString(wrapped)
+	return formatEnvelopeValue(value)
+}
+
+// Re-encrypts the values of an auth with envelope encryption, and rewraps
+// values wrapped with another key than the active one. Returns whether
+// anything changed. The auth has to be saved by the caller
+func MigrateAuthEncryption(ctx context.Context, auth *AppAuthenticationStorage) (bool, error) {
+	kms, err := getEnvelopeKms()
+	if err != nil {
+		return false, err
+	}
+
+	if kms == nil {
+		return false, errors.New("envelope encryption isn't enabled")
+	}
+
+	// Unencrypted auths are encrypted when saved
+	if !auth.Encrypted {
+		return true, nil
+	}
+
+	changed := false
+	fields := []AuthenticationStore{}
+	for _, field := range auth.Fields {
+		value := []byte(field.Value)
+		if len(value) == 0 {
+			fields = append(fields, field)
+			continue
+		}
+
+		parsedKey := fmt.Sprintf("%s_%d_%s_%s", auth.OrgId, auth.Created, auth.Label, field.Key)
+		if isEnvelopeValue(value) {
+			envelopeData, err := parseEnvelopeValue(value)
+			if err != nil {
+				return false, err
+			}
+
+			if envelopeData.Kms == kms.name() && envelopeData.KeyId == kms.activeKeyId() {
+				fields = append(fields, field)
+				continue
+			}
+
+			value, err = rewrapEnvelope(ctx, kms, value)
+			if err != nil {
+				return false, err
+			}
+		} else {
+			plaintext, err := HandleKeyDecryption(value, parsedKey)
+			if err != nil {
+				return false, err
+			}
+
+			value, err = encryptEnvelope(ctx, kms, plaintext, parsedKey)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		field.Value = string(value)
+		fields = append(fields, field)
+		changed = true
+	}
+
+	auth.Fields = fields
+	return changed, nil
+}
+
+// Local master keys, for installs without a cloud KMS
+type localEnvelopeKms struct {
+	activeId string
+	keys     map[string][]byte
+}
+
+func newLocalEnvelopeKms(value string) (*localEnvelopeKms, error) {
+	kms := &localEnvelopeKms{keys: map[string][]byte{}}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, errors.New("SHUFFLE_ENVELOPE_MASTER_KEYS must be a list of <id>:<base64 key>")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil || len(key) != 32 {
+			return nil, errors.New(fmt.Sprintf("master key %s must be 32 bytes encoded as base64", parts[0]))
+		}
+
+		if len(kms.activeId) == 0 {
+			kms.activeId = parts[0]
+		}
+
+		kms.keys[parts[0]] = key
+	}
+
+	if len(kms.activeId) == 0 {
+		return nil, errors.New("SHUFFLE_ENVELOPE_MASTER_KEYS is required with SHUFFLE_ENVELOPE_KMS=local")
+	}
+
+	return kms, nil
+}
+
+func (kms *localEnvelopeKms) name() string {
+	return EnvelopeKmsLocal
+}
+
+func (kms *localEnvelopeKms) activeKeyId() string {
+	return kms.activeId
+}
+
+func (kms *localEnvelopeKms) wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	return envelopeSeal(kms.keys[kms.activeId], dataKey, []byte(kms.activeId))
+}
+
+func (kms *localEnvelopeKms) unwrap(ctx context.Context, keyId string, wrapped []byte) ([]byte, error) {
+	key, ok := kms.keys[keyId]
+	if !ok {
+		return []byte{}, errors.New(fmt.Sprintf("master key %s isn't in SHUFFLE_ENVELOPE_MASTER_KEYS", keyId))
+	}
+
+	return envelopeOpen(key, wrapped, []byte(keyId))
+}
+
+// AWS KMS through its JSON API, signed with Signature Version 4
+type awsEnvelopeKms struct {
+	keyId        string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+func newAwsEnvelopeKms() (*awsEnvelopeKms, error) {
+	kms := &awsEnvelopeKms{
+		keyId:        os.Getenv("SHUFFLE_ENVELOPE_AWS_KEY_ID"),
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: envelopeKmsTimeout},
+	}
+
+	if len(kms.keyId) == 0 || len(kms.region) == 0 {
+		return nil, errors.New("SHUFFLE_ENVELOPE_AWS_KEY_ID and AWS_REGION are required with SHUFFLE_ENVELOPE_KMS=aws")
+	}
+
+	if len(kms.accessKey) == 0 || len(kms.secretKey) == 0 {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required with SHUFFLE_ENVELOPE_KMS=aws")
+	}
+
+	return kms, nil
+}
+
+func (kms *awsEnvelopeKms) name() string {
+	return EnvelopeKmsAws
+}
+
+func (kms *awsEnvelopeKms) activeKeyId() string {
+	return kms.keyId
+}
+
+func awsHmac(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func (kms *awsEnvelopeKms) request(ctx context.Context, action string, body interface{}, parsed interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	host := fmt.Sprintf("kms.%s.amazonaws.com", kms.region)
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(payload)
+
+	headers := map[string]string{
+		"content-type": "application/x-amz-json-1.1",
+		"host":         host,
+		"x-amz-date":   amzDate,
+		"x-amz-target": fmt.Sprintf("TrentService.%s", action),
+	}
+
+	signedHeaders := []string{"content-type", "host", "x-amz-date", "x-amz-target"}
+	if len(kms.sessionToken) > 0 {
+		headers["x-amz-security-token"] = kms.sessionToken
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+	}
+
+	canonicalHeaders := ""
+	for _, name := range signedHeaders {
+		canonicalHeaders += fmt.Sprintf("%s:%s\n", name, headers[name])
+	}
+
+	canonicalRequest := strings.Join([]string{"POST", "/", "", canonicalHeaders, strings.Join(signedHeaders, ";"), hex.EncodeToString(payloadHash[:])}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := fmt.Sprintf("%s/%s/kms/aws4_request", date, kms.region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(canonicalHash[:])}, "\n")
+
+	signingKey := awsHmac([]byte("AWS4"+kms.secretKey), date)
+	signingKey = awsHmac(signingKey, kms.region)
+	signingKey = awsHmac(signingKey, "kms")
+	signingKey = awsHmac(signingKey, "aws4_request")
+	signature := hex.EncodeToString(awsHmac(signingKey, stringToSign))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%s/", host), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", kms.accessKey, scope, strings.Join(signedHeaders, ";"), signature))
+
+	resp, err := kms.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != 200 {
+		return errors.New(fmt.Sprintf("AWS KMS %s returned status %d: %s", action, resp.StatusCode, string(respBody)))
+	}
+
+	return json.Unmarshal(respBody, parsed)
+}
+
+func (kms *awsEnvelopeKms) wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	parsed := struct {
+		CiphertextBlob []byte `json:"CiphertextBlob"`
+	}{}
+
+	err := kms.request(ctx, "Encrypt", map[string]interface{}{
+		"KeyId":     kms.keyId,
+		"Plaintext": dataKey,
+	}, &parsed)
+	return parsed.CiphertextBlob, err
+}
+
+// The key is part of the ciphertext, so values wrapped before the key id
+// was changed can still be unwrapped
+func (kms *awsEnvelopeKms) unwrap(ctx context.Context, keyId string, wrapped []byte) ([]byte, error) {
+	parsed := struct {
+		Plaintext []byte `json:"Plaintext"`
+	}{}
+
+	err := kms.request(ctx, "Decrypt", map[string]interface{}{
+		"CiphertextBlob": wrapped,
+	}, &parsed)
+	return parsed.Plaintext, err
+}
+
+// GCP Cloud KMS through its REST API
+type gcpEnvelopeKms struct {
+	keyName string
+	client  *http.Client
+}
+
+func newGcpEnvelopeKms() (*gcpEnvelopeKms, error) {
+	keyName := strings.Trim(os.Getenv("SHUFFLE_ENVELOPE_GCP_KEY_NAME"), "/")
+	if len(keyName) == 0 {
+		return nil, errors.New("SHUFFLE_ENVELOPE_GCP_KEY_NAME is required with SHUFFLE_ENVELOPE_KMS=gcp")
+	}
+
+	client, err := google.DefaultClient(context.Background(), "https://www.googleapis.com/auth/cloudkms")
+	if err != nil {
+		return nil, err
+	}
+
+	client.Timeout = envelopeKmsTimeout
+	return &gcpEnvelopeKms{keyName: keyName, client: client}, nil
+}
+
+func (kms *gcpEnvelopeKms) name() string {
+	return EnvelopeKmsGcp
+}
+
+func (kms *gcpEnvelopeKms) activeKeyId() string {
+	return kms.keyName
+}
+
+func (kms *gcpEnvelopeKms) request(ctx context.Context, keyName, action string, body interface{}, parsed interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://cloudkms.googleapis.com/v1/%s:%s", keyName, action), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := kms.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != 200 {
+		return errors.New(fmt.Sprintf("GCP KMS %s returned status %d: %s", action, resp.StatusCode, string(respBody)))
+	}
+
+	return json.Unmarshal(respBody, parsed)
+}
+
+func (kms *gcpEnvelopeKms) wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	parsed := struct {
+		Ciphertext []byte `json:"ciphertext"`
+	}{}
+
+	err := kms.request(ctx, kms.keyName, "encrypt", map[string]interface{}{
+		"plaintext": dataKey,
+	}, &parsed)
+	return parsed.Ciphertext, err
+}
+
+// Decrypted with the key that wrapped it, which picks the right version
+func (kms *gcpEnvelopeKms) unwrap(ctx context.Context, keyId string, wrapped []byte) ([]byte, error) {
+	parsed := struct {
+		Plaintext []byte `json:"plaintext"`
+	}{}
+
+	err := kms.request(ctx, keyId, "decrypt", map[string]interface{}{
+		"ciphertext": wrapped,
+	}, &parsed)
+	return parsed.Plaintext, err
+}
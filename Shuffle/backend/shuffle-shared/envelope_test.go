@@ -0,0 +1,308 @@
+package shuffle
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+)
+
+func getTestMasterKey(fill byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, 32))
+}
+
+// Sets the KMS used by the envelope functions until the test ends
+func setTestEnvelopeKms(t *testing.T, masterKeys string) {
+	var kms envelopeKms
+	if len(masterKeys) > 0 {
+		localKms, err := newLocalEnvelopeKms(masterKeys)
+		if err != nil {
+			t.Fatalf("newLocalEnvelopeKms failed: %s", err)
+		}
+
+		kms = localKms
+	}
+
+	envelope.Lock()
+	envelope.kms = kms
+	envelope.err = nil
+	envelope.loaded = true
+	envelope.dataKeys = map[string]envelopeCachedKey{}
+	envelope.Unlock()
+
+	t.Cleanup(func() {
+		envelope.Lock()
+		envelope.kms = nil
+		envelope.err = nil
+		envelope.loaded = false
+		envelope.dataKeys = map[string]envelopeCachedKey{}
+		envelope.Unlock()
+	})
+}
+
+func TestNewLocalEnvelopeKms(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		activeId string
+		keys     int
+	}{
+		{name: "single key", value: "k1:" + getTestMasterKey(1), activeId: "k1", keys: 1},
+		{name: "first key is active", value: fmt.Sprintf("k2:%s, k1:%s,", getTestMasterKey(2), getTestMasterKey(1)), activeId: "k2", keys: 2},
+		{name: "empty", value: ""},
+		{name: "only separators", value: " , "},
+		{name: "missing id", value: ":" + getTestMasterKey(1)},
+		{name: "missing key", value: "k1"},
+		{name: "invalid base64", value: "k1:not base64"},
+		{name: "short key", value: "k1:" + base64.StdEncoding.EncodeToString([]byte("short"))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kms, err := newLocalEnvelopeKms(test.value)
+			if len(test.activeId) == 0 {
+				if err == nil {
+					t.Errorf("newLocalEnvelopeKms succeeded, expected an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("newLocalEnvelopeKms failed: %s", err)
+			}
+
+			if kms.activeKeyId() != test.activeId || len(kms.keys) != test.keys {
+				t.Errorf("got active key %s of %d, expected %s of %d", kms.activeKeyId(), len(kms.keys), test.activeId, test.keys)
+			}
+		})
+	}
+}
+
+func TestEnvelopeSealOpen(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	sealed, err := envelopeSeal(key, []byte("api key"), []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("envelopeSeal failed: %s", err)
+	}
+
+	tests := []struct {
+		name           string
+		key            []byte
+		sealed         []byte
+		additionalData []byte
+		valid          bool
+	}{
+		{name: "valid", key: key, sealed: sealed, additionalData: []byte("passphrase"), valid: true},
+		{name: "other additional data", key: key, sealed: sealed, additionalData: []byte("other")},
+		{name: "other key", key: bytes.Repeat([]byte{2}, 32), sealed: sealed, additionalData: []byte("passphrase")},
+		{name: "tampered", key: key, sealed: append(append([]byte{}, sealed[:len(sealed)-1]...), sealed[len(sealed)-1]^1), additionalData: []byte("passphrase")},
+		{name: "too short", key: key, sealed: sealed[:4], additionalData: []byte("passphrase")},
+		{name: "invalid key size", key: []byte("short"), sealed: sealed, additionalData: []byte("passphrase")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plaintext, err := envelopeOpen(test.key, test.sealed, test.additionalData)
+			if (err == nil) != test.valid {
+				t.Fatalf("envelopeOpen returned %v, expected valid=%t", err, test.valid)
+			}
+
+			if test.valid && string(plaintext) != "api key" {
+				t.Errorf("envelopeOpen = %q, expected %q", plaintext, "api key")
+			}
+		})
+	}
+}
+
+func TestGetEnvelopeKey(t *testing.T) {
+	value, err := formatEnvelopeValue(envelopeValue{Kms: EnvelopeKmsLocal, KeyId: "k1", WrappedKey: "a", Data: "b"})
+	if err != nil {
+		t.Fatalf("formatEnvelopeValue failed: %s", err)
+	}
+
+	tests := []struct {
+		name  string
+		data  []byte
+		kms   string
+		keyId string
+		ok    bool
+	}{
+		{name: "envelope value", data: value, kms: EnvelopeKmsLocal, keyId: "k1", ok: true},
+		{name: "legacy value", data: []byte(base64.StdEncoding.EncodeToString([]byte("legacy")))},
+		{name: "invalid base64", data: []byte(envelopePrefix + "not base64")},
+		{name: "invalid json", data: []byte(envelopePrefix + base64.StdEncoding.EncodeToString([]byte("{")))},
+	}
+
+	for _, test := range tests {
+		kms, keyId, ok := GetEnvelopeKey(test.data)
+		if kms != test.kms || keyId != test.keyId || ok != test.ok {
+			t.Errorf("%s: GetEnvelopeKey = %s, %s, %t, expected %s, %s, %t", test.name, kms, keyId, ok, test.kms, test.keyId, test.ok)
+		}
+	}
+}
+
+func TestEnvelopeEncryption(t *testing.T) {
+	setTestEnvelopeKms(t, "k1:"+getTestMasterKey(1))
+
+	encrypted, err := HandleKeyEncryption([]byte("api key"), "org_1_label_apikey")
+	if err != nil {
+		t.Fatalf("HandleKeyEncryption failed: %s", err)
+	}
+
+	kms, keyId, ok := GetEnvelopeKey(encrypted)
+	if !ok || kms != EnvelopeKmsLocal || keyId != "k1" {
+		t.Errorf("got envelope key %s, %s, %t, expected local k1", kms, keyId, ok)
+	}
+
+	again, err := HandleKeyEncryption([]byte("api key"), "org_1_label_apikey")
+	if err != nil || bytes.Equal(encrypted, again) {
+		t.Errorf("encrypting the same value twice gave the same result")
+	}
+
+	decrypted, err := HandleKeyDecryption(encrypted, "org_1_label_apikey")
+	if err != nil || string(decrypted) != "api key" {
+		t.Errorf("HandleKeyDecryption = %q, %v, expected %q", decrypted, err, "api key")
+	}
+
+	// The passphrase is bound to the value, so it can't be moved to another field
+	_, err = HandleKeyDecryption(encrypted, "org_1_label_username")
+	if err == nil {
+		t.Errorf("value was decrypted with another passphrase")
+	}
+}
+
+func TestEnvelopeEncryptionDisabled(t *testing.T) {
+	setTestEnvelopeKms(t, "")
+	t.Setenv("SHUFFLE_ENCRYPTION_MODIFIER", "envelope-test-modifier")
+
+	if IsEnvelopeEncryptionEnabled() {
+		t.Errorf("envelope encryption is enabled without a KMS")
+	}
+
+	encrypted, err := HandleKeyEncryption([]byte("api key"), "passphrase")
+	if err != nil || isEnvelopeValue(encrypted) {
+		t.Fatalf("expected legacy encryption, got %q, %v", encrypted, err)
+	}
+
+	decrypted, err := HandleKeyDecryption(encrypted, "passphrase")
+	if err != nil || string(decrypted) != "api key" {
+		t.Errorf("HandleKeyDecryption = %q, %v, expected %q", decrypted, err, "api key")
+	}
+
+	value, err := formatEnvelopeValue(envelopeValue{Kms: EnvelopeKmsLocal, KeyId: "k1"})
+	if err != nil {
+		t.Fatalf("formatEnvelopeValue failed: %s", err)
+	}
+
+	_, err = HandleKeyDecryption(value, "passphrase")
+	if err == nil {
+		t.Errorf("envelope value was decrypted without a KMS")
+	}
+}
+
+func TestEnvelopeKeyRotation(t *testing.T) {
+	setTestEnvelopeKms(t, "k1:"+getTestMasterKey(1))
+	encrypted, err := HandleKeyEncryption([]byte("api key"), "passphrase")
+	if err != nil {
+		t.Fatalf("HandleKeyEncryption failed: %s", err)
+	}
+
+	setTestEnvelopeKms(t, fmt.Sprintf("k2:%s,k1:%s", getTestMasterKey(2), getTestMasterKey(1)))
+	decrypted, err := HandleKeyDecryption(encrypted, "passphrase")
+	if err != nil || string(decrypted) != "api key" {
+		t.Fatalf("decrypting with the old key kept returned %q, %v", decrypted, err)
+	}
+
+	kms, _ := getEnvelopeKms()
+	rewrapped, err := rewrapEnvelope(context.Background(), kms, encrypted)
+	if err != nil {
+		t.Fatalf("rewrapEnvelope failed: %s", err)
+	}
+
+	oldValue, _ := parseEnvelopeValue(encrypted)
+	newValue, _ := parseEnvelopeValue(rewrapped)
+	if newValue.KeyId != "k2" || newValue.Data != oldValue.Data || newValue.WrappedKey == oldValue.WrappedKey {
+		t.Errorf("got rewrapped value %+v from %+v", newValue, oldValue)
+	}
+
+	setTestEnvelopeKms(t, "k2:"+getTestMasterKey(2))
+	decrypted, err = HandleKeyDecryption(rewrapped, "passphrase")
+	if err != nil || string(decrypted) != "api key" {
+		t.Errorf("decrypting the rewrapped value returned %q, %v", decrypted, err)
+	}
+
+	_, err = HandleKeyDecryption(encrypted, "passphrase")
+	if err == nil {
+		t.Errorf("value wrapped with a removed key was decrypted")
+	}
+}
+
+func TestMigrateAuthEncryption(t *testing.T) {
+	t.Setenv("SHUFFLE_ENCRYPTION_MODIFIER", "envelope-test-modifier")
+	auth := AppAuthenticationStorage{OrgId: "org", Created: 1718000000, Label: "label", Encrypted: true}
+	getPassphrase := func(key string) string {
+		return fmt.Sprintf("%s_%d_%s_%s", auth.OrgId, auth.Created, auth.Label, key)
+	}
+
+	legacy, err := handleKeyEncryption([]byte("legacy value"), getPassphrase("legacy"))
+	if err != nil {
+		t.Fatalf("handleKeyEncryption failed: %s", err)
+	}
+
+	setTestEnvelopeKms(t, "k1:"+getTestMasterKey(1))
+	wrapped, err := HandleKeyEncryption([]byte("old key value"), getPassphrase("old_key"))
+	if err != nil {
+		t.Fatalf("HandleKeyEncryption failed: %s", err)
+	}
+
+	auth.Fields = []AuthenticationStore{
+		{Key: "legacy", Value: string(legacy)},
+		{Key: "old_key", Value: string(wrapped)},
+		{Key: "empty", Value: ""},
+	}
+
+	setTestEnvelopeKms(t, fmt.Sprintf("k2:%s,k1:%s", getTestMasterKey(2), getTestMasterKey(1)))
+	changed, err := MigrateAuthEncryption(context.Background(), &auth)
+	if err != nil || !changed {
+		t.Fatalf("MigrateAuthEncryption = %t, %v, expected a change", changed, err)
+	}
+
+	expected := map[string]string{"legacy": "legacy value", "old_key": "old key value"}
+	for _, field := range auth.Fields {
+		if field.Key == "empty" {
+			if len(field.Value) > 0 {
+				t.Errorf("empty field was changed to %s", field.Value)
+			}
+
+			continue
+		}
+
+		_, keyId, ok := GetEnvelopeKey([]byte(field.Value))
+		if !ok || keyId != "k2" {
+			t.Errorf("field %s is wrapped with %s, expected k2", field.Key, keyId)
+		}
+
+		decrypted, err := HandleKeyDecryption([]byte(field.Value), getPassphrase(field.Key))
+		if err != nil || string(decrypted) != expected[field.Key] {
+			t.Errorf("field %s decrypted to %q, %v, expected %q", field.Key, decrypted, err, expected[field.Key])
+		}
+	}
+
+	changed, err = MigrateAuthEncryption(context.Background(), &auth)
+	if err != nil || changed {
+		t.Errorf("second migration = %t, %v, expected no change", changed, err)
+	}
+
+	changed, err = MigrateAuthEncryption(context.Background(), &AppAuthenticationStorage{Encrypted: false})
+	if err != nil || !changed {
+		t.Errorf("migrating an unencrypted auth = %t, %v, expected it to be saved", changed, err)
+	}
+
+	setTestEnvelopeKms(t, "")
+	_, err = MigrateAuthEncryption(context.Background(), &auth)
+	if err == nil {
+		t.Errorf("MigrateAuthEncryption succeeded without a KMS")
+	}
+}
@@ -0,0 +1,105 @@
+package shuffle
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Fault injection for resilience testing. A DatastoreFault in the context
+// of a database call delays it and fails some of them, as if the database
+// was slow or partially down. Faults are only added to contexts by the
+// backend, so nothing here runs unless it is turned on there.
+//
+// Opensearch requests are faulted in the client transport, and PostgreSQL
+// queries before they are sent. Cached reads never reach the database and
+// aren't affected.
+
+var ErrInjectedFault = errors.New("injected datastore fault")
+
+type DatastoreFault struct {
+	OrgId     string  `json:"org_id"`
+	LatencyMs int     `json:"latency_ms"`
+	JitterMs  int     `json:"jitter_ms"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+type datastoreFaultKey struct{}
+
+type faultTransport struct {
+	next http.RoundTripper
+}
+
+// Faults injected per org since the backend started
+var injectedFaults = struct {
+	sync.Mutex
+	counts map[string]int64
+}{
+	counts: map[string]int64{},
+}
+
+func WithDatastoreFault(ctx context.Context, fault DatastoreFault) context.Context {
+	return context.WithValue(ctx, datastoreFaultKey{}, fault)
+}
+
+func GetDatastoreFault(ctx context.Context) (DatastoreFault, bool) {
+	if ctx == nil {
+		return DatastoreFault{}, false
+	}
+
+	fault, ok := ctx.Value(datastoreFaultKey{}).(DatastoreFault)
+	return fault, ok
+}
+
+func GetInjectedDatastoreFaults(orgId string) int64 {
+	injectedFaults.Lock()
+	defer injectedFaults.Unlock()
+
+	return injectedFaults.counts[orgId]
+}
+
+// Waits the latency of the fault in the context, and returns
+// ErrInjectedFault for the share of calls set by its error rate
+func injectDatastoreFault(ctx context.Context) error {
+	fault, ok := GetDatastoreFault(ctx)
+	if !ok {
+		return nil
+	}
+
+	delay := time.Duration(fault.LatencyMs) * time.Millisecond
+	if fault.JitterMs > 0 {
+		delay += time.Duration(rand.Intn(fault.JitterMs+1)) * time.Millisecond
+	}
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if fault.ErrorRate <= 0 || rand.Float64() >= fault.ErrorRate {
+		return nil
+	}
+
+	injectedFaults.Lock()
+	injectedFaults.counts[fault.OrgId] += 1
+	injectedFaults.Unlock()
+
+	log.Printf("[DEBUG] Injected datastore fault for org %s", fault.OrgId)
+	return ErrInjectedFault
+}
+
+func (transport *faultTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	err := injectDatastoreFault(request.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	return transport.next.RoundTrip(request)
+}
@@ -1931,3 +1931,86 @@ func HandleDownloadRemoteFiles(resp http.ResponseWriter, request *http.Request)
 	resp.WriteHeader(200)
 	resp.Write([]byte(fmt.Sprintf(`{"success": true}`)))
 }
+
+// Stores contents as a new file of an org, in the same storage and with the
+// same encryption as uploads through the files API. Used by backend features
+// keeping their own files. Returns the id of the file
+func UploadOrgFile(ctx context.Context, file *File, contents []byte) (string, error) {
+	if len(basepath) == 0 {
+		basepath = "files"
+	}
+
+	if len(file.WorkflowId) == 0 {
+		file.WorkflowId = "global"
+	}
+
+	folderPath := fmt.Sprintf("%s/%s/%s", basepath, file.OrgId, file.WorkflowId)
+	if project.Environment != "cloud" {
+		err := os.MkdirAll(folderPath, os.ModePerm)
+		if err != nil {
+			log.Printf("[ERROR] Failed creating file location %s: %s", folderPath, err)
+			return "", err
+		}
+	}
+
+	timeNow := time.Now().Unix()
+	file.Id = fmt.Sprintf("file_%s", uuid.NewV4().String())
+	file.CreatedAt = timeNow
+	file.UpdatedAt = timeNow
+	file.Status = "created"
+	file.DownloadPath = fmt.Sprintf("%s/%s", folderPath, file.Id)
+	file.StorageArea = "local"
+	file.OriginalMd5sum = Md5sum(contents)
+	if project.Environment == "cloud" {
+		file.StorageArea = "google_storage"
+	}
+
+	err := SetFile(ctx, *file)
+	if err != nil {
+		log.Printf("[ERROR] Failed setting file %s: %s", file.Id, err)
+		return "", err
+	}
+
+	return uploadFile(ctx, file, fmt.Sprintf("%s_%s", file.OrgId, file.Id), contents)
+}
+
+// Marks a file as deleted. The stored contents are removed unless another
+// active file of the org references the same contents
+func DeleteOrgFile(ctx context.Context, file *File) error {
+	if file.Status == "deleted" {
+		return nil
+	}
+
+	file.Status = "deleted"
+	err := SetFile(ctx, *file)
+	if err != nil {
+		log.Printf("[ERROR] Failed setting file %s to deleted: %s", file.Id, err)
+		return err
+	}
+
+	nameKey := "Files"
+	DeleteCache(ctx, fmt.Sprintf("%s_%s_%s", nameKey, file.OrgId, file.Md5sum))
+	DeleteCache(ctx, fmt.Sprintf("%s_%s", nameKey, file.OrgId))
+
+	similarFiles, err := FindSimilarFile(ctx, file.Md5sum, file.OrgId)
+	if err != nil {
+		return err
+	}
+
+	for _, similarFile := range similarFiles {
+		if similarFile.Id != file.Id && similarFile.Status == "active" {
+			log.Printf("[DEBUG] Keeping contents of deleted file %s as file %s references them", file.Id, similarFile.Id)
+			return nil
+		}
+	}
+
+	if project.Environment == "cloud" || file.StorageArea == "google_storage" {
+		return project.StorageClient.Bucket(orgFileBucket).Object(file.DownloadPath).Delete(ctx)
+	}
+
+	if fileExists(file.DownloadPath) {
+		return os.Remove(file.DownloadPath)
+	}
+
+	return nil
+}
@@ -0,0 +1,266 @@
+package shuffle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"github.com/redis/go-redis/v9"
+)
+
+// Leases let one backend replica at a time do work that should only run once,
+// such as scheduled jobs. A lease belongs to its holder until it expires or is
+// released, and only the holder can renew it. Leases are kept in Redis when
+// SHUFFLE_REDIS_URL is set, and otherwise in the database, using its
+// optimistic concurrency control so two replicas can't take the same lease.
+//
+// Expiry is compared with the clock of each replica, so their clocks have to
+// be synchronized to well within the lease duration.
+
+type Lease struct {
+	Name     string `json:"name" datastore:"name"`
+	Holder   string `json:"holder" datastore:"holder"`
+	Expires  int64  `json:"expires" datastore:"expires"`
+	Acquired int64  `json:"acquired" datastore:"acquired"`
+}
+
+type leaseWrapper struct {
+	Found       bool  `json:"found"`
+	SeqNo       int   `json:"_seq_no"`
+	PrimaryTerm int   `json:"_primary_term"`
+	Source      Lease `json:"_source"`
+}
+
+var errLeaseTaken = errors.New("lease is held by another holder")
+
+// Sets the lease if the holder already has it, or if it's free
+var redisAcquireLease = redis.NewScript(`
+local current = redis.call("get", KEYS[1])
+if current == false or current == ARGV[1] then
+	redis.call("set", KEYS[1], ARGV[1], "px", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+var redisReleaseLease = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+func getLeaseKey(name string) string {
+	return fmt.Sprintf("shuffle_lease_%s", name)
+}
+
+func getOpensearchLease(ctx context.Context, name string) (leaseWrapper, error) {
+	wrapped := leaseWrapper{}
+	res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix("leases")), name, project.Es.Get.WithContext(ctx))
+	if err != nil {
+		return wrapped, err
+	}
+
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return wrapped, nil
+	}
+
+	respBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return wrapped, err
+	}
+
+	err = json.Unmarshal(respBody, &wrapped)
+	return wrapped, err
+}
+
+// Writes the lease if it wasn't changed since it was read. Returns
+// errLeaseTaken if another replica wrote it in between
+func setOpensearchLease(ctx context.Context, lease Lease, previous leaseWrapper) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+
+	req := opensearchapi.IndexRequest{
+		Index:      strings.ToLower(GetESIndexPrefix("leases")),
+		DocumentID: lease.Name,
+		Body:       strings.NewReader(string(data)),
+		Refresh:    "true",
+	}
+
+	if previous.Found {
+		req.IfSeqNo = &previous.SeqNo
+		req.IfPrimaryTerm = &previous.PrimaryTerm
+	} else {
+		req.OpType = "create"
+	}
+
+	res, err := req.Do(ctx, &project.Es)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+	if res.StatusCode == 409 {
+		return errLeaseTaken
+	}
+
+	if res.StatusCode != 200 && res.StatusCode != 201 {
+		respBody, _ := ioutil.ReadAll(res.Body)
+		return errors.New(fmt.Sprintf("Bad statuscode from database: %d. Reason: %s", res.StatusCode, string(respBody)))
+	}
+
+	return nil
+}
+
+// Reads the lease and writes what update returns, without another replica
+// changing it in between. update returns errLeaseTaken to leave it as it is
+func updateLease(ctx context.Context, name string, update func(lease Lease) (Lease, error)) error {
+	if project.DbType == "opensearch" {
+		previous, err := getOpensearchLease(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		lease, err := update(previous.Source)
+		if err != nil {
+			return err
+		}
+
+		return setOpensearchLease(ctx, lease, previous)
+	}
+
+	key := datastore.NameKey("leases", name, nil)
+	_, err := project.Dbclient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		lease := Lease{}
+		err := tx.Get(key, &lease)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		lease, err = update(lease)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Put(key, &lease)
+		return err
+	})
+
+	return err
+}
+
+// Takes or renews the lease for the holder. Returns false if another holder
+// has it
+func AcquireLease(ctx context.Context, name, holder string, duration time.Duration) (bool, error) {
+	if useRedis() {
+		redisCtx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+
+		acquired, err := redisAcquireLease.Run(redisCtx, project.Redis, []string{getLeaseKey(name)}, holder, duration.Milliseconds()).Int()
+		return acquired == 1, err
+	}
+
+	timeNow := time.Now()
+	err := updateLease(ctx, name, func(lease Lease) (Lease, error) {
+		if lease.Holder != holder && lease.Expires > timeNow.Unix() {
+			return lease, errLeaseTaken
+		}
+
+		if lease.Holder != holder {
+			lease.Acquired = timeNow.Unix()
+		}
+
+		lease.Name = name
+		lease.Holder = holder
+		lease.Expires = timeNow.Add(duration).Unix()
+		return lease, nil
+	})
+
+	if err == errLeaseTaken {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Gives the lease up so another holder can take it right away
+func ReleaseLease(ctx context.Context, name, holder string) error {
+	if useRedis() {
+		redisCtx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+
+		return redisReleaseLease.Run(redisCtx, project.Redis, []string{getLeaseKey(name)}, holder).Err()
+	}
+
+	err := updateLease(ctx, name, func(lease Lease) (Lease, error) {
+		if lease.Holder != holder {
+			return lease, errLeaseTaken
+		}
+
+		lease.Expires = 0
+		return lease, nil
+	})
+
+	if err == errLeaseTaken {
+		return nil
+	}
+
+	return err
+}
+
+// Returns the current holder of the lease. Holder is empty if it's free
+func GetLease(ctx context.Context, name string) (Lease, error) {
+	lease := Lease{Name: name}
+	if useRedis() {
+		redisCtx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+
+		holder, err := project.Redis.Get(redisCtx, getLeaseKey(name)).Result()
+		if err == redis.Nil {
+			return lease, nil
+		}
+
+		if err != nil {
+			return lease, err
+		}
+
+		ttl, err := project.Redis.PTTL(redisCtx, getLeaseKey(name)).Result()
+		if err != nil {
+			return lease, err
+		}
+
+		lease.Holder = holder
+		lease.Expires = time.Now().Add(ttl).Unix()
+		return lease, nil
+	}
+
+	if project.DbType == "opensearch" {
+		wrapped, err := getOpensearchLease(ctx, name)
+		if err != nil {
+			return lease, err
+		}
+
+		lease = wrapped.Source
+	} else {
+		err := project.Dbclient.Get(ctx, datastore.NameKey("leases", name, nil), &lease)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return lease, err
+		}
+	}
+
+	if lease.Expires <= time.Now().Unix() {
+		lease.Holder = ""
+	}
+
+	lease.Name = name
+	return lease, nil
+}
@@ -0,0 +1,236 @@
+package shuffle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Internal documents of an org, such as the settings and state of backend
+// features. They are kept in their own kind instead of org_cache, so they
+// can't be read or changed through the cache API that every org member and
+// running workflow can use.
+//
+// Updates hold a lease (lease.go) on the document while it's read, changed
+// and written, so updates from several backend replicas aren't lost.
+
+const orgDocumentKind = "org_documents"
+
+// Cache keys with this prefix are refused by the cache API. Documents used to
+// be stored in org_cache with it
+const ReservedCacheKeyPrefix = "csl_"
+
+// Longest time an update can hold a document, in case its replica dies
+const orgDocumentLockDuration = 30 * time.Second
+
+// Longest time an update waits for another update of the same document
+const orgDocumentLockTimeout = 10 * time.Second
+
+// Minutes a document is cached after it's read
+const orgDocumentCacheMinutes = 1
+
+type OrgDocument struct {
+	OrgId  string `json:"org_id" datastore:"org_id"`
+	Name   string `json:"name" datastore:"name"`
+	Value  string `json:"value" datastore:"value,noindex"`
+	Edited int64  `json:"edited" datastore:"edited"`
+}
+
+type orgDocumentWrapper struct {
+	Found  bool        `json:"found"`
+	Source OrgDocument `json:"_source"`
+}
+
+// Serializes updates within this replica, so they don't have to poll the lease
+var orgDocumentLocks sync.Map
+
+// Whether a cache key is reserved for internal use. Keys are compared the same
+// way as the last resort search in HandleGetCacheKey
+func IsReservedCacheKey(key string) bool {
+	key = strings.ReplaceAll(strings.ToLower(strings.Trim(key, " ")), " ", "_")
+	return strings.HasPrefix(key, ReservedCacheKeyPrefix)
+}
+
+func getOrgDocumentId(orgId, name string) string {
+	return fmt.Sprintf("%s_%s", orgId, name)
+}
+
+func getOrgDocumentCacheKey(id string) string {
+	return fmt.Sprintf("%s_%s", orgDocumentKind, id)
+}
+
+// Reads a document from the database, skipping the cache
+func getOrgDocumentFromDb(ctx context.Context, id string) (OrgDocument, bool, error) {
+	document := OrgDocument{}
+	if project.DbType == "opensearch" {
+		res, err := project.Es.Get(strings.ToLower(GetESIndexPrefix(orgDocumentKind)), id, project.Es.Get.WithContext(ctx))
+		if err != nil {
+			return document, false, err
+		}
+
+		defer res.Body.Close()
+		if res.StatusCode == 404 {
+			return document, false, nil
+		}
+
+		respBody, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return document, false, err
+		}
+
+		wrapped := orgDocumentWrapper{}
+		err = json.Unmarshal(respBody, &wrapped)
+		if err != nil {
+			return document, false, err
+		}
+
+		return wrapped.Source, wrapped.Found, nil
+	}
+
+	err := project.Dbclient.Get(ctx, datastore.NameKey(orgDocumentKind, id, nil), &document)
+	if err == datastore.ErrNoSuchEntity {
+		return document, false, nil
+	}
+
+	if err != nil {
+		return document, false, err
+	}
+
+	return document, true, nil
+}
+
+// Returns a document of an org. Found is false if it hasn't been stored yet
+func GetOrgDocument(ctx context.Context, orgId, name string) (OrgDocument, bool, error) {
+	id := getOrgDocumentId(orgId, name)
+	cacheKey := getOrgDocumentCacheKey(id)
+	if project.CacheDb {
+		cache, err := GetCache(ctx, cacheKey)
+		if err == nil {
+			document := OrgDocument{}
+			err = json.Unmarshal([]byte(cache.([]uint8)), &document)
+			if err == nil {
+				return document, true, nil
+			}
+		}
+	}
+
+	document, found, err := getOrgDocumentFromDb(ctx, id)
+	if err != nil || !found {
+		return document, found, err
+	}
+
+	if project.CacheDb {
+		data, err := json.Marshal(document)
+		if err == nil {
+			err = SetCache(ctx, cacheKey, data, orgDocumentCacheMinutes)
+			if err != nil {
+				log.Printf("[WARNING] Failed caching org document %s: %s", id, err)
+			}
+		}
+	}
+
+	return document, true, nil
+}
+
+func setOrgDocument(ctx context.Context, document OrgDocument) error {
+	id := getOrgDocumentId(document.OrgId, document.Name)
+	document.Edited = time.Now().Unix()
+
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	if project.DbType == "opensearch" {
+		err = indexEs(ctx, orgDocumentKind, id, data)
+	} else {
+		_, err = project.Dbclient.Put(ctx, datastore.NameKey(orgDocumentKind, id, nil), &document)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if project.CacheDb {
+		err = SetCache(ctx, getOrgDocumentCacheKey(id), data, orgDocumentCacheMinutes)
+		if err != nil {
+			log.Printf("[WARNING] Failed caching org document %s: %s", id, err)
+		}
+	}
+
+	return nil
+}
+
+// Holds the lease of a document until the returned function is called
+func lockOrgDocument(ctx context.Context, id string) (func(), error) {
+	localLock, _ := orgDocumentLocks.LoadOrStore(id, &sync.Mutex{})
+	localLock.(*sync.Mutex).Lock()
+
+	leaseName := fmt.Sprintf("org_document_%s", id)
+	holder := uuid.NewV4().String()
+	deadline := time.Now().Add(orgDocumentLockTimeout)
+	for {
+		acquired, err := AcquireLease(ctx, leaseName, holder, orgDocumentLockDuration)
+		if err != nil {
+			localLock.(*sync.Mutex).Unlock()
+			return nil, err
+		}
+
+		if acquired {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			localLock.(*sync.Mutex).Unlock()
+			return nil, errors.New(fmt.Sprintf("timed out waiting for another update of org document %s", id))
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return func() {
+		err := ReleaseLease(context.Background(), leaseName, holder)
+		if err != nil {
+			log.Printf("[WARNING] Failed releasing the lease of org document %s: %s", id, err)
+		}
+
+		localLock.(*sync.Mutex).Unlock()
+	}, nil
+}
+
+// Reads a document, runs update on it and stores the result, without another
+// update of the same document in between on any replica. Document.Value is
+// empty if it hasn't been stored yet. Nothing is stored if update returns an
+// error
+func UpdateOrgDocument(ctx context.Context, orgId, name string, update func(document *OrgDocument) error) error {
+	id := getOrgDocumentId(orgId, name)
+	unlock, err := lockOrgDocument(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	document, _, err := getOrgDocumentFromDb(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = update(&document)
+	if err != nil {
+		return err
+	}
+
+	document.OrgId = orgId
+	document.Name = name
+	return setOrgDocument(ctx, document)
+}
@@ -0,0 +1,292 @@
+package shuffle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Per-org redaction of execution data. Rules are applied when an execution
+// is saved to the database and when executions are returned to users. The
+// execution cache used by running executions keeps the original values, so
+// later actions in the same execution can still use them.
+//
+// Two kinds of rules exist:
+//
+//	regex  replaces every match of the pattern in results and parameters
+//	field  replaces values of JSON keys matching a dotted path. The path is
+//	       matched against the end of the key path, so "password" matches
+//	       the key at any depth and "headers.authorization" only under
+//	       headers. * matches any single key or array index
+const (
+	RedactionTypeRegex = "regex"
+	RedactionTypeField = "field"
+)
+
+var RedactionTypes = []string{RedactionTypeRegex, RedactionTypeField}
+
+const DefaultRedactionReplacement = "[REDACTED]"
+
+type RedactionRule struct {
+	Id          string `json:"id" datastore:"id"`
+	Name        string `json:"name" datastore:"name"`
+	Type        string `json:"type" datastore:"type"`
+	Pattern     string `json:"pattern" datastore:"pattern,noindex"`
+	Replacement string `json:"replacement" datastore:"replacement,noindex"`
+	Enabled     bool   `json:"enabled" datastore:"enabled"`
+	Created     int64  `json:"created" datastore:"created"`
+	CreatedBy   string `json:"created_by" datastore:"created_by"`
+}
+
+var redactionPatterns = struct {
+	sync.Mutex
+	compiled map[string]*regexp.Regexp
+}{compiled: map[string]*regexp.Regexp{}}
+
+func getRedactionPattern(pattern string) (*regexp.Regexp, error) {
+	redactionPatterns.Lock()
+	defer redactionPatterns.Unlock()
+
+	if compiled, ok := redactionPatterns.compiled[pattern]; ok {
+		return compiled, nil
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	redactionPatterns.compiled[pattern] = compiled
+	return compiled, nil
+}
+
+func ValidateRedactionRule(rule RedactionRule) error {
+	if !ArrayContains(RedactionTypes, rule.Type) {
+		return errors.New(fmt.Sprintf("type must be one of %v", RedactionTypes))
+	}
+
+	if len(strings.TrimSpace(rule.Pattern)) == 0 {
+		return errors.New("pattern is required")
+	}
+
+	if rule.Type == RedactionTypeRegex {
+		_, err := getRedactionPattern(rule.Pattern)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid regex: %s", err))
+		}
+	}
+
+	if rule.Type == RedactionTypeField {
+		for _, segment := range strings.Split(rule.Pattern, ".") {
+			if len(segment) == 0 {
+				return errors.New("field paths can't have empty segments")
+			}
+		}
+	}
+
+	return nil
+}
+
+func getRedactionReplacement(rule RedactionRule) string {
+	if len(rule.Replacement) == 0 {
+		return DefaultRedactionReplacement
+	}
+
+	return rule.Replacement
+}
+
+// Checks if the end of a key path matches the segments of a field rule
+func matchesRedactionPath(path []string, segments []string) bool {
+	if len(path) < len(segments) {
+		return false
+	}
+
+	offset := len(path) - len(segments)
+	for index, segment := range segments {
+		if segment != "*" && !strings.EqualFold(segment, path[offset+index]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func redactJsonValue(value interface{}, path []string, rules [][]string, replacements []string) (interface{}, bool) {
+	for index, segments := range rules {
+		if len(path) > 0 && matchesRedactionPath(path, segments) {
+			return replacements[index], true
+		}
+	}
+
+	changed := false
+	switch parsed := value.(type) {
+	case map[string]interface{}:
+		for key, item := range parsed {
+			newItem, itemChanged := redactJsonValue(item, append(path, key), rules, replacements)
+			if itemChanged {
+				parsed[key] = newItem
+				changed = true
+			}
+		}
+	case []interface{}:
+		for index, item := range parsed {
+			newItem, itemChanged := redactJsonValue(item, append(path, strconv.Itoa(index)), rules, replacements)
+			if itemChanged {
+				parsed[index] = newItem
+				changed = true
+			}
+		}
+	}
+
+	return value, changed
+}
+
+// Redacts a single value, such as an action result. Field rules only apply
+// to values that are JSON objects or arrays
+func RedactValue(value string, rules []RedactionRule) (string, bool) {
+	if len(value) == 0 {
+		return value, false
+	}
+
+	changed := false
+	fieldRules := [][]string{}
+	fieldReplacements := []string{}
+	for _, rule := range rules {
+		if rule.Enabled && rule.Type == RedactionTypeField {
+			fieldRules = append(fieldRules, strings.Split(rule.Pattern, "."))
+			fieldReplacements = append(fieldReplacements, getRedactionReplacement(rule))
+		}
+	}
+
+	trimmed := strings.TrimSpace(value)
+	if len(fieldRules) > 0 && (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) {
+		var parsed interface{}
+		if err := json.Unmarshal([]byte(trimmed), &parsed); err == nil {
+			newValue, fieldsChanged := redactJsonValue(parsed, []string{}, fieldRules, fieldReplacements)
+			if fieldsChanged {
+				marshalled, err := json.Marshal(newValue)
+				if err == nil {
+					value = string(marshalled)
+					changed = true
+				}
+			}
+		}
+	}
+
+	for _, rule := range rules {
+		if !rule.Enabled || rule.Type != RedactionTypeRegex {
+			continue
+		}
+
+		pattern, err := getRedactionPattern(rule.Pattern)
+		if err != nil {
+			continue
+		}
+
+		if pattern.MatchString(value) {
+			value = pattern.ReplaceAllLiteralString(value, getRedactionReplacement(rule))
+			changed = true
+		}
+	}
+
+	return value, changed
+}
+
+// Parameters are redacted by value, and field rules with a single segment
+// also match the parameter name
+func redactParameters(parameters []WorkflowAppActionParameter, rules []RedactionRule) ([]WorkflowAppActionParameter, bool) {
+	changed := false
+	newParameters := make([]WorkflowAppActionParameter, len(parameters))
+	for index, param := range parameters {
+		for _, rule := range rules {
+			if rule.Enabled && rule.Type == RedactionTypeField && len(param.Value) > 0 && matchesRedactionPath([]string{param.Name}, strings.Split(rule.Pattern, ".")) {
+				param.Value = getRedactionReplacement(rule)
+				changed = true
+			}
+		}
+
+		newValue, valueChanged := RedactValue(param.Value, rules)
+		if valueChanged {
+			param.Value = newValue
+			changed = true
+		}
+
+		newParameters[index] = param
+	}
+
+	return newParameters, changed
+}
+
+// Applies rules to the results, parameters, argument and result of an
+// execution. The execution passed in isn't modified
+func RedactExecutionWithRules(workflowExecution WorkflowExecution, rules []RedactionRule) (WorkflowExecution, bool) {
+	enabled := false
+	for _, rule := range rules {
+		if rule.Enabled {
+			enabled = true
+			break
+		}
+	}
+
+	if !enabled {
+		return workflowExecution, false
+	}
+
+	changed := false
+	if newValue, valueChanged := RedactValue(workflowExecution.ExecutionArgument, rules); valueChanged {
+		workflowExecution.ExecutionArgument = newValue
+		changed = true
+	}
+
+	if newValue, valueChanged := RedactValue(workflowExecution.Result, rules); valueChanged {
+		workflowExecution.Result = newValue
+		changed = true
+	}
+
+	newResults := make([]ActionResult, len(workflowExecution.Results))
+	for index, result := range workflowExecution.Results {
+		if newValue, valueChanged := RedactValue(result.Result, rules); valueChanged {
+			result.Result = newValue
+			changed = true
+		}
+
+		if newParameters, parametersChanged := redactParameters(result.Action.Parameters, rules); parametersChanged {
+			result.Action.Parameters = newParameters
+			changed = true
+		}
+
+		newResults[index] = result
+	}
+
+	if changed {
+		workflowExecution.Results = newResults
+	}
+
+	return workflowExecution, changed
+}
+
+// Applies the redaction rules of the org the execution ran in
+func RedactExecution(ctx context.Context, workflowExecution WorkflowExecution) (WorkflowExecution, bool) {
+	orgId := workflowExecution.ExecutionOrg
+	if len(orgId) == 0 {
+		orgId = workflowExecution.OrgId
+	}
+
+	if len(orgId) == 0 {
+		return workflowExecution, false
+	}
+
+	org, err := GetOrg(ctx, orgId)
+	if err != nil {
+		log.Printf("[WARNING][%s] Failed getting org %s for redaction: %s", workflowExecution.ExecutionId, orgId, err)
+		return workflowExecution, false
+	}
+
+	return RedactExecutionWithRules(workflowExecution, org.RedactionRules)
+}
@@ -0,0 +1,147 @@
+package shuffle
+
+import (
+	"testing"
+)
+
+func TestValidateRedactionRule(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  RedactionRule
+		valid bool
+	}{
+		{name: "regex", rule: RedactionRule{Type: RedactionTypeRegex, Pattern: `\d{4}-\d{4}`}, valid: true},
+		{name: "field", rule: RedactionRule{Type: RedactionTypeField, Pattern: "headers.*.authorization"}, valid: true},
+		{name: "unknown type", rule: RedactionRule{Type: "mask", Pattern: "password"}},
+		{name: "empty pattern", rule: RedactionRule{Type: RedactionTypeField, Pattern: "  "}},
+		{name: "invalid regex", rule: RedactionRule{Type: RedactionTypeRegex, Pattern: "(unclosed"}},
+		{name: "empty segment", rule: RedactionRule{Type: RedactionTypeField, Pattern: "headers..authorization"}},
+		{name: "trailing dot", rule: RedactionRule{Type: RedactionTypeField, Pattern: "password."}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateRedactionRule(test.rule)
+			if (err == nil) != test.valid {
+				t.Errorf("ValidateRedactionRule returned %v, expected valid=%t", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestMatchesRedactionPath(t *testing.T) {
+	tests := []struct {
+		path     []string
+		segments []string
+		expected bool
+	}{
+		{path: []string{"password"}, segments: []string{"password"}, expected: true},
+		{path: []string{"user", "Password"}, segments: []string{"password"}, expected: true},
+		{path: []string{"headers", "authorization"}, segments: []string{"headers", "authorization"}, expected: true},
+		{path: []string{"body", "authorization"}, segments: []string{"headers", "authorization"}},
+		{path: []string{"items", "0", "token"}, segments: []string{"items", "*", "token"}, expected: true},
+		{path: []string{"authorization"}, segments: []string{"headers", "authorization"}},
+		{path: []string{"password", "hint"}, segments: []string{"password"}},
+	}
+
+	for _, test := range tests {
+		if matchesRedactionPath(test.path, test.segments) != test.expected {
+			t.Errorf("matchesRedactionPath(%v, %v) = %t, expected %t", test.path, test.segments, !test.expected, test.expected)
+		}
+	}
+}
+
+func TestRedactValue(t *testing.T) {
+	cardRule := RedactionRule{Type: RedactionTypeRegex, Pattern: `\d{4}-\d{4}-\d{4}-\d{4}`, Replacement: "[CARD]", Enabled: true}
+	passwordRule := RedactionRule{Type: RedactionTypeField, Pattern: "password", Enabled: true}
+	headerRule := RedactionRule{Type: RedactionTypeField, Pattern: "headers.authorization", Enabled: true}
+	tokenRule := RedactionRule{Type: RedactionTypeField, Pattern: "items.*.token", Replacement: "***", Enabled: true}
+	disabledRule := RedactionRule{Type: RedactionTypeRegex, Pattern: "secret", Enabled: false}
+
+	tests := []struct {
+		name     string
+		value    string
+		rules    []RedactionRule
+		expected string
+		changed  bool
+	}{
+		{name: "regex", value: "card 1234-5678-9012-3456 used", rules: []RedactionRule{cardRule}, expected: "card [CARD] used", changed: true},
+		{name: "regex without match", value: "card 1234 used", rules: []RedactionRule{cardRule}, expected: "card 1234 used"},
+		{name: "field at any depth", value: `{"user": {"name": "a", "password": "hunter2"}}`, rules: []RedactionRule{passwordRule}, expected: `{"user":{"name":"a","password":"[REDACTED]"}}`, changed: true},
+		{name: "field with object value", value: `{"password": {"old": "a", "new": "b"}}`, rules: []RedactionRule{passwordRule}, expected: `{"password":"[REDACTED]"}`, changed: true},
+		{name: "field path", value: `{"headers": {"authorization": "Bearer x"}, "authorization": "kept"}`, rules: []RedactionRule{headerRule}, expected: `{"authorization":"kept","headers":{"authorization":"[REDACTED]"}}`, changed: true},
+		{name: "field in array", value: `{"items": [{"token": "a"}, {"token": "b", "id": 1}]}`, rules: []RedactionRule{tokenRule}, expected: `{"items":[{"token":"***"},{"id":1,"token":"***"}]}`, changed: true},
+		{name: "field without match keeps formatting", value: `{"user":  "a"}`, rules: []RedactionRule{passwordRule}, expected: `{"user":  "a"}`},
+		{name: "field on plain text", value: "password: hunter2", rules: []RedactionRule{passwordRule}, expected: "password: hunter2"},
+		{name: "field on invalid json", value: `{"password": `, rules: []RedactionRule{passwordRule}, expected: `{"password": `},
+		{name: "field and regex", value: `{"password": "a", "note": "card 1234-5678-9012-3456"}`, rules: []RedactionRule{passwordRule, cardRule}, expected: `{"note":"card [CARD]","password":"[REDACTED]"}`, changed: true},
+		{name: "disabled rule", value: "a secret value", rules: []RedactionRule{disabledRule}, expected: "a secret value"},
+		{name: "empty value", value: "", rules: []RedactionRule{cardRule}, expected: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, changed := RedactValue(test.value, test.rules)
+			if value != test.expected || changed != test.changed {
+				t.Errorf("RedactValue = %s, %t, expected %s, %t", value, changed, test.expected, test.changed)
+			}
+		})
+	}
+}
+
+func TestRedactExecutionWithRules(t *testing.T) {
+	rules := []RedactionRule{
+		{Type: RedactionTypeField, Pattern: "apikey", Enabled: true},
+		{Type: RedactionTypeRegex, Pattern: `10\.0\.0\.\d+`, Replacement: "[IP]", Enabled: true},
+	}
+
+	execution := WorkflowExecution{
+		ExecutionArgument: `{"apikey": "secret", "host": "10.0.0.5"}`,
+		Result:            "done with 10.0.0.6",
+		Results: []ActionResult{
+			{
+				Result: `{"status": 200}`,
+				Action: Action{Parameters: []WorkflowAppActionParameter{
+					{Name: "apikey", Value: "secret"},
+					{Name: "url", Value: "https://10.0.0.7/api"},
+					{Name: "body", Value: ""},
+				}},
+			},
+		},
+	}
+
+	redacted, changed := RedactExecutionWithRules(execution, rules)
+	if !changed {
+		t.Fatalf("RedactExecutionWithRules didn't change the execution")
+	}
+
+	if redacted.ExecutionArgument != `{"apikey":"[REDACTED]","host":"[IP]"}` {
+		t.Errorf("got execution argument %s", redacted.ExecutionArgument)
+	}
+
+	if redacted.Result != "done with [IP]" {
+		t.Errorf("got result %s", redacted.Result)
+	}
+
+	if redacted.Results[0].Result != `{"status": 200}` {
+		t.Errorf("action result without matches was changed to %s", redacted.Results[0].Result)
+	}
+
+	expected := []string{"[REDACTED]", "https://[IP]/api", ""}
+	for index, param := range redacted.Results[0].Action.Parameters {
+		if param.Value != expected[index] {
+			t.Errorf("parameter %s = %s, expected %s", param.Name, param.Value, expected[index])
+		}
+	}
+
+	if execution.Results[0].Action.Parameters[0].Value != "secret" || execution.Result != "done with 10.0.0.6" {
+		t.Errorf("the original execution was modified")
+	}
+
+	for _, rules := range [][]RedactionRule{nil, {{Type: RedactionTypeRegex, Pattern: "secret", Enabled: false}}} {
+		_, changed = RedactExecutionWithRules(execution, rules)
+		if changed {
+			t.Errorf("execution was changed without enabled rules")
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package shuffle
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HashiCorp Vault secrets backend, storing app authentication values in a
+// KV version 2 secrets engine. Configured with:
+//
+//	SHUFFLE_VAULT_ADDR          e.g. https://vault:8200
+//	SHUFFLE_VAULT_TOKEN         token to use, or
+//	SHUFFLE_VAULT_ROLE_ID       AppRole to log in with, together with
+//	SHUFFLE_VAULT_SECRET_ID
+//	SHUFFLE_VAULT_NAMESPACE     optional, for Vault Enterprise
+//	SHUFFLE_VAULT_MOUNT         KV mount, default secret
+//	SHUFFLE_VAULT_PATH_PREFIX   path under the mount, default shuffle/app_auth
+//	SHUFFLE_VAULT_CACERT        optional CA certificate file
+//
+// The token is renewed in the background before its lease runs out. AppRole
+// logins are made again if the token can't be renewed any more.
+
+const defaultVaultMount = "secret"
+const defaultVaultPathPrefix = "shuffle/app_auth"
+
+const vaultTimeout = 10 * time.Second
+const vaultRenewCheckInterval = time.Minute
+
+// Values are kept in memory for a short while, as executions load every
+// auth of an org
+const vaultSecretCacheSeconds = 30
+
+type vaultBackend struct {
+	sync.Mutex
+	address    string
+	namespace  string
+	mount      string
+	pathPrefix string
+	roleId     string
+	secretId   string
+	client     *http.Client
+
+	token     string
+	renewable bool
+	renewAt   time.Time
+	expiresAt time.Time
+
+	secrets map[string]vaultCachedSecret
+}
+
+type vaultCachedSecret struct {
+	values  map[string]string
+	fetched time.Time
+}
+
+type vaultAuthResponse struct {
+	Auth struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int64  `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+	Data struct {
+		Ttl       int64 `json:"ttl"`
+		Renewable bool  `json:"renewable"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+var vault = struct {
+	sync.Mutex
+	backend *vaultBackend
+}{}
+
+func getVaultBackend() (*vaultBackend, error) {
+	vault.Lock()
+	defer vault.Unlock()
+
+	if vault.backend != nil {
+		return vault.backend, nil
+	}
+
+	backend, err := newVaultBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	err = backend.login(context.Background())
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed logging in to Vault: %s", err))
+	}
+
+	log.Printf("[INFO] Using Vault at %s for app authentication values", backend.address)
+	go backend.renewLoop()
+
+	vault.backend = backend
+	return backend, nil
+}
+
+func newVaultBackend() (*vaultBackend, error) {
+	backend := &vaultBackend{
+		address:    strings.TrimRight(os.Getenv("SHUFFLE_VAULT_ADDR"), "/"),
+		namespace:  os.Getenv("SHUFFLE_VAULT_NAMESPACE"),
+		mount:      strings.Trim(os.Getenv("SHUFFLE_VAULT_MOUNT"), "/"),
+		pathPrefix: strings.Trim(os.Getenv("SHUFFLE_VAULT_PATH_PREFIX"), "/"),
+		roleId:     os.Getenv("SHUFFLE_VAULT_ROLE_ID"),
+		secretId:   os.Getenv("SHUFFLE_VAULT_SECRET_ID"),
+		token:      os.Getenv("SHUFFLE_VAULT_TOKEN"),
+		secrets:    map[string]vaultCachedSecret{},
+	}
+
+	if len(backend.address) == 0 {
+		return nil, errors.New("SHUFFLE_VAULT_ADDR isn't set")
+	}
+
+	if len(backend.token) == 0 && len(backend.roleId) == 0 {
+		return nil, errors.New("SHUFFLE_VAULT_TOKEN or SHUFFLE_VAULT_ROLE_ID is required")
+	}
+
+	if len(backend.mount) == 0 {
+		backend.mount = defaultVaultMount
+	}
+
+	if len(backend.pathPrefix) == 0 {
+		backend.pathPrefix = defaultVaultPathPrefix
+	}
+
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+
+	if caFile := os.Getenv("SHUFFLE_VAULT_CACERT"); len(caFile) > 0 {
+		caData, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, errors.New(fmt.Sprintf("no certificates found in %s", caFile))
+		}
+
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	backend.client = &http.Client{
+		Timeout:   vaultTimeout,
+		Transport: transport,
+	}
+
+	return backend, nil
+}
+
+func (backend *vaultBackend) Name() string {
+	return SecretsBackendVault
+}
+
+func (backend *vaultBackend) request(ctx context.Context, method, path, token string, body interface{}) ([]byte, int, error) {
+	var requestBody *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		requestBody = bytes.NewReader(data)
+	} else {
+		requestBody = bytes.NewReader([]byte{})
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s/v1/%s", backend.address, path), requestBody)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if len(token) > 0 {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	if len(backend.namespace) > 0 {
+		req.Header.Set("X-Vault-Namespace", backend.namespace)
+	}
+
+	resp, err := backend.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+
+	if resp.StatusCode >= 300 && resp.StatusCode != 404 {
+		parsed := vaultAuthResponse{}
+		json.Unmarshal(respBody, &parsed)
+		return nil, resp.StatusCode, errors.New(fmt.Sprintf("Vault returned status %d: %s", resp.StatusCode, strings.Join(parsed.Errors, ", ")))
+	}
+
+	return respBody, resp.StatusCode, nil
+}
+
+// Sets when to renew the token, at two thirds of its lease
+func (backend *vaultBackend) setLease(seconds int64, renewable bool) {
+	backend.renewable = renewable
+	if seconds <= 0 {
+		backend.renewAt = time.Time{}
+		backend.expiresAt = time.Time{}
+		return
+	}
+
+	lease := time.Duration(seconds) * time.Second
+	backend.renewAt = time.Now().Add(lease * 2 / 3)
+	backend.expiresAt = time.Now().Add(lease)
+}
+
+// Logs in with AppRole, or looks up the lease of the token from env
+func (backend *vaultBackend) login(ctx context.Context) error {
+	backend.Lock()
+	defer backend.Unlock()
+
+	parsed := vaultAuthResponse{}
+	if len(backend.roleId) > 0 {
+		respBody, _, err := backend.request(ctx, "POST", "auth/approle/login", "", map[string]string{
+			"role_id":   backend.roleId,
+			"secret_id": backend.secretId,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = json.Unmarshal(respBody, &parsed)
+		if err != nil || len(parsed.Auth.ClientToken) == 0 {
+			return errors.New("no token in Vault AppRole login response")
+		}
+
+		backend.token = parsed.Auth.ClientToken
+		backend.setLease(parsed.Auth.LeaseDuration, parsed.Auth.Renewable)
+		return nil
+	}
+
+	respBody, _, err := backend.request(ctx, "GET", "auth/token/lookup-self", backend.token, nil)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(respBody, &parsed)
+	if err != nil {
+		return err
+	}
+
+	backend.setLease(parsed.Data.Ttl, parsed.Data.Renewable)
+	return nil
+}
+
+// Renews the token if its lease is past the renewal point. Logs in again
+// with AppRole when renewing fails
+func (backend *vaultBackend) renewToken(ctx context.Context) error {
+	backend.Lock()
+	if backend.renewAt.IsZero() || time.Now().Before(backend.renewAt) {
+		backend.Unlock()
+		return nil
+	}
+
+	var err error
+	if backend.renewable {
+		respBody, _, requestErr := backend.request(ctx, "POST", "auth/token/renew-self", backend.token, map[string]string{})
+		err = requestErr
+		if err == nil {
+			parsed := vaultAuthResponse{}
+			err = json.Unmarshal(respBody, &parsed)
+			if err == nil {
+				backend.setLease(parsed.Auth.LeaseDuration, parsed.Auth.Renewable)
+				backend.Unlock()
+				return nil
+			}
+		}
+	} else {
+		err = errors.New("the token isn't renewable")
+	}
+
+	hasAppRole := len(backend.roleId) > 0
+	backend.Unlock()
+
+	if !hasAppRole {
+		return errors.New(fmt.Sprintf("failed renewing Vault token: %s", err))
+	}
+
+	log.Printf("[INFO] Logging in to Vault again, as the token couldn't be renewed: %s", err)
+	return backend.login(ctx)
+}
+
+func (backend *vaultBackend) renewLoop() {
+	for range time.Tick(vaultRenewCheckInterval) {
+		ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+		err := backend.renewToken(ctx)
+		cancel()
+
+		if err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+	}
+}
+
+func (backend *vaultBackend) getToken(ctx context.Context) string {
+	err := backend.renewToken(ctx)
+	if err != nil {
+		log.Printf("[WARNING] %s", err)
+	}
+
+	backend.Lock()
+	defer backend.Unlock()
+	return backend.token
+}
+
+func (backend *vaultBackend) getDataPath(path string) string {
+	return fmt.Sprintf("%s/data/%s/%s", backend.mount, backend.pathPrefix, path)
+}
+
+func (backend *vaultBackend) GetSecrets(ctx context.Context, path string) (map[string]string, error) {
+	backend.Lock()
+	cached, ok := backend.secrets[path]
+	backend.Unlock()
+
+	if ok && time.Since(cached.fetched) < vaultSecretCacheSeconds*time.Second {
+		return copyVaultValues(cached.values), nil
+	}
+
+	respBody, status, err := backend.request(ctx, "GET", backend.getDataPath(path), backend.getToken(ctx), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	if status != 404 {
+		parsed := struct {
+			Data struct {
+				Data map[string]string `json:"data"`
+			} `json:"data"`
+		}{}
+
+		err = json.Unmarshal(respBody, &parsed)
+		if err != nil {
+			return nil, err
+		}
+
+		if parsed.Data.Data != nil {
+			values = parsed.Data.Data
+		}
+	}
+
+	backend.Lock()
+	backend.secrets[path] = vaultCachedSecret{values: values, fetched: time.Now()}
+	backend.Unlock()
+
+	return copyVaultValues(values), nil
+}
+
+func (backend *vaultBackend) SetSecrets(ctx context.Context, path string, values map[string]string) error {
+	_, _, err := backend.request(ctx, "POST", backend.getDataPath(path), backend.getToken(ctx), map[string]interface{}{
+		"data": values,
+	})
+
+	backend.Lock()
+	delete(backend.secrets, path)
+	backend.Unlock()
+
+	return err
+}
+
+// Deletes every version of the secret, not only the latest
+func (backend *vaultBackend) DeleteSecrets(ctx context.Context, path string) error {
+	metadataPath := fmt.Sprintf("%s/metadata/%s/%s", backend.mount, backend.pathPrefix, path)
+	_, _, err := backend.request(ctx, "DELETE", metadataPath, backend.getToken(ctx), nil)
+
+	backend.Lock()
+	delete(backend.secrets, path)
+	backend.Unlock()
+
+	return err
+}
+
+func copyVaultValues(values map[string]string) map[string]string {
+	copied := map[string]string{}
+	for key, value := range values {
+		copied[key] = value
+	}
+
+	return copied
+}
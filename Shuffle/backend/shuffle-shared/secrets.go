@@ -0,0 +1,158 @@
+package shuffle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Backends the values of app authentications can be stored in, selected per
+// org with Org.SecretsBackend. Values are encrypted the same way regardless
+// of the backend. With a backend other than the datastore, the datastore
+// only keeps the field names, and AppAuthenticationStorage.SecretsBackend
+// tells where the values are. Auths keep their backend until saved again,
+// so switching the backend of an org moves each auth as it's saved.
+
+const SecretsBackendDatastore = "datastore"
+const SecretsBackendVault = "vault"
+
+var SecretsBackends = []string{SecretsBackendDatastore, SecretsBackendVault}
+
+type SecretsBackend interface {
+	Name() string
+	GetSecrets(ctx context.Context, path string) (map[string]string, error)
+	SetSecrets(ctx context.Context, path string, values map[string]string) error
+	DeleteSecrets(ctx context.Context, path string) error
+}
+
+// Returns the backend with the name. The datastore isn't a SecretsBackend,
+// as values stored there are part of the auth itself
+func GetSecretsBackend(name string) (SecretsBackend, error) {
+	switch name {
+	case SecretsBackendVault:
+		return getVaultBackend()
+	}
+
+	return nil, errors.New(fmt.Sprintf("unknown secrets backend %s", name))
+}
+
+func isExternalSecretsBackend(name string) bool {
+	return len(name) > 0 && name != SecretsBackendDatastore
+}
+
+func getAuthSecretsPath(auth AppAuthenticationStorage, id string) string {
+	return fmt.Sprintf("%s/%s", auth.OrgId, id)
+}
+
+// Moves the values of an auth about to be saved to the backend of its org.
+// Fields without a value keep the value already in the backend, so auths
+// saved without being loaded through GetWorkflowAppAuthDatastore don't
+// lose their values. Returns the backend the values were stored in before
+func storeAuthSecrets(ctx context.Context, auth *AppAuthenticationStorage, id string) (string, error) {
+	previousBackend := auth.SecretsBackend
+
+	backendName := SecretsBackendDatastore
+	org, err := GetOrg(ctx, auth.OrgId)
+	if err == nil && len(org.SecretsBackend) > 0 {
+		backendName = org.SecretsBackend
+	}
+
+	if !isExternalSecretsBackend(backendName) {
+		if isExternalSecretsBackend(previousBackend) {
+			err = resolveAuthSecrets(ctx, auth, id)
+			if err != nil {
+				return previousBackend, err
+			}
+		}
+
+		auth.SecretsBackend = ""
+		return previousBackend, nil
+	}
+
+	backend, err := GetSecretsBackend(backendName)
+	if err != nil {
+		return previousBackend, err
+	}
+
+	values := map[string]string{}
+	if previousBackend == backendName {
+		values, err = backend.GetSecrets(ctx, getAuthSecretsPath(*auth, id))
+		if err != nil {
+			return previousBackend, err
+		}
+	}
+
+	for _, field := range auth.Fields {
+		if len(field.Value) > 0 {
+			values[field.Key] = field.Value
+		}
+	}
+
+	err = backend.SetSecrets(ctx, getAuthSecretsPath(*auth, id), values)
+	if err != nil {
+		return previousBackend, err
+	}
+
+	// Copied, as the fields are shared with the auth of the caller
+	fields := []AuthenticationStore{}
+	for _, field := range auth.Fields {
+		field.Value = ""
+		fields = append(fields, field)
+	}
+
+	auth.Fields = fields
+
+	auth.SecretsBackend = backendName
+	return previousBackend, nil
+}
+
+// Fills in the values of an auth stored in another backend than the
+// datastore. Fields that already have a value are kept
+func resolveAuthSecrets(ctx context.Context, auth *AppAuthenticationStorage, id string) error {
+	if !isExternalSecretsBackend(auth.SecretsBackend) {
+		return nil
+	}
+
+	backend, err := GetSecretsBackend(auth.SecretsBackend)
+	if err != nil {
+		return err
+	}
+
+	values, err := backend.GetSecrets(ctx, getAuthSecretsPath(*auth, id))
+	if err != nil {
+		return err
+	}
+
+	for index, field := range auth.Fields {
+		if len(field.Value) == 0 {
+			auth.Fields[index].Value = values[field.Key]
+		}
+	}
+
+	return nil
+}
+
+func deleteAuthSecrets(ctx context.Context, auth AppAuthenticationStorage, id string) error {
+	if !isExternalSecretsBackend(auth.SecretsBackend) {
+		return nil
+	}
+
+	backend, err := GetSecretsBackend(auth.SecretsBackend)
+	if err != nil {
+		return err
+	}
+
+	return backend.DeleteSecrets(ctx, getAuthSecretsPath(auth, id))
+}
+
+// Resolves the values of auths loaded in bulk. Auths that fail keep empty
+// values, so one unreachable backend doesn't fail every auth of an org
+func resolveAllAuthSecrets(ctx context.Context, auths []AppAuthenticationStorage) {
+	for index := range auths {
+		err := resolveAuthSecrets(ctx, &auths[index], auths[index].Id)
+		if err != nil {
+			log.Printf("[ERROR] Failed getting values of app auth %s from %s: %s", auths[index].Id, auths[index].SecretsBackend, err)
+		}
+	}
+}
@@ -74,7 +74,25 @@ func RequestMiddleware(next http.Handler) http.Handler {
 
 var sandboxProject = "shuffle-sandbox-337810"
 
+// Returns a context with the injected datastore fault and delegated grant of
+// the request, if the backend set them.
+// It has no deadline and isn't canceled when the request finishes, as
+// handlers start goroutines with it that outlive the request. Use
+// GetRequestContext for work that has to finish before the response
 func GetContext(request *http.Request) context.Context {
+	if request != nil {
+		ctx := context.Background()
+		if fault, ok := GetDatastoreFault(request.Context()); ok {
+			ctx = WithDatastoreFault(ctx, fault)
+		}
+
+		if grant, ok := GetDelegatedGrant(request.Context()); ok {
+			ctx = WithDelegatedGrant(ctx, grant)
+		}
+
+		return ctx
+	}
+
 	return context.Background()
 
 	if project.Environment == "cloud" && len(memcached) == 0 {
@@ -85,6 +103,20 @@ func GetContext(request *http.Request) context.Context {
 	return context.Background()
 }
 
+// Same as GetContext, with the deadline of the request if the backend set one.
+// Only use it for work the handler waits for, and call cancel before
+// returning. Goroutines that outlive the request need GetContext
+func GetRequestContext(request *http.Request) (context.Context, context.CancelFunc) {
+	ctx := GetContext(request)
+	if request != nil {
+		if deadline, ok := request.Context().Deadline(); ok {
+			return context.WithDeadline(ctx, deadline)
+		}
+	}
+
+	return context.WithCancel(ctx)
+}
+
 func HandleCors(resp http.ResponseWriter, request *http.Request) bool {
 	origin := request.Header["Origin"]
 	resp.Header().Set("Vary", "Origin")
@@ -2424,6 +2456,11 @@ func DeleteAppAuthentication(resp http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	err = deleteAuthSecrets(ctx, *auth, fileId)
+	if err != nil {
+		log.Printf("[WARNING] Failed deleting values of app auth %s from %s: %s", fileId, auth.SecretsBackend, err)
+	}
+
 	cacheKey := fmt.Sprintf("%s_%s", nameKey, user.ActiveOrg.Id)
 	DeleteCache(ctx, cacheKey)
 	cacheKey = fmt.Sprintf("%s_%s", nameKey, fileId)
@@ -3281,7 +3318,18 @@ func HandleGetEnvironments(resp http.ResponseWriter, request *http.Request) {
 	resp.Write(newjson)
 }
 
+// Authenticates the user of a request with an api key or session. Users
+// with a delegated grant for the request get the admin role (delegation.go)
 func HandleApiAuthentication(resp http.ResponseWriter, request *http.Request) (User, error) {
+	user, err := handleApiAuthentication(resp, request)
+	if err != nil {
+		return user, err
+	}
+
+	return applyDelegatedGrant(request, user), nil
+}
+
+func handleApiAuthentication(resp http.ResponseWriter, request *http.Request) (User, error) {
 	var err error
 	apikey := request.Header.Get("Authorization")
 
@@ -3881,6 +3929,41 @@ func HandleGetWorkflowRunCount(resp http.ResponseWriter, request *http.Request)
 	resp.Write([]byte(fmt.Sprintf(`{"success": true, "count": %d}`, workflowCount)))
 }
 
+// Writes items as a JSON array one item at a time instead of marshaling the
+// whole array into memory. Flushes every 100 items so the response is sent in chunks.
+// The status is expected to be written already, so a failure can only cut the response short
+func WriteJsonArray[T any](resp http.ResponseWriter, items []T) error {
+	flusher, canFlush := resp.(http.Flusher)
+
+	_, err := resp.Write([]byte("["))
+	if err != nil {
+		return err
+	}
+
+	for index, item := range items {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+
+		if index > 0 {
+			data = append([]byte(","), data...)
+		}
+
+		_, err = resp.Write(data)
+		if err != nil {
+			return err
+		}
+
+		if canFlush && (index+1)%100 == 0 {
+			flusher.Flush()
+		}
+	}
+
+	_, err = resp.Write([]byte("]"))
+	return err
+}
+
 func GetWorkflowExecutions(resp http.ResponseWriter, request *http.Request) {
 	cors := HandleCors(resp, request)
 	if cors {
@@ -4016,17 +4099,16 @@ func GetWorkflowExecutions(resp http.ResponseWriter, request *http.Request) {
 		workflowExecutions[index].Workflow.Actions = newActions
 		workflowExecutions[index].Workflow.Image = ""
 		workflowExecutions[index].Workflow.Triggers = newTriggers
-	}
 
-	newjson, err := json.Marshal(workflowExecutions)
-	if err != nil {
-		resp.WriteHeader(401)
-		resp.Write([]byte(fmt.Sprintf(`{"success": false, "reason": "Failed unpacking workflow executions"}`)))
-		return
+		workflowExecutions[index], _ = RedactExecution(ctx, workflowExecutions[index])
 	}
 
+	// Executions can be large, so they are written one by one
 	resp.WriteHeader(200)
-	resp.Write(newjson)
+	err = WriteJsonArray(resp, workflowExecutions)
+	if err != nil {
+		log.Printf("[WARNING] Failed writing workflow executions for workflow %s: %s", fileId, err)
+	}
 }
 
 func GetWorkflowExecutionsV2(resp http.ResponseWriter, request *http.Request) {
@@ -4199,6 +4281,8 @@ func GetWorkflowExecutionsV2(resp http.ResponseWriter, request *http.Request) {
 		workflowExecutions[index].Workflow.Image = ""
 		workflowExecutions[index].Workflow.Triggers = newTriggers
 
+		workflowExecutions[index], _ = RedactExecution(ctx, workflowExecutions[index])
+
 		// Would like to omit the whole thing :thinking:
 		//workflowExecutions[index].Workflow = Workflow{}
 	}
@@ -11824,7 +11908,9 @@ func AbortExecution(resp http.ResponseWriter, request *http.Request) {
 
 	// Checks the users' role and such if the key fails
 	//log.Printf("Abort info: %s vs %s", workflowExecution.Authorization, parsedKey)
+	failureCategory := ""
 	if workflowExecution.Authorization != parsedKey {
+		failureCategory = FailureUserAbort
 		user, err := HandleApiAuthentication(resp, request)
 		if err != nil {
 			log.Printf("[AUDIT] Api authentication failed in abort workflow: %s", err)
@@ -11958,6 +12044,10 @@ func AbortExecution(resp http.ResponseWriter, request *http.Request) {
 			CompletedAt:   workflowExecution.StartedAt,
 			Status:        "FAILURE",
 		})
+
+		if len(failureCategory) == 0 {
+			failureCategory = GetFailureCategory(workflowExecution.Results[len(workflowExecution.Results)-1])
+		}
 	} else if len(workflowExecution.Results) >= len(workflowExecution.Workflow.Actions)+extra {
 		log.Printf("[INFO] DONE - Nothing to add during abort!")
 	} else {
@@ -11996,6 +12086,10 @@ func AbortExecution(resp http.ResponseWriter, request *http.Request) {
 					CompletedAt:   workflowExecution.StartedAt,
 					Status:        "FAILURE",
 				})
+
+				if len(failureCategory) == 0 {
+					failureCategory = GetFailureCategory(workflowExecution.Results[len(workflowExecution.Results)-1])
+				}
 			}
 		}
 	}
@@ -12019,6 +12113,10 @@ func AbortExecution(resp http.ResponseWriter, request *http.Request) {
 
 	// This is the same as aborted
 	IncrementCache(ctx, workflowExecution.ExecutionOrg, "workflow_executions_failed")
+	if len(failureCategory) > 0 {
+		IncrementCache(ctx, workflowExecution.ExecutionOrg, GetFailureStatKey(failureCategory))
+	}
+
 	err = SetWorkflowExecution(ctx, *workflowExecution, true)
 	if err != nil {
 		log.Printf("[WARNING] Error saving workflow execution for updates when aborting (2) %s: %s", topic, err)
@@ -14247,6 +14345,70 @@ func RunExecutionTranslation(ctx context.Context, actionResult ActionResult) {
 	//log.Printf("\n\n[DEBUG] Found body in action result of length: %d", len(parsedBody))
 }
 
+// Categories failed actions are counted by in the org statistics
+const (
+	FailureAuth      = "auth"
+	FailureTimeout   = "timeout"
+	FailureBadInput  = "bad_input"
+	FailureAppCrash  = "app_crash"
+	FailureResource  = "resource_limit"
+	FailureUserAbort = "user_abort"
+	FailureOther     = "other"
+)
+
+var FailureCategories = []string{FailureAuth, FailureTimeout, FailureBadInput, FailureAppCrash, FailureResource, FailureUserAbort, FailureOther}
+
+// Counts are stored as additions with this prefix
+const FailureStatPrefix = "execution_failures_"
+
+// Checked in order, as e.g. a crash log may also mention a timeout
+var failureKeywords = map[string][]string{
+	FailureResource: {"resource limit exceeded", "out of memory", "oomkilled"},
+	FailureAuth:     {"unauthorized", "forbidden", "authentication", "invalid api key", "invalid credentials", "invalid token", "access denied", "permission denied"},
+	FailureTimeout:  {"timed out", "timeout", "deadline exceeded"},
+	FailureBadInput: {"liquid", "bad request", "invalid input", "missing required", "validation", "json decode", "jsondecodeerror"},
+	FailureAppCrash: {"traceback", "exception", "panic", "segmentation fault", "exited with", "docker image"},
+}
+
+func GetFailureStatKey(category string) string {
+	return fmt.Sprintf("%s%s", FailureStatPrefix, category)
+}
+
+// Classifies a failed action by the status code of HTTP outputs, then by
+// keywords in the result. User aborts are decided by AbortExecution
+func GetFailureCategory(actionResult ActionResult) string {
+	for _, param := range actionResult.Action.Parameters {
+		if param.Name == "liquid_syntax_error" {
+			return FailureBadInput
+		}
+	}
+
+	outputValue := HTTPOutput{}
+	err := json.Unmarshal([]byte(actionResult.Result), &outputValue)
+	if err == nil && outputValue.Status > 0 {
+		if outputValue.Status == 401 || outputValue.Status == 403 {
+			return FailureAuth
+		} else if outputValue.Status == 408 || outputValue.Status == 504 {
+			return FailureTimeout
+		} else if outputValue.Status == 400 || outputValue.Status == 422 {
+			return FailureBadInput
+		} else if outputValue.Status >= 500 {
+			return FailureAppCrash
+		}
+	}
+
+	result := strings.ToLower(actionResult.Result)
+	for _, category := range []string{FailureResource, FailureAuth, FailureTimeout, FailureBadInput, FailureAppCrash} {
+		for _, keyword := range failureKeywords[category] {
+			if strings.Contains(result, keyword) {
+				return category
+			}
+		}
+	}
+
+	return FailureOther
+}
+
 // Updateparam is a check to see if the execution should be continuously validated
 func ParsedExecutionResult(ctx context.Context, workflowExecution WorkflowExecution, actionResult ActionResult, updateParam bool, retries int64) (*WorkflowExecution, bool, error) {
 	var err error
@@ -14607,6 +14769,7 @@ func ParsedExecutionResult(ctx context.Context, workflowExecution WorkflowExecut
 
 	if actionResult.Status == "ABORTED" || actionResult.Status == "FAILURE" {
 		IncrementCache(ctx, workflowExecution.ExecutionOrg, "app_executions_failed")
+		IncrementCache(ctx, workflowExecution.ExecutionOrg, GetFailureStatKey(GetFailureCategory(actionResult)))
 
 		if workflowExecution.Workflow.Configuration.SkipNotifications == false {
 			// Add an else for HTTP request errors with success "false"
@@ -16531,6 +16694,10 @@ func handleKeyEncryption(data []byte, passphrase string) ([]byte, error) {
 func HandleKeyDecryption(data []byte, passphrase string) ([]byte, error) {
 	//log.Printf("[DEBUG] Passphrase: %s", passphrase)
 	//log.Printf("Decrypting key: %s", data)
+	if isEnvelopeValue(data) {
+		return decryptEnvelope(context.Background(), data, passphrase)
+	}
+
 	key, err := create32Hash(passphrase)
 	if err != nil {
 		log.Printf("[ERROR] Failed hashing in decrypt: %s", err)
@@ -16642,6 +16809,15 @@ func HandleListCacheKeys(resp http.ResponseWriter, request *http.Request) {
 		isSuccess = false
 	}
 
+	visibleKeys := []CacheKeyData{}
+	for _, key := range keys {
+		if !IsReservedCacheKey(key.Key) {
+			visibleKeys = append(visibleKeys, key)
+		}
+	}
+
+	keys = visibleKeys
+
 	newReturn := CacheReturn{
 		Success: isSuccess,
 		Keys:    keys,
@@ -16720,6 +16896,13 @@ func HandleDeleteCacheKey(resp http.ResponseWriter, request *http.Request) {
 	//cacheKey = strings.Replace(cacheKey, "%20", " ", -1)
 	cacheKey = strings.Trim(cacheKey, " ")
 	cacheId := fmt.Sprintf("%s_%s", orgId, cacheKey)
+	if IsReservedCacheKey(cacheKey) {
+		log.Printf("[AUDIT] Refused deleting reserved cache key '%s' in org %s", cacheKey, orgId)
+		resp.WriteHeader(403)
+		resp.Write([]byte(`{"success": false, "reason": "Keys starting with csl_ are reserved"}`))
+		return
+	}
+
 
 	cacheData, err := GetCacheKey(ctx, cacheId)
 	if err != nil || cacheData.Key == "" {
@@ -16879,6 +17062,13 @@ func HandleDeleteCacheKeyPost(resp http.ResponseWriter, request *http.Request) {
 
 	tmpData.Key = strings.Trim(tmpData.Key, " ")
 	cacheId := fmt.Sprintf("%s_%s", selectedOrg, tmpData.Key)
+	if IsReservedCacheKey(tmpData.Key) {
+		log.Printf("[AUDIT] Refused deleting reserved cache key '%s' in org %s", tmpData.Key, selectedOrg)
+		resp.WriteHeader(403)
+		resp.Write([]byte(`{"success": false, "reason": "Keys starting with csl_ are reserved"}`))
+		return
+	}
+
 	cacheData, err := GetCacheKey(ctx, cacheId)
 
 	log.Printf("[DEBUG] Attempting to delete cache key '%s' for org %s", tmpData.Key, tmpData.OrgId)
@@ -17111,6 +17301,13 @@ func HandleGetCacheKey(resp http.ResponseWriter, request *http.Request) {
 	}
 
 	tmpData.Key = strings.Trim(tmpData.Key, " ")
+	if IsReservedCacheKey(tmpData.Key) {
+		log.Printf("[AUDIT] Refused reading reserved cache key '%s' in org %s", tmpData.Key, tmpData.OrgId)
+		resp.WriteHeader(403)
+		resp.Write([]byte(`{"success": false, "reason": "Keys starting with csl_ are reserved"}`))
+		return
+	}
+
 	cacheId := fmt.Sprintf("%s_%s", tmpData.OrgId, tmpData.Key)
 	cacheData, err := GetCacheKey(ctx, cacheId)
 	if err != nil {
@@ -17382,6 +17579,13 @@ func HandleSetCacheKey(resp http.ResponseWriter, request *http.Request) {
 	}
 
 	tmpData.Key = strings.Trim(tmpData.Key, " ")
+	if IsReservedCacheKey(tmpData.Key) {
+		log.Printf("[AUDIT] Refused setting reserved cache key '%s' in org %s", tmpData.Key, tmpData.OrgId)
+		resp.WriteHeader(403)
+		resp.Write([]byte(`{"success": false, "reason": "Keys starting with csl_ are reserved"}`))
+		return
+	}
+
 	err = SetCacheKey(ctx, tmpData)
 	if err != nil {
 		log.Printf("[ERROR] Failed to set cache key '%s' for org %s", tmpData.Key, tmpData.OrgId)
@@ -26870,12 +27074,20 @@ func HandleWorkflowRunSearch(resp http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	// Runs of restricted workflows are only returned to users in their ACL
+	aclOrg, aclErr := GetOrg(ctx, user.ActiveOrg.Id)
 	parsedRuns := []WorkflowExecution{}
 	for _, run := range runs {
 		if run.ExecutionOrg != user.ActiveOrg.Id {
 			if !user.SupportAccess {
 				continue
 			}
+		} else if aclErr != nil {
+			if run.Workflow.Owner != user.Id {
+				continue
+			}
+		} else if access := GetWorkflowAccess(aclOrg, user, run.Workflow.ID); !access.View && !access.Execute {
+			continue
 		}
 
 		parsedRuns = append(parsedRuns, run)
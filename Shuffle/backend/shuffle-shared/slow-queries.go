@@ -0,0 +1,210 @@
+package shuffle
+
+import (
+	"crypto/x509"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+)
+
+// Logs and counts database requests slower than SHUFFLE_SLOW_QUERY_MS
+// (default 500, 0 disables), with the function making the query and the
+// handler or job it was made for. Requests are timed in the transport of
+// the Opensearch client, so every query is covered, including retries.
+// Callers are found from the stack, as most queries don't get a context.
+
+const defaultSlowQueryMs = 500
+
+// Queries are grouped by handler, function and operation. Groups past the
+// limit are counted together
+const maxSlowQueryGroups = 500
+
+type SlowQueryStat struct {
+	Handler   string `json:"handler"`
+	Function  string `json:"function"`
+	Operation string `json:"operation"`
+	Count     int64  `json:"count"`
+	TotalMs   int64  `json:"total_ms"`
+	MaxMs     int64  `json:"max_ms"`
+	LastSeen  int64  `json:"last_seen"`
+}
+
+var slowQueries = struct {
+	sync.Mutex
+	stats map[string]*SlowQueryStat
+}{
+	stats: map[string]*SlowQueryStat{},
+}
+
+type slowQueryTransport struct {
+	next http.RoundTripper
+}
+
+func GetSlowQueryThreshold() time.Duration {
+	threshold := defaultSlowQueryMs
+	if value := os.Getenv("SHUFFLE_SLOW_QUERY_MS"); len(value) > 0 {
+		parsed, err := strconv.Atoi(value)
+		if err == nil && parsed >= 0 {
+			threshold = parsed
+		}
+	}
+
+	return time.Duration(threshold) * time.Millisecond
+}
+
+// Returns the index and API of the request, e.g. workflow/_search
+func getQueryOperation(request *http.Request) string {
+	parts := strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+	operation := request.Method
+	if len(parts) > 0 && len(parts[0]) > 0 {
+		operation = parts[0]
+	}
+
+	for _, part := range parts[1:] {
+		if strings.HasPrefix(part, "_") {
+			return operation + "/" + part
+		}
+	}
+
+	return request.Method + " " + operation
+}
+
+// Returns the shuffle function making the query, and the backend handler or
+// job it was made for
+func getQueryCallers() (string, string) {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+
+	function := ""
+	handler := ""
+	for {
+		frame, more := frames.Next()
+		name := frame.Function
+
+		if strings.HasPrefix(name, "github.com/shuffle/shuffle-shared.") {
+			if len(function) == 0 && !strings.Contains(name, "slowQueryTransport") {
+				function = strings.TrimPrefix(name, "github.com/shuffle/shuffle-shared.")
+			}
+		} else if strings.HasPrefix(name, "main.") {
+			handler = strings.TrimPrefix(name, "main.")
+		} else if len(handler) > 0 && (strings.HasPrefix(name, "net/http.") || strings.HasPrefix(name, "github.com/gorilla/mux.")) {
+			// Past the handler, into the router and middleware
+			break
+		}
+
+		if !more {
+			break
+		}
+	}
+
+	if len(handler) == 0 {
+		handler = "unknown"
+	}
+
+	if len(function) == 0 {
+		function = "unknown"
+	}
+
+	return function, handler
+}
+
+func recordSlowQuery(function, handler, operation string, duration time.Duration) {
+	key := handler + "|" + function + "|" + operation
+
+	slowQueries.Lock()
+	defer slowQueries.Unlock()
+
+	stat, ok := slowQueries.stats[key]
+	if !ok {
+		if len(slowQueries.stats) >= maxSlowQueryGroups {
+			key = "other"
+			handler, function, operation = "other", "other", "other"
+		}
+
+		stat, ok = slowQueries.stats[key]
+		if !ok {
+			stat = &SlowQueryStat{Handler: handler, Function: function, Operation: operation}
+			slowQueries.stats[key] = stat
+		}
+	}
+
+	milliseconds := duration.Milliseconds()
+	stat.Count += 1
+	stat.TotalMs += milliseconds
+	stat.LastSeen = time.Now().Unix()
+	if milliseconds > stat.MaxMs {
+		stat.MaxMs = milliseconds
+	}
+}
+
+func (transport *slowQueryTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	threshold := GetSlowQueryThreshold()
+	if threshold == 0 {
+		return transport.next.RoundTrip(request)
+	}
+
+	started := time.Now()
+	resp, err := transport.next.RoundTrip(request)
+	duration := time.Since(started)
+
+	if duration >= threshold {
+		function, handler := getQueryCallers()
+		operation := getQueryOperation(request)
+		recordSlowQuery(function, handler, operation, duration)
+
+		log.Printf("[WARNING] Slow database query: %s took %dms in %s, called from %s", operation, duration.Milliseconds(), function, handler)
+	}
+
+	return resp, err
+}
+
+// Times every request of the Opensearch client. Injected faults are inside
+// the timing, so injected latency shows up as slow queries. The CA
+// certificate is added here, as the client only adds it to transports of
+// type *http.Transport
+func wrapSlowQueryTransport(config *opensearch.Config) error {
+	transport := config.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	if config.CACert != nil {
+		httpTransport, ok := transport.(*http.Transport)
+		if !ok {
+			return errors.New("unable to set CA certificate for the database transport")
+		}
+
+		httpTransport = httpTransport.Clone()
+		httpTransport.TLSClientConfig.RootCAs = x509.NewCertPool()
+		if !httpTransport.TLSClientConfig.RootCAs.AppendCertsFromPEM(config.CACert) {
+			return errors.New("unable to add the database CA certificate")
+		}
+
+		transport = httpTransport
+		config.CACert = nil
+	}
+
+	config.Transport = &slowQueryTransport{next: &faultTransport{next: transport}}
+	return nil
+}
+
+// Returns the slow queries since the backend started
+func GetSlowQueryStats() []SlowQueryStat {
+	slowQueries.Lock()
+	defer slowQueries.Unlock()
+
+	stats := []SlowQueryStat{}
+	for _, stat := range slowQueries.stats {
+		stats = append(stats, *stat)
+	}
+
+	return stats
+}
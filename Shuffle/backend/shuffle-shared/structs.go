@@ -370,6 +370,9 @@ type ExecutionInfo struct {
 	OrgName     string `json:"org_name" datastore:"org_name"`
 	LastCleared int64  `json:"last_cleared" datastore:"last_cleared"`
 
+	// IANA timezone the daily statistics roll over in. Empty uses the server timezone
+	Timezone string `json:"timezone,omitempty" datastore:"timezone"`
+
 	DailyStatistics []DailyStatistics `json:"daily_statistics" datastore:"daily_statistics"`
 	OnpremStats     []DailyStatistics `json:"onprem_stats,omitempty" datastore:"onprem_stats"`
 
@@ -891,6 +894,10 @@ type Org struct {
 	EulaSigned   bool    `json:"eula_signed" datastore:"eula_signed"`
 	EulaSignedBy string  `json:"eula_signed_by" datastore:"eula_signed_by"`
 	Billing      Billing `json:"Billing" datastore:"Billing"`
+
+	SecretsBackend string          `json:"secrets_backend" datastore:"secrets_backend"` // Where app authentication values are stored. See secrets.go
+	RedactionRules []RedactionRule `json:"redaction_rules" datastore:"redaction_rules"` // Applied to executions before they are stored. See redaction.go
+	WorkflowAcls   []WorkflowAcl   `json:"workflow_acls" datastore:"workflow_acls"`     // Who can see and run restricted workflows. See workflow-acl.go
 }
 
 type Billing struct {
@@ -1339,6 +1346,7 @@ type ActionResult struct {
 	AttackTechniques []string        `json:"attack_techniques" datastore:"attack_techniques"`
 	AttackTactics    []string        `json:"attack_tactics" datastore:"attack_tactics"`
 	SimilarActions   []SimilarAction `json:"similar_actions" datastore:"similar_actions"`
+	RetryCount       int64           `json:"retry_count" datastore:"retry_count"`
 }
 
 type AuthenticationUsage struct {
@@ -1448,6 +1456,7 @@ type AppAuthenticationStorage struct {
 
 	Environment       string `json:"environment" datastore:"environment"`               // In case an auth should ALWAYS be mapped to an environment. Can help out with Oauth2 refresh (e.g. running partially on cloud and partially onprem), as well as for KMS. For now ONLY KMS has a frontend.
 	SuborgDistributed bool   `json:"suborg_distributed" datastore:"suborg_distributed"` // Decides if it's distributed to suborgs or not
+	SecretsBackend    string `json:"secrets_backend" datastore:"secrets_backend"`       // Set when the field values are stored outside the datastore
 }
 
 type PasswordChange struct {
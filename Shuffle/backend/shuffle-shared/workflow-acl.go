@@ -0,0 +1,142 @@
+package shuffle
+
+import (
+	"context"
+	"log"
+)
+
+// Per-workflow sharing. Workflows without an ACL are visible to every member
+// of their org. Once a workflow has an ACL it is restricted to:
+//
+//	owner      everything, including changing the ACL and deleting it
+//	editors    view, edit and run it
+//	viewers    view it and its executions
+//	executors  run it and see its executions, but not how it's built
+//
+// Org admins always have full access. ACLs are stored on the org, so
+// checking them doesn't need another lookup for each workflow.
+
+type WorkflowAcl struct {
+	WorkflowId string   `json:"workflow_id" datastore:"workflow_id"`
+	Owner      string   `json:"owner" datastore:"owner"`
+	Editors    []string `json:"editors" datastore:"editors"`
+	Viewers    []string `json:"viewers" datastore:"viewers"`
+	Executors  []string `json:"executors" datastore:"executors"`
+	UpdatedBy  string   `json:"updated_by" datastore:"updated_by"`
+	Updated    int64    `json:"updated" datastore:"updated"`
+}
+
+type WorkflowAccess struct {
+	View    bool `json:"view"`
+	Execute bool `json:"execute"`
+	Edit    bool `json:"edit"`
+	Manage  bool `json:"manage"`
+}
+
+var fullWorkflowAccess = WorkflowAccess{View: true, Execute: true, Edit: true, Manage: true}
+
+func GetWorkflowAcl(org *Org, workflowId string) (WorkflowAcl, bool) {
+	if org == nil {
+		return WorkflowAcl{}, false
+	}
+
+	for _, acl := range org.WorkflowAcls {
+		if acl.WorkflowId == workflowId {
+			return acl, true
+		}
+	}
+
+	return WorkflowAcl{}, false
+}
+
+// Whether the user is an admin of the org. The role is read from the org
+// instead of the user, as delegated roles (delegation.go) give users the
+// admin role for requests they are allowed to make
+func isWorkflowAclAdmin(org *Org, user User) bool {
+	// Background lookups without a user
+	if len(user.Id) == 0 {
+		return true
+	}
+
+	if user.SupportAccess {
+		return true
+	}
+
+	for _, orgUser := range org.Users {
+		if orgUser.Id == user.Id {
+			return orgUser.Role == "admin"
+		}
+	}
+
+	return false
+}
+
+// Returns what the user can do with a workflow in the org
+func GetWorkflowAccess(org *Org, user User, workflowId string) WorkflowAccess {
+	acl, found := GetWorkflowAcl(org, workflowId)
+	if !found || isWorkflowAclAdmin(org, user) || acl.Owner == user.Id {
+		return fullWorkflowAccess
+	}
+
+	if ArrayContains(acl.Editors, user.Id) {
+		return WorkflowAccess{View: true, Execute: true, Edit: true}
+	}
+
+	access := WorkflowAccess{}
+	if ArrayContains(acl.Viewers, user.Id) {
+		access.View = true
+	}
+
+	if ArrayContains(acl.Executors, user.Id) {
+		access.Execute = true
+	}
+
+	return access
+}
+
+// Removes the workflows the user doesn't have access to. Execute only users
+// get the workflow without its actions, triggers and branches
+func FilterWorkflowsByAcl(ctx context.Context, user User, workflows []Workflow) []Workflow {
+	if len(user.ActiveOrg.Id) == 0 {
+		return workflows
+	}
+
+	// Without the ACLs only the users' own workflows are safe to return
+	org, err := GetOrg(ctx, user.ActiveOrg.Id)
+	if err != nil {
+		log.Printf("[WARNING] Failed getting org %s for workflow ACLs: %s", user.ActiveOrg.Id, err)
+		owned := []Workflow{}
+		for _, workflow := range workflows {
+			if len(user.Id) == 0 || workflow.Owner == user.Id {
+				owned = append(owned, workflow)
+			}
+		}
+
+		return owned
+	}
+
+	if len(org.WorkflowAcls) == 0 || isWorkflowAclAdmin(org, user) {
+		return workflows
+	}
+
+	filtered := []Workflow{}
+	for _, workflow := range workflows {
+		access := GetWorkflowAccess(org, user, workflow.ID)
+		if access.View {
+			filtered = append(filtered, workflow)
+		} else if access.Execute {
+			filtered = append(filtered, Workflow{
+				ID:          workflow.ID,
+				Name:        workflow.Name,
+				Description: workflow.Description,
+				Tags:        workflow.Tags,
+				Owner:       workflow.Owner,
+				OrgId:       workflow.OrgId,
+				Created:     workflow.Created,
+				Edited:      workflow.Edited,
+			})
+		}
+	}
+
+	return filtered
+}
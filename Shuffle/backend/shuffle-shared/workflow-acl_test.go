@@ -0,0 +1,76 @@
+package shuffle
+
+import (
+	"testing"
+)
+
+func TestGetWorkflowAccess(t *testing.T) {
+	org := &Org{
+		Id: "org",
+		Users: []User{
+			{Id: "admin", Role: "admin"},
+			{Id: "owner", Role: "user"},
+			{Id: "editor", Role: "user"},
+			{Id: "viewer", Role: "user"},
+			{Id: "executor", Role: "user"},
+			{Id: "both", Role: "user"},
+			{Id: "other", Role: "user"},
+		},
+		WorkflowAcls: []WorkflowAcl{
+			{
+				WorkflowId: "restricted",
+				Owner:      "owner",
+				Editors:    []string{"editor"},
+				Viewers:    []string{"viewer", "both"},
+				Executors:  []string{"executor", "both"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		user       User
+		workflowId string
+		expected   WorkflowAccess
+	}{
+		{name: "workflow without acl", user: User{Id: "other"}, workflowId: "open", expected: fullWorkflowAccess},
+		{name: "admin", user: User{Id: "admin"}, workflowId: "restricted", expected: fullWorkflowAccess},
+		{name: "owner", user: User{Id: "owner"}, workflowId: "restricted", expected: fullWorkflowAccess},
+		{name: "editor", user: User{Id: "editor"}, workflowId: "restricted", expected: WorkflowAccess{View: true, Execute: true, Edit: true}},
+		{name: "viewer", user: User{Id: "viewer"}, workflowId: "restricted", expected: WorkflowAccess{View: true}},
+		{name: "executor", user: User{Id: "executor"}, workflowId: "restricted", expected: WorkflowAccess{Execute: true}},
+		{name: "viewer and executor", user: User{Id: "both"}, workflowId: "restricted", expected: WorkflowAccess{View: true, Execute: true}},
+		{name: "not shared", user: User{Id: "other"}, workflowId: "restricted", expected: WorkflowAccess{}},
+		{name: "not a member", user: User{Id: "outsider", Role: "admin"}, workflowId: "restricted", expected: WorkflowAccess{}},
+		{name: "support access", user: User{Id: "support", SupportAccess: true}, workflowId: "restricted", expected: fullWorkflowAccess},
+		{name: "background lookup", user: User{}, workflowId: "restricted", expected: fullWorkflowAccess},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			access := GetWorkflowAccess(org, test.user, test.workflowId)
+			if access != test.expected {
+				t.Errorf("GetWorkflowAccess = %+v, expected %+v", access, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetWorkflowAcl(t *testing.T) {
+	org := &Org{WorkflowAcls: []WorkflowAcl{{WorkflowId: "a", Owner: "owner"}}}
+
+	acl, found := GetWorkflowAcl(org, "a")
+	if !found || acl.Owner != "owner" {
+		t.Errorf("GetWorkflowAcl(a) = %+v, %t", acl, found)
+	}
+
+	_, found = GetWorkflowAcl(org, "b")
+	if found {
+		t.Errorf("GetWorkflowAcl found an ACL for a workflow without one")
+	}
+
+	_, found = GetWorkflowAcl(nil, "a")
+	if found {
+		t.Errorf("GetWorkflowAcl found an ACL without an org")
+	}
+}
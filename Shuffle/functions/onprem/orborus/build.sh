@@ -3,7 +3,10 @@ VERSION=1.3.0
 
 echo "Running docker build with $NAME:$VERSION"
 #docker rmi frikky/shuffle:$NAME --force
+# The image builds against the shared package of this repository
+rm -rf shuffle-shared && cp -r ../../../backend/shuffle-shared ./shuffle-shared
 docker build . -t frikky/shuffle:$NAME -t docker.pkg.github.com/frikky/shuffle/$NAME:$VERSION -t frikky/$NAME:$VERSION -t ghcr.io/frikky/$NAME:$VERSION -t ghcr.io/frikky/$NAME:nightly -t  ghcr.io/shuffle/$NAME:$VERSION -t ghcr.io/shuffle/$NAME:nightly
+rm -rf shuffle-shared
 
 #docker push frikky/$NAME:$VERSION
 # docker push docker.pkg.github.com/frikky/shuffle/$NAME:$VERSION
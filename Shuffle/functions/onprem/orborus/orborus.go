@@ -13,6 +13,8 @@ import (
 
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -62,6 +64,9 @@ import (
 // Starts jobs in bulk, so this could be increased
 var sleepTime = 2
 
+// Seconds the backend may hold an empty queue request open (max 30)
+var queueWait = 0
+
 // Making it work on low-end machines even during busy times :)
 // May cause some things to run slowly
 var maxConcurrency = 7
@@ -107,6 +112,9 @@ var memcached = os.Getenv("SHUFFLE_MEMCACHED")
 var tenzirUrl = os.Getenv("SHUFFLE_TENZIR_URL")
 
 var executionIds = []string{}
+
+// Milliseconds it took to deploy workers since the last queue request
+var containerStarts = []string{}
 var namespacemade = false  // For K8s
 
 var dockercli *dockerclient.Client
@@ -484,6 +492,9 @@ func deployServiceWorkers(image string) {
 						fmt.Sprintf("SHUFFLE_MAX_SWARM_NODES=%d", os.Getenv("SHUFFLE_MAX_SWARM_NODES")),
 						fmt.Sprintf("SHUFFLE_BASE_IMAGE_NAME=%s", os.Getenv("SHUFFLE_BASE_IMAGE_NAME")),
 						fmt.Sprintf("SHUFFLE_APP_REQUEST_TIMEOUT=%s", os.Getenv("SHUFFLE_APP_REQUEST_TIMEOUT")),
+						fmt.Sprintf("SHUFFLE_TLS_CERT_FILE=%s", os.Getenv("SHUFFLE_TLS_CERT_FILE")),
+						fmt.Sprintf("SHUFFLE_TLS_KEY_FILE=%s", os.Getenv("SHUFFLE_TLS_KEY_FILE")),
+						fmt.Sprintf("SHUFFLE_MTLS_CA_FILE=%s", os.Getenv("SHUFFLE_MTLS_CA_FILE")),
 					},
 					//Hosts: []string{
 					//	innerContainerName,
@@ -1452,6 +1463,14 @@ func main() {
 		}
 	}
 
+	if len(os.Getenv("SHUFFLE_ORBORUS_QUEUE_WAIT")) > 0 {
+		tmpInt, err := strconv.Atoi(os.Getenv("SHUFFLE_ORBORUS_QUEUE_WAIT"))
+		if err == nil && tmpInt > 0 {
+			log.Printf("[INFO] Asking backend to wait up to %d seconds for new executions before returning an empty queue", tmpInt)
+			queueWait = tmpInt
+		}
+	}
+
 	// Handle Cleanup - made it cleanup by default
 	if strings.ToLower(os.Getenv("SHUFFLE_CONTAINER_AUTO_CLEANUP")) != "false" {
 		cleanupEnv = "true"
@@ -1525,7 +1544,7 @@ func main() {
 
 	zombiecheck(ctx, workerTimeout)
 
-	client := shuffle.GetExternalClient(baseUrl)
+	client := applyMtls(shuffle.GetExternalClient(baseUrl))
 	fullUrl := fmt.Sprintf("%s/api/v1/workflows/queue", baseUrl)
 	log.Printf("[INFO] Finished configuring docker environment. Connecting to %s", fullUrl)
 
@@ -1566,6 +1585,10 @@ func main() {
 		req.Header.Add("X-Orborus-Runmode", "Docker Swarm")
 	}
 
+	if queueWait > 0 {
+		req.Header.Add("X-Queue-Wait", strconv.Itoa(queueWait))
+	}
+
 	if os.Getenv("SHUFFLE_MAX_CPU") != "" {
 		// parse
 		tmpInt, err := strconv.Atoi(os.Getenv("SHUFFLE_MAX_CPU"))
@@ -1591,6 +1614,13 @@ func main() {
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
 
+			// Reported to the backend for the runner health
+			if len(containerStarts) > 0 {
+				req.Header.Set("X-Container-Start-Ms", strings.Join(containerStarts, ","))
+			} else {
+				req.Header.Del("X-Container-Start-Ms")
+			}
+
 			// Marshal and set body
 			orborusStats := getOrborusStats(ctx)
 			jsonData, err := json.Marshal(orborusStats)
@@ -1652,6 +1682,7 @@ func main() {
 			}
 
 			hasStarted = true
+			containerStarts = []string{}
 		}
 
 		var executionRequests shuffle.ExecutionRequestWrapper
@@ -1668,6 +1699,8 @@ func main() {
 			continue
 		}
 
+		// The backend already waited for new executions, so no need to sleep
+		longPolled := queueWait > 0 && newresp.StatusCode == 200 && len(executionRequests.Data) == 0
 
 		if hasStarted && len(executionRequests.Data) > 0 {
 			//log.Printf("[INFO] Body: %s", string(body))
@@ -1726,7 +1759,11 @@ func main() {
 					go zombiecheck(ctx, workerTimeout)
 					zombiecounter = 0
 				}
-				time.Sleep(time.Duration(sleepTime) * time.Second)
+
+				if !longPolled {
+					time.Sleep(time.Duration(sleepTime) * time.Second)
+				}
+
 				continue
 			}
 
@@ -1807,6 +1844,9 @@ func main() {
 				fmt.Sprintf("SHUFFLE_SWARM_CONFIG=%s", os.Getenv("SHUFFLE_SWARM_CONFIG")),
 				fmt.Sprintf("SHUFFLE_LOGS_DISABLED=%s", os.Getenv("SHUFFLE_LOGS_DISABLED")),
 				fmt.Sprintf("SHUFFLE_BASE_IMAGE_NAME=%s", os.Getenv("SHUFFLE_BASE_IMAGE_NAME")),
+				fmt.Sprintf("SHUFFLE_TLS_CERT_FILE=%s", os.Getenv("SHUFFLE_TLS_CERT_FILE")),
+				fmt.Sprintf("SHUFFLE_TLS_KEY_FILE=%s", os.Getenv("SHUFFLE_TLS_KEY_FILE")),
+				fmt.Sprintf("SHUFFLE_MTLS_CA_FILE=%s", os.Getenv("SHUFFLE_MTLS_CA_FILE")),
 			}
 
 			//log.Printf("Running worker with proxy? %s", os.Getenv("SHUFFLE_PASS_WORKER_PROXY"))
@@ -1865,9 +1905,14 @@ func main() {
 				env = append(env, fmt.Sprintf("SHUFFLE_MAX_SWARM_NODES=%s", os.Getenv("SHUFFLE_MAX_SWARM_NODES")))
 			}
 
+			deployStart := time.Now()
 			err = deployWorker(workerImage, containerName, env, execution)
 			zombiecounter += 1
 			if err == nil {
+				if len(containerStarts) < 50 {
+					containerStarts = append(containerStarts, strconv.FormatInt(time.Since(deployStart).Milliseconds(), 10))
+				}
+
 				//log.Printf("[DEBUG] ExecutionID %s was deployed and to be removed from queue.", execution.ExecutionId)
 				toBeRemoved.Data = append(toBeRemoved.Data, execution)
 				executionIds = append(executionIds, execution.ExecutionId)
@@ -1891,7 +1936,9 @@ func main() {
 
 		}
 
-		time.Sleep(time.Duration(sleepTime) * time.Second)
+		if !longPolled {
+			time.Sleep(time.Duration(sleepTime) * time.Second)
+		}
 	}
 }
 
@@ -2365,7 +2412,7 @@ func createPipeline(command, identifier string) (string, error) {
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := applyMtls(&http.Client{Timeout: 10 * time.Second})
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Printf("[ERROR] Failed to send HTTP request: %s", err)
@@ -2439,7 +2486,7 @@ func updatePipelineState(pipelineId, action string) (string, error) {
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := applyMtls(&http.Client{Timeout: 10 * time.Second})
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Printf("[ERROR] Failed to send HTTP request: %s", err)
@@ -2495,7 +2542,7 @@ func deletePipeline(pipelineId string) error {
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := applyMtls(&http.Client{Timeout: 10 * time.Second})
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Printf("[ERROR] Failed to send HTTP request: %s", err)
@@ -2834,7 +2881,12 @@ func sendWorkerRequest(workflowExecution shuffle.ExecutionRequest) error {
 		streamUrl = fmt.Sprintf("http://shuffle-workers:33333/api/v1/execute")
 	}
 
-	client := &http.Client{}
+	// Workers only serve TLS when mTLS is configured
+	if mtlsReloader != nil && strings.HasPrefix(streamUrl, "http://") {
+		streamUrl = strings.Replace(streamUrl, "http://", "https://", 1)
+	}
+
+	client := applyMtls(&http.Client{})
 	req, err := http.NewRequest(
 		"POST",
 		streamUrl,
@@ -2900,3 +2952,88 @@ func sendWorkerRequest(workflowExecution shuffle.ExecutionRequest) error {
 	log.Printf("[DEBUG] Ran worker from request with execution ID: %s. Worker URL: %s. DEBUGGING:\ndocker service logs shuffle-workers 2>&1 -f | grep %s", workflowExecution.ExecutionId, streamUrl, workflowExecution.ExecutionId)
 	return nil
 }
+
+// Mutual TLS towards the backend and workers. Enabled by setting
+// SHUFFLE_TLS_CERT_FILE and SHUFFLE_TLS_KEY_FILE to a client certificate.
+// SHUFFLE_MTLS_CA_FILE is used to verify the other side. The files are
+// re-read when they change so certificates can be rotated without a restart
+var mtlsReloader = setupMtlsReloader()
+
+// Transport used for requests with the client certificate. It's cloned from
+// http.DefaultTransport, which other clients share, and rebuilt when the CA
+// is rotated
+var mtlsTransport = struct {
+	sync.Mutex
+	transport *http.Transport
+	caPool    *x509.CertPool
+}{}
+
+func setupMtlsReloader() *shuffle.CertReloader {
+	certFile := os.Getenv("SHUFFLE_TLS_CERT_FILE")
+	keyFile := os.Getenv("SHUFFLE_TLS_KEY_FILE")
+	if len(certFile) == 0 || len(keyFile) == 0 {
+		return nil
+	}
+
+	reloader, err := shuffle.NewCertReloader(certFile, keyFile, os.Getenv("SHUFFLE_MTLS_CA_FILE"))
+	if err != nil {
+		log.Printf("[ERROR] Failed loading mTLS certificates. Running without them: %s", err)
+		return nil
+	}
+
+	log.Printf("[INFO] Using mTLS client certificate %s", certFile)
+	return reloader
+}
+
+func getMtlsClientConfig(caPool *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		GetClientCertificate: mtlsReloader.GetClientCertificate,
+		RootCAs:              caPool,
+		InsecureSkipVerify:   caPool == nil && strings.ToLower(os.Getenv("SHUFFLE_SKIPSSL_VERIFY")) == "true",
+	}
+}
+
+func getMtlsTransport() *http.Transport {
+	caPool := mtlsReloader.GetCaPool()
+
+	mtlsTransport.Lock()
+	defer mtlsTransport.Unlock()
+	if mtlsTransport.transport != nil && mtlsTransport.caPool == caPool {
+		return mtlsTransport.transport
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 100
+	transport.ResponseHeaderTimeout = time.Second * 60
+	transport.IdleConnTimeout = time.Second * 60
+	transport.TLSClientConfig = getMtlsClientConfig(caPool)
+
+	if mtlsTransport.transport != nil {
+		mtlsTransport.transport.CloseIdleConnections()
+	}
+
+	mtlsTransport.transport = transport
+	mtlsTransport.caPool = caPool
+	return transport
+}
+
+// Makes a client send the client certificate if mTLS is configured. Only use
+// it for requests to the backend and workers. Clients from GetExternalClient
+// keep their proxy settings, as only the TLS config of a copy is changed
+func applyMtls(client *http.Client) *http.Client {
+	if mtlsReloader == nil {
+		return client
+	}
+
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok || transport == nil {
+		client.Transport = getMtlsTransport()
+		return client
+	}
+
+	transport = transport.Clone()
+	transport.TLSClientConfig = getMtlsClientConfig(mtlsReloader.GetCaPool())
+	client.Transport = transport
+	return client
+}
@@ -3,7 +3,10 @@ VERSION=1.3.0
 
 echo "Running docker build with $NAME:$VERSION"
 #CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo -o worker.bin .
+# The image builds against the shared package of this repository
+rm -rf shuffle-shared && cp -r ../../../backend/shuffle-shared ./shuffle-shared
 docker build . -t frikky/shuffle:$NAME -t frikky/shuffle:$NAME_$VERSION -t docker.pkg.github.com/frikky/shuffle/$NAME:$VERSION -t ghcr.io/frikky/$NAME:$VERSION -t ghcr.io/frikky/$NAME:nightly -t ghcr.io/shuffle/$NAME:$VERSION -t ghcr.io/shuffle/$NAME:nightly
+rm -rf shuffle-shared
 
 # Push both for now..
 #docker push frikky/$NAME:$VERSION 
@@ -5,6 +5,8 @@ import (
 
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,6 +20,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
@@ -32,6 +35,7 @@ import (
 
 	//k8s deps
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -353,7 +357,7 @@ func shutdown(workflowExecution shuffle.WorkflowExecution, nodeId string, reason
 		req.Header.Add("Content-Type", "application/json")
 
 		//log.Printf("[DEBUG][%s] All App Logs: %#v", workflowExecution.ExecutionId, allLogs)
-		client := shuffle.GetExternalClient(abortUrl)
+		client := applyMtls(shuffle.GetExternalClient(abortUrl))
 		newresp, err := client.Do(req)
 		if err != nil {
 			log.Printf("[WARNING][%s] Failed abort request: %s", workflowExecution.ExecutionId, err)
@@ -445,6 +449,25 @@ func deployApp(cli *dockerclient.Client, image string, identifier string, env []
 		podUuid := uuid.NewV4().String()
 		podName := fmt.Sprintf("%s-%s", value, podUuid)
 
+		limit := getAppResourceLimit(workflowExecution, action.AppName)
+		resources := corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{},
+		}
+
+		if limit.CpuCores > 0 {
+			resources.Limits[corev1.ResourceCPU] = *resource.NewMilliQuantity(int64(limit.CpuCores*1000), resource.DecimalSI)
+		}
+
+		if limit.MemoryMb > 0 {
+			resources.Limits[corev1.ResourceMemory] = *resource.NewQuantity(int64(limit.MemoryMb)*1024*1024, resource.BinarySI)
+		}
+
+		var activeDeadline *int64
+		if limit.TimeoutSeconds > 0 {
+			deadline := int64(limit.TimeoutSeconds)
+			activeDeadline = &deadline
+		}
+
 		pod := &corev1.Pod{
 			ObjectMeta: metav1.ObjectMeta{
 				Name: podName,
@@ -456,12 +479,14 @@ func deployApp(cli *dockerclient.Client, image string, identifier string, env []
 			Spec: corev1.PodSpec{
 				RestartPolicy: "Never", // As a crash is not useful in this context 
 				DNSPolicy:     "Default",
+				ActiveDeadlineSeconds: activeDeadline,
 				// NodeName:      "worker1"
 				Containers: []corev1.Container{
 					{
 						Name:            value,
 						Image:           image,
 						Env:             buildEnvVars(envMap),
+						Resources:       resources,
 
 						// Pull if not available
 						ImagePullPolicy: corev1.PullIfNotPresent,
@@ -528,6 +553,16 @@ func deployApp(cli *dockerclient.Client, image string, identifier string, env []
 		Resources: container.Resources{},
 	}
 
+	limit := getAppResourceLimit(workflowExecution, action.AppName)
+	if limit.MemoryMb > 0 {
+		hostConfig.Resources.Memory = int64(limit.MemoryMb) * 1024 * 1024
+		hostConfig.Resources.MemorySwap = hostConfig.Resources.Memory
+	}
+
+	if limit.CpuCores > 0 {
+		hostConfig.Resources.NanoCPUs = int64(limit.CpuCores * 1e9)
+	}
+
 	if os.Getenv("SHUFFLE_SWARM_CONFIG") != "run" && os.Getenv("SHUFFLE_SWARM_CONFIG") != "swarm" {
 		hostConfig.NetworkMode = container.NetworkMode(fmt.Sprintf("container:worker-%s", workflowExecution.ExecutionId))
 		//log.Printf("Environments: %#v", env)
@@ -707,7 +742,7 @@ func DeployContainer(ctx context.Context, cli *dockerclient.Client, config *cont
 						"max-size": "10m",
 					},
 				},
-				Resources: container.Resources{},
+				Resources: hostConfig.Resources,
 			}
 
 			cont, err = cli.ContainerCreate(
@@ -748,6 +783,7 @@ func DeployContainer(ctx context.Context, cli *dockerclient.Client, config *cont
 	}
 
 	log.Printf("[DEBUG][%s] Container %s was created for %s", workflowExecution.ExecutionId, cont.ID, identifier)
+	go watchResourceLimits(cli, cont.ID, workflowExecution, actionExecId)
 
 	// Waiting to see if it exits.. Stupid, but stable(r)
 	if workflowExecution.ExecutionSource != "default" {
@@ -762,6 +798,152 @@ func DeployContainer(ctx context.Context, cli *dockerclient.Client, config *cont
 	return nil
 }
 
+// Resource limits of the apps in an execution, set per org in the backend
+type appResourceLimit struct {
+	CpuCores       float64 `json:"cpu_cores"`
+	MemoryMb       int     `json:"memory_mb"`
+	TimeoutSeconds int     `json:"timeout_seconds"`
+}
+
+type appResourceLimits struct {
+	Default appResourceLimit            `json:"default"`
+	Apps    map[string]appResourceLimit `json:"apps"`
+}
+
+var resourceLimits = map[string]appResourceLimits{}
+var resourceLimitsLock sync.Mutex
+
+func fetchResourceLimits(workflowExecution shuffle.WorkflowExecution) appResourceLimits {
+	parsed := struct {
+		Success bool              `json:"success"`
+		Data    appResourceLimits `json:"data"`
+	}{}
+
+	limitUrl := fmt.Sprintf("%s/api/v1/csl/resourceLimits/effective?execution_id=%s&authorization=%s", baseUrl, url.QueryEscape(workflowExecution.ExecutionId), url.QueryEscape(workflowExecution.Authorization))
+	client := applyMtls(shuffle.GetExternalClient(limitUrl))
+	newresp, err := client.Get(limitUrl)
+	if err != nil {
+		log.Printf("[WARNING][%s] Failed getting resource limits: %s", workflowExecution.ExecutionId, err)
+		return parsed.Data
+	}
+
+	defer newresp.Body.Close()
+	body, err := ioutil.ReadAll(newresp.Body)
+	if err != nil || newresp.StatusCode != 200 {
+		log.Printf("[WARNING][%s] Failed getting resource limits. Status: %d", workflowExecution.ExecutionId, newresp.StatusCode)
+		return parsed.Data
+	}
+
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		log.Printf("[WARNING][%s] Failed parsing resource limits: %s", workflowExecution.ExecutionId, err)
+	}
+
+	return parsed.Data
+}
+
+// Limits are fetched once per execution. Apps run without limits if they
+// can't be fetched, e.g. from a backend that doesn't have them. The lock
+// isn't held while fetching, so a slow backend doesn't hold up other
+// executions
+func getAppResourceLimit(workflowExecution shuffle.WorkflowExecution, appName string) appResourceLimit {
+	resourceLimitsLock.Lock()
+	limits, ok := resourceLimits[workflowExecution.ExecutionId]
+	resourceLimitsLock.Unlock()
+
+	if !ok {
+		limits = fetchResourceLimits(workflowExecution)
+
+		resourceLimitsLock.Lock()
+		if len(resourceLimits) > 1000 {
+			resourceLimits = map[string]appResourceLimits{}
+		}
+
+		resourceLimits[workflowExecution.ExecutionId] = limits
+		resourceLimitsLock.Unlock()
+	}
+
+	if limit, ok := limits.Apps[strings.ToLower(appName)]; ok {
+		return limit
+	}
+
+	return limits.Default
+}
+
+// Kills app containers running past their time limit. Apps that are killed
+// or run out of memory can't send their result, so a failure is sent for them
+func watchResourceLimits(cli *dockerclient.Client, containerId string, workflowExecution shuffle.WorkflowExecution, actionExecId string) {
+	actionId := strings.TrimPrefix(actionExecId, fmt.Sprintf("%s_", workflowExecution.ExecutionId))
+	action := shuffle.Action{}
+	for _, item := range workflowExecution.Workflow.Actions {
+		if item.ID == actionId {
+			action = item
+			break
+		}
+	}
+
+	if len(action.ID) == 0 {
+		return
+	}
+
+	limit := getAppResourceLimit(workflowExecution, action.AppName)
+	if limit.TimeoutSeconds == 0 && limit.MemoryMb == 0 {
+		return
+	}
+
+	// Only waiting for the container to stop when only memory is limited
+	timeout := time.Duration(limit.TimeoutSeconds) * time.Second
+	if limit.TimeoutSeconds == 0 {
+		timeout = 24 * time.Hour
+	}
+
+	ctx := context.Background()
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	startedAt := time.Now().Unix()
+	reason := ""
+	statusCh, errCh := cli.ContainerWait(waitCtx, containerId, container.WaitConditionNotRunning)
+	select {
+	case status := <-statusCh:
+		// 137 is SIGKILL, which is what the OOM killer sends
+		if limit.MemoryMb == 0 || status.StatusCode != 137 {
+			return
+		}
+
+		info, err := cli.ContainerInspect(ctx, containerId)
+		if err == nil && info.State != nil && !info.State.OOMKilled {
+			return
+		}
+
+		reason = fmt.Sprintf("the app used more than its memory limit of %d MB", limit.MemoryMb)
+	case <-errCh:
+		if waitCtx.Err() != context.DeadlineExceeded || limit.TimeoutSeconds == 0 {
+			return
+		}
+
+		err := cli.ContainerKill(ctx, containerId, "KILL")
+		if err != nil {
+			log.Printf("[WARNING][%s] Failed killing container %s after its time limit: %s", workflowExecution.ExecutionId, containerId, err)
+		}
+
+		reason = fmt.Sprintf("the app ran longer than its time limit of %d seconds", limit.TimeoutSeconds)
+	}
+
+	log.Printf("[WARNING][%s] Resource limit exceeded in action %s (%s): %s", workflowExecution.ExecutionId, action.Label, action.ID, reason)
+	actionResult := shuffle.ActionResult{
+		Action:        action,
+		ExecutionId:   workflowExecution.ExecutionId,
+		Authorization: workflowExecution.Authorization,
+		Result:        fmt.Sprintf(`{"success": false, "reason": "Resource limit exceeded: %s"}`, reason),
+		StartedAt:     startedAt,
+		CompletedAt:   time.Now().Unix(),
+		Status:        "FAILURE",
+	}
+
+	sendSelfRequest(actionResult)
+}
+
 func removeContainer(containername string) error {
 	ctx := context.Background()
 
@@ -845,7 +1027,7 @@ func getWorkerURLs() ([]string, error) {
 
 	// Print task information
 	for _, task := range tasks {
-		url := fmt.Sprintf("http://%s.%d.%s:33333", serviceName, task.Slot, task.ID)
+		url := fmt.Sprintf("%s://%s.%d.%s:33333", getWorkerScheme(), serviceName, task.Slot, task.ID)
 		workerUrls = append(workerUrls, url)
 	}
 
@@ -878,7 +1060,7 @@ func askOtherWorkersToDownloadImage(image string) {
 	}
 
 
-	httpClient := &http.Client{}
+	httpClient := applyMtls(&http.Client{})
 	distributed := false 
 	for _, url := range urls {
 		//log.Printf("[DEBUG] Trying to speak to: %s", url)
@@ -1127,7 +1309,7 @@ func handleExecutionResult(workflowExecution shuffle.WorkflowExecution) {
 					return
 				}
 
-				err := downloadDockerImageBackend(&http.Client{Timeout: 60 * time.Second}, image)
+				err := downloadDockerImageBackend(applyMtls(&http.Client{Timeout: 60 * time.Second}), image)
 				executed := false
 				if err == nil {
 					log.Printf("[DEBUG] Downloaded image %s from backend (CLEANUP)", image)
@@ -1240,7 +1422,7 @@ func handleExecutionResult(workflowExecution shuffle.WorkflowExecution) {
 					}
 
 					log.Printf("[DEBUG][%s] Failed deploy. Downloading image %s: %s", workflowExecution.ExecutionId, image, err)
-					err := downloadDockerImageBackend(&http.Client{Timeout: 60 * time.Second}, image)
+					err := downloadDockerImageBackend(applyMtls(&http.Client{Timeout: 60 * time.Second}), image)
 					executed := false
 					if err == nil {
 						log.Printf("[DEBUG] Downloaded image %s from backend (CLEANUP)", image)
@@ -1547,7 +1729,7 @@ func handleSubflowPoller(ctx context.Context, workflowExecution shuffle.Workflow
 		bytes.NewBuffer([]byte(data)),
 	)
 
-	client := shuffle.GetExternalClient(streamResultUrl)
+	client := applyMtls(shuffle.GetExternalClient(streamResultUrl))
 	newresp, err := client.Do(req)
 	if err != nil {
 		log.Printf("[ERROR] Failed making request (1): %s", err)
@@ -2178,10 +2360,10 @@ func sendSelfRequest(actionResult shuffle.ActionResult) {
 	log.Printf("[DEBUG][%s] Sending FAILURE to self to stop the workflow execution. Action: %s (%s), app %s:%s", actionResult.ExecutionId, actionResult.Action.Label, actionResult.Action.ID, actionResult.Action.AppName, actionResult.Action.AppVersion)
 	
 	// Literally sending to same worker to run it as a new request
-	streamUrl := fmt.Sprintf("http://localhost:33333/api/v1/streams")
+	streamUrl := fmt.Sprintf("%s://localhost:33333/api/v1/streams", getWorkerScheme())
 	hostenv := os.Getenv("WORKER_HOSTNAME")
 	if len(hostenv) > 0 {
-		streamUrl = fmt.Sprintf("http://%s:33333/api/v1/streams", hostenv)
+		streamUrl = fmt.Sprintf("%s://%s:33333/api/v1/streams", getWorkerScheme(), hostenv)
 	}
 	
 	req, err := http.NewRequest(
@@ -2195,7 +2377,7 @@ func sendSelfRequest(actionResult shuffle.ActionResult) {
 		return
 	}
 	
-	client := shuffle.GetExternalClient(streamUrl)
+	client := applyMtls(shuffle.GetExternalClient(streamUrl))
 	newresp, err := client.Do(req)
 	if err != nil {
 		log.Printf("[ERROR][%s] Error running finishing request (2): %s", actionResult.ExecutionId, err)
@@ -2254,7 +2436,7 @@ func sendResult(workflowExecution shuffle.WorkflowExecution, data []byte) {
 		return
 	}
 	
-	client := shuffle.GetExternalClient(streamUrl)
+	client := applyMtls(shuffle.GetExternalClient(streamUrl))
 	newresp, err := client.Do(req)
 	if err != nil {
 		log.Printf("[ERROR][%s] Error running finishing request (1): %s", workflowExecution.ExecutionId, err)
@@ -2438,7 +2620,7 @@ func webserverSetup(workflowExecution shuffle.WorkflowExecution) net.Listener {
 	os.Setenv("WORKER_PORT", fmt.Sprintf("%d", port))
 	
 	log.Printf("[DEBUG] Starting webserver (2) on port %d with hostname: %s", port, hostname)
-	appCallbackUrl = fmt.Sprintf("http://%s:%d", hostname, port)
+	appCallbackUrl = fmt.Sprintf("%s://%s:%d", getWorkerScheme(), hostname, port)
 	
 	log.Printf("[INFO] NEW WORKER HOSTNAME: %s", appCallbackUrl)
 	return listener
@@ -2644,12 +2826,12 @@ parsedRequest.BaseUrl = tmp
 // Run with proper hostname, but set to shuffle-worker to avoid specific host target.
 // This means running with VIP instead.
 if len(hostname) > 0 {
-	parsedRequest.BaseUrl = fmt.Sprintf("http://%s:%d", hostname, baseport)
+	parsedRequest.BaseUrl = fmt.Sprintf("%s://%s:%d", getWorkerScheme(), hostname, baseport)
 	//parsedRequest.BaseUrl = fmt.Sprintf("http://shuffle-workers:%d", baseport)
 	//log.Printf("[DEBUG][%s] Changing hostname to local hostname in Docker network for WORKER URL: %s", workflowExecution.ExecutionId, parsedRequest.BaseUrl)
 
 	if parsedRequest.Action.AppName == "shuffle-subflow" || parsedRequest.Action.AppName == "shuffle-subflow-v2" || parsedRequest.Action.AppName == "User Input" {
-		parsedRequest.BaseUrl = fmt.Sprintf("http://%s:%d", hostname, baseport)
+		parsedRequest.BaseUrl = fmt.Sprintf("%s://%s:%d", getWorkerScheme(), hostname, baseport)
 		//parsedRequest.Url = parsedRequest.BaseUrl
 	}
 }
@@ -2692,7 +2874,7 @@ if err != nil {
 	//log.Printf("[DEBUG][%s] Adding %s to cache (%#v)", workflowExecution.ExecutionId, newExecId, action.Name)
 }
 
-client := shuffle.GetExternalClient(streamUrl)
+client := applyMtls(shuffle.GetExternalClient(streamUrl))
 customTimeout := os.Getenv("SHUFFLE_APP_REQUEST_TIMEOUT")
 if len(customTimeout) > 0 {
 	// convert to int
@@ -2992,7 +3174,7 @@ func main() {
 
 	log.Printf("[INFO] Setting up worker environment")
 	sleepTime = 5
-	client := shuffle.GetExternalClient(baseUrl)
+	client := applyMtls(shuffle.GetExternalClient(baseUrl))
 
 	if timezone == "" {
 		timezone = "Europe/Amsterdam"
@@ -3169,7 +3351,7 @@ func handleRunExecution(resp http.ResponseWriter, request *http.Request) {
 		bytes.NewBuffer([]byte(data)),
 	)
 
-	client := shuffle.GetExternalClient(streamResultUrl)
+	client := applyMtls(shuffle.GetExternalClient(streamResultUrl))
 	newresp, err := client.Do(req)
 	if err != nil {
 		log.Printf("[ERROR] Failed making request (2): %s", err)
@@ -3334,7 +3516,7 @@ func handleDownloadImage(resp http.ResponseWriter, request *http.Request) {
 	}
 
 	log.Printf("[INFO] Downloading image %s", image.Image)
-	downloadDockerImageBackend(&http.Client{Timeout: 60 * time.Second}, image.Image)
+	downloadDockerImageBackend(applyMtls(&http.Client{Timeout: 60 * time.Second}), image.Image)
 
 	// return success
 	resp.WriteHeader(200)
@@ -3377,8 +3559,132 @@ func runWebserver(listener net.Listener) {
 		WriteTimeout:      60 * time.Second,
 	}
 
-	err := srv.Serve(listener)
+	err := srv.Serve(getMtlsListener(listener))
 	if err != nil {
 		log.Printf("[ERROR] Serve issue in worker: %#v", err)
 	}
 }
+
+// Mutual TLS towards the backend and workers. Enabled by setting
+// SHUFFLE_TLS_CERT_FILE and SHUFFLE_TLS_KEY_FILE to a client certificate.
+// SHUFFLE_MTLS_CA_FILE is used to verify the other side. The files are
+// re-read when they change so certificates can be rotated without a restart
+var mtlsReloader = setupMtlsReloader()
+
+// Transport used for requests with the client certificate. It's cloned from
+// http.DefaultTransport, which other clients share, and rebuilt when the CA
+// is rotated
+var mtlsTransport = struct {
+	sync.Mutex
+	transport *http.Transport
+	caPool    *x509.CertPool
+}{}
+
+func setupMtlsReloader() *shuffle.CertReloader {
+	certFile := os.Getenv("SHUFFLE_TLS_CERT_FILE")
+	keyFile := os.Getenv("SHUFFLE_TLS_KEY_FILE")
+	if len(certFile) == 0 || len(keyFile) == 0 {
+		return nil
+	}
+
+	reloader, err := shuffle.NewCertReloader(certFile, keyFile, os.Getenv("SHUFFLE_MTLS_CA_FILE"))
+	if err != nil {
+		log.Printf("[ERROR] Failed loading mTLS certificates. Running without them: %s", err)
+		return nil
+	}
+
+	log.Printf("[INFO] Using mTLS client certificate %s", certFile)
+	return reloader
+}
+
+func getMtlsClientConfig(caPool *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		GetClientCertificate: mtlsReloader.GetClientCertificate,
+		RootCAs:              caPool,
+		InsecureSkipVerify:   caPool == nil && strings.ToLower(os.Getenv("SHUFFLE_SKIPSSL_VERIFY")) == "true",
+	}
+}
+
+func getMtlsTransport() *http.Transport {
+	caPool := mtlsReloader.GetCaPool()
+
+	mtlsTransport.Lock()
+	defer mtlsTransport.Unlock()
+	if mtlsTransport.transport != nil && mtlsTransport.caPool == caPool {
+		return mtlsTransport.transport
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 100
+	transport.ResponseHeaderTimeout = time.Second * 60
+	transport.IdleConnTimeout = time.Second * 60
+	transport.TLSClientConfig = getMtlsClientConfig(caPool)
+
+	if mtlsTransport.transport != nil {
+		mtlsTransport.transport.CloseIdleConnections()
+	}
+
+	mtlsTransport.transport = transport
+	mtlsTransport.caPool = caPool
+	return transport
+}
+
+// Makes a client send the client certificate if mTLS is configured. Only use
+// it for requests to the backend and workers. Clients from GetExternalClient
+// keep their proxy settings, as only the TLS config of a copy is changed
+func applyMtls(client *http.Client) *http.Client {
+	if mtlsReloader == nil {
+		return client
+	}
+
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok || transport == nil {
+		client.Transport = getMtlsTransport()
+		return client
+	}
+
+	transport = transport.Clone()
+	transport.TLSClientConfig = getMtlsClientConfig(mtlsReloader.GetCaPool())
+	client.Transport = transport
+	return client
+}
+
+// The worker API only accepts TLS when mTLS is configured, so urls to workers
+// have to use https then
+func getWorkerScheme() string {
+	if mtlsReloader == nil {
+		return "http"
+	}
+
+	return "https"
+}
+
+// Wraps the worker listener with TLS when mTLS is configured. Requests to the
+// worker then need a client certificate signed by SHUFFLE_MTLS_CA_FILE
+func getMtlsListener(listener net.Listener) net.Listener {
+	if mtlsReloader == nil {
+		return listener
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			config := &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: mtlsReloader.GetCertificate,
+			}
+
+			caPool := mtlsReloader.GetCaPool()
+			if caPool != nil {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				config.ClientCAs = caPool
+			}
+
+			return config, nil
+		},
+	}
+
+	log.Printf("[INFO] Serving worker API with mTLS")
+	return tls.NewListener(listener, tlsConfig)
+}